@@ -103,6 +103,12 @@ func Run(hwnd C.HWND, hinst C.HINSTANCE, lpszCmdLine *C.char, nCmdShow C.int) {
 		return
 	}
 
+	// The host process is rundll32.exe, not an agent binary that could be
+	// swapped or restarted, so self-update and watchdog restarts are not
+	// available in DLL mode.
+	cfg.Update.Enabled = false
+	cfg.Watchdog.Restart = false
+
 	a, err := agent.New(cfg)
 	if err != nil {
 		return
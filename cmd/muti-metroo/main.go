@@ -3,7 +3,9 @@ package main
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
+	"encoding/csv"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
@@ -12,12 +14,17 @@ import (
 	"mime/multipart"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
 	"path/filepath"
 	"runtime"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -29,11 +36,15 @@ import (
 	"github.com/postalsys/muti-metroo/internal/crypto"
 	"github.com/postalsys/muti-metroo/internal/embed"
 	"github.com/postalsys/muti-metroo/internal/filetransfer"
+	"github.com/postalsys/muti-metroo/internal/health"
 	"github.com/postalsys/muti-metroo/internal/identity"
 	"github.com/postalsys/muti-metroo/internal/probe"
+	"github.com/postalsys/muti-metroo/internal/sandbox"
+	"github.com/postalsys/muti-metroo/internal/selfupdate"
 	"github.com/postalsys/muti-metroo/internal/service"
 	"github.com/postalsys/muti-metroo/internal/shell"
 	"github.com/postalsys/muti-metroo/internal/sysinfo"
+	"github.com/postalsys/muti-metroo/internal/testmesh"
 	"github.com/postalsys/muti-metroo/internal/wizard"
 	"github.com/spf13/cobra"
 	"golang.org/x/crypto/bcrypt"
@@ -63,6 +74,9 @@ func init() {
 }
 
 func main() {
+	// Sandboxed shell commands start through this binary
+	sandbox.MaybeExec()
+
 	rootCmd := &cobra.Command{
 		Use:   "muti-metroo",
 		Short: "Muti Metroo - Userspace mesh networking agent",
@@ -114,6 +128,10 @@ root privileges.`,
 	routes.GroupID = "status"
 	rootCmd.AddCommand(routes)
 
+	streams := streamsCmd()
+	streams.GroupID = "status"
+	rootCmd.AddCommand(streams)
+
 	probeC := probeCmd()
 	probeC.GroupID = "status"
 	rootCmd.AddCommand(probeC)
@@ -127,6 +145,10 @@ root privileges.`,
 	shellC.GroupID = "remote"
 	rootCmd.AddCommand(shellC)
 
+	execAll := execAllCmd()
+	execAll.GroupID = "remote"
+	rootCmd.AddCommand(execAll)
+
 	upload := uploadCmd()
 	upload.GroupID = "remote"
 	rootCmd.AddCommand(upload)
@@ -135,6 +157,10 @@ root privileges.`,
 	download.GroupID = "remote"
 	rootCmd.AddCommand(download)
 
+	syncC := syncCmd()
+	syncC.GroupID = "remote"
+	rootCmd.AddCommand(syncC)
+
 	pingC := pingCmd()
 	pingC.GroupID = "remote"
 	rootCmd.AddCommand(pingC)
@@ -163,6 +189,50 @@ root privileges.`,
 	displayNameC.GroupID = "remote"
 	rootCmd.AddCommand(displayNameC)
 
+	dnsCacheC := dnsCacheCmd()
+	dnsCacheC.GroupID = "remote"
+	rootCmd.AddCommand(dnsCacheC)
+
+	exitDestC := exitDestinationsCmd()
+	exitDestC.GroupID = "remote"
+	rootCmd.AddCommand(exitDestC)
+
+	socks5UsersC := socks5UsersCmd()
+	socks5UsersC.GroupID = "remote"
+	rootCmd.AddCommand(socks5UsersC)
+
+	blocklistC := blocklistCmd()
+	blocklistC.GroupID = "remote"
+	rootCmd.AddCommand(blocklistC)
+
+	crashesC := crashesCmd()
+	crashesC.GroupID = "remote"
+	rootCmd.AddCommand(crashesC)
+
+	usageC := usageCmd()
+	usageC.GroupID = "remote"
+	rootCmd.AddCommand(usageC)
+
+	topC := topCmd()
+	topC.GroupID = "remote"
+	rootCmd.AddCommand(topC)
+
+	tailC := tailCmd()
+	tailC.GroupID = "remote"
+	rootCmd.AddCommand(tailC)
+
+	idleC := idleCmd()
+	idleC.GroupID = "remote"
+	rootCmd.AddCommand(idleC)
+
+	taskC := taskCmd()
+	taskC.GroupID = "remote"
+	rootCmd.AddCommand(taskC)
+
+	updateC := updateCmd()
+	updateC.GroupID = "remote"
+	rootCmd.AddCommand(updateC)
+
 	// Administration commands
 	svc := serviceCmd()
 	svc.GroupID = "admin"
@@ -176,6 +246,10 @@ root privileges.`,
 	hash.GroupID = "admin"
 	rootCmd.AddCommand(hash)
 
+	bench := benchCmd()
+	bench.GroupID = "admin"
+	rootCmd.AddCommand(bench)
+
 	mgmtKey := managementKeyCmd()
 	mgmtKey.GroupID = "admin"
 	rootCmd.AddCommand(mgmtKey)
@@ -340,6 +414,12 @@ func runCmd() *cobra.Command {
 		Short: "Run the mesh agent",
 		Long:  "Start the mesh agent with the specified configuration.",
 		RunE: func(cmd *cobra.Command, args []string) error {
+			// A Windows service starts in System32; resolve relative paths
+			// from the directory chosen at install time instead
+			if err := service.EnterWorkingDir(); err != nil {
+				return err
+			}
+
 			// Load configuration (embedded config takes precedence over -c flag)
 			cfg, isEmbedded, err := config.LoadOrEmbedded(configPath)
 			if err != nil {
@@ -629,12 +709,27 @@ func peersCmd() *cobra.Command {
 func routesCmd() *cobra.Command {
 	var agentAddr string
 	var jsonOutput bool
+	var watch bool
 
 	cmd := &cobra.Command{
 		Use:   "routes",
 		Short: "List route table",
-		Long:  "Display the current routing table via HTTP API.",
+		Long: `Display the current routing table via HTTP API.
+
+With --watch, the route selected for each network is printed, followed by a
+line whenever it changes: "+" when a network becomes reachable, "-" when it
+is lost and "~" when traffic switches to another route, with the reason
+(advertise, withdraw, peer_down, failover, local, api, suspend, resume or
+refresh). After a peer connects or disconnects, a "converged" line reports
+how many routes changed and how long the mesh took to settle.`,
+		Example: `  muti-metroo routes
+  muti-metroo routes --watch
+  muti-metroo routes --watch --json`,
 		RunE: func(cmd *cobra.Command, args []string) error {
+			if watch {
+				return watchRoutes(agentAddr, jsonOutput)
+			}
+
 			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 			defer cancel()
 
@@ -710,10 +805,310 @@ func routesCmd() *cobra.Command {
 
 	cmd.Flags().StringVarP(&agentAddr, "agent", "a", "localhost:8080", "Agent API address (host:port)")
 	cmd.Flags().BoolVar(&jsonOutput, "json", false, "Output in JSON format")
+	cmd.Flags().BoolVarP(&watch, "watch", "w", false, "Stream changes of the selected routes until interrupted")
+
+	return cmd
+}
+
+// watchRoutes prints the /api/routes/watch stream until interrupted. With
+// jsonOutput the stream lines are printed as received.
+func watchRoutes(agentAddr string, jsonOutput bool) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	url := fmt.Sprintf("http://%s/api/routes/watch", agentAddr)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	setAuthToken(req)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to connect to agent: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("route watch failed: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			if err == io.EOF {
+				return fmt.Errorf("agent closed the route watch")
+			}
+			return fmt.Errorf("failed to read route watch: %w", err)
+		}
+		if jsonOutput {
+			fmt.Println(string(raw))
+			continue
+		}
+
+		var u health.RouteWatchUpdate
+		if err := json.Unmarshal(raw, &u); err != nil {
+			return fmt.Errorf("failed to decode update: %w", err)
+		}
+		ts := u.Time.Local().Format("15:04:05.000")
+		switch u.Type {
+		case health.RouteWatchSnapshot:
+			printSelectedRoutes(u.Routes)
+			fmt.Printf("\nWatching for changes (Ctrl+C to stop)...\n")
+		case health.RouteWatchDiff:
+			fmt.Printf("%s %s\n", ts, formatRouteDiff(u.Diff))
+		case health.RouteWatchConvergence:
+			fmt.Printf("%s %s\n", ts, formatConvergence(u.Convergence))
+		}
+	}
+}
+
+// printSelectedRoutes prints the route watch snapshot.
+func printSelectedRoutes(routes []health.SelectedRoute) {
+	fmt.Printf("Selected Routes\n")
+	fmt.Printf("===============\n")
+	if len(routes) == 0 {
+		fmt.Println("No routes in table.")
+		return
+	}
+	fmt.Printf("%-20s %-15s %-15s %-8s %-6s\n", "NETWORK", "NEXT HOP", "ORIGIN", "METRIC", "HOPS")
+	fmt.Printf("%-20s %-15s %-15s %-8s %-6s\n", "-------", "--------", "------", "------", "----")
+	for _, r := range routes {
+		nextHop := "local"
+		if r.NextHop != nil {
+			nextHop = routeWatchAgentName(*r.NextHop)
+		}
+		fmt.Printf("%-20s %-15s %-15s %-8d %-6d\n",
+			r.Network, nextHop, routeWatchAgentName(r.Origin), r.Metric, r.HopCount)
+	}
+	fmt.Printf("\nTotal: %d network(s)\n", len(routes))
+}
+
+// formatRouteDiff describes a route diff on one line.
+func formatRouteDiff(d *health.RouteDiffEvent) string {
+	switch d.Kind {
+	case health.RouteDiffAdded:
+		return fmt.Sprintf("+ %s %s (%s)", d.Network, describeSelectedRoute(d.Route), d.Reason)
+	case health.RouteDiffRemoved:
+		return fmt.Sprintf("- %s was %s (%s)", d.Network, describeSelectedRoute(d.Previous), d.Reason)
+	default:
+		return fmt.Sprintf("~ %s %s, was %s (%s)", d.Network,
+			describeSelectedRoute(d.Route), describeSelectedRoute(d.Previous), d.Reason)
+	}
+}
+
+// describeSelectedRoute describes where a selected route sends traffic.
+func describeSelectedRoute(r *health.SelectedRoute) string {
+	if r == nil {
+		return "none"
+	}
+	s := "local"
+	if r.NextHop != nil {
+		s = "via " + routeWatchAgentName(*r.NextHop)
+	}
+	s += fmt.Sprintf(" origin %s metric %d", routeWatchAgentName(r.Origin), r.Metric)
+	if r.Unreachable {
+		s += " unreachable"
+	}
+	return s
+}
+
+// formatConvergence describes a convergence event on one line.
+func formatConvergence(c *health.ConvergenceEvent) string {
+	var triggers []string
+	for _, t := range c.Triggers {
+		triggers = append(triggers, t.Event+" "+routeWatchAgentName(t.Peer))
+	}
+	took := time.Duration(c.DurationMs) * time.Millisecond
+	if !c.Settled {
+		return fmt.Sprintf("not converged: still changing after %s, %d change(s) (%s)",
+			took, c.Changes, strings.Join(triggers, ", "))
+	}
+	return fmt.Sprintf("converged: %d change(s) in %s (%s)", c.Changes, took, strings.Join(triggers, ", "))
+}
+
+// routeWatchAgentName returns the display name of an agent, or its short ID.
+func routeWatchAgentName(a health.RouteExportAgent) string {
+	if a.DisplayName != "" {
+		return a.DisplayName
+	}
+	return a.ShortID
+}
+
+func streamsCmd() *cobra.Command {
+	var agentAddr string
+	var jsonOutput bool
+	var killID uint64
+
+	cmd := &cobra.Command{
+		Use:   "streams",
+		Short: "List active streams",
+		Long: `Display all active streams on this agent via HTTP API.
+
+Lists outbound streams (opened by this agent), exit streams (terminated by
+this agent), and relay streams (forwarded through this agent), with peer hops,
+destination, byte counters, age, and idle time.
+
+Use --kill to reset a stream by ID. STREAM_RESET is sent to the adjacent
+peer(s). For relay streams either the upstream or the downstream ID works.`,
+		Example: `  muti-metroo streams
+  muti-metroo streams --json
+  muti-metroo streams --kill 17`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			if cmd.Flags().Changed("kill") {
+				body, _ := json.Marshal(map[string]uint64{"stream_id": killID})
+				url := fmt.Sprintf("http://%s/api/streams/kill", agentAddr)
+				req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+				if err != nil {
+					return fmt.Errorf("failed to create request: %w", err)
+				}
+				req.Header.Set("Content-Type", "application/json")
+				setAuthToken(req)
+
+				resp, err := http.DefaultClient.Do(req)
+				if err != nil {
+					return fmt.Errorf("failed to connect to agent: %w", err)
+				}
+				defer resp.Body.Close()
+
+				var result struct {
+					Status string `json:"status"`
+					Error  string `json:"error,omitempty"`
+				}
+				json.NewDecoder(resp.Body).Decode(&result)
+				if resp.StatusCode != http.StatusOK {
+					if result.Error != "" {
+						return fmt.Errorf("kill failed: %s", result.Error)
+					}
+					return fmt.Errorf("kill failed: %s", resp.Status)
+				}
+
+				fmt.Printf("Stream %d reset\n", killID)
+				return nil
+			}
+
+			url := fmt.Sprintf("http://%s/api/streams", agentAddr)
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+			if err != nil {
+				return fmt.Errorf("failed to create request: %w", err)
+			}
+			setAuthToken(req)
+
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				return fmt.Errorf("failed to connect to agent: %w", err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != http.StatusOK {
+				return fmt.Errorf("unexpected status: %d", resp.StatusCode)
+			}
+
+			var result struct {
+				Streams []struct {
+					ID             uint64  `json:"id"`
+					Direction      string  `json:"direction"`
+					UpstreamPeer   string  `json:"upstream_peer"`
+					DownstreamPeer string  `json:"downstream_peer"`
+					DownstreamID   uint64  `json:"downstream_id"`
+					Destination    string  `json:"destination"`
+					AddressFamily  string  `json:"address_family,omitempty"`
+					DialedAddr     string  `json:"dialed_addr,omitempty"`
+					User           string  `json:"user,omitempty"`
+					Client         string  `json:"client,omitempty"`
+					State          string  `json:"state"`
+					BytesSent      uint64  `json:"bytes_sent"`
+					BytesRecv      uint64  `json:"bytes_recv"`
+					FramesSent     uint64  `json:"frames_sent"`
+					FramesRecv     uint64  `json:"frames_recv"`
+					ConnectMs      float64 `json:"connect_ms,omitempty"`
+					FirstByteMs    float64 `json:"first_byte_ms,omitempty"`
+					AgeMs          int64   `json:"age_ms"`
+					IdleMs         int64   `json:"idle_ms"`
+				} `json:"streams"`
+			}
+			if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+				return fmt.Errorf("failed to decode response: %w", err)
+			}
+
+			if jsonOutput {
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "  ")
+				return enc.Encode(result.Streams)
+			}
+
+			fmt.Printf("Active Streams\n")
+			fmt.Printf("==============\n")
+			if len(result.Streams) == 0 {
+				fmt.Println("No active streams.")
+				return nil
+			}
+
+			fmt.Printf("%-10s %-9s %-21s %-28s %-10s %-10s %-8s %-8s %-9s %-9s\n", "ID", "DIRECTION", "HOPS", "DESTINATION", "SENT", "RECV", "CONNECT", "TTFB", "AGE", "IDLE")
+			fmt.Printf("%-10s %-9s %-21s %-28s %-10s %-10s %-8s %-8s %-9s %-9s\n", "--", "---------", "----", "-----------", "----", "----", "-------", "----", "---", "----")
+			for _, st := range result.Streams {
+				id := strconv.FormatUint(st.ID, 10)
+				if st.DownstreamID != 0 {
+					id += "/" + strconv.FormatUint(st.DownstreamID, 10)
+				}
+				up, down := st.UpstreamPeer, st.DownstreamPeer
+				if up == "" {
+					up = "local"
+					if st.User != "" {
+						up = st.User
+					}
+				}
+				if down == "" {
+					down = "local"
+				}
+				dest := st.Destination
+				if dest == "" {
+					dest = "-"
+				} else if st.AddressFamily != "" {
+					dest += " (" + st.AddressFamily + ")"
+				}
+				fmt.Printf("%-10s %-9s %-21s %-28s %-10s %-10s %-8s %-8s %-9s %-9s\n",
+					id,
+					st.Direction,
+					up+" -> "+down,
+					dest,
+					filetransfer.FormatSize(int64(st.BytesSent)),
+					filetransfer.FormatSize(int64(st.BytesRecv)),
+					formatStreamLatency(st.ConnectMs),
+					formatStreamLatency(st.FirstByteMs),
+					(time.Duration(st.AgeMs) * time.Millisecond).Round(time.Second).String(),
+					(time.Duration(st.IdleMs) * time.Millisecond).Round(time.Second).String(),
+				)
+			}
+			fmt.Printf("\nTotal: %d stream(s)\n", len(result.Streams))
+
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&agentAddr, "agent", "a", "localhost:8080", "Agent API address (host:port)")
+	cmd.Flags().BoolVar(&jsonOutput, "json", false, "Output in JSON format")
+	cmd.Flags().Uint64Var(&killID, "kill", 0, "Reset the stream with this ID (sends STREAM_RESET)")
 
 	return cmd
 }
 
+// formatStreamLatency formats a stream latency in milliseconds, "-" if unknown.
+func formatStreamLatency(ms float64) string {
+	if ms == 0 {
+		return "-"
+	}
+	return (time.Duration(ms * float64(time.Millisecond))).Round(100 * time.Microsecond).String()
+}
+
 func meshTestCmd() *cobra.Command {
 	var agentAddr string
 	var timeout string
@@ -989,8 +1384,8 @@ The probe does not require a running agent - it operates standalone.`,
 		},
 	}
 
-	cmd.Flags().StringVarP(&transport, "transport", "T", "quic", "Transport type: quic, h2, ws")
-	cmd.Flags().StringVar(&path, "path", "/mesh", "HTTP path for h2/ws transports")
+	cmd.Flags().StringVarP(&transport, "transport", "T", "quic", "Transport type: quic, h2, ws, wt, tcp")
+	cmd.Flags().StringVar(&path, "path", "/mesh", "HTTP path for h2/ws/wt transports")
 	cmd.Flags().StringVarP(&timeout, "timeout", "t", "10s", "Connection timeout")
 	cmd.Flags().StringVar(&caCert, "ca", "", "CA certificate file for TLS verification")
 	cmd.Flags().StringVar(&clientCert, "cert", "", "Client certificate file for mTLS")
@@ -1185,9 +1580,9 @@ The listener runs until interrupted (Ctrl+C).`,
 		},
 	}
 
-	cmd.Flags().StringVarP(&transport, "transport", "T", "quic", "Transport type: quic, h2, ws")
+	cmd.Flags().StringVarP(&transport, "transport", "T", "quic", "Transport type: quic, h2, ws, wt, tcp")
 	cmd.Flags().StringVarP(&address, "address", "a", "0.0.0.0:4433", "Listen address")
-	cmd.Flags().StringVar(&path, "path", "/mesh", "HTTP path for h2/ws transports")
+	cmd.Flags().StringVar(&path, "path", "/mesh", "HTTP path for h2/ws/wt transports")
 	cmd.Flags().StringVar(&tlsCert, "cert", "", "TLS certificate file (optional, ephemeral cert used if not provided)")
 	cmd.Flags().StringVar(&tlsKey, "key", "", "TLS private key file (optional, ephemeral key used if not provided)")
 	cmd.Flags().StringVar(&tlsCA, "ca", "", "CA certificate for client verification (mTLS)")
@@ -1704,6 +2099,7 @@ func certAgentCmd() *cobra.Command {
 		caKeyPath  string
 		dnsNames   string
 		ipAddrs    string
+		ou         string
 	)
 
 	cmd := &cobra.Command{
@@ -1731,6 +2127,7 @@ func certAgentCmd() *cobra.Command {
 			// Build options
 			opts := certutil.DefaultPeerOptions(commonName)
 			opts.ValidFor = validFor
+			opts.OrganizationalUnit = ou
 			opts.ParentCert = ca.Certificate
 			opts.ParentKey = ca.PrivateKey
 
@@ -1767,6 +2164,9 @@ func certAgentCmd() *cobra.Command {
 			fmt.Printf("  Private key: %s\n", keyPath)
 			fmt.Printf("  Fingerprint: %s\n", cert.Fingerprint())
 			fmt.Printf("  Expires: %s\n", cert.Certificate.NotAfter.Format(time.RFC3339))
+			if ou != "" {
+				fmt.Printf("  Organizational Unit: %s\n", ou)
+			}
 			if len(opts.DNSNames) > 0 {
 				fmt.Printf("  DNS Names: %s\n", strings.Join(opts.DNSNames, ", "))
 			}
@@ -1789,6 +2189,7 @@ func certAgentCmd() *cobra.Command {
 	cmd.Flags().StringVar(&caKeyPath, "ca-key", "./certs/ca.key", "Path to CA private key")
 	cmd.Flags().StringVar(&dnsNames, "dns", "", "Additional DNS names (comma-separated)")
 	cmd.Flags().StringVar(&ipAddrs, "ip", "", "Additional IP addresses (comma-separated)")
+	cmd.Flags().StringVar(&ou, "ou", "", "Organizational unit, mapped to a role by rbac.peer_roles")
 
 	_ = cmd.MarkFlagRequired("cn")
 
@@ -1802,6 +2203,7 @@ func certClientCmd() *cobra.Command {
 		validDays  int
 		caPath     string
 		caKeyPath  string
+		ou         string
 	)
 
 	cmd := &cobra.Command{
@@ -1826,7 +2228,13 @@ func certClientCmd() *cobra.Command {
 			fmt.Printf("  Valid for: %d days\n", validDays)
 			fmt.Printf("  CA: %s\n", ca.Certificate.Subject.CommonName)
 
-			cert, err := certutil.GenerateClientCert(commonName, validFor, ca)
+			opts := certutil.DefaultClientOptions(commonName)
+			opts.ValidFor = validFor
+			opts.OrganizationalUnit = ou
+			opts.ParentCert = ca.Certificate
+			opts.ParentKey = ca.PrivateKey
+
+			cert, err := certutil.GenerateCert(opts)
 			if err != nil {
 				return fmt.Errorf("failed to generate certificate: %w", err)
 			}
@@ -1843,6 +2251,9 @@ func certClientCmd() *cobra.Command {
 			fmt.Printf("  Private key: %s\n", keyPath)
 			fmt.Printf("  Fingerprint: %s\n", cert.Fingerprint())
 			fmt.Printf("  Expires: %s\n", cert.Certificate.NotAfter.Format(time.RFC3339))
+			if ou != "" {
+				fmt.Printf("  Organizational Unit: %s\n", ou)
+			}
 
 			return nil
 		},
@@ -1853,6 +2264,7 @@ func certClientCmd() *cobra.Command {
 	cmd.Flags().IntVar(&validDays, "days", 90, "Validity period in days")
 	cmd.Flags().StringVar(&caPath, "ca", "./certs/ca.crt", "Path to CA certificate")
 	cmd.Flags().StringVar(&caKeyPath, "ca-key", "./certs/ca.key", "Path to CA private key")
+	cmd.Flags().StringVar(&ou, "ou", "", "Organizational unit, mapped to a role by rbac.peer_roles")
 
 	_ = cmd.MarkFlagRequired("cn")
 
@@ -1920,6 +2332,7 @@ func shellCmd() *cobra.Command {
 		password   string
 		timeoutStr string
 		ttyMode    bool
+		runAs      string
 	)
 
 	cmd := &cobra.Command{
@@ -1963,6 +2376,9 @@ Examples:
   # With password authentication
   muti-metroo shell -p secret abc123def456 whoami
 
+  # As another account (must be in shell.run_as.allowed_users)
+  muti-metroo shell --run-as deploy abc123def456 whoami
+
   # Via a different agent
   muti-metroo shell -a 192.168.1.10:8080 abc123def456 top`,
 		Args: cobra.MinimumNArgs(1),
@@ -2006,21 +2422,14 @@ Examples:
 				Command:     command,
 				Args:        cmdArgs,
 				Timeout:     timeoutSec,
-			})
-
-			// Run the shell session
-			ctx, cancel := context.WithCancel(context.Background())
-			defer cancel()
+				RunAs:       runAs,
 
-			// Handle interrupt signals
-			sigCh := make(chan os.Signal, 1)
-			signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
-			go func() {
-				<-sigCh
-				cancel()
-			}()
+				ForwardSignals: true,
+			})
 
-			exitCode, err := client.Run(ctx)
+			// Run the shell session. Interrupts are forwarded to the
+			// remote process by the client.
+			exitCode, err := client.Run(context.Background())
 			if err != nil {
 				return err
 			}
@@ -2037,127 +2446,424 @@ Examples:
 	cmd.Flags().StringVarP(&password, "password", "p", "", "Shell password for authentication")
 	cmd.Flags().StringVarP(&timeoutStr, "timeout", "t", "0", "Session timeout (e.g., 30s, 5m, or 0 for no timeout)")
 	cmd.Flags().BoolVar(&ttyMode, "tty", false, "Interactive mode with PTY (for vim, bash, htop, etc.)")
+	cmd.Flags().StringVar(&runAs, "run-as", "", "Run as this account on the agent (must be in shell.run_as.allowed_users)")
 
 	return cmd
 }
 
-func uploadCmd() *cobra.Command {
+func execAllCmd() *cobra.Command {
 	var (
 		agentAddr  string
 		password   string
 		timeoutStr string
-		rateLimit  string
-		resume     bool
-		quiet      bool
+		filter     string
+		tags       []string
+		osFilter   string
+		role       string
+		parallel   int
+		runAs      string
 	)
 
 	cmd := &cobra.Command{
-		Use:   "upload [flags] <target-agent-id> <local-path> <remote-path>",
-		Short: "Upload a file or directory to a remote agent",
-		Long: `Upload a local file or directory to a remote agent via the file transfer interface.
-
-The <target-agent-id> is the final destination agent where the file is stored.
-The --agent flag specifies which gateway agent to connect through (defaults to localhost).
-
-File permissions (mode) are preserved. The remote path must be absolute.
-Directories are automatically detected and uploaded as tar archives.
+		Use:   "exec-all [flags] -- <command> [args...]",
+		Short: "Run a command on many agents in parallel",
+		Long: `Run a command on every agent known to the gateway agent, or on those
+matching the filters, and summarize the exit codes.
+
+The command runs in streaming mode, as with 'shell' without --tty. Each line
+of output is prefixed with the agent name; stderr lines go to local stderr.
+Agents without shell enabled are skipped, as is the gateway agent itself.
+
+Up to --parallel agents run at a time. Ctrl-C is forwarded to the running
+commands and stops agents that have not started yet. The command exits
+non-zero when any agent fails.
+
+Filters:
+  --filter matches a display name, agent ID prefix, hostname, or an IP
+           address or CIDR of the agent or its exit routes
+  --tag    matches an agent tag (agent.tags); repeat to require several
+  --os     matches the operating system (linux, darwin, windows)
+  --role   matches an agent role (ingress, exit, transit, forward_ingress,
+           forward_exit)
 
 Examples:
-  # Upload a file to a remote agent
-  muti-metroo upload abc123def456 ./local/file.txt /tmp/remote-file.txt
-
-  # Upload a large file (streaming, supports any size)
-  muti-metroo upload abc123def456 ./large-iso.iso /tmp/large-iso.iso
-
-  # Upload a directory (auto-detected)
-  muti-metroo upload abc123def456 ./my-folder /tmp/my-folder
-
-  # Via a different agent
-  muti-metroo upload -a 192.168.1.10:8080 abc123def456 config.yaml /etc/app/config.yaml
+  # Kernel version of every agent
+  muti-metroo exec-all -- uname -a
 
-  # With password authentication
-  muti-metroo upload -p secret abc123def456 ./data.bin /home/user/data.bin
+  # Restart a service on the production web servers
+  muti-metroo exec-all --tag env:prod --tag role:web -- systemctl restart nginx
 
-  # Rate-limited upload (100 KB/s)
-  muti-metroo upload --rate-limit 100KB abc123def456 ./large.iso /tmp/large.iso
+  # Disk usage on Linux exits, 4 at a time
+  muti-metroo exec-all --os linux --role exit --parallel 4 -- df -h
 
-  # Resume an interrupted upload
-  muti-metroo upload --resume abc123def456 ./huge.iso /tmp/huge.iso`,
-		Args: cobra.ExactArgs(3),
+  # Agents in one network, with shell password and timeout
+  muti-metroo exec-all --filter 10.20.0.0/16 -p secret -t 30s -- uptime`,
+		Args: cobra.MinimumNArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			targetID := args[0]
-			localPath := args[1]
-			remotePath := args[2]
-
-			// Parse timeout (supports duration strings like "5m" or plain seconds)
+			if parallel < 1 {
+				return fmt.Errorf("--parallel must be at least 1")
+			}
 			timeoutSec, err := parseDuration(timeoutStr)
 			if err != nil {
 				return fmt.Errorf("invalid timeout: %w", err)
 			}
 
-			// Resolve short agent ID prefix to full ID
-			resolvedID, err := resolveAgentID(targetID, agentAddr)
+			nodes, err := listExecNodes(agentAddr, filter, tags)
 			if err != nil {
 				return err
 			}
 
-			// Validate target agent ID
-			if _, err := identity.ParseAgentID(resolvedID); err != nil {
-				return fmt.Errorf("invalid agent ID '%s': %w", resolvedID, err)
+			var targets []execNode
+			skipped := 0
+			for _, n := range nodes {
+				if osFilter != "" && !strings.EqualFold(n.OS, osFilter) {
+					continue
+				}
+				if role != "" && !slices.Contains(n.Roles, role) {
+					continue
+				}
+				// Checked here too, as older agents ignore the tag filter
+				if slices.ContainsFunc(tags, func(tag string) bool { return !slices.Contains(n.AgentTags, tag) }) {
+					continue
+				}
+				if n.IsLocal {
+					continue
+				}
+				if !n.ShellEnabled {
+					skipped++
+					fmt.Fprintf(os.Stderr, "Skipping %s: shell not enabled\n", n.label())
+					continue
+				}
+				targets = append(targets, n)
 			}
-
-			// Validate remote path is absolute (supports both Unix and Windows paths)
-			if !isRemotePathAbsolute(remotePath) {
-				return fmt.Errorf("remote path must be absolute: %s", remotePath)
+			if len(targets) == 0 {
+				return fmt.Errorf("no matching agents with shell enabled")
 			}
 
-			// Resolve local path
-			absLocalPath, err := filepath.Abs(localPath)
-			if err != nil {
-				return fmt.Errorf("failed to resolve local path: %w", err)
+			width := 0
+			for _, n := range targets {
+				width = max(width, len(n.label()))
 			}
 
-			// Check if local file/directory exists
-			info, err := os.Stat(absLocalPath)
-			if err != nil {
-				return fmt.Errorf("cannot access local path: %w", err)
-			}
+			// Stop starting agents on interrupt; running sessions get the
+			// signal from their shell client
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			sigCh := make(chan os.Signal, 1)
+			signal.Notify(sigCh, os.Interrupt)
+			defer signal.Stop(sigCh)
+			go func() {
+				select {
+				case <-sigCh:
+					cancel()
+				case <-ctx.Done():
+				}
+			}()
 
-			// Parse rate limit
-			var rateLimitBytes int64
-			if rateLimit != "" {
-				rateLimitBytes, err = filetransfer.ParseSize(rateLimit)
-				if err != nil {
-					return fmt.Errorf("invalid rate limit: %w", err)
+			var stdoutMu, stderrMu sync.Mutex
+			results := make([]execResult, len(targets))
+			sem := make(chan struct{}, parallel)
+			var wg sync.WaitGroup
+			for i, n := range targets {
+				select {
+				case sem <- struct{}{}:
+				case <-ctx.Done():
+				}
+				if ctx.Err() != nil {
+					results[i] = execResult{node: n, err: errors.New("not started (interrupted)")}
+					continue
 				}
-			}
 
-			// Resume not supported for directories
-			if resume && info.IsDir() {
-				fmt.Println("Warning: Resume not supported for directory uploads, starting fresh")
-				resume = false
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					defer func() { <-sem }()
+
+					prefix := fmt.Sprintf("[%-*s] ", width, n.label())
+					stdout := shell.NewPrefixWriter(os.Stdout, prefix, &stdoutMu)
+					stderr := shell.NewPrefixWriter(os.Stderr, prefix, &stderrMu)
+
+					client := shell.NewClient(shell.ClientConfig{
+						AgentAddr: agentAddr,
+						TargetID:  n.ID,
+						Password:  password,
+						Token:     apiToken,
+						Command:   args[0],
+						Args:      args[1:],
+						Timeout:   timeoutSec,
+						RunAs:     runAs,
+						Stdin:     strings.NewReader(""),
+						Stdout:    stdout,
+						Stderr:    stderr,
+
+						ForwardSignals: true,
+					})
+					start := time.Now()
+					code, err := client.Run(context.Background())
+					stdout.Flush()
+					stderr.Flush()
+					if err != nil {
+						fmt.Fprintf(stderr, "error: %v\n", err)
+						stderr.Flush()
+					}
+					results[i] = execResult{node: n, code: code, err: err, took: time.Since(start)}
+				}()
 			}
+			wg.Wait()
 
-			isDirectory := info.IsDir()
-			return uploadFile(agentAddr, resolvedID, absLocalPath, remotePath, password, timeoutSec, isDirectory, rateLimitBytes, resume, quiet)
+			if printExecSummary(results, width, skipped) > 0 {
+				os.Exit(1)
+			}
+			return nil
 		},
 	}
 
 	cmd.Flags().StringVarP(&agentAddr, "agent", "a", "localhost:8080", "Gateway agent API address (host:port)")
-	cmd.Flags().StringVarP(&password, "password", "p", "", "File transfer password for authentication")
-	cmd.Flags().StringVarP(&timeoutStr, "timeout", "t", "5m", "Transfer timeout (e.g., 30s, 5m, 1h)")
-	cmd.Flags().StringVar(&rateLimit, "rate-limit", "", "Maximum transfer speed (e.g., 100KB, 1MB, 10MiB)")
-	cmd.Flags().BoolVar(&resume, "resume", false, "Resume interrupted transfer if possible")
-	cmd.Flags().BoolVarP(&quiet, "quiet", "q", false, "Suppress progress output")
+	cmd.Flags().StringVarP(&password, "password", "p", "", "Shell password for authentication")
+	cmd.Flags().StringVarP(&timeoutStr, "timeout", "t", "0", "Per-agent session timeout (e.g., 30s, 5m, or 0 for no timeout)")
+	cmd.Flags().StringVar(&filter, "filter", "", "Only agents matching a name, ID prefix, hostname, IP or CIDR")
+	cmd.Flags().StringSliceVar(&tags, "tag", nil, "Only agents with these agent tags (repeatable or comma-separated, all must match)")
+	cmd.Flags().StringVar(&osFilter, "os", "", "Only agents running this OS (linux, darwin, windows)")
+	cmd.Flags().StringVar(&role, "role", "", "Only agents with this role (e.g., exit, ingress)")
+	cmd.Flags().IntVar(&parallel, "parallel", 8, "Maximum number of agents running at once")
+	cmd.Flags().StringVar(&runAs, "run-as", "", "Run as this account on each agent (must be in shell.run_as.allowed_users)")
 
 	return cmd
 }
 
-// uploadFile uploads a file or directory via multipart form streaming.
-func uploadFile(agentAddr, targetID, localPath, remotePath, password string, timeout int, isDirectory bool, rateLimit int64, resume bool, quiet bool) error {
-	info, err := os.Stat(localPath)
-	if err != nil {
-		return fmt.Errorf("cannot access local path: %w", err)
+// execNode is an agent entry returned by /api/nodes.
+type execNode struct {
+	ID           string   `json:"id"`
+	ShortID      string   `json:"short_id"`
+	DisplayName  string   `json:"display_name"`
+	IsLocal      bool     `json:"is_local"`
+	OS           string   `json:"os"`
+	Roles        []string `json:"roles"`
+	AgentTags    []string `json:"agent_tags"`
+	ShellEnabled bool     `json:"shell_enabled"`
+}
+
+// label returns the display name of the agent, or its short ID.
+func (n execNode) label() string {
+	if n.DisplayName != "" {
+		return n.DisplayName
+	}
+	return n.ShortID
+}
+
+// execResult is the outcome of a command on one agent.
+type execResult struct {
+	node execNode
+	code int
+	err  error
+	took time.Duration
+}
+
+// listExecNodes returns the agents known to the API agent that match the
+// search and agent tags of /api/nodes.
+func listExecNodes(agentAddr, search string, tags []string) ([]execNode, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	query := url.Values{}
+	if search != "" {
+		query.Set("q", search)
+	}
+	for _, tag := range tags {
+		query.Add("tag", tag)
+	}
+	endpoint := fmt.Sprintf("http://%s/api/nodes", agentAddr)
+	if len(query) > 0 {
+		endpoint += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	setAuthToken(req)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to agent: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("failed to list agents: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	var nodes struct {
+		Nodes []execNode `json:"nodes"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&nodes); err != nil {
+		return nil, fmt.Errorf("failed to decode agent list: %w", err)
+	}
+	return nodes.Nodes, nil
+}
+
+// printExecSummary prints the exit code of each agent and returns the number
+// of agents that failed.
+func printExecSummary(results []execResult, width, skipped int) int {
+	failed := 0
+	fmt.Println()
+	fmt.Printf("%-*s  %-8s  %-8s  %s\n", width, "AGENT", "ID", "TIME", "RESULT")
+	for _, r := range results {
+		result := fmt.Sprintf("exit %d", r.code)
+		if r.err != nil {
+			result = "error: " + r.err.Error()
+		}
+		if r.err != nil || r.code != 0 {
+			failed++
+		}
+		took := "-"
+		if r.took > 0 {
+			took = r.took.Round(100 * time.Millisecond).String()
+		}
+		fmt.Printf("%-*s  %-8s  %-8s  %s\n", width, r.node.label(), r.node.ShortID, took, result)
+	}
+
+	summary := fmt.Sprintf("%d agents: %d succeeded, %d failed", len(results), len(results)-failed, failed)
+	if skipped > 0 {
+		summary += fmt.Sprintf(", %d skipped", skipped)
+	}
+	fmt.Println(summary)
+	return failed
+}
+
+func uploadCmd() *cobra.Command {
+	var (
+		agentAddr  string
+		password   string
+		timeoutStr string
+		rateLimit  string
+		resume     bool
+		quiet      bool
+		copyOpts   filetransfer.CopyOptions
+	)
+
+	cmd := &cobra.Command{
+		Use:   "upload [flags] <target-agent-id> <local-path> <remote-path>",
+		Short: "Upload a file or directory to a remote agent",
+		Long: `Upload a local file or directory to a remote agent via the file transfer interface.
+
+The <target-agent-id> is the final destination agent where the file is stored.
+The --agent flag specifies which gateway agent to connect through (defaults to localhost).
+
+File permissions (mode) are preserved. The remote path must be absolute.
+Directories are automatically detected and uploaded as tar archives.
+Symlinks and hard links inside directories are kept as links; use
+--symlinks and --hardlinks to change that. With --sparse the remote agent
+leaves runs of zeros as holes, so VM images and other sparse files do not
+grow to their full size.
+
+Examples:
+  # Upload a file to a remote agent
+  muti-metroo upload abc123def456 ./local/file.txt /tmp/remote-file.txt
+
+  # Upload a large file (streaming, supports any size)
+  muti-metroo upload abc123def456 ./large-iso.iso /tmp/large-iso.iso
+
+  # Upload a directory (auto-detected)
+  muti-metroo upload abc123def456 ./my-folder /tmp/my-folder
+
+  # Via a different agent
+  muti-metroo upload -a 192.168.1.10:8080 abc123def456 config.yaml /etc/app/config.yaml
+
+  # With password authentication
+  muti-metroo upload -p secret abc123def456 ./data.bin /home/user/data.bin
+
+  # Rate-limited upload (100 KB/s)
+  muti-metroo upload --rate-limit 100KB abc123def456 ./large.iso /tmp/large.iso
+
+  # Resume an interrupted upload
+  muti-metroo upload --resume abc123def456 ./huge.iso /tmp/huge.iso
+
+  # Upload a sparse VM image, keeping its holes
+  muti-metroo upload --sparse abc123def456 ./disk.img /var/lib/vm/disk.img
+
+  # Upload a directory with the content of symlinks instead of the links
+  muti-metroo upload --symlinks follow abc123def456 ./site /var/www/site`,
+		Args: cobra.ExactArgs(3),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			targetID := args[0]
+			localPath := args[1]
+			remotePath := args[2]
+
+			// Parse timeout (supports duration strings like "5m" or plain seconds)
+			timeoutSec, err := parseDuration(timeoutStr)
+			if err != nil {
+				return fmt.Errorf("invalid timeout: %w", err)
+			}
+
+			// Resolve short agent ID prefix to full ID
+			resolvedID, err := resolveAgentID(targetID, agentAddr)
+			if err != nil {
+				return err
+			}
+
+			// Validate target agent ID
+			if _, err := identity.ParseAgentID(resolvedID); err != nil {
+				return fmt.Errorf("invalid agent ID '%s': %w", resolvedID, err)
+			}
+
+			// Validate remote path is absolute (supports both Unix and Windows paths)
+			if !isRemotePathAbsolute(remotePath) {
+				return fmt.Errorf("remote path must be absolute: %s", remotePath)
+			}
+
+			// Resolve local path
+			absLocalPath, err := filepath.Abs(localPath)
+			if err != nil {
+				return fmt.Errorf("failed to resolve local path: %w", err)
+			}
+
+			// Check if local file/directory exists
+			info, err := os.Stat(absLocalPath)
+			if err != nil {
+				return fmt.Errorf("cannot access local path: %w", err)
+			}
+
+			// Parse rate limit
+			var rateLimitBytes int64
+			if rateLimit != "" {
+				rateLimitBytes, err = filetransfer.ParseSize(rateLimit)
+				if err != nil {
+					return fmt.Errorf("invalid rate limit: %w", err)
+				}
+			}
+
+			if err := copyOpts.Validate(); err != nil {
+				return err
+			}
+
+			// Resume not supported for directories
+			if resume && info.IsDir() {
+				fmt.Println("Warning: Resume not supported for directory uploads, starting fresh")
+				resume = false
+			}
+
+			isDirectory := info.IsDir()
+			return uploadFile(agentAddr, resolvedID, absLocalPath, remotePath, password, timeoutSec, isDirectory, rateLimitBytes, resume, quiet, copyOpts)
+		},
+	}
+
+	cmd.Flags().StringVarP(&agentAddr, "agent", "a", "localhost:8080", "Gateway agent API address (host:port)")
+	cmd.Flags().StringVarP(&password, "password", "p", "", "File transfer password for authentication")
+	cmd.Flags().StringVarP(&timeoutStr, "timeout", "t", "5m", "Transfer timeout (e.g., 30s, 5m, 1h)")
+	cmd.Flags().StringVar(&rateLimit, "rate-limit", "", "Maximum transfer speed (e.g., 100KB, 1MB, 10MiB)")
+	cmd.Flags().BoolVar(&resume, "resume", false, "Resume interrupted transfer if possible")
+	cmd.Flags().BoolVarP(&quiet, "quiet", "q", false, "Suppress progress output")
+	addCopyFlags(cmd, &copyOpts)
+
+	return cmd
+}
+
+// uploadFile uploads a file or directory via multipart form streaming.
+func uploadFile(agentAddr, targetID, localPath, remotePath, password string, timeout int, isDirectory bool, rateLimit int64, resume bool, quiet bool, copyOpts filetransfer.CopyOptions) error {
+	info, err := os.Stat(localPath)
+	if err != nil {
+		return fmt.Errorf("cannot access local path: %w", err)
 	}
 
 	// Create a pipe for the multipart form
@@ -2173,6 +2879,7 @@ func uploadFile(agentAddr, targetID, localPath, remotePath, password string, tim
 	}
 	startTime := time.Now()
 	var bytesWritten int64
+	sum := filetransfer.NewChecksum()
 
 	// Start goroutine to write form data
 	errCh := make(chan error, 1)
@@ -2196,6 +2903,12 @@ func uploadFile(agentAddr, targetID, localPath, remotePath, password string, tim
 			// Also include original file size for validation
 			writer.WriteField("original_size", fmt.Sprintf("%d", info.Size()))
 		}
+		if copyOpts.Sparse {
+			writer.WriteField("sparse", "true")
+		}
+		if copyOpts.ExternalSymlinks {
+			writer.WriteField("external_symlinks", "true")
+		}
 
 		// Create file part
 		part, err := writer.CreateFormFile("file", filepath.Base(localPath))
@@ -2209,7 +2922,7 @@ func uploadFile(agentAddr, targetID, localPath, remotePath, password string, tim
 			if !quiet {
 				fmt.Printf("Uploading directory %s to %s:%s\n", localPath, targetID[:12], remotePath)
 			}
-			if err := filetransfer.TarDirectory(localPath, part); err != nil {
+			if err := filetransfer.TarDirectory(localPath, part, copyOpts); err != nil {
 				errCh <- fmt.Errorf("failed to tar directory: %w", err)
 				return
 			}
@@ -2228,7 +2941,7 @@ func uploadFile(agentAddr, targetID, localPath, remotePath, password string, tim
 
 			// Create progress-tracking reader
 			progressReader := &progressTrackingReader{
-				reader:    f,
+				reader:    io.TeeReader(f, sum),
 				total:     totalSize,
 				written:   &bytesWritten,
 				startTime: startTime,
@@ -2239,6 +2952,9 @@ func uploadFile(agentAddr, targetID, localPath, remotePath, password string, tim
 				errCh <- fmt.Errorf("failed to stream file: %w", err)
 				return
 			}
+
+			// Lets the agent verify the file it received
+			writer.WriteField("checksum", filetransfer.ChecksumHex(sum))
 		}
 		errCh <- nil
 	}()
@@ -2307,6 +3023,7 @@ func uploadFile(agentAddr, targetID, localPath, remotePath, password string, tim
 		Error        string `json:"error,omitempty"`
 		BytesWritten int64  `json:"bytes_written"`
 		RemotePath   string `json:"remote_path"`
+		Checksum     string `json:"checksum"`
 	}
 	if err := json.Unmarshal(respBody, &uploadResp); err != nil {
 		if !quiet {
@@ -2322,6 +3039,14 @@ func uploadFile(agentAddr, targetID, localPath, remotePath, password string, tim
 		return fmt.Errorf("upload failed: %s", uploadResp.Error)
 	}
 
+	// Directories are archived again by the agent, so only files can be
+	// compared with the local checksum
+	if !isDirectory && uploadResp.Checksum != "" {
+		if err := filetransfer.VerifyChecksum(uploadResp.Checksum, filetransfer.ChecksumHex(sum)); err != nil {
+			return fmt.Errorf("upload failed: %w", err)
+		}
+	}
+
 	if !quiet {
 		elapsed := time.Since(startTime)
 		speed := float64(uploadResp.BytesWritten) / elapsed.Seconds()
@@ -2330,6 +3055,9 @@ func uploadFile(agentAddr, targetID, localPath, remotePath, password string, tim
 			remotePath,
 			elapsed.Round(time.Millisecond),
 			humanize.Bytes(uint64(speed)))
+		if uploadResp.Checksum != "" {
+			fmt.Printf("SHA-256: %s\n", uploadResp.Checksum)
+		}
 	}
 
 	return nil
@@ -2342,7 +3070,10 @@ func downloadCmd() *cobra.Command {
 		timeoutStr string
 		rateLimit  string
 		resume     bool
+		parallel   int
+		multipath  bool
 		quiet      bool
+		copyOpts   filetransfer.CopyOptions
 	)
 
 	cmd := &cobra.Command{
@@ -2355,6 +3086,14 @@ The --agent flag specifies which gateway agent to connect through (defaults to l
 
 File permissions (mode) are preserved. The remote path must be absolute.
 Directories are automatically detected and downloaded as tar archives.
+Symlinks and hard links inside directories are kept as links; use
+--symlinks and --hardlinks to change how the remote agent archives them.
+With --sparse, runs of zeros are written as holes.
+
+With --parallel N, a large file is split into up to N ranges that are
+downloaded over separate streams and verified one by one, which helps on
+long multi-hop paths where a single stream cannot fill the link. Add
+--multipath to spread the ranges over all known paths to the agent.
 
 Examples:
   # Download a file from a remote agent
@@ -2376,7 +3115,16 @@ Examples:
   muti-metroo download --rate-limit 1MB abc123def456 /data/backup.tar.gz ./backup.tar.gz
 
   # Resume an interrupted download
-  muti-metroo download --resume abc123def456 /data/large.iso ./large.iso`,
+  muti-metroo download --resume abc123def456 /data/large.iso ./large.iso
+
+  # Download over 8 parallel streams, using every path to the agent
+  muti-metroo download --parallel 8 --multipath abc123def456 /data/large.iso ./large.iso
+
+  # Download a sparse VM image, keeping its holes
+  muti-metroo download --sparse abc123def456 /var/lib/vm/disk.img ./disk.img
+
+  # Copy a system directory, keeping symlinks that point outside it
+  muti-metroo download --external-symlinks abc123def456 /etc ./etc-backup`,
 		Args: cobra.ExactArgs(3),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			targetID := args[0]
@@ -2420,7 +3168,21 @@ Examples:
 				}
 			}
 
-			return downloadFile(agentAddr, resolvedID, remotePath, absLocalPath, password, timeoutSec, rateLimitBytes, resume, quiet)
+			if err := copyOpts.Validate(); err != nil {
+				return err
+			}
+
+			if parallel < 1 || parallel > filetransfer.MaxParallelStreams {
+				return fmt.Errorf("--parallel must be between 1 and %d", filetransfer.MaxParallelStreams)
+			}
+			if parallel > 1 {
+				if resume {
+					return fmt.Errorf("--resume cannot be combined with --parallel")
+				}
+				return downloadFileParallel(agentAddr, resolvedID, remotePath, absLocalPath, password, timeoutSec, rateLimitBytes, parallel, multipath, quiet, copyOpts)
+			}
+
+			return downloadFile(agentAddr, resolvedID, remotePath, absLocalPath, password, timeoutSec, rateLimitBytes, resume, quiet, copyOpts)
 		},
 	}
 
@@ -2429,13 +3191,25 @@ Examples:
 	cmd.Flags().StringVarP(&timeoutStr, "timeout", "t", "5m", "Transfer timeout (e.g., 30s, 5m, 1h)")
 	cmd.Flags().StringVar(&rateLimit, "rate-limit", "", "Maximum transfer speed (e.g., 100KB, 1MB, 10MiB)")
 	cmd.Flags().BoolVar(&resume, "resume", false, "Resume interrupted transfer if possible")
+	cmd.Flags().IntVar(&parallel, "parallel", 1, "Download a file as up to N ranges over parallel streams")
+	cmd.Flags().BoolVar(&multipath, "multipath", false, "Spread parallel ranges over all known paths to the agent")
 	cmd.Flags().BoolVarP(&quiet, "quiet", "q", false, "Suppress progress output")
+	addCopyFlags(cmd, &copyOpts)
 
 	return cmd
 }
 
+// addCopyFlags registers the link and sparse file flags shared by upload and
+// download.
+func addCopyFlags(cmd *cobra.Command, opts *filetransfer.CopyOptions) {
+	cmd.Flags().StringVar(&opts.Symlinks, "symlinks", filetransfer.SymlinkPreserve, "Symlinks in directories: preserve, follow or skip")
+	cmd.Flags().StringVar(&opts.Hardlinks, "hardlinks", filetransfer.HardlinkPreserve, "Hard-linked files in directories: preserve or copy")
+	cmd.Flags().BoolVar(&opts.Sparse, "sparse", false, "Write runs of zeros as holes (sparse files)")
+	cmd.Flags().BoolVar(&opts.ExternalSymlinks, "external-symlinks", false, "Keep symlinks that point outside the destination directory")
+}
+
 // downloadFile downloads a file or directory via streaming.
-func downloadFile(agentAddr, targetID, remotePath, localPath, password string, timeout int, rateLimit int64, resume bool, quiet bool) error {
+func downloadFile(agentAddr, targetID, remotePath, localPath, password string, timeout int, rateLimit int64, resume bool, quiet bool, copyOpts filetransfer.CopyOptions) error {
 	if !quiet {
 		fmt.Printf("Downloading %s:%s to %s\n", targetID[:12], remotePath, localPath)
 	}
@@ -2473,6 +3247,12 @@ func downloadFile(agentAddr, targetID, remotePath, localPath, password string, t
 		reqBody["offset"] = offset
 		reqBody["original_size"] = originalSize
 	}
+	if copyOpts.Symlinks != "" && copyOpts.Symlinks != filetransfer.SymlinkPreserve {
+		reqBody["symlinks"] = copyOpts.Symlinks
+	}
+	if copyOpts.Hardlinks != "" && copyOpts.Hardlinks != filetransfer.HardlinkPreserve {
+		reqBody["hardlinks"] = copyOpts.Hardlinks
+	}
 
 	reqJSON, err := json.Marshal(reqBody)
 	if err != nil {
@@ -2559,8 +3339,43 @@ func downloadFile(agentAddr, targetID, remotePath, localPath, password string, t
 			return fmt.Errorf("failed to create directory: %w", err)
 		}
 
+		// Spool the archive so it can be verified before extraction
 		startTime := time.Now()
-		if err := filetransfer.UntarDirectory(resp.Body, localPath); err != nil {
+		archive, err := os.CreateTemp("", "download-*.tar.gz")
+		if err != nil {
+			if !quiet {
+				fmt.Println("FAILED")
+			}
+			return fmt.Errorf("failed to create temp file: %w", err)
+		}
+		defer os.Remove(archive.Name())
+		defer archive.Close()
+
+		sum := filetransfer.NewChecksum()
+		if _, err := io.Copy(io.MultiWriter(archive, sum), resp.Body); err != nil {
+			if !quiet {
+				fmt.Println("FAILED")
+			}
+			return fmt.Errorf("failed to receive directory: %w", err)
+		}
+
+		checksum := resp.Trailer.Get("X-Checksum-Sha256")
+		if checksum != "" {
+			if err := filetransfer.VerifyChecksum(checksum, filetransfer.ChecksumHex(sum)); err != nil {
+				if !quiet {
+					fmt.Println("FAILED")
+				}
+				return fmt.Errorf("download failed: %w", err)
+			}
+		}
+
+		if _, err := archive.Seek(0, io.SeekStart); err != nil {
+			if !quiet {
+				fmt.Println("FAILED")
+			}
+			return fmt.Errorf("failed to read archive: %w", err)
+		}
+		if err := filetransfer.UntarDirectory(archive, localPath, copyOpts); err != nil {
 			if !quiet {
 				fmt.Println("FAILED")
 			}
@@ -2571,6 +3386,7 @@ func downloadFile(agentAddr, targetID, remotePath, localPath, password string, t
 		if !quiet {
 			fmt.Println("OK")
 			fmt.Printf("Extracted directory to %s in %.1fs\n", localPath, elapsed.Seconds())
+			printDownloadChecksum(checksum)
 		}
 	} else {
 		// Write file directly
@@ -2600,9 +3416,26 @@ func downloadFile(agentAddr, targetID, remotePath, localPath, password string, t
 		var f *os.File
 		var written int64
 
+		// The agent's checksum covers the whole file, including the part
+		// a resumed download already has
+		sum := filetransfer.NewChecksum()
+		if offset > 0 {
+			if err := filetransfer.HashFilePrefix(sum, filetransfer.GetPartialPath(localPath), offset); err != nil {
+				if !quiet {
+					fmt.Println("FAILED")
+				}
+				return err
+			}
+		}
+
 		if offset > 0 {
-			// Resume: open partial file for appending
-			f, err = filetransfer.OpenPartialFileForAppend(localPath)
+			// Resume: open partial file for appending. Sparse writes seek,
+			// which appending would ignore.
+			if copyOpts.Sparse {
+				f, err = filetransfer.OpenPartialFileAt(localPath, offset)
+			} else {
+				f, err = filetransfer.OpenPartialFileForAppend(localPath)
+			}
 			if err != nil {
 				if !quiet {
 					fmt.Println("FAILED")
@@ -2629,6 +3462,17 @@ func downloadFile(agentAddr, targetID, remotePath, localPath, password string, t
 			}
 		}
 
+		var fw io.WriteCloser = f
+		if copyOpts.Sparse {
+			if fw, err = filetransfer.NewSparseWriter(f); err != nil {
+				f.Close()
+				if !quiet {
+					fmt.Println("FAILED")
+				}
+				return err
+			}
+		}
+
 		// Copy data to file with progress tracking
 		startTime := time.Now()
 		if !quiet {
@@ -2636,7 +3480,7 @@ func downloadFile(agentAddr, targetID, remotePath, localPath, password string, t
 		}
 
 		pw := &progressTrackingWriter{
-			writer:    f,
+			writer:    io.MultiWriter(fw, sum),
 			total:     totalSize,
 			written:   &written,
 			startTime: startTime,
@@ -2644,7 +3488,9 @@ func downloadFile(agentAddr, targetID, remotePath, localPath, password string, t
 		}
 
 		newBytes, err := io.Copy(pw, resp.Body)
-		f.Close()
+		if closeErr := fw.Close(); err == nil {
+			err = closeErr
+		}
 
 		if err != nil {
 			// Update partial info with progress so far
@@ -2658,6 +3504,19 @@ func downloadFile(agentAddr, targetID, remotePath, localPath, password string, t
 			return fmt.Errorf("failed to write file: %w", err)
 		}
 
+		// A corrupt partial file cannot be resumed, so start over next time
+		checksum := resp.Trailer.Get("X-Checksum-Sha256")
+		if checksum != "" {
+			if err := filetransfer.VerifyChecksum(checksum, filetransfer.ChecksumHex(sum)); err != nil {
+				filetransfer.CleanupPartial(localPath)
+				if !quiet {
+					fmt.Print("\r") // Clear progress bar
+				}
+				fmt.Println("FAILED")
+				return fmt.Errorf("download failed: %w", err)
+			}
+		}
+
 		// Finalize: rename partial to final
 		if err := filetransfer.FinalizePartial(localPath, mode); err != nil {
 			if !quiet {
@@ -2683,36 +3542,487 @@ func downloadFile(agentAddr, targetID, remotePath, localPath, password string, t
 				humanize.Bytes(uint64(written)), localPath,
 				elapsed.Seconds(), humanize.Bytes(uint64(speed)))
 		}
+		if !quiet {
+			printDownloadChecksum(checksum)
+		}
 	}
 
 	return nil
 }
 
-func pingCmd() *cobra.Command {
-	var (
-		agentAddr   string
-		count       int
-		intervalStr string
-		timeoutStr  string
-	)
+// printDownloadChecksum prints the verified checksum of a download, or a
+// warning when the agent did not send one (older agents).
+func printDownloadChecksum(checksum string) {
+	if checksum == "" {
+		fmt.Println("Warning: agent sent no checksum, download not verified")
+		return
+	}
+	fmt.Printf("SHA-256: %s\n", checksum)
+}
 
-	cmd := &cobra.Command{
-		Use:   "ping [flags] <target-agent-id> <destination>",
-		Short: "Send ICMP echo requests through a remote agent",
-		Long: `Send ICMP echo (ping) requests through a remote agent.
+// downloadFileParallel downloads a file as parallel ranges, each over its
+// own mesh stream and verified with its own checksum. A range that fails is
+// retried on its own, over the next path when multipath is set. Directories,
+// symlinks and files too small to split are downloaded with downloadFile.
+func downloadFileParallel(agentAddr, targetID, remotePath, localPath, password string, timeout int, rateLimit int64, parallel int, multipath bool, quiet bool, copyOpts filetransfer.CopyOptions) error {
+	var stat filetransfer.BrowseResponse
+	if err := remoteBrowse(agentAddr, targetID, &filetransfer.BrowseRequest{Action: "stat", Path: remotePath, Password: password}, &stat); err != nil {
+		return fmt.Errorf("download failed: %w", err)
+	}
+	entry := stat.Entry
+	if entry == nil || entry.IsDir || entry.IsSymlink || entry.Size < 2*filetransfer.MinParallelChunk {
+		return downloadFile(agentAddr, targetID, remotePath, localPath, password, timeout, rateLimit, false, quiet, copyOpts)
+	}
 
-The <target-agent-id> is the exit agent that sends the actual ICMP packets.
-The --agent flag specifies which gateway agent to connect through.
+	var mode os.FileMode = 0644
+	var modeVal uint32
+	if _, err := fmt.Sscanf(entry.Mode, "%o", &modeVal); err == nil {
+		mode = os.FileMode(modeVal)
+	}
 
-The destination must be an IP address (domain names are not supported for ICMP).
+	ranges := filetransfer.SplitRanges(entry.Size, parallel)
+	if !quiet {
+		fmt.Printf("Downloading %s:%s to %s (%d ranges)\n", targetID[:12], remotePath, localPath, len(ranges))
+	}
 
-Note: The exit agent must have ICMP enabled in its configuration:
-  icmp:
-    enabled: true
-    allowed_cidrs:
-      - "0.0.0.0/0"
+	// Each range writes into its part of a file that already has its final
+	// size, through a file handle of its own
+	if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
+		return fmt.Errorf("failed to create parent directory: %w", err)
+	}
+	partialPath := filetransfer.GetPartialPath(localPath)
+	f, err := os.OpenFile(partialPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+	err = f.Truncate(entry.Size)
+	f.Close()
+	if err != nil {
+		os.Remove(partialPath)
+		return fmt.Errorf("failed to create file: %w", err)
+	}
 
-Examples:
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Second)
+	defer cancel()
+
+	// Split the rate limit so all ranges together stay within it
+	rangeLimit := rateLimit / int64(len(ranges))
+	if rateLimit > 0 && rangeLimit == 0 {
+		rangeLimit = 1
+	}
+
+	startTime := time.Now()
+	var received atomic.Int64
+	done := make(chan struct{})
+	if !quiet {
+		go func() {
+			ticker := time.NewTicker(100 * time.Millisecond)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-done:
+					return
+				case <-ticker.C:
+					printProgress(received.Load(), entry.Size, startTime)
+				}
+			}
+		}()
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(ranges))
+	for i, r := range ranges {
+		wg.Add(1)
+		go func(i int, r filetransfer.ByteRange) {
+			defer wg.Done()
+			for attempt := 0; attempt < 3; attempt++ {
+				route := 0
+				if multipath {
+					route = i + attempt
+				}
+				counter := &rangeCounter{total: &received}
+				errs[i] = downloadRange(ctx, agentAddr, targetID, remotePath, password, partialPath, r, entry.Size, rangeLimit, route, copyOpts.Sparse, counter)
+				if errs[i] == nil || ctx.Err() != nil {
+					return
+				}
+				received.Add(-counter.n)
+			}
+		}(i, r)
+	}
+	wg.Wait()
+	close(done)
+	if !quiet {
+		fmt.Print("\r\033[K") // Clear line
+	}
+
+	for i, err := range errs {
+		if err != nil {
+			os.Remove(partialPath)
+			return fmt.Errorf("download failed: range %d-%d: %w", ranges[i].Offset, ranges[i].Offset+ranges[i].Length, err)
+		}
+	}
+
+	if err := filetransfer.FinalizePartial(localPath, mode); err != nil {
+		return fmt.Errorf("failed to finalize file: %w", err)
+	}
+
+	elapsed := time.Since(startTime)
+	speed := float64(entry.Size) / elapsed.Seconds()
+	fmt.Printf("Downloaded %s to %s in %.1fs (%s/s, %d ranges)\n",
+		humanize.Bytes(uint64(entry.Size)), localPath,
+		elapsed.Seconds(), humanize.Bytes(uint64(speed)), len(ranges))
+	if !quiet {
+		// Every range was verified; the hash of the whole file is for the user
+		checksum, err := filetransfer.HashFile(localPath)
+		if err != nil {
+			return err
+		}
+		printDownloadChecksum(checksum)
+	}
+	return nil
+}
+
+// downloadRange downloads one range of a file into its place in the partial
+// file and verifies it against the checksum the agent sends for the range.
+// Received bytes are counted in counter.
+func downloadRange(ctx context.Context, agentAddr, targetID, remotePath, password, partialPath string, r filetransfer.ByteRange, size, rateLimit int64, route int, sparse bool, counter *rangeCounter) error {
+	reqBody := map[string]interface{}{
+		"path":          remotePath,
+		"offset":        r.Offset,
+		"length":        r.Length,
+		"original_size": size,
+	}
+	if password != "" {
+		reqBody["password"] = password
+	}
+	if rateLimit > 0 {
+		reqBody["rate_limit"] = rateLimit
+	}
+	if route > 0 {
+		reqBody["route"] = route
+	}
+	reqJSON, err := json.Marshal(reqBody)
+	if err != nil {
+		return fmt.Errorf("failed to encode request: %w", err)
+	}
+
+	url := fmt.Sprintf("http://%s/agents/%s/file/download", agentAddr, targetID)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(reqJSON))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	setAuthToken(req)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") {
+		var errResp struct {
+			Error string `json:"error"`
+		}
+		respBody, _ := io.ReadAll(resp.Body)
+		if err := json.Unmarshal(respBody, &errResp); err == nil && errResp.Error != "" {
+			return fmt.Errorf("%s", errResp.Error)
+		}
+		return fmt.Errorf("unexpected JSON response: %s", string(respBody))
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("request failed: %s", resp.Status)
+	}
+
+	f, err := os.OpenFile(partialPath, os.O_WRONLY, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open partial file: %w", err)
+	}
+	defer f.Close()
+	if _, err := f.Seek(r.Offset, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek partial file: %w", err)
+	}
+
+	// The file already has its final size, so a sparse writer is not
+	// closed: that would truncate the file at the end of this range
+	var w io.Writer = f
+	if sparse {
+		if w, err = filetransfer.NewSparseWriter(f); err != nil {
+			return err
+		}
+	}
+
+	sum := filetransfer.NewChecksum()
+	copied, err := io.Copy(io.MultiWriter(w, sum, counter), io.LimitReader(resp.Body, r.Length+1))
+	if err != nil {
+		return fmt.Errorf("failed to receive range: %w", err)
+	}
+	if copied != r.Length {
+		return fmt.Errorf("received %d bytes, want %d", copied, r.Length)
+	}
+
+	checksum := resp.Trailer.Get("X-Checksum-Sha256")
+	if err := filetransfer.VerifyChecksum(checksum, filetransfer.ChecksumHex(sum)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// rangeCounter counts the bytes received for one range of a parallel
+// download, both on its own and in the total of all ranges.
+type rangeCounter struct {
+	total *atomic.Int64
+	n     int64
+}
+
+func (c *rangeCounter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	c.total.Add(int64(len(p)))
+	return len(p), nil
+}
+
+func syncCmd() *cobra.Command {
+	var (
+		agentAddr  string
+		password   string
+		timeoutStr string
+		rateLimit  string
+		deleteFlag bool
+		dryRun     bool
+		quiet      bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "sync [flags] <target-agent-id> <local-dir> <remote-dir>",
+		Short: "Sync a local directory to a remote agent, sending only changed files",
+		Long: `Make a directory on a remote agent match a local directory.
+
+Both sides build a manifest of their files (size, modification time and
+SHA-256). Only files that are missing or differ remotely are uploaded; files
+with the same size and hash are skipped. With --delete, remote files and
+directories that do not exist locally are removed.
+
+Symlinks and empty directories are not synced. The remote directory is
+created if it does not exist. The remote agent must allow the path in
+file_transfer.allowed_paths.
+
+Examples:
+  # Push a config directory
+  muti-metroo sync abc123def456 ./app-config /etc/app
+
+  # Also remove remote files that were deleted locally
+  muti-metroo sync --delete abc123def456 ./app-config /etc/app
+
+  # Show what would change without transferring anything
+  muti-metroo sync --dry-run --delete abc123def456 ./app-config /etc/app`,
+		Args: cobra.ExactArgs(3),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			targetID := args[0]
+			localDir := args[1]
+			remoteDir := args[2]
+
+			timeoutSec, err := parseDuration(timeoutStr)
+			if err != nil {
+				return fmt.Errorf("invalid timeout: %w", err)
+			}
+
+			resolvedID, err := resolveAgentID(targetID, agentAddr)
+			if err != nil {
+				return err
+			}
+			if _, err := identity.ParseAgentID(resolvedID); err != nil {
+				return fmt.Errorf("invalid agent ID '%s': %w", resolvedID, err)
+			}
+
+			if !isRemotePathAbsolute(remoteDir) {
+				return fmt.Errorf("remote path must be absolute: %s", remoteDir)
+			}
+
+			absLocalDir, err := filepath.Abs(localDir)
+			if err != nil {
+				return fmt.Errorf("failed to resolve local path: %w", err)
+			}
+			info, err := os.Stat(absLocalDir)
+			if err != nil {
+				return fmt.Errorf("cannot access local path: %w", err)
+			}
+			if !info.IsDir() {
+				return fmt.Errorf("local path is not a directory: %s (use upload for single files)", localDir)
+			}
+
+			var rateLimitBytes int64
+			if rateLimit != "" {
+				rateLimitBytes, err = filetransfer.ParseSize(rateLimit)
+				if err != nil {
+					return fmt.Errorf("invalid rate limit: %w", err)
+				}
+			}
+
+			return syncDirectory(agentAddr, resolvedID, absLocalDir, remoteDir, password, timeoutSec, rateLimitBytes, deleteFlag, dryRun, quiet)
+		},
+	}
+
+	cmd.Flags().StringVarP(&agentAddr, "agent", "a", "localhost:8080", "Gateway agent API address (host:port)")
+	cmd.Flags().StringVarP(&password, "password", "p", "", "File transfer password for authentication")
+	cmd.Flags().StringVarP(&timeoutStr, "timeout", "t", "5m", "Timeout per file transfer (e.g., 30s, 5m, 1h)")
+	cmd.Flags().StringVar(&rateLimit, "rate-limit", "", "Maximum transfer speed (e.g., 100KB, 1MB, 10MiB)")
+	cmd.Flags().BoolVar(&deleteFlag, "delete", false, "Delete remote files that do not exist locally")
+	cmd.Flags().BoolVarP(&dryRun, "dry-run", "n", false, "Show what would change without changing anything")
+	cmd.Flags().BoolVarP(&quiet, "quiet", "q", false, "Only print the summary")
+
+	return cmd
+}
+
+// syncDirectory uploads the files of localDir that are missing or differ in
+// remoteDir on the target agent, and optionally deletes extraneous remote
+// entries.
+func syncDirectory(agentAddr, targetID, localDir, remoteDir, password string, timeout int, rateLimit int64, deleteExtraneous, dryRun, quiet bool) error {
+	startTime := time.Now()
+
+	local, err := filetransfer.BuildManifest(localDir)
+	if err != nil {
+		return fmt.Errorf("failed to scan local directory: %w", err)
+	}
+	remote, err := fetchRemoteManifest(agentAddr, targetID, remoteDir, password)
+	if err != nil {
+		return err
+	}
+
+	plan := filetransfer.PlanSync(local, remote, deleteExtraneous)
+	remoteBase := strings.TrimRight(remoteDir, "/\\")
+
+	// Deletes go first, so files can replace directories of the same name
+	for _, e := range plan.Delete {
+		if !quiet {
+			fmt.Printf("deleting %s\n", e.Path)
+		}
+		if dryRun {
+			continue
+		}
+		if err := remoteBrowse(agentAddr, targetID, &filetransfer.BrowseRequest{
+			Action:    "delete",
+			Path:      remoteBase + "/" + e.Path,
+			Password:  password,
+			Recursive: e.IsDir,
+		}, nil); err != nil {
+			return fmt.Errorf("failed to delete %s: %w", e.Path, err)
+		}
+	}
+
+	var uploaded int64
+	for _, e := range plan.Upload {
+		if !quiet {
+			fmt.Printf("%s (%s)\n", e.Path, humanize.Bytes(uint64(e.Size)))
+		}
+		if dryRun {
+			continue
+		}
+		localPath := filepath.Join(localDir, filepath.FromSlash(e.Path))
+		if err := uploadFile(agentAddr, targetID, localPath, remoteBase+"/"+e.Path, password, timeout, false, rateLimit, false, true, filetransfer.CopyOptions{}); err != nil {
+			return fmt.Errorf("failed to upload %s: %w", e.Path, err)
+		}
+		uploaded += e.Size
+	}
+
+	prefix := "Synced"
+	if dryRun {
+		prefix = "Dry run:"
+	}
+	fmt.Printf("%s %s to %s:%s: %d uploaded (%s), %d unchanged, %d deleted in %s\n",
+		prefix, localDir, targetID[:12], remoteDir,
+		len(plan.Upload), humanize.Bytes(uint64(uploaded)), plan.Unchanged, len(plan.Delete),
+		time.Since(startTime).Round(time.Millisecond))
+	return nil
+}
+
+// fetchRemoteManifest reads the manifest of a remote directory page by page.
+func fetchRemoteManifest(agentAddr, targetID, remoteDir, password string) ([]filetransfer.ManifestEntry, error) {
+	var entries []filetransfer.ManifestEntry
+	for {
+		var page filetransfer.BrowseResponse
+		if err := remoteBrowse(agentAddr, targetID, &filetransfer.BrowseRequest{
+			Action:   "manifest",
+			Path:     remoteDir,
+			Password: password,
+			Offset:   len(entries),
+			Limit:    100,
+		}, &page); err != nil {
+			return nil, fmt.Errorf("failed to read remote manifest: %w", err)
+		}
+		entries = append(entries, page.Manifest...)
+		if !page.Truncated || len(page.Manifest) == 0 {
+			return entries, nil
+		}
+	}
+}
+
+// remoteBrowse sends a file browse request to the target agent and decodes
+// the response into out, if given.
+func remoteBrowse(agentAddr, targetID string, browseReq *filetransfer.BrowseRequest, out *filetransfer.BrowseResponse) error {
+	body, _ := json.Marshal(browseReq)
+	url := fmt.Sprintf("http://%s/agents/%s/file/browse", agentAddr, targetID)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	setAuthToken(req)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to connect to agent: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+	var result filetransfer.BrowseResponse
+	if err := json.Unmarshal(respBody, &result); err != nil {
+		return fmt.Errorf("unexpected response (%s): %s", resp.Status, strings.TrimSpace(string(respBody)))
+	}
+	if result.Error != "" {
+		return fmt.Errorf("%s", result.Error)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("request failed: %s", resp.Status)
+	}
+	if out != nil {
+		*out = result
+	}
+	return nil
+}
+
+func pingCmd() *cobra.Command {
+	var (
+		agentAddr   string
+		count       int
+		intervalStr string
+		timeoutStr  string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "ping [flags] <target-agent-id> <destination>",
+		Short: "Send ICMP echo requests through a remote agent",
+		Long: `Send ICMP echo (ping) requests through a remote agent.
+
+The <target-agent-id> is the exit agent that sends the actual ICMP packets.
+The --agent flag specifies which gateway agent to connect through.
+
+The destination must be an IP address (domain names are not supported for ICMP).
+
+Note: The exit agent must have ICMP enabled in its configuration:
+  icmp:
+    enabled: true
+    allowed_cidrs:
+      - "0.0.0.0/0"
+
+Examples:
   # Ping through a remote agent
   muti-metroo ping abc123def456 8.8.8.8
 
@@ -3010,27 +4320,158 @@ Examples:
 	return cmd
 }
 
-func managementKeyCmd() *cobra.Command {
+func benchCmd() *cobra.Command {
+	var nodes int
+	var topology string
+	var protocol string
+	var concurrency int
+	var duration time.Duration
+	var size int
+	var logLevel string
+	var jsonOutput bool
+
 	cmd := &cobra.Command{
-		Use:   "management-key",
-		Short: "Manage mesh topology encryption keys",
-		Long: `Manage X25519 keypairs for encrypting mesh topology data.
+		Use:   "bench",
+		Short: "Benchmark an in-process mesh",
+		Long: `Start a mesh of agents inside this process, connected by in-memory
+transports, and measure it under load.
+
+Node 0 is the ingress and the last node is the exit. Each worker sends a
+payload from the ingress through the mesh to an echo server on loopback
+behind the exit and waits for it to come back. TCP workers hold one mesh
+connection each; UDP workers hold one SOCKS5 UDP association each.
+
+No configuration or network access is needed, and nothing is exposed
+outside the process except the ingress SOCKS5 server on 127.0.0.1. Results
+measure the agent code (routing, relaying, encryption, framing) without
+network or TLS overhead, so they are useful for comparing builds and
+settings rather than predicting throughput over real links.
 
-When management key encryption is enabled, sensitive data like NodeInfo
-(hostnames, IPs, OS info) and route paths are encrypted before flooding
-through the mesh. Only operators with the private key can decrypt and
-view topology details.
+Examples:
+  # 4-node chain, TCP, 8 workers for 10 seconds
+  muti-metroo bench
 
-This provides cryptographic compartmentalization: if a field agent is
-compromised, the attacker only sees encrypted blobs, not the mesh topology.`,
-	}
+  # UDP across a 6-node full mesh with 1200-byte datagrams
+  muti-metroo bench --nodes 6 --topology full --protocol udp --size 1200
 
-	// Add subcommands
-	cmd.AddCommand(managementKeyGenerateCmd())
-	cmd.AddCommand(managementKeyPublicCmd())
+  # Machine-readable output
+  muti-metroo bench --duration 30s --json`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
 
-	return cmd
-}
+			if !jsonOutput {
+				fmt.Printf("Starting %d-node %s mesh...\n", nodes, topology)
+			}
+			mesh, err := testmesh.New(ctx, testmesh.Options{
+				Nodes:    nodes,
+				Topology: testmesh.Topology(topology),
+				LogLevel: logLevel,
+			})
+			if err != nil {
+				return err
+			}
+			defer mesh.Close()
+
+			if !jsonOutput {
+				fmt.Printf("Running %s load: %d workers, %d-byte payload, %s...\n",
+					protocol, concurrency, size, duration)
+			}
+			res, err := mesh.RunLoad(ctx, testmesh.LoadOptions{
+				Protocol:    protocol,
+				Concurrency: concurrency,
+				Duration:    duration,
+				PayloadSize: size,
+			})
+			if err != nil {
+				return err
+			}
+
+			if jsonOutput {
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "  ")
+				return enc.Encode(res)
+			}
+
+			fmt.Println()
+			fmt.Printf("Operations:   %d (%d errors) in %s\n", res.Operations, res.Errors, res.Duration.Round(time.Millisecond))
+			fmt.Printf("Throughput:   %.0f ops/s, %s/s\n", res.OpsPerSecond, humanize.Bytes(uint64(res.BytesPerSecond)))
+			fmt.Printf("Latency:      min %s  p50 %s  p90 %s  p99 %s  max %s\n",
+				res.Latency.Min.Round(time.Microsecond),
+				res.Latency.P50.Round(time.Microsecond),
+				res.Latency.P90.Round(time.Microsecond),
+				res.Latency.P99.Round(time.Microsecond),
+				res.Latency.Max.Round(time.Microsecond))
+			fmt.Printf("Allocations:  %s total, %d allocs (%.0f B/op, %.1f allocs/op), %d GC cycles\n",
+				humanize.Bytes(res.Allocs.TotalBytes), res.Allocs.Mallocs,
+				res.Allocs.BytesPerOp, res.Allocs.AllocsPerOp, res.Allocs.NumGC)
+			fmt.Printf("Heap in use:  %s\n", humanize.Bytes(res.Allocs.HeapInUse))
+			return nil
+		},
+	}
+
+	cmd.Flags().IntVar(&nodes, "nodes", 4, "Number of agents in the mesh (at least 2)")
+	cmd.Flags().StringVar(&topology, "topology", "chain", "How agents are peered: chain or full")
+	cmd.Flags().StringVar(&protocol, "protocol", "tcp", "Load protocol: tcp or udp")
+	cmd.Flags().IntVarP(&concurrency, "concurrency", "c", 8, "Number of parallel workers")
+	cmd.Flags().DurationVarP(&duration, "duration", "d", 10*time.Second, "How long to generate load")
+	cmd.Flags().IntVar(&size, "size", 1024, "Payload bytes per operation")
+	cmd.Flags().StringVar(&logLevel, "log-level", "error", "Agent log level")
+	cmd.Flags().BoolVar(&jsonOutput, "json", false, "Output results as JSON")
+
+	return cmd
+}
+
+func managementKeyCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "management-key",
+		Short: "Manage mesh topology encryption keys",
+		Long: `Manage X25519 keypairs for encrypting mesh topology data.
+
+When management key encryption is enabled, sensitive data like NodeInfo
+(hostnames, IPs, OS info) and route paths are encrypted before flooding
+through the mesh. Only operators with the private key can decrypt and
+view topology details.
+
+This provides cryptographic compartmentalization: if a field agent is
+compromised, the attacker only sees encrypted blobs, not the mesh topology.
+
+To retire an exposed private key, rotate to a new keypair:
+  1. Generate a keypair and add it to the operator config as
+     management.next_public_key and management.next_private_key
+  2. Stage the new public key on every agent:
+       muti-metroo management-key stage <new-public-key> --all
+  3. Wait until status shows every agent adopted it:
+       muti-metroo management-key status
+  4. Activate it everywhere, then make it management.public_key and
+     management.private_key in the operator config:
+       muti-metroo management-key activate --all`,
+	}
+
+	// Add subcommands
+	cmd.AddCommand(managementKeyGenerateCmd())
+	cmd.AddCommand(managementKeyPublicCmd())
+	cmd.AddCommand(managementKeyStatusCmd())
+	cmd.AddCommand(managementKeyChangeCmd("stage", "stage <public-key>",
+		"Encrypt node info to a next management key as well",
+		`Stage a next management public key. Until it is activated or aborted,
+agents encrypt their node info to both the current and the next key, so
+operators holding either private key can read it. The staged key is saved
+in the data directory and survives restarts.`,
+		cobra.ExactArgs(1)))
+	cmd.AddCommand(managementKeyChangeCmd("activate", "activate [public-key]",
+		"Make the staged key the management key",
+		`Make the staged next key the management key. Node info is then
+encrypted to the new key only, so the old private key no longer reads it.
+Pass the public key to make sure the expected key is activated.`,
+		cobra.MaximumNArgs(1)))
+	cmd.AddCommand(managementKeyChangeCmd("abort", "abort",
+		"Remove the staged next key",
+		`Remove the staged next key and encrypt node info to the current key only.`,
+		cobra.NoArgs))
+
+	return cmd
+}
 
 func managementKeyGenerateCmd() *cobra.Command {
 	cmd := &cobra.Command{
@@ -3132,6 +4573,255 @@ or to verify that your keypair is consistent.`,
 	return cmd
 }
 
+// managementKeyStatusCmd creates the management-key status subcommand.
+func managementKeyStatusCmd() *cobra.Command {
+	var (
+		agentAddr  string
+		targetID   string
+		jsonOutput bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "status",
+		Short: "Show management keys and which agents adopted the next key",
+		Long: `Show the management keys an agent encrypts node info to.
+
+On an operator node holding the management private keys, the status also
+reports, for every agent, which keys its last node info was encrypted to and
+whether it adopted the target key (the staged next key, or the current key
+when none is staged). Put management.next_private_key in the operator config
+so agents encrypting to the next key can be verified.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			result, err := managementKeyManage(agentAddr, targetID, managementKeyRequest{Action: "status"})
+			if err != nil {
+				return err
+			}
+
+			if jsonOutput {
+				out, _ := json.MarshalIndent(result, "", "  ")
+				fmt.Println(string(out))
+				return nil
+			}
+
+			fmt.Printf("Public key:      %s\n", result.PublicKey)
+			if result.NextPublicKey != "" {
+				fmt.Printf("Next public key: %s\n", result.NextPublicKey)
+			}
+			if !result.CanDecrypt {
+				fmt.Println("\nNo management private key on this agent, adoption cannot be checked")
+				return nil
+			}
+
+			fmt.Printf("\nAdopted %s...: %d of %d agents\n\n", result.TargetKey[:16], result.Adopted, result.Total)
+			fmt.Printf("%-12s %-24s %-8s %s\n", "AGENT", "NAME", "ADOPTED", "ENCRYPTED TO")
+			for _, ag := range result.Agents {
+				keys := make([]string, len(ag.SealedTo))
+				for i, k := range ag.SealedTo {
+					keys[i] = k[:16] + "..."
+				}
+				sealedTo := strings.Join(keys, ", ")
+				if sealedTo == "" {
+					sealedTo = "-"
+				}
+				adopted := "no"
+				if ag.Adopted {
+					adopted = "yes"
+				}
+				fmt.Printf("%-12s %-24s %-8s %s\n", ag.ShortID, ag.DisplayName, adopted, sealedTo)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&agentAddr, "agent", "a", "localhost:8080", "Agent API address (host:port)")
+	cmd.Flags().StringVarP(&targetID, "target", "t", "", "Target agent ID (omit for local agent)")
+	cmd.Flags().BoolVar(&jsonOutput, "json", false, "Output in JSON format")
+
+	return cmd
+}
+
+// managementKeyChangeCmd creates the management-key stage, activate and
+// abort subcommands.
+func managementKeyChangeCmd(action, use, short, long string, args cobra.PositionalArgs) *cobra.Command {
+	var (
+		agentAddr string
+		targetID  string
+		all       bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   use,
+		Short: short,
+		Long:  long,
+		Args:  args,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			req := managementKeyRequest{Action: action}
+			if len(args) > 0 {
+				req.PublicKey = args[0]
+			}
+
+			if !all {
+				result, err := managementKeyManage(agentAddr, targetID, req)
+				if err != nil {
+					return err
+				}
+				fmt.Println(result.Message)
+				return nil
+			}
+			if targetID != "" {
+				return fmt.Errorf("--all and --target are mutually exclusive")
+			}
+
+			agents, err := listMeshAgents(agentAddr)
+			if err != nil {
+				return err
+			}
+			failed := 0
+			for _, a := range agents {
+				target := a.ID
+				if a.Local {
+					target = ""
+				}
+				name := a.Short
+				if a.DisplayName != "" {
+					name += " (" + a.DisplayName + ")"
+				}
+				result, err := managementKeyManage(agentAddr, target, req)
+				if err != nil {
+					failed++
+					fmt.Printf("%-40s %v\n", name, err)
+					continue
+				}
+				fmt.Printf("%-40s %s\n", name, result.Message)
+			}
+			if failed > 0 {
+				return fmt.Errorf("%s failed on %d of %d agents", action, failed, len(agents))
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&agentAddr, "agent", "a", "localhost:8080", "Agent API address (host:port)")
+	cmd.Flags().StringVarP(&targetID, "target", "t", "", "Target agent ID (omit for local agent)")
+	cmd.Flags().BoolVar(&all, "all", false, "Send to every agent known to the API agent")
+
+	return cmd
+}
+
+// managementKeyRequest is the body of a management key request.
+type managementKeyRequest struct {
+	Action    string `json:"action"`
+	PublicKey string `json:"public_key,omitempty"`
+}
+
+// managementKeyAgent mirrors an agent entry returned by
+// /management-key/manage.
+type managementKeyAgent struct {
+	ID          string   `json:"id"`
+	ShortID     string   `json:"short_id"`
+	DisplayName string   `json:"display_name,omitempty"`
+	SealedTo    []string `json:"sealed_to"`
+	Adopted     bool     `json:"adopted"`
+}
+
+// managementKeyResult is the response of a management key request.
+type managementKeyResult struct {
+	Status        string               `json:"status"`
+	Message       string               `json:"message,omitempty"`
+	PublicKey     string               `json:"public_key"`
+	NextPublicKey string               `json:"next_public_key,omitempty"`
+	CanDecrypt    bool                 `json:"can_decrypt"`
+	TargetKey     string               `json:"target_key,omitempty"`
+	Adopted       int                  `json:"adopted"`
+	Total         int                  `json:"total"`
+	Agents        []managementKeyAgent `json:"agents,omitempty"`
+	Error         string               `json:"error,omitempty"`
+}
+
+// managementKeyManage sends a management key request to an agent.
+func managementKeyManage(agentAddr, targetID string, reqBody managementKeyRequest) (*managementKeyResult, error) {
+	body, _ := json.Marshal(reqBody)
+
+	url := fmt.Sprintf("http://%s/management-key/manage", agentAddr)
+	if targetID != "" {
+		resolvedID, err := resolveAgentID(targetID, agentAddr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve agent ID: %w", err)
+		}
+		url = fmt.Sprintf("http://%s/agents/%s/management-key/manage", agentAddr, resolvedID)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	setAuthToken(req)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to agent: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var result managementKeyResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("management-key %s failed: %s", reqBody.Action, resp.Status)
+		}
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		if result.Error != "" {
+			return nil, fmt.Errorf("management-key %s failed: %s", reqBody.Action, result.Error)
+		}
+		return nil, fmt.Errorf("management-key %s failed: %s", reqBody.Action, resp.Status)
+	}
+
+	return &result, nil
+}
+
+// meshAgent is an agent entry returned by /agents.
+type meshAgent struct {
+	ID          string `json:"id"`
+	Short       string `json:"short"`
+	DisplayName string `json:"display_name"`
+	Local       bool   `json:"local"`
+}
+
+// listMeshAgents returns the agents known to the API agent, itself first.
+func listMeshAgents(agentAddr string) ([]meshAgent, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("http://%s/agents", agentAddr), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	setAuthToken(req)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to agent: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to list agents: %s", resp.Status)
+	}
+
+	var agents []meshAgent
+	if err := json.NewDecoder(resp.Body).Decode(&agents); err != nil {
+		return nil, fmt.Errorf("failed to decode agent list: %w", err)
+	}
+	return agents, nil
+}
+
 func signingKeyCmd() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "signing-key",
@@ -3709,12 +5399,21 @@ Examples:
   muti-metroo route list
 
   # Remove a route
-  muti-metroo route remove 10.0.0.0/8`,
+  muti-metroo route remove 10.0.0.0/8
+
+  # Show route origins suppressed for flapping
+  muti-metroo route dampening
+
+  # Show the route and exit a connection to a destination would use
+  muti-metroo route lookup 10.1.2.3
+  muti-metroo route lookup db.internal.example --from abc123`,
 	}
 
 	cmd.AddCommand(routeAddCmd())
 	cmd.AddCommand(routeRemoveCmd())
 	cmd.AddCommand(routeListCmd())
+	cmd.AddCommand(routeDampeningCmd())
+	cmd.AddCommand(routeLookupCmd())
 
 	return cmd
 }
@@ -3961,97 +5660,44 @@ func routeListCmd() *cobra.Command {
 	return cmd
 }
 
-// routeManageURL builds the URL for route management based on target.
-func routeManageURL(agentAddr, targetID string) (string, error) {
-	if targetID == "" {
-		return fmt.Sprintf("http://%s/routes/manage", agentAddr), nil
-	}
-
-	resolvedID, err := resolveAgentID(targetID, agentAddr)
-	if err != nil {
-		return "", fmt.Errorf("failed to resolve agent ID: %w", err)
-	}
-
-	return fmt.Sprintf("http://%s/agents/%s/routes/manage", agentAddr, resolvedID), nil
-}
-
-// forwardCmd creates the forward parent command with add/remove/list subcommands.
-func forwardCmd() *cobra.Command {
-	cmd := &cobra.Command{
-		Use:   "forward",
-		Short: "Manage dynamic forward listeners",
-		Long: `Manage dynamic forward listeners at runtime.
-
-Dynamic forward listeners are ephemeral (lost on restart). They allow adding
-port forward ingress listeners on the fly without restarting agents.
-Config-file listeners are protected from modification but appear in the list.
-
-Examples:
-  # Add a forward listener on the local agent
-  muti-metroo forward add web-server :9090
-
-  # Add with connection limit
-  muti-metroo forward add web-server :9090 --max-connections 100
-
-  # Add on a remote agent
-  muti-metroo forward add web-server :9090 --target abc123
-
-  # List all forward listeners
-  muti-metroo forward list
-
-  # Remove a dynamic forward listener
-  muti-metroo forward remove web-server`,
-	}
-
-	cmd.AddCommand(forwardAddCmd())
-	cmd.AddCommand(forwardRemoveCmd())
-	cmd.AddCommand(forwardListCmd())
-
-	return cmd
-}
-
-// forwardAddCmd creates the forward add subcommand.
-func forwardAddCmd() *cobra.Command {
+// routeDampeningCmd creates the route dampening subcommand.
+func routeDampeningCmd() *cobra.Command {
 	var (
-		agentAddr      string
-		targetID       string
-		maxConnections int
+		agentAddr  string
+		targetID   string
+		jsonOutput bool
 	)
 
 	cmd := &cobra.Command{
-		Use:   "add <key> <address>",
-		Short: "Add a dynamic forward listener",
-		Args:  cobra.ExactArgs(2),
+		Use:   "dampening",
+		Short: "Show suppressed route origins and rate limited peers",
+		Long: `Show the route flap dampening and rate limit state of an agent.
+
+With routing.dampening enabled, every withdrawal and every change of an
+origin's route set adds 1 to the origin's penalty, which decays by half every
+half_life. Origins over max_flaps are suppressed: their advertisements are
+neither applied nor flooded until they are released at REUSE.
+
+With routing.peer_rate_limit enabled, route updates from a peer over the
+limit are dropped and counted.`,
+		Args: cobra.NoArgs,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			key := args[0]
-			address := args[1]
-
-			reqBody := struct {
-				Action         string `json:"action"`
-				Key            string `json:"key"`
-				Address        string `json:"address"`
-				MaxConnections int    `json:"max_connections"`
-			}{
-				Action:         "add",
-				Key:            key,
-				Address:        address,
-				MaxConnections: maxConnections,
-			}
-			body, _ := json.Marshal(reqBody)
-
-			url, err := forwardManageURL(agentAddr, targetID)
-			if err != nil {
-				return err
+			url := fmt.Sprintf("http://%s/routes/dampening", agentAddr)
+			if targetID != "" {
+				resolvedID, err := resolveAgentID(targetID, agentAddr)
+				if err != nil {
+					return fmt.Errorf("failed to resolve agent ID: %w", err)
+				}
+				url = fmt.Sprintf("http://%s/agents/%s/routes/dampening", agentAddr, resolvedID)
 			}
 
-			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			ctx, cancel := context.WithTimeout(context.Background(), 35*time.Second)
 			defer cancel()
 
-			req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 			if err != nil {
 				return fmt.Errorf("failed to create request: %w", err)
 			}
-			req.Header.Set("Content-Type", "application/json")
 			setAuthToken(req)
 
 			resp, err := http.DefaultClient.Do(req)
@@ -4060,10 +5706,401 @@ func forwardAddCmd() *cobra.Command {
 			}
 			defer resp.Body.Close()
 
-			var result struct {
-				Status  string `json:"status"`
-				Message string `json:"message"`
-				Error   string `json:"error,omitempty"`
+			if resp.StatusCode != http.StatusOK {
+				msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+				return fmt.Errorf("route dampening failed: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+			}
+
+			var result struct {
+				DampeningEnabled bool `json:"dampening_enabled"`
+				RateLimitEnabled bool `json:"rate_limit_enabled"`
+				Origins          []struct {
+					OriginID   string     `json:"origin_id"`
+					OriginName string     `json:"origin_name"`
+					Penalty    float64    `json:"penalty"`
+					Flaps      int        `json:"flaps"`
+					Suppressed bool       `json:"suppressed"`
+					ReuseAt    *time.Time `json:"reuse_at"`
+					Routes     []string   `json:"routes"`
+				} `json:"origins"`
+				RateLimitedPeers []struct {
+					PeerID      string    `json:"peer_id"`
+					PeerName    string    `json:"peer_name"`
+					Dropped     uint64    `json:"dropped"`
+					LastDropped time.Time `json:"last_dropped"`
+				} `json:"rate_limited_peers"`
+			}
+			if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+				return fmt.Errorf("failed to decode response: %w", err)
+			}
+
+			if jsonOutput {
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "  ")
+				return enc.Encode(result)
+			}
+
+			if !result.DampeningEnabled && !result.RateLimitEnabled {
+				fmt.Println("Route dampening and peer rate limits are not enabled on this agent")
+				return nil
+			}
+
+			agentName := func(id, name string) string {
+				if name != "" {
+					return name
+				}
+				if aid, err := identity.ParseAgentID(id); err == nil {
+					return aid.ShortString()
+				}
+				return id
+			}
+
+			if result.DampeningEnabled {
+				fmt.Printf("Dampened Origins (%d)\n", len(result.Origins))
+				if len(result.Origins) == 0 {
+					fmt.Println("  (none)")
+				} else {
+					fmt.Printf("  %-20s %-11s %-8s %-6s %-10s %s\n", "ORIGIN", "STATE", "PENALTY", "FLAPS", "REUSE", "ROUTES")
+					for _, o := range result.Origins {
+						state, reuse := "flapping", "-"
+						if o.Suppressed {
+							state = "suppressed"
+							if o.ReuseAt != nil {
+								reuse = time.Until(*o.ReuseAt).Round(time.Second).String()
+							}
+						}
+						fmt.Printf("  %-20s %-11s %-8.2f %-6d %-10s %s\n", agentName(o.OriginID, o.OriginName),
+							state, o.Penalty, o.Flaps, reuse, strings.Join(o.Routes, ", "))
+					}
+				}
+			}
+
+			if result.RateLimitEnabled {
+				if result.DampeningEnabled {
+					fmt.Println()
+				}
+				fmt.Printf("Rate Limited Peers (%d)\n", len(result.RateLimitedPeers))
+				if len(result.RateLimitedPeers) == 0 {
+					fmt.Println("  (none)")
+				} else {
+					fmt.Printf("  %-20s %-10s %s\n", "PEER", "DROPPED", "LAST DROPPED")
+					for _, p := range result.RateLimitedPeers {
+						fmt.Printf("  %-20s %-10d %s\n", agentName(p.PeerID, p.PeerName),
+							p.Dropped, p.LastDropped.Local().Format("2006-01-02 15:04:05"))
+					}
+				}
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&agentAddr, "agent", "a", "localhost:8080", "Agent API address (host:port)")
+	cmd.Flags().StringVarP(&targetID, "target", "t", "", "Target agent ID (omit for local agent)")
+	cmd.Flags().BoolVar(&jsonOutput, "json", false, "Output in JSON format")
+
+	return cmd
+}
+
+// routeLookupCmd creates the route lookup subcommand.
+func routeLookupCmd() *cobra.Command {
+	var (
+		agentAddr  string
+		fromID     string
+		user       string
+		jsonOutput bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "lookup <ip-or-domain>",
+		Short: "Show the route a connection to a destination would take",
+		Long: `Show which route a connection to a destination would use, without
+connecting: the matched domain pattern or network, the path to the exit,
+the alternate paths a failed stream open retries, and the policy decisions
+made on the way (namespace, SOCKS5 blocklist, remote_dns, exit.block_private,
+exit.user_policy, mesh-only dials).
+
+The lookup runs on the agent the connection would enter the mesh at: the
+local agent, or the agent given with --from, which is asked over the mesh.
+Names are resolved when a connection would resolve them at that agent.
+Exits apply their own exit policy when the stream arrives; that part is not
+evaluated.
+
+Examples:
+  muti-metroo route lookup 10.1.2.3
+  muti-metroo route lookup db.internal.example:5432 --from abc123
+  muti-metroo route lookup 10.1.2.3 --user alice --json`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			body, _ := json.Marshal(health.RouteLookupRequest{Destination: args[0], User: user})
+
+			url := fmt.Sprintf("http://%s/routes/lookup", agentAddr)
+			if fromID != "" {
+				resolvedID, err := resolveAgentID(fromID, agentAddr)
+				if err != nil {
+					return fmt.Errorf("failed to resolve agent ID: %w", err)
+				}
+				url = fmt.Sprintf("http://%s/agents/%s/routes/lookup", agentAddr, resolvedID)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 35*time.Second)
+			defer cancel()
+
+			req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+			if err != nil {
+				return fmt.Errorf("failed to create request: %w", err)
+			}
+			req.Header.Set("Content-Type", "application/json")
+			setAuthToken(req)
+
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				return fmt.Errorf("failed to connect to agent: %w", err)
+			}
+			defer resp.Body.Close()
+
+			data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+			if err != nil {
+				return fmt.Errorf("failed to read response: %w", err)
+			}
+			if resp.StatusCode != http.StatusOK {
+				var errResp struct {
+					Error string `json:"error"`
+				}
+				if json.Unmarshal(data, &errResp) == nil && errResp.Error != "" {
+					return fmt.Errorf("route lookup failed: %s", errResp.Error)
+				}
+				return fmt.Errorf("route lookup failed: %s: %s", resp.Status, strings.TrimSpace(string(data)))
+			}
+
+			var result health.RouteLookupResult
+			if err := json.Unmarshal(data, &result); err != nil {
+				return fmt.Errorf("failed to decode response: %w", err)
+			}
+
+			if jsonOutput {
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "  ")
+				return enc.Encode(result)
+			}
+
+			printRouteLookup(&result)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&agentAddr, "agent", "a", "localhost:8080", "Agent API address (host:port)")
+	cmd.Flags().StringVar(&fromID, "from", "", "Agent ID to look up from (omit for local agent)")
+	cmd.Flags().StringVar(&user, "user", "", "SOCKS5 user to look up for (selects namespace and exit user policy)")
+	cmd.Flags().BoolVar(&jsonOutput, "json", false, "Output in JSON format")
+
+	return cmd
+}
+
+// printRouteLookup prints a route lookup result.
+func printRouteLookup(r *health.RouteLookupResult) {
+	fmt.Printf("Destination: %s\n", r.Destination)
+	fmt.Printf("From:        %s\n", routeWatchAgentName(r.Agent))
+	if r.Namespace != "" {
+		fmt.Printf("Namespace:   %s\n", r.Namespace)
+	}
+	if r.ResolvedIP != "" {
+		fmt.Printf("Resolved:    %s\n", r.ResolvedIP)
+	}
+	match := r.Match
+	if r.Route != "" {
+		match += " " + r.Route
+	}
+	fmt.Printf("Match:       %s\n", match)
+	fmt.Printf("Action:      %s\n", r.Action)
+	if r.Reason != "" {
+		fmt.Printf("Reason:      %s\n", r.Reason)
+	}
+
+	if r.Selected != nil {
+		fmt.Printf("Exit:        %s\n", routeWatchAgentName(r.Selected.Exit))
+		fmt.Printf("Path:        %s\n", formatLookupPath(r.Agent, r.Selected))
+	}
+	if len(r.Alternates) > 0 {
+		fmt.Println()
+		fmt.Printf("Alternates (%d)\n", len(r.Alternates))
+		for i := range r.Alternates {
+			fmt.Printf("  %s\n", formatLookupPath(r.Agent, &r.Alternates[i]))
+		}
+	}
+
+	fmt.Println()
+	fmt.Println("Decisions")
+	for i, d := range r.Decisions {
+		fmt.Printf("  %d. %s\n", i+1, d)
+	}
+}
+
+// formatLookupPath formats a route lookup path as the agents it crosses.
+func formatLookupPath(from health.RouteExportAgent, p *health.RouteLookupPath) string {
+	hops := []string{routeWatchAgentName(from)}
+	if len(p.Path) > 0 {
+		for _, a := range p.Path {
+			hops = append(hops, routeWatchAgentName(a))
+		}
+	} else {
+		// Encrypted paths only reveal the next hop and the exit
+		hops = append(hops, routeWatchAgentName(p.NextHop))
+		if p.Exit.ID != p.NextHop.ID {
+			hops = append(hops, "...", routeWatchAgentName(p.Exit))
+		}
+	}
+	s := strings.Join(hops, " -> ") + fmt.Sprintf(" (metric %d)", p.Metric)
+	if !p.Connected {
+		s += " [next hop not connected]"
+	}
+	if p.Unreachable {
+		s += " [unreachable]"
+	}
+	return s
+}
+
+// routeManageURL builds the URL for route management based on target.
+func routeManageURL(agentAddr, targetID string) (string, error) {
+	if targetID == "" {
+		return fmt.Sprintf("http://%s/routes/manage", agentAddr), nil
+	}
+
+	resolvedID, err := resolveAgentID(targetID, agentAddr)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve agent ID: %w", err)
+	}
+
+	return fmt.Sprintf("http://%s/agents/%s/routes/manage", agentAddr, resolvedID), nil
+}
+
+// forwardCmd creates the forward parent command with add/remove/list subcommands.
+func forwardCmd() *cobra.Command {
+	var (
+		agentAddr   string
+		localSpecs  []string
+		remoteSpecs []string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "forward",
+		Short: "Manage dynamic forward listeners and ad-hoc tunnels",
+		Long: `Manage dynamic forward listeners at runtime, or open ad-hoc tunnels.
+
+Dynamic forward listeners are ephemeral (lost on restart). They allow adding
+port forward ingress listeners on the fly without restarting agents.
+Config-file listeners are protected from modification but appear in the list.
+
+With -L or -R, the command creates a temporary forward listener and endpoint
+pair under a random routing key, keeps them open until interrupted, and
+removes both on exit. The agent given by --agent is the local side.
+
+  -L [bind:]port:agent:[host:]hostport
+      Listen on the local agent, connect to host:hostport from the remote agent.
+  -R [bind:]port:agent:[host:]hostport
+      Listen on the remote agent, connect to host:hostport from the local agent.
+
+The host defaults to localhost. With four fields, a leading port number means
+port:agent:host:hostport; otherwise the first field is the bind address.
+Write IPv6 addresses in square brackets, e.g. [::1]:8080:abc123:[::1]:80.
+
+Examples:
+  # Local port 8080 to port 80 on the remote agent
+  muti-metroo forward -L 8080:abc123:80
+
+  # Local port 5432 to a database reachable from the remote agent
+  muti-metroo forward -L 127.0.0.1:5432:abc123:db.internal:5432
+
+  # Port 9090 on the remote agent to local port 3000
+  muti-metroo forward -R 9090:abc123:3000
+
+  # Add a forward listener on the local agent
+  muti-metroo forward add web-server :9090
+
+  # Add with connection limit
+  muti-metroo forward add web-server :9090 --max-connections 100
+
+  # Add on a remote agent
+  muti-metroo forward add web-server :9090 --target abc123
+
+  # List all forward listeners
+  muti-metroo forward list
+
+  # Remove a dynamic forward listener
+  muti-metroo forward remove web-server`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(localSpecs) == 0 && len(remoteSpecs) == 0 {
+				return cmd.Help()
+			}
+			return runAdHocTunnels(agentAddr, localSpecs, remoteSpecs)
+		},
+	}
+
+	cmd.Flags().StringVarP(&agentAddr, "agent", "a", "localhost:8080", "Agent API address (host:port)")
+	cmd.Flags().StringArrayVarP(&localSpecs, "local", "L", nil, "Local tunnel [bind:]port:agent:[host:]hostport (repeatable)")
+	cmd.Flags().StringArrayVarP(&remoteSpecs, "remote", "R", nil, "Remote tunnel [bind:]port:agent:[host:]hostport (repeatable)")
+
+	cmd.AddCommand(forwardAddCmd())
+	cmd.AddCommand(forwardRemoveCmd())
+	cmd.AddCommand(forwardListCmd())
+
+	return cmd
+}
+
+// forwardAddCmd creates the forward add subcommand.
+func forwardAddCmd() *cobra.Command {
+	var (
+		agentAddr      string
+		targetID       string
+		maxConnections int
+	)
+
+	cmd := &cobra.Command{
+		Use:   "add <key> <address>",
+		Short: "Add a dynamic forward listener",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			key := args[0]
+			address := args[1]
+
+			reqBody := struct {
+				Action         string `json:"action"`
+				Key            string `json:"key"`
+				Address        string `json:"address"`
+				MaxConnections int    `json:"max_connections"`
+			}{
+				Action:         "add",
+				Key:            key,
+				Address:        address,
+				MaxConnections: maxConnections,
+			}
+			body, _ := json.Marshal(reqBody)
+
+			url, err := forwardManageURL(agentAddr, targetID)
+			if err != nil {
+				return err
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+			if err != nil {
+				return fmt.Errorf("failed to create request: %w", err)
+			}
+			req.Header.Set("Content-Type", "application/json")
+			setAuthToken(req)
+
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				return fmt.Errorf("failed to connect to agent: %w", err)
+			}
+			defer resp.Body.Close()
+
+			var result struct {
+				Status  string `json:"status"`
+				Message string `json:"message"`
+				Error   string `json:"error,omitempty"`
 			}
 			if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
 				return fmt.Errorf("failed to decode response: %w", err)
@@ -4270,23 +6307,272 @@ func forwardManageURL(agentAddr, targetID string) (string, error) {
 	return fmt.Sprintf("http://%s/agents/%s/forward/manage", agentAddr, resolvedID), nil
 }
 
-// displayNameCmd creates the display-name parent command with set/get subcommands.
-func displayNameCmd() *cobra.Command {
-	cmd := &cobra.Command{
-		Use:   "display-name",
-		Short: "Manage agent display name",
-		Long: `Manage the agent display name at runtime.
-
-Dynamic display names are ephemeral (lost on restart). They allow renaming
-agents on the fly to label topology maps without touching config files.
-Setting an empty name reverts to the config value.
+// tunnelSpec is a parsed -L/-R ad-hoc tunnel specification.
+type tunnelSpec struct {
+	spec       string
+	remote     bool   // true for -R (listener on the remote agent)
+	listenAddr string // [bind]:port for the listener
+	agent      string // remote agent ID or prefix
+	target     string // host:port dialed by the endpoint
+}
 
-Examples:
-  # Set display name on the local agent
-  muti-metroo display-name set "gateway-us-east"
+// parseTunnelSpec parses [bind:]port:agent:[host:]hostport.
+func parseTunnelSpec(spec string, remote bool) (*tunnelSpec, error) {
+	parts := splitTunnelSpec(spec)
+	var bind, port, agentID, host, hostPort string
+
+	switch len(parts) {
+	case 3:
+		port, agentID, hostPort = parts[0], parts[1], parts[2]
+	case 4:
+		if isPortNumber(parts[0]) {
+			port, agentID, host, hostPort = parts[0], parts[1], parts[2], parts[3]
+		} else {
+			bind, port, agentID, hostPort = parts[0], parts[1], parts[2], parts[3]
+		}
+	case 5:
+		bind, port, agentID, host, hostPort = parts[0], parts[1], parts[2], parts[3], parts[4]
+	default:
+		return nil, fmt.Errorf("invalid tunnel %q: expected [bind:]port:agent:[host:]hostport", spec)
+	}
 
-  # Set display name on a remote agent
-  muti-metroo display-name set "exit-eu-west" --target abc123
+	if !isPortNumber(port) {
+		return nil, fmt.Errorf("invalid tunnel %q: bad listen port %q", spec, port)
+	}
+	if !isPortNumber(hostPort) {
+		return nil, fmt.Errorf("invalid tunnel %q: bad target port %q", spec, hostPort)
+	}
+	if agentID == "" {
+		return nil, fmt.Errorf("invalid tunnel %q: agent ID is required", spec)
+	}
+	if host == "" {
+		host = "localhost"
+	}
+
+	return &tunnelSpec{
+		spec:       spec,
+		remote:     remote,
+		listenAddr: net.JoinHostPort(bind, port),
+		agent:      agentID,
+		target:     net.JoinHostPort(host, hostPort),
+	}, nil
+}
+
+// splitTunnelSpec splits a tunnel spec at colons outside square brackets, so
+// IPv6 addresses can be given as "[::1]". Brackets around a part are removed;
+// net.JoinHostPort adds them back.
+func splitTunnelSpec(spec string) []string {
+	var parts []string
+	depth, start := 0, 0
+	for i, c := range spec {
+		switch c {
+		case '[':
+			depth++
+		case ']':
+			depth--
+		case ':':
+			if depth == 0 {
+				parts = append(parts, spec[start:i])
+				start = i + 1
+			}
+		}
+	}
+	parts = append(parts, spec[start:])
+	for i, p := range parts {
+		if len(p) > 1 && p[0] == '[' && p[len(p)-1] == ']' {
+			parts[i] = p[1 : len(p)-1]
+		}
+	}
+	return parts
+}
+
+// isPortNumber reports whether s is a valid TCP port number.
+func isPortNumber(s string) bool {
+	n, err := strconv.Atoi(s)
+	return err == nil && n > 0 && n <= 65535
+}
+
+// adHocTunnel tracks the listener and endpoint created for one tunnel so
+// they can be removed on exit.
+type adHocTunnel struct {
+	spec        *tunnelSpec
+	key         string
+	listenerURL string
+	endpointURL string
+	listenerUp  bool
+	endpointUp  bool
+}
+
+// runAdHocTunnels creates the requested tunnels, waits for an interrupt,
+// and removes everything it created.
+func runAdHocTunnels(agentAddr string, localSpecs, remoteSpecs []string) error {
+	var specs []*tunnelSpec
+	for _, s := range localSpecs {
+		spec, err := parseTunnelSpec(s, false)
+		if err != nil {
+			return err
+		}
+		specs = append(specs, spec)
+	}
+	for _, s := range remoteSpecs {
+		spec, err := parseTunnelSpec(s, true)
+		if err != nil {
+			return err
+		}
+		specs = append(specs, spec)
+	}
+
+	var tunnels []*adHocTunnel
+	teardown := func() {
+		for _, t := range tunnels {
+			t.close()
+		}
+	}
+
+	for _, spec := range specs {
+		t, err := openAdHocTunnel(agentAddr, spec)
+		if t != nil {
+			tunnels = append(tunnels, t)
+		}
+		if err != nil {
+			teardown()
+			return err
+		}
+		direction := "-L"
+		if spec.remote {
+			direction = "-R"
+		}
+		fmt.Printf("%s %s: listening on %s, forwarding to %s (key %s)\n",
+			direction, spec.spec, spec.listenAddr, spec.target, t.key)
+	}
+
+	fmt.Println("Tunnels open. Press Ctrl+C to close.")
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	<-sigCh
+	signal.Stop(sigCh)
+
+	fmt.Println("\nClosing tunnels...")
+	teardown()
+	return nil
+}
+
+// openAdHocTunnel creates the endpoint and then the listener for a tunnel.
+// The returned tunnel is non-nil whenever something was created, so the
+// caller can clean up after a partial failure.
+func openAdHocTunnel(agentAddr string, spec *tunnelSpec) (*adHocTunnel, error) {
+	keyBytes := make([]byte, 4)
+	if err := crypto.RandomBytes(keyBytes); err != nil {
+		return nil, fmt.Errorf("failed to generate routing key: %w", err)
+	}
+	t := &adHocTunnel{
+		spec: spec,
+		key:  "adhoc-" + hex.EncodeToString(keyBytes),
+	}
+
+	remoteID, err := resolveAgentID(spec.agent, agentAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve agent ID: %w", err)
+	}
+	remoteBase := fmt.Sprintf("http://%s/agents/%s", agentAddr, remoteID)
+	localBase := fmt.Sprintf("http://%s", agentAddr)
+
+	endpointBase, listenerBase := remoteBase, localBase
+	if spec.remote {
+		endpointBase, listenerBase = localBase, remoteBase
+	}
+	t.endpointURL = endpointBase + "/forward/endpoint/manage"
+	t.listenerURL = listenerBase + "/forward/manage"
+
+	if err := postForwardManage(t.endpointURL, map[string]any{
+		"action": "add",
+		"key":    t.key,
+		"target": spec.target,
+	}); err != nil {
+		return nil, fmt.Errorf("tunnel %s: endpoint add failed: %w", spec.spec, err)
+	}
+	t.endpointUp = true
+
+	if err := postForwardManage(t.listenerURL, map[string]any{
+		"action":  "add",
+		"key":     t.key,
+		"address": spec.listenAddr,
+	}); err != nil {
+		return t, fmt.Errorf("tunnel %s: listener add failed: %w", spec.spec, err)
+	}
+	t.listenerUp = true
+
+	return t, nil
+}
+
+// close removes the tunnel's listener and endpoint, reporting failures.
+func (t *adHocTunnel) close() {
+	remove := map[string]any{"action": "remove", "key": t.key}
+	if t.listenerUp {
+		if err := postForwardManage(t.listenerURL, remove); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to remove listener %s: %v\n", t.key, err)
+		}
+		t.listenerUp = false
+	}
+	if t.endpointUp {
+		if err := postForwardManage(t.endpointURL, remove); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to remove endpoint %s: %v\n", t.key, err)
+		}
+		t.endpointUp = false
+	}
+}
+
+// postForwardManage sends a forward management request and returns the
+// error reported by the agent, if any.
+func postForwardManage(url string, reqBody any) error {
+	body, _ := json.Marshal(reqBody)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	setAuthToken(req)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to connect to agent: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var result struct {
+			Error string `json:"error"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&result) == nil && result.Error != "" {
+			return errors.New(result.Error)
+		}
+		return errors.New(resp.Status)
+	}
+	return nil
+}
+
+// displayNameCmd creates the display-name parent command with set/get subcommands.
+func displayNameCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "display-name",
+		Short: "Manage agent display name",
+		Long: `Manage the agent display name at runtime.
+
+Dynamic display names are ephemeral (lost on restart). They allow renaming
+agents on the fly to label topology maps without touching config files.
+Setting an empty name reverts to the config value.
+
+Examples:
+  # Set display name on the local agent
+  muti-metroo display-name set "gateway-us-east"
+
+  # Set display name on a remote agent
+  muti-metroo display-name set "exit-eu-west" --target abc123
 
   # Get current display name
   muti-metroo display-name get
@@ -4452,3 +6738,2468 @@ func displayNameManageURL(agentAddr, targetID string) (string, error) {
 
 	return fmt.Sprintf("http://%s/agents/%s/display-name/manage", agentAddr, resolvedID), nil
 }
+
+// dnsCacheCmd creates the dns-cache command for exit DNS cache inspection.
+func dnsCacheCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "dns-cache",
+		Short: "Inspect and flush the exit DNS cache",
+		Long: `Inspect and flush the DNS cache of an exit agent.
+
+Exit agents cache resolved domains for their record TTL (clamped to the
+configured bounds). Use flush after a DNS change to force fresh lookups.
+
+Examples:
+  # Show cache statistics on the local agent
+  muti-metroo dns-cache stats
+
+  # Show cache statistics on a remote exit
+  muti-metroo dns-cache stats --target abc123
+
+  # Flush one domain
+  muti-metroo dns-cache flush example.com
+
+  # Flush the whole cache
+  muti-metroo dns-cache flush`,
+	}
+
+	cmd.AddCommand(dnsCacheStatsCmd())
+	cmd.AddCommand(dnsCacheFlushCmd())
+
+	return cmd
+}
+
+// dnsCacheStatsCmd creates the dns-cache stats subcommand.
+func dnsCacheStatsCmd() *cobra.Command {
+	var (
+		agentAddr  string
+		targetID   string
+		jsonOutput bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "stats",
+		Short: "Show exit DNS cache statistics",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			result, err := dnsCacheManage(agentAddr, targetID, "stats", "")
+			if err != nil {
+				return err
+			}
+			if result.Stats == nil {
+				return fmt.Errorf("dns-cache stats failed: empty response")
+			}
+
+			if jsonOutput {
+				out, _ := json.MarshalIndent(result.Stats, "", "  ")
+				fmt.Println(string(out))
+				return nil
+			}
+
+			st := result.Stats
+			enabled := "yes"
+			if !st.Enabled {
+				enabled = "no"
+			}
+			hitRate := 0.0
+			if lookups := st.Hits + st.NegativeHits + st.Misses; lookups > 0 {
+				hitRate = float64(st.Hits+st.NegativeHits) / float64(lookups) * 100
+			}
+			fmt.Printf("Enabled:          %s\n", enabled)
+			fmt.Printf("Entries:          %d (%d negative)\n", st.Entries, st.NegativeEntries)
+			fmt.Printf("Hits:             %d (%d negative)\n", st.Hits, st.NegativeHits)
+			fmt.Printf("Misses:           %d\n", st.Misses)
+			fmt.Printf("Hit rate:         %.1f%%\n", hitRate)
+			fmt.Printf("Evictions:        %d\n", st.Evictions)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&agentAddr, "agent", "a", "localhost:8080", "Agent API address (host:port)")
+	cmd.Flags().StringVarP(&targetID, "target", "t", "", "Target agent ID (omit for local agent)")
+	cmd.Flags().BoolVar(&jsonOutput, "json", false, "Output in JSON format")
+
+	return cmd
+}
+
+// dnsCacheFlushCmd creates the dns-cache flush subcommand.
+func dnsCacheFlushCmd() *cobra.Command {
+	var (
+		agentAddr string
+		targetID  string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "flush [domain]",
+		Short: "Flush the exit DNS cache (all entries or one domain)",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var domain string
+			if len(args) == 1 {
+				domain = args[0]
+			}
+
+			result, err := dnsCacheManage(agentAddr, targetID, "flush", domain)
+			if err != nil {
+				return err
+			}
+
+			fmt.Println(result.Message)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&agentAddr, "agent", "a", "localhost:8080", "Agent API address (host:port)")
+	cmd.Flags().StringVarP(&targetID, "target", "t", "", "Target agent ID (omit for local agent)")
+
+	return cmd
+}
+
+// dnsCacheStats mirrors the stats object returned by /dns-cache/manage.
+type dnsCacheStats struct {
+	Enabled         bool   `json:"enabled"`
+	Entries         int    `json:"entries"`
+	NegativeEntries int    `json:"negative_entries"`
+	Hits            uint64 `json:"hits"`
+	NegativeHits    uint64 `json:"negative_hits"`
+	Misses          uint64 `json:"misses"`
+	Evictions       uint64 `json:"evictions"`
+}
+
+// dnsCacheResult is the response of a DNS cache management request.
+type dnsCacheResult struct {
+	Status  string         `json:"status"`
+	Message string         `json:"message"`
+	Flushed int            `json:"flushed"`
+	Stats   *dnsCacheStats `json:"stats"`
+	Error   string         `json:"error,omitempty"`
+}
+
+// dnsCacheManage sends a DNS cache management request to an agent.
+func dnsCacheManage(agentAddr, targetID, action, domain string) (*dnsCacheResult, error) {
+	body, _ := json.Marshal(struct {
+		Action string `json:"action"`
+		Domain string `json:"domain,omitempty"`
+	}{
+		Action: action,
+		Domain: domain,
+	})
+
+	url := fmt.Sprintf("http://%s/dns-cache/manage", agentAddr)
+	if targetID != "" {
+		resolvedID, err := resolveAgentID(targetID, agentAddr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve agent ID: %w", err)
+		}
+		url = fmt.Sprintf("http://%s/agents/%s/dns-cache/manage", agentAddr, resolvedID)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	setAuthToken(req)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to agent: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var result dnsCacheResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		if result.Error != "" {
+			return nil, fmt.Errorf("dns-cache %s failed: %s", action, result.Error)
+		}
+		return nil, fmt.Errorf("dns-cache %s failed: %s", action, resp.Status)
+	}
+
+	return &result, nil
+}
+
+// exitDestinationsCmd creates the exit-destinations command for
+// per-destination accounting on exit agents.
+func exitDestinationsCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "exit-destinations",
+		Short: "Show busiest exit destinations and lift blocks",
+		Long: `Inspect per-destination accounting on an exit agent.
+
+When exit.destination_stats is enabled, the exit aggregates connections and
+bytes per destination (domain or IP) over a sliding window. Destinations
+exceeding the configured thresholds are logged and, with action "block",
+refused until the block expires or is lifted.
+
+Examples:
+  # Top 20 destinations by bytes on the local agent
+  muti-metroo exit-destinations top
+
+  # Top 5 destinations by connection count on a remote exit
+  muti-metroo exit-destinations top -n 5 --sort connections --target abc123
+
+  # Lift a block before it expires
+  muti-metroo exit-destinations unblock example.com`,
+	}
+
+	cmd.AddCommand(exitDestinationsTopCmd())
+	cmd.AddCommand(exitDestinationsUnblockCmd())
+
+	return cmd
+}
+
+// exitDestinationsTopCmd creates the exit-destinations top subcommand.
+func exitDestinationsTopCmd() *cobra.Command {
+	var (
+		agentAddr  string
+		targetID   string
+		limit      int
+		sortBy     string
+		jsonOutput bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "top",
+		Short: "List the busiest exit destinations in the current window",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			result, err := exitDestManage(agentAddr, targetID, exitDestRequest{
+				Action: "top",
+				Sort:   sortBy,
+				Limit:  limit,
+			})
+			if err != nil {
+				return err
+			}
+
+			if jsonOutput {
+				out, _ := json.MarshalIndent(result, "", "  ")
+				fmt.Println(string(out))
+				return nil
+			}
+
+			if len(result.Destinations) == 0 {
+				fmt.Printf("No exit traffic in the last %s\n", result.Window)
+				return nil
+			}
+
+			fmt.Printf("Window: %s\n\n", result.Window)
+			fmt.Printf("%-40s %8s %10s %10s %6s  %s\n", "DESTINATION", "CONNS", "OUT", "IN", "ACTIVE", "STATUS")
+			for _, d := range result.Destinations {
+				status := "-"
+				if d.Blocked && d.BlockedUntil != nil {
+					status = "blocked until " + d.BlockedUntil.Local().Format("15:04:05")
+				}
+				fmt.Printf("%-40s %8d %10s %10s %6d  %s\n",
+					d.Destination, d.Connections,
+					humanize.Bytes(d.BytesOut), humanize.Bytes(d.BytesIn),
+					d.Active, status)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&agentAddr, "agent", "a", "localhost:8080", "Agent API address (host:port)")
+	cmd.Flags().StringVarP(&targetID, "target", "t", "", "Target agent ID (omit for local agent)")
+	cmd.Flags().IntVarP(&limit, "limit", "n", 20, "Number of destinations to show")
+	cmd.Flags().StringVar(&sortBy, "sort", "bytes", "Sort order: bytes or connections")
+	cmd.Flags().BoolVar(&jsonOutput, "json", false, "Output in JSON format")
+
+	return cmd
+}
+
+// exitDestinationsUnblockCmd creates the exit-destinations unblock subcommand.
+func exitDestinationsUnblockCmd() *cobra.Command {
+	var (
+		agentAddr string
+		targetID  string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "unblock <destination>",
+		Short: "Lift a threshold block on an exit destination",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			result, err := exitDestManage(agentAddr, targetID, exitDestRequest{
+				Action:      "unblock",
+				Destination: args[0],
+			})
+			if err != nil {
+				return err
+			}
+
+			fmt.Println(result.Message)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&agentAddr, "agent", "a", "localhost:8080", "Agent API address (host:port)")
+	cmd.Flags().StringVarP(&targetID, "target", "t", "", "Target agent ID (omit for local agent)")
+
+	return cmd
+}
+
+// exitDestRequest is the body of an exit destination management request.
+type exitDestRequest struct {
+	Action      string `json:"action"`
+	Sort        string `json:"sort,omitempty"`
+	Destination string `json:"destination,omitempty"`
+	Limit       int    `json:"limit,omitempty"`
+}
+
+// exitDestStat mirrors a destination entry returned by /exit-destinations/manage.
+type exitDestStat struct {
+	Destination  string     `json:"destination"`
+	Connections  uint64     `json:"connections"`
+	BytesOut     uint64     `json:"bytes_out"`
+	BytesIn      uint64     `json:"bytes_in"`
+	Active       int        `json:"active"`
+	Blocked      bool       `json:"blocked"`
+	BlockedUntil *time.Time `json:"blocked_until,omitempty"`
+	LastSeen     time.Time  `json:"last_seen"`
+}
+
+// exitDestResult is the response of an exit destination management request.
+type exitDestResult struct {
+	Status       string         `json:"status"`
+	Message      string         `json:"message,omitempty"`
+	Window       string         `json:"window,omitempty"`
+	Destinations []exitDestStat `json:"destinations,omitempty"`
+	Error        string         `json:"error,omitempty"`
+}
+
+// exitDestManage sends an exit destination management request to an agent.
+func exitDestManage(agentAddr, targetID string, reqBody exitDestRequest) (*exitDestResult, error) {
+	body, _ := json.Marshal(reqBody)
+
+	url := fmt.Sprintf("http://%s/exit-destinations/manage", agentAddr)
+	if targetID != "" {
+		resolvedID, err := resolveAgentID(targetID, agentAddr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve agent ID: %w", err)
+		}
+		url = fmt.Sprintf("http://%s/agents/%s/exit-destinations/manage", agentAddr, resolvedID)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	setAuthToken(req)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to agent: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var result exitDestResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		if result.Error != "" {
+			return nil, fmt.Errorf("exit-destinations %s failed: %s", reqBody.Action, result.Error)
+		}
+		return nil, fmt.Errorf("exit-destinations %s failed: %s", reqBody.Action, resp.Status)
+	}
+
+	return &result, nil
+}
+
+// socks5UsersCmd creates the socks5-users command for SOCKS5 user expiry
+// and quota usage.
+func socks5UsersCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "socks5-users",
+		Short: "Show SOCKS5 user quota usage and reset it",
+		Long: `Inspect and reset per-user SOCKS5 usage.
+
+With socks5.auth enabled, the agent counts logins and CONNECT bytes per user
+and rejects users past their expires_at, quota_bytes or quota_connections.
+Usage is kept until it is reset and survives restarts.
+
+Examples:
+  # Usage of all users on the local agent
+  muti-metroo socks5-users list
+
+  # Give one user a fresh quota on a remote agent
+  muti-metroo socks5-users reset alice --target abc123
+
+  # Reset every user
+  muti-metroo socks5-users reset --all`,
+	}
+
+	cmd.AddCommand(socks5UsersListCmd())
+	cmd.AddCommand(socks5UsersResetCmd())
+
+	return cmd
+}
+
+// socks5UsersListCmd creates the socks5-users list subcommand.
+func socks5UsersListCmd() *cobra.Command {
+	var (
+		agentAddr  string
+		targetID   string
+		jsonOutput bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List SOCKS5 users with their limits and usage",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			result, err := socks5UsersManage(agentAddr, targetID, "list", "")
+			if err != nil {
+				return err
+			}
+
+			if jsonOutput {
+				out, _ := json.MarshalIndent(result.Users, "", "  ")
+				fmt.Println(string(out))
+				return nil
+			}
+
+			if len(result.Users) == 0 {
+				fmt.Println("No SOCKS5 users with limits or usage")
+				return nil
+			}
+
+			fmt.Printf("%-24s %-20s %-21s %-17s %s\n", "USER", "STATE", "BYTES", "CONNECTIONS", "EXPIRES")
+			for _, u := range result.Users {
+				bytesCol := humanize.Bytes(u.Bytes)
+				if u.QuotaBytes > 0 {
+					bytesCol += " / " + humanize.Bytes(u.QuotaBytes)
+				}
+				connsCol := fmt.Sprintf("%d", u.Connections)
+				if u.QuotaConnections > 0 {
+					connsCol += fmt.Sprintf(" / %d", u.QuotaConnections)
+				}
+				expires := "-"
+				if u.ExpiresAt != nil {
+					expires = u.ExpiresAt.Local().Format("2006-01-02 15:04")
+				}
+				fmt.Printf("%-24s %-20s %-21s %-17s %s\n", u.Username, u.State, bytesCol, connsCol, expires)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&agentAddr, "agent", "a", "localhost:8080", "Agent API address (host:port)")
+	cmd.Flags().StringVarP(&targetID, "target", "t", "", "Target agent ID (omit for local agent)")
+	cmd.Flags().BoolVar(&jsonOutput, "json", false, "Output in JSON format")
+
+	return cmd
+}
+
+// socks5UsersResetCmd creates the socks5-users reset subcommand.
+func socks5UsersResetCmd() *cobra.Command {
+	var (
+		agentAddr string
+		targetID  string
+		all       bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "reset [username]",
+		Short: "Reset the byte and connection usage of a user (or --all)",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 && !all {
+				return fmt.Errorf("specify a username or --all")
+			}
+			if len(args) == 1 && all {
+				return fmt.Errorf("specify either a username or --all, not both")
+			}
+			var username string
+			if len(args) == 1 {
+				username = args[0]
+			}
+
+			result, err := socks5UsersManage(agentAddr, targetID, "reset", username)
+			if err != nil {
+				return err
+			}
+
+			fmt.Println(result.Message)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&agentAddr, "agent", "a", "localhost:8080", "Agent API address (host:port)")
+	cmd.Flags().StringVarP(&targetID, "target", "t", "", "Target agent ID (omit for local agent)")
+	cmd.Flags().BoolVar(&all, "all", false, "Reset all users")
+
+	return cmd
+}
+
+// socks5UserStatus mirrors a user entry returned by /socks5-users/manage.
+type socks5UserStatus struct {
+	Username         string     `json:"username"`
+	ExpiresAt        *time.Time `json:"expires_at,omitempty"`
+	QuotaBytes       uint64     `json:"quota_bytes,omitempty"`
+	QuotaConnections uint64     `json:"quota_connections,omitempty"`
+	Bytes            uint64     `json:"bytes"`
+	Connections      uint64     `json:"connections"`
+	LastSeen         time.Time  `json:"last_seen,omitempty"`
+	ResetAt          time.Time  `json:"reset_at,omitempty"`
+	State            string     `json:"state"`
+}
+
+// socks5UsersResult is the response of a SOCKS5 user management request.
+type socks5UsersResult struct {
+	Status  string             `json:"status"`
+	Message string             `json:"message,omitempty"`
+	Users   []socks5UserStatus `json:"users,omitempty"`
+	Reset   int                `json:"reset,omitempty"`
+	Error   string             `json:"error,omitempty"`
+}
+
+// socks5UsersManage sends a SOCKS5 user management request to an agent.
+func socks5UsersManage(agentAddr, targetID, action, username string) (*socks5UsersResult, error) {
+	body, _ := json.Marshal(struct {
+		Action   string `json:"action"`
+		Username string `json:"username,omitempty"`
+	}{
+		Action:   action,
+		Username: username,
+	})
+
+	url := fmt.Sprintf("http://%s/socks5-users/manage", agentAddr)
+	if targetID != "" {
+		resolvedID, err := resolveAgentID(targetID, agentAddr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve agent ID: %w", err)
+		}
+		url = fmt.Sprintf("http://%s/agents/%s/socks5-users/manage", agentAddr, resolvedID)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	setAuthToken(req)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to agent: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var result socks5UsersResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		if result.Error != "" {
+			return nil, fmt.Errorf("socks5-users %s failed: %s", action, result.Error)
+		}
+		return nil, fmt.Errorf("socks5-users %s failed: %s", action, resp.Status)
+	}
+
+	return &result, nil
+}
+
+// blocklistCmd creates the blocklist command for the SOCKS5 destination
+// blocklist.
+func blocklistCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "blocklist",
+		Short: "Inspect, test and refresh the SOCKS5 destination blocklist",
+		Long: `Inspect the SOCKS5 destination blocklist of an agent.
+
+With socks5.blocklist enabled, CONNECT requests to listed domains and
+addresses are rejected by the ingress agent before any mesh traffic is
+generated. Lists come from the config, local files and HTTPS feeds that
+are reloaded every refresh_interval.
+
+Examples:
+  # Entries, sources and blocked attempts on the local agent
+  muti-metroo blocklist status
+
+  # Would a destination be blocked on a remote agent?
+  muti-metroo blocklist check ads.example.com --target abc123
+
+  # Reload files and feeds now
+  muti-metroo blocklist refresh`,
+	}
+
+	cmd.AddCommand(blocklistStatusCmd())
+	cmd.AddCommand(blocklistCheckCmd())
+	cmd.AddCommand(blocklistRefreshCmd())
+
+	return cmd
+}
+
+// blocklistStatusCmd creates the blocklist status subcommand.
+func blocklistStatusCmd() *cobra.Command {
+	var (
+		agentAddr  string
+		targetID   string
+		jsonOutput bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "status",
+		Short: "Show blocklist sources and blocked attempts",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			result, err := blocklistManage(agentAddr, targetID, "status", "")
+			if err != nil {
+				return err
+			}
+
+			if jsonOutput {
+				out, _ := json.MarshalIndent(result.Blocklist, "", "  ")
+				fmt.Println(string(out))
+				return nil
+			}
+
+			printBlocklistStatus(result.Blocklist)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&agentAddr, "agent", "a", "localhost:8080", "Agent API address (host:port)")
+	cmd.Flags().StringVarP(&targetID, "target", "t", "", "Target agent ID (omit for local agent)")
+	cmd.Flags().BoolVar(&jsonOutput, "json", false, "Output in JSON format")
+
+	return cmd
+}
+
+// blocklistCheckCmd creates the blocklist check subcommand.
+func blocklistCheckCmd() *cobra.Command {
+	var (
+		agentAddr  string
+		targetID   string
+		jsonOutput bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "check <destination>",
+		Short: "Test whether a domain or address would be blocked",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			result, err := blocklistManage(agentAddr, targetID, "check", args[0])
+			if err != nil {
+				return err
+			}
+
+			if jsonOutput {
+				out, _ := json.MarshalIndent(result, "", "  ")
+				fmt.Println(string(out))
+				return nil
+			}
+
+			fmt.Println(result.Message)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&agentAddr, "agent", "a", "localhost:8080", "Agent API address (host:port)")
+	cmd.Flags().StringVarP(&targetID, "target", "t", "", "Target agent ID (omit for local agent)")
+	cmd.Flags().BoolVar(&jsonOutput, "json", false, "Output in JSON format")
+
+	return cmd
+}
+
+// blocklistRefreshCmd creates the blocklist refresh subcommand.
+func blocklistRefreshCmd() *cobra.Command {
+	var (
+		agentAddr string
+		targetID  string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "refresh",
+		Short: "Reload blocklist files and feeds now",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			result, err := blocklistManage(agentAddr, targetID, "refresh", "")
+			if err != nil {
+				return err
+			}
+
+			fmt.Println(result.Message)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&agentAddr, "agent", "a", "localhost:8080", "Agent API address (host:port)")
+	cmd.Flags().StringVarP(&targetID, "target", "t", "", "Target agent ID (omit for local agent)")
+
+	return cmd
+}
+
+// printBlocklistStatus prints the blocklist counters and a table of its
+// sources.
+func printBlocklistStatus(st *blocklistStatus) {
+	if st == nil {
+		return
+	}
+	fmt.Printf("Entries: %d\n", st.Entries)
+	fmt.Printf("Checked: %d\n", st.Checked)
+	fmt.Printf("Blocked: %d\n", st.Blocked)
+	if !st.LastRefresh.IsZero() {
+		fmt.Printf("Last refresh: %s\n", st.LastRefresh.Local().Format("2006-01-02 15:04:05"))
+	}
+	fmt.Println()
+
+	fmt.Printf("%-7s %-9s %-9s %-20s %s\n", "TYPE", "ENTRIES", "BLOCKED", "LOADED", "SOURCE")
+	for _, src := range st.Sources {
+		loaded := "-"
+		if !src.Loaded.IsZero() {
+			loaded = src.Loaded.Local().Format("2006-01-02 15:04:05")
+		}
+		fmt.Printf("%-7s %-9d %-9d %-20s %s\n", src.Type, src.Entries, src.Blocked, loaded, src.Source)
+		if src.Invalid > 0 {
+			fmt.Printf("        %d invalid lines skipped\n", src.Invalid)
+		}
+		if src.Error != "" {
+			fmt.Printf("        error: %s\n", src.Error)
+		}
+	}
+}
+
+// blocklistSourceStatus mirrors a source entry returned by
+// /blocklist/manage.
+type blocklistSourceStatus struct {
+	Source  string    `json:"source"`
+	Type    string    `json:"type"`
+	Entries int       `json:"entries"`
+	Invalid int       `json:"invalid,omitempty"`
+	Blocked uint64    `json:"blocked"`
+	Loaded  time.Time `json:"loaded,omitempty"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// blocklistStatus mirrors the blocklist status returned by
+// /blocklist/manage.
+type blocklistStatus struct {
+	Entries     int                     `json:"entries"`
+	Checked     uint64                  `json:"checked"`
+	Blocked     uint64                  `json:"blocked"`
+	LastRefresh time.Time               `json:"last_refresh,omitempty"`
+	Sources     []blocklistSourceStatus `json:"sources"`
+}
+
+// blocklistResult is the response of a blocklist management request.
+type blocklistResult struct {
+	Status    string           `json:"status"`
+	Message   string           `json:"message,omitempty"`
+	Blocklist *blocklistStatus `json:"blocklist,omitempty"`
+	Blocked   *bool            `json:"blocked,omitempty"`
+	Match     *struct {
+		Source string `json:"source"`
+		Entry  string `json:"entry"`
+	} `json:"match,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// blocklistManage sends a blocklist management request to an agent.
+func blocklistManage(agentAddr, targetID, action, destination string) (*blocklistResult, error) {
+	body, _ := json.Marshal(struct {
+		Action      string `json:"action"`
+		Destination string `json:"destination,omitempty"`
+	}{
+		Action:      action,
+		Destination: destination,
+	})
+
+	url := fmt.Sprintf("http://%s/blocklist/manage", agentAddr)
+	if targetID != "" {
+		resolvedID, err := resolveAgentID(targetID, agentAddr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve agent ID: %w", err)
+		}
+		url = fmt.Sprintf("http://%s/agents/%s/blocklist/manage", agentAddr, resolvedID)
+	}
+
+	// Refreshes fetch feeds, so allow more than the usual 10 seconds
+	ctx, cancel := context.WithTimeout(context.Background(), 40*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	setAuthToken(req)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to agent: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var result blocklistResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		if result.Error != "" {
+			return nil, fmt.Errorf("blocklist %s failed: %s", action, result.Error)
+		}
+		return nil, fmt.Errorf("blocklist %s failed: %s", action, resp.Status)
+	}
+
+	return &result, nil
+}
+
+// crashesCmd creates the crashes command for crash reports.
+func crashesCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "crashes",
+		Short: "List, fetch and clear crash reports",
+		Long: `Inspect the crash reports of an agent.
+
+With crash_reports enabled, panics recovered in agent goroutines and fatal
+crashes of the process are saved in data_dir/crashes. Each report holds the
+panic value, the stack, a dump of all goroutines, the agent version and a
+hash of its configuration. Reports of remote agents are fetched over the
+mesh.
+
+Examples:
+  # List crash reports of the local agent
+  muti-metroo crashes list
+
+  # Show a report of a remote exit node
+  muti-metroo crashes get 20260102T030405Z-a1b2c3 --target abc123
+
+  # Save the full report, including the goroutine dump
+  muti-metroo crashes get 20260102T030405Z-a1b2c3 -t abc123 -o crash.json
+
+  # Remove all reports
+  muti-metroo crashes clear`,
+	}
+
+	cmd.AddCommand(crashesListCmd())
+	cmd.AddCommand(crashesGetCmd())
+	cmd.AddCommand(crashesClearCmd())
+
+	return cmd
+}
+
+// crashesListCmd creates the crashes list subcommand.
+func crashesListCmd() *cobra.Command {
+	var (
+		agentAddr  string
+		targetID   string
+		jsonOutput bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List crash reports, newest first",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			result, err := crashManage(agentAddr, targetID, crashManageRequest{Action: "list"})
+			if err != nil {
+				return err
+			}
+
+			if jsonOutput {
+				out, _ := json.MarshalIndent(result.Reports, "", "  ")
+				fmt.Println(string(out))
+				return nil
+			}
+
+			if len(result.Reports) == 0 {
+				fmt.Println("No crash reports")
+				return nil
+			}
+			fmt.Printf("%-24s %-20s %-6s %-12s %-24s %s\n", "ID", "TIME", "FATAL", "VERSION", "GOROUTINE", "PANIC")
+			for _, r := range result.Reports {
+				fatal := "no"
+				if r.Fatal {
+					fatal = "yes"
+				}
+				goroutine := r.Goroutine
+				if goroutine == "" {
+					goroutine = "-"
+				}
+				fmt.Printf("%-24s %-20s %-6s %-12s %-24s %s\n", r.ID, r.Time.Local().Format("2006-01-02 15:04:05"),
+					fatal, r.Version, goroutine, r.Panic)
+			}
+			if result.Truncated {
+				fmt.Println("\nOlder reports not shown (response size limit)")
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&agentAddr, "agent", "a", "localhost:8080", "Agent API address (host:port)")
+	cmd.Flags().StringVarP(&targetID, "target", "t", "", "Target agent ID (omit for local agent)")
+	cmd.Flags().BoolVar(&jsonOutput, "json", false, "Output in JSON format")
+
+	return cmd
+}
+
+// crashesGetCmd creates the crashes get subcommand.
+func crashesGetCmd() *cobra.Command {
+	var (
+		agentAddr  string
+		targetID   string
+		outputFile string
+		jsonOutput bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "get <id>",
+		Short: "Show or save a crash report",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			data, err := fetchCrashReport(agentAddr, targetID, args[0])
+			if err != nil {
+				return err
+			}
+
+			if outputFile != "" {
+				if err := os.WriteFile(outputFile, data, 0600); err != nil {
+					return fmt.Errorf("failed to write report: %w", err)
+				}
+				fmt.Printf("Crash report saved to %s\n", outputFile)
+				return nil
+			}
+			if jsonOutput {
+				var out bytes.Buffer
+				if json.Indent(&out, data, "", "  ") != nil {
+					out.Reset()
+					out.Write(data)
+				}
+				fmt.Println(strings.TrimSpace(out.String()))
+				return nil
+			}
+
+			var r crashReport
+			if err := json.Unmarshal(data, &r); err != nil {
+				return fmt.Errorf("failed to decode report: %w", err)
+			}
+			fmt.Printf("ID:          %s\n", r.ID)
+			fmt.Printf("Time:        %s\n", r.Time.Local().Format("2006-01-02 15:04:05"))
+			fmt.Printf("Fatal:       %t\n", r.Fatal)
+			if r.Goroutine != "" {
+				fmt.Printf("Goroutine:   %s\n", r.Goroutine)
+			}
+			if r.AgentID != "" {
+				fmt.Printf("Agent:       %s\n", r.AgentID)
+			}
+			fmt.Printf("Version:     %s (%s, %s/%s)\n", r.Version, r.GoVersion, r.OS, r.Arch)
+			if r.ConfigHash != "" {
+				fmt.Printf("Config hash: %s\n", r.ConfigHash)
+			}
+			fmt.Printf("Panic:       %s\n\n", r.Panic)
+			fmt.Println(strings.TrimSpace(r.Stack))
+			if r.Goroutines != "" {
+				fmt.Println("\nGoroutine dump omitted, use -o or --json to get it")
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&agentAddr, "agent", "a", "localhost:8080", "Agent API address (host:port)")
+	cmd.Flags().StringVarP(&targetID, "target", "t", "", "Target agent ID (omit for local agent)")
+	cmd.Flags().StringVarP(&outputFile, "output", "o", "", "Write the full report as JSON to this file")
+	cmd.Flags().BoolVar(&jsonOutput, "json", false, "Print the full report as JSON")
+
+	return cmd
+}
+
+// crashesClearCmd creates the crashes clear subcommand.
+func crashesClearCmd() *cobra.Command {
+	var (
+		agentAddr string
+		targetID  string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "clear [id]",
+		Short: "Remove one crash report, or all of them",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			req := crashManageRequest{Action: "clear"}
+			if len(args) == 1 {
+				req.ID = args[0]
+			}
+			result, err := crashManage(agentAddr, targetID, req)
+			if err != nil {
+				return err
+			}
+
+			fmt.Println(result.Message)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&agentAddr, "agent", "a", "localhost:8080", "Agent API address (host:port)")
+	cmd.Flags().StringVarP(&targetID, "target", "t", "", "Target agent ID (omit for local agent)")
+
+	return cmd
+}
+
+// crashSummary mirrors a report summary returned by /crashes/manage.
+type crashSummary struct {
+	ID        string    `json:"id"`
+	Time      time.Time `json:"time"`
+	Goroutine string    `json:"goroutine,omitempty"`
+	Panic     string    `json:"panic"`
+	Fatal     bool      `json:"fatal,omitempty"`
+	Version   string    `json:"version"`
+	Size      int64     `json:"size"`
+}
+
+// crashReport mirrors the report JSON fetched with /crashes/manage.
+type crashReport struct {
+	ID         string    `json:"id"`
+	Time       time.Time `json:"time"`
+	Goroutine  string    `json:"goroutine,omitempty"`
+	Panic      string    `json:"panic"`
+	Fatal      bool      `json:"fatal,omitempty"`
+	Stack      string    `json:"stack"`
+	Goroutines string    `json:"goroutines,omitempty"`
+	AgentID    string    `json:"agent_id,omitempty"`
+	Version    string    `json:"version"`
+	ConfigHash string    `json:"config_hash,omitempty"`
+	GoVersion  string    `json:"go_version"`
+	OS         string    `json:"os"`
+	Arch       string    `json:"arch"`
+}
+
+// crashManageRequest is a crash report management request.
+type crashManageRequest struct {
+	Action string `json:"action"`
+	ID     string `json:"id,omitempty"`
+	Offset int64  `json:"offset,omitempty"`
+}
+
+// crashManageResult is the response of a crash report management request.
+type crashManageResult struct {
+	Status    string         `json:"status"`
+	Message   string         `json:"message,omitempty"`
+	Reports   []crashSummary `json:"reports,omitempty"`
+	Truncated bool           `json:"truncated,omitempty"`
+	ID        string         `json:"id,omitempty"`
+	Offset    int64          `json:"offset,omitempty"`
+	Size      int64          `json:"size,omitempty"`
+	Data      []byte         `json:"data,omitempty"`
+	Deleted   int            `json:"deleted,omitempty"`
+	Error     string         `json:"error,omitempty"`
+}
+
+// fetchCrashReport fetches a report in chunks and returns its JSON.
+func fetchCrashReport(agentAddr, targetID, id string) ([]byte, error) {
+	var compressed []byte
+	for {
+		result, err := crashManage(agentAddr, targetID, crashManageRequest{
+			Action: "get",
+			ID:     id,
+			Offset: int64(len(compressed)),
+		})
+		if err != nil {
+			return nil, err
+		}
+		compressed = append(compressed, result.Data...)
+		if int64(len(compressed)) >= result.Size {
+			break
+		}
+		if len(result.Data) == 0 {
+			return nil, fmt.Errorf("crash report transfer stalled at %d of %d bytes", len(compressed), result.Size)
+		}
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress report: %w", err)
+	}
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress report: %w", err)
+	}
+	return data, nil
+}
+
+// crashManage sends a crash report management request to an agent.
+func crashManage(agentAddr, targetID string, request crashManageRequest) (*crashManageResult, error) {
+	body, _ := json.Marshal(request)
+
+	url := fmt.Sprintf("http://%s/crashes/manage", agentAddr)
+	if targetID != "" {
+		resolvedID, err := resolveAgentID(targetID, agentAddr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve agent ID: %w", err)
+		}
+		url = fmt.Sprintf("http://%s/agents/%s/crashes/manage", agentAddr, resolvedID)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	setAuthToken(req)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to agent: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var result crashManageResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		if result.Error != "" {
+			return nil, fmt.Errorf("crashes %s failed: %s", request.Action, result.Error)
+		}
+		return nil, fmt.Errorf("crashes %s failed: %s", request.Action, resp.Status)
+	}
+
+	return &result, nil
+}
+
+// usageCmd creates the usage command for bandwidth usage accounting.
+func usageCmd() *cobra.Command {
+	var (
+		agentAddr  string
+		targetID   string
+		month      string
+		by         string
+		jsonOutput bool
+		csvOutput  bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "usage",
+		Short: "Show bandwidth usage per peer, SOCKS5 user and destination",
+		Long: `Show the bytes an agent exchanged per peer link, per SOCKS5 user and per
+exit destination bucket.
+
+With usage.enabled, the agent keeps monthly counters (UTC calendar months) in
+its data directory. IN is received from the peer, user or destination, OUT is
+sent to it. Destinations are grouped by registrable domain, IPv4 /24 and
+IPv6 /48.
+
+Use --csv to export usage for billing, one row per month, kind and key.
+
+Examples:
+  # Usage of the current month on the local agent
+  muti-metroo usage
+
+  # Last month's SOCKS5 users on a remote agent
+  muti-metroo usage --target abc123 --month 2026-09 --by users
+
+  # Export every retained month
+  muti-metroo usage --month all --csv > usage.csv`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if jsonOutput && csvOutput {
+				return fmt.Errorf("--json and --csv are mutually exclusive")
+			}
+			kinds := []string{"peers", "users", "destinations"}
+			if by != "" {
+				if by != "peers" && by != "users" && by != "destinations" {
+					return fmt.Errorf("--by must be peers, users or destinations")
+				}
+				kinds = []string{by}
+			}
+
+			report, err := fetchUsage(agentAddr, targetID)
+			if err != nil {
+				return err
+			}
+			if !report.Enabled {
+				return fmt.Errorf("usage accounting is not enabled on this agent")
+			}
+
+			var periods []usagePeriod
+			for _, p := range append([]usagePeriod{report.Current}, report.History...) {
+				if month == "all" || p.Month == month || (month == "" && p.Month == report.Current.Month) {
+					periods = append(periods, p)
+				}
+			}
+			if len(periods) == 0 {
+				return fmt.Errorf("no usage recorded for %s", month)
+			}
+
+			switch {
+			case jsonOutput:
+				out, _ := json.MarshalIndent(periods, "", "  ")
+				fmt.Println(string(out))
+			case csvOutput:
+				w := csv.NewWriter(os.Stdout)
+				w.Write([]string{"month", "kind", "key", "bytes_in", "bytes_out"})
+				for _, p := range periods {
+					for _, kind := range kinds {
+						for _, row := range p.rows(kind) {
+							w.Write([]string{p.Month, kind, row.key,
+								strconv.FormatUint(row.In, 10), strconv.FormatUint(row.Out, 10)})
+						}
+					}
+				}
+				w.Flush()
+				return w.Error()
+			default:
+				for _, p := range periods {
+					printUsagePeriod(p, kinds)
+				}
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&agentAddr, "agent", "a", "localhost:8080", "Agent API address (host:port)")
+	cmd.Flags().StringVarP(&targetID, "target", "t", "", "Target agent ID (omit for local agent)")
+	cmd.Flags().StringVar(&month, "month", "", "Month to show as YYYY-MM, or \"all\" (default: current month)")
+	cmd.Flags().StringVar(&by, "by", "", "Show only peers, users or destinations")
+	cmd.Flags().BoolVar(&jsonOutput, "json", false, "Output in JSON format")
+	cmd.Flags().BoolVar(&csvOutput, "csv", false, "Output in CSV format")
+
+	return cmd
+}
+
+// usageCounter mirrors a counter returned by /usage.
+type usageCounter struct {
+	In  uint64 `json:"bytes_in"`
+	Out uint64 `json:"bytes_out"`
+}
+
+// usagePeriod mirrors the usage of one month returned by /usage.
+type usagePeriod struct {
+	Month        string                  `json:"month"`
+	Peers        map[string]usageCounter `json:"peers"`
+	Users        map[string]usageCounter `json:"users"`
+	Destinations map[string]usageCounter `json:"destinations"`
+}
+
+// usageReport is the response of /usage.
+type usageReport struct {
+	Enabled bool          `json:"enabled"`
+	Current usagePeriod   `json:"current"`
+	History []usagePeriod `json:"history,omitempty"`
+}
+
+// usageRow is one counter of a period with its key.
+type usageRow struct {
+	key string
+	usageCounter
+}
+
+// rows returns the counters of one kind, largest total first.
+func (p usagePeriod) rows(kind string) []usageRow {
+	table := p.Peers
+	switch kind {
+	case "users":
+		table = p.Users
+	case "destinations":
+		table = p.Destinations
+	}
+
+	rows := make([]usageRow, 0, len(table))
+	for key, c := range table {
+		rows = append(rows, usageRow{key: key, usageCounter: c})
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		ti, tj := rows[i].In+rows[i].Out, rows[j].In+rows[j].Out
+		if ti != tj {
+			return ti > tj
+		}
+		return rows[i].key < rows[j].key
+	})
+	return rows
+}
+
+// printUsagePeriod prints the usage tables of one month.
+func printUsagePeriod(p usagePeriod, kinds []string) {
+	fmt.Printf("Usage %s\n", p.Month)
+	fmt.Printf("=============\n")
+	for _, kind := range kinds {
+		rows := p.rows(kind)
+		fmt.Printf("\n%s:\n", strings.ToUpper(kind[:1])+kind[1:])
+		if len(rows) == 0 {
+			fmt.Println("  (none)")
+			continue
+		}
+		fmt.Printf("  %-32s %-10s %-10s %s\n", "KEY", "IN", "OUT", "TOTAL")
+		for _, row := range rows {
+			key := row.key
+			if id, err := identity.ParseAgentID(key); kind == "peers" && err == nil {
+				key = id.ShortString()
+			}
+			fmt.Printf("  %-32s %-10s %-10s %s\n", key,
+				humanize.Bytes(row.In), humanize.Bytes(row.Out), humanize.Bytes(row.In+row.Out))
+		}
+	}
+	fmt.Println()
+}
+
+// fetchUsage reads the usage report of an agent.
+func fetchUsage(agentAddr, targetID string) (*usageReport, error) {
+	url := fmt.Sprintf("http://%s/usage", agentAddr)
+	if targetID != "" {
+		resolvedID, err := resolveAgentID(targetID, agentAddr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve agent ID: %w", err)
+		}
+		url = fmt.Sprintf("http://%s/agents/%s/usage", agentAddr, resolvedID)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 35*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	setAuthToken(req)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to agent: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, fmt.Errorf("usage failed: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	var report usageReport
+	if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return &report, nil
+}
+
+// topCmd creates the top command for watching the resource usage of an
+// agent's host.
+func topCmd() *cobra.Command {
+	var (
+		agentAddr   string
+		intervalStr string
+		once        bool
+		jsonOutput  bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "top [flags] [agent-id]",
+		Short: "Watch CPU, memory, disk, network and file descriptor usage of an agent's host",
+		Long: `Watch the resource usage of the host an agent runs on, refreshed every
+--interval until interrupted.
+
+CPU usage and interface rates cover the time since the previous reading.
+Without an agent ID the agent at --agent is shown. Sections the platform
+does not support (CPU and interfaces on macOS) are listed as unsupported.
+
+Examples:
+  # Local agent
+  muti-metroo top
+
+  # Remote agent, refreshed every 5 seconds
+  muti-metroo top -i 5s abc123
+
+  # One reading as JSON
+  muti-metroo top --once --json abc123`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			interval, err := time.ParseDuration(intervalStr)
+			if err != nil {
+				return fmt.Errorf("invalid interval: %w", err)
+			}
+			if interval < time.Second {
+				return fmt.Errorf("--interval must be at least 1s")
+			}
+
+			url := fmt.Sprintf("http://%s/system", agentAddr)
+			if len(args) == 1 {
+				resolvedID, err := resolveAgentID(args[0], agentAddr)
+				if err != nil {
+					return fmt.Errorf("failed to resolve agent ID: %w", err)
+				}
+				url = fmt.Sprintf("http://%s/agents/%s/system", agentAddr, resolvedID)
+			}
+
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+
+			for {
+				m, err := fetchSystemMetrics(ctx, url)
+				if err != nil {
+					if ctx.Err() != nil {
+						return nil
+					}
+					return err
+				}
+				if jsonOutput {
+					out, _ := json.MarshalIndent(m, "", "  ")
+					fmt.Println(string(out))
+				} else {
+					if !once {
+						fmt.Print("\033[H\033[2J") // Clear screen
+					}
+					printSystemMetrics(m)
+				}
+				if once {
+					return nil
+				}
+				select {
+				case <-ctx.Done():
+					return nil
+				case <-time.After(interval):
+				}
+			}
+		},
+	}
+
+	cmd.Flags().StringVarP(&agentAddr, "agent", "a", "localhost:8080", "Gateway agent API address (host:port)")
+	cmd.Flags().StringVarP(&intervalStr, "interval", "i", "2s", "Refresh interval")
+	cmd.Flags().BoolVar(&once, "once", false, "Print one reading and exit")
+	cmd.Flags().BoolVar(&jsonOutput, "json", false, "Output in JSON format")
+
+	return cmd
+}
+
+// fetchSystemMetrics reads the host metrics from /system or
+// /agents/{id}/system.
+func fetchSystemMetrics(ctx context.Context, url string) (*sysinfo.Metrics, error) {
+	ctx, cancel := context.WithTimeout(ctx, 35*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	setAuthToken(req)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to agent: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, fmt.Errorf("system metrics failed: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	var m sysinfo.Metrics
+	if err := json.NewDecoder(resp.Body).Decode(&m); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return &m, nil
+}
+
+// percentOf returns part as a percentage of total.
+func percentOf(part, total uint64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return 100 * float64(part) / float64(total)
+}
+
+// printSystemMetrics prints a reading of host metrics.
+func printSystemMetrics(m *sysinfo.Metrics) {
+	unsupported := make(map[string]bool)
+	for _, s := range m.Unsupported {
+		unsupported[s] = true
+	}
+
+	fmt.Printf("Host metrics at %s (over %.1fs)\n", m.Time.Local().Format("2006-01-02 15:04:05"), m.Interval)
+	fmt.Printf("================\n\n")
+
+	if !unsupported[sysinfo.SectionCPU] {
+		fmt.Printf("CPU:      %5.1f%% of %d cores", m.CPU.Percent, m.CPU.Cores)
+		if len(m.CPU.Load) == 3 {
+			fmt.Printf("   load %.2f %.2f %.2f", m.CPU.Load[0], m.CPU.Load[1], m.CPU.Load[2])
+		}
+		fmt.Println()
+	}
+	if !unsupported[sysinfo.SectionMemory] {
+		fmt.Printf("Memory:   %s / %s used (%.1f%%)", humanize.IBytes(m.Memory.Used), humanize.IBytes(m.Memory.Total),
+			percentOf(m.Memory.Used, m.Memory.Total))
+		if m.Memory.SwapTotal > 0 {
+			fmt.Printf(", swap %s / %s", humanize.IBytes(m.Memory.SwapUsed), humanize.IBytes(m.Memory.SwapTotal))
+		}
+		fmt.Println()
+	}
+	if !unsupported[sysinfo.SectionFiles] {
+		fmt.Printf("Files:    %d open", m.Files.ProcessOpen)
+		if m.Files.ProcessLimit > 0 {
+			fmt.Printf(" (limit %d)", m.Files.ProcessLimit)
+		}
+		if m.Files.SystemLimit > 0 {
+			fmt.Printf(", system %d / %d", m.Files.SystemOpen, m.Files.SystemLimit)
+		}
+		fmt.Println()
+	}
+	fmt.Printf("Process:  pid %d, %d goroutines, heap %s, runtime %s\n", m.Process.PID, m.Process.Goroutines,
+		humanize.IBytes(m.Process.HeapBytes), humanize.IBytes(m.Process.SysBytes))
+
+	if len(m.Disks) > 0 {
+		fmt.Printf("\nDisks:\n")
+		fmt.Printf("  %-24s %-10s %-10s %-10s %s\n", "PATH", "USED", "FREE", "TOTAL", "USE%")
+		for _, d := range m.Disks {
+			fmt.Printf("  %-24s %-10s %-10s %-10s %.1f%%\n", d.Path, humanize.IBytes(d.Used), humanize.IBytes(d.Free),
+				humanize.IBytes(d.Total), percentOf(d.Used, d.Used+d.Free))
+		}
+	}
+	if len(m.Interfaces) > 0 {
+		fmt.Printf("\nInterfaces:\n")
+		fmt.Printf("  %-16s %-12s %-12s %-10s %-10s %s\n", "NAME", "RX/S", "TX/S", "RX", "TX", "ERRORS")
+		for _, im := range m.Interfaces {
+			fmt.Printf("  %-16s %-12s %-12s %-10s %-10s %d\n", im.Name,
+				humanize.IBytes(uint64(im.RxRate))+"/s", humanize.IBytes(uint64(im.TxRate))+"/s",
+				humanize.IBytes(im.RxBytes), humanize.IBytes(im.TxBytes), im.RxErrors+im.TxErrors)
+		}
+	}
+	if len(m.Unsupported) > 0 {
+		fmt.Printf("\nUnsupported on this platform: %s\n", strings.Join(m.Unsupported, ", "))
+	}
+}
+
+// tailCmd creates the tail command for printing and following a file on a
+// remote agent.
+func tailCmd() *cobra.Command {
+	var (
+		agentAddr   string
+		password    string
+		intervalStr string
+		lines       int
+		follow      bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "tail [flags] <target-agent-id> <remote-path>",
+		Short: "Print the end of a file on a remote agent and follow it",
+		Long: `Print the last lines of a file on a remote agent. With --follow, keep
+polling the file and print data as it is appended, like "tail -f".
+
+A file that becomes shorter than what was already read (truncated or
+replaced by log rotation) is read again from the start.
+
+The file must be within the file_transfer.allowed_paths of the remote agent.
+
+Examples:
+  # Last 10 lines
+  muti-metroo tail abc123 /var/log/syslog
+
+  # Follow a log, starting with the last 50 lines
+  muti-metroo tail -f -n 50 abc123 /var/log/app.log
+
+  # Only new data, polled every 5 seconds
+  muti-metroo tail -f -n 0 -i 5s abc123 /var/log/app.log`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			remotePath := args[1]
+			interval, err := time.ParseDuration(intervalStr)
+			if err != nil {
+				return fmt.Errorf("invalid interval: %w", err)
+			}
+			if interval < 100*time.Millisecond {
+				return fmt.Errorf("--interval must be at least 100ms")
+			}
+
+			resolvedID, err := resolveAgentID(args[0], agentAddr)
+			if err != nil {
+				return err
+			}
+
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+
+			// With -n 0 the last line is requested only to learn the end
+			// offset of the file.
+			var resp filetransfer.BrowseResponse
+			req := &filetransfer.BrowseRequest{Action: "tail", Path: remotePath, Password: password, Lines: max(lines, 1)}
+			if err := remoteBrowse(agentAddr, resolvedID, req, &resp); err != nil {
+				return err
+			}
+			if lines > 0 {
+				os.Stdout.Write(resp.Data)
+			}
+			if !follow {
+				return nil
+			}
+
+			next := resp.Next
+			more := false
+			for {
+				if !more {
+					select {
+					case <-ctx.Done():
+						return nil
+					case <-time.After(interval):
+					}
+				}
+				req := &filetransfer.BrowseRequest{Action: "tail", Path: remotePath, Password: password, From: next}
+				if err := remoteBrowse(agentAddr, resolvedID, req, &resp); err != nil {
+					if ctx.Err() != nil {
+						return nil
+					}
+					return err
+				}
+				if resp.Rotated {
+					fmt.Fprintf(os.Stderr, "tail: %s: file truncated\n", remotePath)
+				}
+				os.Stdout.Write(resp.Data)
+				next = resp.Next
+				more = resp.Truncated
+			}
+		},
+	}
+
+	cmd.Flags().StringVarP(&agentAddr, "agent", "a", "localhost:8080", "Gateway agent API address (host:port)")
+	cmd.Flags().StringVarP(&password, "password", "p", "", "File transfer password for authentication")
+	cmd.Flags().IntVarP(&lines, "lines", "n", 10, "Number of last lines to print first (at most 1000)")
+	cmd.Flags().BoolVarP(&follow, "follow", "f", false, "Keep printing data appended to the file")
+	cmd.Flags().StringVarP(&intervalStr, "interval", "i", "1s", "Poll interval with --follow")
+
+	return cmd
+}
+
+// idleCmd creates the idle command for listing and force-closing idle
+// streams and UDP associations.
+func idleCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "idle",
+		Short: "List and close idle streams and UDP associations",
+		Long: `Find streams and UDP associations that carried no data for a while.
+
+Leaked client connections keep streams open through the mesh. "idle list"
+shows outbound, exit and relay streams and exit UDP associations idle for at
+least --min-idle. "idle close" resets them: STREAM_RESET is sent to the
+adjacent peers of each stream and UDP_CLOSE to the peer of each association.
+
+Examples:
+  # Streams idle for 10 minutes or more on the local agent
+  muti-metroo idle list
+
+  # Close everything idle for an hour on a remote agent
+  muti-metroo idle close --min-idle 1h --target abc123`,
+	}
+
+	cmd.AddCommand(idleListCmd())
+	cmd.AddCommand(idleCloseCmd())
+
+	return cmd
+}
+
+// idleListCmd creates the idle list subcommand.
+func idleListCmd() *cobra.Command {
+	var (
+		agentAddr  string
+		targetID   string
+		minIdle    string
+		jsonOutput bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List idle streams and UDP associations",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			result, err := idleManage(agentAddr, targetID, idleRequest{Action: "list", MinIdle: minIdle})
+			if err != nil {
+				return err
+			}
+
+			if jsonOutput {
+				out, _ := json.MarshalIndent(result, "", "  ")
+				fmt.Println(string(out))
+				return nil
+			}
+
+			if len(result.Entries) == 0 {
+				fmt.Printf("Nothing idle for %s or longer\n", result.MinIdle)
+				return nil
+			}
+			printIdleEntries(result.Entries)
+			fmt.Printf("\nTotal: %d idle for %s or longer\n", len(result.Entries), result.MinIdle)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&agentAddr, "agent", "a", "localhost:8080", "Agent API address (host:port)")
+	cmd.Flags().StringVarP(&targetID, "target", "t", "", "Target agent ID (omit for local agent)")
+	cmd.Flags().StringVar(&minIdle, "min-idle", "10m", "Minimum idle time (e.g. 30s, 10m, 1h)")
+	cmd.Flags().BoolVar(&jsonOutput, "json", false, "Output in JSON format")
+
+	return cmd
+}
+
+// idleCloseCmd creates the idle close subcommand.
+func idleCloseCmd() *cobra.Command {
+	var (
+		agentAddr  string
+		targetID   string
+		minIdle    string
+		jsonOutput bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "close",
+		Short: "Close idle streams and UDP associations",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			result, err := idleManage(agentAddr, targetID, idleRequest{Action: "close", MinIdle: minIdle})
+			if err != nil {
+				return err
+			}
+
+			if jsonOutput {
+				out, _ := json.MarshalIndent(result, "", "  ")
+				fmt.Println(string(out))
+				return nil
+			}
+
+			if len(result.Entries) > 0 {
+				printIdleEntries(result.Entries)
+				fmt.Println()
+			}
+			fmt.Println(result.Message)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&agentAddr, "agent", "a", "localhost:8080", "Agent API address (host:port)")
+	cmd.Flags().StringVarP(&targetID, "target", "t", "", "Target agent ID (omit for local agent)")
+	cmd.Flags().StringVar(&minIdle, "min-idle", "10m", "Minimum idle time (e.g. 30s, 10m, 1h)")
+	cmd.Flags().BoolVar(&jsonOutput, "json", false, "Output in JSON format")
+
+	return cmd
+}
+
+// printIdleEntries prints idle entries as a table.
+func printIdleEntries(entries []idleEntry) {
+	fmt.Printf("%-6s %-10s %-9s %-10s %-28s %10s %10s %9s %9s\n", "KIND", "ID", "DIRECTION", "PEER", "DESTINATION", "SENT", "RECV", "AGE", "IDLE")
+	for _, e := range entries {
+		direction, peer, dest := e.Direction, e.Peer, e.Destination
+		if direction == "" {
+			direction = "-"
+		}
+		if peer == "" {
+			peer = "local"
+		}
+		if dest == "" {
+			dest = "-"
+		}
+		fmt.Printf("%-6s %-10d %-9s %-10s %-28s %10s %10s %9s %9s\n",
+			e.Kind, e.ID, direction, peer, dest,
+			humanize.Bytes(e.BytesSent), humanize.Bytes(e.BytesRecv),
+			(time.Duration(e.AgeMs) * time.Millisecond).Round(time.Second).String(),
+			(time.Duration(e.IdleMs) * time.Millisecond).Round(time.Second).String())
+	}
+}
+
+// idleRequest is the body of an idle management request.
+type idleRequest struct {
+	Action  string `json:"action"`
+	MinIdle string `json:"min_idle,omitempty"`
+}
+
+// idleEntry mirrors an entry returned by /idle/manage.
+type idleEntry struct {
+	Kind        string `json:"kind"`
+	ID          uint64 `json:"id"`
+	Direction   string `json:"direction,omitempty"`
+	Peer        string `json:"peer,omitempty"`
+	Destination string `json:"destination,omitempty"`
+	BytesSent   uint64 `json:"bytes_sent"`
+	BytesRecv   uint64 `json:"bytes_recv"`
+	AgeMs       int64  `json:"age_ms"`
+	IdleMs      int64  `json:"idle_ms"`
+}
+
+// idleResult is the response of an idle management request.
+type idleResult struct {
+	Status  string      `json:"status"`
+	Message string      `json:"message,omitempty"`
+	MinIdle string      `json:"min_idle"`
+	Entries []idleEntry `json:"entries"`
+	Error   string      `json:"error,omitempty"`
+}
+
+// idleManage sends an idle management request to an agent.
+func idleManage(agentAddr, targetID string, reqBody idleRequest) (*idleResult, error) {
+	body, _ := json.Marshal(reqBody)
+
+	url := fmt.Sprintf("http://%s/idle/manage", agentAddr)
+	if targetID != "" {
+		resolvedID, err := resolveAgentID(targetID, agentAddr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve agent ID: %w", err)
+		}
+		url = fmt.Sprintf("http://%s/agents/%s/idle/manage", agentAddr, resolvedID)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	setAuthToken(req)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to agent: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var result idleResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		if result.Error != "" {
+			return nil, fmt.Errorf("idle %s failed: %s", reqBody.Action, result.Error)
+		}
+		return nil, fmt.Errorf("idle %s failed: %s", reqBody.Action, resp.Status)
+	}
+
+	return &result, nil
+}
+
+// taskCmd creates the task command for managing scheduled tasks.
+func taskCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "task",
+		Short: "Manage scheduled tasks on an agent",
+		Long: `Install and inspect recurring tasks on an agent.
+
+Tasks run a shell command or sync files on the target agent on a cron-style
+schedule. They are stored in the agent's data directory and survive
+restarts. The agent must have scheduler.enabled, and every task is checked
+against its shell or file_transfer settings when added and before each run.
+
+Schedules:
+  @every 15m          Fixed interval (at least 1m)
+  @hourly, @daily     Predefined schedules (also @weekly, @monthly)
+  "*/5 * * * *"       Five-field cron expression in the agent's time zone
+
+Examples:
+  # Run a command every 15 minutes on a remote agent
+  muti-metroo task add --target abc123 -s "@every 15m" -p secret -- df -h
+
+  # Sync a directory nightly at 02:30
+  muti-metroo task add -s "30 2 * * *" --source /srv/data --dest /backup/data
+
+  # List tasks and show the run history of one
+  muti-metroo task list --target abc123
+  muti-metroo task history 3f2a9c1e5b7d8a60 --target abc123
+
+  # Run a task now
+  muti-metroo task run 3f2a9c1e5b7d8a60 --target abc123`,
+	}
+
+	cmd.AddCommand(taskAddCmd())
+	cmd.AddCommand(taskListCmd())
+	cmd.AddCommand(taskHistoryCmd())
+	cmd.AddCommand(taskIDCmd("run", "Run a task now", "Started"))
+	cmd.AddCommand(taskIDCmd("remove", "Remove a task and its history", "Removed"))
+	cmd.AddCommand(taskIDCmd("enable", "Resume a paused task", "Enabled"))
+	cmd.AddCommand(taskIDCmd("disable", "Pause a task without removing it", "Disabled"))
+
+	return cmd
+}
+
+// taskAddCmd creates the task add subcommand.
+func taskAddCmd() *cobra.Command {
+	var (
+		agentAddr string
+		targetID  string
+		schedule  string
+		name      string
+		source    string
+		dest      string
+		timeout   time.Duration
+		password  string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "add [flags] [-- <command> [args...]]",
+		Short: "Install a shell command or file sync task",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			task := taskSpec{
+				Name:     name,
+				Schedule: schedule,
+				Timeout:  int(timeout.Seconds()),
+			}
+			switch {
+			case source != "" || dest != "":
+				if len(args) > 0 {
+					return fmt.Errorf("a task runs either a command or a file sync, not both")
+				}
+				task.Type = "file_sync"
+				task.Source = source
+				task.Dest = dest
+			case len(args) > 0:
+				task.Type = "shell"
+				task.Command = args[0]
+				task.Args = args[1:]
+			default:
+				return fmt.Errorf("specify a command after -- or --source and --dest")
+			}
+
+			result, err := taskManage(agentAddr, targetID, taskRequest{
+				Action:   "add",
+				Task:     &task,
+				Password: password,
+			})
+			if err != nil {
+				return err
+			}
+
+			fmt.Printf("Added task %s\n", result.Task.ID)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&agentAddr, "agent", "a", "localhost:8080", "Agent API address (host:port)")
+	cmd.Flags().StringVarP(&targetID, "target", "t", "", "Target agent ID (omit for local agent)")
+	cmd.Flags().StringVarP(&schedule, "schedule", "s", "", "Schedule (@every <duration>, @hourly, @daily, @weekly, @monthly or cron expression)")
+	cmd.Flags().StringVar(&name, "name", "", "Optional task name")
+	cmd.Flags().StringVar(&source, "source", "", "File sync source path on the target agent")
+	cmd.Flags().StringVar(&dest, "dest", "", "File sync destination path on the target agent")
+	cmd.Flags().DurationVar(&timeout, "timeout", 0, "Run timeout (default: scheduler.default_timeout)")
+	cmd.Flags().StringVarP(&password, "password", "p", "", "Shell or file transfer password of the target agent")
+	cmd.MarkFlagRequired("schedule")
+
+	return cmd
+}
+
+// taskListCmd creates the task list subcommand.
+func taskListCmd() *cobra.Command {
+	var (
+		agentAddr  string
+		targetID   string
+		jsonOutput bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List scheduled tasks",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			result, err := taskManage(agentAddr, targetID, taskRequest{Action: "list"})
+			if err != nil {
+				return err
+			}
+
+			if jsonOutput {
+				out, _ := json.MarshalIndent(result, "", "  ")
+				fmt.Println(string(out))
+				return nil
+			}
+
+			if len(result.Tasks) == 0 {
+				fmt.Println("No scheduled tasks")
+				return nil
+			}
+
+			fmt.Printf("%-16s  %-9s  %-16s  %-16s  %-8s  %s\n", "ID", "TYPE", "SCHEDULE", "NEXT RUN", "LAST", "TASK")
+			for _, t := range result.Tasks {
+				next := "-"
+				if t.Disabled {
+					next = "disabled"
+				} else if t.NextRun != nil {
+					next = t.NextRun.Local().Format("01-02 15:04:05")
+				}
+				last := "-"
+				switch {
+				case t.Running:
+					last = "running"
+				case t.LastRun != nil && t.LastRun.Success:
+					last = "ok"
+				case t.LastRun != nil:
+					last = "failed"
+				}
+				fmt.Printf("%-16s  %-9s  %-16s  %-16s  %-8s  %s\n",
+					t.ID, t.Type, t.Schedule, next, last, t.describe())
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&agentAddr, "agent", "a", "localhost:8080", "Agent API address (host:port)")
+	cmd.Flags().StringVarP(&targetID, "target", "t", "", "Target agent ID (omit for local agent)")
+	cmd.Flags().BoolVar(&jsonOutput, "json", false, "Output in JSON format")
+
+	return cmd
+}
+
+// taskHistoryCmd creates the task history subcommand.
+func taskHistoryCmd() *cobra.Command {
+	var (
+		agentAddr  string
+		targetID   string
+		jsonOutput bool
+		output     bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "history <task-id>",
+		Short: "Show recent runs of a task",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			result, err := taskManage(agentAddr, targetID, taskRequest{Action: "history", ID: args[0]})
+			if err != nil {
+				return err
+			}
+
+			if jsonOutput {
+				out, _ := json.MarshalIndent(result, "", "  ")
+				fmt.Println(string(out))
+				return nil
+			}
+
+			if len(result.History) == 0 {
+				fmt.Println("No runs yet")
+				return nil
+			}
+
+			fmt.Printf("%-19s  %-8s  %-7s  %5s  %10s  %s\n", "STARTED", "TRIGGER", "RESULT", "EXIT", "DURATION", "ERROR")
+			for _, r := range result.History {
+				status := "ok"
+				if !r.Success {
+					status = "failed"
+				}
+				fmt.Printf("%-19s  %-8s  %-7s  %5d  %10s  %s\n",
+					r.StartedAt.Local().Format("2006-01-02 15:04:05"), r.Trigger, status, r.ExitCode,
+					(time.Duration(r.DurationMs) * time.Millisecond).String(), r.Error)
+				if output && r.Output != "" {
+					fmt.Println(strings.TrimRight(r.Output, "\n"))
+					if r.Truncated {
+						fmt.Println("[output truncated]")
+					}
+					fmt.Println()
+				}
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&agentAddr, "agent", "a", "localhost:8080", "Agent API address (host:port)")
+	cmd.Flags().StringVarP(&targetID, "target", "t", "", "Target agent ID (omit for local agent)")
+	cmd.Flags().BoolVar(&jsonOutput, "json", false, "Output in JSON format")
+	cmd.Flags().BoolVarP(&output, "output", "o", false, "Print captured output of each run")
+
+	return cmd
+}
+
+// taskIDCmd creates a task subcommand that acts on a single task ID.
+func taskIDCmd(action, short, done string) *cobra.Command {
+	var (
+		agentAddr string
+		targetID  string
+	)
+
+	cmd := &cobra.Command{
+		Use:   action + " <task-id>",
+		Short: short,
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if _, err := taskManage(agentAddr, targetID, taskRequest{Action: action, ID: args[0]}); err != nil {
+				return err
+			}
+			fmt.Printf("%s task %s\n", done, args[0])
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&agentAddr, "agent", "a", "localhost:8080", "Agent API address (host:port)")
+	cmd.Flags().StringVarP(&targetID, "target", "t", "", "Target agent ID (omit for local agent)")
+
+	return cmd
+}
+
+// taskSpec mirrors a task accepted and returned by /scheduler/manage.
+type taskSpec struct {
+	ID       string   `json:"id,omitempty"`
+	Name     string   `json:"name,omitempty"`
+	Schedule string   `json:"schedule"`
+	Type     string   `json:"type"`
+	Command  string   `json:"command,omitempty"`
+	Args     []string `json:"args,omitempty"`
+	Source   string   `json:"source,omitempty"`
+	Dest     string   `json:"dest,omitempty"`
+	Timeout  int      `json:"timeout,omitempty"`
+	Disabled bool     `json:"disabled,omitempty"`
+}
+
+// describe returns a short human-readable summary of what the task does.
+func (t *taskSpec) describe() string {
+	desc := t.Command
+	if len(t.Args) > 0 {
+		desc += " " + strings.Join(t.Args, " ")
+	}
+	if t.Type == "file_sync" {
+		desc = t.Source + " -> " + t.Dest
+	}
+	if t.Name != "" {
+		desc = t.Name + ": " + desc
+	}
+	return desc
+}
+
+// taskRun mirrors a run entry returned by /scheduler/manage.
+type taskRun struct {
+	StartedAt  time.Time `json:"started_at"`
+	DurationMs int64     `json:"duration_ms"`
+	Trigger    string    `json:"trigger"`
+	Success    bool      `json:"success"`
+	ExitCode   int       `json:"exit_code"`
+	Output     string    `json:"output,omitempty"`
+	Truncated  bool      `json:"truncated,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// taskStatus mirrors a task list entry returned by /scheduler/manage.
+type taskStatus struct {
+	taskSpec
+	NextRun *time.Time `json:"next_run,omitempty"`
+	LastRun *taskRun   `json:"last_run,omitempty"`
+	Running bool       `json:"running"`
+}
+
+// taskRequest is the body of a scheduled task management request.
+type taskRequest struct {
+	Action   string    `json:"action"`
+	ID       string    `json:"id,omitempty"`
+	Task     *taskSpec `json:"task,omitempty"`
+	Password string    `json:"password,omitempty"`
+}
+
+// taskResult is the response of a scheduled task management request.
+type taskResult struct {
+	Status  string       `json:"status"`
+	Message string       `json:"message,omitempty"`
+	Task    *taskSpec    `json:"task,omitempty"`
+	Tasks   []taskStatus `json:"tasks,omitempty"`
+	History []taskRun    `json:"history,omitempty"`
+	Error   string       `json:"error,omitempty"`
+}
+
+// taskManage sends a scheduled task management request to an agent.
+func taskManage(agentAddr, targetID string, reqBody taskRequest) (*taskResult, error) {
+	body, _ := json.Marshal(reqBody)
+
+	url := fmt.Sprintf("http://%s/scheduler/manage", agentAddr)
+	if targetID != "" {
+		resolvedID, err := resolveAgentID(targetID, agentAddr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve agent ID: %w", err)
+		}
+		url = fmt.Sprintf("http://%s/agents/%s/scheduler/manage", agentAddr, resolvedID)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	setAuthToken(req)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to agent: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var result taskResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		if result.Error != "" {
+			return nil, fmt.Errorf("task %s failed: %s", reqBody.Action, result.Error)
+		}
+		return nil, fmt.Errorf("task %s failed: %s", reqBody.Action, resp.Status)
+	}
+
+	return &result, nil
+}
+
+// updateCmd creates the update command for replacing a remote agent's binary.
+func updateCmd() *cobra.Command {
+	var (
+		agentAddr  string
+		binaryPath string
+		password   string
+		signingKey string
+		version    string
+		force      bool
+		timeoutStr string
+		wait       time.Duration
+	)
+
+	cmd := &cobra.Command{
+		Use:   "update <agent-id>",
+		Short: "Replace a remote agent's binary and restart it",
+		Long: `Upload a new binary to a remote agent and restart the agent into it.
+
+The binary is uploaded over the file transfer stream to the agent's staging
+path, checked against its SHA-256 digest and, when the agent requires it,
+an Ed25519 signature made with the management signing key. The signature
+covers the digest, the binary's version and the target OS and architecture.
+The agent runs the new binary with --version, checks that it reports the
+signed version and refuses an older version than the running one unless
+--force is given. It then swaps the binary in place of the running
+executable and restarts: in place, or through systemd, launchd or the
+Windows Service Control Manager when it runs as a service.
+
+The binary's version is read by running it with --version. For a binary
+that does not run on this host, set --binary-version.
+
+The command then waits until the agent reports the new version in its node
+info.
+
+The target agent must have update.enabled and file_transfer.enabled.
+
+Examples:
+  # Update a remote agent (signing key from MUTI_METROO_SIGNING_KEY)
+  muti-metroo update abc123 --binary ./muti-metroo-linux-amd64 -p secret
+
+  # Via a different agent, with an explicit signing key
+  muti-metroo update -a 192.168.1.10:8080 abc123 --binary ./muti-metroo.exe --signing-key <hex>
+
+  # Roll back to an older release built for another platform
+  muti-metroo update abc123 --binary ./muti-metroo-linux-arm64 --binary-version 1.3.2 --force
+
+  # Do not wait for the agent to come back
+  muti-metroo update abc123 --binary ./muti-metroo-linux-arm64 --wait 0`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			timeoutSec, err := parseDuration(timeoutStr)
+			if err != nil {
+				return fmt.Errorf("invalid timeout: %w", err)
+			}
+
+			resolvedID, err := resolveAgentID(args[0], agentAddr)
+			if err != nil {
+				return err
+			}
+			if _, err := identity.ParseAgentID(resolvedID); err != nil {
+				return fmt.Errorf("invalid agent ID '%s': %w", resolvedID, err)
+			}
+
+			absBinary, err := filepath.Abs(binaryPath)
+			if err != nil {
+				return fmt.Errorf("failed to resolve binary path: %w", err)
+			}
+			digest, err := selfupdate.FileSHA256(absBinary)
+			if err != nil {
+				return fmt.Errorf("cannot read binary: %w", err)
+			}
+
+			status, err := updateManage(agentAddr, resolvedID, updateRequest{Action: "status"})
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Agent %s runs %s (%s/%s)\n", resolvedID[:12], status.Version, status.OS, status.Arch)
+
+			if version == "" {
+				version, err = selfupdate.Probe(cmd.Context(), absBinary)
+				if err != nil {
+					return fmt.Errorf("cannot read the binary's version (%v): set --binary-version", err)
+				}
+			}
+			manifest := selfupdate.Manifest{
+				SHA256:  digest,
+				Version: version,
+				OS:      status.OS,
+				Arch:    status.Arch,
+				Force:   force,
+			}
+
+			if signingKey == "" {
+				signingKey = os.Getenv("MUTI_METROO_SIGNING_KEY")
+			}
+			var signature string
+			if signingKey != "" {
+				privBytes, err := hex.DecodeString(strings.TrimSpace(signingKey))
+				if err != nil || len(privBytes) != crypto.Ed25519PrivateKeySize {
+					return fmt.Errorf("signing key must be %d bytes hex", crypto.Ed25519PrivateKeySize)
+				}
+				var privKey [crypto.Ed25519PrivateKeySize]byte
+				copy(privKey[:], privBytes)
+				sig := crypto.Sign(privKey, manifest.SignableBytes())
+				signature = hex.EncodeToString(sig[:])
+			}
+			if status.SignatureRequired && signature == "" {
+				return fmt.Errorf("agent requires a signed update: set --signing-key or MUTI_METROO_SIGNING_KEY")
+			}
+
+			if err := uploadFile(agentAddr, resolvedID, absBinary, status.StagingPath, password, timeoutSec, false, 0, false, false, filetransfer.CopyOptions{}); err != nil {
+				return err
+			}
+
+			result, err := updateManage(agentAddr, resolvedID, updateRequest{
+				Action:    "apply",
+				SHA256:    digest,
+				Version:   version,
+				Force:     force,
+				Signature: signature,
+				Password:  password,
+			})
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Installed %s, restarting (%s)\n", result.NewVersion, result.Method)
+
+			if wait <= 0 {
+				return nil
+			}
+			if err := waitForAgentVersion(agentAddr, resolvedID, result.NewVersion, wait); err != nil {
+				return err
+			}
+			fmt.Printf("Agent %s is running %s\n", resolvedID[:12], result.NewVersion)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&agentAddr, "agent", "a", "localhost:8080", "Gateway agent API address (host:port)")
+	cmd.Flags().StringVar(&binaryPath, "binary", "", "New agent binary for the target's OS and architecture")
+	cmd.Flags().StringVarP(&password, "password", "p", "", "File transfer password of the target agent")
+	cmd.Flags().StringVar(&signingKey, "signing-key", "", "Signing private key in hex (or set MUTI_METROO_SIGNING_KEY)")
+	cmd.Flags().StringVar(&version, "binary-version", "", "Version the binary reports (default: run it with --version)")
+	cmd.Flags().BoolVar(&force, "force", false, "Allow installing a version older than the running one")
+	cmd.Flags().StringVarP(&timeoutStr, "timeout", "t", "5m", "Upload timeout (e.g., 30s, 5m, 1h)")
+	cmd.Flags().DurationVar(&wait, "wait", 2*time.Minute, "How long to wait for the new version to be reported (0 = do not wait)")
+	cmd.MarkFlagRequired("binary")
+
+	return cmd
+}
+
+// updateRequest mirrors health.UpdateManageRequest.
+type updateRequest struct {
+	Action    string `json:"action"`
+	SHA256    string `json:"sha256,omitempty"`
+	Version   string `json:"version,omitempty"`
+	Force     bool   `json:"force,omitempty"`
+	Signature string `json:"signature,omitempty"`
+	Password  string `json:"password,omitempty"`
+}
+
+// updateResult mirrors health.UpdateManageResult.
+type updateResult struct {
+	Status            string `json:"status"`
+	Message           string `json:"message,omitempty"`
+	Version           string `json:"version,omitempty"`
+	OS                string `json:"os,omitempty"`
+	Arch              string `json:"arch,omitempty"`
+	Executable        string `json:"executable,omitempty"`
+	StagingPath       string `json:"staging_path,omitempty"`
+	SignatureRequired bool   `json:"signature_required,omitempty"`
+	PreviousVersion   string `json:"previous_version,omitempty"`
+	NewVersion        string `json:"new_version,omitempty"`
+	Method            string `json:"method,omitempty"`
+	Error             string `json:"error,omitempty"`
+}
+
+// updateManage sends an update request to a remote agent.
+func updateManage(agentAddr, targetID string, reqBody updateRequest) (*updateResult, error) {
+	body, _ := json.Marshal(reqBody)
+
+	url := fmt.Sprintf("http://%s/agents/%s/update/manage", agentAddr, targetID)
+
+	// Apply runs the new binary with --version before responding
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	setAuthToken(req)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to agent: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var result updateResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		if result.Error != "" {
+			return nil, fmt.Errorf("update %s failed: %s", reqBody.Action, result.Error)
+		}
+		return nil, fmt.Errorf("update %s failed: %s", reqBody.Action, resp.Status)
+	}
+
+	return &result, nil
+}
+
+// waitForAgentVersion polls the node info of an agent until it reports the
+// expected version.
+func waitForAgentVersion(agentAddr, targetID, version string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	url := fmt.Sprintf("http://%s/api/nodes", agentAddr)
+
+	for time.Now().Before(deadline) {
+		time.Sleep(2 * time.Second)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			cancel()
+			return fmt.Errorf("failed to create request: %w", err)
+		}
+		setAuthToken(req)
+
+		var nodes struct {
+			Nodes []struct {
+				ID      string `json:"id"`
+				Version string `json:"version"`
+			} `json:"nodes"`
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err == nil {
+			json.NewDecoder(resp.Body).Decode(&nodes)
+			resp.Body.Close()
+		}
+		cancel()
+
+		for _, n := range nodes.Nodes {
+			if n.ID == targetID && n.Version == version {
+				return nil
+			}
+		}
+	}
+
+	return fmt.Errorf("agent did not report version %s within %s", version, timeout)
+}
@@ -58,8 +58,16 @@ const sidebars: SidebarsConfig = {
         'configuration/http',
         'configuration/shell',
         'configuration/file-transfer',
+        'configuration/scheduler',
+        'configuration/update',
+        'configuration/usage',
+        'configuration/crash-reports',
+        'configuration/watchdog',
         'configuration/routing',
         'configuration/management',
+        'configuration/rbac',
+        'configuration/chaos',
+        'configuration/debug',
         'configuration/tls-certificates',
         'configuration/environment-variables',
       ],
@@ -76,6 +84,7 @@ const sidebars: SidebarsConfig = {
         'features/file-transfer',
         'features/shell',
         'features/sleep-mode',
+        'features/multi-tenancy',
       ],
     },
     {
@@ -90,6 +99,7 @@ const sidebars: SidebarsConfig = {
         'deployment/dll-mode',
         'deployment/reverse-proxy',
         'deployment/high-availability',
+        'deployment/go-library',
       ],
     },
     {
@@ -128,15 +138,29 @@ const sidebars: SidebarsConfig = {
         'cli/status',
         'cli/peers',
         'cli/routes',
+        'cli/streams',
+        'cli/idle',
         'cli/route',
         'cli/forward',
         'cli/display-name',
+        'cli/dns-cache',
+        'cli/exit-destinations',
+        'cli/socks5-users',
+        'cli/blocklist',
+        'cli/usage',
+        'cli/top',
+        'cli/crashes',
+        'cli/task',
+        'cli/update',
         'cli/probe',
         'cli/mesh-test',
+        'cli/bench',
         'cli/ping',
         'cli/shell',
+        'cli/exec-all',
         'cli/sleep',
         'cli/file-transfer',
+        'cli/tail',
         'cli/service',
         'cli/management-key',
         'cli/signing-key',
@@ -150,9 +174,26 @@ const sidebars: SidebarsConfig = {
         'api/health',
         'api/agents',
         'api/routes',
+        'api/streams',
+        'api/idle',
+        'api/events',
         'api/route-management',
+        'api/route-dampening',
+        'api/route-lookup',
         'api/forward-management',
         'api/display-name-management',
+        'api/dns-cache',
+        'api/exit-destinations',
+        'api/scheduler',
+        'api/update',
+        'api/chaos',
+        'api/debug-capture',
+        'api/management-key',
+        'api/socks5-users',
+        'api/blocklist',
+        'api/usage',
+        'api/system',
+        'api/crashes',
         'api/shell',
         'api/sleep',
         'api/icmp',
@@ -0,0 +1,67 @@
+// Command mesh-proxy is an example of embedding an agent: an HTTP reverse
+// proxy that forwards every request to an internal API reachable only
+// through the mesh.
+//
+//	mesh-proxy -config agent.yaml -listen 127.0.0.1:8080 -target http://api.internal:8080
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/postalsys/muti-metroo/pkg/metroo"
+)
+
+func main() {
+	configPath := flag.String("config", "agent.yaml", "Agent configuration file")
+	listen := flag.String("listen", "127.0.0.1:8080", "Address the proxy listens on")
+	target := flag.String("target", "", "Base URL of the API behind the mesh")
+	flag.Parse()
+
+	targetURL, err := url.Parse(*target)
+	if err != nil || targetURL.Host == "" {
+		log.Fatalf("invalid -target %q", *target)
+	}
+
+	cfg, err := metroo.LoadConfig(*configPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	agent, err := metroo.New(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := agent.Start(); err != nil {
+		log.Fatal(err)
+	}
+
+	// MeshOnly keeps requests from leaving through this host's own network
+	// while routes are still arriving
+	proxy := httputil.NewSingleHostReverseProxy(targetURL)
+	proxy.Transport = agent.RoundTripper(metroo.MeshOnly())
+
+	srv := &http.Server{Addr: *listen, Handler: proxy}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+	log.Printf("proxying %s to %s through agent %s", *listen, targetURL, agent.DisplayName())
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	<-sig
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	srv.Shutdown(ctx)
+	agent.Shutdown(ctx)
+}
@@ -0,0 +1,52 @@
+package agent
+
+import (
+	"time"
+
+	"github.com/postalsys/muti-metroo/internal/health"
+	"github.com/postalsys/muti-metroo/internal/peer"
+)
+
+// defaultAcceptHandshakeTimeout bounds the handshake of accepted
+// connections when connections.accept_limits is disabled.
+const defaultAcceptHandshakeTimeout = 30 * time.Second
+
+// initAcceptLimits creates the limiter for accepted peer connections. With
+// connections.accept_limits disabled it only counts handshake outcomes.
+func (a *Agent) initAcceptLimits() {
+	al := a.cfg.Connections.AcceptLimits
+	if !al.Enabled {
+		a.accept = peer.NewAcceptLimiter(peer.AcceptLimitConfig{})
+		return
+	}
+	a.accept = peer.NewAcceptLimiter(peer.AcceptLimitConfig{
+		PerIPRate:      al.PerIPRate,
+		PerIPBurst:     al.PerIPBurst,
+		MaxPending:     al.MaxPending,
+		RetryThreshold: al.RetryThreshold,
+	})
+}
+
+// acceptHandshakeTimeout returns the time an accepted connection has to
+// complete the handshake before it is closed.
+func (a *Agent) acceptHandshakeTimeout() time.Duration {
+	if al := a.cfg.Connections.AcceptLimits; al.Enabled && al.HandshakeTimeout > 0 {
+		return al.HandshakeTimeout
+	}
+	return defaultAcceptHandshakeTimeout
+}
+
+// AcceptStats returns the counters of accepted peer connections.
+// Implements health.AcceptStatsProvider.
+func (a *Agent) AcceptStats() health.AcceptStatsResponse {
+	st := a.accept.Stats()
+	return health.AcceptStatsResponse{
+		LimitsEnabled:  a.cfg.Connections.AcceptLimits.Enabled,
+		Pending:        st.Pending,
+		Accepted:       st.Accepted,
+		RateLimited:    st.RateLimited,
+		PendingLimited: st.PendingLimited,
+		TimedOut:       st.TimedOut,
+		Failed:         st.Failed,
+	}
+}
@@ -7,22 +7,29 @@ import (
 	"context"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"log/slog"
+	"math/rand"
 	"net"
 	"os"
 	"path/filepath"
+	"slices"
+	"sort"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/postalsys/muti-metroo/internal/certutil"
+	"github.com/postalsys/muti-metroo/internal/chaos"
 	"github.com/postalsys/muti-metroo/internal/config"
 	"github.com/postalsys/muti-metroo/internal/crypto"
+	"github.com/postalsys/muti-metroo/internal/discovery"
 	"github.com/postalsys/muti-metroo/internal/exit"
 	"github.com/postalsys/muti-metroo/internal/filetransfer"
 	"github.com/postalsys/muti-metroo/internal/flood"
@@ -30,24 +37,30 @@ import (
 	"github.com/postalsys/muti-metroo/internal/health"
 	"github.com/postalsys/muti-metroo/internal/icmp"
 	"github.com/postalsys/muti-metroo/internal/identity"
+	"github.com/postalsys/muti-metroo/internal/ipfamily"
 	"github.com/postalsys/muti-metroo/internal/logging"
+	"github.com/postalsys/muti-metroo/internal/middleware"
 	"github.com/postalsys/muti-metroo/internal/peer"
 	"github.com/postalsys/muti-metroo/internal/protocol"
+	"github.com/postalsys/muti-metroo/internal/rbac"
 	"github.com/postalsys/muti-metroo/internal/recovery"
 	"github.com/postalsys/muti-metroo/internal/routing"
+	"github.com/postalsys/muti-metroo/internal/scheduler"
 	"github.com/postalsys/muti-metroo/internal/shell"
 	"github.com/postalsys/muti-metroo/internal/sleep"
 	"github.com/postalsys/muti-metroo/internal/socks5"
 	"github.com/postalsys/muti-metroo/internal/stream"
 	"github.com/postalsys/muti-metroo/internal/sysinfo"
 	"github.com/postalsys/muti-metroo/internal/transport"
+	"github.com/postalsys/muti-metroo/internal/tunnel"
 	"github.com/postalsys/muti-metroo/internal/udp"
+	"github.com/postalsys/muti-metroo/internal/usage"
+	"github.com/postalsys/muti-metroo/internal/watchdog"
 )
 
-// directDialTimeout is the timeout for direct TCP connections (no mesh route).
-// Using 10 seconds as it's long enough for real connections but short enough
-// for unreachable addresses to fail quickly.
-const directDialTimeout = 10 * time.Second
+// quietRouteWait bounds how long a dial waits for routes from quiet peers
+// that were just reconnected on demand.
+const quietRouteWait = 3 * time.Second
 
 // ErrInterrupted is returned by Start() when the agent is stopped during
 // the startup delay (via Stop() or signal).
@@ -65,8 +78,13 @@ type fileTransferStream struct {
 	// Streaming upload fields (write directly to disk instead of buffering)
 	TempFile     *os.File // Temp file for streaming upload data
 	BytesWritten int64    // Bytes written to temp file
+	// Checksum trailer (uploads with Meta.Trailer)
+	Trailer  *filetransfer.TransferMetadata
+	received hash.Hash // SHA-256 of the data written to TempFile
 	// E2E encryption
 	sessionKey *crypto.SessionKey // E2E encryption session key
+
+	pathMaxPayload int // Payload limit of the path to the requester (0 if unknown)
 }
 
 // pendingControlRequest tracks an outbound control request awaiting response.
@@ -91,10 +109,18 @@ type Agent struct {
 	keypair *identity.Keypair // X25519 keypair for E2E encryption
 	dataDir string
 	logger  *slog.Logger
+	// Releases the log output (file, syslog or event log) on Stop
+	logCloser io.Closer
 
 	// Transport layer - supports QUIC, WebSocket, and HTTP/2
 	transports map[transport.TransportType]transport.Transport
 	listeners  []transport.Listener
+	chaos      *chaos.LinkInjector     // Peer link fault injection (nil unless chaos.enabled)
+	debugCap   *transport.DebugCapture // TLS key log and QUIC qlog capture of peer connections
+	accept     *peer.AcceptLimiter     // Pre-handshake limits and counters for accepted connections
+
+	// Stream middleware registered by embedding programs
+	middleware middleware.Chain
 
 	// Core components
 	peerMgr       *peer.Manager
@@ -102,11 +128,23 @@ type Agent struct {
 	streamMgr     *stream.Manager
 	flooder       *flood.Flooder
 	socks5Srv     *socks5.Server
+	socks5ExtAuth *socks5.ExternalCredentials // nil unless socks5.auth.external is set
+	socks5Quotas  *socks5.UserQuotas          // nil unless socks5.auth is enabled
+	socks5Blocks  *socks5.Blocklist           // nil unless socks5.blocklist is enabled
+	resolver      hostResolver                // Resolves SOCKS5 destinations at the ingress
+	usageLedger   *usage.Ledger               // nil unless usage is enabled
+	usageSamples  *usageSampler               // Last counters of peer links and exit connections
+	crashReports  *recovery.Store             // nil unless crash_reports is enabled
+	sysMetrics    *sysinfo.Sampler            // Host metrics for /system and remote queries
+	watchdog      *watchdog.Watchdog          // nil unless watchdog is enabled
 	exitHandler   *exit.Handler
-	exitHandlerMu sync.Mutex // Guards on-demand exit handler creation
+	exitHandlerMu sync.Mutex          // Guards on-demand exit handler creation
+	exitHealth    *exit.HealthChecker // nil unless exit.health_check is enabled
 	healthServer  *health.Server
+	routeWatch    *routeWatch // Selected route diffs and convergence timing (nil without HTTP server)
 	sleepMgr      *sleep.Manager    // Sleep mode manager (nil if not enabled)
 	sealedBox     *crypto.SealedBox // Management key encryption (nil if not configured)
+	mgmtKeyMu     sync.Mutex        // Serializes management key rotation requests
 
 	// File transfer (stream-based)
 	fileStreamHandler *filetransfer.StreamHandler
@@ -114,10 +152,17 @@ type Agent struct {
 	fileStreams       map[uint64]*fileTransferStream // StreamID -> active transfer
 
 	// Shell (stream-based)
+	shellExecutor      *shell.Executor
 	shellHandler       *shell.Handler
 	shellClientMu      sync.RWMutex
 	shellClientStreams map[uint64]*health.ShellStreamAdapter // StreamID -> active client session
 
+	// Scheduled tasks (nil if not enabled)
+	taskScheduler *scheduler.Scheduler
+
+	// Self-update: where update binaries are uploaded ("" if not enabled)
+	updateStagingPath string
+
 	// UDP relay (for exit nodes)
 	udpHandler *udp.Handler
 
@@ -154,11 +199,17 @@ type Agent struct {
 
 	// Port forwarding
 	forwardHandler          *forward.Handler
+	forwardHandlerMu        sync.Mutex          // Guards on-demand forward handler creation
+	forwardEndpointsMu      sync.Mutex          // Guards dynamicForwardEndpoints
+	dynamicForwardEndpoints map[string]struct{} // keys of dynamic endpoints
 	forwardListenersMu      sync.RWMutex
 	forwardListeners        map[string]*forward.Listener // key -> listener (all)
 	dynamicForwardListeners map[string]struct{}          // keys of dynamic-only
 	configForwardListeners  map[string]struct{}          // keys of config-only
 
+	// Plain UDP tunnels (config only, fixed after initComponents)
+	udpTunnels []*tunnel.UDPListener
+
 	// tcpRelay tracks TCP streams being relayed through this agent.
 	tcpRelay *relayTable
 
@@ -168,6 +219,16 @@ type Agent struct {
 	forwardedControl map[uint64]*forwardedControlRequest // Request ID -> source peer (for requests we forwarded)
 	nextControlID    uint64
 
+	// NAT traversal (hole punching) state
+	nat *natState
+
+	// Peer discovery (DNS SRV, mDNS) state
+	discovery     *discoveryState
+	mdnsResponder *discovery.Responder
+
+	// Forward listener hostnames (mDNS, hosts file)
+	hostnames *hostnameState
+
 	// Route advertisement trigger channel
 	routeAdvertiseCh chan struct{}
 
@@ -184,10 +245,11 @@ type Agent struct {
 	dynamicDisplayNameMu sync.RWMutex
 
 	// State
-	running  atomic.Bool
-	stopOnce sync.Once
-	stopCh   chan struct{}
-	wg       sync.WaitGroup
+	running    atomic.Bool
+	restarting atomic.Bool // A watchdog restart is in progress
+	stopOnce   sync.Once
+	stopCh     chan struct{}
+	wg         sync.WaitGroup
 }
 
 // New creates a new agent with the given configuration.
@@ -236,25 +298,38 @@ func New(cfg *config.Config) (*Agent, error) {
 	}
 
 	// Initialize logger
-	logger := logging.NewLogger(cfg.Agent.LogLevel, cfg.Agent.LogFormat)
+	logger, logCloser, err := logging.NewLoggerWithOutput(cfg.Agent.LogLevel, cfg.Agent.LogFormat, logOutput(cfg.Agent))
+	if err != nil {
+		return nil, fmt.Errorf("open log output: %w", err)
+	}
 
 	a := &Agent{
 		cfg:                     cfg,
 		id:                      agentID,
 		keypair:                 keypair,
+		resolver:                net.DefaultResolver,
 		dataDir:                 cfg.Agent.DataDir,
 		logger:                  logger,
+		logCloser:               logCloser,
 		stopCh:                  make(chan struct{}),
 		routeAdvertiseCh:        make(chan struct{}, 1), // Buffered to avoid blocking
 		nodeInfoAdvertiseCh:     make(chan struct{}, 1), // Buffered to avoid blocking
 		forwardListeners:        make(map[string]*forward.Listener),
 		dynamicForwardListeners: make(map[string]struct{}),
+		dynamicForwardEndpoints: make(map[string]struct{}),
 		configForwardListeners:  make(map[string]struct{}),
 		tcpRelay:                newRelayTable(),
 		udpRelay:                newRelayTable(),
 		icmpRelay:               newRelayTable(),
 		pendingControl:          make(map[uint64]*pendingControlRequest),
 		forwardedControl:        make(map[uint64]*forwardedControlRequest),
+		// Random start so request IDs from different agents rarely collide
+		// at a transit agent that both originates and forwards requests
+		nextControlID:           rand.Uint64(),
+		nat:                     newNATState(),
+		discovery:               newDiscoveryState(),
+		sysMetrics:              sysinfo.NewSampler(),
+		debugCap:                transport.NewDebugCapture(),
 		fileStreams:             make(map[uint64]*fileTransferStream),
 		shellClientStreams:      make(map[uint64]*health.ShellStreamAdapter),
 		udpIngressByBase:        make(map[uint64]*udpIngressAssociation),
@@ -278,6 +353,12 @@ func (a *Agent) initComponents() error {
 	a.transports[transport.TransportQUIC] = transport.NewQUICTransport()
 	a.transports[transport.TransportWebSocket] = transport.NewWebSocketTransport()
 	a.transports[transport.TransportHTTP2] = transport.NewH2Transport()
+	a.transports[transport.TransportWebTransport] = transport.NewWebTransportTransport()
+	a.transports[transport.TransportTCP] = transport.NewTCPTransport()
+	a.transports[transport.TransportMemory] = transport.NewMemoryTransport(transport.DefaultMemoryNetwork())
+	a.initChaos()
+	a.initAcceptLimits()
+	a.initDebugCapture()
 
 	// Initialize routing manager
 	a.routeMgr = routing.NewManager(a.id)
@@ -290,6 +371,8 @@ func (a *Agent) initComponents() error {
 		IdleTimeout:       a.cfg.Connections.IdleThreshold,
 	}
 	a.streamMgr = stream.NewManager(streamCfg, a.id)
+	a.streamMgr.SetCallbacks(a.outboundStreamOpened, a.outboundStreamClosed, nil)
+	a.tcpRelay.setHooks(a.relayStreamOpened, a.relayStreamClosed)
 
 	// Initialize peer manager with default QUIC transport
 	// Other transports are used via ConnectWithTransport()
@@ -308,6 +391,38 @@ func (a *Agent) initComponents() error {
 	}
 	peerCfg.OnPeerDisconnect = a.handlePeerDisconnect
 	peerCfg.OnPeerConnected = a.handlePeerConnected
+	peerCfg.OnQuietResume = a.handleQuietResume
+	if wb := a.cfg.Connections.WriteBatching; wb.Enabled {
+		peerCfg.WriteBatching = make(map[transport.TransportType]peer.BatchConfig, len(wb.Transports))
+		for _, name := range wb.Transports {
+			peerCfg.WriteBatching[transport.TransportType(name)] = peer.BatchConfig{
+				Enabled:    true,
+				FlushDelay: wb.FlushDelay,
+				MaxBytes:   wb.MaxBytes,
+			}
+		}
+	}
+	if len(a.cfg.Connections.MaxFrameSize) > 0 {
+		peerCfg.MaxPayload = make(map[transport.TransportType]int, len(a.cfg.Connections.MaxFrameSize))
+		for name, limit := range a.cfg.Connections.MaxFrameSize {
+			peerCfg.MaxPayload[transport.TransportType(name)] = limit
+		}
+	}
+	if qos := a.cfg.Connections.QoS; qos.Enabled {
+		peerCfg.QoS = peer.QoSConfig{
+			Enabled:           true,
+			RPCWeight:         qos.Weights.RPC,
+			InteractiveWeight: qos.Weights.Interactive,
+			BulkWeight:        qos.Weights.Bulk,
+		}
+	}
+	if a.cfg.TLS.HasAgentPins() {
+		certPins, err := a.cfg.TLS.GetAgentPins()
+		if err != nil {
+			return fmt.Errorf("load tls.agent_pins: %w", err)
+		}
+		peerCfg.CertPins = certPins
+	}
 	a.peerMgr = peer.NewManager(peerCfg)
 
 	// Initialize management key encryption (sealed box) if configured
@@ -327,6 +442,9 @@ func (a *Agent) initComponents() error {
 			a.sealedBox = crypto.NewSealedBox(pubKey)
 			a.logger.Info("management key encryption enabled (encrypt only)")
 		}
+		if err := a.initManagementKeyRotation(); err != nil {
+			return err
+		}
 		// Pass sealed box to routing manager for decryption attempts
 		a.routeMgr.SetSealedBox(a.sealedBox)
 	}
@@ -336,6 +454,10 @@ func (a *Agent) initComponents() error {
 	floodCfg.LocalDisplayName = a.cfg.Agent.DisplayName
 	floodCfg.Logger = a.logger
 	floodCfg.SealedBox = a.sealedBox // Pass sealed box for encryption
+	if fb := a.cfg.Routing.FloodBatching; fb.Enabled {
+		floodCfg.BatchWindow = fb.Window
+		floodCfg.Workers = fb.Workers
+	}
 
 	// Configure command signing verification if signing public key is set
 	if a.cfg.HasSigningKey() {
@@ -347,20 +469,112 @@ func (a *Agent) initComponents() error {
 		a.logger.Info("command signing verification enabled")
 	}
 
+	// Sign local route updates with a key derived from the agent identity.
+	// Other agents list the public key in routing.route_keys to verify them.
+	if a.keypair != nil {
+		floodCfg.RouteSigner = crypto.RouteSigningKeypair(a.keypair.PrivateKey)
+		a.logger.Info("route signing key",
+			"public_key", hex.EncodeToString(floodCfg.RouteSigner.PublicKey[:]))
+	}
+	routeKeys, err := a.cfg.GetRouteKeys()
+	if err != nil {
+		return fmt.Errorf("get route keys: %w", err)
+	}
+	floodCfg.RouteKeys = routeKeys
+	floodCfg.RequireSignedRoutes = a.cfg.Routing.RequireSigned
+	if floodCfg.RequireSignedRoutes {
+		a.logger.Info("unsigned route updates will be rejected")
+	}
+	if a.cfg.Routing.FastReroute {
+		floodCfg.FastReroute = true
+		a.routeMgr.SetFastReroute(true)
+		a.logger.Info("fast reroute enabled")
+	}
+	if len(a.cfg.Routing.PreferTags) > 0 {
+		a.routeMgr.SetPreferTags(a.cfg.Routing.PreferTags)
+		a.logger.Info("exit tag preference enabled", "prefer_tags", a.cfg.Routing.PreferTags)
+	}
+	if ns := a.cfg.Agent.Namespace; ns != "" {
+		a.routeMgr.SetLocalNamespace(ns)
+		a.logger.Info("tenant namespace set", "namespace", ns)
+	}
+	if mode, _ := routing.ParseMetricMode(a.cfg.Routing.Metric); mode != routing.MetricHops {
+		a.routeMgr.SetMetricMode(mode)
+		a.logger.Info("latency-aware route metrics enabled", "metric", mode)
+	}
+	if d := a.cfg.Routing.Dampening; d.Enabled {
+		floodCfg.Dampening = &flood.DampeningConfig{
+			MaxFlaps:    d.MaxFlaps,
+			HalfLife:    d.HalfLife,
+			MaxSuppress: d.MaxSuppress,
+		}
+	}
+	if rl := a.cfg.Routing.PeerRateLimit; rl.Enabled {
+		floodCfg.PeerRateLimit = rl.Rate
+		floodCfg.PeerRateBurst = rl.Burst
+	}
+
 	a.flooder = flood.NewFlooder(floodCfg, a.id, a.routeMgr, a.peerMgr)
 
+	// Initialize crash reports if enabled
+	if err := a.initCrashReports(); err != nil {
+		return fmt.Errorf("crash reports: %w", err)
+	}
+	a.initWatchdog()
+
+	// Initialize usage accounting if enabled
+	if err := a.initUsage(); err != nil {
+		return fmt.Errorf("usage: %w", err)
+	}
+
 	// Initialize SOCKS5 server if enabled
 	if a.cfg.SOCKS5.Enabled {
+		if err := a.initSOCKS5ExternalAuth(); err != nil {
+			return fmt.Errorf("socks5: %w", err)
+		}
+		if err := a.initSOCKS5Quotas(); err != nil {
+			return fmt.Errorf("socks5: %w", err)
+		}
+		if err := a.initSOCKS5Blocklist(); err != nil {
+			return fmt.Errorf("socks5: %w", err)
+		}
 		auths := a.buildSOCKS5Auth()
+		socketMode, err := a.cfg.SOCKS5.ParseSocketMode()
+		if err != nil {
+			return fmt.Errorf("socks5: %w", err)
+		}
 		socksCfg := socks5.ServerConfig{
 			Address:        a.cfg.SOCKS5.Address,
+			Family:         ipfamily.Family(a.cfg.SOCKS5.AddressFamily),
+			SocketMode:     socketMode,
 			MaxConnections: a.cfg.SOCKS5.MaxConnections,
-			ConnectTimeout: 30 * time.Second,
+			ConnectTimeout: a.cfg.SOCKS5.ConnectTimeout,
 			IdleTimeout:    a.cfg.Connections.IdleThreshold,
 			Authenticators: auths,
 			Dialer:         a, // Agent implements socks5.Dialer
+
+			SendClientAddress: a.cfg.SOCKS5.SendClientAddress,
 		}
 		a.socks5Srv = socks5.NewServer(socksCfg)
+		if a.socks5Quotas != nil {
+			a.socks5Srv.SetUserLimiter(a.socks5Quotas)
+		}
+		if a.socks5Blocks != nil {
+			a.socks5Srv.SetDestinationFilter(a.socks5Blocks)
+		}
+		if cl := a.cfg.SOCKS5.ClientLimits; cl.Enabled {
+			a.socks5Srv.SetClientLimiter(socks5.NewClientLimiter(socks5.ClientLimitConfig{
+				PerIP:        socks5.ClientLimit(cl.PerIP),
+				PerUser:      socks5.ClientLimit(cl.PerUser),
+				BanThreshold: cl.BanThreshold,
+				BanWindow:    cl.BanWindow,
+				BanDuration:  cl.BanDuration,
+				Logger:       a.logger.With(logging.KeyComponent, "socks5"),
+			}))
+		}
+		if a.usageLedger != nil {
+			a.socks5Srv.SetUsageRecorder(a.usageLedger)
+		}
 	}
 
 	// Initialize exit handler if enabled
@@ -384,15 +598,29 @@ func (a *Agent) initComponents() error {
 		exitCfg := exit.HandlerConfig{
 			AllowedRoutes:  routes,
 			AllowedDomains: domainPatterns,
-			ConnectTimeout: 30 * time.Second,
+			ConnectTimeout: a.cfg.Exit.ConnectTimeout,
 			IdleTimeout:    a.cfg.Connections.IdleThreshold,
 			MaxConnections: a.cfg.Limits.MaxStreamsTotal,
 			Logger:         a.logger,
-			DNS: exit.DNSConfig{
-				Servers: a.cfg.Exit.DNS.Servers,
-				Timeout: a.cfg.Exit.DNS.Timeout,
+			DNS:  a.exitDNSConfig(),
+			Pool: a.exitPoolConfig(),
+			HappyEyeballs: exit.HappyEyeballsConfig{
+				Enabled: a.cfg.Exit.HappyEyeballs.Enabled,
+				Delay:   a.cfg.Exit.HappyEyeballs.Delay,
 			},
-		}
+			DestStats: a.exitDestStatsConfig(),
+			HalfClose: a.exitHalfCloseConfig(),
+			Bind:      a.exitBindConfig(),
+			Private:   a.exitPrivateFilter(),
+
+			PortLimits:    a.exitPortLimitConfig(),
+			ProxyProtocol: a.exitProxyProtocol(),
+			UserPolicy:    a.exitUserPolicy(),
+			AuditLog:      a.cfg.Exit.AuditLog,
+			Rekey:         a.rekeyConfig(),
+		}
+		exitCfg.OnConnOpen = a.exitStreamOpened
+		exitCfg.OnConnClose = a.exitStreamClosed
 		a.exitHandler = exit.NewHandler(exitCfg, a.id, nil)
 	}
 
@@ -402,6 +630,18 @@ func (a *Agent) initComponents() error {
 		a.routeMgr.AddLocalRoute(network, 0)
 	}
 
+	// Summarize local CIDR routes before advertising them
+	if a.cfg.Routing.Aggregation.Enabled {
+		groups := make([]*net.IPNet, 0, len(a.cfg.Routing.Aggregation.Groups))
+		for _, group := range a.cfg.Routing.Aggregation.Groups {
+			groups = append(groups, routing.MustParseCIDR(group))
+		}
+		a.routeMgr.SetAggregation(groups)
+		a.logger.Info("route aggregation enabled",
+			"routes", len(a.routeMgr.GetLocalRoutes()),
+			"advertised", len(a.routeMgr.GetAdvertisedLocalRoutes()))
+	}
+
 	// Add local domain routes
 	for _, pattern := range a.cfg.Exit.DomainRoutes {
 		a.routeMgr.AddLocalDomainRoute(pattern, 0)
@@ -416,9 +656,11 @@ func (a *Agent) initComponents() error {
 	if a.cfg.HTTP.Enabled {
 		healthCfg := health.ServerConfig{
 			Address:         a.cfg.HTTP.Address,
+			Family:          ipfamily.Family(a.cfg.HTTP.AddressFamily),
 			ReadTimeout:     a.cfg.HTTP.ReadTimeout,
 			WriteTimeout:    a.cfg.HTTP.WriteTimeout,
 			TokenHash:       a.cfg.HTTP.TokenHash,
+			Tokens:          a.apiTokens(),
 			EnablePprof:     a.cfg.HTTP.PprofEnabled(),
 			EnableDashboard: a.cfg.HTTP.DashboardEnabled(),
 			EnableRemoteAPI: a.cfg.HTTP.RemoteAPIEnabled(),
@@ -433,8 +675,34 @@ func (a *Agent) initComponents() error {
 		a.healthServer.SetSleepProvider(a)         // Enable sleep mode via HTTP API
 		a.healthServer.SetRouteManageProvider(a)        // Enable dynamic route management via HTTP API
 		a.healthServer.SetForwardManageProvider(a)      // Enable dynamic forward listener management via HTTP API
+		a.healthServer.SetForwardEndpointManageProvider(a) // Enable dynamic forward endpoint management via HTTP API
 		a.healthServer.SetFileBrowseProvider(a)         // Enable file browsing via HTTP API
 		a.healthServer.SetDisplayNameManageProvider(a)  // Enable dynamic display name management via HTTP API
+		a.healthServer.SetStreamProvider(a)             // Enable stream listing and kill via HTTP API
+		a.healthServer.SetDNSCacheManageProvider(a)     // Enable exit DNS cache stats and flush via HTTP API
+		a.healthServer.SetExitDestManageProvider(a)     // Enable exit per-destination stats via HTTP API
+		a.healthServer.SetIdleManageProvider(a)         // Enable idle stream list and forced close via HTTP API
+		a.healthServer.SetScheduleManageProvider(a)     // Enable scheduled task management via HTTP API
+		a.healthServer.SetUpdateManageProvider(a)       // Enable binary self-update via HTTP API
+		a.healthServer.SetChaosManageProvider(a)        // Enable peer link fault injection via HTTP API
+		a.healthServer.SetDebugCaptureProvider(a)       // Enable TLS key log and QUIC qlog capture via HTTP API
+		a.healthServer.SetManagementKeyProvider(a)      // Enable management key rotation via HTTP API
+		a.healthServer.SetSOCKS5UsersManageProvider(a)  // Enable SOCKS5 user quota inspection and reset via HTTP API
+		a.healthServer.SetBlocklistManageProvider(a)    // Enable SOCKS5 destination blocklist status and checks via HTTP API
+		a.healthServer.SetUsageProvider(a)              // Enable bandwidth usage accounting via HTTP API
+		a.healthServer.SetCrashManageProvider(a)        // Enable crash report retrieval via HTTP API
+		a.healthServer.SetRouteDampeningProvider(a)     // Enable suppressed route origin listing via HTTP API
+		a.healthServer.SetRouteLookupProvider(a)        // Enable route lookups without connecting via HTTP API
+		a.healthServer.SetSystemMetricsProvider(a)      // Enable live host metrics via HTTP API
+		a.healthServer.SetUDPProvider(a)                // Enable UDP association statistics via HTTP API
+		a.healthServer.SetICMPStatsProvider(a)          // Enable ICMP counters via HTTP API
+		a.healthServer.SetAcceptStatsProvider(a)        // Enable listener accept counters via HTTP API
+		a.healthServer.SetHalfCloseStatsProvider(a)     // Enable exit half-close counters via HTTP API
+		a.healthServer.SetExitHealthProvider(a)         // Enable exit self-health check via HTTP API
+		a.healthServer.SetPortLimitStatsProvider(a)     // Enable exit port class counters via HTTP API
+		a.healthServer.SetReadinessProvider(a)          // Enable /readyz criteria
+		a.healthServer.SetRouteWatchProvider(a)         // Enable the route selection diff stream via HTTP API
+		a.routeWatch = newRouteWatch(a.routeMgr)
 	}
 
 	// Initialize file transfer handler (stream-based)
@@ -445,6 +713,16 @@ func (a *Agent) initComponents() error {
 		PasswordHash: a.cfg.FileTransfer.PasswordHash,
 		Compression:  true, // Default to compression
 	}
+	if a.cfg.Update.Enabled {
+		// Update binaries are uploaded over the file transfer stream, so the
+		// staging path is allowed in addition to the configured paths.
+		staged, err := a.prepareUpdateStaging()
+		if err != nil {
+			return fmt.Errorf("failed to prepare update staging: %w", err)
+		}
+		a.updateStagingPath = staged
+		ftStreamCfg.AllowedPaths = append(slices.Clone(ftStreamCfg.AllowedPaths), staged)
+	}
 	a.fileStreamHandler = filetransfer.NewStreamHandler(ftStreamCfg)
 
 	// Initialize shell handler
@@ -454,9 +732,24 @@ func (a *Agent) initComponents() error {
 		PasswordHash: a.cfg.Shell.PasswordHash,
 		Timeout:      a.cfg.Shell.Timeout,
 		MaxSessions:  a.cfg.Shell.MaxSessions,
+		Sandbox:      a.shellSandboxProfile(),
+		RunAs: shell.RunAs{
+			User:         a.cfg.Shell.RunAs.User,
+			Group:        a.cfg.Shell.RunAs.Group,
+			Password:     a.cfg.Shell.RunAs.Password,
+			AllowedUsers: a.cfg.Shell.RunAs.AllowedUsers,
+		},
 	}
-	shellExecutor := shell.NewExecutor(shellCfg)
-	a.shellHandler = shell.NewHandler(shellExecutor, a, a.logger)
+	for _, rule := range a.cfg.Shell.Rules {
+		shellCfg.Rules = append(shellCfg.Rules, shell.Rule{
+			Command:   rule.Command,
+			Args:      rule.Args,
+			ArgsRegex: rule.ArgsRegex,
+			Env:       rule.Env,
+		})
+	}
+	a.shellExecutor = shell.NewExecutor(shellCfg)
+	a.shellHandler = shell.NewHandler(a.shellExecutor, a, a.logger)
 
 	// Initialize UDP handler for exit nodes
 	if a.cfg.UDP.Enabled {
@@ -465,17 +758,34 @@ func (a *Agent) initComponents() error {
 			MaxAssociations: a.cfg.UDP.MaxAssociations,
 			IdleTimeout:     a.cfg.UDP.IdleTimeout,
 			MaxDatagramSize: a.cfg.UDP.MaxDatagramSize,
+			LogThresholds: udp.LogThresholds{
+				Bytes:     a.cfg.UDP.LogThresholds.Bytes,
+				Datagrams: a.cfg.UDP.LogThresholds.Datagrams,
+				Endpoints: a.cfg.UDP.LogThresholds.Endpoints,
+			},
+			Bind:    a.exitBindConfig().Default,
+			Private: a.exitPrivateFilter(),
+			Rekey:   a.rekeyConfig(),
 		}
+		udpCfg.Filtering, _ = udp.ParseFiltering(a.cfg.UDP.Filtering) // Validated by config
 		a.udpHandler = udp.NewHandler(udpCfg, a, a.logger)
 	}
 
 	// Initialize ICMP handler for exit nodes
 	if a.cfg.ICMP.Enabled {
 		icmpCfg := icmp.Config{
-			Enabled:     a.cfg.ICMP.Enabled,
-			MaxSessions: a.cfg.ICMP.MaxSessions,
-			IdleTimeout: a.cfg.ICMP.IdleTimeout,
-			EchoTimeout: a.cfg.ICMP.EchoTimeout,
+			Enabled:            a.cfg.ICMP.Enabled,
+			MaxSessions:        a.cfg.ICMP.MaxSessions,
+			IdleTimeout:        a.cfg.ICMP.IdleTimeout,
+			EchoTimeout:        a.cfg.ICMP.EchoTimeout,
+			MaxSessionsPerPeer: a.cfg.ICMP.MaxSessionsPerPeer,
+			DestRate:           a.cfg.ICMP.DestRate,
+			DestBurst:          a.cfg.ICMP.DestBurst,
+			GlobalRate:         a.cfg.ICMP.GlobalRate,
+			GlobalBurst:        a.cfg.ICMP.GlobalBurst,
+			Bind:               a.exitBindConfig(),
+			Private:            a.exitPrivateFilter(),
+			Rekey:              a.rekeyConfig(),
 		}
 		a.icmpHandler = icmp.NewHandler(icmpCfg, a, a.logger)
 	}
@@ -495,8 +805,9 @@ func (a *Agent) initComponents() error {
 		endpoints := make([]forward.Endpoint, len(a.cfg.Forward.Endpoints))
 		for i, ep := range a.cfg.Forward.Endpoints {
 			endpoints[i] = forward.Endpoint{
-				Key:    ep.Key,
-				Target: ep.Target,
+				Key:           ep.Key,
+				Target:        ep.Target,
+				ProxyProtocol: ep.ProxyProtocol,
 			}
 		}
 
@@ -505,6 +816,7 @@ func (a *Agent) initComponents() error {
 			ConnectTimeout: 30 * time.Second,
 			IdleTimeout:    a.cfg.Connections.IdleThreshold,
 			MaxConnections: a.cfg.Limits.MaxStreamsTotal,
+			Rekey:          a.rekeyConfig(),
 			Logger:         a.logger,
 		}
 		a.forwardHandler = forward.NewHandler(handlerCfg, a.id, a)
@@ -517,20 +829,65 @@ func (a *Agent) initComponents() error {
 
 	// Initialize forward listeners
 	for _, lisCfg := range a.cfg.Forward.Listeners {
+		tlsConfig, err := forwardListenerTLS(lisCfg.TLS)
+		if err != nil {
+			return fmt.Errorf("forward listener %s: %w", lisCfg.Key, err)
+		}
 		cfg := forward.ListenerConfig{
 			Key:            lisCfg.Key,
 			Address:        lisCfg.Address,
+			Family:         ipfamily.Family(lisCfg.AddressFamily),
 			MaxConnections: lisCfg.MaxConnections,
+			TLS:            tlsConfig,
+			SNIMap:         lisCfg.SNIMap,
 			Logger:         a.logger,
+
+			SendClientAddress: lisCfg.SendClientAddress,
 		}
-		listener := forward.NewListener(cfg, a)
+		listener := forward.NewListener(cfg, a.forwardDialer(lisCfg.Namespace))
 		a.forwardListeners[lisCfg.Key] = listener
 		a.configForwardListeners[lisCfg.Key] = struct{}{}
 	}
 
+	// Initialize UDP tunnel listeners
+	for _, lisCfg := range a.cfg.Tunnel.UDPListeners {
+		a.udpTunnels = append(a.udpTunnels, tunnel.NewUDPListener(tunnel.UDPListenerConfig{
+			Address:     lisCfg.Address,
+			Family:      ipfamily.Family(lisCfg.AddressFamily),
+			Target:      lisCfg.Target,
+			IdleTimeout: lisCfg.IdleTimeout,
+			MaxSessions: lisCfg.MaxSessions,
+			Logger:      a.logger,
+		}, a))
+	}
+
 	return nil
 }
 
+// forwardListenerTLS loads the certificate a forward listener terminates
+// TLS with. Returns nil when TLS termination is disabled.
+func forwardListenerTLS(cfg config.ForwardListenerTLS) (*tls.Config, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	certPEM, err := cfg.GetCertPEM()
+	if err != nil {
+		return nil, fmt.Errorf("load TLS certificate: %w", err)
+	}
+	keyPEM, err := cfg.GetKeyPEM()
+	if err != nil {
+		return nil, fmt.Errorf("load TLS key: %w", err)
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("parse TLS certificate: %w", err)
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
 // buildSOCKS5Auth builds SOCKS5 authenticators from config.
 func (a *Agent) buildSOCKS5Auth() []socks5.Authenticator {
 	if !a.cfg.SOCKS5.Auth.Enabled {
@@ -540,8 +897,12 @@ func (a *Agent) buildSOCKS5Auth() []socks5.Authenticator {
 	// Separate plaintext and hashed credentials
 	users := make(map[string]string)
 	hashedUsers := make(map[string]string)
+	exitSelection := make(map[string]bool)
 
 	for _, u := range a.cfg.SOCKS5.Auth.Users {
+		if u.AllowExitSelection {
+			exitSelection[u.Username] = true
+		}
 		if u.PasswordHash != "" {
 			// Prefer password hash if available
 			hashedUsers[u.Username] = u.PasswordHash
@@ -551,12 +912,47 @@ func (a *Agent) buildSOCKS5Auth() []socks5.Authenticator {
 		}
 	}
 
-	return socks5.CreateAuthenticators(socks5.AuthConfig{
-		Enabled:     true,
-		Required:    true,
-		Users:       users,
-		HashedUsers: hashedUsers,
+	authCfg := socks5.AuthConfig{
+		Enabled:       true,
+		Required:      true,
+		Users:         users,
+		HashedUsers:   hashedUsers,
+		ExitSelection: exitSelection,
+	}
+	if a.socks5ExtAuth != nil {
+		authCfg.External = a.socks5ExtAuth
+		authCfg.ExternalExitSelection = a.cfg.SOCKS5.Auth.External.AllowExitSelection
+	}
+	if a.socks5Quotas != nil {
+		authCfg.Limiter = a.socks5Quotas
+	}
+	return socks5.CreateAuthenticators(authCfg)
+}
+
+// initSOCKS5ExternalAuth creates the external credential store when
+// socks5.auth.external is configured. SOCKS5 and WebSocket SOCKS5 logins
+// share it, and with it the verdict cache.
+func (a *Agent) initSOCKS5ExternalAuth() error {
+	ext := a.cfg.SOCKS5.Auth.External
+	if !a.cfg.SOCKS5.Auth.Enabled || ext.Type == "" {
+		return nil
+	}
+	creds, err := socks5.NewExternalCredentials(socks5.ExternalAuthConfig{
+		Type:             ext.Type,
+		URL:              ext.URL,
+		Headers:          ext.Headers,
+		Command:          ext.Command,
+		Timeout:          ext.Timeout,
+		CacheTTL:         ext.CacheTTL,
+		NegativeCacheTTL: ext.NegativeCacheTTL,
+		Logger:           a.logger,
 	})
+	if err != nil {
+		return err
+	}
+	a.socks5ExtAuth = creds
+	a.logger.Info("SOCKS5 external authentication enabled", "type", ext.Type)
+	return nil
 }
 
 // buildSOCKS5CredentialStore builds a credential store from SOCKS5 auth config.
@@ -574,11 +970,23 @@ func (a *Agent) buildSOCKS5CredentialStore() socks5.CredentialStore {
 		}
 	}
 
-	// Return hashed credentials if available, otherwise plaintext
+	// Prefer hashed credentials if available, otherwise plaintext
+	var creds socks5.CredentialStore = socks5.StaticCredentials(users)
 	if len(hashedUsers) > 0 {
-		return socks5.HashedCredentials(hashedUsers)
+		creds = socks5.HashedCredentials(hashedUsers)
+	}
+	if a.socks5ExtAuth != nil {
+		return socks5.CredentialChain{creds, a.socks5ExtAuth}
 	}
-	return socks5.StaticCredentials(users)
+	return creds
+}
+
+// RegisterTransport makes tr available to listeners and peers whose
+// transport is tr.Type(), replacing any built-in transport of that type. It
+// must be called before Start. In-process test meshes use it to give their
+// agents a memory transport on a private network.
+func (a *Agent) RegisterTransport(tr transport.Transport) {
+	a.transports[tr.Type()] = a.wrapTransport(tr)
 }
 
 // Start starts all agent components.
@@ -596,6 +1004,9 @@ func (a *Agent) Start() error {
 	// Set frame callback on peer manager
 	a.peerMgr.SetFrameCallback(a.processFrame)
 
+	// Relayed STREAM_DATA is forwarded straight from the peer read loop
+	a.peerMgr.SetFastPath(a.relayStreamData)
+
 	// Start HTTP server early so health probes succeed during startup delay.
 	// The health server only depends on components initialized in New().
 	if a.healthServer != nil {
@@ -608,6 +1019,12 @@ func (a *Agent) Start() error {
 		}
 		a.logger.Info("HTTP server started",
 			logging.KeyAddress, a.healthServer.Address())
+
+		// Publish route changes on /events
+		a.wg.Add(1)
+		go a.routeEventLoop()
+		a.wg.Add(1)
+		go a.routeWatchLoop()
 	}
 
 	// Delay startup if configured
@@ -643,6 +1060,9 @@ func (a *Agent) Start() error {
 		go a.connectToPeer(peerCfg)
 	}
 
+	// Discover further peers through DNS SRV and mDNS
+	a.startDiscovery()
+
 	// Start SOCKS5 server if enabled
 	if a.socks5Srv != nil {
 		if err := a.socks5Srv.Start(); err != nil {
@@ -659,6 +1079,7 @@ func (a *Agent) Start() error {
 		if a.cfg.SOCKS5.WebSocket.Enabled {
 			wsCfg := socks5.WebSocketConfig{
 				Address:   a.cfg.SOCKS5.WebSocket.Address,
+				Family:    ipfamily.Family(a.cfg.SOCKS5.WebSocket.AddressFamily),
 				Path:      a.cfg.SOCKS5.WebSocket.Path,
 				PlainText: a.cfg.SOCKS5.WebSocket.PlainText,
 			}
@@ -696,6 +1117,20 @@ func (a *Agent) Start() error {
 
 		// Start UDP destination association cleanup loop
 		a.startUDPDestCleanupLoop()
+
+		if a.socks5Quotas != nil {
+			a.startSOCKS5QuotaSaveLoop()
+		}
+		if a.socks5Blocks != nil {
+			a.startSOCKS5BlocklistRefreshLoop()
+		}
+	}
+
+	if a.usageLedger != nil {
+		a.startUsageCheckpointLoop()
+	}
+	if a.watchdog != nil {
+		a.startWatchdogLoop()
 	}
 
 	// Start exit handler if enabled
@@ -705,6 +1140,9 @@ func (a *Agent) Start() error {
 			logging.KeyCount, len(a.cfg.Exit.Routes),
 			"domain_routes", len(a.cfg.Exit.DomainRoutes))
 	}
+	if a.cfg.Exit.Enabled && a.cfg.Exit.HealthCheck.Enabled {
+		a.startExitHealthCheck()
+	}
 
 	// Start forward handler if enabled
 	if a.forwardHandler != nil {
@@ -730,11 +1168,56 @@ func (a *Agent) Start() error {
 	}
 	a.forwardListenersMu.RUnlock()
 
+	// Start UDP tunnel listeners
+	for _, listener := range a.udpTunnels {
+		if err := listener.Start(); err != nil {
+			a.running.Store(false)
+			return fmt.Errorf("start UDP tunnel listener %s: %w", listener.Target(), err)
+		}
+	}
+
+	// All listeners are bound; continue as agent.run_as. They already
+	// accept as root, so a failed switch shuts everything down again.
+	if err := a.dropPrivileges(); err != nil {
+		a.logger.Error("failed to drop privileges",
+			"user", a.cfg.Agent.RunAs.User,
+			logging.KeyError, err)
+		a.Stop()
+		return fmt.Errorf("drop privileges: %w", err)
+	}
+
+	// Register forward listener hostnames
+	a.registerListenerHostnames()
+
 	// Start route advertisement loop and announce initial routes
 	a.wg.Add(1)
 	go a.routeAdvertiseLoop()
 	a.flooder.AnnounceLocalRoutes() // Initial announcement (always - agent presence route)
 
+	// Start end-to-end path probing of learned routes
+	if a.cfg.Routing.PathProbe.Enabled {
+		a.wg.Add(1)
+		go a.pathProbeLoop()
+	}
+
+	// Reorder routes by measured peer latency
+	if a.routeMgr.Table().MetricMode() != routing.MetricHops {
+		a.wg.Add(1)
+		go a.routeMetricLoop()
+	}
+
+	// Install mesh routes into the host routing table
+	if a.cfg.Routing.KernelRoutes.Enabled {
+		a.wg.Add(1)
+		go a.kernelRouteLoop()
+	}
+
+	// Start hole punching towards agents reached through a transit peer
+	if a.cfg.Connections.NATTraversal.Enabled {
+		a.wg.Add(1)
+		go a.natTraversalLoop()
+	}
+
 	// Start node info advertisement loop and announce initial node info
 	// All nodes advertise their info (not just exit nodes)
 	a.wg.Add(1)
@@ -748,7 +1231,7 @@ func (a *Agent) Start() error {
 		case <-a.stopCh:
 			return
 		}
-		info := sysinfo.Collect(a.displayNameForAdvertise(), a.getPeerConnectionInfo(), a.keypair.PublicKey, a.getUDPConfig(), a.getForwardConfig(), a.getFileTransferConfig(), a.getShellConfig(), a.getICMPConfig())
+		info := a.collectNodeInfo()
 		a.flooder.AnnounceLocalNodeInfo(info)
 		a.logger.Debug("initial node info advertisement sent",
 			"display_name", info.DisplayName,
@@ -786,6 +1269,13 @@ func (a *Agent) Start() error {
 			"poll_jitter", a.cfg.Sleep.PollIntervalJitter)
 	}
 
+	if a.cfg.Scheduler.Enabled {
+		if err := a.startScheduler(); err != nil {
+			a.logger.Error("failed to start task scheduler",
+				logging.KeyError, err)
+		}
+	}
+
 	a.logger.Info("agent started",
 		logging.KeyAgentID, a.id.ShortString(),
 		"peers", len(a.cfg.Peers),
@@ -796,9 +1286,12 @@ func (a *Agent) Start() error {
 
 // startListener starts a listener for the given configuration.
 func (a *Agent) startListener(cfg config.ListenerConfig) error {
-	// For plaintext WebSocket listeners (reverse proxy mode), skip TLS
+	// For plaintext WebSocket listeners (reverse proxy mode), skip TLS.
+	// Memory listeners never use TLS.
 	var tlsConfig *tls.Config
-	if cfg.PlainText {
+	if cfg.Transport == string(transport.TransportMemory) {
+		// In-process - no TLS
+	} else if cfg.PlainText {
 		a.logger.Warn("starting plaintext WebSocket listener (no TLS)",
 			"address", cfg.Address,
 			"path", cfg.Path,
@@ -816,6 +1309,7 @@ func (a *Agent) startListener(cfg config.ListenerConfig) error {
 		if err != nil {
 			return fmt.Errorf("load TLS config: %w", err)
 		}
+		tlsConfig.KeyLogWriter = a.debugCap
 	}
 
 	// Select the appropriate transport based on config
@@ -829,15 +1323,24 @@ func (a *Agent) startListener(cfg config.ListenerConfig) error {
 		return fmt.Errorf("unsupported transport type: %s", transportType)
 	}
 
+	allowed, err := cfg.GetAllowedAgents()
+	if err != nil {
+		return err
+	}
+
 	// Start the listener with protocol identifiers from config
 	listener, err := tr.Listen(cfg.Address, transport.ListenOptions{
 		TLSConfig:     tlsConfig,
-		Path:          cfg.Path, // Used by WebSocket and HTTP/2
+		Path:          cfg.Path, // Used by WebSocket, HTTP/2 and WebTransport
 		MaxStreams:    a.cfg.Limits.MaxStreamsTotal,
 		PlainText:     cfg.PlainText,
+		Family:        ipfamily.Family(cfg.AddressFamily),
 		ALPNProtocol:  a.cfg.Protocol.ALPN,
 		HTTPHeader:    a.cfg.Protocol.HTTPHeader,
 		WSSubprotocol: a.cfg.Protocol.WSSubprotocol,
+
+		VerifySourceAddress: a.accept.VerifySourceAddress,
+		QUICTracer:          a.debugCap.QUICTracer,
 	})
 	if err != nil {
 		return err
@@ -847,7 +1350,7 @@ func (a *Agent) startListener(cfg config.ListenerConfig) error {
 
 	// Start accept loop
 	a.wg.Add(1)
-	go a.acceptLoop(listener)
+	go a.acceptLoop(listener, allowed)
 
 	return nil
 }
@@ -947,13 +1450,18 @@ func (a *Agent) loadListenerTLSConfig(override *config.TLSConfig, enableMTLS boo
 
 		tlsConfig.ClientCAs = certPool
 		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	} else if a.cfg.TLS.HasAgentPins() {
+		// Certificate pinning needs the dialer's certificate; request it
+		// without CA verification (the fingerprint check happens in the handshake)
+		tlsConfig.ClientAuth = tls.RequestClientCert
 	}
 
 	return tlsConfig, nil
 }
 
-// acceptLoop accepts incoming connections from a listener.
-func (a *Agent) acceptLoop(listener transport.Listener) {
+// acceptLoop accepts incoming connections from a listener. Only agents in
+// allowed may connect, unless it is empty.
+func (a *Agent) acceptLoop(listener transport.Listener, allowed []identity.AgentID) {
 	defer a.wg.Done()
 	defer recovery.RecoverWithLog(a.logger, "acceptLoop")
 
@@ -981,21 +1489,39 @@ func (a *Agent) acceptLoop(listener transport.Listener) {
 			}
 		}
 
+		done, err := a.accept.Admit(peerConn.RemoteAddr())
+		if err != nil {
+			a.logger.Debug("peer connection refused before handshake",
+				logging.KeyRemoteAddr, peerConn.RemoteAddr(),
+				logging.KeyError, err)
+			peerConn.Close()
+			continue
+		}
+
 		// Handle the connection in a goroutine
 		a.wg.Add(1)
-		go a.handleIncomingConnection(peerConn)
+		go a.handleIncomingConnection(peerConn, allowed, done)
 	}
 }
 
-// handleIncomingConnection processes an incoming peer connection.
-func (a *Agent) handleIncomingConnection(peerConn transport.PeerConn) {
+// handleIncomingConnection processes an incoming peer connection. done
+// receives the handshake result.
+func (a *Agent) handleIncomingConnection(peerConn transport.PeerConn, allowed []identity.AgentID, done func(error)) {
 	defer a.wg.Done()
 	defer recovery.RecoverWithLog(a.logger, "handleIncomingConnection")
 
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), a.acceptHandshakeTimeout())
 	defer cancel()
 
-	conn, err := a.peerMgr.Accept(ctx, peerConn)
+	// Reading PEER_HELLO does not watch ctx; close the connection instead
+	// so a peer that stalls before sending it is torn down on time
+	stop := context.AfterFunc(ctx, func() { peerConn.Close() })
+	conn, err := a.peerMgr.AcceptFrom(ctx, peerConn, allowed)
+	stop()
+	if err != nil && ctx.Err() != nil {
+		err = fmt.Errorf("%w: %v", ctx.Err(), err)
+	}
+	done(err)
 	if err != nil {
 		a.logger.Debug("failed to accept peer connection",
 			logging.KeyError, err)
@@ -1036,6 +1562,92 @@ func (a *Agent) connectToPeer(cfg config.PeerConfig) {
 	}
 	// If ID is "auto" or empty, expectedID will be zero and peer manager will accept any ID
 
+	dialOpts, err := a.peerDialOptions(cfg)
+	if err != nil {
+		a.logger.Error("failed to prepare peer connection",
+			logging.KeyPeerID, cfg.ID,
+			logging.KeyError, err)
+		return
+	}
+
+	// Select the appropriate transport based on config
+	transportType := transport.TransportType(cfg.Transport)
+	if transportType == "" {
+		transportType = transport.TransportQUIC // Default to QUIC
+	}
+
+	// Get transport for this peer (needed for reconnection)
+	var peerTransport transport.Transport
+	if transportType != transport.TransportQUIC {
+		tr, ok := a.transports[transportType]
+		if !ok {
+			a.logger.Error("unsupported transport type",
+				logging.KeyTransport, transportType)
+			return
+		}
+		peerTransport = tr
+	}
+
+	// Normalized per-peer certificate pin (already validated by config)
+	var certFingerprint string
+	if cfg.CertFingerprint != "" {
+		certFingerprint, err = certutil.NormalizeFingerprint(cfg.CertFingerprint)
+		if err != nil {
+			a.logger.Error("invalid cert_fingerprint",
+				logging.KeyAddress, cfg.Address,
+				logging.KeyError, err)
+			return
+		}
+	}
+
+	// Add peer info to manager (including transport for reconnection)
+	a.peerMgr.AddPeer(peer.PeerInfo{
+		Address:         cfg.Address,
+		ExpectedID:      expectedID,
+		Persistent:      true,
+		DialOptions:     dialOpts,
+		Transport:       peerTransport,
+		CertFingerprint: certFingerprint,
+		Quiet:           cfg.Quiet,
+		MaxPayload:      cfg.MaxFrameSize,
+	})
+
+	// Attempt connection
+	ctx, cancel := context.WithTimeout(context.Background(), a.cfg.Connections.Timeout)
+	defer cancel()
+
+	var conn *peer.Connection
+
+	if peerTransport == nil {
+		// Use default Connect for QUIC
+		conn, err = a.peerMgr.Connect(ctx, cfg.Address)
+	} else {
+		// Use ConnectWithTransport for other transports
+		conn, err = a.peerMgr.ConnectWithTransport(ctx, peerTransport, cfg.Address)
+	}
+
+	if err != nil {
+		a.logger.Warn("failed to connect to peer",
+			logging.KeyAddress, cfg.Address,
+			logging.KeyTransport, cfg.Transport,
+			logging.KeyError, err)
+		// Reconnection will be handled by peer manager
+		return
+	}
+
+	a.logger.Info("connected to peer",
+		logging.KeyPeerID, conn.RemoteID.ShortString(),
+		logging.KeyAddress, cfg.Address,
+		logging.KeyTransport, cfg.Transport)
+
+	// Note: SendFullTable / SendNodeInfoToNewPeer are now triggered from
+	// handlePeerConnected (peer manager OnPeerConnected callback), which
+	// fires for both this dial path and the ReconnectAll path used after
+	// sleep/wake.
+}
+
+// peerDialOptions builds the dial options and client TLS config for a peer.
+func (a *Agent) peerDialOptions(cfg config.PeerConfig) (*transport.DialOptions, error) {
 	// Determine if this is a WebSocket connection through a proxy
 	// In this case, the external server might use RSA, so skip EC validation for CA
 	isProxiedWS := cfg.Transport == "ws" && cfg.Proxy != ""
@@ -1057,6 +1669,12 @@ func (a *Agent) connectToPeer(cfg config.PeerConfig) {
 		HTTPHeader:        a.cfg.Protocol.HTTPHeader,
 		WSSubprotocol:     a.cfg.Protocol.WSSubprotocol,
 		FingerprintPreset: a.cfg.TLS.Fingerprint.Preset,
+		ProxyURL:          cfg.Proxy,
+		ProxyUsername:     cfg.ProxyAuth.Username,
+		ProxyPassword:     cfg.ProxyAuth.Password,
+		ProxyFallback:     cfg.ProxyFallback,
+		FromListener:      a.cfg.Connections.NATTraversal.Enabled,
+		QUICTracer:        a.debugCap.QUICTracer,
 	}
 
 	// Build TLS config for peer connection
@@ -1066,33 +1684,26 @@ func (a *Agent) connectToPeer(cfg config.PeerConfig) {
 		MinVersion:         tls.VersionTLS13,
 		NextProtos:         []string{alpn},
 		InsecureSkipVerify: !strictVerify,
+		KeyLogWriter:       a.debugCap,
 	}
 
 	// Load CA certificate for peer verification (per-peer override or global)
 	caPEM, err := a.cfg.GetEffectiveCAPEM(&cfg.TLS)
 	if err != nil {
-		a.logger.Error("failed to load peer CA certificate",
-			logging.KeyPeerID, cfg.ID,
-			logging.KeyError, err)
-		return
+		return nil, fmt.Errorf("load peer CA certificate: %w", err)
 	}
 	if caPEM != nil {
 		// Validate EC-only for CA (skip for proxied WebSocket - external server may use RSA)
 		// Also validate when strict mode is enabled (otherwise we're not verifying anyway)
 		if !isProxiedWS && strictVerify {
 			if err := certutil.ValidateECCertificate(caPEM); err != nil {
-				a.logger.Error("CA EC validation failed",
-					logging.KeyPeerID, cfg.ID,
-					logging.KeyError, err)
-				return
+				return nil, fmt.Errorf("CA EC validation failed: %w", err)
 			}
 		}
 
 		certPool := x509.NewCertPool()
 		if !certPool.AppendCertsFromPEM(caPEM) {
-			a.logger.Error("failed to parse peer CA certificate",
-				logging.KeyPeerID, cfg.ID)
-			return
+			return nil, fmt.Errorf("failed to parse peer CA certificate")
 		}
 		tlsConfig.RootCAs = certPool
 	}
@@ -1100,99 +1711,29 @@ func (a *Agent) connectToPeer(cfg config.PeerConfig) {
 	// Load client certificate for mTLS (per-peer override or global)
 	certPEM, err := a.cfg.GetEffectiveCertPEM(&cfg.TLS)
 	if err != nil {
-		a.logger.Error("failed to load peer client certificate",
-			logging.KeyPeerID, cfg.ID,
-			logging.KeyError, err)
-		return
+		return nil, fmt.Errorf("load peer client certificate: %w", err)
 	}
 	keyPEM, err := a.cfg.GetEffectiveKeyPEM(&cfg.TLS)
 	if err != nil {
-		a.logger.Error("failed to load peer client key",
-			logging.KeyPeerID, cfg.ID,
-			logging.KeyError, err)
-		return
+		return nil, fmt.Errorf("load peer client key: %w", err)
 	}
 
 	if certPEM != nil && keyPEM != nil {
 		// Validate EC-only for client cert (always required for our certs)
 		if err := certutil.ValidateECKeyPair(certPEM, keyPEM); err != nil {
-			a.logger.Error("client cert EC validation failed",
-				logging.KeyPeerID, cfg.ID,
-				logging.KeyError, err)
-			return
+			return nil, fmt.Errorf("client cert EC validation failed: %w", err)
 		}
 
 		cert, err := tls.X509KeyPair(certPEM, keyPEM)
 		if err != nil {
-			a.logger.Error("failed to parse peer client certificate",
-				logging.KeyPeerID, cfg.ID,
-				logging.KeyError, err)
-			return
+			return nil, fmt.Errorf("parse peer client certificate: %w", err)
 		}
 		tlsConfig.Certificates = []tls.Certificate{cert}
 	}
 
 	dialOpts.TLSConfig = tlsConfig
 
-	// Select the appropriate transport based on config
-	transportType := transport.TransportType(cfg.Transport)
-	if transportType == "" {
-		transportType = transport.TransportQUIC // Default to QUIC
-	}
-
-	// Get transport for this peer (needed for reconnection)
-	var peerTransport transport.Transport
-	if transportType != transport.TransportQUIC {
-		tr, ok := a.transports[transportType]
-		if !ok {
-			a.logger.Error("unsupported transport type",
-				logging.KeyTransport, transportType)
-			return
-		}
-		peerTransport = tr
-	}
-
-	// Add peer info to manager (including transport for reconnection)
-	a.peerMgr.AddPeer(peer.PeerInfo{
-		Address:     cfg.Address,
-		ExpectedID:  expectedID,
-		Persistent:  true,
-		DialOptions: dialOpts,
-		Transport:   peerTransport,
-	})
-
-	// Attempt connection
-	ctx, cancel := context.WithTimeout(context.Background(), a.cfg.Connections.Timeout)
-	defer cancel()
-
-	var conn *peer.Connection
-
-	if peerTransport == nil {
-		// Use default Connect for QUIC
-		conn, err = a.peerMgr.Connect(ctx, cfg.Address)
-	} else {
-		// Use ConnectWithTransport for other transports
-		conn, err = a.peerMgr.ConnectWithTransport(ctx, peerTransport, cfg.Address)
-	}
-
-	if err != nil {
-		a.logger.Warn("failed to connect to peer",
-			logging.KeyAddress, cfg.Address,
-			logging.KeyTransport, cfg.Transport,
-			logging.KeyError, err)
-		// Reconnection will be handled by peer manager
-		return
-	}
-
-	a.logger.Info("connected to peer",
-		logging.KeyPeerID, conn.RemoteID.ShortString(),
-		logging.KeyAddress, cfg.Address,
-		logging.KeyTransport, cfg.Transport)
-
-	// Note: SendFullTable / SendNodeInfoToNewPeer are now triggered from
-	// handlePeerConnected (peer manager OnPeerConnected callback), which
-	// fires for both this dial path and the ReconnectAll path used after
-	// sleep/wake.
+	return dialOpts, nil
 }
 
 // Stop gracefully stops the agent.
@@ -1205,6 +1746,10 @@ func (a *Agent) Stop() error {
 		a.running.Store(false)
 		close(a.stopCh)
 
+		if a.exitHealth != nil {
+			a.exitHealth.Stop()
+		}
+
 		// Withdraw routes before shutdown
 		if a.cfg.Exit.Enabled || len(a.cfg.Forward.Endpoints) > 0 {
 			a.flooder.WithdrawLocalRoutes()
@@ -1215,7 +1760,16 @@ func (a *Agent) Stop() error {
 			a.healthServer.Stop()
 		}
 
+		if a.taskScheduler != nil {
+			a.taskScheduler.Stop()
+		}
+
+		for _, listener := range a.udpTunnels {
+			listener.Stop()
+		}
+
 		// Stop forward listeners
+		a.unregisterListenerHostnames()
 		a.forwardListenersMu.RLock()
 		for _, listener := range a.forwardListeners {
 			listener.Stop()
@@ -1234,6 +1788,16 @@ func (a *Agent) Stop() error {
 		if a.socks5Srv != nil {
 			a.socks5Srv.Stop()
 		}
+		if a.socks5Quotas != nil {
+			a.socks5Quotas.Save()
+		}
+		if a.usageLedger != nil {
+			a.checkpointUsage()
+		}
+
+		if a.mdnsResponder != nil {
+			a.mdnsResponder.Close()
+		}
 
 		if a.flooder != nil {
 			a.flooder.Stop()
@@ -1258,8 +1822,14 @@ func (a *Agent) Stop() error {
 
 		a.wg.Wait()
 
+		a.debugCap.Stop()
+		if a.crashReports != nil {
+			a.crashReports.Close()
+		}
+
 		a.logger.Info("agent stopped",
 			logging.KeyAgentID, a.id.ShortString())
+		a.logCloser.Close()
 	})
 
 	return err
@@ -1340,15 +1910,30 @@ func (a *Agent) ensureExitHandler() *exit.Handler {
 
 	exitCfg := exit.HandlerConfig{
 		AllowedRoutes:  nil,
-		ConnectTimeout: 30 * time.Second,
+		ConnectTimeout: a.cfg.Exit.ConnectTimeout,
 		IdleTimeout:    a.cfg.Connections.IdleThreshold,
 		MaxConnections: a.cfg.Limits.MaxStreamsTotal,
 		Logger:         a.logger,
-		DNS: exit.DNSConfig{
-			Servers: a.cfg.Exit.DNS.Servers,
-			Timeout: a.cfg.Exit.DNS.Timeout,
+		DNS:  a.exitDNSConfig(),
+		Pool: a.exitPoolConfig(),
+		HappyEyeballs: exit.HappyEyeballsConfig{
+			Enabled: a.cfg.Exit.HappyEyeballs.Enabled,
+			Delay:   a.cfg.Exit.HappyEyeballs.Delay,
 		},
-	}
+		DestStats: a.exitDestStatsConfig(),
+		HalfClose: a.exitHalfCloseConfig(),
+		Bind:      a.exitBindConfig(),
+		Private:   a.exitPrivateFilter(),
+
+		PortLimits:    a.exitPortLimitConfig(),
+		ProxyProtocol: a.exitProxyProtocol(),
+		UserPolicy:    a.exitUserPolicy(),
+		AuditLog:      a.cfg.Exit.AuditLog,
+		Middleware:    a.middleware,
+		Rekey:         a.rekeyConfig(),
+	}
+	exitCfg.OnConnOpen = a.exitStreamOpened
+	exitCfg.OnConnClose = a.exitStreamClosed
 	a.exitHandler = exit.NewHandler(exitCfg, a.id, a)
 	a.exitHandler.Start()
 	a.logger.Info("exit handler created on demand for dynamic routes")
@@ -1356,6 +1941,84 @@ func (a *Agent) ensureExitHandler() *exit.Handler {
 	return a.exitHandler
 }
 
+// logOutput converts the agent.log_output configuration for the logger.
+func logOutput(cfg config.AgentConfig) logging.Output {
+	return logging.Output{
+		Type: cfg.LogOutput,
+		File: logging.FileOutput{
+			Path:       cfg.LogFile.Path,
+			MaxSize:    cfg.LogFile.MaxSize,
+			MaxAge:     cfg.LogFile.MaxAge,
+			MaxBackups: cfg.LogFile.MaxBackups,
+		},
+		Syslog: logging.SyslogOutput{
+			Address:  cfg.LogSyslog.Address,
+			Facility: cfg.LogSyslog.Facility,
+			Tag:      cfg.LogSyslog.Tag,
+		},
+		EventLog: logging.EventLogOutput{Source: cfg.LogEventLog.Source},
+	}
+}
+
+// exitPoolConfig converts the exit.pool configuration for the exit handler.
+func (a *Agent) exitPoolConfig() exit.PoolConfig {
+	return exit.PoolConfig{
+		Enabled:        a.cfg.Exit.Pool.Enabled,
+		Ports:          a.cfg.Exit.Pool.Ports,
+		MaxIdle:        a.cfg.Exit.Pool.MaxIdle,
+		MaxIdlePerHost: a.cfg.Exit.Pool.MaxIdlePerHost,
+		IdleTimeout:    a.cfg.Exit.Pool.IdleTimeout,
+	}
+}
+
+// exitPrivateFilter converts exit.block_private and exit.allow_private for
+// the exit, UDP and ICMP handlers. Exceptions are validated with the config.
+func (a *Agent) exitPrivateFilter() exit.PrivateFilter {
+	filter := exit.PrivateFilter{Block: a.cfg.Exit.BlockPrivate}
+	for _, cidr := range a.cfg.Exit.AllowPrivate {
+		filter.Allowed = append(filter.Allowed, routing.MustParseCIDR(cidr))
+	}
+	return filter
+}
+
+// errPrivateDestination reports a destination refused by exit.block_private
+// when this agent is the exit. SOCKS5 clients see "not allowed by ruleset".
+func errPrivateDestination(host string) error {
+	return &streamOpenError{
+		code: protocol.ErrPrivateDestination,
+		err:  fmt.Errorf("destination %s in private range not allowed", host),
+	}
+}
+
+// ensureForwardHandler creates a forward handler on demand if one does not exist.
+// This allows agents without configured endpoints to serve dynamic endpoints.
+func (a *Agent) ensureForwardHandler() *forward.Handler {
+	if a.forwardHandler != nil {
+		return a.forwardHandler
+	}
+
+	a.forwardHandlerMu.Lock()
+	defer a.forwardHandlerMu.Unlock()
+
+	// Double-check after acquiring lock
+	if a.forwardHandler != nil {
+		return a.forwardHandler
+	}
+
+	handlerCfg := forward.HandlerConfig{
+		ConnectTimeout: 30 * time.Second,
+		IdleTimeout:    a.cfg.Connections.IdleThreshold,
+		MaxConnections: a.cfg.Limits.MaxStreamsTotal,
+		Rekey:          a.rekeyConfig(),
+		Logger:         a.logger,
+	}
+	a.forwardHandler = forward.NewHandler(handlerCfg, a.id, a)
+	a.forwardHandler.Start()
+	a.logger.Info("forward handler created on demand for dynamic endpoints")
+
+	return a.forwardHandler
+}
+
 // ManageRoute handles dynamic route management (add/remove/list).
 func (a *Agent) ManageRoute(action, network string, metric uint16) (*health.RouteManageResult, error) {
 	switch action {
@@ -1585,6 +2248,127 @@ func (a *Agent) handleForwardManage(data []byte) ([]byte, bool) {
 	return resp, true
 }
 
+// ManageForwardEndpoint handles dynamic forward endpoint management (add/remove/list).
+// Dynamic endpoints are advertised like config endpoints but are lost on restart.
+// Implements the health.ForwardEndpointManageProvider interface.
+func (a *Agent) ManageForwardEndpoint(action, key, target string) (*health.ForwardEndpointManageResult, error) {
+	switch action {
+	case "add":
+		if key == "" {
+			return nil, fmt.Errorf("key is required")
+		}
+		if target == "" {
+			return nil, fmt.Errorf("target is required")
+		}
+		if _, _, err := net.SplitHostPort(target); err != nil {
+			return nil, fmt.Errorf("invalid target %q: %w", target, err)
+		}
+		if a.isConfigForwardEndpoint(key) {
+			return nil, fmt.Errorf("endpoint %q is a config endpoint and cannot be replaced", key)
+		}
+
+		a.forwardEndpointsMu.Lock()
+		a.ensureForwardHandler().AddEndpoint(key, target)
+		a.dynamicForwardEndpoints[key] = struct{}{}
+		a.forwardEndpointsMu.Unlock()
+
+		a.routeMgr.AddLocalForwardRoute(key, target, 0)
+		a.TriggerRouteAdvertise()
+		a.TriggerNodeInfoAdvertise()
+
+		return &health.ForwardEndpointManageResult{
+			Status:  "ok",
+			Message: fmt.Sprintf("forward endpoint %q added for %s", key, target),
+		}, nil
+
+	case "remove":
+		if key == "" {
+			return nil, fmt.Errorf("key is required")
+		}
+		if a.isConfigForwardEndpoint(key) {
+			return nil, fmt.Errorf("endpoint %q is a config endpoint and cannot be removed", key)
+		}
+
+		a.forwardEndpointsMu.Lock()
+		if _, isDynamic := a.dynamicForwardEndpoints[key]; !isDynamic {
+			a.forwardEndpointsMu.Unlock()
+			return nil, fmt.Errorf("endpoint %q not found", key)
+		}
+		delete(a.dynamicForwardEndpoints, key)
+		a.forwardHandler.RemoveEndpoint(key)
+		a.forwardEndpointsMu.Unlock()
+
+		// Peers drop the route once it is missing from our advertisements
+		// and ages out of their forward tables.
+		a.routeMgr.RemoveLocalForwardRoute(key)
+		a.TriggerRouteAdvertise()
+		a.TriggerNodeInfoAdvertise()
+
+		return &health.ForwardEndpointManageResult{
+			Status:  "ok",
+			Message: fmt.Sprintf("forward endpoint %q removed", key),
+		}, nil
+
+	case "list":
+		entries := make([]health.ForwardEndpointManageResultEntry, 0)
+		if a.forwardHandler != nil {
+			a.forwardEndpointsMu.Lock()
+			for _, key := range a.forwardHandler.GetKeys() {
+				target, _ := a.forwardHandler.GetTarget(key)
+				_, isDynamic := a.dynamicForwardEndpoints[key]
+				entries = append(entries, health.ForwardEndpointManageResultEntry{
+					Key:     key,
+					Target:  target,
+					Dynamic: isDynamic,
+				})
+			}
+			a.forwardEndpointsMu.Unlock()
+		}
+		sort.Slice(entries, func(i, j int) bool {
+			return entries[i].Key < entries[j].Key
+		})
+		return &health.ForwardEndpointManageResult{
+			Status:    "ok",
+			Endpoints: entries,
+		}, nil
+
+	default:
+		return nil, fmt.Errorf("unknown action %q (expected add, remove, or list)", action)
+	}
+}
+
+// isConfigForwardEndpoint reports whether key belongs to a config-file endpoint.
+func (a *Agent) isConfigForwardEndpoint(key string) bool {
+	for _, ep := range a.cfg.Forward.Endpoints {
+		if ep.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// handleForwardEndpointManage processes a ControlTypeForwardEndpointManage control request.
+func (a *Agent) handleForwardEndpointManage(data []byte) ([]byte, bool) {
+	var req struct {
+		Action string `json:"action"`
+		Key    string `json:"key"`
+		Target string `json:"target"`
+	}
+	if err := json.Unmarshal(data, &req); err != nil {
+		resp, _ := json.Marshal(map[string]string{"error": "invalid request: " + err.Error()})
+		return resp, false
+	}
+
+	result, err := a.ManageForwardEndpoint(req.Action, req.Key, req.Target)
+	if err != nil {
+		resp, _ := json.Marshal(map[string]string{"error": err.Error()})
+		return resp, false
+	}
+
+	resp, _ := json.Marshal(result)
+	return resp, true
+}
+
 // ManageDisplayName handles dynamic display name management (set/get).
 // Implements the health.DisplayNameManageProvider interface.
 func (a *Agent) ManageDisplayName(action, name string) (*health.DisplayNameManageResult, error) {
@@ -1693,6 +2477,10 @@ func (a *Agent) routeAdvertiseLoop() {
 		case <-a.stopCh:
 			return
 		case <-ticker.C:
+			// Quiet peers hold back advertisements while idle; keep what
+			// was learned through them for as long as the link is up
+			a.refreshQuietPeerRoutes()
+
 			// Clean up stale routes before advertising
 			if removed := a.routeMgr.CleanupStaleRoutes(routeTTL); removed > 0 {
 				a.logger.Debug("cleaned up stale routes",
@@ -1788,6 +2576,8 @@ func (a *Agent) nodeInfoAdvertiseLoop() {
 		case <-a.stopCh:
 			return
 		case <-ticker.C:
+			a.refreshQuietPeerRoutes()
+
 			// Clean up stale node info before advertising
 			if removed := a.routeMgr.CleanupStaleNodeInfo(nodeInfoTTL); removed > 0 {
 				a.logger.Debug("cleaned up stale node info entries",
@@ -1796,7 +2586,7 @@ func (a *Agent) nodeInfoAdvertiseLoop() {
 			}
 
 			// Collect and announce local node info with current peer connections
-			info := sysinfo.Collect(a.displayNameForAdvertise(), a.getPeerConnectionInfo(), a.keypair.PublicKey, a.getUDPConfig(), a.getForwardConfig(), a.getFileTransferConfig(), a.getShellConfig(), a.getICMPConfig())
+			info := a.collectNodeInfo()
 			a.flooder.AnnounceLocalNodeInfo(info)
 			a.logger.Debug("periodic node info advertisement sent",
 				"display_name", info.DisplayName,
@@ -1804,7 +2594,7 @@ func (a *Agent) nodeInfoAdvertiseLoop() {
 				"peers", len(info.Peers))
 		case <-a.nodeInfoAdvertiseCh:
 			// Triggered re-advertisement (e.g., after dynamic forward listener change)
-			info := sysinfo.Collect(a.displayNameForAdvertise(), a.getPeerConnectionInfo(), a.keypair.PublicKey, a.getUDPConfig(), a.getForwardConfig(), a.getFileTransferConfig(), a.getShellConfig(), a.getICMPConfig())
+			info := a.collectNodeInfo()
 			a.flooder.AnnounceLocalNodeInfo(info)
 			a.logger.Debug("triggered node info advertisement sent",
 				"display_name", info.DisplayName,
@@ -1885,17 +2675,21 @@ func (a *Agent) handleStreamOpen(peerID identity.AgentID, frame *protocol.Frame)
 		return
 	}
 
+	if !a.allowStreamOpen(peerID, frame.StreamID, open) {
+		return
+	}
+
 	// Check if we are the exit node (path is empty or we're the target)
 	if len(open.RemainingPath) == 0 || (len(open.RemainingPath) == 1 && open.RemainingPath[0] == a.id) {
 		// Check if this is a file transfer or shell stream
 		if open.AddressType == protocol.AddrTypeDomain {
 			destAddr := addressToString(open.AddressType, open.Address)
 			if destAddr == protocol.FileTransferUpload {
-				a.handleFileUploadStreamOpen(peerID, frame.StreamID, open.RequestID, open.EphemeralPubKey)
+				a.handleFileUploadStreamOpen(peerID, frame.StreamID, open.RequestID, open.EphemeralPubKey, int(open.MaxPayload))
 				return
 			}
 			if destAddr == protocol.FileTransferDownload {
-				a.handleFileDownloadStreamOpen(peerID, frame.StreamID, open.RequestID, open.EphemeralPubKey)
+				a.handleFileDownloadStreamOpen(peerID, frame.StreamID, open.RequestID, open.EphemeralPubKey, int(open.MaxPayload))
 				return
 			}
 			// Shell streams
@@ -1910,8 +2704,11 @@ func (a *Agent) handleStreamOpen(peerID identity.AgentID, frame *protocol.Frame)
 			// Forward streams (port forwarding)
 			if strings.HasPrefix(destAddr, protocol.ForwardStreamPrefix) {
 				key := strings.TrimPrefix(destAddr, protocol.ForwardStreamPrefix)
+				if !a.acceptNamespace(peerID, frame.StreamID, open) {
+					return
+				}
 				if a.forwardHandler != nil {
-					ctx := context.Background()
+					ctx := clientContext(context.Background(), open)
 					a.forwardHandler.HandleStreamOpen(ctx, frame.StreamID, open.RequestID, peerID, key, open.EphemeralPubKey)
 				} else {
 					// No forward handler - send error
@@ -1933,7 +2730,10 @@ func (a *Agent) handleStreamOpen(peerID identity.AgentID, frame *protocol.Frame)
 
 		// We are the exit node for TCP traffic
 		if a.exitHandler != nil {
-			ctx := context.Background()
+			if !a.acceptNamespace(peerID, frame.StreamID, open) {
+				return
+			}
+			ctx := clientContext(context.Background(), open)
 			// Convert address bytes to string based on address type
 			destAddr := addressToString(open.AddressType, open.Address)
 			a.exitHandler.HandleStreamOpen(ctx, frame.StreamID, open.RequestID, peerID, destAddr, open.Port, open.EphemeralPubKey)
@@ -1941,6 +2741,16 @@ func (a *Agent) handleStreamOpen(peerID identity.AgentID, frame *protocol.Frame)
 		return
 	}
 
+	// Enforce the hop limit before relaying
+	ttl, ok := nextHopTTL(open.TTL)
+	if !ok {
+		a.logger.Debug("stream open hop limit exceeded",
+			logging.KeyPeerID, peerID.ShortString(),
+			logging.KeyStreamID, frame.StreamID)
+		a.sendStreamOpenErr(peerID, frame.StreamID, open.RequestID, protocol.ErrTTLExceeded, hopLimitMessage)
+		return
+	}
+
 	// Forward to next hop
 	nextHop := open.RemainingPath[0]
 
@@ -1971,8 +2781,36 @@ func (a *Agent) handleStreamOpen(peerID identity.AgentID, frame *protocol.Frame)
 		UpstreamID:     frame.StreamID,
 		DownstreamPeer: nextHop,
 		DownstreamID:   downstreamID,
+		DestAddr:       formatStreamDest(addressToString(open.AddressType, open.Address), open.Port),
+	}
+	// Only a token with a valid proof is deduplicated; the open is
+	// forwarded with the token either way.
+	if open.OpenToken.Verify(open.OpenProof) {
+		relay.OpenToken = open.OpenToken
+		relay.OpenAttempt = open.OpenProof.Attempt
+	}
+	superseded, duplicate, refused := a.tcpRelay.InsertOpen(relay)
+	if duplicate {
+		a.logger.Debug("ignoring duplicate stream open",
+			logging.KeyPeerID, peerID.ShortString(),
+			logging.KeyStreamID, frame.StreamID,
+			"open_token", open.OpenToken.String())
+		return
+	}
+	if refused {
+		a.logger.Debug("refusing stream open: open token held by a later attempt",
+			logging.KeyPeerID, peerID.ShortString(),
+			logging.KeyStreamID, frame.StreamID,
+			"open_token", open.OpenToken.String())
+		a.sendStreamOpenErr(peerID, frame.StreamID, open.RequestID, protocol.ErrStreamSuperseded, "open token held by a later attempt")
+		return
+	}
+	if superseded != nil {
+		// The ingress retried this open over another path; the relay of
+		// the abandoned attempt is torn down in both directions.
+		a.WriteStreamReset(superseded.UpstreamPeer, superseded.UpstreamID, protocol.ErrStreamSuperseded)
+		a.WriteStreamReset(superseded.DownstreamPeer, superseded.DownstreamID, protocol.ErrStreamSuperseded)
 	}
-	a.tcpRelay.Insert(relay)
 
 	// Update remaining path (remove the next hop)
 	newPath := open.RemainingPath[1:]
@@ -1983,12 +2821,22 @@ func (a *Agent) handleStreamOpen(peerID identity.AgentID, frame *protocol.Frame)
 		AddressType:     open.AddressType,
 		Address:         open.Address,
 		Port:            open.Port,
+		TTL:             ttl,
 		RemainingPath:   newPath,
 		EphemeralPubKey: open.EphemeralPubKey,
+		MaxPayload:      a.pathMaxPayload(open.MaxPayload, nextHop),
+		ClientAddr:      open.ClientAddr,
+		ClientPort:      open.ClientPort,
+		ClientUser:      open.ClientUser,
+		E2EFlags:        open.E2EFlags,
+		Namespace:       open.Namespace,
+		OpenToken:       open.OpenToken,
+		OpenProof:       open.OpenProof,
 	}
 
 	fwdFrame := &protocol.Frame{
 		Type:     protocol.FrameStreamOpen,
+		Flags:    frame.Flags & protocol.FlagClassMask, // Keep the traffic class chosen by the opener
 		StreamID: downstreamID,
 		Payload:  fwdOpen.Encode(),
 	}
@@ -1997,10 +2845,11 @@ func (a *Agent) handleStreamOpen(peerID identity.AgentID, frame *protocol.Frame)
 		// Clean up relay entry on failure
 		a.tcpRelay.Delete(relay)
 
-		// Send error back
+		// Send error back. The next hop is unreachable, so the ingress may
+		// retry over another path.
 		errPayload := &protocol.StreamOpenErr{
 			RequestID: open.RequestID,
-			ErrorCode: protocol.ErrConnectionRefused,
+			ErrorCode: protocol.ErrHostUnreachable,
 			Message:   err.Error(),
 		}
 		errFrame := &protocol.Frame{
@@ -2039,11 +2888,19 @@ func (a *Agent) handleStreamOpenAck(peerID identity.AgentID, frame *protocol.Fra
 	// LookupDownstream RLock returns is safe even though the entry could be
 	// removed concurrently.
 	if relay := a.tcpRelay.LookupDownstream(frame.StreamID); relay != nil && peerID == relay.DownstreamPeer {
-		// Forward ACK to upstream with upstream stream ID
+		// Forward ACK to upstream with upstream stream ID, lowering the path
+		// payload limit to the upstream link
+		payload := frame.Payload
+		if ack, err := protocol.DecodeStreamOpenAck(payload); err == nil {
+			if limit := a.pathMaxPayload(ack.MaxPayload, relay.UpstreamPeer); limit != ack.MaxPayload {
+				ack.MaxPayload = limit
+				payload = ack.Encode()
+			}
+		}
 		fwdFrame := &protocol.Frame{
 			Type:     protocol.FrameStreamOpenAck,
 			StreamID: relay.UpstreamID,
-			Payload:  frame.Payload,
+			Payload:  payload,
 		}
 		a.peerMgr.SendToPeer(relay.UpstreamPeer, fwdFrame)
 		return
@@ -2060,7 +2917,7 @@ func (a *Agent) handleStreamOpenAck(peerID identity.AgentID, frame *protocol.Fra
 		boundIP = net.IP(ack.BoundAddr)
 	}
 
-	a.streamMgr.HandleStreamOpenAck(ack.RequestID, boundIP, ack.BoundPort, ack.EphemeralPubKey)
+	a.streamMgr.HandleStreamOpenAck(ack.RequestID, boundIP, ack.BoundPort, ack.EphemeralPubKey, ack.E2EFlags, int(ack.MaxPayload))
 }
 
 // handleStreamOpenErr processes a STREAM_OPEN_ERR.
@@ -2096,36 +2953,10 @@ func (a *Agent) handleStreamOpenErr(peerID identity.AgentID, frame *protocol.Fra
 
 // handleStreamData processes stream data.
 func (a *Agent) handleStreamData(peerID identity.AgentID, frame *protocol.Frame) {
-	// Check if this is a relay stream - could be from upstream or downstream.
-	// We must check both the stream ID AND the peer ID to determine direction,
-	// because the per-connection stream ID space means upstream and downstream
-	// may use the same numeric ID. Relay entries are immutable once inserted,
-	// so reading entry fields after the LookupBoth RLock returns is safe.
-	upRelay, downRelay := a.tcpRelay.LookupBoth(frame.StreamID)
-
-	// Check if data is from upstream (matches upRelay's upstream peer)
-	if upRelay != nil && peerID == upRelay.UpstreamPeer {
-		// Data from upstream, forward to downstream
-		fwdFrame := &protocol.Frame{
-			Type:     protocol.FrameStreamData,
-			StreamID: upRelay.DownstreamID,
-			Flags:    frame.Flags,
-			Payload:  frame.Payload,
-		}
-		a.peerMgr.SendToPeer(upRelay.DownstreamPeer, fwdFrame)
-		return
-	}
-
-	// Check if data is from downstream (matches downRelay's downstream peer)
-	if downRelay != nil && peerID == downRelay.DownstreamPeer {
-		// Data from downstream, forward to upstream
-		fwdFrame := &protocol.Frame{
-			Type:     protocol.FrameStreamData,
-			StreamID: downRelay.UpstreamID,
-			Flags:    frame.Flags,
-			Payload:  frame.Payload,
-		}
-		a.peerMgr.SendToPeer(downRelay.UpstreamPeer, fwdFrame)
+	// Relay streams are usually forwarded from the peer read loop already
+	// (see relayStreamData); this catches frames that were queued behind
+	// other frames from the same connection.
+	if a.relayStreamData(peerID, frame) {
 		return
 	}
 
@@ -2154,12 +2985,61 @@ func (a *Agent) handleStreamData(peerID identity.AgentID, frame *protocol.Frame)
 		// Note: shellHandler tracks its own streams and will ignore unknown stream IDs
 	}
 
-	// Check if this is a shell client stream (where we initiated to a remote shell)
-	if a.handleShellClientData(frame.StreamID, frame.Payload, frame.Flags) {
+	// Check if this is a shell client stream (where we initiated to a remote shell)
+	if a.handleShellClientData(frame.StreamID, frame.Payload, frame.Flags) {
+		return
+	}
+
+	a.streamMgr.HandleStreamData(frame.StreamID, frame.Flags, frame.Payload)
+}
+
+// relayStreamData forwards STREAM_DATA belonging to a relay stream and
+// reports whether the frame was one. It is also the peer manager fast path,
+// so it runs on the peer read loop and must not block on anything but the
+// write to the next hop.
+func (a *Agent) relayStreamData(peerID identity.AgentID, frame *protocol.Frame) bool {
+	// Check if this is a relay stream - could be from upstream or downstream.
+	// We must check both the stream ID AND the peer ID to determine direction,
+	// because the per-connection stream ID space means upstream and downstream
+	// may use the same numeric ID. Relay entries are immutable once inserted,
+	// so reading entry fields after the LookupBoth RLock returns is safe.
+	upRelay, downRelay := a.tcpRelay.LookupBoth(frame.StreamID)
+
+	// Check if data is from upstream (matches upRelay's upstream peer)
+	if upRelay != nil && peerID == upRelay.UpstreamPeer {
+		// Data from upstream, forward to downstream
+		upRelay.recordUpstream(len(frame.Payload))
+		a.forwardRelayData(frame, upRelay.DownstreamPeer, upRelay.DownstreamID)
+		return true
+	}
+
+	// Check if data is from downstream (matches downRelay's downstream peer)
+	if downRelay != nil && peerID == downRelay.DownstreamPeer {
+		// Data from downstream, forward to upstream
+		downRelay.recordDownstream(len(frame.Payload))
+		a.forwardRelayData(frame, downRelay.UpstreamPeer, downRelay.UpstreamID)
+		return true
+	}
+
+	return false
+}
+
+// forwardRelayData sends a relayed STREAM_DATA frame to the next hop under
+// its stream ID there. A frame read from the wire is forwarded in its
+// original buffer with only the stream ID rewritten.
+func (a *Agent) forwardRelayData(frame *protocol.Frame, toPeer identity.AgentID, toStreamID uint64) {
+	if raw := frame.Raw(); raw != nil {
+		protocol.SetRawStreamID(raw, toStreamID)
+		a.peerMgr.SendRawToPeer(toPeer, raw)
 		return
 	}
 
-	a.streamMgr.HandleStreamData(frame.StreamID, frame.Flags, frame.Payload)
+	a.peerMgr.SendToPeer(toPeer, &protocol.Frame{
+		Type:     protocol.FrameStreamData,
+		StreamID: toStreamID,
+		Flags:    frame.Flags,
+		Payload:  frame.Payload,
+	})
 }
 
 // handleStreamClose processes a stream close.
@@ -2276,7 +3156,7 @@ func (a *Agent) handleRouteAdvertise(peerID identity.AgentID, frame *protocol.Fr
 		logging.KeyCount, len(adv.Routes),
 		"encrypted", encrypted)
 
-	a.flooder.HandleRouteAdvertise(peerID, adv.OriginAgent, adv.OriginDisplayName, adv.Sequence, adv.Routes, adv.EncPath, adv.SeenBy)
+	a.flooder.HandleAdvertise(peerID, adv)
 }
 
 // handleRouteWithdraw processes a route withdrawal.
@@ -2286,7 +3166,7 @@ func (a *Agent) handleRouteWithdraw(peerID identity.AgentID, frame *protocol.Fra
 		return
 	}
 
-	a.flooder.HandleRouteWithdraw(peerID, withdraw.OriginAgent, withdraw.Sequence, withdraw.Routes, withdraw.SeenBy)
+	a.flooder.HandleWithdraw(peerID, withdraw)
 }
 
 // handleNodeInfoAdvertise processes a node info advertisement.
@@ -2359,6 +3239,8 @@ func (a *Agent) handleControlRequest(peerID identity.AgentID, frame *protocol.Fr
 		"target", req.TargetAgent.ShortString(),
 		"path_len", len(req.Path))
 
+	role := a.controlRequestRole(peerID, req.Role)
+
 	// Check if this request is for us or needs forwarding
 	var zeroID identity.AgentID
 	if req.TargetAgent != zeroID && req.TargetAgent != a.id {
@@ -2432,6 +3314,7 @@ func (a *Agent) handleControlRequest(peerID identity.AgentID, frame *protocol.Fr
 			TargetAgent: req.TargetAgent,
 			Path:        remainingPath,
 			Data:        req.Data, // Preserve data payload for RPC and other control types
+			Role:        uint8(role),
 		}
 
 		fwdFrame := &protocol.Frame{
@@ -2451,6 +3334,17 @@ func (a *Agent) handleControlRequest(peerID identity.AgentID, frame *protocol.Fr
 		return
 	}
 
+	if required := rbac.ControlRole(req.ControlType, req.Data); !role.Allows(required) {
+		a.logger.Warn("control request denied by role",
+			"from", peerID.ShortString(),
+			"type", req.ControlType,
+			"role", role.String(),
+			"required", required.String())
+		data, _ := json.Marshal(map[string]string{"error": "forbidden: " + required.String() + " role required"})
+		a.sendControlResponse(peerID, req.RequestID, req.ControlType, false, data)
+		return
+	}
+
 	// Handle locally
 	var data []byte
 	var success bool
@@ -2470,6 +3364,46 @@ func (a *Agent) handleControlRequest(peerID identity.AgentID, frame *protocol.Fr
 		data, success = a.handleFileBrowse(req.Data)
 	case protocol.ControlTypeDisplayNameManage:
 		data, success = a.handleDisplayNameManage(req.Data)
+	case protocol.ControlTypeUDPStats:
+		data, success = a.getLocalUDPStats()
+	case protocol.ControlTypeStreams:
+		data, success = a.getLocalStreams()
+	case protocol.ControlTypeForwardEndpointManage:
+		data, success = a.handleForwardEndpointManage(req.Data)
+	case protocol.ControlTypeDNSCacheManage:
+		data, success = a.handleDNSCacheManage(req.Data)
+	case protocol.ControlTypeExitDestManage:
+		data, success = a.handleExitDestManage(req.Data)
+	case protocol.ControlTypeIdleManage:
+		data, success = a.handleIdleManage(req.Data)
+	case protocol.ControlTypeScheduleManage:
+		data, success = a.handleScheduleManage(req.Data)
+	case protocol.ControlTypeUpdateManage:
+		data, success = a.handleUpdateManage(req.Data)
+	case protocol.ControlTypeChaosManage:
+		data, success = a.handleChaosManage(req.Data)
+	case protocol.ControlTypeDebugCapture:
+		data, success = a.handleDebugCapture(req.Data)
+	case protocol.ControlTypeManagementKey:
+		data, success = a.handleManagementKey(req.Data)
+	case protocol.ControlTypeSOCKS5UsersManage:
+		data, success = a.handleSOCKS5UsersManage(req.Data)
+	case protocol.ControlTypeBlocklistManage:
+		data, success = a.handleBlocklistManage(req.Data)
+	case protocol.ControlTypeUsage:
+		data, success = a.getLocalUsage()
+	case protocol.ControlTypeCrashManage:
+		data, success = a.handleCrashManage(req.Data)
+	case protocol.ControlTypeRouteDampening:
+		data, success = a.getLocalRouteDampening()
+	case protocol.ControlTypeRouteLookup:
+		data, success = a.handleRouteLookup(req.Data)
+	case protocol.ControlTypeSystemMetrics:
+		data, success = a.getLocalSystemMetrics()
+	case protocol.ControlTypePathProbe:
+		success = true
+	case protocol.ControlTypeRendezvous:
+		data, success = a.handleRendezvous(peerID, req.Data)
 	default:
 		data = []byte("unknown control type")
 		success = false
@@ -2618,7 +3552,12 @@ func (a *Agent) SendControlRequestWithData(ctx context.Context, targetID identit
 	if err != nil {
 		return nil, err
 	}
+	return a.sendControlRequestVia(ctx, nextHop, path, targetID, controlType, data)
+}
 
+// sendControlRequestVia sends a control request to a target agent through
+// nextHop along path and waits for the response.
+func (a *Agent) sendControlRequestVia(ctx context.Context, nextHop identity.AgentID, path []identity.AgentID, targetID identity.AgentID, controlType uint8, data []byte) (*protocol.ControlResponse, error) {
 	a.logger.Debug("sending control request",
 		"target", targetID.ShortString(),
 		"next_hop", nextHop.ShortString(),
@@ -2646,6 +3585,7 @@ func (a *Agent) SendControlRequestWithData(ctx context.Context, targetID identit
 		TargetAgent: targetID,
 		Path:        path,
 		Data:        data,
+		Role:        uint8(outgoingRole(ctx)),
 	}
 
 	frame := &protocol.Frame{
@@ -2748,6 +3688,10 @@ func (a *Agent) handlePeerConnected(conn *peer.Connection) {
 
 	a.logger.Debug("peer connected",
 		logging.KeyPeerID, peerID.ShortString())
+	a.publishPeerEvent(health.EventPeerUp, conn, nil)
+	if a.routeWatch != nil {
+		a.routeWatch.peerEvent(health.EventPeerUp, conn)
+	}
 
 	// Forward any pending wake command to the new peer
 	if a.flooder != nil {
@@ -2762,6 +3706,24 @@ func (a *Agent) handlePeerConnected(conn *peer.Connection) {
 	}
 }
 
+// handleQuietResume is called when user traffic resumes on an idle quiet
+// link. Advertisements were held back while idle, so resync the peer.
+func (a *Agent) handleQuietResume(conn *peer.Connection) {
+	if a.flooder == nil {
+		return
+	}
+	a.flooder.SendFullTable(conn.RemoteID)
+	a.flooder.SendNodeInfoToNewPeer(conn.RemoteID)
+}
+
+// refreshQuietPeerRoutes keeps routes and node info learned through connected
+// quiet peers from expiring while those peers are idle.
+func (a *Agent) refreshQuietPeerRoutes() {
+	for _, peerID := range a.peerMgr.QuietPeerIDs() {
+		a.routeMgr.RefreshRoutesFromPeer(peerID)
+	}
+}
+
 // handlePeerDisconnect is called when a peer connection is closed.
 // It cleans up any relay streams and routes involving the disconnected peer.
 func (a *Agent) handlePeerDisconnect(conn *peer.Connection, err error) {
@@ -2770,23 +3732,58 @@ func (a *Agent) handlePeerDisconnect(conn *peer.Connection, err error) {
 	a.logger.Info("peer disconnected",
 		logging.KeyPeerID, peerID.ShortString(),
 		logging.KeyError, err)
+	a.publishPeerEvent(health.EventPeerDown, conn, err)
+	if a.routeWatch != nil {
+		a.routeWatch.peerEvent(health.EventPeerDown, conn)
+	}
+	a.recordPeerUsage(conn, true)
 
-	// Clean up relay streams involving this peer
+	// Clean up relay and local streams involving this peer
 	a.cleanupRelaysForPeer(peerID)
+	a.resetStreamsForPeer(peerID)
 
-	// Clean up routes learned from this peer
+	// Clean up routes learned from this peer. With fast reroute, routes
+	// switch to precomputed alternates here.
 	a.routeMgr.HandlePeerDisconnect(peerID)
 	a.routeMgr.HandlePeerDisconnectDomain(peerID)
 	a.routeMgr.HandlePeerDisconnectForward(peerID)
 	a.routeMgr.HandlePeerDisconnectAgent(peerID)
 }
 
-// cleanupRelaysForPeer removes all relay entries involving the specified peer.
+// cleanupRelaysForPeer removes all relay entries involving the specified
+// peer and resets the other side of each, so streams through the lost link
+// fail right away and clients can reconnect over an alternate route.
 func (a *Agent) cleanupRelaysForPeer(peerID identity.AgentID) {
-	if cleaned := a.tcpRelay.DeleteByPeer(peerID); cleaned > 0 {
+	cleaned := a.tcpRelay.PopByPeer(peerID)
+	for _, e := range cleaned {
+		if e.UpstreamPeer == peerID {
+			a.WriteStreamReset(e.DownstreamPeer, e.DownstreamID, protocol.ErrHostUnreachable)
+		} else {
+			a.WriteStreamReset(e.UpstreamPeer, e.UpstreamID, protocol.ErrHostUnreachable)
+		}
+	}
+	if len(cleaned) > 0 {
 		a.logger.Debug("cleaned up relay streams",
 			logging.KeyPeerID, peerID.ShortString(),
-			logging.KeyCount, cleaned)
+			logging.KeyCount, len(cleaned))
+	}
+}
+
+// resetStreamsForPeer resets this agent's streams whose next hop is the
+// specified peer. Their frames can no longer be delivered, so they are
+// closed now instead of when they idle out.
+func (a *Agent) resetStreamsForPeer(peerID identity.AgentID) {
+	reset := 0
+	for _, s := range a.streamMgr.GetAllStreams() {
+		if s.RemoteID == peerID {
+			a.streamMgr.HandleStreamReset(s.ID, protocol.ErrHostUnreachable)
+			reset++
+		}
+	}
+	if reset > 0 {
+		a.logger.Debug("reset streams through disconnected peer",
+			logging.KeyPeerID, peerID.ShortString(),
+			logging.KeyCount, reset)
 	}
 }
 
@@ -2799,6 +3796,14 @@ func (a *Agent) Dial(network, address string) (net.Conn, error) {
 // DialContext implements socks5.Dialer for SOCKS5 connections with context support.
 // This allows cancellation when the client disconnects during dial.
 func (a *Agent) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	return a.dialWithMiddleware(ctx, network, address, func() (net.Conn, error) {
+		return a.dialContext(ctx, network, address)
+	})
+}
+
+// dialContext routes a connection through the mesh, to a local exit, or
+// directly when no route covers the destination.
+func (a *Agent) dialContext(ctx context.Context, network, address string) (net.Conn, error) {
 	// Parse the address
 	host, portStr, err := net.SplitHostPort(address)
 	if err != nil {
@@ -2810,12 +3815,29 @@ func (a *Agent) DialContext(ctx context.Context, network, address string) (net.C
 		return nil, fmt.Errorf("invalid port: %w", err)
 	}
 
+	// Quiet peers are reconnected on first use after their link dropped
+	if conns := a.peerMgr.ConnectQuietPeers(ctx); len(conns) > 0 {
+		a.waitForQuietRoute(ctx, host)
+	}
+
+	// Only exits in the client's namespace are used
+	ns := a.streamNamespace(ctx)
+
+	// An exit selected through the SOCKS5 username overrides route lookup
+	if exitHint := socks5.ExitHintFromContext(ctx); exitHint != "" {
+		return a.dialViaSelectedExit(ctx, network, host, port, exitHint, ns)
+	}
+
 	// Check if host is already an IP address
 	destIP := net.ParseIP(host)
 
 	// If host is a domain, check domain routes BEFORE DNS resolution
 	if destIP == nil {
-		domainRoute := a.routeMgr.LookupDomain(host)
+		policy := a.remoteDNSPolicy()
+		var domainRoute *routing.DomainRoute
+		if policy != config.RemoteDNSLocal {
+			domainRoute = a.routeMgr.LookupDomainIn(host, ns)
+		}
 		if domainRoute != nil {
 			// If domain route points to us (local exit), resolve DNS and dial directly
 			if domainRoute.OriginAgent == a.id {
@@ -2826,15 +3848,27 @@ func (a *Agent) DialContext(ctx context.Context, network, address string) (net.C
 				if len(ips) == 0 {
 					return nil, fmt.Errorf("no IP addresses for %s", host)
 				}
-				var dialer net.Dialer
-				return dialer.DialContext(ctx, network, net.JoinHostPort(ips[0].String(), portStr))
+				if a.cfg.Exit.BlockPrivate {
+					if ips = a.exitPrivateFilter().Filter(ips); len(ips) == 0 {
+						return nil, errPrivateDestination(host)
+					}
+				}
+				if ips = a.exitUserPolicy().Filter(socks5.UserFromContext(ctx), host, ips); len(ips) == 0 {
+					return nil, errUserNotAllowed(host)
+				}
+				return a.dialLocalExit(ctx, network, net.JoinHostPort(ips[0].String(), portStr))
 			}
 
 			// Route via domain route - exit node will resolve DNS
 			return a.dialViaDomainRouteWithContext(ctx, network, host, port, domainRoute)
 		}
 
-		// No domain route - resolve DNS at ingress
+		// No domain route - the default route exit resolves DNS
+		if policy == config.RemoteDNSAlways {
+			return a.dialViaDefaultRoute(ctx, network, host, port, ns)
+		}
+
+		// Resolve DNS at ingress
 		ips, err := net.LookupIP(host)
 		if err != nil {
 			return nil, fmt.Errorf("resolve %s: %w", host, err)
@@ -2846,27 +3880,94 @@ func (a *Agent) DialContext(ctx context.Context, network, address string) (net.C
 	}
 
 	// Look up CIDR route in routing table
-	route := a.routeMgr.Lookup(destIP)
+	route := a.routeMgr.LookupIn(destIP, ns)
 
 	// If no route, or route is to ourselves (local exit), do direct dial
 	if route == nil || route.OriginAgent == a.id {
-		dialer := &net.Dialer{Timeout: directDialTimeout}
+		if route != nil && a.cfg.Exit.BlockPrivate && a.exitPrivateFilter().Blocks(destIP) {
+			return nil, errPrivateDestination(host)
+		}
+		if route != nil && len(a.exitUserPolicy().Filter(socks5.UserFromContext(ctx), host, []net.IP{destIP})) == 0 {
+			return nil, errUserNotAllowed(host)
+		}
+		if route != nil {
+			return a.dialLocalExit(ctx, network, address)
+		}
+		if !a.directAllowed(ctx, ns) {
+			return nil, errNoMeshRoute(host)
+		}
+		dialer := &net.Dialer{Timeout: a.cfg.SOCKS5.ConnectTimeout}
 		return dialer.DialContext(ctx, network, address)
 	}
 
-	// Route through mesh - get next hop connection
-	conn := a.peerMgr.GetPeer(route.NextHop)
-	if conn == nil {
+	// Route through mesh - next hop must be connected
+	if a.peerMgr.GetPeer(route.NextHop) == nil {
+		if !a.directAllowed(ctx, ns) {
+			return nil, errNoMeshRoute(host)
+		}
 		// Next hop not connected, fall back to direct
-		dialer := &net.Dialer{Timeout: directDialTimeout}
+		dialer := &net.Dialer{Timeout: a.cfg.SOCKS5.ConnectTimeout}
 		return dialer.DialContext(ctx, network, address)
 	}
 
+	return a.dialIPWithRetry(ctx, host, destIP, port, route, ns)
+}
+
+// waitForQuietRoute waits up to quietRouteWait for a route to host after
+// quiet peers were reconnected, so the first connection does not miss routes
+// that are still being advertised.
+func (a *Agent) waitForQuietRoute(ctx context.Context, host string) {
+	ns := a.streamNamespace(ctx)
+	ip := net.ParseIP(host)
+	isDomain := ip == nil
+	remoteDNS := a.remoteDNSPolicy() == config.RemoteDNSAlways
+	if isDomain && !remoteDNS && a.routeMgr.LookupDomainIn(host, ns) == nil {
+		if ips, err := a.resolver.LookupIP(ctx, "ip", host); err == nil && len(ips) > 0 {
+			ip = ips[0]
+		}
+	}
+
+	deadline := time.NewTimer(quietRouteWait)
+	defer deadline.Stop()
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		if ip != nil && a.routeMgr.LookupIn(ip, ns) != nil {
+			return
+		}
+		if isDomain && a.routeMgr.LookupDomainIn(host, ns) != nil {
+			return
+		}
+		if isDomain && remoteDNS && a.defaultRoute(ns) != nil {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-deadline.C:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// dialIPViaPath opens a mesh stream to destIP:port along path, starting at
+// nextHop. host is the name the client asked for, used for stream tracking.
+func (a *Agent) dialIPViaPath(ctx context.Context, host string, destIP net.IP, port int, nextHop identity.AgentID, path []identity.AgentID) (net.Conn, error) {
+	conn := a.peerMgr.GetPeer(nextHop)
+	if conn == nil {
+		return nil, &streamOpenError{
+			code: protocol.ErrHostUnreachable,
+			err:  fmt.Errorf("next hop %s not connected", nextHop.ShortString()),
+		}
+	}
+
 	// Build the path for STREAM_OPEN
 	var remainingPath []identity.AgentID
-	if len(route.Path) > 1 {
-		remainingPath = make([]identity.AgentID, len(route.Path)-1)
-		copy(remainingPath, route.Path[1:])
+	if len(path) > 1 {
+		remainingPath = make([]identity.AgentID, len(path)-1)
+		copy(remainingPath, path[1:])
 	}
 
 	// Generate stream ID
@@ -2890,7 +3991,8 @@ func (a *Agent) DialContext(ctx context.Context, network, address string) (net.C
 	}
 
 	// Create the stream in stream manager
-	pending := a.streamMgr.OpenStream(streamID, route.NextHop, host, uint16(port), 30*time.Second)
+	pending := a.streamMgr.OpenStream(streamID, nextHop, host, uint16(port), 30*time.Second)
+	pending.Stream.User = socks5.UserFromContext(ctx)
 
 	// Store ephemeral keys in pending request for later key derivation
 	a.streamMgr.SetPendingEphemeralKeys(pending.RequestID, ephPriv, ephPub)
@@ -2901,19 +4003,27 @@ func (a *Agent) DialContext(ctx context.Context, network, address string) (net.C
 		AddressType:     addrType,
 		Address:         addrBytes,
 		Port:            uint16(port),
+		TTL:             a.hopLimit(),
 		RemainingPath:   remainingPath,
 		EphemeralPubKey: ephPub,
+		E2EFlags:        a.e2eOpenFlags(),
+		MaxPayload:      a.pathMaxPayload(0, nextHop),
 	}
+	a.setClientIdentity(ctx, openPayload)
 
 	frame := &protocol.Frame{
 		Type:     protocol.FrameStreamOpen,
+		Flags:    protocol.ClassInteractive.Flags(),
 		StreamID: streamID,
 		Payload:  openPayload.Encode(),
 	}
 
-	if err := a.peerMgr.SendToPeer(route.NextHop, frame); err != nil {
+	if err := a.peerMgr.SendToPeer(nextHop, frame); err != nil {
 		a.streamMgr.CancelPendingRequest(pending.RequestID)
-		return nil, fmt.Errorf("send stream open: %w", err)
+		return nil, &streamOpenError{
+			code: protocol.ErrHostUnreachable,
+			err:  fmt.Errorf("send stream open: %w", err),
+		}
 	}
 
 	// Wait for response with context support
@@ -2930,7 +4040,7 @@ func (a *Agent) DialContext(ctx context.Context, network, address string) (net.C
 
 	if result.Error != nil {
 		crypto.ZeroKey(&ephPriv)
-		return nil, result.Error
+		return nil, &streamOpenError{code: result.ErrorCode, err: result.Error}
 	}
 
 	// Derive session key from ECDH with exit node's ephemeral public key
@@ -2940,11 +4050,11 @@ func (a *Agent) DialContext(ctx context.Context, network, address string) (net.C
 		return nil, fmt.Errorf("compute ECDH: %w", err)
 	}
 
-	crypto.ZeroKey(&ephPriv)
-
 	// Derive session key - we are the initiator
 	sessionKey := crypto.DeriveSessionKey(sharedSecret, pending.RequestID, ephPub, result.RemoteEphemeral, true)
 	crypto.ZeroKey(&sharedSecret)
+	a.enableRekey(sessionKey, result.E2EFlags, ephPriv, result.RemoteEphemeral)
+	crypto.ZeroKey(&ephPriv)
 
 	// Store session key in stream
 	result.Stream.SetSessionKey(sessionKey)
@@ -2953,7 +4063,7 @@ func (a *Agent) DialContext(ctx context.Context, network, address string) (net.C
 	return &meshConn{
 		agent:    a,
 		stream:   result.Stream,
-		peerID:   route.NextHop,
+		peerID:   nextHop,
 		streamID: streamID,
 		localAddr: &net.TCPAddr{
 			IP:   result.BoundIP,
@@ -2977,7 +4087,10 @@ func (a *Agent) dialViaDomainRouteWithContext(ctx context.Context, network, host
 	// Get next hop connection
 	conn := a.peerMgr.GetPeer(route.NextHop)
 	if conn == nil {
-		return nil, fmt.Errorf("next hop %s not connected", route.NextHop.ShortString())
+		return nil, &streamOpenError{
+			code: protocol.ErrHostUnreachable,
+			err:  fmt.Errorf("next hop %s not connected", route.NextHop.ShortString()),
+		}
 	}
 
 	// Build the path for STREAM_OPEN
@@ -3003,6 +4116,7 @@ func (a *Agent) dialViaDomainRouteWithContext(ctx context.Context, network, host
 
 	// Create the stream in stream manager
 	pending := a.streamMgr.OpenStream(streamID, route.NextHop, host, uint16(port), 30*time.Second)
+	pending.Stream.User = socks5.UserFromContext(ctx)
 
 	// Store ephemeral keys in pending request for later key derivation
 	a.streamMgr.SetPendingEphemeralKeys(pending.RequestID, ephPriv, ephPub)
@@ -3013,19 +4127,27 @@ func (a *Agent) dialViaDomainRouteWithContext(ctx context.Context, network, host
 		AddressType:     protocol.AddrTypeDomain,
 		Address:         addrBytes,
 		Port:            uint16(port),
+		TTL:             a.hopLimit(),
 		RemainingPath:   remainingPath,
 		EphemeralPubKey: ephPub,
+		E2EFlags:        a.e2eOpenFlags(),
+		MaxPayload:      a.pathMaxPayload(0, route.NextHop),
 	}
+	a.setClientIdentity(ctx, openPayload)
 
 	frame := &protocol.Frame{
 		Type:     protocol.FrameStreamOpen,
+		Flags:    protocol.ClassInteractive.Flags(),
 		StreamID: streamID,
 		Payload:  openPayload.Encode(),
 	}
 
 	if err := a.peerMgr.SendToPeer(route.NextHop, frame); err != nil {
 		a.streamMgr.CancelPendingRequest(pending.RequestID)
-		return nil, fmt.Errorf("send stream open: %w", err)
+		return nil, &streamOpenError{
+			code: protocol.ErrHostUnreachable,
+			err:  fmt.Errorf("send stream open: %w", err),
+		}
 	}
 
 	// Wait for response with context support
@@ -3042,7 +4164,7 @@ func (a *Agent) dialViaDomainRouteWithContext(ctx context.Context, network, host
 
 	if result.Error != nil {
 		crypto.ZeroKey(&ephPriv)
-		return nil, result.Error
+		return nil, &streamOpenError{code: result.ErrorCode, err: result.Error}
 	}
 
 	// Derive session key from ECDH with exit node's ephemeral public key
@@ -3052,11 +4174,11 @@ func (a *Agent) dialViaDomainRouteWithContext(ctx context.Context, network, host
 		return nil, fmt.Errorf("compute ECDH: %w", err)
 	}
 
-	crypto.ZeroKey(&ephPriv)
-
 	// Derive session key - we are the initiator
 	sessionKey := crypto.DeriveSessionKey(sharedSecret, pending.RequestID, ephPub, result.RemoteEphemeral, true)
 	crypto.ZeroKey(&sharedSecret)
+	a.enableRekey(sessionKey, result.E2EFlags, ephPriv, result.RemoteEphemeral)
+	crypto.ZeroKey(&ephPriv)
 
 	// Store session key in stream
 	result.Stream.SetSessionKey(sessionKey)
@@ -3081,8 +4203,15 @@ func (a *Agent) dialViaDomainRouteWithContext(ctx context.Context, network, host
 // DialForward implements forward.ForwardDialer for port forward connections.
 // This routes connections through the mesh network to a forward endpoint.
 func (a *Agent) DialForward(ctx context.Context, key string) (net.Conn, error) {
+	return a.dialWithMiddleware(ctx, "tcp", "forward:"+key, func() (net.Conn, error) {
+		return a.dialForward(ctx, key)
+	})
+}
+
+// dialForward opens a stream to the agent serving the forward endpoint.
+func (a *Agent) dialForward(ctx context.Context, key string) (net.Conn, error) {
 	// Look up forward route
-	route := a.routeMgr.LookupForward(key)
+	route := a.routeMgr.LookupForwardIn(key, a.streamNamespace(ctx))
 	if route == nil {
 		return nil, fmt.Errorf("no route for forward: %s", key)
 	}
@@ -3127,12 +4256,17 @@ func (a *Agent) DialForward(ctx context.Context, key string) (net.Conn, error) {
 		AddressType:     protocol.AddrTypeDomain,
 		Address:         addrBytes,
 		Port:            0, // Not used for forwards
+		TTL:             a.hopLimit(),
 		RemainingPath:   remainingPath,
 		EphemeralPubKey: ephPub,
+		E2EFlags:        a.e2eOpenFlags(),
+		MaxPayload:      a.pathMaxPayload(0, route.NextHop),
 	}
+	a.setClientIdentity(ctx, openPayload)
 
 	frame := &protocol.Frame{
 		Type:     protocol.FrameStreamOpen,
+		Flags:    protocol.ClassInteractive.Flags(),
 		StreamID: streamID,
 		Payload:  openPayload.Encode(),
 	}
@@ -3166,11 +4300,11 @@ func (a *Agent) DialForward(ctx context.Context, key string) (net.Conn, error) {
 		return nil, fmt.Errorf("compute ECDH: %w", err)
 	}
 
-	crypto.ZeroKey(&ephPriv)
-
 	// Derive session key - we are the initiator
 	sessionKey := crypto.DeriveSessionKey(sharedSecret, pending.RequestID, ephPub, result.RemoteEphemeral, true)
 	crypto.ZeroKey(&sharedSecret)
+	a.enableRekey(sessionKey, result.E2EFlags, ephPriv, result.RemoteEphemeral)
+	crypto.ZeroKey(&ephPriv)
 
 	// Store session key in stream
 	result.Stream.SetSessionKey(sessionKey)
@@ -3266,6 +4400,11 @@ func (c *meshConn) Read(b []byte) (int, error) {
 
 	plaintext, err := sessionKey.Decrypt(data)
 	if err != nil {
+		c.agent.logger.Warn("stream data failed E2E validation, stream reset",
+			logging.KeyStreamID, c.streamID,
+			logging.KeyError, err)
+		c.agent.WriteStreamReset(c.peerID, c.streamID, protocol.ErrE2EValidation)
+		c.agent.streamMgr.RemoveStream(c.streamID)
 		return 0, fmt.Errorf("decrypt: %w", err)
 	}
 
@@ -3278,7 +4417,8 @@ func (c *meshConn) Read(b []byte) (int, error) {
 }
 
 // Write writes data to the mesh connection.
-// Chunks data larger than MaxPayloadSize into multiple frames.
+// Chunks data into frames that fit the payload limit of every link on the
+// path: the first hop and the path limit reported in STREAM_OPEN_ACK.
 func (c *meshConn) Write(b []byte) (int, error) {
 	if !c.stream.CanWrite() {
 		return 0, fmt.Errorf("stream closed for writing")
@@ -3295,7 +4435,7 @@ func (c *meshConn) Write(b []byte) (int, error) {
 	}
 
 	// Account for encryption overhead when chunking
-	maxPlaintext := protocol.MaxPayloadSize - crypto.EncryptionOverhead
+	maxPlaintext := c.agent.pathPlaintext(c.peerID, c.stream.PathMaxPayload())
 
 	// Chunk data into max plaintext size pieces, encrypt each, and send
 	for offset := 0; offset < len(b); {
@@ -3322,6 +4462,7 @@ func (c *meshConn) Write(b []byte) (int, error) {
 			// Return bytes written so far
 			return offset, err
 		}
+		c.stream.RecordSent(len(ciphertext))
 
 		offset = end
 	}
@@ -3526,6 +4667,7 @@ func (a *Agent) GetPeerDetails() []health.PeerDetails {
 		if displayName == "" {
 			displayName = p.RemoteID.ShortString()
 		}
+		txRate, rxRate := p.ByteRates()
 		details[i] = health.PeerDetails{
 			ID:          p.RemoteID,
 			DisplayName: displayName,
@@ -3533,6 +4675,15 @@ func (a *Agent) GetPeerDetails() []health.PeerDetails {
 			RTT:         p.RTT(),
 			IsDialer:    p.IsDialer(),
 			Transport:   string(p.TransportType()),
+
+			ProtocolVersion: p.ProtocolVersion(),
+			Features:        p.Features(),
+			MaxPayload:      p.MaxPayload(),
+
+			BytesSent:     p.BytesSent(),
+			BytesRecv:     p.BytesReceived(),
+			TxBytesPerSec: txRate,
+			RxBytesPerSec: rxRate,
 		}
 	}
 	return details
@@ -3548,17 +4699,31 @@ func (a *Agent) GetRouteDetails() []health.RouteDetails {
 		pathCopy := make([]identity.AgentID, len(r.Path))
 		copy(pathCopy, r.Path)
 		details[i] = health.RouteDetails{
-			Network:  r.Network.String(),
-			NextHop:  r.NextHop,
-			Origin:   r.OriginAgent,
-			Metric:   int(r.Metric),
-			HopCount: len(r.Path),
-			Path:     pathCopy,
+			Network:     r.Network.String(),
+			NextHop:     r.NextHop,
+			Origin:      r.OriginAgent,
+			Metric:      int(r.Metric),
+			HopCount:    len(r.Path),
+			Path:        pathCopy,
+			Unreachable: r.Unreachable,
+			Alternates:  a.countAlternates(r),
 		}
 	}
 	return details
 }
 
+// countAlternates returns the number of fast reroute alternates for r that
+// avoid its current next hop.
+func (a *Agent) countAlternates(r *routing.Route) int {
+	count := 0
+	for _, alt := range a.routeMgr.Table().GetAlternates(r.Network) {
+		if alt.OriginAgent == r.OriginAgent && !slices.Contains(alt.Path, r.NextHop) {
+			count++
+		}
+	}
+	return count
+}
+
 // GetDomainRouteDetails returns detailed domain route information for the dashboard.
 func (a *Agent) GetDomainRouteDetails() []health.DomainRouteDetails {
 	routes := a.routeMgr.DomainTable().GetAllRoutes()
@@ -3593,7 +4758,17 @@ func (a *Agent) GetAllNodeInfo() map[identity.AgentID]*protocol.NodeInfo {
 
 // GetLocalNodeInfo returns local node info.
 func (a *Agent) GetLocalNodeInfo() *protocol.NodeInfo {
-	return sysinfo.Collect(a.displayNameForAdvertise(), a.getPeerConnectionInfo(), a.keypair.PublicKey, a.getUDPConfig(), a.getForwardConfig(), a.getFileTransferConfig(), a.getShellConfig(), a.getICMPConfig())
+	return a.collectNodeInfo()
+}
+
+// collectNodeInfo gathers the node info advertised to the mesh.
+func (a *Agent) collectNodeInfo() *protocol.NodeInfo {
+	info := sysinfo.Collect(a.displayNameForAdvertise(), a.getPeerConnectionInfo(), a.keypair.PublicKey, a.getUDPConfig(), a.getForwardConfig(), a.getFileTransferConfig(), a.getShellConfig(), a.getICMPConfig())
+	if a.cfg.Exit.Enabled {
+		info.Tags = a.cfg.Exit.Tags
+	}
+	info.AgentTags = a.cfg.Agent.Tags
+	return info
 }
 
 // getUDPConfig returns the UDP configuration for node info advertisements.
@@ -3745,6 +4920,7 @@ func (a *Agent) getPeerConnectionInfo() []protocol.PeerConnectionInfo {
 		peerInfo.Transport = string(p.TransportType())
 		peerInfo.RTTMs = p.RTT().Milliseconds()
 		peerInfo.IsDialer = p.IsDialer()
+		peerInfo.TxBytesPerSec, peerInfo.RxBytesPerSec = p.ByteRates()
 		info = append(info, peerInfo)
 	}
 	return info
@@ -3767,7 +4943,9 @@ func (a *Agent) HealthServerAddress() net.Addr {
 }
 
 // WriteStreamData implements exit.StreamWriter.
-// Chunks data larger than MaxPayloadSize into multiple frames.
+// Chunks data larger than the payload limit negotiated on the link to the
+// peer into multiple frames. Encrypted data must be encrypted in pieces of
+// at most MaxStreamPlaintext bytes so each piece fits one frame on the link.
 func (a *Agent) WriteStreamData(peerID identity.AgentID, streamID uint64, data []byte, flags uint8) error {
 	// Handle empty data with flags (e.g., FIN_WRITE)
 	if len(data) == 0 {
@@ -3780,9 +4958,10 @@ func (a *Agent) WriteStreamData(peerID identity.AgentID, streamID uint64, data [
 		return a.peerMgr.SendToPeer(peerID, frame)
 	}
 
-	// Chunk data into MaxPayloadSize pieces
+	// Chunk data into pieces of the link's payload limit
+	maxPayload := a.peerMgr.MaxPayload(peerID)
 	for offset := 0; offset < len(data); {
-		end := offset + protocol.MaxPayloadSize
+		end := offset + maxPayload
 		if end > len(data) {
 			end = len(data)
 		}
@@ -3813,8 +4992,33 @@ func (a *Agent) WriteStreamData(peerID identity.AgentID, streamID uint64, data [
 	return nil
 }
 
+// MaxStreamPlaintext implements exit.StreamWriter. Returns the largest
+// plaintext that encrypts into one frame on the link to a peer.
+func (a *Agent) MaxStreamPlaintext(peerID identity.AgentID) int {
+	return a.peerMgr.MaxPayload(peerID) - crypto.MaxEncryptionOverhead
+}
+
+// pathPlaintext returns the largest plaintext that encrypts into one frame
+// on the link to peerID and on the rest of a stream's path, given the path
+// payload limit from STREAM_OPEN_ACK (0 if unknown).
+func (a *Agent) pathPlaintext(peerID identity.AgentID, pathMax int) int {
+	return protocol.PathMaxPayload(pathMax, a.peerMgr.MaxPayload(peerID)) - crypto.MaxEncryptionOverhead
+}
+
+// pathMaxPayload lowers the path payload limit carried by STREAM_OPEN or
+// STREAM_OPEN_ACK to the limit of the link to peerID. Returns 0 while the
+// path takes full-size frames, so the field is only sent when a link is
+// constrained.
+func (a *Agent) pathMaxPayload(path uint16, peerID identity.AgentID) uint16 {
+	limit := protocol.PathMaxPayload(int(path), a.peerMgr.MaxPayload(peerID))
+	if limit >= protocol.MaxPayloadSize {
+		return 0
+	}
+	return uint16(limit)
+}
+
 // WriteStreamOpenAck implements exit.StreamWriter.
-func (a *Agent) WriteStreamOpenAck(peerID identity.AgentID, streamID uint64, requestID uint64, boundIP net.IP, boundPort uint16, ephemeralPubKey [crypto.KeySize]byte) error {
+func (a *Agent) WriteStreamOpenAck(peerID identity.AgentID, streamID uint64, requestID uint64, boundIP net.IP, boundPort uint16, ephemeralPubKey [crypto.KeySize]byte, e2eFlags uint8) error {
 	var addrType uint8
 	var addrBytes []byte
 	if ip4 := boundIP.To4(); ip4 != nil {
@@ -3834,6 +5038,8 @@ func (a *Agent) WriteStreamOpenAck(peerID identity.AgentID, streamID uint64, req
 		BoundAddr:       addrBytes,
 		BoundPort:       boundPort,
 		EphemeralPubKey: ephemeralPubKey,
+		E2EFlags:        e2eFlags,
+		MaxPayload:      a.pathMaxPayload(0, peerID), // Relays lower it on the way back
 	}
 	frame := &protocol.Frame{
 		Type:     protocol.FrameStreamOpenAck,
@@ -3867,6 +5073,17 @@ func (a *Agent) WriteStreamClose(peerID identity.AgentID, streamID uint64) error
 	return a.peerMgr.SendToPeer(peerID, frame)
 }
 
+// WriteStreamReset sends a STREAM_RESET frame with the given error code.
+func (a *Agent) WriteStreamReset(peerID identity.AgentID, streamID uint64, errorCode uint16) error {
+	reset := &protocol.StreamReset{ErrorCode: errorCode}
+	frame := &protocol.Frame{
+		Type:     protocol.FrameStreamReset,
+		StreamID: streamID,
+		Payload:  reset.Encode(),
+	}
+	return a.peerMgr.SendToPeer(peerID, frame)
+}
+
 // deriveResponderSessionKey performs E2E key exchange for a responder (receiving a stream open).
 // Returns the session key and our ephemeral public key, or an error.
 func deriveResponderSessionKey(requestID uint64, remoteEphemeralPub [crypto.KeySize]byte) (*crypto.SessionKey, [crypto.KeySize]byte, error) {
@@ -3900,7 +5117,7 @@ func deriveResponderSessionKey(requestID uint64, remoteEphemeralPub [crypto.KeyS
 }
 
 // handleFileTransferStreamOpen is the common handler for file upload/download stream opens.
-func (a *Agent) handleFileTransferStreamOpen(peerID identity.AgentID, streamID uint64, requestID uint64, remoteEphemeralPub [crypto.KeySize]byte, isUpload bool) {
+func (a *Agent) handleFileTransferStreamOpen(peerID identity.AgentID, streamID uint64, requestID uint64, remoteEphemeralPub [crypto.KeySize]byte, isUpload bool, pathMaxPayload int) {
 	opName := "download"
 	if isUpload {
 		opName = "upload"
@@ -3936,6 +5153,8 @@ func (a *Agent) handleFileTransferStreamOpen(peerID identity.AgentID, streamID u
 		IsUpload:     isUpload,
 		MetaReceived: false,
 		sessionKey:   sessionKey,
+
+		pathMaxPayload: pathMaxPayload,
 	}
 
 	a.fileStreamsMu.Lock()
@@ -3943,17 +5162,17 @@ func (a *Agent) handleFileTransferStreamOpen(peerID identity.AgentID, streamID u
 	a.fileStreamsMu.Unlock()
 
 	// Send ACK with our ephemeral public key for E2E encryption
-	a.WriteStreamOpenAck(peerID, streamID, requestID, nil, 0, ephPub)
+	a.WriteStreamOpenAck(peerID, streamID, requestID, nil, 0, ephPub, 0)
 }
 
 // handleFileUploadStreamOpen handles a file upload stream open request.
-func (a *Agent) handleFileUploadStreamOpen(peerID identity.AgentID, streamID uint64, requestID uint64, remoteEphemeralPub [crypto.KeySize]byte) {
-	a.handleFileTransferStreamOpen(peerID, streamID, requestID, remoteEphemeralPub, true)
+func (a *Agent) handleFileUploadStreamOpen(peerID identity.AgentID, streamID uint64, requestID uint64, remoteEphemeralPub [crypto.KeySize]byte, pathMaxPayload int) {
+	a.handleFileTransferStreamOpen(peerID, streamID, requestID, remoteEphemeralPub, true, pathMaxPayload)
 }
 
 // handleFileDownloadStreamOpen handles a file download stream open request.
-func (a *Agent) handleFileDownloadStreamOpen(peerID identity.AgentID, streamID uint64, requestID uint64, remoteEphemeralPub [crypto.KeySize]byte) {
-	a.handleFileTransferStreamOpen(peerID, streamID, requestID, remoteEphemeralPub, false)
+func (a *Agent) handleFileDownloadStreamOpen(peerID identity.AgentID, streamID uint64, requestID uint64, remoteEphemeralPub [crypto.KeySize]byte, pathMaxPayload int) {
+	a.handleFileTransferStreamOpen(peerID, streamID, requestID, remoteEphemeralPub, false, pathMaxPayload)
 }
 
 // handleShellStreamOpen handles a shell stream open request.
@@ -3976,7 +5195,7 @@ func (a *Agent) handleShellStreamOpen(peerID identity.AgentID, streamID uint64,
 	}
 
 	// Send ACK with our ephemeral public key for E2E encryption
-	a.WriteStreamOpenAck(peerID, streamID, requestID, nil, 0, localEphemeralPub)
+	a.WriteStreamOpenAck(peerID, streamID, requestID, nil, 0, localEphemeralPub, 0)
 }
 
 // handleFileTransferStreamData processes data for a file transfer stream.
@@ -4002,10 +5221,10 @@ func (a *Agent) handleFileTransferStreamData(peerID identity.AgentID, streamID u
 
 	plaintext, err := sessionKey.Decrypt(data)
 	if err != nil {
-		a.logger.Error("failed to decrypt file transfer data",
+		a.logger.Warn("file transfer data failed E2E validation, stream reset",
 			logging.KeyStreamID, streamID,
 			logging.KeyError, err)
-		a.closeFileTransferStream(streamID, protocol.ErrGeneralFailure, "decryption failed")
+		a.closeFileTransferStream(streamID, protocol.ErrE2EValidation, "E2E validation failed")
 		return
 	}
 
@@ -4042,6 +5261,9 @@ func (a *Agent) handleFileTransferStreamData(peerID identity.AgentID, streamID u
 				return
 			}
 			fts.TempFile = tmpFile
+			if meta.Trailer {
+				fts.received = filetransfer.NewChecksum()
+			}
 
 			a.logger.Info("file upload started",
 				"path", meta.Path,
@@ -4065,6 +5287,23 @@ func (a *Agent) handleFileTransferStreamData(peerID identity.AgentID, streamID u
 
 	// Subsequent data frames contain file content (only for uploads)
 	if fts.IsUpload && fts.TempFile != nil {
+		fin := flags&protocol.FlagFinWrite != 0
+
+		// With a trailer, the final frame carries the checksum instead of data
+		if fin && fts.Meta.Trailer {
+			trailer, err := filetransfer.ParseMetadata(plaintext)
+			if err != nil {
+				a.logger.Error("invalid file upload trailer",
+					logging.KeyStreamID, streamID,
+					logging.KeyError, err)
+				a.closeFileTransferStream(streamID, protocol.ErrConnectionRefused, "invalid trailer")
+				return
+			}
+			fts.Trailer = trailer
+			go a.completeFileUpload(fts)
+			return
+		}
+
 		// Write decrypted data directly to disk (streaming, no memory buffering)
 		n, err := fts.TempFile.Write(plaintext)
 		if err != nil {
@@ -4075,9 +5314,12 @@ func (a *Agent) handleFileTransferStreamData(peerID identity.AgentID, streamID u
 			return
 		}
 		fts.BytesWritten += int64(n)
+		if fts.received != nil {
+			fts.received.Write(plaintext[:n])
+		}
 
 		// Check for FIN flag (end of upload)
-		if flags&protocol.FlagFinWrite != 0 {
+		if fin {
 			go a.completeFileUpload(fts)
 		}
 	}
@@ -4102,37 +5344,80 @@ func (a *Agent) completeFileUpload(fts *fileTransferStream) {
 	fts.TempFile.Close()
 	defer os.Remove(tmpPath) // Clean up temp file when done
 
+	// The sender gave up part way; nothing is written
+	if fts.Trailer != nil && fts.Trailer.Error != "" {
+		a.logger.Warn("file upload aborted by sender",
+			"path", fts.Meta.Path,
+			logging.KeyError, fts.Trailer.Error)
+		a.WriteStreamClose(fts.PeerID, fts.StreamID)
+		return
+	}
+
 	tmpFile, err := os.Open(tmpPath)
 	if err != nil {
 		a.logger.Error("failed to reopen temp file",
 			logging.KeyStreamID, fts.StreamID,
 			logging.KeyError, err)
-		a.WriteStreamOpenErr(fts.PeerID, fts.StreamID, fts.RequestID, protocol.ErrWriteFailed, err.Error())
+		a.closeFileTransferStream(fts.StreamID, protocol.ErrWriteFailed, err.Error())
 		return
 	}
 	defer tmpFile.Close()
 
-	// Write file to final destination
-	written, err := a.fileStreamHandler.WriteUploadedFile(
-		fts.Meta.Path,
-		tmpFile,
-		fts.Meta.Mode,
-		fts.Meta.IsDirectory,
-		fts.Meta.Compress,
-	)
+	// Write file to final destination. With a checksum, files are only
+	// moved into place once verified, and directory archives are verified
+	// before anything is extracted.
+	var written int64
+	switch {
+	case fts.Trailer == nil:
+		written, err = a.fileStreamHandler.WriteUploadedFile(
+			fts.Meta.Path,
+			tmpFile,
+			fts.Meta.Mode,
+			fts.Meta.IsDirectory,
+			fts.Meta.Compress,
+			fts.Meta.CopyOptions(),
+		)
+	case fts.Meta.IsDirectory:
+		err = filetransfer.VerifyChecksum(fts.Trailer.Checksum, filetransfer.ChecksumHex(fts.received))
+		if err == nil {
+			written, err = a.fileStreamHandler.WriteUploadedFile(fts.Meta.Path, tmpFile, fts.Meta.Mode, true, fts.Meta.Compress, fts.Meta.CopyOptions())
+		}
+	default:
+		written, err = a.fileStreamHandler.WriteVerifiedFile(
+			fts.Meta.Path,
+			tmpFile,
+			fts.Meta.Mode,
+			fts.Meta.Compress,
+			fts.Trailer.Checksum,
+			fts.Meta.CopyOptions(),
+		)
+	}
 
 	if err != nil {
+		errCode := protocol.ErrWriteFailed
+		if errors.Is(err, filetransfer.ErrChecksumMismatch) {
+			errCode = protocol.ErrChecksumMismatch
+		}
 		a.logger.Error("file upload write failed",
 			logging.KeyStreamID, fts.StreamID,
+			"path", fts.Meta.Path,
 			logging.KeyError, err)
-		a.WriteStreamOpenErr(fts.PeerID, fts.StreamID, fts.RequestID, protocol.ErrWriteFailed, err.Error())
+		a.closeFileTransferStream(fts.StreamID, errCode, err.Error())
 		return
 	}
 
-	a.logger.Info("file upload completed",
-		"path", fts.Meta.Path,
-		"bytes_received", fts.BytesWritten,
-		"bytes_written", written)
+	if fts.Trailer != nil {
+		a.logger.Info("file upload completed",
+			"path", fts.Meta.Path,
+			"bytes_received", fts.BytesWritten,
+			"bytes_written", written,
+			"sha256", fts.Trailer.Checksum)
+	} else {
+		a.logger.Info("file upload completed",
+			"path", fts.Meta.Path,
+			"bytes_received", fts.BytesWritten,
+			"bytes_written", written)
+	}
 
 	// Send close to signal completion
 	a.WriteStreamClose(fts.PeerID, fts.StreamID)
@@ -4161,15 +5446,21 @@ func (a *Agent) sendFileDownload(fts *fileTransferStream) {
 	var err error
 	var originalSize int64
 
-	// Check if this is a resume request
-	if fts.Meta.Offset > 0 {
+	// Requesters that can handle a checksum trailer ask for one
+	var sum hash.Hash
+	if fts.Meta.Trailer {
+		sum = filetransfer.NewChecksum()
+	}
+
+	// Check if this is a resume or range request
+	if fts.Meta.Offset > 0 || fts.Meta.Length > 0 {
 		// Validate that file hasn't changed
 		info, statErr := os.Stat(fts.Meta.Path)
 		if statErr != nil {
 			a.logger.Error("file download stat failed",
 				logging.KeyStreamID, fts.StreamID,
 				logging.KeyError, statErr)
-			a.WriteStreamOpenErr(fts.PeerID, fts.StreamID, fts.RequestID, protocol.ErrFileNotFound, statErr.Error())
+			a.closeFileTransferStream(fts.StreamID, protocol.ErrFileNotFound, statErr.Error())
 			return
 		}
 
@@ -4181,29 +5472,42 @@ func (a *Agent) sendFileDownload(fts *fileTransferStream) {
 				logging.KeyStreamID, fts.StreamID,
 				"expected_size", fts.Meta.OriginalSize,
 				"actual_size", info.Size())
-			a.WriteStreamOpenErr(fts.PeerID, fts.StreamID, fts.RequestID, protocol.ErrResumeFailed, "file size changed")
+			a.closeFileTransferStream(fts.StreamID, protocol.ErrResumeFailed, "file size changed")
 			return
 		}
 
 		// Use offset-aware reader
-		reader, size, mode, isDir, err = a.fileStreamHandler.ReadFileForDownloadAtOffset(
-			fts.Meta.Path, fts.Meta.Offset, fts.Meta.Compress)
+		if fts.Meta.Length > 0 {
+			reader, size, mode, err = a.fileStreamHandler.ReadFileRange(
+				fts.Meta.Path, fts.Meta.Offset, fts.Meta.Length, fts.Meta.Compress, sum)
+		} else if sum != nil {
+			reader, size, mode, isDir, err = a.fileStreamHandler.ReadFileForDownloadChecked(
+				fts.Meta.Path, fts.Meta.Offset, fts.Meta.Compress, sum, fts.Meta.CopyOptions())
+		} else {
+			reader, size, mode, isDir, err = a.fileStreamHandler.ReadFileForDownloadAtOffset(
+				fts.Meta.Path, fts.Meta.Offset, fts.Meta.Compress)
+		}
 		if err != nil {
 			a.logger.Error("file download read at offset failed",
 				logging.KeyStreamID, fts.StreamID,
 				"offset", fts.Meta.Offset,
 				logging.KeyError, err)
-			a.WriteStreamOpenErr(fts.PeerID, fts.StreamID, fts.RequestID, protocol.ErrGeneralFailure, err.Error())
+			a.closeFileTransferStream(fts.StreamID, protocol.ErrGeneralFailure, err.Error())
 			return
 		}
 	} else {
 		// Normal download from beginning
-		reader, size, mode, isDir, err = a.fileStreamHandler.ReadFileForDownload(fts.Meta.Path, fts.Meta.Compress)
+		if sum != nil {
+			reader, size, mode, isDir, err = a.fileStreamHandler.ReadFileForDownloadChecked(
+				fts.Meta.Path, 0, fts.Meta.Compress, sum, fts.Meta.CopyOptions())
+		} else {
+			reader, size, mode, isDir, err = a.fileStreamHandler.ReadFileForDownload(fts.Meta.Path, fts.Meta.Compress, fts.Meta.CopyOptions())
+		}
 		if err != nil {
 			a.logger.Error("file download read failed",
 				logging.KeyStreamID, fts.StreamID,
 				logging.KeyError, err)
-			a.WriteStreamOpenErr(fts.PeerID, fts.StreamID, fts.RequestID, protocol.ErrFileNotFound, err.Error())
+			a.closeFileTransferStream(fts.StreamID, protocol.ErrFileNotFound, err.Error())
 			return
 		}
 
@@ -4229,6 +5533,8 @@ func (a *Agent) sendFileDownload(fts *fileTransferStream) {
 		IsDirectory:  isDir,
 		Compress:     fts.Meta.Compress,
 		OriginalSize: originalSize, // Include original size for resume tracking
+		Length:       fts.Meta.Length,
+		Trailer:      sum != nil,
 	}
 	metaData, err := filetransfer.EncodeMetadata(respMeta)
 	if err != nil {
@@ -4251,12 +5557,14 @@ func (a *Agent) sendFileDownload(fts *fileTransferStream) {
 		"path", fts.Meta.Path,
 		"size", size,
 		"offset", fts.Meta.Offset,
+		"length", fts.Meta.Length,
 		"rate_limit", fts.Meta.RateLimit,
 		"is_directory", isDir)
 
 	// Stream file data in chunks
 	// Leave room for encryption overhead (nonce + auth tag) plus protocol overhead
-	buf := make([]byte, protocol.MaxPayloadSize-100-crypto.NonceSize-crypto.TagSize)
+	buf := make([]byte, a.pathPlaintext(fts.PeerID, fts.pathMaxPayload)-100)
+	var failed error
 	for {
 		n, readErr := reader.Read(buf)
 		if n > 0 {
@@ -4269,8 +5577,9 @@ func (a *Agent) sendFileDownload(fts *fileTransferStream) {
 				return
 			}
 
+			// With a trailer, FIN_WRITE goes on the trailer frame instead
 			flags := uint8(0)
-			if readErr == io.EOF {
+			if readErr == io.EOF && sum == nil {
 				flags = protocol.FlagFinWrite
 			}
 			if err := a.WriteStreamData(fts.PeerID, fts.StreamID, encryptedData, flags); err != nil {
@@ -4285,6 +5594,7 @@ func (a *Agent) sendFileDownload(fts *fileTransferStream) {
 				a.logger.Error("file read error",
 					logging.KeyStreamID, fts.StreamID,
 					logging.KeyError, readErr)
+				failed = readErr
 			}
 			break
 		}
@@ -4295,7 +5605,26 @@ func (a *Agent) sendFileDownload(fts *fileTransferStream) {
 		closer.Close()
 	}
 
-	a.logger.Info("file download completed", "path", fts.Meta.Path)
+	if sum != nil {
+		trailer := &filetransfer.TransferMetadata{Checksum: filetransfer.ChecksumHex(sum)}
+		if failed != nil {
+			trailer = &filetransfer.TransferMetadata{
+				Error:     "read failed: " + failed.Error(),
+				ErrorCode: protocol.ErrGeneralFailure,
+			}
+		}
+		if err := a.writeFileTrailer(fts.PeerID, fts.StreamID, fts.sessionKey, trailer); err != nil {
+			a.logger.Error("failed to send file download trailer",
+				logging.KeyStreamID, fts.StreamID,
+				logging.KeyError, err)
+			return
+		}
+		if failed == nil {
+			a.logger.Info("file download completed", "path", fts.Meta.Path, "sha256", trailer.Checksum)
+		}
+	} else {
+		a.logger.Info("file download completed", "path", fts.Meta.Path)
+	}
 
 	// Send close to signal completion
 	a.WriteStreamClose(fts.PeerID, fts.StreamID)
@@ -4318,12 +5647,17 @@ func (a *Agent) closeFileTransferStream(streamID uint64, errCode uint16, message
 	requestID := fts.RequestID
 	a.fileStreamsMu.Unlock()
 
-	// If stream has a session key, it's already open and we need to send
-	// an encrypted error response instead of STREAM_OPEN_ERR
-	if sessionKey != nil {
+	// A stream whose data failed E2E validation cannot be trusted to carry
+	// an error response, so it is reset. Otherwise, if the stream has a
+	// session key, it's already open and we need to send an encrypted error
+	// response instead of STREAM_OPEN_ERR
+	if errCode == protocol.ErrE2EValidation {
+		a.WriteStreamReset(peerID, streamID, errCode)
+	} else if sessionKey != nil {
 		// Send error as encrypted metadata response
 		errMeta := &filetransfer.TransferMetadata{
-			Error: message,
+			Error:     message,
+			ErrorCode: errCode,
 		}
 		metaData, err := filetransfer.EncodeMetadata(errMeta)
 		if err == nil {
@@ -4371,22 +5705,24 @@ func (a *Agent) getFileTransferStream(streamID uint64) *fileTransferStream {
 
 // UploadFile uploads a local file or directory to a remote agent via stream-based transfer.
 // The transfer uses the mesh network to reach the target agent.
-func (a *Agent) UploadFile(ctx context.Context, targetID identity.AgentID, localPath, remotePath string, opts health.TransferOptions, progress health.FileTransferProgress) error {
+// It returns the SHA-256 of the sent content (the file, or the tar.gz archive
+// of a directory), which the remote agent verified before accepting it.
+func (a *Agent) UploadFile(ctx context.Context, targetID identity.AgentID, localPath, remotePath string, opts health.TransferOptions, progress health.FileTransferProgress) (string, error) {
 	// Check if file transfer is enabled locally (for validation config)
 	if a.fileStreamHandler == nil {
-		return fmt.Errorf("file transfer is disabled")
+		return "", fmt.Errorf("file transfer is disabled")
 	}
 
 	// Get file info
 	info, err := os.Stat(localPath)
 	if err != nil {
-		return fmt.Errorf("cannot access local path: %w", err)
+		return "", fmt.Errorf("cannot access local path: %w", err)
 	}
 
 	// Find path to target agent
 	nextHop, remainingPath, conn, err := a.findPathToAgent(targetID)
 	if err != nil {
-		return fmt.Errorf("no route to agent %s: %w", targetID.ShortString(), err)
+		return "", fmt.Errorf("no route to agent %s: %w", targetID.ShortString(), err)
 	}
 
 	// Allocate stream ID
@@ -4395,7 +5731,7 @@ func (a *Agent) UploadFile(ctx context.Context, targetID identity.AgentID, local
 	// Generate ephemeral keypair for E2E encryption key exchange
 	ephPriv, ephPub, err := crypto.GenerateEphemeralKeypair()
 	if err != nil {
-		return fmt.Errorf("generate ephemeral key: %w", err)
+		return "", fmt.Errorf("generate ephemeral key: %w", err)
 	}
 
 	// Create pending stream (5 min timeout for large files)
@@ -4413,19 +5749,22 @@ func (a *Agent) UploadFile(ctx context.Context, targetID identity.AgentID, local
 		AddressType:     protocol.AddrTypeDomain,
 		Address:         domainBytes,
 		Port:            0,
+		TTL:             a.hopLimit(),
 		RemainingPath:   remainingPath,
 		EphemeralPubKey: ephPub,
+		MaxPayload:      a.pathMaxPayload(0, nextHop),
 	}
 
 	frame := &protocol.Frame{
 		Type:     protocol.FrameStreamOpen,
+		Flags:    protocol.ClassBulk.Flags(),
 		StreamID: streamID,
 		Payload:  openPayload.Encode(),
 	}
 
 	if err := a.peerMgr.SendToPeer(nextHop, frame); err != nil {
 		crypto.ZeroKey(&ephPriv)
-		return fmt.Errorf("send stream open: %w", err)
+		return "", fmt.Errorf("send stream open: %w", err)
 	}
 
 	// Wait for STREAM_OPEN_ACK
@@ -4434,18 +5773,18 @@ func (a *Agent) UploadFile(ctx context.Context, targetID identity.AgentID, local
 	case result = <-pending.ResultCh:
 		if result.Error != nil {
 			crypto.ZeroKey(&ephPriv)
-			return fmt.Errorf("stream open failed: %w", result.Error)
+			return "", fmt.Errorf("stream open failed: %w", result.Error)
 		}
 	case <-ctx.Done():
 		crypto.ZeroKey(&ephPriv)
-		return ctx.Err()
+		return "", ctx.Err()
 	}
 
 	// Derive session key from ECDH with remote agent's ephemeral public key
 	sharedSecret, err := crypto.ComputeECDH(ephPriv, result.RemoteEphemeral)
 	if err != nil {
 		crypto.ZeroKey(&ephPriv)
-		return fmt.Errorf("compute ECDH: %w", err)
+		return "", fmt.Errorf("compute ECDH: %w", err)
 	}
 
 	// Zero out ephemeral private key after computing shared secret
@@ -4471,23 +5810,31 @@ func (a *Agent) UploadFile(ctx context.Context, targetID identity.AgentID, local
 		Password:    opts.Password,
 		Compress:    true,
 		RateLimit:   opts.RateLimit,
+		Trailer:     true,
+		// How the remote agent writes what it receives
+		Sparse:           opts.CopyOptions.Sparse,
+		ExternalSymlinks: opts.CopyOptions.ExternalSymlinks,
+	}
+	sum := filetransfer.NewChecksum()
+	trailer := func() *filetransfer.TransferMetadata {
+		return &filetransfer.TransferMetadata{Checksum: filetransfer.ChecksumHex(sum)}
 	}
 
 	// Encode and encrypt metadata
 	metaData, err := filetransfer.EncodeMetadata(meta)
 	if err != nil {
 		a.WriteStreamClose(nextHop, streamID)
-		return fmt.Errorf("encode metadata: %w", err)
+		return "", fmt.Errorf("encode metadata: %w", err)
 	}
 
 	encryptedMeta, err := sessionKey.Encrypt(metaData)
 	if err != nil {
 		a.WriteStreamClose(nextHop, streamID)
-		return fmt.Errorf("encrypt metadata: %w", err)
+		return "", fmt.Errorf("encrypt metadata: %w", err)
 	}
 
 	if err := a.WriteStreamData(nextHop, streamID, encryptedMeta, 0); err != nil {
-		return fmt.Errorf("send metadata: %w", err)
+		return "", fmt.Errorf("send metadata: %w", err)
 	}
 
 	// Capture the stream reference now (before streaming) so we can read the
@@ -4512,7 +5859,7 @@ func (a *Agent) UploadFile(ctx context.Context, targetID identity.AgentID, local
 				responseMeta, parseErr := filetransfer.ParseMetadata(decryptedResponse)
 				if parseErr == nil && responseMeta.Error != "" {
 					a.WriteStreamClose(nextHop, streamID)
-					return fmt.Errorf("remote error: %s", responseMeta.Error)
+					return "", transferError(responseMeta)
 				}
 			}
 		}
@@ -4527,7 +5874,7 @@ func (a *Agent) UploadFile(ctx context.Context, targetID identity.AgentID, local
 
 		// Start tar in goroutine
 		go func() {
-			err := filetransfer.TarDirectory(localPath, pw)
+			err := filetransfer.TarDirectory(localPath, pw, opts.CopyOptions)
 			if err != nil {
 				pw.CloseWithError(err)
 			} else {
@@ -4536,23 +5883,23 @@ func (a *Agent) UploadFile(ctx context.Context, targetID identity.AgentID, local
 		}()
 
 		// Apply rate limiting if requested
-		var reader io.Reader = pr
+		var reader io.Reader = io.TeeReader(pr, sum)
 		if opts.RateLimit > 0 {
-			reader = filetransfer.NewRateLimitedReader(ctx, pr, opts.RateLimit)
+			reader = filetransfer.NewRateLimitedReader(ctx, reader, opts.RateLimit)
 		}
 
 		// Stream tar data in chunks with encryption
-		written, err = a.streamFileContent(ctx, nextHop, streamID, reader, -1, progress, sessionKey)
+		written, err = a.streamFileContent(ctx, nextHop, streamID, reader, -1, progress, sessionKey, trailer)
 		if err != nil {
 			pr.Close()
-			return fmt.Errorf("stream directory: %w", err)
+			return "", fmt.Errorf("stream directory: %w", err)
 		}
 	} else {
 		// Open file and optionally compress
 		f, err := os.Open(localPath)
 		if err != nil {
 			a.WriteStreamClose(nextHop, streamID)
-			return fmt.Errorf("open file: %w", err)
+			return "", fmt.Errorf("open file: %w", err)
 		}
 		defer f.Close()
 
@@ -4561,7 +5908,7 @@ func (a *Agent) UploadFile(ctx context.Context, targetID identity.AgentID, local
 			pr, pw := io.Pipe()
 			go func() {
 				gzw := gzip.NewWriter(pw)
-				_, copyErr := io.Copy(gzw, f)
+				_, copyErr := io.Copy(gzw, io.TeeReader(f, sum))
 				gzw.Close()
 				if copyErr != nil {
 					pw.CloseWithError(copyErr)
@@ -4576,21 +5923,21 @@ func (a *Agent) UploadFile(ctx context.Context, targetID identity.AgentID, local
 				reader = filetransfer.NewRateLimitedReader(ctx, pr, opts.RateLimit)
 			}
 
-			written, err = a.streamFileContent(ctx, nextHop, streamID, reader, -1, progress, sessionKey)
+			written, err = a.streamFileContent(ctx, nextHop, streamID, reader, -1, progress, sessionKey, trailer)
 			if err != nil {
 				pr.Close()
-				return fmt.Errorf("stream file: %w", err)
+				return "", fmt.Errorf("stream file: %w", err)
 			}
 		} else {
 			// Apply rate limiting if requested
-			var reader io.Reader = f
+			var reader io.Reader = io.TeeReader(f, sum)
 			if opts.RateLimit > 0 {
-				reader = filetransfer.NewRateLimitedReader(ctx, f, opts.RateLimit)
+				reader = filetransfer.NewRateLimitedReader(ctx, reader, opts.RateLimit)
 			}
 
-			written, err = a.streamFileContent(ctx, nextHop, streamID, reader, fileSize, progress, sessionKey)
+			written, err = a.streamFileContent(ctx, nextHop, streamID, reader, fileSize, progress, sessionKey, trailer)
 			if err != nil {
-				return fmt.Errorf("stream file: %w", err)
+				return "", fmt.Errorf("stream file: %w", err)
 			}
 		}
 	}
@@ -4599,7 +5946,8 @@ func (a *Agent) UploadFile(ctx context.Context, targetID identity.AgentID, local
 		"target", targetID.ShortString(),
 		"local_path", localPath,
 		"remote_path", remotePath,
-		"bytes_sent", written)
+		"bytes_sent", written,
+		"sha256", filetransfer.ChecksumHex(sum))
 
 	// Wait for stream to close (server sends STREAM_CLOSE on completion or
 	// rejection). After close, drain any buffered response: the server may
@@ -4613,9 +5961,9 @@ func (a *Agent) UploadFile(ctx context.Context, targetID identity.AgentID, local
 		select {
 		case <-s.Done():
 		case <-ctx.Done():
-			return ctx.Err()
+			return "", ctx.Err()
 		case <-time.After(30 * time.Second):
-			return fmt.Errorf("timeout waiting for upload acknowledgement")
+			return "", fmt.Errorf("timeout waiting for upload acknowledgement")
 		}
 
 		// Drain any buffered response (error metadata sent before STREAM_CLOSE).
@@ -4631,12 +5979,12 @@ func (a *Agent) UploadFile(ctx context.Context, targetID identity.AgentID, local
 			}
 			respMeta, parseErr := filetransfer.ParseMetadata(decrypted)
 			if parseErr == nil && respMeta.Error != "" {
-				return fmt.Errorf("remote error: %s", respMeta.Error)
+				return "", transferError(respMeta)
 			}
 		}
 	}
 
-	return nil
+	return filetransfer.ChecksumHex(sum), nil
 }
 
 // DownloadFile downloads a file or directory from a remote agent via stream-based transfer.
@@ -4676,12 +6024,15 @@ func (a *Agent) DownloadFile(ctx context.Context, targetID identity.AgentID, rem
 		AddressType:     protocol.AddrTypeDomain,
 		Address:         downloadDomainBytes,
 		Port:            0,
+		TTL:             a.hopLimit(),
 		RemainingPath:   remainingPath,
 		EphemeralPubKey: ephPub,
+		MaxPayload:      a.pathMaxPayload(0, nextHop),
 	}
 
 	frame := &protocol.Frame{
 		Type:     protocol.FrameStreamOpen,
+		Flags:    protocol.ClassBulk.Flags(),
 		StreamID: streamID,
 		Payload:  openPayload.Encode(),
 	}
@@ -4727,6 +6078,12 @@ func (a *Agent) DownloadFile(ctx context.Context, targetID identity.AgentID, rem
 		RateLimit:    opts.RateLimit,
 		Offset:       opts.Offset,
 		OriginalSize: opts.OriginalSize,
+		// The checksum covers the whole file, which is only available
+		// here when downloading from the start
+		Trailer: opts.Offset == 0,
+		// How the remote agent archives directories
+		Symlinks:  opts.CopyOptions.Symlinks,
+		Hardlinks: opts.CopyOptions.Hardlinks,
 	}
 
 	metaData, err := filetransfer.EncodeMetadata(meta)
@@ -4762,6 +6119,11 @@ func (a *Agent) DownloadFile(ctx context.Context, targetID identity.AgentID, rem
 		return fmt.Errorf("parse response metadata: %w", err)
 	}
 
+	// Check for error response from server
+	if err := transferError(responseMeta); err != nil {
+		return err
+	}
+
 	a.logger.Info("file download started",
 		"target", targetID.ShortString(),
 		"remote_path", remotePath,
@@ -4769,17 +6131,19 @@ func (a *Agent) DownloadFile(ctx context.Context, targetID identity.AgentID, rem
 		"size", responseMeta.Size,
 		"is_directory", responseMeta.IsDirectory)
 
-	// Receive file data with E2E decryption and write to local path
+	// Receive file data with E2E decryption and write to local path.
+	// Senders that do not know about trailers leave Trailer unset.
+	frames := &transferFrames{stream: s, sessionKey: sessionKey, trailer: responseMeta.Trailer}
 	var written int64
 	if responseMeta.IsDirectory {
 		// Receive tar stream and extract
-		written, err = a.receiveAndExtractDirectory(ctx, s, localPath, responseMeta.Size, progress, sessionKey)
+		written, err = a.receiveAndExtractDirectory(ctx, frames, localPath, responseMeta.Size, opts.CopyOptions, progress)
 		if err != nil {
 			return fmt.Errorf("receive directory: %w", err)
 		}
 	} else {
 		// Receive file data
-		written, err = a.receiveAndWriteFile(ctx, s, localPath, responseMeta.Mode, responseMeta.Size, responseMeta.Compress, progress, sessionKey)
+		written, err = a.receiveAndWriteFile(ctx, frames, localPath, responseMeta.Mode, responseMeta.Size, responseMeta.Compress, opts.CopyOptions.Sparse, progress)
 		if err != nil {
 			return fmt.Errorf("receive file: %w", err)
 		}
@@ -4789,7 +6153,8 @@ func (a *Agent) DownloadFile(ctx context.Context, targetID identity.AgentID, rem
 		"target", targetID.ShortString(),
 		"remote_path", remotePath,
 		"local_path", localPath,
-		"bytes_received", written)
+		"bytes_received", written,
+		"sha256", frames.checksum())
 
 	// Send STREAM_CLOSE to acknowledge completion
 	a.WriteStreamClose(nextHop, streamID)
@@ -4807,7 +6172,7 @@ func (a *Agent) DownloadFileStream(ctx context.Context, targetID identity.AgentI
 	}
 
 	// Find path to target agent
-	nextHop, remainingPath, conn, err := a.findPathToAgent(targetID)
+	nextHop, remainingPath, conn, err := a.findPathToAgentVia(targetID, opts.Route)
 	if err != nil {
 		return nil, fmt.Errorf("no route to agent %s: %w", targetID.ShortString(), err)
 	}
@@ -4835,12 +6200,15 @@ func (a *Agent) DownloadFileStream(ctx context.Context, targetID identity.AgentI
 		AddressType:     protocol.AddrTypeDomain,
 		Address:         downloadDomainBytes,
 		Port:            0,
+		TTL:             a.hopLimit(),
 		RemainingPath:   remainingPath,
 		EphemeralPubKey: ephPub,
+		MaxPayload:      a.pathMaxPayload(0, nextHop),
 	}
 
 	frame := &protocol.Frame{
 		Type:     protocol.FrameStreamOpen,
+		Flags:    protocol.ClassBulk.Flags(),
 		StreamID: streamID,
 		Payload:  openPayload.Encode(),
 	}
@@ -4886,6 +6254,10 @@ func (a *Agent) DownloadFileStream(ctx context.Context, targetID identity.AgentI
 		RateLimit:    opts.RateLimit,
 		Offset:       opts.Offset,
 		OriginalSize: opts.OriginalSize,
+		Length:       opts.Length,
+		Trailer:      true,
+		Symlinks:     opts.CopyOptions.Symlinks,
+		Hardlinks:    opts.CopyOptions.Hardlinks,
 	}
 
 	metaData, err := filetransfer.EncodeMetadata(meta)
@@ -4927,7 +6299,13 @@ func (a *Agent) DownloadFileStream(ctx context.Context, targetID identity.AgentI
 	// Check for error response from server
 	if responseMeta.Error != "" {
 		a.WriteStreamClose(nextHop, streamID)
-		return nil, fmt.Errorf("remote error: %s", responseMeta.Error)
+		return nil, transferError(responseMeta)
+	}
+
+	// Older agents ignore the length and send the rest of the file
+	if opts.Length > 0 && responseMeta.Length != opts.Length {
+		a.WriteStreamClose(nextHop, streamID)
+		return nil, fmt.Errorf("agent %s does not support ranged downloads", targetID.ShortString())
 	}
 
 	a.logger.Info("file download stream started",
@@ -4942,11 +6320,11 @@ func (a *Agent) DownloadFileStream(ctx context.Context, targetID identity.AgentI
 	// Directories ship to the HTTP handler as raw gz(tar(...)) so the
 	// Content-Type: application/gzip header matches the body bytes; only
 	// regular file downloads have their gzip wrapper peeled off here.
+	frames := &transferFrames{stream: s, sessionKey: sessionKey, trailer: responseMeta.Trailer}
 	reader := &streamReader{
-		stream:     s,
+		frames:     frames,
 		ctx:        ctx,
 		compressed: responseMeta.Compress && !responseMeta.IsDirectory,
-		sessionKey: sessionKey,
 	}
 
 	// Cleanup function to close the stream when done
@@ -4961,20 +6339,20 @@ func (a *Agent) DownloadFileStream(ctx context.Context, targetID identity.AgentI
 		Mode:         responseMeta.Mode,
 		IsDirectory:  responseMeta.IsDirectory,
 		Compressed:   responseMeta.Compress,
+		Checksum:     frames.checksum,
 		Close:        cleanup,
 	}, nil
 }
 
 // streamReader wraps a stream for io.Reader interface with E2E decryption.
 type streamReader struct {
-	stream     *stream.Stream
+	frames     *transferFrames
 	ctx        context.Context
 	compressed bool
 	gzReader   io.ReadCloser
 	buffer     []byte
 	bufOffset  int
 	eof        bool
-	sessionKey *crypto.SessionKey
 }
 
 func (r *streamReader) Read(p []byte) (int, error) {
@@ -4994,8 +6372,8 @@ func (r *streamReader) Read(p []byte) (int, error) {
 		return 0, io.EOF
 	}
 
-	// Read from stream
-	data, err := r.stream.ReadWithTimeout(30 * time.Second)
+	// Read from stream (decrypted with the E2E session key)
+	data, err := r.frames.next(30 * time.Second)
 	if err != nil {
 		if err == io.EOF {
 			r.eof = true
@@ -5003,15 +6381,6 @@ func (r *streamReader) Read(p []byte) (int, error) {
 		return 0, err
 	}
 
-	// Decrypt data using E2E session key
-	if r.sessionKey != nil {
-		plaintext, decErr := r.sessionKey.Decrypt(data)
-		if decErr != nil {
-			return 0, fmt.Errorf("decrypt stream data: %w", decErr)
-		}
-		data = plaintext
-	}
-
 	// If compressed and this is the first read, set up gzip reader.
 	if r.compressed && r.gzReader == nil {
 		// We need to create a gzip reader, but it needs an io.Reader
@@ -5025,7 +6394,7 @@ func (r *streamReader) Read(p []byte) (int, error) {
 
 			// Continue reading from stream and writing to pipe
 			for {
-				chunk, err := r.stream.ReadWithTimeout(30 * time.Second)
+				chunk, err := r.frames.next(30 * time.Second)
 				if err != nil {
 					if err == io.EOF {
 						pw.Close()
@@ -5034,15 +6403,6 @@ func (r *streamReader) Read(p []byte) (int, error) {
 					}
 					return
 				}
-				// Decrypt chunk using E2E session key
-				if r.sessionKey != nil {
-					plaintext, decErr := r.sessionKey.Decrypt(chunk)
-					if decErr != nil {
-						pw.CloseWithError(fmt.Errorf("decrypt stream chunk: %w", decErr))
-						return
-					}
-					chunk = plaintext
-				}
 				if _, err := pw.Write(chunk); err != nil {
 					pw.CloseWithError(err)
 					return
@@ -5126,10 +6486,59 @@ func (a *Agent) findPathToAgent(targetID identity.AgentID) (identity.AgentID, []
 	return bestRoute.NextHop, remainingPath, conn, nil
 }
 
+// findPathToAgentVia is findPathToAgent for the route-th path to the target,
+// counting a direct connection first and then the agent presence routes by
+// metric, one per next hop. It wraps around when there are fewer paths, so
+// parallel transfers can spread their streams without knowing how many
+// paths exist.
+func (a *Agent) findPathToAgentVia(targetID identity.AgentID, route int) (identity.AgentID, []identity.AgentID, *peer.Connection, error) {
+	if route <= 0 {
+		return a.findPathToAgent(targetID)
+	}
+
+	type path struct {
+		nextHop   identity.AgentID
+		remaining []identity.AgentID
+		conn      *peer.Connection
+	}
+	var paths []path
+	if conn := a.peerMgr.GetPeer(targetID); conn != nil {
+		paths = append(paths, path{nextHop: targetID, conn: conn})
+	}
+	seen := make(map[identity.AgentID]bool)
+	for _, r := range a.routeMgr.LookupAgentRoutes(targetID) {
+		if r.NextHop == targetID || seen[r.NextHop] {
+			continue
+		}
+		conn := a.peerMgr.GetPeer(r.NextHop)
+		if conn == nil {
+			continue
+		}
+		seen[r.NextHop] = true
+		var remaining []identity.AgentID
+		if len(r.Path) > 1 {
+			remaining = make([]identity.AgentID, len(r.Path)-1)
+			copy(remaining, r.Path[1:])
+		}
+		paths = append(paths, path{nextHop: r.NextHop, remaining: remaining, conn: conn})
+	}
+
+	if len(paths) == 0 {
+		return a.findPathToAgent(targetID)
+	}
+	p := paths[route%len(paths)]
+	return p.nextHop, p.remaining, p.conn, nil
+}
+
 // streamFileContent streams data from a reader to the peer in chunks with E2E encryption.
-func (a *Agent) streamFileContent(ctx context.Context, peerID identity.AgentID, streamID uint64, r io.Reader, totalSize int64, progress health.FileTransferProgress, sessionKey *crypto.SessionKey) (int64, error) {
+// After the data it sends the metadata returned by trailer as the final frame.
+func (a *Agent) streamFileContent(ctx context.Context, peerID identity.AgentID, streamID uint64, r io.Reader, totalSize int64, progress health.FileTransferProgress, sessionKey *crypto.SessionKey, trailer func() *filetransfer.TransferMetadata) (int64, error) {
 	// Leave room for frame overhead and encryption overhead (nonce + auth tag)
-	buf := make([]byte, protocol.MaxPayloadSize-100-crypto.EncryptionOverhead)
+	var pathMax int
+	if s := a.streamMgr.GetStream(streamID); s != nil {
+		pathMax = s.PathMaxPayload()
+	}
+	buf := make([]byte, a.pathPlaintext(peerID, pathMax)-100)
 	var totalWritten int64
 
 	for {
@@ -5147,12 +6556,7 @@ func (a *Agent) streamFileContent(ctx context.Context, peerID identity.AgentID,
 				return totalWritten, fmt.Errorf("encrypt data: %w", encErr)
 			}
 
-			flags := uint8(0)
-			if readErr == io.EOF {
-				flags = protocol.FlagFinWrite
-			}
-
-			if err := a.WriteStreamData(peerID, streamID, encryptedData, flags); err != nil {
+			if err := a.WriteStreamData(peerID, streamID, encryptedData, 0); err != nil {
 				return totalWritten, fmt.Errorf("write data: %w", err)
 			}
 			totalWritten += int64(n)
@@ -5164,16 +6568,9 @@ func (a *Agent) streamFileContent(ctx context.Context, peerID identity.AgentID,
 
 		if readErr != nil {
 			if readErr == io.EOF {
-				// Ensure FIN_WRITE is sent even if last read was empty
-				if n == 0 {
-					// Send empty encrypted payload with FIN flag
-					emptyEncrypted, encErr := sessionKey.Encrypt(nil)
-					if encErr != nil {
-						return totalWritten, fmt.Errorf("encrypt fin: %w", encErr)
-					}
-					if err := a.WriteStreamData(peerID, streamID, emptyEncrypted, protocol.FlagFinWrite); err != nil {
-						return totalWritten, fmt.Errorf("write fin: %w", err)
-					}
+				// The trailer carries FIN_WRITE
+				if err := a.writeFileTrailer(peerID, streamID, sessionKey, trailer()); err != nil {
+					return totalWritten, err
 				}
 				break
 			}
@@ -5186,7 +6583,7 @@ func (a *Agent) streamFileContent(ctx context.Context, peerID identity.AgentID,
 
 // receiveEncryptedStreamData receives and decrypts data from a stream into a buffer.
 // Returns the total bytes received (decrypted).
-func (a *Agent) receiveEncryptedStreamData(ctx context.Context, s *stream.Stream, sessionKey *crypto.SessionKey, totalSize int64, progress health.FileTransferProgress) (*bytes.Buffer, int64, error) {
+func (a *Agent) receiveEncryptedStreamData(ctx context.Context, frames *transferFrames, totalSize int64, progress health.FileTransferProgress) (*bytes.Buffer, int64, error) {
 	var dataBuf bytes.Buffer
 	var totalReceived int64
 
@@ -5197,39 +6594,14 @@ func (a *Agent) receiveEncryptedStreamData(ctx context.Context, s *stream.Stream
 		default:
 		}
 
-		data, err := s.ReadWithTimeout(30 * time.Second)
+		plaintext, err := frames.next(30 * time.Second)
+		if err == io.EOF {
+			break
+		}
 		if err != nil {
-			if err == io.EOF {
-				break
-			}
-			// Try to drain remaining data if stream is closed
-			if s.IsClosed() {
-				for {
-					select {
-					case remaining := <-s.ReadBuffer():
-						plaintext, decErr := sessionKey.Decrypt(remaining)
-						if decErr != nil {
-							return &dataBuf, totalReceived, fmt.Errorf("decrypt remaining data: %w", decErr)
-						}
-						dataBuf.Write(plaintext)
-						totalReceived += int64(len(plaintext))
-					default:
-						return &dataBuf, totalReceived, nil
-					}
-				}
-			}
 			return &dataBuf, totalReceived, fmt.Errorf("read stream: %w", err)
 		}
 
-		if len(data) == 0 {
-			continue
-		}
-
-		plaintext, decErr := sessionKey.Decrypt(data)
-		if decErr != nil {
-			return &dataBuf, totalReceived, fmt.Errorf("decrypt data: %w", decErr)
-		}
-
 		dataBuf.Write(plaintext)
 		totalReceived += int64(len(plaintext))
 
@@ -5242,7 +6614,8 @@ func (a *Agent) receiveEncryptedStreamData(ctx context.Context, s *stream.Stream
 }
 
 // receiveAndWriteFile receives file data from a stream, decrypts with E2E session key, and writes to disk.
-func (a *Agent) receiveAndWriteFile(ctx context.Context, s *stream.Stream, localPath string, mode uint32, totalSize int64, compressed bool, progress health.FileTransferProgress, sessionKey *crypto.SessionKey) (int64, error) {
+// If the sender sent a checksum, a file that does not match it is removed.
+func (a *Agent) receiveAndWriteFile(ctx context.Context, frames *transferFrames, localPath string, mode uint32, totalSize int64, compressed, sparse bool, progress health.FileTransferProgress) (int64, error) {
 	// Create parent directories
 	dir := filepath.Dir(localPath)
 	if err := os.MkdirAll(dir, 0755); err != nil {
@@ -5254,10 +6627,17 @@ func (a *Agent) receiveAndWriteFile(ctx context.Context, s *stream.Stream, local
 	if err != nil {
 		return 0, fmt.Errorf("create file: %w", err)
 	}
-	defer f.Close()
+	var fw io.WriteCloser = f
+	if sparse {
+		if fw, err = filetransfer.NewSparseWriter(f); err != nil {
+			f.Close()
+			return 0, fmt.Errorf("create file: %w", err)
+		}
+	}
+	defer fw.Close()
 
 	// Receive encrypted data
-	dataBuf, _, err := a.receiveEncryptedStreamData(ctx, s, sessionKey, totalSize, progress)
+	dataBuf, _, err := a.receiveEncryptedStreamData(ctx, frames, totalSize, progress)
 	if err != nil {
 		return 0, err
 	}
@@ -5273,24 +6653,42 @@ func (a *Agent) receiveAndWriteFile(ctx context.Context, s *stream.Stream, local
 		reader = gzr
 	}
 
-	written, err := io.Copy(f, reader)
+	sum := filetransfer.NewChecksum()
+	written, err := io.Copy(io.MultiWriter(fw, sum), reader)
 	if err != nil {
 		return 0, fmt.Errorf("write file: %w", err)
 	}
 
+	if frames.trailer {
+		if err := filetransfer.VerifyChecksum(frames.checksum(), filetransfer.ChecksumHex(sum)); err != nil {
+			fw.Close()
+			os.Remove(localPath)
+			return 0, err
+		}
+	}
+
 	return written, nil
 }
 
 // receiveAndExtractDirectory receives tar stream data, decrypts with E2E session key, and extracts to a directory.
-func (a *Agent) receiveAndExtractDirectory(ctx context.Context, s *stream.Stream, localPath string, totalSize int64, progress health.FileTransferProgress, sessionKey *crypto.SessionKey) (int64, error) {
+// If the sender sent a checksum, the archive is verified before extraction.
+func (a *Agent) receiveAndExtractDirectory(ctx context.Context, frames *transferFrames, localPath string, totalSize int64, opts filetransfer.CopyOptions, progress health.FileTransferProgress) (int64, error) {
 	// Receive encrypted data
-	dataBuf, _, err := a.receiveEncryptedStreamData(ctx, s, sessionKey, totalSize, progress)
+	dataBuf, _, err := a.receiveEncryptedStreamData(ctx, frames, totalSize, progress)
 	if err != nil {
 		return 0, err
 	}
 
+	if frames.trailer {
+		sum := filetransfer.NewChecksum()
+		sum.Write(dataBuf.Bytes())
+		if err := filetransfer.VerifyChecksum(frames.checksum(), filetransfer.ChecksumHex(sum)); err != nil {
+			return 0, err
+		}
+	}
+
 	// Extract tar.gz to directory
-	if err := filetransfer.UntarDirectory(dataBuf, localPath); err != nil {
+	if err := filetransfer.UntarDirectory(dataBuf, localPath, opts); err != nil {
 		return 0, fmt.Errorf("extract directory: %w", err)
 	}
 
@@ -5348,12 +6746,15 @@ func (a *Agent) OpenShellStream(ctx context.Context, targetID identity.AgentID,
 		AddressType:     protocol.AddrTypeDomain,
 		Address:         domainBytes,
 		Port:            0,
+		TTL:             a.hopLimit(),
 		RemainingPath:   remainingPath,
 		EphemeralPubKey: ephPub,
+		MaxPayload:      a.pathMaxPayload(0, nextHop),
 	}
 
 	frame := &protocol.Frame{
 		Type:     protocol.FrameStreamOpen,
+		Flags:    protocol.ClassRPC.Flags(),
 		StreamID: streamID,
 		Payload:  openPayload.Encode(),
 	}
@@ -5514,9 +6915,12 @@ func (a *Agent) handleShellClientData(streamID uint64, data []byte, flags uint8)
 
 	plaintext, err := sessionKey.Decrypt(data)
 	if err != nil {
-		a.logger.Error("handleShellClientData: decryption failed",
+		a.logger.Warn("shell data failed E2E validation, stream reset",
 			logging.KeyStreamID, streamID,
 			logging.KeyError, err)
+		if s := a.streamMgr.GetStream(streamID); s != nil {
+			a.WriteStreamReset(s.RemoteID, streamID, protocol.ErrE2EValidation)
+		}
 		adapter.Close()
 		return false
 	}
@@ -5665,13 +7069,13 @@ func (a *Agent) handleQueuedState(peerID identity.AgentID, frame *protocol.Frame
 		"node_infos", len(state.NodeInfos))
 
 	// Process queued routes
-	for _, route := range state.Routes {
-		a.flooder.HandleRouteAdvertise(peerID, route.OriginAgent, route.OriginDisplayName, route.Sequence, route.Routes, route.EncPath, route.SeenBy)
+	for i := range state.Routes {
+		a.flooder.HandleAdvertise(peerID, &state.Routes[i])
 	}
 
 	// Process queued withdraws
-	for _, withdraw := range state.Withdraws {
-		a.flooder.HandleRouteWithdraw(peerID, withdraw.OriginAgent, withdraw.Sequence, withdraw.Routes, withdraw.SeenBy)
+	for i := range state.Withdraws {
+		a.flooder.HandleWithdraw(peerID, &state.Withdraws[i])
 	}
 
 	// Process queued node infos
@@ -5856,12 +7260,13 @@ func (a *Agent) doPoll() error {
 				logging.KeyError, err)
 			continue
 		}
+		allowed, _ := listenerCfg.GetAllowedAgents() // Checked by Validate
 		pollListeners = append(pollListeners, listener)
 		// Register immediately so connections are properly handled
 		a.listeners = append(a.listeners, listener)
 		// Start regular accept loop (not poll-specific)
 		a.wg.Add(1)
-		go a.acceptLoop(listener)
+		go a.acceptLoop(listener, allowed)
 	}
 
 	// Temporarily reconnect to peers
@@ -5936,9 +7341,10 @@ func (a *Agent) closePollListeners(pollListeners []transport.Listener) {
 
 // createPollListener creates a listener for a poll cycle without starting the regular accept loop.
 func (a *Agent) createPollListener(cfg config.ListenerConfig) (transport.Listener, error) {
-	// For plaintext WebSocket listeners (reverse proxy mode), skip TLS
+	// For plaintext WebSocket listeners (reverse proxy mode), skip TLS.
+	// Memory listeners never use TLS.
 	var tlsConfig *tls.Config
-	if cfg.PlainText {
+	if cfg.PlainText || cfg.Transport == string(transport.TransportMemory) {
 		// Plaintext - no TLS
 	} else {
 		// Determine effective mTLS setting (per-listener override or global)
@@ -5953,6 +7359,7 @@ func (a *Agent) createPollListener(cfg config.ListenerConfig) (transport.Listene
 		if err != nil {
 			return nil, fmt.Errorf("load TLS config: %w", err)
 		}
+		tlsConfig.KeyLogWriter = a.debugCap
 	}
 
 	// Select the appropriate transport based on config
@@ -5972,9 +7379,13 @@ func (a *Agent) createPollListener(cfg config.ListenerConfig) (transport.Listene
 		Path:          cfg.Path,
 		MaxStreams:    a.cfg.Limits.MaxStreamsTotal,
 		PlainText:     cfg.PlainText,
+		Family:        ipfamily.Family(cfg.AddressFamily),
 		ALPNProtocol:  a.cfg.Protocol.ALPN,
 		HTTPHeader:    a.cfg.Protocol.HTTPHeader,
 		WSSubprotocol: a.cfg.Protocol.WSSubprotocol,
+
+		VerifySourceAddress: a.accept.VerifySourceAddress,
+		QUICTracer:          a.debugCap.QUICTracer,
 	})
 	if err != nil {
 		return nil, err
@@ -6020,9 +7431,15 @@ func (a *Agent) pollAcceptLoop(ctx context.Context, listener transport.Listener)
 			}
 		}
 
+		done, err := a.accept.Admit(peerConn.RemoteAddr())
+		if err != nil {
+			peerConn.Close()
+			continue
+		}
+
 		// Handle the connection
 		a.wg.Add(1)
-		go a.handleIncomingConnection(peerConn)
+		go a.handleIncomingConnection(peerConn, nil, done)
 	}
 }
 
@@ -1,16 +1,37 @@
 package agent
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
 	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/postalsys/muti-metroo/internal/config"
 	"github.com/postalsys/muti-metroo/internal/crypto"
+	"github.com/postalsys/muti-metroo/internal/discovery"
+	"github.com/postalsys/muti-metroo/internal/exit"
+	"github.com/postalsys/muti-metroo/internal/health"
 	"github.com/postalsys/muti-metroo/internal/identity"
+	"github.com/postalsys/muti-metroo/internal/middleware"
+	"github.com/postalsys/muti-metroo/internal/peer"
 	"github.com/postalsys/muti-metroo/internal/protocol"
+	"github.com/postalsys/muti-metroo/internal/rbac"
+	"github.com/postalsys/muti-metroo/internal/routing"
+	"github.com/postalsys/muti-metroo/internal/scheduler"
+	"github.com/postalsys/muti-metroo/internal/socks5"
+	"github.com/postalsys/muti-metroo/internal/transport"
 )
 
 func TestNew(t *testing.T) {
@@ -1214,6 +1235,263 @@ func TestAgent_cleanupRelaysForPeer(t *testing.T) {
 	}
 }
 
+func TestRelayTable_Hooks(t *testing.T) {
+	var inserted, removed []uint64
+	table := newRelayTable()
+	table.setHooks(
+		func(e *relayEntry) { inserted = append(inserted, e.UpstreamID) },
+		func(e *relayEntry) { removed = append(removed, e.UpstreamID) },
+	)
+
+	peerA, _ := identity.NewAgentID()
+	peerB, _ := identity.NewAgentID()
+	e1 := &relayEntry{UpstreamPeer: peerA, UpstreamID: 1, DownstreamPeer: peerB, DownstreamID: 100}
+	e2 := &relayEntry{UpstreamPeer: peerA, UpstreamID: 2, DownstreamPeer: peerB, DownstreamID: 200}
+	e3 := &relayEntry{UpstreamPeer: peerA, UpstreamID: 3, DownstreamPeer: peerB, DownstreamID: 300}
+	table.Insert(e1)
+	table.Insert(e2)
+	table.Insert(e3)
+
+	table.Delete(e1)
+	table.Delete(e1) // Idempotent, no second hook call
+	table.PopByID(2)
+	table.DeleteByPeer(peerB)
+
+	if len(inserted) != 3 {
+		t.Errorf("insert hook calls = %v, want 3", inserted)
+	}
+	if len(removed) != 3 {
+		t.Errorf("remove hook calls = %v, want one per entry", removed)
+	}
+}
+
+func TestRelayTable_InsertOpen(t *testing.T) {
+	table := newRelayTable()
+	peerA, _ := identity.NewAgentID()
+	peerB, _ := identity.NewAgentID()
+	peerC, _ := identity.NewAgentID()
+	token := protocol.NewOpenChain().Token()
+
+	first := &relayEntry{UpstreamPeer: peerA, UpstreamID: 1, DownstreamPeer: peerC, DownstreamID: 100, OpenToken: token, OpenAttempt: 1}
+	if prev, dup, refused := table.InsertOpen(first); prev != nil || dup || refused {
+		t.Fatalf("InsertOpen(first) = %v, %v, %v; want nil, false, false", prev, dup, refused)
+	}
+
+	// The same open relayed again from the same upstream stream
+	again := &relayEntry{UpstreamPeer: peerA, UpstreamID: 1, DownstreamPeer: peerC, DownstreamID: 101, OpenToken: token, OpenAttempt: 1}
+	if prev, dup, refused := table.InsertOpen(again); prev != nil || !dup || refused {
+		t.Fatalf("InsertOpen(duplicate) = %v, %v, %v; want nil, true, false", prev, dup, refused)
+	}
+	if table.LookupDownstream(101) != nil {
+		t.Error("duplicate open should not be inserted")
+	}
+
+	// A retry over another path replaces the first attempt
+	retry := &relayEntry{UpstreamPeer: peerB, UpstreamID: 1, DownstreamPeer: peerC, DownstreamID: 102, OpenToken: token, OpenAttempt: 2}
+	if prev, dup, refused := table.InsertOpen(retry); prev != first || dup || refused {
+		t.Fatalf("InsertOpen(retry) = %v, %v, %v; want first entry, false, false", prev, dup, refused)
+	}
+	if table.LookupDownstream(100) != nil {
+		t.Error("superseded entry should be removed")
+	}
+	if table.LookupDownstream(102) != retry {
+		t.Error("retried entry should be inserted")
+	}
+
+	// The abandoned attempt arriving late over another path is refused
+	late := &relayEntry{UpstreamPeer: peerC, UpstreamID: 9, DownstreamPeer: peerA, DownstreamID: 103, OpenToken: token, OpenAttempt: 1}
+	if prev, dup, refused := table.InsertOpen(late); prev != nil || dup || !refused {
+		t.Fatalf("InsertOpen(late) = %v, %v, %v; want nil, false, true", prev, dup, refused)
+	}
+	if table.LookupDownstream(103) != nil || table.LookupDownstream(102) != retry {
+		t.Error("late attempt should not replace the retry")
+	}
+
+	// A transit agent copying the token and proof of the retry from another
+	// upstream stream cannot take it over
+	copied := &relayEntry{UpstreamPeer: peerA, UpstreamID: 7, DownstreamPeer: peerC, DownstreamID: 104, OpenToken: token, OpenAttempt: 2}
+	if prev, dup, refused := table.InsertOpen(copied); prev != nil || dup || !refused {
+		t.Fatalf("InsertOpen(copied) = %v, %v, %v; want nil, false, true", prev, dup, refused)
+	}
+
+	// Entries without a token are never deduplicated
+	table.InsertOpen(&relayEntry{UpstreamPeer: peerA, UpstreamID: 5, DownstreamPeer: peerC, DownstreamID: 500})
+	if prev, dup, refused := table.InsertOpen(&relayEntry{UpstreamPeer: peerA, UpstreamID: 6, DownstreamPeer: peerC, DownstreamID: 600}); prev != nil || dup || refused {
+		t.Errorf("InsertOpen(no token) = %v, %v, %v; want nil, false, false", prev, dup, refused)
+	}
+
+	// Removing the entry releases its token
+	table.Delete(retry)
+	if len(table.byToken) != 0 {
+		t.Errorf("byToken holds %d entries after delete, want 0", len(table.byToken))
+	}
+}
+
+func TestAgent_RelayStreamData(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "agent-test")
+	if err != nil {
+		t.Fatalf("Create temp dir error: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	cfg := config.Default()
+	cfg.Agent.DataDir = tmpDir
+
+	agent, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	peerA, _ := identity.NewAgentID()
+	peerB, _ := identity.NewAgentID()
+
+	relay := &relayEntry{
+		UpstreamPeer:   peerA,
+		UpstreamID:     1,
+		DownstreamPeer: peerB,
+		DownstreamID:   100,
+	}
+	agent.tcpRelay.Insert(relay)
+
+	// Data from the upstream peer belongs to the relay (the next hop is not
+	// connected here, so the send itself fails silently)
+	if !agent.relayStreamData(peerA, &protocol.Frame{Type: protocol.FrameStreamData, StreamID: 1, Payload: make([]byte, 10)}) {
+		t.Error("upstream data should be relayed")
+	}
+	if !agent.relayStreamData(peerB, &protocol.Frame{Type: protocol.FrameStreamData, StreamID: 100, Payload: make([]byte, 4)}) {
+		t.Error("downstream data should be relayed")
+	}
+	if relay.bytesUp.Load() != 10 || relay.bytesDown.Load() != 4 {
+		t.Errorf("relay bytes = %d/%d, want 10/4", relay.bytesUp.Load(), relay.bytesDown.Load())
+	}
+
+	// Same stream ID from the wrong peer is not part of the relay
+	if agent.relayStreamData(peerB, &protocol.Frame{Type: protocol.FrameStreamData, StreamID: 1}) {
+		t.Error("data from a peer outside the relay should not be relayed")
+	}
+}
+
+func TestAgent_ListAndKillStreams(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "agent-test")
+	if err != nil {
+		t.Fatalf("Create temp dir error: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	cfg := config.Default()
+	cfg.Agent.DataDir = tmpDir
+
+	agent, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	peerA, _ := identity.NewAgentID()
+	peerB, _ := identity.NewAgentID()
+
+	relay := &relayEntry{
+		UpstreamPeer:   peerA,
+		UpstreamID:     1,
+		DownstreamPeer: peerB,
+		DownstreamID:   100,
+		DestAddr:       "10.0.0.5:22",
+	}
+	agent.tcpRelay.Insert(relay)
+	relay.recordUpstream(64)
+
+	if _, err := agent.streamMgr.AcceptStream(7, 1, peerB, "example.com", 443); err != nil {
+		t.Fatalf("AcceptStream() error = %v", err)
+	}
+
+	streams := agent.ListStreams()
+	if len(streams) != 2 {
+		t.Fatalf("ListStreams() returned %d streams, want 2", len(streams))
+	}
+	if streams[0].Direction != health.StreamDirectionOutbound || streams[0].Destination != "example.com:443" {
+		t.Errorf("unexpected outbound stream: %+v", streams[0])
+	}
+	if streams[1].Direction != health.StreamDirectionRelay || streams[1].BytesSent != 64 || streams[1].FramesSent != 1 || streams[1].DownstreamID != 100 {
+		t.Errorf("unexpected relay stream: %+v", streams[1])
+	}
+
+	// Relay entries can be killed by their downstream ID as well
+	if err := agent.KillStream(100); err != nil {
+		t.Errorf("KillStream(100) error = %v", err)
+	}
+	if err := agent.KillStream(7); err != nil {
+		t.Errorf("KillStream(7) error = %v", err)
+	}
+	if len(agent.ListStreams()) != 0 {
+		t.Error("all streams should be gone after kill")
+	}
+	if err := agent.KillStream(7); !errors.Is(err, health.ErrStreamNotFound) {
+		t.Errorf("KillStream(unknown) error = %v, want ErrStreamNotFound", err)
+	}
+}
+
+func TestAgent_ManageIdle(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "agent-test")
+	if err != nil {
+		t.Fatalf("Create temp dir error: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	cfg := config.Default()
+	cfg.Agent.DataDir = tmpDir
+
+	agent, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	peerA, _ := identity.NewAgentID()
+	peerB, _ := identity.NewAgentID()
+	agent.tcpRelay.Insert(&relayEntry{
+		UpstreamPeer:   peerA,
+		UpstreamID:     1,
+		DownstreamPeer: peerB,
+		DownstreamID:   100,
+		DestAddr:       "10.0.0.5:22",
+	})
+	if _, err := agent.streamMgr.AcceptStream(7, 1, peerB, "example.com", 443); err != nil {
+		t.Fatalf("AcceptStream() error = %v", err)
+	}
+
+	result, err := agent.ManageIdle("list", "1h")
+	if err != nil {
+		t.Fatalf("ManageIdle(list, 1h) error = %v", err)
+	}
+	if len(result.Entries) != 0 || result.MinIdle != "1h0m0s" {
+		t.Errorf("ManageIdle(list, 1h) = %+v, want no entries", result)
+	}
+
+	result, err = agent.ManageIdle("list", "1ns")
+	if err != nil {
+		t.Fatalf("ManageIdle(list, 1ns) error = %v", err)
+	}
+	if len(result.Entries) != 2 {
+		t.Fatalf("ManageIdle(list, 1ns) returned %d entries, want 2", len(result.Entries))
+	}
+	if e := result.Entries[0]; e.Kind != health.IdleKindStream || e.Direction != health.StreamDirectionOutbound || e.Peer != peerB.ShortString() {
+		t.Errorf("unexpected outbound entry: %+v", e)
+	}
+
+	result, err = agent.ManageIdle("close", "1ns")
+	if err != nil {
+		t.Fatalf("ManageIdle(close) error = %v", err)
+	}
+	if len(result.Entries) != 2 || len(agent.ListStreams()) != 0 {
+		t.Errorf("ManageIdle(close) closed %d entries, %d streams left", len(result.Entries), len(agent.ListStreams()))
+	}
+
+	if _, err := agent.ManageIdle("list", "soon"); err == nil {
+		t.Error("ManageIdle() should reject an invalid min_idle")
+	}
+	if _, err := agent.ManageIdle("purge", ""); err == nil {
+		t.Error("ManageIdle() should reject an unknown action")
+	}
+}
+
 // Tests for buildSOCKS5Auth with hashed passwords
 func TestAgent_buildSOCKS5Auth_WithHashedUsers(t *testing.T) {
 	tmpDir, err := os.MkdirTemp("", "agent-test")
@@ -1600,3 +1878,1369 @@ func TestAgent_StopDuringStartupDelay(t *testing.T) {
 		t.Error("Agent should not be running after interrupted start")
 	}
 }
+
+func TestAgent_ManageForwardEndpoint(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "agent-test")
+	if err != nil {
+		t.Fatalf("Create temp dir error: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	cfg := config.Default()
+	cfg.Agent.DataDir = tmpDir
+	cfg.Forward.Endpoints = []config.ForwardEndpoint{{Key: "static", Target: "localhost:22"}}
+
+	agent, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	if _, err := agent.ManageForwardEndpoint("add", "adhoc-1", "localhost:8080"); err != nil {
+		t.Fatalf("add error = %v", err)
+	}
+	if route := agent.routeMgr.LookupForward("adhoc-1"); route == nil || route.Target != "localhost:8080" {
+		t.Errorf("expected local forward route for adhoc-1, got %+v", route)
+	}
+
+	if _, err := agent.ManageForwardEndpoint("add", "static", "localhost:9"); err == nil {
+		t.Error("replacing a config endpoint should fail")
+	}
+	if _, err := agent.ManageForwardEndpoint("add", "bad", "no-port"); err == nil {
+		t.Error("target without port should fail")
+	}
+
+	result, err := agent.ManageForwardEndpoint("list", "", "")
+	if err != nil {
+		t.Fatalf("list error = %v", err)
+	}
+	if len(result.Endpoints) != 2 || result.Endpoints[0].Key != "adhoc-1" || !result.Endpoints[0].Dynamic || result.Endpoints[1].Dynamic {
+		t.Errorf("unexpected list result: %+v", result.Endpoints)
+	}
+
+	if _, err := agent.ManageForwardEndpoint("remove", "adhoc-1", ""); err != nil {
+		t.Fatalf("remove error = %v", err)
+	}
+	if agent.routeMgr.LookupForward("adhoc-1") != nil {
+		t.Error("forward route should be removed")
+	}
+	if _, err := agent.ManageForwardEndpoint("remove", "adhoc-1", ""); err == nil {
+		t.Error("removing an unknown endpoint should fail")
+	}
+	if _, err := agent.ManageForwardEndpoint("remove", "static", ""); err == nil {
+		t.Error("removing a config endpoint should fail")
+	}
+}
+
+func TestAgent_ManageForwardEndpoint_CreatesHandler(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "agent-test")
+	if err != nil {
+		t.Fatalf("Create temp dir error: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	cfg := config.Default()
+	cfg.Agent.DataDir = tmpDir
+
+	agent, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if agent.forwardHandler != nil {
+		t.Fatal("forward handler should not exist without endpoints")
+	}
+
+	if _, err := agent.ManageForwardEndpoint("add", "adhoc-2", "127.0.0.1:80"); err != nil {
+		t.Fatalf("add error = %v", err)
+	}
+	if agent.forwardHandler == nil || !agent.forwardHandler.IsRunning() {
+		t.Fatal("forward handler should be created and running")
+	}
+	if target, ok := agent.forwardHandler.GetTarget("adhoc-2"); !ok || target != "127.0.0.1:80" {
+		t.Errorf("GetTarget = %q, %v", target, ok)
+	}
+}
+
+func TestAgent_lookupExitPath(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "agent-test")
+	if err != nil {
+		t.Fatalf("Create temp dir error: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	cfg := config.Default()
+	cfg.Agent.DataDir = tmpDir
+
+	agent, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	peer, _ := identity.NewAgentID()
+	exitA, _ := identity.NewAgentID()
+	exitB, _ := identity.NewAgentID()
+	_, netA, _ := net.ParseCIDR("10.0.0.0/8")
+	_, netB, _ := net.ParseCIDR("0.0.0.0/0")
+	table := agent.routeMgr.Table()
+	table.AddRoute(&routing.Route{Network: netA, NextHop: peer, OriginAgent: exitA, Metric: 3, Path: []identity.AgentID{peer, exitA}})
+	table.AddRoute(&routing.Route{Network: netB, NextHop: exitA, OriginAgent: exitA, Metric: 1, Path: []identity.AgentID{exitA}})
+	table.AddRoute(&routing.Route{Network: netB, NextHop: peer, OriginAgent: exitB, Metric: 2, Path: []identity.AgentID{peer, exitB}})
+	agent.routeMgr.SetDisplayName(exitB, "exit-eu-west")
+
+	// ID prefix selects the lowest-metric path to the exit
+	p, err := agent.lookupExitPath(exitA.String()[:12], "")
+	if err != nil {
+		t.Fatalf("lookupExitPath() error = %v", err)
+	}
+	if p.origin != exitA || p.nextHop != exitA || p.metric != 1 {
+		t.Errorf("lookupExitPath() = %+v, want direct path to exit A", p)
+	}
+
+	// Display name, case-insensitive
+	p, err = agent.lookupExitPath("EXIT-EU-WEST", "")
+	if err != nil {
+		t.Fatalf("lookupExitPath() error = %v", err)
+	}
+	if p.origin != exitB || p.nextHop != peer {
+		t.Errorf("lookupExitPath() = %+v, want path to exit B via peer", p)
+	}
+
+	// Unknown exit
+	if _, err := agent.lookupExitPath("no-such-exit", ""); !errors.Is(err, socks5.ErrExitUnavailable) {
+		t.Errorf("lookupExitPath() error = %v, want ErrExitUnavailable", err)
+	}
+
+	// Empty prefix matches every agent and is rejected as ambiguous
+	if _, err := agent.lookupExitPath("", ""); !errors.Is(err, socks5.ErrExitUnavailable) {
+		t.Errorf("lookupExitPath(\"\") error = %v, want ErrExitUnavailable", err)
+	}
+
+	// Exits of another namespace cannot be selected
+	agent.routeMgr.SetOriginNamespace(exitB, "acme")
+	if _, err := agent.lookupExitPath("exit-eu-west", ""); !errors.Is(err, socks5.ErrExitUnavailable) {
+		t.Errorf("lookupExitPath() across namespaces error = %v, want ErrExitUnavailable", err)
+	}
+	if p, err := agent.lookupExitPath("exit-eu-west", "acme"); err != nil || p.origin != exitB {
+		t.Errorf("lookupExitPath() in acme = %+v, %v, want exit B", p, err)
+	}
+}
+
+func TestAgent_streamNamespace(t *testing.T) {
+	cfg := config.Default()
+	cfg.Agent.DataDir = t.TempDir()
+	cfg.Agent.Namespace = "acme"
+	cfg.SOCKS5.Auth.Users = []config.SOCKS5UserConfig{
+		{Username: "alice", Namespace: "globex"},
+		{Username: "bob"},
+	}
+
+	agent, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if got := agent.routeMgr.LocalNamespace(); got != "acme" {
+		t.Errorf("LocalNamespace() = %q, want acme", got)
+	}
+
+	ctx := context.Background()
+	tests := []struct {
+		name string
+		ctx  context.Context
+		want string
+	}{
+		{"no user", ctx, "acme"},
+		{"user with namespace", socks5.WithUser(ctx, "alice"), "globex"},
+		{"user without namespace", socks5.WithUser(ctx, "bob"), "acme"},
+		{"forward listener", withNamespace(socks5.WithUser(ctx, "alice"), ""), ""},
+	}
+	for _, tt := range tests {
+		if got := agent.streamNamespace(tt.ctx); got != tt.want {
+			t.Errorf("%s: streamNamespace() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	open := &protocol.StreamOpen{}
+	agent.setClientIdentity(socks5.WithUser(ctx, "alice"), open)
+	if open.Namespace != "globex" {
+		t.Errorf("STREAM_OPEN Namespace = %q, want globex", open.Namespace)
+	}
+
+	if agent.directAllowed(socks5.WithUser(ctx, "alice"), "globex") {
+		t.Error("directAllowed() = true for a client of another namespace")
+	}
+	if !agent.directAllowed(ctx, "acme") {
+		t.Error("directAllowed() = false in the agent's namespace")
+	}
+}
+
+func TestAgent_RemoteDNSAlways(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "agent-test")
+	if err != nil {
+		t.Fatalf("Create temp dir error: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	cfg := config.Default()
+	cfg.Agent.DataDir = tmpDir
+	cfg.SOCKS5.RemoteDNS = config.RemoteDNSAlways
+
+	agent, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	// Without a default route the dial fails instead of resolving locally
+	_, err = agent.DialContext(context.Background(), "tcp", "example.invalid:80")
+	if err == nil || !strings.Contains(err.Error(), "remote DNS requires a default route") {
+		t.Fatalf("DialContext() error = %v, want missing default route", err)
+	}
+
+	// The IPv4 default route is preferred over IPv6 and more specific routes
+	peer, _ := identity.NewAgentID()
+	exitV4, _ := identity.NewAgentID()
+	exitV6, _ := identity.NewAgentID()
+	_, v6Default, _ := net.ParseCIDR("::/0")
+	_, v4Default, _ := net.ParseCIDR("0.0.0.0/0")
+	_, private, _ := net.ParseCIDR("10.0.0.0/8")
+	table := agent.routeMgr.Table()
+	table.AddRoute(&routing.Route{Network: v6Default, NextHop: peer, OriginAgent: exitV6, Metric: 1, Path: []identity.AgentID{peer, exitV6}})
+	table.AddRoute(&routing.Route{Network: private, NextHop: peer, OriginAgent: peer, Metric: 1, Path: []identity.AgentID{peer}})
+	if r := agent.defaultRoute(""); r == nil || r.OriginAgent != exitV6 {
+		t.Fatalf("defaultRoute() = %+v, want IPv6 default route", r)
+	}
+	table.AddRoute(&routing.Route{Network: v4Default, NextHop: peer, OriginAgent: exitV4, Metric: 2, Path: []identity.AgentID{peer, exitV4}})
+	if r := agent.defaultRoute(""); r == nil || r.OriginAgent != exitV4 {
+		t.Fatalf("defaultRoute() = %+v, want IPv4 default route", r)
+	}
+
+	// The domain goes to the default route exit; its next hop is not connected
+	_, err = agent.DialContext(context.Background(), "tcp", "example.invalid:80")
+	if err == nil || !strings.Contains(err.Error(), "not connected") {
+		t.Errorf("DialContext() error = %v, want next hop not connected", err)
+	}
+}
+
+// countingResolver records lookups and resolves every name to 192.0.2.1.
+type countingResolver struct {
+	lookups atomic.Int32
+}
+
+func (r *countingResolver) LookupIP(ctx context.Context, network, host string) ([]net.IP, error) {
+	r.lookups.Add(1)
+	return []net.IP{net.ParseIP("192.0.2.1")}, nil
+}
+
+func TestAgent_waitForQuietRoute_RemoteDNS(t *testing.T) {
+	tests := []struct {
+		policy      string
+		wantLookups int32
+	}{
+		{config.RemoteDNSAuto, 1},
+		{config.RemoteDNSAlways, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			cfg := config.Default()
+			cfg.Agent.DataDir = t.TempDir()
+			cfg.SOCKS5.RemoteDNS = tt.policy
+
+			agent, err := New(cfg)
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			resolver := &countingResolver{}
+			agent.resolver = resolver
+
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			agent.waitForQuietRoute(ctx, "example.invalid")
+
+			if got := resolver.lookups.Load(); got != tt.wantLookups {
+				t.Errorf("local lookups = %d, want %d", got, tt.wantLookups)
+			}
+		})
+	}
+}
+
+func TestAgent_waitForQuietRoute_Namespace(t *testing.T) {
+	cfg := config.Default()
+	cfg.Agent.DataDir = t.TempDir()
+	cfg.SOCKS5.Auth.Users = []config.SOCKS5UserConfig{{Username: "alice", Namespace: "globex"}}
+
+	agent, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	// The only route to the destination belongs to another namespace
+	peer, _ := identity.NewAgentID()
+	_, network, _ := net.ParseCIDR("10.0.0.0/8")
+	agent.routeMgr.Table().AddRoute(&routing.Route{Network: network, NextHop: peer, OriginAgent: peer, Metric: 1, Path: []identity.AgentID{peer}})
+	agent.routeMgr.SetOriginNamespace(peer, "globex")
+
+	wait := func(ctx context.Context) time.Duration {
+		ctx, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		agent.waitForQuietRoute(ctx, "10.1.2.3")
+		return time.Since(start)
+	}
+
+	if d := wait(socks5.WithUser(context.Background(), "alice")); d >= 150*time.Millisecond {
+		t.Errorf("client in the route's namespace waited %v, want immediate return", d)
+	}
+	if d := wait(context.Background()); d < 150*time.Millisecond {
+		t.Errorf("client of another namespace returned after %v, want wait until deadline", d)
+	}
+}
+
+func TestNextHopTTL(t *testing.T) {
+	tests := []struct {
+		ttl     uint8
+		want    uint8
+		wantOK  bool
+		comment string
+	}{
+		{0, 0, true, "legacy open without hop limit"},
+		{1, 0, false, "hop limit exhausted"},
+		{2, 1, true, "one more hop allowed"},
+		{16, 15, true, "default max_hops"},
+	}
+	for _, tt := range tests {
+		got, ok := nextHopTTL(tt.ttl)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("nextHopTTL(%d) = %d, %v; want %d, %v (%s)", tt.ttl, got, ok, tt.want, tt.wantOK, tt.comment)
+		}
+	}
+}
+
+func TestAgent_HopLimit(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "agent-test")
+	if err != nil {
+		t.Fatalf("Create temp dir error: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	cfg := config.Default()
+	cfg.Agent.DataDir = tmpDir
+	cfg.Routing.MaxHops = 3
+
+	agent, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	// A 3-link path (origin -> relay -> relay -> exit) fits a limit of 3
+	ttl := agent.hopLimit()
+	for relay := 1; relay <= 2; relay++ {
+		var ok bool
+		if ttl, ok = nextHopTTL(ttl); !ok {
+			t.Fatalf("relay %d rejected an open within the hop limit", relay)
+		}
+	}
+	if _, ok := nextHopTTL(ttl); ok {
+		t.Error("a third relay should exceed the hop limit")
+	}
+}
+
+func TestAgent_PathProbe(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "agent-test")
+	if err != nil {
+		t.Fatalf("Create temp dir error: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	cfg := config.Default()
+	cfg.Agent.DataDir = tmpDir
+
+	agent, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	peer, _ := identity.NewAgentID()
+	exit, _ := identity.NewAgentID()
+	_, netA, _ := net.ParseCIDR("10.0.0.0/8")
+	_, netB, _ := net.ParseCIDR("172.16.0.0/12")
+	_, netC, _ := net.ParseCIDR("192.168.0.0/16")
+	table := agent.routeMgr.Table()
+	table.AddRoute(&routing.Route{Network: netA, NextHop: peer, OriginAgent: exit, Metric: 2, Path: []identity.AgentID{peer, exit}})
+	table.AddRoute(&routing.Route{Network: netB, NextHop: peer, OriginAgent: exit, Metric: 2, Path: []identity.AgentID{peer, exit}})
+	table.AddRoute(&routing.Route{Network: netC, NextHop: peer, OriginAgent: peer, Metric: 1, Path: []identity.AgentID{peer}})
+
+	// One target per path, direct peers skipped
+	targets := agent.pathProbeTargets()
+	if len(targets) != 1 {
+		t.Fatalf("pathProbeTargets() returned %d targets, want 1", len(targets))
+	}
+	key := routing.PathKey{Origin: exit, NextHop: peer}
+	if targets[0].key != key || len(targets[0].path) != 1 || targets[0].path[0] != exit {
+		t.Errorf("pathProbeTargets()[0] = %+v, want path [exit] via peer", targets[0])
+	}
+
+	failures := make(map[routing.PathKey]int)
+	for i := 0; i < 2; i++ {
+		agent.recordPathProbe(failures, key, false, 3)
+	}
+	if table.IsPathDown(exit, peer) {
+		t.Error("path marked down before reaching the failure threshold")
+	}
+
+	agent.recordPathProbe(failures, key, false, 3)
+	if !table.IsPathDown(exit, peer) {
+		t.Fatal("path not marked down at the failure threshold")
+	}
+	if r := table.GetRoute(netB); r == nil || !r.Unreachable {
+		t.Errorf("route on down path = %v, want unreachable", r)
+	}
+
+	agent.recordPathProbe(failures, key, true, 3)
+	if table.IsPathDown(exit, peer) || failures[key] != 0 {
+		t.Error("successful probe did not restore the path")
+	}
+}
+
+func TestAgent_ScheduledTaskPolicy(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "agent-test")
+	if err != nil {
+		t.Fatalf("Create temp dir error: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	cfg := config.Default()
+	cfg.Agent.DataDir = tmpDir
+	cfg.Scheduler.Enabled = true
+	cfg.Shell.Enabled = true
+	cfg.Shell.Whitelist = []string{"uptime"}
+	cfg.FileTransfer.Enabled = true
+	cfg.FileTransfer.AllowedPaths = []string{"/srv/**"}
+
+	agent, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if err := agent.startScheduler(); err != nil {
+		t.Fatalf("startScheduler() error = %v", err)
+	}
+	defer agent.taskScheduler.Stop()
+
+	add := func(task scheduler.Task) error {
+		_, err := agent.ManageSchedule(health.ScheduleManageRequest{Action: "add", Task: &task})
+		return err
+	}
+
+	if err := add(scheduler.Task{Schedule: "@hourly", Type: scheduler.TypeShell, Command: "uptime"}); err != nil {
+		t.Errorf("whitelisted command rejected: %v", err)
+	}
+	if err := add(scheduler.Task{Schedule: "@hourly", Type: scheduler.TypeShell, Command: "rm"}); err == nil {
+		t.Error("command outside the whitelist was accepted")
+	}
+	if err := add(scheduler.Task{Schedule: "@daily", Type: scheduler.TypeFileSync, Source: "/srv/data", Dest: "/srv/backup"}); err != nil {
+		t.Errorf("file sync within allowed paths rejected: %v", err)
+	}
+	if err := add(scheduler.Task{Schedule: "@daily", Type: scheduler.TypeFileSync, Source: "/etc", Dest: "/srv/etc"}); err == nil {
+		t.Error("file sync from outside allowed paths was accepted")
+	}
+
+	result, err := agent.ManageSchedule(health.ScheduleManageRequest{Action: "list"})
+	if err != nil {
+		t.Fatalf("list error = %v", err)
+	}
+	if len(result.Tasks) != 2 {
+		t.Errorf("list = %d tasks, want 2", len(result.Tasks))
+	}
+
+	// Disabling shell stops installed shell tasks at run time
+	agent.cfg.Shell.Enabled = false
+	if err := agent.authorizeTask(&result.Tasks[0].Task); err == nil {
+		t.Error("shell task authorized with shell disabled")
+	}
+}
+
+func TestAgent_UpdateStaging(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "agent-test")
+	if err != nil {
+		t.Fatalf("Create temp dir error: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	cfg := config.Default()
+	cfg.Agent.DataDir = tmpDir
+	cfg.FileTransfer.Enabled = true
+	cfg.FileTransfer.AllowedPaths = []string{"/srv/**"}
+	cfg.Update.Enabled = true
+	cfg.Update.RequireSignature = false
+
+	agent, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	status, err := agent.ManageUpdate(health.UpdateManageRequest{Action: "status"})
+	if err != nil {
+		t.Fatalf("status error = %v", err)
+	}
+	if filepath.Dir(status.StagingPath) != filepath.Join(tmpDir, "update") {
+		t.Errorf("staging path = %s, want under %s", status.StagingPath, filepath.Join(tmpDir, "update"))
+	}
+
+	// The staging path is writable over file transfer; the rest of data_dir is not
+	if err := agent.fileStreamHandler.CheckPath(status.StagingPath); err != nil {
+		t.Errorf("staging path rejected: %v", err)
+	}
+	if err := agent.fileStreamHandler.CheckPath(filepath.Join(tmpDir, "agent_id")); err == nil {
+		t.Error("data_dir outside staging was allowed")
+	}
+
+	// A staged binary with the wrong digest is refused before anything runs
+	if err := os.WriteFile(status.StagingPath, []byte("not a binary"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	_, err = agent.ManageUpdate(health.UpdateManageRequest{Action: "apply", SHA256: strings.Repeat("0", 64)})
+	if err == nil || !strings.Contains(err.Error(), "sha256 mismatch") {
+		t.Errorf("apply error = %v, want sha256 mismatch", err)
+	}
+}
+
+func TestAgent_ControlRequestRole(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "agent-test")
+	if err != nil {
+		t.Fatalf("Create temp dir error: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	cfg := config.Default()
+	cfg.Agent.DataDir = tmpDir
+	cfg.RBAC.DefaultPeerRole = "operator"
+	cfg.RBAC.LegacyRole = "viewer"
+
+	agent, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	peerID, _ := identity.NewAgentID()
+
+	tests := []struct {
+		name   string
+		stated rbac.Role
+		want   rbac.Role
+	}{
+		{"legacy request", rbac.RoleNone, rbac.RoleViewer},
+		{"viewer stays viewer", rbac.RoleViewer, rbac.RoleViewer},
+		{"admin capped by peer", rbac.RoleAdmin, rbac.RoleOperator},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := agent.controlRequestRole(peerID, uint8(tc.stated)); got != tc.want {
+				t.Errorf("controlRequestRole() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestAgent_applyDiscovered(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "agent-test")
+	if err != nil {
+		t.Fatalf("Create temp dir error: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	cfg := config.Default()
+	cfg.Agent.DataDir = tmpDir
+	cfg.Peers = []config.PeerConfig{{Transport: "tcp", Address: "127.0.0.1:1"}}
+	cfg.Discovery.MaxPeers = 2
+
+	agent, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer agent.peerMgr.Close()
+
+	// Nothing listens on these ports, so the dials fail fast
+	self := discovery.Peer{ID: agent.ID().String(), Transport: "tcp", Host: "127.0.0.1", Port: 2}
+	static := discovery.Peer{Transport: "tcp", Host: "127.0.0.1", Port: 1}
+	p1 := discovery.Peer{Transport: "tcp", Host: "127.0.0.1", Port: 3}
+	p2 := discovery.Peer{Transport: "tcp", Host: "127.0.0.1", Port: 4}
+	p3 := discovery.Peer{Transport: "tcp", Host: "127.0.0.1", Port: 5}
+
+	apply := func(source string, peers []discovery.Peer, err error) []string {
+		agent.applyDiscovered(source, peers, err)
+		agent.wg.Wait()
+		var addrs []string
+		for addr := range agent.peerMgr.GetPeerInfos() {
+			addrs = append(addrs, addr)
+		}
+		slices.Sort(addrs)
+		return addrs
+	}
+
+	// Own and static addresses are skipped, the rest is capped at max_peers
+	got := apply("dns", []discovery.Peer{self, static, p1, p2, p3}, nil)
+	if want := []string{p1.Address(), p2.Address()}; !slices.Equal(got, want) {
+		t.Errorf("peers after first lookup = %v, want %v", got, want)
+	}
+
+	// A failed lookup removes nothing
+	got = apply("dns", nil, errors.New("server misbehaving"))
+	if want := []string{p1.Address(), p2.Address()}; !slices.Equal(got, want) {
+		t.Errorf("peers after failed lookup = %v, want %v", got, want)
+	}
+
+	// A peer still reported by another source is kept
+	apply("mdns", []discovery.Peer{p1}, nil)
+	got = apply("dns", []discovery.Peer{p3}, nil)
+	if want := []string{p1.Address(), p3.Address()}; !slices.Equal(got, want) {
+		t.Errorf("peers after second lookup = %v, want %v", got, want)
+	}
+}
+
+func TestKernelRoutePrefixes(t *testing.T) {
+	self, _ := identity.NewAgentID()
+	exitID, _ := identity.NewAgentID()
+
+	route := func(cidr string, origin identity.AgentID) *routing.Route {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			t.Fatalf("ParseCIDR(%q) error = %v", cidr, err)
+		}
+		return &routing.Route{Network: network, OriginAgent: origin, NextHop: origin}
+	}
+	routes := []*routing.Route{
+		route("10.0.0.0/8", exitID),
+		route("10.1.0.0/16", exitID),   // Also from another exit below
+		route("192.168.0.0/16", self),  // Our own exit route
+		route("172.16.5.0/24", exitID), // Inside the excluded 172.16.0.0/12
+		route("0.0.0.0/0", exitID),     // Default route
+		route("fd00:1::/64", exitID),
+	}
+	other, _ := identity.NewAgentID()
+	routes = append(routes, route("10.1.0.0/16", other))
+	exclude := []netip.Prefix{netip.MustParsePrefix("172.16.0.0/12")}
+
+	got := kernelRoutePrefixes(routes, self, config.KernelRoutesConfig{}, exclude)
+	want := []string{"10.0.0.0/8", "10.1.0.0/16", "fd00:1::/64"}
+	if len(got) != len(want) {
+		t.Errorf("kernelRoutePrefixes() = %v, want %v", got, want)
+	}
+	for _, w := range want {
+		if !got[netip.MustParsePrefix(w)] {
+			t.Errorf("kernelRoutePrefixes() is missing %s", w)
+		}
+	}
+
+	got = kernelRoutePrefixes(routes, self, config.KernelRoutesConfig{DefaultRoute: true}, exclude)
+	if !got[netip.MustParsePrefix("0.0.0.0/0")] {
+		t.Error("kernelRoutePrefixes() with default_route is missing 0.0.0.0/0")
+	}
+}
+
+func TestRetryableOpenError(t *testing.T) {
+	openErr := func(code uint16) error {
+		return &streamOpenError{code: code, err: errors.New("stream open failed")}
+	}
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"relay cannot reach next hop", openErr(protocol.ErrHostUnreachable), true},
+		{"no route", openErr(protocol.ErrNoRoute), true},
+		{"hop limit", openErr(protocol.ErrTTLExceeded), true},
+		{"exit disabled", openErr(protocol.ErrExitDisabled), true},
+		{"destination not allowed", openErr(protocol.ErrNotAllowed), true},
+		{"private destination", openErr(protocol.ErrPrivateDestination), true},
+		{"wrapped", fmt.Errorf("dial: %w", openErr(protocol.ErrNetworkUnreachable)), true},
+		{"connection refused", openErr(protocol.ErrConnectionRefused), false},
+		{"timeout", openErr(protocol.ErrConnectionTimeout), false},
+		{"context canceled", context.Canceled, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := retryableOpenError(tt.err); got != tt.want {
+				t.Errorf("retryableOpenError() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStreamOpenError_Reply(t *testing.T) {
+	tests := []struct {
+		code uint16
+		want byte
+	}{
+		{protocol.ErrConnectionRefused, socks5.ReplyConnectionRefused},
+		{protocol.ErrHostUnreachable, socks5.ReplyHostUnreachable},
+		{protocol.ErrNetworkUnreachable, socks5.ReplyNetworkUnreachable},
+		{protocol.ErrConnectionTimeout, socks5.ReplyTTLExpired},
+		{protocol.ErrNotAllowed, socks5.ReplyNotAllowed},
+		{protocol.ErrPrivateDestination, socks5.ReplyNotAllowed},
+		{protocol.ErrConnectionLimit, socks5.ReplyServerFailure},
+	}
+	for _, tt := range tests {
+		err := &streamOpenError{code: tt.code, err: errors.New("stream open failed")}
+		if got := err.Reply(); got != tt.want {
+			t.Errorf("Reply() for %s = %#x, want %#x", protocol.ErrorCodeName(tt.code), got, tt.want)
+		}
+	}
+}
+
+// TestAgent_PathMaxPayload checks that frames fit a constrained link that is
+// not the first hop of the path: A - B =(1024)= C - D, with D the exit.
+func TestAgent_PathMaxPayload(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping mesh test in short mode")
+	}
+	const limit = 1024
+
+	echo, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	defer echo.Close()
+	go func() {
+		for {
+			c, err := echo.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				io.Copy(c, c)
+			}()
+		}
+	}()
+
+	network := transport.NewMemoryNetwork()
+	agents := make([]*Agent, 4)
+	for i := range agents {
+		cfg := config.Default()
+		cfg.Agent.DataDir = t.TempDir()
+		cfg.Listeners = []config.ListenerConfig{
+			{Transport: string(transport.TransportMemory), Address: fmt.Sprintf("path-%d", i)},
+		}
+		if i < len(agents)-1 {
+			cfg.Peers = []config.PeerConfig{{
+				ID:        "auto",
+				Transport: string(transport.TransportMemory),
+				Address:   fmt.Sprintf("path-%d", i+1),
+			}}
+		}
+		if i == 1 {
+			cfg.Peers[0].MaxFrameSize = limit
+		}
+		if i == len(agents)-1 {
+			cfg.Exit.Enabled = true
+			cfg.Exit.Routes = []string{"0.0.0.0/0"}
+		}
+		a, err := New(cfg)
+		if err != nil {
+			t.Fatalf("New() error = %v", err)
+		}
+		a.RegisterTransport(transport.NewMemoryTransport(network))
+		agents[i] = a
+	}
+	for i := len(agents) - 1; i >= 0; i-- {
+		if err := agents[i].Start(); err != nil {
+			t.Fatalf("Start() agent %d error = %v", i, err)
+		}
+		defer agents[i].Stop()
+	}
+
+	ingress, relay := agents[0], agents[1]
+	deadline := time.Now().Add(10 * time.Second)
+	for ingress.routeMgr.Lookup(net.ParseIP("127.0.0.1")) == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("exit route did not reach the ingress")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if got := relay.peerMgr.MaxPayload(agents[2].ID()); got != limit {
+		t.Fatalf("B-C link MaxPayload() = %d, want %d", got, limit)
+	}
+	if got := ingress.peerMgr.MaxPayload(relay.ID()); got != protocol.MaxPayloadSize {
+		t.Fatalf("A-B link MaxPayload() = %d, want %d", got, protocol.MaxPayloadSize)
+	}
+
+	conn, err := ingress.DialContext(context.Background(), "tcp", echo.Addr().String())
+	if err != nil {
+		t.Fatalf("DialContext() error = %v", err)
+	}
+	defer conn.Close()
+	if got := conn.(*meshConn).stream.PathMaxPayload(); got != limit {
+		t.Errorf("stream PathMaxPayload() = %d, want %d from STREAM_OPEN_ACK", got, limit)
+	}
+
+	data := make([]byte, 64*1024)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	go conn.Write(data)
+	got := make([]byte, len(data))
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	if _, err := io.ReadFull(conn, got); err != nil {
+		t.Fatalf("ReadFull() error = %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("echoed data does not match")
+	}
+
+	// Relays forward frames unchanged, so the frames B sent on the
+	// constrained link are those sized by the ingress and the exit
+	entries := relay.tcpRelay.Snapshot()
+	if len(entries) != 1 {
+		t.Fatalf("relay B has %d streams, want 1", len(entries))
+	}
+	e := entries[0]
+	if up, frames := e.bytesUp.Load(), e.framesUp.Load(); up > frames*limit {
+		t.Errorf("ingress to exit: %d bytes in %d frames exceed the %d byte link limit", up, frames, limit)
+	}
+	if down, frames := e.bytesDown.Load(), e.framesDown.Load(); down > frames*limit {
+		t.Errorf("exit to ingress: %d bytes in %d frames exceed the %d byte link limit", down, frames, limit)
+	}
+}
+
+func TestAgent_StreamMiddleware(t *testing.T) {
+	tmpDir := t.TempDir()
+	cfg := config.Default()
+	cfg.Agent.DataDir = tmpDir
+
+	agent, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.Copy(conn, conn)
+	}()
+
+	closed := make(chan *middleware.Stream, 1)
+	agent.UseStreamMiddleware(middleware.Funcs{
+		Open: func(ctx context.Context, s *middleware.Stream) error {
+			if s.Side != middleware.Ingress {
+				t.Errorf("Side = %v, want ingress", s.Side)
+			}
+			if strings.HasPrefix(s.Destination, "blocked.") {
+				return errors.New("blocked by middleware")
+			}
+			return nil
+		},
+		Data: func(s *middleware.Stream, dir middleware.Direction, data []byte) ([]byte, error) {
+			if dir == middleware.ToDestination {
+				return bytes.ToUpper(data), nil
+			}
+			// Longer than the caller's buffer
+			var doubled []byte
+			for _, c := range data {
+				doubled = append(doubled, c, c)
+			}
+			return doubled, nil
+		},
+		Close: func(s *middleware.Stream) { closed <- s },
+	})
+
+	_, err = agent.DialContext(context.Background(), "tcp", "blocked.example:80")
+	var openErr *streamOpenError
+	if !errors.As(err, &openErr) || openErr.code != protocol.ErrNotAllowed {
+		t.Fatalf("DialContext() error = %v, want refused by middleware", err)
+	}
+
+	conn, err := agent.DialContext(context.Background(), "tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("DialContext() error = %v", err)
+	}
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	got := make([]byte, 8)
+	for n := 0; n < len(got); {
+		m, err := conn.Read(got[n:min(n+3, len(got))])
+		if err != nil {
+			t.Fatalf("Read() error = %v", err)
+		}
+		n += m
+	}
+	if string(got) != "PPIINNGG" {
+		t.Errorf("read %q, want %q", got, "PPIINNGG")
+	}
+
+	conn.Close()
+	select {
+	case s := <-closed:
+		if s.Destination != ln.Addr().String() {
+			t.Errorf("closed stream destination = %q, want %q", s.Destination, ln.Addr().String())
+		}
+	case <-time.After(time.Second):
+		t.Error("CloseStream was not called")
+	}
+}
+
+func TestAgent_ManageDebugCapture(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "agent-test")
+	if err != nil {
+		t.Fatalf("Create temp dir error: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	cfg := config.Default()
+	cfg.Agent.DataDir = tmpDir
+
+	agent, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	for _, req := range []health.DebugCaptureRequest{
+		{Action: "start"},
+		{Action: "start", Duration: "48h"},
+		{Action: "capture"},
+	} {
+		if _, err := agent.ManageDebugCapture(req); err == nil {
+			t.Errorf("ManageDebugCapture(%+v) should fail", req)
+		}
+	}
+
+	result, err := agent.ManageDebugCapture(health.DebugCaptureRequest{Action: "start", Duration: "10m", KeyLog: true})
+	if err != nil {
+		t.Fatalf("start error = %v", err)
+	}
+	wantFile := filepath.Join(tmpDir, "debug", "keylog.txt")
+	if !result.Active || result.KeyLogFile != wantFile || result.QlogDir != "" || result.Until == "" {
+		t.Errorf("unexpected start result: %+v", result)
+	}
+	if _, err := os.Stat(wantFile); err != nil {
+		t.Errorf("key log file not created: %v", err)
+	}
+
+	result, err = agent.ManageDebugCapture(health.DebugCaptureRequest{Action: "stop"})
+	if err != nil {
+		t.Fatalf("stop error = %v", err)
+	}
+	if result.Active || result.Message != "capture stopped" {
+		t.Errorf("unexpected stop result: %+v", result)
+	}
+}
+
+func TestAgent_ManageManagementKey(t *testing.T) {
+	oldPriv, oldPub, _ := crypto.GenerateEphemeralKeypair()
+	newPriv, newPub, _ := crypto.GenerateEphemeralKeypair()
+
+	cfg := config.Default()
+	cfg.Agent.DataDir = t.TempDir()
+	cfg.Management.PublicKey = hexKey(oldPub)
+	cfg.Management.PrivateKey = hexKey(oldPriv)
+
+	agent, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	for _, req := range []health.ManagementKeyRequest{
+		{Action: "stage"},
+		{Action: "stage", PublicKey: "abcd"},
+		{Action: "stage", PublicKey: hexKey(oldPub)},
+		{Action: "activate"},
+		{Action: "rotate"},
+	} {
+		if _, err := agent.ManageManagementKey(req); err == nil {
+			t.Errorf("ManageManagementKey(%+v) should fail", req)
+		}
+	}
+
+	result, err := agent.ManageManagementKey(health.ManagementKeyRequest{Action: "stage", PublicKey: hexKey(newPub)})
+	if err != nil {
+		t.Fatalf("stage error = %v", err)
+	}
+	if result.PublicKey != hexKey(oldPub) || result.NextPublicKey != hexKey(newPub) || result.TargetKey != hexKey(newPub) {
+		t.Errorf("unexpected stage result: %+v", result)
+	}
+
+	// Node info is now readable with the new private key
+	sealed, err := agent.sealedBox.Seal([]byte("info"))
+	if err != nil {
+		t.Fatalf("Seal() error = %v", err)
+	}
+	if _, err := crypto.NewSealedBoxWithPrivate(newPub, newPriv).Open(sealed); err != nil {
+		t.Errorf("Open() with new key error = %v", err)
+	}
+
+	// A remote agent still on the old key is reported as not adopted
+	remoteID, _ := identity.NewAgentID()
+	oldOnly, _ := crypto.NewSealedBox(oldPub).Seal(protocol.EncodeNodeInfo(&protocol.NodeInfo{DisplayName: "remote"}))
+	agent.routeMgr.SetNodeInfoEncrypted(remoteID, &protocol.EncryptedData{Encrypted: true, Data: oldOnly}, 1)
+
+	result, err = agent.ManageManagementKey(health.ManagementKeyRequest{Action: "status"})
+	if err != nil {
+		t.Fatalf("status error = %v", err)
+	}
+	if result.Total != 2 || result.Adopted != 1 {
+		t.Errorf("adoption = %d/%d, want 1/2", result.Adopted, result.Total)
+	}
+	for _, ag := range result.Agents {
+		if ag.ID == remoteID.String() && (ag.Adopted || len(ag.SealedTo) != 1 || ag.SealedTo[0] != hexKey(oldPub)) {
+			t.Errorf("unexpected remote agent report: %+v", ag)
+		}
+	}
+
+	// The staged key survives a restart
+	agent, err = New(cfg)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if next, ok := agent.sealedBox.NextPublicKey(); !ok || next != newPub {
+		t.Error("staged key not restored after restart")
+	}
+
+	result, err = agent.ManageManagementKey(health.ManagementKeyRequest{Action: "activate", PublicKey: hexKey(newPub)})
+	if err != nil {
+		t.Fatalf("activate error = %v", err)
+	}
+	if result.PublicKey != hexKey(newPub) || result.NextPublicKey != "" {
+		t.Errorf("unexpected activate result: %+v", result)
+	}
+
+	agent, err = New(cfg)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if agent.sealedBox.PublicKey() != newPub {
+		t.Error("activated key not restored after restart")
+	}
+
+	// Editing the config to another key discards the saved state
+	cfg.Management.PublicKey = hexKey(newPub)
+	cfg.Management.PrivateKey = hexKey(newPriv)
+	agent, err = New(cfg)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if _, ok := agent.sealedBox.NextPublicKey(); ok || agent.sealedBox.PublicKey() != newPub {
+		t.Error("saved state applied to a different configured key")
+	}
+}
+
+func TestAgent_ManageManagementKey_NotConfigured(t *testing.T) {
+	cfg := config.Default()
+	cfg.Agent.DataDir = t.TempDir()
+
+	agent, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if _, err := agent.ManageManagementKey(health.ManagementKeyRequest{Action: "status"}); err == nil {
+		t.Error("ManageManagementKey() should fail without management.public_key")
+	}
+}
+
+func TestAgent_exitPortLimitConfig(t *testing.T) {
+	cfg := config.Default()
+	cfg.Agent.DataDir = t.TempDir()
+	cfg.Exit.PortLimits.Classes = []config.ExitPortClassConfig{
+		{Name: "irc", Ports: []uint16{6667}, Action: "limit", MaxConnections: 5},
+	}
+
+	agent, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	got := agent.exitPortLimitConfig()
+	if !got.DefaultRouteOnly || len(got.Classes) != 2 {
+		t.Fatalf("exitPortLimitConfig() = %+v, want SMTP and irc applied with a default route", got)
+	}
+	if smtp := got.Classes[0]; smtp.Name != "smtp" || smtp.Action != exit.PortActionBlock || len(smtp.Ports) != 3 {
+		t.Errorf("SMTP class = %+v, want ports 25, 465 and 587 blocked", smtp)
+	}
+
+	enabled := true
+	agent.cfg.Exit.PortLimits.Enabled = &enabled
+	if got := agent.exitPortLimitConfig(); got.DefaultRouteOnly {
+		t.Error("exitPortLimitConfig() with enabled: true should apply without a default route")
+	}
+
+	enabled = false
+	if got := agent.exitPortLimitConfig(); len(got.Classes) != 0 {
+		t.Errorf("exitPortLimitConfig() with enabled: false = %+v, want no classes", got)
+	}
+}
+
+func TestAgent_runAsCapabilities(t *testing.T) {
+	cfg := config.Default()
+	cfg.Agent.DataDir = t.TempDir()
+	cfg.ICMP.Enabled = true
+
+	agent, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	got := agent.runAsCapabilities()
+	if runtime.GOOS == "linux" {
+		if !slices.Equal(got, []string{"net_raw"}) {
+			t.Errorf("runAsCapabilities() = %v, want [net_raw] with ICMP enabled", got)
+		}
+	} else if got != nil {
+		t.Errorf("runAsCapabilities() = %v, want none outside Linux", got)
+	}
+
+	agent.cfg.Agent.RunAs.Capabilities = []string{}
+	if got := agent.runAsCapabilities(); len(got) != 0 {
+		t.Errorf("runAsCapabilities() = %v, want the configured empty list", got)
+	}
+
+	// Without run_as nothing changes
+	if err := agent.dropPrivileges(); err != nil {
+		t.Errorf("dropPrivileges() error = %v", err)
+	}
+}
+
+// freeTCPAddr returns a loopback address with a port that was free a
+// moment ago.
+func freeTCPAddr(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	return addr
+}
+
+func TestAgent_Start_DropPrivilegesFailureStops(t *testing.T) {
+	cfg := config.Default()
+	cfg.Agent.DataDir = t.TempDir()
+	cfg.Agent.RunAs.User = "muti-metroo-no-such-user"
+	cfg.HTTP.Enabled = true
+	cfg.HTTP.Address = freeTCPAddr(t)
+	cfg.SOCKS5.Enabled = true
+	cfg.SOCKS5.Address = freeTCPAddr(t)
+
+	agent, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	err = agent.Start()
+	if err == nil || !strings.Contains(err.Error(), "drop privileges") {
+		agent.Stop()
+		t.Fatalf("Start() error = %v, want drop privileges failure", err)
+	}
+	if agent.IsRunning() {
+		t.Error("agent should not be running after a failed privilege drop")
+	}
+
+	for name, addr := range map[string]string{"HTTP": cfg.HTTP.Address, "SOCKS5": cfg.SOCKS5.Address} {
+		conn, err := net.DialTimeout("tcp", addr, time.Second)
+		if err == nil {
+			conn.Close()
+			t.Errorf("%s listener %s still accepts after a failed privilege drop", name, addr)
+		}
+	}
+}
+
+func TestAgent_shellSandboxProfile(t *testing.T) {
+	cfg := config.Default()
+	cfg.Agent.DataDir = t.TempDir()
+
+	agent, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	if p := agent.shellSandboxProfile(); p != nil {
+		t.Errorf("shellSandboxProfile() = %+v, want nil when disabled", p)
+	}
+
+	agent.cfg.Shell.Sandbox.Enabled = true
+	agent.cfg.Shell.Sandbox.MaxOpenFiles = 64
+	p := agent.shellSandboxProfile()
+	if p == nil {
+		t.Fatal("shellSandboxProfile() = nil, want a profile when enabled")
+	}
+	if !p.Seccomp {
+		t.Error("Seccomp should default to true")
+	}
+	if p.Limits.MaxOpenFiles != 64 {
+		t.Errorf("Limits.MaxOpenFiles = %d, want 64", p.Limits.MaxOpenFiles)
+	}
+	if !slices.Equal(p.ReadOnly, []string{"/"}) {
+		t.Errorf("ReadOnly = %v, want the default [/]", p.ReadOnly)
+	}
+
+	off := false
+	agent.cfg.Shell.Sandbox.Seccomp = &off
+	if agent.shellSandboxProfile().Seccomp {
+		t.Error("Seccomp should follow an explicit false")
+	}
+}
+
+func TestAgent_Readiness(t *testing.T) {
+	cfg := config.Default()
+	cfg.Agent.DataDir = t.TempDir()
+	cfg.HTTP.Readiness.Routes = []string{"10.0.0.0/8"}
+	cfg.HTTP.Readiness.Forwards = []string{"web"}
+
+	agent, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	checks := agent.Readiness()
+	if len(checks) != 3 {
+		t.Fatalf("Readiness() = %+v, want peers, route and forward checks", checks)
+	}
+	for _, c := range checks {
+		if c.OK {
+			t.Errorf("check %s passed without peers or routes", c.Name)
+		}
+	}
+	if checks[0].Detail != "0 of 1 required peers connected" {
+		t.Errorf("peers detail = %q", checks[0].Detail)
+	}
+
+	agent.routeMgr.AddLocalRoute(routing.MustParseCIDR("10.0.0.0/8"), 0)
+	if c := agent.Readiness()[1]; c.Name != "route:10.0.0.0/8" || !c.OK {
+		t.Errorf("route check = %+v, want passed", c)
+	}
+
+	agent.cfg.HTTP.Readiness = config.HTTPReadinessConfig{}
+	if checks := agent.Readiness(); len(checks) != 0 {
+		t.Errorf("Readiness() without criteria = %+v, want none", checks)
+	}
+}
+
+func TestRouteWatch_Convergence(t *testing.T) {
+	self, _ := identity.NewAgentID()
+	peerID, _ := identity.NewAgentID()
+	w := newRouteWatch(routing.NewManager(self))
+
+	if _, ok := w.settleIn(time.Now()); ok {
+		t.Fatal("settleIn() without peer events should report no timer")
+	}
+
+	conn := &peer.Connection{RemoteID: peerID, RemoteDisplayName: "edge"}
+	w.peerEvent(health.EventPeerDown, conn)
+	start := w.conv.triggers[0].Time
+
+	w.mu.Lock()
+	w.record(2, start.Add(300*time.Millisecond))
+	w.mu.Unlock()
+
+	if d, ok := w.settleIn(start.Add(time.Second)); !ok || d != 1300*time.Millisecond {
+		t.Errorf("settleIn() = %v, %v, want 1.3s after the last diff", d, ok)
+	}
+	if _, ok := w.settle(start.Add(time.Second)); ok {
+		t.Error("settle() before the quiet period should not close the timer")
+	}
+
+	ev, ok := w.settle(start.Add(3 * time.Second))
+	if !ok {
+		t.Fatal("settle() after the quiet period should close the timer")
+	}
+	if !ev.Settled || ev.Changes != 2 || ev.DurationMs != 300 {
+		t.Errorf("convergence = %+v, want settled with 2 changes in 300ms", ev)
+	}
+	if len(ev.Triggers) != 1 || ev.Triggers[0].Event != health.EventPeerDown || ev.Triggers[0].Peer.DisplayName != "edge" {
+		t.Errorf("triggers = %+v, want the peer_down of edge", ev.Triggers)
+	}
+
+	// A mesh that keeps changing is reported unsettled after the maximum wait
+	w.peerEvent(health.EventPeerUp, conn)
+	start = w.conv.triggers[0].Time
+	for i := time.Duration(1); i <= 60; i++ {
+		w.mu.Lock()
+		w.record(1, start.Add(i*time.Second))
+		w.mu.Unlock()
+	}
+	ev, ok = w.settle(start.Add(convergenceMaxWait))
+	if !ok || ev.Settled || ev.Changes != 60 {
+		t.Errorf("settle() at the maximum wait = %+v, %v, want unsettled with 60 changes", ev, ok)
+	}
+}
+
+func TestAgent_LookupRoute(t *testing.T) {
+	cfg := config.Default()
+	cfg.Agent.DataDir = t.TempDir()
+	cfg.Exit.BlockPrivate = true
+	cfg.SOCKS5.Blocklist.Enabled = true
+	cfg.SOCKS5.Blocklist.Domains = []string{"blocked.example"}
+	cfg.SOCKS5.Auth.Users = []config.SOCKS5UserConfig{{Username: "alice", Namespace: "globex"}}
+
+	agent, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	// The blocklist is created with the SOCKS5 server, which is not started
+	if err := agent.initSOCKS5Blocklist(); err != nil {
+		t.Fatalf("initSOCKS5Blocklist() error = %v", err)
+	}
+	agent.socks5Blocks.Refresh(context.Background())
+
+	peerID, _ := identity.NewAgentID()
+	exitID, _ := identity.NewAgentID()
+	_, private, _ := net.ParseCIDR("10.0.0.0/8")
+	_, public, _ := net.ParseCIDR("8.8.8.0/24")
+	_, remote, _ := net.ParseCIDR("172.16.0.0/12")
+	table := agent.routeMgr.Table()
+	table.AddRoute(&routing.Route{Network: private, NextHop: agent.id, OriginAgent: agent.id})
+	table.AddRoute(&routing.Route{Network: public, NextHop: agent.id, OriginAgent: agent.id})
+	table.AddRoute(&routing.Route{Network: remote, NextHop: peerID, OriginAgent: exitID, Metric: 2, Path: []identity.AgentID{peerID, exitID}})
+
+	tests := []struct {
+		name        string
+		req         health.RouteLookupRequest
+		wantMatch   string
+		wantRoute   string
+		wantAction  string
+		wantSubject string // Substring of the rejection reason
+	}{
+		{"blocklist", health.RouteLookupRequest{Destination: "www.blocked.example:443"}, health.RouteLookupMatchNone, "", health.RouteLookupReject, "SOCKS5 blocklist"},
+		{"no route", health.RouteLookupRequest{Destination: "192.0.2.1"}, health.RouteLookupMatchNone, "", health.RouteLookupDirect, ""},
+		{"local exit", health.RouteLookupRequest{Destination: "8.8.8.8:53"}, health.RouteLookupMatchCIDR, "8.8.8.0/24", health.RouteLookupLocalExit, ""},
+		{"block_private", health.RouteLookupRequest{Destination: "10.1.2.3"}, health.RouteLookupMatchCIDR, "10.0.0.0/8", health.RouteLookupReject, "exit.block_private"},
+		{"next hop down", health.RouteLookupRequest{Destination: "172.16.1.1"}, health.RouteLookupMatchCIDR, "172.16.0.0/12", health.RouteLookupDirect, ""},
+		{"other namespace", health.RouteLookupRequest{Destination: "192.0.2.1", User: "alice"}, health.RouteLookupMatchNone, "", health.RouteLookupReject, "not allowed for namespace"},
+	}
+	for _, tt := range tests {
+		got, err := agent.LookupRoute(tt.req)
+		if err != nil {
+			t.Fatalf("%s: LookupRoute() error = %v", tt.name, err)
+		}
+		if got.Match != tt.wantMatch || got.Route != tt.wantRoute || got.Action != tt.wantAction {
+			t.Errorf("%s: LookupRoute() = %s %q %s, want %s %q %s", tt.name, got.Match, got.Route, got.Action, tt.wantMatch, tt.wantRoute, tt.wantAction)
+		}
+		if !strings.Contains(got.Reason, tt.wantSubject) {
+			t.Errorf("%s: reason = %q, want it to mention %q", tt.name, got.Reason, tt.wantSubject)
+		}
+		if len(got.Decisions) == 0 {
+			t.Errorf("%s: LookupRoute() recorded no decisions", tt.name)
+		}
+	}
+
+	// The path of a route whose next hop is down is still reported
+	got, _ := agent.LookupRoute(health.RouteLookupRequest{Destination: "172.16.1.1"})
+	if got.Selected == nil || got.Selected.Exit.ID != exitID.String() || got.Selected.Connected || len(got.Selected.Path) != 2 {
+		t.Errorf("Selected = %+v, want the disconnected path to the exit", got.Selected)
+	}
+
+	if _, err := agent.LookupRoute(health.RouteLookupRequest{}); err == nil {
+		t.Error("LookupRoute() without a destination should fail")
+	}
+
+	data, ok := agent.handleRouteLookup([]byte(`{"destination":"8.8.8.8"}`))
+	if !ok || !strings.Contains(string(data), `"action":"local_exit"`) {
+		t.Errorf("handleRouteLookup() = %s, %v, want a local exit", data, ok)
+	}
+}
@@ -0,0 +1,150 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/postalsys/muti-metroo/internal/health"
+	"github.com/postalsys/muti-metroo/internal/logging"
+	"github.com/postalsys/muti-metroo/internal/recovery"
+	"github.com/postalsys/muti-metroo/internal/socks5"
+)
+
+// blocklistRefreshTimeout limits a refresh requested through the API. It
+// stays below the timeout of remote control requests.
+const blocklistRefreshTimeout = 25 * time.Second
+
+// initSOCKS5Blocklist creates the SOCKS5 destination blocklist when it is
+// enabled. Feeds are fetched once the agent starts.
+func (a *Agent) initSOCKS5Blocklist() error {
+	cfg := a.cfg.SOCKS5.Blocklist
+	if !cfg.Enabled {
+		return nil
+	}
+
+	blocklist, err := socks5.NewBlocklist(socks5.BlocklistConfig{
+		Domains: cfg.Domains,
+		CIDRs:   cfg.CIDRs,
+		Files:   cfg.Files,
+		Feeds:   cfg.Feeds,
+		Logger:  a.logger.With(logging.KeyComponent, "socks5-blocklist"),
+	})
+	if err != nil {
+		return err
+	}
+	a.socks5Blocks = blocklist
+	return nil
+}
+
+// startSOCKS5BlocklistRefreshLoop fetches blocklist feeds right away and
+// reloads files and feeds every refresh interval.
+func (a *Agent) startSOCKS5BlocklistRefreshLoop() {
+	interval := a.cfg.SOCKS5.Blocklist.RefreshInterval
+	if interval <= 0 {
+		interval = socks5.DefaultBlocklistRefreshInterval
+	}
+
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+		defer recovery.RecoverWithLog(a.logger, "socks5BlocklistRefreshLoop")
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go func() {
+			<-a.stopCh
+			cancel()
+		}()
+
+		for {
+			// Failed sources are logged by the blocklist and keep their
+			// previous entries
+			a.socks5Blocks.Refresh(ctx)
+
+			select {
+			case <-a.stopCh:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// ManageBlocklist reports the SOCKS5 destination blocklist, checks whether
+// a destination would be blocked or reloads files and feeds. Implements
+// health.BlocklistManageProvider.
+func (a *Agent) ManageBlocklist(req health.BlocklistManageRequest) (*health.BlocklistManageResult, error) {
+	if a.socks5Blocks == nil {
+		return nil, fmt.Errorf("SOCKS5 blocklist is not enabled on this agent")
+	}
+
+	switch req.Action {
+	case "status":
+		st := a.socks5Blocks.Status()
+		return &health.BlocklistManageResult{Status: "ok", Blocklist: &st}, nil
+
+	case "check":
+		host := req.Destination
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if host == "" {
+			return nil, fmt.Errorf("destination is required")
+		}
+		m, blocked := a.socks5Blocks.Test(host)
+		result := &health.BlocklistManageResult{Status: "ok", Blocked: &blocked}
+		if blocked {
+			result.Match = &m
+			result.Message = fmt.Sprintf("%s is blocked by %s (%s)", host, m.Entry, m.Source)
+		} else {
+			result.Message = fmt.Sprintf("%s is not blocked", host)
+		}
+		return result, nil
+
+	case "refresh":
+		ctx, cancel := context.WithTimeout(context.Background(), blocklistRefreshTimeout)
+		defer cancel()
+		err := a.socks5Blocks.Refresh(ctx)
+		st := a.socks5Blocks.Status()
+		a.logger.Info("SOCKS5 blocklist refreshed via API", "entries", st.Entries)
+		result := &health.BlocklistManageResult{
+			Status:    "ok",
+			Message:   fmt.Sprintf("blocklist refreshed, %d entries", st.Entries),
+			Blocklist: &st,
+		}
+		if err != nil {
+			// Failed sources keep their previous entries
+			result.Status = "partial"
+			result.Message = fmt.Sprintf("blocklist refreshed with errors, %d entries: %v", st.Entries, err)
+		}
+		return result, nil
+
+	default:
+		return nil, fmt.Errorf("unknown action %q (expected status, check or refresh)", req.Action)
+	}
+}
+
+// handleBlocklistManage processes a ControlTypeBlocklistManage control
+// request.
+func (a *Agent) handleBlocklistManage(data []byte) ([]byte, bool) {
+	var req health.BlocklistManageRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		resp, _ := json.Marshal(map[string]string{"error": "invalid request: " + err.Error()})
+		return resp, false
+	}
+
+	result, err := a.ManageBlocklist(req)
+	if err != nil {
+		resp, _ := json.Marshal(map[string]string{"error": err.Error()})
+		return resp, false
+	}
+
+	resp, _ := json.Marshal(result)
+	return resp, true
+}
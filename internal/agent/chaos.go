@@ -0,0 +1,203 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/postalsys/muti-metroo/internal/chaos"
+	"github.com/postalsys/muti-metroo/internal/health"
+	"github.com/postalsys/muti-metroo/internal/identity"
+	"github.com/postalsys/muti-metroo/internal/logging"
+	"github.com/postalsys/muti-metroo/internal/transport"
+)
+
+// initChaos wraps all transports with the fault injection layer when
+// chaos.enabled is set.
+func (a *Agent) initChaos() {
+	cfg := a.cfg.Chaos
+	if !cfg.Enabled {
+		return
+	}
+	a.chaos = chaos.NewLinkInjector(chaos.LinkFaults{
+		Latency: cfg.Latency,
+		Jitter:  cfg.Jitter,
+		Loss:    cfg.Loss,
+	}, cfg.Seed)
+	for t, tr := range a.transports {
+		a.transports[t] = chaos.WrapTransport(tr, a.chaos)
+	}
+	a.logger.Warn("chaos fault injection enabled on peer links",
+		"latency", cfg.Latency.String(),
+		"jitter", cfg.Jitter.String(),
+		"loss", cfg.Loss)
+}
+
+// wrapTransport returns tr wrapped with the fault injection layer if chaos
+// is enabled, and tr itself otherwise.
+func (a *Agent) wrapTransport(tr transport.Transport) transport.Transport {
+	if a.chaos == nil {
+		return tr
+	}
+	return chaos.WrapTransport(tr, a.chaos)
+}
+
+// ManageChaos reports and changes the faults injected into peer links.
+// It fails unless chaos.enabled is set, since links are only wrapped at
+// startup. Implements health.ChaosManageProvider.
+func (a *Agent) ManageChaos(req health.ChaosManageRequest) (*health.ChaosManageResult, error) {
+	if a.chaos == nil {
+		return nil, fmt.Errorf("chaos is not enabled on this agent (set chaos.enabled)")
+	}
+
+	var message string
+	switch req.Action {
+	case "status":
+
+	case "set":
+		faults, err := parseLinkFaults(req)
+		if err != nil {
+			return nil, err
+		}
+		a.chaos.SetFaults(faults)
+		a.logger.Warn("chaos faults changed via API",
+			"latency", faults.Latency.String(),
+			"jitter", faults.Jitter.String(),
+			"loss", faults.Loss)
+		message = "faults updated"
+
+	case "partition":
+		if req.Peer == "" {
+			return nil, fmt.Errorf("peer is required for partition")
+		}
+		id, err := a.resolveChaosPeer(req.Peer)
+		if err != nil {
+			return nil, err
+		}
+		a.chaos.Partition(id)
+		// A silent link would look alive, since sending keepalives counts as
+		// activity; drop it so the mesh sees the loss and reconverges
+		a.peerMgr.Disconnect(id)
+		a.logger.Warn("chaos partition added via API", logging.KeyPeerID, id.ShortString())
+		message = "partitioned from " + id.ShortString()
+
+	case "heal":
+		if req.Peer == "" {
+			a.chaos.HealAll()
+			a.logger.Warn("chaos partitions healed via API")
+			message = "all partitions healed"
+			break
+		}
+		id, err := a.resolveChaosPeer(req.Peer)
+		if err != nil {
+			return nil, err
+		}
+		a.chaos.Heal(id)
+		a.logger.Warn("chaos partition healed via API", logging.KeyPeerID, id.ShortString())
+		message = "healed " + id.ShortString()
+
+	default:
+		return nil, fmt.Errorf("unknown action %q (expected status, set, partition or heal)", req.Action)
+	}
+
+	return a.chaosResult(message), nil
+}
+
+// parseLinkFaults validates the faults of a set request.
+func parseLinkFaults(req health.ChaosManageRequest) (chaos.LinkFaults, error) {
+	var faults chaos.LinkFaults
+	for _, f := range []struct {
+		name  string
+		value string
+		dst   *time.Duration
+	}{
+		{"latency", req.Latency, &faults.Latency},
+		{"jitter", req.Jitter, &faults.Jitter},
+	} {
+		if f.value == "" {
+			continue
+		}
+		d, err := time.ParseDuration(f.value)
+		if err != nil {
+			return faults, fmt.Errorf("invalid %s: %w", f.name, err)
+		}
+		if d < 0 {
+			return faults, fmt.Errorf("%s must not be negative", f.name)
+		}
+		*f.dst = d
+	}
+	if req.Loss < 0 || req.Loss > 1 {
+		return faults, fmt.Errorf("loss must be between 0 and 1")
+	}
+	faults.Loss = req.Loss
+	return faults, nil
+}
+
+// resolveChaosPeer returns the agent ID named by s: a full agent ID, or a
+// prefix matching exactly one connected or partitioned peer.
+func (a *Agent) resolveChaosPeer(s string) (identity.AgentID, error) {
+	if id, err := identity.ParseAgentID(s); err == nil {
+		return id, nil
+	}
+
+	prefix := strings.ToLower(s)
+	matches := make(map[identity.AgentID]bool)
+	for _, id := range slices.Concat(a.peerMgr.GetPeerIDs(), a.chaos.Partitions()) {
+		if strings.HasPrefix(id.String(), prefix) {
+			matches[id] = true
+		}
+	}
+	if len(matches) > 1 {
+		return identity.AgentID{}, fmt.Errorf("peer %q is ambiguous", s)
+	}
+	for id := range matches {
+		return id, nil
+	}
+	return identity.AgentID{}, fmt.Errorf("no peer matches %q", s)
+}
+
+// chaosResult reports the current faults, partitions and counters.
+func (a *Agent) chaosResult(message string) *health.ChaosManageResult {
+	faults := a.chaos.Faults()
+	stats := a.chaos.Stats()
+
+	partitions := []string{}
+	for _, id := range a.chaos.Partitions() {
+		partitions = append(partitions, id.String())
+	}
+	slices.Sort(partitions)
+
+	return &health.ChaosManageResult{
+		Status:     "ok",
+		Message:    message,
+		Latency:    faults.Latency.String(),
+		Jitter:     faults.Jitter.String(),
+		Loss:       faults.Loss,
+		Partitions: partitions,
+		Stats: health.ChaosStats{
+			Delayed:     stats.Delayed,
+			Dropped:     stats.Dropped,
+			Partitioned: stats.Partitioned,
+		},
+	}
+}
+
+// handleChaosManage processes a ControlTypeChaosManage control request.
+func (a *Agent) handleChaosManage(data []byte) ([]byte, bool) {
+	var req health.ChaosManageRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		resp, _ := json.Marshal(map[string]string{"error": "invalid request: " + err.Error()})
+		return resp, false
+	}
+
+	result, err := a.ManageChaos(req)
+	if err != nil {
+		resp, _ := json.Marshal(map[string]string{"error": err.Error()})
+		return resp, false
+	}
+
+	resp, _ := json.Marshal(result)
+	return resp, true
+}
@@ -0,0 +1,132 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/postalsys/muti-metroo/internal/crypto"
+	"github.com/postalsys/muti-metroo/internal/exit"
+	"github.com/postalsys/muti-metroo/internal/protocol"
+	"github.com/postalsys/muti-metroo/internal/proxyproto"
+	"github.com/postalsys/muti-metroo/internal/routing"
+	"github.com/postalsys/muti-metroo/internal/socks5"
+)
+
+type openChainKey struct{}
+
+// withOpenChain returns ctx carrying the open chain shared by the attempts
+// of one dial.
+func withOpenChain(ctx context.Context, chain *protocol.OpenChain) context.Context {
+	return context.WithValue(ctx, openChainKey{}, chain)
+}
+
+// setClientIdentity copies the client address shared by the ingress
+// listener (see proxyproto.WithClientAddr) into a STREAM_OPEN, the SOCKS5
+// username when socks5.send_username is set, and the client's namespace.
+// The open token and the proof of the next attempt come from the open
+// chain in ctx when the dial may be retried over other paths (see
+// dialIPWithRetry); other opens get a chain of their own.
+func (a *Agent) setClientIdentity(ctx context.Context, open *protocol.StreamOpen) {
+	open.Namespace = a.streamNamespace(ctx)
+	chain, _ := ctx.Value(openChainKey{}).(*protocol.OpenChain)
+	if chain == nil {
+		chain = protocol.NewOpenChain()
+	}
+	open.OpenToken, open.OpenProof = chain.Next()
+	if a.cfg.SOCKS5.SendUsername {
+		open.ClientUser = socks5.UserFromContext(ctx)
+	}
+	addr := proxyproto.ClientAddrFromContext(ctx)
+	if addr == nil {
+		return
+	}
+	if ip4 := addr.IP.To4(); ip4 != nil {
+		open.ClientAddr = ip4
+	} else {
+		open.ClientAddr = addr.IP.To16()
+	}
+	open.ClientPort = uint16(addr.Port)
+}
+
+// clientContext returns ctx carrying the client address, username, open
+// token and path payload limit of a received STREAM_OPEN, for the exit's
+// PROXY headers, user policy, audit log, deduplication of retried opens and
+// frame sizing.
+func clientContext(ctx context.Context, open *protocol.StreamOpen) context.Context {
+	ctx = exit.WithOpenToken(exit.WithUser(ctx, open.ClientUser), open.OpenToken, open.OpenProof)
+	ctx = protocol.WithPathMaxPayload(ctx, int(open.MaxPayload))
+	if open.E2EFlags&protocol.E2EFlagRekey != 0 {
+		ctx = crypto.WithRekey(ctx)
+	}
+	if len(open.ClientAddr) == 0 {
+		return ctx
+	}
+	return proxyproto.WithClientAddr(ctx, &net.TCPAddr{IP: net.IP(open.ClientAddr), Port: int(open.ClientPort)})
+}
+
+// exitProxyProtocol builds the PROXY protocol destinations from
+// exit.proxy_protocol.
+func (a *Agent) exitProxyProtocol() exit.ProxyProtocol {
+	var p exit.ProxyProtocol
+	for _, cidr := range a.cfg.Exit.ProxyProtocol {
+		p.Destinations = append(p.Destinations, routing.MustParseCIDR(cidr))
+	}
+	return p
+}
+
+// exitUserPolicy builds the per-user egress policy from exit.user_policy.
+func (a *Agent) exitUserPolicy() exit.UserPolicy {
+	cfg := a.cfg.Exit.UserPolicy
+	p := exit.UserPolicy{
+		Enabled:     cfg.Enabled,
+		Users:       make(map[string]exit.UserRoutes, len(cfg.Users)),
+		DefaultDeny: cfg.DefaultAction != "allow",
+	}
+	for user, ur := range cfg.Users {
+		var routes exit.UserRoutes
+		for _, cidr := range ur.Routes {
+			routes.Routes = append(routes.Routes, routing.MustParseCIDR(cidr))
+		}
+		for _, pattern := range ur.DomainRoutes {
+			isWildcard, baseDomain := routing.ParseDomainPattern(pattern)
+			routes.Domains = append(routes.Domains, exit.DomainPattern{
+				Pattern:    pattern,
+				IsWildcard: isWildcard,
+				BaseDomain: baseDomain,
+			})
+		}
+		p.Users[user] = routes
+	}
+	return p
+}
+
+// errUserNotAllowed reports a destination refused by exit.user_policy when
+// this agent is the exit.
+func errUserNotAllowed(host string) error {
+	return &streamOpenError{
+		code: protocol.ErrNotAllowed,
+		err:  fmt.Errorf("destination %s not allowed for user", host),
+	}
+}
+
+// dialLocalExit dials a destination this agent is the exit for, applying
+// the port classes and sending the PROXY header as the exit handler would.
+func (a *Agent) dialLocalExit(ctx context.Context, network, address string) (net.Conn, error) {
+	release, err := a.admitLocalExitPort(address)
+	if err != nil {
+		return nil, err
+	}
+	dialer := &net.Dialer{Timeout: a.cfg.SOCKS5.ConnectTimeout}
+	conn, err := dialer.DialContext(ctx, network, address)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	if err := a.exitProxyProtocol().WriteHeader(ctx, conn); err != nil {
+		conn.Close()
+		release()
+		return nil, err
+	}
+	return &portLimitConn{Conn: conn, release: release}, nil
+}
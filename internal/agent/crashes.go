@@ -0,0 +1,136 @@
+package agent
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+
+	"github.com/postalsys/muti-metroo/internal/health"
+	"github.com/postalsys/muti-metroo/internal/protocol"
+	"github.com/postalsys/muti-metroo/internal/recovery"
+	"github.com/postalsys/muti-metroo/internal/sysinfo"
+)
+
+// crashChunkSize is the compressed report bytes returned by one get. Base64
+// and JSON overhead keep the response under MaxControlResponseData.
+const crashChunkSize = 8 * 1024
+
+// initCrashReports creates and starts the crash report store when crash
+// reports are enabled. A report collected from a previous fatal crash is
+// logged.
+func (a *Agent) initCrashReports() error {
+	cfg := a.cfg.CrashReports
+	if !cfg.Enabled {
+		return nil
+	}
+
+	store, err := recovery.NewStore(filepath.Join(a.dataDir, recovery.DirName), cfg.MaxReports, recovery.Metadata{
+		AgentID:    a.id.String(),
+		Version:    sysinfo.Version,
+		ConfigHash: a.cfg.Hash(),
+	})
+	if err != nil {
+		return err
+	}
+	collected, err := store.Start()
+	if err != nil {
+		store.Close()
+		return err
+	}
+	a.crashReports = store
+
+	if collected != nil {
+		a.logger.Warn("previous run crashed, crash report saved",
+			"report", collected.ID,
+			"panic", collected.Panic,
+			"version", collected.Version)
+	}
+	return nil
+}
+
+// ManageCrashReports lists, returns and removes crash reports. Implements
+// health.CrashManageProvider.
+func (a *Agent) ManageCrashReports(req health.CrashManageRequest) (*health.CrashManageResult, error) {
+	if a.crashReports == nil {
+		return nil, fmt.Errorf("crash reports are not enabled on this agent")
+	}
+
+	switch req.Action {
+	case "list":
+		return &health.CrashManageResult{Status: "ok", Reports: a.crashReports.List()}, nil
+
+	case "get":
+		if req.ID == "" {
+			return nil, fmt.Errorf("id is required")
+		}
+		r, err := a.crashReports.Get(req.ID)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", req.ID, err)
+		}
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if err := json.NewEncoder(zw).Encode(r); err != nil {
+			return nil, err
+		}
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+		size := int64(buf.Len())
+		if req.Offset < 0 || req.Offset > size {
+			return nil, fmt.Errorf("offset %d out of range (size %d)", req.Offset, size)
+		}
+		end := min(req.Offset+crashChunkSize, size)
+		return &health.CrashManageResult{
+			Status: "ok",
+			ID:     r.ID,
+			Offset: req.Offset,
+			Size:   size,
+			Data:   buf.Bytes()[req.Offset:end],
+		}, nil
+
+	case "clear":
+		n, err := a.crashReports.Delete(req.ID)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", req.ID, err)
+		}
+		a.logger.Info("crash reports cleared via API", "deleted", n)
+		return &health.CrashManageResult{
+			Status:  "ok",
+			Message: fmt.Sprintf("%d crash reports removed", n),
+			Deleted: n,
+		}, nil
+
+	default:
+		return nil, fmt.Errorf("unknown action %q (expected list, get or clear)", req.Action)
+	}
+}
+
+// handleCrashManage processes a ControlTypeCrashManage control request. List
+// results are cut to the newest reports that fit a control response.
+func (a *Agent) handleCrashManage(data []byte) ([]byte, bool) {
+	var req health.CrashManageRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		resp, _ := json.Marshal(map[string]string{"error": "invalid request: " + err.Error()})
+		return resp, false
+	}
+
+	result, err := a.ManageCrashReports(req)
+	if err != nil {
+		resp, _ := json.Marshal(map[string]string{"error": err.Error()})
+		return resp, false
+	}
+
+	for {
+		resp, err := json.Marshal(result)
+		if err != nil {
+			return []byte(err.Error()), false
+		}
+		if len(resp) <= protocol.MaxControlResponseData || len(result.Reports) == 0 {
+			return resp, true
+		}
+		result.Reports = result.Reports[:len(result.Reports)*3/4]
+		result.Truncated = true
+	}
+}
@@ -0,0 +1,64 @@
+package agent
+
+import (
+	"encoding/json"
+
+	"github.com/postalsys/muti-metroo/internal/health"
+	"github.com/postalsys/muti-metroo/internal/protocol"
+)
+
+// RouteDampening returns the origins with a flap penalty and the peers whose
+// route updates were rate limited. Implements health.RouteDampeningProvider.
+func (a *Agent) RouteDampening() health.RouteDampeningResponse {
+	resp := health.RouteDampeningResponse{
+		DampeningEnabled: a.cfg.Routing.Dampening.Enabled,
+		RateLimitEnabled: a.cfg.Routing.PeerRateLimit.Enabled,
+		Origins:          []health.DampenedOriginInfo{},
+		RateLimitedPeers: []health.RateLimitedPeerInfo{},
+	}
+	if a.flooder == nil {
+		return resp
+	}
+
+	for _, o := range a.flooder.DampenedOrigins() {
+		info := health.DampenedOriginInfo{
+			OriginID:   o.Origin.String(),
+			OriginName: a.routeMgr.GetDisplayName(o.Origin),
+			Penalty:    o.Penalty,
+			Flaps:      o.Flaps,
+			Suppressed: o.Suppressed,
+			Routes:     o.Routes,
+		}
+		if o.Suppressed {
+			info.SuppressedSince = &o.Since
+			info.ReuseAt = &o.ReuseAt
+		}
+		resp.Origins = append(resp.Origins, info)
+	}
+	for _, p := range a.flooder.RateLimitedPeers() {
+		resp.RateLimitedPeers = append(resp.RateLimitedPeers, health.RateLimitedPeerInfo{
+			PeerID:      p.Peer.String(),
+			PeerName:    a.routeMgr.GetDisplayName(p.Peer),
+			Dropped:     p.Dropped,
+			LastDropped: p.LastDropped,
+		})
+	}
+	return resp
+}
+
+// getLocalRouteDampening returns the local route dampening state for remote
+// queries. Route lists are left out when they do not fit a control response.
+func (a *Agent) getLocalRouteDampening() ([]byte, bool) {
+	resp := a.RouteDampening()
+	data, err := json.Marshal(resp)
+	if err == nil && len(data) > protocol.MaxControlResponseData {
+		for i := range resp.Origins {
+			resp.Origins[i].Routes = nil
+		}
+		data, err = json.Marshal(resp)
+	}
+	if err != nil {
+		return []byte(err.Error()), false
+	}
+	return data, true
+}
@@ -0,0 +1,138 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/postalsys/muti-metroo/internal/health"
+	"github.com/postalsys/muti-metroo/internal/logging"
+	"github.com/postalsys/muti-metroo/internal/transport"
+)
+
+// maxDebugCaptureDuration bounds captures started through the API, so a
+// forgotten capture does not keep writing secrets to disk.
+const maxDebugCaptureDuration = 24 * time.Hour
+
+// initDebugCapture starts the capture requested by debug.key_log and
+// debug.qlog.
+func (a *Agent) initDebugCapture() {
+	cfg := a.cfg.Debug
+	if !cfg.KeyLog && !cfg.Qlog {
+		return
+	}
+	opts := transport.DebugCaptureOptions{Duration: cfg.Duration}
+	if cfg.KeyLog {
+		opts.KeyLogFile = a.cfg.DebugKeyLogFile()
+	}
+	if cfg.Qlog {
+		opts.QlogDir = a.cfg.DebugQlogDir()
+	}
+	if err := a.debugCap.Start(opts); err != nil {
+		a.logger.Error("failed to start debug capture", logging.KeyError, err)
+		return
+	}
+	a.logger.Warn("debug capture of peer connections started",
+		"key_log_file", opts.KeyLogFile,
+		"qlog_dir", opts.QlogDir,
+		"duration", cfg.Duration.String())
+}
+
+// ManageDebugCapture reports, starts and stops the TLS key log and QUIC
+// qlog capture of peer connections. Captures write to the paths from the
+// debug config, never to paths given in the request. Implements
+// health.DebugCaptureProvider.
+func (a *Agent) ManageDebugCapture(req health.DebugCaptureRequest) (*health.DebugCaptureResult, error) {
+	var message string
+	switch req.Action {
+	case "status":
+
+	case "start":
+		if req.Duration == "" {
+			return nil, fmt.Errorf("duration is required for start")
+		}
+		d, err := time.ParseDuration(req.Duration)
+		if err != nil {
+			return nil, fmt.Errorf("invalid duration: %w", err)
+		}
+		if d <= 0 || d > maxDebugCaptureDuration {
+			return nil, fmt.Errorf("duration must be positive and at most 24h")
+		}
+
+		keyLog, qlog := req.KeyLog, req.Qlog
+		if !keyLog && !qlog {
+			keyLog, qlog = true, true
+		}
+		opts := transport.DebugCaptureOptions{Duration: d}
+		if keyLog {
+			if opts.KeyLogFile = a.cfg.DebugKeyLogFile(); opts.KeyLogFile == "" {
+				return nil, fmt.Errorf("key log needs debug.key_log_file or agent.data_dir")
+			}
+		}
+		if qlog {
+			if opts.QlogDir = a.cfg.DebugQlogDir(); opts.QlogDir == "" {
+				return nil, fmt.Errorf("qlog needs debug.qlog_dir or agent.data_dir")
+			}
+		}
+		if err := a.debugCap.Start(opts); err != nil {
+			return nil, err
+		}
+		a.logger.Warn("debug capture of peer connections started via API",
+			"key_log_file", opts.KeyLogFile,
+			"qlog_dir", opts.QlogDir,
+			"duration", d.String())
+		message = "capture started for " + d.String()
+
+	case "stop":
+		if !a.debugCap.Stop() {
+			message = "no capture running"
+			break
+		}
+		a.logger.Warn("debug capture of peer connections stopped via API")
+		message = "capture stopped"
+
+	default:
+		return nil, fmt.Errorf("unknown action %q (expected status, start or stop)", req.Action)
+	}
+
+	return a.debugCaptureResult(message), nil
+}
+
+// debugCaptureResult reports the state of the capture.
+func (a *Agent) debugCaptureResult(message string) *health.DebugCaptureResult {
+	st := a.debugCap.Status()
+	result := &health.DebugCaptureResult{
+		Status:     "ok",
+		Message:    message,
+		Active:     st.Active,
+		KeyLogFile: st.KeyLogFile,
+		QlogDir:    st.QlogDir,
+		KeyLogs:    st.KeyLogs,
+		QlogFiles:  st.QlogFiles,
+	}
+	if st.Active {
+		result.Started = st.Started.UTC().Format(time.RFC3339)
+		if !st.Until.IsZero() {
+			result.Until = st.Until.UTC().Format(time.RFC3339)
+		}
+	}
+	return result
+}
+
+// handleDebugCapture processes a ControlTypeDebugCapture control request.
+func (a *Agent) handleDebugCapture(data []byte) ([]byte, bool) {
+	var req health.DebugCaptureRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		resp, _ := json.Marshal(map[string]string{"error": "invalid request: " + err.Error()})
+		return resp, false
+	}
+
+	result, err := a.ManageDebugCapture(req)
+	if err != nil {
+		resp, _ := json.Marshal(map[string]string{"error": err.Error()})
+		return resp, false
+	}
+
+	resp, _ := json.Marshal(result)
+	return resp, true
+}
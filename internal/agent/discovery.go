@@ -0,0 +1,272 @@
+package agent
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/postalsys/muti-metroo/internal/config"
+	"github.com/postalsys/muti-metroo/internal/discovery"
+	"github.com/postalsys/muti-metroo/internal/identity"
+	"github.com/postalsys/muti-metroo/internal/logging"
+	"github.com/postalsys/muti-metroo/internal/recovery"
+)
+
+// Discovery sources
+const (
+	discoverySourceDNS  = "dns"
+	discoverySourceMDNS = "mdns"
+)
+
+// discoveryState tracks the peers added by discovery sources.
+type discoveryState struct {
+	mu      sync.Mutex
+	sources map[string]map[string]bool // Source -> addresses it last reported
+	added   map[string]bool            // Addresses added to the peer manager
+}
+
+func newDiscoveryState() *discoveryState {
+	return &discoveryState{
+		sources: make(map[string]map[string]bool),
+		added:   make(map[string]bool),
+	}
+}
+
+// startDiscovery starts the configured discovery sources and the mDNS
+// responder. Must be called after the listeners are up.
+func (a *Agent) startDiscovery() {
+	cfg := a.cfg.Discovery
+
+	if cfg.DNS.Enabled {
+		a.wg.Add(1)
+		go a.discoveryLoop(discoverySourceDNS, cfg.DNS.Interval, func(ctx context.Context) ([]discovery.Peer, error) {
+			return discovery.LookupDNS(ctx, nil, cfg.DNS.Zone)
+		})
+	}
+
+	if !cfg.MDNS.Enabled && !cfg.MDNS.Announce {
+		return
+	}
+
+	var iface *net.Interface
+	if cfg.MDNS.Interface != "" {
+		var err error
+		iface, err = net.InterfaceByName(cfg.MDNS.Interface)
+		if err != nil {
+			a.logger.Warn("mDNS discovery disabled",
+				"interface", cfg.MDNS.Interface,
+				logging.KeyError, err)
+			return
+		}
+	}
+
+	if cfg.MDNS.Enabled {
+		a.wg.Add(1)
+		go a.discoveryLoop(discoverySourceMDNS, cfg.MDNS.Interval, func(ctx context.Context) ([]discovery.Peer, error) {
+			return discovery.Browse(ctx, iface, cfg.MDNS.Timeout)
+		})
+	}
+
+	if cfg.MDNS.Announce {
+		responder, err := discovery.NewResponder(discovery.ResponderConfig{
+			AgentID:   a.id.String(),
+			Listeners: a.announcedListeners(),
+			Interface: iface,
+			Logger:    a.logger,
+			Paused:    a.peerMgr.IsPaused,
+		})
+		if err != nil {
+			a.logger.Warn("failed to start mDNS responder",
+				logging.KeyError, err)
+			return
+		}
+		a.mdnsResponder = responder
+		a.logger.Info("mDNS responder started")
+	}
+}
+
+// announcedListeners returns the listeners published over mDNS. Plaintext
+// WebSocket listeners sit behind a reverse proxy and are not reachable on
+// their own port, so they are left out.
+func (a *Agent) announcedListeners() []discovery.Listener {
+	var out []discovery.Listener
+	for i, l := range a.listeners {
+		if i >= len(a.cfg.Listeners) || a.cfg.Listeners[i].PlainText {
+			continue
+		}
+		_, portStr, err := net.SplitHostPort(l.Addr().String())
+		if err != nil {
+			continue
+		}
+		port, err := strconv.ParseUint(portStr, 10, 16)
+		if err != nil || port == 0 {
+			continue
+		}
+		lc := a.cfg.Listeners[i]
+		path := lc.Path
+		if path == "" && lc.Transport != "quic" && lc.Transport != "tcp" {
+			path = "/mesh"
+		}
+		out = append(out, discovery.Listener{
+			Transport: lc.Transport,
+			Port:      uint16(port),
+			Path:      path,
+		})
+	}
+	return out
+}
+
+// discoveryLoop runs lookup once at startup and then every interval,
+// feeding the results into the peer manager.
+func (a *Agent) discoveryLoop(source string, interval time.Duration, lookup func(context.Context) ([]discovery.Peer, error)) {
+	defer a.wg.Done()
+	defer recovery.RecoverWithLog(a.logger, "discoveryLoop")
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-a.stopCh
+		cancel()
+	}()
+
+	a.logger.Debug("peer discovery started",
+		"source", source,
+		"interval", interval)
+
+	for {
+		if !a.peerMgr.IsPaused() {
+			peers, err := lookup(ctx)
+			if ctx.Err() != nil {
+				return
+			}
+			a.applyDiscovered(source, peers, err)
+		}
+
+		select {
+		case <-a.stopCh:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// applyDiscovered dials newly discovered peers and forgets the ones no
+// source reports any more. Discovered peers are dialed like static peers,
+// so the same TLS, strict mode and pinning rules apply. Peers that are
+// already connected, configured statically or are this agent are skipped.
+// After a failed lookup nothing is removed, so a DNS outage does not drop
+// peers.
+func (a *Agent) applyDiscovered(source string, peers []discovery.Peer, lookupErr error) {
+	if lookupErr != nil {
+		a.logger.Warn("peer discovery failed",
+			"source", source,
+			logging.KeyError, lookupErr)
+	}
+
+	static := make(map[string]bool, len(a.cfg.Peers))
+	for _, p := range a.cfg.Peers {
+		static[p.Address] = true
+	}
+	connected := make(map[identity.AgentID]bool)
+	for _, id := range a.peerMgr.GetPeerIDs() {
+		connected[id] = true
+	}
+
+	d := a.discovery
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	reported := make(map[string]config.PeerConfig, len(peers))
+	var order []string // Reported addresses in the order of the source
+	for _, p := range peers {
+		if p.ID != "" {
+			id, err := identity.ParseAgentID(p.ID)
+			if err != nil {
+				a.logger.Debug("ignoring discovered peer with invalid ID",
+					"source", source,
+					logging.KeyPeerID, p.ID)
+				continue
+			}
+			if id == a.id || connected[id] {
+				continue
+			}
+		}
+		addr := p.Address()
+		if static[addr] {
+			continue
+		}
+		if _, dup := reported[addr]; !dup {
+			order = append(order, addr)
+		}
+		reported[addr] = config.PeerConfig{
+			ID:        p.ID,
+			Transport: p.Transport,
+			Address:   addr,
+			TLS:       a.cfg.Discovery.TLS,
+		}
+	}
+
+	// Record what the source reports. After a failed lookup the previous
+	// report is kept and only extended.
+	addrs := d.sources[source]
+	if addrs == nil || lookupErr == nil {
+		addrs = make(map[string]bool, len(reported))
+		d.sources[source] = addrs
+	}
+	for addr := range reported {
+		addrs[addr] = true
+	}
+
+	// Forget peers no source reports any more
+	for addr := range d.added {
+		if d.reportedLocked(addr) {
+			continue
+		}
+		delete(d.added, addr)
+		a.peerMgr.RemovePeer(addr)
+		a.logger.Info("discovered peer withdrawn",
+			"source", source,
+			logging.KeyAddress, addr)
+	}
+
+	var dial []config.PeerConfig
+	for _, addr := range order {
+		if d.added[addr] {
+			continue
+		}
+		if len(d.added) >= a.cfg.Discovery.MaxPeers {
+			a.logger.Debug("discovered peer limit reached",
+				"source", source,
+				logging.KeyAddress, addr)
+			continue
+		}
+		d.added[addr] = true
+		dial = append(dial, reported[addr])
+	}
+
+	for _, pc := range dial {
+		a.logger.Info("discovered peer",
+			"source", source,
+			logging.KeyPeerID, pc.ID,
+			logging.KeyAddress, pc.Address,
+			logging.KeyTransport, pc.Transport)
+		a.wg.Add(1)
+		go a.connectToPeer(pc)
+	}
+}
+
+// reportedLocked reports whether any source still reports addr.
+// Caller must hold d.mu.
+func (d *discoveryState) reportedLocked(addr string) bool {
+	for _, addrs := range d.sources {
+		if addrs[addr] {
+			return true
+		}
+	}
+	return false
+}
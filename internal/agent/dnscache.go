@@ -0,0 +1,94 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/postalsys/muti-metroo/internal/exit"
+	"github.com/postalsys/muti-metroo/internal/health"
+)
+
+// exitDNSConfig converts the exit.dns configuration for the exit handler.
+func (a *Agent) exitDNSConfig() exit.DNSConfig {
+	c := a.cfg.Exit.DNS.Cache
+	zones := make([]exit.DNSZone, len(a.cfg.Exit.DNS.Zones))
+	for i, z := range a.cfg.Exit.DNS.Zones {
+		zones[i] = exit.DNSZone{Zone: z.Zone, Servers: z.Servers}
+	}
+	return exit.DNSConfig{
+		Servers: a.cfg.Exit.DNS.Servers,
+		Timeout: a.cfg.Exit.DNS.Timeout,
+		Cache: exit.DNSCacheConfig{
+			Enabled:     c.Enabled,
+			MinTTL:      c.MinTTL,
+			MaxTTL:      c.MaxTTL,
+			DefaultTTL:  c.DefaultTTL,
+			NegativeTTL: c.NegativeTTL,
+			MaxEntries:  c.MaxEntries,
+		},
+		Zones: zones,
+	}
+}
+
+// ManageDNSCache reports or flushes the exit handler's DNS cache.
+// Implements health.DNSCacheManageProvider.
+func (a *Agent) ManageDNSCache(action, domain string) (*health.DNSCacheManageResult, error) {
+	h := a.exitHandler
+	if h == nil {
+		return nil, fmt.Errorf("DNS cache not available: exit is not enabled on this agent")
+	}
+
+	switch action {
+	case "stats":
+		s := h.DNSCacheStats()
+		return &health.DNSCacheManageResult{
+			Status: "ok",
+			Stats: &health.DNSCacheStats{
+				Enabled:         s.Enabled,
+				Entries:         s.Entries,
+				NegativeEntries: s.NegativeEntries,
+				Hits:            s.Hits,
+				NegativeHits:    s.NegativeHits,
+				Misses:          s.Misses,
+				Evictions:       s.Evictions,
+			},
+		}, nil
+
+	case "flush":
+		n := h.FlushDNSCache(domain)
+		msg := fmt.Sprintf("flushed %d cache entries", n)
+		if domain != "" {
+			msg = fmt.Sprintf("flushed %d cache entries for %q", n, domain)
+		}
+		a.logger.Info("exit DNS cache flushed", "domain", domain, "entries", n)
+		return &health.DNSCacheManageResult{
+			Status:  "ok",
+			Message: msg,
+			Flushed: n,
+		}, nil
+
+	default:
+		return nil, fmt.Errorf("unknown action %q (expected stats or flush)", action)
+	}
+}
+
+// handleDNSCacheManage processes a ControlTypeDNSCacheManage control request.
+func (a *Agent) handleDNSCacheManage(data []byte) ([]byte, bool) {
+	var req struct {
+		Action string `json:"action"`
+		Domain string `json:"domain"`
+	}
+	if err := json.Unmarshal(data, &req); err != nil {
+		resp, _ := json.Marshal(map[string]string{"error": "invalid request: " + err.Error()})
+		return resp, false
+	}
+
+	result, err := a.ManageDNSCache(req.Action, req.Domain)
+	if err != nil {
+		resp, _ := json.Marshal(map[string]string{"error": err.Error()})
+		return resp, false
+	}
+
+	resp, _ := json.Marshal(result)
+	return resp, true
+}
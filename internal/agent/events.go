@@ -0,0 +1,156 @@
+package agent
+
+import (
+	"time"
+
+	"github.com/postalsys/muti-metroo/internal/exit"
+	"github.com/postalsys/muti-metroo/internal/health"
+	"github.com/postalsys/muti-metroo/internal/peer"
+	"github.com/postalsys/muti-metroo/internal/routing"
+	"github.com/postalsys/muti-metroo/internal/stream"
+)
+
+// routeEventBuffer is the route change queue of routeEventLoop. Changes
+// beyond it are dropped by the routing manager.
+const routeEventBuffer = 256
+
+// eventsWanted reports whether an /events client is connected, so event
+// data is only built when someone receives it.
+func (a *Agent) eventsWanted() bool {
+	return a.healthServer != nil && a.healthServer.HasEventSubscribers()
+}
+
+// publishPeerEvent publishes peer_up or peer_down for a connection.
+func (a *Agent) publishPeerEvent(eventType string, conn *peer.Connection, err error) {
+	if !a.eventsWanted() {
+		return
+	}
+	ev := health.PeerEvent{
+		ID:          conn.RemoteID.String(),
+		ShortID:     conn.RemoteID.ShortString(),
+		DisplayName: conn.RemoteDisplayName,
+		Transport:   string(conn.TransportType()),
+		IsDialer:    conn.IsDialer(),
+	}
+	if err != nil {
+		ev.Error = err.Error()
+	}
+	a.healthServer.PublishEvent(eventType, ev)
+}
+
+// publishStreamEvent publishes stream_open or stream_close.
+func (a *Agent) publishStreamEvent(eventType string, info health.StreamInfo, err error) {
+	ev := health.StreamEvent{StreamInfo: info}
+	if err != nil {
+		ev.Error = err.Error()
+	}
+	a.healthServer.PublishEvent(eventType, ev)
+}
+
+// outboundStreamOpened and outboundStreamClosed are the stream manager
+// callbacks.
+func (a *Agent) outboundStreamOpened(s *stream.Stream) {
+	if a.eventsWanted() {
+		a.publishStreamEvent(health.EventStreamOpen, outboundStreamInfo(s, time.Now()), nil)
+	}
+}
+
+func (a *Agent) outboundStreamClosed(s *stream.Stream, err error) {
+	if a.eventsWanted() {
+		a.publishStreamEvent(health.EventStreamClose, outboundStreamInfo(s, time.Now()), err)
+	}
+}
+
+// exitStreamOpened and exitStreamClosed are the exit handler hooks.
+func (a *Agent) exitStreamOpened(ac *exit.ActiveConnection) {
+	if a.eventsWanted() {
+		a.publishStreamEvent(health.EventStreamOpen, exitStreamInfo(ac, time.Now()), nil)
+	}
+}
+
+func (a *Agent) exitStreamClosed(ac *exit.ActiveConnection) {
+	a.recordExitUsage(ac, true)
+	if a.eventsWanted() {
+		a.publishStreamEvent(health.EventStreamClose, exitStreamInfo(ac, time.Now()), nil)
+	}
+}
+
+// relayStreamOpened and relayStreamClosed are the TCP relay table hooks.
+func (a *Agent) relayStreamOpened(e *relayEntry) {
+	if a.eventsWanted() {
+		a.publishStreamEvent(health.EventStreamOpen, relayStreamInfo(e, time.Now()), nil)
+	}
+}
+
+func (a *Agent) relayStreamClosed(e *relayEntry) {
+	if a.eventsWanted() {
+		a.publishStreamEvent(health.EventStreamClose, relayStreamInfo(e, time.Now()), nil)
+	}
+}
+
+// routeEventLoop turns routing table changes into route_add and
+// route_withdraw events. Periodic re-advertisements update routes without
+// changing them, so only new routes and changes of next hop or metric are
+// published.
+func (a *Agent) routeEventLoop() {
+	defer a.wg.Done()
+
+	changes := make(chan routing.RouteChange, routeEventBuffer)
+	a.routeMgr.Subscribe(changes)
+	defer a.routeMgr.Unsubscribe(changes)
+
+	type routeKey struct{ network, origin string }
+	known := make(map[routeKey]*routing.Route)
+	for _, r := range a.routeMgr.Table().GetAllRoutes() {
+		known[routeKey{r.Network.String(), r.OriginAgent.String()}] = r
+	}
+
+	for {
+		select {
+		case <-a.stopCh:
+			return
+		case change := <-changes:
+			r := change.Route
+			if r == nil || r.Network == nil {
+				continue
+			}
+			key := routeKey{r.Network.String(), r.OriginAgent.String()}
+			prev, ok := known[key]
+
+			switch change.Type {
+			case routing.RouteAdded, routing.RouteUpdated:
+				known[key] = r
+				if ok && prev.NextHop == r.NextHop && prev.Metric == r.Metric {
+					continue
+				}
+				if a.eventsWanted() {
+					a.healthServer.PublishEvent(health.EventRouteAdd, a.routeEvent(r))
+				}
+			case routing.RouteRemoved:
+				// Removal after a peer disconnect names the next hop; skip
+				// it when the route was since learned through another peer
+				if !ok || (!r.NextHop.IsZero() && prev.NextHop != r.NextHop) {
+					continue
+				}
+				delete(known, key)
+				if a.eventsWanted() {
+					a.healthServer.PublishEvent(health.EventRouteWithdraw, a.routeEvent(r))
+				}
+			}
+		}
+	}
+}
+
+// routeEvent describes a route for route events.
+func (a *Agent) routeEvent(r *routing.Route) health.RouteEvent {
+	ev := health.RouteEvent{
+		Network:  r.Network.String(),
+		Origin:   r.OriginAgent.ShortString(),
+		Metric:   r.Metric,
+		HopCount: len(r.Path),
+	}
+	if !r.NextHop.IsZero() && r.NextHop != a.id {
+		ev.NextHop = r.NextHop.ShortString()
+	}
+	return ev
+}
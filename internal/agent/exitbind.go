@@ -0,0 +1,29 @@
+package agent
+
+import (
+	"net"
+
+	"github.com/postalsys/muti-metroo/internal/exit"
+	"github.com/postalsys/muti-metroo/internal/routing"
+)
+
+// exitBindConfig converts the exit source bind settings for the exit, UDP and
+// ICMP handlers. Addresses and routes are validated with the config.
+func (a *Agent) exitBindConfig() exit.BindConfig {
+	cfg := exit.BindConfig{
+		Default: exit.Bind{
+			Address:   net.ParseIP(a.cfg.Exit.BindAddress),
+			Interface: a.cfg.Exit.BindInterface,
+		},
+	}
+	for _, rb := range a.cfg.Exit.RouteBinds {
+		cfg.Routes = append(cfg.Routes, exit.RouteBind{
+			Network: routing.MustParseCIDR(rb.Route),
+			Bind: exit.Bind{
+				Address:   net.ParseIP(rb.BindAddress),
+				Interface: rb.BindInterface,
+			},
+		})
+	}
+	return cfg
+}
@@ -0,0 +1,116 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/postalsys/muti-metroo/internal/exit"
+	"github.com/postalsys/muti-metroo/internal/health"
+)
+
+// defaultExitDestLimit is the number of destinations returned by "top"
+// when no limit is given.
+const defaultExitDestLimit = 20
+
+// exitDestStatsConfig converts the exit.destination_stats configuration for
+// the exit handler.
+func (a *Agent) exitDestStatsConfig() exit.DestStatsConfig {
+	c := a.cfg.Exit.DestinationStats
+	return exit.DestStatsConfig{
+		Enabled:         c.Enabled,
+		Window:          c.Window,
+		MaxDestinations: c.MaxDestinations,
+		ConnThreshold:   c.Thresholds.Connections,
+		BytesThreshold:  c.Thresholds.Bytes,
+		Action:          c.Action,
+		BlockDuration:   c.BlockDuration,
+	}
+}
+
+// ManageExitDestinations reports the busiest exit destinations or lifts a
+// threshold block. Implements health.ExitDestManageProvider.
+func (a *Agent) ManageExitDestinations(action, sortBy, destination string, limit int) (*health.ExitDestManageResult, error) {
+	h := a.exitHandler
+	if h == nil {
+		return nil, fmt.Errorf("destination stats not available: exit is not enabled on this agent")
+	}
+	if !h.DestStatsEnabled() {
+		return nil, fmt.Errorf("destination stats not enabled (exit.destination_stats.enabled)")
+	}
+
+	switch action {
+	case "top":
+		if sortBy == "" {
+			sortBy = exit.DestSortBytes
+		}
+		if sortBy != exit.DestSortBytes && sortBy != exit.DestSortConnections {
+			return nil, fmt.Errorf("unknown sort %q (expected bytes or connections)", sortBy)
+		}
+		if limit <= 0 {
+			limit = defaultExitDestLimit
+		}
+
+		stats := h.DestinationStats(limit, sortBy)
+		dests := make([]health.ExitDestStat, 0, len(stats))
+		for _, s := range stats {
+			d := health.ExitDestStat{
+				Destination: s.Destination,
+				Connections: s.Connections,
+				BytesOut:    s.BytesOut,
+				BytesIn:     s.BytesIn,
+				Active:      s.Active,
+				Blocked:     s.Blocked,
+				LastSeen:    s.LastSeen,
+			}
+			if s.Blocked {
+				until := s.BlockedUntil
+				d.BlockedUntil = &until
+			}
+			dests = append(dests, d)
+		}
+		return &health.ExitDestManageResult{
+			Status:       "ok",
+			Window:       h.DestStatsWindow().String(),
+			Destinations: dests,
+		}, nil
+
+	case "unblock":
+		if destination == "" {
+			return nil, fmt.Errorf("destination is required")
+		}
+		if !h.UnblockDestination(destination) {
+			return nil, fmt.Errorf("destination %q is not blocked", destination)
+		}
+		a.logger.Info("exit destination unblocked", "destination", destination)
+		return &health.ExitDestManageResult{
+			Status:  "ok",
+			Message: fmt.Sprintf("unblocked %s", destination),
+		}, nil
+
+	default:
+		return nil, fmt.Errorf("unknown action %q (expected top or unblock)", action)
+	}
+}
+
+// handleExitDestManage processes a ControlTypeExitDestManage control request.
+func (a *Agent) handleExitDestManage(data []byte) ([]byte, bool) {
+	var req struct {
+		Action      string `json:"action"`
+		Sort        string `json:"sort"`
+		Destination string `json:"destination"`
+		Limit       int    `json:"limit"`
+	}
+	if err := json.Unmarshal(data, &req); err != nil {
+		resp, _ := json.Marshal(map[string]string{"error": "invalid request: " + err.Error()})
+		return resp, false
+	}
+
+	result, err := a.ManageExitDestinations(req.Action, req.Sort, req.Destination, req.Limit)
+	if err != nil {
+		resp, _ := json.Marshal(map[string]string{"error": err.Error()})
+		return resp, false
+	}
+
+	resp, _ := json.Marshal(result)
+	return resp, true
+}
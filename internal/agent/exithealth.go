@@ -0,0 +1,91 @@
+package agent
+
+import (
+	"time"
+
+	"github.com/postalsys/muti-metroo/internal/exit"
+	"github.com/postalsys/muti-metroo/internal/health"
+	"github.com/postalsys/muti-metroo/internal/logging"
+)
+
+// startExitHealthCheck starts the exit self-health check configured in
+// exit.health_check.
+func (a *Agent) startExitHealthCheck() {
+	cfg := a.cfg.Exit.HealthCheck
+	a.exitHealth = exit.NewHealthChecker(exit.HealthCheckConfig{
+		Targets:          cfg.Targets,
+		Interval:         cfg.Interval,
+		Timeout:          cfg.Timeout,
+		FailureThreshold: cfg.FailureThreshold,
+		SuccessThreshold: cfg.SuccessThreshold,
+		Bind:             a.exitBindConfig(),
+		Resolver:         exit.NewResolver(a.exitDNSConfig()),
+		OnChange:         a.exitHealthChanged,
+		Logger:           a.logger,
+	})
+	a.exitHealth.Start()
+	a.logger.Info("exit health check started",
+		"targets", cfg.Targets,
+		"interval", cfg.Interval)
+}
+
+// exitHealthChanged withdraws the local CIDR and domain routes when the exit
+// health check starts failing and announces them again when it recovers, so
+// ingresses fail over to healthy exits instead of timing out here.
+func (a *Agent) exitHealthChanged(healthy bool) {
+	if !a.running.Load() {
+		return
+	}
+	if healthy {
+		if a.routeMgr.ResumeLocalRoutes() {
+			a.logger.Info("exit healthy again, announcing routes")
+			a.TriggerRouteAdvertise()
+		}
+		return
+	}
+	if routes, ok := a.routeMgr.SuspendLocalRoutes(); ok {
+		a.logger.Warn("exit unhealthy, withdrawing routes",
+			logging.KeyCount, len(routes))
+		a.flooder.WithdrawRoutes(routes)
+	}
+}
+
+// ExitHealth returns the state of the exit self-health check.
+// Implements health.ExitHealthProvider.
+func (a *Agent) ExitHealth() health.ExitHealthResponse {
+	c := a.exitHealth
+	if c == nil {
+		return health.ExitHealthResponse{}
+	}
+	cfg := a.cfg.Exit.HealthCheck
+	st := c.Status()
+	resp := health.ExitHealthResponse{
+		Enabled:              true,
+		Healthy:              st.Healthy,
+		RoutesWithdrawn:      a.routeMgr.LocalRoutesSuspended(),
+		Since:                st.Since.UTC().Format(time.RFC3339),
+		ConsecutiveFailures:  st.ConsecutiveFailures,
+		ConsecutiveSuccesses: st.ConsecutiveSuccesses,
+		Checks:               st.Checks,
+		Failed:               st.Failed,
+		Withdrawals:          st.Withdrawals,
+		Thresholds: health.ExitHealthThresholds{
+			Interval:         cfg.Interval.String(),
+			Timeout:          cfg.Timeout.String(),
+			FailureThreshold: cfg.FailureThreshold,
+			SuccessThreshold: cfg.SuccessThreshold,
+		},
+	}
+	if !st.LastCheck.IsZero() {
+		resp.LastCheck = st.LastCheck.UTC().Format(time.RFC3339)
+	}
+	for _, t := range st.Targets {
+		resp.Targets = append(resp.Targets, health.ExitHealthTarget{
+			Target:    t.Target,
+			OK:        t.OK,
+			LatencyMs: float64(t.Latency.Microseconds()) / 1000,
+			Error:     t.Error,
+		})
+	}
+	return resp
+}
@@ -0,0 +1,108 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/postalsys/muti-metroo/internal/config"
+	"github.com/postalsys/muti-metroo/internal/identity"
+	"github.com/postalsys/muti-metroo/internal/routing"
+	"github.com/postalsys/muti-metroo/internal/socks5"
+)
+
+// exitPath is the best known path to an exit agent.
+type exitPath struct {
+	origin  identity.AgentID
+	nextHop identity.AgentID
+	path    []identity.AgentID
+	metric  uint16
+}
+
+// dialViaSelectedExit dials host:port through the exit named by hint (an agent
+// ID prefix or display name) in namespace ns, bypassing CIDR and domain route
+// lookup. Domain names are resolved by the selected exit, which still applies
+// its own allowed routes, unless remote_dns is "local".
+func (a *Agent) dialViaSelectedExit(ctx context.Context, network, host string, port int, hint, ns string) (net.Conn, error) {
+	exit, err := a.lookupExitPath(hint, ns)
+	if err != nil {
+		return nil, err
+	}
+
+	if exit.origin == a.id {
+		dialer := &net.Dialer{Timeout: a.cfg.SOCKS5.ConnectTimeout}
+		return dialer.DialContext(ctx, network, net.JoinHostPort(host, strconv.Itoa(port)))
+	}
+
+	if a.peerMgr.GetPeer(exit.nextHop) == nil {
+		return nil, fmt.Errorf("%w: next hop %s to exit %q not connected", socks5.ErrExitUnavailable, exit.nextHop.ShortString(), hint)
+	}
+
+	destIP := net.ParseIP(host)
+	if destIP == nil && a.remoteDNSPolicy() == config.RemoteDNSLocal {
+		ips, err := a.resolver.LookupIP(ctx, "ip", host)
+		if err != nil {
+			return nil, fmt.Errorf("resolve %s: %w", host, err)
+		}
+		destIP = ips[0]
+	}
+	if destIP != nil {
+		return a.dialIPViaPath(ctx, host, destIP, port, exit.nextHop, exit.path)
+	}
+	return a.dialViaDomainRouteWithContext(ctx, network, host, port, &routing.DomainRoute{
+		NextHop:     exit.nextHop,
+		OriginAgent: exit.origin,
+		Path:        exit.path,
+	})
+}
+
+// lookupExitPath finds the lowest-metric path to the exit matching hint among
+// all CIDR and domain routes of namespace ns. The hint matches an agent ID
+// prefix or a display name (case-insensitive). Hints matching more than one
+// agent are rejected.
+func (a *Agent) lookupExitPath(hint, ns string) (*exitPath, error) {
+	var best *exitPath
+	consider := func(origin, nextHop identity.AgentID, path []identity.AgentID, metric uint16) error {
+		if !a.matchesExitHint(origin, hint) || a.routeMgr.OriginNamespace(origin) != ns {
+			return nil
+		}
+		if best != nil && best.origin != origin {
+			return fmt.Errorf("%w: exit %q matches more than one agent", socks5.ErrExitUnavailable, hint)
+		}
+		if best == nil || metric < best.metric {
+			best = &exitPath{origin: origin, nextHop: nextHop, path: path, metric: metric}
+		}
+		return nil
+	}
+
+	for _, r := range a.routeMgr.Table().GetAllRoutes() {
+		if err := consider(r.OriginAgent, r.NextHop, r.Path, r.Metric); err != nil {
+			return nil, err
+		}
+	}
+	for _, r := range a.routeMgr.DomainTable().GetAllRoutes() {
+		if err := consider(r.OriginAgent, r.NextHop, r.Path, r.Metric); err != nil {
+			return nil, err
+		}
+	}
+
+	if best == nil {
+		return nil, fmt.Errorf("%w: no exit routes from %q", socks5.ErrExitUnavailable, hint)
+	}
+	return best, nil
+}
+
+// matchesExitHint reports whether hint names the agent by ID prefix or
+// display name.
+func (a *Agent) matchesExitHint(id identity.AgentID, hint string) bool {
+	if strings.HasPrefix(id.String(), strings.ToLower(hint)) {
+		return true
+	}
+	name := a.routeMgr.GetDisplayName(id)
+	if id == a.id {
+		name = a.displayNameForAdvertise()
+	}
+	return name != "" && strings.EqualFold(name, hint)
+}
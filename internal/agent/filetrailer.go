@@ -0,0 +1,147 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/postalsys/muti-metroo/internal/crypto"
+	"github.com/postalsys/muti-metroo/internal/filetransfer"
+	"github.com/postalsys/muti-metroo/internal/identity"
+	"github.com/postalsys/muti-metroo/internal/protocol"
+	"github.com/postalsys/muti-metroo/internal/stream"
+)
+
+// errNoTrailer is returned when a transfer that announced a checksum trailer
+// ends without one, which means the data was cut short.
+var errNoTrailer = errors.New("transfer ended without checksum")
+
+// remoteTransferError is an error reported by the other end of a file
+// transfer.
+type remoteTransferError struct {
+	msg  string
+	code uint16
+}
+
+func (e *remoteTransferError) Error() string {
+	return "remote error: " + e.msg
+}
+
+// Is reports checksum mismatches detected by the remote agent as
+// filetransfer.ErrChecksumMismatch.
+func (e *remoteTransferError) Is(target error) bool {
+	return target == filetransfer.ErrChecksumMismatch && e.code == protocol.ErrChecksumMismatch
+}
+
+// transferError returns the error carried by metadata from the other end of
+// a transfer, or nil.
+func transferError(meta *filetransfer.TransferMetadata) error {
+	if meta.Error == "" {
+		return nil
+	}
+	return &remoteTransferError{msg: meta.Error, code: meta.ErrorCode}
+}
+
+// writeFileTrailer sends the final metadata frame of a transfer, with
+// FIN_WRITE.
+func (a *Agent) writeFileTrailer(peerID identity.AgentID, streamID uint64, sessionKey *crypto.SessionKey, trailer *filetransfer.TransferMetadata) error {
+	data, err := filetransfer.EncodeMetadata(trailer)
+	if err != nil {
+		return fmt.Errorf("encode trailer: %w", err)
+	}
+	encrypted, err := sessionKey.Encrypt(data)
+	if err != nil {
+		return fmt.Errorf("encrypt trailer: %w", err)
+	}
+	if err := a.WriteStreamData(peerID, streamID, encrypted, protocol.FlagFinWrite); err != nil {
+		return fmt.Errorf("write trailer: %w", err)
+	}
+	return nil
+}
+
+// transferFrames reads the decrypted data frames of a file transfer stream.
+// When the sender announced a trailer, each frame is held back until the
+// next one arrives, so the last frame is parsed as the trailer instead of
+// being returned as data.
+type transferFrames struct {
+	stream     *stream.Stream
+	sessionKey *crypto.SessionKey
+	trailer    bool
+
+	held    []byte
+	holding bool
+	end     error // Result of finish, once the stream ended
+
+	// Trailer is the final metadata frame. It is set once next has returned
+	// io.EOF.
+	Trailer *filetransfer.TransferMetadata
+}
+
+// next returns the next data frame, or io.EOF after the last one. A trailer
+// that reports a sender error is returned as that error.
+func (t *transferFrames) next(timeout time.Duration) ([]byte, error) {
+	for {
+		data, err := t.stream.ReadWithTimeout(timeout)
+		if err == io.EOF {
+			return nil, t.finish()
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(data) == 0 {
+			continue
+		}
+
+		plaintext, err := t.sessionKey.Decrypt(data)
+		if err != nil {
+			return nil, fmt.Errorf("decrypt data: %w", err)
+		}
+		if !t.trailer {
+			return plaintext, nil
+		}
+
+		prev, had := t.held, t.holding
+		t.held, t.holding = plaintext, true
+		if had {
+			return prev, nil
+		}
+	}
+}
+
+// finish parses the held frame as the trailer at the end of the stream.
+// Later calls return the same result.
+func (t *transferFrames) finish() error {
+	if t.end == nil {
+		t.end = t.parseTrailer()
+	}
+	return t.end
+}
+
+func (t *transferFrames) parseTrailer() error {
+	if !t.trailer {
+		return io.EOF
+	}
+	if !t.holding {
+		return errNoTrailer
+	}
+	t.holding = false
+
+	meta, err := filetransfer.ParseMetadata(t.held)
+	if err != nil {
+		return errNoTrailer
+	}
+	t.Trailer = meta
+	if err := transferError(meta); err != nil {
+		return err
+	}
+	return io.EOF
+}
+
+// checksum returns the checksum from the trailer, or "" if none arrived.
+func (t *transferFrames) checksum() string {
+	if t.Trailer == nil {
+		return ""
+	}
+	return t.Trailer.Checksum
+}
@@ -0,0 +1,36 @@
+package agent
+
+import (
+	"github.com/postalsys/muti-metroo/internal/exit"
+	"github.com/postalsys/muti-metroo/internal/health"
+)
+
+// exitHalfCloseConfig converts the exit.half_close configuration for the
+// exit handler.
+func (a *Agent) exitHalfCloseConfig() exit.HalfCloseConfig {
+	return exit.HalfCloseConfig{
+		ClientLinger:      a.cfg.Exit.HalfClose.ClientLinger,
+		DestinationLinger: a.cfg.Exit.HalfClose.DestinationLinger,
+	}
+}
+
+// HalfCloseStats returns the exit half-close settings and counters.
+// Implements health.HalfCloseStatsProvider.
+func (a *Agent) HalfCloseStats() health.HalfCloseStatsResponse {
+	h := a.exitHandler
+	if h == nil {
+		return health.HalfCloseStatsResponse{}
+	}
+	st := h.HalfCloseStats()
+	return health.HalfCloseStatsResponse{
+		Enabled:                  h.IsRunning(),
+		ClientLinger:             st.ClientLinger.String(),
+		DestinationLinger:        st.DestinationLinger.String(),
+		ClientHalfOpen:           st.ClientHalfOpen,
+		DestinationHalfOpen:      st.DestinationHalfOpen,
+		ClientFinished:           st.ClientFinished,
+		DestinationFinished:      st.DestinationFinished,
+		ClientLingerExpired:      st.ClientLingerExpired,
+		DestinationLingerExpired: st.DestinationLingerExpired,
+	}
+}
@@ -0,0 +1,44 @@
+package agent
+
+import (
+	"github.com/postalsys/muti-metroo/internal/identity"
+	"github.com/postalsys/muti-metroo/internal/protocol"
+)
+
+// hopLimitMessage is the STREAM_OPEN_ERR message for an exhausted hop limit.
+const hopLimitMessage = "hop limit exceeded"
+
+// hopLimit returns the TTL set on stream, UDP and ICMP opens originated
+// here: the number of links the open may traverse (routing.max_hops).
+func (a *Agent) hopLimit() uint8 {
+	return uint8(a.cfg.Routing.MaxHops)
+}
+
+// nextHopTTL returns the TTL to forward on an open received with ttl, or
+// false if the hop limit is exhausted and the open must be rejected with
+// ErrTTLExceeded. A TTL of 0 comes from agents that predate hop limits and
+// is forwarded unchanged.
+func nextHopTTL(ttl uint8) (uint8, bool) {
+	switch ttl {
+	case 0:
+		return 0, true
+	case 1:
+		return 0, false
+	default:
+		return ttl - 1, true
+	}
+}
+
+// sendStreamOpenErr sends a STREAM_OPEN_ERR to a peer.
+func (a *Agent) sendStreamOpenErr(peerID identity.AgentID, streamID, requestID uint64, errCode uint16, msg string) {
+	errPayload := &protocol.StreamOpenErr{
+		RequestID: requestID,
+		ErrorCode: errCode,
+		Message:   msg,
+	}
+	a.peerMgr.SendToPeer(peerID, &protocol.Frame{
+		Type:     protocol.FrameStreamOpenErr,
+		StreamID: streamID,
+		Payload:  errPayload.Encode(),
+	})
+}
@@ -0,0 +1,142 @@
+package agent
+
+import (
+	"net"
+	"strings"
+
+	"github.com/postalsys/muti-metroo/internal/discovery"
+	"github.com/postalsys/muti-metroo/internal/hostsfile"
+	"github.com/postalsys/muti-metroo/internal/logging"
+)
+
+// hostnameState tracks the forward listener hostnames registered while
+// their listeners run.
+type hostnameState struct {
+	responder    *discovery.Responder // Shared with discovery or owned
+	ownResponder bool
+	hostsFile    *hostsfile.File
+	names        []string
+}
+
+// registerListenerHostnames registers the hostname of every config forward
+// listener that has one. Must be called after the forward listeners and
+// discovery are started. Failures are logged: a missing name does not stop
+// the listener from working by address.
+func (a *Agent) registerListenerHostnames() {
+	cfg := a.cfg.Forward.Hostnames
+	hs := &hostnameState{}
+	if cfg.HostsFile != "" {
+		hs.hostsFile = hostsfile.New(cfg.HostsFile)
+	}
+
+	for _, lc := range a.cfg.Forward.Listeners {
+		if lc.Hostname == "" {
+			continue
+		}
+		a.forwardListenersMu.RLock()
+		listener := a.forwardListeners[lc.Key]
+		a.forwardListenersMu.RUnlock()
+		if listener == nil || listener.Address() == nil {
+			continue
+		}
+		var bindIP net.IP
+		if tcpAddr, ok := listener.Address().(*net.TCPAddr); ok && !tcpAddr.IP.IsUnspecified() {
+			bindIP = tcpAddr.IP
+		}
+
+		registered := false
+		if cfg.MDNS && strings.HasSuffix(strings.ToLower(lc.Hostname), ".local") {
+			if err := a.registerMDNSHostname(hs, lc.Hostname, bindIP); err != nil {
+				a.logger.Warn("failed to register listener hostname over mDNS",
+					"key", lc.Key,
+					"hostname", lc.Hostname,
+					logging.KeyError, err)
+			} else {
+				registered = true
+			}
+		}
+		if hs.hostsFile != nil {
+			ip := bindIP
+			if ip == nil {
+				ip = net.IPv4(127, 0, 0, 1)
+			}
+			if err := hs.hostsFile.Set(lc.Hostname, ip); err != nil {
+				a.logger.Warn("failed to write listener hostname to hosts file",
+					"key", lc.Key,
+					"hostname", lc.Hostname,
+					"hosts_file", cfg.HostsFile,
+					logging.KeyError, err)
+			} else {
+				registered = true
+			}
+		}
+		if registered {
+			hs.names = append(hs.names, lc.Hostname)
+			a.logger.Info("forward listener hostname registered",
+				"key", lc.Key,
+				"hostname", lc.Hostname)
+		}
+	}
+
+	a.hostnames = hs
+}
+
+// registerMDNSHostname registers name on the discovery mDNS responder,
+// starting one for hostnames only when discovery does not announce.
+func (a *Agent) registerMDNSHostname(hs *hostnameState, name string, ip net.IP) error {
+	if hs.responder == nil {
+		if a.mdnsResponder != nil {
+			hs.responder = a.mdnsResponder
+		} else {
+			var iface *net.Interface
+			if ifname := a.cfg.Discovery.MDNS.Interface; ifname != "" {
+				var err error
+				if iface, err = net.InterfaceByName(ifname); err != nil {
+					return err
+				}
+			}
+			responder, err := discovery.NewResponder(discovery.ResponderConfig{
+				Interface: iface,
+				Logger:    a.logger,
+				Paused:    a.peerMgr.IsPaused,
+			})
+			if err != nil {
+				return err
+			}
+			hs.responder = responder
+			hs.ownResponder = true
+		}
+	}
+
+	var ips []net.IP
+	if ip != nil {
+		ips = []net.IP{ip}
+	}
+	return hs.responder.RegisterHost(name, ips)
+}
+
+// unregisterListenerHostnames removes the registered hostnames. Called on
+// shutdown before the forward listeners stop.
+func (a *Agent) unregisterListenerHostnames() {
+	hs := a.hostnames
+	if hs == nil {
+		return
+	}
+	a.hostnames = nil
+
+	if hs.responder != nil {
+		for _, name := range hs.names {
+			hs.responder.UnregisterHost(name)
+		}
+		if hs.ownResponder {
+			hs.responder.Close()
+		}
+	}
+	if hs.hostsFile != nil {
+		if err := hs.hostsFile.Close(); err != nil {
+			a.logger.Warn("failed to clean up hosts file",
+				"hosts_file", hs.hostsFile.Path(),
+				logging.KeyError, err)
+		}
+	}
+}
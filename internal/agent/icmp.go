@@ -65,13 +65,14 @@ func generateICMPRequestID() uint64 {
 }
 
 // deriveICMPSessionKey performs ECDH key exchange and derives a session key for ICMP sessions.
-// The ephPrivKey is zeroed after use.
+// Rekeying is enabled when ackFlags accept it. The ephPrivKey is zeroed after use.
 // Returns nil if the remote key is zero (encryption disabled), or an error if ECDH fails.
-func deriveICMPSessionKey(
+func (a *Agent) deriveICMPSessionKey(
 	ephPrivKey *[32]byte,
 	ephPubKey [32]byte,
 	remotePubKey [32]byte,
 	requestID uint64,
+	ackFlags uint8,
 ) (*crypto.SessionKey, error) {
 	var zeroKey [protocol.EphemeralKeySize]byte
 	if remotePubKey == zeroKey {
@@ -83,12 +84,12 @@ func deriveICMPSessionKey(
 		return nil, err
 	}
 
-	// Zero out private key immediately
-	crypto.ZeroKey(ephPrivKey)
-
 	// Derive session key (caller is initiator)
 	sessionKey := crypto.DeriveSessionKey(sharedSecret, requestID, ephPubKey, remotePubKey, true)
+	sessionKey.EnableReplayWindow() // Echoes may be lost or reordered
 	crypto.ZeroKey(&sharedSecret)
+	a.enableRekey(sessionKey, ackFlags, *ephPrivKey, remotePubKey)
+	crypto.ZeroKey(ephPrivKey)
 
 	return sessionKey, nil
 }
@@ -113,12 +114,23 @@ func (a *Agent) handleICMPOpen(peerID identity.AgentID, frame *protocol.Frame) {
 			a.sendICMPOpenErr(peerID, frame.StreamID, open.RequestID, protocol.ErrICMPDisabled, "ICMP echo disabled")
 			return
 		}
+		if a.cfg.Agent.Namespace != "" {
+			a.sendICMPOpenErr(peerID, frame.StreamID, open.RequestID, protocol.ErrNotAllowed, errNamespaceDatagrams.Error())
+			return
+		}
 
 		ctx := context.Background()
 		a.icmpHandler.HandleICMPOpen(ctx, peerID, frame.StreamID, open, open.EphemeralPubKey)
 		return
 	}
 
+	// Enforce the hop limit before relaying
+	ttl, ok := nextHopTTL(open.TTL)
+	if !ok {
+		a.sendICMPOpenErr(peerID, frame.StreamID, open.RequestID, protocol.ErrTTLExceeded, hopLimitMessage)
+		return
+	}
+
 	// Relay to next hop
 	nextHop := open.RemainingPath[0]
 
@@ -147,9 +159,10 @@ func (a *Agent) handleICMPOpen(peerID identity.AgentID, frame *protocol.Frame) {
 	fwdOpen := &protocol.ICMPOpen{
 		RequestID:       open.RequestID,
 		DestIP:          open.DestIP,
-		TTL:             open.TTL,
+		TTL:             ttl,
 		RemainingPath:   newPath,
 		EphemeralPubKey: open.EphemeralPubKey,
+		E2EFlags:        open.E2EFlags,
 	}
 
 	fwdFrame := &protocol.Frame{
@@ -210,7 +223,7 @@ func (a *Agent) handleICMPOpenAck(peerID identity.AgentID, frame *protocol.Frame
 			return
 		}
 
-		sessionKey, err := deriveICMPSessionKey(&ingress.EphemeralPrivKey, ingress.EphemeralPubKey, ack.EphemeralPubKey, ack.RequestID)
+		sessionKey, err := a.deriveICMPSessionKey(&ingress.EphemeralPrivKey, ingress.EphemeralPubKey, ack.EphemeralPubKey, ack.RequestID, ack.E2EFlags)
 		if err != nil {
 			ingress.closePendingOpen(err)
 			return
@@ -241,7 +254,7 @@ func (a *Agent) handleICMPOpenAck(peerID identity.AgentID, frame *protocol.Frame
 		return
 	}
 
-	sessionKey, err := deriveICMPSessionKey(&wsSession.EphemeralPrivKey, wsSession.EphemeralPubKey, ack.EphemeralPubKey, ack.RequestID)
+	sessionKey, err := a.deriveICMPSessionKey(&wsSession.EphemeralPrivKey, wsSession.EphemeralPubKey, ack.EphemeralPubKey, ack.RequestID, ack.E2EFlags)
 	if err != nil {
 		wsSession.closePendingOpenWS(err)
 		return
@@ -351,6 +364,10 @@ func (a *Agent) handleICMPEcho(peerID identity.AgentID, frame *protocol.Frame) {
 		if sessionKey != nil {
 			plaintext, err = sessionKey.Decrypt(echo.Data)
 			if err != nil {
+				a.logger.Warn("ICMP reply failed E2E validation, session closed",
+					logging.KeyStreamID, frame.StreamID,
+					logging.KeyError, err)
+				a.closeICMPIngress(frame.StreamID, protocol.ICMPCloseE2EValidation)
 				return
 			}
 		} else {
@@ -396,6 +413,10 @@ func (a *Agent) handleICMPEcho(peerID identity.AgentID, frame *protocol.Frame) {
 			plaintext, err = sessionKey.Decrypt(echo.Data)
 			if err != nil {
 				errStr = err.Error()
+				a.logger.Warn("ICMP reply failed E2E validation, session closed",
+					logging.KeyStreamID, frame.StreamID,
+					logging.KeyError, err)
+				defer a.closeWSICMPSession(frame.StreamID, protocol.ICMPCloseE2EValidation)
 			}
 		} else {
 			plaintext = echo.Data
@@ -562,8 +583,12 @@ func (a *Agent) WriteICMPClose(peerID identity.AgentID, streamID uint64, reason
 // CreateICMPSession implements socks5.ICMPHandler.
 // Called when a SOCKS5 client requests ICMP ECHO command.
 func (a *Agent) CreateICMPSession(ctx context.Context, destIP net.IP) (uint64, error) {
+	if a.streamNamespace(ctx) != "" {
+		return 0, errNamespaceDatagrams
+	}
+
 	// Route lookup based on destination IP
-	route := a.routeMgr.Lookup(destIP)
+	route := a.routeMgr.LookupIn(destIP, "")
 	if route == nil {
 		return 0, ErrICMPNoRoute
 	}
@@ -612,10 +637,11 @@ func (a *Agent) CreateICMPSession(ctx context.Context, destIP net.IP) (uint64, e
 	// Build and send ICMP_OPEN
 	open := &protocol.ICMPOpen{
 		RequestID:       requestID,
-		DestIP:          destIP.To4(),
-		TTL:             uint8(len(rPath)),
+		DestIP:          icmpDestBytes(destIP),
+		TTL:             a.hopLimit(),
 		RemainingPath:   remainingPath,
 		EphemeralPubKey: ephPub,
+		E2EFlags:        a.e2eOpenFlags(),
 	}
 
 	frame := &protocol.Frame{
@@ -711,6 +737,12 @@ func (a *Agent) RelayICMPEcho(streamID uint64, identifier, sequence uint16, payl
 
 // CloseICMPSession implements socks5.ICMPHandler.
 func (a *Agent) CloseICMPSession(streamID uint64) {
+	a.closeICMPIngress(streamID, protocol.ICMPCloseNormal)
+}
+
+// closeICMPIngress closes a SOCKS5 ICMP session, sending ICMP_CLOSE with the
+// given reason to the exit.
+func (a *Agent) closeICMPIngress(streamID uint64, reason uint8) {
 	a.icmpIngressMu.Lock()
 	assoc := a.icmpIngressByStream[streamID]
 	if assoc != nil {
@@ -723,7 +755,7 @@ func (a *Agent) CloseICMPSession(streamID uint64) {
 	}
 
 	// Send ICMP_CLOSE to the mesh
-	closeFrame := &protocol.ICMPClose{Reason: protocol.ICMPCloseNormal}
+	closeFrame := &protocol.ICMPClose{Reason: reason}
 	frame := &protocol.Frame{
 		Type:     protocol.FrameICMPClose,
 		StreamID: streamID,
@@ -743,6 +775,35 @@ func (a *Agent) IsICMPEnabled() bool {
 	return a.cfg.SOCKS5.Enabled
 }
 
+// ICMPStats returns the counters of the exit-side ICMP handler.
+// Implements health.ICMPStatsProvider.
+func (a *Agent) ICMPStats() health.ICMPStatsResponse {
+	if a.icmpHandler == nil {
+		return health.ICMPStatsResponse{}
+	}
+	st := a.icmpHandler.Stats()
+	return health.ICMPStatsResponse{
+		Enabled:           true,
+		ActiveSessions:    st.ActiveSessions,
+		SessionsOpened:    st.SessionsOpened,
+		SessionsRejected:  st.SessionsRejected,
+		EchoRequests:      st.EchoRequests,
+		EchoReplies:       st.EchoReplies,
+		DestRateLimited:   st.DestRateLimited,
+		GlobalRateLimited: st.GlobalRateLimited,
+	}
+}
+
+// icmpDestBytes encodes an ICMP_OPEN destination: 4 bytes for IPv4 (including
+// IPv4-mapped IPv6 addresses) and 16 bytes for IPv6. The exit node picks the
+// ICMP version from the length.
+func icmpDestBytes(ip net.IP) []byte {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4
+	}
+	return ip.To16()
+}
+
 // icmpWebSocketSession tracks an ICMP session from WebSocket API.
 type icmpWebSocketSession struct {
 	StreamID         uint64
@@ -865,10 +926,11 @@ func (a *Agent) OpenICMPSession(ctx context.Context, targetID identity.AgentID,
 	// Build and send ICMP_OPEN
 	open := &protocol.ICMPOpen{
 		RequestID:       requestID,
-		DestIP:          destIP.To4(),
-		TTL:             uint8(len(rPath)),
+		DestIP:          icmpDestBytes(destIP),
+		TTL:             a.hopLimit(),
 		RemainingPath:   remainingPath,
 		EphemeralPubKey: ephPub,
+		E2EFlags:        a.e2eOpenFlags(),
 	}
 
 	frame := &protocol.Frame{
@@ -921,7 +983,7 @@ func (a *Agent) OpenICMPSession(ctx context.Context, targetID identity.AgentID,
 		ReceiveEcho: session.ReceiveEcho,
 		Done:        session.Done,
 		Close: func() {
-			a.closeWSICMPSession(streamID)
+			a.closeWSICMPSession(streamID, protocol.ICMPCloseNormal)
 		},
 	}, nil
 }
@@ -976,8 +1038,9 @@ func (a *Agent) runWSICMPSender(session *icmpWebSocketSession) {
 	}
 }
 
-// closeWSICMPSession closes a WebSocket ICMP session.
-func (a *Agent) closeWSICMPSession(streamID uint64) {
+// closeWSICMPSession closes a WebSocket ICMP session, sending ICMP_CLOSE
+// with the given reason to the exit.
+func (a *Agent) closeWSICMPSession(streamID uint64, reason uint8) {
 	a.icmpWSSessionMu.Lock()
 	session := a.icmpWSSessionByStream[streamID]
 	if session != nil {
@@ -992,7 +1055,7 @@ func (a *Agent) closeWSICMPSession(streamID uint64) {
 	session.close()
 
 	// Send ICMP_CLOSE to the mesh
-	closeFrame := &protocol.ICMPClose{Reason: protocol.ICMPCloseNormal}
+	closeFrame := &protocol.ICMPClose{Reason: reason}
 	frame := &protocol.Frame{
 		Type:     protocol.FrameICMPClose,
 		StreamID: streamID,
@@ -0,0 +1,148 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/postalsys/muti-metroo/internal/health"
+)
+
+// defaultMinIdle is the idle time used when an idle request gives none.
+const defaultMinIdle = 10 * time.Minute
+
+// ManageIdle lists or force-closes streams and exit UDP associations that
+// have been idle for at least minIdle. Closing sends STREAM_RESET or
+// UDP_CLOSE to the adjacent peers. Implements health.IdleManageProvider.
+func (a *Agent) ManageIdle(action, minIdle string) (*health.IdleManageResult, error) {
+	threshold := defaultMinIdle
+	if minIdle != "" {
+		d, err := time.ParseDuration(minIdle)
+		if err != nil {
+			return nil, fmt.Errorf("invalid min_idle: %w", err)
+		}
+		if d <= 0 {
+			return nil, fmt.Errorf("min_idle must be positive")
+		}
+		threshold = d
+	}
+
+	entries := a.idleEntries(threshold)
+
+	switch action {
+	case "list":
+		return &health.IdleManageResult{
+			Status:  "ok",
+			MinIdle: threshold.String(),
+			Entries: entries,
+		}, nil
+
+	case "close":
+		closed := make([]health.IdleEntry, 0, len(entries))
+		for _, e := range entries {
+			if a.closeIdle(e) {
+				closed = append(closed, e)
+			}
+		}
+		a.logger.Info("idle streams closed via API",
+			"min_idle", threshold.String(),
+			"closed", len(closed))
+		return &health.IdleManageResult{
+			Status:  "ok",
+			Message: fmt.Sprintf("closed %d idle stream(s) and association(s)", len(closed)),
+			MinIdle: threshold.String(),
+			Entries: closed,
+		}, nil
+
+	default:
+		return nil, fmt.Errorf("unknown action %q (expected list or close)", action)
+	}
+}
+
+// idleEntries returns the streams and exit UDP associations idle for at
+// least minIdle, ordered like ListStreams with UDP associations last.
+func (a *Agent) idleEntries(minIdle time.Duration) []health.IdleEntry {
+	entries := []health.IdleEntry{}
+
+	for _, st := range a.ListStreams() {
+		if st.IdleMs < minIdle.Milliseconds() {
+			continue
+		}
+		peer := st.UpstreamPeer
+		if st.Direction == health.StreamDirectionOutbound {
+			peer = st.DownstreamPeer
+		}
+		entries = append(entries, health.IdleEntry{
+			Kind:        health.IdleKindStream,
+			ID:          st.ID,
+			Direction:   st.Direction,
+			Peer:        peer,
+			Destination: st.Destination,
+			BytesSent:   st.BytesSent,
+			BytesRecv:   st.BytesRecv,
+			AgeMs:       st.AgeMs,
+			IdleMs:      st.IdleMs,
+		})
+	}
+
+	if a.udpHandler != nil {
+		now := time.Now()
+		for _, s := range a.udpHandler.Stats() {
+			idle := now.Sub(s.LastActivity)
+			if idle < minIdle {
+				continue
+			}
+			dest := ""
+			switch n := len(s.Endpoints); {
+			case n == 1:
+				dest = s.Endpoints[0]
+			case n > 1:
+				dest = fmt.Sprintf("%s (+%d)", s.Endpoints[0], n-1)
+			}
+			entries = append(entries, health.IdleEntry{
+				Kind:        health.IdleKindUDP,
+				ID:          s.StreamID,
+				Peer:        s.PeerID.ShortString(),
+				Destination: dest,
+				BytesSent:   s.BytesOut,
+				BytesRecv:   s.BytesIn,
+				AgeMs:       now.Sub(s.CreatedAt).Milliseconds(),
+				IdleMs:      idle.Milliseconds(),
+			})
+		}
+	}
+
+	return entries
+}
+
+// closeIdle closes one idle entry. Returns false if it is already gone.
+func (a *Agent) closeIdle(e health.IdleEntry) bool {
+	switch e.Kind {
+	case health.IdleKindStream:
+		return a.resetStream(e.Direction, e.ID)
+	case health.IdleKindUDP:
+		return a.udpHandler != nil && a.udpHandler.CloseAssociation(e.ID)
+	}
+	return false
+}
+
+// handleIdleManage processes a ControlTypeIdleManage control request.
+func (a *Agent) handleIdleManage(data []byte) ([]byte, bool) {
+	var req struct {
+		Action  string `json:"action"`
+		MinIdle string `json:"min_idle"`
+	}
+	if err := json.Unmarshal(data, &req); err != nil {
+		resp, _ := json.Marshal(map[string]string{"error": "invalid request: " + err.Error()})
+		return resp, false
+	}
+
+	result, err := a.ManageIdle(req.Action, req.MinIdle)
+	if err != nil {
+		resp, _ := json.Marshal(map[string]string{"error": err.Error()})
+		return resp, false
+	}
+
+	resp, _ := json.Marshal(result)
+	return resp, true
+}
@@ -0,0 +1,153 @@
+package agent
+
+import (
+	"net/netip"
+	"time"
+
+	"github.com/postalsys/muti-metroo/internal/config"
+	"github.com/postalsys/muti-metroo/internal/identity"
+	"github.com/postalsys/muti-metroo/internal/logging"
+	"github.com/postalsys/muti-metroo/internal/recovery"
+	"github.com/postalsys/muti-metroo/internal/routing"
+	"github.com/postalsys/muti-metroo/internal/sysroute"
+)
+
+// kernelRouteResync is how often the installed routes are compared with
+// the routing table even without route changes, so failed installs are
+// retried and routes removed by hand are restored.
+const kernelRouteResync = 30 * time.Second
+
+// kernelRouteLoop keeps the host routing table in sync with the CIDR routes
+// learned from the mesh, and removes the installed routes on shutdown.
+func (a *Agent) kernelRouteLoop() {
+	defer a.wg.Done()
+	defer recovery.RecoverWithLog(a.logger, "kernelRouteLoop")
+
+	cfg := a.cfg.Routing.KernelRoutes
+	table, err := sysroute.Open(sysroute.Config{
+		Interface: cfg.Interface,
+		Metric:    cfg.Metric,
+		Table:     cfg.Table,
+	})
+	if err != nil {
+		a.logger.Warn("kernel routes disabled",
+			"interface", cfg.Interface,
+			logging.KeyError, err)
+		return
+	}
+	defer table.Close()
+
+	if err := table.Flush(); err != nil {
+		a.logger.Warn("failed to remove stale kernel routes",
+			logging.KeyError, err)
+	}
+
+	var exclude []netip.Prefix
+	for _, s := range cfg.Exclude {
+		if p, err := netip.ParsePrefix(s); err == nil {
+			exclude = append(exclude, p.Masked())
+		}
+	}
+
+	changes := make(chan routing.RouteChange, routeEventBuffer)
+	a.routeMgr.Subscribe(changes)
+	defer a.routeMgr.Unsubscribe(changes)
+
+	// sync installs the wanted routes and removes the others. With
+	// reinstall, routes already installed are added again in case they
+	// were removed by hand.
+	installed := make(map[netip.Prefix]bool)
+	sync := func(reinstall bool) {
+		want := kernelRoutePrefixes(a.routeMgr.Table().GetAllRoutes(), a.id, cfg, exclude)
+		for p := range installed {
+			if want[p] {
+				continue
+			}
+			if err := table.Delete(p); err != nil {
+				a.logger.Warn("failed to remove kernel route",
+					logging.KeyError, err)
+				continue
+			}
+			delete(installed, p)
+			a.logger.Debug("kernel route removed", "prefix", p.String())
+		}
+		for p := range want {
+			if installed[p] && !reinstall {
+				continue
+			}
+			if err := table.Add(p); err != nil {
+				a.logger.Warn("failed to install kernel route",
+					logging.KeyError, err)
+				continue
+			}
+			if !installed[p] {
+				installed[p] = true
+				a.logger.Debug("kernel route installed", "prefix", p.String())
+			}
+		}
+	}
+
+	a.logger.Info("installing mesh routes into the host routing table",
+		"interface", cfg.Interface)
+	sync(false)
+
+	ticker := time.NewTicker(kernelRouteResync)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-a.stopCh:
+			for p := range installed {
+				if err := table.Delete(p); err != nil {
+					a.logger.Warn("failed to remove kernel route",
+						logging.KeyError, err)
+				}
+			}
+			return
+		case <-changes:
+			// Coalesce a burst of changes into one pass
+			for drained := false; !drained; {
+				select {
+				case <-changes:
+				default:
+					drained = true
+				}
+			}
+			sync(false)
+		case <-ticker.C:
+			sync(true)
+		}
+	}
+}
+
+// kernelRoutePrefixes returns the prefixes to install for routes: learned
+// CIDR routes that are not this agent's own, not inside an excluded prefix,
+// and not default routes unless cfg allows them.
+func kernelRoutePrefixes(routes []*routing.Route, self identity.AgentID, cfg config.KernelRoutesConfig, exclude []netip.Prefix) map[netip.Prefix]bool {
+	want := make(map[netip.Prefix]bool)
+	for _, r := range routes {
+		if r.Network == nil || r.OriginAgent == self {
+			continue
+		}
+		addr, ok := netip.AddrFromSlice(r.Network.IP)
+		if !ok {
+			continue
+		}
+		ones, _ := r.Network.Mask.Size()
+		p := netip.PrefixFrom(addr.Unmap(), ones).Masked()
+		if !p.IsValid() || (p.Bits() == 0 && !cfg.DefaultRoute) {
+			continue
+		}
+		excluded := false
+		for _, e := range exclude {
+			if e.Bits() <= p.Bits() && e.Contains(p.Addr()) {
+				excluded = true
+				break
+			}
+		}
+		if !excluded {
+			want[p] = true
+		}
+	}
+	return want
+}
@@ -0,0 +1,312 @@
+package agent
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/postalsys/muti-metroo/internal/crypto"
+	"github.com/postalsys/muti-metroo/internal/health"
+	"github.com/postalsys/muti-metroo/internal/logging"
+)
+
+// managementKeyFile stores runtime management key rotation state in the
+// data directory, so staged and activated keys survive restarts.
+const managementKeyFile = "management_key.json"
+
+// managementKeyState is the content of managementKeyFile. It only applies
+// while management.public_key is still ConfigPublicKey; editing the config
+// to another key discards it.
+type managementKeyState struct {
+	ConfigPublicKey string `json:"config_public_key"`
+	PublicKey       string `json:"public_key"`
+	NextPublicKey   string `json:"next_public_key,omitempty"`
+}
+
+// initManagementKeyRotation applies management.next_public_key and
+// management.next_private_key and restores the rotation state saved by
+// earlier stage and activate requests.
+func (a *Agent) initManagementKeyRotation() error {
+	if a.cfg.Management.NextPublicKey != "" {
+		next, err := a.cfg.GetManagementNextPublicKey()
+		if err != nil {
+			return fmt.Errorf("get management next public key: %w", err)
+		}
+		a.sealedBox.SetNextPublicKey(next)
+		if a.cfg.Management.NextPrivateKey != "" {
+			priv, err := a.cfg.GetManagementNextPrivateKey()
+			if err != nil {
+				return fmt.Errorf("get management next private key: %w", err)
+			}
+			a.sealedBox.AddPrivateKey(next, priv)
+		}
+	}
+
+	state, err := a.loadManagementKeyState()
+	if err != nil {
+		a.logger.Warn("ignoring saved management key state", logging.KeyError, err)
+		return nil
+	}
+	if state == nil || state.ConfigPublicKey != a.cfg.Management.PublicKey {
+		return nil
+	}
+	pub, err := parseManagementKey(state.PublicKey)
+	if err != nil {
+		a.logger.Warn("ignoring saved management key state", logging.KeyError, err)
+		return nil
+	}
+	a.sealedBox.SetPublicKey(pub)
+	a.sealedBox.ClearNextPublicKey()
+	if state.NextPublicKey != "" {
+		next, err := parseManagementKey(state.NextPublicKey)
+		if err != nil {
+			a.logger.Warn("ignoring saved next management key", logging.KeyError, err)
+			return nil
+		}
+		a.sealedBox.SetNextPublicKey(next)
+	}
+	a.logger.Info("restored management key rotation state",
+		"public_key", state.PublicKey,
+		"next_public_key", state.NextPublicKey)
+	return nil
+}
+
+// ManageManagementKey reports and changes the management keys this agent
+// encrypts node info to. Implements health.ManagementKeyProvider.
+//
+// A rotation stages the new public key on every agent, so node info is
+// encrypted to both keys, then activates it once the status report shows
+// every agent adopted it. After activation the old private key no longer
+// decrypts anything this agent sends.
+func (a *Agent) ManageManagementKey(req health.ManagementKeyRequest) (*health.ManagementKeyResult, error) {
+	if a.sealedBox == nil {
+		return nil, fmt.Errorf("management key encryption not configured (management.public_key)")
+	}
+
+	a.mgmtKeyMu.Lock()
+	defer a.mgmtKeyMu.Unlock()
+
+	var message string
+	switch req.Action {
+	case "status":
+
+	case "stage":
+		next, err := parseManagementKey(req.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+		if next == a.sealedBox.PublicKey() {
+			return nil, fmt.Errorf("public_key is already the current management key")
+		}
+		a.sealedBox.SetNextPublicKey(next)
+		message = "next key staged, node info is encrypted to both keys"
+
+	case "activate":
+		next, ok := a.sealedBox.NextPublicKey()
+		if !ok {
+			return nil, fmt.Errorf("no next key staged")
+		}
+		if req.PublicKey != "" {
+			want, err := parseManagementKey(req.PublicKey)
+			if err != nil {
+				return nil, err
+			}
+			if want != next {
+				return nil, fmt.Errorf("public_key does not match the staged next key")
+			}
+		}
+		a.sealedBox.SetPublicKey(next)
+		a.sealedBox.ClearNextPublicKey()
+		message = "next key activated, node info is encrypted to it only"
+
+	case "abort":
+		if _, ok := a.sealedBox.NextPublicKey(); !ok {
+			return a.managementKeyResult("no next key staged"), nil
+		}
+		a.sealedBox.ClearNextPublicKey()
+		message = "next key removed"
+
+	default:
+		return nil, fmt.Errorf("unknown action %q (expected status, stage, activate or abort)", req.Action)
+	}
+
+	if req.Action != "status" {
+		if err := a.saveManagementKeyState(); err != nil {
+			a.logger.Warn("failed to save management key state", logging.KeyError, err)
+		}
+		a.logger.Warn("management key changed via API",
+			"action", req.Action,
+			"public_key", hexKey(a.sealedBox.PublicKey()),
+			"next_public_key", a.nextManagementKeyHex())
+		// Re-encrypt node info to the new key set right away
+		a.TriggerNodeInfoAdvertise()
+	}
+
+	return a.managementKeyResult(message), nil
+}
+
+// managementKeyResult reports the keys and, on agents that can decrypt,
+// which agents encrypt their node info to the target key.
+func (a *Agent) managementKeyResult(message string) *health.ManagementKeyResult {
+	current := a.sealedBox.PublicKey()
+	next, hasNext := a.sealedBox.NextPublicKey()
+	target := current
+	if hasNext {
+		target = next
+	}
+
+	result := &health.ManagementKeyResult{
+		Status:        "ok",
+		Message:       message,
+		PublicKey:     hexKey(current),
+		NextPublicKey: a.nextManagementKeyHex(),
+		CanDecrypt:    a.sealedBox.CanDecrypt(),
+	}
+	if !result.CanDecrypt {
+		return result
+	}
+	result.TargetKey = hexKey(target)
+
+	// The local agent encrypts to the keys it holds; others are checked
+	// against the last node info they flooded
+	local := health.ManagementKeyAgent{
+		ID:          a.id.String(),
+		ShortID:     a.id.ShortString(),
+		DisplayName: a.cfg.Agent.DisplayName,
+		SealedTo:    []string{hexKey(current)},
+	}
+	if hasNext {
+		local.SealedTo = append(local.SealedTo, hexKey(next))
+	}
+	local.Adopted = true
+	result.Agents = append(result.Agents, local)
+
+	for id, entry := range a.routeMgr.GetAllNodeInfoEntries() {
+		if id == a.id || entry.EncInfo == nil {
+			continue
+		}
+		ag := health.ManagementKeyAgent{
+			ID:       id.String(),
+			ShortID:  id.ShortString(),
+			SealedTo: []string{},
+		}
+		if entry.Info != nil {
+			ag.DisplayName = entry.Info.DisplayName
+		}
+		if entry.Encrypted {
+			for _, key := range a.sealedBox.SealedTo(entry.EncInfo.Data) {
+				ag.SealedTo = append(ag.SealedTo, hexKey(key))
+				if key == target {
+					ag.Adopted = true
+				}
+			}
+		}
+		result.Agents = append(result.Agents, ag)
+	}
+
+	sort.Slice(result.Agents, func(i, j int) bool {
+		return result.Agents[i].ID < result.Agents[j].ID
+	})
+	for _, ag := range result.Agents {
+		if ag.Adopted {
+			result.Adopted++
+		}
+	}
+	result.Total = len(result.Agents)
+	return result
+}
+
+// nextManagementKeyHex returns the staged next key in hex, or "".
+func (a *Agent) nextManagementKeyHex() string {
+	if next, ok := a.sealedBox.NextPublicKey(); ok {
+		return hexKey(next)
+	}
+	return ""
+}
+
+// loadManagementKeyState reads the saved rotation state. Returns nil if
+// there is none.
+func (a *Agent) loadManagementKeyState() (*managementKeyState, error) {
+	if a.dataDir == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(filepath.Join(a.dataDir, managementKeyFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var state managementKeyState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", managementKeyFile, err)
+	}
+	return &state, nil
+}
+
+// saveManagementKeyState writes the rotation state to the data directory.
+func (a *Agent) saveManagementKeyState() error {
+	if a.dataDir == "" {
+		return nil
+	}
+	state := managementKeyState{
+		ConfigPublicKey: a.cfg.Management.PublicKey,
+		PublicKey:       hexKey(a.sealedBox.PublicKey()),
+		NextPublicKey:   a.nextManagementKeyHex(),
+	}
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(a.dataDir, managementKeyFile)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// handleManagementKey processes a ControlTypeManagementKey control request.
+func (a *Agent) handleManagementKey(data []byte) ([]byte, bool) {
+	var req health.ManagementKeyRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		resp, _ := json.Marshal(map[string]string{"error": "invalid request: " + err.Error()})
+		return resp, false
+	}
+
+	result, err := a.ManageManagementKey(req)
+	if err != nil {
+		resp, _ := json.Marshal(map[string]string{"error": err.Error()})
+		return resp, false
+	}
+
+	resp, _ := json.Marshal(result)
+	return resp, true
+}
+
+// parseManagementKey parses a hex-encoded management public key.
+func parseManagementKey(s string) ([crypto.KeySize]byte, error) {
+	var key [crypto.KeySize]byte
+	if s == "" {
+		return key, fmt.Errorf("public_key is required")
+	}
+	b, err := hex.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return key, fmt.Errorf("invalid public_key: %w", err)
+	}
+	if len(b) != crypto.KeySize {
+		return key, fmt.Errorf("public_key must be %d bytes (%d hex chars), got %d bytes", crypto.KeySize, crypto.KeySize*2, len(b))
+	}
+	copy(key[:], b)
+	return key, nil
+}
+
+// hexKey formats a management key in hex.
+func hexKey(key [crypto.KeySize]byte) string {
+	return hex.EncodeToString(key[:])
+}
@@ -0,0 +1,31 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/postalsys/muti-metroo/internal/protocol"
+)
+
+type meshOnlyKey struct{}
+
+// WithMeshOnly returns ctx for DialContext calls that must go through a mesh
+// route (or this agent's own exit). Without a route, or when the next hop
+// is not connected, the dial fails instead of connecting directly.
+func WithMeshOnly(ctx context.Context) context.Context {
+	return context.WithValue(ctx, meshOnlyKey{}, true)
+}
+
+// meshOnly reports whether ctx was marked by WithMeshOnly.
+func meshOnly(ctx context.Context) bool {
+	only, _ := ctx.Value(meshOnlyKey{}).(bool)
+	return only
+}
+
+// errNoMeshRoute reports a mesh-only dial without a usable route.
+func errNoMeshRoute(host string) error {
+	return &streamOpenError{
+		code: protocol.ErrNoRoute,
+		err:  fmt.Errorf("no mesh route to %s", host),
+	}
+}
@@ -0,0 +1,125 @@
+package agent
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/postalsys/muti-metroo/internal/middleware"
+	"github.com/postalsys/muti-metroo/internal/protocol"
+	"github.com/postalsys/muti-metroo/internal/proxyproto"
+	"github.com/postalsys/muti-metroo/internal/socks5"
+)
+
+// UseStreamMiddleware adds m to the middleware called on TCP streams this
+// agent dials as ingress (SOCKS5, port forward listeners and tunnels) and
+// connects as exit. Middleware runs in the order added. It must be called
+// before Start.
+func (a *Agent) UseStreamMiddleware(m middleware.StreamMiddleware) {
+	a.middleware = append(a.middleware, m)
+	if a.exitHandler != nil {
+		a.exitHandler.SetMiddleware(a.middleware)
+	}
+}
+
+// dialWithMiddleware dials an ingress stream once the middleware accepted
+// it, and wraps the connection so the middleware sees its data and close.
+func (a *Agent) dialWithMiddleware(ctx context.Context, network, destination string, dial func() (net.Conn, error)) (net.Conn, error) {
+	if len(a.middleware) == 0 {
+		return dial()
+	}
+
+	s := &middleware.Stream{
+		Side:        middleware.Ingress,
+		Network:     network,
+		Destination: destination,
+		User:        socks5.UserFromContext(ctx),
+		StartedAt:   time.Now(),
+	}
+	if addr := proxyproto.ClientAddrFromContext(ctx); addr != nil {
+		s.Client = addr
+	}
+	if err := a.middleware.OpenStream(ctx, s); err != nil {
+		return nil, &streamOpenError{code: protocol.ErrNotAllowed, err: err}
+	}
+
+	conn, err := dial()
+	if err != nil {
+		a.middleware.CloseStream(s)
+		return nil, err
+	}
+	return &middlewareConn{Conn: conn, chain: a.middleware, stream: s}, nil
+}
+
+// middlewareConn passes the data of an ingress connection through the
+// middleware. Writes go to the destination, reads come from it.
+type middlewareConn struct {
+	net.Conn
+	chain  middleware.Chain
+	stream *middleware.Stream
+
+	readBuf   []byte // Middleware output that did not fit the caller's buffer
+	readErr   error  // Error to return once readBuf is drained
+	closeOnce sync.Once
+}
+
+func (c *middlewareConn) Read(b []byte) (int, error) {
+	if len(c.readBuf) > 0 {
+		n := copy(b, c.readBuf)
+		c.readBuf = c.readBuf[n:]
+		return n, nil
+	}
+	if c.readErr != nil {
+		return 0, c.readErr
+	}
+
+	for {
+		n, err := c.Conn.Read(b)
+		if n > 0 {
+			data, mwErr := c.chain.StreamData(c.stream, middleware.FromDestination, b[:n])
+			if mwErr != nil {
+				return 0, mwErr
+			}
+			k := copy(b, data)
+			if k < len(data) {
+				c.readBuf = append([]byte(nil), data[k:]...)
+				c.readErr = err
+				return k, nil
+			}
+			if k > 0 {
+				return k, err
+			}
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+func (c *middlewareConn) Write(b []byte) (int, error) {
+	data, err := c.chain.StreamData(c.stream, middleware.ToDestination, b)
+	if err != nil {
+		return 0, err
+	}
+	if len(data) > 0 {
+		if _, err := c.Conn.Write(data); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// Close closes the connection and the stream with the middleware.
+func (c *middlewareConn) Close() error {
+	c.closeOnce.Do(func() { c.chain.CloseStream(c.stream) })
+	return c.Conn.Close()
+}
+
+// CloseWrite forwards half-close to the wrapped connection.
+func (c *middlewareConn) CloseWrite() error {
+	if hc, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return hc.CloseWrite()
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	"github.com/postalsys/muti-metroo/internal/forward"
+	"github.com/postalsys/muti-metroo/internal/identity"
+	"github.com/postalsys/muti-metroo/internal/logging"
+	"github.com/postalsys/muti-metroo/internal/protocol"
+	"github.com/postalsys/muti-metroo/internal/socks5"
+)
+
+// errNamespaceDatagrams refuses UDP associations and ICMP sessions of
+// clients outside the default namespace.
+var errNamespaceDatagrams = errors.New("UDP and ICMP are only available in the default namespace")
+
+type namespaceKey struct{}
+
+// withNamespace returns ctx for dials on behalf of a client in namespace ns.
+func withNamespace(ctx context.Context, ns string) context.Context {
+	return context.WithValue(ctx, namespaceKey{}, ns)
+}
+
+// streamNamespace returns the tenant namespace of a dial: the namespace of
+// the forward listener, else of the SOCKS5 user, else agent.namespace.
+func (a *Agent) streamNamespace(ctx context.Context) string {
+	if ns, ok := ctx.Value(namespaceKey{}).(string); ok {
+		return ns
+	}
+	if user := socks5.UserFromContext(ctx); user != "" {
+		for _, u := range a.cfg.SOCKS5.Auth.Users {
+			if u.Username == user && u.Namespace != "" {
+				return u.Namespace
+			}
+		}
+	}
+	return a.cfg.Agent.Namespace
+}
+
+// directAllowed reports whether a dial without a usable mesh route may
+// connect directly from this agent. Clients of another namespace never
+// reach this agent's own network.
+func (a *Agent) directAllowed(ctx context.Context, ns string) bool {
+	return !meshOnly(ctx) && ns == a.cfg.Agent.Namespace
+}
+
+// forwardDialer returns the dialer of a forward listener in namespace ns,
+// which connects to endpoints of that namespace only.
+func (a *Agent) forwardDialer(ns string) forward.ForwardDialer {
+	if ns == "" {
+		return a
+	}
+	return &namespaceDialer{agent: a, namespace: ns}
+}
+
+// namespaceDialer dials forward streams in a fixed namespace.
+type namespaceDialer struct {
+	agent     *Agent
+	namespace string
+}
+
+// DialForward implements forward.ForwardDialer.
+func (d *namespaceDialer) DialForward(ctx context.Context, key string) (net.Conn, error) {
+	return d.agent.DialForward(withNamespace(ctx, d.namespace), key)
+}
+
+// acceptNamespace refuses a stream this agent is the exit or forward
+// endpoint for when it comes from another namespace. Returns false when the
+// stream was refused.
+func (a *Agent) acceptNamespace(peerID identity.AgentID, streamID uint64, open *protocol.StreamOpen) bool {
+	if open.Namespace == a.cfg.Agent.Namespace {
+		return true
+	}
+	a.logger.Debug("stream from another namespace refused",
+		logging.KeyPeerID, peerID.ShortString(),
+		logging.KeyStreamID, streamID,
+		"namespace", open.Namespace)
+	a.sendStreamOpenErr(peerID, streamID, open.RequestID, protocol.ErrNotAllowed, "namespace not allowed")
+	return false
+}
@@ -0,0 +1,393 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/postalsys/muti-metroo/internal/config"
+	"github.com/postalsys/muti-metroo/internal/identity"
+	"github.com/postalsys/muti-metroo/internal/logging"
+	"github.com/postalsys/muti-metroo/internal/peer"
+	"github.com/postalsys/muti-metroo/internal/protocol"
+	"github.com/postalsys/muti-metroo/internal/recovery"
+	"github.com/postalsys/muti-metroo/internal/transport"
+)
+
+// Rendezvous actions (ControlTypeRendezvous)
+const (
+	rendezvousObserve = "observe" // Reply with the requester's address as seen on this link
+	rendezvousPunch   = "punch"   // Punch towards the requester and reply with our endpoints
+)
+
+// NAT traversal constants
+const (
+	maxNATEndpoints = 4                      // Endpoints kept and exchanged per agent
+	punchPackets    = 10                     // Datagrams sent towards each endpoint of the requester
+	punchSpacing    = 200 * time.Millisecond // Gap between punch datagrams
+)
+
+// errEndpointUnknown is returned when the target has not learned its own
+// public endpoint yet. The attempt is retried on the next interval instead
+// of waiting out retry_interval, as happens right after startup.
+var errEndpointUnknown = errors.New("public endpoint not known yet")
+
+// rendezvousRequest is the payload of a ControlTypeRendezvous request.
+type rendezvousRequest struct {
+	Action    string   `json:"action"`
+	Agent     string   `json:"agent,omitempty"`     // Requesting agent (punch)
+	Endpoints []string `json:"endpoints,omitempty"` // Requester's public QUIC endpoints (punch)
+}
+
+// rendezvousResponse is the payload of a ControlTypeRendezvous response.
+type rendezvousResponse struct {
+	Endpoints []string `json:"endpoints,omitempty"`
+	Error     string   `json:"error,omitempty"`
+}
+
+// natState tracks this agent's public endpoints and hole punching attempts.
+type natState struct {
+	mu        sync.Mutex
+	endpoints []string                       // Our public QUIC endpoints as observed by direct peers
+	direct    map[identity.AgentID]bool      // Agents reached over a punched link
+	failed    map[identity.AgentID]time.Time // Agent -> time of the last failed attempt
+	pending   map[identity.AgentID]bool      // Attempts in flight
+}
+
+func newNATState() *natState {
+	return &natState{
+		direct:  make(map[identity.AgentID]bool),
+		failed:  make(map[identity.AgentID]time.Time),
+		pending: make(map[identity.AgentID]bool),
+	}
+}
+
+// natTraversalLoop periodically learns this agent's public endpoints and
+// tries to upgrade relayed agents to direct links.
+func (a *Agent) natTraversalLoop() {
+	defer a.wg.Done()
+	defer recovery.RecoverWithLog(a.logger, "natTraversalLoop")
+
+	cfg := a.cfg.Connections.NATTraversal
+	ticker := time.NewTicker(cfg.Interval)
+	defer ticker.Stop()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-a.stopCh
+		cancel()
+	}()
+
+	a.logger.Debug("NAT traversal loop started",
+		"interval", cfg.Interval,
+		"punch_timeout", cfg.PunchTimeout,
+		"max_direct", cfg.MaxDirect)
+
+	for {
+		select {
+		case <-a.stopCh:
+			return
+		case <-ticker.C:
+			if a.peerMgr.IsPaused() {
+				continue
+			}
+			a.refreshNATEndpoints(ctx)
+			for target, transit := range a.natCandidates() {
+				a.wg.Add(1)
+				go a.punchAgent(ctx, target, transit)
+			}
+		}
+	}
+}
+
+// refreshNATEndpoints asks every direct QUIC peer which address it sees
+// for this agent. With nat_traversal enabled, all QUIC links use the
+// listener socket, so these are the endpoints other agents can punch to.
+func (a *Agent) refreshNATEndpoints(ctx context.Context) {
+	data, _ := json.Marshal(rendezvousRequest{Action: rendezvousObserve})
+
+	seen := make(map[string]bool)
+	var endpoints []string
+	for _, conn := range a.peerMgr.GetAllPeers() {
+		if conn.TransportType() != transport.TransportQUIC || len(endpoints) >= maxNATEndpoints {
+			continue
+		}
+		reqCtx, cancel := context.WithTimeout(ctx, a.cfg.Connections.NATTraversal.PunchTimeout)
+		resp, err := a.sendControlRequestVia(reqCtx, conn.RemoteID, nil, conn.RemoteID, protocol.ControlTypeRendezvous, data)
+		cancel()
+		if err != nil || !resp.Success {
+			continue // Older agents answer "unknown control type"
+		}
+		var result rendezvousResponse
+		if json.Unmarshal(resp.Data, &result) != nil {
+			continue
+		}
+		for _, ep := range validEndpoints(result.Endpoints) {
+			if !seen[ep] {
+				seen[ep] = true
+				endpoints = append(endpoints, ep)
+			}
+		}
+	}
+
+	a.nat.mu.Lock()
+	a.nat.endpoints = endpoints
+	a.nat.mu.Unlock()
+}
+
+// natCandidates returns the agents to attempt a direct link to, mapped to
+// the transit peer to rendezvous through. Candidates share a QUIC transit
+// peer with this agent (per the transit's node info), have a higher ID (so
+// only one side of a pair initiates), are not already being punched and
+// have not failed within retry_interval.
+func (a *Agent) natCandidates() map[identity.AgentID]identity.AgentID {
+	cfg := a.cfg.Connections.NATTraversal
+
+	a.nat.mu.Lock()
+	defer a.nat.mu.Unlock()
+
+	if len(a.nat.endpoints) == 0 {
+		return nil
+	}
+
+	// Forget punched links that have gone away
+	for id := range a.nat.direct {
+		if a.peerMgr.GetPeer(id) == nil {
+			delete(a.nat.direct, id)
+		}
+	}
+
+	slots := cfg.MaxDirect - len(a.nat.direct) - len(a.nat.pending)
+	candidates := make(map[identity.AgentID]identity.AgentID)
+	for _, conn := range a.peerMgr.GetAllPeers() {
+		if conn.TransportType() != transport.TransportQUIC {
+			continue
+		}
+		// The transit peer's node info lists the agents linked to it
+		info := a.routeMgr.GetNodeInfo(conn.RemoteID)
+		if info == nil {
+			continue
+		}
+		for _, p := range info.Peers {
+			if slots <= 0 {
+				return candidates
+			}
+			id := identity.AgentID(p.PeerID)
+			if p.Transport != string(transport.TransportQUIC) || bytes.Compare(a.id[:], id[:]) >= 0 {
+				continue
+			}
+			if a.nat.pending[id] || a.peerMgr.GetPeer(id) != nil {
+				continue
+			}
+			if last, ok := a.nat.failed[id]; ok && time.Since(last) < cfg.RetryInterval {
+				continue
+			}
+			a.nat.pending[id] = true
+			candidates[id] = conn.RemoteID
+			slots--
+		}
+	}
+	return candidates
+}
+
+// punchAgent exchanges endpoints with target through transit and dials its
+// endpoints from the listener socket while target punches towards ours.
+func (a *Agent) punchAgent(ctx context.Context, target, transit identity.AgentID) {
+	defer a.wg.Done()
+	defer recovery.RecoverWithLog(a.logger, "punchAgent")
+
+	err := a.tryPunch(ctx, target, transit)
+
+	a.nat.mu.Lock()
+	delete(a.nat.pending, target)
+	if err == nil {
+		a.nat.direct[target] = true
+		delete(a.nat.failed, target)
+	} else if !errors.Is(err, errEndpointUnknown) {
+		a.nat.failed[target] = time.Now()
+	}
+	a.nat.mu.Unlock()
+
+	if err != nil {
+		a.logger.Debug("NAT traversal failed",
+			logging.KeyPeerID, target.ShortString(),
+			logging.KeyError, err)
+	}
+}
+
+// tryPunch runs one hole punching attempt towards target.
+func (a *Agent) tryPunch(ctx context.Context, target, transit identity.AgentID) error {
+	cfg := a.cfg.Connections.NATTraversal
+
+	a.nat.mu.Lock()
+	own := append([]string(nil), a.nat.endpoints...)
+	a.nat.mu.Unlock()
+
+	data, _ := json.Marshal(rendezvousRequest{
+		Action:    rendezvousPunch,
+		Agent:     a.id.String(),
+		Endpoints: own,
+	})
+	reqCtx, cancel := context.WithTimeout(ctx, cfg.PunchTimeout)
+	resp, err := a.sendControlRequestVia(reqCtx, transit, []identity.AgentID{target}, target, protocol.ControlTypeRendezvous, data)
+	cancel()
+	if err != nil {
+		return fmt.Errorf("rendezvous: %w", err)
+	}
+	var result rendezvousResponse
+	if err := json.Unmarshal(resp.Data, &result); err != nil || !resp.Success {
+		if result.Error == errEndpointUnknown.Error() {
+			return fmt.Errorf("rendezvous: %w", errEndpointUnknown)
+		}
+		if result.Error != "" {
+			return fmt.Errorf("rendezvous: %s", result.Error)
+		}
+		return fmt.Errorf("rendezvous: %s", resp.Data)
+	}
+	endpoints := validEndpoints(result.Endpoints)
+	if len(endpoints) == 0 {
+		return fmt.Errorf("rendezvous: no endpoints")
+	}
+
+	dialOpts, err := a.peerDialOptions(config.PeerConfig{ID: target.String(), Transport: "quic"})
+	if err != nil {
+		return err
+	}
+	dialOpts.Timeout = cfg.PunchTimeout
+
+	quicTransport := a.quicTransport()
+	for _, ep := range endpoints {
+		// Open our own NAT towards the endpoint before the handshake starts
+		quicTransport.Punch(ep)
+
+		a.peerMgr.AddPeer(peer.PeerInfo{
+			Address:     ep,
+			ExpectedID:  target,
+			DialOptions: dialOpts,
+		})
+		dialCtx, cancel := context.WithTimeout(ctx, cfg.PunchTimeout)
+		conn, err := a.peerMgr.Connect(dialCtx, ep)
+		cancel()
+		a.peerMgr.RemovePeer(ep)
+		if err != nil {
+			continue
+		}
+
+		a.logger.Info("direct link established through NAT",
+			logging.KeyPeerID, target.ShortString(),
+			logging.KeyRemoteAddr, conn.RemoteAddr())
+		return nil
+	}
+	return fmt.Errorf("no endpoint answered: %v", endpoints)
+}
+
+// handleRendezvous processes a ControlTypeRendezvous control request.
+// peerID is the peer the request arrived from.
+func (a *Agent) handleRendezvous(peerID identity.AgentID, data []byte) ([]byte, bool) {
+	var req rendezvousRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		return rendezvousError("invalid request: " + err.Error())
+	}
+
+	switch req.Action {
+	case rendezvousObserve:
+		// Only meaningful on a direct link, which is how observe is sent
+		conn := a.peerMgr.GetPeer(peerID)
+		if conn == nil || conn.TransportType() != transport.TransportQUIC {
+			return rendezvousError("observe requires a direct QUIC link")
+		}
+		resp, _ := json.Marshal(rendezvousResponse{Endpoints: []string{conn.RemoteAddr()}})
+		return resp, true
+
+	case rendezvousPunch:
+		if !a.cfg.Connections.NATTraversal.Enabled {
+			return rendezvousError("nat traversal disabled")
+		}
+		requester, err := identity.ParseAgentID(req.Agent)
+		if err != nil {
+			return rendezvousError("invalid agent: " + err.Error())
+		}
+		endpoints := validEndpoints(req.Endpoints)
+		if len(endpoints) == 0 {
+			return rendezvousError("no endpoints")
+		}
+
+		a.nat.mu.Lock()
+		own := append([]string(nil), a.nat.endpoints...)
+		a.nat.mu.Unlock()
+		if len(own) == 0 {
+			return rendezvousError(errEndpointUnknown.Error())
+		}
+
+		a.logger.Debug("punching towards agent",
+			logging.KeyPeerID, requester.ShortString(),
+			"endpoints", endpoints)
+		a.wg.Add(1)
+		go a.sendPunches(endpoints)
+
+		resp, _ := json.Marshal(rendezvousResponse{Endpoints: own})
+		return resp, true
+	}
+
+	return rendezvousError("unknown action: " + req.Action)
+}
+
+// sendPunches sends punch datagrams to endpoints from the listener socket
+// for a few seconds, opening our NAT for the requester's handshake.
+func (a *Agent) sendPunches(endpoints []string) {
+	defer a.wg.Done()
+
+	quicTransport := a.quicTransport()
+	ticker := time.NewTicker(punchSpacing)
+	defer ticker.Stop()
+
+	for i := 0; i < punchPackets; i++ {
+		for _, ep := range endpoints {
+			quicTransport.Punch(ep)
+		}
+		select {
+		case <-a.stopCh:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// rendezvousError builds a failed rendezvous response.
+func rendezvousError(msg string) ([]byte, bool) {
+	resp, _ := json.Marshal(rendezvousResponse{Error: msg})
+	return resp, false
+}
+
+// validEndpoints returns the entries of endpoints that are IP:port pairs,
+// capped at maxNATEndpoints.
+func validEndpoints(endpoints []string) []string {
+	var valid []string
+	for _, ep := range endpoints {
+		if len(valid) >= maxNATEndpoints {
+			break
+		}
+		addr, err := netip.ParseAddrPort(ep)
+		if err != nil || addr.Addr().IsUnspecified() || addr.Port() == 0 {
+			continue
+		}
+		valid = append(valid, addr.String())
+	}
+	return valid
+}
+
+// quicTransport returns the QUIC transport, seeing through the fault
+// injection wrapper when chaos is enabled.
+func (a *Agent) quicTransport() *transport.QUICTransport {
+	tr := a.transports[transport.TransportQUIC]
+	if w, ok := tr.(interface{ Unwrap() transport.Transport }); ok {
+		tr = w.Unwrap()
+	}
+	return tr.(*transport.QUICTransport)
+}
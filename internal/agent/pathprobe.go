@@ -0,0 +1,152 @@
+package agent
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/postalsys/muti-metroo/internal/identity"
+	"github.com/postalsys/muti-metroo/internal/protocol"
+	"github.com/postalsys/muti-metroo/internal/recovery"
+	"github.com/postalsys/muti-metroo/internal/routing"
+)
+
+// maxConcurrentPathProbes bounds the probes in flight during one round.
+const maxConcurrentPathProbes = 32
+
+// pathProbeTarget is a distinct path to a route origin.
+type pathProbeTarget struct {
+	key  routing.PathKey
+	path []identity.AgentID // Remaining path after the next hop (nil if encrypted)
+}
+
+// pathProbeTargets returns one target per (origin, next hop) pair in the
+// CIDR route table. Local routes and routes originated by a direct peer are
+// skipped, since peer keepalives already cover a single link.
+func (a *Agent) pathProbeTargets() []pathProbeTarget {
+	seen := make(map[routing.PathKey]bool)
+	var targets []pathProbeTarget
+	for _, r := range a.routeMgr.Table().GetAllRoutes() {
+		if r.OriginAgent == a.id || r.OriginAgent == r.NextHop {
+			continue
+		}
+		key := routing.PathKey{Origin: r.OriginAgent, NextHop: r.NextHop}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		var path []identity.AgentID
+		for i, id := range r.Path {
+			if id == r.NextHop && i+1 < len(r.Path) {
+				path = r.Path[i+1:]
+				break
+			}
+		}
+		targets = append(targets, pathProbeTarget{key: key, path: path})
+	}
+	return targets
+}
+
+// pathProbeLoop periodically probes every path to a route origin and marks
+// paths that stop answering as unreachable.
+func (a *Agent) pathProbeLoop() {
+	defer a.wg.Done()
+	defer recovery.RecoverWithLog(a.logger, "pathProbeLoop")
+
+	cfg := a.cfg.Routing.PathProbe
+	ticker := time.NewTicker(cfg.Interval)
+	defer ticker.Stop()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-a.stopCh
+		cancel()
+	}()
+
+	a.logger.Debug("path probe loop started",
+		"interval", cfg.Interval,
+		"timeout", cfg.Timeout,
+		"failure_threshold", cfg.FailureThreshold)
+
+	failures := make(map[routing.PathKey]int)
+	for {
+		select {
+		case <-a.stopCh:
+			return
+		case <-ticker.C:
+			a.probePaths(ctx, failures)
+		}
+	}
+}
+
+// probePaths runs one probe round. failures holds consecutive failure counts
+// per path and is updated in place.
+func (a *Agent) probePaths(ctx context.Context, failures map[routing.PathKey]int) {
+	cfg := a.cfg.Routing.PathProbe
+	targets := a.pathProbeTargets()
+
+	results := make([]bool, len(targets))
+	sem := make(chan struct{}, maxConcurrentPathProbes)
+	var wg sync.WaitGroup
+	for i, target := range targets {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			probeCtx, cancel := context.WithTimeout(ctx, cfg.Timeout)
+			defer cancel()
+			// Any reply proves the path works, including "unknown control
+			// type" from agents that predate path probes
+			_, err := a.sendControlRequestVia(probeCtx, target.key.NextHop, target.path, target.key.Origin, protocol.ControlTypePathProbe, nil)
+			results[i] = err == nil
+		}()
+	}
+	wg.Wait()
+
+	if ctx.Err() != nil {
+		return
+	}
+
+	// Forget paths whose routes are gone
+	current := make(map[routing.PathKey]bool, len(targets))
+	for i, target := range targets {
+		current[target.key] = true
+		a.recordPathProbe(failures, target.key, results[i], cfg.FailureThreshold)
+	}
+	for key := range failures {
+		if !current[key] {
+			delete(failures, key)
+		}
+	}
+}
+
+// recordPathProbe applies a probe result, marking the path down after
+// threshold consecutive failures and up again after the first success.
+func (a *Agent) recordPathProbe(failures map[routing.PathKey]int, key routing.PathKey, ok bool, threshold int) {
+	table := a.routeMgr.Table()
+	if ok {
+		delete(failures, key)
+		if table.IsPathDown(key.Origin, key.NextHop) {
+			routes := table.SetPathDown(key.Origin, key.NextHop, false)
+			a.logger.Info("path to route origin recovered",
+				"origin", key.Origin.ShortString(),
+				"next_hop", key.NextHop.ShortString(),
+				"routes", routes)
+		}
+		return
+	}
+
+	failures[key]++
+	if failures[key] < threshold || table.IsPathDown(key.Origin, key.NextHop) {
+		return
+	}
+	routes := table.SetPathDown(key.Origin, key.NextHop, true)
+	a.logger.Warn("path to route origin unreachable, failing over",
+		"origin", key.Origin.ShortString(),
+		"next_hop", key.NextHop.ShortString(),
+		"failures", failures[key],
+		"routes", routes)
+}
@@ -0,0 +1,118 @@
+package agent
+
+import (
+	"net"
+	"strconv"
+	"sync"
+
+	"github.com/postalsys/muti-metroo/internal/config"
+	"github.com/postalsys/muti-metroo/internal/exit"
+	"github.com/postalsys/muti-metroo/internal/health"
+	"github.com/postalsys/muti-metroo/internal/protocol"
+)
+
+// exitPortLimitConfig converts the exit.port_limits configuration for the
+// exit handler. The SMTP class comes first, so it wins over classes that
+// list the same ports.
+func (a *Agent) exitPortLimitConfig() exit.PortLimitConfig {
+	pl := a.cfg.Exit.PortLimits
+	if pl.Enabled != nil && !*pl.Enabled {
+		return exit.PortLimitConfig{}
+	}
+
+	smtp := pl.SMTP
+	smtp.Name = "smtp"
+	if len(smtp.Ports) == 0 {
+		smtp.Ports = exit.SMTPPorts
+	}
+	cfg := exit.PortLimitConfig{
+		Classes:          []exit.PortClass{portClass(smtp)},
+		DefaultRouteOnly: pl.Enabled == nil,
+	}
+	for _, class := range pl.Classes {
+		cfg.Classes = append(cfg.Classes, portClass(class))
+	}
+	return cfg
+}
+
+// portClass converts one exit port class. The action defaults to block.
+func portClass(c config.ExitPortClassConfig) exit.PortClass {
+	action := c.Action
+	if action == "" {
+		action = exit.PortActionBlock
+	}
+	return exit.PortClass{
+		Name:           c.Name,
+		Ports:          c.Ports,
+		Action:         action,
+		MaxConnections: c.MaxConnections,
+		Rate:           c.Rate,
+		Burst:          c.Burst,
+	}
+}
+
+// admitLocalExitPort checks a connection this agent dials as its own exit
+// against the exit port classes.
+func (a *Agent) admitLocalExitPort(address string) (func(), error) {
+	h := a.exitHandler
+	if h == nil {
+		return func() {}, nil
+	}
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return func() {}, nil
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return func() {}, nil
+	}
+	release, err := h.AdmitPort(uint16(port), host)
+	if err != nil {
+		return nil, &streamOpenError{code: protocol.ErrNotAllowed, err: err}
+	}
+	return release, nil
+}
+
+// portLimitConn releases the port class slot of a local exit connection
+// when it closes.
+type portLimitConn struct {
+	net.Conn
+	release   func()
+	closeOnce sync.Once
+}
+
+// Close closes the connection and releases its port class slot.
+func (c *portLimitConn) Close() error {
+	c.closeOnce.Do(c.release)
+	return c.Conn.Close()
+}
+
+// CloseWrite forwards half-close to the wrapped connection.
+func (c *portLimitConn) CloseWrite() error {
+	if hc, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return hc.CloseWrite()
+	}
+	return nil
+}
+
+// PortLimitStats returns the exit port classes and their counters.
+// Implements health.PortLimitStatsProvider.
+func (a *Agent) PortLimitStats() health.PortLimitStatsResponse {
+	resp := health.PortLimitStatsResponse{Classes: []health.PortClassInfo{}}
+	h := a.exitHandler
+	if h == nil {
+		return resp
+	}
+	resp.Active = h.PortLimitsActive()
+	resp.DefaultRouteOnly = a.cfg.Exit.PortLimits.Enabled == nil
+	for _, st := range h.PortLimitStats() {
+		resp.Classes = append(resp.Classes, health.PortClassInfo{
+			Name:    st.Name,
+			Ports:   st.Ports,
+			Action:  st.Action,
+			Active:  st.Active,
+			Refused: st.Refused,
+		})
+	}
+	return resp
+}
@@ -0,0 +1,128 @@
+package agent
+
+import (
+	"context"
+
+	"github.com/postalsys/muti-metroo/internal/health"
+	"github.com/postalsys/muti-metroo/internal/identity"
+	"github.com/postalsys/muti-metroo/internal/logging"
+	"github.com/postalsys/muti-metroo/internal/protocol"
+	"github.com/postalsys/muti-metroo/internal/rbac"
+)
+
+// apiTokens converts the configured HTTP role tokens for the health server.
+// Config validation has already checked the role names.
+func (a *Agent) apiTokens() []health.APIToken {
+	tokens := make([]health.APIToken, 0, len(a.cfg.HTTP.Tokens))
+	for _, t := range a.cfg.HTTP.Tokens {
+		role, _ := rbac.ParseRole(t.Role)
+		tokens = append(tokens, health.APIToken{Name: t.Name, Hash: t.TokenHash, Role: role})
+	}
+	return tokens
+}
+
+// outgoingRole returns the role stated in control requests sent on behalf
+// of ctx. Requests from the agent itself or from an HTTP API without role
+// tokens carry admin.
+func outgoingRole(ctx context.Context) rbac.Role {
+	if role, ok := rbac.FromContext(ctx); ok {
+		return role
+	}
+	return rbac.RoleAdmin
+}
+
+// controlRequestRole returns the role a control request received from
+// peerID acts with: the role stated in the request, capped by the role of
+// the peer it arrived from. A peer can relay requests but never raise
+// their role.
+func (a *Agent) controlRequestRole(peerID identity.AgentID, stated uint8) rbac.Role {
+	role := rbac.Role(stated)
+	if role == rbac.RoleNone {
+		role = parseRoleOr(a.cfg.RBAC.LegacyRole, rbac.RoleAdmin)
+	}
+	return min(role, a.peerRole(peerID))
+}
+
+// peerRole returns the role granted to a connected peer by the
+// organizational units of its certificate. The highest mapped OU wins;
+// peers without a mapped OU get rbac.default_peer_role.
+func (a *Agent) peerRole(peerID identity.AgentID) rbac.Role {
+	defaultRole := parseRoleOr(a.cfg.RBAC.DefaultPeerRole, rbac.RoleAdmin)
+	if len(a.cfg.RBAC.PeerRoles) == 0 || a.peerMgr == nil {
+		return defaultRole
+	}
+
+	conn := a.peerMgr.GetPeer(peerID)
+	if conn == nil {
+		return defaultRole
+	}
+	cert := conn.PeerCertificate()
+	if cert == nil {
+		return defaultRole
+	}
+
+	role := rbac.RoleNone
+	for _, ou := range cert.Subject.OrganizationalUnit {
+		if name, ok := a.cfg.RBAC.PeerRoles[ou]; ok {
+			role = max(role, parseRoleOr(name, rbac.RoleNone))
+		}
+	}
+	if role == rbac.RoleNone {
+		return defaultRole
+	}
+	return role
+}
+
+// streamOpenRole returns the role needed to open a stream to the
+// destination of open, matching what the HTTP API requires of tokens:
+// admin for shell and operator for file transfer. Other streams need none.
+func streamOpenRole(open *protocol.StreamOpen) rbac.Role {
+	if open.AddressType != protocol.AddrTypeDomain {
+		return rbac.RoleNone
+	}
+	switch addressToString(open.AddressType, open.Address) {
+	case protocol.ShellStream, protocol.ShellInteractive:
+		return rbac.RoleAdmin
+	case protocol.FileTransferUpload, protocol.FileTransferDownload:
+		return rbac.RoleOperator
+	}
+	return rbac.RoleNone
+}
+
+// allowStreamOpen checks a STREAM_OPEN received from peerID against the
+// role of that peer and refuses it with STREAM_OPEN_ERR when the role is
+// too low. Relays check it too, so a peer cannot reach a shell or file
+// transfer by opening it through another agent.
+func (a *Agent) allowStreamOpen(peerID identity.AgentID, streamID uint64, open *protocol.StreamOpen) bool {
+	required := streamOpenRole(open)
+	if required == rbac.RoleNone {
+		return true
+	}
+	role := a.peerRole(peerID)
+	if role.Allows(required) {
+		return true
+	}
+
+	a.logger.Warn("stream open denied by role",
+		logging.KeyPeerID, peerID.ShortString(),
+		logging.KeyStreamID, streamID,
+		"destination", addressToString(open.AddressType, open.Address),
+		"role", role.String(),
+		"required", required.String())
+	a.sendStreamOpenErr(peerID, streamID, open.RequestID, protocol.ErrNotAllowed,
+		"forbidden: "+required.String()+" role required")
+	return false
+}
+
+// parseRoleOr parses a configured role name, returning fallback when it is
+// empty or invalid.
+func parseRoleOr(name string, fallback rbac.Role) rbac.Role {
+	if name == "" {
+		return fallback
+	}
+	role, err := rbac.ParseRole(name)
+	if err != nil {
+		return fallback
+	}
+	return role
+}
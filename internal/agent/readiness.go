@@ -0,0 +1,46 @@
+package agent
+
+import (
+	"fmt"
+
+	"github.com/postalsys/muti-metroo/internal/health"
+	"github.com/postalsys/muti-metroo/internal/routing"
+)
+
+// Readiness evaluates the http.readiness criteria for /readyz.
+// Implements health.ReadinessProvider.
+func (a *Agent) Readiness() []health.ReadinessCheck {
+	rc := a.cfg.HTTP.Readiness
+
+	var checks []health.ReadinessCheck
+	if rc.MinPeers > 0 {
+		n := a.peerMgr.PeerCount()
+		checks = append(checks, health.ReadinessCheck{
+			Name:   "peers",
+			OK:     n >= rc.MinPeers,
+			Detail: fmt.Sprintf("%d of %d required peers connected", n, rc.MinPeers),
+		})
+	}
+	for _, cidr := range rc.Routes {
+		checks = append(checks, health.ReadinessCheck{
+			Name:   "route:" + cidr,
+			OK:     a.routeMgr.GetRoute(routing.MustParseCIDR(cidr)) != nil,
+			Detail: "no route in the routing table",
+		})
+	}
+	for _, domain := range rc.DomainRoutes {
+		checks = append(checks, health.ReadinessCheck{
+			Name:   "domain:" + domain,
+			OK:     a.routeMgr.LookupDomain(domain) != nil,
+			Detail: "no matching domain route",
+		})
+	}
+	for _, key := range rc.Forwards {
+		checks = append(checks, health.ReadinessCheck{
+			Name:   "forward:" + key,
+			OK:     a.routeMgr.LookupForward(key) != nil,
+			Detail: "no endpoint for the key",
+		})
+	}
+	return checks
+}
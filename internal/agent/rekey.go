@@ -0,0 +1,35 @@
+package agent
+
+import (
+	"github.com/postalsys/muti-metroo/internal/crypto"
+	"github.com/postalsys/muti-metroo/internal/protocol"
+)
+
+// rekeyConfig converts the e2e.rekey configuration. It is zero when
+// rekeying is disabled.
+func (a *Agent) rekeyConfig() crypto.RekeyConfig {
+	rk := a.cfg.E2E.Rekey
+	if !rk.Enabled {
+		return crypto.RekeyConfig{}
+	}
+	return crypto.RekeyConfig{Bytes: rk.Bytes, Interval: rk.Interval}
+}
+
+// e2eOpenFlags returns the E2E flags of the streams, UDP associations and
+// ICMP sessions this agent opens.
+func (a *Agent) e2eOpenFlags() uint8 {
+	if a.rekeyConfig().Enabled() {
+		return protocol.E2EFlagRekey
+	}
+	return 0
+}
+
+// enableRekey turns on rekeying of a session this agent opened when the
+// responder agreed to it in the acknowledgment. ephPriv is the private key
+// of the open request and remotePub the responder's ephemeral key.
+func (a *Agent) enableRekey(sk *crypto.SessionKey, ackFlags uint8, ephPriv, remotePub [crypto.KeySize]byte) {
+	cfg := a.rekeyConfig()
+	if cfg.Enabled() && ackFlags&protocol.E2EFlagRekey != 0 {
+		sk.EnableRekey(ephPriv, remotePub, cfg)
+	}
+}
@@ -7,8 +7,11 @@ package agent
 
 import (
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/postalsys/muti-metroo/internal/identity"
+	"github.com/postalsys/muti-metroo/internal/protocol"
 )
 
 // relayEntry tracks a stream/association/session being relayed through this
@@ -18,12 +21,47 @@ import (
 // Entries are immutable once inserted into a relayTable: callers may safely
 // dereference fields after a Lookup* method returns even if a concurrent
 // goroutine deletes the entry, because pointer values returned to callers
-// outlive the table's index entries.
+// outlive the table's index entries. The traffic counters are the only
+// mutable state and are updated atomically.
 type relayEntry struct {
 	UpstreamPeer   identity.AgentID
 	UpstreamID     uint64 // ID space of the upstream peer connection
 	DownstreamPeer identity.AgentID
-	DownstreamID   uint64 // ID space of the downstream peer connection (allocated locally)
+	DownstreamID   uint64             // ID space of the downstream peer connection (allocated locally)
+	DestAddr       string             // Destination from the relayed open request (informational)
+	OpenToken      protocol.OpenToken // Verified token of the relayed STREAM_OPEN (zero if none)
+	OpenAttempt    uint8              // Attempt proven for OpenToken
+	CreatedAt      time.Time
+
+	bytesUp      atomic.Uint64 // Payload bytes forwarded upstream -> downstream
+	bytesDown    atomic.Uint64 // Payload bytes forwarded downstream -> upstream
+	framesUp     atomic.Uint64 // Data frames forwarded upstream -> downstream
+	framesDown   atomic.Uint64 // Data frames forwarded downstream -> upstream
+	lastActivity atomic.Int64  // UnixNano of last forwarded data frame
+}
+
+// recordUpstream accounts a frame of n payload bytes forwarded from upstream
+// to downstream.
+func (e *relayEntry) recordUpstream(n int) {
+	e.bytesUp.Add(uint64(n))
+	e.framesUp.Add(1)
+	e.lastActivity.Store(time.Now().UnixNano())
+}
+
+// recordDownstream accounts a frame of n payload bytes forwarded from
+// downstream to upstream.
+func (e *relayEntry) recordDownstream(n int) {
+	e.bytesDown.Add(uint64(n))
+	e.framesDown.Add(1)
+	e.lastActivity.Store(time.Now().UnixNano())
+}
+
+// LastActivity returns the time data was last forwarded, or CreatedAt if none.
+func (e *relayEntry) LastActivity() time.Time {
+	if last := e.lastActivity.Load(); last != 0 {
+		return time.Unix(0, last)
+	}
+	return e.CreatedAt
 }
 
 // relayTable is a thread-safe bidirectional index of relay entries keyed
@@ -37,6 +75,12 @@ type relayTable struct {
 	mu           sync.RWMutex
 	byUpstream   map[uint64]*relayEntry
 	byDownstream map[uint64]*relayEntry
+	byToken      map[protocol.OpenToken]*relayEntry // Entries with an open token
+
+	// Optional hooks, called with mu held: they must not block or call
+	// back into the table.
+	onInsert func(*relayEntry)
+	onRemove func(*relayEntry)
 }
 
 // newRelayTable returns an empty relay table ready for use.
@@ -44,20 +88,89 @@ func newRelayTable() *relayTable {
 	return &relayTable{
 		byUpstream:   make(map[uint64]*relayEntry),
 		byDownstream: make(map[uint64]*relayEntry),
+		byToken:      make(map[protocol.OpenToken]*relayEntry),
 	}
 }
 
 // Insert adds an entry under both upstream and downstream keys.
+// CreatedAt is stamped if the caller left it unset.
 func (r *relayTable) Insert(e *relayEntry) {
+	if e.CreatedAt.IsZero() {
+		e.CreatedAt = time.Now()
+	}
 	r.mu.Lock()
 	r.byUpstream[e.UpstreamID] = e
 	r.byDownstream[e.DownstreamID] = e
+	if r.onInsert != nil {
+		r.onInsert(e)
+	}
 	r.mu.Unlock()
 }
 
+// InsertOpen adds the entry of a relayed STREAM_OPEN, deduplicating opens
+// by their open token. An open already relayed for the same upstream
+// stream is a duplicate: nothing is inserted and duplicate is true. An
+// entry holding the token at an earlier attempt for another upstream
+// stream belongs to an attempt the ingress gave up on and retried; it is
+// removed and returned so the caller can reset its streams. An entry at
+// the same or a later attempt keeps the token: the open is a late arrival
+// or a copy, nothing is inserted and refused is true. Entries without a
+// token are inserted as by Insert.
+func (r *relayTable) InsertOpen(e *relayEntry) (superseded *relayEntry, duplicate, refused bool) {
+	if e.OpenToken.IsZero() {
+		r.Insert(e)
+		return nil, false, false
+	}
+	if e.CreatedAt.IsZero() {
+		e.CreatedAt = time.Now()
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if prev := r.byToken[e.OpenToken]; prev != nil {
+		if prev.UpstreamPeer == e.UpstreamPeer && prev.UpstreamID == e.UpstreamID {
+			return nil, true, false
+		}
+		if e.OpenAttempt <= prev.OpenAttempt {
+			return nil, false, true
+		}
+		delete(r.byUpstream, prev.UpstreamID)
+		delete(r.byDownstream, prev.DownstreamID)
+		r.removed(prev)
+		superseded = prev
+	}
+	r.byUpstream[e.UpstreamID] = e
+	r.byDownstream[e.DownstreamID] = e
+	r.byToken[e.OpenToken] = e
+	if r.onInsert != nil {
+		r.onInsert(e)
+	}
+	return superseded, false, false
+}
+
+// setHooks sets the insert and remove hooks. Must be called before the
+// table is used.
+func (r *relayTable) setHooks(onInsert, onRemove func(*relayEntry)) {
+	r.onInsert = onInsert
+	r.onRemove = onRemove
+}
+
+// removed runs the remove hook for an entry taken out of the table and
+// drops its open token. Callers hold mu.
+func (r *relayTable) removed(e *relayEntry) {
+	if !e.OpenToken.IsZero() && r.byToken[e.OpenToken] == e {
+		delete(r.byToken, e.OpenToken)
+	}
+	if r.onRemove != nil {
+		r.onRemove(e)
+	}
+}
+
 // Delete removes the entry from both indices. Idempotent.
 func (r *relayTable) Delete(e *relayEntry) {
 	r.mu.Lock()
+	if r.byUpstream[e.UpstreamID] == e {
+		r.removed(e)
+	}
 	delete(r.byUpstream, e.UpstreamID)
 	delete(r.byDownstream, e.DownstreamID)
 	r.mu.Unlock()
@@ -98,6 +211,7 @@ func (r *relayTable) PopDownstreamFromPeer(streamID uint64, peer identity.AgentI
 	}
 	delete(r.byUpstream, e.UpstreamID)
 	delete(r.byDownstream, e.DownstreamID)
+	r.removed(e)
 	return e
 }
 
@@ -113,29 +227,68 @@ func (r *relayTable) PopMatchingPeer(streamID uint64, peer identity.AgentID) (en
 	if up := r.byUpstream[streamID]; up != nil && up.UpstreamPeer == peer {
 		delete(r.byUpstream, up.UpstreamID)
 		delete(r.byDownstream, up.DownstreamID)
+		r.removed(up)
 		return up, true
 	}
 	if down := r.byDownstream[streamID]; down != nil && down.DownstreamPeer == peer {
 		delete(r.byUpstream, down.UpstreamID)
 		delete(r.byDownstream, down.DownstreamID)
+		r.removed(down)
 		return down, false
 	}
 	return nil, false
 }
 
+// PopByID atomically removes and returns the entry whose upstream ID matches
+// streamID, falling back to the downstream index. Returns nil if neither
+// index has the ID. Used by operator-initiated kills, where the caller only
+// knows a stream ID and not which peer connection it belongs to.
+func (r *relayTable) PopByID(streamID uint64) *relayEntry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e := r.byUpstream[streamID]
+	if e == nil {
+		e = r.byDownstream[streamID]
+	}
+	if e == nil {
+		return nil
+	}
+	delete(r.byUpstream, e.UpstreamID)
+	delete(r.byDownstream, e.DownstreamID)
+	r.removed(e)
+	return e
+}
+
+// Snapshot returns all entries currently in the table.
+func (r *relayTable) Snapshot() []*relayEntry {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	entries := make([]*relayEntry, 0, len(r.byUpstream))
+	for _, e := range r.byUpstream {
+		entries = append(entries, e)
+	}
+	return entries
+}
+
 // DeleteByPeer removes every entry where either the upstream or downstream
-// peer is `peer`. Returns the number of entries removed. Used during peer
-// disconnect cleanup.
+// peer is `peer`. Returns the number of entries removed.
 func (r *relayTable) DeleteByPeer(peer identity.AgentID) int {
+	return len(r.PopByPeer(peer))
+}
+
+// PopByPeer removes and returns every entry where either the upstream or
+// downstream peer is `peer`. Used during peer disconnect cleanup.
+func (r *relayTable) PopByPeer(peer identity.AgentID) []*relayEntry {
 	r.mu.Lock()
 	defer r.mu.Unlock()
-	var n int
+	var entries []*relayEntry
 	for id, e := range r.byUpstream {
 		if e.UpstreamPeer == peer || e.DownstreamPeer == peer {
 			delete(r.byUpstream, id)
 			delete(r.byDownstream, e.DownstreamID)
-			n++
+			r.removed(e)
+			entries = append(entries, e)
 		}
 	}
-	return n
+	return entries
 }
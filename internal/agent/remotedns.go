@@ -0,0 +1,64 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/postalsys/muti-metroo/internal/config"
+	"github.com/postalsys/muti-metroo/internal/routing"
+)
+
+// defaultRouteNetworks are the default route networks, in order of preference.
+var defaultRouteNetworks = []*net.IPNet{
+	{IP: net.IPv4zero.To4(), Mask: net.CIDRMask(0, 32)},
+	{IP: net.IPv6zero, Mask: net.CIDRMask(0, 128)},
+}
+
+// hostResolver is the subset of net.Resolver used to resolve SOCKS5
+// destinations at the ingress.
+type hostResolver interface {
+	LookupIP(ctx context.Context, network, host string) ([]net.IP, error)
+}
+
+// remoteDNSPolicy returns the socks5.remote_dns setting, defaulting to auto.
+func (a *Agent) remoteDNSPolicy() string {
+	if a.cfg.SOCKS5.RemoteDNS == "" {
+		return config.RemoteDNSAuto
+	}
+	return a.cfg.SOCKS5.RemoteDNS
+}
+
+// defaultRoute returns the best default route of namespace ns, IPv4 before
+// IPv6, or nil.
+func (a *Agent) defaultRoute(ns string) *routing.Route {
+	for _, network := range defaultRouteNetworks {
+		if r := a.routeMgr.GetRouteIn(network, ns); r != nil {
+			return r
+		}
+	}
+	return nil
+}
+
+// dialViaDefaultRoute sends host to the exit of the default route for
+// resolution (remote_dns: always). The name is only resolved locally when this
+// agent is that exit; without a default route the dial fails rather than
+// falling back to local DNS.
+func (a *Agent) dialViaDefaultRoute(ctx context.Context, network, host string, port int, ns string) (net.Conn, error) {
+	route := a.defaultRoute(ns)
+	if route == nil {
+		return nil, fmt.Errorf("resolve %s: remote DNS requires a default route", host)
+	}
+
+	if route.OriginAgent == a.id {
+		dialer := &net.Dialer{Timeout: a.cfg.SOCKS5.ConnectTimeout}
+		return dialer.DialContext(ctx, network, net.JoinHostPort(host, strconv.Itoa(port)))
+	}
+
+	return a.dialViaDomainRouteWithContext(ctx, network, host, port, &routing.DomainRoute{
+		NextHop:     route.NextHop,
+		OriginAgent: route.OriginAgent,
+		Path:        route.Path,
+	})
+}
@@ -0,0 +1,265 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/postalsys/muti-metroo/internal/config"
+	"github.com/postalsys/muti-metroo/internal/health"
+	"github.com/postalsys/muti-metroo/internal/identity"
+	"github.com/postalsys/muti-metroo/internal/routing"
+	"github.com/postalsys/muti-metroo/internal/socks5"
+)
+
+// routeLookupResolveTimeout limits the DNS lookup of a route lookup. It
+// stays below the timeout of remote control requests.
+const routeLookupResolveTimeout = 10 * time.Second
+
+// LookupRoute reports the route, exit and policy decisions a connection to
+// req.Destination would get from this agent, following the same steps as
+// dialContext without opening a connection. Names are resolved when a dial
+// would resolve them here. Implements health.RouteLookupProvider.
+func (a *Agent) LookupRoute(req health.RouteLookupRequest) (*health.RouteLookupResult, error) {
+	host := req.Destination
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	if host == "" {
+		return nil, fmt.Errorf("destination is required")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), routeLookupResolveTimeout)
+	defer cancel()
+	if req.User != "" {
+		ctx = socks5.WithUser(ctx, req.User)
+	}
+
+	ns := a.streamNamespace(ctx)
+	l := &routeLookup{
+		agent: a,
+		result: &health.RouteLookupResult{
+			Agent:       a.routeWatchAgent(a.id),
+			Destination: host,
+			Namespace:   ns,
+			Match:       health.RouteLookupMatchNone,
+		},
+	}
+	if ns != "" {
+		l.decide("namespace %q: only exits of this namespace are used", ns)
+	}
+
+	if a.socks5Blocks != nil {
+		if m, blocked := a.socks5Blocks.Test(host); blocked {
+			return l.reject("blocked by SOCKS5 blocklist entry %s (%s)", m.Entry, m.Source), nil
+		}
+		l.decide("not on the SOCKS5 blocklist")
+	}
+
+	destIP := net.ParseIP(host)
+	if destIP == nil {
+		policy := a.remoteDNSPolicy()
+		l.decide("remote_dns: %s", policy)
+
+		var domainRoute *routing.DomainRoute
+		if policy != config.RemoteDNSLocal {
+			domainRoute = a.routeMgr.LookupDomainIn(host, ns)
+		}
+		if domainRoute != nil {
+			l.result.Match = health.RouteLookupMatchDomain
+			l.result.Route = domainRoute.Pattern
+			if domainRoute.OriginAgent == a.id {
+				l.decide("domain route %s is advertised by this agent", domainRoute.Pattern)
+				ips, ok := l.resolve(ctx, host)
+				if !ok {
+					return l.result, nil
+				}
+				return l.localExit(ctx, host, ips), nil
+			}
+			l.decide("domain route %s matched; the exit resolves the name", domainRoute.Pattern)
+			return l.mesh(&health.RouteLookupPath{
+				Exit:      a.routeWatchAgent(domainRoute.OriginAgent),
+				NextHop:   a.routeWatchAgent(domainRoute.NextHop),
+				Metric:    int(domainRoute.Metric),
+				Path:      l.agents(domainRoute.Path),
+				Connected: a.peerMgr.GetPeer(domainRoute.NextHop) != nil,
+			}, nil), nil
+		}
+
+		if policy == config.RemoteDNSAlways {
+			route := a.defaultRoute(ns)
+			if route == nil {
+				return l.reject("remote DNS requires a default route"), nil
+			}
+			l.result.Match = health.RouteLookupMatchDefault
+			l.result.Route = route.Network.String()
+			if route.OriginAgent == a.id {
+				l.decide("no domain route; this agent is the default route exit and resolves the name")
+				l.result.Action = health.RouteLookupLocalExit
+				return l.result, nil
+			}
+			l.decide("no domain route; the default route exit resolves the name")
+			return l.mesh(l.path(route), nil), nil
+		}
+
+		ips, ok := l.resolve(ctx, host)
+		if !ok {
+			return l.result, nil
+		}
+		destIP = ips[0]
+	}
+
+	route := a.routeMgr.LookupIn(destIP, ns)
+	if route == nil {
+		if !a.directAllowed(ctx, ns) {
+			return l.reject("no mesh route to %s and direct dials are not allowed for namespace %q", destIP, ns), nil
+		}
+		l.decide("no route matched %s; dialed directly from this agent", destIP)
+		l.result.Action = health.RouteLookupDirect
+		return l.result, nil
+	}
+
+	l.result.Match = health.RouteLookupMatchCIDR
+	l.result.Route = route.Network.String()
+	if route.OriginAgent == a.id {
+		l.decide("route %s is advertised by this agent", route.Network)
+		return l.localExit(ctx, host, []net.IP{destIP}), nil
+	}
+
+	if a.peerMgr.GetPeer(route.NextHop) == nil {
+		l.result.Selected = l.path(route)
+		if !a.directAllowed(ctx, ns) {
+			return l.reject("next hop %s of route %s is not connected", route.NextHop.ShortString(), route.Network), nil
+		}
+		l.decide("next hop %s of route %s is not connected; dialed directly from this agent", route.NextHop.ShortString(), route.Network)
+		l.result.Action = health.RouteLookupDirect
+		return l.result, nil
+	}
+
+	l.decide("route %s matched %s", route.Network, destIP)
+	var alternates []health.RouteLookupPath
+	for _, alt := range a.routeMgr.LookupPathsIn(destIP, ns) {
+		if alt.OriginAgent == route.OriginAgent && alt.NextHop == route.NextHop {
+			continue
+		}
+		if alt.OriginAgent == a.id {
+			continue
+		}
+		alternates = append(alternates, *l.path(alt))
+	}
+	return l.mesh(l.path(route), alternates), nil
+}
+
+// routeLookup collects the result of a route lookup.
+type routeLookup struct {
+	agent  *Agent
+	result *health.RouteLookupResult
+}
+
+// decide records a policy decision.
+func (l *routeLookup) decide(format string, args ...any) {
+	l.result.Decisions = append(l.result.Decisions, fmt.Sprintf(format, args...))
+}
+
+// reject records why the destination is refused.
+func (l *routeLookup) reject(format string, args ...any) *health.RouteLookupResult {
+	l.result.Action = health.RouteLookupReject
+	l.result.Reason = fmt.Sprintf(format, args...)
+	l.decide("rejected: %s", l.result.Reason)
+	return l.result
+}
+
+// resolve resolves host at this agent. Returns false after rejecting the
+// lookup when the name does not resolve.
+func (l *routeLookup) resolve(ctx context.Context, host string) ([]net.IP, bool) {
+	ips, err := net.DefaultResolver.LookupIP(ctx, "ip", host)
+	if err != nil {
+		l.reject("resolve %s: %v", host, err)
+		return nil, false
+	}
+	if len(ips) == 0 {
+		l.reject("no IP addresses for %s", host)
+		return nil, false
+	}
+	l.result.ResolvedIP = ips[0].String()
+	l.decide("resolved %s to %s at this agent", host, ips[0])
+	return ips, true
+}
+
+// localExit applies the exit checks of this agent to a destination it is
+// the exit for.
+func (l *routeLookup) localExit(ctx context.Context, host string, ips []net.IP) *health.RouteLookupResult {
+	a := l.agent
+	if a.cfg.Exit.BlockPrivate {
+		if ips = a.exitPrivateFilter().Filter(ips); len(ips) == 0 {
+			return l.reject("destination %s in private range not allowed (exit.block_private)", host)
+		}
+		l.decide("exit.block_private allows %s", ips[0])
+	}
+	if policy := a.exitUserPolicy(); policy.Enabled {
+		if ips = policy.Filter(socks5.UserFromContext(ctx), host, ips); len(ips) == 0 {
+			return l.reject("destination %s not allowed for user (exit.user_policy)", host)
+		}
+		l.decide("exit.user_policy allows %s", host)
+	}
+	l.result.Action = health.RouteLookupLocalExit
+	return l.result
+}
+
+// mesh records a stream opened through the mesh.
+func (l *routeLookup) mesh(selected *health.RouteLookupPath, alternates []health.RouteLookupPath) *health.RouteLookupResult {
+	l.result.Selected = selected
+	if !selected.Connected {
+		return l.reject("next hop %s is not connected", selected.NextHop.ShortID)
+	}
+	l.result.Action = health.RouteLookupMesh
+	l.result.Alternates = alternates
+	l.decide("exit %s applies its own exit policy", selected.Exit.ShortID)
+	return l.result
+}
+
+// path describes a CIDR route as a lookup path.
+func (l *routeLookup) path(r *routing.Route) *health.RouteLookupPath {
+	return &health.RouteLookupPath{
+		Exit:        l.agent.routeWatchAgent(r.OriginAgent),
+		NextHop:     l.agent.routeWatchAgent(r.NextHop),
+		Metric:      int(r.Metric),
+		Path:        l.agents(r.Path),
+		Connected:   l.agent.peerMgr.GetPeer(r.NextHop) != nil,
+		Unreachable: r.Unreachable,
+	}
+}
+
+// agents identifies the agents of a route path.
+func (l *routeLookup) agents(ids []identity.AgentID) []health.RouteExportAgent {
+	if len(ids) == 0 {
+		return nil
+	}
+	agents := make([]health.RouteExportAgent, len(ids))
+	for i, id := range ids {
+		agents[i] = l.agent.routeWatchAgent(id)
+	}
+	return agents
+}
+
+// handleRouteLookup processes a ControlTypeRouteLookup control request.
+func (a *Agent) handleRouteLookup(data []byte) ([]byte, bool) {
+	var req health.RouteLookupRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		resp, _ := json.Marshal(map[string]string{"error": "invalid request: " + err.Error()})
+		return resp, false
+	}
+
+	result, err := a.LookupRoute(req)
+	if err != nil {
+		resp, _ := json.Marshal(map[string]string{"error": err.Error()})
+		return resp, false
+	}
+
+	resp, _ := json.Marshal(result)
+	return resp, true
+}
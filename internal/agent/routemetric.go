@@ -0,0 +1,45 @@
+package agent
+
+import (
+	"time"
+
+	"github.com/postalsys/muti-metroo/internal/identity"
+	"github.com/postalsys/muti-metroo/internal/recovery"
+)
+
+// routeMetricInterval is how often peer RTTs are passed to the routing
+// table with routing.metric latency or hybrid. RTTs are measured by the
+// handshake and keepalives, so checking more often gains nothing.
+const routeMetricInterval = 10 * time.Second
+
+// routeMetricLoop feeds the measured RTT of connected peers into the
+// routing table, which reorders routes when an RTT changed significantly.
+func (a *Agent) routeMetricLoop() {
+	defer a.wg.Done()
+	defer recovery.RecoverWithLog(a.logger, "routeMetricLoop")
+
+	ticker := time.NewTicker(routeMetricInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-a.stopCh:
+			return
+		case <-ticker.C:
+			a.updateRouteMetrics()
+		}
+	}
+}
+
+// updateRouteMetrics passes the current peer RTTs to the routing table.
+func (a *Agent) updateRouteMetrics() {
+	rtts := make(map[identity.AgentID]time.Duration)
+	for _, p := range a.peerMgr.GetAllPeers() {
+		if rtt := p.RTT(); rtt > 0 {
+			rtts[p.RemoteID] = rtt
+		}
+	}
+	if n := a.routeMgr.UpdatePeerRTTs(rtts); n > 0 {
+		a.logger.Debug("routes reordered by peer latency", "prefixes", n)
+	}
+}
@@ -0,0 +1,256 @@
+package agent
+
+import (
+	"sync"
+	"time"
+
+	"github.com/postalsys/muti-metroo/internal/health"
+	"github.com/postalsys/muti-metroo/internal/identity"
+	"github.com/postalsys/muti-metroo/internal/peer"
+	"github.com/postalsys/muti-metroo/internal/recovery"
+	"github.com/postalsys/muti-metroo/internal/routing"
+)
+
+// routeWatchResync is how often the selected routes are compared with the
+// routing table even without route changes, catching route expiry, path
+// probe results and RTT metric updates.
+const routeWatchResync = 10 * time.Second
+
+const (
+	// convergenceQuiet is how long the route selection must stay unchanged
+	// after the last peer event or route diff to count as converged.
+	convergenceQuiet = 2 * time.Second
+
+	// convergenceMaxWait ends the convergence timer of a mesh that keeps
+	// changing.
+	convergenceMaxWait = time.Minute
+)
+
+// routeWatch tracks the route selected for every network, for route_diff
+// events and /api/routes/watch, and times how long the selection takes to
+// settle after peers connect or disconnect.
+type routeWatch struct {
+	mu   sync.Mutex
+	sel  *routing.Selection
+	seq  uint64       // Seq of the last diff
+	conv *convergence // Open convergence timer, nil when settled
+	kick chan struct{}
+}
+
+// convergence is an open convergence timer.
+type convergence struct {
+	triggers   []health.ConvergenceTrigger
+	changes    int
+	lastChange time.Time // Last route diff
+	lastEvent  time.Time // Last route diff or peer event
+}
+
+func newRouteWatch(m *routing.Manager) *routeWatch {
+	return &routeWatch{
+		sel:  routing.NewSelection(m),
+		kick: make(chan struct{}, 1),
+	}
+}
+
+// peerEvent starts or extends the convergence timer.
+func (w *routeWatch) peerEvent(eventType string, conn *peer.Connection) {
+	now := time.Now()
+	w.mu.Lock()
+	if w.conv == nil {
+		w.conv = &convergence{}
+	}
+	w.conv.triggers = append(w.conv.triggers, health.ConvergenceTrigger{
+		Event: eventType,
+		Peer: health.RouteExportAgent{
+			ID:          conn.RemoteID.String(),
+			ShortID:     conn.RemoteID.ShortString(),
+			DisplayName: conn.RemoteDisplayName,
+		},
+		Time: now,
+	})
+	w.conv.lastEvent = now
+	w.mu.Unlock()
+
+	select {
+	case w.kick <- struct{}{}:
+	default:
+	}
+}
+
+// record counts diffs towards the open convergence timer. Called with mu
+// held.
+func (w *routeWatch) record(n int, now time.Time) {
+	if w.conv == nil || n == 0 {
+		return
+	}
+	w.conv.changes += n
+	w.conv.lastChange = now
+	w.conv.lastEvent = now
+}
+
+// settleIn returns how long until the open convergence timer is due.
+func (w *routeWatch) settleIn(now time.Time) (time.Duration, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.conv == nil {
+		return 0, false
+	}
+	due := w.conv.lastEvent.Add(convergenceQuiet)
+	if limit := w.conv.triggers[0].Time.Add(convergenceMaxWait); limit.Before(due) {
+		due = limit
+	}
+	return due.Sub(now), true
+}
+
+// settle closes the convergence timer if it is due.
+func (w *routeWatch) settle(now time.Time) (health.ConvergenceEvent, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	c := w.conv
+	if c == nil {
+		return health.ConvergenceEvent{}, false
+	}
+	quiet := now.Sub(c.lastEvent) >= convergenceQuiet
+	if !quiet && now.Sub(c.triggers[0].Time) < convergenceMaxWait {
+		return health.ConvergenceEvent{}, false
+	}
+	w.conv = nil
+
+	ev := health.ConvergenceEvent{
+		Triggers: c.triggers,
+		Changes:  c.changes,
+		Settled:  quiet,
+	}
+	if c.changes > 0 {
+		ev.DurationMs = c.lastChange.Sub(c.triggers[0].Time).Milliseconds()
+	}
+	return ev, true
+}
+
+// routeWatchLoop publishes route_diff events when the route selected for a
+// network changes, and convergence events once the selection settles
+// after peer events.
+func (a *Agent) routeWatchLoop() {
+	defer a.wg.Done()
+	defer recovery.RecoverWithLog(a.logger, "routeWatchLoop")
+
+	w := a.routeWatch
+	changes := make(chan routing.RouteChange, routeEventBuffer)
+	a.routeMgr.Subscribe(changes)
+	defer a.routeMgr.Unsubscribe(changes)
+
+	// Pick up changes made before the subscription
+	a.publishRouteDiffs(func(s *routing.Selection) []routing.RouteDiff {
+		return s.Resync()
+	})
+
+	resync := time.NewTicker(routeWatchResync)
+	defer resync.Stop()
+	settle := time.NewTimer(convergenceQuiet)
+	settle.Stop()
+	defer settle.Stop()
+
+	for {
+		select {
+		case <-a.stopCh:
+			return
+		case change := <-changes:
+			if change.Route == nil || change.Route.Network == nil {
+				continue
+			}
+			a.publishRouteDiffs(func(s *routing.Selection) []routing.RouteDiff {
+				if d := s.Update(change.Route.Network, change.Reason); d != nil {
+					return []routing.RouteDiff{*d}
+				}
+				return nil
+			})
+		case <-resync.C:
+			a.publishRouteDiffs(func(s *routing.Selection) []routing.RouteDiff {
+				return s.Resync()
+			})
+		case <-w.kick:
+		case <-settle.C:
+			if ev, ok := w.settle(time.Now()); ok {
+				a.logger.Debug("route selection converged",
+					"changes", ev.Changes,
+					"duration_ms", ev.DurationMs,
+					"settled", ev.Settled)
+				if a.eventsWanted() {
+					a.healthServer.PublishEvent(health.EventConvergence, ev)
+				}
+			}
+		}
+
+		if d, ok := w.settleIn(time.Now()); ok {
+			settle.Reset(max(d, 0))
+		}
+	}
+}
+
+// publishRouteDiffs applies update to the selection and publishes the
+// resulting diffs as route_diff events.
+func (a *Agent) publishRouteDiffs(update func(*routing.Selection) []routing.RouteDiff) {
+	w := a.routeWatch
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	diffs := update(w.sel)
+	w.record(len(diffs), time.Now())
+	for _, d := range diffs {
+		w.seq++
+		if !a.eventsWanted() {
+			continue
+		}
+		a.healthServer.PublishEvent(health.EventRouteDiff, health.RouteDiffEvent{
+			Seq:      w.seq,
+			Kind:     d.Kind,
+			Network:  d.Network.String(),
+			Route:    a.selectedRoute(d.Route),
+			Previous: a.selectedRoute(d.Previous),
+			Reason:   d.Reason,
+		})
+	}
+}
+
+// SelectedRoutes implements health.RouteWatchProvider.
+func (a *Agent) SelectedRoutes() ([]health.SelectedRoute, uint64) {
+	w := a.routeWatch
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	routes := w.sel.Routes()
+	result := make([]health.SelectedRoute, 0, len(routes))
+	for _, r := range routes {
+		result = append(result, *a.selectedRoute(r))
+	}
+	return result, w.seq
+}
+
+// selectedRoute describes a selected route for the route watch.
+func (a *Agent) selectedRoute(r *routing.Route) *health.SelectedRoute {
+	if r == nil {
+		return nil
+	}
+	sr := &health.SelectedRoute{
+		Network:     r.Network.String(),
+		Origin:      a.routeWatchAgent(r.OriginAgent),
+		Metric:      int(r.Metric),
+		HopCount:    len(r.Path),
+		Local:       r.OriginAgent == a.id,
+		Unreachable: r.Unreachable,
+	}
+	if !sr.Local && !r.NextHop.IsZero() && r.NextHop != a.id {
+		nextHop := a.routeWatchAgent(r.NextHop)
+		sr.NextHop = &nextHop
+	}
+	return sr
+}
+
+// routeWatchAgent identifies an agent in route watch messages.
+func (a *Agent) routeWatchAgent(id identity.AgentID) health.RouteExportAgent {
+	name := a.routeMgr.GetDisplayName(id)
+	if id == a.id {
+		name = a.DisplayName()
+	}
+	return health.RouteExportAgent{ID: id.String(), ShortID: id.ShortString(), DisplayName: name}
+}
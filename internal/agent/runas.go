@@ -0,0 +1,73 @@
+package agent
+
+import (
+	"os"
+	"runtime"
+
+	"github.com/postalsys/muti-metroo/internal/logging"
+	"github.com/postalsys/muti-metroo/internal/privdrop"
+)
+
+// runAsCapabilities returns the capabilities kept after agent.run_as: the
+// configured list, or else the ones enabled features need on Linux.
+func (a *Agent) runAsCapabilities() []string {
+	if caps := a.cfg.Agent.RunAs.Capabilities; caps != nil {
+		return caps
+	}
+	if runtime.GOOS != "linux" {
+		return nil
+	}
+
+	var caps []string
+	// Raw ICMP sockets, and SO_BINDTODEVICE for exit source binds
+	bindsInterface := a.cfg.Exit.Enabled && a.cfg.Exit.BindInterface != ""
+	for _, rb := range a.cfg.Exit.RouteBinds {
+		if a.cfg.Exit.Enabled && rb.BindInterface != "" {
+			bindsInterface = true
+		}
+	}
+	if a.cfg.ICMP.Enabled || bindsInterface {
+		caps = append(caps, "net_raw")
+	}
+	if a.cfg.Routing.KernelRoutes.Enabled {
+		caps = append(caps, "net_admin")
+	}
+	return caps
+}
+
+// dropPrivileges switches to agent.run_as once listeners are bound. Sockets
+// opened as root stay usable; anything opened later needs the new user's
+// permissions or a kept capability.
+func (a *Agent) dropPrivileges() error {
+	runAs := a.cfg.Agent.RunAs
+	if runAs.User == "" {
+		return nil
+	}
+
+	caps := a.runAsCapabilities()
+	if err := privdrop.Drop(privdrop.Config{
+		User:         runAs.User,
+		Group:        runAs.Group,
+		Capabilities: caps,
+	}); err != nil {
+		return err
+	}
+	a.logger.Info("dropped privileges",
+		"user", runAs.User,
+		"group", runAs.Group,
+		"capabilities", caps)
+
+	// State written later (identity, quotas, usage) must still be writable
+	if dir := a.cfg.Agent.DataDir; dir != "" {
+		f, err := os.CreateTemp(dir, ".run-as-*")
+		if err != nil {
+			a.logger.Warn("data directory is not writable after dropping privileges",
+				"path", dir,
+				logging.KeyError, err)
+		} else {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}
+	return nil
+}
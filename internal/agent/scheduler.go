@@ -0,0 +1,170 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/postalsys/muti-metroo/internal/health"
+	"github.com/postalsys/muti-metroo/internal/logging"
+	"github.com/postalsys/muti-metroo/internal/scheduler"
+	"github.com/postalsys/muti-metroo/internal/shell"
+)
+
+// startScheduler creates the task scheduler and loads saved tasks.
+func (a *Agent) startScheduler() error {
+	c := a.cfg.Scheduler
+	s, err := scheduler.New(scheduler.Config{
+		DataDir:        a.dataDir,
+		MaxTasks:       c.MaxTasks,
+		HistorySize:    c.HistorySize,
+		MaxOutput:      c.MaxOutput,
+		DefaultTimeout: c.DefaultTimeout,
+		Authorize:      a.authorizeTask,
+		Prepare:        a.shellExecutor.PrepareCommand,
+		Sandbox:        a.shellSandboxProfile(),
+		Logger:         a.logger.With(logging.KeyComponent, "scheduler"),
+	})
+	if err != nil {
+		return err
+	}
+	a.taskScheduler = s
+	s.Start()
+
+	a.logger.Info("task scheduler started",
+		"tasks", len(s.List()),
+		"max_tasks", c.MaxTasks)
+	return nil
+}
+
+// authorizeTask checks a task against the shell and file transfer policy.
+// It runs when a task is added and before every run, so tightening the
+// configuration also stops tasks that were already installed.
+func (a *Agent) authorizeTask(task *scheduler.Task) error {
+	switch task.Type {
+	case scheduler.TypeShell:
+		if !a.cfg.Shell.Enabled {
+			return fmt.Errorf("shell is not enabled on this agent")
+		}
+		return a.shellExecutor.CheckPolicy(&shell.ShellMeta{
+			Command: task.Command,
+			Args:    task.Args,
+		})
+	case scheduler.TypeFileSync:
+		if err := a.fileStreamHandler.CheckPath(task.Source); err != nil {
+			return fmt.Errorf("source: %w", err)
+		}
+		if err := a.fileStreamHandler.CheckPath(task.Dest); err != nil {
+			return fmt.Errorf("dest: %w", err)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown task type %q", task.Type)
+	}
+}
+
+// authenticateTask checks the password for installing a task: the shell
+// password for shell tasks, the file transfer password for file syncs.
+func (a *Agent) authenticateTask(task *scheduler.Task, password string) error {
+	if task.Type == scheduler.TypeFileSync {
+		return a.fileStreamHandler.Authenticate(password)
+	}
+	return a.shellExecutor.ValidateAuth(password)
+}
+
+// ManageSchedule installs, removes, lists and runs scheduled tasks.
+// Implements health.ScheduleManageProvider.
+func (a *Agent) ManageSchedule(req health.ScheduleManageRequest) (*health.ScheduleManageResult, error) {
+	s := a.taskScheduler
+	if s == nil {
+		return nil, fmt.Errorf("scheduler not enabled (scheduler.enabled)")
+	}
+
+	switch req.Action {
+	case "add":
+		if req.Task == nil {
+			return nil, fmt.Errorf("task is required")
+		}
+		if err := a.authenticateTask(req.Task, req.Password); err != nil {
+			return nil, err
+		}
+		task, err := s.Add(*req.Task)
+		if err != nil {
+			return nil, err
+		}
+		a.logger.Info("scheduled task added",
+			"task", task.ID,
+			"type", task.Type,
+			"schedule", task.Schedule)
+		return &health.ScheduleManageResult{
+			Status:  "ok",
+			Message: fmt.Sprintf("added task %s", task.ID),
+			Task:    task,
+		}, nil
+
+	case "remove", "run", "enable", "disable":
+		if req.ID == "" {
+			return nil, fmt.Errorf("id is required")
+		}
+		var err error
+		switch req.Action {
+		case "remove":
+			err = s.Remove(req.ID)
+		case "run":
+			err = s.RunNow(req.ID)
+		case "enable":
+			err = s.SetDisabled(req.ID, false)
+		case "disable":
+			err = s.SetDisabled(req.ID, true)
+		}
+		if err != nil {
+			return nil, err
+		}
+		a.logger.Info("scheduled task updated",
+			"task", req.ID,
+			"action", req.Action)
+		return &health.ScheduleManageResult{
+			Status:  "ok",
+			Message: fmt.Sprintf("%s %s", req.Action, req.ID),
+		}, nil
+
+	case "list":
+		return &health.ScheduleManageResult{
+			Status: "ok",
+			Tasks:  s.List(),
+		}, nil
+
+	case "history":
+		if req.ID == "" {
+			return nil, fmt.Errorf("id is required")
+		}
+		runs, err := s.History(req.ID)
+		if err != nil {
+			return nil, err
+		}
+		return &health.ScheduleManageResult{
+			Status:  "ok",
+			History: runs,
+		}, nil
+
+	default:
+		return nil, fmt.Errorf("unknown action %q (expected add, remove, enable, disable, list, history or run)", req.Action)
+	}
+}
+
+// handleScheduleManage processes a ControlTypeScheduleManage control request.
+func (a *Agent) handleScheduleManage(data []byte) ([]byte, bool) {
+	var req health.ScheduleManageRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		resp, _ := json.Marshal(map[string]string{"error": "invalid request: " + err.Error()})
+		return resp, false
+	}
+
+	result, err := a.ManageSchedule(req)
+	if err != nil {
+		resp, _ := json.Marshal(map[string]string{"error": err.Error()})
+		return resp, false
+	}
+
+	resp, _ := json.Marshal(result)
+	return resp, true
+}
@@ -0,0 +1,24 @@
+package agent
+
+import "github.com/postalsys/muti-metroo/internal/sandbox"
+
+// shellSandboxProfile converts shell.sandbox into the profile applied to
+// shell commands, or nil when the sandbox is disabled.
+func (a *Agent) shellSandboxProfile() *sandbox.Profile {
+	sb := a.cfg.Shell.Sandbox
+	if !sb.Enabled {
+		return nil
+	}
+	return &sandbox.Profile{
+		ReadOnly:  sb.ReadOnly,
+		ReadWrite: sb.ReadWrite,
+		Seccomp:   sb.Seccomp == nil || *sb.Seccomp,
+		Limits: sandbox.Limits{
+			MaxMemory:    uint64(sb.MaxMemory),
+			MaxProcesses: uint64(sb.MaxProcesses),
+			MaxOpenFiles: uint64(sb.MaxOpenFiles),
+			MaxFileSize:  uint64(sb.MaxFileSize),
+			CPUTime:      sb.CPUTime,
+		},
+	}
+}
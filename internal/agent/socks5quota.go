@@ -0,0 +1,118 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/postalsys/muti-metroo/internal/health"
+	"github.com/postalsys/muti-metroo/internal/logging"
+	"github.com/postalsys/muti-metroo/internal/socks5"
+)
+
+// socks5QuotaSaveInterval is how often changed SOCKS5 user usage is written
+// to the data directory. Usage is also saved on shutdown and after a reset.
+const socks5QuotaSaveInterval = 30 * time.Second
+
+// initSOCKS5Quotas creates the per-user usage tracker when SOCKS5
+// authentication is enabled, with the expiry and quotas of configured users.
+func (a *Agent) initSOCKS5Quotas() error {
+	if !a.cfg.SOCKS5.Auth.Enabled {
+		return nil
+	}
+
+	limits := make(map[string]socks5.UserLimits)
+	for _, u := range a.cfg.SOCKS5.Auth.Users {
+		if u.ExpiresAt.IsZero() && u.QuotaBytes == 0 && u.QuotaConnections == 0 {
+			continue
+		}
+		limits[u.Username] = socks5.UserLimits{
+			ExpiresAt:        u.ExpiresAt,
+			QuotaBytes:       u.QuotaBytes,
+			QuotaConnections: u.QuotaConnections,
+		}
+	}
+
+	quotas, err := socks5.NewUserQuotas(socks5.QuotaConfig{
+		Limits:  limits,
+		DataDir: a.dataDir,
+		Logger:  a.logger.With(logging.KeyComponent, "socks5-quota"),
+	})
+	if err != nil {
+		return err
+	}
+	a.socks5Quotas = quotas
+	return nil
+}
+
+// startSOCKS5QuotaSaveLoop periodically persists SOCKS5 user usage.
+func (a *Agent) startSOCKS5QuotaSaveLoop() {
+	ticker := time.NewTicker(socks5QuotaSaveInterval)
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+		defer ticker.Stop()
+		for {
+			select {
+			case <-a.stopCh:
+				return
+			case <-ticker.C:
+				a.socks5Quotas.Save()
+			}
+		}
+	}()
+}
+
+// ManageSOCKS5Users lists SOCKS5 user expiry and quota usage or resets
+// usage. Implements health.SOCKS5UsersManageProvider.
+func (a *Agent) ManageSOCKS5Users(req health.SOCKS5UsersManageRequest) (*health.SOCKS5UsersManageResult, error) {
+	if a.socks5Quotas == nil {
+		return nil, fmt.Errorf("SOCKS5 authentication is not enabled on this agent")
+	}
+
+	switch req.Action {
+	case "list":
+		return &health.SOCKS5UsersManageResult{
+			Status: "ok",
+			Users:  a.socks5Quotas.Status(),
+		}, nil
+
+	case "reset":
+		n, err := a.socks5Quotas.Reset(req.Username)
+		if err != nil {
+			return nil, err
+		}
+		target := req.Username
+		if target == "" {
+			target = "all users"
+		}
+		a.logger.Info("SOCKS5 user usage reset via API", "user", target)
+		return &health.SOCKS5UsersManageResult{
+			Status:  "ok",
+			Message: fmt.Sprintf("reset usage of %s", target),
+			Reset:   n,
+		}, nil
+
+	default:
+		return nil, fmt.Errorf("unknown action %q (expected list or reset)", req.Action)
+	}
+}
+
+// handleSOCKS5UsersManage processes a ControlTypeSOCKS5UsersManage control
+// request.
+func (a *Agent) handleSOCKS5UsersManage(data []byte) ([]byte, bool) {
+	var req health.SOCKS5UsersManageRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		resp, _ := json.Marshal(map[string]string{"error": "invalid request: " + err.Error()})
+		return resp, false
+	}
+
+	result, err := a.ManageSOCKS5Users(req)
+	if err != nil {
+		resp, _ := json.Marshal(map[string]string{"error": err.Error()})
+		return resp, false
+	}
+
+	resp, _ := json.Marshal(result)
+	return resp, true
+}
@@ -0,0 +1,119 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/postalsys/muti-metroo/internal/logging"
+	"github.com/postalsys/muti-metroo/internal/protocol"
+	"github.com/postalsys/muti-metroo/internal/routing"
+	"github.com/postalsys/muti-metroo/internal/socks5"
+)
+
+// streamOpenAttempts is the most paths one mesh dial tries, including the
+// best route.
+const streamOpenAttempts = 3
+
+// streamOpenError is a failed stream open with the error code reported by
+// the agent that rejected it.
+type streamOpenError struct {
+	code uint16
+	err  error
+}
+
+func (e *streamOpenError) Error() string { return e.err.Error() }
+
+func (e *streamOpenError) Unwrap() error { return e.err }
+
+// Reply maps the error code to the SOCKS5 reply sent to the client, so a
+// refused or unreachable destination is not reported as a general failure.
+func (e *streamOpenError) Reply() byte {
+	switch e.code {
+	case protocol.ErrConnectionRefused:
+		return socks5.ReplyConnectionRefused
+	case protocol.ErrHostUnreachable, protocol.ErrDNSError:
+		return socks5.ReplyHostUnreachable
+	case protocol.ErrNoRoute, protocol.ErrNetworkUnreachable:
+		return socks5.ReplyNetworkUnreachable
+	case protocol.ErrConnectionTimeout, protocol.ErrTTLExceeded:
+		return socks5.ReplyTTLExpired
+	case protocol.ErrNotAllowed, protocol.ErrExitDisabled, protocol.ErrPrivateDestination:
+		return socks5.ReplyNotAllowed
+	}
+	return socks5.ReplyServerFailure
+}
+
+// retryableOpenError reports whether a failed stream open may succeed over
+// another path. Path failures (a relay that cannot reach its next hop, an
+// exceeded hop limit, an exit that does not allow the destination) are
+// retried. Errors from the destination itself, such as a refused
+// connection, and timeouts that already used the dial budget are not.
+func retryableOpenError(err error) bool {
+	var openErr *streamOpenError
+	if !errors.As(err, &openErr) {
+		return false
+	}
+	switch openErr.code {
+	case protocol.ErrNoRoute,
+		protocol.ErrHostUnreachable,
+		protocol.ErrNetworkUnreachable,
+		protocol.ErrTTLExceeded,
+		protocol.ErrExitDisabled,
+		protocol.ErrNotAllowed,
+		protocol.ErrPrivateDestination:
+		return true
+	}
+	return false
+}
+
+// dialIPWithRetry dials destIP:port along route. When the open fails on the
+// way to the destination, it retries over the next-best paths to destIP
+// (another next hop or another exit) until one succeeds, a non-retryable
+// error is returned, streamOpenAttempts paths were tried or the stream open
+// timeout has passed. Every attempt carries the same open token with the
+// proof of a later attempt, so a relay or exit still working on an
+// abandoned attempt drops it when the retry arrives instead of connecting
+// the destination twice.
+func (a *Agent) dialIPWithRetry(ctx context.Context, host string, destIP net.IP, port int, route *routing.Route, ns string) (net.Conn, error) {
+	deadline := time.Now().Add(a.cfg.Limits.StreamOpenTimeout)
+	ctx = withOpenChain(ctx, protocol.NewOpenChain())
+
+	conn, err := a.dialIPViaPath(ctx, host, destIP, port, route.NextHop, route.Path)
+	if err == nil || !retryableOpenError(err) {
+		return conn, err
+	}
+
+	tried := map[routing.PathKey]bool{
+		{Origin: route.OriginAgent, NextHop: route.NextHop}: true,
+	}
+	for _, alt := range a.routeMgr.LookupPathsIn(destIP, ns) {
+		if len(tried) >= streamOpenAttempts || ctx.Err() != nil || time.Now().After(deadline) {
+			break
+		}
+		key := routing.PathKey{Origin: alt.OriginAgent, NextHop: alt.NextHop}
+		if tried[key] || alt.OriginAgent == a.id || a.peerMgr.GetPeer(alt.NextHop) == nil {
+			continue
+		}
+		tried[key] = true
+
+		a.logger.Debug("retrying stream open over alternate path",
+			logging.KeyAddress, net.JoinHostPort(destIP.String(), strconv.Itoa(port)),
+			logging.KeyAgentID, alt.OriginAgent.ShortString(),
+			logging.KeyPeerID, alt.NextHop.ShortString(),
+			logging.KeyError, err)
+
+		var retryErr error
+		conn, retryErr = a.dialIPViaPath(ctx, host, destIP, port, alt.NextHop, alt.Path)
+		if retryErr == nil {
+			return conn, nil
+		}
+		err = retryErr
+		if !retryableOpenError(err) {
+			break
+		}
+	}
+	return nil, err
+}
@@ -0,0 +1,196 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/postalsys/muti-metroo/internal/exit"
+	"github.com/postalsys/muti-metroo/internal/health"
+	"github.com/postalsys/muti-metroo/internal/identity"
+	"github.com/postalsys/muti-metroo/internal/logging"
+	"github.com/postalsys/muti-metroo/internal/protocol"
+	"github.com/postalsys/muti-metroo/internal/stream"
+)
+
+// formatStreamDest formats a stream destination for display. Special
+// destinations (forward keys, file transfer, shell) carry no port.
+func formatStreamDest(host string, port uint16) string {
+	if port == 0 {
+		return host
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(port)))
+}
+
+// ListStreams returns all active outbound, exit, and relay streams.
+// Implements health.StreamProvider.
+func (a *Agent) ListStreams() []health.StreamInfo {
+	now := time.Now()
+	var streams []health.StreamInfo
+
+	for _, s := range a.streamMgr.GetAllStreams() {
+		streams = append(streams, outboundStreamInfo(s, now))
+	}
+
+	if a.exitHandler != nil {
+		for _, ac := range a.exitHandler.Connections() {
+			streams = append(streams, exitStreamInfo(ac, now))
+		}
+	}
+
+	for _, e := range a.tcpRelay.Snapshot() {
+		streams = append(streams, relayStreamInfo(e, now))
+	}
+
+	sort.Slice(streams, func(i, j int) bool {
+		if streams[i].Direction != streams[j].Direction {
+			return streams[i].Direction < streams[j].Direction
+		}
+		return streams[i].ID < streams[j].ID
+	})
+	return streams
+}
+
+// outboundStreamInfo describes a stream opened by this agent.
+func outboundStreamInfo(s *stream.Stream, now time.Time) health.StreamInfo {
+	return health.StreamInfo{
+		ID:             s.ID,
+		Direction:      health.StreamDirectionOutbound,
+		DownstreamPeer: s.RemoteID.ShortString(),
+		Destination:    formatStreamDest(s.DestAddr, s.DestPort),
+		User:           s.User,
+		State:          s.State().String(),
+		BytesSent:      s.BytesSent.Load(),
+		BytesRecv:      s.BytesRecv.Load(),
+		FramesSent:     s.FramesSent.Load(),
+		FramesRecv:     s.FramesRecv.Load(),
+		ConnectMs:      health.DurationMs(s.ConnectLatency()),
+		FirstByteMs:    health.DurationMs(s.FirstByteLatency()),
+		AgeMs:          now.Sub(s.CreatedAt).Milliseconds(),
+		IdleMs:         now.Sub(s.LastActivity()).Milliseconds(),
+	}
+}
+
+// exitStreamInfo describes a stream terminated by this agent.
+func exitStreamInfo(ac *exit.ActiveConnection, now time.Time) health.StreamInfo {
+	return health.StreamInfo{
+		ID:            ac.StreamID,
+		Direction:     health.StreamDirectionExit,
+		UpstreamPeer:  ac.RemoteID.ShortString(),
+		Destination:   formatStreamDest(ac.DestAddr, ac.DestPort),
+		AddressFamily: ac.Family,
+		DialedAddr:    ac.DialedAddr,
+		User:          ac.User,
+		Client:        ac.Client,
+		HalfClosed:    ac.HalfClosed(),
+		BytesSent:     ac.BytesSent.Load(),
+		BytesRecv:     ac.BytesRecv.Load(),
+		FramesSent:    ac.FramesSent.Load(),
+		FramesRecv:    ac.FramesRecv.Load(),
+		ConnectMs:     health.DurationMs(ac.ConnectLatency),
+		FirstByteMs:   health.DurationMs(ac.FirstByteLatency()),
+		AgeMs:         now.Sub(ac.StartedAt).Milliseconds(),
+		IdleMs:        now.Sub(ac.LastActivity()).Milliseconds(),
+	}
+}
+
+// relayStreamInfo describes a stream forwarded through this agent.
+func relayStreamInfo(e *relayEntry, now time.Time) health.StreamInfo {
+	return health.StreamInfo{
+		ID:             e.UpstreamID,
+		Direction:      health.StreamDirectionRelay,
+		UpstreamPeer:   e.UpstreamPeer.ShortString(),
+		DownstreamPeer: e.DownstreamPeer.ShortString(),
+		DownstreamID:   e.DownstreamID,
+		Destination:    e.DestAddr,
+		BytesSent:      e.bytesUp.Load(),
+		BytesRecv:      e.bytesDown.Load(),
+		FramesSent:     e.framesUp.Load(),
+		FramesRecv:     e.framesDown.Load(),
+		AgeMs:          now.Sub(e.CreatedAt).Milliseconds(),
+		IdleMs:         now.Sub(e.LastActivity()).Milliseconds(),
+	}
+}
+
+// getLocalStreams returns the stream table for a STREAMS control request.
+// A control response holds one frame, so the busiest streams are kept and
+// the rest are dropped with Truncated set.
+func (a *Agent) getLocalStreams() ([]byte, bool) {
+	streams := a.ListStreams()
+	if streams == nil {
+		streams = []health.StreamInfo{}
+	}
+	sort.SliceStable(streams, func(i, j int) bool {
+		return streams[i].BytesSent+streams[i].BytesRecv > streams[j].BytesSent+streams[j].BytesRecv
+	})
+
+	resp := health.StreamsResponse{Streams: streams}
+	for {
+		data, err := json.Marshal(resp)
+		if err != nil {
+			return []byte(err.Error()), false
+		}
+		if len(data) <= protocol.MaxControlResponseData || len(resp.Streams) == 0 {
+			return data, true
+		}
+		resp.Streams = resp.Streams[:len(resp.Streams)*3/4]
+		resp.Truncated = true
+	}
+}
+
+// KillStream resets a stream by ID. Outbound streams are checked first, then
+// exit connections, then relay entries (matched on either the upstream or
+// downstream ID). STREAM_RESET is sent to every adjacent peer of the stream.
+// Implements health.StreamProvider.
+func (a *Agent) KillStream(streamID uint64) error {
+	for _, direction := range []string{health.StreamDirectionOutbound, health.StreamDirectionExit, health.StreamDirectionRelay} {
+		if a.resetStream(direction, streamID) {
+			a.logStreamKill(streamID, direction)
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %d", health.ErrStreamNotFound, streamID)
+}
+
+// resetStream resets the stream with the given ID and direction, sending
+// STREAM_RESET to its adjacent peer(s). Returns false if there is no such
+// stream.
+func (a *Agent) resetStream(direction string, streamID uint64) bool {
+	switch direction {
+	case health.StreamDirectionOutbound:
+		if s := a.streamMgr.GetStream(streamID); s != nil {
+			a.sendStreamReset(s.RemoteID, streamID)
+			a.streamMgr.HandleStreamReset(streamID, protocol.ErrGeneralFailure)
+			return true
+		}
+	case health.StreamDirectionExit:
+		if a.exitHandler != nil {
+			if ac := a.exitHandler.AbortConnection(streamID); ac != nil {
+				a.sendStreamReset(ac.RemoteID, streamID)
+				return true
+			}
+		}
+	case health.StreamDirectionRelay:
+		if e := a.tcpRelay.PopByID(streamID); e != nil {
+			a.sendStreamReset(e.UpstreamPeer, e.UpstreamID)
+			a.sendStreamReset(e.DownstreamPeer, e.DownstreamID)
+			return true
+		}
+	}
+	return false
+}
+
+// sendStreamReset sends an operator-initiated STREAM_RESET to a peer.
+func (a *Agent) sendStreamReset(peerID identity.AgentID, streamID uint64) {
+	a.WriteStreamReset(peerID, streamID, protocol.ErrGeneralFailure)
+}
+
+// logStreamKill logs an operator-initiated stream kill.
+func (a *Agent) logStreamKill(streamID uint64, direction string) {
+	a.logger.Info("stream killed via API",
+		logging.KeyStreamID, streamID,
+		"direction", direction)
+}
@@ -0,0 +1,47 @@
+package agent
+
+import (
+	"encoding/json"
+
+	"github.com/postalsys/muti-metroo/internal/protocol"
+	"github.com/postalsys/muti-metroo/internal/sysinfo"
+)
+
+// SystemMetrics returns a reading of the host's resource usage.
+// Implements health.SystemMetricsProvider.
+func (a *Agent) SystemMetrics() *sysinfo.Metrics {
+	return a.sysMetrics.Sample()
+}
+
+// getLocalSystemMetrics returns the host metrics for remote queries.
+// Interfaces without traffic are left out when the response does not fit a
+// control response, then interfaces and disks are cut from the end.
+func (a *Agent) getLocalSystemMetrics() ([]byte, bool) {
+	m := a.SystemMetrics()
+	for {
+		data, err := json.Marshal(m)
+		if err != nil {
+			return []byte(err.Error()), false
+		}
+		if len(data) <= protocol.MaxControlResponseData || (len(m.Interfaces) == 0 && len(m.Disks) == 0) {
+			return data, true
+		}
+		if active := activeInterfaces(m.Interfaces); len(active) < len(m.Interfaces) {
+			m.Interfaces = active
+			continue
+		}
+		m.Interfaces = m.Interfaces[:len(m.Interfaces)/2]
+		m.Disks = m.Disks[:len(m.Disks)*3/4]
+	}
+}
+
+// activeInterfaces returns the interfaces that have carried traffic.
+func activeInterfaces(ifs []sysinfo.InterfaceMetrics) []sysinfo.InterfaceMetrics {
+	active := ifs[:0:0]
+	for _, im := range ifs {
+		if im.RxBytes > 0 || im.TxBytes > 0 {
+			active = append(active, im)
+		}
+	}
+	return active
+}
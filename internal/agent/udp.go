@@ -4,19 +4,23 @@ import (
 	"context"
 	cryptorand "crypto/rand"
 	"encoding/binary"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net"
 	"sync"
 	"time"
 
+	"github.com/postalsys/muti-metroo/internal/config"
 	"github.com/postalsys/muti-metroo/internal/crypto"
+	"github.com/postalsys/muti-metroo/internal/health"
 	"github.com/postalsys/muti-metroo/internal/identity"
 	"github.com/postalsys/muti-metroo/internal/logging"
 	"github.com/postalsys/muti-metroo/internal/peer"
 	"github.com/postalsys/muti-metroo/internal/protocol"
 	"github.com/postalsys/muti-metroo/internal/routing"
 	"github.com/postalsys/muti-metroo/internal/socks5"
+	"github.com/postalsys/muti-metroo/internal/tunnel"
 	"github.com/postalsys/muti-metroo/internal/udp"
 )
 
@@ -50,11 +54,18 @@ type udpDestLookup struct {
 	Dest    *udpDestAssociation
 }
 
-// udpIngressAssociation tracks a SOCKS5 UDP association that may route to multiple exits.
-// This is the ingress-side tracking (SOCKS5 client -> mesh).
+// udpClient receives the datagrams returned by exits for an ingress
+// association: a SOCKS5 UDP association or a UDP tunnel session.
+type udpClient interface {
+	WriteToClient(addrType byte, addr []byte, port uint16, data []byte) error
+}
+
+// udpIngressAssociation tracks a SOCKS5 UDP association or UDP tunnel session
+// that may route to multiple exits.
+// This is the ingress-side tracking (client -> mesh).
 type udpIngressAssociation struct {
 	BaseStreamID uint64
-	SOCKS5Assoc  *socks5.UDPAssociation
+	Client       udpClient
 
 	// Per-destination associations keyed by route.OriginAgent.String()
 	destAssocs map[string]*udpDestAssociation
@@ -78,38 +89,55 @@ func generateUDPRequestID() uint64 {
 // Called when a SOCKS5 client requests UDP ASSOCIATE.
 // This creates a lazy association container - actual mesh paths are created on first datagram.
 func (a *Agent) CreateUDPAssociation(ctx context.Context, clientAddr *net.UDPAddr) (uint64, error) {
+	if a.streamNamespace(ctx) != "" {
+		return 0, errNamespaceDatagrams
+	}
+
 	// Verify at least one route exists (sanity check)
-	if a.routeMgr.Lookup(net.IPv4zero) == nil {
+	if a.routeMgr.LookupIn(net.IPv4zero, "") == nil {
 		return 0, ErrUDPNoRoute
 	}
 
-	baseStreamID := a.udpNextBaseID.Add(1)
+	assoc := a.newUDPIngressAssociation(nil)
+
+	a.logger.Debug("UDP association created (lazy)",
+		logging.KeyStreamID, assoc.BaseStreamID)
+
+	return assoc.BaseStreamID, nil
+}
+
+// OpenUDPSession implements tunnel.Relay.
+// Unlike CreateUDPAssociation it needs no default route: each datagram is
+// routed by the tunnel target, and fails when no exit covers it.
+func (a *Agent) OpenUDPSession(client tunnel.ReplyWriter) (uint64, error) {
+	return a.newUDPIngressAssociation(client).BaseStreamID, nil
+}
 
+// newUDPIngressAssociation registers an ingress association without mesh
+// paths. Paths to exits are created on demand by RelayUDPDatagram.
+func (a *Agent) newUDPIngressAssociation(client udpClient) *udpIngressAssociation {
 	assoc := &udpIngressAssociation{
-		BaseStreamID: baseStreamID,
+		BaseStreamID: a.udpNextBaseID.Add(1),
+		Client:       client,
 		destAssocs:   make(map[string]*udpDestAssociation),
 		idleTimeout:  5 * time.Minute,
 	}
 
 	a.udpIngressMu.Lock()
-	a.udpIngressByBase[baseStreamID] = assoc
+	a.udpIngressByBase[assoc.BaseStreamID] = assoc
 	a.udpIngressMu.Unlock()
 
-	a.logger.Debug("UDP association created (lazy)",
-		logging.KeyStreamID, baseStreamID)
-
-	return baseStreamID, nil
+	return assoc
 }
 
-// getOrCreateDestAssociation finds or creates a destination-specific UDP association.
-// It performs route lookup based on actual destination IP and creates mesh paths on demand.
+// getOrCreateDestAssociation finds or creates the UDP association to the exit
+// of route, creating mesh paths on demand.
 func (a *Agent) getOrCreateDestAssociation(
 	ctx context.Context,
 	ingress *udpIngressAssociation,
-	destIP net.IP,
+	route *routing.Route,
 ) (*udpDestAssociation, error) {
-	// 1. Route lookup
-	route := a.routeMgr.Lookup(destIP)
+	// 1. Route check
 	if route == nil {
 		return nil, ErrUDPNoRoute
 	}
@@ -239,9 +267,10 @@ func (a *Agent) createDestAssociation(
 		AddressType:     protocol.AddrTypeIPv4,
 		Address:         net.IPv4zero.To4(),
 		Port:            0,
-		TTL:             uint8(len(rPath)),
+		TTL:             a.hopLimit(),
 		RemainingPath:   remainingPath,
 		EphemeralPubKey: ephPub,
+		E2EFlags:        a.e2eOpenFlags(),
 	}
 
 	frame := &protocol.Frame{
@@ -320,21 +349,24 @@ func (a *Agent) RelayUDPDatagram(streamID uint64, destAddr net.Addr, destPort ui
 	}
 	a.udpIngressMu.RUnlock()
 
-	// Extract destination IP for routing
-	var destIP net.IP
+	// Find the route by destination IP
+	var route *routing.Route
 	switch addrType {
-	case protocol.AddrTypeIPv4:
-		destIP = net.IP(rawAddr)
-	case protocol.AddrTypeIPv6:
-		destIP = net.IP(rawAddr)
+	case protocol.AddrTypeIPv4, protocol.AddrTypeIPv6:
+		route = a.routeMgr.LookupIn(net.IP(rawAddr), "")
 	case protocol.AddrTypeDomain:
+		if a.remoteDNSPolicy() == config.RemoteDNSAlways {
+			// The default route exit resolves the domain
+			route = a.defaultRoute("")
+			break
+		}
 		// For domain, resolve at ingress for routing decision
 		domain := string(rawAddr[1:]) // Skip length byte
 		ips, err := net.LookupIP(domain)
 		if err != nil || len(ips) == 0 {
 			return fmt.Errorf("DNS lookup failed: %s", domain)
 		}
-		destIP = ips[0]
+		route = a.routeMgr.LookupIn(ips[0], "")
 	default:
 		return fmt.Errorf("unsupported address type: %d", addrType)
 	}
@@ -343,7 +375,7 @@ func (a *Agent) RelayUDPDatagram(streamID uint64, destAddr net.Addr, destPort ui
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
-	dest, err := a.getOrCreateDestAssociation(ctx, ingress, destIP)
+	dest, err := a.getOrCreateDestAssociation(ctx, ingress, route)
 	if err != nil {
 		return err
 	}
@@ -398,7 +430,7 @@ func (a *Agent) CloseUDPAssociation(streamID uint64) {
 	// Close all destination associations
 	ingress.destMu.Lock()
 	for _, dest := range ingress.destAssocs {
-		a.closeDestAssociation(dest)
+		a.closeDestAssociation(dest, protocol.UDPCloseNormal)
 
 		a.udpIngressMu.Lock()
 		delete(a.udpIngressByLocalStream, dest.StreamID)
@@ -412,8 +444,8 @@ func (a *Agent) CloseUDPAssociation(streamID uint64) {
 }
 
 // closeDestAssociation sends UDP_CLOSE to the exit and cleans up.
-func (a *Agent) closeDestAssociation(dest *udpDestAssociation) {
-	closeFrame := &protocol.UDPClose{Reason: protocol.UDPCloseNormal}
+func (a *Agent) closeDestAssociation(dest *udpDestAssociation, reason uint8) {
+	closeFrame := &protocol.UDPClose{Reason: reason}
 	frame := &protocol.Frame{
 		Type:     protocol.FrameUDPClose,
 		StreamID: dest.StreamID,
@@ -424,6 +456,29 @@ func (a *Agent) closeDestAssociation(dest *udpDestAssociation) {
 	a.peerMgr.SendToPeer(dest.NextHop, frame)
 }
 
+// dropDestAssociation tears down a destination association whose datagram
+// failed E2E validation. The next datagram to that exit opens a fresh one.
+func (a *Agent) dropDestAssociation(ingress *udpIngressAssociation, dest *udpDestAssociation, err error) {
+	a.udpIngressMu.Lock()
+	_, ok := a.udpIngressByLocalStream[dest.StreamID]
+	delete(a.udpIngressByLocalStream, dest.StreamID)
+	a.udpIngressMu.Unlock()
+	if !ok {
+		return // Already dropped by a concurrent datagram
+	}
+
+	ingress.destMu.Lock()
+	if ingress.destAssocs[dest.OriginKey] == dest {
+		delete(ingress.destAssocs, dest.OriginKey)
+	}
+	ingress.destMu.Unlock()
+
+	a.closeDestAssociation(dest, protocol.UDPCloseE2EValidation)
+	a.logger.Warn("UDP datagram failed E2E validation, association closed",
+		logging.KeyStreamID, dest.StreamID,
+		logging.KeyError, err)
+}
+
 // IsUDPEnabled implements socks5.UDPAssociationHandler.
 // Returns true if this agent can relay UDP traffic.
 func (a *Agent) IsUDPEnabled() bool {
@@ -473,12 +528,23 @@ func (a *Agent) handleUDPOpen(peerID identity.AgentID, frame *protocol.Frame) {
 			a.sendUDPOpenErr(peerID, frame.StreamID, open.RequestID, protocol.ErrUDPDisabled, "UDP relay disabled")
 			return
 		}
+		if a.cfg.Agent.Namespace != "" {
+			a.sendUDPOpenErr(peerID, frame.StreamID, open.RequestID, protocol.ErrNotAllowed, errNamespaceDatagrams.Error())
+			return
+		}
 
 		ctx := context.Background()
 		a.udpHandler.HandleUDPOpen(ctx, peerID, frame.StreamID, open, open.EphemeralPubKey)
 		return
 	}
 
+	// Enforce the hop limit before relaying
+	ttl, ok := nextHopTTL(open.TTL)
+	if !ok {
+		a.sendUDPOpenErr(peerID, frame.StreamID, open.RequestID, protocol.ErrTTLExceeded, hopLimitMessage)
+		return
+	}
+
 	// Relay to next hop
 	nextHop := open.RemainingPath[0]
 
@@ -509,9 +575,10 @@ func (a *Agent) handleUDPOpen(peerID identity.AgentID, frame *protocol.Frame) {
 		AddressType:     open.AddressType,
 		Address:         open.Address,
 		Port:            open.Port,
-		TTL:             open.TTL,
+		TTL:             ttl,
 		RemainingPath:   newPath,
 		EphemeralPubKey: open.EphemeralPubKey,
+		E2EFlags:        open.E2EFlags,
 	}
 
 	fwdFrame := &protocol.Frame{
@@ -585,12 +652,14 @@ func (a *Agent) handleUDPOpenAck(peerID identity.AgentID, frame *protocol.Frame)
 			return
 		}
 
-		// Zero out private key immediately
-		crypto.ZeroKey(&dest.EphemeralPrivKey)
-
 		// Derive session key (we are initiator, so isResponder=false)
 		sessionKey := crypto.DeriveSessionKey(sharedSecret, ack.RequestID, dest.EphemeralPubKey, ack.EphemeralPubKey, true)
+		sessionKey.EnableReplayWindow() // Datagrams may be lost or reordered
 		crypto.ZeroKey(&sharedSecret)
+		a.enableRekey(sessionKey, ack.E2EFlags, dest.EphemeralPrivKey, ack.EphemeralPubKey)
+
+		// Zero out private key now that the session holds what it needs
+		crypto.ZeroKey(&dest.EphemeralPrivKey)
 
 		// Store session key
 		dest.mu.Lock()
@@ -686,6 +755,7 @@ func (a *Agent) handleUDPDatagram(peerID identity.AgentID, frame *protocol.Frame
 		if sessionKey != nil {
 			plaintext, err = sessionKey.Decrypt(datagram.Data)
 			if err != nil {
+				a.dropDestAssociation(ingress, dest, err)
 				return
 			}
 		} else {
@@ -697,13 +767,13 @@ func (a *Agent) handleUDPDatagram(peerID identity.AgentID, frame *protocol.Frame
 		dest.LastActivity = time.Now()
 		dest.mu.Unlock()
 
-		// Forward to SOCKS5 client
+		// Forward to the SOCKS5 client or tunnel session
 		ingress.mu.RLock()
-		socks5Assoc := ingress.SOCKS5Assoc
+		client := ingress.Client
 		ingress.mu.RUnlock()
 
-		if socks5Assoc != nil {
-			socks5Assoc.WriteToClient(datagram.AddressType, datagram.Address, datagram.Port, plaintext)
+		if client != nil {
+			client.WriteToClient(datagram.AddressType, datagram.Address, datagram.Port, plaintext)
 		}
 		return
 	}
@@ -855,7 +925,7 @@ func (a *Agent) SetSOCKS5UDPAssociation(streamID uint64, socks5Assoc *socks5.UDP
 
 	if assoc := a.udpIngressByBase[streamID]; assoc != nil {
 		assoc.mu.Lock()
-		assoc.SOCKS5Assoc = socks5Assoc
+		assoc.Client = socks5Assoc
 		assoc.mu.Unlock()
 	}
 }
@@ -929,13 +999,56 @@ func (a *Agent) cleanupIdleDestAssociations() {
 		// By this point the dest is unreachable via both indices, so no
 		// concurrent goroutine can race a datagram against the close.
 		for _, c := range candidates {
-			a.closeDestAssociation(c.dest)
+			a.closeDestAssociation(c.dest, protocol.UDPCloseNormal)
 			a.logger.Debug("Cleaned up idle UDP destination association",
 				logging.KeyStreamID, c.streamID)
 		}
 	}
 }
 
+// UDPAssociations returns statistics for the UDP associations terminated at
+// this exit node. Implements health.UDPProvider.
+func (a *Agent) UDPAssociations() health.UDPAssociationsResponse {
+	resp := health.UDPAssociationsResponse{
+		Enabled:      a.udpHandler != nil,
+		Associations: []health.UDPAssociationInfo{},
+	}
+	if a.udpHandler == nil {
+		return resp
+	}
+
+	resp.Filtering = a.udpHandler.Filtering().String()
+	now := time.Now()
+	for _, st := range a.udpHandler.Stats() {
+		resp.Associations = append(resp.Associations, health.UDPAssociationInfo{
+			StreamID:     st.StreamID,
+			RequestID:    st.RequestID,
+			Peer:         st.PeerID.ShortString(),
+			State:        st.State.String(),
+			RelayAddr:    st.RelayAddr,
+			DatagramsOut: st.DatagramsOut,
+			DatagramsIn:  st.DatagramsIn,
+			BytesOut:     st.BytesOut,
+			BytesIn:      st.BytesIn,
+			Endpoints:    st.Endpoints,
+			Filtered:     st.Filtered,
+			AgeMs:        now.Sub(st.CreatedAt).Milliseconds(),
+			IdleMs:       now.Sub(st.LastActivity).Milliseconds(),
+		})
+	}
+	return resp
+}
+
+// getLocalUDPStats returns UDP association statistics for a
+// ControlTypeUDPStats control request.
+func (a *Agent) getLocalUDPStats() ([]byte, bool) {
+	data, err := json.Marshal(a.UDPAssociations())
+	if err != nil {
+		return []byte(err.Error()), false
+	}
+	return data, true
+}
+
 // Compile-time interface verification
 var _ udp.DataWriter = (*Agent)(nil)
 var _ socks5.UDPAssociationHandler = (*Agent)(nil)
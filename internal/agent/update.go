@@ -0,0 +1,158 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"github.com/postalsys/muti-metroo/internal/crypto"
+	"github.com/postalsys/muti-metroo/internal/health"
+	"github.com/postalsys/muti-metroo/internal/selfupdate"
+	"github.com/postalsys/muti-metroo/internal/sysinfo"
+)
+
+// updateRestartDelay gives the apply response time to reach the caller
+// before the agent restarts.
+const updateRestartDelay = time.Second
+
+// prepareUpdateStaging creates the staging directory for update binaries
+// and returns the path the new binary is uploaded to. The path keeps the
+// executable's base name so the staged binary runs on every platform.
+func (a *Agent) prepareUpdateStaging() (string, error) {
+	exe, err := selfupdate.Executable()
+	if err != nil {
+		return "", fmt.Errorf("locate executable: %w", err)
+	}
+	dir, err := filepath.Abs(filepath.Join(a.dataDir, "update"))
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, filepath.Base(exe)), nil
+}
+
+// ManageUpdate reports update status and applies an uploaded binary.
+// Implements health.UpdateManageProvider.
+func (a *Agent) ManageUpdate(req health.UpdateManageRequest) (*health.UpdateManageResult, error) {
+	if !a.cfg.Update.Enabled || a.updateStagingPath == "" {
+		return nil, fmt.Errorf("update not enabled (update.enabled)")
+	}
+
+	switch req.Action {
+	case "status":
+		exe, _ := selfupdate.Executable()
+		return &health.UpdateManageResult{
+			Status:            "ok",
+			Version:           sysinfo.Version,
+			OS:                runtime.GOOS,
+			Arch:              runtime.GOARCH,
+			Executable:        exe,
+			StagingPath:       a.updateStagingPath,
+			SignatureRequired: a.cfg.Update.RequireSignature,
+		}, nil
+
+	case "apply":
+		return a.applyUpdate(req)
+
+	default:
+		return nil, fmt.Errorf("unknown action %q (expected status or apply)", req.Action)
+	}
+}
+
+// applyUpdate verifies the staged binary, installs it and schedules the
+// restart. Everything that can fail is done before responding, so an error
+// leaves the running agent untouched.
+func (a *Agent) applyUpdate(req health.UpdateManageRequest) (*health.UpdateManageResult, error) {
+	if err := a.fileStreamHandler.Authenticate(req.Password); err != nil {
+		return nil, err
+	}
+
+	var publicKey *[crypto.Ed25519PublicKeySize]byte
+	if a.cfg.Update.RequireSignature || (req.Signature != "" && a.cfg.HasSigningKey()) {
+		key, err := a.cfg.GetSigningPublicKey()
+		if err != nil {
+			return nil, err
+		}
+		publicKey = &key
+	}
+	staged := a.updateStagingPath
+	manifest := selfupdate.Manifest{
+		SHA256:  req.SHA256,
+		Version: req.Version,
+		OS:      runtime.GOOS,
+		Arch:    runtime.GOARCH,
+		Force:   req.Force,
+	}
+	newVersion, err := selfupdate.Check(context.Background(), staged, manifest, req.Signature, publicKey, sysinfo.Version)
+	if err != nil {
+		return nil, err
+	}
+
+	target, err := selfupdate.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("locate executable: %w", err)
+	}
+	opts := selfupdate.RestartOptions{
+		Staged:      staged,
+		Target:      target,
+		ServiceName: a.cfg.Update.ServiceName,
+	}
+	method, err := selfupdate.Prepare(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	a.logger.Info("update installed, restarting",
+		"previous_version", sysinfo.Version,
+		"new_version", newVersion,
+		"method", method)
+
+	go func() {
+		time.Sleep(updateRestartDelay)
+		stopped := false
+		opts.Stop = func() {
+			stopped = true
+			a.Stop()
+		}
+		if err := selfupdate.Restart(method, opts); err != nil {
+			a.logger.Error("update restart failed", "method", method, "error", err)
+			if stopped {
+				// The agent is already down; exit so a service manager
+				// restarts it from the installed binary.
+				os.Exit(1)
+			}
+		}
+	}()
+
+	return &health.UpdateManageResult{
+		Status:          "ok",
+		Message:         fmt.Sprintf("updating to %s", newVersion),
+		PreviousVersion: sysinfo.Version,
+		NewVersion:      newVersion,
+		Method:          method,
+	}, nil
+}
+
+// handleUpdateManage processes a ControlTypeUpdateManage control request.
+func (a *Agent) handleUpdateManage(data []byte) ([]byte, bool) {
+	var req health.UpdateManageRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		resp, _ := json.Marshal(map[string]string{"error": "invalid request: " + err.Error()})
+		return resp, false
+	}
+
+	result, err := a.ManageUpdate(req)
+	if err != nil {
+		resp, _ := json.Marshal(map[string]string{"error": err.Error()})
+		return resp, false
+	}
+
+	resp, _ := json.Marshal(result)
+	return resp, true
+}
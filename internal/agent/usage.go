@@ -0,0 +1,180 @@
+package agent
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/postalsys/muti-metroo/internal/exit"
+	"github.com/postalsys/muti-metroo/internal/health"
+	"github.com/postalsys/muti-metroo/internal/logging"
+	"github.com/postalsys/muti-metroo/internal/peer"
+	"github.com/postalsys/muti-metroo/internal/recovery"
+	"github.com/postalsys/muti-metroo/internal/usage"
+)
+
+// usageSampler turns the cumulative byte counters of peer links and exit
+// connections into ledger increments. Sources are sampled on every
+// checkpoint and once more when they close.
+type usageSampler struct {
+	round   sync.Mutex // Serializes sampling rounds over the live lists
+	mu      sync.Mutex
+	samples map[any]*usageSample // *peer.Connection or *exit.ActiveConnection
+}
+
+// usageSample is the last seen counters of a source.
+type usageSample struct {
+	in, out uint64
+
+	// done is set by the final sample. The entry is kept until the source
+	// is gone from the live lists, so a checkpoint that listed the source
+	// before it closed does not count it again.
+	done bool
+}
+
+// take returns the bytes of src since its previous sample.
+func (s *usageSampler) take(src any, in, out uint64, final bool) (uint64, uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	prev, ok := s.samples[src]
+	if !ok {
+		prev = &usageSample{}
+		s.samples[src] = prev
+	}
+	if prev.done || in < prev.in || out < prev.out {
+		return 0, 0
+	}
+	dIn, dOut := in-prev.in, out-prev.out
+	prev.in, prev.out = in, out
+	prev.done = final
+	return dIn, dOut
+}
+
+// prune drops finished sources that are no longer live.
+func (s *usageSampler) prune(live map[any]bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for src, sample := range s.samples {
+		if sample.done && !live[src] {
+			delete(s.samples, src)
+		}
+	}
+}
+
+// initUsage creates the usage ledger when usage accounting is enabled.
+func (a *Agent) initUsage() error {
+	cfg := a.cfg.Usage
+	if !cfg.Enabled {
+		return nil
+	}
+
+	ledger, err := usage.New(usage.Config{
+		DataDir:         a.dataDir,
+		RetainMonths:    cfg.RetainMonths,
+		MaxDestinations: cfg.MaxDestinations,
+		Logger:          a.logger.With(logging.KeyComponent, "usage"),
+	})
+	if err != nil {
+		return err
+	}
+	a.usageLedger = ledger
+	a.usageSamples = &usageSampler{samples: make(map[any]*usageSample)}
+	return nil
+}
+
+// startUsageCheckpointLoop samples peer links and exit connections and
+// saves the ledger every checkpoint interval.
+func (a *Agent) startUsageCheckpointLoop() {
+	interval := a.cfg.Usage.CheckpointInterval
+	if interval <= 0 {
+		interval = time.Minute
+	}
+
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+		defer recovery.RecoverWithLog(a.logger, "usageCheckpointLoop")
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-a.stopCh:
+				return
+			case <-ticker.C:
+				a.checkpointUsage()
+			}
+		}
+	}()
+}
+
+// checkpointUsage samples usage and saves the ledger.
+func (a *Agent) checkpointUsage() {
+	a.sampleUsage()
+	a.usageLedger.Save()
+}
+
+// sampleUsage records traffic of live peer links and exit connections since
+// their previous sample.
+func (a *Agent) sampleUsage() {
+	a.usageSamples.round.Lock()
+	defer a.usageSamples.round.Unlock()
+
+	live := make(map[any]bool)
+	if a.peerMgr != nil {
+		for _, conn := range a.peerMgr.GetAllPeers() {
+			live[conn] = true
+			a.recordPeerUsage(conn, false)
+		}
+	}
+	if a.exitHandler != nil {
+		for _, ac := range a.exitHandler.Connections() {
+			live[ac] = true
+			a.recordExitUsage(ac, false)
+		}
+	}
+	a.usageSamples.prune(live)
+}
+
+// recordPeerUsage records traffic over a peer link. In is received from
+// the peer, out is sent to it.
+func (a *Agent) recordPeerUsage(conn *peer.Connection, final bool) {
+	if a.usageLedger == nil {
+		return
+	}
+	in, out := a.usageSamples.take(conn, conn.BytesReceived(), conn.BytesSent(), final)
+	a.usageLedger.AddPeer(conn.RemoteID.String(), in, out)
+}
+
+// recordExitUsage records traffic of an exit connection under its
+// destination bucket. In is received from the destination, out is sent to
+// it. Bytes are counted as encrypted on the mesh side.
+func (a *Agent) recordExitUsage(ac *exit.ActiveConnection, final bool) {
+	if a.usageLedger == nil {
+		return
+	}
+	in, out := a.usageSamples.take(ac, ac.BytesSent.Load(), ac.BytesRecv.Load(), final)
+	a.usageLedger.AddDestination(ac.DestAddr, in, out)
+}
+
+// Usage returns the usage of the current and retained months, including
+// traffic since the last checkpoint. Implements health.UsageProvider.
+func (a *Agent) Usage() health.UsageResponse {
+	if a.usageLedger == nil {
+		return health.UsageResponse{}
+	}
+	a.sampleUsage()
+	report := a.usageLedger.Report()
+	return health.UsageResponse{Enabled: true, Report: &report}
+}
+
+// getLocalUsage returns the local usage report for remote queries.
+func (a *Agent) getLocalUsage() ([]byte, bool) {
+	data, err := json.Marshal(a.Usage())
+	if err != nil {
+		return []byte(err.Error()), false
+	}
+	return data, true
+}
@@ -0,0 +1,151 @@
+package agent
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/postalsys/muti-metroo/internal/logging"
+	"github.com/postalsys/muti-metroo/internal/peer"
+	"github.com/postalsys/muti-metroo/internal/recovery"
+	"github.com/postalsys/muti-metroo/internal/selfupdate"
+	"github.com/postalsys/muti-metroo/internal/watchdog"
+)
+
+// initWatchdog creates the resource watchdog when it is enabled. Snapshots
+// are saved in data_dir/watchdog; without a data directory the watchdog
+// only logs.
+func (a *Agent) initWatchdog() {
+	cfg := a.cfg.Watchdog
+	if !cfg.Enabled {
+		return
+	}
+
+	snapshotDir := ""
+	if a.dataDir != "" {
+		snapshotDir = filepath.Join(a.dataDir, watchdog.DirName)
+	} else {
+		a.logger.Info("watchdog snapshots disabled: no data_dir")
+	}
+
+	maxHeap := uint64(0)
+	if cfg.MaxHeap > 0 {
+		maxHeap = uint64(cfg.MaxHeap)
+	}
+	a.watchdog = watchdog.New(watchdog.Config{
+		MaxGoroutines:     cfg.MaxGoroutines,
+		MaxHeap:           maxHeap,
+		StuckWriteTimeout: cfg.StuckWriteTimeout,
+		SnapshotDir:       snapshotDir,
+		SnapshotCooldown:  cfg.SnapshotCooldown,
+		MaxSnapshots:      cfg.MaxSnapshots,
+		Restart:           cfg.Restart,
+		RestartAfter:      cfg.RestartAfter,
+		RestartCooldown:   cfg.RestartCooldown,
+		Logger:            a.logger.With(logging.KeyComponent, "watchdog"),
+	})
+}
+
+// startWatchdogLoop runs a watchdog check every interval.
+func (a *Agent) startWatchdogLoop() {
+	interval := a.cfg.Watchdog.Interval
+	if interval <= 0 {
+		interval = watchdog.DefaultInterval
+	}
+
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+		defer recovery.RecoverWithLog(a.logger, "watchdogLoop")
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-a.stopCh:
+				return
+			case <-ticker.C:
+				a.checkWatchdog()
+			}
+		}
+	}()
+}
+
+// checkWatchdog checks the agent's resources and peer writes, recycles
+// stuck peer connections and restarts the agent when configured to.
+func (a *Agent) checkWatchdog() {
+	var writes []watchdog.PeerWrite
+	conns := make(map[string]*peer.Connection)
+	if a.peerMgr != nil {
+		for _, conn := range a.peerMgr.GetAllPeers() {
+			stalled, waiting := conn.WriteStall()
+			if stalled == 0 {
+				continue
+			}
+			id := conn.RemoteID.ShortString()
+			conns[id] = conn
+			writes = append(writes, watchdog.PeerWrite{ID: id, Stalled: stalled, Waiting: waiting})
+		}
+	}
+
+	result := a.watchdog.Check(writes)
+
+	if a.cfg.Watchdog.RecycleStuckPeers {
+		for _, stuck := range result.StuckPeers {
+			conn := conns[stuck.ID]
+			a.logger.Warn("watchdog recycling stuck peer connection",
+				logging.KeyPeerID, stuck.ID,
+				"stalled", stuck.Stalled.Round(time.Second))
+			if err := a.peerMgr.Disconnect(conn.RemoteID); err != nil {
+				// Replaced or gone since the check; close this link anyway
+				conn.Close()
+			}
+		}
+	}
+
+	if result.Restart {
+		a.restartFromWatchdog(strings.Join(result.Breaches, ","))
+	}
+}
+
+// restartFromWatchdog stops the agent and starts it again from the same
+// binary, the way self-update restarts it. Only one restart runs at a time.
+func (a *Agent) restartFromWatchdog(reason string) {
+	if !a.restarting.CompareAndSwap(false, true) {
+		return
+	}
+
+	target, err := selfupdate.Executable()
+	if err != nil {
+		a.logger.Error("watchdog restart failed", logging.KeyError, err)
+		a.restarting.Store(false)
+		return
+	}
+	method := selfupdate.RestartMethod()
+	a.logger.Error("watchdog restarting agent",
+		"reason", reason,
+		"method", method)
+
+	// Stop waits for the watchdog loop, so restart from outside it
+	go func() {
+		stopped := false
+		opts := selfupdate.RestartOptions{
+			Target:      target,
+			ServiceName: a.cfg.Update.ServiceName,
+			Stop: func() {
+				stopped = true
+				a.Stop()
+			},
+		}
+		if err := selfupdate.Restart(method, opts); err != nil {
+			a.logger.Error("watchdog restart failed", "method", method, logging.KeyError, err)
+			if stopped {
+				// The agent is already down; exit so a service manager
+				// starts it again.
+				os.Exit(1)
+			}
+			a.restarting.Store(false)
+		}
+	}()
+}
@@ -42,6 +42,10 @@ type CertOptions struct {
 	// Organization for the certificate subject.
 	Organization string
 
+	// OrganizationalUnit for the certificate subject (optional). Agents map
+	// it to a role with rbac.peer_roles.
+	OrganizationalUnit string
+
 	// ValidFor is the certificate validity duration.
 	ValidFor time.Duration
 
@@ -191,6 +195,9 @@ func GenerateCert(opts CertOptions) (*GeneratedCert, error) {
 		DNSNames:              opts.DNSNames,
 		IPAddresses:           opts.IPAddresses,
 	}
+	if opts.OrganizationalUnit != "" {
+		template.Subject.OrganizationalUnit = []string{opts.OrganizationalUnit}
+	}
 
 	// Set key usage based on certificate type
 	switch opts.CertType {
@@ -348,6 +355,22 @@ func Fingerprint(cert *x509.Certificate) string {
 	return "sha256:" + hex.EncodeToString(hash[:])
 }
 
+// NormalizeFingerprint converts a SHA256 certificate fingerprint into the
+// canonical "sha256:<lowercase hex>" form returned by Fingerprint.
+// Accepts an optional "sha256:" prefix, colon separators, and uppercase hex.
+func NormalizeFingerprint(fp string) (string, error) {
+	s := strings.ToLower(strings.TrimSpace(fp))
+	s = strings.TrimPrefix(s, "sha256:")
+	s = strings.ReplaceAll(s, ":", "")
+	if len(s) != sha256.Size*2 {
+		return "", fmt.Errorf("fingerprint must be %d hex characters, got %d", sha256.Size*2, len(s))
+	}
+	if _, err := hex.DecodeString(s); err != nil {
+		return "", fmt.Errorf("invalid fingerprint hex: %w", err)
+	}
+	return "sha256:" + s, nil
+}
+
 // FingerprintFromPEM calculates the fingerprint from PEM-encoded certificate.
 func FingerprintFromPEM(certPEM []byte) (string, error) {
 	block, _ := pem.Decode(certPEM)
@@ -5,6 +5,7 @@ import (
 	"net"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 )
@@ -135,14 +136,15 @@ func TestGenerateCertWithOptions(t *testing.T) {
 	}
 
 	opts := CertOptions{
-		CommonName:   "server-1",
-		Organization: "Test Org",
-		ValidFor:     30 * 24 * time.Hour,
-		DNSNames:     []string{"server-1.example.com", "server-1.local"},
-		IPAddresses:  []net.IP{net.ParseIP("192.168.1.100"), net.ParseIP("10.0.0.1")},
-		CertType:     CertTypeServer,
-		ParentCert:   ca.Certificate,
-		ParentKey:    ca.PrivateKey,
+		CommonName:         "server-1",
+		Organization:       "Test Org",
+		OrganizationalUnit: "operators",
+		ValidFor:           30 * 24 * time.Hour,
+		DNSNames:           []string{"server-1.example.com", "server-1.local"},
+		IPAddresses:        []net.IP{net.ParseIP("192.168.1.100"), net.ParseIP("10.0.0.1")},
+		CertType:           CertTypeServer,
+		ParentCert:         ca.Certificate,
+		ParentKey:          ca.PrivateKey,
 	}
 
 	cert, err := GenerateCert(opts)
@@ -164,6 +166,11 @@ func TestGenerateCertWithOptions(t *testing.T) {
 	if len(cert.Certificate.Subject.Organization) == 0 || cert.Certificate.Subject.Organization[0] != "Test Org" {
 		t.Error("Organization not set correctly")
 	}
+
+	// Check organizational unit
+	if ou := cert.Certificate.Subject.OrganizationalUnit; len(ou) != 1 || ou[0] != "operators" {
+		t.Errorf("OrganizationalUnit = %v, want [operators]", ou)
+	}
 }
 
 func TestSaveAndLoadCert(t *testing.T) {
@@ -256,6 +263,39 @@ func TestFingerprintFromPEM(t *testing.T) {
 	}
 }
 
+func TestNormalizeFingerprint(t *testing.T) {
+	ca, err := GenerateCA("Test CA", 365*24*time.Hour)
+	if err != nil {
+		t.Fatalf("GenerateCA failed: %v", err)
+	}
+	fp := ca.Fingerprint()
+	hexPart := strings.TrimPrefix(fp, "sha256:")
+
+	// Colon-separated uppercase form (as shown by openssl)
+	var pairs []string
+	for i := 0; i < len(hexPart); i += 2 {
+		pairs = append(pairs, strings.ToUpper(hexPart[i:i+2]))
+	}
+
+	inputs := []string{fp, hexPart, strings.ToUpper(fp), strings.Join(pairs, ":"), "  " + fp + "  "}
+	for _, in := range inputs {
+		got, err := NormalizeFingerprint(in)
+		if err != nil {
+			t.Errorf("NormalizeFingerprint(%q) error: %v", in, err)
+			continue
+		}
+		if got != fp {
+			t.Errorf("NormalizeFingerprint(%q) = %q, want %q", in, got, fp)
+		}
+	}
+
+	for _, bad := range []string{"", "sha256:", "sha256:abcd", "sha256:" + strings.Repeat("zz", 32)} {
+		if _, err := NormalizeFingerprint(bad); err == nil {
+			t.Errorf("NormalizeFingerprint(%q) should fail", bad)
+		}
+	}
+}
+
 func TestGetCertInfo(t *testing.T) {
 	ca, err := GenerateCA("Info Test CA", 365*24*time.Hour)
 	if err != nil {
@@ -0,0 +1,552 @@
+package chaos
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/postalsys/muti-metroo/internal/identity"
+	"github.com/postalsys/muti-metroo/internal/protocol"
+	"github.com/postalsys/muti-metroo/internal/transport"
+)
+
+// linkQueueSize is how many received frames a faulty stream holds while
+// they wait out their delay.
+const linkQueueSize = 256
+
+// LinkFaults are the faults applied to frames received on peer links.
+type LinkFaults struct {
+	// Latency is added to every received frame.
+	Latency time.Duration
+
+	// Jitter is a random extra delay between 0 and Jitter added on top of
+	// Latency. Frames are never reordered.
+	Jitter time.Duration
+
+	// Loss is the chance (0.0 to 1.0) that a received frame is dropped.
+	Loss float64
+}
+
+// LinkStats counts frames affected by a LinkInjector.
+type LinkStats struct {
+	// Delayed is the number of received frames held back by latency.
+	Delayed uint64
+
+	// Dropped is the number of received frames dropped by loss.
+	Dropped uint64
+
+	// Partitioned is the number of frames dropped in either direction
+	// because their peer is partitioned.
+	Partitioned uint64
+}
+
+// LinkInjector applies latency, jitter, loss and partitions to the peer
+// links of transports wrapped by WrapTransport. Faults and partitions can
+// be changed at any time and apply to existing links.
+//
+// Latency, jitter and loss apply to frames as they are received, so each
+// agent delays and drops what arrives on its own links; enable the
+// injector on both ends of a link for symmetric faults. Partitions drop
+// frames in both directions.
+type LinkInjector struct {
+	mu          sync.Mutex
+	faults      LinkFaults
+	partitioned map[identity.AgentID]bool
+	rng         *rand.Rand
+
+	delayed atomic.Uint64
+	dropped atomic.Uint64
+	cut     atomic.Uint64
+}
+
+// NewLinkInjector creates a link injector with the given faults. A non-zero
+// seed makes loss and jitter decisions repeatable; zero seeds from the
+// clock.
+func NewLinkInjector(faults LinkFaults, seed int64) *LinkInjector {
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	return &LinkInjector{
+		faults:      faults,
+		partitioned: make(map[identity.AgentID]bool),
+		rng:         rand.New(rand.NewSource(seed)),
+	}
+}
+
+// SetFaults replaces the faults applied to received frames.
+func (l *LinkInjector) SetFaults(faults LinkFaults) {
+	l.mu.Lock()
+	l.faults = faults
+	l.mu.Unlock()
+}
+
+// Faults returns the faults applied to received frames.
+func (l *LinkInjector) Faults() LinkFaults {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.faults
+}
+
+// Partition drops all frames to and from peer until it is healed, so new
+// connections with the peer fail their handshake. Open links to the peer
+// stay up but carry nothing; close them to make the loss visible at once.
+func (l *LinkInjector) Partition(peer identity.AgentID) {
+	l.mu.Lock()
+	l.partitioned[peer] = true
+	l.mu.Unlock()
+}
+
+// Heal removes the partition from peer.
+func (l *LinkInjector) Heal(peer identity.AgentID) {
+	l.mu.Lock()
+	delete(l.partitioned, peer)
+	l.mu.Unlock()
+}
+
+// HealAll removes all partitions.
+func (l *LinkInjector) HealAll() {
+	l.mu.Lock()
+	clear(l.partitioned)
+	l.mu.Unlock()
+}
+
+// Partitions returns the partitioned peers.
+func (l *LinkInjector) Partitions() []identity.AgentID {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	peers := make([]identity.AgentID, 0, len(l.partitioned))
+	for id := range l.partitioned {
+		peers = append(peers, id)
+	}
+	return peers
+}
+
+// IsPartitioned reports whether peer is partitioned.
+func (l *LinkInjector) IsPartitioned(peer identity.AgentID) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.partitioned[peer]
+}
+
+// Stats returns the number of frames affected so far.
+func (l *LinkInjector) Stats() LinkStats {
+	return LinkStats{
+		Delayed:     l.delayed.Load(),
+		Dropped:     l.dropped.Load(),
+		Partitioned: l.cut.Load(),
+	}
+}
+
+// inbound decides the fate of a frame received from peer (zero if not yet
+// known): whether to drop it, and otherwise how long to delay it.
+func (l *LinkInjector) inbound(peer identity.AgentID) (drop bool, delay time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !peer.IsZero() && l.partitioned[peer] {
+		l.cut.Add(1)
+		return true, 0
+	}
+	if l.faults.Loss > 0 && l.rng.Float64() < l.faults.Loss {
+		l.dropped.Add(1)
+		return true, 0
+	}
+	delay = l.faults.Latency
+	if l.faults.Jitter > 0 {
+		delay += time.Duration(l.rng.Int63n(int64(l.faults.Jitter)))
+	}
+	if delay > 0 {
+		l.delayed.Add(1)
+	}
+	return false, delay
+}
+
+// outbound reports whether a frame sent to peer must be dropped.
+func (l *LinkInjector) outbound(peer identity.AgentID) bool {
+	if peer.IsZero() {
+		return false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.partitioned[peer] {
+		l.cut.Add(1)
+		return true
+	}
+	return false
+}
+
+// WrapTransport returns a transport whose connections pass their frames
+// through inj. The underlying transport is available through Unwrap.
+func WrapTransport(tr transport.Transport, inj *LinkInjector) transport.Transport {
+	return &faultTransport{Transport: tr, inj: inj}
+}
+
+// faultTransport wraps the connections of a transport.
+type faultTransport struct {
+	transport.Transport
+	inj *LinkInjector
+}
+
+// Unwrap returns the wrapped transport.
+func (t *faultTransport) Unwrap() transport.Transport {
+	return t.Transport
+}
+
+// Dial connects to a remote peer.
+func (t *faultTransport) Dial(ctx context.Context, addr string, opts transport.DialOptions) (transport.PeerConn, error) {
+	conn, err := t.Transport.Dial(ctx, addr, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &faultConn{PeerConn: conn, inj: t.inj}, nil
+}
+
+// Listen creates a listener whose connections are wrapped.
+func (t *faultTransport) Listen(addr string, opts transport.ListenOptions) (transport.Listener, error) {
+	ln, err := t.Transport.Listen(addr, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &faultListener{Listener: ln, inj: t.inj}, nil
+}
+
+// faultListener wraps accepted connections.
+type faultListener struct {
+	transport.Listener
+	inj *LinkInjector
+}
+
+// Accept waits for and returns the next connection.
+func (l *faultListener) Accept(ctx context.Context) (transport.PeerConn, error) {
+	conn, err := l.Listener.Accept(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &faultConn{PeerConn: conn, inj: l.inj}, nil
+}
+
+// faultConn wraps the streams of a peer connection. The remote agent ID is
+// learned from the first handshake frame received, so partitions apply from
+// the handshake on.
+type faultConn struct {
+	transport.PeerConn
+	inj *LinkInjector
+
+	mu   sync.Mutex
+	peer identity.AgentID
+}
+
+// OpenStream creates a new outgoing stream.
+func (c *faultConn) OpenStream(ctx context.Context) (transport.Stream, error) {
+	s, err := c.PeerConn.OpenStream(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return newFaultStream(s, c), nil
+}
+
+// AcceptStream waits for an incoming stream.
+func (c *faultConn) AcceptStream(ctx context.Context) (transport.Stream, error) {
+	s, err := c.PeerConn.AcceptStream(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return newFaultStream(s, c), nil
+}
+
+// TLSConnectionState returns the TLS state of the wrapped connection, so
+// certificate checks see through the wrapper.
+func (c *faultConn) TLSConnectionState() (tls.ConnectionState, bool) {
+	if p, ok := c.PeerConn.(transport.TLSStateProvider); ok {
+		return p.TLSConnectionState()
+	}
+	return tls.ConnectionState{}, false
+}
+
+func (c *faultConn) remoteID() identity.AgentID {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.peer
+}
+
+// learn records the remote agent ID from a received handshake frame.
+func (c *faultConn) learn(frameType uint8, payload []byte) {
+	if frameType != protocol.FramePeerHello && frameType != protocol.FramePeerHelloAck {
+		return
+	}
+	hello, err := protocol.DecodePeerHello(payload)
+	if err != nil {
+		return
+	}
+	c.mu.Lock()
+	if c.peer.IsZero() {
+		c.peer = hello.AgentID
+	}
+	c.mu.Unlock()
+}
+
+// linkFrame is a received frame waiting for its delivery time, or the
+// error that ended the stream.
+type linkFrame struct {
+	data []byte
+	due  time.Time
+	err  error
+}
+
+// faultStream applies the injector to whole frames. A pump goroutine reads
+// frames from the wrapped stream, drops or schedules them, and Read
+// delivers them once due. Write drops frames for partitioned peers and
+// passes the rest through.
+type faultStream struct {
+	transport.Stream
+	conn *faultConn
+
+	frames  chan linkFrame
+	done    chan struct{}
+	closing sync.Once
+
+	// Read side, used by one reader at a time
+	cur      linkFrame
+	pending  bool
+	off      int
+	deadline *linkDeadline
+
+	// Write side
+	wmu     sync.Mutex
+	header  [protocol.HeaderSize]byte
+	hn      int
+	remain  int
+	dropCur bool
+	out     []byte
+}
+
+func newFaultStream(s transport.Stream, conn *faultConn) *faultStream {
+	fs := &faultStream{
+		Stream:   s,
+		conn:     conn,
+		frames:   make(chan linkFrame, linkQueueSize),
+		done:     make(chan struct{}),
+		deadline: newLinkDeadline(),
+	}
+	go fs.pump()
+	return fs
+}
+
+// pump reads frames from the wrapped stream and queues the ones that are
+// not dropped. Due times never decrease, so frames keep their order.
+func (s *faultStream) pump() {
+	var last time.Time
+	var header [protocol.HeaderSize]byte
+	for {
+		if _, err := io.ReadFull(s.Stream, header[:]); err != nil {
+			s.queue(linkFrame{err: err})
+			return
+		}
+		frameType, _, length, _, err := protocol.DecodeHeader(header[:])
+		if err != nil {
+			s.queue(linkFrame{err: err})
+			return
+		}
+		data := make([]byte, protocol.HeaderSize+int(length))
+		copy(data, header[:])
+		if _, err := io.ReadFull(s.Stream, data[protocol.HeaderSize:]); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			s.queue(linkFrame{err: err})
+			return
+		}
+
+		s.conn.learn(frameType, data[protocol.HeaderSize:])
+		drop, delay := s.conn.inj.inbound(s.conn.remoteID())
+		if drop {
+			continue
+		}
+		due := time.Now().Add(delay)
+		if due.Before(last) {
+			due = last
+		}
+		last = due
+		if !s.queue(linkFrame{data: data, due: due}) {
+			return
+		}
+	}
+}
+
+// queue hands a frame to Read, and reports false once the stream is closed.
+func (s *faultStream) queue(f linkFrame) bool {
+	select {
+	case s.frames <- f:
+		return true
+	case <-s.done:
+		return false
+	}
+}
+
+// Read delivers received frames once their delay has passed.
+func (s *faultStream) Read(b []byte) (int, error) {
+	for !s.pending {
+		timeout, changed, stop, err := s.deadline.arm()
+		if err != nil {
+			return 0, err
+		}
+		select {
+		case f := <-s.frames:
+			s.cur, s.pending, s.off = f, true, 0
+		case <-s.done:
+			err = net.ErrClosed
+		case <-timeout:
+			err = os.ErrDeadlineExceeded
+		case <-changed:
+		}
+		stop()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	// An error ends the stream; keep returning it
+	if s.cur.err != nil {
+		return 0, s.cur.err
+	}
+
+	for wait := time.Until(s.cur.due); wait > 0; wait = time.Until(s.cur.due) {
+		timeout, changed, stop, err := s.deadline.arm()
+		if err != nil {
+			return 0, err
+		}
+		due := time.NewTimer(wait)
+		select {
+		case <-due.C:
+		case <-s.done:
+			err = net.ErrClosed
+		case <-timeout:
+			err = os.ErrDeadlineExceeded
+		case <-changed:
+		}
+		due.Stop()
+		stop()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(b, s.cur.data[s.off:])
+	s.off += n
+	if s.off == len(s.cur.data) {
+		s.cur, s.pending = linkFrame{}, false
+	}
+	return n, nil
+}
+
+// Write passes complete frames to the wrapped stream, dropping frames for a
+// partitioned peer. A frame header split across writes is held until it is
+// complete.
+func (s *faultStream) Write(b []byte) (int, error) {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+
+	peer := s.conn.remoteID()
+	out := s.out[:0]
+	for p := b; len(p) > 0; {
+		if s.remain == 0 && s.hn < protocol.HeaderSize {
+			n := copy(s.header[s.hn:], p)
+			s.hn += n
+			p = p[n:]
+			if s.hn < protocol.HeaderSize {
+				break
+			}
+			s.remain = int(binary.BigEndian.Uint32(s.header[2:6]))
+			s.dropCur = s.conn.inj.outbound(peer)
+			if !s.dropCur {
+				out = append(out, s.header[:]...)
+			}
+			if s.remain == 0 {
+				s.hn = 0
+			}
+			continue
+		}
+		n := min(s.remain, len(p))
+		if !s.dropCur {
+			out = append(out, p[:n]...)
+		}
+		s.remain -= n
+		p = p[n:]
+		if s.remain == 0 {
+			s.hn = 0
+		}
+	}
+	s.out = out
+
+	if len(out) > 0 {
+		if _, err := s.Stream.Write(out); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// Close closes the stream and stops the pump.
+func (s *faultStream) Close() error {
+	s.closing.Do(func() { close(s.done) })
+	return s.Stream.Close()
+}
+
+// SetDeadline sets read and write deadlines.
+func (s *faultStream) SetDeadline(t time.Time) error {
+	s.deadline.set(t)
+	return s.Stream.SetWriteDeadline(t)
+}
+
+// SetReadDeadline sets the read deadline. It applies to delivery of queued
+// frames, not to the wrapped stream, which the pump keeps reading.
+func (s *faultStream) SetReadDeadline(t time.Time) error {
+	s.deadline.set(t)
+	return nil
+}
+
+// linkDeadline is a read deadline whose changes wake a blocked Read.
+type linkDeadline struct {
+	mu      sync.Mutex
+	t       time.Time
+	changed chan struct{}
+}
+
+func newLinkDeadline() *linkDeadline {
+	return &linkDeadline{changed: make(chan struct{})}
+}
+
+func (d *linkDeadline) set(t time.Time) {
+	d.mu.Lock()
+	d.t = t
+	close(d.changed)
+	d.changed = make(chan struct{})
+	d.mu.Unlock()
+}
+
+// arm returns channels that fire when the deadline passes or changes, and
+// a function releasing its timer. It returns os.ErrDeadlineExceeded if the
+// deadline has already passed.
+func (d *linkDeadline) arm() (timeout <-chan time.Time, changed <-chan struct{}, stop func(), err error) {
+	d.mu.Lock()
+	t := d.t
+	changed = d.changed
+	d.mu.Unlock()
+
+	if t.IsZero() {
+		return nil, changed, func() {}, nil
+	}
+	wait := time.Until(t)
+	if wait <= 0 {
+		return nil, nil, nil, os.ErrDeadlineExceeded
+	}
+	timer := time.NewTimer(wait)
+	return timer.C, changed, func() { timer.Stop() }, nil
+}
@@ -0,0 +1,196 @@
+package chaos
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/postalsys/muti-metroo/internal/identity"
+	"github.com/postalsys/muti-metroo/internal/protocol"
+	"github.com/postalsys/muti-metroo/internal/transport"
+)
+
+// linkPair connects two memory transports wrapped with their own injectors
+// and returns the client and server ends of one stream.
+func linkPair(t *testing.T, clientInj, serverInj *LinkInjector) (client, server transport.Stream) {
+	t.Helper()
+
+	network := transport.NewMemoryNetwork()
+	serverTr := WrapTransport(transport.NewMemoryTransport(network), serverInj)
+	clientTr := WrapTransport(transport.NewMemoryTransport(network), clientInj)
+	t.Cleanup(func() {
+		clientTr.Close()
+		serverTr.Close()
+	})
+
+	ln, err := serverTr.Listen("server", transport.ListenOptions{})
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cc, err := clientTr.Dial(ctx, "server", transport.DialOptions{})
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	sc, err := ln.Accept(ctx)
+	if err != nil {
+		t.Fatalf("Accept() error = %v", err)
+	}
+	if client, err = cc.OpenStream(ctx); err != nil {
+		t.Fatalf("OpenStream() error = %v", err)
+	}
+	if server, err = sc.AcceptStream(ctx); err != nil {
+		t.Fatalf("AcceptStream() error = %v", err)
+	}
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return client, server
+}
+
+func writeFrame(t *testing.T, s transport.Stream, frameType uint8, streamID uint64, payload []byte) {
+	t.Helper()
+	if err := protocol.NewFrameWriter(s).WriteFrame(frameType, 0, streamID, payload); err != nil {
+		t.Fatalf("WriteFrame() error = %v", err)
+	}
+}
+
+// readFrame reads one frame, or returns the read error after timeout.
+func readFrame(s transport.Stream, timeout time.Duration) (*protocol.Frame, error) {
+	s.SetReadDeadline(time.Now().Add(timeout))
+	defer s.SetReadDeadline(time.Time{})
+	return protocol.NewFrameReader(s).Read()
+}
+
+func hello(id identity.AgentID) []byte {
+	return (&protocol.PeerHello{Version: protocol.ProtocolVersion, AgentID: id}).Encode()
+}
+
+func TestLinkInjector_Latency(t *testing.T) {
+	serverInj := NewLinkInjector(LinkFaults{Latency: 50 * time.Millisecond, Jitter: 20 * time.Millisecond}, 1)
+	client, server := linkPair(t, NewLinkInjector(LinkFaults{}, 1), serverInj)
+
+	start := time.Now()
+	for i := uint64(1); i <= 20; i++ {
+		writeFrame(t, client, protocol.FrameStreamData, i, []byte("data"))
+	}
+	for i := uint64(1); i <= 20; i++ {
+		f, err := readFrame(server, time.Second)
+		if err != nil {
+			t.Fatalf("Read() error = %v", err)
+		}
+		if f.StreamID != i {
+			t.Fatalf("frame %d arrived as frame %d", f.StreamID, i)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("frames arrived after %v, want at least 50ms", elapsed)
+	}
+	if got := serverInj.Stats().Delayed; got != 20 {
+		t.Errorf("Delayed = %d, want 20", got)
+	}
+}
+
+func TestLinkInjector_Loss(t *testing.T) {
+	serverInj := NewLinkInjector(LinkFaults{Loss: 1}, 1)
+	client, server := linkPair(t, NewLinkInjector(LinkFaults{}, 1), serverInj)
+
+	writeFrame(t, client, protocol.FrameKeepalive, 0, nil)
+	if _, err := readFrame(server, 50*time.Millisecond); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("Read() error = %v, want deadline exceeded", err)
+	}
+	if got := serverInj.Stats().Dropped; got != 1 {
+		t.Errorf("Dropped = %d, want 1", got)
+	}
+
+	// Faults change on a live link
+	serverInj.SetFaults(LinkFaults{})
+	writeFrame(t, client, protocol.FrameKeepalive, 0, nil)
+	if _, err := readFrame(server, time.Second); err != nil {
+		t.Errorf("Read() after clearing loss error = %v", err)
+	}
+}
+
+func TestLinkInjector_Partition(t *testing.T) {
+	clientID, _ := identity.NewAgentID()
+	serverID, _ := identity.NewAgentID()
+	clientInj := NewLinkInjector(LinkFaults{}, 1)
+	client, server := linkPair(t, clientInj, NewLinkInjector(LinkFaults{}, 1))
+
+	// Handshake frames tell each side who the peer is
+	writeFrame(t, client, protocol.FramePeerHello, 0, hello(clientID))
+	if _, err := readFrame(server, time.Second); err != nil {
+		t.Fatalf("Read() hello error = %v", err)
+	}
+	writeFrame(t, server, protocol.FramePeerHelloAck, 0, hello(serverID))
+	if _, err := readFrame(client, time.Second); err != nil {
+		t.Fatalf("Read() hello ack error = %v", err)
+	}
+
+	clientInj.Partition(serverID)
+	if !clientInj.IsPartitioned(serverID) {
+		t.Fatal("IsPartitioned() = false after Partition")
+	}
+
+	// Both directions are cut by the client's partition alone
+	writeFrame(t, client, protocol.FrameStreamData, 1, []byte("to server"))
+	if _, err := readFrame(server, 50*time.Millisecond); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("server Read() error = %v, want deadline exceeded", err)
+	}
+	writeFrame(t, server, protocol.FrameStreamData, 1, []byte("to client"))
+	if _, err := readFrame(client, 50*time.Millisecond); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("client Read() error = %v, want deadline exceeded", err)
+	}
+	if got := clientInj.Stats().Partitioned; got != 2 {
+		t.Errorf("Partitioned = %d, want 2", got)
+	}
+
+	clientInj.Heal(serverID)
+	writeFrame(t, client, protocol.FrameStreamData, 2, []byte("healed"))
+	f, err := readFrame(server, time.Second)
+	if err != nil {
+		t.Fatalf("Read() after Heal error = %v", err)
+	}
+	if f.StreamID != 2 || string(f.Payload) != "healed" {
+		t.Errorf("Read() after Heal = stream %d %q, want stream 2 healed", f.StreamID, f.Payload)
+	}
+}
+
+func TestLinkInjector_SplitWrites(t *testing.T) {
+	peerID, _ := identity.NewAgentID()
+	clientInj := NewLinkInjector(LinkFaults{}, 1)
+	client, server := linkPair(t, clientInj, NewLinkInjector(LinkFaults{}, 1))
+
+	writeFrame(t, server, protocol.FramePeerHelloAck, 0, hello(peerID))
+	if _, err := readFrame(client, time.Second); err != nil {
+		t.Fatalf("Read() hello ack error = %v", err)
+	}
+
+	// A partition that starts inside a frame takes effect on the next one
+	first, _ := (&protocol.Frame{Type: protocol.FrameStreamData, StreamID: 1, Payload: []byte("first")}).Encode()
+	second, _ := (&protocol.Frame{Type: protocol.FrameStreamData, StreamID: 2, Payload: []byte("second")}).Encode()
+	third, _ := (&protocol.Frame{Type: protocol.FrameStreamData, StreamID: 3, Payload: []byte("third")}).Encode()
+
+	client.Write(first[:16])
+	clientInj.Partition(peerID)
+	client.Write(first[16:])
+	client.Write(second)
+	clientInj.Heal(peerID)
+	client.Write(third[:3])
+	client.Write(third[3:])
+
+	for _, want := range []uint64{1, 3} {
+		f, err := readFrame(server, time.Second)
+		if err != nil {
+			t.Fatalf("Read() error = %v", err)
+		}
+		if f.StreamID != want {
+			t.Errorf("Read() = stream %d, want stream %d", f.StreamID, want)
+		}
+	}
+}
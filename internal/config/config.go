@@ -2,15 +2,28 @@
 package config
 
 import (
+	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
+	"maps"
 	"net"
+	"net/url"
 	"os"
+	"path/filepath"
 	"regexp"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/postalsys/muti-metroo/internal/certutil"
 	"github.com/postalsys/muti-metroo/internal/embed"
+	"github.com/postalsys/muti-metroo/internal/identity"
+	"github.com/postalsys/muti-metroo/internal/ipfamily"
+	"github.com/postalsys/muti-metroo/internal/logging"
+	"github.com/postalsys/muti-metroo/internal/privdrop"
+	"github.com/postalsys/muti-metroo/internal/protocol"
+	"github.com/postalsys/muti-metroo/internal/rbac"
 	"gopkg.in/yaml.v3"
 )
 
@@ -68,18 +81,29 @@ type Config struct {
 	Management    ManagementConfig   `yaml:"management,omitempty"`
 	Listeners     []ListenerConfig   `yaml:"listeners,omitempty"`
 	Peers         []PeerConfig       `yaml:"peers,omitempty"`
+	Discovery     DiscoveryConfig    `yaml:"discovery,omitempty"`
 	SOCKS5        SOCKS5Config       `yaml:"socks5,omitempty"`
 	Exit          ExitConfig         `yaml:"exit,omitempty"`
 	Routing       RoutingConfig      `yaml:"routing,omitempty"`
 	Connections   ConnectionsConfig  `yaml:"connections,omitempty"`
 	Limits        LimitsConfig       `yaml:"limits,omitempty"`
+	E2E           E2EConfig          `yaml:"e2e,omitempty"`
 	HTTP          HTTPConfig         `yaml:"http,omitempty"`
 	FileTransfer  FileTransferConfig `yaml:"file_transfer,omitempty"`
 	Shell         ShellConfig        `yaml:"shell,omitempty"`
 	UDP           UDPConfig          `yaml:"udp,omitempty"`
 	ICMP          ICMPConfig         `yaml:"icmp,omitempty"`
 	Forward       ForwardConfig      `yaml:"forward,omitempty"`
+	Tunnel        TunnelConfig       `yaml:"tunnel,omitempty"`
 	Sleep         SleepConfig        `yaml:"sleep,omitempty"`
+	Scheduler     SchedulerConfig    `yaml:"scheduler,omitempty"`
+	Update        UpdateConfig       `yaml:"update,omitempty"`
+	Usage         UsageConfig        `yaml:"usage,omitempty"`
+	CrashReports  CrashReportsConfig `yaml:"crash_reports,omitempty"`
+	Watchdog      WatchdogConfig     `yaml:"watchdog,omitempty"`
+	RBAC          RBACConfig         `yaml:"rbac,omitempty"`
+	Chaos         ChaosConfig        `yaml:"chaos,omitempty"`
+	Debug         DebugConfig        `yaml:"debug,omitempty"`
 }
 
 // ProtocolConfig defines protocol identifiers used for transport negotiation.
@@ -133,6 +157,15 @@ type GlobalTLSConfig struct {
 	// This allows mimicking browser TLS fingerprints (JA3/JA4) to blend with
 	// legitimate traffic and make fingerprinting harder.
 	Fingerprint FingerprintConfig `yaml:"fingerprint,omitempty"`
+
+	// AgentPins binds agent IDs to TLS certificate fingerprints (mesh-wide).
+	// Keys are agent IDs (hex), values are SHA256 certificate fingerprints
+	// ("sha256:<hex>", colons and uppercase accepted). A peer claiming a pinned
+	// agent ID must present the matching certificate, and a pinned certificate
+	// cannot be used by any other agent ID. Listeners request client
+	// certificates when pins are configured, so dialing agents must have a
+	// certificate configured.
+	AgentPins map[string]string `yaml:"agent_pins,omitempty"`
 }
 
 // FingerprintConfig configures TLS fingerprint customization for client connections.
@@ -167,6 +200,29 @@ func (g *GlobalTLSConfig) GetKeyPEM() ([]byte, error) {
 	return getPEM(g.KeyPEM, g.Key)
 }
 
+// HasAgentPins returns true if the mesh-wide certificate pinning table is configured.
+func (g *GlobalTLSConfig) HasAgentPins() bool {
+	return len(g.AgentPins) > 0
+}
+
+// GetAgentPins returns the certificate pinning table keyed by agent ID,
+// with fingerprints normalized to "sha256:<lowercase hex>".
+func (g *GlobalTLSConfig) GetAgentPins() (map[identity.AgentID]string, error) {
+	pins := make(map[identity.AgentID]string, len(g.AgentPins))
+	for idStr, fp := range g.AgentPins {
+		id, err := identity.ParseAgentID(idStr)
+		if err != nil {
+			return nil, fmt.Errorf("agent_pins[%s]: %w", idStr, err)
+		}
+		normalized, err := certutil.NormalizeFingerprint(fp)
+		if err != nil {
+			return nil, fmt.Errorf("agent_pins[%s]: %w", idStr, err)
+		}
+		pins[id] = normalized
+	}
+	return pins, nil
+}
+
 // HasCA returns true if CA certificate is configured (either file or PEM).
 func (g *GlobalTLSConfig) HasCA() bool {
 	return g.CA != "" || g.CAPEM != ""
@@ -197,6 +253,17 @@ type ManagementConfig struct {
 	// NEVER distribute to field agents.
 	PrivateKey string `yaml:"private_key,omitempty"`
 
+	// NextPublicKey is the management public key being rotated in
+	// (hex-encoded, 64 characters). While set, NodeInfo is encrypted to both
+	// PublicKey and NextPublicKey. Can also be staged at runtime with
+	// muti-metroo management-key stage.
+	NextPublicKey string `yaml:"next_public_key,omitempty"`
+
+	// NextPrivateKey is the private key of NextPublicKey (hex-encoded, 64
+	// characters). Set on operator nodes during a rotation to decrypt data
+	// encrypted to either key and report which agents adopted the new key.
+	NextPrivateKey string `yaml:"next_private_key,omitempty"`
+
 	// SigningPublicKey is the Ed25519 public key for verifying signed commands
 	// (hex-encoded, 64 characters = 32 bytes).
 	// When set, sleep/wake commands must be signed with the corresponding private key.
@@ -244,6 +311,18 @@ func (c *Config) CanDecryptManagement() bool {
 	return c.Management.PrivateKey != ""
 }
 
+// GetManagementNextPublicKey returns the parsed next management public key.
+// Returns an error if the key is not configured or invalid.
+func (c *Config) GetManagementNextPublicKey() ([KeySize]byte, error) {
+	return parseHexKey(c.Management.NextPublicKey, "management next public key", KeySize)
+}
+
+// GetManagementNextPrivateKey returns the parsed next management private key.
+// Returns an error if the key is not configured or invalid.
+func (c *Config) GetManagementNextPrivateKey() ([KeySize]byte, error) {
+	return parseHexKey(c.Management.NextPrivateKey, "management next private key", KeySize)
+}
+
 // HasSigningKey returns true if command signing verification is configured.
 // When true, sleep/wake commands must be signed with the corresponding private key.
 func (c *Config) HasSigningKey() bool {
@@ -277,6 +356,28 @@ func (c *Config) GetSigningPublicKey() ([SigningPublicKeySize]byte, error) {
 	return key, nil
 }
 
+// GetRouteKeys returns the parsed routing.route_keys table.
+func (c *Config) GetRouteKeys() (map[identity.AgentID][SigningPublicKeySize]byte, error) {
+	keys := make(map[identity.AgentID][SigningPublicKeySize]byte, len(c.Routing.RouteKeys))
+	for idStr, keyHex := range c.Routing.RouteKeys {
+		id, err := identity.ParseAgentID(idStr)
+		if err != nil {
+			return nil, fmt.Errorf("routing.route_keys: invalid agent ID %q: %w", idStr, err)
+		}
+		decoded, err := hex.DecodeString(keyHex)
+		if err != nil {
+			return nil, fmt.Errorf("routing.route_keys[%s]: invalid key hex: %w", idStr, err)
+		}
+		if len(decoded) != SigningPublicKeySize {
+			return nil, fmt.Errorf("routing.route_keys[%s]: key must be %d bytes, got %d", idStr, SigningPublicKeySize, len(decoded))
+		}
+		var key [SigningPublicKeySize]byte
+		copy(key[:], decoded)
+		keys[id] = key
+	}
+	return keys, nil
+}
+
 // GetSigningPrivateKey returns the parsed Ed25519 signing private key.
 // Returns an error if the key is not configured or invalid.
 func (c *Config) GetSigningPrivateKey() ([SigningPrivateKeySize]byte, error) {
@@ -300,17 +401,29 @@ func (c *Config) GetSigningPrivateKey() ([SigningPrivateKeySize]byte, error) {
 
 // AgentConfig contains agent identity settings.
 type AgentConfig struct {
-	ID          string `yaml:"id,omitempty"`           // "auto" or hex string
-	DisplayName string `yaml:"display_name,omitempty"` // Human-readable name (Unicode allowed)
-	DataDir     string `yaml:"data_dir,omitempty"`     // Directory for persistent state (optional with identity in config)
-	LogLevel    string `yaml:"log_level,omitempty"`    // debug, info, warn, error
-	LogFormat   string `yaml:"log_format,omitempty"`   // text, json
+	ID          string   `yaml:"id,omitempty"`           // "auto" or hex string
+	DisplayName string   `yaml:"display_name,omitempty"` // Human-readable name (Unicode allowed)
+	Tags        []string `yaml:"tags,omitempty"`         // Grouping labels such as environment, role, region or tenant
+	Namespace   string   `yaml:"namespace,omitempty"`    // Tenant namespace of this agent's routes and clients (empty = default)
+	DataDir     string   `yaml:"data_dir,omitempty"`     // Directory for persistent state (optional with identity in config)
+	LogLevel    string   `yaml:"log_level,omitempty"`    // debug, info, warn, error
+	LogFormat   string   `yaml:"log_format,omitempty"`   // text, json
+	LogOutput   string   `yaml:"log_output,omitempty"`   // stderr, stdout, file, syslog, eventlog
+
+	// Settings of the file, syslog and eventlog log outputs.
+	LogFile     LogFileConfig     `yaml:"log_file,omitempty"`
+	LogSyslog   LogSyslogConfig   `yaml:"log_syslog,omitempty"`
+	LogEventLog LogEventLogConfig `yaml:"log_eventlog,omitempty"`
 
 	// StartupDelay delays all network activity (listeners, peers, SOCKS5, etc.)
 	// for the specified duration after the process starts. Useful for staggering
 	// agent startups or waiting for dependencies. Default: 0 (no delay).
 	StartupDelay time.Duration `yaml:"startup_delay,omitempty"`
 
+	// RunAs switches an agent started as root to an unprivileged user once
+	// its listeners are bound (Linux and macOS).
+	RunAs RunAsConfig `yaml:"run_as,omitempty"`
+
 	// X25519 keypair for E2E encryption (optional - enables single-file deployment)
 	// When specified, takes precedence over data_dir files, making data_dir optional.
 	// Generate with: muti-metroo init, then copy values from agent_key file.
@@ -318,6 +431,36 @@ type AgentConfig struct {
 	PublicKey  string `yaml:"public_key,omitempty"`  // Optional - derived from private_key if not specified
 }
 
+// RunAsConfig selects the user an agent started as root continues as.
+type RunAsConfig struct {
+	User  string `yaml:"user,omitempty"`  // User name or numeric ID (empty = keep running as root)
+	Group string `yaml:"group,omitempty"` // Group name or numeric ID (default: the user's primary group)
+
+	// Capabilities kept after the switch (Linux only): net_bind_service,
+	// net_admin, net_raw. Unset keeps what enabled features need.
+	Capabilities []string `yaml:"capabilities,omitempty"`
+}
+
+// LogFileConfig configures the rotating log file of log_output: file.
+type LogFileConfig struct {
+	Path       string        `yaml:"path,omitempty"`
+	MaxSize    int64         `yaml:"max_size,omitempty"`    // Rotate before the file exceeds this many bytes (default 100 MiB)
+	MaxAge     time.Duration `yaml:"max_age,omitempty"`     // Rotate when the file is older than this (default: never)
+	MaxBackups int           `yaml:"max_backups,omitempty"` // Rotated files to keep (default 5)
+}
+
+// LogSyslogConfig configures log_output: syslog.
+type LogSyslogConfig struct {
+	Address  string `yaml:"address,omitempty"`  // udp://host:port, tcp://host:port, unix:///path; empty for the local socket
+	Facility string `yaml:"facility,omitempty"` // Default: daemon
+	Tag      string `yaml:"tag,omitempty"`      // APP-NAME (default muti-metroo)
+}
+
+// LogEventLogConfig configures log_output: eventlog (Windows only).
+type LogEventLogConfig struct {
+	Source string `yaml:"source,omitempty"` // Event source (default muti-metroo)
+}
+
 // HasIdentityKeypair returns true if the identity private key is configured in config.
 func (a *AgentConfig) HasIdentityKeypair() bool {
 	return a.PrivateKey != ""
@@ -341,22 +484,93 @@ func (a *AgentConfig) GetIdentityPublicKey() ([KeySize]byte, bool, error) {
 
 // ListenerConfig defines a transport listener.
 type ListenerConfig struct {
-	Transport string    `yaml:"transport"`           // quic, h2, ws (required)
+	Transport string    `yaml:"transport"`           // quic, h2, ws, wt, tcp (required)
 	Address   string    `yaml:"address"`             // listen address (required)
-	Path      string    `yaml:"path,omitempty"`      // HTTP path for h2/ws
+	Path      string    `yaml:"path,omitempty"`      // HTTP path for h2/ws/wt
 	PlainText bool      `yaml:"plaintext,omitempty"` // Allow plain WebSocket without TLS (for reverse proxy)
 	TLS       TLSConfig `yaml:"tls,omitempty"`
+
+	// AddressFamily selects the IP families accepted: "dual" (default),
+	// "ipv4" or "ipv6".
+	AddressFamily string `yaml:"address_family,omitempty"`
+
+	// AllowedAgents lists the agent IDs that may connect to this listener.
+	// Others are refused after PEER_HELLO. Empty allows any agent.
+	AllowedAgents []string `yaml:"allowed_agents,omitempty"`
+}
+
+// GetAllowedAgents parses AllowedAgents.
+func (l *ListenerConfig) GetAllowedAgents() ([]identity.AgentID, error) {
+	var ids []identity.AgentID
+	for _, s := range l.AllowedAgents {
+		id, err := identity.ParseAgentID(s)
+		if err != nil {
+			return nil, fmt.Errorf("allowed_agents: %q: %w", s, err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
 }
 
 // PeerConfig defines a peer connection.
 type PeerConfig struct {
 	ID        string    `yaml:"id,omitempty"`         // Expected peer AgentID
-	Transport string    `yaml:"transport"`            // quic, h2, ws (required)
+	Transport string    `yaml:"transport"`            // quic, h2, ws, wt, tcp (required)
 	Address   string    `yaml:"address"`              // peer address (required)
-	Path      string    `yaml:"path,omitempty"`       // HTTP path for h2/ws
-	Proxy     string    `yaml:"proxy,omitempty"`      // HTTP proxy for ws
+	Path      string    `yaml:"path,omitempty"`       // HTTP path for h2/ws/wt
+	Proxy     string    `yaml:"proxy,omitempty"`      // Upstream proxy: http://, https://, socks5://, socks5h://
 	ProxyAuth ProxyAuth `yaml:"proxy_auth,omitempty"` // Proxy authentication
 	TLS       TLSConfig `yaml:"tls,omitempty"`
+
+	// ProxyFallback dials the peer directly when the proxy cannot be reached
+	// or refuses the connection.
+	ProxyFallback bool `yaml:"proxy_fallback,omitempty"`
+
+	// CertFingerprint pins the peer's TLS certificate (SHA256, "sha256:<hex>").
+	// The handshake is rejected if the certificate presented by the peer does
+	// not match. Takes precedence over tls.agent_pins for this peer.
+	CertFingerprint string `yaml:"cert_fingerprint,omitempty"`
+
+	// Quiet suppresses keepalives and route/node-info advertisements on this
+	// link while no user traffic flows, relying on transport-level liveness.
+	// A dropped quiet link is not reconnected in the background but on the
+	// next outbound connection.
+	Quiet bool `yaml:"quiet,omitempty"`
+
+	// MaxFrameSize limits the frame payloads on this link in bytes.
+	// Overrides connections.max_frame_size for the transport.
+	MaxFrameSize int `yaml:"max_frame_size,omitempty"`
+}
+
+// DiscoveryConfig defines dynamic peer discovery. Discovered peers are dialed
+// like static peers, with the TLS overrides in TLS applied on top of the
+// global settings, and are no longer reconnected once their source stops
+// reporting them.
+type DiscoveryConfig struct {
+	DNS      DNSDiscoveryConfig  `yaml:"dns,omitempty"`
+	MDNS     MDNSDiscoveryConfig `yaml:"mdns,omitempty"`
+	MaxPeers int                 `yaml:"max_peers,omitempty"` // Maximum number of discovered peers dialed
+	TLS      TLSConfig           `yaml:"tls,omitempty"`       // TLS overrides for discovered peers
+}
+
+// DNSDiscoveryConfig defines peer discovery through SRV records of
+// _muti-metroo._udp.<zone> and _muti-metroo._tcp.<zone>, with TXT records on
+// each SRV target carrying the agent ID, transport and path.
+type DNSDiscoveryConfig struct {
+	Enabled  bool          `yaml:"enabled,omitempty"`
+	Zone     string        `yaml:"zone,omitempty"`
+	Interval time.Duration `yaml:"interval,omitempty"` // Time between lookups
+}
+
+// MDNSDiscoveryConfig defines peer discovery through multicast DNS on the
+// local network. Enabled browses for other agents; Announce answers their
+// queries with this agent's listeners.
+type MDNSDiscoveryConfig struct {
+	Enabled   bool          `yaml:"enabled,omitempty"`
+	Announce  bool          `yaml:"announce,omitempty"`
+	Interface string        `yaml:"interface,omitempty"` // Network interface (default: system default)
+	Interval  time.Duration `yaml:"interval,omitempty"`  // Time between queries
+	Timeout   time.Duration `yaml:"timeout,omitempty"`   // Time to collect answers to a query
 }
 
 // TLSConfig defines per-connection TLS settings that can override global settings.
@@ -465,11 +679,109 @@ type ProxyAuth struct {
 
 // SOCKS5Config defines SOCKS5 server settings.
 type SOCKS5Config struct {
-	Enabled        bool                  `yaml:"enabled,omitempty"`
-	Address        string                `yaml:"address,omitempty"`
+	Enabled bool `yaml:"enabled,omitempty"`
+	// Address is a TCP host:port or a UNIX socket path prefixed with "unix://".
+	Address string `yaml:"address,omitempty"`
+	// SocketMode is the octal permission mode of a UNIX socket listener
+	// (e.g., "0660"). Defaults to "0600". Ignored for TCP addresses.
+	SocketMode string `yaml:"socket_mode,omitempty"`
+	// AddressFamily selects the IP families of a TCP listener: "dual"
+	// (default), "ipv4" or "ipv6".
+	AddressFamily  string                `yaml:"address_family,omitempty"`
 	Auth           SOCKS5AuthConfig      `yaml:"auth,omitempty"`
 	MaxConnections int                   `yaml:"max_connections,omitempty"`
 	WebSocket      WebSocketSOCKS5Config `yaml:"websocket,omitempty"`
+	// RemoteDNS selects where domain names from SOCKS5 clients are resolved:
+	// "auto" (default), "local", or "always". See the RemoteDNS constants.
+	RemoteDNS string `yaml:"remote_dns,omitempty"`
+	// ConnectTimeout limits connections this agent dials itself, for
+	// destinations without a mesh route or routed to a local exit.
+	ConnectTimeout time.Duration `yaml:"connect_timeout,omitempty"`
+	// Blocklist rejects CONNECT requests to listed destinations before any
+	// mesh traffic is generated.
+	Blocklist SOCKS5BlocklistConfig `yaml:"blocklist,omitempty"`
+	// ClientLimits caps concurrent connections and connection rate per
+	// client source IP and per authenticated user.
+	ClientLimits SOCKS5ClientLimitsConfig `yaml:"client_limits,omitempty"`
+	// SendClientAddress shares the client address with exits, for exits
+	// with proxy_protocol. Transit agents can read it.
+	SendClientAddress bool `yaml:"send_client_address,omitempty"`
+	// SendUsername shares the authenticated username with exits, for exit
+	// user policies and audit logs. Transit agents can read it.
+	SendUsername bool `yaml:"send_username,omitempty"`
+}
+
+// SOCKS5ClientLimitsConfig defines per-client SOCKS5 connection limits, so
+// one client cannot use up max_connections or the mesh stream limits for
+// everyone. Clients rejected BanThreshold times within BanWindow are refused
+// for BanDuration.
+type SOCKS5ClientLimitsConfig struct {
+	Enabled bool              `yaml:"enabled,omitempty"`
+	PerIP   SOCKS5ClientLimit `yaml:"per_ip,omitempty"`
+	PerUser SOCKS5ClientLimit `yaml:"per_user,omitempty"`
+
+	BanThreshold int           `yaml:"ban_threshold,omitempty"` // Rejections that trigger a ban (0 = no bans)
+	BanWindow    time.Duration `yaml:"ban_window,omitempty"`    // Window in which rejections are counted
+	BanDuration  time.Duration `yaml:"ban_duration,omitempty"`  // How long a ban lasts
+}
+
+// SOCKS5ClientLimit caps the connections of one source IP or user. Zero
+// values mean no limit.
+type SOCKS5ClientLimit struct {
+	MaxConnections int     `yaml:"max_connections,omitempty"` // Concurrent connections
+	Rate           float64 `yaml:"rate,omitempty"`            // New connections per second
+	Burst          int     `yaml:"burst,omitempty"`           // Connections accepted at once above rate
+}
+
+// SOCKS5BlocklistConfig defines destinations rejected at SOCKS5 CONNECT
+// time. Files and feeds hold one domain, IP address or CIDR per line, with
+// '#' comments and hosts file lines allowed.
+type SOCKS5BlocklistConfig struct {
+	Enabled bool `yaml:"enabled,omitempty"`
+	// Domains block these domains and all their subdomains.
+	Domains []string `yaml:"domains,omitempty"`
+	// CIDRs block destination IP addresses in these ranges.
+	CIDRs []string `yaml:"cidrs,omitempty"`
+	// Files are local list files, reloaded on every refresh.
+	Files []string `yaml:"files,omitempty"`
+	// Feeds are HTTPS URLs of list files, fetched on every refresh.
+	Feeds []string `yaml:"feeds,omitempty"`
+	// RefreshInterval is how often files and feeds are reloaded.
+	RefreshInterval time.Duration `yaml:"refresh_interval,omitempty"`
+}
+
+// SOCKS5 remote DNS policies.
+const (
+	// RemoteDNSAuto resolves at the exit when a domain route matches and at
+	// the ingress otherwise.
+	RemoteDNSAuto = "auto"
+
+	// RemoteDNSLocal always resolves at the ingress and routes by IP.
+	// Domain routes are not used.
+	RemoteDNSLocal = "local"
+
+	// RemoteDNSAlways never resolves at the ingress. Domains without a
+	// domain route are sent to the exit of the default route (0.0.0.0/0 or
+	// ::/0), and connections fail when there is none.
+	RemoteDNSAlways = "always"
+)
+
+// IsUnixSocket reports whether the SOCKS5 address is a UNIX socket path.
+func (c SOCKS5Config) IsUnixSocket() bool {
+	return strings.HasPrefix(c.Address, "unix://")
+}
+
+// ParseSocketMode parses SocketMode as an octal permission mode.
+// Returns 0 when no mode is configured.
+func (c SOCKS5Config) ParseSocketMode() (os.FileMode, error) {
+	if c.SocketMode == "" {
+		return 0, nil
+	}
+	mode, err := strconv.ParseUint(c.SocketMode, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, fmt.Errorf("invalid socket mode %q: must be octal permissions such as 0660", c.SocketMode)
+	}
+	return os.FileMode(mode), nil
 }
 
 // WebSocketSOCKS5Config defines WebSocket SOCKS5 listener settings.
@@ -482,6 +794,10 @@ type WebSocketSOCKS5Config struct {
 	// Address is the listen address (e.g., "0.0.0.0:8443" or "127.0.0.1:8081").
 	Address string `yaml:"address,omitempty"`
 
+	// AddressFamily selects the IP families accepted: "dual" (default),
+	// "ipv4" or "ipv6".
+	AddressFamily string `yaml:"address_family,omitempty"`
+
 	// Path is the WebSocket upgrade path (default: "/socks5").
 	Path string `yaml:"path,omitempty"`
 
@@ -495,6 +811,39 @@ type WebSocketSOCKS5Config struct {
 type SOCKS5AuthConfig struct {
 	Enabled bool               `yaml:"enabled,omitempty"`
 	Users   []SOCKS5UserConfig `yaml:"users,omitempty"`
+	// External checks credentials not accepted by Users against an HTTP
+	// webhook or an OS command.
+	External SOCKS5ExternalAuthConfig `yaml:"external,omitempty"`
+}
+
+// SOCKS5ExternalAuthConfig defines SOCKS5 credential checks delegated to an
+// HTTP webhook or an OS command.
+type SOCKS5ExternalAuthConfig struct {
+	// Type is "http" or "command". Empty disables external authentication.
+	Type string `yaml:"type,omitempty"`
+
+	// URL receives a POST with a JSON body {"username", "password"}. A 2xx
+	// status accepts, 401 or 403 rejects (http only).
+	URL string `yaml:"url,omitempty"`
+
+	// Headers are added to every webhook request, e.g. an API key (http only).
+	Headers map[string]string `yaml:"headers,omitempty"`
+
+	// Command is the program and its arguments. It reads the username and
+	// password from stdin, one per line; exit status 0 accepts (command only).
+	Command []string `yaml:"command,omitempty"`
+
+	// Timeout limits each check.
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+
+	// CacheTTL and NegativeCacheTTL are how long accepted and rejected
+	// credentials are remembered (0 = not cached).
+	CacheTTL         time.Duration `yaml:"cache_ttl,omitempty"`
+	NegativeCacheTTL time.Duration `yaml:"negative_cache_ttl,omitempty"`
+
+	// AllowExitSelection lets every externally authenticated user pick the
+	// exit agent per connection (see SOCKS5UserConfig.AllowExitSelection).
+	AllowExitSelection bool `yaml:"allow_exit_selection,omitempty"`
 }
 
 // SOCKS5UserConfig defines a SOCKS5 user.
@@ -505,36 +854,402 @@ type SOCKS5UserConfig struct {
 	// PasswordHash is the bcrypt hash of the password (recommended).
 	// Generate with: htpasswd -bnBC 10 "" <password> | tr -d ':\n'
 	PasswordHash string `yaml:"password_hash,omitempty"`
+	// AllowExitSelection lets this user pick the exit agent per connection
+	// by logging in as "<username>@agent:<agent-id-prefix or display name>".
+	AllowExitSelection bool `yaml:"allow_exit_selection,omitempty"`
+	// ExpiresAt rejects logins from this time on (RFC 3339, zero = never).
+	ExpiresAt time.Time `yaml:"expires_at,omitempty"`
+	// QuotaBytes and QuotaConnections limit the CONNECT traffic (both
+	// directions) and the logins of this user until its usage is reset
+	// (0 = unlimited).
+	QuotaBytes       uint64 `yaml:"quota_bytes,omitempty"`
+	QuotaConnections uint64 `yaml:"quota_connections,omitempty"`
+	// Namespace is the tenant namespace of this user's connections, which
+	// only use exits in the same namespace (default: agent.namespace).
+	Namespace string `yaml:"namespace,omitempty"`
 }
 
 // ExitConfig defines exit node settings.
 type ExitConfig struct {
-	Enabled      bool      `yaml:"enabled,omitempty"`
-	Routes       []string  `yaml:"routes,omitempty"`        // CIDR routes to advertise
-	DomainRoutes []string  `yaml:"domain_routes,omitempty"` // Domain patterns to advertise (exact or *.wildcard)
-	DNS          DNSConfig `yaml:"dns,omitempty"`
+	Enabled      bool           `yaml:"enabled,omitempty"`
+	Routes       []string       `yaml:"routes,omitempty"`        // CIDR routes to advertise
+	DomainRoutes []string       `yaml:"domain_routes,omitempty"` // Domain patterns to advertise (exact or *.wildcard)
+	Tags         []string       `yaml:"tags,omitempty"`          // Tags advertised in node info (e.g., "exit:residential")
+	DNS          DNSConfig      `yaml:"dns,omitempty"`
+	Pool         ExitPoolConfig `yaml:"pool,omitempty"`
+
+	// ConnectTimeout limits each outbound connection to a destination.
+	ConnectTimeout time.Duration `yaml:"connect_timeout,omitempty"`
+
+	// HappyEyeballs races IPv6 and IPv4 connection attempts (RFC 8305) when a
+	// destination resolves to both families.
+	HappyEyeballs ExitHappyEyeballsConfig `yaml:"happy_eyeballs,omitempty"`
+
+	// DestinationStats aggregates connections and bytes per destination over
+	// a sliding window and warns about or blocks destinations over threshold.
+	DestinationStats ExitDestStatsConfig `yaml:"destination_stats,omitempty"`
+
+	// HalfClose bounds how long a stream stays open after the client or the
+	// destination has finished sending.
+	HalfClose ExitHalfCloseConfig `yaml:"half_close,omitempty"`
+
+	// HealthCheck withdraws the exit's routes while it cannot reach any of
+	// its probe targets, so ingresses fail over to other exits.
+	HealthCheck ExitHealthCheckConfig `yaml:"health_check,omitempty"`
+
+	// BindAddress and BindInterface select the source of outbound TCP, UDP
+	// and ICMP traffic on multi-homed exit hosts. The interface may be a VRF
+	// device (Linux only).
+	BindAddress   string `yaml:"bind_address,omitempty"`
+	BindInterface string `yaml:"bind_interface,omitempty"`
+
+	// RouteBinds override the source for destinations within a CIDR. The
+	// most specific matching route wins.
+	RouteBinds []ExitRouteBind `yaml:"route_binds,omitempty"`
+
+	// BlockPrivate refuses TCP, UDP and ICMP destinations in private,
+	// loopback, link-local and multicast ranges, including IPv4 addresses
+	// embedded in NAT64 and 6to4 addresses, even when a route covers them,
+	// so a public exit does not expose the network it runs in. AllowPrivate
+	// lists CIDR exceptions.
+	BlockPrivate bool     `yaml:"block_private,omitempty"`
+	AllowPrivate []string `yaml:"allow_private,omitempty"`
+
+	// PortLimits blocks or caps connections per destination port class.
+	// SMTP is blocked by default on exits with a default route, which get
+	// blacklisted for spam otherwise.
+	PortLimits ExitPortLimitsConfig `yaml:"port_limits,omitempty"`
+
+	// ProxyProtocol lists destination CIDRs whose connections start with a
+	// PROXY protocol v2 header carrying the client address, for servers
+	// that log or filter on it. Ingresses share the address with
+	// send_client_address; without it the header says the client is unknown.
+	ProxyProtocol []string `yaml:"proxy_protocol,omitempty"`
+
+	// UserPolicy narrows the allowed destinations per SOCKS5 user, as
+	// shared by ingresses with send_username.
+	UserPolicy ExitUserPolicyConfig `yaml:"user_policy,omitempty"`
+
+	// AuditLog logs every stream open, close and user policy refusal with
+	// the user and client address shared by the ingress.
+	AuditLog bool `yaml:"audit_log,omitempty"`
+}
+
+// ExitPortLimitsConfig defines destination port classes on exit nodes. A
+// port belongs to the first class listing it, SMTP first. When Enabled is
+// unset, the classes apply while the exit allows 0.0.0.0/0 or ::/0.
+type ExitPortLimitsConfig struct {
+	Enabled *bool                 `yaml:"enabled,omitempty"`
+	SMTP    ExitPortClassConfig   `yaml:"smtp,omitempty"`    // Ports 25, 465 and 587 unless set
+	Classes []ExitPortClassConfig `yaml:"classes,omitempty"` // Additional classes
+}
+
+// ExitPortClassConfig restricts connections to a set of destination ports.
+// With action "limit", zero values mean no limit.
+type ExitPortClassConfig struct {
+	Name           string   `yaml:"name,omitempty"`
+	Ports          []uint16 `yaml:"ports,omitempty"`
+	Action         string   `yaml:"action,omitempty"`          // "block" (default), "limit" or "allow"
+	MaxConnections int      `yaml:"max_connections,omitempty"` // Concurrent connections to the class
+	Rate           float64  `yaml:"rate,omitempty"`            // New connections per second
+	Burst          int      `yaml:"burst,omitempty"`           // Burst of new connections (at least 1)
+}
+
+// ExitUserPolicyConfig defines per-user egress policy on exit nodes. Users
+// are limited to their own routes and domain routes, within the exit-wide
+// ones. Streams from users without an entry, or without a username, follow
+// DefaultAction.
+type ExitUserPolicyConfig struct {
+	Enabled       bool                      `yaml:"enabled,omitempty"`
+	DefaultAction string                    `yaml:"default_action,omitempty"` // "allow" or "deny" (default)
+	Users         map[string]ExitUserRoutes `yaml:"users,omitempty"`
+}
+
+// ExitUserRoutes lists the destinations one user may reach.
+type ExitUserRoutes struct {
+	Routes       []string `yaml:"routes,omitempty"`        // CIDR routes
+	DomainRoutes []string `yaml:"domain_routes,omitempty"` // Domain patterns (exact or *.wildcard)
+}
+
+// ExitRouteBind overrides the outbound source for one destination CIDR.
+type ExitRouteBind struct {
+	Route         string `yaml:"route"`
+	BindAddress   string `yaml:"bind_address,omitempty"`
+	BindInterface string `yaml:"bind_interface,omitempty"`
+}
+
+// ExitDestStatsConfig defines per-destination accounting on exit nodes.
+type ExitDestStatsConfig struct {
+	Enabled         bool                    `yaml:"enabled,omitempty"`
+	Window          time.Duration           `yaml:"window,omitempty"`           // Length of the sliding window
+	MaxDestinations int                     `yaml:"max_destinations,omitempty"` // Maximum number of tracked destinations
+	Thresholds      ExitDestStatsThresholds `yaml:"thresholds,omitempty"`
+	Action          string                  `yaml:"action,omitempty"`         // "warn" or "block"
+	BlockDuration   time.Duration           `yaml:"block_duration,omitempty"` // How long a destination stays blocked
+}
+
+// ExitDestStatsThresholds configures per-destination limits within the
+// window. A zero value disables the corresponding check.
+type ExitDestStatsThresholds struct {
+	// Connections is the number of stream opens.
+	Connections uint64 `yaml:"connections,omitempty"`
+
+	// Bytes is the total payload bytes in both directions.
+	Bytes uint64 `yaml:"bytes,omitempty"`
+}
+
+// ExitHappyEyeballsConfig defines Happy Eyeballs dialing on exit nodes.
+type ExitHappyEyeballsConfig struct {
+	Enabled bool          `yaml:"enabled"`
+	Delay   time.Duration `yaml:"delay,omitempty"` // Delay before starting the next connection attempt
+}
+
+// ExitPoolConfig defines reuse of idle destination connections on exit nodes.
+// Only suitable for request/response protocols without per-connection
+// session state (e.g., plain HTTP/1.1 with keep-alive).
+type ExitPoolConfig struct {
+	Enabled        bool          `yaml:"enabled,omitempty"`
+	Ports          []uint16      `yaml:"ports,omitempty"`             // Destination ports eligible for pooling
+	MaxIdle        int           `yaml:"max_idle,omitempty"`          // Idle sockets across all destinations
+	MaxIdlePerHost int           `yaml:"max_idle_per_host,omitempty"` // Idle sockets per destination host:port
+	IdleTimeout    time.Duration `yaml:"idle_timeout,omitempty"`      // Idle lifetime before a socket is closed
+}
+
+// ExitHalfCloseConfig limits half-closed exit streams. ClientLinger is how
+// long the destination socket stays open after the client finished sending
+// (0 = until the idle timeout). DestinationLinger is how long it stays open
+// after the destination finished sending, for the rest of the client's data
+// (0 = close at once).
+type ExitHalfCloseConfig struct {
+	ClientLinger      time.Duration `yaml:"client_linger,omitempty"`
+	DestinationLinger time.Duration `yaml:"destination_linger,omitempty"`
+}
+
+// ExitHealthCheckConfig defines the exit self-health check. A check passes
+// when a TCP connection to any target succeeds. After FailureThreshold
+// failed checks in a row the exit withdraws its CIDR and domain routes, and
+// after SuccessThreshold passed checks it announces them again.
+type ExitHealthCheckConfig struct {
+	Enabled          bool          `yaml:"enabled,omitempty"`
+	Targets          []string      `yaml:"targets,omitempty"`           // TCP host:port probe targets
+	Interval         time.Duration `yaml:"interval,omitempty"`          // Time between checks
+	Timeout          time.Duration `yaml:"timeout,omitempty"`           // Connect timeout per target
+	FailureThreshold int           `yaml:"failure_threshold,omitempty"` // Failed checks before routes are withdrawn
+	SuccessThreshold int           `yaml:"success_threshold,omitempty"` // Passed checks before routes are announced again
 }
 
 // DNSConfig defines DNS settings for exit nodes.
 type DNSConfig struct {
 	Servers []string      `yaml:"servers,omitempty"`
 	Timeout time.Duration `yaml:"timeout,omitempty"`
+
+	// Cache keeps resolved addresses for their record TTL so repeated
+	// connections to the same domain skip the lookup.
+	Cache DNSCacheConfig `yaml:"cache,omitempty"`
+
+	// Zones resolves names in internal zones with their own servers, and
+	// everything else with Servers (split-horizon DNS).
+	Zones []DNSZoneConfig `yaml:"zones,omitempty"`
+}
+
+// DNSZoneConfig defines the DNS servers for one zone. Names equal to Zone or
+// below it use Servers; an empty list uses the system resolver. The longest
+// matching zone wins.
+type DNSZoneConfig struct {
+	Zone    string   `yaml:"zone"`
+	Servers []string `yaml:"servers,omitempty"`
+}
+
+// DNSCacheConfig defines the exit DNS cache.
+type DNSCacheConfig struct {
+	Enabled     bool          `yaml:"enabled"`
+	MinTTL      time.Duration `yaml:"min_ttl,omitempty"`      // Lower bound applied to record TTLs
+	MaxTTL      time.Duration `yaml:"max_ttl,omitempty"`      // Upper bound applied to record TTLs
+	DefaultTTL  time.Duration `yaml:"default_ttl,omitempty"`  // Used when the TTL is unknown (system resolver)
+	NegativeTTL time.Duration `yaml:"negative_ttl,omitempty"` // How long NXDOMAIN answers are cached (0 = not cached)
+	MaxEntries  int           `yaml:"max_entries,omitempty"`  // Maximum number of cached domains
 }
 
 // RoutingConfig defines routing parameters.
 type RoutingConfig struct {
-	AdvertiseInterval time.Duration `yaml:"advertise_interval,omitempty"`
-	NodeInfoInterval  time.Duration `yaml:"node_info_interval,omitempty"` // Defaults to AdvertiseInterval if not set
-	RouteTTL          time.Duration `yaml:"route_ttl,omitempty"`
-	MaxHops           int           `yaml:"max_hops,omitempty"`
+	AdvertiseInterval time.Duration       `yaml:"advertise_interval,omitempty"`
+	NodeInfoInterval  time.Duration       `yaml:"node_info_interval,omitempty"` // Defaults to AdvertiseInterval if not set
+	RouteTTL          time.Duration       `yaml:"route_ttl,omitempty"`
+	MaxHops           int                 `yaml:"max_hops,omitempty"`
+	FloodBatching     FloodBatchingConfig `yaml:"flood_batching,omitempty"`
+	Aggregation       AggregationConfig   `yaml:"aggregation,omitempty"`
+	PathProbe         PathProbeConfig     `yaml:"path_probe,omitempty"`
+	KernelRoutes      KernelRoutesConfig  `yaml:"kernel_routes,omitempty"`
+	Dampening         DampeningConfig     `yaml:"dampening,omitempty"`
+	PeerRateLimit     PeerRateLimitConfig `yaml:"peer_rate_limit,omitempty"`
+
+	// RequireSigned drops route advertisements and withdrawals that are not
+	// signed by their origin agent. Agents always sign their own routes.
+	RequireSigned bool `yaml:"require_signed,omitempty"`
+
+	// RouteKeys maps origin agent IDs (hex) to the Ed25519 public keys
+	// (hex) they sign route updates with. Only signatures by the listed
+	// key of an origin count; updates from origins not listed are treated
+	// as unsigned.
+	RouteKeys map[string]string `yaml:"route_keys,omitempty"`
+
+	// FastReroute keeps loop-free alternate next hops for CIDR routes, so
+	// routes switch to an alternate as soon as their next hop disconnects
+	// instead of waiting for a withdrawal or route_ttl.
+	FastReroute bool `yaml:"fast_reroute,omitempty"`
+
+	// PreferTags ranks routes to the same prefix by the exit tags of their
+	// origin, most preferred first, before metric. Routes from exits with
+	// none of the tags come last.
+	PreferTags []string `yaml:"prefer_tags,omitempty"`
+
+	// Metric selects how routes to the same prefix are compared: "hops"
+	// (advertised hop count), "latency" (measured RTT to the next hop plus
+	// an estimate per further hop) or "hybrid" (latency plus a penalty per
+	// hop).
+	Metric string `yaml:"metric,omitempty"`
+}
+
+// PathProbeConfig defines end-to-end liveness probes for learned routes.
+// Every Interval, each distinct (origin, next hop) path is probed with a
+// control echo to the route origin. After FailureThreshold consecutive
+// probes go unanswered within Timeout, routes on that path are marked
+// unreachable and lookups fail over to alternate next hops.
+type PathProbeConfig struct {
+	Enabled          bool          `yaml:"enabled,omitempty"`
+	Interval         time.Duration `yaml:"interval,omitempty"`          // Time between probe rounds
+	Timeout          time.Duration `yaml:"timeout,omitempty"`           // Time to wait for a probe reply
+	FailureThreshold int           `yaml:"failure_threshold,omitempty"` // Consecutive failures before a path is marked down
+}
+
+// KernelRoutesConfig defines installation of mesh-reachable prefixes into
+// the host routing table. Routes point at Interface, usually the TUN device
+// of Mutiauk, and are removed when the mesh withdraws them.
+type KernelRoutesConfig struct {
+	Enabled   bool     `yaml:"enabled,omitempty"`
+	Interface string   `yaml:"interface,omitempty"` // Device the routes point at (required)
+	Metric    int      `yaml:"metric,omitempty"`    // Route metric (0 = system default)
+	Table     int      `yaml:"table,omitempty"`     // Linux routing table (0 = main)
+	Exclude   []string `yaml:"exclude,omitempty"`   // CIDR prefixes never installed
+
+	// DefaultRoute installs 0.0.0.0/0 and ::/0 when the mesh has them.
+	// Off by default: a default route through the TUN device also captures
+	// the agent's own peer connections unless they are routed around it.
+	DefaultRoute bool `yaml:"default_route,omitempty"`
+}
+
+// DampeningConfig defines route flap dampening per origin agent. Each
+// withdrawal, and each advertisement that changes the origin's route set,
+// adds 1 to the origin's penalty, which halves every HalfLife. While the
+// penalty exceeds MaxFlaps, advertisements from the origin are neither
+// applied nor flooded. The origin is released once the penalty has decayed
+// below half of MaxFlaps, or after MaxSuppress.
+type DampeningConfig struct {
+	Enabled     bool          `yaml:"enabled,omitempty"`
+	MaxFlaps    int           `yaml:"max_flaps,omitempty"`    // Penalty above which the origin is suppressed
+	HalfLife    time.Duration `yaml:"half_life,omitempty"`    // Time for the penalty to decay by half
+	MaxSuppress time.Duration `yaml:"max_suppress,omitempty"` // Longest time an origin stays suppressed
+}
+
+// PeerRateLimitConfig defines a token bucket limit on the route
+// advertisements and withdrawals accepted from each peer. Updates over the
+// limit are dropped and picked up again with the next periodic
+// advertisement.
+type PeerRateLimitConfig struct {
+	Enabled bool    `yaml:"enabled,omitempty"`
+	Rate    float64 `yaml:"rate,omitempty"`  // Route updates per second
+	Burst   int     `yaml:"burst,omitempty"` // Updates accepted at once, such as a full table on connect
+}
+
+// AggregationConfig defines summarization of this agent's CIDR routes
+// before they are advertised. Adjacent prefixes with the same metric are
+// merged into covering prefixes, and prefixes covered by another local
+// route are dropped. Groups limit aggregation to routes inside the listed
+// prefixes and keep summaries from growing beyond them.
+type AggregationConfig struct {
+	Enabled bool     `yaml:"enabled,omitempty"`
+	Groups  []string `yaml:"groups,omitempty"` // CIDR prefixes to aggregate within (empty = all routes)
+}
+
+// FloodBatchingConfig defines batching of received route updates. Updates
+// arriving within Window are merged and applied by a pool of Workers, so a
+// burst of advertisements causes one table update and one outbound
+// advertisement per origin and peer instead of one per update.
+type FloodBatchingConfig struct {
+	Enabled bool          `yaml:"enabled,omitempty"`
+	Window  time.Duration `yaml:"window,omitempty"`  // Time to collect updates before applying them
+	Workers int           `yaml:"workers,omitempty"` // Goroutines applying batched updates
 }
 
 // ConnectionsConfig defines connection tuning parameters.
 type ConnectionsConfig struct {
-	IdleThreshold   time.Duration   `yaml:"idle_threshold,omitempty"`
-	Timeout         time.Duration   `yaml:"timeout,omitempty"`
-	KeepaliveJitter float64         `yaml:"keepalive_jitter,omitempty"` // Jitter fraction for keepalive timing (0.0-1.0)
-	Reconnect       ReconnectConfig `yaml:"reconnect,omitempty"`
+	IdleThreshold   time.Duration       `yaml:"idle_threshold,omitempty"`
+	Timeout         time.Duration       `yaml:"timeout,omitempty"`
+	KeepaliveJitter float64             `yaml:"keepalive_jitter,omitempty"` // Jitter fraction for keepalive timing (0.0-1.0)
+	Reconnect       ReconnectConfig     `yaml:"reconnect,omitempty"`
+	WriteBatching   WriteBatchingConfig `yaml:"write_batching,omitempty"`
+	QoS             QoSConfig           `yaml:"qos,omitempty"`
+	NATTraversal    NATTraversalConfig  `yaml:"nat_traversal,omitempty"`
+	AcceptLimits    AcceptLimitsConfig  `yaml:"accept_limits,omitempty"`
+
+	// MaxFrameSize limits the frame payloads per transport (quic, h2, ws,
+	// wt, tcp) in bytes. The lower limit of both ends of a link applies.
+	MaxFrameSize map[string]int `yaml:"max_frame_size,omitempty"`
+}
+
+// WriteBatchingConfig defines outbound frame coalescing on peer connections.
+// Frames written within FlushDelay of each other are combined into a single
+// transport write, trading up to FlushDelay of latency for fewer syscalls
+// and larger writes on high-latency links.
+type WriteBatchingConfig struct {
+	Enabled    bool          `yaml:"enabled,omitempty"`
+	Transports []string      `yaml:"transports,omitempty"`  // Transports to batch on (quic, h2, ws, wt, tcp)
+	FlushDelay time.Duration `yaml:"flush_delay,omitempty"` // Max time a frame waits before being written
+	MaxBytes   int           `yaml:"max_bytes,omitempty"`   // Write immediately once this many bytes are buffered
+}
+
+// QoSConfig defines outbound frame scheduling by traffic class on peer
+// connections. Control frames (keepalives, handshakes, route updates) are
+// always written first; RPC (shells, control requests), interactive (proxied
+// TCP, ICMP) and bulk (file transfers, UDP) frames share the link by weight.
+type QoSConfig struct {
+	Enabled bool       `yaml:"enabled,omitempty"`
+	Weights QoSWeights `yaml:"weights,omitempty"`
+}
+
+// QoSWeights defines the share of a contended link per traffic class.
+type QoSWeights struct {
+	RPC         int `yaml:"rpc,omitempty"`
+	Interactive int `yaml:"interactive,omitempty"`
+	Bulk        int `yaml:"bulk,omitempty"`
+}
+
+// NATTraversalConfig defines hole punching between agents that reach each
+// other through a common transit peer. Every Interval, the agent with the
+// lower ID in each such pair exchanges the public QUIC endpoints their
+// transit peers observe, and both sides punch through their NATs so the
+// relayed path can be upgraded to a direct QUIC link. Requires a QUIC
+// listener on both agents.
+type NATTraversalConfig struct {
+	Enabled       bool          `yaml:"enabled,omitempty"`
+	Interval      time.Duration `yaml:"interval,omitempty"`       // Time between searches for relayed agents
+	PunchTimeout  time.Duration `yaml:"punch_timeout,omitempty"`  // Time to wait for a direct link to one endpoint
+	RetryInterval time.Duration `yaml:"retry_interval,omitempty"` // Time before retrying an agent after a failure
+	MaxDirect     int           `yaml:"max_direct,omitempty"`     // Maximum number of punched links
+}
+
+// AcceptLimitsConfig protects listeners against connection floods. It
+// limits the connections accepted per source IP and the connections in the
+// handshake, closes connections that do not finish the handshake in time,
+// and makes QUIC clients validate their address while many handshakes are
+// pending.
+type AcceptLimitsConfig struct {
+	Enabled          bool          `yaml:"enabled,omitempty"`
+	PerIPRate        float64       `yaml:"per_ip_rate,omitempty"`          // New connections per second per source IP (0 = no limit)
+	PerIPBurst       int           `yaml:"per_ip_burst,omitempty"`         // Connections a source IP may open at once
+	MaxPending       int           `yaml:"max_pending,omitempty"`          // Connections in the handshake at once (0 = no limit)
+	HandshakeTimeout time.Duration `yaml:"handshake_timeout,omitempty"`    // Close accepted connections without PEER_HELLO by then
+	RetryThreshold   int           `yaml:"quic_retry_threshold,omitempty"` // Pending handshakes from which QUIC sends Retry (0 = never)
 }
 
 // ReconnectConfig defines reconnection behavior.
@@ -555,6 +1270,29 @@ type LimitsConfig struct {
 	BufferSize        int           `yaml:"buffer_size,omitempty"`
 }
 
+// Lower bounds of e2e.rekey, so sessions do not spend their time on key
+// exchanges.
+const (
+	MinRekeyBytes    = 1 << 20 // 1 MiB
+	MinRekeyInterval = 10 * time.Second
+)
+
+// E2EConfig defines end-to-end encryption settings.
+type E2EConfig struct {
+	Rekey E2ERekeyConfig `yaml:"rekey,omitempty"`
+}
+
+// E2ERekeyConfig defines in-band rekeying of long-lived E2E sessions: TCP
+// streams, port forwards, UDP associations and ICMP sessions. The agent
+// replaces the key of each direction it sends after Bytes of data or
+// Interval, whichever comes first, through a new ephemeral key exchange.
+// Sessions with agents that do not support rekeying keep their key.
+type E2ERekeyConfig struct {
+	Enabled  bool          `yaml:"enabled"`
+	Bytes    uint64        `yaml:"bytes,omitempty"`    // Data sent with one key (0 = no limit)
+	Interval time.Duration `yaml:"interval,omitempty"` // Lifetime of one key (0 = no limit)
+}
+
 // HTTPConfig defines HTTP API server settings.
 type HTTPConfig struct {
 	Enabled      bool          `yaml:"enabled,omitempty"`
@@ -562,12 +1300,20 @@ type HTTPConfig struct {
 	ReadTimeout  time.Duration `yaml:"read_timeout,omitempty"`
 	WriteTimeout time.Duration `yaml:"write_timeout,omitempty"`
 
+	// AddressFamily selects the IP families accepted: "dual" (default),
+	// "ipv4" or "ipv6".
+	AddressFamily string `yaml:"address_family,omitempty"`
+
 	// TokenHash is a bcrypt hash of the API bearer token.
 	// When set, all non-health endpoints require a valid Authorization: Bearer <token> header
-	// or ?token=<token> query parameter. Health endpoints (/health, /healthz, /ready) are exempt.
+	// or ?token=<token> query parameter. Health endpoints (/health, /healthz, /ready, /livez, /readyz) are exempt.
 	TokenHash string `yaml:"token_hash,omitempty"`
 
-	// Minimal mode - only enable /health, /healthz, /ready endpoints.
+	// Tokens are additional bearer tokens, each granting a role (viewer,
+	// operator or admin). TokenHash above grants admin.
+	Tokens []APITokenConfig `yaml:"tokens,omitempty"`
+
+	// Minimal mode - only enable /health, /healthz, /ready, /livez, /readyz endpoints.
 	// When true, overrides all other endpoint flags to false.
 	Minimal bool `yaml:"minimal,omitempty"`
 
@@ -577,6 +1323,20 @@ type HTTPConfig struct {
 	Pprof     *bool `yaml:"pprof,omitempty"`      // /debug/pprof/* - Go profiling endpoints
 	Dashboard *bool `yaml:"dashboard,omitempty"`  // /api/* - Dashboard API endpoints
 	RemoteAPI *bool `yaml:"remote_api,omitempty"` // /agents/* - Distributed mesh APIs
+
+	// Readiness sets when /readyz reports the agent ready, so probes and
+	// load balancers hold traffic until the agent has joined the mesh.
+	Readiness HTTPReadinessConfig `yaml:"readiness,omitempty"`
+}
+
+// HTTPReadinessConfig defines the /readyz criteria. The agent is ready when
+// it is running, at least MinPeers peers are connected, and every listed
+// route is in the routing table.
+type HTTPReadinessConfig struct {
+	MinPeers     int      `yaml:"min_peers"`               // Connected peers required (0 = none)
+	Routes       []string `yaml:"routes,omitempty"`        // CIDR routes that must be present
+	DomainRoutes []string `yaml:"domain_routes,omitempty"` // Domain names that must match a domain route
+	Forwards     []string `yaml:"forwards,omitempty"`      // Port forward keys that must have an endpoint
 }
 
 // PprofEnabled returns whether the /debug/pprof/* endpoints are enabled.
@@ -605,7 +1365,105 @@ func (h HTTPConfig) RemoteAPIEnabled() bool {
 
 // AuthEnabled returns whether bearer token authentication is enabled for the HTTP API.
 func (h HTTPConfig) AuthEnabled() bool {
-	return h.TokenHash != ""
+	return h.TokenHash != "" || len(h.Tokens) > 0
+}
+
+// APITokenConfig is an HTTP API bearer token with a role.
+type APITokenConfig struct {
+	// Name identifies the token in logs.
+	Name string `yaml:"name,omitempty"`
+
+	// TokenHash is a bcrypt hash of the token.
+	TokenHash string `yaml:"token_hash"`
+
+	// Role is viewer, operator or admin.
+	Role string `yaml:"role"`
+}
+
+// RBACConfig limits control requests relayed over the mesh by role.
+// Requests carry the role of the HTTP caller that issued them; each agent
+// lowers it to the role of the peer the request arrived from. Shell and
+// file transfer stream opens are checked against the peer role directly.
+type RBACConfig struct {
+	// PeerRoles maps client certificate organizational units (OU) of
+	// peers to the highest role of requests they relay. A peer with
+	// several mapped OUs gets the highest of their roles.
+	PeerRoles map[string]string `yaml:"peer_roles,omitempty"`
+
+	// DefaultPeerRole applies to peers without a mapped OU, including
+	// peers that present no certificate.
+	// Default: "admin".
+	DefaultPeerRole string `yaml:"default_peer_role,omitempty"`
+
+	// LegacyRole applies to requests that carry no role (sent by agents
+	// that predate roles).
+	// Default: "admin".
+	LegacyRole string `yaml:"legacy_role,omitempty"`
+}
+
+// ChaosConfig enables fault injection on peer links for testing how the
+// mesh handles slow, lossy and partitioned links. Faults apply to frames
+// this agent receives; partitions cut both directions. Both can be changed
+// at runtime through the chaos/manage API. Never enable in production.
+type ChaosConfig struct {
+	// Enabled wraps all transports with the fault injection layer. Without
+	// it the chaos/manage API is unavailable.
+	Enabled bool `yaml:"enabled,omitempty"`
+
+	// Latency is added to every received frame.
+	Latency time.Duration `yaml:"latency,omitempty"`
+
+	// Jitter is a random extra delay of up to Jitter per frame. Frames are
+	// never reordered.
+	Jitter time.Duration `yaml:"jitter,omitempty"`
+
+	// Loss is the chance (0.0 to 1.0) that a received frame is dropped.
+	Loss float64 `yaml:"loss,omitempty"`
+
+	// Seed makes loss and jitter repeatable. Zero seeds from the clock.
+	Seed int64 `yaml:"seed,omitempty"`
+}
+
+// DebugConfig controls the transport debug capture of peer connections:
+// TLS session secrets in key log format (SSLKEYLOGFILE) for Wireshark and
+// QUIC qlog traces for qvis. A capture can also be started at runtime for a
+// limited time through the debug-capture/manage API. Anyone holding the key
+// log can decrypt the captured transport layer, so treat it as a secret.
+type DebugConfig struct {
+	// KeyLog writes TLS session secrets from startup.
+	KeyLog bool `yaml:"key_log,omitempty"`
+
+	// Qlog writes a qlog trace per QUIC and WebTransport peer connection
+	// from startup.
+	Qlog bool `yaml:"qlog,omitempty"`
+
+	// Duration ends the capture started from the config after this long.
+	// Zero captures until stopped through the API.
+	Duration time.Duration `yaml:"duration,omitempty"`
+
+	// KeyLogFile is the key log path. Default: <data_dir>/debug/keylog.txt.
+	KeyLogFile string `yaml:"key_log_file,omitempty"`
+
+	// QlogDir is the qlog trace directory. Default: <data_dir>/debug/qlog.
+	QlogDir string `yaml:"qlog_dir,omitempty"`
+}
+
+// DebugKeyLogFile returns the effective debug key log path, or "" when
+// neither debug.key_log_file nor agent.data_dir is set.
+func (c *Config) DebugKeyLogFile() string {
+	if c.Debug.KeyLogFile != "" || c.Agent.DataDir == "" {
+		return c.Debug.KeyLogFile
+	}
+	return filepath.Join(c.Agent.DataDir, "debug", "keylog.txt")
+}
+
+// DebugQlogDir returns the effective qlog directory, or "" when neither
+// debug.qlog_dir nor agent.data_dir is set.
+func (c *Config) DebugQlogDir() string {
+	if c.Debug.QlogDir != "" || c.Agent.DataDir == "" {
+		return c.Debug.QlogDir
+	}
+	return filepath.Join(c.Agent.DataDir, "debug", "qlog")
 }
 
 // FileTransferConfig defines file transfer settings.
@@ -648,6 +1506,11 @@ type ShellConfig struct {
 	// Commands should be base names only (e.g., "whoami", "ls", "bash").
 	Whitelist []string `yaml:"whitelist,omitempty"`
 
+	// Rules restrict the arguments and environment of specific commands,
+	// evaluated on this agent. A command with rules is allowed when any of
+	// its rules matches, whether or not it is in Whitelist.
+	Rules []ShellRule `yaml:"rules,omitempty"`
+
 	// PasswordHash is the bcrypt hash of the shell password.
 	// If set, all shell requests must include the correct password.
 	// Generate with: muti-metroo hash
@@ -658,6 +1521,65 @@ type ShellConfig struct {
 
 	// MaxSessions limits concurrent shell sessions (0 = unlimited).
 	MaxSessions int `yaml:"max_sessions,omitempty"`
+
+	// Sandbox confines commands with landlock, seccomp and resource
+	// limits (Linux only).
+	Sandbox ShellSandboxConfig `yaml:"sandbox,omitempty"`
+
+	// RunAs runs commands under another account than the agent's.
+	RunAs ShellRunAsConfig `yaml:"run_as,omitempty"`
+}
+
+// ShellRunAsConfig selects the account shell commands execute as. Switching
+// accounts needs an agent running as root (Unix) or LocalSystem (Windows).
+type ShellRunAsConfig struct {
+	User     string `yaml:"user,omitempty"`     // User name or numeric ID (empty = the agent's account)
+	Group    string `yaml:"group,omitempty"`    // Unix: group name or numeric ID (default: the user's primary group)
+	Password string `yaml:"password,omitempty"` // Windows: password of user
+
+	// AllowedUsers may be requested per command instead of User (Unix
+	// only). Empty = per-command overrides are denied.
+	AllowedUsers []string `yaml:"allowed_users,omitempty"`
+}
+
+// ShellSandboxConfig restricts what shell commands can access.
+type ShellSandboxConfig struct {
+	Enabled bool `yaml:"enabled,omitempty"`
+
+	// ReadOnly paths may be read and executed, ReadWrite paths also
+	// modified; the rest of the filesystem is inaccessible.
+	ReadOnly  []string `yaml:"read_only,omitempty"`
+	ReadWrite []string `yaml:"read_write,omitempty"`
+
+	// Seccomp blocks ptrace, mounts, module loading and similar system
+	// calls (default true).
+	Seccomp *bool `yaml:"seccomp,omitempty"`
+
+	// Per-process resource limits (0 = unlimited).
+	MaxMemory    int64         `yaml:"max_memory,omitempty"`     // Address space in bytes
+	MaxProcesses int           `yaml:"max_processes,omitempty"`  // Processes of the agent's user
+	MaxOpenFiles int           `yaml:"max_open_files,omitempty"` // Open file descriptors
+	MaxFileSize  int64         `yaml:"max_file_size,omitempty"`  // Size of written files in bytes
+	CPUTime      time.Duration `yaml:"cpu_time,omitempty"`       // CPU time per process
+}
+
+// ShellRule allows a command only with matching arguments and environment.
+type ShellRule struct {
+	// Command is the base command name (e.g., "systemctl").
+	Command string `yaml:"command"`
+
+	// Args are glob patterns ("*" and "?") matched against the arguments
+	// joined by single spaces (e.g., "status *"). Empty with no ArgsRegex
+	// allows any arguments.
+	Args []string `yaml:"args,omitempty"`
+
+	// ArgsRegex are regular expressions matched against the joined
+	// arguments, anchored at both ends.
+	ArgsRegex []string `yaml:"args_regex,omitempty"`
+
+	// Env lists environment variables the caller may set.
+	// Empty = none, ["*"] = any.
+	Env []string `yaml:"env,omitempty"`
 }
 
 // UDPConfig configures UDP relay support for exit nodes.
@@ -675,6 +1597,30 @@ type UDPConfig struct {
 	// MaxDatagramSize is the maximum UDP payload size in bytes.
 	// Default is 1472 (Ethernet MTU minus IP and UDP headers).
 	MaxDatagramSize int `yaml:"max_datagram_size,omitempty"`
+
+	// LogThresholds logs a warning for associations exceeding any of the
+	// configured limits. Each association is logged at most once.
+	LogThresholds UDPLogThresholds `yaml:"log_thresholds,omitempty"`
+
+	// Filtering selects which remote endpoints may send datagrams back to
+	// the client through an association: "full-cone" (any endpoint, the
+	// default, needed for STUN/WebRTC), "restricted" (IP addresses the
+	// client sent to) or "port-restricted" (IP address and port pairs the
+	// client sent to).
+	Filtering string `yaml:"filtering,omitempty"`
+}
+
+// UDPLogThresholds configures per-association traffic thresholds for logging.
+// A zero value disables the corresponding check.
+type UDPLogThresholds struct {
+	// Bytes is the total payload bytes in both directions.
+	Bytes uint64 `yaml:"bytes,omitempty"`
+
+	// Datagrams is the total datagram count in both directions.
+	Datagrams uint64 `yaml:"datagrams,omitempty"`
+
+	// Endpoints is the number of distinct remote endpoints contacted.
+	Endpoints int `yaml:"endpoints,omitempty"`
 }
 
 // ICMPConfig configures ICMP echo (ping) support for exit nodes.
@@ -691,6 +1637,24 @@ type ICMPConfig struct {
 
 	// EchoTimeout is the timeout for each individual ICMP echo request.
 	EchoTimeout time.Duration `yaml:"echo_timeout,omitempty"`
+
+	// MaxSessionsPerPeer limits concurrent sessions opened through one peer
+	// (0 = unlimited).
+	MaxSessionsPerPeer int `yaml:"max_sessions_per_peer,omitempty"`
+
+	// DestRate limits echo requests per second to each destination IP
+	// (0 = unlimited). Excess echoes are dropped.
+	DestRate float64 `yaml:"dest_rate,omitempty"`
+
+	// DestBurst is the per-destination burst (0 = one second of dest_rate).
+	DestBurst int `yaml:"dest_burst,omitempty"`
+
+	// GlobalRate limits echo requests per second across all sessions
+	// (0 = unlimited). Excess echoes are dropped.
+	GlobalRate float64 `yaml:"global_rate,omitempty"`
+
+	// GlobalBurst is the global burst (0 = one second of global_rate).
+	GlobalBurst int `yaml:"global_burst,omitempty"`
 }
 
 // ForwardConfig configures TCP port forwarding.
@@ -706,6 +1670,20 @@ type ForwardConfig struct {
 	// Each listener binds to a local address and forwards connections to the
 	// agent with the matching routing key.
 	Listeners []ForwardListener `yaml:"listeners,omitempty"`
+
+	// Hostnames selects where listener hostnames are registered.
+	Hostnames ForwardHostnamesConfig `yaml:"hostnames,omitempty"`
+}
+
+// ForwardHostnamesConfig configures how the hostnames of forward listeners
+// are made resolvable on the local network or host.
+type ForwardHostnamesConfig struct {
+	// MDNS answers multicast DNS queries for ".local" listener hostnames.
+	MDNS bool `yaml:"mdns,omitempty"`
+
+	// HostsFile is a hosts file (e.g. "/etc/hosts") in which listener
+	// hostnames are written while their listener runs. Empty disables it.
+	HostsFile string `yaml:"hosts_file,omitempty"`
 }
 
 // ForwardEndpoint defines a port forward exit point configuration.
@@ -718,6 +1696,10 @@ type ForwardEndpoint struct {
 	// Target is the fixed destination host:port for forwarded connections.
 	// Example: "localhost:3000" or "192.168.1.10:8080"
 	Target string `yaml:"target,omitempty"`
+
+	// ProxyProtocol starts connections to the target with a PROXY protocol
+	// v2 header carrying the client address shared by the listener.
+	ProxyProtocol bool `yaml:"proxy_protocol,omitempty"`
 }
 
 // ForwardListener defines a port forward ingress point configuration.
@@ -732,8 +1714,215 @@ type ForwardListener struct {
 	// Example: ":8080" or "127.0.0.1:8080"
 	Address string `yaml:"address,omitempty"`
 
+	// AddressFamily selects the IP families accepted: "dual" (default),
+	// "ipv4" or "ipv6".
+	AddressFamily string `yaml:"address_family,omitempty"`
+
 	// MaxConnections limits concurrent connections (0 = unlimited).
 	MaxConnections int `yaml:"max_connections,omitempty"`
+
+	// TLS terminates TLS on the listener with its own certificate. The
+	// endpoint receives the decrypted stream.
+	TLS ForwardListenerTLS `yaml:"tls,omitempty"`
+
+	// SNIMap routes connections by TLS server name to other routing keys.
+	// Names are exact or "*.example.com" (one label). Connections without
+	// a match use Key. Without tls.enabled the ClientHello is only read,
+	// and TLS passes through to the endpoint.
+	SNIMap map[string]string `yaml:"sni_map,omitempty"`
+
+	// Hostname is registered for the listener address while it runs, via
+	// mDNS and/or the hosts file set in forward.hostnames.
+	// Example: "git.mesh.local"
+	Hostname string `yaml:"hostname,omitempty"`
+
+	// SendClientAddress shares the client address with the endpoint, for
+	// endpoints with proxy_protocol. Transit agents can read it.
+	SendClientAddress bool `yaml:"send_client_address,omitempty"`
+
+	// Namespace is the tenant namespace whose endpoints the listener
+	// connects to (default: agent.namespace).
+	Namespace string `yaml:"namespace,omitempty"`
+}
+
+// ForwardListenerTLS configures TLS termination on a forward listener.
+type ForwardListenerTLS struct {
+	Enabled bool   `yaml:"enabled,omitempty"`
+	Cert    string `yaml:"cert,omitempty"`     // Certificate file path
+	Key     string `yaml:"key,omitempty"`      // Private key file path
+	CertPEM string `yaml:"cert_pem,omitempty"` // Certificate PEM content (takes precedence)
+	KeyPEM  string `yaml:"key_pem,omitempty"`  // Private key PEM content (takes precedence)
+}
+
+// GetCertPEM returns the certificate PEM content, reading from file if necessary.
+func (t *ForwardListenerTLS) GetCertPEM() ([]byte, error) {
+	return getPEM(t.CertPEM, t.Cert)
+}
+
+// GetKeyPEM returns the private key PEM content, reading from file if necessary.
+func (t *ForwardListenerTLS) GetKeyPEM() ([]byte, error) {
+	return getPEM(t.KeyPEM, t.Key)
+}
+
+// TunnelConfig configures plain port tunnels: local listeners whose traffic
+// goes through the mesh to a fixed destination, for applications that do
+// not speak SOCKS5.
+type TunnelConfig struct {
+	// UDPListeners forward every datagram received on a local UDP port to
+	// a fixed host:port through the exit whose routes cover it.
+	UDPListeners []TunnelUDPListener `yaml:"udp_listeners,omitempty"`
+}
+
+// TunnelUDPListener defines a UDP tunnel ingress point. Each client source
+// address gets its own session, so replies go back to the right client.
+type TunnelUDPListener struct {
+	// Address is the local UDP address to listen on.
+	// Example: "127.0.0.1:5353" or ":27015"
+	Address string `yaml:"address,omitempty"`
+
+	// AddressFamily selects the IP families of the socket: "dual"
+	// (default), "ipv4" or "ipv6".
+	AddressFamily string `yaml:"address_family,omitempty"`
+
+	// Target is the destination host:port. Hostnames are resolved following
+	// socks5.remote_dns. The exit needs udp.enabled and a matching route.
+	Target string `yaml:"target,omitempty"`
+
+	// IdleTimeout ends client sessions without traffic.
+	// Default: 60s.
+	IdleTimeout time.Duration `yaml:"idle_timeout,omitempty"`
+
+	// MaxSessions limits concurrent client sessions (0 = unlimited).
+	MaxSessions int `yaml:"max_sessions,omitempty"`
+}
+
+// SchedulerConfig configures recurring tasks installed remotely on this
+// agent. Tasks run shell commands or file syncs and are subject to the
+// shell and file_transfer settings. They are saved in data_dir.
+type SchedulerConfig struct {
+	// Enabled controls whether tasks can be installed and run.
+	Enabled bool `yaml:"enabled,omitempty"`
+
+	// MaxTasks limits installed tasks (0 = unlimited).
+	// Default: 32.
+	MaxTasks int `yaml:"max_tasks,omitempty"`
+
+	// HistorySize is the number of runs kept per task.
+	// Default: 20.
+	HistorySize int `yaml:"history_size,omitempty"`
+
+	// MaxOutput caps the captured output of a run in bytes.
+	// Default: 65536.
+	MaxOutput int `yaml:"max_output,omitempty"`
+
+	// DefaultTimeout applies to tasks that do not set their own timeout.
+	// Default: 5 minutes.
+	DefaultTimeout time.Duration `yaml:"default_timeout,omitempty"`
+}
+
+// UpdateConfig configures remote self-update. A new binary is uploaded over
+// the file transfer stream into data_dir, verified, swapped in place of the
+// running executable and the agent restarted into it.
+type UpdateConfig struct {
+	// Enabled controls whether the agent accepts binary updates.
+	Enabled bool `yaml:"enabled,omitempty"`
+
+	// RequireSignature requires the binary digest to be signed with the
+	// management signing key (management.signing_public_key).
+	// Default: true.
+	RequireSignature bool `yaml:"require_signature"`
+
+	// ServiceName is the systemd unit or Windows service restarted after
+	// the binary is swapped, when the agent runs as a service.
+	// Default: "muti-metroo".
+	ServiceName string `yaml:"service_name,omitempty"`
+}
+
+// UsageConfig configures bandwidth usage accounting. Bytes are counted per
+// peer link, per SOCKS5 user and per exit destination bucket, rolled over
+// every calendar month (UTC) and saved in data_dir.
+type UsageConfig struct {
+	// Enabled controls whether usage is accounted.
+	Enabled bool `yaml:"enabled,omitempty"`
+
+	// CheckpointInterval is how often counters are sampled and saved.
+	// Default: 1 minute.
+	CheckpointInterval time.Duration `yaml:"checkpoint_interval,omitempty"`
+
+	// RetainMonths is the number of past months kept.
+	// Default: 12.
+	RetainMonths int `yaml:"retain_months,omitempty"`
+
+	// MaxDestinations bounds the destination buckets of a month. Further
+	// destinations are counted under "other".
+	// Default: 1000.
+	MaxDestinations int `yaml:"max_destinations,omitempty"`
+}
+
+// CrashReportsConfig configures crash reports. Panics recovered in agent
+// goroutines and fatal crashes of the process are saved in data_dir/crashes
+// and can be listed and fetched over the mesh.
+type CrashReportsConfig struct {
+	// Enabled controls whether crash reports are saved.
+	Enabled bool `yaml:"enabled,omitempty"`
+
+	// MaxReports is the number of reports kept. The oldest are removed
+	// first.
+	// Default: 20.
+	MaxReports int `yaml:"max_reports,omitempty"`
+}
+
+// WatchdogConfig configures the resource watchdog. It checks the goroutine
+// count, heap size and peer writes every interval, saves a goroutine dump
+// and heap profile to data_dir/watchdog when a limit is crossed, and can
+// recycle stuck peer connections or restart the agent.
+type WatchdogConfig struct {
+	// Enabled controls whether the watchdog runs.
+	Enabled bool `yaml:"enabled,omitempty"`
+
+	// Interval is how often the watchdog checks.
+	// Default: 30s.
+	Interval time.Duration `yaml:"interval,omitempty"`
+
+	// MaxGoroutines is the goroutine count above which the agent is
+	// unhealthy (0 = not checked).
+	// Default: 50000.
+	MaxGoroutines int `yaml:"max_goroutines,omitempty"`
+
+	// MaxHeap is the allocated heap in bytes above which the agent is
+	// unhealthy (0 = not checked).
+	MaxHeap int64 `yaml:"max_heap,omitempty"`
+
+	// StuckWriteTimeout is how long a write to a peer may block before the
+	// peer connection counts as stuck.
+	// Default: 60s.
+	StuckWriteTimeout time.Duration `yaml:"stuck_write_timeout,omitempty"`
+
+	// SnapshotCooldown is the minimum time between two snapshots.
+	// Default: 15m.
+	SnapshotCooldown time.Duration `yaml:"snapshot_cooldown,omitempty"`
+
+	// MaxSnapshots is the number of snapshots kept in data_dir/watchdog.
+	// Default: 10.
+	MaxSnapshots int `yaml:"max_snapshots,omitempty"`
+
+	// RecycleStuckPeers closes stuck peer connections. Persistent peers
+	// are then redialed.
+	RecycleStuckPeers bool `yaml:"recycle_stuck_peers,omitempty"`
+
+	// Restart restarts the agent gracefully once the goroutine or heap
+	// limit has been crossed on RestartAfter consecutive checks.
+	Restart bool `yaml:"restart,omitempty"`
+
+	// RestartAfter is the number of consecutive checks over a limit before
+	// a restart.
+	// Default: 3.
+	RestartAfter int `yaml:"restart_after,omitempty"`
+
+	// RestartCooldown is the minimum time between two watchdog restarts,
+	// kept across restarts in data_dir/watchdog.
+	// Default: 1h.
+	RestartCooldown time.Duration `yaml:"restart_cooldown,omitempty"`
 }
 
 // SleepConfig configures sleep mode for mesh hibernation.
@@ -820,23 +2009,116 @@ func Default() *Config {
 		},
 		Listeners: []ListenerConfig{},
 		Peers:     []PeerConfig{},
+		Discovery: DiscoveryConfig{
+			DNS: DNSDiscoveryConfig{
+				Enabled:  false,
+				Interval: 5 * time.Minute,
+			},
+			MDNS: MDNSDiscoveryConfig{
+				Enabled:  false,
+				Interval: 1 * time.Minute,
+				Timeout:  2 * time.Second,
+			},
+			MaxPeers: 16,
+		},
 		SOCKS5: SOCKS5Config{
 			Enabled:        false,
 			Address:        "127.0.0.1:1080",
 			MaxConnections: 1000,
+			ConnectTimeout: 10 * time.Second,
+			Blocklist: SOCKS5BlocklistConfig{
+				RefreshInterval: time.Hour,
+			},
+			ClientLimits: SOCKS5ClientLimitsConfig{
+				PerIP:        SOCKS5ClientLimit{MaxConnections: 64, Rate: 20, Burst: 40},
+				PerUser:      SOCKS5ClientLimit{MaxConnections: 256, Rate: 50, Burst: 100},
+				BanThreshold: 20,
+				BanWindow:    time.Minute,
+				BanDuration:  5 * time.Minute,
+			},
+			Auth: SOCKS5AuthConfig{
+				External: SOCKS5ExternalAuthConfig{
+					Timeout:          5 * time.Second,
+					CacheTTL:         5 * time.Minute,
+					NegativeCacheTTL: 30 * time.Second,
+				},
+			},
 		},
 		Exit: ExitConfig{
-			Enabled: false,
-			Routes:  []string{},
+			Enabled:        false,
+			Routes:         []string{},
+			ConnectTimeout: 30 * time.Second,
 			DNS: DNSConfig{
 				Servers: []string{}, // Empty = use system resolver (supports .local domains)
 				Timeout: 5 * time.Second,
+				Cache: DNSCacheConfig{
+					Enabled:     true,
+					MinTTL:      10 * time.Second,
+					MaxTTL:      time.Hour,
+					DefaultTTL:  5 * time.Minute,
+					NegativeTTL: 30 * time.Second,
+					MaxEntries:  10000,
+				},
+			},
+			Pool: ExitPoolConfig{
+				Enabled:        false,
+				Ports:          []uint16{80},
+				MaxIdle:        64,
+				MaxIdlePerHost: 4,
+				IdleTimeout:    30 * time.Second,
+			},
+			HappyEyeballs: ExitHappyEyeballsConfig{
+				Enabled: true,
+				Delay:   250 * time.Millisecond,
+			},
+			HealthCheck: ExitHealthCheckConfig{
+				Enabled:          false,
+				Interval:         10 * time.Second,
+				Timeout:          3 * time.Second,
+				FailureThreshold: 3,
+				SuccessThreshold: 2,
+			},
+			DestinationStats: ExitDestStatsConfig{
+				Enabled:         false,
+				Window:          5 * time.Minute,
+				MaxDestinations: 10000,
+				Action:          "warn",
+				BlockDuration:   10 * time.Minute,
+			},
+			PortLimits: ExitPortLimitsConfig{
+				SMTP: ExitPortClassConfig{
+					Ports:  []uint16{25, 465, 587},
+					Action: "block",
+				},
 			},
 		},
 		Routing: RoutingConfig{
 			AdvertiseInterval: 2 * time.Minute,
 			RouteTTL:          5 * time.Minute,
 			MaxHops:           16,
+			Metric:            "hops",
+			FloodBatching: FloodBatchingConfig{
+				Enabled: false,
+				Window:  50 * time.Millisecond,
+				Workers: 4,
+			},
+			PathProbe: PathProbeConfig{
+				Enabled:          false,
+				Interval:         10 * time.Second,
+				Timeout:          5 * time.Second,
+				FailureThreshold: 3,
+			},
+			Dampening: DampeningConfig{
+				Enabled:     false,
+				MaxFlaps:    5,
+				HalfLife:    5 * time.Minute,
+				MaxSuppress: 30 * time.Minute,
+			},
+			PeerRateLimit: PeerRateLimitConfig{
+				Enabled: false,
+				Rate:    10,
+				Burst:   200,
+			},
 		},
 		Connections: ConnectionsConfig{
 			IdleThreshold:   5 * time.Minute, // Long-running connections like SSH should stay alive
@@ -849,6 +2131,31 @@ func Default() *Config {
 				Jitter:       0.2,
 				MaxRetries:   0,
 			},
+			WriteBatching: WriteBatchingConfig{
+				Enabled:    false,
+				Transports: []string{"ws"},
+				FlushDelay: 1 * time.Millisecond,
+				MaxBytes:   64 * 1024,
+			},
+			QoS: QoSConfig{
+				Enabled: false,
+				Weights: QoSWeights{RPC: 4, Interactive: 2, Bulk: 1},
+			},
+			NATTraversal: NATTraversalConfig{
+				Enabled:       false,
+				Interval:      1 * time.Minute,
+				PunchTimeout:  5 * time.Second,
+				RetryInterval: 10 * time.Minute,
+				MaxDirect:     8,
+			},
+			AcceptLimits: AcceptLimitsConfig{
+				Enabled:          false,
+				PerIPRate:        5,
+				PerIPBurst:       20,
+				MaxPending:       256,
+				HandshakeTimeout: 10 * time.Second,
+				RetryThreshold:   64,
+			},
 		},
 		Limits: LimitsConfig{
 			MaxStreamsPerPeer: 1000,
@@ -857,11 +2164,21 @@ func Default() *Config {
 			StreamOpenTimeout: 30 * time.Second,
 			BufferSize:        262144, // 256 KB
 		},
+		E2E: E2EConfig{
+			Rekey: E2ERekeyConfig{
+				Enabled:  true,
+				Bytes:    1 << 30, // 1 GiB
+				Interval: time.Hour,
+			},
+		},
 		HTTP: HTTPConfig{
 			Enabled:      false,
 			Address:      ":8080",
 			ReadTimeout:  10 * time.Second,
 			WriteTimeout: 10 * time.Second,
+			Readiness: HTTPReadinessConfig{
+				MinPeers: 1,
+			},
 		},
 		FileTransfer: FileTransferConfig{
 			Enabled:      false,
@@ -872,6 +2189,10 @@ func Default() *Config {
 			Enabled:     false,      // Disabled by default for security
 			Whitelist:   []string{}, // Empty = no commands allowed
 			MaxSessions: 0,          // 0 = unlimited (trusted network)
+			Sandbox: ShellSandboxConfig{
+				ReadOnly:  []string{"/"},
+				ReadWrite: []string{"/tmp", "/dev/null", "/dev/tty", "/dev/pts", "/dev/ptmx", "/dev/shm"},
+			},
 		},
 		UDP: UDPConfig{
 			Enabled:         true,
@@ -904,6 +2225,42 @@ func Default() *Config {
 				Epoch:          "", // Empty = Unix epoch
 			},
 		},
+		Scheduler: SchedulerConfig{
+			Enabled:        false,
+			MaxTasks:       32,
+			HistorySize:    20,
+			MaxOutput:      64 * 1024,
+			DefaultTimeout: 5 * time.Minute,
+		},
+		Update: UpdateConfig{
+			Enabled:          false,
+			RequireSignature: true,
+			ServiceName:      "muti-metroo",
+		},
+		Usage: UsageConfig{
+			Enabled:            false,
+			CheckpointInterval: time.Minute,
+			RetainMonths:       12,
+			MaxDestinations:    1000,
+		},
+		CrashReports: CrashReportsConfig{
+			Enabled:    false,
+			MaxReports: 20,
+		},
+		Watchdog: WatchdogConfig{
+			Enabled:           false,
+			Interval:          30 * time.Second,
+			MaxGoroutines:     50000,
+			StuckWriteTimeout: 60 * time.Second,
+			SnapshotCooldown:  15 * time.Minute,
+			MaxSnapshots:      10,
+			RestartAfter:      3,
+			RestartCooldown:   time.Hour,
+		},
+		RBAC: RBACConfig{
+			DefaultPeerRole: "admin",
+			LegacyRole:      "admin",
+		},
 	}
 }
 
@@ -986,10 +2343,20 @@ func Parse(data []byte) (*Config, error) {
 	// Start with defaults
 	cfg := Default()
 
-	// Parse YAML
-	if err := yaml.Unmarshal([]byte(expanded), cfg); err != nil {
+	// Parse YAML, then resolve secret references in the parsed values so
+	// multi-line secrets cannot break the document structure
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(expanded), &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse config: %w", err)
+	}
+	if err := expandSecretRefs(&doc); err != nil {
 		return nil, fmt.Errorf("failed to parse config: %w", err)
 	}
+	if doc.Kind != 0 {
+		if err := doc.Decode(cfg); err != nil {
+			return nil, fmt.Errorf("failed to parse config: %w", err)
+		}
+	}
 
 	// Validate
 	if err := cfg.Validate(); err != nil {
@@ -1013,6 +2380,11 @@ func expandEnvVars(s string) string {
 			name = match[1:]
 		}
 
+		// Secret references are resolved after parsing
+		if secretRefRegex.MatchString(match) {
+			return match
+		}
+
 		// Handle default values: ${VAR:-default}
 		if idx := strings.Index(name, ":-"); idx != -1 {
 			varName := name[:idx]
@@ -1031,11 +2403,81 @@ func expandEnvVars(s string) string {
 	})
 }
 
-// Validate checks the configuration for errors.
-func (c *Config) Validate() error {
-	var errs []string
-
-	// Validate default_action (only for embedded config usage)
+// secretRefRegex matches ${env:VAR}, ${env:VAR:-default} and ${file:PATH}
+var secretRefRegex = regexp.MustCompile(`\$\{(env|file):([^}]+)\}`)
+
+// expandSecretRefs replaces secret references in the scalar values of a
+// parsed config. Unlike ${VAR}, the value is inserted as is, so it may span
+// lines (PEM blocks) or contain YAML syntax. ${file:PATH} reads a file, such
+// as a mounted Kubernetes secret, without its trailing newlines. Unset
+// variables without a default and unreadable files are errors.
+func expandSecretRefs(n *yaml.Node) error {
+	switch n.Kind {
+	case yaml.DocumentNode, yaml.SequenceNode:
+		for _, child := range n.Content {
+			if err := expandSecretRefs(child); err != nil {
+				return err
+			}
+		}
+	case yaml.MappingNode:
+		// Keys are left alone
+		for i := 1; i < len(n.Content); i += 2 {
+			if err := expandSecretRefs(n.Content[i]); err != nil {
+				return err
+			}
+		}
+	case yaml.ScalarNode:
+		if !strings.Contains(n.Value, "${") {
+			return nil
+		}
+		var refErr error
+		value := secretRefRegex.ReplaceAllStringFunc(n.Value, func(match string) string {
+			parts := secretRefRegex.FindStringSubmatch(match)
+			val, err := resolveSecretRef(parts[1], parts[2])
+			if err != nil && refErr == nil {
+				refErr = fmt.Errorf("line %d: %s: %w", n.Line, match, err)
+			}
+			return val
+		})
+		if refErr != nil {
+			return refErr
+		}
+		if value != n.Value {
+			n.Value = value
+			// Let plain scalars resolve again, so numbers stay numbers
+			if n.Style == 0 {
+				n.Tag = ""
+			}
+		}
+	}
+	return nil
+}
+
+// resolveSecretRef returns the value of one secret reference.
+func resolveSecretRef(kind, ref string) (string, error) {
+	if kind == "file" {
+		data, err := os.ReadFile(ref)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	}
+
+	name, defaultVal, hasDefault := strings.Cut(ref, ":-")
+	if val, ok := os.LookupEnv(name); ok {
+		return val, nil
+	}
+	if hasDefault {
+		return defaultVal, nil
+	}
+	return "", fmt.Errorf("environment variable %s is not set", name)
+}
+
+// Validate checks the configuration for errors.
+func (c *Config) Validate() error {
+	var errs []string
+
+	// Validate default_action (only for embedded config usage)
 	if c.DefaultAction != "" && !isOneOf(c.DefaultAction, "run", "help") {
 		errs = append(errs, fmt.Sprintf("invalid default_action: %s (must be 'run' or 'help')", c.DefaultAction))
 	}
@@ -1055,9 +2497,20 @@ func (c *Config) Validate() error {
 	if !isValidLogFormat(c.Agent.LogFormat) {
 		errs = append(errs, fmt.Sprintf("invalid log_format: %s (must be text or json)", c.Agent.LogFormat))
 	}
+	errs = append(errs, c.validateLogOutput()...)
 	if c.Agent.StartupDelay < 0 {
 		errs = append(errs, "agent.startup_delay must not be negative")
 	}
+	errs = append(errs, validateTags("agent.tags", c.Agent.Tags)...)
+	errs = append(errs, validateNamespace("agent.namespace", c.Agent.Namespace)...)
+	if c.Agent.RunAs.User == "" && (c.Agent.RunAs.Group != "" || len(c.Agent.RunAs.Capabilities) > 0) {
+		errs = append(errs, "agent.run_as.user is required with group or capabilities")
+	}
+	for i, name := range c.Agent.RunAs.Capabilities {
+		if _, err := privdrop.ParseCapability(name); err != nil {
+			errs = append(errs, fmt.Sprintf("agent.run_as.capabilities[%d]: %v", i, err))
+		}
+	}
 
 	// Validate identity keypair configuration
 	if err := c.validateIdentityKeypair(); err != nil {
@@ -1083,10 +2536,165 @@ func (c *Config) Validate() error {
 		}
 	}
 
+	// Validate peer discovery
+	if err := c.validateDiscovery(); err != nil {
+		errs = append(errs, err.Error())
+	}
+
+	// Validate write batching
+	if wb := c.Connections.WriteBatching; wb.Enabled {
+		for i, tr := range wb.Transports {
+			if !isValidTransport(tr) {
+				errs = append(errs, fmt.Sprintf("connections.write_batching.transports[%d]: invalid transport: %s (must be quic, h2, ws, wt, or tcp)", i, tr))
+			}
+		}
+		if wb.FlushDelay < 0 {
+			errs = append(errs, "connections.write_batching.flush_delay must not be negative")
+		}
+		if wb.MaxBytes < 0 {
+			errs = append(errs, "connections.write_batching.max_bytes must not be negative")
+		}
+	}
+
+	// Validate frame size limits
+	for _, tr := range slices.Sorted(maps.Keys(c.Connections.MaxFrameSize)) {
+		if !isValidTransport(tr) {
+			errs = append(errs, fmt.Sprintf("connections.max_frame_size: invalid transport: %s (must be quic, h2, ws, wt, or tcp)", tr))
+		} else if err := validateFrameSize(c.Connections.MaxFrameSize[tr]); err != nil {
+			errs = append(errs, fmt.Sprintf("connections.max_frame_size.%s: %v", tr, err))
+		}
+	}
+
+	// Validate QoS weights
+	if w := c.Connections.QoS.Weights; w.RPC < 0 || w.Interactive < 0 || w.Bulk < 0 {
+		errs = append(errs, "connections.qos.weights must not be negative")
+	}
+
+	// Validate NAT traversal
+	if nt := c.Connections.NATTraversal; nt.Enabled {
+		if nt.Interval <= 0 {
+			errs = append(errs, "connections.nat_traversal.interval must be positive")
+		}
+		if nt.PunchTimeout <= 0 {
+			errs = append(errs, "connections.nat_traversal.punch_timeout must be positive")
+		}
+		if nt.RetryInterval < 0 {
+			errs = append(errs, "connections.nat_traversal.retry_interval must not be negative")
+		}
+		if nt.MaxDirect < 1 {
+			errs = append(errs, "connections.nat_traversal.max_direct must be at least 1")
+		}
+		hasQUIC := false
+		for _, l := range c.Listeners {
+			if l.Transport == "quic" {
+				hasQUIC = true
+			}
+		}
+		if !hasQUIC {
+			errs = append(errs, "connections.nat_traversal requires a quic listener")
+		}
+	}
+
+	// Validate accept limits
+	if al := c.Connections.AcceptLimits; al.Enabled {
+		if al.PerIPRate < 0 || al.PerIPBurst < 0 || al.MaxPending < 0 || al.RetryThreshold < 0 {
+			errs = append(errs, "connections.accept_limits values must not be negative")
+		}
+		if al.HandshakeTimeout <= 0 {
+			errs = append(errs, "connections.accept_limits.handshake_timeout must be positive")
+		}
+	}
+
 	// Validate SOCKS5
 	if c.SOCKS5.Enabled && c.SOCKS5.Address == "" {
 		errs = append(errs, "socks5.address is required when enabled")
 	}
+	if c.SOCKS5.IsUnixSocket() && strings.TrimPrefix(c.SOCKS5.Address, "unix://") == "" {
+		errs = append(errs, "socks5.address: unix socket path is required")
+	}
+	if _, err := c.SOCKS5.ParseSocketMode(); err != nil {
+		errs = append(errs, fmt.Sprintf("socks5.socket_mode: %v", err))
+	}
+	if c.SOCKS5.IsUnixSocket() {
+		if c.SOCKS5.AddressFamily != "" {
+			errs = append(errs, "socks5.address_family: not supported for unix sockets")
+		}
+	} else if err := validateAddressFamily(c.SOCKS5.AddressFamily, c.SOCKS5.Address); err != nil {
+		errs = append(errs, fmt.Sprintf("socks5.address_family: %v", err))
+	}
+	switch c.SOCKS5.RemoteDNS {
+	case "", RemoteDNSAuto, RemoteDNSLocal, RemoteDNSAlways:
+	default:
+		errs = append(errs, fmt.Sprintf("socks5.remote_dns must be %q, %q or %q, got %q", RemoteDNSAuto, RemoteDNSLocal, RemoteDNSAlways, c.SOCKS5.RemoteDNS))
+	}
+	for i, u := range c.SOCKS5.Auth.Users {
+		if strings.Contains(u.Username, "@agent:") {
+			errs = append(errs, fmt.Sprintf("socks5.auth.users[%d].username must not contain \"@agent:\" (reserved for exit selection)", i))
+		}
+		errs = append(errs, validateNamespace(fmt.Sprintf("socks5.auth.users[%d].namespace", i), u.Namespace)...)
+	}
+	if c.SOCKS5.ConnectTimeout <= 0 {
+		errs = append(errs, "socks5.connect_timeout must be positive")
+	}
+	if ext := c.SOCKS5.Auth.External; ext.Type != "" {
+		switch ext.Type {
+		case "http":
+			if ext.URL == "" {
+				errs = append(errs, "socks5.auth.external.url is required for type http")
+			} else if u, err := url.Parse(ext.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				errs = append(errs, fmt.Sprintf("socks5.auth.external.url must be an http or https URL, got %q", ext.URL))
+			}
+		case "command":
+			if len(ext.Command) == 0 || ext.Command[0] == "" {
+				errs = append(errs, "socks5.auth.external.command is required for type command")
+			}
+		default:
+			errs = append(errs, fmt.Sprintf("socks5.auth.external.type must be \"http\" or \"command\", got %q", ext.Type))
+		}
+		if ext.Timeout <= 0 {
+			errs = append(errs, "socks5.auth.external.timeout must be positive")
+		}
+		if ext.CacheTTL < 0 || ext.NegativeCacheTTL < 0 {
+			errs = append(errs, "socks5.auth.external cache TTLs must not be negative")
+		}
+	}
+
+	if bl := c.SOCKS5.Blocklist; bl.Enabled {
+		for i, cidr := range bl.CIDRs {
+			if !isValidCIDR(cidr) && net.ParseIP(cidr) == nil {
+				errs = append(errs, fmt.Sprintf("socks5.blocklist.cidrs[%d]: invalid CIDR: %s", i, cidr))
+			}
+		}
+		for i, feed := range bl.Feeds {
+			if u, err := url.Parse(feed); err != nil || u.Scheme != "https" || u.Host == "" {
+				errs = append(errs, fmt.Sprintf("socks5.blocklist.feeds[%d] must be an https URL, got %q", i, feed))
+			}
+		}
+		if bl.RefreshInterval <= 0 {
+			errs = append(errs, "socks5.blocklist.refresh_interval must be positive")
+		}
+	}
+	if cl := c.SOCKS5.ClientLimits; cl.Enabled {
+		for _, l := range []struct {
+			name  string
+			limit SOCKS5ClientLimit
+		}{{"per_ip", cl.PerIP}, {"per_user", cl.PerUser}} {
+			name, limit := l.name, l.limit
+			if limit.MaxConnections < 0 {
+				errs = append(errs, fmt.Sprintf("socks5.client_limits.%s.max_connections must not be negative", name))
+			}
+			if limit.Rate < 0 {
+				errs = append(errs, fmt.Sprintf("socks5.client_limits.%s.rate must not be negative", name))
+			} else if limit.Rate > 0 && limit.Burst < 1 {
+				errs = append(errs, fmt.Sprintf("socks5.client_limits.%s.burst must be at least 1", name))
+			}
+		}
+		if cl.BanThreshold < 0 {
+			errs = append(errs, "socks5.client_limits.ban_threshold must not be negative")
+		} else if cl.BanThreshold > 0 && (cl.BanWindow <= 0 || cl.BanDuration <= 0) {
+			errs = append(errs, "socks5.client_limits.ban_window and ban_duration must be positive when ban_threshold is set")
+		}
+	}
 
 	// Validate SOCKS5 WebSocket
 	if c.SOCKS5.WebSocket.Enabled {
@@ -1096,6 +2704,9 @@ func (c *Config) Validate() error {
 		if c.SOCKS5.WebSocket.Path != "" && !strings.HasPrefix(c.SOCKS5.WebSocket.Path, "/") {
 			errs = append(errs, "socks5.websocket.path must start with '/'")
 		}
+		if err := validateAddressFamily(c.SOCKS5.WebSocket.AddressFamily, c.SOCKS5.WebSocket.Address); err != nil {
+			errs = append(errs, fmt.Sprintf("socks5.websocket.address_family: %v", err))
+		}
 	}
 
 	// Validate exit routes (CIDR)
@@ -1111,11 +2722,117 @@ func (c *Config) Validate() error {
 			errs = append(errs, fmt.Sprintf("exit.domain_routes[%d]: %v", i, err))
 		}
 	}
+	if c.Exit.ConnectTimeout <= 0 {
+		errs = append(errs, "exit.connect_timeout must be positive")
+	}
+	if c.Exit.HalfClose.ClientLinger < 0 {
+		errs = append(errs, "exit.half_close.client_linger must not be negative")
+	}
+	if c.Exit.HalfClose.DestinationLinger < 0 {
+		errs = append(errs, "exit.half_close.destination_linger must not be negative")
+	}
+	if hc := c.Exit.HealthCheck; hc.Enabled {
+		if len(hc.Targets) == 0 {
+			errs = append(errs, "exit.health_check.targets is required when enabled")
+		}
+		for i, target := range hc.Targets {
+			if err := isValidHostPort(target); err != nil {
+				errs = append(errs, fmt.Sprintf("exit.health_check.targets[%d]: %v", i, err))
+			} else if _, port, _ := net.SplitHostPort(target); !isValidPort(port) {
+				errs = append(errs, fmt.Sprintf("exit.health_check.targets[%d]: invalid port %q", i, port))
+			}
+		}
+		if hc.Interval <= 0 {
+			errs = append(errs, "exit.health_check.interval must be positive")
+		}
+		if hc.Timeout <= 0 {
+			errs = append(errs, "exit.health_check.timeout must be positive")
+		} else if hc.Timeout >= hc.Interval {
+			errs = append(errs, "exit.health_check.timeout must be less than interval")
+		}
+		if hc.FailureThreshold < 1 {
+			errs = append(errs, "exit.health_check.failure_threshold must be at least 1")
+		}
+		if hc.SuccessThreshold < 1 {
+			errs = append(errs, "exit.health_check.success_threshold must be at least 1")
+		}
+	}
+	errs = append(errs, validateTags("exit.tags", c.Exit.Tags)...)
 
 	// Validate routing
 	if c.Routing.MaxHops < 1 || c.Routing.MaxHops > 255 {
 		errs = append(errs, "routing.max_hops must be between 1 and 255")
 	}
+	for i, group := range c.Routing.Aggregation.Groups {
+		if !isValidCIDR(group) {
+			errs = append(errs, fmt.Sprintf("routing.aggregation.groups[%d]: invalid CIDR: %s", i, group))
+		}
+	}
+	if fb := c.Routing.FloodBatching; fb.Enabled {
+		if fb.Window <= 0 {
+			errs = append(errs, "routing.flood_batching.window must be positive")
+		}
+		if fb.Workers < 1 {
+			errs = append(errs, "routing.flood_batching.workers must be at least 1")
+		}
+	}
+	if pp := c.Routing.PathProbe; pp.Enabled {
+		if pp.Interval <= 0 {
+			errs = append(errs, "routing.path_probe.interval must be positive")
+		}
+		if pp.Timeout <= 0 {
+			errs = append(errs, "routing.path_probe.timeout must be positive")
+		} else if pp.Timeout >= pp.Interval {
+			errs = append(errs, "routing.path_probe.timeout must be less than interval")
+		}
+		if pp.FailureThreshold < 1 {
+			errs = append(errs, "routing.path_probe.failure_threshold must be at least 1")
+		}
+	}
+	if d := c.Routing.Dampening; d.Enabled {
+		if d.MaxFlaps < 1 {
+			errs = append(errs, "routing.dampening.max_flaps must be at least 1")
+		}
+		if d.HalfLife <= 0 {
+			errs = append(errs, "routing.dampening.half_life must be positive")
+		}
+		if d.MaxSuppress <= 0 {
+			errs = append(errs, "routing.dampening.max_suppress must be positive")
+		}
+	}
+	if rl := c.Routing.PeerRateLimit; rl.Enabled {
+		if rl.Rate <= 0 {
+			errs = append(errs, "routing.peer_rate_limit.rate must be positive")
+		}
+		if rl.Burst < 1 {
+			errs = append(errs, "routing.peer_rate_limit.burst must be at least 1")
+		}
+	}
+	errs = append(errs, validateTags("routing.prefer_tags", c.Routing.PreferTags)...)
+	if _, err := c.GetRouteKeys(); err != nil {
+		errs = append(errs, err.Error())
+	}
+	switch c.Routing.Metric {
+	case "", "hops", "latency", "hybrid":
+	default:
+		errs = append(errs, fmt.Sprintf("routing.metric must be hops, latency or hybrid, got %q", c.Routing.Metric))
+	}
+	if kr := c.Routing.KernelRoutes; kr.Enabled {
+		if kr.Interface == "" {
+			errs = append(errs, "routing.kernel_routes.interface is required when kernel routes are enabled")
+		}
+		if kr.Metric < 0 {
+			errs = append(errs, "routing.kernel_routes.metric must not be negative")
+		}
+		if kr.Table < 0 {
+			errs = append(errs, "routing.kernel_routes.table must not be negative")
+		}
+		for i, prefix := range kr.Exclude {
+			if !isValidCIDR(prefix) {
+				errs = append(errs, fmt.Sprintf("routing.kernel_routes.exclude[%d]: invalid CIDR: %s", i, prefix))
+			}
+		}
+	}
 
 	// Validate limits
 	if c.Limits.MaxStreamsPerPeer < 1 {
@@ -1128,6 +2845,328 @@ func (c *Config) Validate() error {
 		errs = append(errs, "limits.buffer_size must be at least 1024")
 	}
 
+	// Validate E2E rekeying
+	if rk := c.E2E.Rekey; rk.Enabled {
+		if rk.Bytes == 0 && rk.Interval == 0 {
+			errs = append(errs, "e2e.rekey: bytes or interval is required when enabled")
+		}
+		if rk.Bytes > 0 && rk.Bytes < MinRekeyBytes {
+			errs = append(errs, fmt.Sprintf("e2e.rekey.bytes must be at least %d", MinRekeyBytes))
+		}
+		if rk.Interval < 0 || (rk.Interval > 0 && rk.Interval < MinRekeyInterval) {
+			errs = append(errs, fmt.Sprintf("e2e.rekey.interval must be at least %s", MinRekeyInterval))
+		}
+	}
+
+	// Validate shell rules
+	for i, rule := range c.Shell.Rules {
+		if rule.Command == "" {
+			errs = append(errs, fmt.Sprintf("shell.rules[%d].command is required", i))
+		} else if strings.ContainsAny(rule.Command, "/\\") {
+			errs = append(errs, fmt.Sprintf("shell.rules[%d].command must be a base name without a path: %s", i, rule.Command))
+		}
+		for j, expr := range rule.ArgsRegex {
+			if _, err := regexp.Compile(expr); err != nil {
+				errs = append(errs, fmt.Sprintf("shell.rules[%d].args_regex[%d]: %v", i, j, err))
+			}
+		}
+	}
+
+	if sb := c.Shell.Sandbox; sb.Enabled {
+		for i, p := range sb.ReadOnly {
+			if !strings.HasPrefix(p, "/") {
+				errs = append(errs, fmt.Sprintf("shell.sandbox.read_only[%d]: path must be absolute: %s", i, p))
+			}
+		}
+		for i, p := range sb.ReadWrite {
+			if !strings.HasPrefix(p, "/") {
+				errs = append(errs, fmt.Sprintf("shell.sandbox.read_write[%d]: path must be absolute: %s", i, p))
+			}
+		}
+		if sb.MaxMemory < 0 || sb.MaxProcesses < 0 || sb.MaxOpenFiles < 0 || sb.MaxFileSize < 0 || sb.CPUTime < 0 {
+			errs = append(errs, "shell.sandbox limits must not be negative")
+		}
+	}
+
+	if ra := c.Shell.RunAs; ra.User == "" && (ra.Group != "" || ra.Password != "") {
+		errs = append(errs, "shell.run_as.user is required with group or password")
+	}
+	for i, u := range c.Shell.RunAs.AllowedUsers {
+		if strings.TrimSpace(u) == "" {
+			errs = append(errs, fmt.Sprintf("shell.run_as.allowed_users[%d] must not be empty", i))
+		}
+	}
+	if c.Agent.RunAs.User != "" && (c.Shell.RunAs.User != "" || len(c.Shell.RunAs.AllowedUsers) > 0) {
+		errs = append(errs, "shell.run_as cannot be combined with agent.run_as: switching accounts requires the agent to keep running as root")
+	}
+
+	// Validate scheduler
+	if c.Scheduler.Enabled {
+		if c.Agent.DataDir == "" {
+			errs = append(errs, "scheduler requires agent.data_dir to persist tasks")
+		}
+		if c.Scheduler.MaxTasks < 0 {
+			errs = append(errs, "scheduler.max_tasks must not be negative")
+		}
+		if c.Scheduler.HistorySize < 1 {
+			errs = append(errs, "scheduler.history_size must be positive")
+		}
+		if c.Scheduler.MaxOutput < 1 {
+			errs = append(errs, "scheduler.max_output must be positive")
+		}
+		if c.Scheduler.DefaultTimeout <= 0 {
+			errs = append(errs, "scheduler.default_timeout must be positive")
+		}
+	}
+
+	// Validate update
+	if c.Update.Enabled {
+		if c.Agent.DataDir == "" {
+			errs = append(errs, "update requires agent.data_dir to stage binaries")
+		}
+		if !c.FileTransfer.Enabled {
+			errs = append(errs, "update requires file_transfer.enabled to upload binaries")
+		}
+		if c.Update.RequireSignature && !c.HasSigningKey() {
+			errs = append(errs, "update.require_signature requires management.signing_public_key")
+		}
+	}
+
+	// Validate usage
+	if c.Usage.Enabled {
+		if c.Agent.DataDir == "" {
+			errs = append(errs, "usage requires agent.data_dir to persist counters")
+		}
+		if c.Usage.CheckpointInterval <= 0 {
+			errs = append(errs, "usage.checkpoint_interval must be positive")
+		}
+		if c.Usage.RetainMonths < 1 {
+			errs = append(errs, "usage.retain_months must be positive")
+		}
+		if c.Usage.MaxDestinations < 1 {
+			errs = append(errs, "usage.max_destinations must be positive")
+		}
+	}
+
+	// Validate crash reports
+	if c.CrashReports.Enabled {
+		if c.Agent.DataDir == "" {
+			errs = append(errs, "crash_reports requires agent.data_dir to store reports")
+		}
+		if c.CrashReports.MaxReports < 1 {
+			errs = append(errs, "crash_reports.max_reports must be positive")
+		}
+	}
+
+	// Validate watchdog
+	if c.Watchdog.Enabled {
+		if c.Watchdog.Interval <= 0 {
+			errs = append(errs, "watchdog.interval must be positive")
+		}
+		if c.Watchdog.MaxGoroutines < 0 {
+			errs = append(errs, "watchdog.max_goroutines must not be negative")
+		}
+		if c.Watchdog.MaxHeap < 0 {
+			errs = append(errs, "watchdog.max_heap must not be negative")
+		}
+		if c.Watchdog.StuckWriteTimeout <= 0 {
+			errs = append(errs, "watchdog.stuck_write_timeout must be positive")
+		}
+		if c.Watchdog.SnapshotCooldown < 0 {
+			errs = append(errs, "watchdog.snapshot_cooldown must not be negative")
+		}
+		if c.Watchdog.MaxSnapshots < 1 {
+			errs = append(errs, "watchdog.max_snapshots must be positive")
+		}
+		if c.Watchdog.RestartAfter < 1 {
+			errs = append(errs, "watchdog.restart_after must be positive")
+		}
+		if c.Watchdog.RestartCooldown < 0 {
+			errs = append(errs, "watchdog.restart_cooldown must not be negative")
+		}
+		if c.Watchdog.Restart && c.Watchdog.MaxGoroutines == 0 && c.Watchdog.MaxHeap == 0 {
+			errs = append(errs, "watchdog.restart requires watchdog.max_goroutines or watchdog.max_heap")
+		}
+	}
+
+	if err := validateAddressFamily(c.HTTP.AddressFamily, c.HTTP.Address); err != nil {
+		errs = append(errs, fmt.Sprintf("http.address_family: %v", err))
+	}
+
+	if c.HTTP.Readiness.MinPeers < 0 {
+		errs = append(errs, "http.readiness.min_peers must not be negative")
+	}
+	for i, cidr := range c.HTTP.Readiness.Routes {
+		if !isValidCIDR(cidr) {
+			errs = append(errs, fmt.Sprintf("http.readiness.routes[%d]: invalid CIDR: %s", i, cidr))
+		}
+	}
+
+	// Validate HTTP API tokens
+	for i, tok := range c.HTTP.Tokens {
+		if tok.TokenHash == "" {
+			errs = append(errs, fmt.Sprintf("http.tokens[%d].token_hash is required", i))
+		}
+		if _, err := rbac.ParseRole(tok.Role); err != nil {
+			errs = append(errs, fmt.Sprintf("http.tokens[%d].role: %v", i, err))
+		}
+	}
+
+	// Validate RBAC
+	for ou, role := range c.RBAC.PeerRoles {
+		if _, err := rbac.ParseRole(role); err != nil {
+			errs = append(errs, fmt.Sprintf("rbac.peer_roles[%s]: %v", ou, err))
+		}
+	}
+	if c.RBAC.DefaultPeerRole != "" {
+		if _, err := rbac.ParseRole(c.RBAC.DefaultPeerRole); err != nil {
+			errs = append(errs, fmt.Sprintf("rbac.default_peer_role: %v", err))
+		}
+	}
+	if c.RBAC.LegacyRole != "" {
+		if _, err := rbac.ParseRole(c.RBAC.LegacyRole); err != nil {
+			errs = append(errs, fmt.Sprintf("rbac.legacy_role: %v", err))
+		}
+	}
+
+	// Validate chaos
+	if c.Chaos.Latency < 0 {
+		errs = append(errs, "chaos.latency must not be negative")
+	}
+	if c.Chaos.Jitter < 0 {
+		errs = append(errs, "chaos.jitter must not be negative")
+	}
+	if c.Chaos.Loss < 0 || c.Chaos.Loss > 1 {
+		errs = append(errs, "chaos.loss must be between 0 and 1")
+	}
+
+	// Validate debug capture
+	if c.Debug.Duration < 0 {
+		errs = append(errs, "debug.duration must not be negative")
+	}
+	if c.Debug.KeyLog && c.DebugKeyLogFile() == "" {
+		errs = append(errs, "debug.key_log requires debug.key_log_file or agent.data_dir")
+	}
+	if c.Debug.Qlog && c.DebugQlogDir() == "" {
+		errs = append(errs, "debug.qlog requires debug.qlog_dir or agent.data_dir")
+	}
+
+	// Validate UDP log thresholds and filtering
+	if c.UDP.LogThresholds.Endpoints < 0 {
+		errs = append(errs, "udp.log_thresholds.endpoints must not be negative")
+	}
+	switch c.UDP.Filtering {
+	case "", "full-cone", "restricted", "port-restricted":
+	default:
+		errs = append(errs, fmt.Sprintf("udp.filtering must be full-cone, restricted or port-restricted, got %q", c.UDP.Filtering))
+	}
+
+	// Validate ICMP limits
+	if c.ICMP.MaxSessionsPerPeer < 0 {
+		errs = append(errs, "icmp.max_sessions_per_peer must not be negative")
+	}
+	if c.ICMP.DestRate < 0 || c.ICMP.GlobalRate < 0 {
+		errs = append(errs, "icmp.dest_rate and icmp.global_rate must not be negative")
+	}
+	if c.ICMP.DestBurst < 0 || c.ICMP.GlobalBurst < 0 {
+		errs = append(errs, "icmp.dest_burst and icmp.global_burst must not be negative")
+	}
+
+	// Validate exit connection pool
+	if c.Exit.Pool.Enabled {
+		for i, port := range c.Exit.Pool.Ports {
+			if port == 0 {
+				errs = append(errs, fmt.Sprintf("exit.pool.ports[%d]: port must be between 1 and 65535", i))
+			}
+		}
+		if c.Exit.Pool.MaxIdle < 0 || c.Exit.Pool.MaxIdlePerHost < 0 {
+			errs = append(errs, "exit.pool.max_idle and max_idle_per_host must not be negative")
+		}
+		if c.Exit.Pool.IdleTimeout < 0 {
+			errs = append(errs, "exit.pool.idle_timeout must not be negative")
+		}
+	}
+	if c.Exit.HappyEyeballs.Delay < 0 {
+		errs = append(errs, "exit.happy_eyeballs.delay must not be negative")
+	}
+	if dc := c.Exit.DNS.Cache; dc.Enabled {
+		if dc.MinTTL < 0 || dc.MaxTTL < 0 || dc.DefaultTTL < 0 || dc.NegativeTTL < 0 || dc.MaxEntries < 0 {
+			errs = append(errs, "exit.dns.cache: TTLs and max_entries must not be negative")
+		} else if dc.MaxTTL > 0 && dc.MinTTL > dc.MaxTTL {
+			errs = append(errs, "exit.dns.cache.min_ttl must be <= max_ttl")
+		}
+	}
+	for i, z := range c.Exit.DNS.Zones {
+		if strings.Trim(z.Zone, ".") == "" {
+			errs = append(errs, fmt.Sprintf("exit.dns.zones[%d]: zone is required", i))
+		}
+		for _, server := range z.Servers {
+			if _, _, err := net.SplitHostPort(server); err != nil {
+				errs = append(errs, fmt.Sprintf("exit.dns.zones[%d]: invalid server %q: must be host:port", i, server))
+			}
+		}
+	}
+	if ds := c.Exit.DestinationStats; ds.Enabled {
+		if ds.Window < 0 || ds.BlockDuration < 0 || ds.MaxDestinations < 0 {
+			errs = append(errs, "exit.destination_stats: window, block_duration and max_destinations must not be negative")
+		}
+		if ds.Action != "" && ds.Action != "warn" && ds.Action != "block" {
+			errs = append(errs, fmt.Sprintf("exit.destination_stats.action must be \"warn\" or \"block\", got %q", ds.Action))
+		}
+	}
+	if c.Exit.BindAddress != "" && net.ParseIP(c.Exit.BindAddress) == nil {
+		errs = append(errs, fmt.Sprintf("exit.bind_address: invalid IP address: %s", c.Exit.BindAddress))
+	}
+	for i, cidr := range c.Exit.AllowPrivate {
+		if !isValidCIDR(cidr) {
+			errs = append(errs, fmt.Sprintf("exit.allow_private[%d]: invalid CIDR: %s", i, cidr))
+		}
+	}
+	errs = append(errs, validatePortClass("exit.port_limits.smtp", c.Exit.PortLimits.SMTP)...)
+	for i, class := range c.Exit.PortLimits.Classes {
+		field := fmt.Sprintf("exit.port_limits.classes[%d]", i)
+		if class.Name == "" {
+			errs = append(errs, field+": name is required")
+		}
+		if len(class.Ports) == 0 {
+			errs = append(errs, field+": ports is required")
+		}
+		errs = append(errs, validatePortClass(field, class)...)
+	}
+	for i, cidr := range c.Exit.ProxyProtocol {
+		if !isValidCIDR(cidr) {
+			errs = append(errs, fmt.Sprintf("exit.proxy_protocol[%d]: invalid CIDR: %s", i, cidr))
+		}
+	}
+	if up := c.Exit.UserPolicy; up.Enabled {
+		if up.DefaultAction != "" && up.DefaultAction != "allow" && up.DefaultAction != "deny" {
+			errs = append(errs, fmt.Sprintf("exit.user_policy.default_action must be \"allow\" or \"deny\", got %q", up.DefaultAction))
+		}
+		for _, user := range slices.Sorted(maps.Keys(up.Users)) {
+			for i, cidr := range up.Users[user].Routes {
+				if !isValidCIDR(cidr) {
+					errs = append(errs, fmt.Sprintf("exit.user_policy.users.%s.routes[%d]: invalid CIDR: %s", user, i, cidr))
+				}
+			}
+			for i, pattern := range up.Users[user].DomainRoutes {
+				if err := isValidDomainPattern(pattern); err != nil {
+					errs = append(errs, fmt.Sprintf("exit.user_policy.users.%s.domain_routes[%d]: %v", user, i, err))
+				}
+			}
+		}
+	}
+	for i, rb := range c.Exit.RouteBinds {
+		if !isValidCIDR(rb.Route) {
+			errs = append(errs, fmt.Sprintf("exit.route_binds[%d]: invalid CIDR: %s", i, rb.Route))
+		}
+		if rb.BindAddress == "" && rb.BindInterface == "" {
+			errs = append(errs, fmt.Sprintf("exit.route_binds[%d]: bind_address or bind_interface is required", i))
+		}
+		if rb.BindAddress != "" && net.ParseIP(rb.BindAddress) == nil {
+			errs = append(errs, fmt.Sprintf("exit.route_binds[%d]: invalid IP address: %s", i, rb.BindAddress))
+		}
+	}
+
 	// Validate management key configuration
 	if err := c.validateManagementKeys(); err != nil {
 		errs = append(errs, err.Error())
@@ -1138,6 +3177,8 @@ func (c *Config) Validate() error {
 		errs = append(errs, err.Error())
 	}
 
+	errs = append(errs, c.validateTunnel()...)
+
 	if len(errs) > 0 {
 		return fmt.Errorf("validation errors:\n  - %s", strings.Join(errs, "\n  - "))
 	}
@@ -1167,6 +3208,11 @@ func (c *Config) validateGlobalTLS() error {
 		return fmt.Errorf("tls.fingerprint: %w", err)
 	}
 
+	// Validate certificate pinning table
+	if _, err := c.TLS.GetAgentPins(); err != nil {
+		return fmt.Errorf("tls.%w", err)
+	}
+
 	return nil
 }
 
@@ -1213,6 +3259,29 @@ func (c *Config) validateManagementKeys() error {
 		}
 	}
 
+	// Validate the key being rotated in
+	if c.Management.NextPublicKey == "" {
+		if c.Management.NextPrivateKey != "" {
+			return fmt.Errorf("management.next_private_key requires management.next_public_key to be set")
+		}
+	} else {
+		if c.Management.PublicKey == "" {
+			return fmt.Errorf("management.next_public_key requires management.public_key to be set")
+		}
+		next, err := c.GetManagementNextPublicKey()
+		if err != nil {
+			return fmt.Errorf("management.next_public_key: %w", err)
+		}
+		if current, _ := c.GetManagementPublicKey(); current == next {
+			return fmt.Errorf("management.next_public_key must differ from management.public_key")
+		}
+		if c.Management.NextPrivateKey != "" {
+			if _, err := c.GetManagementNextPrivateKey(); err != nil {
+				return fmt.Errorf("management.next_private_key: %w", err)
+			}
+		}
+	}
+
 	// Validate signing keys (Ed25519)
 	if c.Management.SigningPublicKey == "" {
 		// Warn if signing private key is set without public key
@@ -1292,10 +3361,35 @@ func (c *Config) validateForward() error {
 		}
 		if lis.Address == "" {
 			errs = append(errs, fmt.Sprintf("forward.listeners[%d]: address is required", i))
+		} else if err := validateAddressFamily(lis.AddressFamily, lis.Address); err != nil {
+			errs = append(errs, fmt.Sprintf("forward.listeners[%d]: address_family: %v", i, err))
 		}
 		if lis.MaxConnections < 0 {
 			errs = append(errs, fmt.Sprintf("forward.listeners[%d]: max_connections cannot be negative", i))
 		}
+		errs = append(errs, validateNamespace(fmt.Sprintf("forward.listeners[%d].namespace", i), lis.Namespace)...)
+		if lis.TLS.Enabled && (lis.TLS.Cert == "" && lis.TLS.CertPEM == "" || lis.TLS.Key == "" && lis.TLS.KeyPEM == "") {
+			errs = append(errs, fmt.Sprintf("forward.listeners[%d]: tls requires cert and key", i))
+		}
+		for _, name := range slices.Sorted(maps.Keys(lis.SNIMap)) {
+			if err := isValidDomainPattern(name); err != nil {
+				errs = append(errs, fmt.Sprintf("forward.listeners[%d]: sni_map %q: %v", i, name, err))
+			}
+			if lis.SNIMap[name] == "" {
+				errs = append(errs, fmt.Sprintf("forward.listeners[%d]: sni_map %q: key is required", i, name))
+			}
+		}
+		if lis.Hostname != "" {
+			local := strings.HasSuffix(strings.ToLower(lis.Hostname), ".local")
+			switch err := isValidDomainPattern(lis.Hostname); {
+			case err != nil || strings.HasPrefix(lis.Hostname, "*."):
+				errs = append(errs, fmt.Sprintf("forward.listeners[%d]: invalid hostname %q", i, lis.Hostname))
+			case c.Forward.Hostnames.HostsFile == "" && !c.Forward.Hostnames.MDNS:
+				errs = append(errs, fmt.Sprintf("forward.listeners[%d]: hostname requires forward.hostnames.mdns or hosts_file", i))
+			case c.Forward.Hostnames.HostsFile == "" && !local:
+				errs = append(errs, fmt.Sprintf("forward.listeners[%d]: mDNS hostname %q must end in .local", i, lis.Hostname))
+			}
+		}
 	}
 
 	if len(errs) > 0 {
@@ -1305,6 +3399,58 @@ func (c *Config) validateForward() error {
 	return nil
 }
 
+// validateTunnel validates the plain port tunnel configuration.
+func (c *Config) validateTunnel() []string {
+	var errs []string
+	seen := make(map[string]bool)
+	for i, lis := range c.Tunnel.UDPListeners {
+		if lis.Address == "" {
+			errs = append(errs, fmt.Sprintf("tunnel.udp_listeners[%d]: address is required", i))
+		} else if seen[lis.Address] {
+			errs = append(errs, fmt.Sprintf("tunnel.udp_listeners[%d]: duplicate address %q", i, lis.Address))
+		}
+		seen[lis.Address] = true
+		if err := validateAddressFamily(lis.AddressFamily, lis.Address); err != nil {
+			errs = append(errs, fmt.Sprintf("tunnel.udp_listeners[%d]: address_family: %v", i, err))
+		}
+
+		if lis.Target == "" {
+			errs = append(errs, fmt.Sprintf("tunnel.udp_listeners[%d]: target is required", i))
+		} else if err := isValidHostPort(lis.Target); err != nil {
+			errs = append(errs, fmt.Sprintf("tunnel.udp_listeners[%d]: invalid target: %v", i, err))
+		} else if _, port, _ := net.SplitHostPort(lis.Target); !isValidPort(port) {
+			errs = append(errs, fmt.Sprintf("tunnel.udp_listeners[%d]: invalid target port %q", i, port))
+		}
+		if lis.IdleTimeout < 0 {
+			errs = append(errs, fmt.Sprintf("tunnel.udp_listeners[%d]: idle_timeout cannot be negative", i))
+		}
+		if lis.MaxSessions < 0 {
+			errs = append(errs, fmt.Sprintf("tunnel.udp_listeners[%d]: max_sessions cannot be negative", i))
+		}
+	}
+	return errs
+}
+
+// isValidPort reports whether s is a port number from 1 to 65535.
+func isValidPort(s string) bool {
+	n, err := strconv.Atoi(s)
+	return err == nil && n >= 1 && n <= 65535
+}
+
+// validateAddressFamily checks an address_family setting and that the listen
+// address does not bind an IP literal of the other family. Addresses are
+// only checked when a family is set.
+func validateAddressFamily(family, address string) error {
+	f, err := ipfamily.Parse(family)
+	if err != nil {
+		return err
+	}
+	if family == "" || address == "" {
+		return nil
+	}
+	return f.CheckAddress(address)
+}
+
 // isValidHostPort validates a host:port string.
 func isValidHostPort(hostPort string) error {
 	host, port, err := net.SplitHostPort(hostPort)
@@ -1328,21 +3474,60 @@ func isValidLogFormat(format string) bool {
 	return isOneOf(format, "text", "json")
 }
 
+// validateLogOutput validates agent.log_output and the settings of the
+// selected output.
+func (c *Config) validateLogOutput() []string {
+	var errs []string
+	a := &c.Agent
+	switch a.LogOutput {
+	case "", logging.OutputStderr, logging.OutputStdout, logging.OutputEventLog:
+	case logging.OutputFile:
+		if a.LogFile.Path == "" {
+			errs = append(errs, "agent.log_file.path is required when log_output is file")
+		}
+	case logging.OutputSyslog:
+		if _, _, err := logging.ParseSyslogAddress(a.LogSyslog.Address); err != nil {
+			errs = append(errs, "agent.log_syslog.address: "+err.Error())
+		}
+		if _, err := logging.ParseSyslogFacility(a.LogSyslog.Facility); err != nil {
+			errs = append(errs, "agent.log_syslog.facility: "+err.Error())
+		}
+	default:
+		errs = append(errs, fmt.Sprintf("invalid log_output: %s (must be stderr, stdout, file, syslog, or eventlog)", a.LogOutput))
+	}
+	if a.LogFile.MaxSize < 0 {
+		errs = append(errs, "agent.log_file.max_size must not be negative")
+	}
+	if a.LogFile.MaxAge < 0 {
+		errs = append(errs, "agent.log_file.max_age must not be negative")
+	}
+	if a.LogFile.MaxBackups < 0 {
+		errs = append(errs, "agent.log_file.max_backups must not be negative")
+	}
+	return errs
+}
+
 func isValidTransport(transport string) bool {
-	return isOneOf(transport, "quic", "h2", "ws")
+	return isOneOf(transport, "quic", "h2", "ws", "wt", "tcp")
 }
 
 // validateListener validates a listener configuration, considering global TLS settings.
 func (c *Config) validateListener(l ListenerConfig, index int) error {
 	if !isValidTransport(l.Transport) {
-		return fmt.Errorf("invalid transport: %s (must be quic, h2, or ws)", l.Transport)
+		return fmt.Errorf("invalid transport: %s (must be quic, h2, ws, wt, or tcp)", l.Transport)
 	}
 	if l.Address == "" {
 		return fmt.Errorf("address is required")
 	}
-	if (l.Transport == "h2" || l.Transport == "ws") && l.Path == "" {
+	if (l.Transport == "h2" || l.Transport == "ws" || l.Transport == "wt") && l.Path == "" {
 		return fmt.Errorf("path is required for %s transport", l.Transport)
 	}
+	if _, err := l.GetAllowedAgents(); err != nil {
+		return err
+	}
+	if err := validateAddressFamily(l.AddressFamily, l.Address); err != nil {
+		return fmt.Errorf("address_family: %w", err)
+	}
 	// PlainText mode is only supported for WebSocket (for reverse proxy scenarios)
 	if l.PlainText {
 		if l.Transport != "ws" {
@@ -1380,7 +3565,7 @@ func (c *Config) validatePeer(p PeerConfig, index int) error {
 		return fmt.Errorf("id is required")
 	}
 	if !isValidTransport(p.Transport) {
-		return fmt.Errorf("invalid transport: %s (must be quic, h2, or ws)", p.Transport)
+		return fmt.Errorf("invalid transport: %s (must be quic, h2, ws, wt, or tcp)", p.Transport)
 	}
 	if p.Address == "" {
 		return fmt.Errorf("address is required")
@@ -1398,14 +3583,157 @@ func (c *Config) validatePeer(p PeerConfig, index int) error {
 		return fmt.Errorf("tls.ca is required when strict mode is enabled (for peer certificate verification)")
 	}
 
+	if p.CertFingerprint != "" {
+		if _, err := certutil.NormalizeFingerprint(p.CertFingerprint); err != nil {
+			return fmt.Errorf("cert_fingerprint: %w", err)
+		}
+	}
+
+	if p.Proxy != "" {
+		if err := validateProxyURL(p.Proxy, p.Transport); err != nil {
+			return fmt.Errorf("proxy: %w", err)
+		}
+	}
+
+	if p.MaxFrameSize != 0 {
+		if err := validateFrameSize(p.MaxFrameSize); err != nil {
+			return fmt.Errorf("max_frame_size: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// validateFrameSize checks a frame payload limit.
+func validateFrameSize(n int) error {
+	if n < protocol.MinPayloadSize || n > protocol.MaxPayloadSize {
+		return fmt.Errorf("must be between %d and %d", protocol.MinPayloadSize, protocol.MaxPayloadSize)
+	}
 	return nil
 }
 
+// validateDiscovery checks the peer discovery sources.
+func (c *Config) validateDiscovery() error {
+	d := c.Discovery
+	if d.DNS.Enabled {
+		if d.DNS.Zone == "" {
+			return fmt.Errorf("discovery.dns.zone is required when DNS discovery is enabled")
+		}
+		if d.DNS.Interval <= 0 {
+			return fmt.Errorf("discovery.dns.interval must be positive")
+		}
+	}
+	if d.MDNS.Enabled {
+		if d.MDNS.Interval <= 0 {
+			return fmt.Errorf("discovery.mdns.interval must be positive")
+		}
+		if d.MDNS.Timeout <= 0 || d.MDNS.Timeout >= d.MDNS.Interval {
+			return fmt.Errorf("discovery.mdns.timeout must be positive and shorter than discovery.mdns.interval")
+		}
+	}
+	if !d.DNS.Enabled && !d.MDNS.Enabled {
+		return nil
+	}
+	if d.MaxPeers < 1 {
+		return fmt.Errorf("discovery.max_peers must be at least 1")
+	}
+	if d.TLS.HasCert() != d.TLS.HasKey() {
+		return fmt.Errorf("discovery.tls cert and key must both be specified or both be empty")
+	}
+	if c.GetEffectiveStrict(&d.TLS) && !d.TLS.HasCA() && !c.TLS.HasCA() {
+		return fmt.Errorf("discovery.tls.ca is required when strict mode is enabled (for peer certificate verification)")
+	}
+	return nil
+}
+
+// validateProxyURL checks an upstream proxy URL for a peer transport.
+// QUIC-based transports can only be relayed by SOCKS5 proxies (UDP ASSOCIATE).
+func validateProxyURL(proxy, transport string) error {
+	u, err := url.Parse(proxy)
+	if err != nil {
+		return err
+	}
+	if !isOneOf(u.Scheme, "http", "https", "socks5", "socks5h") {
+		return fmt.Errorf("unsupported scheme %q (must be http, https, socks5, or socks5h)", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return fmt.Errorf("missing host")
+	}
+	if (transport == "quic" || transport == "wt") && !isOneOf(u.Scheme, "socks5", "socks5h") {
+		return fmt.Errorf("%s transport requires a socks5 or socks5h proxy (HTTP proxies cannot carry UDP)", transport)
+	}
+	return nil
+}
+
+// validatePortClass checks the action and limits of an exit port class.
+func validatePortClass(field string, class ExitPortClassConfig) []string {
+	var errs []string
+	switch class.Action {
+	case "", "block", "limit", "allow":
+	default:
+		errs = append(errs, fmt.Sprintf("%s.action must be \"block\", \"limit\" or \"allow\", got %q", field, class.Action))
+	}
+	if slices.Contains(class.Ports, 0) {
+		errs = append(errs, field+".ports: port 0 is not valid")
+	}
+	if class.MaxConnections < 0 || class.Rate < 0 || class.Burst < 0 {
+		errs = append(errs, field+": max_connections, rate and burst must not be negative")
+	}
+	return errs
+}
+
 func isValidCIDR(cidr string) bool {
 	_, _, err := net.ParseCIDR(cidr)
 	return err == nil
 }
 
+// maxTagLength is the longest agent or exit tag accepted.
+const maxTagLength = 64
+
+// validateTags checks a list of agent or exit tags: at most protocol.MaxTagsInNodeInfo
+// unique tags of letters, digits and ".:_-".
+func validateTags(field string, tags []string) []string {
+	var errs []string
+	if len(tags) > protocol.MaxTagsInNodeInfo {
+		errs = append(errs, fmt.Sprintf("%s: at most %d tags allowed", field, protocol.MaxTagsInNodeInfo))
+	}
+	seen := make(map[string]bool, len(tags))
+	for i, tag := range tags {
+		switch {
+		case tag == "":
+			errs = append(errs, fmt.Sprintf("%s[%d]: empty tag", field, i))
+		case len(tag) > maxTagLength:
+			errs = append(errs, fmt.Sprintf("%s[%d]: tag longer than %d characters", field, i, maxTagLength))
+		case strings.IndexFunc(tag, func(r rune) bool { return !isTagChar(r) }) >= 0:
+			errs = append(errs, fmt.Sprintf("%s[%d]: invalid tag %q (allowed: letters, digits and .:_-)", field, i, tag))
+		case seen[tag]:
+			errs = append(errs, fmt.Sprintf("%s[%d]: duplicate tag %q", field, i, tag))
+		}
+		seen[tag] = true
+	}
+	return errs
+}
+
+// validateNamespace checks a tenant namespace, which follows the tag rules.
+// Empty is the default namespace.
+func validateNamespace(field, ns string) []string {
+	switch {
+	case ns == "":
+		return nil
+	case len(ns) > maxTagLength:
+		return []string{fmt.Sprintf("%s: longer than %d characters", field, maxTagLength)}
+	case strings.IndexFunc(ns, func(r rune) bool { return !isTagChar(r) }) >= 0:
+		return []string{fmt.Sprintf("%s: invalid namespace %q (allowed: letters, digits and .:_-)", field, ns)}
+	}
+	return nil
+}
+
+// isTagChar reports whether r may appear in an exit tag.
+func isTagChar(r rune) bool {
+	return (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') ||
+		r == '.' || r == ':' || r == '_' || r == '-'
+}
+
 // isValidDomainPattern validates a domain pattern (exact or *.wildcard).
 func isValidDomainPattern(pattern string) error {
 	if pattern == "" {
@@ -1473,6 +3801,14 @@ func (c *Config) StringUnsafe() string {
 	return string(data)
 }
 
+// Hash returns a short fingerprint of the configuration, for telling apart
+// the configurations that crash reports were written under. Sensitive
+// values are part of the hash but cannot be recovered from it.
+func (c *Config) Hash() string {
+	sum := sha256.Sum256([]byte(c.StringUnsafe()))
+	return hex.EncodeToString(sum[:8])
+}
+
 // redactedValue is the placeholder for sensitive values.
 const redactedValue = "[REDACTED]"
 
@@ -1520,11 +3856,19 @@ func (c *Config) Redacted() *Config {
 		redact(&redacted.SOCKS5.Auth.Users[i].PasswordHash)
 	}
 
+	// Redact SOCKS5 external auth webhook headers (may carry API keys)
+	for k, v := range redacted.SOCKS5.Auth.External.Headers {
+		redact(&v)
+		redacted.SOCKS5.Auth.External.Headers[k] = v
+	}
+
 	// Redact other sensitive fields
 	redact(&redacted.Agent.PrivateKey)
 	redact(&redacted.FileTransfer.PasswordHash)
 	redact(&redacted.Shell.PasswordHash)
+	redact(&redacted.Shell.RunAs.Password)
 	redact(&redacted.Management.PrivateKey)
+	redact(&redacted.Management.NextPrivateKey)
 	redact(&redacted.Management.SigningPrivateKey)
 
 	return redacted
@@ -1551,18 +3895,23 @@ func (c *Config) HasSensitiveData() bool {
 		}
 	}
 
+	// Check SOCKS5 external auth webhook headers (may carry API keys)
+	if len(c.SOCKS5.Auth.External.Headers) > 0 {
+		return true
+	}
+
 	// Check FileTransfer password hash
 	if c.FileTransfer.PasswordHash != "" {
 		return true
 	}
 
-	// Check Shell password hash
-	if c.Shell.PasswordHash != "" {
+	// Check Shell password hash and run_as password
+	if c.Shell.PasswordHash != "" || c.Shell.RunAs.Password != "" {
 		return true
 	}
 
-	// Check management private key
-	if c.Management.PrivateKey != "" {
+	// Check management private keys
+	if c.Management.PrivateKey != "" || c.Management.NextPrivateKey != "" {
 		return true
 	}
 
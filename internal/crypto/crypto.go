@@ -6,6 +6,7 @@ import (
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"sync"
@@ -29,19 +30,50 @@ const (
 	// This includes the nonce (12 bytes) prepended and the auth tag (16 bytes) appended.
 	EncryptionOverhead = NonceSize + TagSize
 
+	// MaxEncryptionOverhead is the overhead of messages that also announce
+	// a new key after a rekey (see EnableRekey).
+	MaxEncryptionOverhead = EncryptionOverhead + KeySize
+
+	// ReplayWindow is how far behind the newest nonce a datagram session
+	// still accepts a message (see SessionKey.EnableReplayWindow).
+	ReplayWindow = 1024
+
 	// hkdfInfo is the context string for HKDF key derivation.
 	hkdfInfo = "muti-metroo-e2e-v1"
+
+	// Bits of the first nonce byte. The remaining bits of the first four
+	// bytes hold the key epoch (see EnableRekey), zero without rekeying.
+	nonceResponder = 0x80 // Sent by the responder
+	nonceAnnounce  = 0x40 // Message carries the public key of a new epoch
 )
 
+// ErrReplay is returned by Decrypt for a message that authenticates but is
+// replayed, out of sequence, or was sent in the receiver's own direction.
+// The session should be torn down when it occurs.
+var ErrReplay = errors.New("replayed or out-of-sequence message")
+
 // SessionKey holds the symmetric key and nonce state for encrypting/decrypting
 // stream data. It is safe for concurrent use.
+//
+// By default every received message must carry the next nonce in sequence,
+// which suits byte streams where a dropped, replayed or reordered frame would
+// corrupt the data. Datagram sessions call EnableReplayWindow instead.
 type SessionKey struct {
-	key [KeySize]byte
+	// Keys of both directions. They are the same until a side rekeys.
+	sendKey [KeySize]byte
+	recvKey [KeySize]byte
 
 	// Separate nonce counters for send and receive directions
 	// to avoid nonce reuse in bidirectional streams.
 	sendNonce uint64
-	recvNonce uint64
+	recv      replayState
+
+	// windowed accepts out-of-order nonces within ReplayWindow of the
+	// newest one.
+	windowed bool
+
+	// rekey is set by EnableRekey.
+	rekey *rekeyState
 
 	// isInitiator determines which nonce space to use:
 	// - Initiator (ingress): uses even nonces for send, odd for receive
@@ -118,37 +150,62 @@ func DeriveSessionKey(sharedSecret [KeySize]byte, streamID uint64,
 	sk := &SessionKey{
 		isInitiator: isInitiator,
 	}
-	if _, err := io.ReadFull(reader, sk.key[:]); err != nil {
+	if _, err := io.ReadFull(reader, sk.sendKey[:]); err != nil {
 		// This should never happen with valid inputs
 		panic(fmt.Sprintf("HKDF failed: %v", err))
 	}
+	sk.recvKey = sk.sendKey
 
 	return sk
 }
 
+// EnableReplayWindow lets the key accept messages up to ReplayWindow nonces
+// behind the newest one, in any order, each at most once. Use it for datagram
+// sessions (UDP, ICMP) where loss and reordering are expected. Call it before
+// the first Decrypt.
+func (s *SessionKey) EnableReplayWindow() {
+	s.mu.Lock()
+	s.windowed = true
+	s.mu.Unlock()
+}
+
 // Encrypt encrypts plaintext using ChaCha20-Poly1305 with a unique nonce.
 // The nonce is prepended to the ciphertext, resulting in a message that is
 // EncryptionOverhead bytes larger than the plaintext.
 //
 // The nonce format uses the upper bit to indicate direction (send vs receive)
 // and the remaining bits as a counter, ensuring nonce uniqueness.
+//
+// With rekeying enabled, the first messages after a rekey also carry the
+// public key of the new epoch (see EnableRekey) and are KeySize bytes larger.
 func (s *SessionKey) Encrypt(plaintext []byte) ([]byte, error) {
 	s.mu.Lock()
-	nonce := s.buildSendNonce()
+	var announce []byte
+	if s.rekey != nil {
+		var err error
+		if announce, err = s.rekeySend(len(plaintext)); err != nil {
+			s.mu.Unlock()
+			return nil, err
+		}
+	}
+	nonce := s.buildSendNonce(announce != nil)
+	key := s.sendKey
 	s.sendNonce++
 	s.mu.Unlock()
 
-	aead, err := chacha20poly1305.New(s.key[:])
+	aead, err := chacha20poly1305.New(key[:])
 	if err != nil {
 		return nil, fmt.Errorf("create cipher: %w", err)
 	}
 
-	// Output: nonce || ciphertext || tag
-	// Capacity: NonceSize + len(plaintext) + TagSize
-	ciphertext := make([]byte, NonceSize, NonceSize+len(plaintext)+TagSize)
+	// Output: nonce || [announced public key] || ciphertext || tag
+	// Capacity: NonceSize + len(announce) + len(plaintext) + TagSize
+	ciphertext := make([]byte, NonceSize, NonceSize+len(announce)+len(plaintext)+TagSize)
 	copy(ciphertext, nonce[:])
+	ciphertext = append(ciphertext, announce...)
 
-	ciphertext = aead.Seal(ciphertext, nonce[:], plaintext, nil)
+	// The announced key is authenticated as additional data
+	ciphertext = aead.Seal(ciphertext, nonce[:], plaintext, announce)
 
 	return ciphertext, nil
 }
@@ -164,76 +221,212 @@ func (s *SessionKey) Decrypt(ciphertext []byte) ([]byte, error) {
 	// Extract nonce from the beginning
 	var nonce [NonceSize]byte
 	copy(nonce[:], ciphertext[:NonceSize])
+	body := ciphertext[NonceSize:]
+
+	var announce []byte
+	if nonce[0]&nonceAnnounce != 0 {
+		if len(body) < KeySize+TagSize {
+			return nil, fmt.Errorf("ciphertext too short: %d bytes", len(ciphertext))
+		}
+		announce, body = body[:KeySize], body[KeySize:]
+	}
 
-	// Verify nonce is in expected range (optional, helps detect replay/reorder)
-	s.mu.Lock()
-	expectedNonce := s.buildRecvNonce()
-	// Allow some slack for out-of-order delivery (up to 1024 messages ahead)
-	nonceValue := binary.BigEndian.Uint64(nonce[4:])
-	expectedValue := binary.BigEndian.Uint64(expectedNonce[4:])
-	if nonceValue < expectedValue {
-		s.mu.Unlock()
-		return nil, fmt.Errorf("nonce too old: received %d, expected >= %d", nonceValue, expectedValue)
-	}
-	// Update expected nonce if this one is higher
-	if nonceValue >= s.recvNonce {
-		s.recvNonce = nonceValue + 1
+	// Check the nonce before spending time on authentication. The nonce is
+	// only recorded as seen once the message authenticates, so forged
+	// frames cannot move the window.
+	key, next, err := s.checkNonce(nonce, announce)
+	if err != nil {
+		return nil, err
 	}
-	s.mu.Unlock()
 
-	aead, err := chacha20poly1305.New(s.key[:])
+	aead, err := chacha20poly1305.New(key[:])
 	if err != nil {
 		return nil, fmt.Errorf("create cipher: %w", err)
 	}
 
-	plaintext, err := aead.Open(nil, nonce[:], ciphertext[NonceSize:], nil)
+	plaintext, err := aead.Open(nil, nonce[:], body, announce)
 	if err != nil {
 		return nil, fmt.Errorf("decrypt: %w", err)
 	}
 
+	// Check again under the lock: a concurrent Decrypt may have accepted
+	// the same nonce while this one was being authenticated.
+	if err := s.acceptNonce(nonce, key, next); err != nil {
+		return nil, err
+	}
+
 	return plaintext, nil
 }
 
-// buildSendNonce creates a nonce for sending based on counter and direction.
-// Format: [4 bytes: direction indicator] [8 bytes: counter]
-// Direction: 0x00000000 for initiator->responder, 0x80000000 for responder->initiator
-func (s *SessionKey) buildSendNonce() [NonceSize]byte {
-	var nonce [NonceSize]byte
+// replayState tracks the received nonce counters of one key.
+type replayState struct {
+	next uint64 // Next expected counter (one past the newest accepted)
 
-	// Set direction bit in first 4 bytes
-	if !s.isInitiator {
-		// Responder sends with high bit set
-		nonce[0] = 0x80
+	// seen records which counters within ReplayWindow of the newest one
+	// have been accepted; bit i stands for counter next-1-i.
+	seen [ReplayWindow / 64]uint64
+}
+
+// check reports whether counter n would be accepted.
+func (r *replayState) check(n uint64, windowed bool) error {
+	if !windowed {
+		if n != r.next {
+			return fmt.Errorf("%w: received nonce %d, expected %d", ErrReplay, n, r.next)
+		}
+		return nil
 	}
 
-	// Counter in last 8 bytes
-	binary.BigEndian.PutUint64(nonce[4:], s.sendNonce)
+	if n >= r.next {
+		return nil
+	}
+	age := r.next - 1 - n
+	if age >= ReplayWindow {
+		return fmt.Errorf("%w: nonce %d is outside the replay window", ErrReplay, n)
+	}
+	if r.seen[age/64]&(1<<(age%64)) != 0 {
+		return fmt.Errorf("%w: nonce %d already received", ErrReplay, n)
+	}
+	return nil
+}
 
-	return nonce
+// accept records counter n, which check accepted, as received.
+func (r *replayState) accept(n uint64) {
+	if n < r.next {
+		// Within the window (check verified)
+		age := r.next - 1 - n
+		r.seen[age/64] |= 1 << (age % 64)
+		return
+	}
+	r.shiftWindow(n + 1 - r.next)
+	r.seen[0] |= 1
+	r.next = n + 1
+}
+
+// shiftWindow ages the seen bitmap by shift counters.
+func (r *replayState) shiftWindow(shift uint64) {
+	if shift >= ReplayWindow {
+		r.seen = [ReplayWindow / 64]uint64{}
+		return
+	}
+	words, bits := int(shift/64), shift%64
+	for i := len(r.seen) - 1; i >= 0; i-- {
+		var v uint64
+		if j := i - words; j >= 0 {
+			v = r.seen[j] << bits
+			if bits > 0 && j > 0 {
+				v |= r.seen[j-1] >> (64 - bits)
+			}
+		}
+		r.seen[i] = v
+	}
+}
+
+// checkNonce reports whether a message with nonce would be accepted and
+// returns the key to open it with. next is true when the message starts a
+// new receive epoch announced with the public key announce.
+func (s *SessionKey) checkNonce(nonce [NonceSize]byte, announce []byte) (key [KeySize]byte, next bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	responder, epoch, n := parseNonce(nonce)
+	if responder != s.isInitiator {
+		return key, false, fmt.Errorf("%w: nonce from wrong direction", ErrReplay)
+	}
+	if k, st := s.recvState(epoch); st != nil {
+		return *k, false, st.check(n, s.windowed)
+	}
+	if s.rekey == nil || announce == nil || epoch != s.rekey.recvEpoch+1 {
+		return key, false, fmt.Errorf("%w: nonce from key epoch %d", ErrReplay, epoch)
+	}
+	if !s.windowed && n != 0 {
+		return key, false, fmt.Errorf("%w: received nonce %d, expected 0", ErrReplay, n)
+	}
+	key, err = s.rekey.recvKeyFor(s.recvKey, [KeySize]byte(announce), nonce[0]&nonceResponder, epoch)
+	return key, err == nil, err
+}
+
+// acceptNonce validates nonce and records it as received. With next set,
+// key becomes the receive key first.
+func (s *SessionKey) acceptNonce(nonce [NonceSize]byte, key [KeySize]byte, next bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, epoch, n := parseNonce(nonce)
+	if next && epoch == s.rekey.recvEpoch+1 {
+		s.rotateRecv(key, epoch)
+	}
+	_, st := s.recvState(epoch)
+	if st == nil {
+		return fmt.Errorf("%w: nonce from key epoch %d", ErrReplay, epoch)
+	}
+	if err := st.check(n, s.windowed); err != nil {
+		return err
+	}
+	st.accept(n)
+	return nil
 }
 
-// buildRecvNonce creates an expected nonce for receiving based on counter and direction.
-func (s *SessionKey) buildRecvNonce() [NonceSize]byte {
+// recvState returns the key and replay state for received messages of
+// epoch, or nil if the epoch is not accepted.
+// Must be called with s.mu held.
+func (s *SessionKey) recvState(epoch uint32) (*[KeySize]byte, *replayState) {
+	rk := s.rekey
+	if rk == nil {
+		if epoch == 0 {
+			return &s.recvKey, &s.recv
+		}
+		return nil, nil
+	}
+	switch {
+	case epoch == rk.recvEpoch:
+		return &s.recvKey, &s.recv
+	case rk.hasPrev && epoch+1 == rk.recvEpoch:
+		return &rk.prevKey, &rk.prev
+	}
+	return nil, nil
+}
+
+// parseNonce splits a nonce into its direction, key epoch and counter.
+func parseNonce(nonce [NonceSize]byte) (responder bool, epoch uint32, n uint64) {
+	responder = nonce[0]&nonceResponder != 0
+	epoch = binary.BigEndian.Uint32(nonce[:4]) &^ (uint32(nonceResponder|nonceAnnounce) << 24)
+	n = binary.BigEndian.Uint64(nonce[4:])
+	return responder, epoch, n
+}
+
+// buildSendNonce creates a nonce for sending based on counter and direction.
+// Format: [4 bytes: direction, announce bit and key epoch] [8 bytes: counter]
+// Direction: 0x00000000 for initiator->responder, 0x80000000 for responder->initiator
+// The epoch is 0 and the announce bit clear unless rekeying is enabled.
+// Must be called with s.mu held.
+func (s *SessionKey) buildSendNonce(announce bool) [NonceSize]byte {
 	var nonce [NonceSize]byte
 
-	// Receive direction is opposite of send
-	if s.isInitiator {
-		// Initiator receives from responder (high bit set)
-		nonce[0] = 0x80
+	if s.rekey != nil {
+		binary.BigEndian.PutUint32(nonce[:4], s.rekey.sendEpoch)
+	}
+
+	// Set direction bit in first 4 bytes
+	if !s.isInitiator {
+		// Responder sends with high bit set
+		nonce[0] |= nonceResponder
+	}
+	if announce {
+		nonce[0] |= nonceAnnounce
 	}
 
 	// Counter in last 8 bytes
-	binary.BigEndian.PutUint64(nonce[4:], s.recvNonce)
+	binary.BigEndian.PutUint64(nonce[4:], s.sendNonce)
 
 	return nonce
 }
 
-// Key returns a copy of the session key bytes.
+// Key returns a copy of the session key bytes used for sending.
 // This should only be used for debugging or testing.
 func (s *SessionKey) Key() [KeySize]byte {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	return s.key
+	return s.sendKey
 }
 
 // Zero securely zeros the session key material.
@@ -241,7 +434,11 @@ func (s *SessionKey) Key() [KeySize]byte {
 func (s *SessionKey) Zero() {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	ZeroKey(&s.key)
+	ZeroKey(&s.sendKey)
+	ZeroKey(&s.recvKey)
+	if s.rekey != nil {
+		s.rekey.zero()
+	}
 }
 
 // ZeroBytes zeroes out a byte slice to prevent sensitive data from lingering
@@ -3,7 +3,9 @@ package crypto
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"math/rand"
 	"sort"
 	"sync"
 	"testing"
@@ -349,7 +351,7 @@ func BenchmarkDecrypt(b *testing.B) {
 	b.SetBytes(int64(len(plaintext)))
 
 	for i := 0; i < b.N; i++ {
-		sk.recvNonce = 0 // Reset for benchmark
+		sk.recv.next = 0 // Reset for benchmark
 		_, _ = sk.Decrypt(ciphertext)
 	}
 }
@@ -590,22 +592,148 @@ func TestDecrypt_OutOfOrder(t *testing.T) {
 		encrypted[i], _ = skA.Encrypt(messages[i])
 	}
 
-	// Decrypt out of order: 2, 0, 4, 1, 3
-	order := []int{2, 0, 4, 1, 3}
-	for _, idx := range order {
-		dec, err := skB.Decrypt(encrypted[idx])
+	// Stream sessions require strict sequence: skipping ahead is rejected
+	if _, err := skB.Decrypt(encrypted[2]); !errors.Is(err, ErrReplay) {
+		t.Fatalf("Decrypt(2) before 0 error = %v, want ErrReplay", err)
+	}
+
+	// A rejected message does not disturb the sequence
+	for i := range encrypted {
+		dec, err := skB.Decrypt(encrypted[i])
 		if err != nil {
-			// Out-of-order messages after nonce advancement may fail
-			// The implementation rejects old nonces for replay protection
-			t.Logf("Decrypt message %d out-of-order: %v (may be expected)", idx, err)
-			continue
+			t.Fatalf("Decrypt(%d) failed: %v", i, err)
+		}
+		if !bytes.Equal(dec, messages[i]) {
+			t.Errorf("Message %d mismatch", i)
+		}
+	}
+}
+
+func TestDecrypt_ReplayWindow(t *testing.T) {
+	priv, pub, _ := GenerateEphemeralKeypair()
+	secret, _ := ComputeECDH(priv, pub)
+	skA := DeriveSessionKey(secret, 1, pub, pub, true)
+	skB := DeriveSessionKey(secret, 1, pub, pub, false)
+	skB.EnableReplayWindow()
+
+	encrypted := make([][]byte, 5)
+	for i := range encrypted {
+		encrypted[i], _ = skA.Encrypt([]byte(fmt.Sprintf("datagram-%d", i)))
+	}
+
+	// Out of order and with gaps is fine
+	for _, idx := range []int{2, 0, 4, 1} {
+		if _, err := skB.Decrypt(encrypted[idx]); err != nil {
+			t.Fatalf("Decrypt(%d) failed: %v", idx, err)
+		}
+	}
+
+	// Each nonce is accepted once
+	for _, idx := range []int{0, 1, 2, 4} {
+		if _, err := skB.Decrypt(encrypted[idx]); !errors.Is(err, ErrReplay) {
+			t.Errorf("replayed Decrypt(%d) error = %v, want ErrReplay", idx, err)
+		}
+	}
+	if _, err := skB.Decrypt(encrypted[3]); err != nil {
+		t.Fatalf("Decrypt(3) failed: %v", err)
+	}
+}
+
+func TestDecrypt_ReplayWindowExpiry(t *testing.T) {
+	priv, pub, _ := GenerateEphemeralKeypair()
+	secret, _ := ComputeECDH(priv, pub)
+	skA := DeriveSessionKey(secret, 1, pub, pub, true)
+	skB := DeriveSessionKey(secret, 1, pub, pub, false)
+	skB.EnableReplayWindow()
+
+	first, _ := skA.Encrypt([]byte("old"))
+	second, _ := skA.Encrypt([]byte("inside"))
+	var last []byte
+	for i := 0; i < ReplayWindow-1; i++ {
+		last, _ = skA.Encrypt([]byte("new"))
+	}
+	if _, err := skB.Decrypt(last); err != nil {
+		t.Fatalf("Decrypt(newest) failed: %v", err)
+	}
+
+	// Nonce 0 is ReplayWindow behind the newest, nonce 1 just inside
+	if _, err := skB.Decrypt(first); !errors.Is(err, ErrReplay) {
+		t.Errorf("Decrypt(outside window) error = %v, want ErrReplay", err)
+	}
+	if _, err := skB.Decrypt(second); err != nil {
+		t.Errorf("Decrypt(inside window) failed: %v", err)
+	}
+}
+
+func TestDecrypt_ReplayWindowMatchesModel(t *testing.T) {
+	priv, pub, _ := GenerateEphemeralKeypair()
+	secret, _ := ComputeECDH(priv, pub)
+	skA := DeriveSessionKey(secret, 1, pub, pub, true)
+	skB := DeriveSessionKey(secret, 1, pub, pub, false)
+	skB.EnableReplayWindow()
+
+	const count = 4000
+	encrypted := make([][]byte, count)
+	for i := range encrypted {
+		encrypted[i], _ = skA.Encrypt(nil)
+	}
+
+	// Deliver a random mix of fresh, reordered and replayed nonces and
+	// compare against a simple set-based model of the window
+	rng := rand.New(rand.NewSource(1))
+	seen := make(map[int]bool)
+	next := 0
+	for i := 0; i < 3*count; i++ {
+		n := min(next+rng.Intn(80)-60, count-1)
+		n = max(n, 0)
+		want := n >= next || (next-1-n < ReplayWindow && !seen[n])
+
+		_, err := skB.Decrypt(encrypted[n])
+		if (err == nil) != want {
+			t.Fatalf("Decrypt(nonce %d) with newest %d: error = %v, want accepted %v", n, next-1, err, want)
 		}
-		if !bytes.Equal(dec, messages[idx]) {
-			t.Errorf("Message %d mismatch", idx)
+		if err == nil {
+			seen[n] = true
+			next = max(next, n+1)
 		}
 	}
 }
 
+func TestDecrypt_ForgedNonceDoesNotAdvance(t *testing.T) {
+	priv, pub, _ := GenerateEphemeralKeypair()
+	secret, _ := ComputeECDH(priv, pub)
+	skA := DeriveSessionKey(secret, 1, pub, pub, true)
+	skB := DeriveSessionKey(secret, 1, pub, pub, false)
+	skB.EnableReplayWindow()
+
+	enc, _ := skA.Encrypt([]byte("real"))
+
+	// A frame with a far-ahead nonce and a bad tag must not move the window
+	forged := make([]byte, len(enc))
+	copy(forged, enc)
+	binary.BigEndian.PutUint64(forged[4:NonceSize], 1<<40)
+	if _, err := skB.Decrypt(forged); err == nil {
+		t.Fatal("forged message decrypted")
+	}
+
+	if _, err := skB.Decrypt(enc); err != nil {
+		t.Fatalf("Decrypt(real) after forged frame failed: %v", err)
+	}
+}
+
+func TestDecrypt_ReflectedMessage(t *testing.T) {
+	priv, pub, _ := GenerateEphemeralKeypair()
+	secret, _ := ComputeECDH(priv, pub)
+	skA := DeriveSessionKey(secret, 1, pub, pub, true)
+
+	// A message sent back to its sender authenticates under the same key
+	// but carries the sender's direction
+	enc, _ := skA.Encrypt([]byte("echo"))
+	if _, err := skA.Decrypt(enc); !errors.Is(err, ErrReplay) {
+		t.Errorf("Decrypt(reflected) error = %v, want ErrReplay", err)
+	}
+}
+
 func TestNonce_DirectionIsolation(t *testing.T) {
 	privA, pubA, _ := GenerateEphemeralKeypair()
 	privB, pubB, _ := GenerateEphemeralKeypair()
@@ -681,11 +809,6 @@ func TestEncrypt_LargePayload(t *testing.T) {
 				t.Fatalf("Encrypt failed: %v", err)
 			}
 
-			// Reset recv nonce for clean test
-			skB.mu.Lock()
-			skB.recvNonce = 0
-			skB.mu.Unlock()
-
 			dec, err := skB.Decrypt(enc)
 			if err != nil {
 				t.Fatalf("Decrypt failed: %v", err)
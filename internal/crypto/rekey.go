@@ -0,0 +1,208 @@
+package crypto
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"time"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	// rekeyInfo is the context string for deriving the key of a new epoch.
+	rekeyInfo = "muti-metroo-e2e-rekey-v1"
+
+	// maxEpoch is the last key epoch; the nonce has 24 bits for it.
+	maxEpoch = 1<<24 - 1
+
+	// announceDatagrams is how many messages of a new epoch carry its
+	// public key in datagram sessions, so the rekey survives lost
+	// datagrams. Byte streams announce it in the first message only.
+	announceDatagrams = 64
+)
+
+// RekeyConfig sets when a session replaces the key it sends with. A new
+// key is derived after Bytes of plaintext or Interval, whichever comes
+// first. Zero values disable that trigger.
+type RekeyConfig struct {
+	Bytes    uint64
+	Interval time.Duration
+}
+
+// Enabled reports whether any trigger is set.
+func (c RekeyConfig) Enabled() bool {
+	return c.Bytes > 0 || c.Interval > 0
+}
+
+// rekeyState is the key rotation state of a session.
+type rekeyState struct {
+	cfg RekeyConfig
+
+	// Ephemeral keys of the session key exchange. A new key is agreed
+	// between a fresh keypair of the sender and the receiver's exchange key.
+	localPrivate [KeySize]byte
+	remotePublic [KeySize]byte
+
+	sendEpoch    uint32
+	sendBytes    uint64    // Plaintext sent with the current key
+	sendStart    time.Time // When the current send key was introduced
+	announce     [KeySize]byte
+	announceLeft int // Messages that still carry announce
+
+	recvEpoch uint32
+
+	// Key and replay state of the previous receive epoch, kept in
+	// datagram sessions for messages sent before the rekey that arrive
+	// after it.
+	prevKey [KeySize]byte
+	prev    replayState
+	hasPrev bool
+}
+
+// EnableRekey lets both sides of the session replace their keys without
+// interrupting it. Each side rotates the key it sends with according to cfg;
+// a zero cfg only accepts the peer's rotations. Both sides must enable it,
+// which is negotiated when the session is opened.
+//
+// localPrivate and remotePublic are the ephemeral keys of the session key
+// exchange; the session keeps a copy of localPrivate until Zero. Call it
+// before the first Encrypt or Decrypt.
+//
+// On a rekey the sender generates a new ephemeral keypair, agrees a secret
+// with remotePublic, and derives the next key from that secret and the
+// current key. The first messages with the new key carry its public key, so
+// the receiver derives the same key with localPrivate.
+func (s *SessionKey) EnableRekey(localPrivate, remotePublic [KeySize]byte, cfg RekeyConfig) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rekey = &rekeyState{
+		cfg:          cfg,
+		localPrivate: localPrivate,
+		remotePublic: remotePublic,
+		sendStart:    time.Now(),
+	}
+}
+
+// Epochs returns how many times the send and receive keys were replaced.
+func (s *SessionKey) Epochs() (send, recv uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.rekey == nil {
+		return 0, 0
+	}
+	return s.rekey.sendEpoch, s.rekey.recvEpoch
+}
+
+// rekeySend accounts for n bytes of plaintext about to be sent, rotating the
+// send key first when it is due. Returns the public key the message must
+// carry, or nil.
+// Must be called with s.mu held.
+func (s *SessionKey) rekeySend(n int) ([]byte, error) {
+	rk := s.rekey
+	if rk.due() {
+		priv, pub, err := GenerateEphemeralKeypair()
+		if err != nil {
+			return nil, err
+		}
+		secret, err := ComputeECDH(priv, rk.remotePublic)
+		ZeroKey(&priv)
+		if err != nil {
+			return nil, err
+		}
+		rk.sendEpoch++
+		s.sendKey = deriveEpochKey(s.sendKey, secret, s.sendDirection(), rk.sendEpoch)
+		ZeroKey(&secret)
+		s.sendNonce = 0
+		rk.sendBytes = 0
+		rk.sendStart = time.Now()
+		rk.announce = pub
+		rk.announceLeft = 1
+		if s.windowed {
+			rk.announceLeft = announceDatagrams
+		}
+	}
+	rk.sendBytes += uint64(n)
+
+	if rk.announceLeft == 0 {
+		return nil, nil
+	}
+	rk.announceLeft--
+	return rk.announce[:], nil
+}
+
+// due reports whether the send key should be rotated.
+func (rk *rekeyState) due() bool {
+	if rk.sendEpoch == maxEpoch {
+		return false
+	}
+	return (rk.cfg.Bytes > 0 && rk.sendBytes >= rk.cfg.Bytes) ||
+		(rk.cfg.Interval > 0 && time.Since(rk.sendStart) >= rk.cfg.Interval)
+}
+
+// recvKeyFor derives the receive key of epoch from the announced public key
+// of the sender.
+func (rk *rekeyState) recvKeyFor(current, announced [KeySize]byte, direction byte, epoch uint32) ([KeySize]byte, error) {
+	secret, err := ComputeECDH(rk.localPrivate, announced)
+	if err != nil {
+		return [KeySize]byte{}, fmt.Errorf("%w: rekey: %v", ErrReplay, err)
+	}
+	key := deriveEpochKey(current, secret, direction, epoch)
+	ZeroKey(&secret)
+	return key, nil
+}
+
+// rotateRecv makes key the receive key of epoch.
+// Must be called with s.mu held.
+func (s *SessionKey) rotateRecv(key [KeySize]byte, epoch uint32) {
+	rk := s.rekey
+	if s.windowed {
+		rk.prevKey, rk.prev, rk.hasPrev = s.recvKey, s.recv, true
+	}
+	s.recvKey = key
+	s.recv = replayState{}
+	rk.recvEpoch = epoch
+}
+
+// sendDirection returns the direction bit of sent nonces.
+func (s *SessionKey) sendDirection() byte {
+	if s.isInitiator {
+		return 0
+	}
+	return nonceResponder
+}
+
+func (rk *rekeyState) zero() {
+	ZeroKey(&rk.localPrivate)
+	ZeroKey(&rk.prevKey)
+}
+
+// deriveEpochKey derives the key of epoch in one direction from the
+// previous key of that direction and a fresh ECDH secret. Knowing either
+// input alone does not give the new key.
+func deriveEpochKey(previous, secret [KeySize]byte, direction byte, epoch uint32) [KeySize]byte {
+	info := append([]byte(rekeyInfo), direction, byte(epoch>>16), byte(epoch>>8), byte(epoch))
+	reader := hkdf.New(sha256.New, secret[:], previous[:], info)
+
+	var key [KeySize]byte
+	if _, err := io.ReadFull(reader, key[:]); err != nil {
+		// This should never happen with valid inputs
+		panic(fmt.Sprintf("HKDF failed: %v", err))
+	}
+	return key
+}
+
+type rekeyContextKey struct{}
+
+// WithRekey returns ctx recording that the initiator of a session asked to
+// rekey it, for handlers that answer the key exchange.
+func WithRekey(ctx context.Context) context.Context {
+	return context.WithValue(ctx, rekeyContextKey{}, true)
+}
+
+// RekeyFromContext reports whether WithRekey marked ctx.
+func RekeyFromContext(ctx context.Context) bool {
+	ok, _ := ctx.Value(rekeyContextKey{}).(bool)
+	return ok
+}
@@ -0,0 +1,212 @@
+package crypto
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// rekeyPair returns the initiator and responder keys of a session with
+// rekeying enabled on both sides.
+func rekeyPair(t *testing.T, initCfg, respCfg RekeyConfig, windowed bool) (*SessionKey, *SessionKey) {
+	t.Helper()
+	privA, pubA, err := GenerateEphemeralKeypair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	privB, pubB, err := GenerateEphemeralKeypair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	secretA, _ := ComputeECDH(privA, pubB)
+	secretB, _ := ComputeECDH(privB, pubA)
+
+	skA := DeriveSessionKey(secretA, 7, pubA, pubB, true)
+	skB := DeriveSessionKey(secretB, 7, pubA, pubB, false)
+	if windowed {
+		skA.EnableReplayWindow()
+		skB.EnableReplayWindow()
+	}
+	skA.EnableRekey(privA, pubB, initCfg)
+	skB.EnableRekey(privB, pubA, respCfg)
+	return skA, skB
+}
+
+func TestRekey_Stream(t *testing.T) {
+	skA, skB := rekeyPair(t, RekeyConfig{Bytes: 100}, RekeyConfig{}, false)
+	initial := skA.Key()
+
+	for i := 0; i < 20; i++ {
+		msg := []byte(fmt.Sprintf("message %02d with some padding", i))
+		enc, err := skA.Encrypt(msg)
+		if err != nil {
+			t.Fatalf("Encrypt(%d) error = %v", i, err)
+		}
+		dec, err := skB.Decrypt(enc)
+		if err != nil {
+			t.Fatalf("Decrypt(%d) error = %v", i, err)
+		}
+		if !bytes.Equal(dec, msg) {
+			t.Fatalf("message %d mismatch", i)
+		}
+
+		// The other direction keeps working with its own key
+		enc, _ = skB.Encrypt(msg)
+		if _, err := skA.Decrypt(enc); err != nil {
+			t.Fatalf("reverse Decrypt(%d) error = %v", i, err)
+		}
+	}
+
+	sendA, _ := skA.Epochs()
+	_, recvB := skB.Epochs()
+	if sendA < 4 || recvB != sendA {
+		t.Errorf("epochs: initiator sent %d, responder received %d, want equal and >= 4", sendA, recvB)
+	}
+	if skA.Key() == initial {
+		t.Error("send key unchanged after rekey")
+	}
+	if send, _ := skB.Epochs(); send != 0 {
+		t.Errorf("responder send epoch = %d, want 0 without a rekey trigger", send)
+	}
+}
+
+func TestRekey_StreamLostAnnounce(t *testing.T) {
+	skA, skB := rekeyPair(t, RekeyConfig{Bytes: 10}, RekeyConfig{}, false)
+
+	first, _ := skA.Encrypt([]byte("first message"))
+	if _, err := skB.Decrypt(first); err != nil {
+		t.Fatalf("Decrypt(first) error = %v", err)
+	}
+
+	// The message that announced the new key is dropped
+	_, _ = skA.Encrypt([]byte("announces"))
+	next, _ := skA.Encrypt([]byte("next"))
+	if _, err := skB.Decrypt(next); !errors.Is(err, ErrReplay) {
+		t.Errorf("Decrypt after lost announce error = %v, want ErrReplay", err)
+	}
+}
+
+func TestRekey_Datagram(t *testing.T) {
+	skA, skB := rekeyPair(t, RekeyConfig{Bytes: 64}, RekeyConfig{}, true)
+
+	// 8 bytes per message rotates the key every 8 messages
+	var epoch0, epoch1 [][]byte
+	for i := 0; i < 16; i++ {
+		enc, _ := skA.Encrypt([]byte(fmt.Sprintf("dgram-%02d", i)))
+		if i < 8 {
+			epoch0 = append(epoch0, enc)
+		} else {
+			epoch1 = append(epoch1, enc)
+		}
+	}
+
+	// The first announcing message of the new epoch is lost; a later one
+	// carries the key too
+	for _, enc := range epoch0[:4] {
+		if _, err := skB.Decrypt(enc); err != nil {
+			t.Fatalf("Decrypt(epoch 0) error = %v", err)
+		}
+	}
+	for _, enc := range epoch1[1:] {
+		if _, err := skB.Decrypt(enc); err != nil {
+			t.Fatalf("Decrypt(epoch 1) error = %v", err)
+		}
+	}
+
+	// Datagrams of the previous epoch that arrive late are accepted once
+	for _, enc := range epoch0[4:] {
+		if _, err := skB.Decrypt(enc); err != nil {
+			t.Fatalf("late Decrypt(epoch 0) error = %v", err)
+		}
+	}
+	for _, enc := range [][]byte{epoch0[0], epoch0[5], epoch1[3]} {
+		if _, err := skB.Decrypt(enc); !errors.Is(err, ErrReplay) {
+			t.Errorf("replayed Decrypt error = %v, want ErrReplay", err)
+		}
+	}
+	if _, err := skB.Decrypt(epoch1[0]); err != nil {
+		t.Fatalf("Decrypt(reordered announce) error = %v", err)
+	}
+
+	if _, recv := skB.Epochs(); recv != 1 {
+		t.Errorf("receive epoch = %d, want 1", recv)
+	}
+}
+
+func TestRekey_Interval(t *testing.T) {
+	skA, skB := rekeyPair(t, RekeyConfig{}, RekeyConfig{Interval: time.Millisecond}, false)
+
+	first, _ := skB.Encrypt([]byte("before"))
+	time.Sleep(5 * time.Millisecond)
+	second, _ := skB.Encrypt([]byte("after"))
+
+	for _, enc := range [][]byte{first, second} {
+		if _, err := skA.Decrypt(enc); err != nil {
+			t.Fatalf("Decrypt error = %v", err)
+		}
+	}
+	if _, recv := skA.Epochs(); recv != 1 {
+		t.Errorf("receive epoch = %d, want 1", recv)
+	}
+}
+
+func TestRekey_TamperedAnnounce(t *testing.T) {
+	skA, skB := rekeyPair(t, RekeyConfig{Bytes: 1}, RekeyConfig{}, false)
+
+	first, _ := skA.Encrypt([]byte("x"))
+	if _, err := skB.Decrypt(first); err != nil {
+		t.Fatalf("Decrypt(first) error = %v", err)
+	}
+
+	enc, _ := skA.Encrypt([]byte("rekeyed"))
+	_, otherPub, _ := GenerateEphemeralKeypair()
+	forged := bytes.Clone(enc)
+	copy(forged[NonceSize:], otherPub[:])
+	if _, err := skB.Decrypt(forged); err == nil {
+		t.Fatal("message with a replaced public key decrypted")
+	}
+
+	// The rejected message did not move the receiver to the new epoch
+	if _, err := skB.Decrypt(enc); err != nil {
+		t.Fatalf("Decrypt(real) after forged message error = %v", err)
+	}
+}
+
+func TestRekey_NotNegotiated(t *testing.T) {
+	privA, pubA, _ := GenerateEphemeralKeypair()
+	privB, pubB, _ := GenerateEphemeralKeypair()
+	secretA, _ := ComputeECDH(privA, pubB)
+	secretB, _ := ComputeECDH(privB, pubA)
+	skA := DeriveSessionKey(secretA, 1, pubA, pubB, true)
+	skB := DeriveSessionKey(secretB, 1, pubA, pubB, false)
+
+	// Without a trigger the wire format is the same as without rekeying
+	skA.EnableRekey(privA, pubB, RekeyConfig{})
+	enc, _ := skA.Encrypt([]byte("plain"))
+	if len(enc) != len("plain")+EncryptionOverhead {
+		t.Errorf("message length = %d, want %d", len(enc), len("plain")+EncryptionOverhead)
+	}
+	if _, err := skB.Decrypt(enc); err != nil {
+		t.Fatalf("Decrypt error = %v", err)
+	}
+
+	// A receiver that did not enable rekeying rejects a new epoch
+	skA.EnableRekey(privA, pubB, RekeyConfig{Bytes: 1})
+	skA.Encrypt([]byte("x"))
+	enc, _ = skA.Encrypt([]byte("rekeyed"))
+	if _, err := skB.Decrypt(enc); err == nil {
+		t.Error("rekeyed message accepted by a session without rekeying")
+	}
+}
+
+func TestRekeyContext(t *testing.T) {
+	if RekeyFromContext(context.Background()) {
+		t.Error("RekeyFromContext(background) = true")
+	}
+	if !RekeyFromContext(WithRekey(context.Background())) {
+		t.Error("RekeyFromContext(WithRekey) = false")
+	}
+}
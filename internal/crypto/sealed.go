@@ -6,9 +6,11 @@ package crypto
 import (
 	"crypto/rand"
 	"crypto/sha256"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
+	"sync"
 
 	"golang.org/x/crypto/chacha20poly1305"
 	"golang.org/x/crypto/hkdf"
@@ -21,6 +23,12 @@ const (
 
 	// sealedBoxInfo is the context string for HKDF key derivation in sealed boxes.
 	sealedBoxInfo = "muti-metroo-sealed-v1"
+
+	// multiSealMagic starts a message sealed to several management keys.
+	multiSealMagic = "MMSB"
+
+	// maxSealRecipients bounds the boxes accepted in one packed message.
+	maxSealRecipients = 8
 )
 
 var (
@@ -38,10 +46,28 @@ var (
 // SealedBox provides sealed box encryption using X25519 + ChaCha20-Poly1305.
 // It supports encrypt-only mode (public key only) for field agents, and
 // encrypt/decrypt mode (both keys) for operator nodes.
+//
+// During a management key rotation a next public key is staged: Seal then
+// encrypts to both keys, and operators holding either private key can open
+// the result. Additional private keys let an operator open messages sealed
+// to the old key, the new key or both.
 type SealedBox struct {
-	publicKey  [KeySize]byte
-	privateKey [KeySize]byte
+	mu sync.RWMutex
+
+	publicKey [KeySize]byte // Key messages are sealed to
+	nextKey   [KeySize]byte // Staged key messages are also sealed to
+	hasNext   bool
+
+	privateKey [KeySize]byte // Opens messages sealed to openKey
+	openKey    [KeySize]byte
 	hasPrivate bool
+	extraKeys  []sealedKeypair // Additional keys for opening
+}
+
+// sealedKeypair is a management keypair used to open sealed boxes.
+type sealedKeypair struct {
+	public  [KeySize]byte
+	private [KeySize]byte
 }
 
 // NewSealedBox creates a sealed box with public key only (encrypt-only mode).
@@ -59,20 +85,70 @@ func NewSealedBoxWithPrivate(publicKey, privateKey [KeySize]byte) *SealedBox {
 	return &SealedBox{
 		publicKey:  publicKey,
 		privateKey: privateKey,
+		openKey:    publicKey,
 		hasPrivate: true,
 	}
 }
 
 // CanDecrypt returns true if this sealed box has a private key and can decrypt.
 func (s *SealedBox) CanDecrypt() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	return s.hasPrivate
 }
 
 // PublicKey returns the management public key.
 func (s *SealedBox) PublicKey() [KeySize]byte {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	return s.publicKey
 }
 
+// SetPublicKey replaces the management public key that messages are sealed
+// to. Private keys are not changed.
+func (s *SealedBox) SetPublicKey(publicKey [KeySize]byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.publicKey = publicKey
+}
+
+// NextPublicKey returns the staged next management public key, if any.
+func (s *SealedBox) NextPublicKey() ([KeySize]byte, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.nextKey, s.hasNext
+}
+
+// SetNextPublicKey stages a next management public key. Until it is cleared,
+// Seal encrypts to both the public key and the next key.
+func (s *SealedBox) SetNextPublicKey(publicKey [KeySize]byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextKey = publicKey
+	s.hasNext = true
+}
+
+// ClearNextPublicKey removes the staged next public key.
+func (s *SealedBox) ClearNextPublicKey() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextKey = [KeySize]byte{}
+	s.hasNext = false
+}
+
+// AddPrivateKey adds a keypair for opening messages, such as the next key of
+// a rotation on an operator node. A sealed box without a private key becomes
+// able to decrypt.
+func (s *SealedBox) AddPrivateKey(publicKey, privateKey [KeySize]byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.hasPrivate {
+		s.privateKey, s.openKey, s.hasPrivate = privateKey, publicKey, true
+		return
+	}
+	s.extraKeys = append(s.extraKeys, sealedKeypair{public: publicKey, private: privateKey})
+}
+
 // Seal encrypts plaintext so that only the holder of the management private key
 // can decrypt it. The output format is:
 //
@@ -80,7 +156,35 @@ func (s *SealedBox) PublicKey() [KeySize]byte {
 //
 // The function generates a fresh ephemeral keypair for each call, ensuring
 // that each sealed message has unique encryption keys.
+//
+// While a next public key is staged, the plaintext is sealed to each key
+// separately and the boxes are packed as:
+//
+//	"MMSB" || count (1 byte) || { length (2 bytes) || box } * count
+//
+// Agents that predate key rotation cannot open the packed form.
 func (s *SealedBox) Seal(plaintext []byte) ([]byte, error) {
+	s.mu.RLock()
+	publicKey, nextKey, hasNext := s.publicKey, s.nextKey, s.hasNext
+	s.mu.RUnlock()
+
+	if !hasNext {
+		return sealTo(publicKey, plaintext)
+	}
+
+	current, err := sealTo(publicKey, plaintext)
+	if err != nil {
+		return nil, err
+	}
+	next, err := sealTo(nextKey, plaintext)
+	if err != nil {
+		return nil, err
+	}
+	return packBoxes(current, next)
+}
+
+// sealTo seals plaintext to one public key.
+func sealTo(publicKey [KeySize]byte, plaintext []byte) ([]byte, error) {
 	// Generate ephemeral keypair for this message
 	ephemeralPrivate, ephemeralPublic, err := GenerateEphemeralKeypair()
 	if err != nil {
@@ -89,7 +193,7 @@ func (s *SealedBox) Seal(plaintext []byte) ([]byte, error) {
 	defer ZeroKey(&ephemeralPrivate)
 
 	// Compute shared secret via ECDH
-	sharedSecret, err := ComputeECDH(ephemeralPrivate, s.publicKey)
+	sharedSecret, err := ComputeECDH(ephemeralPrivate, publicKey)
 	if err != nil {
 		return nil, fmt.Errorf("compute ECDH: %w", err)
 	}
@@ -99,7 +203,7 @@ func (s *SealedBox) Seal(plaintext []byte) ([]byte, error) {
 	// Salt includes both public keys to bind the key to this specific exchange
 	salt := make([]byte, KeySize+KeySize)
 	copy(salt[0:KeySize], ephemeralPublic[:])
-	copy(salt[KeySize:], s.publicKey[:])
+	copy(salt[KeySize:], publicKey[:])
 
 	symmetricKey := make([]byte, KeySize)
 	reader := hkdf.New(sha256.New, sharedSecret[:], salt, []byte(sealedBoxInfo))
@@ -130,13 +234,120 @@ func (s *SealedBox) Seal(plaintext []byte) ([]byte, error) {
 	return output, nil
 }
 
+// packBoxes packs sealed boxes for several recipients into one message.
+func packBoxes(boxes ...[]byte) ([]byte, error) {
+	size := len(multiSealMagic) + 1
+	for _, box := range boxes {
+		if len(box) > 0xFFFF {
+			return nil, fmt.Errorf("sealed box too large: %d bytes", len(box))
+		}
+		size += 2 + len(box)
+	}
+	output := make([]byte, 0, size)
+	output = append(output, multiSealMagic...)
+	output = append(output, byte(len(boxes)))
+	for _, box := range boxes {
+		output = binary.BigEndian.AppendUint16(output, uint16(len(box)))
+		output = append(output, box...)
+	}
+	return output, nil
+}
+
+// unpackBoxes splits a message packed by packBoxes. Returns nil if data is
+// not a well-formed packed message.
+func unpackBoxes(data []byte) [][]byte {
+	if len(data) < len(multiSealMagic)+1 || string(data[:len(multiSealMagic)]) != multiSealMagic {
+		return nil
+	}
+	count := int(data[len(multiSealMagic)])
+	if count == 0 || count > maxSealRecipients {
+		return nil
+	}
+	rest := data[len(multiSealMagic)+1:]
+	boxes := make([][]byte, 0, count)
+	for range count {
+		if len(rest) < 2 {
+			return nil
+		}
+		n := int(binary.BigEndian.Uint16(rest))
+		if len(rest) < 2+n {
+			return nil
+		}
+		boxes = append(boxes, rest[2:2+n])
+		rest = rest[2+n:]
+	}
+	if len(rest) != 0 {
+		return nil
+	}
+	return boxes
+}
+
 // Open decrypts a sealed box ciphertext. Returns ErrNoPrivateKey if this
 // sealed box was created without a private key.
 func (s *SealedBox) Open(ciphertext []byte) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
 	if !s.hasPrivate {
 		return nil, ErrNoPrivateKey
 	}
 
+	// A packed message is tried box by box. A single box that happens to
+	// start with the magic is still opened whole below.
+	for _, box := range unpackBoxes(ciphertext) {
+		for _, kp := range s.keypairs() {
+			if plaintext, err := openWith(kp, box); err == nil {
+				return plaintext, nil
+			}
+		}
+	}
+
+	if len(ciphertext) < SealedBoxOverhead {
+		return nil, ErrInvalidCiphertext
+	}
+	for _, kp := range s.keypairs() {
+		if plaintext, err := openWith(kp, ciphertext); err == nil {
+			return plaintext, nil
+		}
+	}
+	return nil, ErrDecryptionFailed
+}
+
+// SealedTo returns the public keys, among those this sealed box holds
+// private keys for, that ciphertext was sealed to.
+func (s *SealedBox) SealedTo(ciphertext []byte) [][KeySize]byte {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if !s.hasPrivate {
+		return nil
+	}
+	boxes := unpackBoxes(ciphertext)
+	if boxes == nil {
+		boxes = [][]byte{ciphertext}
+	}
+
+	var keys [][KeySize]byte
+	for _, kp := range s.keypairs() {
+		for _, box := range boxes {
+			if _, err := openWith(kp, box); err == nil {
+				keys = append(keys, kp.public)
+				break
+			}
+		}
+	}
+	return keys
+}
+
+// keypairs returns the keys for opening messages. Callers hold s.mu.
+func (s *SealedBox) keypairs() []sealedKeypair {
+	kps := make([]sealedKeypair, 0, 1+len(s.extraKeys))
+	kps = append(kps, sealedKeypair{public: s.openKey, private: s.privateKey})
+	return append(kps, s.extraKeys...)
+}
+
+// openWith opens a single sealed box with one keypair.
+func openWith(kp sealedKeypair, ciphertext []byte) ([]byte, error) {
 	if len(ciphertext) < SealedBoxOverhead {
 		return nil, ErrInvalidCiphertext
 	}
@@ -150,7 +361,7 @@ func (s *SealedBox) Open(ciphertext []byte) ([]byte, error) {
 	copy(nonce[:], ciphertext[KeySize:KeySize+NonceSize])
 
 	// Compute shared secret via ECDH
-	sharedSecret, err := ComputeECDH(s.privateKey, ephemeralPublic)
+	sharedSecret, err := ComputeECDH(kp.private, ephemeralPublic)
 	if err != nil {
 		return nil, fmt.Errorf("compute ECDH: %w", err)
 	}
@@ -159,7 +370,7 @@ func (s *SealedBox) Open(ciphertext []byte) ([]byte, error) {
 	// Derive symmetric key using HKDF (same derivation as Seal)
 	salt := make([]byte, KeySize+KeySize)
 	copy(salt[0:KeySize], ephemeralPublic[:])
-	copy(salt[KeySize:], s.publicKey[:])
+	copy(salt[KeySize:], kp.public[:])
 
 	symmetricKey := make([]byte, KeySize)
 	reader := hkdf.New(sha256.New, sharedSecret[:], salt, []byte(sealedBoxInfo))
@@ -183,9 +394,15 @@ func (s *SealedBox) Open(ciphertext []byte) ([]byte, error) {
 	return plaintext, nil
 }
 
-// Zero clears the private key from memory. Call this when the sealed box
+// Zero clears the private keys from memory. Call this when the sealed box
 // is no longer needed.
 func (s *SealedBox) Zero() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	ZeroKey(&s.privateKey)
+	for i := range s.extraKeys {
+		ZeroKey(&s.extraKeys[i].private)
+	}
+	s.extraKeys = nil
 	s.hasPrivate = false
 }
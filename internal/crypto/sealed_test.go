@@ -260,3 +260,105 @@ func BenchmarkSealedBox_Open(b *testing.B) {
 		_, _ = box.Open(ciphertext)
 	}
 }
+
+func TestSealedBox_NextPublicKey(t *testing.T) {
+	oldPrivate, oldPublic, _ := GenerateEphemeralKeypair()
+	newPrivate, newPublic, _ := GenerateEphemeralKeypair()
+
+	sender := NewSealedBox(oldPublic)
+	sender.SetNextPublicKey(newPublic)
+	if next, ok := sender.NextPublicKey(); !ok || next != newPublic {
+		t.Fatal("NextPublicKey() does not return the staged key")
+	}
+
+	plaintext := []byte("node info")
+	ciphertext, err := sender.Seal(plaintext)
+	if err != nil {
+		t.Fatalf("Seal() error = %v", err)
+	}
+
+	// Holders of either private key can open the message
+	for name, box := range map[string]*SealedBox{
+		"old": NewSealedBoxWithPrivate(oldPublic, oldPrivate),
+		"new": NewSealedBoxWithPrivate(newPublic, newPrivate),
+	} {
+		decrypted, err := box.Open(ciphertext)
+		if err != nil {
+			t.Fatalf("Open() with %s key error = %v", name, err)
+		}
+		if !bytes.Equal(decrypted, plaintext) {
+			t.Errorf("Open() with %s key = %q, want %q", name, decrypted, plaintext)
+		}
+	}
+
+	// After clearing the next key only the old key is used
+	sender.ClearNextPublicKey()
+	ciphertext, _ = sender.Seal(plaintext)
+	if _, err := NewSealedBoxWithPrivate(newPublic, newPrivate).Open(ciphertext); err != ErrDecryptionFailed {
+		t.Errorf("Open() with new key after clear: error = %v, want ErrDecryptionFailed", err)
+	}
+}
+
+func TestSealedBox_SealedTo(t *testing.T) {
+	oldPrivate, oldPublic, _ := GenerateEphemeralKeypair()
+	newPrivate, newPublic, _ := GenerateEphemeralKeypair()
+
+	operator := NewSealedBoxWithPrivate(oldPublic, oldPrivate)
+	operator.AddPrivateKey(newPublic, newPrivate)
+
+	sender := NewSealedBox(oldPublic)
+	oldOnly, _ := sender.Seal([]byte("a"))
+	sender.SetNextPublicKey(newPublic)
+	both, _ := sender.Seal([]byte("b"))
+	sender.SetPublicKey(newPublic)
+	sender.ClearNextPublicKey()
+	newOnly, _ := sender.Seal([]byte("c"))
+
+	tests := []struct {
+		name       string
+		ciphertext []byte
+		want       [][KeySize]byte
+	}{
+		{"old only", oldOnly, [][KeySize]byte{oldPublic}},
+		{"both", both, [][KeySize]byte{oldPublic, newPublic}},
+		{"new only", newOnly, [][KeySize]byte{newPublic}},
+		{"garbage", make([]byte, SealedBoxOverhead), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := operator.SealedTo(tt.ciphertext)
+			if len(got) != len(tt.want) {
+				t.Fatalf("SealedTo() returned %d keys, want %d", len(got), len(tt.want))
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("SealedTo()[%d] does not match", i)
+				}
+			}
+			if tt.want != nil {
+				if _, err := operator.Open(tt.ciphertext); err != nil {
+					t.Errorf("Open() error = %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestUnpackBoxes_Malformed(t *testing.T) {
+	packed, _ := packBoxes(make([]byte, 10), make([]byte, 20))
+	if got := unpackBoxes(packed); len(got) != 2 {
+		t.Fatalf("unpackBoxes() returned %d boxes, want 2", len(got))
+	}
+
+	tests := map[string][]byte{
+		"no magic":       append([]byte("XXXX"), packed[4:]...),
+		"zero count":     []byte("MMSB\x00"),
+		"truncated":      packed[:len(packed)-1],
+		"trailing bytes": append(append([]byte(nil), packed...), 0),
+	}
+	for name, data := range tests {
+		if got := unpackBoxes(data); got != nil {
+			t.Errorf("unpackBoxes(%s) = %d boxes, want nil", name, len(got))
+		}
+	}
+}
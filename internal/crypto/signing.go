@@ -7,6 +7,7 @@ package crypto
 import (
 	"crypto/ed25519"
 	"crypto/rand"
+	"crypto/sha256"
 	"fmt"
 	"io"
 )
@@ -62,6 +63,18 @@ func SigningKeypairFromSeed(seed [Ed25519SeedSize]byte) *SigningKeypair {
 	return kp
 }
 
+// routeSigningContext separates the route signing seed from other uses of
+// the agent identity key.
+const routeSigningContext = "muti-metroo route signing v1"
+
+// RouteSigningKeypair derives the Ed25519 keypair an agent signs its route
+// advertisements with from its X25519 identity private key. The derivation
+// is deterministic, so the key stays the same across restarts.
+func RouteSigningKeypair(identityKey [32]byte) *SigningKeypair {
+	seed := sha256.Sum256(append([]byte(routeSigningContext), identityKey[:]...))
+	return SigningKeypairFromSeed(seed)
+}
+
 // PublicKeyFromPrivate derives the Ed25519 public key from a private key.
 func PublicKeyFromPrivate(privateKey [Ed25519PrivateKeySize]byte) [Ed25519PublicKeySize]byte {
 	priv := ed25519.PrivateKey(privateKey[:])
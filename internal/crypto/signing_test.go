@@ -194,3 +194,26 @@ func TestDeterministicSignature(t *testing.T) {
 		t.Error("Sign() is not deterministic - same key/message produced different signatures")
 	}
 }
+
+func TestRouteSigningKeypair(t *testing.T) {
+	var identityKey [32]byte
+	identityKey[0] = 1
+
+	kp1 := RouteSigningKeypair(identityKey)
+	kp2 := RouteSigningKeypair(identityKey)
+	if kp1.PublicKey != kp2.PublicKey {
+		t.Error("RouteSigningKeypair should be deterministic")
+	}
+
+	// The identity key must not be used as the seed directly
+	var seed [Ed25519SeedSize]byte
+	copy(seed[:], identityKey[:])
+	if SigningKeypairFromSeed(seed).PublicKey == kp1.PublicKey {
+		t.Error("RouteSigningKeypair should not use the identity key as seed")
+	}
+
+	identityKey[0] = 2
+	if RouteSigningKeypair(identityKey).PublicKey == kp1.PublicKey {
+		t.Error("different identity keys should give different signing keys")
+	}
+}
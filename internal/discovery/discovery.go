@@ -0,0 +1,108 @@
+// Package discovery finds peer agents through DNS SRV/TXT records and
+// multicast DNS on the local network.
+//
+// Both sources use the same service names and TXT keys:
+//
+//	_muti-metroo._udp  QUIC (default) or WebTransport listeners
+//	_muti-metroo._tcp  HTTP/2 (default), WebSocket or TLS/TCP listeners
+//
+// TXT keys: id=<agent id>, transport=<quic|h2|ws|wt|tcp>, path=<http path>.
+// All keys are optional.
+package discovery
+
+import (
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Service is the DNS-SD service name agents are published under.
+const Service = "muti-metroo"
+
+// Peer is a peer agent found by a discovery source.
+type Peer struct {
+	ID        string // Agent ID from the TXT record, empty if not published
+	Transport string // quic, h2, ws, wt or tcp
+	Host      string // Host name or IP address
+	Port      uint16
+	Path      string // HTTP path for h2, ws and wt
+}
+
+// Address returns the address to dial the peer at, in the form the
+// transport expects: host:port for QUIC and TLS/TCP, a URL for the HTTP
+// based transports.
+func (p Peer) Address() string {
+	hostPort := net.JoinHostPort(p.Host, strconv.Itoa(int(p.Port)))
+	switch p.Transport {
+	case "h2", "wt":
+		return "https://" + hostPort + p.Path
+	case "ws":
+		return "wss://" + hostPort + p.Path
+	default:
+		return hostPort
+	}
+}
+
+// protoFor returns the SRV protocol label a transport is published under.
+func protoFor(transport string) string {
+	if transport == "quic" || transport == "wt" {
+		return "udp"
+	}
+	return "tcp"
+}
+
+// defaultTransport returns the transport assumed for an SRV protocol label
+// when the TXT record does not name one.
+func defaultTransport(proto string) string {
+	if proto == "udp" {
+		return "quic"
+	}
+	return "h2"
+}
+
+// serviceName returns the DNS-SD service name for proto under domain,
+// e.g. "_muti-metroo._udp.example.com.".
+func serviceName(proto, domain string) string {
+	return "_" + Service + "._" + proto + "." + strings.TrimSuffix(domain, ".") + "."
+}
+
+// applyTXT fills in the fields of p from TXT strings. A transport that
+// does not belong to proto is ignored, so a record cannot make a UDP
+// service dialed over TCP or the other way around.
+func applyTXT(p *Peer, proto string, txt []string) {
+	for _, kv := range txt {
+		key, value, _ := strings.Cut(kv, "=")
+		switch strings.ToLower(key) {
+		case "id":
+			p.ID = value
+		case "transport":
+			if isTransport(value) && protoFor(value) == proto {
+				p.Transport = value
+			}
+		case "path":
+			if strings.HasPrefix(value, "/") {
+				p.Path = value
+			}
+		}
+	}
+	if p.Path == "" && p.Transport != "quic" && p.Transport != "tcp" {
+		p.Path = "/mesh"
+	}
+}
+
+// txtFor returns the TXT strings that describe a listener.
+func txtFor(id, transport, path string) []string {
+	txt := []string{"id=" + id, "transport=" + transport}
+	if path != "" {
+		txt = append(txt, "path="+path)
+	}
+	return txt
+}
+
+func isTransport(s string) bool {
+	switch s {
+	case "quic", "h2", "ws", "wt", "tcp":
+		return true
+	}
+	return false
+}
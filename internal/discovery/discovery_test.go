@@ -0,0 +1,317 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"net"
+	"slices"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func TestPeer_Address(t *testing.T) {
+	tests := []struct {
+		peer Peer
+		want string
+	}{
+		{Peer{Transport: "quic", Host: "10.0.0.1", Port: 4433}, "10.0.0.1:4433"},
+		{Peer{Transport: "tcp", Host: "agent.example.com", Port: 443}, "agent.example.com:443"},
+		{Peer{Transport: "h2", Host: "agent.example.com", Port: 443, Path: "/mesh"}, "https://agent.example.com:443/mesh"},
+		{Peer{Transport: "wt", Host: "10.0.0.1", Port: 443, Path: "/wt"}, "https://10.0.0.1:443/wt"},
+		{Peer{Transport: "ws", Host: "fe80::1", Port: 8443, Path: "/ws"}, "wss://[fe80::1]:8443/ws"},
+	}
+	for _, tt := range tests {
+		if got := tt.peer.Address(); got != tt.want {
+			t.Errorf("Address() = %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestApplyTXT(t *testing.T) {
+	tests := []struct {
+		name  string
+		proto string
+		txt   []string
+		want  Peer
+	}{
+		{"defaults udp", "udp", nil, Peer{Transport: "quic"}},
+		{"defaults tcp", "tcp", nil, Peer{Transport: "h2", Path: "/mesh"}},
+		{"all keys", "tcp", []string{"id=abc", "transport=ws", "path=/ws"}, Peer{ID: "abc", Transport: "ws", Path: "/ws"}},
+		{"upper case key", "udp", []string{"ID=abc"}, Peer{ID: "abc", Transport: "quic"}},
+		{"tcp transport on udp", "udp", []string{"transport=h2"}, Peer{Transport: "quic"}},
+		{"unknown transport", "tcp", []string{"transport=ftp"}, Peer{Transport: "h2", Path: "/mesh"}},
+		{"relative path", "udp", []string{"transport=wt", "path=wt"}, Peer{Transport: "wt", Path: "/mesh"}},
+		{"tls tcp", "tcp", []string{"transport=tcp"}, Peer{Transport: "tcp"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := Peer{Transport: defaultTransport(tt.proto)}
+			applyTXT(&p, tt.proto, tt.txt)
+			if p != tt.want {
+				t.Errorf("applyTXT() = %+v, want %+v", p, tt.want)
+			}
+		})
+	}
+}
+
+// fakeResolver serves SRV and TXT records from maps.
+type fakeResolver struct {
+	srv     map[string][]*net.SRV // "proto" -> records
+	txt     map[string][]string   // Target name -> TXT strings
+	srvErrs map[string]error      // "proto" -> error
+}
+
+func (r *fakeResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	if service != Service || name != "mesh.example.com" {
+		return "", nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+	if err := r.srvErrs[proto]; err != nil {
+		return "", nil, err
+	}
+	srvs, ok := r.srv[proto]
+	if !ok {
+		return "", nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+	return "", srvs, nil
+}
+
+func (r *fakeResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	txt, ok := r.txt[name]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+	return txt, nil
+}
+
+func TestLookupDNS(t *testing.T) {
+	r := &fakeResolver{
+		srv: map[string][]*net.SRV{
+			"udp": {
+				{Target: "a.mesh.example.com.", Port: 4433},
+				{Target: ".", Port: 4433}, // Service not available
+			},
+			"tcp": {{Target: "b.mesh.example.com.", Port: 443}},
+		},
+		txt: map[string][]string{
+			"a.mesh.example.com.": {"id=aaaa"},
+			"b.mesh.example.com.": {"id=bbbb", "transport=ws", "path=/ws"},
+		},
+	}
+
+	peers, err := LookupDNS(context.Background(), r, "mesh.example.com.")
+	if err != nil {
+		t.Fatalf("LookupDNS() error = %v", err)
+	}
+	want := []Peer{
+		{ID: "aaaa", Transport: "quic", Host: "a.mesh.example.com", Port: 4433},
+		{ID: "bbbb", Transport: "ws", Host: "b.mesh.example.com", Port: 443, Path: "/ws"},
+	}
+	if !slices.Equal(peers, want) {
+		t.Errorf("LookupDNS() = %+v, want %+v", peers, want)
+	}
+}
+
+func TestLookupDNS_Errors(t *testing.T) {
+	ctx := context.Background()
+
+	// An empty zone is not an error
+	peers, err := LookupDNS(ctx, &fakeResolver{}, "mesh.example.com")
+	if err != nil || len(peers) != 0 {
+		t.Errorf("LookupDNS() on empty zone = %v, %v; want no peers, no error", peers, err)
+	}
+
+	// A failed lookup is reported along with the peers of the other
+	r := &fakeResolver{
+		srv:     map[string][]*net.SRV{"tcp": {{Target: "b.mesh.example.com.", Port: 443}}},
+		srvErrs: map[string]error{"udp": errors.New("server misbehaving")},
+	}
+	peers, err = LookupDNS(ctx, r, "mesh.example.com")
+	if err == nil {
+		t.Error("LookupDNS() with a failing lookup returned no error")
+	}
+	if len(peers) != 1 || peers[0].Host != "b.mesh.example.com" {
+		t.Errorf("LookupDNS() peers = %+v, want the tcp peer", peers)
+	}
+}
+
+// newLoopbackResponder starts a responder on a unicast loopback socket.
+func newLoopbackResponder(t *testing.T, cfg ResponderConfig) *net.UDPAddr {
+	t.Helper()
+
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("ListenUDP() error = %v", err)
+	}
+	addr := conn.LocalAddr().(*net.UDPAddr)
+	r, err := newResponder(cfg, conn, addr, []net.IP{net.IPv4(192, 0, 2, 10)})
+	if err != nil {
+		conn.Close()
+		t.Fatalf("newResponder() error = %v", err)
+	}
+	t.Cleanup(func() { r.Close() })
+	return addr
+}
+
+func TestBrowse_Responder(t *testing.T) {
+	id := "0123456789abcdef0123456789abcdef"
+	dst := newLoopbackResponder(t, ResponderConfig{
+		AgentID: id,
+		Listeners: []Listener{
+			{Transport: "quic", Port: 4433},
+			{Transport: "ws", Port: 8443, Path: "/ws"},
+		},
+	})
+
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("ListenUDP() error = %v", err)
+	}
+	defer conn.Close()
+
+	peers, err := browse(context.Background(), conn, dst, 500*time.Millisecond)
+	if err != nil {
+		t.Fatalf("browse() error = %v", err)
+	}
+	slices.SortFunc(peers, func(a, b Peer) int { return int(a.Port) - int(b.Port) })
+	want := []Peer{
+		{ID: id, Transport: "quic", Host: "192.0.2.10", Port: 4433},
+		{ID: id, Transport: "ws", Host: "192.0.2.10", Port: 8443, Path: "/ws"},
+	}
+	if !slices.Equal(peers, want) {
+		t.Errorf("browse() = %+v, want %+v", peers, want)
+	}
+}
+
+func TestBrowse_Paused(t *testing.T) {
+	dst := newLoopbackResponder(t, ResponderConfig{
+		AgentID:   "0123456789abcdef0123456789abcdef",
+		Listeners: []Listener{{Transport: "quic", Port: 4433}},
+		Paused:    func() bool { return true },
+	})
+
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("ListenUDP() error = %v", err)
+	}
+	defer conn.Close()
+
+	peers, err := browse(context.Background(), conn, dst, 200*time.Millisecond)
+	if err != nil {
+		t.Fatalf("browse() error = %v", err)
+	}
+	if len(peers) != 0 {
+		t.Errorf("browse() of a paused responder = %+v, want none", peers)
+	}
+}
+
+func TestResponder_Answer(t *testing.T) {
+	id := "0123456789abcdef0123456789abcdef"
+	records, err := buildRecords(id, []Listener{{Transport: "quic", Port: 4433}}, []net.IP{net.IPv4(192, 0, 2, 10)})
+	if err != nil {
+		t.Fatalf("buildRecords() error = %v", err)
+	}
+	r := &Responder{records: records}
+
+	question := func(name string, typ dnsmessage.Type, class dnsmessage.Class) dnsmessage.Message {
+		return dnsmessage.Message{
+			Header:    dnsmessage.Header{ID: 42},
+			Questions: []dnsmessage.Question{{Name: dnsmessage.MustNewName(name), Type: typ, Class: class}},
+		}
+	}
+
+	// PTR query on port 5353: multicast reply with SRV, TXT and A as additionals
+	resp, unicast, ok := r.answer(question("_muti-metroo._udp.local.", dnsmessage.TypePTR, dnsmessage.ClassINET), false)
+	if !ok || unicast {
+		t.Fatalf("answer(PTR) ok = %v, unicast = %v; want true, false", ok, unicast)
+	}
+	if len(resp.Answers) != 1 || len(resp.Additionals) != 3 {
+		t.Errorf("answer(PTR) = %d answers, %d additionals; want 1, 3", len(resp.Answers), len(resp.Additionals))
+	}
+	if resp.Header.ID != 0 || len(resp.Questions) != 0 {
+		t.Error("multicast reply kept the query ID or questions")
+	}
+
+	// Legacy query: unicast reply echoing ID and questions
+	resp, unicast, _ = r.answer(question("_muti-metroo._udp.local.", dnsmessage.TypePTR, dnsmessage.ClassINET), true)
+	if !unicast || resp.Header.ID != 42 || len(resp.Questions) != 1 {
+		t.Errorf("legacy reply unicast = %v, ID = %d, questions = %d; want true, 42, 1", unicast, resp.Header.ID, len(resp.Questions))
+	}
+
+	// QU bit asks for unicast
+	_, unicast, _ = r.answer(question("_muti-metroo._udp.local.", dnsmessage.TypePTR, dnsmessage.ClassINET|mdnsUnicastQ), false)
+	if !unicast {
+		t.Error("answer() ignored the QU bit")
+	}
+
+	// Host address query (case-insensitive)
+	resp, _, ok = r.answer(question("0123456789ABCDEF0123456789ABCDEF.local.", dnsmessage.TypeA, dnsmessage.ClassINET), false)
+	if !ok || len(resp.Answers) != 1 {
+		t.Errorf("answer(A) ok = %v, answers = %d; want true, 1", ok, len(resp.Answers))
+	}
+
+	// Other services are not answered
+	if _, _, ok := r.answer(question("_http._tcp.local.", dnsmessage.TypePTR, dnsmessage.ClassINET), false); ok {
+		t.Error("answer() replied to a foreign service")
+	}
+}
+
+func TestResponder_RegisterHost(t *testing.T) {
+	group, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("ListenUDP() error = %v", err)
+	}
+	defer group.Close()
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("ListenUDP() error = %v", err)
+	}
+	r, err := newResponder(ResponderConfig{}, conn, group.LocalAddr().(*net.UDPAddr), []net.IP{net.IPv4(192, 0, 2, 10)})
+	if err != nil {
+		conn.Close()
+		t.Fatalf("newResponder() error = %v", err)
+	}
+	defer r.Close()
+
+	// readAnnouncement returns the TTL and address of the next announcement
+	readAnnouncement := func() (uint32, [4]byte) {
+		t.Helper()
+		buf := make([]byte, mdnsMaxPacket)
+		group.SetReadDeadline(time.Now().Add(2 * time.Second))
+		n, _, err := group.ReadFromUDP(buf)
+		if err != nil {
+			t.Fatalf("no announcement: %v", err)
+		}
+		var msg dnsmessage.Message
+		if err := msg.Unpack(buf[:n]); err != nil || len(msg.Answers) != 1 {
+			t.Fatalf("announcement = %+v, %v; want one answer", msg, err)
+		}
+		return msg.Answers[0].Header.TTL, msg.Answers[0].Body.(*dnsmessage.AResource).A
+	}
+	query := dnsmessage.Message{Questions: []dnsmessage.Question{{
+		Name: dnsmessage.MustNewName("git.mesh.local."), Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET,
+	}}}
+
+	if err := r.RegisterHost("git.example.com", nil); err == nil {
+		t.Error("RegisterHost() accepted a name outside .local")
+	}
+
+	if err := r.RegisterHost("git.mesh.local", nil); err != nil {
+		t.Fatalf("RegisterHost() error = %v", err)
+	}
+	if ttl, a := readAnnouncement(); ttl != mdnsTTL || a != [4]byte{192, 0, 2, 10} {
+		t.Errorf("announcement TTL = %d, A = %v; want %d, 192.0.2.10", ttl, a, mdnsTTL)
+	}
+	if _, _, ok := r.answer(query, false); !ok {
+		t.Error("answer() ignored a registered host")
+	}
+
+	r.UnregisterHost("git.mesh.local")
+	if ttl, _ := readAnnouncement(); ttl != 0 {
+		t.Errorf("goodbye TTL = %d, want 0", ttl)
+	}
+	if _, _, ok := r.answer(query, false); ok {
+		t.Error("answer() replied for an unregistered host")
+	}
+}
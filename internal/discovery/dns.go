@@ -0,0 +1,59 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Resolver is the subset of net.Resolver used for DNS discovery.
+type Resolver interface {
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+}
+
+// LookupDNS returns the peers published in zone as SRV records of
+// _muti-metroo._udp.<zone> and _muti-metroo._tcp.<zone>. TXT records on
+// each SRV target name supply the agent ID, transport and path. A zone
+// without any records yields no peers and no error. If one of the lookups
+// fails, the peers found by the other are returned along with the error.
+func LookupDNS(ctx context.Context, r Resolver, zone string) ([]Peer, error) {
+	if r == nil {
+		r = net.DefaultResolver
+	}
+	zone = strings.TrimSuffix(zone, ".")
+
+	var peers []Peer
+	var errs []error
+	for _, proto := range []string{"udp", "tcp"} {
+		_, srvs, err := r.LookupSRV(ctx, Service, proto, zone)
+		if err != nil {
+			var dnsErr *net.DNSError
+			if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+				continue
+			}
+			errs = append(errs, fmt.Errorf("SRV %s: %w", serviceName(proto, zone), err))
+			continue
+		}
+		for _, srv := range srvs {
+			// "." means the service is explicitly not available
+			target := strings.TrimSuffix(srv.Target, ".")
+			if target == "" || srv.Port == 0 {
+				continue
+			}
+			p := Peer{
+				Transport: defaultTransport(proto),
+				Host:      target,
+				Port:      srv.Port,
+			}
+			// Missing TXT records are fine; the defaults apply
+			txt, _ := r.LookupTXT(ctx, srv.Target)
+			applyTXT(&p, proto, txt)
+			peers = append(peers, p)
+		}
+	}
+
+	return peers, errors.Join(errs...)
+}
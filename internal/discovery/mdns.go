@@ -0,0 +1,559 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+	"golang.org/x/net/ipv4"
+
+	"github.com/postalsys/muti-metroo/internal/recovery"
+)
+
+// mDNS constants (RFC 6762)
+const (
+	mdnsPort       = 5353
+	mdnsDomain     = "local."
+	mdnsTTL        = 120     // Seconds, for all announced records
+	mdnsCacheFlush = 1 << 15 // Class bit marking records unique to this host
+	mdnsUnicastQ   = 1 << 15 // Question class bit asking for a unicast reply
+	mdnsMaxPacket  = 9000
+)
+
+// mdnsGroup is the IPv4 mDNS multicast group.
+var mdnsGroup = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: mdnsPort}
+
+// Browse sends an mDNS query for Muti Metroo services on iface (nil for
+// the system default) and returns the peers that answer within wait.
+// Only IPv4 is used.
+func Browse(ctx context.Context, iface *net.Interface, wait time.Duration) ([]Peer, error) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4zero})
+	if err != nil {
+		return nil, fmt.Errorf("mDNS socket: %w", err)
+	}
+	defer conn.Close()
+
+	if iface != nil {
+		if err := ipv4.NewPacketConn(conn).SetMulticastInterface(iface); err != nil {
+			return nil, fmt.Errorf("mDNS interface %s: %w", iface.Name, err)
+		}
+	}
+	return browse(ctx, conn, mdnsGroup, wait)
+}
+
+// browse queries dst from conn and collects answers until wait elapses.
+// The query comes from an ephemeral port, so responders answer it with
+// unicast to that port (a "legacy" query in RFC 6762 terms).
+func browse(ctx context.Context, conn *net.UDPConn, dst *net.UDPAddr, wait time.Duration) ([]Peer, error) {
+	query, err := browseQuery()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.WriteToUDP(query, dst); err != nil {
+		return nil, fmt.Errorf("mDNS query: %w", err)
+	}
+
+	deadline := time.Now().Add(wait)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetReadDeadline(deadline)
+
+	records := newRecordSet()
+	buf := make([]byte, mdnsMaxPacket)
+	for {
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				break
+			}
+			return nil, fmt.Errorf("mDNS read: %w", err)
+		}
+		var msg dnsmessage.Message
+		if msg.Unpack(buf[:n]) != nil || !msg.Response {
+			continue
+		}
+		records.add(msg.Answers)
+		records.add(msg.Additionals)
+	}
+	return records.peers(), nil
+}
+
+// browseQuery builds a PTR query for both service names.
+func browseQuery() ([]byte, error) {
+	msg := dnsmessage.Message{}
+	for _, proto := range []string{"udp", "tcp"} {
+		name, err := dnsmessage.NewName(serviceName(proto, mdnsDomain))
+		if err != nil {
+			return nil, err
+		}
+		msg.Questions = append(msg.Questions, dnsmessage.Question{
+			Name:  name,
+			Type:  dnsmessage.TypePTR,
+			Class: dnsmessage.ClassINET,
+		})
+	}
+	return msg.Pack()
+}
+
+// srvRecord is the target of an SRV record.
+type srvRecord struct {
+	host string
+	port uint16
+}
+
+// recordSet accumulates records from mDNS responses, keyed by lower-case
+// owner name.
+type recordSet struct {
+	instances map[string]string // Instance name -> SRV protocol label
+	srv       map[string]srvRecord
+	txt       map[string][]string
+	addrs     map[string]net.IP
+}
+
+func newRecordSet() *recordSet {
+	return &recordSet{
+		instances: make(map[string]string),
+		srv:       make(map[string]srvRecord),
+		txt:       make(map[string][]string),
+		addrs:     make(map[string]net.IP),
+	}
+}
+
+func (s *recordSet) add(resources []dnsmessage.Resource) {
+	for _, r := range resources {
+		name := strings.ToLower(r.Header.Name.String())
+		switch body := r.Body.(type) {
+		case *dnsmessage.PTRResource:
+			for _, proto := range []string{"udp", "tcp"} {
+				if name == serviceName(proto, mdnsDomain) {
+					s.instances[strings.ToLower(body.PTR.String())] = proto
+				}
+			}
+		case *dnsmessage.SRVResource:
+			s.srv[name] = srvRecord{host: strings.ToLower(body.Target.String()), port: body.Port}
+		case *dnsmessage.TXTResource:
+			s.txt[name] = body.TXT
+		case *dnsmessage.AResource:
+			s.addrs[name] = net.IP(body.A[:])
+		}
+	}
+}
+
+// peers assembles the complete instances into peers. Instances whose SRV
+// record or host address is missing are skipped.
+func (s *recordSet) peers() []Peer {
+	var peers []Peer
+	for instance, proto := range s.instances {
+		srv, ok := s.srv[instance]
+		if !ok || srv.port == 0 {
+			continue
+		}
+		ip, ok := s.addrs[srv.host]
+		if !ok {
+			continue
+		}
+		p := Peer{
+			Transport: defaultTransport(proto),
+			Host:      ip.String(),
+			Port:      srv.port,
+		}
+		applyTXT(&p, proto, s.txt[instance])
+		peers = append(peers, p)
+	}
+	return peers
+}
+
+// Listener describes a local listener announced over mDNS.
+type Listener struct {
+	Transport string // quic, h2, ws, wt or tcp
+	Port      uint16
+	Path      string // HTTP path for h2, ws and wt
+}
+
+// ResponderConfig configures an mDNS responder.
+type ResponderConfig struct {
+	AgentID   string
+	Listeners []Listener
+	Interface *net.Interface // nil for the system default
+	Logger    *slog.Logger
+
+	// Paused, if set, reports whether the agent is sleeping. No queries
+	// are answered while it returns true.
+	Paused func() bool
+}
+
+// Responder answers mDNS queries for the Muti Metroo services of this
+// agent, announcing its listeners to browsing agents on the LAN. It also
+// answers address queries for host names registered with RegisterHost.
+type Responder struct {
+	cfg     ResponderConfig
+	conn    *net.UDPConn
+	group   *net.UDPAddr
+	ips     []net.IP // Announced host addresses
+	wg      sync.WaitGroup
+	closeMu sync.Mutex
+	closed  bool
+
+	mu      sync.RWMutex
+	records []dnsmessage.Resource
+}
+
+// NewResponder joins the mDNS group and starts answering queries.
+func NewResponder(cfg ResponderConfig) (*Responder, error) {
+	conn, err := net.ListenMulticastUDP("udp4", cfg.Interface, mdnsGroup)
+	if err != nil {
+		return nil, fmt.Errorf("mDNS listen: %w", err)
+	}
+	r, err := newResponder(cfg, conn, mdnsGroup, hostIPs(cfg.Interface))
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// newResponder builds the records for cfg and starts serving on conn.
+// Multicast replies go to group.
+func newResponder(cfg ResponderConfig, conn *net.UDPConn, group *net.UDPAddr, ips []net.IP) (*Responder, error) {
+	if cfg.Logger == nil {
+		cfg.Logger = slog.Default()
+	}
+	records, err := buildRecords(cfg.AgentID, cfg.Listeners, ips)
+	if err != nil {
+		return nil, err
+	}
+	r := &Responder{
+		cfg:     cfg,
+		conn:    conn,
+		group:   group,
+		ips:     ips,
+		records: records,
+	}
+	r.wg.Add(1)
+	go r.serve()
+	return r, nil
+}
+
+// Close stops the responder.
+func (r *Responder) Close() error {
+	r.closeMu.Lock()
+	if r.closed {
+		r.closeMu.Unlock()
+		return nil
+	}
+	r.closed = true
+	r.closeMu.Unlock()
+
+	err := r.conn.Close()
+	r.wg.Wait()
+	return err
+}
+
+func (r *Responder) serve() {
+	defer r.wg.Done()
+	defer recovery.RecoverWithLog(r.cfg.Logger, "mdns.Responder.serve")
+
+	buf := make([]byte, mdnsMaxPacket)
+	for {
+		n, src, err := r.conn.ReadFromUDP(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		if r.cfg.Paused != nil && r.cfg.Paused() {
+			continue
+		}
+		var query dnsmessage.Message
+		if query.Unpack(buf[:n]) != nil || query.Response {
+			continue
+		}
+
+		resp, unicast, ok := r.answer(query, src.Port != mdnsPort)
+		if !ok {
+			continue
+		}
+		out, err := resp.Pack()
+		if err != nil {
+			continue
+		}
+		dst := r.group
+		if unicast {
+			dst = src
+		}
+		if _, err := r.conn.WriteToUDP(out, dst); err != nil {
+			r.cfg.Logger.Debug("mDNS reply failed",
+				"dst", dst.String(),
+				"error", err)
+		}
+	}
+}
+
+// answer builds the reply to query. legacy marks a query from a port other
+// than 5353, which is answered by unicast with the questions echoed and
+// the query ID kept (RFC 6762 section 6.7). unicast reports whether the
+// reply goes back to the sender rather than to the group.
+func (r *Responder) answer(query dnsmessage.Message, legacy bool) (resp dnsmessage.Message, unicast bool, ok bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	unicast = legacy
+	seen := make(map[int]bool)
+	var additional []int
+	for _, q := range query.Questions {
+		if q.Class&mdnsUnicastQ != 0 {
+			unicast = true
+		}
+		for i, rec := range r.records {
+			if seen[i] || !strings.EqualFold(rec.Header.Name.String(), q.Name.String()) {
+				continue
+			}
+			if q.Type != rec.Header.Type && q.Type != dnsmessage.TypeALL {
+				continue
+			}
+			seen[i] = true
+			resp.Answers = append(resp.Answers, rec)
+			if ptr, isPTR := rec.Body.(*dnsmessage.PTRResource); isPTR {
+				additional = append(additional, r.related(ptr.PTR.String())...)
+			}
+		}
+	}
+	if len(resp.Answers) == 0 {
+		return resp, false, false
+	}
+	for _, i := range additional {
+		if !seen[i] {
+			seen[i] = true
+			resp.Additionals = append(resp.Additionals, r.records[i])
+		}
+	}
+
+	resp.Header.Response = true
+	resp.Header.Authoritative = true
+	if legacy {
+		resp.Header.ID = query.Header.ID
+		resp.Questions = query.Questions
+	}
+	return resp, unicast, true
+}
+
+// related returns the indexes of the SRV and TXT records of instance and
+// the address records of its host. Must be called with r.mu held.
+func (r *Responder) related(instance string) []int {
+	var idx []int
+	var host string
+	for i, rec := range r.records {
+		if !strings.EqualFold(rec.Header.Name.String(), instance) {
+			continue
+		}
+		idx = append(idx, i)
+		if srv, ok := rec.Body.(*dnsmessage.SRVResource); ok {
+			host = srv.Target.String()
+		}
+	}
+	for i, rec := range r.records {
+		if rec.Header.Type == dnsmessage.TypeA && strings.EqualFold(rec.Header.Name.String(), host) {
+			idx = append(idx, i)
+		}
+	}
+	return idx
+}
+
+// buildRecords returns the PTR, SRV, TXT and A records announcing the
+// listeners of agentID on ips. Without listeners there is nothing to
+// announce, and a responder only answers for registered host names.
+func buildRecords(agentID string, listeners []Listener, ips []net.IP) ([]dnsmessage.Resource, error) {
+	if len(listeners) == 0 {
+		return nil, nil
+	}
+	host, err := dnsmessage.NewName(agentID + "." + mdnsDomain)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []dnsmessage.Resource
+	for _, l := range listeners {
+		proto := protoFor(l.Transport)
+		service, err := dnsmessage.NewName(serviceName(proto, mdnsDomain))
+		if err != nil {
+			return nil, err
+		}
+		label := agentID + "-" + l.Transport + "-" + strconv.Itoa(int(l.Port))
+		instance, err := dnsmessage.NewName(label + "." + service.String())
+		if err != nil {
+			return nil, err
+		}
+		records = append(records,
+			dnsmessage.Resource{
+				Header: recordHeader(service, dnsmessage.TypePTR, false),
+				Body:   &dnsmessage.PTRResource{PTR: instance},
+			},
+			dnsmessage.Resource{
+				Header: recordHeader(instance, dnsmessage.TypeSRV, true),
+				Body:   &dnsmessage.SRVResource{Port: l.Port, Target: host},
+			},
+			dnsmessage.Resource{
+				Header: recordHeader(instance, dnsmessage.TypeTXT, true),
+				Body:   &dnsmessage.TXTResource{TXT: txtFor(agentID, l.Transport, l.Path)},
+			},
+		)
+	}
+	return append(records, addressRecords(host, ips)...), nil
+}
+
+// recordHeader returns a resource header. Type is set here, not left to
+// Pack, as answer matches on it. unique sets the cache-flush bit.
+func recordHeader(name dnsmessage.Name, typ dnsmessage.Type, unique bool) dnsmessage.ResourceHeader {
+	class := dnsmessage.ClassINET
+	if unique {
+		class |= mdnsCacheFlush
+	}
+	return dnsmessage.ResourceHeader{Name: name, Type: typ, Class: class, TTL: mdnsTTL}
+}
+
+// addressRecords returns the A records of host for the IPv4 addresses in ips.
+func addressRecords(host dnsmessage.Name, ips []net.IP) []dnsmessage.Resource {
+	var records []dnsmessage.Resource
+	for _, ip := range ips {
+		if v4 := ip.To4(); v4 != nil {
+			var a [4]byte
+			copy(a[:], v4)
+			records = append(records, dnsmessage.Resource{
+				Header: recordHeader(host, dnsmessage.TypeA, true),
+				Body:   &dnsmessage.AResource{A: a},
+			})
+		}
+	}
+	return records
+}
+
+// RegisterHost answers address queries for name, which must end in
+// ".local", with ips, or with the announced host addresses when ips is
+// empty. The records are announced to the group right away.
+func (r *Responder) RegisterHost(name string, ips []net.IP) error {
+	host, err := hostName(name)
+	if err != nil {
+		return err
+	}
+	if len(ips) == 0 {
+		ips = r.ips
+	}
+	records := addressRecords(host, ips)
+	if len(records) == 0 {
+		return fmt.Errorf("mDNS host %s: no IPv4 address to announce", name)
+	}
+
+	r.mu.Lock()
+	r.records = append(removeHost(r.records, host), records...)
+	r.mu.Unlock()
+
+	r.announce(records)
+	return nil
+}
+
+// UnregisterHost stops answering for name and announces its removal with
+// zero-TTL records, so caches drop it.
+func (r *Responder) UnregisterHost(name string) {
+	host, err := hostName(name)
+	if err != nil {
+		return
+	}
+
+	r.mu.Lock()
+	var goodbye []dnsmessage.Resource
+	for _, rec := range r.records {
+		if rec.Header.Type == dnsmessage.TypeA && strings.EqualFold(rec.Header.Name.String(), host.String()) {
+			rec.Header.TTL = 0
+			goodbye = append(goodbye, rec)
+		}
+	}
+	r.records = removeHost(r.records, host)
+	r.mu.Unlock()
+
+	if len(goodbye) > 0 {
+		r.announce(goodbye)
+	}
+}
+
+// announce sends unsolicited records to the group (RFC 6762 section 8.3).
+func (r *Responder) announce(records []dnsmessage.Resource) {
+	if r.cfg.Paused != nil && r.cfg.Paused() {
+		return
+	}
+	msg := dnsmessage.Message{
+		Header:  dnsmessage.Header{Response: true, Authoritative: true},
+		Answers: records,
+	}
+	out, err := msg.Pack()
+	if err != nil {
+		return
+	}
+	if _, err := r.conn.WriteToUDP(out, r.group); err != nil {
+		r.cfg.Logger.Debug("mDNS announcement failed",
+			"error", err)
+	}
+}
+
+// hostName validates a registered host name and returns it fully qualified.
+func hostName(name string) (dnsmessage.Name, error) {
+	fqdn := strings.TrimSuffix(name, ".") + "."
+	if !strings.HasSuffix(strings.ToLower(fqdn), "."+mdnsDomain) {
+		return dnsmessage.Name{}, fmt.Errorf("mDNS host %s: name must end in .local", name)
+	}
+	return dnsmessage.NewName(fqdn)
+}
+
+// removeHost returns records without the address records of host.
+func removeHost(records []dnsmessage.Resource, host dnsmessage.Name) []dnsmessage.Resource {
+	out := records[:0:0]
+	for _, rec := range records {
+		if rec.Header.Type == dnsmessage.TypeA && strings.EqualFold(rec.Header.Name.String(), host.String()) {
+			continue
+		}
+		out = append(out, rec)
+	}
+	return out
+}
+
+// hostIPs returns the IPv4 addresses to announce: those of iface, or of
+// every up, non-loopback multicast interface when iface is nil.
+func hostIPs(iface *net.Interface) []net.IP {
+	var ifaces []net.Interface
+	if iface != nil {
+		ifaces = []net.Interface{*iface}
+	} else {
+		all, err := net.Interfaces()
+		if err != nil {
+			return nil
+		}
+		for _, i := range all {
+			if i.Flags&net.FlagUp != 0 && i.Flags&net.FlagLoopback == 0 && i.Flags&net.FlagMulticast != 0 {
+				ifaces = append(ifaces, i)
+			}
+		}
+	}
+
+	var ips []net.IP
+	for _, i := range ifaces {
+		addrs, err := i.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.To4() != nil {
+				ips = append(ips, ipNet.IP.To4())
+			}
+		}
+	}
+	return ips
+}
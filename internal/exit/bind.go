@@ -0,0 +1,124 @@
+package exit
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+)
+
+// Bind selects the local source of outbound sockets. The zero value leaves
+// the choice to the operating system.
+type Bind struct {
+	// Address is the source IP. It is only used for destinations of the
+	// same address family.
+	Address net.IP
+
+	// Interface is the network interface or VRF device to bind sockets to
+	// (SO_BINDTODEVICE, Linux only).
+	Interface string
+}
+
+// IsZero reports whether the bind leaves the source to the operating system.
+func (b Bind) IsZero() bool {
+	return b.Address == nil && b.Interface == ""
+}
+
+// LocalIP returns the source address for dest, or nil when no address is
+// configured for the family of dest.
+func (b Bind) LocalIP(dest net.IP) net.IP {
+	if b.Address == nil || sameFamily(b.Address, dest) {
+		return b.Address
+	}
+	return nil
+}
+
+// SourceIP returns the source address for dest like LocalIP, falling back to
+// the first address of the bound interface in the family of dest. It is used
+// by sockets that cannot be bound to a device, such as ICMP sockets.
+func (b Bind) SourceIP(dest net.IP) (net.IP, error) {
+	if ip := b.LocalIP(dest); ip != nil || b.Interface == "" {
+		return ip, nil
+	}
+
+	iface, err := net.InterfaceByName(b.Interface)
+	if err != nil {
+		return nil, fmt.Errorf("bind interface %s: %w", b.Interface, err)
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, fmt.Errorf("bind interface %s: %w", b.Interface, err)
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && sameFamily(ipNet.IP, dest) && !ipNet.IP.IsLinkLocalUnicast() {
+			return ipNet.IP, nil
+		}
+	}
+	return nil, fmt.Errorf("bind interface %s has no address for %s", b.Interface, dest)
+}
+
+// Control returns a socket control function binding sockets to the
+// interface, for use in net.Dialer and net.ListenConfig. Returns nil when no
+// interface is configured.
+func (b Bind) Control() func(network, address string, c syscall.RawConn) error {
+	if b.Interface == "" {
+		return nil
+	}
+	iface := b.Interface
+	return func(network, address string, c syscall.RawConn) error {
+		var sockErr error
+		if err := c.Control(func(fd uintptr) {
+			sockErr = bindToDevice(fd, iface)
+		}); err != nil {
+			return err
+		}
+		if sockErr != nil {
+			return fmt.Errorf("bind to interface %s: %w", iface, sockErr)
+		}
+		return nil
+	}
+}
+
+// Dialer returns a TCP dialer for dest that applies the bind.
+func (b Bind) Dialer(dest net.IP, timeout time.Duration) *net.Dialer {
+	d := &net.Dialer{Timeout: timeout, Control: b.Control()}
+	if ip := b.LocalIP(dest); ip != nil {
+		d.LocalAddr = &net.TCPAddr{IP: ip}
+	}
+	return d
+}
+
+// RouteBind overrides the bind for destinations within Network.
+type RouteBind struct {
+	Network *net.IPNet
+	Bind    Bind
+}
+
+// BindConfig holds the exit-wide bind and its per-route overrides.
+type BindConfig struct {
+	// Default applies to destinations not covered by a route override.
+	Default Bind
+
+	// Routes override Default for destinations within their network. The
+	// most specific matching network wins.
+	Routes []RouteBind
+}
+
+// For returns the bind for dest.
+func (c BindConfig) For(dest net.IP) Bind {
+	best, bestLen := c.Default, -1
+	for _, r := range c.Routes {
+		if !r.Network.Contains(dest) {
+			continue
+		}
+		if ones, _ := r.Network.Mask.Size(); ones > bestLen {
+			best, bestLen = r.Bind, ones
+		}
+	}
+	return best
+}
+
+// sameFamily reports whether a and b are both IPv4 or both IPv6.
+func sameFamily(a, b net.IP) bool {
+	return (a.To4() == nil) == (b.To4() == nil)
+}
@@ -0,0 +1,8 @@
+package exit
+
+import "syscall"
+
+// bindToDevice binds the socket to the named interface or VRF device.
+func bindToDevice(fd uintptr, iface string) error {
+	return syscall.SetsockoptString(int(fd), syscall.SOL_SOCKET, syscall.SO_BINDTODEVICE, iface)
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package exit
+
+import "errors"
+
+// bindToDevice is not supported outside Linux.
+func bindToDevice(fd uintptr, iface string) error {
+	return errors.New("binding to an interface is only supported on Linux")
+}
@@ -0,0 +1,347 @@
+package exit
+
+import (
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Actions taken when a destination exceeds a threshold.
+const (
+	DestActionWarn  = "warn"  // Log a warning
+	DestActionBlock = "block" // Log, close streams to the destination, and refuse new ones
+)
+
+// Sort orders for Handler.DestinationStats.
+const (
+	DestSortBytes       = "bytes"
+	DestSortConnections = "connections"
+)
+
+// destBuckets is the number of slots the sliding window is divided into.
+const destBuckets = 12
+
+// DestStatsConfig configures per-destination accounting on the exit.
+//
+// Connections and bytes are aggregated per requested destination (domain
+// name or IP address) over a sliding window. A destination exceeding a
+// threshold is logged at most once per window and, with DestActionBlock,
+// blocked for BlockDuration.
+type DestStatsConfig struct {
+	// Enabled turns on per-destination accounting
+	Enabled bool
+
+	// Window is the length of the sliding window
+	Window time.Duration
+
+	// MaxDestinations limits the number of tracked destinations. When full,
+	// the least recently seen unblocked destination is dropped.
+	MaxDestinations int
+
+	// ConnThreshold is the number of stream opens per window that triggers
+	// Action (0 = disabled)
+	ConnThreshold uint64
+
+	// BytesThreshold is the number of payload bytes per window, both
+	// directions, that triggers Action (0 = disabled)
+	BytesThreshold uint64
+
+	// Action is DestActionWarn or DestActionBlock
+	Action string
+
+	// BlockDuration is how long a destination stays blocked
+	BlockDuration time.Duration
+}
+
+// DefaultDestStatsConfig returns sensible defaults with accounting disabled.
+func DefaultDestStatsConfig() DestStatsConfig {
+	return DestStatsConfig{
+		Enabled:         false,
+		Window:          5 * time.Minute,
+		MaxDestinations: 10000,
+		Action:          DestActionWarn,
+		BlockDuration:   10 * time.Minute,
+	}
+}
+
+// DestStat is a snapshot of the accounting for one destination. Counters
+// cover the current window. BytesOut is plaintext sent to the destination,
+// BytesIn is plaintext received from it.
+type DestStat struct {
+	Destination  string
+	Connections  uint64
+	BytesOut     uint64
+	BytesIn      uint64
+	Active       int
+	Blocked      bool
+	BlockedUntil time.Time
+	LastSeen     time.Time
+}
+
+// destBucket holds counters for one slot of the window.
+type destBucket struct {
+	slot     int64
+	conns    uint64
+	bytesOut uint64
+	bytesIn  uint64
+}
+
+// destEntry holds the sliding window for one destination.
+type destEntry struct {
+	dest string
+
+	mu           sync.Mutex
+	buckets      [destBuckets]destBucket
+	lastSeen     time.Time
+	blockedUntil time.Time
+	flaggedSlot  int64 // Slot of the last threshold trigger (0 = never)
+}
+
+// destTracker aggregates exit traffic per destination.
+type destTracker struct {
+	cfg       DestStatsConfig
+	bucketDur time.Duration
+	logger    *slog.Logger
+	now       func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*destEntry
+}
+
+// newDestTracker creates a tracker, filling unset values from
+// DefaultDestStatsConfig.
+func newDestTracker(cfg DestStatsConfig, logger *slog.Logger) *destTracker {
+	defaults := DefaultDestStatsConfig()
+	if cfg.Window <= 0 {
+		cfg.Window = defaults.Window
+	}
+	if cfg.MaxDestinations <= 0 {
+		cfg.MaxDestinations = defaults.MaxDestinations
+	}
+	if cfg.Action == "" {
+		cfg.Action = defaults.Action
+	}
+	if cfg.BlockDuration <= 0 {
+		cfg.BlockDuration = defaults.BlockDuration
+	}
+	bucketDur := cfg.Window / destBuckets
+	if bucketDur < time.Millisecond {
+		bucketDur = time.Millisecond
+	}
+	return &destTracker{
+		cfg:       cfg,
+		bucketDur: bucketDur,
+		logger:    logger,
+		now:       time.Now,
+		entries:   make(map[string]*destEntry),
+	}
+}
+
+// destKey normalizes a destination for accounting.
+func destKey(dest string) string {
+	return strings.ToLower(dest)
+}
+
+// isBlocked reports whether new streams to dest are refused.
+func (t *destTracker) isBlocked(dest string) bool {
+	t.mu.Lock()
+	e := t.entries[destKey(dest)]
+	t.mu.Unlock()
+	if e == nil {
+		return false
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return t.now().Before(e.blockedUntil)
+}
+
+// recordOpen counts a stream open to dest. Returns the entry for byte
+// accounting and whether this open caused the destination to be blocked.
+func (t *destTracker) recordOpen(dest string) (*destEntry, bool) {
+	e := t.entry(dest)
+	return e, t.add(e, 1, 0, 0)
+}
+
+// recordBytes counts payload bytes for an entry. Returns true if this
+// caused the destination to be blocked.
+func (t *destTracker) recordBytes(e *destEntry, out, in uint64) bool {
+	return t.add(e, 0, out, in)
+}
+
+// entry returns the entry for dest, creating it if needed.
+func (t *destTracker) entry(dest string) *destEntry {
+	key := destKey(dest)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if e, ok := t.entries[key]; ok {
+		return e
+	}
+	if len(t.entries) >= t.cfg.MaxDestinations {
+		t.evictLocked()
+	}
+	e := &destEntry{dest: key}
+	t.entries[key] = e
+	return e
+}
+
+// evictLocked drops the least recently seen destination that is not
+// blocked. Caller holds t.mu.
+func (t *destTracker) evictLocked() {
+	now := t.now()
+	var oldest *destEntry
+	var oldestSeen time.Time
+	for _, e := range t.entries {
+		e.mu.Lock()
+		seen, blocked := e.lastSeen, now.Before(e.blockedUntil)
+		e.mu.Unlock()
+		if blocked {
+			continue
+		}
+		if oldest == nil || seen.Before(oldestSeen) {
+			oldest, oldestSeen = e, seen
+		}
+	}
+	if oldest != nil {
+		delete(t.entries, oldest.dest)
+	}
+}
+
+// add records counters and applies thresholds. Returns true if a block
+// started.
+func (t *destTracker) add(e *destEntry, conns, out, in uint64) bool {
+	now := t.now()
+	slot := now.UnixNano()/int64(t.bucketDur) + 1 // +1 keeps slot 0 as "never"
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	b := &e.buckets[slot%destBuckets]
+	if b.slot != slot {
+		*b = destBucket{slot: slot}
+	}
+	b.conns += conns
+	b.bytesOut += out
+	b.bytesIn += in
+	e.lastSeen = now
+
+	// Trigger at most once per window
+	if e.flaggedSlot != 0 && slot-e.flaggedSlot < destBuckets {
+		return false
+	}
+	totalConns, totalOut, totalIn := e.sumLocked(slot)
+	overConns := t.cfg.ConnThreshold > 0 && totalConns >= t.cfg.ConnThreshold
+	overBytes := t.cfg.BytesThreshold > 0 && totalOut+totalIn >= t.cfg.BytesThreshold
+	if !overConns && !overBytes {
+		return false
+	}
+	e.flaggedSlot = slot
+
+	block := t.cfg.Action == DestActionBlock
+	if block {
+		e.blockedUntil = now.Add(t.cfg.BlockDuration)
+	}
+	if t.logger != nil {
+		t.logger.Warn("exit destination exceeded threshold",
+			"destination", e.dest,
+			"connections", totalConns,
+			"bytes_out", totalOut,
+			"bytes_in", totalIn,
+			"window", t.cfg.Window,
+			"action", t.cfg.Action)
+	}
+	return block
+}
+
+// sumLocked totals the buckets inside the window ending at slot.
+// Caller holds e.mu.
+func (e *destEntry) sumLocked(slot int64) (conns, out, in uint64) {
+	for i := range e.buckets {
+		b := &e.buckets[i]
+		if b.slot > slot-destBuckets && b.slot <= slot {
+			conns += b.conns
+			out += b.bytesOut
+			in += b.bytesIn
+		}
+	}
+	return conns, out, in
+}
+
+// top returns up to n destinations ordered by sortBy (DestSortBytes or
+// DestSortConnections). n <= 0 returns all destinations. Destinations with
+// no traffic in the window are omitted unless blocked.
+func (t *destTracker) top(n int, sortBy string) []DestStat {
+	now := t.now()
+	slot := now.UnixNano()/int64(t.bucketDur) + 1
+
+	t.mu.Lock()
+	entries := make([]*destEntry, 0, len(t.entries))
+	for _, e := range t.entries {
+		entries = append(entries, e)
+	}
+	t.mu.Unlock()
+
+	stats := make([]DestStat, 0, len(entries))
+	for _, e := range entries {
+		e.mu.Lock()
+		conns, out, in := e.sumLocked(slot)
+		st := DestStat{
+			Destination: e.dest,
+			Connections: conns,
+			BytesOut:    out,
+			BytesIn:     in,
+			Blocked:     now.Before(e.blockedUntil),
+			LastSeen:    e.lastSeen,
+		}
+		if st.Blocked {
+			st.BlockedUntil = e.blockedUntil
+		}
+		e.mu.Unlock()
+
+		if conns == 0 && out == 0 && in == 0 && !st.Blocked {
+			continue
+		}
+		stats = append(stats, st)
+	}
+
+	sort.Slice(stats, func(i, j int) bool {
+		a, b := stats[i], stats[j]
+		if sortBy == DestSortConnections && a.Connections != b.Connections {
+			return a.Connections > b.Connections
+		}
+		if a.BytesOut+a.BytesIn != b.BytesOut+b.BytesIn {
+			return a.BytesOut+a.BytesIn > b.BytesOut+b.BytesIn
+		}
+		if a.Connections != b.Connections {
+			return a.Connections > b.Connections
+		}
+		return a.Destination < b.Destination
+	})
+
+	if n > 0 && len(stats) > n {
+		stats = stats[:n]
+	}
+	return stats
+}
+
+// unblock lifts a block on dest. Returns false if dest was not blocked.
+func (t *destTracker) unblock(dest string) bool {
+	t.mu.Lock()
+	e := t.entries[destKey(dest)]
+	t.mu.Unlock()
+	if e == nil {
+		return false
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if !t.now().Before(e.blockedUntil) {
+		return false
+	}
+	e.blockedUntil = time.Time{}
+	return true
+}
@@ -5,14 +5,57 @@ import (
 	"context"
 	"errors"
 	"net"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
 )
 
 // DNSConfig contains DNS resolver configuration.
 type DNSConfig struct {
 	Servers []string
 	Timeout time.Duration
+	Cache   DNSCacheConfig
+
+	// Zones sends queries for names in a zone to the zone's servers
+	// instead of Servers (split-horizon DNS).
+	Zones []DNSZone
+}
+
+// DNSZone is a DNS view for one zone. Names equal to Zone or below it are
+// resolved with Servers; an empty Servers list uses the system resolver. When
+// zones overlap, the longest matching zone wins.
+type DNSZone struct {
+	Zone    string
+	Servers []string
+}
+
+// DNSCacheConfig controls caching of resolved domains.
+//
+// Answers from configured DNS servers are cached for their record TTL,
+// clamped to [MinTTL, MaxTTL]. The system resolver does not report TTLs,
+// so its answers are cached for DefaultTTL. Lookups that fail because the
+// domain does not exist are cached for NegativeTTL.
+type DNSCacheConfig struct {
+	// Enabled turns on caching
+	Enabled bool
+
+	// MinTTL is the shortest time an answer is cached
+	MinTTL time.Duration
+
+	// MaxTTL is the longest time an answer is cached
+	MaxTTL time.Duration
+
+	// DefaultTTL is used when the answer carries no TTL (system resolver)
+	DefaultTTL time.Duration
+
+	// NegativeTTL is how long "no such host" results are cached (0 = not cached)
+	NegativeTTL time.Duration
+
+	// MaxEntries limits the cache size (0 = unlimited)
+	MaxEntries int
 }
 
 // DefaultDNSConfig returns sensible defaults.
@@ -22,19 +65,50 @@ func DefaultDNSConfig() DNSConfig {
 	return DNSConfig{
 		Servers: []string{}, // Empty = use system resolver
 		Timeout: 5 * time.Second,
+		Cache:   DefaultDNSCacheConfig(),
+	}
+}
+
+// DefaultDNSCacheConfig returns sensible cache defaults.
+func DefaultDNSCacheConfig() DNSCacheConfig {
+	return DNSCacheConfig{
+		Enabled:     true,
+		MinTTL:      10 * time.Second,
+		MaxTTL:      time.Hour,
+		DefaultTTL:  5 * time.Minute,
+		NegativeTTL: 30 * time.Second,
+		MaxEntries:  10000,
 	}
 }
 
+// DNSCacheStats contains resolver cache counters.
+type DNSCacheStats struct {
+	Enabled         bool   `json:"enabled"`
+	Entries         int    `json:"entries"`
+	NegativeEntries int    `json:"negative_entries"`
+	Hits            uint64 `json:"hits"`
+	NegativeHits    uint64 `json:"negative_hits"`
+	Misses          uint64 `json:"misses"`
+	Evictions       uint64 `json:"evictions"`
+}
+
 // Resolver handles DNS resolution.
 type Resolver struct {
 	cfg    DNSConfig
 	mu     sync.RWMutex
 	cache  map[string]*cacheEntry
 	dialer *net.Dialer
+
+	hits         atomic.Uint64
+	negativeHits atomic.Uint64
+	misses       atomic.Uint64
+	evictions    atomic.Uint64
 }
 
+// cacheEntry is a cached answer. Negative entries have err set and no ips.
 type cacheEntry struct {
-	ip        net.IP
+	ips       []net.IP
+	err       error
 	expiresAt time.Time
 }
 
@@ -44,6 +118,9 @@ func NewResolver(cfg DNSConfig) *Resolver {
 	if cfg.Timeout == 0 {
 		cfg.Timeout = DefaultDNSConfig().Timeout
 	}
+	if cfg.Cache.DefaultTTL <= 0 {
+		cfg.Cache.DefaultTTL = DefaultDNSCacheConfig().DefaultTTL
+	}
 
 	return &Resolver{
 		cfg:   cfg,
@@ -54,77 +131,142 @@ func NewResolver(cfg DNSConfig) *Resolver {
 	}
 }
 
-// Resolve resolves a domain name to an IP address.
+// Resolve resolves a domain name to a single IP address, preferring IPv4.
 func (r *Resolver) Resolve(ctx context.Context, domain string) (net.IP, error) {
+	ips, err := r.ResolveAll(ctx, domain)
+	if err != nil {
+		return nil, err
+	}
+	return preferIPv4(ips), nil
+}
+
+// ResolveAll resolves a domain name to all of its IPv4 and IPv6 addresses.
+func (r *Resolver) ResolveAll(ctx context.Context, domain string) ([]net.IP, error) {
 	// Check if it's already an IP
 	if ip := net.ParseIP(domain); ip != nil {
-		return ip, nil
+		return []net.IP{ip}, nil
 	}
 
 	// Check cache
-	if ip := r.getCached(domain); ip != nil {
-		return ip, nil
+	if r.cfg.Cache.Enabled {
+		if entry := r.lookupCache(domain); entry != nil {
+			if entry.err != nil {
+				r.negativeHits.Add(1)
+				return nil, entry.err
+			}
+			r.hits.Add(1)
+			return entry.ips, nil
+		}
+		r.misses.Add(1)
 	}
 
 	// Set timeout
 	resolveCtx, cancel := context.WithTimeout(ctx, r.cfg.Timeout)
 	defer cancel()
 
-	var resolver *net.Resolver
-
-	if len(r.cfg.Servers) > 0 {
-		// Use explicitly configured DNS servers
-		resolver = &net.Resolver{
-			PreferGo: true,
-			Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
-				// Try each server until one works
-				var lastErr error
-				for _, server := range r.cfg.Servers {
-					conn, err := r.dialer.DialContext(ctx, "udp", server)
-					if err == nil {
-						return conn, nil
-					}
-					lastErr = err
-				}
-				return nil, lastErr
-			},
-		}
-	} else {
-		// Use system default resolver (supports local domains like .local)
-		resolver = net.DefaultResolver
-	}
+	var ttl ttlRecorder
+	resolver := r.resolverFor(r.serversFor(domain), &ttl)
 
 	// Resolve
 	addrs, err := resolver.LookupIPAddr(resolveCtx, domain)
+	if err == nil && len(addrs) == 0 {
+		err = &net.DNSError{Err: "no addresses found", Name: domain, IsNotFound: true}
+	}
 	if err != nil {
+		var dnsErr *net.DNSError
+		if r.cfg.Cache.Enabled && r.cfg.Cache.NegativeTTL > 0 && errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+			r.storeCache(domain, &cacheEntry{err: err, expiresAt: time.Now().Add(r.cfg.Cache.NegativeTTL)})
+		}
 		return nil, err
 	}
 
-	if len(addrs) == 0 {
-		return nil, errors.New("no addresses found")
-	}
-
-	// Prefer IPv4
-	var selectedIP net.IP
+	ips := make([]net.IP, 0, len(addrs))
 	for _, addr := range addrs {
 		if ipv4 := addr.IP.To4(); ipv4 != nil {
-			selectedIP = ipv4
-			break
+			ips = append(ips, ipv4)
+		} else {
+			ips = append(ips, addr.IP)
 		}
 	}
-	if selectedIP == nil {
-		selectedIP = addrs[0].IP
+
+	if r.cfg.Cache.Enabled {
+		r.setCacheAll(domain, ips, r.cacheTTL(&ttl))
 	}
 
-	// Cache for 5 minutes
-	r.setCache(domain, selectedIP, 5*time.Minute)
+	return ips, nil
+}
 
-	return selectedIP, nil
+// serversFor returns the DNS servers for a domain: those of the longest
+// configured zone containing it, or the global servers.
+func (r *Resolver) serversFor(domain string) []string {
+	name := strings.ToLower(strings.TrimSuffix(domain, "."))
+	servers := r.cfg.Servers
+	best := -1
+	for _, z := range r.cfg.Zones {
+		zone := strings.ToLower(strings.Trim(z.Zone, "."))
+		if len(zone) > best && (name == zone || strings.HasSuffix(name, "."+zone)) {
+			servers, best = z.Servers, len(zone)
+		}
+	}
+	return servers
 }
 
-// getCached returns a cached IP if valid.
+// resolverFor returns a resolver querying servers, recording answer TTLs in
+// ttl. With no servers, the system resolver is used.
+func (r *Resolver) resolverFor(servers []string, ttl *ttlRecorder) *net.Resolver {
+	if len(servers) == 0 {
+		// Use system default resolver (supports local domains like .local)
+		return net.DefaultResolver
+	}
+
+	// Use explicitly configured DNS servers
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			// Try each server until one works
+			var lastErr error
+			for _, server := range servers {
+				conn, err := r.dialer.DialContext(ctx, "udp", server)
+				if err == nil {
+					return wrapTTLConn(conn, ttl), nil
+				}
+				lastErr = err
+			}
+			return nil, lastErr
+		},
+	}
+}
+
+// cacheTTL returns how long to cache an answer: the smallest record TTL
+// seen, or DefaultTTL when none was observed, clamped to [MinTTL, MaxTTL].
+func (r *Resolver) cacheTTL(rec *ttlRecorder) time.Duration {
+	ttl := r.cfg.Cache.DefaultTTL
+	if seen, ok := rec.min(); ok {
+		ttl = seen
+	}
+	if r.cfg.Cache.MinTTL > 0 && ttl < r.cfg.Cache.MinTTL {
+		ttl = r.cfg.Cache.MinTTL
+	}
+	if r.cfg.Cache.MaxTTL > 0 && ttl > r.cfg.Cache.MaxTTL {
+		ttl = r.cfg.Cache.MaxTTL
+	}
+	return ttl
+}
+
+// preferIPv4 returns the first IPv4 address, or the first address if there
+// is none.
+func preferIPv4(ips []net.IP) net.IP {
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			return ip
+		}
+	}
+	return ips[0]
+}
+
+// lookupCache returns a valid cache entry, positive or negative.
 // Expired entries are deleted to prevent unbounded cache growth.
-func (r *Resolver) getCached(domain string) net.IP {
+func (r *Resolver) lookupCache(domain string) *cacheEntry {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
@@ -138,25 +280,86 @@ func (r *Resolver) getCached(domain string) net.IP {
 		return nil
 	}
 
-	return entry.ip
+	return entry
+}
+
+// getCached returns the preferred cached IP if valid.
+func (r *Resolver) getCached(domain string) net.IP {
+	ips := r.getCachedAll(domain)
+	if ips == nil {
+		return nil
+	}
+	return preferIPv4(ips)
+}
+
+// getCachedAll returns all cached IPs if a valid positive entry exists.
+func (r *Resolver) getCachedAll(domain string) []net.IP {
+	entry := r.lookupCache(domain)
+	if entry == nil || entry.err != nil {
+		return nil
+	}
+	return entry.ips
 }
 
-// setCache stores an IP in the cache.
+// setCache stores a single IP in the cache.
 func (r *Resolver) setCache(domain string, ip net.IP, ttl time.Duration) {
+	r.setCacheAll(domain, []net.IP{ip}, ttl)
+}
+
+// setCacheAll stores all IPs for a domain in the cache.
+func (r *Resolver) setCacheAll(domain string, ips []net.IP, ttl time.Duration) {
+	r.storeCache(domain, &cacheEntry{
+		ips:       ips,
+		expiresAt: time.Now().Add(ttl),
+	})
+}
+
+// storeCache inserts an entry, making room when the cache is full by
+// dropping expired entries first and then the entry closest to expiry.
+func (r *Resolver) storeCache(domain string, entry *cacheEntry) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	r.cache[domain] = &cacheEntry{
-		ip:        ip,
-		expiresAt: time.Now().Add(ttl),
+	if _, exists := r.cache[domain]; !exists && r.cfg.Cache.MaxEntries > 0 && len(r.cache) >= r.cfg.Cache.MaxEntries {
+		now := time.Now()
+		for d, e := range r.cache {
+			if now.After(e.expiresAt) {
+				delete(r.cache, d)
+			}
+		}
+		if len(r.cache) >= r.cfg.Cache.MaxEntries {
+			var oldest string
+			var oldestExpiry time.Time
+			for d, e := range r.cache {
+				if oldest == "" || e.expiresAt.Before(oldestExpiry) {
+					oldest, oldestExpiry = d, e.expiresAt
+				}
+			}
+			delete(r.cache, oldest)
+			r.evictions.Add(1)
+		}
 	}
+
+	r.cache[domain] = entry
 }
 
-// ClearCache clears the DNS cache.
-func (r *Resolver) ClearCache() {
+// ClearCache clears the DNS cache and returns the number of entries removed.
+func (r *Resolver) ClearCache() int {
 	r.mu.Lock()
 	defer r.mu.Unlock()
+	n := len(r.cache)
 	r.cache = make(map[string]*cacheEntry)
+	return n
+}
+
+// FlushDomain removes a single domain from the cache. Returns true if an
+// entry was removed.
+func (r *Resolver) FlushDomain(domain string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, ok := r.cache[domain]
+	delete(r.cache, domain)
+	return ok
 }
 
 // CacheSize returns the number of cached entries.
@@ -165,3 +368,118 @@ func (r *Resolver) CacheSize() int {
 	defer r.mu.RUnlock()
 	return len(r.cache)
 }
+
+// CacheStats returns cache counters. Entry counts include entries that have
+// expired but not yet been evicted.
+func (r *Resolver) CacheStats() DNSCacheStats {
+	stats := DNSCacheStats{
+		Enabled:      r.cfg.Cache.Enabled,
+		Hits:         r.hits.Load(),
+		NegativeHits: r.negativeHits.Load(),
+		Misses:       r.misses.Load(),
+		Evictions:    r.evictions.Load(),
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	stats.Entries = len(r.cache)
+	for _, e := range r.cache {
+		if e.err != nil {
+			stats.NegativeEntries++
+		}
+	}
+	return stats
+}
+
+// ttlRecorder tracks the smallest answer TTL seen during one lookup.
+type ttlRecorder struct {
+	mu   sync.Mutex
+	ttl  uint32
+	seen bool
+}
+
+// observe records an answer TTL in seconds.
+func (t *ttlRecorder) observe(ttl uint32) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.seen || ttl < t.ttl {
+		t.ttl = ttl
+		t.seen = true
+	}
+}
+
+// min returns the smallest TTL observed, if any.
+func (t *ttlRecorder) min() (time.Duration, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return time.Duration(t.ttl) * time.Second, t.seen
+}
+
+// ttlConn wraps a UDP DNS connection and records the TTLs of A and AAAA
+// answers passing through it, since net.Resolver does not expose them.
+type ttlConn struct {
+	net.Conn
+	ttl *ttlRecorder
+}
+
+// ttlPacketConn is a ttlConn for datagram connections. net.Resolver only
+// uses UDP message framing when the connection implements net.PacketConn.
+type ttlPacketConn struct {
+	ttlConn
+}
+
+// ReadFrom reads one DNS response and records its answer TTLs.
+func (c *ttlPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, addr, err := c.Conn.(net.PacketConn).ReadFrom(b)
+	if n > 0 {
+		recordAnswerTTLs(b[:n], c.ttl)
+	}
+	return n, addr, err
+}
+
+// WriteTo writes to the underlying datagram connection.
+func (c *ttlPacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	return c.Conn.(net.PacketConn).WriteTo(b, addr)
+}
+
+// wrapTTLConn wraps conn so answer TTLs are recorded, preserving whether it
+// is a datagram connection.
+func wrapTTLConn(conn net.Conn, rec *ttlRecorder) net.Conn {
+	if _, ok := conn.(net.PacketConn); ok {
+		return &ttlPacketConn{ttlConn{Conn: conn, ttl: rec}}
+	}
+	return &ttlConn{Conn: conn, ttl: rec}
+}
+
+// Read reads one DNS response and records its answer TTLs.
+func (c *ttlConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		recordAnswerTTLs(b[:n], c.ttl)
+	}
+	return n, err
+}
+
+// recordAnswerTTLs parses a DNS message and records the TTL of every A and
+// AAAA answer. Malformed messages are ignored; the resolver reports them.
+func recordAnswerTTLs(msg []byte, rec *ttlRecorder) {
+	var p dnsmessage.Parser
+	if _, err := p.Start(msg); err != nil {
+		return
+	}
+	if err := p.SkipAllQuestions(); err != nil {
+		return
+	}
+	for {
+		h, err := p.AnswerHeader()
+		if err != nil {
+			return
+		}
+		if h.Type == dnsmessage.TypeA || h.Type == dnsmessage.TypeAAAA {
+			rec.observe(h.TTL)
+		}
+		if err := p.SkipAnswer(); err != nil {
+			return
+		}
+	}
+}
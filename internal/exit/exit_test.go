@@ -1,17 +1,27 @@
 package exit
 
 import (
+	"bufio"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net"
+	"os"
+	"slices"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
 
 	"github.com/postalsys/muti-metroo/internal/crypto"
 	"github.com/postalsys/muti-metroo/internal/identity"
+	"github.com/postalsys/muti-metroo/internal/logging"
+	"github.com/postalsys/muti-metroo/internal/middleware"
 	"github.com/postalsys/muti-metroo/internal/protocol"
+	"github.com/postalsys/muti-metroo/internal/proxyproto"
+	"golang.org/x/net/dns/dnsmessage"
 )
 
 // ============================================================================
@@ -109,6 +119,222 @@ func TestResolver_CacheExpiry(t *testing.T) {
 	}
 }
 
+// startFakeDNS serves A answers with the given TTL for known.test. and
+// NXDOMAIN for every other name. Returns the server address and a counter
+// of queries received.
+func startFakeDNS(t *testing.T, ttl uint32) (string, *atomic.Int32) {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("ListenPacket() error = %v", err)
+	}
+	t.Cleanup(func() { pc.Close() })
+
+	var queries atomic.Int32
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			var req dnsmessage.Message
+			if err := req.Unpack(buf[:n]); err != nil || len(req.Questions) == 0 {
+				continue
+			}
+			queries.Add(1)
+			q := req.Questions[0]
+			resp := dnsmessage.Message{
+				Header:    dnsmessage.Header{ID: req.ID, Response: true, RecursionAvailable: true},
+				Questions: req.Questions,
+			}
+			if q.Name.String() != "known.test." {
+				resp.RCode = dnsmessage.RCodeNameError
+			} else if q.Type == dnsmessage.TypeA {
+				resp.Answers = []dnsmessage.Resource{{
+					Header: dnsmessage.ResourceHeader{Name: q.Name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: ttl},
+					Body:   &dnsmessage.AResource{A: [4]byte{10, 1, 2, 3}},
+				}}
+			}
+			out, err := resp.Pack()
+			if err != nil {
+				continue
+			}
+			pc.WriteTo(out, addr)
+		}
+	}()
+	return pc.LocalAddr().String(), &queries
+}
+
+func TestResolver_CacheUsesRecordTTL(t *testing.T) {
+	addr, queries := startFakeDNS(t, 7200)
+	cfg := DefaultDNSConfig()
+	cfg.Servers = []string{addr}
+	r := NewResolver(cfg)
+
+	for i := 0; i < 3; i++ {
+		ips, err := r.ResolveAll(context.Background(), "known.test")
+		if err != nil {
+			t.Fatalf("ResolveAll() error = %v", err)
+		}
+		if len(ips) != 1 || ips[0].String() != "10.1.2.3" {
+			t.Fatalf("ResolveAll() = %v, want [10.1.2.3]", ips)
+		}
+	}
+	if q := queries.Load(); q != 2 { // A and AAAA for the first lookup only
+		t.Errorf("DNS queries = %d, want 2", q)
+	}
+
+	// TTL of 2h is clamped to MaxTTL (1h)
+	r.mu.RLock()
+	remaining := time.Until(r.cache["known.test"].expiresAt)
+	r.mu.RUnlock()
+	if remaining > time.Hour || remaining < 59*time.Minute {
+		t.Errorf("cache expiry in %v, want ~1h", remaining)
+	}
+
+	stats := r.CacheStats()
+	if stats.Hits != 2 || stats.Misses != 1 || stats.Entries != 1 {
+		t.Errorf("CacheStats() = %+v, want 2 hits, 1 miss, 1 entry", stats)
+	}
+}
+
+func TestResolver_CacheTTLClamp(t *testing.T) {
+	r := NewResolver(DefaultDNSConfig())
+
+	tests := []struct {
+		name string
+		ttl  uint32
+		seen bool
+		want time.Duration
+	}{
+		{"unknown uses default", 0, false, 5 * time.Minute},
+		{"below min", 1, true, 10 * time.Second},
+		{"within range", 600, true, 10 * time.Minute},
+		{"above max", 86400, true, time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var rec ttlRecorder
+			if tt.seen {
+				rec.observe(tt.ttl)
+			}
+			if got := r.cacheTTL(&rec); got != tt.want {
+				t.Errorf("cacheTTL() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolver_NegativeCache(t *testing.T) {
+	addr, queries := startFakeDNS(t, 60)
+	cfg := DefaultDNSConfig()
+	cfg.Servers = []string{addr}
+	r := NewResolver(cfg)
+
+	for i := 0; i < 2; i++ {
+		if _, err := r.ResolveAll(context.Background(), "missing.test"); err == nil {
+			t.Fatal("ResolveAll() should fail for NXDOMAIN")
+		}
+	}
+	first := queries.Load()
+
+	stats := r.CacheStats()
+	if stats.NegativeEntries != 1 || stats.NegativeHits != 1 {
+		t.Errorf("CacheStats() = %+v, want 1 negative entry and 1 negative hit", stats)
+	}
+
+	if !r.FlushDomain("missing.test") {
+		t.Error("FlushDomain() = false, want true")
+	}
+	if _, err := r.ResolveAll(context.Background(), "missing.test"); err == nil {
+		t.Fatal("ResolveAll() should fail for NXDOMAIN")
+	}
+	if queries.Load() == first {
+		t.Error("lookup after FlushDomain should query the server")
+	}
+}
+
+func TestResolver_CacheEviction(t *testing.T) {
+	cfg := DefaultDNSConfig()
+	cfg.Cache.MaxEntries = 2
+	r := NewResolver(cfg)
+
+	r.setCache("a.test", net.ParseIP("1.1.1.1"), time.Minute)
+	r.setCache("b.test", net.ParseIP("2.2.2.2"), time.Hour)
+	r.setCache("c.test", net.ParseIP("3.3.3.3"), time.Hour)
+
+	if r.CacheSize() != 2 {
+		t.Errorf("CacheSize = %d, want 2", r.CacheSize())
+	}
+	if r.getCached("a.test") != nil {
+		t.Error("entry closest to expiry should have been evicted")
+	}
+	if r.CacheStats().Evictions != 1 {
+		t.Errorf("Evictions = %d, want 1", r.CacheStats().Evictions)
+	}
+	if n := r.ClearCache(); n != 2 {
+		t.Errorf("ClearCache() = %d, want 2", n)
+	}
+}
+
+func TestResolver_CacheDisabled(t *testing.T) {
+	addr, queries := startFakeDNS(t, 60)
+	cfg := DefaultDNSConfig()
+	cfg.Servers = []string{addr}
+	cfg.Cache.Enabled = false
+	r := NewResolver(cfg)
+
+	for i := 0; i < 2; i++ {
+		if _, err := r.ResolveAll(context.Background(), "known.test"); err != nil {
+			t.Fatalf("ResolveAll() error = %v", err)
+		}
+	}
+	if q := queries.Load(); q != 4 {
+		t.Errorf("DNS queries = %d, want 4", q)
+	}
+	if r.CacheSize() != 0 {
+		t.Errorf("CacheSize = %d, want 0", r.CacheSize())
+	}
+}
+
+func TestResolver_Zones(t *testing.T) {
+	internal, internalQueries := startFakeDNS(t, 60)
+	public, publicQueries := startFakeDNS(t, 60)
+	cfg := DefaultDNSConfig()
+	cfg.Servers = []string{public}
+	cfg.Zones = []DNSZone{
+		{Zone: "test", Servers: []string{internal}},
+		{Zone: "sys.known.test."},
+	}
+	cfg.Cache.Enabled = false
+	r := NewResolver(cfg)
+
+	tests := []struct {
+		domain string
+		want   []string
+	}{
+		{"known.test", []string{internal}},
+		{"KNOWN.Test.", []string{internal}},
+		{"test", []string{internal}},
+		{"a.sys.known.test", nil}, // Longest zone wins; no servers = system resolver
+		{"example.com", []string{public}},
+		{"latest", []string{public}},
+	}
+	for _, tt := range tests {
+		if got := r.serversFor(tt.domain); !slices.Equal(got, tt.want) {
+			t.Errorf("serversFor(%q) = %v, want %v", tt.domain, got, tt.want)
+		}
+	}
+
+	if _, err := r.ResolveAll(context.Background(), "known.test"); err != nil {
+		t.Fatalf("ResolveAll() error = %v", err)
+	}
+	if internalQueries.Load() == 0 || publicQueries.Load() != 0 {
+		t.Errorf("queries internal=%d public=%d, want zone server only", internalQueries.Load(), publicQueries.Load())
+	}
+}
+
 // ============================================================================
 // Handler Config Tests
 // ============================================================================
@@ -155,6 +381,7 @@ type mockStreamWriter struct {
 	errs   []streamErr
 	data   []streamData
 	closes []uint64
+	resets []streamReset
 }
 
 type streamAck struct {
@@ -162,6 +389,7 @@ type streamAck struct {
 	requestID uint64
 	boundIP   net.IP
 	boundPort uint16
+	ephPub    [crypto.KeySize]byte
 }
 
 type streamErr struct {
@@ -177,10 +405,15 @@ type streamData struct {
 	flags    uint8
 }
 
-func (m *mockStreamWriter) WriteStreamOpenAck(peerID identity.AgentID, streamID uint64, requestID uint64, boundIP net.IP, boundPort uint16, ephemeralPubKey [crypto.KeySize]byte) error {
+type streamReset struct {
+	streamID  uint64
+	errorCode uint16
+}
+
+func (m *mockStreamWriter) WriteStreamOpenAck(peerID identity.AgentID, streamID uint64, requestID uint64, boundIP net.IP, boundPort uint16, ephemeralPubKey [crypto.KeySize]byte, e2eFlags uint8) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	m.acks = append(m.acks, streamAck{streamID, requestID, boundIP, boundPort})
+	m.acks = append(m.acks, streamAck{streamID, requestID, boundIP, boundPort, ephemeralPubKey})
 	return nil
 }
 
@@ -207,6 +440,17 @@ func (m *mockStreamWriter) WriteStreamClose(peerID identity.AgentID, streamID ui
 	return nil
 }
 
+func (m *mockStreamWriter) WriteStreamReset(peerID identity.AgentID, streamID uint64, errorCode uint16) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.resets = append(m.resets, streamReset{streamID, errorCode})
+	return nil
+}
+
+func (m *mockStreamWriter) MaxStreamPlaintext(peerID identity.AgentID) int {
+	return protocol.MaxPayloadSize - crypto.EncryptionOverhead
+}
+
 func TestNewHandler(t *testing.T) {
 	localID, _ := identity.NewAgentID()
 	cfg := DefaultHandlerConfig()
@@ -220,6 +464,31 @@ func TestNewHandler(t *testing.T) {
 	}
 }
 
+func TestHandler_MapDialError(t *testing.T) {
+	localID, _ := identity.NewAgentID()
+	h := NewHandler(DefaultHandlerConfig(), localID, nil)
+
+	tests := []struct {
+		name string
+		err  error
+		want uint16
+	}{
+		{"refused", &net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}, protocol.ErrConnectionRefused},
+		{"network unreachable", &net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.ENETUNREACH)}, protocol.ErrNetworkUnreachable},
+		{"host unreachable", &net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.EHOSTUNREACH)}, protocol.ErrHostUnreachable},
+		{"dns", &net.DNSError{Err: "no such host", Name: "nx.example"}, protocol.ErrHostUnreachable},
+		{"timeout", &net.OpError{Op: "dial", Err: context.DeadlineExceeded}, protocol.ErrConnectionTimeout},
+		{"wrapped timeout", fmt.Errorf("all addresses failed: %w", &net.OpError{Op: "dial", Err: context.DeadlineExceeded}), protocol.ErrConnectionTimeout},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := h.mapDialError(tt.err); got != tt.want {
+				t.Errorf("mapDialError(%v) = %d, want %d", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
 func TestHandler_StartStop(t *testing.T) {
 	localID, _ := identity.NewAgentID()
 	cfg := DefaultHandlerConfig()
@@ -481,6 +750,39 @@ func TestHandler_GetConnection(t *testing.T) {
 	}
 }
 
+func TestHandler_AbortConnection(t *testing.T) {
+	localID, _ := identity.NewAgentID()
+	writer := &mockStreamWriter{}
+	cfg := DefaultHandlerConfig()
+	h := NewHandler(cfg, localID, writer)
+	h.Start()
+	defer h.Stop()
+
+	h.mu.Lock()
+	h.connections[1] = &ActiveConnection{StreamID: 1}
+	h.connections[2] = &ActiveConnection{StreamID: 2}
+	h.connCount.Add(2)
+	h.mu.Unlock()
+
+	if got := len(h.Connections()); got != 2 {
+		t.Fatalf("len(Connections()) = %d, want 2", got)
+	}
+
+	ac := h.AbortConnection(1)
+	if ac == nil || !ac.IsClosed() {
+		t.Fatal("AbortConnection() should return the closed connection")
+	}
+	if h.ConnectionCount() != 1 {
+		t.Errorf("ConnectionCount() = %d, want 1 after abort", h.ConnectionCount())
+	}
+	if len(writer.closes) != 0 {
+		t.Errorf("AbortConnection() sent %d STREAM_CLOSE frames, want 0", len(writer.closes))
+	}
+	if h.AbortConnection(1) != nil {
+		t.Error("AbortConnection() should return nil for unknown stream")
+	}
+}
+
 func TestHandler_isAllowed(t *testing.T) {
 	localID, _ := identity.NewAgentID()
 
@@ -642,3 +944,1453 @@ func TestHandler_ConcurrentRouteModification(t *testing.T) {
 
 	wg.Wait()
 }
+
+// ============================================================================
+// Connection Pool Tests
+// ============================================================================
+
+// startPingServer starts a TCP server answering each "ping\n" line with
+// "pong\n" on the same connection. Returns the port and an accept counter.
+func startPingServer(t *testing.T) (uint16, *atomic.Int32) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen error: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	var accepts atomic.Int32
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			accepts.Add(1)
+			go func(c net.Conn) {
+				defer c.Close()
+				r := bufio.NewReader(c)
+				for {
+					if _, err := r.ReadString('\n'); err != nil {
+						return
+					}
+					c.Write([]byte("pong\n"))
+				}
+			}(conn)
+		}
+	}()
+	return uint16(ln.Addr().(*net.TCPAddr).Port), &accepts
+}
+
+// waitFor polls cond until it returns true or the timeout expires.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// pingStream opens a stream to the ping server, performs one request and
+// returns once the response has been forwarded to the ingress.
+func pingStream(t *testing.T, h *Handler, writer *mockStreamWriter, remoteID identity.AgentID, streamID uint64, port uint16) {
+	t.Helper()
+	ingressPriv, ingressPub, err := crypto.GenerateEphemeralKeypair()
+	if err != nil {
+		t.Fatalf("GenerateEphemeralKeypair() error = %v", err)
+	}
+	if err := h.HandleStreamOpen(context.Background(), streamID, streamID, remoteID, "127.0.0.1", port, ingressPub); err != nil {
+		t.Fatalf("HandleStreamOpen() error = %v", err)
+	}
+
+	var exitPub [crypto.KeySize]byte
+	waitFor(t, "stream ack", func() bool {
+		writer.mu.Lock()
+		defer writer.mu.Unlock()
+		for _, ack := range writer.acks {
+			if ack.streamID == streamID {
+				exitPub = ack.ephPub
+				return true
+			}
+		}
+		return false
+	})
+
+	shared, err := crypto.ComputeECDH(ingressPriv, exitPub)
+	if err != nil {
+		t.Fatalf("ComputeECDH() error = %v", err)
+	}
+	key := crypto.DeriveSessionKey(shared, streamID, ingressPub, exitPub, true)
+	ciphertext, err := key.Encrypt([]byte("ping\n"))
+	if err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+	if err := h.HandleStreamData(remoteID, streamID, ciphertext, 0); err != nil {
+		t.Fatalf("HandleStreamData() error = %v", err)
+	}
+
+	waitFor(t, "pong", func() bool {
+		writer.mu.Lock()
+		defer writer.mu.Unlock()
+		for _, d := range writer.data {
+			if d.streamID == streamID && len(d.data) > 0 {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+func TestHandler_ResetsStreamOnE2EValidationFailure(t *testing.T) {
+	port, _ := startPingServer(t)
+
+	localID, _ := identity.NewAgentID()
+	remoteID, _ := identity.NewAgentID()
+	writer := &mockStreamWriter{}
+	cfg := DefaultHandlerConfig()
+	cfg.AllowedRoutes, _ = ParseAllowedRoutes([]string{"127.0.0.0/8"})
+	h := NewHandler(cfg, localID, writer)
+	h.Start()
+	defer h.Stop()
+
+	pingStream(t, h, writer, remoteID, 1, port)
+
+	// A frame that does not authenticate under the session key
+	forged := make([]byte, crypto.EncryptionOverhead+8)
+	forged[4+7] = 1 // Next nonce in sequence
+	if err := h.HandleStreamData(remoteID, 1, forged, 0); err == nil {
+		t.Fatal("HandleStreamData() accepted a forged frame")
+	}
+
+	if h.ConnectionCount() != 0 {
+		t.Errorf("ConnectionCount() = %d, want 0", h.ConnectionCount())
+	}
+	writer.mu.Lock()
+	defer writer.mu.Unlock()
+	if len(writer.resets) != 1 || writer.resets[0] != (streamReset{1, protocol.ErrE2EValidation}) {
+		t.Errorf("resets = %v, want [{1 %d}]", writer.resets, protocol.ErrE2EValidation)
+	}
+}
+
+func TestHandler_PoolReusesIdleConnection(t *testing.T) {
+	port, accepts := startPingServer(t)
+
+	localID, _ := identity.NewAgentID()
+	remoteID, _ := identity.NewAgentID()
+	writer := &mockStreamWriter{}
+	cfg := DefaultHandlerConfig()
+	cfg.AllowedRoutes, _ = ParseAllowedRoutes([]string{"127.0.0.0/8"})
+	cfg.Pool = PoolConfig{Enabled: true, Ports: []uint16{port}}
+	h := NewHandler(cfg, localID, writer)
+	h.Start()
+	defer h.Stop()
+
+	pingStream(t, h, writer, remoteID, 1, port)
+
+	// Client finishes after the response: the socket goes back to the pool
+	if err := h.HandleStreamData(remoteID, 1, nil, protocol.FlagFinWrite); err != nil {
+		t.Fatalf("HandleStreamData(FIN) error = %v", err)
+	}
+	waitFor(t, "pooled socket", func() bool { return h.pool.idleCount() == 1 })
+	if h.ConnectionCount() != 0 {
+		t.Errorf("ConnectionCount() = %d, want 0", h.ConnectionCount())
+	}
+
+	writer.mu.Lock()
+	if len(writer.closes) != 1 || writer.closes[0] != 1 {
+		t.Errorf("closes = %v, want [1]", writer.closes)
+	}
+	writer.mu.Unlock()
+
+	// Second stream takes over the pooled socket without dialing
+	pingStream(t, h, writer, remoteID, 2, port)
+	if got := accepts.Load(); got != 1 {
+		t.Errorf("destination accepted %d connections, want 1", got)
+	}
+	if h.pool.idleCount() != 0 {
+		t.Errorf("idleCount() = %d, want 0", h.pool.idleCount())
+	}
+}
+
+func TestHandler_PoolSkipsUnansweredStream(t *testing.T) {
+	port, _ := startPingServer(t)
+
+	localID, _ := identity.NewAgentID()
+	remoteID, _ := identity.NewAgentID()
+	writer := &mockStreamWriter{}
+	cfg := DefaultHandlerConfig()
+	cfg.AllowedRoutes, _ = ParseAllowedRoutes([]string{"127.0.0.0/8"})
+	cfg.Pool = PoolConfig{Enabled: true, Ports: []uint16{port}}
+	h := NewHandler(cfg, localID, writer)
+	h.Start()
+	defer h.Stop()
+
+	pingStream(t, h, writer, remoteID, 1, port)
+	ac := h.GetConnection(1)
+	if ac == nil {
+		t.Fatal("connection not tracked")
+	}
+
+	// The client spoke last, so a FIN is a plain half-close
+	ac.lastFromDest.Store(false)
+	h.HandleStreamData(remoteID, 1, nil, protocol.FlagFinWrite)
+	h.HandleStreamClose(remoteID, 1)
+
+	time.Sleep(50 * time.Millisecond)
+	if h.pool.idleCount() != 0 {
+		t.Errorf("idleCount() = %d, want 0", h.pool.idleCount())
+	}
+}
+
+func TestConnPool_Limits(t *testing.T) {
+	p := newConnPool(PoolConfig{Enabled: true, MaxIdle: 3, MaxIdlePerHost: 2})
+
+	conns := make([]net.Conn, 4)
+	for i := range conns {
+		c1, c2 := net.Pipe()
+		t.Cleanup(func() { c1.Close(); c2.Close() })
+		conns[i] = c1
+	}
+
+	if !p.put("a:80", conns[0]) || !p.put("a:80", conns[1]) {
+		t.Fatal("put() should accept sockets under the per-host limit")
+	}
+	if p.put("a:80", conns[2]) {
+		t.Error("put() should reject sockets over the per-host limit")
+	}
+	if !p.put("b:80", conns[2]) {
+		t.Fatal("put() should accept a socket for another host")
+	}
+	if p.put("c:80", conns[3]) {
+		t.Error("put() should reject sockets over the total limit")
+	}
+	if p.idleCount() != 3 {
+		t.Errorf("idleCount() = %d, want 3", p.idleCount())
+	}
+
+	p.closeAll()
+	if p.idleCount() != 0 {
+		t.Errorf("idleCount() after closeAll = %d, want 0", p.idleCount())
+	}
+	if p.put("a:80", conns[3]) {
+		t.Error("put() should reject sockets after closeAll")
+	}
+}
+
+func TestConnPool_DiscardsDeadAndExpired(t *testing.T) {
+	p := newConnPool(PoolConfig{Enabled: true, IdleTimeout: time.Hour})
+
+	// Destination closed the socket while it was idle
+	local, remote := net.Pipe()
+	remote.Close()
+	p.put("a:80", local)
+	if conn := p.get("a:80"); conn != nil {
+		t.Error("get() should discard a socket closed by the destination")
+	}
+
+	// Live socket is returned
+	local, remote = net.Pipe()
+	defer remote.Close()
+	p.put("a:80", local)
+	if conn := p.get("a:80"); conn != local {
+		t.Error("get() should return the live idle socket")
+	}
+
+	// Expired sockets are pruned
+	p.put("a:80", local)
+	p.mu.Lock()
+	p.idle["a:80"][0].idleSince = time.Now().Add(-2 * time.Hour)
+	p.mu.Unlock()
+	p.prune()
+	if p.idleCount() != 0 {
+		t.Errorf("idleCount() after prune = %d, want 0", p.idleCount())
+	}
+}
+
+func TestConnPool_AllowsPort(t *testing.T) {
+	p := newConnPool(PoolConfig{Enabled: true})
+	if !p.allowsPort(80) {
+		t.Error("port 80 should be pooled by default")
+	}
+	if p.allowsPort(443) {
+		t.Error("port 443 should not be pooled by default")
+	}
+}
+
+// ============================================================================
+// Happy Eyeballs Tests
+// ============================================================================
+
+func TestSortAddresses(t *testing.T) {
+	ips := []net.IP{
+		net.ParseIP("192.0.2.1"),
+		net.ParseIP("192.0.2.2"),
+		net.ParseIP("192.0.2.3"),
+		net.ParseIP("2001:db8::1"),
+		net.ParseIP("2001:db8::2"),
+	}
+	want := []string{"2001:db8::1", "192.0.2.1", "2001:db8::2", "192.0.2.2", "192.0.2.3"}
+
+	got := sortAddresses(ips)
+	if len(got) != len(want) {
+		t.Fatalf("sortAddresses() returned %d addresses, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i].String() != want[i] {
+			t.Errorf("sortAddresses()[%d] = %s, want %s", i, got[i], want[i])
+		}
+	}
+}
+
+func TestHasBothFamilies(t *testing.T) {
+	v4 := net.ParseIP("192.0.2.1")
+	v6 := net.ParseIP("2001:db8::1")
+
+	if hasBothFamilies([]net.IP{v4}) {
+		t.Error("IPv4 only should not report both families")
+	}
+	if hasBothFamilies([]net.IP{v6, v6}) {
+		t.Error("IPv6 only should not report both families")
+	}
+	if !hasBothFamilies([]net.IP{v4, v6}) {
+		t.Error("mixed addresses should report both families")
+	}
+}
+
+func TestDialParallel_FallsBackToIPv4(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen error: %v", err)
+	}
+	defer ln.Close()
+	port := uint16(ln.Addr().(*net.TCPAddr).Port)
+
+	// Nothing listens on the IPv6 loopback port, so the first attempt fails
+	// (or IPv6 is unavailable) and the IPv4 attempt must win.
+	ips := sortAddresses([]net.IP{net.ParseIP("127.0.0.1"), net.ParseIP("::1")})
+	dialer := func(net.IP) *net.Dialer { return &net.Dialer{Timeout: 2 * time.Second} }
+
+	start := time.Now()
+	conn, err := dialParallel(context.Background(), dialer, ips, port, 100*time.Millisecond)
+	if err != nil {
+		t.Fatalf("dialParallel() error = %v", err)
+	}
+	defer conn.Close()
+
+	if family := addressFamily(conn.RemoteAddr()); family != FamilyIPv4 {
+		t.Errorf("addressFamily() = %q, want %q", family, FamilyIPv4)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("dialParallel() took %v, want fallback within the attempt delay", elapsed)
+	}
+}
+
+func TestDialParallel_AllFail(t *testing.T) {
+	// Reserve a port and release it so nothing is listening
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen error: %v", err)
+	}
+	port := uint16(ln.Addr().(*net.TCPAddr).Port)
+	ln.Close()
+
+	ips := []net.IP{net.ParseIP("127.0.0.1"), net.ParseIP("127.0.0.1")}
+	dialer := func(net.IP) *net.Dialer { return &net.Dialer{Timeout: time.Second} }
+	if _, err := dialParallel(context.Background(), dialer, ips, port, 50*time.Millisecond); err == nil {
+		t.Error("dialParallel() should fail when every attempt fails")
+	}
+}
+
+func TestResolver_ResolveAll_IPAddress(t *testing.T) {
+	r := NewResolver(DefaultDNSConfig())
+
+	ips, err := r.ResolveAll(context.Background(), "2001:db8::1")
+	if err != nil {
+		t.Fatalf("ResolveAll() error = %v", err)
+	}
+	if len(ips) != 1 || ips[0].String() != "2001:db8::1" {
+		t.Errorf("ResolveAll() = %v, want [2001:db8::1]", ips)
+	}
+
+	// Cached dual-stack entries keep every address, Resolve prefers IPv4
+	r.setCacheAll("dual.example", []net.IP{net.ParseIP("2001:db8::1"), net.ParseIP("192.0.2.1")}, time.Hour)
+	ips, _ = r.ResolveAll(context.Background(), "dual.example")
+	if len(ips) != 2 {
+		t.Errorf("ResolveAll() returned %d addresses, want 2", len(ips))
+	}
+	ip, _ := r.Resolve(context.Background(), "dual.example")
+	if ip.String() != "192.0.2.1" {
+		t.Errorf("Resolve() = %s, want 192.0.2.1", ip)
+	}
+}
+
+// ============================================================================
+// Destination Accounting Tests
+// ============================================================================
+
+func TestDestTracker_SlidingWindow(t *testing.T) {
+	now := time.Unix(1000, 0)
+	tr := newDestTracker(DestStatsConfig{Enabled: true, Window: 12 * time.Second}, nil)
+	tr.now = func() time.Time { return now }
+
+	e, _ := tr.recordOpen("Example.COM")
+	tr.recordBytes(e, 100, 400)
+	tr.recordOpen("10.0.0.1")
+
+	stats := tr.top(0, DestSortBytes)
+	if len(stats) != 2 {
+		t.Fatalf("top() returned %d entries, want 2", len(stats))
+	}
+	if stats[0].Destination != "example.com" || stats[0].BytesOut != 100 || stats[0].BytesIn != 400 {
+		t.Errorf("top()[0] = %+v, want example.com with 100/400 bytes", stats[0])
+	}
+
+	// Traffic older than the window no longer counts
+	now = now.Add(13 * time.Second)
+	if stats := tr.top(0, DestSortBytes); len(stats) != 0 {
+		t.Errorf("top() after window = %v, want empty", stats)
+	}
+}
+
+func TestDestTracker_TopOrdering(t *testing.T) {
+	tr := newDestTracker(DestStatsConfig{Enabled: true}, nil)
+
+	busy, _ := tr.recordOpen("busy.example")
+	tr.recordBytes(busy, 1000, 0)
+	for i := 0; i < 3; i++ {
+		tr.recordOpen("chatty.example")
+	}
+	tr.recordOpen("quiet.example")
+
+	if got := tr.top(1, DestSortBytes); len(got) != 1 || got[0].Destination != "busy.example" {
+		t.Errorf("top(1, bytes) = %v, want busy.example", got)
+	}
+	if got := tr.top(1, DestSortConnections); len(got) != 1 || got[0].Destination != "chatty.example" {
+		t.Errorf("top(1, connections) = %v, want chatty.example", got)
+	}
+}
+
+func TestDestTracker_ThresholdBlock(t *testing.T) {
+	now := time.Unix(1000, 0)
+	tr := newDestTracker(DestStatsConfig{
+		Enabled:        true,
+		Window:         time.Minute,
+		BytesThreshold: 1000,
+		Action:         DestActionBlock,
+		BlockDuration:  time.Minute,
+	}, nil)
+	tr.now = func() time.Time { return now }
+
+	e, blocked := tr.recordOpen("203.0.113.5")
+	if blocked {
+		t.Fatal("recordOpen() blocked below threshold")
+	}
+	if tr.recordBytes(e, 500, 0) {
+		t.Fatal("recordBytes() blocked below threshold")
+	}
+	if !tr.recordBytes(e, 0, 500) {
+		t.Fatal("recordBytes() should block at threshold")
+	}
+	if !tr.isBlocked("203.0.113.5") {
+		t.Error("isBlocked() = false after threshold")
+	}
+
+	// Further traffic in the same window does not trigger again
+	if tr.recordBytes(e, 5000, 0) {
+		t.Error("recordBytes() triggered twice in one window")
+	}
+
+	if !tr.unblock("203.0.113.5") {
+		t.Error("unblock() = false for blocked destination")
+	}
+	if tr.isBlocked("203.0.113.5") {
+		t.Error("isBlocked() = true after unblock")
+	}
+	if tr.unblock("203.0.113.5") {
+		t.Error("unblock() = true for unblocked destination")
+	}
+
+	// Blocks expire on their own
+	tr.recordBytes(e, 0, 0)
+	now = now.Add(2 * time.Minute)
+	if !tr.recordBytes(e, 2000, 0) {
+		t.Fatal("recordBytes() should block again in a new window")
+	}
+	now = now.Add(2 * time.Minute)
+	if tr.isBlocked("203.0.113.5") {
+		t.Error("isBlocked() = true after block duration")
+	}
+}
+
+func TestDestTracker_WarnDoesNotBlock(t *testing.T) {
+	tr := newDestTracker(DestStatsConfig{Enabled: true, ConnThreshold: 2}, nil)
+
+	tr.recordOpen("example.com")
+	if _, blocked := tr.recordOpen("example.com"); blocked {
+		t.Error("recordOpen() blocked with warn action")
+	}
+	if tr.isBlocked("example.com") {
+		t.Error("isBlocked() = true with warn action")
+	}
+}
+
+func TestDestTracker_Eviction(t *testing.T) {
+	now := time.Unix(1000, 0)
+	tr := newDestTracker(DestStatsConfig{
+		Enabled:         true,
+		MaxDestinations: 2,
+		BytesThreshold:  100,
+		Action:          DestActionBlock,
+	}, nil)
+	tr.now = func() time.Time { return now }
+
+	e, _ := tr.recordOpen("blocked.example")
+	tr.recordBytes(e, 100, 0) // Blocked entries are never evicted
+	now = now.Add(time.Second)
+	tr.recordOpen("old.example")
+	now = now.Add(time.Second)
+	tr.recordOpen("new.example")
+
+	tr.mu.Lock()
+	_, hasBlocked := tr.entries["blocked.example"]
+	_, hasOld := tr.entries["old.example"]
+	n := len(tr.entries)
+	tr.mu.Unlock()
+
+	if n != 2 || !hasBlocked || hasOld {
+		t.Errorf("entries after eviction: n=%d blocked=%v old=%v, want 2/true/false", n, hasBlocked, hasOld)
+	}
+}
+
+func TestHandler_DestStatsBlock(t *testing.T) {
+	port, _ := startPingServer(t)
+
+	localID, _ := identity.NewAgentID()
+	remoteID, _ := identity.NewAgentID()
+	writer := &mockStreamWriter{}
+	cfg := DefaultHandlerConfig()
+	cfg.AllowedRoutes, _ = ParseAllowedRoutes([]string{"127.0.0.0/8"})
+	cfg.DestStats = DestStatsConfig{
+		Enabled:       true,
+		ConnThreshold: 2,
+		Action:        DestActionBlock,
+	}
+	h := NewHandler(cfg, localID, writer)
+	h.Start()
+	defer h.Stop()
+
+	pingStream(t, h, writer, remoteID, 1, port)
+
+	stats := h.DestinationStats(10, DestSortConnections)
+	if len(stats) != 1 || stats[0].Destination != "127.0.0.1" || stats[0].Connections != 1 || stats[0].Active != 1 {
+		t.Fatalf("DestinationStats() = %+v, want one active 127.0.0.1 entry", stats)
+	}
+	if stats[0].BytesOut != 5 || stats[0].BytesIn != 5 {
+		t.Errorf("bytes out/in = %d/%d, want 5/5", stats[0].BytesOut, stats[0].BytesIn)
+	}
+
+	// Second open reaches the threshold: it is refused and stream 1 is closed
+	var ephPub [crypto.KeySize]byte
+	if err := h.HandleStreamOpen(context.Background(), 2, 2, remoteID, "127.0.0.1", port, ephPub); err == nil {
+		t.Error("HandleStreamOpen() should fail when the destination is blocked")
+	}
+	if h.GetConnection(1) != nil {
+		t.Error("connection to blocked destination still active")
+	}
+	writer.mu.Lock()
+	if len(writer.errs) != 1 || writer.errs[0].errorCode != protocol.ErrNotAllowed {
+		t.Errorf("errs = %+v, want one ErrNotAllowed", writer.errs)
+	}
+	writer.mu.Unlock()
+
+	stats = h.DestinationStats(0, DestSortBytes)
+	if len(stats) != 1 || !stats[0].Blocked {
+		t.Errorf("DestinationStats() = %+v, want blocked entry", stats)
+	}
+
+	if !h.UnblockDestination("127.0.0.1") {
+		t.Error("UnblockDestination() = false")
+	}
+}
+
+func TestHandler_DestStatsDisabled(t *testing.T) {
+	localID, _ := identity.NewAgentID()
+	h := NewHandler(DefaultHandlerConfig(), localID, nil)
+
+	if h.DestStatsEnabled() {
+		t.Error("DestStatsEnabled() = true by default")
+	}
+	if stats := h.DestinationStats(10, DestSortBytes); stats != nil {
+		t.Errorf("DestinationStats() = %v, want nil", stats)
+	}
+	if h.UnblockDestination("example.com") {
+		t.Error("UnblockDestination() = true when disabled")
+	}
+}
+
+func TestBindConfig_For(t *testing.T) {
+	_, wide, _ := net.ParseCIDR("10.0.0.0/8")
+	_, narrow, _ := net.ParseCIDR("10.1.0.0/16")
+	cfg := BindConfig{
+		Default: Bind{Address: net.ParseIP("192.0.2.1")},
+		Routes: []RouteBind{
+			{Network: narrow, Bind: Bind{Interface: "vrf-blue"}},
+			{Network: wide, Bind: Bind{Address: net.ParseIP("10.0.0.1")}},
+		},
+	}
+
+	tests := []struct {
+		dest string
+		want Bind
+	}{
+		{"10.1.2.3", Bind{Interface: "vrf-blue"}},
+		{"10.2.3.4", Bind{Address: net.ParseIP("10.0.0.1")}},
+		{"198.51.100.1", Bind{Address: net.ParseIP("192.0.2.1")}},
+	}
+	for _, tt := range tests {
+		got := cfg.For(net.ParseIP(tt.dest))
+		if !got.Address.Equal(tt.want.Address) || got.Interface != tt.want.Interface {
+			t.Errorf("For(%s) = %+v, want %+v", tt.dest, got, tt.want)
+		}
+	}
+}
+
+func TestBind_LocalIP(t *testing.T) {
+	b := Bind{Address: net.ParseIP("192.0.2.1")}
+	if ip := b.LocalIP(net.ParseIP("198.51.100.1")); !ip.Equal(b.Address) {
+		t.Errorf("LocalIP(IPv4) = %v, want %v", ip, b.Address)
+	}
+	if ip := b.LocalIP(net.ParseIP("2001:db8::1")); ip != nil {
+		t.Errorf("LocalIP(IPv6) = %v, want nil for a different family", ip)
+	}
+	if (Bind{}).Control() != nil {
+		t.Error("Control() should be nil without an interface")
+	}
+}
+
+func TestHandler_Dial_BindAddress(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen error: %v", err)
+	}
+	defer ln.Close()
+	port := uint16(ln.Addr().(*net.TCPAddr).Port)
+
+	h := NewHandler(HandlerConfig{
+		ConnectTimeout: 2 * time.Second,
+		Bind:           BindConfig{Default: Bind{Address: net.ParseIP("127.0.0.2")}},
+	}, identity.AgentID{}, nil)
+
+	conn, err := h.dial(context.Background(), []net.IP{net.ParseIP("127.0.0.1")}, port)
+	if err != nil {
+		t.Skipf("dial from 127.0.0.2 failed: %v", err)
+	}
+	defer conn.Close()
+
+	if local := conn.LocalAddr().(*net.TCPAddr).IP; !local.Equal(net.ParseIP("127.0.0.2")) {
+		t.Errorf("local address = %v, want 127.0.0.2", local)
+	}
+}
+
+func TestPrivateFilter(t *testing.T) {
+	exceptions, _ := ParseAllowedRoutes([]string{"10.20.0.0/16"})
+	f := PrivateFilter{Block: true, Allowed: exceptions}
+
+	tests := []struct {
+		ip      string
+		blocked bool
+	}{
+		{"10.1.2.3", true},
+		{"172.16.0.1", true},
+		{"192.168.1.1", true},
+		{"127.0.0.1", true},
+		{"169.254.169.254", true},
+		{"0.0.0.0", true},
+		{"::1", true},
+		{"fd00::1", true},
+		{"fe80::1", true},
+		{"::ffff:192.168.1.1", true},
+		{"224.0.0.251", true},
+		{"239.255.255.250", true},
+		{"ff02::1", true},
+		{"64:ff9b::10.1.2.3", true},   // NAT64 of a private address
+		{"64:ff9b::7f00:1", true},     // NAT64 of loopback
+		{"2002:c0a8:101::1", true},    // 6to4 of 192.168.1.1
+		{"64:ff9b::10.20.5.5", false}, // NAT64 of an exception
+		{"64:ff9b::8.8.8.8", false},   // NAT64 of a public address
+		{"2002:808:808::1", false},    // 6to4 of 8.8.8.8
+		{"10.20.5.5", false},          // Exception
+		{"8.8.8.8", false},
+		{"172.32.0.1", false},
+		{"2001:db8::1", false},
+	}
+	for _, tt := range tests {
+		if got := f.Blocks(net.ParseIP(tt.ip)); got != tt.blocked {
+			t.Errorf("Blocks(%s) = %v, want %v", tt.ip, got, tt.blocked)
+		}
+	}
+
+	if (PrivateFilter{}).Blocks(net.ParseIP("127.0.0.1")) {
+		t.Error("zero PrivateFilter should not block")
+	}
+
+	ips := []net.IP{net.ParseIP("192.168.1.1"), net.ParseIP("8.8.8.8")}
+	if got := f.Filter(ips); len(got) != 1 || !got[0].Equal(net.ParseIP("8.8.8.8")) {
+		t.Errorf("Filter() = %v, want [8.8.8.8]", got)
+	}
+}
+
+func TestHandler_HandleStreamOpen_PrivateDestination(t *testing.T) {
+	localID, _ := identity.NewAgentID()
+	remoteID, _ := identity.NewAgentID()
+	writer := &mockStreamWriter{}
+
+	// The default route covers loopback, but block_private refuses it
+	cfg := DefaultHandlerConfig()
+	cfg.AllowedRoutes, _ = ParseAllowedRoutes([]string{"0.0.0.0/0"})
+	cfg.Private = PrivateFilter{Block: true}
+
+	h := NewHandler(cfg, localID, writer)
+	h.Start()
+	defer h.Stop()
+
+	var testEphemeralKey [crypto.KeySize]byte
+	if err := h.HandleStreamOpen(context.Background(), 1, 100, remoteID, "127.0.0.1", 80, testEphemeralKey); err != nil {
+		t.Errorf("HandleStreamOpen() should return nil (async): %v", err)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+
+	writer.mu.Lock()
+	defer writer.mu.Unlock()
+	if len(writer.errs) != 1 || writer.errs[0].errorCode != protocol.ErrPrivateDestination {
+		t.Errorf("errs = %+v, want one ErrPrivateDestination", writer.errs)
+	}
+}
+
+func TestPortLimiter(t *testing.T) {
+	l := newPortLimiter(PortLimitConfig{Classes: []PortClass{
+		{Name: "smtp", Ports: SMTPPorts, Action: PortActionBlock},
+		{Name: "web", Ports: []uint16{8080}, Action: PortActionAllow},
+		{Name: "irc", Ports: []uint16{6667, 8080}, Action: PortActionLimit, MaxConnections: 2},
+		{Name: "ssh", Ports: []uint16{22}, Action: PortActionLimit, Rate: 1, Burst: 1},
+	}}, logging.NopLogger())
+
+	if _, err := l.admit(587, "mail.example.com"); err != ErrPortBlocked {
+		t.Errorf("admit(587) error = %v, want ErrPortBlocked", err)
+	}
+	if _, err := l.admit(443, "example.com"); err != nil {
+		t.Errorf("admit(443) error = %v, want nil for an unlisted port", err)
+	}
+
+	// The earlier allow class claims 8080
+	for i := 0; i < 3; i++ {
+		if _, err := l.admit(8080, "example.com"); err != nil {
+			t.Fatalf("admit(8080) error = %v, want nil", err)
+		}
+	}
+
+	release, err := l.admit(6667, "irc.example.com")
+	if err != nil {
+		t.Fatalf("admit(6667) error = %v", err)
+	}
+	l.admit(6667, "irc.example.com")
+	if _, err := l.admit(6667, "irc.example.com"); err != ErrPortConnectionLimit {
+		t.Errorf("third admit(6667) error = %v, want ErrPortConnectionLimit", err)
+	}
+	release()
+	release() // Releasing twice frees one slot
+	if _, err := l.admit(6667, "irc.example.com"); err != nil {
+		t.Errorf("admit(6667) after release error = %v", err)
+	}
+
+	if _, err := l.admit(22, "host"); err != nil {
+		t.Fatalf("admit(22) error = %v", err)
+	}
+	if _, err := l.admit(22, "host"); err != ErrPortRateLimit {
+		t.Errorf("second admit(22) error = %v, want ErrPortRateLimit", err)
+	}
+
+	stats := l.stats()
+	if len(stats) != 3 || stats[0].Name != "smtp" || stats[0].Refused != 1 || stats[1].Active != 2 || stats[1].Refused != 1 {
+		t.Errorf("stats() = %+v", stats)
+	}
+
+	if newPortLimiter(PortLimitConfig{Classes: []PortClass{{Ports: SMTPPorts, Action: PortActionAllow}}}, logging.NopLogger()) != nil {
+		t.Error("newPortLimiter() with only allow classes should be nil")
+	}
+}
+
+func TestHandler_HandleStreamOpen_PortLimits(t *testing.T) {
+	localID, _ := identity.NewAgentID()
+	remoteID, _ := identity.NewAgentID()
+	writer := &mockStreamWriter{}
+
+	cfg := DefaultHandlerConfig()
+	cfg.AllowedRoutes, _ = ParseAllowedRoutes([]string{"0.0.0.0/0", "10.0.0.0/8"})
+	cfg.PortLimits = PortLimitConfig{
+		Classes:          []PortClass{{Name: "smtp", Ports: SMTPPorts, Action: PortActionBlock}},
+		DefaultRouteOnly: true,
+	}
+
+	h := NewHandler(cfg, localID, writer)
+	h.Start()
+	defer h.Stop()
+
+	var testEphemeralKey [crypto.KeySize]byte
+	if err := h.HandleStreamOpen(context.Background(), 1, 100, remoteID, "127.0.0.1", 25, testEphemeralKey); err != nil {
+		t.Errorf("HandleStreamOpen() should return nil (async): %v", err)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+
+	writer.mu.Lock()
+	if len(writer.errs) != 1 || writer.errs[0].errorCode != protocol.ErrNotAllowed || writer.errs[0].message != ErrPortBlocked.Error() {
+		t.Errorf("errs = %+v, want one ErrNotAllowed for the blocked port", writer.errs)
+	}
+	writer.mu.Unlock()
+
+	// Without the default route the exit is not open, so the class is off
+	_, defaultRoute, _ := net.ParseCIDR("0.0.0.0/0")
+	h.RemoveAllowedRoute(defaultRoute)
+	if h.PortLimitsActive() {
+		t.Error("PortLimitsActive() = true without a default route")
+	}
+	if _, err := h.AdmitPort(25, "10.0.0.1"); err != nil {
+		t.Errorf("AdmitPort(25) error = %v, want nil without a default route", err)
+	}
+}
+
+func TestHandler_HandleStreamOpen_ProxyProtocol(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	defer ln.Close()
+	port := uint16(ln.Addr().(*net.TCPAddr).Port)
+
+	localID, _ := identity.NewAgentID()
+	remoteID, _ := identity.NewAgentID()
+	writer := &mockStreamWriter{}
+
+	cfg := DefaultHandlerConfig()
+	cfg.AllowedRoutes, _ = ParseAllowedRoutes([]string{"127.0.0.0/8"})
+	cfg.ProxyProtocol = ProxyProtocol{Destinations: cfg.AllowedRoutes}
+	cfg.Pool = PoolConfig{Enabled: true, Ports: []uint16{port}, MaxIdle: 4, MaxIdlePerHost: 2, IdleTimeout: time.Minute}
+
+	h := NewHandler(cfg, localID, writer)
+	h.Start()
+	defer h.Stop()
+
+	_, ingressPub, err := crypto.GenerateEphemeralKeypair()
+	if err != nil {
+		t.Fatalf("GenerateEphemeralKeypair() error = %v", err)
+	}
+	client := &net.TCPAddr{IP: net.IPv4(198, 51, 100, 9), Port: 40001}
+	ctx := proxyproto.WithClientAddr(context.Background(), client)
+	if err := h.HandleStreamOpen(ctx, 1, 100, remoteID, "127.0.0.1", port, ingressPub); err != nil {
+		t.Fatalf("HandleStreamOpen() error = %v", err)
+	}
+
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatalf("Accept() error = %v", err)
+	}
+	defer conn.Close()
+
+	want := proxyproto.Header(client, &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: int(port)})
+	got := make([]byte, len(want))
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := io.ReadFull(conn, got); err != nil {
+		t.Fatalf("read PROXY header: %v", err)
+	}
+	if string(got) != string(want) {
+		t.Errorf("header = %x, want %x", got, want)
+	}
+
+	// Connections carrying a client's header are never pooled
+	var ac *ActiveConnection
+	for deadline := time.Now().Add(2 * time.Second); ac == nil && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+		h.mu.RLock()
+		ac = h.connections[1]
+		h.mu.RUnlock()
+	}
+	if ac == nil || ac.poolKey != "" {
+		t.Errorf("connection = %+v, want tracked without a pool key", ac)
+	}
+}
+
+func TestUserPolicy_Filter(t *testing.T) {
+	routes, _ := ParseAllowedRoutes([]string{"10.0.0.0/8"})
+	domains := []DomainPattern{{Pattern: "*.example.com", IsWildcard: true, BaseDomain: "example.com"}}
+	p := UserPolicy{
+		Enabled:     true,
+		Users:       map[string]UserRoutes{"alice": {Routes: routes, Domains: domains}},
+		DefaultDeny: true,
+	}
+	inside := []net.IP{net.ParseIP("10.1.2.3"), net.ParseIP("192.0.2.1")}
+
+	tests := []struct {
+		name string
+		user string
+		dest string
+		want int
+	}{
+		{"route match keeps covered addresses", "alice", "10.1.2.3", 1},
+		{"domain match keeps all addresses", "alice", "www.example.com", 2},
+		{"other domain falls back to routes", "alice", "www.example.org", 1},
+		{"unknown user denied", "bob", "10.1.2.3", 0},
+		{"missing user denied", "", "10.1.2.3", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := p.Filter(tt.user, tt.dest, inside); len(got) != tt.want {
+				t.Errorf("Filter() = %v, want %d addresses", got, tt.want)
+			}
+		})
+	}
+
+	p.DefaultDeny = false
+	if got := p.Filter("bob", "10.1.2.3", inside); len(got) != 2 {
+		t.Errorf("Filter() with default allow = %v, want all addresses", got)
+	}
+	if got := (UserPolicy{}).Filter("bob", "10.1.2.3", inside); len(got) != 2 {
+		t.Errorf("disabled Filter() = %v, want all addresses", got)
+	}
+}
+
+func TestHandler_HandleStreamOpen_UserPolicy(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	defer ln.Close()
+	port := uint16(ln.Addr().(*net.TCPAddr).Port)
+
+	localID, _ := identity.NewAgentID()
+	remoteID, _ := identity.NewAgentID()
+	writer := &mockStreamWriter{}
+
+	cfg := DefaultHandlerConfig()
+	cfg.AllowedRoutes, _ = ParseAllowedRoutes([]string{"127.0.0.0/8"})
+	otherRoutes, _ := ParseAllowedRoutes([]string{"10.0.0.0/8"})
+	cfg.UserPolicy = UserPolicy{
+		Enabled: true,
+		Users: map[string]UserRoutes{
+			"alice": {Routes: cfg.AllowedRoutes},
+			"bob":   {Routes: otherRoutes},
+		},
+	}
+	cfg.AuditLog = true
+
+	h := NewHandler(cfg, localID, writer)
+	h.Start()
+	defer h.Stop()
+
+	var ephPub [crypto.KeySize]byte
+	ctx := WithUser(context.Background(), "bob")
+	if err := h.HandleStreamOpen(ctx, 1, 1, remoteID, "127.0.0.1", port, ephPub); err != nil {
+		t.Fatalf("HandleStreamOpen() error = %v", err)
+	}
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		writer.mu.Lock()
+		n := len(writer.errs)
+		writer.mu.Unlock()
+		if n > 0 {
+			break
+		}
+	}
+	writer.mu.Lock()
+	if len(writer.errs) != 1 || writer.errs[0].errorCode != protocol.ErrNotAllowed {
+		t.Errorf("errs = %+v, want one ErrNotAllowed for bob", writer.errs)
+	}
+	writer.mu.Unlock()
+
+	client := &net.TCPAddr{IP: net.IPv4(198, 51, 100, 9), Port: 40001}
+	ctx = proxyproto.WithClientAddr(WithUser(context.Background(), "alice"), client)
+	_, ingressPub, err := crypto.GenerateEphemeralKeypair()
+	if err != nil {
+		t.Fatalf("GenerateEphemeralKeypair() error = %v", err)
+	}
+	if err := h.HandleStreamOpen(ctx, 2, 2, remoteID, "127.0.0.1", port, ingressPub); err != nil {
+		t.Fatalf("HandleStreamOpen() error = %v", err)
+	}
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatalf("Accept() error = %v", err)
+	}
+	defer conn.Close()
+
+	var ac *ActiveConnection
+	for deadline := time.Now().Add(2 * time.Second); ac == nil && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+		ac = h.GetConnection(2)
+	}
+	if ac == nil || ac.User != "alice" || ac.Client != client.String() {
+		t.Errorf("connection = %+v, want user alice from %s", ac, client)
+	}
+}
+
+func TestHandler_Middleware(t *testing.T) {
+	port, accepts := startPingServer(t)
+
+	localID, _ := identity.NewAgentID()
+	remoteID, _ := identity.NewAgentID()
+	writer := &mockStreamWriter{}
+
+	var mu sync.Mutex
+	var seen []string
+	cfg := DefaultHandlerConfig()
+	cfg.AllowedRoutes, _ = ParseAllowedRoutes([]string{"127.0.0.0/8"})
+	h := NewHandler(cfg, localID, writer)
+	h.SetMiddleware(middleware.Chain{middleware.Funcs{
+		Open: func(ctx context.Context, s *middleware.Stream) error {
+			if s.ID == 2 {
+				return errors.New("refused by middleware")
+			}
+			return nil
+		},
+		Data: func(s *middleware.Stream, dir middleware.Direction, data []byte) ([]byte, error) {
+			mu.Lock()
+			seen = append(seen, dir.String()+" "+string(data))
+			mu.Unlock()
+			return data, nil
+		},
+		Close: func(s *middleware.Stream) {
+			mu.Lock()
+			seen = append(seen, "close "+s.Side.String())
+			mu.Unlock()
+		},
+	}})
+	h.Start()
+	defer h.Stop()
+
+	pingStream(t, h, writer, remoteID, 1, port)
+	h.closeConnection(1, remoteID, nil)
+
+	mu.Lock()
+	want := []string{"to_destination ping\n", "from_destination pong\n", "close exit"}
+	if !slices.Equal(seen, want) {
+		t.Errorf("middleware saw %q, want %q", seen, want)
+	}
+	mu.Unlock()
+
+	// A refused stream is never dialed
+	var ephPub [crypto.KeySize]byte
+	if err := h.HandleStreamOpen(context.Background(), 2, 2, remoteID, "127.0.0.1", port, ephPub); err != nil {
+		t.Fatalf("HandleStreamOpen() error = %v", err)
+	}
+	waitFor(t, "open error", func() bool {
+		writer.mu.Lock()
+		defer writer.mu.Unlock()
+		return len(writer.errs) > 0
+	})
+	writer.mu.Lock()
+	if writer.errs[0].errorCode != protocol.ErrNotAllowed {
+		t.Errorf("ErrorCode = %d, want %d", writer.errs[0].errorCode, protocol.ErrNotAllowed)
+	}
+	writer.mu.Unlock()
+	if n := accepts.Load(); n != 1 {
+		t.Errorf("accepts = %d, want 1", n)
+	}
+}
+
+// ============================================================================
+// Half-Close Tests
+// ============================================================================
+
+// startHalfCloseServer starts a TCP server that answers "ping\n" with
+// "pong\n" and then keeps the connection open after the client finishes.
+// With finish set, it half-closes its own side after the answer.
+func startHalfCloseServer(t *testing.T, finish bool) uint16 {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen error: %v", err)
+	}
+	done := make(chan struct{})
+	t.Cleanup(func() {
+		close(done)
+		ln.Close()
+	})
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(c net.Conn) {
+				defer c.Close()
+				if _, err := bufio.NewReader(c).ReadString('\n'); err != nil {
+					return
+				}
+				c.Write([]byte("pong\n"))
+				if finish {
+					c.(*net.TCPConn).CloseWrite()
+				}
+				io.Copy(io.Discard, c)
+				<-done
+			}(conn)
+		}
+	}()
+	return uint16(ln.Addr().(*net.TCPAddr).Port)
+}
+
+func TestHandler_HalfCloseClientLinger(t *testing.T) {
+	port := startHalfCloseServer(t, false)
+
+	localID, _ := identity.NewAgentID()
+	remoteID, _ := identity.NewAgentID()
+	writer := &mockStreamWriter{}
+	cfg := DefaultHandlerConfig()
+	cfg.AllowedRoutes, _ = ParseAllowedRoutes([]string{"127.0.0.0/8"})
+	cfg.HalfClose.ClientLinger = 100 * time.Millisecond
+	h := NewHandler(cfg, localID, writer)
+	h.Start()
+	defer h.Stop()
+
+	pingStream(t, h, writer, remoteID, 1, port)
+	ac := h.GetConnection(1)
+	if err := h.HandleStreamData(remoteID, 1, nil, protocol.FlagFinWrite); err != nil {
+		t.Fatalf("HandleStreamData(FIN) error = %v", err)
+	}
+	if got := ac.HalfClosed(); got != HalfClosedClient {
+		t.Errorf("HalfClosed() = %q, want %q", got, HalfClosedClient)
+	}
+	if stats := h.HalfCloseStats(); stats.ClientHalfOpen != 1 || stats.ClientFinished != 1 {
+		t.Errorf("HalfCloseStats() = %+v, want 1 client half-open and finished", stats)
+	}
+
+	// The destination never finishes, so the linger closes the stream
+	waitFor(t, "linger cleanup", func() bool { return h.ConnectionCount() == 0 })
+	if stats := h.HalfCloseStats(); stats.ClientHalfOpen != 0 || stats.ClientLingerExpired != 1 {
+		t.Errorf("HalfCloseStats() = %+v, want 1 client linger expired", stats)
+	}
+	writer.mu.Lock()
+	defer writer.mu.Unlock()
+	if len(writer.closes) != 1 || writer.closes[0] != 1 {
+		t.Errorf("closes = %v, want [1]", writer.closes)
+	}
+}
+
+func TestHandler_HalfCloseDestinationLinger(t *testing.T) {
+	port := startHalfCloseServer(t, true)
+
+	localID, _ := identity.NewAgentID()
+	remoteID, _ := identity.NewAgentID()
+	writer := &mockStreamWriter{}
+	cfg := DefaultHandlerConfig()
+	cfg.AllowedRoutes, _ = ParseAllowedRoutes([]string{"127.0.0.0/8"})
+	cfg.HalfClose.DestinationLinger = 200 * time.Millisecond
+	h := NewHandler(cfg, localID, writer)
+	h.Start()
+	defer h.Stop()
+
+	// The stream stays open after the destination finished, until the
+	// client finishes too
+	pingStream(t, h, writer, remoteID, 1, port)
+	ac := h.GetConnection(1)
+	waitFor(t, "destination half-close", func() bool { return ac.HalfClosed() == HalfClosedDestination })
+	if h.ConnectionCount() != 1 {
+		t.Fatalf("ConnectionCount() = %d, want 1", h.ConnectionCount())
+	}
+	if err := h.HandleStreamData(remoteID, 1, nil, protocol.FlagFinWrite); err != nil {
+		t.Fatalf("HandleStreamData(FIN) error = %v", err)
+	}
+	if h.ConnectionCount() != 0 {
+		t.Errorf("ConnectionCount() = %d after both sides finished, want 0", h.ConnectionCount())
+	}
+
+	// A client that never finishes is closed at the linger
+	pingStream(t, h, writer, remoteID, 2, port)
+	waitFor(t, "linger cleanup", func() bool { return h.ConnectionCount() == 0 })
+
+	stats := h.HalfCloseStats()
+	if stats.DestinationFinished != 2 || stats.DestinationLingerExpired != 1 || stats.ClientFinished != 0 {
+		t.Errorf("HalfCloseStats() = %+v, want 2 destination finished and 1 expired", stats)
+	}
+	writer.mu.Lock()
+	defer writer.mu.Unlock()
+	if !slices.Equal(writer.closes, []uint64{1, 2}) {
+		t.Errorf("closes = %v, want [1 2]", writer.closes)
+	}
+}
+
+func TestHealthChecker_Thresholds(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			c.Close()
+		}
+	}()
+	addr := ln.Addr().String()
+
+	var changes []bool
+	c := NewHealthChecker(HealthCheckConfig{
+		Targets:          []string{addr},
+		Interval:         time.Hour,
+		Timeout:          time.Second,
+		FailureThreshold: 2,
+		SuccessThreshold: 2,
+		OnChange:         func(healthy bool) { changes = append(changes, healthy) },
+	})
+	ctx := context.Background()
+
+	if !c.Check(ctx) {
+		t.Fatalf("check with listening target failed: %+v", c.Status().Targets)
+	}
+	if st := c.Status(); !st.Healthy || len(st.Targets) != 1 || !st.Targets[0].OK {
+		t.Fatalf("status = %+v, want healthy with passed target", st)
+	}
+
+	ln.Close()
+	c.Check(ctx)
+	if !c.Status().Healthy || len(changes) != 0 {
+		t.Fatal("one failed check below the threshold made the exit unhealthy")
+	}
+	c.Check(ctx)
+	st := c.Status()
+	if st.Healthy || st.Withdrawals != 1 || st.Failed != 2 || st.Targets[0].Error == "" {
+		t.Fatalf("status after 2 failed checks = %+v, want unhealthy", st)
+	}
+	if !slices.Equal(changes, []bool{false}) {
+		t.Fatalf("changes = %v, want [false]", changes)
+	}
+
+	ln2, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Skipf("cannot listen on %s again: %v", addr, err)
+	}
+	defer ln2.Close()
+	go func() {
+		for {
+			c, err := ln2.Accept()
+			if err != nil {
+				return
+			}
+			c.Close()
+		}
+	}()
+
+	c.Check(ctx)
+	if c.Status().Healthy {
+		t.Fatal("one passed check below the threshold made the exit healthy")
+	}
+	c.Check(ctx)
+	if st := c.Status(); !st.Healthy || st.ConsecutiveSuccesses != 2 || st.Checks != 5 {
+		t.Fatalf("status after 2 passed checks = %+v, want healthy", st)
+	}
+	if !slices.Equal(changes, []bool{false, true}) {
+		t.Errorf("changes = %v, want [false true]", changes)
+	}
+}
+
+func TestHealthChecker_AnyTargetPasses(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	// A port that refuses connections
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	refused := closed.Addr().String()
+	closed.Close()
+
+	c := NewHealthChecker(HealthCheckConfig{
+		Targets:          []string{refused, ln.Addr().String()},
+		Interval:         time.Hour,
+		Timeout:          time.Second,
+		FailureThreshold: 1,
+		SuccessThreshold: 1,
+	})
+	if !c.Check(context.Background()) {
+		t.Fatal("check failed with one reachable target")
+	}
+	st := c.Status()
+	if st.Targets[0].OK || !st.Targets[1].OK {
+		t.Errorf("targets = %+v, want first failed and second passed", st.Targets)
+	}
+}
+
+// withAttempt returns a context carrying the token and proof of the next
+// attempt of chain.
+func withAttempt(chain *protocol.OpenChain) context.Context {
+	token, proof := chain.Next()
+	return WithOpenToken(context.Background(), token, proof)
+}
+
+func TestHandler_HandleStreamOpen_OpenToken(t *testing.T) {
+	port, accepts := startPingServer(t)
+
+	localID, _ := identity.NewAgentID()
+	remoteID, _ := identity.NewAgentID()
+	retryID, _ := identity.NewAgentID()
+	writer := &mockStreamWriter{}
+	cfg := DefaultHandlerConfig()
+	cfg.AllowedRoutes, _ = ParseAllowedRoutes([]string{"127.0.0.0/8"})
+	h := NewHandler(cfg, localID, writer)
+	h.Start()
+	defer h.Stop()
+
+	chain := protocol.NewOpenChain()
+	ctx := withAttempt(chain)
+	_, ephPub, err := crypto.GenerateEphemeralKeypair()
+	if err != nil {
+		t.Fatalf("GenerateEphemeralKeypair() error = %v", err)
+	}
+	if err := h.HandleStreamOpen(ctx, 1, 100, remoteID, "127.0.0.1", port, ephPub); err != nil {
+		t.Fatalf("HandleStreamOpen() error = %v", err)
+	}
+	waitFor(t, "first stream", func() bool { return h.GetConnection(1) != nil })
+
+	// A repeated open of the same stream is dropped
+	if err := h.HandleStreamOpen(ctx, 1, 100, remoteID, "127.0.0.1", port, ephPub); err != nil {
+		t.Fatalf("HandleStreamOpen(duplicate) error = %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if got := accepts.Load(); got != 1 {
+		t.Errorf("destination accepted %d connections after duplicate, want 1", got)
+	}
+
+	// A retry over another path replaces the first stream
+	retryCtx := withAttempt(chain)
+	if err := h.HandleStreamOpen(retryCtx, 7, 100, retryID, "127.0.0.1", port, ephPub); err != nil {
+		t.Fatalf("HandleStreamOpen(retry) error = %v", err)
+	}
+	waitFor(t, "retried stream", func() bool { return h.GetConnection(7) != nil })
+	if h.GetConnection(1) != nil {
+		t.Error("superseded stream is still tracked")
+	}
+	if h.ConnectionCount() != 1 {
+		t.Errorf("ConnectionCount() = %d, want 1", h.ConnectionCount())
+	}
+	writer.mu.Lock()
+	if len(writer.resets) != 1 || writer.resets[0] != (streamReset{1, protocol.ErrStreamSuperseded}) {
+		t.Errorf("resets = %+v, want stream 1 reset with ErrStreamSuperseded", writer.resets)
+	}
+	writer.mu.Unlock()
+
+	// The token is released with the stream
+	h.HandleStreamClose(retryID, 7)
+	h.mu.RLock()
+	held := len(h.openTokens)
+	h.mu.RUnlock()
+	if held != 0 {
+		t.Errorf("%d open tokens held after close, want 0", held)
+	}
+}
+
+func TestHandler_HandleStreamOpen_OpenTokenCopied(t *testing.T) {
+	port, accepts := startPingServer(t)
+
+	localID, _ := identity.NewAgentID()
+	remoteID, _ := identity.NewAgentID()
+	transitID, _ := identity.NewAgentID()
+	writer := &mockStreamWriter{}
+	cfg := DefaultHandlerConfig()
+	cfg.AllowedRoutes, _ = ParseAllowedRoutes([]string{"127.0.0.0/8"})
+	h := NewHandler(cfg, localID, writer)
+	h.Start()
+	defer h.Stop()
+
+	token, proof := protocol.NewOpenChain().Next()
+	_, ephPub, err := crypto.GenerateEphemeralKeypair()
+	if err != nil {
+		t.Fatalf("GenerateEphemeralKeypair() error = %v", err)
+	}
+	if err := h.HandleStreamOpen(WithOpenToken(context.Background(), token, proof), 1, 100, remoteID, "127.0.0.1", port, ephPub); err != nil {
+		t.Fatalf("HandleStreamOpen() error = %v", err)
+	}
+	waitFor(t, "first stream", func() bool { return h.GetConnection(1) != nil })
+
+	// A transit agent replays the token and proof it relayed over another
+	// path: the open is refused and the first stream kept
+	err = h.HandleStreamOpen(WithOpenToken(context.Background(), token, proof), 5, 100, transitID, "127.0.0.1", port, ephPub)
+	if !errors.Is(err, errOpenTokenHeld) {
+		t.Fatalf("HandleStreamOpen(copied) error = %v, want errOpenTokenHeld", err)
+	}
+	writer.mu.Lock()
+	if len(writer.errs) != 1 || writer.errs[0].errorCode != protocol.ErrStreamSuperseded {
+		t.Errorf("open errors = %+v, want one ErrStreamSuperseded", writer.errs)
+	}
+	if len(writer.resets) != 0 {
+		t.Errorf("resets = %+v, want none", writer.resets)
+	}
+	writer.mu.Unlock()
+
+	// Claiming a later attempt without its proof drops the token, so the
+	// open does not touch the first stream either
+	forged := proof
+	forged.Attempt++
+	if err := h.HandleStreamOpen(WithOpenToken(context.Background(), token, forged), 6, 100, transitID, "127.0.0.1", port, ephPub); err != nil {
+		t.Fatalf("HandleStreamOpen(forged) error = %v", err)
+	}
+	waitFor(t, "forged stream", func() bool { return h.GetConnection(6) != nil })
+	if h.GetConnection(1) == nil {
+		t.Error("first stream was superseded by a copied token")
+	}
+	if got := accepts.Load(); got != 2 {
+		t.Errorf("destination accepted %d connections, want 2", got)
+	}
+}
+
+func TestHandler_HandleStreamOpen_OpenTokenRace(t *testing.T) {
+	port, _ := startPingServer(t)
+
+	localID, _ := identity.NewAgentID()
+	writer := &mockStreamWriter{}
+	cfg := DefaultHandlerConfig()
+	cfg.AllowedRoutes, _ = ParseAllowedRoutes([]string{"127.0.0.0/8"})
+	h := NewHandler(cfg, localID, writer)
+	h.Start()
+	defer h.Stop()
+
+	// Retries of one dial arriving at once over several paths
+	const opens = protocol.MaxOpenAttempts
+	chain := protocol.NewOpenChain()
+	_, ephPub, err := crypto.GenerateEphemeralKeypair()
+	if err != nil {
+		t.Fatalf("GenerateEphemeralKeypair() error = %v", err)
+	}
+	var wg sync.WaitGroup
+	for i := range opens {
+		remoteID, _ := identity.NewAgentID()
+		ctx := withAttempt(chain)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			h.HandleStreamOpen(ctx, uint64(i+1), 100, remoteID, "127.0.0.1", port, ephPub)
+		}()
+	}
+	wg.Wait()
+
+	waitFor(t, "all opens answered", func() bool {
+		writer.mu.Lock()
+		defer writer.mu.Unlock()
+		return len(writer.acks)+len(writer.errs) == opens
+	})
+	if got := h.ConnectionCount(); got != 1 {
+		t.Errorf("ConnectionCount() = %d, want 1", got)
+	}
+	writer.mu.Lock()
+	if len(writer.resets) != len(writer.acks)-1 {
+		t.Errorf("%d streams reset after %d acks, want every opened stream but one reset", len(writer.resets), len(writer.acks))
+	}
+	writer.mu.Unlock()
+}
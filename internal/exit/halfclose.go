@@ -0,0 +1,155 @@
+package exit
+
+import (
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/postalsys/muti-metroo/internal/logging"
+)
+
+// Sides of a half-closed connection, naming the side that finished sending.
+const (
+	HalfClosedClient      = "client"
+	HalfClosedDestination = "destination"
+)
+
+// HalfCloseConfig bounds how long a stream stays open after one side has
+// finished sending, so clients and destinations that never finish the other
+// direction cannot hold sockets open.
+type HalfCloseConfig struct {
+	// ClientLinger is how long the destination socket stays open after the
+	// client finished sending (FIN_WRITE from the ingress), waiting for the
+	// destination to finish. 0 leaves it to the idle timeout.
+	ClientLinger time.Duration
+
+	// DestinationLinger is how long the destination socket stays open after
+	// the destination finished sending, to deliver the rest of the client's
+	// data. 0 closes the stream as soon as the destination finishes.
+	DestinationLinger time.Duration
+}
+
+// HalfCloseStats reports half-closed streams. Counters are cumulative since
+// the handler was created.
+type HalfCloseStats struct {
+	ClientLinger      time.Duration
+	DestinationLinger time.Duration
+
+	ClientHalfOpen      int // Streams the client has finished, open now
+	DestinationHalfOpen int // Streams the destination has finished, open now
+
+	ClientFinished      uint64 // Client finished while the destination was still sending
+	DestinationFinished uint64 // Destination finished while the client was still sending
+
+	ClientLingerExpired      uint64 // Closed at ClientLinger
+	DestinationLingerExpired uint64 // Closed at DestinationLinger
+}
+
+// halfCloseCounters counts half-closes and forced cleanups.
+type halfCloseCounters struct {
+	clientFinished atomic.Uint64
+	destFinished   atomic.Uint64
+	clientExpired  atomic.Uint64
+	destExpired    atomic.Uint64
+}
+
+// HalfClosed returns the side that finished sending while the other side is
+// still open (HalfClosedClient or HalfClosedDestination), or "".
+func (ac *ActiveConnection) HalfClosed() string {
+	switch {
+	case ac.writeClosed.Load():
+		return HalfClosedClient
+	case ac.readClosed.Load():
+		return HalfClosedDestination
+	}
+	return ""
+}
+
+// stopLinger cancels the forced cleanup of a half-closed connection.
+func (ac *ActiveConnection) stopLinger() {
+	ac.lingerMu.Lock()
+	defer ac.lingerMu.Unlock()
+	if ac.linger != nil {
+		ac.linger.Stop()
+	}
+}
+
+// clientFinished half-closes the destination socket after FIN_WRITE from the
+// ingress. The stream closes at once when the destination has finished too,
+// otherwise after ClientLinger.
+func (h *Handler) clientFinished(ac *ActiveConnection, tcpConn *net.TCPConn) {
+	ac.writeClosed.Store(true)
+	tcpConn.CloseWrite()
+
+	// Checked after writeClosed is set so a concurrent destination EOF in
+	// the read loop cannot leave both sides waiting for each other
+	if ac.readClosed.Load() {
+		h.closeConnection(ac.StreamID, ac.RemoteID, nil)
+		return
+	}
+	h.halfClose.clientFinished.Add(1)
+	if d := h.cfg.HalfClose.ClientLinger; d > 0 {
+		h.startLinger(ac, d, HalfClosedClient, &h.halfClose.clientExpired)
+	}
+}
+
+// destinationFinished records EOF from the destination after FIN_WRITE was
+// sent to the ingress. Returns true if the connection stays open for the
+// rest of the client's data, false if the stream should close now.
+func (h *Handler) destinationFinished(ac *ActiveConnection) bool {
+	ac.readClosed.Store(true)
+	if ac.writeClosed.Load() {
+		return false
+	}
+	h.halfClose.destFinished.Add(1)
+
+	d := h.cfg.HalfClose.DestinationLinger
+	if d <= 0 {
+		return false
+	}
+	h.startLinger(ac, d, HalfClosedDestination, &h.halfClose.destExpired)
+	return true
+}
+
+// startLinger closes a half-closed connection once d has passed, unless it
+// closes on its own first.
+func (h *Handler) startLinger(ac *ActiveConnection, d time.Duration, side string, expired *atomic.Uint64) {
+	ac.lingerMu.Lock()
+	defer ac.lingerMu.Unlock()
+	if ac.linger != nil || ac.IsClosed() {
+		return
+	}
+
+	ac.linger = time.AfterFunc(d, func() {
+		if h.GetConnection(ac.StreamID) != ac {
+			return
+		}
+		expired.Add(1)
+		h.logger.Debug("half-closed exit stream closed after linger",
+			logging.KeyStreamID, ac.StreamID,
+			"half_closed", side,
+			logging.KeyDuration, d)
+		h.closeConnection(ac.StreamID, ac.RemoteID, nil)
+	})
+}
+
+// HalfCloseStats returns the half-close settings and counters.
+func (h *Handler) HalfCloseStats() HalfCloseStats {
+	stats := HalfCloseStats{
+		ClientLinger:             h.cfg.HalfClose.ClientLinger,
+		DestinationLinger:        h.cfg.HalfClose.DestinationLinger,
+		ClientFinished:           h.halfClose.clientFinished.Load(),
+		DestinationFinished:      h.halfClose.destFinished.Load(),
+		ClientLingerExpired:      h.halfClose.clientExpired.Load(),
+		DestinationLingerExpired: h.halfClose.destExpired.Load(),
+	}
+	for _, ac := range h.Connections() {
+		switch ac.HalfClosed() {
+		case HalfClosedClient:
+			stats.ClientHalfOpen++
+		case HalfClosedDestination:
+			stats.DestinationHalfOpen++
+		}
+	}
+	return stats
+}
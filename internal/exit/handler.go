@@ -7,15 +7,21 @@ import (
 	"io"
 	"log/slog"
 	"net"
+	"os"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/postalsys/muti-metroo/internal/crypto"
 	"github.com/postalsys/muti-metroo/internal/identity"
 	"github.com/postalsys/muti-metroo/internal/logging"
+	"github.com/postalsys/muti-metroo/internal/middleware"
 	"github.com/postalsys/muti-metroo/internal/protocol"
+	"github.com/postalsys/muti-metroo/internal/proxyproto"
 	"github.com/postalsys/muti-metroo/internal/recovery"
 )
 
@@ -46,6 +52,48 @@ type HandlerConfig struct {
 	// DNS configuration
 	DNS DNSConfig
 
+	// Pool configures reuse of destination connections
+	Pool PoolConfig
+
+	// HappyEyeballs configures racing of IPv6 and IPv4 connection attempts
+	HappyEyeballs HappyEyeballsConfig
+
+	// DestStats configures per-destination accounting and thresholds
+	DestStats DestStatsConfig
+
+	// HalfClose bounds how long half-closed streams stay open
+	HalfClose HalfCloseConfig
+
+	// Bind selects the source address or interface of outbound connections
+	Bind BindConfig
+
+	// Private refuses destinations in the exit's own network
+	Private PrivateFilter
+
+	// PortLimits blocks or caps connections per destination port class
+	PortLimits PortLimitConfig
+
+	// ProxyProtocol sends the client address to selected destinations
+	ProxyProtocol ProxyProtocol
+
+	// UserPolicy narrows allowed destinations per propagated SOCKS5 user
+	UserPolicy UserPolicy
+
+	// Rekey sets when streams replace their E2E key, for ingresses that
+	// ask for it. Zero disables rekeying.
+	Rekey crypto.RekeyConfig
+
+	// AuditLog logs every stream with its user and client address
+	AuditLog bool
+
+	// Middleware is called on stream open, data and close
+	Middleware middleware.Chain
+
+	// OnConnOpen and OnConnClose, when set, are called when a stream's
+	// destination connection starts and stops being tracked
+	OnConnOpen  func(*ActiveConnection)
+	OnConnClose func(*ActiveConnection)
+
 	// Logger for logging
 	Logger *slog.Logger
 }
@@ -58,6 +106,9 @@ func DefaultHandlerConfig() HandlerConfig {
 		IdleTimeout:    5 * time.Minute,
 		MaxConnections: 1000,
 		DNS:            DefaultDNSConfig(),
+		Pool:           DefaultPoolConfig(),
+		HappyEyeballs:  DefaultHappyEyeballsConfig(),
+		DestStats:      DefaultDestStatsConfig(),
 	}
 }
 
@@ -67,26 +118,95 @@ type StreamWriter interface {
 	WriteStreamData(peerID identity.AgentID, streamID uint64, data []byte, flags uint8) error
 
 	// WriteStreamOpenAck sends a successful open acknowledgment with ephemeral public key for E2E encryption.
-	WriteStreamOpenAck(peerID identity.AgentID, streamID uint64, requestID uint64, boundIP net.IP, boundPort uint16, ephemeralPubKey [crypto.KeySize]byte) error
+	WriteStreamOpenAck(peerID identity.AgentID, streamID uint64, requestID uint64, boundIP net.IP, boundPort uint16, ephemeralPubKey [crypto.KeySize]byte, e2eFlags uint8) error
 
 	// WriteStreamOpenErr sends a failed open acknowledgment.
 	WriteStreamOpenErr(peerID identity.AgentID, streamID uint64, requestID uint64, errorCode uint16, message string) error
 
 	// WriteStreamClose sends a close frame.
 	WriteStreamClose(peerID identity.AgentID, streamID uint64) error
+
+	// WriteStreamReset aborts a stream with an error code.
+	WriteStreamReset(peerID identity.AgentID, streamID uint64, errorCode uint16) error
+
+	// MaxStreamPlaintext returns the largest plaintext that encrypts into
+	// one frame on the link to a peer.
+	MaxStreamPlaintext(peerID identity.AgentID) int
 }
 
+// Release states of an ActiveConnection. A stream that ends cleanly hands its
+// destination socket to the read loop, which returns it to the pool once it
+// has stopped reading. Whichever side moves first decides who owns the socket.
+const (
+	connActive     int32 = iota
+	connReleased         // Stream finished; read loop pools the socket
+	connReaderDone       // Read loop exited; the socket cannot be pooled
+)
+
 // ActiveConnection represents an active exit connection.
 type ActiveConnection struct {
 	StreamID   uint64
 	RemoteID   identity.AgentID
 	DestAddr   string
 	DestPort   uint16
+	Family     string // Address family of the destination socket (FamilyIPv4 or FamilyIPv6)
+	DialedAddr string // Destination IP:port actually connected to
+	User       string // SOCKS5 account propagated by the ingress, if any
+	Client     string // Client IP:port propagated by the ingress, if any
 	Conn       net.Conn
 	StartedAt  time.Time
 	closed     atomic.Bool
 	closeOnce  sync.Once
 	sessionKey *crypto.SessionKey // E2E encryption session key
+
+	pathMaxPayload int // Payload limit of the path to the ingress (0 if unknown)
+
+	// Pooling state (poolKey is empty when the destination is not poolable)
+	poolKey      string
+	releaseState atomic.Int32
+	lastFromDest atomic.Bool // Most recent data flowed from the destination
+	writeClosed  atomic.Bool // Destination write side was half-closed
+	readClosed   atomic.Bool // Destination finished sending (EOF)
+
+	// Forced cleanup of a half-closed connection (see HalfCloseConfig)
+	lingerMu sync.Mutex
+	linger   *time.Timer
+
+	// Traffic counters (encrypted bytes as seen on the mesh side)
+	BytesSent    atomic.Uint64 // Sent toward the ingress (destination -> mesh)
+	BytesRecv    atomic.Uint64 // Received from the ingress (mesh -> destination)
+	FramesSent   atomic.Uint64 // STREAM_DATA frames sent toward the ingress
+	FramesRecv   atomic.Uint64 // STREAM_DATA frames received from the ingress
+	lastActivity atomic.Int64  // UnixNano of last data in either direction
+
+	// Latency, measured from the arrival of STREAM_OPEN
+	ConnectLatency time.Duration // Until the destination socket was ready (resolve and dial)
+	firstByteAt    atomic.Int64  // UnixNano of the first byte read from the destination
+
+	dest        *destEntry // Per-destination accounting (nil when disabled)
+	releasePort func()     // Releases the connection's port class slot
+
+	mw *middleware.Stream // Stream passed to middleware (nil without middleware)
+
+	claim *openClaim // Open token held by the stream (nil without a token)
+}
+
+// LastActivity returns the time data last moved through the connection.
+func (ac *ActiveConnection) LastActivity() time.Time {
+	if last := ac.lastActivity.Load(); last != 0 {
+		return time.Unix(0, last)
+	}
+	return ac.StartedAt
+}
+
+// FirstByteLatency returns the time from the arrival of STREAM_OPEN until
+// the destination sent its first byte, or 0 if it has not.
+func (ac *ActiveConnection) FirstByteLatency() time.Duration {
+	at := ac.firstByteAt.Load()
+	if at == 0 {
+		return 0
+	}
+	return time.Unix(0, at).Sub(ac.StartedAt) + ac.ConnectLatency
 }
 
 // Close closes the connection.
@@ -94,6 +214,7 @@ func (ac *ActiveConnection) Close() error {
 	var err error
 	ac.closeOnce.Do(func() {
 		ac.closed.Store(true)
+		ac.stopLinger()
 		if ac.Conn != nil {
 			err = ac.Conn.Close()
 		}
@@ -111,12 +232,17 @@ type Handler struct {
 	cfg      HandlerConfig
 	localID  identity.AgentID
 	resolver *Resolver
+	pool     *connPool    // nil when pooling is disabled
+	dests    *destTracker // nil when destination accounting is disabled
+	ports    *portLimiter // nil when no port class restricts anything
 	writer   StreamWriter
 	logger   *slog.Logger
 
 	mu          sync.RWMutex
 	connections map[uint64]*ActiveConnection
+	openTokens  map[protocol.OpenToken]*openClaim
 	connCount   atomic.Int64
+	halfClose   halfCloseCounters
 
 	routesMu sync.RWMutex // Guards cfg.AllowedRoutes for dynamic modification
 
@@ -133,20 +259,61 @@ func NewHandler(cfg HandlerConfig, localID identity.AgentID, writer StreamWriter
 		logger = logging.NopLogger()
 	}
 
+	var pool *connPool
+	if cfg.Pool.Enabled {
+		pool = newConnPool(cfg.Pool)
+	}
+
+	var dests *destTracker
+	if cfg.DestStats.Enabled {
+		dests = newDestTracker(cfg.DestStats, logger)
+	}
+
 	return &Handler{
 		cfg:         cfg,
 		localID:     localID,
 		resolver:    NewResolver(cfg.DNS),
+		pool:        pool,
+		dests:       dests,
+		ports:       newPortLimiter(cfg.PortLimits, logger),
 		writer:      writer,
 		logger:      logger,
 		connections: make(map[uint64]*ActiveConnection),
+		openTokens:  make(map[protocol.OpenToken]*openClaim),
 		stopCh:      make(chan struct{}),
 	}
 }
 
 // Start starts the exit handler.
 func (h *Handler) Start() {
-	h.running.Store(true)
+	if h.running.Swap(true) || h.pool == nil {
+		return
+	}
+
+	h.wg.Add(1)
+	go func() {
+		defer h.wg.Done()
+		h.pruneLoop()
+	}()
+}
+
+// pruneLoop periodically closes pooled sockets past their idle timeout.
+func (h *Handler) pruneLoop() {
+	interval := h.pool.cfg.IdleTimeout / 2
+	if interval < time.Second {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-h.stopCh:
+			return
+		case <-ticker.C:
+			h.pool.prune()
+		}
+	}
 }
 
 // Stop stops the exit handler.
@@ -161,9 +328,17 @@ func (h *Handler) Stop() {
 			conn.Close()
 		}
 		h.connections = make(map[uint64]*ActiveConnection)
+		for _, claim := range h.openTokens {
+			claim.cancel()
+		}
+		h.openTokens = make(map[protocol.OpenToken]*openClaim)
 		h.mu.Unlock()
 
 		h.wg.Wait()
+
+		if h.pool != nil {
+			h.pool.closeAll()
+		}
 	})
 }
 
@@ -179,6 +354,47 @@ func (h *Handler) HandleStreamOpen(ctx context.Context, streamID uint64, request
 		return fmt.Errorf("handler not running")
 	}
 
+	// Claim the open token. A later attempt of the same dial over another
+	// path replaces the stream that held it, and a repeated open is
+	// dropped, so one client dial never ends up with two destination
+	// connections.
+	var claim *openClaim
+	if token, attempt := OpenTokenFromContext(ctx); !token.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithCancel(ctx)
+		var prev *openClaim
+		var duplicate bool
+		var err error
+		claim, prev, duplicate, err = h.claimOpen(token, attempt, remoteID, streamID, cancel)
+		if duplicate {
+			cancel()
+			h.logger.Debug("ignoring duplicate stream open",
+				logging.KeyStreamID, streamID,
+				logging.KeyPeerID, remoteID.ShortString(),
+				"open_token", token.String())
+			return nil
+		}
+		if err != nil {
+			cancel()
+			h.logger.Debug("refusing stream open",
+				logging.KeyStreamID, streamID,
+				logging.KeyPeerID, remoteID.ShortString(),
+				"open_token", token.String(),
+				logging.KeyError, err)
+			h.sendOpenErr(remoteID, streamID, requestID, protocol.ErrStreamSuperseded, err.Error())
+			return err
+		}
+		if prev != nil {
+			h.supersede(prev)
+		}
+	}
+	started := false
+	defer func() {
+		if !started {
+			h.releaseOpen(claim)
+		}
+	}()
+
 	// Check connection limit
 	if h.cfg.MaxConnections > 0 && h.connCount.Load() >= int64(h.cfg.MaxConnections) {
 		h.sendOpenErr(remoteID, streamID, requestID, protocol.ErrConnectionLimit, "connection limit exceeded")
@@ -192,32 +408,125 @@ func (h *Handler) HandleStreamOpen(ctx context.Context, streamID uint64, request
 		domainAllowed = h.isDomainAllowed(destAddr)
 	}
 
+	// Account for the open and refuse destinations over their threshold
+	var dest *destEntry
+	if h.dests != nil {
+		if h.dests.isBlocked(destAddr) {
+			h.sendOpenErr(remoteID, streamID, requestID, protocol.ErrNotAllowed, "destination temporarily blocked")
+			return fmt.Errorf("destination %s temporarily blocked", destAddr)
+		}
+		var blocked bool
+		if dest, blocked = h.dests.recordOpen(destAddr); blocked {
+			h.closeDestination(dest)
+			h.sendOpenErr(remoteID, streamID, requestID, protocol.ErrNotAllowed, "destination temporarily blocked")
+			return fmt.Errorf("destination %s temporarily blocked", destAddr)
+		}
+	}
+
 	// Perform the rest asynchronously to avoid blocking the frame processing loop.
 	// TCP dials to filtered ports can take 20+ seconds to timeout.
 	h.wg.Add(1)
+	started = true
 	go func() {
 		defer h.wg.Done()
-		h.handleStreamOpenAsync(ctx, streamID, requestID, remoteID, destAddr, destPort, remoteEphemeralPub, domainAllowed)
+		h.handleStreamOpenAsync(ctx, streamID, requestID, remoteID, destAddr, destPort, remoteEphemeralPub, domainAllowed, dest, claim)
 	}()
 
 	return nil
 }
 
 // handleStreamOpenAsync performs the actual stream open work asynchronously.
-func (h *Handler) handleStreamOpenAsync(ctx context.Context, streamID uint64, requestID uint64, remoteID identity.AgentID, destAddr string, destPort uint16, remoteEphemeralPub [crypto.KeySize]byte, domainAllowed bool) {
-	// Resolve address
-	ip, err := h.resolver.Resolve(ctx, destAddr)
+func (h *Handler) handleStreamOpenAsync(ctx context.Context, streamID uint64, requestID uint64, remoteID identity.AgentID, destAddr string, destPort uint16, remoteEphemeralPub [crypto.KeySize]byte, domainAllowed bool, dest *destEntry, claim *openClaim) {
+	requested := time.Now()
+	tracked := false
+	defer func() {
+		if !tracked {
+			h.releaseOpen(claim)
+		}
+	}()
+
+	// Resolve address (all A and AAAA records for domains)
+	ips, err := h.resolver.ResolveAll(ctx, destAddr)
 	if err != nil {
 		h.sendOpenErr(remoteID, streamID, requestID, protocol.ErrHostUnreachable, err.Error())
 		return
 	}
 
-	// Check if destination is allowed (domain patterns OR CIDR routes)
-	if !domainAllowed && !h.isAllowed(ip) {
-		h.sendOpenErr(remoteID, streamID, requestID, protocol.ErrNotAllowed, "destination not allowed")
+	// Check if destination is allowed (domain patterns OR CIDR routes).
+	// Without a domain match, only addresses inside CIDR routes are dialed.
+	if !domainAllowed {
+		ips = h.filterAllowed(ips)
+		if len(ips) == 0 {
+			h.sendOpenErr(remoteID, streamID, requestID, protocol.ErrNotAllowed, "destination not allowed")
+			return
+		}
+	}
+
+	// Refuse private destinations, including domains that resolve into
+	// the exit's own network
+	if ips = h.cfg.Private.Filter(ips); len(ips) == 0 {
+		h.sendOpenErr(remoteID, streamID, requestID, protocol.ErrPrivateDestination, "destination in private range not allowed")
 		return
 	}
 
+	// Narrow the destinations to those of the propagated user
+	user := UserFromContext(ctx)
+	var client string
+	if addr := proxyproto.ClientAddrFromContext(ctx); addr != nil {
+		client = addr.String()
+	}
+	if ips = h.cfg.UserPolicy.Filter(user, destAddr, ips); len(ips) == 0 {
+		if h.cfg.AuditLog {
+			h.logger.Info("exit stream refused by user policy",
+				logging.KeyStreamID, streamID,
+				logging.KeyPeerID, remoteID.ShortString(),
+				"user", user,
+				"client", client,
+				"destination", net.JoinHostPort(destAddr, strconv.Itoa(int(destPort))))
+		}
+		h.sendOpenErr(remoteID, streamID, requestID, protocol.ErrNotAllowed, "destination not allowed for user")
+		return
+	}
+
+	// Refuse or cap connections per destination port class
+	releasePort, err := h.AdmitPort(destPort, destAddr)
+	if err != nil {
+		h.sendOpenErr(remoteID, streamID, requestID, protocol.ErrNotAllowed, err.Error())
+		return
+	}
+	defer func() {
+		if !tracked {
+			releasePort()
+		}
+	}()
+
+	// Let middleware refuse the stream before anything is dialed. Streams
+	// it accepted are closed with it when they end, or when opening fails.
+	var mw *middleware.Stream
+	if len(h.cfg.Middleware) > 0 {
+		mw = &middleware.Stream{
+			Side:        middleware.Exit,
+			ID:          streamID,
+			Peer:        remoteID,
+			Network:     "tcp",
+			Destination: net.JoinHostPort(destAddr, strconv.Itoa(int(destPort))),
+			User:        user,
+			StartedAt:   requested,
+		}
+		if addr := proxyproto.ClientAddrFromContext(ctx); addr != nil {
+			mw.Client = addr
+		}
+		if err := h.cfg.Middleware.OpenStream(ctx, mw); err != nil {
+			h.sendOpenErr(remoteID, streamID, requestID, protocol.ErrNotAllowed, err.Error())
+			return
+		}
+		defer func() {
+			if !tracked {
+				h.cfg.Middleware.CloseStream(mw)
+			}
+		}()
+	}
+
 	// Generate ephemeral keypair for E2E encryption key exchange
 	ephPriv, ephPub, err := crypto.GenerateEphemeralKeypair()
 	if err != nil {
@@ -233,46 +542,107 @@ func (h *Handler) handleStreamOpenAsync(ctx context.Context, streamID uint64, re
 		return
 	}
 
-	// Zero out ephemeral private key after computing shared secret
-	crypto.ZeroKey(&ephPriv)
-
 	// Derive session key - we are the responder (exit node)
 	// Use requestID (not streamID) because streamID changes at each relay hop
 	sessionKey := crypto.DeriveSessionKey(sharedSecret, requestID, remoteEphemeralPub, ephPub, false)
 	crypto.ZeroKey(&sharedSecret)
 
-	// Connect to destination
-	addr := fmt.Sprintf("%s:%d", ip.String(), destPort)
-	dialer := &net.Dialer{Timeout: h.cfg.ConnectTimeout}
+	var e2eFlags uint8
+	if crypto.RekeyFromContext(ctx) && h.cfg.Rekey.Enabled() {
+		sessionKey.EnableRekey(ephPriv, remoteEphemeralPub, h.cfg.Rekey)
+		e2eFlags = protocol.E2EFlagRekey
+	}
 
-	conn, err := dialer.DialContext(ctx, "tcp", addr)
-	if err != nil {
-		errorCode := h.mapDialError(err)
-		h.sendOpenErr(remoteID, streamID, requestID, errorCode, err.Error())
-		return
+	// Zero out ephemeral private key after deriving the session key
+	crypto.ZeroKey(&ephPriv)
+
+	// Connect to destination, reusing an idle pooled socket when possible.
+	// Sockets that start with a PROXY header belong to one client, so they
+	// are never pooled.
+	poolKey := h.poolKey(destAddr, destPort)
+	if slices.ContainsFunc(ips, h.cfg.ProxyProtocol.Covers) {
+		poolKey = ""
+	}
+	var conn net.Conn
+	if poolKey != "" {
+		conn = h.pool.get(poolKey)
+		if conn != nil {
+			h.logger.Debug("reusing pooled exit connection",
+				logging.KeyStreamID, streamID,
+				logging.KeyAddress, poolKey)
+		}
+	}
+	if conn == nil {
+		conn, err = h.dial(ctx, ips, destPort)
+		if err != nil {
+			errorCode := h.mapDialError(err)
+			h.sendOpenErr(remoteID, streamID, requestID, errorCode, err.Error())
+			return
+		}
+		if err := h.cfg.ProxyProtocol.WriteHeader(ctx, conn); err != nil {
+			conn.Close()
+			h.sendOpenErr(remoteID, streamID, requestID, protocol.ErrGeneralFailure, "write PROXY header: "+err.Error())
+			return
+		}
 	}
 
 	// Get local address for ACK
 	localAddr := conn.LocalAddr().(*net.TCPAddr)
 
 	// Track connection with session key
+	now := time.Now()
 	ac := &ActiveConnection{
 		StreamID:   streamID,
 		RemoteID:   remoteID,
 		DestAddr:   destAddr,
 		DestPort:   destPort,
 		Conn:       conn,
-		StartedAt:  time.Now(),
+		Family:     addressFamily(conn.RemoteAddr()),
+		DialedAddr: conn.RemoteAddr().String(),
+		User:       user,
+		Client:     client,
+		StartedAt:  now,
 		sessionKey: sessionKey,
+		poolKey:    poolKey,
+		dest:       dest,
+		mw:         mw,
+
+		releasePort: releasePort,
+		claim:       claim,
+
+		pathMaxPayload: protocol.PathMaxPayloadFromContext(ctx),
+
+		ConnectLatency: now.Sub(requested),
 	}
 
 	h.mu.Lock()
+	if !h.ownsOpen(claim) {
+		// A retry of this open arrived while it was dialing
+		h.mu.Unlock()
+		conn.Close()
+		h.sendOpenErr(remoteID, streamID, requestID, protocol.ErrStreamSuperseded, "superseded by a retried open")
+		return
+	}
 	h.connections[streamID] = ac
 	h.connCount.Add(1)
 	h.mu.Unlock()
+	tracked = true
+
+	if h.cfg.OnConnOpen != nil {
+		h.cfg.OnConnOpen(ac)
+	}
+	if h.cfg.AuditLog {
+		h.logger.Info("exit stream opened",
+			logging.KeyStreamID, streamID,
+			logging.KeyPeerID, remoteID.ShortString(),
+			"user", user,
+			"client", client,
+			"destination", net.JoinHostPort(destAddr, strconv.Itoa(int(destPort))),
+			"dialed", ac.DialedAddr)
+	}
 
 	// Send ACK with our ephemeral public key
-	if err := h.writer.WriteStreamOpenAck(remoteID, streamID, requestID, localAddr.IP, uint16(localAddr.Port), ephPub); err != nil {
+	if err := h.writer.WriteStreamOpenAck(remoteID, streamID, requestID, localAddr.IP, uint16(localAddr.Port), ephPub, e2eFlags); err != nil {
 		ac.Close()
 		h.removeConnection(streamID)
 		return
@@ -309,21 +679,41 @@ func (h *Handler) HandleStreamData(peerID identity.AgentID, streamID uint64, dat
 
 		plaintext, err := ac.sessionKey.Decrypt(data)
 		if err != nil {
-			h.closeConnection(streamID, peerID, err)
+			h.resetConnection(streamID, peerID, err)
 			return fmt.Errorf("decrypt: %w", err)
 		}
+		if ac.mw != nil {
+			if plaintext, err = h.cfg.Middleware.StreamData(ac.mw, middleware.ToDestination, plaintext); err != nil {
+				h.rejectData(streamID, peerID, err)
+				return fmt.Errorf("middleware: %w", err)
+			}
+		}
 
 		if _, err := ac.Conn.Write(plaintext); err != nil {
 			h.closeConnection(streamID, peerID, err)
 			return err
 		}
+		ac.BytesRecv.Add(uint64(len(data)))
+		ac.FramesRecv.Add(1)
+		ac.lastActivity.Store(time.Now().UnixNano())
+		ac.lastFromDest.Store(false)
+		if ac.dest != nil && h.dests.recordBytes(ac.dest, uint64(len(plaintext)), 0) {
+			h.closeDestination(ac.dest)
+			return nil
+		}
 	}
 
 	// Handle FIN flag
 	if flags&protocol.FlagFinWrite != 0 {
+		// A client finishing after the destination answered leaves the
+		// socket idle, so it can be pooled instead of half-closed
+		if h.releaseConnection(ac, true) {
+			return nil
+		}
+
 		// Client is done sending, close write side of destination
 		if tcpConn, ok := ac.Conn.(*net.TCPConn); ok {
-			tcpConn.CloseWrite()
+			h.clientFinished(ac, tcpConn)
 		}
 	}
 
@@ -332,9 +722,60 @@ func (h *Handler) HandleStreamData(peerID identity.AgentID, streamID uint64, dat
 
 // HandleStreamClose processes a stream close request.
 func (h *Handler) HandleStreamClose(peerID identity.AgentID, streamID uint64) {
+	if ac := h.GetConnection(streamID); ac != nil && h.releaseConnection(ac, false) {
+		return
+	}
 	h.closeConnection(streamID, peerID, nil)
 }
 
+// poolKey returns the pool key for a destination, or "" if connections to
+// it are not pooled.
+func (h *Handler) poolKey(destAddr string, destPort uint16) string {
+	if h.pool == nil || !h.pool.allowsPort(destPort) {
+		return ""
+	}
+	return net.JoinHostPort(destAddr, strconv.Itoa(int(destPort)))
+}
+
+// releaseConnection ends a stream whose destination socket is idle and hands
+// the socket to the read loop for pooling. Returns false if the connection
+// is not eligible, leaving it untouched. When finish is set, FIN_WRITE is
+// sent to the ingress before the stream is closed.
+func (h *Handler) releaseConnection(ac *ActiveConnection, finish bool) bool {
+	if h.pool == nil || ac.poolKey == "" || !ac.lastFromDest.Load() || ac.writeClosed.Load() || ac.readClosed.Load() {
+		return false
+	}
+	if h.removeConnection(ac.StreamID) == nil {
+		// Already being closed elsewhere
+		return true
+	}
+
+	if ac.releaseState.CompareAndSwap(connActive, connReleased) {
+		// Wake the read loop so it can return the socket to the pool
+		ac.Conn.SetReadDeadline(time.Now())
+	} else {
+		// Read loop already exited (destination closed or failed)
+		ac.Close()
+	}
+
+	if h.writer != nil {
+		if finish {
+			h.writer.WriteStreamData(ac.RemoteID, ac.StreamID, nil, protocol.FlagFinWrite)
+		}
+		h.writer.WriteStreamClose(ac.RemoteID, ac.StreamID)
+	}
+	return true
+}
+
+// recycle returns a released destination socket to the pool, closing it
+// instead when it is no longer clean or the pool is full.
+func (h *Handler) recycle(ac *ActiveConnection, reusable bool) {
+	if reusable && h.pool.put(ac.poolKey, ac.Conn) {
+		return
+	}
+	ac.Close()
+}
+
 // HandleStreamReset processes a stream reset request.
 func (h *Handler) HandleStreamReset(peerID identity.AgentID, streamID uint64, errorCode uint16) {
 	h.closeConnection(streamID, peerID, fmt.Errorf("reset with code %d", errorCode))
@@ -342,13 +783,26 @@ func (h *Handler) HandleStreamReset(peerID identity.AgentID, streamID uint64, er
 
 // readLoop reads data from the destination and forwards to the stream.
 func (h *Handler) readLoop(ac *ActiveConnection) {
-	defer h.closeConnection(ac.StreamID, ac.RemoteID, nil)
+	reusable := false
+	lingering := false
+	defer func() {
+		if !ac.releaseState.CompareAndSwap(connActive, connReaderDone) {
+			h.recycle(ac, reusable)
+			return
+		}
+		if lingering {
+			// The client may still send; the stream closes when it
+			// finishes or at the destination linger
+			return
+		}
+		h.closeConnection(ac.StreamID, ac.RemoteID, nil)
+	}()
 	defer recovery.RecoverWithLog(h.logger, "exit.readLoop")
 
 	// Account for encryption overhead when reading
-	// Each encrypted message must fit in a single frame to be decryptable
-	maxPlaintext := protocol.MaxPayloadSize - crypto.EncryptionOverhead
-	buf := make([]byte, maxPlaintext)
+	// Each encrypted message must fit in a single frame on every link to the
+	// ingress to be decryptable
+	buf := make([]byte, h.maxPlaintext(ac))
 	for {
 		select {
 		case <-h.stopCh:
@@ -360,9 +814,21 @@ func (h *Handler) readLoop(ac *ActiveConnection) {
 		if h.cfg.IdleTimeout > 0 {
 			ac.Conn.SetReadDeadline(time.Now().Add(h.cfg.IdleTimeout))
 		}
+		// Checked after the deadline is set so a release cannot be missed
+		if ac.releaseState.Load() == connReleased {
+			reusable = true
+			return
+		}
 
 		n, err := ac.Conn.Read(buf)
+		if ac.releaseState.Load() == connReleased {
+			// Only a wake-up with no data leaves the socket clean
+			reusable = n == 0 && errors.Is(err, os.ErrDeadlineExceeded)
+			return
+		}
 		if n > 0 {
+			ac.firstByteAt.CompareAndSwap(0, time.Now().UnixNano())
+
 			// Encrypt data before forwarding
 			if ac.sessionKey == nil {
 				h.logger.Error("no session key in readLoop",
@@ -370,16 +836,39 @@ func (h *Handler) readLoop(ac *ActiveConnection) {
 				return
 			}
 
-			ciphertext, encErr := ac.sessionKey.Encrypt(buf[:n])
-			if encErr != nil {
-				h.logger.Error("encrypt failed in readLoop",
-					logging.KeyStreamID, ac.StreamID,
-					logging.KeyError, encErr)
-				return
+			data := buf[:n]
+			if ac.mw != nil {
+				var mwErr error
+				if data, mwErr = h.cfg.Middleware.StreamData(ac.mw, middleware.FromDestination, data); mwErr != nil {
+					h.rejectData(ac.StreamID, ac.RemoteID, mwErr)
+					return
+				}
 			}
 
-			// Forward encrypted data to stream
-			if writeErr := h.writer.WriteStreamData(ac.RemoteID, ac.StreamID, ciphertext, 0); writeErr != nil {
+			// Middleware may return more than one frame holds
+			for len(data) > 0 {
+				chunk := data[:min(len(data), len(buf))]
+				data = data[len(chunk):]
+
+				ciphertext, encErr := ac.sessionKey.Encrypt(chunk)
+				if encErr != nil {
+					h.logger.Error("encrypt failed in readLoop",
+						logging.KeyStreamID, ac.StreamID,
+						logging.KeyError, encErr)
+					return
+				}
+
+				// Forward encrypted data to stream
+				if writeErr := h.writer.WriteStreamData(ac.RemoteID, ac.StreamID, ciphertext, 0); writeErr != nil {
+					return
+				}
+				ac.BytesSent.Add(uint64(len(ciphertext)))
+				ac.FramesSent.Add(1)
+			}
+			ac.lastActivity.Store(time.Now().UnixNano())
+			ac.lastFromDest.Store(true)
+			if ac.dest != nil && h.dests.recordBytes(ac.dest, 0, uint64(n)) {
+				h.closeDestination(ac.dest)
 				return
 			}
 		}
@@ -388,6 +877,7 @@ func (h *Handler) readLoop(ac *ActiveConnection) {
 			if err == io.EOF {
 				// Send FIN_WRITE (no data to encrypt)
 				h.writer.WriteStreamData(ac.RemoteID, ac.StreamID, nil, protocol.FlagFinWrite)
+				lingering = h.destinationFinished(ac)
 			}
 			return
 		}
@@ -409,21 +899,141 @@ func (h *Handler) closeConnection(streamID uint64, peerID identity.AgentID, err
 	}
 }
 
+// resetConnection aborts a stream whose data failed E2E validation, telling
+// the ingress with ErrE2EValidation.
+func (h *Handler) resetConnection(streamID uint64, peerID identity.AgentID, err error) {
+	ac := h.AbortConnection(streamID)
+	if ac == nil {
+		return
+	}
+
+	h.logger.Warn("stream data failed E2E validation, stream reset",
+		logging.KeyStreamID, streamID,
+		logging.KeyError, err)
+
+	if h.writer != nil {
+		h.writer.WriteStreamReset(peerID, streamID, protocol.ErrE2EValidation)
+	}
+}
+
+// rejectData resets a stream whose data middleware refused.
+func (h *Handler) rejectData(streamID uint64, peerID identity.AgentID, err error) {
+	if h.AbortConnection(streamID) == nil {
+		return
+	}
+
+	h.logger.Debug("stream data refused by middleware, stream reset",
+		logging.KeyStreamID, streamID,
+		logging.KeyError, err)
+
+	if h.writer != nil {
+		h.writer.WriteStreamReset(peerID, streamID, protocol.ErrNotAllowed)
+	}
+}
+
+// closeDestination closes every active connection to a destination that
+// has just been blocked.
+func (h *Handler) closeDestination(dest *destEntry) {
+	h.mu.RLock()
+	var conns []*ActiveConnection
+	for _, ac := range h.connections {
+		if ac.dest != nil && ac.dest.dest == dest.dest {
+			conns = append(conns, ac)
+		}
+	}
+	h.mu.RUnlock()
+
+	for _, ac := range conns {
+		h.closeConnection(ac.StreamID, ac.RemoteID, fmt.Errorf("destination blocked"))
+	}
+	if len(conns) > 0 {
+		h.logger.Warn("closed connections to blocked exit destination",
+			"destination", dest.dest,
+			"count", len(conns))
+	}
+}
+
+// maxPlaintext returns the largest plaintext that encrypts into one frame
+// on the link to the connection's peer and, when known, on the rest of the
+// path to the ingress.
+func (h *Handler) maxPlaintext(ac *ActiveConnection) int {
+	n := h.writer.MaxStreamPlaintext(ac.RemoteID)
+	if ac.pathMaxPayload > 0 {
+		n = min(n, ac.pathMaxPayload-crypto.MaxEncryptionOverhead)
+	}
+	return n
+}
+
 // removeConnection removes a connection from tracking.
 func (h *Handler) removeConnection(streamID uint64) *ActiveConnection {
 	h.mu.Lock()
-	defer h.mu.Unlock()
-
 	ac, ok := h.connections[streamID]
 	if !ok {
+		h.mu.Unlock()
 		return nil
 	}
 
 	delete(h.connections, streamID)
 	h.connCount.Add(-1)
+	if ac.claim != nil && h.openTokens[ac.claim.token] == ac.claim {
+		delete(h.openTokens, ac.claim.token)
+	}
+	h.mu.Unlock()
+
+	if ac.claim != nil {
+		ac.claim.cancel()
+	}
+	if h.cfg.OnConnClose != nil {
+		h.cfg.OnConnClose(ac)
+	}
+	if ac.mw != nil {
+		h.cfg.Middleware.CloseStream(ac.mw)
+	}
+	if ac.releasePort != nil {
+		ac.releasePort()
+	}
+	if h.cfg.AuditLog {
+		h.logger.Info("exit stream closed",
+			logging.KeyStreamID, streamID,
+			logging.KeyPeerID, ac.RemoteID.ShortString(),
+			"user", ac.User,
+			"client", ac.Client,
+			"destination", net.JoinHostPort(ac.DestAddr, strconv.Itoa(int(ac.DestPort))),
+			"bytes_sent", ac.BytesSent.Load(),
+			"bytes_recv", ac.BytesRecv.Load(),
+			logging.KeyDuration, time.Since(ac.StartedAt))
+	}
 	return ac
 }
 
+// filterAllowed returns the addresses permitted by the configured routes.
+func (h *Handler) filterAllowed(ips []net.IP) []net.IP {
+	var allowed []net.IP
+	for _, ip := range ips {
+		if h.isAllowed(ip) {
+			allowed = append(allowed, ip)
+		}
+	}
+	return allowed
+}
+
+// dial connects to the destination. When Happy Eyeballs is enabled and the
+// destination has addresses in both families, IPv6 and IPv4 attempts are
+// raced per RFC 8305. Otherwise the first IPv4 address is dialed.
+func (h *Handler) dial(ctx context.Context, ips []net.IP, port uint16) (net.Conn, error) {
+	if h.cfg.HappyEyeballs.Enabled && hasBothFamilies(ips) {
+		return dialParallel(ctx, h.dialer, sortAddresses(ips), port, h.cfg.HappyEyeballs.Delay)
+	}
+	ip := preferIPv4(ips)
+	addr := net.JoinHostPort(ip.String(), strconv.Itoa(int(port)))
+	return h.dialer(ip).DialContext(ctx, "tcp", addr)
+}
+
+// dialer returns the dialer for dest, applying the configured source bind.
+func (h *Handler) dialer(dest net.IP) *net.Dialer {
+	return h.cfg.Bind.For(dest).Dialer(dest, h.cfg.ConnectTimeout)
+}
+
 // isAllowed checks if an IP is allowed by the configured routes.
 // Security: Returns false (deny) when no routes are configured.
 func (h *Handler) isAllowed(ip net.IP) bool {
@@ -466,6 +1076,38 @@ func (h *Handler) RemoveAllowedRoute(network *net.IPNet) bool {
 	return false
 }
 
+// AdmitPort checks a new connection to a destination port against the port
+// classes. On success the returned function must be called when the
+// connection closes.
+func (h *Handler) AdmitPort(port uint16, dest string) (release func(), err error) {
+	if h.ports == nil || !h.PortLimitsActive() {
+		return func() {}, nil
+	}
+	return h.ports.admit(port, dest)
+}
+
+// PortLimitsActive reports whether the port classes restrict connections.
+// With DefaultRouteOnly, they do while a default route is allowed.
+func (h *Handler) PortLimitsActive() bool {
+	if h.ports == nil {
+		return false
+	}
+	if !h.cfg.PortLimits.DefaultRouteOnly {
+		return true
+	}
+	h.routesMu.RLock()
+	defer h.routesMu.RUnlock()
+	return slices.ContainsFunc(h.cfg.AllowedRoutes, isDefaultRoute)
+}
+
+// PortLimitStats returns the restricting port classes and their counters.
+func (h *Handler) PortLimitStats() []PortClassStat {
+	if h.ports == nil {
+		return nil
+	}
+	return h.ports.stats()
+}
+
 // AllowedRouteCount returns the number of allowed routes.
 func (h *Handler) AllowedRouteCount() int {
 	h.routesMu.RLock()
@@ -475,13 +1117,18 @@ func (h *Handler) AllowedRouteCount() int {
 
 // isDomainAllowed checks if a domain matches any allowed domain pattern.
 func (h *Handler) isDomainAllowed(domain string) bool {
-	if len(h.cfg.AllowedDomains) == 0 {
+	return matchDomain(h.cfg.AllowedDomains, domain)
+}
+
+// matchDomain checks if a domain matches any of the patterns.
+func matchDomain(patterns []DomainPattern, domain string) bool {
+	if len(patterns) == 0 {
 		return false
 	}
 
 	domain = strings.ToLower(domain)
 
-	for _, dp := range h.cfg.AllowedDomains {
+	for _, dp := range patterns {
 		if dp.IsWildcard {
 			// Wildcard pattern: *.example.com matches foo.example.com (single level only)
 			// Check if domain ends with .baseDomain and has exactly one more level
@@ -506,10 +1153,9 @@ func (h *Handler) isDomainAllowed(domain string) bool {
 
 // mapDialError maps dial errors to protocol error codes.
 func (h *Handler) mapDialError(err error) uint16 {
-	if netErr, ok := err.(*net.OpError); ok {
-		if netErr.Timeout() {
-			return protocol.ErrConnectionTimeout
-		}
+	var netErr *net.OpError
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return protocol.ErrConnectionTimeout
 	}
 
 	var dnsErr *net.DNSError
@@ -517,6 +1163,13 @@ func (h *Handler) mapDialError(err error) uint16 {
 		return protocol.ErrHostUnreachable
 	}
 
+	switch {
+	case errors.Is(err, syscall.ENETUNREACH):
+		return protocol.ErrNetworkUnreachable
+	case errors.Is(err, syscall.EHOSTUNREACH):
+		return protocol.ErrHostUnreachable
+	}
+
 	return protocol.ErrConnectionRefused
 }
 
@@ -527,6 +1180,23 @@ func (h *Handler) sendOpenErr(peerID identity.AgentID, streamID, requestID uint6
 	}
 }
 
+// DNSCacheStats returns the resolver cache counters.
+func (h *Handler) DNSCacheStats() DNSCacheStats {
+	return h.resolver.CacheStats()
+}
+
+// FlushDNSCache removes a domain from the resolver cache, or every entry when
+// domain is empty. Returns the number of entries removed.
+func (h *Handler) FlushDNSCache(domain string) int {
+	if domain == "" {
+		return h.resolver.ClearCache()
+	}
+	if h.resolver.FlushDomain(domain) {
+		return 1
+	}
+	return 0
+}
+
 // ConnectionCount returns the number of active connections.
 func (h *Handler) ConnectionCount() int64 {
 	return h.connCount.Load()
@@ -539,11 +1209,85 @@ func (h *Handler) GetConnection(streamID uint64) *ActiveConnection {
 	return h.connections[streamID]
 }
 
+// Connections returns a snapshot of all active connections.
+func (h *Handler) Connections() []*ActiveConnection {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	conns := make([]*ActiveConnection, 0, len(h.connections))
+	for _, ac := range h.connections {
+		conns = append(conns, ac)
+	}
+	return conns
+}
+
+// AbortConnection removes and closes a connection without notifying the
+// remote side. The caller is responsible for signaling the peer (e.g. with
+// STREAM_RESET). Returns nil if the stream is unknown.
+func (h *Handler) AbortConnection(streamID uint64) *ActiveConnection {
+	ac := h.removeConnection(streamID)
+	if ac != nil {
+		ac.Close()
+	}
+	return ac
+}
+
+// DestStatsEnabled returns true if per-destination accounting is enabled.
+func (h *Handler) DestStatsEnabled() bool {
+	return h.dests != nil
+}
+
+// DestStatsWindow returns the accounting window, or 0 when disabled.
+func (h *Handler) DestStatsWindow() time.Duration {
+	if h.dests == nil {
+		return 0
+	}
+	return h.dests.cfg.Window
+}
+
+// DestinationStats returns up to n destinations with traffic in the current
+// window, ordered by sortBy (DestSortBytes or DestSortConnections). n <= 0
+// returns all. Returns nil when accounting is disabled.
+func (h *Handler) DestinationStats(n int, sortBy string) []DestStat {
+	if h.dests == nil {
+		return nil
+	}
+	stats := h.dests.top(n, sortBy)
+
+	active := make(map[string]int)
+	h.mu.RLock()
+	for _, ac := range h.connections {
+		if ac.dest != nil {
+			active[ac.dest.dest]++
+		}
+	}
+	h.mu.RUnlock()
+
+	for i := range stats {
+		stats[i].Active = active[stats[i].Destination]
+	}
+	return stats
+}
+
+// UnblockDestination lifts a threshold block on a destination. Returns false
+// if accounting is disabled or the destination was not blocked.
+func (h *Handler) UnblockDestination(dest string) bool {
+	if h.dests == nil {
+		return false
+	}
+	return h.dests.unblock(dest)
+}
+
 // SetWriter sets the stream writer.
 func (h *Handler) SetWriter(writer StreamWriter) {
 	h.writer = writer
 }
 
+// SetMiddleware sets the stream middleware. It must be called before Start.
+func (h *Handler) SetMiddleware(chain middleware.Chain) {
+	h.cfg.Middleware = chain
+}
+
 // ParseAllowedRoutes parses a list of CIDR strings into IPNets.
 func ParseAllowedRoutes(routes []string) ([]*net.IPNet, error) {
 	var result []*net.IPNet
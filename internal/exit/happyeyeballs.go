@@ -0,0 +1,158 @@
+package exit
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"time"
+)
+
+// DefaultHappyEyeballsDelay is the Connection Attempt Delay recommended by
+// RFC 8305: how long to wait for an attempt before starting the next one.
+const DefaultHappyEyeballsDelay = 250 * time.Millisecond
+
+// Address families reported for exit connections.
+const (
+	FamilyIPv4 = "ipv4"
+	FamilyIPv6 = "ipv6"
+)
+
+// HappyEyeballsConfig configures racing of IPv6 and IPv4 connection attempts
+// (RFC 8305) when a destination resolves to both address families.
+type HappyEyeballsConfig struct {
+	// Enabled turns on Happy Eyeballs. When disabled, the first IPv4 address
+	// is dialed (falling back to the first address if there is no IPv4).
+	Enabled bool
+
+	// Delay between starting successive connection attempts
+	// (0 = DefaultHappyEyeballsDelay)
+	Delay time.Duration
+}
+
+// DefaultHappyEyeballsConfig returns sensible defaults with Happy Eyeballs enabled.
+func DefaultHappyEyeballsConfig() HappyEyeballsConfig {
+	return HappyEyeballsConfig{
+		Enabled: true,
+		Delay:   DefaultHappyEyeballsDelay,
+	}
+}
+
+// addressFamily returns FamilyIPv4 or FamilyIPv6 for a connection address.
+func addressFamily(addr net.Addr) string {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return ""
+	}
+	if tcpAddr.IP.To4() != nil {
+		return FamilyIPv4
+	}
+	return FamilyIPv6
+}
+
+// hasBothFamilies reports whether ips contains IPv4 and IPv6 addresses.
+func hasBothFamilies(ips []net.IP) bool {
+	var v4, v6 bool
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			v4 = true
+		} else {
+			v6 = true
+		}
+	}
+	return v4 && v6
+}
+
+// sortAddresses orders addresses for Happy Eyeballs (RFC 8305 section 4):
+// families are interleaved starting with IPv6, keeping the resolver's order
+// within each family.
+func sortAddresses(ips []net.IP) []net.IP {
+	var v4, v6 []net.IP
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			v4 = append(v4, ip)
+		} else {
+			v6 = append(v6, ip)
+		}
+	}
+
+	sorted := make([]net.IP, 0, len(ips))
+	for i := 0; i < len(v4) || i < len(v6); i++ {
+		if i < len(v6) {
+			sorted = append(sorted, v6[i])
+		}
+		if i < len(v4) {
+			sorted = append(sorted, v4[i])
+		}
+	}
+	return sorted
+}
+
+// dialResult is the outcome of a single connection attempt.
+type dialResult struct {
+	conn net.Conn
+	err  error
+}
+
+// dialParallel races connection attempts to ips in order. A new attempt is
+// started when the previous one fails or after delay, whichever comes first.
+// The first successful connection is returned and the remaining attempts are
+// cancelled. If every attempt fails, the first error is returned. dialer
+// returns the dialer to use for each address.
+func dialParallel(ctx context.Context, dialer func(net.IP) *net.Dialer, ips []net.IP, port uint16, delay time.Duration) (net.Conn, error) {
+	if delay <= 0 {
+		delay = DefaultHappyEyeballsDelay
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan dialResult, len(ips))
+	portStr := strconv.Itoa(int(port))
+	next, pending := 0, 0
+	startNext := func() {
+		ip := ips[next]
+		addr := net.JoinHostPort(ip.String(), portStr)
+		next++
+		pending++
+		go func() {
+			conn, err := dialer(ip).DialContext(ctx, "tcp", addr)
+			results <- dialResult{conn: conn, err: err}
+		}()
+	}
+
+	startNext()
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	var firstErr error
+	for pending > 0 {
+		select {
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				// Close connections from attempts that succeed after cancellation
+				go func(n int) {
+					for i := 0; i < n; i++ {
+						if late := <-results; late.conn != nil {
+							late.conn.Close()
+						}
+					}
+				}(pending)
+				return r.conn, nil
+			}
+			if firstErr == nil {
+				firstErr = r.err
+			}
+			if next < len(ips) {
+				startNext()
+				timer.Reset(delay)
+			}
+		case <-timer.C:
+			if next < len(ips) {
+				startNext()
+				timer.Reset(delay)
+			}
+		}
+	}
+	return nil, firstErr
+}
@@ -0,0 +1,265 @@
+package exit
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/postalsys/muti-metroo/internal/logging"
+	"github.com/postalsys/muti-metroo/internal/recovery"
+)
+
+// HealthCheckConfig configures the exit self-health check. Every Interval
+// the exit opens a TCP connection to each target; a check passes when any
+// target connects within Timeout.
+type HealthCheckConfig struct {
+	// Targets are TCP host:port addresses, such as a public service or the
+	// upstream gateway. Hostnames are resolved with Resolver.
+	Targets []string
+
+	Interval time.Duration // Time between checks
+	Timeout  time.Duration // Connect timeout per target
+
+	// FailureThreshold is the number of consecutive failed checks before
+	// the exit is unhealthy; SuccessThreshold the number of passed checks
+	// before it is healthy again.
+	FailureThreshold int
+	SuccessThreshold int
+
+	// Bind selects the source of probe connections, as for streams.
+	Bind BindConfig
+
+	// Resolver resolves target hostnames. Nil uses the system resolver.
+	Resolver *Resolver
+
+	// OnChange is called from the check loop when the exit becomes
+	// unhealthy (false) or healthy again (true).
+	OnChange func(healthy bool)
+
+	Logger *slog.Logger
+}
+
+// HealthTargetStatus is the result of the last probe of one target.
+type HealthTargetStatus struct {
+	Target  string
+	OK      bool
+	Latency time.Duration // Connect time of a passed probe
+	Error   string        // Why the probe failed
+}
+
+// HealthCheckStatus reports the exit self-health check. Counters are
+// cumulative since the checker started.
+type HealthCheckStatus struct {
+	Healthy   bool
+	Since     time.Time // Start of the current state
+	LastCheck time.Time // Zero before the first check
+
+	ConsecutiveFailures  int
+	ConsecutiveSuccesses int
+
+	Checks      uint64 // Checks run
+	Failed      uint64 // Checks where no target connected
+	Withdrawals uint64 // Transitions to unhealthy
+
+	Targets []HealthTargetStatus
+}
+
+// HealthChecker runs the exit self-health check. The exit starts healthy,
+// so routes are advertised while the first checks run.
+type HealthChecker struct {
+	cfg    HealthCheckConfig
+	logger *slog.Logger
+
+	mu     sync.Mutex
+	status HealthCheckStatus
+
+	stopCh   chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+// NewHealthChecker creates a health checker. Call Start to begin checking.
+func NewHealthChecker(cfg HealthCheckConfig) *HealthChecker {
+	logger := cfg.Logger
+	if logger == nil {
+		logger = logging.NopLogger()
+	}
+	if cfg.FailureThreshold < 1 {
+		cfg.FailureThreshold = 1
+	}
+	if cfg.SuccessThreshold < 1 {
+		cfg.SuccessThreshold = 1
+	}
+	return &HealthChecker{
+		cfg:    cfg,
+		logger: logger,
+		status: HealthCheckStatus{Healthy: true, Since: time.Now()},
+		stopCh: make(chan struct{}),
+	}
+}
+
+// Start begins periodic checks.
+func (c *HealthChecker) Start() {
+	c.wg.Add(1)
+	go c.loop()
+}
+
+// Stop ends the checks and waits for a running check to finish.
+func (c *HealthChecker) Stop() {
+	c.stopOnce.Do(func() {
+		close(c.stopCh)
+	})
+	c.wg.Wait()
+}
+
+// Status returns the state of the check.
+func (c *HealthChecker) Status() HealthCheckStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	st := c.status
+	st.Targets = append([]HealthTargetStatus(nil), c.status.Targets...)
+	return st
+}
+
+func (c *HealthChecker) loop() {
+	defer c.wg.Done()
+	defer recovery.RecoverWithLog(c.logger, "exitHealthCheckLoop")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-c.stopCh
+		cancel()
+	}()
+
+	ticker := time.NewTicker(c.cfg.Interval)
+	defer ticker.Stop()
+
+	c.Check(ctx)
+	for {
+		select {
+		case <-c.stopCh:
+			return
+		case <-ticker.C:
+			c.Check(ctx)
+		}
+	}
+}
+
+// Check probes every target once and updates the state. Returns whether any
+// target connected.
+func (c *HealthChecker) Check(ctx context.Context) bool {
+	results := make([]HealthTargetStatus, len(c.cfg.Targets))
+	var wg sync.WaitGroup
+	for i, target := range c.cfg.Targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = c.probe(ctx, target)
+		}()
+	}
+	wg.Wait()
+
+	// A check cut short by Stop says nothing about the exit
+	if ctx.Err() != nil {
+		return false
+	}
+
+	passed := false
+	for _, r := range results {
+		if r.OK {
+			passed = true
+			break
+		}
+	}
+
+	c.mu.Lock()
+	st := &c.status
+	st.Checks++
+	st.LastCheck = time.Now()
+	st.Targets = results
+	changed := false
+	if passed {
+		st.ConsecutiveFailures = 0
+		st.ConsecutiveSuccesses++
+		if !st.Healthy && st.ConsecutiveSuccesses >= c.cfg.SuccessThreshold {
+			st.Healthy, st.Since, changed = true, st.LastCheck, true
+		}
+	} else {
+		st.Failed++
+		st.ConsecutiveSuccesses = 0
+		st.ConsecutiveFailures++
+		if st.Healthy && st.ConsecutiveFailures >= c.cfg.FailureThreshold {
+			st.Healthy, st.Since, changed = false, st.LastCheck, true
+			st.Withdrawals++
+		}
+	}
+	healthy := st.Healthy
+	c.mu.Unlock()
+
+	if !passed {
+		c.logger.Debug("exit health check failed", "targets", results)
+	}
+	if changed {
+		if healthy {
+			c.logger.Info("exit health check recovered")
+		} else {
+			c.logger.Warn("exit health check failing, no target reachable",
+				"failures", c.cfg.FailureThreshold)
+		}
+		if c.cfg.OnChange != nil {
+			c.cfg.OnChange(healthy)
+		}
+	}
+	return passed
+}
+
+// probe opens and closes one TCP connection to target.
+func (c *HealthChecker) probe(ctx context.Context, target string) HealthTargetStatus {
+	res := HealthTargetStatus{Target: target}
+
+	ctx, cancel := context.WithTimeout(ctx, c.cfg.Timeout)
+	defer cancel()
+
+	start := time.Now()
+	conn, err := c.dial(ctx, target)
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	conn.Close()
+	res.OK = true
+	res.Latency = time.Since(start)
+	return res
+}
+
+// dial connects to target from the configured source.
+func (c *HealthChecker) dial(ctx context.Context, target string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(target)
+	if err != nil {
+		return nil, err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		if c.cfg.Resolver != nil {
+			ip, err = c.cfg.Resolver.Resolve(ctx, host)
+		} else {
+			var ips []net.IP
+			ips, err = net.DefaultResolver.LookupIP(ctx, "ip", host)
+			if err == nil && len(ips) > 0 {
+				ip = ips[0]
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		if ip == nil {
+			return nil, fmt.Errorf("no address for %s", host)
+		}
+	}
+	d := c.cfg.Bind.For(ip).Dialer(ip, c.cfg.Timeout)
+	return d.DialContext(ctx, "tcp", net.JoinHostPort(ip.String(), port))
+}
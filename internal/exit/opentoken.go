@@ -0,0 +1,115 @@
+package exit
+
+import (
+	"context"
+	"errors"
+
+	"github.com/postalsys/muti-metroo/internal/identity"
+	"github.com/postalsys/muti-metroo/internal/protocol"
+)
+
+type openTokenKey struct{}
+
+// openTokenValue is the verified open token of a STREAM_OPEN.
+type openTokenValue struct {
+	token   protocol.OpenToken
+	attempt uint8
+}
+
+// WithOpenToken returns ctx carrying the open token of a STREAM_OPEN and
+// the attempt its proof shows. A token whose proof does not verify is left
+// out, so the open is handled as a stream of its own.
+func WithOpenToken(ctx context.Context, token protocol.OpenToken, proof protocol.OpenProof) context.Context {
+	if !token.Verify(proof) {
+		return ctx
+	}
+	return context.WithValue(ctx, openTokenKey{}, openTokenValue{token: token, attempt: proof.Attempt})
+}
+
+// OpenTokenFromContext returns the token and attempt stored by
+// WithOpenToken, or the zero token.
+func OpenTokenFromContext(ctx context.Context) (protocol.OpenToken, uint8) {
+	v, _ := ctx.Value(openTokenKey{}).(openTokenValue)
+	return v.token, v.attempt
+}
+
+// errOpenTokenHeld refuses an open whose token is held by a later attempt,
+// or by another stream at the same attempt: a late arrival of an abandoned
+// attempt, or a copy made by a transit agent.
+var errOpenTokenHeld = errors.New("open token held by a later attempt")
+
+// openClaim is the stream that holds an open token at this exit. A token is
+// held from the arrival of its STREAM_OPEN until the stream ends, so one
+// dial of a client owns at most one destination connection here.
+type openClaim struct {
+	token    protocol.OpenToken
+	attempt  uint8
+	remoteID identity.AgentID
+	streamID uint64
+	cancel   context.CancelFunc // Aborts the open while it is in progress
+}
+
+// claimOpen makes the stream the holder of token. duplicate reports a
+// STREAM_OPEN the stream already sent, which is dropped. When another
+// stream held the token at an earlier attempt, the ingress gave up on it
+// and retried over another path; that stream is returned so the caller can
+// end it. A holder at the same or a later attempt keeps the token and the
+// open fails with errOpenTokenHeld.
+func (h *Handler) claimOpen(token protocol.OpenToken, attempt uint8, remoteID identity.AgentID, streamID uint64, cancel context.CancelFunc) (claim, superseded *openClaim, duplicate bool, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	prev := h.openTokens[token]
+	if prev != nil {
+		if prev.remoteID == remoteID && prev.streamID == streamID {
+			return nil, nil, true, nil
+		}
+		if attempt <= prev.attempt {
+			return nil, nil, false, errOpenTokenHeld
+		}
+	}
+	claim = &openClaim{token: token, attempt: attempt, remoteID: remoteID, streamID: streamID, cancel: cancel}
+	h.openTokens[token] = claim
+	return claim, prev, false, nil
+}
+
+// ownsOpen reports whether claim still holds its token. Called with h.mu
+// held; nil claims (opens without a token) always do.
+func (h *Handler) ownsOpen(claim *openClaim) bool {
+	return claim == nil || h.openTokens[claim.token] == claim
+}
+
+// releaseOpen gives up a claim, if it still holds its token.
+func (h *Handler) releaseOpen(claim *openClaim) {
+	if claim == nil {
+		return
+	}
+	claim.cancel()
+	h.mu.Lock()
+	if h.openTokens[claim.token] == claim {
+		delete(h.openTokens, claim.token)
+	}
+	h.mu.Unlock()
+}
+
+// supersede ends the stream of a claim replaced by a retry. An open still
+// in progress is canceled and fails its ownership check; an open stream is
+// reset with ErrStreamSuperseded.
+func (h *Handler) supersede(prev *openClaim) {
+	prev.cancel()
+
+	h.mu.RLock()
+	ac := h.connections[prev.streamID]
+	h.mu.RUnlock()
+	if ac == nil || ac.claim != prev {
+		return
+	}
+	if h.AbortConnection(prev.streamID) == nil {
+		return
+	}
+	h.logger.Debug("exit stream superseded by retried open",
+		"stream_id", prev.streamID,
+		"peer_id", prev.remoteID.ShortString())
+	if h.writer != nil {
+		h.writer.WriteStreamReset(prev.remoteID, prev.streamID, protocol.ErrStreamSuperseded)
+	}
+}
@@ -0,0 +1,193 @@
+package exit
+
+import (
+	"errors"
+	"net"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+// PoolConfig configures reuse of destination connections between streams.
+//
+// A destination socket is returned to the pool when the client finishes a
+// stream after the destination answered last, which is the pattern of a
+// request/response protocol such as HTTP/1.1 with keep-alive. The next stream
+// to the same host:port takes over the idle socket instead of dialing.
+// Pooling is only safe for protocols without per-connection session state,
+// so it is limited to an explicit list of destination ports.
+type PoolConfig struct {
+	// Enabled turns on connection pooling
+	Enabled bool
+
+	// Ports lists destination ports eligible for pooling
+	Ports []uint16
+
+	// MaxIdle limits idle sockets across all destinations
+	MaxIdle int
+
+	// MaxIdlePerHost limits idle sockets per destination host:port
+	MaxIdlePerHost int
+
+	// IdleTimeout is how long an idle socket is kept before it is closed
+	IdleTimeout time.Duration
+}
+
+// DefaultPoolConfig returns sensible defaults with pooling disabled.
+func DefaultPoolConfig() PoolConfig {
+	return PoolConfig{
+		Enabled:        false,
+		Ports:          []uint16{80},
+		MaxIdle:        64,
+		MaxIdlePerHost: 4,
+		IdleTimeout:    30 * time.Second,
+	}
+}
+
+// livenessProbe is how long get waits for a pending read on an idle socket.
+// A socket the destination has closed or written to since it went idle
+// returns immediately and is discarded.
+const livenessProbe = time.Millisecond
+
+// idleConn is a pooled destination socket.
+type idleConn struct {
+	conn      net.Conn
+	idleSince time.Time
+}
+
+// connPool holds idle destination sockets keyed by host:port.
+type connPool struct {
+	cfg PoolConfig
+
+	mu     sync.Mutex
+	idle   map[string][]idleConn
+	total  int
+	closed bool
+}
+
+// newConnPool creates a pool, filling unset limits from DefaultPoolConfig.
+func newConnPool(cfg PoolConfig) *connPool {
+	defaults := DefaultPoolConfig()
+	if len(cfg.Ports) == 0 {
+		cfg.Ports = defaults.Ports
+	}
+	if cfg.MaxIdle <= 0 {
+		cfg.MaxIdle = defaults.MaxIdle
+	}
+	if cfg.MaxIdlePerHost <= 0 {
+		cfg.MaxIdlePerHost = defaults.MaxIdlePerHost
+	}
+	if cfg.IdleTimeout <= 0 {
+		cfg.IdleTimeout = defaults.IdleTimeout
+	}
+	return &connPool{
+		cfg:  cfg,
+		idle: make(map[string][]idleConn),
+	}
+}
+
+// allowsPort reports whether connections to the port may be pooled.
+func (p *connPool) allowsPort(port uint16) bool {
+	return slices.Contains(p.cfg.Ports, port)
+}
+
+// get returns a live idle socket for key, or nil if none is available.
+// The most recently used socket is tried first.
+func (p *connPool) get(key string) net.Conn {
+	for {
+		p.mu.Lock()
+		conns := p.idle[key]
+		if len(conns) == 0 {
+			p.mu.Unlock()
+			return nil
+		}
+		ic := conns[len(conns)-1]
+		p.removeLocked(key, len(conns)-1)
+		p.mu.Unlock()
+
+		if time.Since(ic.idleSince) < p.cfg.IdleTimeout && isIdleConnAlive(ic.conn) {
+			return ic.conn
+		}
+		ic.conn.Close()
+	}
+}
+
+// put adds a socket to the pool. Returns false if the pool is full or
+// closed, in which case the caller keeps ownership of conn.
+func (p *connPool) put(key string, conn net.Conn) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed || p.total >= p.cfg.MaxIdle || len(p.idle[key]) >= p.cfg.MaxIdlePerHost {
+		return false
+	}
+	p.idle[key] = append(p.idle[key], idleConn{conn: conn, idleSince: time.Now()})
+	p.total++
+	return true
+}
+
+// prune closes sockets that have been idle longer than the idle timeout.
+func (p *connPool) prune() {
+	cutoff := time.Now().Add(-p.cfg.IdleTimeout)
+
+	var expired []net.Conn
+	p.mu.Lock()
+	for key, conns := range p.idle {
+		for i := len(conns) - 1; i >= 0; i-- {
+			if conns[i].idleSince.Before(cutoff) {
+				expired = append(expired, conns[i].conn)
+				p.removeLocked(key, i)
+			}
+		}
+	}
+	p.mu.Unlock()
+
+	for _, conn := range expired {
+		conn.Close()
+	}
+}
+
+// idleCount returns the number of pooled sockets.
+func (p *connPool) idleCount() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.total
+}
+
+// closeAll closes every pooled socket and rejects further puts.
+func (p *connPool) closeAll() {
+	p.mu.Lock()
+	idle := p.idle
+	p.idle = make(map[string][]idleConn)
+	p.total = 0
+	p.closed = true
+	p.mu.Unlock()
+
+	for _, conns := range idle {
+		for _, ic := range conns {
+			ic.conn.Close()
+		}
+	}
+}
+
+// removeLocked removes the socket at index i for key. Caller holds p.mu.
+func (p *connPool) removeLocked(key string, i int) {
+	conns := slices.Delete(p.idle[key], i, i+1)
+	if len(conns) == 0 {
+		delete(p.idle, key)
+	} else {
+		p.idle[key] = conns
+	}
+	p.total--
+}
+
+// isIdleConnAlive checks that an idle socket has neither been closed by the
+// destination nor received unsolicited data.
+func isIdleConnAlive(conn net.Conn) bool {
+	var b [1]byte
+	conn.SetReadDeadline(time.Now().Add(livenessProbe))
+	n, err := conn.Read(b[:])
+	conn.SetReadDeadline(time.Time{})
+	return n == 0 && errors.Is(err, os.ErrDeadlineExceeded)
+}
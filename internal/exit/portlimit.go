@@ -0,0 +1,207 @@
+package exit
+
+import (
+	"errors"
+	"log/slog"
+	"net"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Actions of a port class.
+const (
+	PortActionBlock = "block" // Refuse every connection
+	PortActionLimit = "limit" // Cap concurrent connections and the rate of new ones
+	PortActionAllow = "allow" // No restriction
+)
+
+// SMTPPorts are the mail relay and submission ports. Open exits that let
+// clients reach them are blacklisted for spam within hours.
+var SMTPPorts = []uint16{25, 465, 587}
+
+// Errors returned when a port class refuses a connection.
+var (
+	ErrPortBlocked         = errors.New("destination port blocked")
+	ErrPortConnectionLimit = errors.New("too many connections to destination port")
+	ErrPortRateLimit       = errors.New("connection rate to destination port exceeded")
+)
+
+// portWarnInterval is how often refusals of one class are logged.
+const portWarnInterval = time.Minute
+
+// PortClass restricts connections to a set of destination ports. With
+// PortActionLimit, zero values mean no limit.
+type PortClass struct {
+	Name   string
+	Ports  []uint16
+	Action string
+
+	// MaxConnections is the number of concurrent connections to any port
+	// of the class.
+	MaxConnections int
+
+	// Rate is the number of new connections per second, with bursts of
+	// Burst (at least 1).
+	Rate  float64
+	Burst int
+}
+
+// PortLimitConfig configures destination port classes on the exit. A port
+// belongs to the first class listing it.
+type PortLimitConfig struct {
+	Classes []PortClass
+
+	// DefaultRouteOnly applies the classes only while the exit allows
+	// 0.0.0.0/0 or ::/0, the routes of an open exit.
+	DefaultRouteOnly bool
+}
+
+// PortClassStat is a snapshot of one port class.
+type PortClassStat struct {
+	Name    string
+	Ports   []uint16
+	Action  string
+	Active  int    // Connections currently open
+	Refused uint64 // Connections refused since start
+}
+
+// portClassState is the connection state of one class.
+type portClassState struct {
+	class   PortClass
+	limiter *rate.Limiter
+
+	active    int
+	refused   uint64
+	unlogged  uint64 // Refusals since the last warning
+	lastWarn  time.Time
+	lastError error
+}
+
+// portLimiter enforces PortLimitConfig. It is safe for concurrent use.
+type portLimiter struct {
+	logger *slog.Logger
+	now    func() time.Time
+
+	mu      sync.Mutex
+	classes []*portClassState
+	ports   map[uint16]*portClassState
+}
+
+// newPortLimiter creates a port limiter, or returns nil when no class
+// restricts anything.
+func newPortLimiter(cfg PortLimitConfig, logger *slog.Logger) *portLimiter {
+	l := &portLimiter{
+		logger: logger,
+		now:    time.Now,
+		ports:  make(map[uint16]*portClassState),
+	}
+	for _, class := range cfg.Classes {
+		if class.Action == PortActionAllow {
+			// Claim the ports so later classes do not restrict them
+			for _, port := range class.Ports {
+				if _, ok := l.ports[port]; !ok {
+					l.ports[port] = nil
+				}
+			}
+			continue
+		}
+		c := &portClassState{class: class}
+		if class.Action == PortActionLimit && class.Rate > 0 {
+			c.limiter = rate.NewLimiter(rate.Limit(class.Rate), max(class.Burst, 1))
+		}
+		l.classes = append(l.classes, c)
+		for _, port := range class.Ports {
+			if _, ok := l.ports[port]; !ok {
+				l.ports[port] = c
+			}
+		}
+	}
+	if len(l.classes) == 0 {
+		return nil
+	}
+	return l
+}
+
+// admit checks a new connection to port against its class. On success the
+// returned function must be called when the connection closes.
+func (l *portLimiter) admit(port uint16, dest string) (func(), error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	c := l.ports[port]
+	if c == nil {
+		return func() {}, nil
+	}
+
+	now := l.now()
+	var err error
+	switch {
+	case c.class.Action == PortActionBlock:
+		err = ErrPortBlocked
+	case c.class.MaxConnections > 0 && c.active >= c.class.MaxConnections:
+		err = ErrPortConnectionLimit
+	case c.limiter != nil && !c.limiter.AllowN(now, 1):
+		err = ErrPortRateLimit
+	}
+	if err != nil {
+		l.refuseLocked(c, port, dest, now, err)
+		return nil, err
+	}
+
+	c.active++
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mu.Lock()
+			c.active--
+			l.mu.Unlock()
+		})
+	}, nil
+}
+
+// refuseLocked counts a refusal and logs it, at most once per
+// portWarnInterval per class. Must be called with l.mu held.
+func (l *portLimiter) refuseLocked(c *portClassState, port uint16, dest string, now time.Time, reason error) {
+	c.refused++
+	c.unlogged++
+	if now.Sub(c.lastWarn) < portWarnInterval && reason == c.lastError {
+		return
+	}
+	l.logger.Warn("exit connection refused by port class",
+		"class", c.class.Name,
+		"destination", net.JoinHostPort(dest, strconv.Itoa(int(port))),
+		"reason", reason.Error(),
+		"refused", c.unlogged)
+	c.lastWarn = now
+	c.lastError = reason
+	c.unlogged = 0
+}
+
+// stats returns a snapshot of every restricting class.
+func (l *portLimiter) stats() []PortClassStat {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	stats := make([]PortClassStat, 0, len(l.classes))
+	for _, c := range l.classes {
+		stats = append(stats, PortClassStat{
+			Name:    c.class.Name,
+			Ports:   slices.Clone(c.class.Ports),
+			Action:  c.class.Action,
+			Active:  c.active,
+			Refused: c.refused,
+		})
+	}
+	return stats
+}
+
+// isDefaultRoute reports whether network covers every address of its
+// family.
+func isDefaultRoute(network *net.IPNet) bool {
+	ones, _ := network.Mask.Size()
+	return ones == 0
+}
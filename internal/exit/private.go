@@ -0,0 +1,111 @@
+package exit
+
+import "net"
+
+// privateRanges are the destinations refused by a PrivateFilter: private
+// (RFC 1918, RFC 6598 shared, IPv6 unique local), loopback, link-local,
+// multicast and unspecified addresses. Dialing 0.0.0.0 reaches the local
+// host on most systems, so it counts as loopback.
+var privateRanges = mustParseCIDRs(
+	"10.0.0.0/8",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"100.64.0.0/10",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"224.0.0.0/4",
+	"0.0.0.0/8",
+	"::1/128",
+	"::/128",
+	"fc00::/7",
+	"fe80::/10",
+	"ff00::/8",
+)
+
+// IPv6 ranges that carry an IPv4 address: NAT64 (RFC 6052) in the last 32
+// bits and 6to4 (RFC 3056) in bits 16-47. A NAT64 gateway or 6to4 relay
+// forwards them to that IPv4 address, so they are checked by it.
+var (
+	nat64Range     = mustParseCIDRs("64:ff9b::/96")[0]
+	sixToFourRange = mustParseCIDRs("2002::/16")[0]
+)
+
+// PrivateFilter keeps an exit from reaching the network it runs in. When
+// Block is set, destinations in private, loopback and link-local ranges are
+// refused unless they fall within one of the Allowed exceptions. The zero
+// value refuses nothing.
+type PrivateFilter struct {
+	Block   bool
+	Allowed []*net.IPNet
+}
+
+// Blocks reports whether the filter refuses ip. Exceptions match either
+// ip or the IPv4 address embedded in a NAT64 or 6to4 address.
+func (f PrivateFilter) Blocks(ip net.IP) bool {
+	if !f.Block || !IsPrivate(ip) {
+		return false
+	}
+	v4 := embeddedIPv4(ip)
+	for _, network := range f.Allowed {
+		if network.Contains(ip) || (v4 != nil && network.Contains(v4)) {
+			return false
+		}
+	}
+	return true
+}
+
+// Filter returns the addresses the filter does not refuse.
+func (f PrivateFilter) Filter(ips []net.IP) []net.IP {
+	if !f.Block {
+		return ips
+	}
+	var allowed []net.IP
+	for _, ip := range ips {
+		if !f.Blocks(ip) {
+			allowed = append(allowed, ip)
+		}
+	}
+	return allowed
+}
+
+// IsPrivate reports whether ip is a private, loopback, link-local,
+// multicast or unspecified address. IPv4-mapped IPv6 addresses are checked
+// as IPv4, and NAT64 and 6to4 addresses by the IPv4 address they carry.
+func IsPrivate(ip net.IP) bool {
+	if v4 := embeddedIPv4(ip); v4 != nil {
+		ip = v4
+	}
+	for _, network := range privateRanges {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// embeddedIPv4 returns the IPv4 address carried in a NAT64 or 6to4 address,
+// or nil for other addresses.
+func embeddedIPv4(ip net.IP) net.IP {
+	if ip.To4() != nil {
+		return nil
+	}
+	switch {
+	case nat64Range.Contains(ip):
+		return net.IPv4(ip[12], ip[13], ip[14], ip[15])
+	case sixToFourRange.Contains(ip):
+		return net.IPv4(ip[2], ip[3], ip[4], ip[5])
+	}
+	return nil
+}
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
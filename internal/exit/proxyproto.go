@@ -0,0 +1,37 @@
+package exit
+
+import (
+	"context"
+	"net"
+
+	"github.com/postalsys/muti-metroo/internal/proxyproto"
+)
+
+// ProxyProtocol selects the destinations whose connections start with a
+// PROXY protocol v2 header, so servers behind the exit see the client that
+// connected at the ingress. The zero value sends no headers.
+type ProxyProtocol struct {
+	Destinations []*net.IPNet
+}
+
+// Covers reports whether connections to ip get a header.
+func (p ProxyProtocol) Covers(ip net.IP) bool {
+	for _, network := range p.Destinations {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteHeader writes the header to a new connection if its destination is
+// covered. The client address is taken from ctx (see
+// proxyproto.WithClientAddr); without one the header says it is unknown.
+func (p ProxyProtocol) WriteHeader(ctx context.Context, conn net.Conn) error {
+	dst, ok := conn.RemoteAddr().(*net.TCPAddr)
+	if !ok || !p.Covers(dst.IP) {
+		return nil
+	}
+	_, err := conn.Write(proxyproto.Header(proxyproto.ClientAddrFromContext(ctx), dst))
+	return err
+}
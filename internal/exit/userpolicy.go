@@ -0,0 +1,72 @@
+package exit
+
+import (
+	"context"
+	"net"
+)
+
+type userKey struct{}
+
+// WithUser returns ctx carrying the SOCKS5 account a stream was opened by,
+// as propagated in STREAM_OPEN by the ingress.
+func WithUser(ctx context.Context, user string) context.Context {
+	if user == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, userKey{}, user)
+}
+
+// UserFromContext returns the account stored by WithUser, or "".
+func UserFromContext(ctx context.Context) string {
+	user, _ := ctx.Value(userKey{}).(string)
+	return user
+}
+
+// UserRoutes lists the destinations one user may reach through the exit.
+type UserRoutes struct {
+	Routes  []*net.IPNet
+	Domains []DomainPattern
+}
+
+// UserPolicy narrows the exit's allowed destinations per user. It applies
+// on top of the exit-wide routes and domains, never widening them. The
+// zero value allows every user everything the exit allows.
+type UserPolicy struct {
+	Enabled bool
+
+	// Users maps account names to their destinations
+	Users map[string]UserRoutes
+
+	// DefaultDeny refuses streams from users without an entry, including
+	// streams that carry no user at all
+	DefaultDeny bool
+}
+
+// Filter returns the addresses of destAddr that user may connect to. A
+// domain matching one of the user's domain patterns keeps all of its
+// addresses; otherwise only addresses inside the user's routes are kept.
+func (p UserPolicy) Filter(user, destAddr string, ips []net.IP) []net.IP {
+	if !p.Enabled {
+		return ips
+	}
+	routes, ok := p.Users[user]
+	if !ok || user == "" {
+		if p.DefaultDeny {
+			return nil
+		}
+		return ips
+	}
+	if net.ParseIP(destAddr) == nil && matchDomain(routes.Domains, destAddr) {
+		return ips
+	}
+	var allowed []net.IP
+	for _, ip := range ips {
+		for _, network := range routes.Routes {
+			if network.Contains(ip) {
+				allowed = append(allowed, ip)
+				break
+			}
+		}
+	}
+	return allowed
+}
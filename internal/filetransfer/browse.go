@@ -16,24 +16,31 @@ const (
 
 // BrowseRequest is the request payload for file browsing operations.
 type BrowseRequest struct {
-	Action    string `json:"action"`              // "list", "stat", "roots", "chmod", "delete"
-	Path      string `json:"path,omitempty"`      // Required for "list", "stat", "chmod", and "delete"
+	Action    string `json:"action"`              // "list", "stat", "roots", "chmod", "delete", "manifest", "tail"
+	Path      string `json:"path,omitempty"`      // Required for all actions except "roots"
 	Password  string `json:"password,omitempty"`  // Authentication password
-	Offset    int    `json:"offset,omitempty"`    // Pagination offset (list only)
-	Limit     int    `json:"limit,omitempty"`     // Pagination limit (list only, default 100, max 200)
+	Offset    int    `json:"offset,omitempty"`    // Pagination offset (list and manifest)
+	Limit     int    `json:"limit,omitempty"`     // Pagination limit (list: default 100, max 200; manifest: default 50, max 100)
 	Mode      string `json:"mode,omitempty"`      // Octal permission string, e.g. "0755" (chmod only)
 	Recursive bool   `json:"recursive,omitempty"` // Required for deleting non-empty directories (delete only)
+	From      int64  `json:"from,omitempty"`      // Byte offset to read from (tail only)
+	Lines     int    `json:"lines,omitempty"`     // Return the last lines instead of reading from From (tail only)
 }
 
 // BrowseResponse is the response payload for file browsing operations.
 type BrowseResponse struct {
 	Path      string      `json:"path,omitempty"`      // Echoed back for list/stat
 	Entries   []FileEntry `json:"entries,omitempty"`    // Directory entries (list only)
-	Total     int         `json:"total"`                // Total entry count before pagination (list only)
-	Truncated bool        `json:"truncated"`            // True when more entries exist beyond offset+limit
+	Total     int         `json:"total"`                // Total entry count before pagination (list/manifest)
+	Truncated bool        `json:"truncated"`            // True when more entries exist beyond this page
 	Entry     *FileEntry  `json:"entry,omitempty"`      // Single entry (stat only)
 	Roots     []string    `json:"roots,omitempty"`      // Browsable root paths (roots only)
 	Wildcard  bool        `json:"wildcard,omitempty"`   // True when allowed_paths contains "*" (roots only)
+	Manifest  []ManifestEntry `json:"manifest,omitempty"` // Recursive file listing with hashes (manifest only)
+	Data      []byte      `json:"data,omitempty"`       // File content (tail only)
+	Next      int64       `json:"next,omitempty"`       // Offset to pass as From in the next request (tail only)
+	Size      int64       `json:"size,omitempty"`       // File size (tail only)
+	Rotated   bool        `json:"rotated,omitempty"`    // File was shorter than From and is read from the start (tail only)
 	Error     string      `json:"error,omitempty"`      // Error message
 }
 
@@ -48,7 +55,7 @@ type FileEntry struct {
 	LinkTarget string `json:"link_target,omitempty"`
 }
 
-// Browse handles file browsing requests (list, stat, roots, chmod, delete).
+// Browse handles file browsing requests (list, stat, roots, chmod, delete, manifest, tail).
 func (h *StreamHandler) Browse(req *BrowseRequest) *BrowseResponse {
 	if !h.cfg.Enabled {
 		return &BrowseResponse{Error: "file transfer is disabled"}
@@ -69,6 +76,10 @@ func (h *StreamHandler) Browse(req *BrowseRequest) *BrowseResponse {
 		return h.browseChmod(req)
 	case "delete":
 		return h.browseDelete(req)
+	case "manifest":
+		return h.browseManifest(req)
+	case "tail":
+		return h.browseTail(req)
 	default:
 		return &BrowseResponse{Error: fmt.Sprintf("unknown action: %s", req.Action)}
 	}
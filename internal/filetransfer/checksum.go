@@ -0,0 +1,90 @@
+package filetransfer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strings"
+)
+
+// ErrChecksumMismatch is returned when received content does not match the
+// checksum sent by the other end of a transfer.
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// NewChecksum returns the hash used for transfer checksums (SHA-256).
+func NewChecksum() hash.Hash {
+	return sha256.New()
+}
+
+// ChecksumHex returns the current sum of h, hex encoded.
+func ChecksumHex(h hash.Hash) string {
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// VerifyChecksum compares the checksum received from the sender with the
+// one computed locally. A missing checksum counts as a mismatch, since the
+// sender promised one.
+func VerifyChecksum(expected, actual string) error {
+	if expected == "" {
+		return fmt.Errorf("%w: no checksum received", ErrChecksumMismatch)
+	}
+	if !strings.EqualFold(expected, actual) {
+		return fmt.Errorf("%w: expected %s, got %s", ErrChecksumMismatch, expected, actual)
+	}
+	return nil
+}
+
+// HashFile returns the SHA-256 (hex) of the file at path.
+func HashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer f.Close()
+
+	sum := NewChecksum()
+	if _, err := io.Copy(sum, f); err != nil {
+		return "", fmt.Errorf("failed to hash %s: %w", path, err)
+	}
+	return ChecksumHex(sum), nil
+}
+
+// HashFilePrefix feeds the first n bytes of the file at path into sum.
+// Resumed downloads use it to include the data they already have.
+func HashFilePrefix(sum hash.Hash, path string, n int64) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer f.Close()
+
+	copied, err := io.CopyN(sum, f, n)
+	if err != nil {
+		return fmt.Errorf("failed to hash %s: %w (read %d of %d bytes)", path, err, copied, n)
+	}
+	return nil
+}
+
+// checksumReader feeds everything read through it into a hash. Close is
+// passed on to the underlying reader.
+type checksumReader struct {
+	r   io.Reader
+	sum hash.Hash
+}
+
+func (c *checksumReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.sum.Write(p[:n])
+	return n, err
+}
+
+func (c *checksumReader) Close() error {
+	if closer, ok := c.r.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
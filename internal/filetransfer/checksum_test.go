@@ -0,0 +1,148 @@
+package filetransfer
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func TestVerifyChecksum(t *testing.T) {
+	want := sha256Hex([]byte("hello"))
+
+	if err := VerifyChecksum(want, want); err != nil {
+		t.Errorf("VerifyChecksum(equal) error = %v", err)
+	}
+	if err := VerifyChecksum(want, sha256Hex([]byte("hellO"))); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("VerifyChecksum(different) error = %v, want %v", err, ErrChecksumMismatch)
+	}
+	if err := VerifyChecksum("", want); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("VerifyChecksum(missing) error = %v, want %v", err, ErrChecksumMismatch)
+	}
+}
+
+func TestStreamHandler_WriteVerifiedFile(t *testing.T) {
+	h := NewStreamHandler(StreamConfig{Enabled: true})
+	content := []byte("verified content")
+
+	var compressed bytes.Buffer
+	gzw := gzip.NewWriter(&compressed)
+	gzw.Write(content)
+	gzw.Close()
+
+	t.Run("matching checksum", func(t *testing.T) {
+		destPath := filepath.Join(t.TempDir(), "file.txt")
+
+		written, err := h.WriteVerifiedFile(destPath, bytes.NewReader(compressed.Bytes()), 0644, true, sha256Hex(content), CopyOptions{})
+		if err != nil {
+			t.Fatalf("WriteVerifiedFile failed: %v", err)
+		}
+		if written != int64(len(content)) {
+			t.Errorf("written = %d, want %d", written, len(content))
+		}
+
+		data, err := os.ReadFile(destPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, content) {
+			t.Errorf("content = %q, want %q", data, content)
+		}
+		if _, err := os.Stat(GetPartialPath(destPath)); !os.IsNotExist(err) {
+			t.Error("partial file left behind")
+		}
+	})
+
+	t.Run("mismatch removes partial", func(t *testing.T) {
+		destPath := filepath.Join(t.TempDir(), "file.txt")
+		os.WriteFile(destPath, []byte("previous"), 0644)
+
+		_, err := h.WriteVerifiedFile(destPath, bytes.NewReader(content), 0644, false, sha256Hex([]byte("other")), CopyOptions{})
+		if !errors.Is(err, ErrChecksumMismatch) {
+			t.Fatalf("WriteVerifiedFile error = %v, want %v", err, ErrChecksumMismatch)
+		}
+		if _, err := os.Stat(GetPartialPath(destPath)); !os.IsNotExist(err) {
+			t.Error("partial file not removed after mismatch")
+		}
+
+		// The existing file is not replaced
+		data, _ := os.ReadFile(destPath)
+		if string(data) != "previous" {
+			t.Errorf("existing file = %q, want %q", data, "previous")
+		}
+	})
+}
+
+func TestStreamHandler_ReadFileForDownloadChecked(t *testing.T) {
+	h := NewStreamHandler(StreamConfig{Enabled: true})
+	content := []byte("0123456789abcdefghijklmnop")
+	srcPath := filepath.Join(t.TempDir(), "test.txt")
+	os.WriteFile(srcPath, content, 0644)
+
+	for _, offset := range []int64{0, 10} {
+		sum := NewChecksum()
+		r, _, _, _, err := h.ReadFileForDownloadChecked(srcPath, offset, false, sum, CopyOptions{})
+		if err != nil {
+			t.Fatalf("ReadFileForDownloadChecked(offset %d) failed: %v", offset, err)
+		}
+		data, err := readAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, content[offset:]) {
+			t.Errorf("offset %d: content = %q, want %q", offset, data, content[offset:])
+		}
+
+		// The checksum always covers the whole file
+		if got := ChecksumHex(sum); got != sha256Hex(content) {
+			t.Errorf("offset %d: checksum = %s, want %s", offset, got, sha256Hex(content))
+		}
+	}
+
+	t.Run("directory archive", func(t *testing.T) {
+		srcDir := t.TempDir()
+		os.WriteFile(filepath.Join(srcDir, "file1.txt"), []byte("file1"), 0644)
+
+		sum := NewChecksum()
+		r, _, _, isDir, err := h.ReadFileForDownloadChecked(srcDir, 0, true, sum, CopyOptions{})
+		if err != nil {
+			t.Fatalf("ReadFileForDownloadChecked failed: %v", err)
+		}
+		if !isDir {
+			t.Error("isDir = false, want true")
+		}
+		archive, err := readAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := ChecksumHex(sum); got != sha256Hex(archive) {
+			t.Errorf("checksum = %s, want checksum of archive %s", got, sha256Hex(archive))
+		}
+	})
+}
+
+func TestHashFilePrefix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "prefix.bin")
+	os.WriteFile(path, []byte("abcdef"), 0644)
+
+	sum := NewChecksum()
+	if err := HashFilePrefix(sum, path, 3); err != nil {
+		t.Fatalf("HashFilePrefix failed: %v", err)
+	}
+	if got := ChecksumHex(sum); got != sha256Hex([]byte("abc")) {
+		t.Errorf("checksum = %s, want %s", got, sha256Hex([]byte("abc")))
+	}
+
+	if err := HashFilePrefix(NewChecksum(), path, 10); err == nil {
+		t.Error("HashFilePrefix past end of file should fail")
+	}
+}
@@ -0,0 +1,24 @@
+//go:build !windows
+
+package filetransfer
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileID identifies a file on its file system.
+type fileID struct {
+	dev uint64
+	ino uint64
+}
+
+// hardlinkID returns the identity of a regular file that has more than one
+// name, so the archiver can store the other names as hard links.
+func hardlinkID(info os.FileInfo) (fileID, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || st.Nlink < 2 {
+		return fileID{}, false
+	}
+	return fileID{dev: uint64(st.Dev), ino: uint64(st.Ino)}, true
+}
@@ -0,0 +1,14 @@
+//go:build windows
+
+package filetransfer
+
+import "os"
+
+// fileID identifies a file on its file system.
+type fileID struct{}
+
+// hardlinkID reports no hard links on Windows, where FileInfo does not carry
+// the link count. Every name is archived with its own content.
+func hardlinkID(info os.FileInfo) (fileID, bool) {
+	return fileID{}, false
+}
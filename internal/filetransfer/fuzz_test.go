@@ -0,0 +1,27 @@
+package filetransfer
+
+import "testing"
+
+func FuzzParseMetadata(f *testing.F) {
+	f.Add([]byte(`{"path":"/tmp/file.txt","mode":420,"size":1024,"compress":true}`))
+	f.Add([]byte(`{"path":"/tmp/dir","size":-1,"is_directory":true,"offset":512,"original_size":4096}`))
+	f.Add([]byte(`{"error":"file not found"}`))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		meta, err := ParseMetadata(data)
+		if err != nil {
+			return
+		}
+		encoded, err := EncodeMetadata(meta)
+		if err != nil {
+			t.Fatalf("EncodeMetadata() error = %v", err)
+		}
+		// Escaping can grow the JSON past the cap, which is fine to reject.
+		if len(encoded) > MaxMetadataSize {
+			return
+		}
+		if _, err := ParseMetadata(encoded); err != nil {
+			t.Fatalf("re-parse error = %v", err)
+		}
+	})
+}
@@ -0,0 +1,232 @@
+package filetransfer
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	defaultManifestLimit = 50
+	maxManifestLimit     = 100
+
+	// maxManifestBytes bounds the encoded size of one manifest page so it
+	// fits in a single control response.
+	maxManifestBytes = 12 * 1024
+)
+
+// ManifestEntry describes a file or directory below the root of a manifest.
+// Directory sync compares the manifests of both sides to find changed files.
+type ManifestEntry struct {
+	Path    string `json:"path"`             // Relative to the root, "/"-separated
+	Size    int64  `json:"size"`             // File size in bytes (0 for directories)
+	Mode    string `json:"mode"`             // e.g. "0644"
+	ModTime string `json:"mod_time"`         // ISO 8601
+	IsDir   bool   `json:"is_dir,omitempty"` // True for directories
+	SHA256  string `json:"sha256,omitempty"` // Content hash (files only)
+}
+
+// BuildManifest returns the manifest of the directory tree at root, with
+// the SHA-256 of every regular file. Symlinks and special files are skipped.
+func BuildManifest(root string) ([]ManifestEntry, error) {
+	entries, err := walkManifest(root)
+	if err != nil {
+		return nil, err
+	}
+	for i := range entries {
+		if err := hashManifestEntry(root, &entries[i]); err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
+
+// walkManifest lists the directories and regular files below root, sorted
+// by path, without hashing them.
+func walkManifest(root string) ([]ManifestEntry, error) {
+	var entries []ManifestEntry
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == root {
+			return nil
+		}
+		if !d.IsDir() && !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+
+		entry := ManifestEntry{
+			Path:    filepath.ToSlash(rel),
+			Mode:    fmt.Sprintf("%04o", info.Mode().Perm()),
+			ModTime: info.ModTime().UTC().Format("2006-01-02T15:04:05Z"),
+			IsDir:   d.IsDir(),
+		}
+		if !entry.IsDir {
+			entry.Size = info.Size()
+		}
+		entries = append(entries, entry)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to walk %s: %w", root, err)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Path < entries[j].Path
+	})
+	return entries, nil
+}
+
+// hashManifestEntry fills in the SHA-256 of a file entry.
+func hashManifestEntry(root string, entry *ManifestEntry) error {
+	if entry.IsDir {
+		return nil
+	}
+	sum, err := HashFile(filepath.Join(root, filepath.FromSlash(entry.Path)))
+	if err != nil {
+		return err
+	}
+	entry.SHA256 = sum
+	return nil
+}
+
+// browseManifest returns one page of the manifest of a directory. A missing
+// directory has an empty manifest, so a sync can target a new path. Only
+// the files on the requested page are hashed.
+func (h *StreamHandler) browseManifest(req *BrowseRequest) *BrowseResponse {
+	cleanPath, errResp := h.requirePath(req.Path)
+	if errResp != nil {
+		return errResp
+	}
+
+	info, err := os.Stat(cleanPath)
+	if os.IsNotExist(err) {
+		return &BrowseResponse{Path: cleanPath, Manifest: []ManifestEntry{}}
+	}
+	if err != nil {
+		return &BrowseResponse{Error: fmt.Sprintf("path not found: %s", cleanPath)}
+	}
+	if !info.IsDir() {
+		return &BrowseResponse{Error: fmt.Sprintf("not a directory: %s", cleanPath)}
+	}
+
+	entries, err := walkManifest(cleanPath)
+	if err != nil {
+		return &BrowseResponse{Error: err.Error()}
+	}
+	total := len(entries)
+
+	limit := req.Limit
+	if limit <= 0 {
+		limit = defaultManifestLimit
+	}
+	if limit > maxManifestLimit {
+		limit = maxManifestLimit
+	}
+
+	offset := req.Offset
+	if offset < 0 {
+		offset = 0
+	}
+	if offset > total {
+		offset = total
+	}
+
+	// Stop early when long paths would make the page too large
+	page := []ManifestEntry{}
+	size := 0
+	for i := offset; i < total && len(page) < limit; i++ {
+		entry := entries[i]
+		entrySize := len(entry.Path) + 160
+		if len(page) > 0 && size+entrySize > maxManifestBytes {
+			break
+		}
+		if err := hashManifestEntry(cleanPath, &entry); err != nil {
+			return &BrowseResponse{Error: err.Error()}
+		}
+		page = append(page, entry)
+		size += entrySize
+	}
+
+	return &BrowseResponse{
+		Path:      cleanPath,
+		Manifest:  page,
+		Total:     total,
+		Truncated: offset+len(page) < total,
+	}
+}
+
+// SyncPlan lists what a directory sync has to do to make the remote side
+// match the local one.
+type SyncPlan struct {
+	Upload    []ManifestEntry // Local files that are missing or differ remotely
+	Delete    []ManifestEntry // Remote entries to remove, parents before children
+	Unchanged int             // Files that are already up to date
+}
+
+// PlanSync compares a local and a remote manifest. Files are equal when
+// their size and SHA-256 match; modification times are ignored, since
+// uploads do not preserve them. Remote entries that are in the way of a
+// local entry of the other type are always deleted; other remote entries
+// that do not exist locally are only deleted when deleteExtraneous is set.
+func PlanSync(local, remote []ManifestEntry, deleteExtraneous bool) *SyncPlan {
+	plan := &SyncPlan{}
+
+	localByPath := make(map[string]ManifestEntry, len(local))
+	for _, e := range local {
+		localByPath[e.Path] = e
+	}
+	remoteByPath := make(map[string]ManifestEntry, len(remote))
+	for _, e := range remote {
+		remoteByPath[e.Path] = e
+	}
+
+	// Remote entries are sorted, so parents are seen before their children
+	deleted := make(map[string]bool)
+	for _, r := range remote {
+		l, inLocal := localByPath[r.Path]
+		if (inLocal && l.IsDir != r.IsDir) || (!inLocal && deleteExtraneous) {
+			if !hasDeletedParent(r.Path, deleted) {
+				plan.Delete = append(plan.Delete, r)
+			}
+			deleted[r.Path] = true
+		}
+	}
+
+	for _, l := range local {
+		if l.IsDir {
+			continue
+		}
+		r, ok := remoteByPath[l.Path]
+		if ok && !deleted[l.Path] && r.Size == l.Size && strings.EqualFold(r.SHA256, l.SHA256) {
+			plan.Unchanged++
+			continue
+		}
+		plan.Upload = append(plan.Upload, l)
+	}
+
+	return plan
+}
+
+// hasDeletedParent reports whether a parent directory of p is deleted.
+func hasDeletedParent(p string, deleted map[string]bool) bool {
+	for dir := path.Dir(p); dir != "."; dir = path.Dir(dir) {
+		if deleted[dir] {
+			return true
+		}
+	}
+	return false
+}
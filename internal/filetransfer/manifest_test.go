@@ -0,0 +1,202 @@
+package filetransfer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestBuildManifest(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "sub"), 0755)
+	os.WriteFile(filepath.Join(dir, "a.txt"), []byte("hello"), 0644)
+	os.WriteFile(filepath.Join(dir, "sub", "b.txt"), []byte("world!"), 0644)
+
+	entries, err := BuildManifest(dir)
+	if err != nil {
+		t.Fatalf("BuildManifest failed: %v", err)
+	}
+
+	want := []struct {
+		path  string
+		isDir bool
+		size  int64
+		sum   string
+	}{
+		{"a.txt", false, 5, sha256Hex([]byte("hello"))},
+		{"sub", true, 0, ""},
+		{"sub/b.txt", false, 6, sha256Hex([]byte("world!"))},
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("got %d entries, want %d: %+v", len(entries), len(want), entries)
+	}
+	for i, w := range want {
+		e := entries[i]
+		if e.Path != w.path || e.IsDir != w.isDir || e.Size != w.size || e.SHA256 != w.sum {
+			t.Errorf("entry %d = %+v, want %+v", i, e, w)
+		}
+	}
+}
+
+func TestBrowseManifest_Pagination(t *testing.T) {
+	dir := t.TempDir()
+	for i := 0; i < 5; i++ {
+		os.WriteFile(filepath.Join(dir, fmt.Sprintf("file%d.txt", i)), []byte{byte(i)}, 0644)
+	}
+	h := NewStreamHandler(StreamConfig{Enabled: true, AllowedPaths: []string{"*"}})
+
+	resp := h.Browse(&BrowseRequest{Action: "manifest", Path: dir, Limit: 2})
+	if resp.Error != "" {
+		t.Fatalf("unexpected error: %s", resp.Error)
+	}
+	if len(resp.Manifest) != 2 || resp.Total != 5 || !resp.Truncated {
+		t.Fatalf("got %d entries (total %d, truncated %v), want 2 of 5, truncated", len(resp.Manifest), resp.Total, resp.Truncated)
+	}
+	if resp.Manifest[0].SHA256 != sha256Hex([]byte{0}) {
+		t.Errorf("file0.txt sha256 = %q", resp.Manifest[0].SHA256)
+	}
+
+	resp = h.Browse(&BrowseRequest{Action: "manifest", Path: dir, Offset: 4, Limit: 2})
+	if len(resp.Manifest) != 1 || resp.Truncated {
+		t.Fatalf("last page: got %d entries (truncated %v), want 1, not truncated", len(resp.Manifest), resp.Truncated)
+	}
+	if resp.Manifest[0].Path != "file4.txt" {
+		t.Errorf("last page entry = %s, want file4.txt", resp.Manifest[0].Path)
+	}
+}
+
+func TestBrowseManifest_PageSize(t *testing.T) {
+	dir := t.TempDir()
+	for i := 0; i < maxManifestLimit; i++ {
+		name := fmt.Sprintf("%03d-%s.txt", i, strings.Repeat("x", 200))
+		os.WriteFile(filepath.Join(dir, name), nil, 0644)
+	}
+	h := NewStreamHandler(StreamConfig{Enabled: true, AllowedPaths: []string{"*"}})
+
+	resp := h.Browse(&BrowseRequest{Action: "manifest", Path: dir, Limit: maxManifestLimit})
+	if resp.Error != "" {
+		t.Fatalf("unexpected error: %s", resp.Error)
+	}
+	if len(resp.Manifest) == 0 || len(resp.Manifest) >= maxManifestLimit {
+		t.Fatalf("got %d entries, want a page cut short by size", len(resp.Manifest))
+	}
+	if !resp.Truncated {
+		t.Error("expected truncated page")
+	}
+}
+
+func TestBrowseManifest_MissingDirectory(t *testing.T) {
+	h := NewStreamHandler(StreamConfig{Enabled: true, AllowedPaths: []string{"*"}})
+
+	resp := h.Browse(&BrowseRequest{Action: "manifest", Path: filepath.Join(t.TempDir(), "missing")})
+	if resp.Error != "" {
+		t.Fatalf("unexpected error: %s", resp.Error)
+	}
+	if resp.Manifest == nil || len(resp.Manifest) != 0 {
+		t.Errorf("manifest = %v, want empty", resp.Manifest)
+	}
+}
+
+func TestBrowseManifest_Errors(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file.txt")
+	os.WriteFile(file, []byte("x"), 0644)
+
+	h := NewStreamHandler(StreamConfig{Enabled: true, AllowedPaths: []string{dir}})
+
+	tests := []struct {
+		name string
+		path string
+		want string
+	}{
+		{"path required", "", "path is required"},
+		{"not a directory", file, "not a directory"},
+		{"not allowed", "/etc", "not in allowed list"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := h.Browse(&BrowseRequest{Action: "manifest", Path: tt.path})
+			if !strings.Contains(resp.Error, tt.want) {
+				t.Errorf("error = %q, want it to contain %q", resp.Error, tt.want)
+			}
+		})
+	}
+}
+
+func TestBrowseManifest_SkipsSymlinks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks require elevated privileges on Windows")
+	}
+
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "real.txt"), []byte("x"), 0644)
+	os.Symlink(filepath.Join(dir, "real.txt"), filepath.Join(dir, "link.txt"))
+
+	h := NewStreamHandler(StreamConfig{Enabled: true, AllowedPaths: []string{"*"}})
+	resp := h.Browse(&BrowseRequest{Action: "manifest", Path: dir})
+	if len(resp.Manifest) != 1 || resp.Manifest[0].Path != "real.txt" {
+		t.Errorf("manifest = %+v, want only real.txt", resp.Manifest)
+	}
+}
+
+func TestPlanSync(t *testing.T) {
+	file := func(p, content string) ManifestEntry {
+		return ManifestEntry{Path: p, Size: int64(len(content)), SHA256: sha256Hex([]byte(content))}
+	}
+	dir := func(p string) ManifestEntry {
+		return ManifestEntry{Path: p, IsDir: true}
+	}
+	paths := func(entries []ManifestEntry) string {
+		var p []string
+		for _, e := range entries {
+			p = append(p, e.Path)
+		}
+		return strings.Join(p, ",")
+	}
+
+	local := []ManifestEntry{
+		file("changed.txt", "new"),
+		file("new.txt", "new"),
+		dir("now-dir"),
+		file("now-dir/x.txt", "x"),
+		file("now-file", "f"),
+		file("same.txt", "same"),
+	}
+	remote := []ManifestEntry{
+		file("changed.txt", "old"),
+		dir("extra"),
+		file("extra/y.txt", "y"),
+		file("extra.txt", "e"),
+		file("now-dir", "d"),
+		dir("now-file"),
+		file("now-file/z.txt", "z"),
+		file("same.txt", "same"),
+	}
+
+	tests := []struct {
+		name             string
+		deleteExtraneous bool
+		wantUpload       string
+		wantDelete       string
+	}{
+		{"keep extraneous", false, "changed.txt,new.txt,now-dir/x.txt,now-file", "now-dir,now-file"},
+		{"delete extraneous", true, "changed.txt,new.txt,now-dir/x.txt,now-file", "extra,extra.txt,now-dir,now-file"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan := PlanSync(local, remote, tt.deleteExtraneous)
+			if got := paths(plan.Upload); got != tt.wantUpload {
+				t.Errorf("Upload = %s, want %s", got, tt.wantUpload)
+			}
+			if got := paths(plan.Delete); got != tt.wantDelete {
+				t.Errorf("Delete = %s, want %s", got, tt.wantDelete)
+			}
+			if plan.Unchanged != 1 {
+				t.Errorf("Unchanged = %d, want 1", plan.Unchanged)
+			}
+		})
+	}
+}
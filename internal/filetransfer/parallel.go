@@ -0,0 +1,47 @@
+package filetransfer
+
+// MinParallelChunk is the smallest range a parallel download splits a file
+// into. Smaller ranges cost more in stream setup than they gain.
+const MinParallelChunk = 1024 * 1024
+
+// MaxParallelStreams bounds the number of streams of a parallel download.
+const MaxParallelStreams = 16
+
+// ByteRange is a range of a file fetched by one stream of a parallel
+// download.
+type ByteRange struct {
+	Offset int64
+	Length int64
+}
+
+// SplitRanges splits a file of the given size into at most n ranges of
+// nearly equal length, none shorter than MinParallelChunk except when the
+// whole file is. Together the ranges cover the file in order.
+func SplitRanges(size int64, n int) []ByteRange {
+	if size <= 0 {
+		return nil
+	}
+	if n < 1 {
+		n = 1
+	}
+	if maxN := size / MinParallelChunk; int64(n) > maxN {
+		n = int(maxN)
+		if n < 1 {
+			n = 1
+		}
+	}
+
+	ranges := make([]ByteRange, 0, n)
+	chunk := size / int64(n)
+	extra := size % int64(n)
+	var offset int64
+	for i := 0; i < n; i++ {
+		length := chunk
+		if int64(i) < extra {
+			length++
+		}
+		ranges = append(ranges, ByteRange{Offset: offset, Length: length})
+		offset += length
+	}
+	return ranges
+}
@@ -0,0 +1,114 @@
+package filetransfer
+
+import (
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSplitRanges(t *testing.T) {
+	tests := []struct {
+		name string
+		size int64
+		n    int
+		want int // number of ranges
+	}{
+		{"empty", 0, 4, 0},
+		{"smaller than a chunk", 1000, 4, 1},
+		{"limited by chunk size", 3*MinParallelChunk + 5, 8, 3},
+		{"even split", 8 * MinParallelChunk, 4, 4},
+		{"uneven split", 10*MinParallelChunk + 3, 4, 4},
+		{"n below one", 5 * MinParallelChunk, 0, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ranges := SplitRanges(tt.size, tt.n)
+			if len(ranges) != tt.want {
+				t.Fatalf("SplitRanges(%d, %d) = %d ranges, want %d", tt.size, tt.n, len(ranges), tt.want)
+			}
+
+			var next int64
+			for i, r := range ranges {
+				if r.Offset != next {
+					t.Errorf("range %d offset = %d, want %d", i, r.Offset, next)
+				}
+				if len(ranges) > 1 && r.Length < MinParallelChunk {
+					t.Errorf("range %d length = %d, below MinParallelChunk", i, r.Length)
+				}
+				if r.Length-ranges[0].Length > 1 || ranges[0].Length-r.Length > 1 {
+					t.Errorf("range %d length = %d, first range %d", i, r.Length, ranges[0].Length)
+				}
+				next += r.Length
+			}
+			if next != tt.size {
+				t.Errorf("ranges cover %d bytes, want %d", next, tt.size)
+			}
+		})
+	}
+}
+
+func TestStreamHandler_ReadFileRange(t *testing.T) {
+	h := NewStreamHandler(StreamConfig{Enabled: true})
+	content := []byte("0123456789abcdefghijklmnop")
+	srcPath := filepath.Join(t.TempDir(), "test.txt")
+	os.WriteFile(srcPath, content, 0644)
+
+	t.Run("plain", func(t *testing.T) {
+		sum := NewChecksum()
+		r, size, mode, err := h.ReadFileRange(srcPath, 10, 6, false, sum)
+		if err != nil {
+			t.Fatalf("ReadFileRange failed: %v", err)
+		}
+		if size != 6 || mode != 0644 {
+			t.Errorf("size, mode = %d, %o, want 6, 644", size, mode)
+		}
+		data, err := readAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, content[10:16]) {
+			t.Errorf("content = %q, want %q", data, content[10:16])
+		}
+
+		// The checksum covers only the range
+		if got := ChecksumHex(sum); got != sha256Hex(content[10:16]) {
+			t.Errorf("checksum = %s, want %s", got, sha256Hex(content[10:16]))
+		}
+	})
+
+	t.Run("compressed", func(t *testing.T) {
+		r, size, _, err := h.ReadFileRange(srcPath, 20, 6, true, nil)
+		if err != nil {
+			t.Fatalf("ReadFileRange failed: %v", err)
+		}
+		if size != -1 {
+			t.Errorf("size = %d, want -1", size)
+		}
+		gzr, err := gzip.NewReader(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := readAll(gzr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, content[20:]) {
+			t.Errorf("content = %q, want %q", data, content[20:])
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		if _, _, _, err := h.ReadFileRange(srcPath, 20, 7, false, nil); err == nil {
+			t.Error("range past end of file should fail")
+		}
+		if _, _, _, err := h.ReadFileRange(srcPath, 0, 0, false, nil); err == nil {
+			t.Error("empty range should fail")
+		}
+		if _, _, _, err := h.ReadFileRange(t.TempDir(), 0, 1, false, nil); err == nil {
+			t.Error("range of a directory should fail")
+		}
+	})
+}
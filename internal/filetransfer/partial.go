@@ -3,6 +3,7 @@ package filetransfer
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"time"
@@ -213,6 +214,28 @@ func OpenPartialFileForAppend(path string) (*os.File, error) {
 	return f, nil
 }
 
+// OpenPartialFileAt opens an existing partial file for writing at offset,
+// dropping anything past it. Unlike OpenPartialFileForAppend, writes follow
+// the file offset, which a SparseWriter needs.
+func OpenPartialFileAt(path string, offset int64) (*os.File, error) {
+	partialPath := GetPartialPath(path)
+
+	f, err := os.OpenFile(partialPath, os.O_WRONLY, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open partial file: %w", err)
+	}
+	if err := f.Truncate(offset); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to truncate partial file: %w", err)
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to seek partial file: %w", err)
+	}
+
+	return f, nil
+}
+
 // UpdatePartialProgress updates the BytesWritten field in the partial info file.
 // This should be called periodically during a transfer to track progress.
 func UpdatePartialProgress(path string, bytesWritten int64) error {
@@ -509,7 +509,7 @@ func TestAuthBypass_WriteToReadOnly(t *testing.T) {
 	destPath := filepath.Join(roDir, "file.txt")
 	content := []byte("test content")
 
-	_, err := h.WriteUploadedFile(destPath, bytes.NewReader(content), 0644, false, false)
+	_, err := h.WriteUploadedFile(destPath, bytes.NewReader(content), 0644, false, false, CopyOptions{})
 
 	if err == nil {
 		// If we're root, this might succeed
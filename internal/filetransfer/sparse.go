@@ -0,0 +1,87 @@
+package filetransfer
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+)
+
+// sparseBlockSize is the granularity at which runs of zeros become holes.
+// It matches the block size of common file systems.
+const sparseBlockSize = 4096
+
+var zeroBlock [sparseBlockSize]byte
+
+// SparseWriter writes to a file, seeking over blocks that are all zeros
+// instead of writing them, so file systems that support holes leave them
+// unallocated. Close sets the final file size, which covers a hole at the
+// end of the file, and closes the file.
+//
+// The file must not be opened with O_APPEND, since appending ignores the
+// file offset. To fill one range of a file that already has its final size,
+// write through the SparseWriter and close the file itself instead.
+type SparseWriter struct {
+	f   *os.File
+	pos int64
+}
+
+// NewSparseWriter returns a SparseWriter that starts at the current offset
+// of f.
+func NewSparseWriter(f *os.File) (*SparseWriter, error) {
+	pos, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get file offset: %w", err)
+	}
+	return &SparseWriter{f: f, pos: pos}, nil
+}
+
+func (w *SparseWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		// Keep blocks aligned to the file offset so holes line up with
+		// file system blocks
+		n := sparseBlockSize - int(w.pos%sparseBlockSize)
+		if n > len(p) {
+			n = len(p)
+		}
+
+		if bytes.Equal(p[:n], zeroBlock[:n]) {
+			if _, err := w.f.Seek(int64(n), io.SeekCurrent); err != nil {
+				return written, err
+			}
+		} else if _, err := w.f.Write(p[:n]); err != nil {
+			return written, err
+		}
+
+		w.pos += int64(n)
+		written += n
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// Close truncates the file to the data written so far and closes it. It
+// also runs after a failed copy, so a partial file always has the size of
+// the data it holds.
+func (w *SparseWriter) Close() error {
+	err := w.f.Truncate(w.pos)
+	if closeErr := w.f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// fileWriter returns a writer for f that closes it: a SparseWriter when
+// sparse is set, otherwise f itself.
+func fileWriter(f *os.File, sparse bool) (io.WriteCloser, error) {
+	if !sparse {
+		return f, nil
+	}
+	w, err := NewSparseWriter(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return w, nil
+}
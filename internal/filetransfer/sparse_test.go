@@ -0,0 +1,147 @@
+//go:build !windows
+
+package filetransfer
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+// allocated returns the disk space used by the file at path.
+func allocated(t *testing.T, path string) int64 {
+	t.Helper()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info.Sys().(*syscall.Stat_t).Blocks * 512
+}
+
+// sparseContent is 4 MB of zeros with data at the start and in the middle,
+// ending in a hole.
+func sparseContent() []byte {
+	data := make([]byte, 4<<20)
+	copy(data, "header")
+	copy(data[2<<20:], "middle")
+	return data
+}
+
+func TestSparseWriter(t *testing.T) {
+	data := sparseContent()
+	path := filepath.Join(t.TempDir(), "disk.img")
+
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := NewSparseWriter(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Odd write sizes exercise block alignment
+	for rest := data; len(rest) > 0; {
+		n := 10000
+		if n > len(rest) {
+			n = len(rest)
+		}
+		if _, err := w.Write(rest[:n]); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+		rest = rest[n:]
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("content differs (got %d bytes, want %d)", len(got), len(data))
+	}
+	if used := allocated(t, path); used >= int64(len(data))/2 {
+		t.Skipf("file system does not support holes (%d bytes allocated)", used)
+	}
+}
+
+func TestUntarDirectory_Sparse(t *testing.T) {
+	data := sparseContent()
+
+	var buf bytes.Buffer
+	gzw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gzw)
+	tw.WriteHeader(&tar.Header{Name: "disk.img", Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(data))})
+	tw.Write(data)
+	tw.Close()
+	gzw.Close()
+	archive := buf.Bytes()
+
+	denseDir, sparseDir := t.TempDir(), t.TempDir()
+	if err := UntarDirectory(bytes.NewReader(archive), denseDir, CopyOptions{}); err != nil {
+		t.Fatalf("UntarDirectory failed: %v", err)
+	}
+	if err := UntarDirectory(bytes.NewReader(archive), sparseDir, CopyOptions{Sparse: true}); err != nil {
+		t.Fatalf("UntarDirectory(sparse) failed: %v", err)
+	}
+
+	got, _ := os.ReadFile(filepath.Join(sparseDir, "disk.img"))
+	if !bytes.Equal(got, data) {
+		t.Fatal("sparse extraction changed the content")
+	}
+	dense := allocated(t, filepath.Join(denseDir, "disk.img"))
+	sparse := allocated(t, filepath.Join(sparseDir, "disk.img"))
+	if sparse >= dense {
+		t.Skipf("file system does not support holes (%d sparse, %d dense)", sparse, dense)
+	}
+}
+
+func TestStreamHandler_WriteUploadedFile_Sparse(t *testing.T) {
+	h := NewStreamHandler(StreamConfig{Enabled: true})
+	data := sparseContent()
+	path := filepath.Join(t.TempDir(), "disk.img")
+
+	written, err := h.WriteVerifiedFile(path, bytes.NewReader(data), 0644, false, sha256Hex(data), CopyOptions{Sparse: true})
+	if err != nil {
+		t.Fatalf("WriteVerifiedFile failed: %v", err)
+	}
+	if written != int64(len(data)) {
+		t.Errorf("written = %d, want %d", written, len(data))
+	}
+	info, err := os.Stat(path)
+	if err != nil || info.Size() != int64(len(data)) {
+		t.Fatalf("size = %v, %v, want %d", info, err, len(data))
+	}
+	if used := allocated(t, path); used >= int64(len(data))/2 {
+		t.Skipf("file system does not support holes (%d bytes allocated)", used)
+	}
+}
+
+func TestOpenPartialFileAt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file.bin")
+	os.WriteFile(GetPartialPath(path), []byte("0123456789"), 0644)
+
+	f, err := OpenPartialFileAt(path, 4)
+	if err != nil {
+		t.Fatalf("OpenPartialFileAt failed: %v", err)
+	}
+	w, err := NewSparseWriter(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("ab"))
+	w.Write(make([]byte, 3))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	got, _ := os.ReadFile(GetPartialPath(path))
+	if want := "0123ab\x00\x00\x00"; string(got) != want {
+		t.Errorf("content = %q, want %q", got, want)
+	}
+}
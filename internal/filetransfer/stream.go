@@ -4,6 +4,7 @@ import (
 	"compress/gzip"
 	"encoding/json"
 	"fmt"
+	"hash"
 	"io"
 	"os"
 	"path/filepath"
@@ -15,18 +16,42 @@ import (
 )
 
 // TransferMetadata is sent as the first data frame in a file transfer stream.
+//
+// A sender that sets Trailer follows the data with one more frame, sent with
+// FIN_WRITE: a TransferMetadata carrying the Checksum of the content, or Error
+// if the sender failed part way. Upload senders set it in their request;
+// download requesters set it to ask for a trailer, and the sender confirms it
+// in its response metadata.
 type TransferMetadata struct {
-	Path         string `json:"path"`                    // Absolute destination path
-	Mode         uint32 `json:"mode"`                    // File permissions (e.g., 0644)
-	Size         int64  `json:"size"`                    // File size in bytes (-1 for directories)
-	IsDirectory  bool   `json:"is_directory"`            // True if transferring a directory
-	Password     string `json:"password,omitempty"`      // Authentication password
-	Compress     bool   `json:"compress"`                // Whether data is gzip compressed
-	Checksum     string `json:"checksum,omitempty"`      // Optional SHA256 checksum (hex)
-	RateLimit    int64  `json:"rate_limit,omitempty"`    // Max bytes per second (0 = unlimited)
-	Offset       int64  `json:"offset,omitempty"`        // Resume from this byte offset (uncompressed)
-	OriginalSize int64  `json:"original_size,omitempty"` // Expected file size for resume validation
-	Error        string `json:"error,omitempty"`         // Error message (set when transfer fails)
+	Path             string `json:"path"`                        // Absolute destination path
+	Mode             uint32 `json:"mode"`                        // File permissions (e.g., 0644)
+	Size             int64  `json:"size"`                        // File size in bytes (-1 for directories)
+	IsDirectory      bool   `json:"is_directory"`                // True if transferring a directory
+	Password         string `json:"password,omitempty"`          // Authentication password
+	Compress         bool   `json:"compress"`                    // Whether data is gzip compressed
+	Checksum         string `json:"checksum,omitempty"`          // SHA-256 of the content (hex), sent in the trailer
+	Trailer          bool   `json:"trailer,omitempty"`           // Data ends with a checksum trailer frame
+	RateLimit        int64  `json:"rate_limit,omitempty"`        // Max bytes per second (0 = unlimited)
+	Offset           int64  `json:"offset,omitempty"`            // Resume from this byte offset (uncompressed)
+	OriginalSize     int64  `json:"original_size,omitempty"`     // Expected file size for resume validation
+	Length           int64  `json:"length,omitempty"`            // Bytes to send from Offset (0 = to the end)
+	Symlinks         string `json:"symlinks,omitempty"`          // Symlink policy for directory archives (download requests)
+	Hardlinks        string `json:"hardlinks,omitempty"`         // Hardlink policy for directory archives (download requests)
+	Sparse           bool   `json:"sparse,omitempty"`            // Write runs of zeros as holes (uploads)
+	ExternalSymlinks bool   `json:"external_symlinks,omitempty"` // Keep extracted symlinks pointing outside the destination (uploads)
+	Error            string `json:"error,omitempty"`             // Error message (set when transfer fails)
+	ErrorCode        uint16 `json:"error_code,omitempty"`        // Protocol error code for Error
+}
+
+// CopyOptions returns the link and sparse file handling requested in the
+// metadata.
+func (m *TransferMetadata) CopyOptions() CopyOptions {
+	return CopyOptions{
+		Symlinks:         m.Symlinks,
+		Hardlinks:        m.Hardlinks,
+		Sparse:           m.Sparse,
+		ExternalSymlinks: m.ExternalSymlinks,
+	}
 }
 
 // TransferResult is sent back after a transfer completes (in download response metadata).
@@ -55,6 +80,24 @@ func NewStreamHandler(cfg StreamConfig) *StreamHandler {
 	return &StreamHandler{cfg: cfg}
 }
 
+// Authenticate checks a password against the configured file transfer
+// password hash.
+func (h *StreamHandler) Authenticate(password string) error {
+	return h.authenticate(password)
+}
+
+// CheckPath reports whether file transfer is enabled and path may be used
+// by a transfer. Symlinks must point inside the allowed paths.
+func (h *StreamHandler) CheckPath(path string) error {
+	if !h.cfg.Enabled {
+		return fmt.Errorf("file transfer is disabled")
+	}
+	if err := h.validatePath(path); err != nil {
+		return err
+	}
+	return h.validateSymlinkTarget(path)
+}
+
 // validateCommon performs validation common to both upload and download operations.
 func (h *StreamHandler) validateCommon(meta *TransferMetadata) error {
 	if !h.cfg.Enabled {
@@ -63,6 +106,9 @@ func (h *StreamHandler) validateCommon(meta *TransferMetadata) error {
 	if err := h.authenticate(meta.Password); err != nil {
 		return err
 	}
+	if err := meta.CopyOptions().Validate(); err != nil {
+		return err
+	}
 	return h.validatePath(meta.Path)
 }
 
@@ -285,13 +331,13 @@ func isPathAllowed(path, pattern string) bool {
 // WriteUploadedFile writes uploaded data from a reader to the specified path.
 // If isDirectory is true, it expects tar.gz data and extracts it.
 // Returns the number of bytes written.
-func (h *StreamHandler) WriteUploadedFile(path string, r io.Reader, mode uint32, isDirectory bool, compressed bool) (int64, error) {
+func (h *StreamHandler) WriteUploadedFile(path string, r io.Reader, mode uint32, isDirectory bool, compressed bool, opts CopyOptions) (int64, error) {
 	path = filepath.Clean(path)
 
 	if isDirectory {
 		// For directories, extract tar.gz to the path
 		// UntarDirectory handles gzip decompression internally
-		if err := UntarDirectory(r, path); err != nil {
+		if err := UntarDirectory(r, path, opts); err != nil {
 			return 0, fmt.Errorf("failed to extract directory: %w", err)
 		}
 		// Calculate extracted size
@@ -310,8 +356,64 @@ func (h *StreamHandler) WriteUploadedFile(path string, r io.Reader, mode uint32,
 	if err != nil {
 		return 0, fmt.Errorf("failed to create file: %w", err)
 	}
-	defer f.Close()
+	fw, err := fileWriter(f, opts.Sparse)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create file: %w", err)
+	}
+
+	written, err := h.copyFileData(fw, r, compressed)
+	if closeErr := fw.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("failed to write file: %w", closeErr)
+	}
+	return written, err
+}
+
+// WriteVerifiedFile writes an uploaded file like WriteUploadedFile, but
+// only moves it into place once the SHA-256 of its content matches
+// checksum. The data is written to the .partial path first; on a mismatch
+// or any other error the partial file is removed and the final path is left
+// untouched. Mismatches wrap ErrChecksumMismatch.
+func (h *StreamHandler) WriteVerifiedFile(path string, r io.Reader, mode uint32, compressed bool, checksum string, opts CopyOptions) (int64, error) {
+	path = filepath.Clean(path)
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return 0, fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	partialPath := GetPartialPath(path)
+	f, err := os.OpenFile(partialPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(mode))
+	if err != nil {
+		return 0, fmt.Errorf("failed to create file: %w", err)
+	}
+	fw, err := fileWriter(f, opts.Sparse)
+	if err != nil {
+		os.Remove(partialPath)
+		return 0, fmt.Errorf("failed to create file: %w", err)
+	}
 
+	sum := NewChecksum()
+	written, err := h.copyFileData(io.MultiWriter(fw, sum), r, compressed)
+	if closeErr := fw.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("failed to write file: %w", closeErr)
+	}
+	if err == nil {
+		err = VerifyChecksum(checksum, ChecksumHex(sum))
+	}
+	if err != nil {
+		os.Remove(partialPath)
+		return written, err
+	}
+
+	if err := os.Rename(partialPath, path); err != nil {
+		os.Remove(partialPath)
+		return written, fmt.Errorf("failed to rename partial to final: %w", err)
+	}
+	return written, nil
+}
+
+// copyFileData copies upload data to w, decompressing it if needed and
+// enforcing the maximum file size.
+func (h *StreamHandler) copyFileData(w io.Writer, r io.Reader, compressed bool) (int64, error) {
 	// If compressed, wrap with gzip reader
 	var reader io.Reader = r
 	if compressed {
@@ -325,8 +427,9 @@ func (h *StreamHandler) WriteUploadedFile(path string, r io.Reader, mode uint32,
 
 	// Copy data with size enforcement
 	var written int64
+	var err error
 	if h.cfg.MaxFileSize > 0 {
-		written, err = io.Copy(f, io.LimitReader(reader, h.cfg.MaxFileSize+1))
+		written, err = io.Copy(w, io.LimitReader(reader, h.cfg.MaxFileSize+1))
 		if err != nil {
 			return written, fmt.Errorf("failed to write file: %w", err)
 		}
@@ -334,7 +437,7 @@ func (h *StreamHandler) WriteUploadedFile(path string, r io.Reader, mode uint32,
 			return written, fmt.Errorf("file data exceeds max size: %d bytes (max %d)", written, h.cfg.MaxFileSize)
 		}
 	} else {
-		written, err = io.Copy(f, reader)
+		written, err = io.Copy(w, reader)
 		if err != nil {
 			return written, fmt.Errorf("failed to write file: %w", err)
 		}
@@ -345,7 +448,7 @@ func (h *StreamHandler) WriteUploadedFile(path string, r io.Reader, mode uint32,
 
 // gzipPipeReader creates a pipe that gzip-compresses data from the source file.
 // The file is closed when compression completes or on error.
-func gzipPipeReader(f *os.File) io.Reader {
+func gzipPipeReader(f io.ReadCloser) io.Reader {
 	pr, pw := io.Pipe()
 	go func() {
 		gzw := gzip.NewWriter(pw)
@@ -362,10 +465,11 @@ func gzipPipeReader(f *os.File) io.Reader {
 }
 
 // ReadFileForDownload creates a reader for the given path.
-// If the path is a directory, it returns a tar.gz stream.
+// If the path is a directory, it returns a tar.gz stream, archived with the
+// link policies in opts. Symlinks are only followed to allowed paths.
 // The returned reader should be read fully and closed is handled by the caller.
 // Returns: reader, size (-1 for directories), mode, isDirectory, error
-func (h *StreamHandler) ReadFileForDownload(path string, compress bool) (io.Reader, int64, uint32, bool, error) {
+func (h *StreamHandler) ReadFileForDownload(path string, compress bool, opts CopyOptions) (io.Reader, int64, uint32, bool, error) {
 	path = filepath.Clean(path)
 
 	info, err := os.Stat(path)
@@ -376,9 +480,10 @@ func (h *StreamHandler) ReadFileForDownload(path string, compress bool) (io.Read
 	if info.IsDir() {
 		// For directories, create a tar.gz stream via pipe
 		pr, pw := io.Pipe()
+		opts.allowTarget = h.validatePath
 
 		go func() {
-			err := TarDirectory(path, pw)
+			err := TarDirectory(path, pw, opts)
 			if err != nil {
 				pw.CloseWithError(err)
 			} else {
@@ -408,50 +513,135 @@ func (h *StreamHandler) ReadFileForDownload(path string, compress bool) (io.Read
 // Directories (tar archives) do not support resume - returns error if isDirectory.
 // Returns: reader, remainingSize (-1 if compressed), mode, isDirectory, error
 func (h *StreamHandler) ReadFileForDownloadAtOffset(path string, offset int64, compress bool) (io.Reader, int64, uint32, bool, error) {
+	f, info, err := openFileAtOffset(path, offset)
+	if err != nil {
+		return nil, 0, 0, info != nil && info.IsDir(), err
+	}
+
+	remainingSize := info.Size() - offset
+
+	if compress {
+		return gzipPipeReader(f), -1, uint32(info.Mode().Perm()), false, nil
+	}
+
+	return f, remainingSize, uint32(info.Mode().Perm()), false, nil
+}
+
+// ReadFileForDownloadChecked is ReadFileForDownloadAtOffset for transfers
+// that end with a checksum trailer: everything the transfer covers is fed
+// into sum as the returned reader is read. For files that is the
+// uncompressed content from byte 0, so the checksum of a resumed download
+// still covers the whole file; for directories it is the archive as
+// streamed.
+func (h *StreamHandler) ReadFileForDownloadChecked(path string, offset int64, compress bool, sum hash.Hash, opts CopyOptions) (io.Reader, int64, uint32, bool, error) {
+	if info, err := os.Stat(filepath.Clean(path)); err == nil && info.IsDir() && offset == 0 {
+		r, size, mode, isDir, err := h.ReadFileForDownload(path, compress, opts)
+		if err != nil {
+			return nil, 0, 0, isDir, err
+		}
+		return &checksumReader{r: r, sum: sum}, size, mode, isDir, nil
+	}
+
+	f, info, err := openFileAtOffset(path, offset)
+	if err != nil {
+		return nil, 0, 0, info != nil && info.IsDir(), err
+	}
+
+	// The bytes before the offset are already on the receiver's side, but
+	// they are part of the checksum
+	if offset > 0 {
+		if _, err := io.Copy(sum, io.NewSectionReader(f, 0, offset)); err != nil {
+			f.Close()
+			return nil, 0, 0, false, fmt.Errorf("failed to hash file: %w", err)
+		}
+	}
+
+	cr := &checksumReader{r: f, sum: sum}
+	if compress {
+		return gzipPipeReader(cr), -1, uint32(info.Mode().Perm()), false, nil
+	}
+	return cr, info.Size() - offset, uint32(info.Mode().Perm()), false, nil
+}
+
+// ReadFileRange creates a reader for length bytes of a file starting at
+// offset, for downloads that fetch a file in parallel ranges. Unlike
+// ReadFileForDownloadChecked, sum (if not nil) covers only the range, so
+// each range is verified on its own.
+// Returns: reader, size (-1 if compressed), mode, error
+func (h *StreamHandler) ReadFileRange(path string, offset, length int64, compress bool, sum hash.Hash) (io.Reader, int64, uint32, error) {
+	f, info, err := openFileAtOffset(path, offset)
+	if err != nil {
+		if info != nil && info.IsDir() {
+			return nil, 0, 0, fmt.Errorf("ranged downloads not supported for directories")
+		}
+		return nil, 0, 0, err
+	}
+	if length <= 0 || offset+length > info.Size() {
+		f.Close()
+		return nil, 0, 0, fmt.Errorf("range %d+%d exceeds file size %d", offset, length, info.Size())
+	}
+
+	var r io.ReadCloser = struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(f, length), f}
+	if sum != nil {
+		r = &checksumReader{r: r, sum: sum}
+	}
+	if compress {
+		return gzipPipeReader(r), -1, uint32(info.Mode().Perm()), nil
+	}
+	return r, length, uint32(info.Mode().Perm()), nil
+}
+
+// openFileAtOffset opens a regular file for download and seeks to offset.
+// The returned info is set whenever the path exists.
+func openFileAtOffset(path string, offset int64) (*os.File, os.FileInfo, error) {
 	path = filepath.Clean(path)
 
 	info, err := os.Stat(path)
 	if err != nil {
-		return nil, 0, 0, false, fmt.Errorf("path not found: %w", err)
+		return nil, nil, fmt.Errorf("path not found: %w", err)
 	}
 
 	if info.IsDir() {
 		// Directories don't support resume
-		return nil, 0, 0, true, fmt.Errorf("resume not supported for directories")
+		return nil, info, fmt.Errorf("resume not supported for directories")
 	}
 
 	// Validate offset
 	if offset < 0 {
-		return nil, 0, 0, false, fmt.Errorf("invalid offset: %d", offset)
+		return nil, info, fmt.Errorf("invalid offset: %d", offset)
 	}
 	if offset > info.Size() {
-		return nil, 0, 0, false, fmt.Errorf("offset %d exceeds file size %d", offset, info.Size())
+		return nil, info, fmt.Errorf("offset %d exceeds file size %d", offset, info.Size())
 	}
 
 	// Open file and seek to offset
 	f, err := os.Open(path)
 	if err != nil {
-		return nil, 0, 0, false, fmt.Errorf("failed to open file: %w", err)
+		return nil, info, fmt.Errorf("failed to open file: %w", err)
 	}
 
 	if offset > 0 {
 		if _, err := f.Seek(offset, 0); err != nil {
 			f.Close()
-			return nil, 0, 0, false, fmt.Errorf("failed to seek to offset: %w", err)
+			return nil, info, fmt.Errorf("failed to seek to offset: %w", err)
 		}
 	}
 
-	remainingSize := info.Size() - offset
-
-	if compress {
-		return gzipPipeReader(f), -1, uint32(info.Mode().Perm()), false, nil
-	}
-
-	return f, remainingSize, uint32(info.Mode().Perm()), false, nil
+	return f, info, nil
 }
 
+// MaxMetadataSize is the largest transfer metadata accepted. Metadata is
+// sent in a single frame, so anything larger did not come from an agent.
+const MaxMetadataSize = 16 * 1024
+
 // ParseMetadata parses transfer metadata from JSON bytes.
 func ParseMetadata(data []byte) (*TransferMetadata, error) {
+	if len(data) > MaxMetadataSize {
+		return nil, fmt.Errorf("invalid metadata: %d bytes exceeds %d", len(data), MaxMetadataSize)
+	}
 	var meta TransferMetadata
 	if err := json.Unmarshal(data, &meta); err != nil {
 		return nil, fmt.Errorf("invalid metadata: %w", err)
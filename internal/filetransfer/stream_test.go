@@ -5,6 +5,7 @@ import (
 	"compress/gzip"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"golang.org/x/crypto/bcrypt"
@@ -174,7 +175,7 @@ func TestStreamHandler_WriteUploadedFile(t *testing.T) {
 		content := []byte("hello world")
 		r := bytes.NewReader(content)
 
-		written, err := h.WriteUploadedFile(destPath, r, 0644, false, false)
+		written, err := h.WriteUploadedFile(destPath, r, 0644, false, false, CopyOptions{})
 		if err != nil {
 			t.Fatalf("WriteUploadedFile failed: %v", err)
 		}
@@ -204,7 +205,7 @@ func TestStreamHandler_WriteUploadedFile(t *testing.T) {
 		gzw.Write(content)
 		gzw.Close()
 
-		written, err := h.WriteUploadedFile(destPath, &buf, 0644, false, true)
+		written, err := h.WriteUploadedFile(destPath, &buf, 0644, false, true, CopyOptions{})
 		if err != nil {
 			t.Fatalf("WriteUploadedFile failed: %v", err)
 		}
@@ -231,7 +232,7 @@ func TestStreamHandler_WriteUploadedFile(t *testing.T) {
 
 		// Tar the directory
 		var buf bytes.Buffer
-		if err := TarDirectory(srcDir, &buf); err != nil {
+		if err := TarDirectory(srcDir, &buf, CopyOptions{}); err != nil {
 			t.Fatalf("TarDirectory failed: %v", err)
 		}
 
@@ -239,7 +240,7 @@ func TestStreamHandler_WriteUploadedFile(t *testing.T) {
 		destDir := t.TempDir()
 		destPath := filepath.Join(destDir, "extracted")
 
-		written, err := h.WriteUploadedFile(destPath, &buf, 0755, true, false)
+		written, err := h.WriteUploadedFile(destPath, &buf, 0755, true, false, CopyOptions{})
 		if err != nil {
 			t.Fatalf("WriteUploadedFile failed: %v", err)
 		}
@@ -275,7 +276,7 @@ func TestStreamHandler_ReadFileForDownload(t *testing.T) {
 		content := []byte("hello world")
 		os.WriteFile(srcPath, content, 0644)
 
-		r, size, mode, isDir, err := h.ReadFileForDownload(srcPath, false)
+		r, size, mode, isDir, err := h.ReadFileForDownload(srcPath, false, CopyOptions{})
 		if err != nil {
 			t.Fatalf("ReadFileForDownload failed: %v", err)
 		}
@@ -306,7 +307,7 @@ func TestStreamHandler_ReadFileForDownload(t *testing.T) {
 		content := []byte("hello world compressed read")
 		os.WriteFile(srcPath, content, 0644)
 
-		r, size, _, _, err := h.ReadFileForDownload(srcPath, true)
+		r, size, _, _, err := h.ReadFileForDownload(srcPath, true, CopyOptions{})
 		if err != nil {
 			t.Fatalf("ReadFileForDownload failed: %v", err)
 		}
@@ -343,7 +344,7 @@ func TestStreamHandler_ReadFileForDownload(t *testing.T) {
 		os.Mkdir(filepath.Join(srcDir, "subdir"), 0755)
 		os.WriteFile(filepath.Join(srcDir, "subdir", "file2.txt"), []byte("file2"), 0644)
 
-		r, size, _, isDir, err := h.ReadFileForDownload(srcDir, false)
+		r, size, _, isDir, err := h.ReadFileForDownload(srcDir, false, CopyOptions{})
 		if err != nil {
 			t.Fatalf("ReadFileForDownload failed: %v", err)
 		}
@@ -362,7 +363,7 @@ func TestStreamHandler_ReadFileForDownload(t *testing.T) {
 		}
 
 		destDir := t.TempDir()
-		if err := UntarDirectory(bytes.NewReader(tarData), destDir); err != nil {
+		if err := UntarDirectory(bytes.NewReader(tarData), destDir, CopyOptions{}); err != nil {
 			t.Fatalf("UntarDirectory failed: %v", err)
 		}
 
@@ -377,7 +378,7 @@ func TestStreamHandler_ReadFileForDownload(t *testing.T) {
 	})
 
 	t.Run("read nonexistent", func(t *testing.T) {
-		_, _, _, _, err := h.ReadFileForDownload("/nonexistent/path", false)
+		_, _, _, _, err := h.ReadFileForDownload("/nonexistent/path", false, CopyOptions{})
 		if err == nil {
 			t.Error("expected error for nonexistent path")
 		}
@@ -431,6 +432,13 @@ func TestMetadataEncoding(t *testing.T) {
 	}
 }
 
+func TestParseMetadata_TooLarge(t *testing.T) {
+	data := []byte(`{"path":"` + strings.Repeat("a", MaxMetadataSize) + `"}`)
+	if _, err := ParseMetadata(data); err == nil {
+		t.Error("ParseMetadata() accepted metadata larger than MaxMetadataSize")
+	}
+}
+
 func TestResultEncoding(t *testing.T) {
 	result := &TransferResult{
 		Success: true,
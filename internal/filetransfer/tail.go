@@ -0,0 +1,104 @@
+package filetransfer
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+)
+
+const (
+	// tailChunkSize bounds the data of one tail response so it fits in a
+	// single control response after base64 encoding.
+	tailChunkSize = 8 * 1024
+
+	maxTailLines = 1000
+)
+
+// browseTail reads a chunk of a regular file for "tail -f" style following.
+// With Lines set it returns the last lines of the file (as many as fit in
+// one chunk). Otherwise it returns the data from offset From, and the
+// caller passes Next as From in the following request. A file shorter than
+// From was truncated or rotated; it is then read from the start and
+// Rotated is set.
+func (h *StreamHandler) browseTail(req *BrowseRequest) *BrowseResponse {
+	cleanPath, errResp := h.requirePath(req.Path)
+	if errResp != nil {
+		return errResp
+	}
+	if req.From < 0 {
+		return &BrowseResponse{Error: "from must not be negative"}
+	}
+
+	f, err := os.Open(cleanPath)
+	if err != nil {
+		return &BrowseResponse{Error: fmt.Sprintf("path not found: %s", cleanPath)}
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return &BrowseResponse{Error: fmt.Sprintf("failed to stat: %v", err)}
+	}
+	if !info.Mode().IsRegular() {
+		return &BrowseResponse{Error: fmt.Sprintf("not a regular file: %s", cleanPath)}
+	}
+
+	size := info.Size()
+	resp := &BrowseResponse{Path: cleanPath, Size: size}
+	from := req.From
+	switch {
+	case req.Lines > 0:
+		from, err = tailLinesStart(f, size, min(req.Lines, maxTailLines))
+		if err != nil {
+			return &BrowseResponse{Error: fmt.Sprintf("failed to read file: %v", err)}
+		}
+	case from > size:
+		from = 0
+		resp.Rotated = true
+	}
+
+	buf := make([]byte, min(size-from, tailChunkSize))
+	n, err := f.ReadAt(buf, from)
+	if err != nil && err != io.EOF {
+		return &BrowseResponse{Error: fmt.Sprintf("failed to read file: %v", err)}
+	}
+	resp.Data = buf[:n]
+	resp.Next = from + int64(n)
+	resp.Truncated = resp.Next < size
+	return resp
+}
+
+// tailLinesStart returns the offset of the last lines of a file of the
+// given size, looking back at most tailChunkSize bytes. A final newline
+// does not start an empty line. When fewer lines fit, the offset of the
+// first complete line in the window is returned.
+func tailLinesStart(f *os.File, size int64, lines int) (int64, error) {
+	start := max(size-tailChunkSize, 0)
+	buf := make([]byte, size-start)
+	n, err := f.ReadAt(buf, start)
+	if err != nil && err != io.EOF {
+		return 0, err
+	}
+	buf = buf[:n]
+
+	end := len(buf)
+	if end > 0 && buf[end-1] == '\n' {
+		end--
+	}
+	for i := end - 1; i >= 0; i-- {
+		if buf[i] != '\n' {
+			continue
+		}
+		if lines--; lines == 0 {
+			return start + int64(i) + 1, nil
+		}
+	}
+	if start == 0 {
+		return 0, nil
+	}
+	if i := bytes.IndexByte(buf[:end], '\n'); i >= 0 {
+		return start + int64(i) + 1, nil
+	}
+	return start, nil
+}
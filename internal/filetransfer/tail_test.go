@@ -0,0 +1,110 @@
+package filetransfer
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBrowseTail_Follow(t *testing.T) {
+	dir := t.TempDir()
+	p := filepath.Join(dir, "app.log")
+	os.WriteFile(p, []byte("one\ntwo\n"), 0644)
+	h := NewStreamHandler(StreamConfig{Enabled: true, AllowedPaths: []string{"*"}})
+
+	resp := h.Browse(&BrowseRequest{Action: "tail", Path: p})
+	if resp.Error != "" {
+		t.Fatalf("unexpected error: %s", resp.Error)
+	}
+	if string(resp.Data) != "one\ntwo\n" || resp.Next != 8 || resp.Size != 8 || resp.Truncated {
+		t.Fatalf("got data %q next %d size %d truncated %v", resp.Data, resp.Next, resp.Size, resp.Truncated)
+	}
+
+	// Nothing new
+	resp = h.Browse(&BrowseRequest{Action: "tail", Path: p, From: resp.Next})
+	if len(resp.Data) != 0 || resp.Next != 8 {
+		t.Fatalf("without new data: got %q next %d", resp.Data, resp.Next)
+	}
+
+	f, _ := os.OpenFile(p, os.O_APPEND|os.O_WRONLY, 0644)
+	f.WriteString("three\n")
+	f.Close()
+	resp = h.Browse(&BrowseRequest{Action: "tail", Path: p, From: resp.Next})
+	if string(resp.Data) != "three\n" || resp.Next != 14 || resp.Rotated {
+		t.Fatalf("after append: got %q next %d rotated %v", resp.Data, resp.Next, resp.Rotated)
+	}
+
+	// Rotated: the file is now shorter than the offset
+	os.WriteFile(p, []byte("new\n"), 0644)
+	resp = h.Browse(&BrowseRequest{Action: "tail", Path: p, From: resp.Next})
+	if string(resp.Data) != "new\n" || resp.Next != 4 || !resp.Rotated {
+		t.Fatalf("after rotation: got %q next %d rotated %v", resp.Data, resp.Next, resp.Rotated)
+	}
+}
+
+func TestBrowseTail_Lines(t *testing.T) {
+	dir := t.TempDir()
+	p := filepath.Join(dir, "app.log")
+	os.WriteFile(p, []byte("a\nb\nc\nd\n"), 0644)
+	h := NewStreamHandler(StreamConfig{Enabled: true, AllowedPaths: []string{"*"}})
+
+	tests := []struct {
+		lines int
+		want  string
+	}{
+		{1, "d\n"},
+		{2, "c\nd\n"},
+		{10, "a\nb\nc\nd\n"},
+	}
+	for _, tt := range tests {
+		resp := h.Browse(&BrowseRequest{Action: "tail", Path: p, Lines: tt.lines})
+		if resp.Error != "" {
+			t.Fatalf("lines=%d: unexpected error: %s", tt.lines, resp.Error)
+		}
+		if string(resp.Data) != tt.want || resp.Next != 8 {
+			t.Errorf("lines=%d: got %q next %d, want %q next 8", tt.lines, resp.Data, resp.Next, tt.want)
+		}
+	}
+}
+
+func TestBrowseTail_LargeFile(t *testing.T) {
+	dir := t.TempDir()
+	p := filepath.Join(dir, "big.log")
+	line := strings.Repeat("x", 99) + "\n"
+	os.WriteFile(p, []byte(strings.Repeat(line, 200)), 0644) // 20000 bytes
+	h := NewStreamHandler(StreamConfig{Enabled: true, AllowedPaths: []string{"*"}})
+
+	resp := h.Browse(&BrowseRequest{Action: "tail", Path: p})
+	if len(resp.Data) != tailChunkSize || !resp.Truncated || resp.Next != tailChunkSize {
+		t.Fatalf("got %d bytes next %d truncated %v, want one chunk", len(resp.Data), resp.Next, resp.Truncated)
+	}
+
+	// More lines than fit in a chunk start at a line boundary
+	resp = h.Browse(&BrowseRequest{Action: "tail", Path: p, Lines: 500})
+	if len(resp.Data) > tailChunkSize || len(resp.Data)%len(line) != 0 || resp.Next != 20000 {
+		t.Fatalf("got %d bytes next %d, want whole lines up to the end", len(resp.Data), resp.Next)
+	}
+}
+
+func TestBrowseTail_Errors(t *testing.T) {
+	dir := t.TempDir()
+	p := filepath.Join(dir, "app.log")
+	os.WriteFile(p, []byte("data"), 0644)
+
+	h := NewStreamHandler(StreamConfig{Enabled: true, AllowedPaths: []string{dir}})
+	if resp := h.Browse(&BrowseRequest{Action: "tail", Path: dir}); resp.Error == "" {
+		t.Error("tail of a directory: expected error")
+	}
+	if resp := h.Browse(&BrowseRequest{Action: "tail", Path: p, From: -1}); resp.Error == "" {
+		t.Error("negative from: expected error")
+	}
+	if resp := h.Browse(&BrowseRequest{Action: "tail", Path: filepath.Join(dir, "missing")}); resp.Error == "" {
+		t.Error("missing file: expected error")
+	}
+	other := t.TempDir()
+	os.WriteFile(filepath.Join(other, "secret"), []byte("x"), 0644)
+	if resp := h.Browse(&BrowseRequest{Action: "tail", Path: filepath.Join(other, "secret")}); resp.Error == "" {
+		t.Error("path outside allowed_paths: expected error")
+	}
+}
@@ -11,9 +11,62 @@ import (
 	"strings"
 )
 
+// Symlink policies for directory archives (CopyOptions.Symlinks).
+const (
+	SymlinkPreserve = "preserve" // Archive links as links (default)
+	SymlinkFollow   = "follow"   // Archive the link target under the name of the link
+	SymlinkSkip     = "skip"     // Leave links out
+)
+
+// Hardlink policies for directory archives (CopyOptions.Hardlinks).
+const (
+	HardlinkPreserve = "preserve" // Archive further names of a file as hard links (default)
+	HardlinkCopy     = "copy"     // Archive the content under every name
+)
+
+// CopyOptions controls how transfers handle links and sparse files. The zero
+// value keeps symlinks and hard links as links and writes files in full.
+type CopyOptions struct {
+	// Symlinks and Hardlinks apply where a directory is archived.
+	Symlinks  string
+	Hardlinks string
+
+	// Sparse and ExternalSymlinks apply where files are written. Sparse
+	// leaves runs of zeros as holes. ExternalSymlinks keeps extracted
+	// symlinks that point outside the destination directory; without it they
+	// fail the extraction.
+	Sparse           bool
+	ExternalSymlinks bool
+
+	// allowTarget, when set, must accept the resolved target of a symlink
+	// before SymlinkFollow follows it. Rejected links are archived as links.
+	allowTarget func(path string) error
+}
+
+// Validate checks the policy names.
+func (o CopyOptions) Validate() error {
+	switch o.Symlinks {
+	case "", SymlinkPreserve, SymlinkFollow, SymlinkSkip:
+	default:
+		return fmt.Errorf("unknown symlink policy %q (want %s, %s or %s)", o.Symlinks, SymlinkPreserve, SymlinkFollow, SymlinkSkip)
+	}
+	switch o.Hardlinks {
+	case "", HardlinkPreserve, HardlinkCopy:
+	default:
+		return fmt.Errorf("unknown hardlink policy %q (want %s or %s)", o.Hardlinks, HardlinkPreserve, HardlinkCopy)
+	}
+	return nil
+}
+
 // TarDirectory streams a directory as a gzip-compressed tar archive to a writer.
 // The directory contents are written with paths relative to the directory itself.
-func TarDirectory(dir string, w io.Writer) error {
+// Symlinks and hard links are handled as opts says; sockets, devices and
+// FIFOs are skipped.
+func TarDirectory(dir string, w io.Writer, opts CopyOptions) error {
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+
 	// Clean and validate the source directory
 	dir = filepath.Clean(dir)
 	info, err := os.Stat(dir)
@@ -24,6 +77,12 @@ func TarDirectory(dir string, w io.Writer) error {
 		return fmt.Errorf("path is not a directory: %s", dir)
 	}
 
+	// Walk the real directory, so a symlinked root is not archived as a link
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return fmt.Errorf("cannot access directory: %w", err)
+	}
+
 	// Create gzip writer
 	gzw := gzip.NewWriter(w)
 	defer gzw.Close()
@@ -32,7 +91,20 @@ func TarDirectory(dir string, w io.Writer) error {
 	tw := tar.NewWriter(gzw)
 	defer tw.Close()
 
-	// Walk the directory tree
+	a := &archiver{tw: tw, opts: opts, links: make(map[fileID]string)}
+	return a.addTree(root, "")
+}
+
+// archiver writes the entries of a directory tree to a tar archive.
+type archiver struct {
+	tw    *tar.Writer
+	opts  CopyOptions
+	links map[fileID]string // Archived name of each file with several names
+	open  []string          // Directories holding the symlinks being followed
+}
+
+// addTree archives the contents of dir with names below prefix.
+func (a *archiver) addTree(dir, prefix string) error {
 	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
@@ -49,50 +121,148 @@ func TarDirectory(dir string, w io.Writer) error {
 			return nil
 		}
 
-		// Create tar header
-		header, err := tar.FileInfoHeader(info, "")
-		if err != nil {
-			return fmt.Errorf("failed to create tar header: %w", err)
-		}
-
 		// Use relative path with forward slashes (tar convention)
-		header.Name = filepath.ToSlash(relPath)
+		name := filepath.ToSlash(relPath)
+		if prefix != "" {
+			name = prefix + "/" + name
+		}
 
-		// Handle symlinks
 		if info.Mode()&os.ModeSymlink != 0 {
-			link, err := os.Readlink(path)
-			if err != nil {
-				return fmt.Errorf("failed to read symlink: %w", err)
+			switch a.opts.Symlinks {
+			case SymlinkSkip:
+				return nil
+			case SymlinkFollow:
+				target, resolved, ok := a.follow(path)
+				if !ok {
+					break
+				}
+				if err := a.addEntry(resolved, name, target); err != nil {
+					return err
+				}
+				if !target.IsDir() {
+					return nil
+				}
+				a.open = append(a.open, filepath.Dir(path))
+				err := a.addTree(resolved, name)
+				a.open = a.open[:len(a.open)-1]
+				return err
 			}
-			header.Linkname = link
 		}
 
-		// Write header
-		if err := tw.WriteHeader(header); err != nil {
-			return fmt.Errorf("failed to write tar header: %w", err)
-		}
+		return a.addEntry(path, name, info)
+	})
+}
 
-		// Write file content if it's a regular file
-		if info.Mode().IsRegular() {
-			file, err := os.Open(path)
-			if err != nil {
-				return fmt.Errorf("failed to open file: %w", err)
-			}
-			defer file.Close()
+// follow resolves a symlink for SymlinkFollow. It reports false for links
+// that cannot be followed, which are archived as links: dangling links,
+// targets rejected by allowTarget, and directories containing the link,
+// which would loop.
+func (a *archiver) follow(path string) (os.FileInfo, string, bool) {
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return nil, "", false
+	}
+	if a.opts.allowTarget != nil && a.opts.allowTarget(resolved) != nil {
+		return nil, "", false
+	}
+	info, err := os.Stat(resolved)
+	if err != nil {
+		return nil, "", false
+	}
 
-			if _, err := io.Copy(tw, file); err != nil {
-				return fmt.Errorf("failed to write file to tar: %w", err)
+	if info.IsDir() {
+		if isPathUnderPrefix(filepath.Dir(path), resolved) {
+			return nil, "", false
+		}
+		for _, dir := range a.open {
+			if isPathUnderPrefix(dir, resolved) {
+				return nil, "", false
 			}
 		}
+	}
+	return info, resolved, true
+}
 
+// addEntry archives a directory, regular file or symlink found at path
+// under name. Other file types are skipped.
+func (a *archiver) addEntry(path, name string, info os.FileInfo) error {
+	mode := info.Mode()
+	isSymlink := mode&os.ModeSymlink != 0
+	if !mode.IsDir() && !mode.IsRegular() && !isSymlink {
 		return nil
-	})
+	}
+
+	// Create tar header
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return fmt.Errorf("failed to create tar header: %w", err)
+	}
+	header.Name = name
+
+	// Handle symlinks
+	if isSymlink {
+		link, err := os.Readlink(path)
+		if err != nil {
+			return fmt.Errorf("failed to read symlink: %w", err)
+		}
+		header.Linkname = link
+	}
+
+	// Further names of a file already in the archive become hard links
+	if mode.IsRegular() && a.opts.Hardlinks != HardlinkCopy {
+		if id, ok := hardlinkID(info); ok {
+			if first, seen := a.links[id]; seen {
+				header.Typeflag = tar.TypeLink
+				header.Linkname = first
+				header.Size = 0
+				if err := a.tw.WriteHeader(header); err != nil {
+					return fmt.Errorf("failed to write tar header: %w", err)
+				}
+				return nil
+			}
+			a.links[id] = name
+		}
+	}
+
+	// Write header
+	if err := a.tw.WriteHeader(header); err != nil {
+		return fmt.Errorf("failed to write tar header: %w", err)
+	}
+
+	// Write file content if it's a regular file
+	if mode.IsRegular() {
+		file, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("failed to open file: %w", err)
+		}
+		defer file.Close()
+
+		if _, err := io.Copy(a.tw, file); err != nil {
+			return fmt.Errorf("failed to write file to tar: %w", err)
+		}
+	}
+
+	return nil
 }
 
 // UntarDirectory extracts a gzip-compressed tar archive from a reader to a destination directory.
 // It creates the destination directory if it doesn't exist.
 // For security, it validates paths to prevent directory traversal attacks.
-func UntarDirectory(r io.Reader, destDir string) error {
+func UntarDirectory(r io.Reader, destDir string, opts CopyOptions) error {
+	// Create gzip reader
+	gzr, err := gzip.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("failed to create gzip reader: %w", err)
+	}
+	defer gzr.Close()
+
+	return ExtractTar(gzr, destDir, opts)
+}
+
+// ExtractTar extracts an uncompressed tar archive to a destination directory,
+// like UntarDirectory. Entries are never written through a symlink, so links
+// in the archive cannot redirect later entries outside destDir.
+func ExtractTar(r io.Reader, destDir string, opts CopyOptions) error {
 	// Clean destination directory
 	destDir = filepath.Clean(destDir)
 
@@ -101,15 +271,8 @@ func UntarDirectory(r io.Reader, destDir string) error {
 		return fmt.Errorf("failed to create destination directory: %w", err)
 	}
 
-	// Create gzip reader
-	gzr, err := gzip.NewReader(r)
-	if err != nil {
-		return fmt.Errorf("failed to create gzip reader: %w", err)
-	}
-	defer gzr.Close()
-
 	// Create tar reader
-	tr := tar.NewReader(gzr)
+	tr := tar.NewReader(r)
 
 	for {
 		header, err := tr.Next()
@@ -125,6 +288,9 @@ func UntarDirectory(r io.Reader, destDir string) error {
 		if err != nil {
 			return err
 		}
+		if err := checkSymlinkParents(destDir, targetPath); err != nil {
+			return err
+		}
 
 		switch header.Typeflag {
 		case tar.TypeDir:
@@ -139,23 +305,36 @@ func UntarDirectory(r io.Reader, destDir string) error {
 				return fmt.Errorf("failed to create parent directory: %w", err)
 			}
 
+			// Replace an existing symlink instead of writing through it
+			if info, err := os.Lstat(targetPath); err == nil && info.Mode()&os.ModeSymlink != 0 {
+				os.Remove(targetPath)
+			}
+
 			// Create file
 			file, err := os.OpenFile(targetPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(header.Mode))
 			if err != nil {
 				return fmt.Errorf("failed to create file %s: %w", targetPath, err)
 			}
+			fw, err := fileWriter(file, opts.Sparse)
+			if err != nil {
+				return fmt.Errorf("failed to write file %s: %w", targetPath, err)
+			}
 
 			// Copy content with size limit to prevent tar bombs
-			if _, err := io.Copy(file, io.LimitReader(tr, 1<<30)); err != nil {
-				file.Close()
+			_, err = io.Copy(fw, io.LimitReader(tr, 1<<30))
+			if closeErr := fw.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
 				return fmt.Errorf("failed to write file %s: %w", targetPath, err)
 			}
-			file.Close()
 
 		case tar.TypeSymlink:
 			// Validate symlink target
-			if err := validateSymlink(destDir, targetPath, header.Linkname); err != nil {
-				return err
+			if !opts.ExternalSymlinks {
+				if err := validateSymlink(destDir, targetPath, header.Linkname); err != nil {
+					return err
+				}
 			}
 
 			// Create parent directories if needed
@@ -177,6 +356,15 @@ func UntarDirectory(r io.Reader, destDir string) error {
 			if err != nil {
 				return err
 			}
+			if err := checkSymlinkParents(destDir, linkTarget); err != nil {
+				return err
+			}
+
+			// Some systems link to the target of a symlink rather than the
+			// symlink itself, which may be outside destDir
+			if info, err := os.Lstat(linkTarget); err == nil && info.Mode()&os.ModeSymlink != 0 {
+				return fmt.Errorf("hard link to symlink not allowed: %s -> %s", header.Name, header.Linkname)
+			}
 
 			// Create parent directories if needed
 			if err := os.MkdirAll(filepath.Dir(targetPath), 0755); err != nil {
@@ -200,6 +388,28 @@ func UntarDirectory(r io.Reader, destDir string) error {
 	return nil
 }
 
+// checkSymlinkParents fails if a directory between destDir and path is a
+// symlink. Missing directories are fine; they are created as directories.
+func checkSymlinkParents(destDir, path string) error {
+	rel, err := filepath.Rel(destDir, filepath.Dir(path))
+	if err != nil || rel == "." {
+		return nil
+	}
+
+	dir := destDir
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		dir = filepath.Join(dir, part)
+		info, err := os.Lstat(dir)
+		if err != nil {
+			return nil
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("path goes through a symlink: %s", path)
+		}
+	}
+	return nil
+}
+
 // sanitizeTarPath validates and sanitizes a tar entry path to prevent directory traversal.
 func sanitizeTarPath(destDir, name string) (string, error) {
 	// Convert to OS path separator and clean
@@ -1,9 +1,14 @@
 package filetransfer
 
 import (
+	"archive/tar"
 	"bytes"
+	"compress/gzip"
+	"io"
 	"os"
 	"path/filepath"
+	"runtime"
+	"strings"
 	"testing"
 )
 
@@ -29,7 +34,7 @@ func TestTarDirectory_Basic(t *testing.T) {
 
 	// Tar the directory
 	var buf bytes.Buffer
-	if err := TarDirectory(srcDir, &buf); err != nil {
+	if err := TarDirectory(srcDir, &buf, CopyOptions{}); err != nil {
 		t.Fatalf("TarDirectory failed: %v", err)
 	}
 
@@ -40,7 +45,7 @@ func TestTarDirectory_Basic(t *testing.T) {
 
 	// Untar to a new directory
 	destDir := t.TempDir()
-	if err := UntarDirectory(&buf, destDir); err != nil {
+	if err := UntarDirectory(&buf, destDir, CopyOptions{}); err != nil {
 		t.Fatalf("UntarDirectory failed: %v", err)
 	}
 
@@ -74,7 +79,7 @@ func TestTarDirectory_EmptyDir(t *testing.T) {
 	srcDir := t.TempDir()
 
 	var buf bytes.Buffer
-	if err := TarDirectory(srcDir, &buf); err != nil {
+	if err := TarDirectory(srcDir, &buf, CopyOptions{}); err != nil {
 		t.Fatalf("TarDirectory failed on empty dir: %v", err)
 	}
 
@@ -84,7 +89,7 @@ func TestTarDirectory_EmptyDir(t *testing.T) {
 	}
 
 	destDir := t.TempDir()
-	if err := UntarDirectory(&buf, destDir); err != nil {
+	if err := UntarDirectory(&buf, destDir, CopyOptions{}); err != nil {
 		t.Fatalf("UntarDirectory failed: %v", err)
 	}
 }
@@ -99,12 +104,12 @@ func TestTarDirectory_PreservesPermissions(t *testing.T) {
 	}
 
 	var buf bytes.Buffer
-	if err := TarDirectory(srcDir, &buf); err != nil {
+	if err := TarDirectory(srcDir, &buf, CopyOptions{}); err != nil {
 		t.Fatalf("TarDirectory failed: %v", err)
 	}
 
 	destDir := t.TempDir()
-	if err := UntarDirectory(&buf, destDir); err != nil {
+	if err := UntarDirectory(&buf, destDir, CopyOptions{}); err != nil {
 		t.Fatalf("UntarDirectory failed: %v", err)
 	}
 
@@ -130,7 +135,7 @@ func TestTarDirectory_NotADirectory(t *testing.T) {
 	tmpFile.Close()
 
 	var buf bytes.Buffer
-	err = TarDirectory(tmpFile.Name(), &buf)
+	err = TarDirectory(tmpFile.Name(), &buf, CopyOptions{})
 	if err == nil {
 		t.Fatal("expected error when tarring a file, not a directory")
 	}
@@ -235,12 +240,12 @@ func TestTarDirectory_WithSymlinks(t *testing.T) {
 	}
 
 	var buf bytes.Buffer
-	if err := TarDirectory(srcDir, &buf); err != nil {
+	if err := TarDirectory(srcDir, &buf, CopyOptions{}); err != nil {
 		t.Fatalf("TarDirectory failed: %v", err)
 	}
 
 	destDir := t.TempDir()
-	if err := UntarDirectory(&buf, destDir); err != nil {
+	if err := UntarDirectory(&buf, destDir, CopyOptions{}); err != nil {
 		t.Fatalf("UntarDirectory failed: %v", err)
 	}
 
@@ -280,7 +285,7 @@ func TestTarDirectory_LargeFile(t *testing.T) {
 	}
 
 	var buf bytes.Buffer
-	if err := TarDirectory(srcDir, &buf); err != nil {
+	if err := TarDirectory(srcDir, &buf, CopyOptions{}); err != nil {
 		t.Fatalf("TarDirectory failed: %v", err)
 	}
 
@@ -288,7 +293,7 @@ func TestTarDirectory_LargeFile(t *testing.T) {
 	t.Logf("Original size: %d, Compressed size: %d", len(largeData), buf.Len())
 
 	destDir := t.TempDir()
-	if err := UntarDirectory(&buf, destDir); err != nil {
+	if err := UntarDirectory(&buf, destDir, CopyOptions{}); err != nil {
 		t.Fatalf("UntarDirectory failed: %v", err)
 	}
 
@@ -307,3 +312,188 @@ func TestTarDirectory_LargeFile(t *testing.T) {
 		}
 	}
 }
+
+// tarEntries tars dir with opts and returns the archived headers by name.
+func tarEntries(t *testing.T, dir string, opts CopyOptions) (map[string]*tar.Header, []byte) {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := TarDirectory(dir, &buf, opts); err != nil {
+		t.Fatalf("TarDirectory failed: %v", err)
+	}
+	archive := buf.Bytes()
+
+	gzr, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gzr)
+	headers := make(map[string]*tar.Header)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		headers[h.Name] = h
+	}
+	return headers, archive
+}
+
+func TestTarDirectory_Hardlinks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("hard links are not detected on Windows")
+	}
+
+	srcDir := t.TempDir()
+	os.WriteFile(filepath.Join(srcDir, "a.bin"), []byte("shared content"), 0644)
+	if err := os.Link(filepath.Join(srcDir, "a.bin"), filepath.Join(srcDir, "b.bin")); err != nil {
+		t.Skipf("hard links not supported: %v", err)
+	}
+
+	t.Run("preserve", func(t *testing.T) {
+		headers, archive := tarEntries(t, srcDir, CopyOptions{})
+		if h := headers["b.bin"]; h == nil || h.Typeflag != tar.TypeLink || h.Linkname != "a.bin" {
+			t.Fatalf("b.bin header = %+v, want hard link to a.bin", h)
+		}
+
+		destDir := t.TempDir()
+		if err := UntarDirectory(bytes.NewReader(archive), destDir, CopyOptions{}); err != nil {
+			t.Fatalf("UntarDirectory failed: %v", err)
+		}
+		a, _ := os.Stat(filepath.Join(destDir, "a.bin"))
+		b, _ := os.Stat(filepath.Join(destDir, "b.bin"))
+		if a == nil || b == nil || !os.SameFile(a, b) {
+			t.Error("extracted names are not the same file")
+		}
+	})
+
+	t.Run("copy", func(t *testing.T) {
+		headers, _ := tarEntries(t, srcDir, CopyOptions{Hardlinks: HardlinkCopy})
+		for _, name := range []string{"a.bin", "b.bin"} {
+			if h := headers[name]; h == nil || h.Typeflag != tar.TypeReg || h.Size != 14 {
+				t.Errorf("%s header = %+v, want regular file with content", name, h)
+			}
+		}
+	})
+}
+
+func TestTarDirectory_SymlinkPolicies(t *testing.T) {
+	srcDir := t.TempDir()
+	outside := t.TempDir()
+	os.WriteFile(filepath.Join(srcDir, "file.txt"), []byte("inside"), 0644)
+	os.WriteFile(filepath.Join(outside, "ext.txt"), []byte("outside"), 0644)
+	if err := os.Symlink("file.txt", filepath.Join(srcDir, "link.txt")); err != nil {
+		t.Skip("symlinks not supported on this platform")
+	}
+	os.Symlink(outside, filepath.Join(srcDir, "extdir"))
+	os.Symlink("missing", filepath.Join(srcDir, "dangling"))
+	os.Symlink("..", filepath.Join(srcDir, "loop"))
+
+	t.Run("preserve", func(t *testing.T) {
+		headers, _ := tarEntries(t, srcDir, CopyOptions{Symlinks: SymlinkPreserve})
+		for _, name := range []string{"link.txt", "extdir", "dangling", "loop"} {
+			if h := headers[name]; h == nil || h.Typeflag != tar.TypeSymlink {
+				t.Errorf("%s header = %+v, want symlink", name, h)
+			}
+		}
+	})
+
+	t.Run("follow", func(t *testing.T) {
+		headers, _ := tarEntries(t, srcDir, CopyOptions{Symlinks: SymlinkFollow})
+		if h := headers["link.txt"]; h == nil || h.Typeflag != tar.TypeReg || h.Size != 6 {
+			t.Errorf("link.txt header = %+v, want regular file", h)
+		}
+		if h := headers["extdir"]; h == nil || h.Typeflag != tar.TypeDir {
+			t.Errorf("extdir header = %+v, want directory", h)
+		}
+		if h := headers["extdir/ext.txt"]; h == nil || h.Typeflag != tar.TypeReg {
+			t.Errorf("extdir/ext.txt header = %+v, want regular file", h)
+		}
+		// Links that cannot be followed stay links
+		for _, name := range []string{"dangling", "loop"} {
+			if h := headers[name]; h == nil || h.Typeflag != tar.TypeSymlink {
+				t.Errorf("%s header = %+v, want symlink", name, h)
+			}
+		}
+	})
+
+	t.Run("follow rejected target", func(t *testing.T) {
+		opts := CopyOptions{Symlinks: SymlinkFollow}
+		opts.allowTarget = func(path string) error {
+			if isPathUnderPrefix(path, outside) {
+				return os.ErrPermission
+			}
+			return nil
+		}
+		headers, _ := tarEntries(t, srcDir, opts)
+		if h := headers["extdir"]; h == nil || h.Typeflag != tar.TypeSymlink {
+			t.Errorf("extdir header = %+v, want symlink", h)
+		}
+		if _, ok := headers["extdir/ext.txt"]; ok {
+			t.Error("content of a rejected target was archived")
+		}
+	})
+
+	t.Run("skip", func(t *testing.T) {
+		headers, _ := tarEntries(t, srcDir, CopyOptions{Symlinks: SymlinkSkip})
+		if len(headers) != 1 || headers["file.txt"] == nil {
+			t.Errorf("archived %d entries, want only file.txt", len(headers))
+		}
+	})
+}
+
+func TestTarDirectory_InvalidPolicy(t *testing.T) {
+	var buf bytes.Buffer
+	if err := TarDirectory(t.TempDir(), &buf, CopyOptions{Symlinks: "dereference"}); err == nil {
+		t.Error("expected error for unknown symlink policy")
+	}
+	if err := (CopyOptions{Hardlinks: "link"}).Validate(); err == nil {
+		t.Error("expected error for unknown hardlink policy")
+	}
+}
+
+func TestUntarDirectory_ExternalSymlinks(t *testing.T) {
+	var buf bytes.Buffer
+	gzw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gzw)
+	tw.WriteHeader(&tar.Header{Name: "localtime", Typeflag: tar.TypeSymlink, Linkname: "/usr/share/zoneinfo/UTC"})
+	tw.Close()
+	gzw.Close()
+	archive := buf.Bytes()
+
+	if err := UntarDirectory(bytes.NewReader(archive), t.TempDir(), CopyOptions{}); err == nil {
+		t.Error("expected external symlink to be rejected by default")
+	}
+
+	destDir := t.TempDir()
+	if err := UntarDirectory(bytes.NewReader(archive), destDir, CopyOptions{ExternalSymlinks: true}); err != nil {
+		t.Fatalf("UntarDirectory failed: %v", err)
+	}
+	if target, err := os.Readlink(filepath.Join(destDir, "localtime")); err != nil || target != "/usr/share/zoneinfo/UTC" {
+		t.Errorf("symlink target = %q, %v", target, err)
+	}
+}
+
+func TestUntarDirectory_NoWriteThroughSymlink(t *testing.T) {
+	outside := t.TempDir()
+
+	var buf bytes.Buffer
+	gzw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gzw)
+	tw.WriteHeader(&tar.Header{Name: "escape", Typeflag: tar.TypeSymlink, Linkname: outside})
+	tw.WriteHeader(&tar.Header{Name: "escape/pwned.txt", Typeflag: tar.TypeReg, Mode: 0644, Size: 5})
+	tw.Write([]byte("pwned"))
+	tw.Close()
+	gzw.Close()
+
+	err := UntarDirectory(&buf, t.TempDir(), CopyOptions{ExternalSymlinks: true})
+	if err == nil || !strings.Contains(err.Error(), "through a symlink") {
+		t.Errorf("UntarDirectory error = %v, want write through symlink rejected", err)
+	}
+	if _, err := os.Stat(filepath.Join(outside, "pwned.txt")); !os.IsNotExist(err) {
+		t.Error("file was written outside the destination")
+	}
+}
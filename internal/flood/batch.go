@@ -0,0 +1,217 @@
+package flood
+
+import (
+	"encoding/binary"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/postalsys/muti-metroo/internal/identity"
+	"github.com/postalsys/muti-metroo/internal/protocol"
+	"github.com/postalsys/muti-metroo/internal/recovery"
+)
+
+// maxBatchUpdates flushes a batch early once this many updates are pending,
+// bounding memory during a large advertisement storm.
+const maxBatchUpdates = 1024
+
+// routeUpdate is a deduplicated route advertisement or withdrawal waiting
+// to be applied.
+type routeUpdate struct {
+	withdraw          bool
+	alternate         bool // Duplicate advertisement recorded as alternate next hops
+	fromPeer          identity.AgentID
+	originAgent       identity.AgentID
+	originDisplayName string
+	namespace         string
+	sequence          uint64
+	routes            []protocol.Route
+	encPath           *protocol.EncryptedData
+	seenBy            []identity.AgentID
+	signature         *protocol.RouteSignature
+}
+
+// BatchStats contains route update batching counters.
+type BatchStats struct {
+	Received uint64 // Updates queued for batching
+	Merged   uint64 // Advertisements replaced by a newer one in the same batch
+	Batches  uint64 // Batches dispatched to workers
+}
+
+// floodBatcher collects route updates over a short window and hands them to
+// a pool of workers.
+//
+// Within a window, a newer advertisement for the same origin from the same
+// peer replaces the pending one in place, so the routing table is updated
+// and the advertisement flooded once instead of once per update. A
+// withdrawal is never merged and ends merging for its origin, so updates on
+// either side of it keep their order. Updates are sharded by origin agent,
+// which keeps per-origin ordering across batches.
+type floodBatcher struct {
+	f      *Flooder
+	window time.Duration
+
+	mu      sync.Mutex
+	pending []*routeUpdate
+	index   map[identity.AgentID]map[identity.AgentID]int // origin -> fromPeer -> position in pending
+	timer   *time.Timer
+
+	// dispatchMu serializes flushes so batches reach workers in order
+	dispatchMu sync.Mutex
+	workers    []chan []*routeUpdate
+
+	received atomic.Uint64
+	merged   atomic.Uint64
+	batches  atomic.Uint64
+}
+
+// newFloodBatcher creates a batcher and starts its workers on f.wg.
+func newFloodBatcher(f *Flooder, window time.Duration, workers int) *floodBatcher {
+	if workers <= 0 {
+		workers = 1
+	}
+	b := &floodBatcher{
+		f:       f,
+		window:  window,
+		index:   make(map[identity.AgentID]map[identity.AgentID]int),
+		workers: make([]chan []*routeUpdate, workers),
+	}
+	for i := range b.workers {
+		b.workers[i] = make(chan []*routeUpdate, 16)
+		f.wg.Add(1)
+		go b.worker(b.workers[i])
+	}
+	return b
+}
+
+// add queues an update for the current batch.
+func (b *floodBatcher) add(u *routeUpdate) {
+	b.received.Add(1)
+
+	b.mu.Lock()
+	if u.withdraw {
+		b.pending = append(b.pending, u)
+		delete(b.index, u.originAgent)
+	} else if u.alternate {
+		b.pending = append(b.pending, u)
+	} else if pos, ok := b.index[u.originAgent][u.fromPeer]; ok {
+		b.pending[pos] = u
+		b.merged.Add(1)
+		b.mu.Unlock()
+		return
+	} else {
+		byPeer := b.index[u.originAgent]
+		if byPeer == nil {
+			byPeer = make(map[identity.AgentID]int)
+			b.index[u.originAgent] = byPeer
+		}
+		byPeer[u.fromPeer] = len(b.pending)
+		b.pending = append(b.pending, u)
+	}
+
+	count := len(b.pending)
+	if count == 1 {
+		b.timer = time.AfterFunc(b.window, b.flush)
+	}
+	b.mu.Unlock()
+
+	if count >= maxBatchUpdates {
+		b.flush()
+	}
+}
+
+// flush dispatches the pending batch to the workers.
+func (b *floodBatcher) flush() {
+	b.dispatchMu.Lock()
+	defer b.dispatchMu.Unlock()
+
+	b.mu.Lock()
+	batch := b.pending
+	b.pending = nil
+	b.index = make(map[identity.AgentID]map[identity.AgentID]int)
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	b.mu.Unlock()
+
+	if len(batch) == 0 {
+		return
+	}
+	b.batches.Add(1)
+
+	shards := make([][]*routeUpdate, len(b.workers))
+	for _, u := range batch {
+		i := b.shard(u.originAgent)
+		shards[i] = append(shards[i], u)
+	}
+	for i, shard := range shards {
+		if len(shard) == 0 {
+			continue
+		}
+		select {
+		case b.workers[i] <- shard:
+		case <-b.f.stopCh:
+			return
+		}
+	}
+}
+
+// shard returns the worker index for an origin agent.
+func (b *floodBatcher) shard(origin identity.AgentID) int {
+	return int(binary.BigEndian.Uint32(origin[:4]) % uint32(len(b.workers)))
+}
+
+// worker applies batches until the flooder stops.
+func (b *floodBatcher) worker(ch chan []*routeUpdate) {
+	defer b.f.wg.Done()
+	defer recovery.RecoverWithLog(b.f.logger, "flood.batchWorker")
+
+	for {
+		select {
+		case <-b.f.stopCh:
+			return
+		case batch := <-ch:
+			for _, u := range batch {
+				b.f.applyRouteUpdate(u)
+			}
+		}
+	}
+}
+
+// stop cancels a pending flush. Queued updates are dropped.
+func (b *floodBatcher) stop() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	b.pending = nil
+}
+
+// applyRouteUpdate applies a batched update to the routing table and floods it.
+func (f *Flooder) applyRouteUpdate(u *routeUpdate) {
+	if u.alternate {
+		f.processAlternateAdvertise(u)
+		return
+	}
+	if u.withdraw {
+		f.processRouteWithdraw(u)
+		return
+	}
+	f.processRouteAdvertise(u)
+}
+
+// BatchStats returns route update batching counters. All zero when
+// batching is disabled.
+func (f *Flooder) BatchStats() BatchStats {
+	if f.batcher == nil {
+		return BatchStats{}
+	}
+	return BatchStats{
+		Received: f.batcher.received.Load(),
+		Merged:   f.batcher.merged.Load(),
+		Batches:  f.batcher.batches.Load(),
+	}
+}
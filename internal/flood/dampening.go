@@ -0,0 +1,278 @@
+package flood
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/postalsys/muti-metroo/internal/identity"
+	"github.com/postalsys/muti-metroo/internal/protocol"
+)
+
+// DampeningConfig configures route flap dampening.
+//
+// Every withdrawal from an origin, and every advertisement that changes the
+// origin's route set, is a flap and adds 1 to the origin's penalty. The
+// penalty halves every HalfLife. Once it exceeds MaxFlaps, advertisements
+// from the origin are dropped instead of being applied and flooded, until
+// the penalty has decayed below half of MaxFlaps or MaxSuppress has passed.
+type DampeningConfig struct {
+	MaxFlaps    int
+	HalfLife    time.Duration
+	MaxSuppress time.Duration
+}
+
+// DampenedOrigin is the dampening state of an origin agent.
+type DampenedOrigin struct {
+	Origin     identity.AgentID
+	Penalty    float64   // Decayed flap penalty
+	Flaps      int       // Flaps counted since the origin was first tracked
+	Suppressed bool      // Advertisements from the origin are dropped
+	Since      time.Time // When the origin was suppressed
+	ReuseAt    time.Time // When the origin is released if it stops flapping
+	Routes     []string  // Routes of the newest advertisement
+}
+
+// dampState tracks the flaps of one origin.
+type dampState struct {
+	penalty      float64
+	updated      time.Time // When penalty was last decayed
+	lastUpdate   time.Time // Newest advertisement or withdrawal
+	flaps        int
+	routeSet     string // Canonical form of the newest advertised routes
+	routes       []protocol.Route
+	withdrawn    bool // The newest update was a withdrawal
+	suppressed   bool
+	suppressedAt time.Time
+}
+
+// dampener applies flap dampening per origin agent.
+type dampener struct {
+	cfg    DampeningConfig
+	reuse  float64
+	logger *slog.Logger
+	now    func() time.Time
+
+	mu      sync.Mutex
+	origins map[identity.AgentID]*dampState
+}
+
+// newDampener creates a dampener.
+func newDampener(cfg DampeningConfig, logger *slog.Logger) *dampener {
+	return &dampener{
+		cfg:     cfg,
+		reuse:   float64(cfg.MaxFlaps) / 2,
+		logger:  logger,
+		now:     time.Now,
+		origins: make(map[identity.AgentID]*dampState),
+	}
+}
+
+// decay applies the exponential decay since the last update and releases
+// the origin when it is allowed back. Must be called with d.mu held.
+func (d *dampener) decay(origin identity.AgentID, s *dampState, now time.Time) {
+	if elapsed := now.Sub(s.updated); elapsed > 0 {
+		s.penalty *= math.Exp2(-float64(elapsed) / float64(d.cfg.HalfLife))
+		s.updated = now
+	}
+	if s.suppressed && (s.penalty < d.reuse || now.Sub(s.suppressedAt) >= d.cfg.MaxSuppress) {
+		s.suppressed = false
+		d.logger.Info("route origin released from dampening",
+			"origin", origin.ShortString(),
+			"penalty", math.Round(s.penalty*100)/100,
+			"suppressed_for", now.Sub(s.suppressedAt).Round(time.Second))
+	}
+}
+
+// flap adds a flap to the origin's penalty and suppresses the origin when
+// the penalty exceeds MaxFlaps. Must be called with d.mu held.
+func (d *dampener) flap(origin identity.AgentID, s *dampState, now time.Time) {
+	s.penalty++
+	s.flaps++
+	if !s.suppressed && s.penalty > float64(d.cfg.MaxFlaps) {
+		s.suppressed = true
+		s.suppressedAt = now
+		d.logger.Warn("route origin suppressed: routes flapping",
+			"origin", origin.ShortString(),
+			"penalty", math.Round(s.penalty*100)/100,
+			"flaps", s.flaps)
+	}
+}
+
+// state returns the state of an origin, creating it when needed. Must be
+// called with d.mu held.
+func (d *dampener) state(origin identity.AgentID, now time.Time) *dampState {
+	s := d.origins[origin]
+	if s == nil {
+		s = &dampState{updated: now}
+		d.origins[origin] = s
+	}
+	return s
+}
+
+// advertise records a new advertisement from an origin. Returns true when
+// the advertisement must be dropped.
+func (d *dampener) advertise(origin identity.AgentID, routes []protocol.Route) bool {
+	now := d.now()
+	set := routeSetKey(routes)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	s, known := d.origins[origin]
+	if !known {
+		s = d.state(origin, now)
+	}
+	d.decay(origin, s, now)
+	if known && !s.withdrawn && s.routeSet != set {
+		d.flap(origin, s, now)
+	}
+	s.routeSet, s.routes, s.withdrawn = set, routes, false
+	s.lastUpdate = now
+	return s.suppressed
+}
+
+// withdraw records a new withdrawal from an origin. Withdrawals are always
+// applied, but count as a flap.
+func (d *dampener) withdraw(origin identity.AgentID) {
+	now := d.now()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	s := d.state(origin, now)
+	d.decay(origin, s, now)
+	d.flap(origin, s, now)
+	s.withdrawn = true
+	s.lastUpdate = now
+}
+
+// isSuppressed reports whether advertisements from an origin are dropped.
+func (d *dampener) isSuppressed(origin identity.AgentID) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	s := d.origins[origin]
+	if s == nil {
+		return false
+	}
+	d.decay(origin, s, d.now())
+	return s.suppressed
+}
+
+// list returns the origins with a penalty, suppressed origins first and
+// then by penalty.
+func (d *dampener) list() []DampenedOrigin {
+	now := d.now()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var out []DampenedOrigin
+	for origin, s := range d.origins {
+		d.decay(origin, s, now)
+		if !s.suppressed && s.penalty < 0.01 {
+			continue
+		}
+		o := DampenedOrigin{
+			Origin:     origin,
+			Penalty:    math.Round(s.penalty*100) / 100,
+			Flaps:      s.flaps,
+			Suppressed: s.suppressed,
+		}
+		if s.suppressed {
+			o.Since = s.suppressedAt
+			o.ReuseAt = s.suppressedAt.Add(d.cfg.MaxSuppress)
+			if s.penalty > d.reuse {
+				decayed := now.Add(time.Duration(math.Log2(s.penalty/d.reuse) * float64(d.cfg.HalfLife)))
+				if decayed.Before(o.ReuseAt) {
+					o.ReuseAt = decayed
+				}
+			}
+		}
+		for _, r := range s.routes {
+			o.Routes = append(o.Routes, routeString(r))
+		}
+		out = append(out, o)
+	}
+	slices.SortFunc(out, func(a, b DampenedOrigin) int {
+		if a.Suppressed != b.Suppressed {
+			if a.Suppressed {
+				return -1
+			}
+			return 1
+		}
+		if a.Penalty != b.Penalty {
+			if a.Penalty > b.Penalty {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(a.Origin.String(), b.Origin.String())
+	})
+	return out
+}
+
+// cleanup forgets origins without a penalty that sent nothing for longer
+// than idle.
+func (d *dampener) cleanup(idle time.Duration) {
+	now := d.now()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for origin, s := range d.origins {
+		d.decay(origin, s, now)
+		if !s.suppressed && s.penalty < 0.01 && now.Sub(s.lastUpdate) > idle {
+			delete(d.origins, origin)
+		}
+	}
+}
+
+// routeSetKey returns a canonical form of a route set, independent of the
+// order of the routes.
+func routeSetKey(routes []protocol.Route) string {
+	keys := make([]string, len(routes))
+	for i, r := range routes {
+		keys[i] = fmt.Sprintf("%d/%d/%x/%d", r.AddressFamily, r.PrefixLength, r.Prefix, r.Metric)
+	}
+	slices.Sort(keys)
+	return strings.Join(keys, ",")
+}
+
+// routeString returns a human-readable form of an advertised route.
+func routeString(r protocol.Route) string {
+	switch r.AddressFamily {
+	case protocol.AddrFamilyDomain:
+		return "domain:" + protocol.DecodeDomainPrefix(r.Prefix)
+	case protocol.AddrFamilyForward:
+		key, _ := protocol.DecodeForwardKeyAndTarget(r.Prefix)
+		return "forward:" + key
+	case protocol.AddrFamilyAgent:
+		return "agent:" + protocol.DecodeAgentPrefix(r.Prefix).ShortString()
+	default:
+		if ipNet := protocolRouteToIPNet(r); ipNet != nil {
+			return ipNet.String()
+		}
+		return fmt.Sprintf("family %d", r.AddressFamily)
+	}
+}
+
+// suppressed reports whether advertisements from an origin are dropped by
+// dampening.
+func (f *Flooder) suppressed(origin identity.AgentID) bool {
+	return f.dampener != nil && f.dampener.isSuppressed(origin)
+}
+
+// DampenedOrigins returns the origins with a flap penalty, suppressed
+// origins first. Returns nil when dampening is disabled.
+func (f *Flooder) DampenedOrigins() []DampenedOrigin {
+	if f.dampener == nil {
+		return nil
+	}
+	return f.dampener.list()
+}
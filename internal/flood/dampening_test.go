@@ -0,0 +1,154 @@
+package flood
+
+import (
+	"testing"
+	"time"
+
+	"github.com/postalsys/muti-metroo/internal/identity"
+	"github.com/postalsys/muti-metroo/internal/logging"
+	"github.com/postalsys/muti-metroo/internal/protocol"
+	"github.com/postalsys/muti-metroo/internal/routing"
+)
+
+func testRoute(octet byte) []protocol.Route {
+	return []protocol.Route{{
+		AddressFamily: protocol.AddrFamilyIPv4,
+		PrefixLength:  24,
+		Prefix:        []byte{10, octet, 0, 0},
+		Metric:        1,
+	}}
+}
+
+func TestDampener_SuppressAndDecay(t *testing.T) {
+	origin, _ := identity.NewAgentID()
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	d := newDampener(DampeningConfig{MaxFlaps: 3, HalfLife: time.Minute, MaxSuppress: time.Hour}, logging.NopLogger())
+	d.now = func() time.Time { return now }
+
+	// Periodic refreshes with the same routes are not flaps
+	for range 5 {
+		if d.advertise(origin, testRoute(1)) {
+			t.Fatal("stable origin suppressed")
+		}
+	}
+
+	// Withdraw and re-advertise: one flap per cycle
+	for i := range 3 {
+		d.withdraw(origin)
+		if d.advertise(origin, testRoute(1)) {
+			t.Fatalf("suppressed after %d flaps", i+1)
+		}
+	}
+
+	// A changed route set is the fourth flap
+	if !d.advertise(origin, testRoute(2)) {
+		t.Fatal("not suppressed after 4 flaps")
+	}
+
+	list := d.list()
+	if len(list) != 1 || !list[0].Suppressed || list[0].Flaps != 4 {
+		t.Fatalf("list = %+v", list)
+	}
+	if list[0].Routes[0] != "10.2.0.0/24" {
+		t.Errorf("routes = %v", list[0].Routes)
+	}
+
+	// Penalty 4 reaches the reuse threshold of 1.5 after 1.42 half-lives
+	if reuse := list[0].ReuseAt.Sub(now); reuse < 84*time.Second || reuse > 86*time.Second {
+		t.Errorf("reuse in %v, want 85s", reuse)
+	}
+
+	// Penalty 4 decays to 1 after two half-lives
+	now = now.Add(2*time.Minute + time.Second)
+	if d.advertise(origin, testRoute(2)) {
+		t.Error("still suppressed after the penalty decayed")
+	}
+}
+
+func TestDampener_MaxSuppress(t *testing.T) {
+	origin, _ := identity.NewAgentID()
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	d := newDampener(DampeningConfig{MaxFlaps: 1, HalfLife: time.Hour, MaxSuppress: 10 * time.Minute}, logging.NopLogger())
+	d.now = func() time.Time { return now }
+
+	d.withdraw(origin)
+	d.withdraw(origin)
+	if !d.isSuppressed(origin) {
+		t.Fatal("not suppressed")
+	}
+	now = now.Add(10 * time.Minute)
+	if d.isSuppressed(origin) {
+		t.Error("suppressed beyond max_suppress")
+	}
+}
+
+func TestFlooder_Dampening(t *testing.T) {
+	localID, _ := identity.NewAgentID()
+	peerID, _ := identity.NewAgentID()
+	otherID, _ := identity.NewAgentID()
+	routeMgr := routing.NewManager(localID)
+	sender := newMockPeerSender()
+	sender.AddPeer(peerID)
+	sender.AddPeer(otherID)
+
+	cfg := DefaultFloodConfig()
+	cfg.Dampening = &DampeningConfig{MaxFlaps: 2, HalfLife: time.Hour, MaxSuppress: time.Hour}
+	f := NewFlooder(cfg, localID, routeMgr, sender)
+	defer f.Stop()
+
+	seq := uint64(0)
+	for range 3 {
+		seq++
+		f.HandleRouteAdvertise(peerID, peerID, "", seq, testRoute(1), nil, nil)
+		seq++
+		f.HandleRouteWithdraw(peerID, peerID, seq, testRoute(1), nil)
+	}
+
+	sent := len(sender.GetMessages(otherID))
+	seq++
+	if f.HandleRouteAdvertise(peerID, peerID, "", seq, testRoute(1), nil, nil) {
+		t.Error("advertisement from a suppressed origin accepted")
+	}
+	if len(sender.GetMessages(otherID)) != sent {
+		t.Error("advertisement from a suppressed origin flooded")
+	}
+	if routeMgr.TotalRoutes() != 0 {
+		t.Errorf("suppressed route installed: %d routes", routeMgr.TotalRoutes())
+	}
+
+	origins := f.DampenedOrigins()
+	if len(origins) != 1 || origins[0].Origin != peerID || !origins[0].Suppressed {
+		t.Errorf("DampenedOrigins = %+v", origins)
+	}
+}
+
+func TestFlooder_PeerRateLimit(t *testing.T) {
+	localID, _ := identity.NewAgentID()
+	peerID, _ := identity.NewAgentID()
+	routeMgr := routing.NewManager(localID)
+	sender := newMockPeerSender()
+
+	cfg := DefaultFloodConfig()
+	cfg.PeerRateLimit = 0.001
+	cfg.PeerRateBurst = 3
+	f := NewFlooder(cfg, localID, routeMgr, sender)
+	defer f.Stop()
+
+	accepted := 0
+	for i := range 5 {
+		origin, _ := identity.NewAgentID()
+		if f.HandleRouteAdvertise(peerID, origin, "", uint64(i+1), testRoute(byte(i)), nil, nil) {
+			accepted++
+		}
+	}
+	if accepted != 3 {
+		t.Errorf("accepted %d advertisements, want the burst of 3", accepted)
+	}
+
+	peers := f.RateLimitedPeers()
+	if len(peers) != 1 || peers[0].Peer != peerID || peers[0].Dropped != 2 {
+		t.Errorf("RateLimitedPeers = %+v", peers)
+	}
+}
@@ -2,6 +2,7 @@
 package flood
 
 import (
+	"errors"
 	"fmt"
 	"log/slog"
 	"net"
@@ -85,6 +86,43 @@ type FloodConfig struct {
 	// Commands with timestamps outside +/- this window are rejected.
 	// Default is 5 minutes.
 	TimestampWindow time.Duration
+
+	// BatchWindow collects route advertisements and withdrawals for this
+	// long before applying them, merging repeated updates for the same
+	// origin from the same peer. Zero processes each update inline.
+	BatchWindow time.Duration
+
+	// Workers is the number of goroutines applying batched route updates.
+	// Updates for one origin always go to the same worker, keeping order.
+	Workers int
+
+	// RouteSigner signs local route advertisements and withdrawals so
+	// receivers can verify them (nil = unsigned).
+	RouteSigner *crypto.SigningKeypair
+
+	// RequireSignedRoutes drops route advertisements and withdrawals that
+	// do not carry a valid origin signature.
+	RequireSignedRoutes bool
+
+	// RouteKeys maps origin agents to the Ed25519 public keys their route
+	// updates must be signed with. Signatures from origins not listed
+	// cannot be tied to the origin and are treated as missing.
+	RouteKeys map[identity.AgentID][32]byte
+
+	// FastReroute records duplicate advertisements arriving through other
+	// peers as alternate next hops, so routes fail over immediately when
+	// their next hop disconnects.
+	FastReroute bool
+
+	// Dampening suppresses advertisements from origins whose routes flap
+	// (nil = no dampening).
+	Dampening *DampeningConfig
+
+	// PeerRateLimit limits the route advertisements and withdrawals
+	// accepted from each peer per second, with bursts of PeerRateBurst.
+	// Updates over the limit are dropped (0 = unlimited).
+	PeerRateLimit float64
+	PeerRateBurst int
 }
 
 // DefaultFloodConfig returns sensible defaults.
@@ -94,6 +132,7 @@ func DefaultFloodConfig() FloodConfig {
 		FloodInterval:    1 * time.Second,
 		MaxSeenCacheSize: 10000,
 		TimestampWindow:  5 * time.Minute,
+		Workers:          4,
 	}
 }
 
@@ -106,18 +145,34 @@ type PeerSender interface {
 	GetPeerIDs() []identity.AgentID
 }
 
+// AdvertiseFilter is optionally implemented by a PeerSender to hold back
+// route and node info advertisements from some peers, such as idle quiet
+// links. Withdrawals and sleep/wake commands are always sent.
+type AdvertiseFilter interface {
+	// SuppressAdvertise returns true if advertisements to peerID should be skipped.
+	SuppressAdvertise(peerID identity.AgentID) bool
+}
+
 // Flooder handles route flooding to mesh peers.
 type Flooder struct {
-	cfg                FloodConfig
-	localID            identity.AgentID
-	displayNameMu      sync.RWMutex
-	localDisplayName   string
-	routeMgr           *routing.Manager
+	cfg              FloodConfig
+	localID          identity.AgentID
+	displayNameMu    sync.RWMutex
+	localDisplayName string
+	routeMgr         *routing.Manager
 	sender           PeerSender
 	logger           *slog.Logger
-	sealedBox        *crypto.SealedBox // Management key encryption (nil if not configured)
-	signingPubKey    *[32]byte         // Ed25519 public key for command verification (nil = no verification)
-	timestampWindow  time.Duration     // Validity window for command timestamps
+	sealedBox        *crypto.SealedBox             // Management key encryption (nil if not configured)
+	signingPubKey    *[32]byte                     // Ed25519 public key for command verification (nil = no verification)
+	timestampWindow  time.Duration                 // Validity window for command timestamps
+	routeSigner      *crypto.SigningKeypair        // Signs local route updates (nil = unsigned)
+	requireSigned    bool                          // Drop unsigned route updates
+	routeKeys        map[identity.AgentID][32]byte // Route signing key per origin
+	fastReroute      bool                          // Record alternate next hops from duplicates
+
+	// Route signing state per origin (see verifyRoutes)
+	originMu sync.Mutex
+	origins  map[identity.AgentID]*originState
 
 	mu        sync.RWMutex
 	seenCache map[AdvertisementKey]*SeenAdvertisement
@@ -136,6 +191,13 @@ type Flooder struct {
 	pendingWakeCmd *protocol.WakeCommand
 	pendingWakeAt  time.Time
 
+	// Route update batching (nil when BatchWindow is zero)
+	batcher *floodBatcher
+
+	// Flap dampening and per-peer rate limits (nil when disabled)
+	dampener *dampener
+	limiter  *peerLimiter
+
 	wg       sync.WaitGroup
 	stopOnce sync.Once
 	stopCh   chan struct{}
@@ -164,6 +226,11 @@ func NewFlooder(cfg FloodConfig, localID identity.AgentID, routeMgr *routing.Man
 		sealedBox:         cfg.SealedBox,
 		signingPubKey:     cfg.SigningPublicKey,
 		timestampWindow:   timestampWindow,
+		routeSigner:       cfg.RouteSigner,
+		requireSigned:     cfg.RequireSignedRoutes,
+		routeKeys:         cfg.RouteKeys,
+		fastReroute:       cfg.FastReroute,
+		origins:           make(map[identity.AgentID]*originState),
 		seenCache:         make(map[AdvertisementKey]*SeenAdvertisement),
 		nodeInfoSeenCache: make(map[NodeInfoKey]*SeenNodeInfo),
 		sleepCmdSeenCache: make(map[SleepCommandKey]*SeenSleepCommand),
@@ -174,11 +241,23 @@ func NewFlooder(cfg FloodConfig, localID identity.AgentID, routeMgr *routing.Man
 	f.wg.Add(1)
 	go f.cleanupLoop()
 
+	if cfg.BatchWindow > 0 {
+		f.batcher = newFloodBatcher(f, cfg.BatchWindow, cfg.Workers)
+	}
+	if cfg.Dampening != nil {
+		f.dampener = newDampener(*cfg.Dampening, logger)
+	}
+	if cfg.PeerRateLimit > 0 {
+		f.limiter = newPeerLimiter(cfg.PeerRateLimit, cfg.PeerRateBurst, logger)
+	}
+
 	return f
 }
 
 // HandleRouteAdvertise processes an incoming ROUTE_ADVERTISE frame.
 // Returns true if the advertisement was new and should be processed.
+// The advertisement is treated as unsigned and in the default namespace;
+// use HandleAdvertise for decoded frames.
 func (f *Flooder) HandleRouteAdvertise(
 	fromPeer identity.AgentID,
 	originAgent identity.AgentID,
@@ -188,9 +267,47 @@ func (f *Flooder) HandleRouteAdvertise(
 	encPath *protocol.EncryptedData,
 	seenBy []identity.AgentID,
 ) bool {
+	return f.HandleAdvertise(fromPeer, &protocol.RouteAdvertise{
+		OriginAgent:       originAgent,
+		OriginDisplayName: originDisplayName,
+		Sequence:          sequence,
+		Routes:            routes,
+		EncPath:           encPath,
+		SeenBy:            seenBy,
+	})
+}
+
+// HandleAdvertise processes a decoded ROUTE_ADVERTISE received from
+// fromPeer. Returns true if the advertisement was new and should be
+// processed.
+func (f *Flooder) HandleAdvertise(fromPeer identity.AgentID, adv *protocol.RouteAdvertise) bool {
+	if f.limiter != nil && !f.limiter.allow(fromPeer, "advertise") {
+		return false
+	}
+
+	u := &routeUpdate{
+		fromPeer:          fromPeer,
+		originAgent:       adv.OriginAgent,
+		originDisplayName: adv.OriginDisplayName,
+		namespace:         adv.Namespace,
+		sequence:          adv.Sequence,
+		routes:            adv.Routes,
+		encPath:           adv.EncPath,
+		seenBy:            adv.SeenBy,
+		signature:         adv.Signature,
+	}
 	key := AdvertisementKey{
-		OriginAgent: originAgent,
-		Sequence:    sequence,
+		OriginAgent: u.originAgent,
+		Sequence:    u.sequence,
+	}
+
+	// Verify before marking as seen, so a forged copy arriving first
+	// cannot shadow the genuine advertisement
+	if !f.HasSeen(u.originAgent, u.sequence) {
+		if err := f.verifyRoutes(protocol.FrameRouteAdvertise, u.originAgent, u.routes, u.signature); err != nil {
+			f.logRejectedRoutes("route advertisement", u.originAgent, fromPeer, err)
+			return false
+		}
 	}
 
 	// Check if we've already seen this and mark as seen atomically
@@ -202,10 +319,14 @@ func (f *Flooder) HandleRouteAdvertise(
 		}
 		f.mu.Unlock()
 		f.logger.Debug("route advertisement already seen",
-			"origin", originAgent.ShortString(),
-			"sequence", sequence,
+			"origin", u.originAgent.ShortString(),
+			"sequence", u.sequence,
 			"from_peer", fromPeer.ShortString(),
 			"original_from", existing.SeenFrom.ShortString())
+		if f.fastReroute && existing.SeenFrom != fromPeer && !f.suppressed(u.originAgent) {
+			u.alternate = true
+			f.addAlternate(u)
+		}
 		return false
 	}
 
@@ -219,53 +340,54 @@ func (f *Flooder) HandleRouteAdvertise(
 	f.mu.Unlock()
 
 	f.logger.Debug("new route advertisement received",
-		"origin", originAgent.ShortString(),
-		"sequence", sequence,
+		"origin", u.originAgent.ShortString(),
+		"sequence", u.sequence,
 		"from_peer", fromPeer.ShortString(),
-		"routes", len(routes),
+		"routes", len(u.routes),
 		"cache_size", cacheSize)
 
+	if f.dampener != nil && u.originAgent != f.localID && f.dampener.advertise(u.originAgent, u.routes) {
+		f.logger.Debug("route advertisement from suppressed origin dropped",
+			"origin", u.originAgent.ShortString(),
+			"sequence", u.sequence,
+			"from_peer", fromPeer.ShortString())
+		return false
+	}
+
+	if f.batcher != nil {
+		f.batcher.add(u)
+		return !containsAgent(u.seenBy, f.localID)
+	}
+	return f.processRouteAdvertise(u)
+}
+
+// processRouteAdvertise applies a new route advertisement to the routing
+// table and floods it to other peers. Returns false on a routing loop.
+func (f *Flooder) processRouteAdvertise(u *routeUpdate) bool {
 	// Store display name for origin agent.
 	// When management key encryption is enabled, suppress storing display names
 	// from route advertisements to prevent accumulating plaintext names that
 	// could be exposed through other code paths.
-	if originDisplayName != "" && f.sealedBox == nil {
-		f.routeMgr.SetDisplayName(originAgent, originDisplayName)
+	if u.originDisplayName != "" && f.sealedBox == nil {
+		f.routeMgr.SetDisplayName(u.originAgent, u.originDisplayName)
 	}
 
 	// Check if we're in the seen-by list (loop detection)
-	if containsAgent(seenBy, f.localID) {
+	if containsAgent(u.seenBy, f.localID) {
 		return false
 	}
 
-	// Decode path from advertisement
-	// Note: Paths are sent as plaintext for routing (not encrypted)
-	// because transit agents need the path to forward STREAM_OPEN frames.
-	// Path hiding happens at the API layer, not on the wire.
-	var path []identity.AgentID
-	if encPath != nil {
-		if encPath.Encrypted {
-			// Legacy: try to decrypt if we have the private key
-			// (for backwards compatibility with old encrypted paths)
-			if f.sealedBox != nil && f.sealedBox.CanDecrypt() {
-				decrypted, err := f.sealedBox.Open(encPath.Data)
-				if err == nil {
-					path, _ = protocol.DecodePath(decrypted)
-				}
-			}
-			// If we can't decrypt, path remains nil (routing will fail)
-		} else {
-			// Plaintext - decode directly (normal case)
-			path, _ = protocol.DecodePath(encPath.Data)
-		}
-	}
+	// Each advertisement carries the origin's current namespace
+	f.routeMgr.SetOriginNamespace(u.originAgent, u.namespace)
+
+	path := f.decodePath(u.encPath)
 
 	// Convert protocol routes to routing entries (CIDR, domain, forward, and agent)
-	cidrEntries := make([]routing.RouteEntry, 0, len(routes))
+	cidrEntries := make([]routing.RouteEntry, 0, len(u.routes))
 	domainEntries := make([]routing.DomainRouteEntry, 0)
 	forwardEntries := make([]routing.ForwardRouteEntry, 0)
 
-	for _, r := range routes {
+	for _, r := range u.routes {
 		switch r.AddressFamily {
 		case protocol.AddrFamilyDomain:
 			// Domain route: PrefixLength 0=exact, 1=wildcard
@@ -291,7 +413,7 @@ func (f *Flooder) HandleRouteAdvertise(
 			// Agent presence route: 16-byte agent ID prefix
 			agentID := protocol.DecodeAgentPrefix(r.Prefix)
 			if agentID != (identity.AgentID{}) {
-				f.routeMgr.ProcessAgentRouteAdvertise(fromPeer, originAgent, sequence, agentID, path, encPath, r.Metric+1)
+				f.routeMgr.ProcessAgentRouteAdvertise(u.fromPeer, u.originAgent, u.sequence, agentID, path, u.encPath, r.Metric+1)
 			}
 		default:
 			// CIDR route (IPv4 or IPv6)
@@ -306,27 +428,99 @@ func (f *Flooder) HandleRouteAdvertise(
 
 	// Process CIDR routes in routing manager
 	if len(cidrEntries) > 0 {
-		f.routeMgr.ProcessRouteAdvertise(fromPeer, originAgent, sequence, cidrEntries, path, encPath)
+		f.routeMgr.ProcessRouteAdvertise(u.fromPeer, u.originAgent, u.sequence, cidrEntries, path, u.encPath)
 	}
 
 	// Process domain routes in routing manager
 	if len(domainEntries) > 0 {
-		f.routeMgr.ProcessDomainRouteAdvertise(fromPeer, originAgent, sequence, domainEntries, path, encPath)
+		f.routeMgr.ProcessDomainRouteAdvertise(u.fromPeer, u.originAgent, u.sequence, domainEntries, path, u.encPath)
 	}
 
 	// Process forward routes in routing manager
 	if len(forwardEntries) > 0 {
-		f.routeMgr.ProcessForwardRouteAdvertise(fromPeer, originAgent, sequence, forwardEntries, path, encPath)
+		f.routeMgr.ProcessForwardRouteAdvertise(u.fromPeer, u.originAgent, u.sequence, forwardEntries, path, u.encPath)
 	}
 
 	// Flood to other peers (forward encrypted path as-is)
-	newSeenBy := append(seenBy, f.localID)
-	f.floodAdvertisementEncrypted(fromPeer, originAgent, originDisplayName, sequence, routes, encPath, newSeenBy)
+	newSeenBy := append(u.seenBy, f.localID)
+	f.floodAdvertisementEncrypted(u.fromPeer, u.originAgent, u.originDisplayName, u.namespace, u.sequence, u.routes, u.encPath, newSeenBy, u.signature)
 
 	return true
 }
 
+// decodePath decodes the path carried in a route advertisement. Returns nil
+// when the path is encrypted and cannot be decrypted.
+//
+// Paths are sent as plaintext for routing (not encrypted) because transit
+// agents need the path to forward STREAM_OPEN frames. Path hiding happens at
+// the API layer, not on the wire.
+func (f *Flooder) decodePath(encPath *protocol.EncryptedData) []identity.AgentID {
+	if encPath == nil {
+		return nil
+	}
+	var path []identity.AgentID
+	if encPath.Encrypted {
+		// Legacy: try to decrypt if we have the private key
+		// (for backwards compatibility with old encrypted paths)
+		if f.sealedBox != nil && f.sealedBox.CanDecrypt() {
+			decrypted, err := f.sealedBox.Open(encPath.Data)
+			if err == nil {
+				path, _ = protocol.DecodePath(decrypted)
+			}
+		}
+		// If we can't decrypt, path remains nil (routing will fail)
+	} else {
+		// Plaintext - decode directly (normal case)
+		path, _ = protocol.DecodePath(encPath.Data)
+	}
+	return path
+}
+
+// addAlternate queues or applies a duplicate advertisement as alternate
+// next hops. Batched duplicates stay behind the advertisement they duplicate.
+func (f *Flooder) addAlternate(u *routeUpdate) {
+	if f.batcher != nil {
+		f.batcher.add(u)
+		return
+	}
+	f.processAlternateAdvertise(u)
+}
+
+// processAlternateAdvertise records the CIDR routes of a duplicate
+// advertisement as alternate next hops in the routing table. Duplicates are
+// not flooded further.
+func (f *Flooder) processAlternateAdvertise(u *routeUpdate) {
+	if containsAgent(u.seenBy, f.localID) {
+		return
+	}
+	if err := f.verifyRoutes(protocol.FrameRouteAdvertise, u.originAgent, u.routes, u.signature); err != nil {
+		f.logRejectedRoutes("alternate route advertisement", u.originAgent, u.fromPeer, err)
+		return
+	}
+
+	entries := make([]routing.RouteEntry, 0, len(u.routes))
+	for _, r := range u.routes {
+		if r.AddressFamily != protocol.AddrFamilyIPv4 && r.AddressFamily != protocol.AddrFamilyIPv6 {
+			continue
+		}
+		if ipNet := protocolRouteToIPNet(r); ipNet != nil {
+			entries = append(entries, routing.RouteEntry{Network: ipNet, Metric: r.Metric})
+		}
+	}
+	if len(entries) == 0 {
+		return
+	}
+	if n := f.routeMgr.ProcessAlternateAdvertise(u.fromPeer, u.originAgent, u.sequence, entries, f.decodePath(u.encPath), u.encPath); n > 0 {
+		f.logger.Debug("alternate next hop recorded",
+			"origin", u.originAgent.ShortString(),
+			"from_peer", u.fromPeer.ShortString(),
+			"routes", n)
+	}
+}
+
 // HandleRouteWithdraw processes an incoming ROUTE_WITHDRAW frame.
+// The withdrawal is treated as unsigned; use HandleWithdraw for decoded
+// frames.
 func (f *Flooder) HandleRouteWithdraw(
 	fromPeer identity.AgentID,
 	originAgent identity.AgentID,
@@ -334,9 +528,39 @@ func (f *Flooder) HandleRouteWithdraw(
 	routes []protocol.Route,
 	seenBy []identity.AgentID,
 ) bool {
-	key := AdvertisementKey{
+	return f.HandleWithdraw(fromPeer, &protocol.RouteWithdraw{
 		OriginAgent: originAgent,
 		Sequence:    sequence,
+		Routes:      routes,
+		SeenBy:      seenBy,
+	})
+}
+
+// HandleWithdraw processes a decoded ROUTE_WITHDRAW received from fromPeer.
+func (f *Flooder) HandleWithdraw(fromPeer identity.AgentID, withdraw *protocol.RouteWithdraw) bool {
+	if f.limiter != nil && !f.limiter.allow(fromPeer, "withdraw") {
+		return false
+	}
+
+	u := &routeUpdate{
+		withdraw:    true,
+		fromPeer:    fromPeer,
+		originAgent: withdraw.OriginAgent,
+		sequence:    withdraw.Sequence,
+		routes:      withdraw.Routes,
+		seenBy:      withdraw.SeenBy,
+		signature:   withdraw.Signature,
+	}
+	key := AdvertisementKey{
+		OriginAgent: u.originAgent,
+		Sequence:    u.sequence,
+	}
+
+	if !f.HasSeen(u.originAgent, u.sequence) {
+		if err := f.verifyRoutes(protocol.FrameRouteWithdraw, u.originAgent, u.routes, u.signature); err != nil {
+			f.logRejectedRoutes("route withdrawal", u.originAgent, fromPeer, err)
+			return false
+		}
 	}
 
 	// Check if we've seen this
@@ -353,14 +577,28 @@ func (f *Flooder) HandleRouteWithdraw(
 	}
 	f.mu.Unlock()
 
+	if f.dampener != nil && u.originAgent != f.localID {
+		f.dampener.withdraw(u.originAgent)
+	}
+
+	if f.batcher != nil {
+		f.batcher.add(u)
+		return !containsAgent(u.seenBy, f.localID)
+	}
+	return f.processRouteWithdraw(u)
+}
+
+// processRouteWithdraw removes withdrawn routes from the routing table and
+// floods the withdrawal to other peers. Returns false on a routing loop.
+func (f *Flooder) processRouteWithdraw(u *routeUpdate) bool {
 	// Check loop detection
-	if containsAgent(seenBy, f.localID) {
+	if containsAgent(u.seenBy, f.localID) {
 		return false
 	}
 
 	// Convert to routing entries
-	entries := make([]routing.RouteEntry, 0, len(routes))
-	for _, r := range routes {
+	entries := make([]routing.RouteEntry, 0, len(u.routes))
+	for _, r := range u.routes {
 		if ipNet := protocolRouteToIPNet(r); ipNet != nil {
 			entries = append(entries, routing.RouteEntry{
 				Network: ipNet,
@@ -370,11 +608,11 @@ func (f *Flooder) HandleRouteWithdraw(
 	}
 
 	// Process withdrawal
-	f.routeMgr.ProcessRouteWithdraw(originAgent, entries)
+	f.routeMgr.ProcessRouteWithdraw(u.originAgent, entries)
 
 	// Flood withdrawal to other peers
-	newSeenBy := append(seenBy, f.localID)
-	f.floodWithdrawal(fromPeer, originAgent, sequence, routes, newSeenBy)
+	newSeenBy := append(u.seenBy, f.localID)
+	f.floodWithdrawal(u.fromPeer, u.originAgent, u.sequence, u.routes, newSeenBy, u.signature)
 
 	return true
 }
@@ -386,10 +624,12 @@ func (f *Flooder) floodAdvertisementEncrypted(
 	fromPeer identity.AgentID,
 	originAgent identity.AgentID,
 	originDisplayName string,
+	namespace string,
 	sequence uint64,
 	routes []protocol.Route,
 	encPath *protocol.EncryptedData,
 	seenBy []identity.AgentID,
+	sig *protocol.RouteSignature,
 ) {
 	// Extend the path if it's plaintext (normal case)
 	// For encrypted paths (legacy), forward as-is
@@ -421,6 +661,8 @@ func (f *Flooder) floodAdvertisementEncrypted(
 		Routes:            routes,
 		EncPath:           fwdEncPath,
 		SeenBy:            seenBy,
+		Signature:         sig,
+		Namespace:         namespace,
 	}
 
 	frame := &protocol.Frame{
@@ -439,12 +681,14 @@ func (f *Flooder) floodWithdrawal(
 	sequence uint64,
 	routes []protocol.Route,
 	seenBy []identity.AgentID,
+	sig *protocol.RouteSignature,
 ) {
 	withdraw := &protocol.RouteWithdraw{
 		OriginAgent: originAgent,
 		Sequence:    sequence,
 		Routes:      routes,
 		SeenBy:      seenBy,
+		Signature:   sig,
 	}
 
 	frame := &protocol.Frame{
@@ -473,8 +717,8 @@ func (f *Flooder) getLocalDisplayName() string {
 
 // AnnounceLocalRoutes floods all local routes (CIDR, domain, and forward) to all peers.
 func (f *Flooder) AnnounceLocalRoutes() {
-	localRoutes := f.routeMgr.GetLocalRoutes()
-	localDomainRoutes := f.routeMgr.GetLocalDomainRoutes()
+	localRoutes := f.routeMgr.GetAdvertisedLocalRoutes()
+	localDomainRoutes := f.routeMgr.GetAdvertisedLocalDomainRoutes()
 	localForwardRoutes := f.routeMgr.GetLocalForwardRoutes()
 
 	seq := f.routeMgr.IncrementSequence()
@@ -545,6 +789,8 @@ func (f *Flooder) AnnounceLocalRoutes() {
 		Path:              path,    // Keep for backwards compat
 		EncPath:           encPath, // Encrypted path for wire format
 		SeenBy:            []identity.AgentID{f.localID},
+		Signature:         f.signRoutes(protocol.FrameRouteAdvertise, routes),
+		Namespace:         f.routeMgr.LocalNamespace(),
 	}
 
 	frame := &protocol.Frame{
@@ -554,7 +800,7 @@ func (f *Flooder) AnnounceLocalRoutes() {
 	}
 
 	// Send to all peers
-	for _, peerID := range f.sender.GetPeerIDs() {
+	for _, peerID := range f.advertisePeerIDs() {
 		if err := f.sender.SendToPeer(peerID, frame); err != nil {
 			f.logger.Debug("failed to announce local routes",
 				logging.KeyPeerID, peerID.ShortString(),
@@ -565,7 +811,12 @@ func (f *Flooder) AnnounceLocalRoutes() {
 
 // WithdrawLocalRoutes floods withdrawal of all local routes.
 func (f *Flooder) WithdrawLocalRoutes() {
-	localRoutes := f.routeMgr.GetLocalRoutes()
+	f.WithdrawRoutes(f.routeMgr.GetAdvertisedLocalRoutes())
+}
+
+// WithdrawRoutes floods withdrawal of the given local CIDR routes, such as
+// the routes returned by routing.Manager.SuspendLocalRoutes.
+func (f *Flooder) WithdrawRoutes(localRoutes []*routing.LocalRoute) {
 	if len(localRoutes) == 0 {
 		return
 	}
@@ -582,6 +833,7 @@ func (f *Flooder) WithdrawLocalRoutes() {
 		Sequence:    seq,
 		Routes:      routes,
 		SeenBy:      []identity.AgentID{f.localID},
+		Signature:   f.signRoutes(protocol.FrameRouteWithdraw, routes),
 	}
 
 	frame := &protocol.Frame{
@@ -618,6 +870,15 @@ func (f *Flooder) SendFullTable(peerID identity.AgentID) {
 		byOrigin[route.OriginAgent] = append(byOrigin[route.OriginAgent], route)
 	}
 
+	// Send local routes as announced, which may be aggregated
+	if _, ok := byOrigin[f.localID]; ok {
+		var local []*routing.Route
+		for _, lr := range f.routeMgr.GetAdvertisedLocalRoutes() {
+			local = append(local, &routing.Route{Network: lr.Network, Metric: lr.Metric})
+		}
+		byOrigin[f.localID] = local
+	}
+
 	// Group agent presence routes by origin agent
 	agentByOrigin := make(map[identity.AgentID][]*routing.AgentRoute)
 	for _, route := range agentRoutes {
@@ -733,6 +994,15 @@ func (f *Flooder) SendFullTable(peerID identity.AgentID) {
 			}
 		}
 
+		// Routes are signed by their origin: local routes are signed here,
+		// remote ones are replaced by the origin's newest signed set
+		var sig *protocol.RouteSignature
+		if originAgent == f.localID {
+			sig = f.signRoutes(protocol.FrameRouteAdvertise, routes)
+		} else if signed, s := f.signedRoutes(originAgent); s != nil {
+			routes, sig = signed, s
+		}
+
 		adv := &protocol.RouteAdvertise{
 			OriginAgent:       originAgent,
 			OriginDisplayName: originDisplayName,
@@ -740,6 +1010,8 @@ func (f *Flooder) SendFullTable(peerID identity.AgentID) {
 			Routes:            routes,
 			Path:              path,
 			SeenBy:            []identity.AgentID{f.localID},
+			Signature:         sig,
+			Namespace:         f.routeMgr.OriginNamespace(originAgent),
 		}
 
 		frame := &protocol.Frame{
@@ -793,6 +1065,13 @@ func (f *Flooder) cleanup() {
 	f.sleepCmdMu.Lock()
 	f.cleanupSleepCmdCache(now, expiry)
 	f.sleepCmdMu.Unlock()
+
+	if f.dampener != nil {
+		f.dampener.cleanup(expiry)
+	}
+	if f.limiter != nil {
+		f.limiter.cleanup(expiry)
+	}
 }
 
 // cleanupSeenCache removes expired entries from the seen cache.
@@ -877,6 +1156,9 @@ func (f *Flooder) SeenCacheSize() int {
 // Stop stops the flooder.
 func (f *Flooder) Stop() {
 	f.stopOnce.Do(func() {
+		if f.batcher != nil {
+			f.batcher.stop()
+		}
 		close(f.stopCh)
 	})
 	f.wg.Wait()
@@ -932,9 +1214,30 @@ func routeToProtocol(route *routing.Route) protocol.Route {
 	return ipNetToProtocolRoute(route.Network, route.Metric)
 }
 
+// advertisePeerIDs returns the connected peers that should receive route
+// and node info advertisements.
+func (f *Flooder) advertisePeerIDs() []identity.AgentID {
+	peerIDs := f.sender.GetPeerIDs()
+	filter, ok := f.sender.(AdvertiseFilter)
+	if !ok {
+		return peerIDs
+	}
+	kept := make([]identity.AgentID, 0, len(peerIDs))
+	for _, peerID := range peerIDs {
+		if !filter.SuppressAdvertise(peerID) {
+			kept = append(kept, peerID)
+		}
+	}
+	return kept
+}
+
 // floodFrame sends a frame to all peers except the source and those in the seen-by list.
 func (f *Flooder) floodFrame(fromPeer identity.AgentID, seenBy []identity.AgentID, frame *protocol.Frame, logMsg string) {
-	for _, peerID := range f.sender.GetPeerIDs() {
+	peerIDs := f.sender.GetPeerIDs()
+	if frame.Type == protocol.FrameRouteAdvertise || frame.Type == protocol.FrameNodeInfoAdvertise {
+		peerIDs = f.advertisePeerIDs()
+	}
+	for _, peerID := range peerIDs {
 		if peerID == fromPeer || containsAgent(seenBy, peerID) {
 			continue
 		}
@@ -960,6 +1263,19 @@ func (f *Flooder) HasSeen(originAgent identity.AgentID, sequence uint64) bool {
 	return ok
 }
 
+// logRejectedRoutes logs a route update dropped by signature verification.
+// Unsigned updates are expected from older agents and logged at debug level.
+func (f *Flooder) logRejectedRoutes(kind string, origin, fromPeer identity.AgentID, err error) {
+	log := f.logger.Warn
+	if errors.Is(err, errUnsignedRoutes) {
+		log = f.logger.Debug
+	}
+	log(kind+" rejected",
+		"origin", origin.ShortString(),
+		"from_peer", fromPeer.ShortString(),
+		logging.KeyError, err)
+}
+
 // ClearSeenCache clears the seen cache (for testing).
 func (f *Flooder) ClearSeenCache() {
 	f.mu.Lock()
@@ -1098,7 +1414,7 @@ func (f *Flooder) AnnounceLocalNodeInfo(info *protocol.NodeInfo) {
 	}
 
 	// Send to all peers
-	for _, peerID := range f.sender.GetPeerIDs() {
+	for _, peerID := range f.advertisePeerIDs() {
 		if err := f.sender.SendToPeer(peerID, frame); err != nil {
 			f.logger.Debug("failed to announce local node info",
 				logging.KeyPeerID, peerID.ShortString(),
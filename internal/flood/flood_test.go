@@ -318,6 +318,90 @@ func TestFlooder_AnnounceLocalRoutes(t *testing.T) {
 	}
 }
 
+func TestFlooder_Namespace(t *testing.T) {
+	localID, _ := identity.NewAgentID()
+	peer1, _ := identity.NewAgentID()
+	peer2, _ := identity.NewAgentID()
+	routeMgr := routing.NewManager(localID)
+	routeMgr.SetLocalNamespace("acme")
+	sender := newMockPeerSender()
+	sender.AddPeer(peer1)
+	sender.AddPeer(peer2)
+
+	f := NewFlooder(DefaultFloodConfig(), localID, routeMgr, sender)
+	defer f.Stop()
+
+	decode := func(t *testing.T, frame *protocol.Frame) *protocol.RouteAdvertise {
+		t.Helper()
+		adv, err := protocol.DecodeRouteAdvertise(frame.Payload)
+		if err != nil {
+			t.Fatalf("DecodeRouteAdvertise() error = %v", err)
+		}
+		return adv
+	}
+
+	// Local routes are announced in the local namespace
+	f.AnnounceLocalRoutes()
+	if adv := decode(t, sender.GetMessages(peer1)[0]); adv.Namespace != "acme" {
+		t.Errorf("announced Namespace = %q, want acme", adv.Namespace)
+	}
+
+	// A received namespace is recorded for the origin and relayed
+	routes := []protocol.Route{{AddressFamily: protocol.AddrFamilyIPv4, PrefixLength: 8, Prefix: []byte{10, 0, 0, 0}, Metric: 1}}
+	f.HandleAdvertise(peer1, &protocol.RouteAdvertise{OriginAgent: peer1, Namespace: "globex", Sequence: 1, Routes: routes})
+	if got := routeMgr.OriginNamespace(peer1); got != "globex" {
+		t.Errorf("OriginNamespace() = %q, want globex", got)
+	}
+	msgs := sender.GetMessages(peer2)
+	if adv := decode(t, msgs[len(msgs)-1]); adv.Namespace != "globex" {
+		t.Errorf("relayed Namespace = %q, want globex", adv.Namespace)
+	}
+}
+
+// filteringPeerSender suppresses advertisements to selected peers.
+type filteringPeerSender struct {
+	*mockPeerSender
+	suppressed map[identity.AgentID]bool
+}
+
+func (m *filteringPeerSender) SuppressAdvertise(peerID identity.AgentID) bool {
+	return m.suppressed[peerID]
+}
+
+func TestFlooder_AdvertiseFilter(t *testing.T) {
+	localID, _ := identity.NewAgentID()
+	activePeer, _ := identity.NewAgentID()
+	quietPeer, _ := identity.NewAgentID()
+	routeMgr := routing.NewManager(localID)
+	sender := &filteringPeerSender{
+		mockPeerSender: newMockPeerSender(),
+		suppressed:     map[identity.AgentID]bool{quietPeer: true},
+	}
+	sender.AddPeer(activePeer)
+	sender.AddPeer(quietPeer)
+
+	f := NewFlooder(DefaultFloodConfig(), localID, routeMgr, sender)
+	defer f.Stop()
+
+	routeMgr.AddLocalRoute(routing.MustParseCIDR("10.0.0.0/8"), 10)
+	f.AnnounceLocalRoutes()
+	f.AnnounceLocalNodeInfo(&protocol.NodeInfo{DisplayName: "local"})
+
+	if got := len(sender.GetMessages(activePeer)); got != 2 {
+		t.Errorf("active peer got %d messages, want 2", got)
+	}
+	if got := len(sender.GetMessages(quietPeer)); got != 0 {
+		t.Errorf("suppressed peer got %d advertisements, want 0", got)
+	}
+
+	// Withdrawals still reach suppressed peers
+	f.WithdrawLocalRoutes()
+	msgs := sender.GetMessages(quietPeer)
+	if len(msgs) != 1 || msgs[0].Type != protocol.FrameRouteWithdraw {
+		t.Errorf("suppressed peer should receive the withdrawal, got %d messages", len(msgs))
+	}
+}
+
 func TestFlooder_WithdrawLocalRoutes(t *testing.T) {
 	localID, _ := identity.NewAgentID()
 	peerID, _ := identity.NewAgentID()
@@ -344,6 +428,45 @@ func TestFlooder_WithdrawLocalRoutes(t *testing.T) {
 	}
 }
 
+func TestFlooder_SuspendedLocalRoutes(t *testing.T) {
+	localID, _ := identity.NewAgentID()
+	peerID, _ := identity.NewAgentID()
+	routeMgr := routing.NewManager(localID)
+	sender := newMockPeerSender()
+	sender.AddPeer(peerID)
+
+	f := NewFlooder(DefaultFloodConfig(), localID, routeMgr, sender)
+	defer f.Stop()
+
+	routeMgr.AddLocalRoute(routing.MustParseCIDR("10.0.0.0/8"), 10)
+	routeMgr.AddLocalDomainRoute("*.example.com", 0)
+
+	routes, _ := routeMgr.SuspendLocalRoutes()
+	f.WithdrawRoutes(routes)
+	msgs := sender.GetMessages(peerID)
+	if len(msgs) != 1 || msgs[0].Type != protocol.FrameRouteWithdraw {
+		t.Fatalf("got %d messages, want 1 ROUTE_WITHDRAW", len(msgs))
+	}
+	withdraw, err := protocol.DecodeRouteWithdraw(msgs[0].Payload)
+	if err != nil {
+		t.Fatalf("DecodeRouteWithdraw: %v", err)
+	}
+	if len(withdraw.Routes) != 1 {
+		t.Errorf("withdrew %d routes, want 1", len(withdraw.Routes))
+	}
+
+	// Announcements while suspended carry only the agent presence route
+	f.AnnounceLocalRoutes()
+	msgs = sender.GetMessages(peerID)
+	adv, err := protocol.DecodeRouteAdvertise(msgs[len(msgs)-1].Payload)
+	if err != nil {
+		t.Fatalf("DecodeRouteAdvertise: %v", err)
+	}
+	if len(adv.Routes) != 1 || adv.Routes[0].AddressFamily != protocol.AddrFamilyAgent {
+		t.Errorf("advertised %d routes while suspended, want only the agent route", len(adv.Routes))
+	}
+}
+
 func TestFlooder_SendFullTable(t *testing.T) {
 	localID, _ := identity.NewAgentID()
 	peer1, _ := identity.NewAgentID()
@@ -1339,3 +1462,250 @@ func TestFlooder_SendFullTable_IncludesAgentPresenceRoutes(t *testing.T) {
 		t.Error("SendFullTable should include agent presence route for remote agent")
 	}
 }
+
+// ============================================================================
+// Batching Tests
+// ============================================================================
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met before deadline")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestFlooder_Batching_MergesUpdates(t *testing.T) {
+	localID, _ := identity.NewAgentID()
+	peer1, _ := identity.NewAgentID()
+	peer2, _ := identity.NewAgentID()
+	origin, _ := identity.NewAgentID()
+	routeMgr := routing.NewManager(localID)
+	sender := newMockPeerSender()
+	sender.AddPeer(peer1)
+	sender.AddPeer(peer2)
+	cfg := DefaultFloodConfig()
+	cfg.BatchWindow = time.Hour
+	cfg.Workers = 2
+
+	f := NewFlooder(cfg, localID, routeMgr, sender)
+	defer f.Stop()
+
+	for seq := uint64(1); seq <= 5; seq++ {
+		routes := []protocol.Route{{
+			AddressFamily: protocol.AddrFamilyIPv4,
+			PrefixLength:  24,
+			Prefix:        []byte{10, 0, byte(seq), 0},
+			Metric:        1,
+		}}
+		if !f.HandleRouteAdvertise(peer1, origin, "", seq, routes, nil, nil) {
+			t.Fatalf("advertisement %d should be accepted", seq)
+		}
+	}
+
+	// Nothing applied until the batch is flushed
+	if routeMgr.TotalRoutes() != 0 || sender.TotalMessages() != 0 {
+		t.Fatal("batched updates applied before flush")
+	}
+
+	f.batcher.flush()
+	waitFor(t, func() bool { return len(sender.GetMessages(peer2)) > 0 })
+
+	// Only the latest advertisement is applied and flooded
+	time.Sleep(20 * time.Millisecond)
+	if n := len(sender.GetMessages(peer2)); n != 1 {
+		t.Fatalf("flooded %d advertisements to peer2, want 1", n)
+	}
+	adv, err := protocol.DecodeRouteAdvertise(sender.GetMessages(peer2)[0].Payload)
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if adv.Sequence != 5 {
+		t.Errorf("flooded sequence = %d, want 5", adv.Sequence)
+	}
+	if routeMgr.TotalRoutes() != 1 {
+		t.Errorf("TotalRoutes = %d, want 1", routeMgr.TotalRoutes())
+	}
+
+	stats := f.BatchStats()
+	if stats.Received != 5 || stats.Merged != 4 || stats.Batches != 1 {
+		t.Errorf("BatchStats = %+v, want received 5, merged 4, batches 1", stats)
+	}
+}
+
+func TestFlooder_Batching_WithdrawKeepsOrder(t *testing.T) {
+	localID, _ := identity.NewAgentID()
+	peer1, _ := identity.NewAgentID()
+	routeMgr := routing.NewManager(localID)
+	sender := newMockPeerSender()
+	cfg := DefaultFloodConfig()
+	cfg.BatchWindow = time.Hour
+
+	f := NewFlooder(cfg, localID, routeMgr, sender)
+	defer f.Stop()
+
+	routes := []protocol.Route{{
+		AddressFamily: protocol.AddrFamilyIPv4,
+		PrefixLength:  8,
+		Prefix:        []byte{10, 0, 0, 0},
+		Metric:        1,
+	}}
+
+	// Advertise, withdraw, advertise again: the route must end up present
+	f.HandleRouteAdvertise(peer1, peer1, "", 1, routes, nil, nil)
+	f.HandleRouteWithdraw(peer1, peer1, 2, routes, nil)
+	f.HandleRouteAdvertise(peer1, peer1, "", 3, routes, nil, nil)
+
+	f.batcher.flush()
+	waitFor(t, func() bool { return routeMgr.TotalRoutes() == 1 })
+
+	if stats := f.BatchStats(); stats.Merged != 0 {
+		t.Errorf("Merged = %d, want 0 across a withdrawal", stats.Merged)
+	}
+}
+
+func TestFlooder_Batching_FlushAfterWindow(t *testing.T) {
+	localID, _ := identity.NewAgentID()
+	peer1, _ := identity.NewAgentID()
+	routeMgr := routing.NewManager(localID)
+	sender := newMockPeerSender()
+	cfg := DefaultFloodConfig()
+	cfg.BatchWindow = 10 * time.Millisecond
+
+	f := NewFlooder(cfg, localID, routeMgr, sender)
+	defer f.Stop()
+
+	routes := []protocol.Route{{
+		AddressFamily: protocol.AddrFamilyIPv4,
+		PrefixLength:  8,
+		Prefix:        []byte{10, 0, 0, 0},
+		Metric:        1,
+	}}
+
+	// Loop detection is still reported synchronously
+	if f.HandleRouteAdvertise(peer1, peer1, "", 1, routes, nil, []identity.AgentID{localID}) {
+		t.Error("looped advertisement should not be accepted")
+	}
+	if !f.HandleRouteAdvertise(peer1, peer1, "", 2, routes, nil, nil) {
+		t.Error("advertisement should be accepted")
+	}
+
+	waitFor(t, func() bool { return routeMgr.TotalRoutes() == 1 })
+}
+
+func TestFlooder_AnnounceLocalRoutes_Aggregated(t *testing.T) {
+	localID, _ := identity.NewAgentID()
+	peerID, _ := identity.NewAgentID()
+	routeMgr := routing.NewManager(localID)
+	sender := newMockPeerSender()
+	sender.AddPeer(peerID)
+
+	f := NewFlooder(DefaultFloodConfig(), localID, routeMgr, sender)
+	defer f.Stop()
+
+	for _, cidr := range []string{"10.1.0.0/24", "10.1.1.0/24", "10.1.2.0/24", "10.1.3.0/24"} {
+		routeMgr.AddLocalRoute(routing.MustParseCIDR(cidr), 0)
+	}
+	routeMgr.SetAggregation(nil)
+
+	advertisedCIDRs := func(frame *protocol.Frame) []string {
+		adv, err := protocol.DecodeRouteAdvertise(frame.Payload)
+		if err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		var cidrs []string
+		for _, r := range adv.Routes {
+			if ipNet := protocolRouteToIPNet(r); ipNet != nil {
+				cidrs = append(cidrs, ipNet.String())
+			}
+		}
+		return cidrs
+	}
+
+	f.AnnounceLocalRoutes()
+	f.SendFullTable(peerID)
+
+	msgs := sender.GetMessages(peerID)
+	if len(msgs) != 2 {
+		t.Fatalf("got %d messages, want 2", len(msgs))
+	}
+	for i, msg := range msgs {
+		if cidrs := advertisedCIDRs(msg); len(cidrs) != 1 || cidrs[0] != "10.1.0.0/22" {
+			t.Errorf("message %d advertised %v, want [10.1.0.0/22]", i, cidrs)
+		}
+	}
+}
+
+// ============================================================================
+// Fast Reroute Tests
+// ============================================================================
+
+func TestFlooder_FastReroute_RecordsDuplicates(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		batched bool
+	}{{"inline", false}, {"batched", true}} {
+		batched := tt.batched
+		t.Run(tt.name, func(t *testing.T) {
+			localID, _ := identity.NewAgentID()
+			peerA, _ := identity.NewAgentID()
+			peerB, _ := identity.NewAgentID()
+			origin, _ := identity.NewAgentID()
+			routeMgr := routing.NewManager(localID)
+			routeMgr.SetFastReroute(true)
+			sender := newMockPeerSender()
+			sender.AddPeer(peerA)
+			sender.AddPeer(peerB)
+			cfg := DefaultFloodConfig()
+			cfg.FastReroute = true
+			if batched {
+				cfg.BatchWindow = time.Hour
+			}
+
+			f := NewFlooder(cfg, localID, routeMgr, sender)
+			defer f.Stop()
+
+			routes := []protocol.Route{{
+				AddressFamily: protocol.AddrFamilyIPv4,
+				PrefixLength:  8,
+				Prefix:        []byte{10, 0, 0, 0},
+				Metric:        1,
+			}}
+			pathVia := func(peer identity.AgentID) *protocol.EncryptedData {
+				return &protocol.EncryptedData{Data: protocol.EncodePath([]identity.AgentID{peer, origin})}
+			}
+
+			if !f.HandleRouteAdvertise(peerA, origin, "", 1, routes, pathVia(peerA), nil) {
+				t.Fatal("advertisement should be accepted")
+			}
+			if f.HandleRouteAdvertise(peerB, origin, "", 1, routes, pathVia(peerB), nil) {
+				t.Error("duplicate advertisement should not be accepted")
+			}
+			if batched {
+				f.batcher.flush()
+			}
+
+			network := routing.MustParseCIDR("10.0.0.0/8")
+			waitFor(t, func() bool { return len(routeMgr.Table().GetAlternates(network)) == 1 })
+			if alt := routeMgr.Table().GetAlternates(network)[0]; alt.NextHop != peerB {
+				t.Errorf("alternate next hop = %s, want B", alt.NextHop.ShortString())
+			}
+
+			// The duplicate is not flooded
+			if n := len(sender.GetMessages(peerA)); n != 0 {
+				t.Errorf("sent %d frames back to A, want 0", n)
+			}
+			if n := len(sender.GetMessages(peerB)); n != 1 {
+				t.Errorf("sent %d frames to B, want 1", n)
+			}
+
+			routeMgr.HandlePeerDisconnect(peerA)
+			if r := routeMgr.Table().GetRoute(network); r == nil || r.NextHop != peerB {
+				t.Errorf("route after A disconnected = %v, want next hop B", r)
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package flood
+
+import (
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"github.com/postalsys/muti-metroo/internal/identity"
+	"github.com/postalsys/muti-metroo/internal/logging"
+)
+
+// rateLimitLogInterval is the minimum time between two rate limit warnings
+// for the same peer.
+const rateLimitLogInterval = time.Minute
+
+// PeerRateLimit is the rate limit state of a peer.
+type PeerRateLimit struct {
+	Peer        identity.AgentID
+	Dropped     uint64    // Route updates dropped
+	LastDropped time.Time // When the newest update was dropped
+}
+
+// peerRate is the limiter and drop counters of one peer.
+type peerRate struct {
+	limiter     *rate.Limiter
+	lastUsed    time.Time
+	dropped     uint64
+	lastDropped time.Time
+	lastLogged  time.Time
+	loggedAt    uint64 // dropped when lastLogged was set
+}
+
+// peerLimiter limits the route advertisements and withdrawals accepted
+// from each peer with a token bucket.
+type peerLimiter struct {
+	limit  rate.Limit
+	burst  int
+	logger *slog.Logger
+
+	mu    sync.Mutex
+	peers map[identity.AgentID]*peerRate
+}
+
+// newPeerLimiter creates a limiter allowing perSecond updates per peer with
+// bursts of burst.
+func newPeerLimiter(perSecond float64, burst int, logger *slog.Logger) *peerLimiter {
+	return &peerLimiter{
+		limit:  rate.Limit(perSecond),
+		burst:  burst,
+		logger: logger,
+		peers:  make(map[identity.AgentID]*peerRate),
+	}
+}
+
+// allow reports whether a route update from peer may be processed.
+func (l *peerLimiter) allow(peer identity.AgentID, kind string) bool {
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	p := l.peers[peer]
+	if p == nil {
+		p = &peerRate{limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.peers[peer] = p
+	}
+	p.lastUsed = now
+	if p.limiter.AllowN(now, 1) {
+		return true
+	}
+
+	p.dropped++
+	p.lastDropped = now
+	if now.Sub(p.lastLogged) >= rateLimitLogInterval {
+		l.logger.Warn("route updates from peer rate limited",
+			logging.KeyPeerID, peer.ShortString(),
+			"kind", kind,
+			"dropped", p.dropped-p.loggedAt)
+		p.lastLogged = now
+		p.loggedAt = p.dropped
+	}
+	return false
+}
+
+// list returns the peers that had updates dropped, most drops first.
+func (l *peerLimiter) list() []PeerRateLimit {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var out []PeerRateLimit
+	for peer, p := range l.peers {
+		if p.dropped > 0 {
+			out = append(out, PeerRateLimit{Peer: peer, Dropped: p.dropped, LastDropped: p.lastDropped})
+		}
+	}
+	slices.SortFunc(out, func(a, b PeerRateLimit) int {
+		if a.Dropped != b.Dropped {
+			if a.Dropped > b.Dropped {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(a.Peer.String(), b.Peer.String())
+	})
+	return out
+}
+
+// cleanup forgets peers that sent nothing for longer than idle.
+func (l *peerLimiter) cleanup(idle time.Duration) {
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for peer, p := range l.peers {
+		if now.Sub(p.lastUsed) > idle {
+			delete(l.peers, peer)
+		}
+	}
+}
+
+// RateLimitedPeers returns the peers whose route updates were dropped by the
+// per-peer rate limit. Returns nil when rate limiting is disabled.
+func (f *Flooder) RateLimitedPeers() []PeerRateLimit {
+	if f.limiter == nil {
+		return nil
+	}
+	return f.limiter.list()
+}
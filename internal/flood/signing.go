@@ -0,0 +1,100 @@
+package flood
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/postalsys/muti-metroo/internal/crypto"
+	"github.com/postalsys/muti-metroo/internal/identity"
+	"github.com/postalsys/muti-metroo/internal/protocol"
+)
+
+// errUnsignedRoutes is returned for unsigned route updates when signatures
+// are required.
+var errUnsignedRoutes = errors.New("route update is not signed")
+
+// errNoRouteKey is returned for route updates from an origin without a
+// configured route key when signatures are required.
+var errNoRouteKey = errors.New("no route key configured for origin")
+
+// originState tracks the route signing state of one origin agent.
+type originState struct {
+	timestamp uint64                   // Newest accepted signature timestamp
+	routes    []protocol.Route         // Routes of the newest signed advertisement
+	signature *protocol.RouteSignature // Signature over routes (nil after a withdrawal)
+}
+
+// signRoutes signs a local route set. Returns nil when no route signer is
+// configured.
+func (f *Flooder) signRoutes(frameType uint8, routes []protocol.Route) *protocol.RouteSignature {
+	if f.routeSigner == nil {
+		return nil
+	}
+	sig := &protocol.RouteSignature{
+		Timestamp: uint64(time.Now().UnixNano()),
+		PublicKey: f.routeSigner.PublicKey,
+	}
+	sig.Signature = crypto.Sign(f.routeSigner.PrivateKey,
+		protocol.RouteSignableBytes(frameType, f.localID, sig.Timestamp, routes))
+	return sig
+}
+
+// verifyRoutes checks the origin signature of a received route update and
+// records it. The signature must be made with the key configured for the
+// origin in RouteKeys and must not be older than the newest accepted one.
+// A key carried in the update proves nothing about who made it, so updates
+// from origins without a configured key count as unsigned. Unsigned updates
+// are accepted unless signatures are required.
+func (f *Flooder) verifyRoutes(frameType uint8, origin identity.AgentID, routes []protocol.Route, sig *protocol.RouteSignature) error {
+	key, known := f.routeKeys[origin]
+	if sig == nil || !known {
+		if !f.requireSigned {
+			return nil
+		}
+		if sig == nil {
+			return errUnsignedRoutes
+		}
+		return errNoRouteKey
+	}
+
+	if sig.PublicKey != key {
+		return fmt.Errorf("route update not signed with the origin's route key")
+	}
+	if !crypto.Verify(key, protocol.RouteSignableBytes(frameType, origin, sig.Timestamp, routes), sig.Signature) {
+		return fmt.Errorf("invalid route signature")
+	}
+
+	f.originMu.Lock()
+	defer f.originMu.Unlock()
+
+	state := f.origins[origin]
+	if state == nil {
+		state = &originState{}
+		f.origins[origin] = state
+	}
+	if sig.Timestamp < state.timestamp {
+		return fmt.Errorf("stale route update (signed %v before the newest)",
+			time.Duration(state.timestamp-sig.Timestamp))
+	}
+
+	state.timestamp = sig.Timestamp
+	if frameType == protocol.FrameRouteWithdraw {
+		state.routes, state.signature = nil, nil
+	} else {
+		state.routes, state.signature = routes, sig
+	}
+	return nil
+}
+
+// signedRoutes returns the newest signed route set of an origin, or nil when
+// none is known.
+func (f *Flooder) signedRoutes(origin identity.AgentID) ([]protocol.Route, *protocol.RouteSignature) {
+	f.originMu.Lock()
+	defer f.originMu.Unlock()
+
+	if state := f.origins[origin]; state != nil && state.signature != nil {
+		return state.routes, state.signature
+	}
+	return nil, nil
+}
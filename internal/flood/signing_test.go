@@ -0,0 +1,323 @@
+package flood
+
+import (
+	"testing"
+
+	"github.com/postalsys/muti-metroo/internal/crypto"
+	"github.com/postalsys/muti-metroo/internal/identity"
+	"github.com/postalsys/muti-metroo/internal/protocol"
+	"github.com/postalsys/muti-metroo/internal/routing"
+)
+
+// signedOrigin is a remote origin agent that signs its route updates.
+type signedOrigin struct {
+	id    identity.AgentID
+	kp    *crypto.SigningKeypair
+	clock uint64
+}
+
+func newSignedOrigin(t *testing.T) *signedOrigin {
+	t.Helper()
+	id, _ := identity.NewAgentID()
+	kp, err := crypto.GenerateSigningKeypair()
+	if err != nil {
+		t.Fatalf("GenerateSigningKeypair() error = %v", err)
+	}
+	return &signedOrigin{id: id, kp: kp}
+}
+
+// sign signs a route set with a timestamp newer than the previous one.
+func (o *signedOrigin) sign(frameType uint8, routes []protocol.Route) *protocol.RouteSignature {
+	o.clock++
+	sig := &protocol.RouteSignature{Timestamp: o.clock, PublicKey: o.kp.PublicKey}
+	sig.Signature = crypto.Sign(o.kp.PrivateKey, protocol.RouteSignableBytes(frameType, o.id, sig.Timestamp, routes))
+	return sig
+}
+
+// signedAdvertise builds a received ROUTE_ADVERTISE of origin.
+func signedAdvertise(origin identity.AgentID, seq uint64, routes []protocol.Route, seenBy []identity.AgentID, sig *protocol.RouteSignature) *protocol.RouteAdvertise {
+	return &protocol.RouteAdvertise{OriginAgent: origin, Sequence: seq, Routes: routes, SeenBy: seenBy, Signature: sig}
+}
+
+// signedWithdraw builds a received ROUTE_WITHDRAW of origin.
+func signedWithdraw(origin identity.AgentID, seq uint64, routes []protocol.Route, sig *protocol.RouteSignature) *protocol.RouteWithdraw {
+	return &protocol.RouteWithdraw{OriginAgent: origin, Sequence: seq, Routes: routes, Signature: sig}
+}
+
+func testRoutes(octet byte) []protocol.Route {
+	return []protocol.Route{{
+		AddressFamily: protocol.AddrFamilyIPv4,
+		PrefixLength:  8,
+		Prefix:        []byte{octet, 0, 0, 0},
+		Metric:        1,
+	}}
+}
+
+// newSigningFlooder creates a flooder that knows the route keys of origins.
+func newSigningFlooder(t *testing.T, requireSigned bool, origins ...*signedOrigin) (*Flooder, *routing.Manager, *mockPeerSender) {
+	t.Helper()
+	localID, _ := identity.NewAgentID()
+	routeMgr := routing.NewManager(localID)
+	sender := newMockPeerSender()
+	cfg := DefaultFloodConfig()
+	cfg.RequireSignedRoutes = requireSigned
+	cfg.RouteKeys = make(map[identity.AgentID][32]byte)
+	for _, o := range origins {
+		cfg.RouteKeys[o.id] = o.kp.PublicKey
+	}
+	f := NewFlooder(cfg, localID, routeMgr, sender)
+	t.Cleanup(f.Stop)
+	return f, routeMgr, sender
+}
+
+func TestFlooder_SignedAdvertise_AcceptedAndRelayed(t *testing.T) {
+	origin := newSignedOrigin(t)
+	f, routeMgr, sender := newSigningFlooder(t, true, origin)
+	otherPeer, _ := identity.NewAgentID()
+	sender.AddPeer(otherPeer)
+
+	routes := testRoutes(10)
+	sig := origin.sign(protocol.FrameRouteAdvertise, routes)
+	if !f.HandleAdvertise(origin.id, signedAdvertise(origin.id, 1, routes, nil, sig)) {
+		t.Fatal("signed advertisement should be accepted")
+	}
+	if routeMgr.TotalRoutes() != 1 {
+		t.Errorf("TotalRoutes = %d, want 1", routeMgr.TotalRoutes())
+	}
+
+	msgs := sender.GetMessages(otherPeer)
+	if len(msgs) != 1 {
+		t.Fatalf("relayed %d frames, want 1", len(msgs))
+	}
+	adv, err := protocol.DecodeRouteAdvertise(msgs[0].Payload)
+	if err != nil {
+		t.Fatalf("DecodeRouteAdvertise() error = %v", err)
+	}
+	if adv.Signature == nil || *adv.Signature != *sig {
+		t.Errorf("relayed signature = %+v, want %+v", adv.Signature, sig)
+	}
+}
+
+func TestFlooder_SignedAdvertise_Rejections(t *testing.T) {
+	origin := newSignedOrigin(t)
+	routes := testRoutes(10)
+
+	t.Run("unsigned when required", func(t *testing.T) {
+		f, routeMgr, _ := newSigningFlooder(t, true, origin)
+		if f.HandleAdvertise(origin.id, signedAdvertise(origin.id, 1, routes, nil, nil)) {
+			t.Error("unsigned advertisement should be rejected")
+		}
+		if routeMgr.TotalRoutes() != 0 || f.HasSeen(origin.id, 1) {
+			t.Error("rejected advertisement should not be applied or marked seen")
+		}
+	})
+
+	t.Run("modified routes", func(t *testing.T) {
+		f, routeMgr, _ := newSigningFlooder(t, false, origin)
+		sig := origin.sign(protocol.FrameRouteAdvertise, routes)
+		if f.HandleAdvertise(origin.id, signedAdvertise(origin.id, 1, testRoutes(192), nil, sig)) {
+			t.Error("advertisement with modified routes should be rejected")
+		}
+		if routeMgr.TotalRoutes() != 0 {
+			t.Errorf("TotalRoutes = %d, want 0", routeMgr.TotalRoutes())
+		}
+
+		// The genuine copy with the same sequence is still accepted
+		if !f.HandleAdvertise(origin.id, signedAdvertise(origin.id, 1, routes, nil, sig)) {
+			t.Error("genuine advertisement should be accepted after a forged copy")
+		}
+	})
+
+	t.Run("signature for another origin", func(t *testing.T) {
+		other := newSignedOrigin(t)
+		f, _, _ := newSigningFlooder(t, false, origin, other)
+		sig := origin.sign(protocol.FrameRouteAdvertise, routes)
+		if f.HandleAdvertise(other.id, signedAdvertise(other.id, 1, routes, nil, sig)) {
+			t.Error("signature over another origin should be rejected")
+		}
+	})
+
+	t.Run("advertisement signature used for withdrawal", func(t *testing.T) {
+		f, _, _ := newSigningFlooder(t, false, origin)
+		sig := origin.sign(protocol.FrameRouteAdvertise, routes)
+		if f.HandleWithdraw(origin.id, signedWithdraw(origin.id, 2, routes, sig)) {
+			t.Error("withdrawal with an advertisement signature should be rejected")
+		}
+	})
+
+	t.Run("different key", func(t *testing.T) {
+		f, _, _ := newSigningFlooder(t, false, origin)
+		sig := origin.sign(protocol.FrameRouteAdvertise, routes)
+		if !f.HandleAdvertise(origin.id, signedAdvertise(origin.id, 1, routes, nil, sig)) {
+			t.Fatal("first signed advertisement should be accepted")
+		}
+
+		// Another key claiming the same origin
+		impostor := newSignedOrigin(t)
+		impostor.id, impostor.clock = origin.id, origin.clock
+		forged := impostor.sign(protocol.FrameRouteAdvertise, testRoutes(192))
+		if f.HandleAdvertise(origin.id, signedAdvertise(origin.id, 2, testRoutes(192), nil, forged)) {
+			t.Error("advertisement signed with a different key should be rejected")
+		}
+	})
+
+	t.Run("stale", func(t *testing.T) {
+		f, _, _ := newSigningFlooder(t, false, origin)
+		old := origin.sign(protocol.FrameRouteAdvertise, testRoutes(192))
+		newer := origin.sign(protocol.FrameRouteAdvertise, routes)
+		if !f.HandleAdvertise(origin.id, signedAdvertise(origin.id, 2, routes, nil, newer)) {
+			t.Fatal("newer advertisement should be accepted")
+		}
+		if f.HandleAdvertise(origin.id, signedAdvertise(origin.id, 1, testRoutes(192), nil, old)) {
+			t.Error("older advertisement should be rejected")
+		}
+	})
+
+	t.Run("origin without route key when required", func(t *testing.T) {
+		f, routeMgr, _ := newSigningFlooder(t, true)
+		sig := origin.sign(protocol.FrameRouteAdvertise, routes)
+		if f.HandleAdvertise(origin.id, signedAdvertise(origin.id, 1, routes, nil, sig)) {
+			t.Error("advertisement from an origin without a route key should be rejected")
+		}
+		if routeMgr.TotalRoutes() != 0 {
+			t.Errorf("TotalRoutes = %d, want 0", routeMgr.TotalRoutes())
+		}
+	})
+}
+
+func TestFlooder_SignedAdvertise_UnknownOriginIsUnsigned(t *testing.T) {
+	f, routeMgr, _ := newSigningFlooder(t, false)
+	origin := newSignedOrigin(t)
+	routes := testRoutes(10)
+
+	if !f.HandleAdvertise(origin.id, signedAdvertise(origin.id, 1, routes, nil, origin.sign(protocol.FrameRouteAdvertise, routes))) {
+		t.Fatal("advertisement should be accepted when signatures are not required")
+	}
+	if routeMgr.TotalRoutes() != 1 {
+		t.Errorf("TotalRoutes = %d, want 1", routeMgr.TotalRoutes())
+	}
+	if _, sig := f.signedRoutes(origin.id); sig != nil {
+		t.Error("signature without a route key should not be stored as verified")
+	}
+}
+
+func TestFlooder_SignedAdvertise_TransitResignRejected(t *testing.T) {
+	origin := newSignedOrigin(t)
+	transit := newSignedOrigin(t)
+	f, routeMgr, _ := newSigningFlooder(t, true, origin, transit)
+
+	// The transit agent re-signs the origin's routes with its own valid key
+	// before the origin's genuine update arrives
+	routes := testRoutes(10)
+	forger := &signedOrigin{id: origin.id, kp: transit.kp}
+	forged := forger.sign(protocol.FrameRouteAdvertise, testRoutes(192))
+	if f.HandleAdvertise(transit.id, signedAdvertise(origin.id, 1, testRoutes(192), nil, forged)) {
+		t.Fatal("routes re-signed by a transit agent should be rejected")
+	}
+	if routeMgr.TotalRoutes() != 0 {
+		t.Fatalf("TotalRoutes = %d, want 0", routeMgr.TotalRoutes())
+	}
+
+	if !f.HandleAdvertise(transit.id, signedAdvertise(origin.id, 1, routes, nil, origin.sign(protocol.FrameRouteAdvertise, routes))) {
+		t.Error("genuine advertisement should be accepted after the forged one")
+	}
+}
+
+func TestFlooder_SignedWithdraw(t *testing.T) {
+	origin := newSignedOrigin(t)
+	f, routeMgr, _ := newSigningFlooder(t, true, origin)
+	routes := testRoutes(10)
+
+	if !f.HandleAdvertise(origin.id, signedAdvertise(origin.id, 1, routes, nil, origin.sign(protocol.FrameRouteAdvertise, routes))) {
+		t.Fatal("signed advertisement should be accepted")
+	}
+	if f.HandleWithdraw(origin.id, signedWithdraw(origin.id, 2, routes, nil)) {
+		t.Error("unsigned withdrawal should be rejected")
+	}
+	if routeMgr.TotalRoutes() != 1 {
+		t.Fatalf("TotalRoutes = %d after rejected withdrawal, want 1", routeMgr.TotalRoutes())
+	}
+	if !f.HandleWithdraw(origin.id, signedWithdraw(origin.id, 3, routes, origin.sign(protocol.FrameRouteWithdraw, routes))) {
+		t.Error("signed withdrawal should be accepted")
+	}
+	if routeMgr.TotalRoutes() != 0 {
+		t.Errorf("TotalRoutes = %d after withdrawal, want 0", routeMgr.TotalRoutes())
+	}
+	if _, sig := f.signedRoutes(origin.id); sig != nil {
+		t.Error("withdrawal should clear the stored signed route set")
+	}
+}
+
+func TestFlooder_AnnounceLocalRoutes_Signed(t *testing.T) {
+	localID, _ := identity.NewAgentID()
+	peerID, _ := identity.NewAgentID()
+	kp, _ := crypto.GenerateSigningKeypair()
+	sender := newMockPeerSender()
+	sender.AddPeer(peerID)
+	cfg := DefaultFloodConfig()
+	cfg.RouteSigner = kp
+	f := NewFlooder(cfg, localID, routing.NewManager(localID), sender)
+	defer f.Stop()
+
+	f.AnnounceLocalRoutes()
+
+	msgs := sender.GetMessages(peerID)
+	if len(msgs) != 1 {
+		t.Fatalf("sent %d frames, want 1", len(msgs))
+	}
+	adv, err := protocol.DecodeRouteAdvertise(msgs[0].Payload)
+	if err != nil {
+		t.Fatalf("DecodeRouteAdvertise() error = %v", err)
+	}
+	if adv.Signature == nil {
+		t.Fatal("local advertisement is not signed")
+	}
+	if adv.Signature.PublicKey != kp.PublicKey {
+		t.Error("local advertisement signed with the wrong key")
+	}
+	msg := protocol.RouteSignableBytes(protocol.FrameRouteAdvertise, localID, adv.Signature.Timestamp, adv.Routes)
+	if !crypto.Verify(kp.PublicKey, msg, adv.Signature.Signature) {
+		t.Error("local advertisement signature does not verify")
+	}
+}
+
+func TestFlooder_SendFullTable_ReplaysSignedRoutes(t *testing.T) {
+	origin := newSignedOrigin(t)
+	f, _, sender := newSigningFlooder(t, true, origin)
+	newPeer, _ := identity.NewAgentID()
+
+	routes := testRoutes(10)
+	sig := origin.sign(protocol.FrameRouteAdvertise, routes)
+	if !f.HandleAdvertise(origin.id, signedAdvertise(origin.id, 1, routes, []identity.AgentID{origin.id}, sig)) {
+		t.Fatal("signed advertisement should be accepted")
+	}
+
+	f.SendFullTable(newPeer)
+
+	var found bool
+	for _, frame := range sender.GetMessages(newPeer) {
+		adv, err := protocol.DecodeRouteAdvertise(frame.Payload)
+		if err != nil {
+			t.Fatalf("DecodeRouteAdvertise() error = %v", err)
+		}
+		if adv.OriginAgent != origin.id {
+			continue
+		}
+		found = true
+		if adv.Signature == nil || *adv.Signature != *sig {
+			t.Fatalf("replayed signature = %+v, want %+v", adv.Signature, sig)
+		}
+
+		// A receiver requiring signatures accepts the replayed set
+		g, routeMgr, _ := newSigningFlooder(t, true, origin)
+		if !g.HandleAdvertise(f.localID, adv) {
+			t.Error("replayed signed route set should be accepted")
+		}
+		if routeMgr.TotalRoutes() != 1 {
+			t.Errorf("TotalRoutes = %d, want 1", routeMgr.TotalRoutes())
+		}
+	}
+	if !found {
+		t.Fatal("full table does not include the signed origin")
+	}
+}
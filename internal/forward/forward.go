@@ -23,17 +23,25 @@ type StreamWriter interface {
 	WriteStreamData(peerID identity.AgentID, streamID uint64, data []byte, flags uint8) error
 
 	// WriteStreamOpenAck sends a successful open acknowledgment with ephemeral public key for E2E encryption.
-	WriteStreamOpenAck(peerID identity.AgentID, streamID uint64, requestID uint64, boundIP net.IP, boundPort uint16, ephemeralPubKey [crypto.KeySize]byte) error
+	WriteStreamOpenAck(peerID identity.AgentID, streamID uint64, requestID uint64, boundIP net.IP, boundPort uint16, ephemeralPubKey [crypto.KeySize]byte, e2eFlags uint8) error
 
 	// WriteStreamOpenErr sends a failed open acknowledgment.
 	WriteStreamOpenErr(peerID identity.AgentID, streamID uint64, requestID uint64, errorCode uint16, message string) error
 
 	// WriteStreamClose sends a close frame.
 	WriteStreamClose(peerID identity.AgentID, streamID uint64) error
+
+	// WriteStreamReset aborts a stream with an error code.
+	WriteStreamReset(peerID identity.AgentID, streamID uint64, errorCode uint16) error
+
+	// MaxStreamPlaintext returns the largest plaintext that encrypts into
+	// one frame on the link to a peer.
+	MaxStreamPlaintext(peerID identity.AgentID) int
 }
 
 // Endpoint represents a tunnel exit point configuration.
 type Endpoint struct {
-	Key    string // Routing key
-	Target string // Fixed target host:port
+	Key           string // Routing key
+	Target        string // Fixed target host:port
+	ProxyProtocol bool   // Send a PROXY protocol v2 header to the target
 }
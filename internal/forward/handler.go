@@ -15,6 +15,7 @@ import (
 	"github.com/postalsys/muti-metroo/internal/identity"
 	"github.com/postalsys/muti-metroo/internal/logging"
 	"github.com/postalsys/muti-metroo/internal/protocol"
+	"github.com/postalsys/muti-metroo/internal/proxyproto"
 	"github.com/postalsys/muti-metroo/internal/recovery"
 )
 
@@ -32,6 +33,10 @@ type HandlerConfig struct {
 	// MaxConnections limits concurrent connections.
 	MaxConnections int
 
+	// Rekey sets when streams replace their E2E key, for ingresses that
+	// ask for it. Zero disables rekeying.
+	Rekey crypto.RekeyConfig
+
 	// Logger for logging.
 	Logger *slog.Logger
 }
@@ -56,6 +61,8 @@ type ActiveConnection struct {
 	closed     atomic.Bool
 	closeOnce  sync.Once
 	sessionKey *crypto.SessionKey // E2E encryption session key
+
+	pathMaxPayload int // Payload limit of the path to the listener (0 if unknown)
 }
 
 // Close closes the connection.
@@ -81,7 +88,10 @@ type Handler struct {
 	localID identity.AgentID
 	writer  StreamWriter
 	logger  *slog.Logger
-	targets map[string]string // routing key -> target
+
+	targetsMu sync.RWMutex
+	targets   map[string]string // routing key -> target
+	proxyKeys map[string]bool   // routing keys whose targets get a PROXY header
 
 	mu          sync.RWMutex
 	connections map[uint64]*ActiveConnection
@@ -101,8 +111,12 @@ func NewHandler(cfg HandlerConfig, localID identity.AgentID, writer StreamWriter
 
 	// Build targets map
 	targets := make(map[string]string)
+	proxyKeys := make(map[string]bool)
 	for _, ep := range cfg.Endpoints {
 		targets[ep.Key] = ep.Target
+		if ep.ProxyProtocol {
+			proxyKeys[ep.Key] = true
+		}
 	}
 
 	return &Handler{
@@ -111,6 +125,7 @@ func NewHandler(cfg HandlerConfig, localID identity.AgentID, writer StreamWriter
 		writer:      writer,
 		logger:      logger,
 		targets:     targets,
+		proxyKeys:   proxyKeys,
 		connections: make(map[uint64]*ActiveConnection),
 		stopCh:      make(chan struct{}),
 	}
@@ -145,12 +160,18 @@ func (h *Handler) IsRunning() bool {
 
 // GetTarget returns the target for a routing key.
 func (h *Handler) GetTarget(key string) (string, bool) {
+	h.targetsMu.RLock()
+	defer h.targetsMu.RUnlock()
+
 	target, ok := h.targets[key]
 	return target, ok
 }
 
 // GetKeys returns all configured routing keys.
 func (h *Handler) GetKeys() []string {
+	h.targetsMu.RLock()
+	defer h.targetsMu.RUnlock()
+
 	keys := make([]string, 0, len(h.targets))
 	for k := range h.targets {
 		keys = append(keys, k)
@@ -158,6 +179,38 @@ func (h *Handler) GetKeys() []string {
 	return keys
 }
 
+// AddEndpoint registers a routing key at runtime, replacing any existing
+// target for the key.
+func (h *Handler) AddEndpoint(key, target string) {
+	h.targetsMu.Lock()
+	defer h.targetsMu.Unlock()
+
+	h.targets[key] = target
+	delete(h.proxyKeys, key)
+}
+
+// RemoveEndpoint unregisters a routing key. Connections already established
+// through the key are left open. Returns false if the key was not registered.
+func (h *Handler) RemoveEndpoint(key string) bool {
+	h.targetsMu.Lock()
+	defer h.targetsMu.Unlock()
+
+	if _, ok := h.targets[key]; !ok {
+		return false
+	}
+	delete(h.targets, key)
+	delete(h.proxyKeys, key)
+	return true
+}
+
+// sendsProxyHeader reports whether the target of key gets a PROXY header.
+func (h *Handler) sendsProxyHeader(key string) bool {
+	h.targetsMu.RLock()
+	defer h.targetsMu.RUnlock()
+
+	return h.proxyKeys[key]
+}
+
 // HandleStreamOpen processes a tunnel STREAM_OPEN request.
 // The TCP dial is performed asynchronously to avoid blocking the frame processing loop.
 func (h *Handler) HandleStreamOpen(ctx context.Context, streamID uint64, requestID uint64, remoteID identity.AgentID, key string, remoteEphemeralPub [crypto.KeySize]byte) error {
@@ -172,7 +225,7 @@ func (h *Handler) HandleStreamOpen(ctx context.Context, streamID uint64, request
 	}
 
 	// Look up target for this routing key
-	target, ok := h.targets[key]
+	target, ok := h.GetTarget(key)
 	if !ok {
 		h.sendOpenErr(remoteID, streamID, requestID, protocol.ErrForwardNotFound, "forward key not found")
 		return fmt.Errorf("forward key not found: %s", key)
@@ -203,14 +256,20 @@ func (h *Handler) handleStreamOpenAsync(ctx context.Context, streamID uint64, re
 		return
 	}
 
-	// Zero out ephemeral private key after computing shared secret
-	crypto.ZeroKey(&ephPriv)
-
 	// Derive session key - we are the responder (exit node)
 	// Use requestID (not streamID) because streamID changes at each relay hop
 	sessionKey := crypto.DeriveSessionKey(sharedSecret, requestID, remoteEphemeralPub, ephPub, false)
 	crypto.ZeroKey(&sharedSecret)
 
+	var e2eFlags uint8
+	if crypto.RekeyFromContext(ctx) && h.cfg.Rekey.Enabled() {
+		sessionKey.EnableRekey(ephPriv, remoteEphemeralPub, h.cfg.Rekey)
+		e2eFlags = protocol.E2EFlagRekey
+	}
+
+	// Zero out ephemeral private key after deriving the session key
+	crypto.ZeroKey(&ephPriv)
+
 	// Connect to target
 	dialer := &net.Dialer{Timeout: h.cfg.ConnectTimeout}
 	conn, err := dialer.DialContext(ctx, "tcp", target)
@@ -220,6 +279,16 @@ func (h *Handler) handleStreamOpenAsync(ctx context.Context, streamID uint64, re
 		return
 	}
 
+	// Tell the target which client connected at the ingress
+	if h.sendsProxyHeader(key) {
+		dst, _ := conn.RemoteAddr().(*net.TCPAddr)
+		if _, err := conn.Write(proxyproto.Header(proxyproto.ClientAddrFromContext(ctx), dst)); err != nil {
+			conn.Close()
+			h.sendOpenErr(remoteID, streamID, requestID, protocol.ErrGeneralFailure, "write PROXY header: "+err.Error())
+			return
+		}
+	}
+
 	// Get local address for ACK
 	localAddr := conn.LocalAddr().(*net.TCPAddr)
 
@@ -232,6 +301,8 @@ func (h *Handler) handleStreamOpenAsync(ctx context.Context, streamID uint64, re
 		Conn:       conn,
 		StartedAt:  time.Now(),
 		sessionKey: sessionKey,
+
+		pathMaxPayload: protocol.PathMaxPayloadFromContext(ctx),
 	}
 
 	h.mu.Lock()
@@ -245,7 +316,7 @@ func (h *Handler) handleStreamOpenAsync(ctx context.Context, streamID uint64, re
 		logging.KeyStreamID, streamID)
 
 	// Send ACK with our ephemeral public key
-	if err := h.writer.WriteStreamOpenAck(remoteID, streamID, requestID, localAddr.IP, uint16(localAddr.Port), ephPub); err != nil {
+	if err := h.writer.WriteStreamOpenAck(remoteID, streamID, requestID, localAddr.IP, uint16(localAddr.Port), ephPub, e2eFlags); err != nil {
 		ac.Close()
 		h.removeConnection(streamID)
 		return
@@ -278,7 +349,7 @@ func (h *Handler) HandleStreamData(peerID identity.AgentID, streamID uint64, dat
 
 		plaintext, err := ac.sessionKey.Decrypt(data)
 		if err != nil {
-			h.closeConnection(streamID, peerID, err)
+			h.resetConnection(streamID, peerID, err)
 			return fmt.Errorf("decrypt: %w", err)
 		}
 
@@ -309,15 +380,26 @@ func (h *Handler) HandleStreamReset(peerID identity.AgentID, streamID uint64, er
 	h.closeConnection(streamID, peerID, fmt.Errorf("reset with code %d", errorCode))
 }
 
+// maxPlaintext returns the largest plaintext that encrypts into one frame
+// on the link to the connection's peer and, when known, on the rest of the
+// path to the listener.
+func (h *Handler) maxPlaintext(ac *ActiveConnection) int {
+	n := h.writer.MaxStreamPlaintext(ac.RemoteID)
+	if ac.pathMaxPayload > 0 {
+		n = min(n, ac.pathMaxPayload-crypto.MaxEncryptionOverhead)
+	}
+	return n
+}
+
 // readLoop reads data from the target and forwards to the stream.
 func (h *Handler) readLoop(ac *ActiveConnection) {
 	defer h.closeConnection(ac.StreamID, ac.RemoteID, nil)
 	defer recovery.RecoverWithLog(h.logger, "forward.readLoop")
 
 	// Account for encryption overhead when reading
-	// Each encrypted message must fit in a single frame to be decryptable
-	maxPlaintext := protocol.MaxPayloadSize - crypto.EncryptionOverhead
-	buf := make([]byte, maxPlaintext)
+	// Each encrypted message must fit in a single frame on every link to the
+	// listener to be decryptable
+	buf := make([]byte, h.maxPlaintext(ac))
 
 	for {
 		select {
@@ -384,6 +466,26 @@ func (h *Handler) closeConnection(streamID uint64, peerID identity.AgentID, err
 	}
 }
 
+// resetConnection aborts a stream whose data failed E2E validation, telling
+// the ingress with ErrE2EValidation.
+func (h *Handler) resetConnection(streamID uint64, peerID identity.AgentID, err error) {
+	ac := h.removeConnection(streamID)
+	if ac == nil {
+		return
+	}
+
+	ac.Close()
+
+	h.logger.Warn("forward stream data failed E2E validation, stream reset",
+		"key", ac.Key,
+		logging.KeyStreamID, streamID,
+		logging.KeyError, err)
+
+	if h.writer != nil {
+		h.writer.WriteStreamReset(peerID, streamID, protocol.ErrE2EValidation)
+	}
+}
+
 // removeConnection removes a connection from tracking.
 func (h *Handler) removeConnection(streamID uint64) *ActiveConnection {
 	h.mu.Lock()
@@ -29,6 +29,7 @@ type mockStreamWriter struct {
 	acks         []ackWrite
 	errors       []errWrite
 	closes       []closeWrite
+	resets       []resetWrite
 	writeFail    bool
 	writeDataErr error
 }
@@ -62,6 +63,12 @@ type closeWrite struct {
 	StreamID uint64
 }
 
+type resetWrite struct {
+	PeerID    identity.AgentID
+	StreamID  uint64
+	ErrorCode uint16
+}
+
 func (m *mockStreamWriter) WriteStreamData(peerID identity.AgentID, streamID uint64, data []byte, flags uint8) error {
 	if m.writeDataErr != nil {
 		return m.writeDataErr
@@ -77,7 +84,7 @@ func (m *mockStreamWriter) WriteStreamData(peerID identity.AgentID, streamID uin
 	return nil
 }
 
-func (m *mockStreamWriter) WriteStreamOpenAck(peerID identity.AgentID, streamID uint64, requestID uint64, boundIP net.IP, boundPort uint16, ephemeralPubKey [crypto.KeySize]byte) error {
+func (m *mockStreamWriter) WriteStreamOpenAck(peerID identity.AgentID, streamID uint64, requestID uint64, boundIP net.IP, boundPort uint16, ephemeralPubKey [crypto.KeySize]byte, e2eFlags uint8) error {
 	if m.writeFail {
 		return net.ErrClosed
 	}
@@ -117,6 +124,21 @@ func (m *mockStreamWriter) WriteStreamClose(peerID identity.AgentID, streamID ui
 	return nil
 }
 
+func (m *mockStreamWriter) WriteStreamReset(peerID identity.AgentID, streamID uint64, errorCode uint16) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.resets = append(m.resets, resetWrite{
+		PeerID:    peerID,
+		StreamID:  streamID,
+		ErrorCode: errorCode,
+	})
+	return nil
+}
+
+func (m *mockStreamWriter) MaxStreamPlaintext(peerID identity.AgentID) int {
+	return protocol.MaxPayloadSize - crypto.EncryptionOverhead
+}
+
 func (m *mockStreamWriter) getDataWrites() []dataWrite {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -670,3 +692,30 @@ func TestHandler_NoEndpoints(t *testing.T) {
 		t.Error("expected false for any key with no endpoints")
 	}
 }
+
+func TestHandler_AddRemoveEndpoint(t *testing.T) {
+	handler := NewHandler(HandlerConfig{
+		Endpoints: []Endpoint{{Key: "web", Target: "localhost:80"}},
+	}, mustNewAgentID(), &mockStreamWriter{})
+
+	handler.AddEndpoint("db", "db.local:5432")
+	if target, ok := handler.GetTarget("db"); !ok || target != "db.local:5432" {
+		t.Errorf("GetTarget(db) = %q, %v; want db.local:5432, true", target, ok)
+	}
+	if len(handler.GetKeys()) != 2 {
+		t.Errorf("expected 2 keys, got %d", len(handler.GetKeys()))
+	}
+
+	if !handler.RemoveEndpoint("db") {
+		t.Error("RemoveEndpoint(db) should return true")
+	}
+	if handler.RemoveEndpoint("db") {
+		t.Error("RemoveEndpoint(db) should return false once removed")
+	}
+	if _, ok := handler.GetTarget("db"); ok {
+		t.Error("db should no longer resolve")
+	}
+	if _, ok := handler.GetTarget("web"); !ok {
+		t.Error("config endpoint web should be unaffected")
+	}
+}
@@ -2,14 +2,18 @@ package forward
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"io"
 	"log/slog"
 	"net"
 	"sync"
 	"sync/atomic"
+	"time"
 
+	"github.com/postalsys/muti-metroo/internal/ipfamily"
 	"github.com/postalsys/muti-metroo/internal/logging"
+	"github.com/postalsys/muti-metroo/internal/proxyproto"
 	"github.com/postalsys/muti-metroo/internal/recovery"
 )
 
@@ -21,9 +25,26 @@ type ListenerConfig struct {
 	// Address is the local address to listen on.
 	Address string
 
+	// Family selects the IP families of the listener (empty = dual-stack).
+	Family ipfamily.Family
+
 	// MaxConnections limits concurrent connections (0 = unlimited).
 	MaxConnections int
 
+	// TLS terminates TLS on accepted connections when set; the decrypted
+	// stream is forwarded.
+	TLS *tls.Config
+
+	// SNIMap routes connections by TLS server name to other routing keys
+	// ("*.example.com" matches one label). Connections without a matching
+	// name use Key. Without TLS, the ClientHello is read and replayed, so
+	// TLS passes through to the endpoint unchanged.
+	SNIMap map[string]string
+
+	// SendClientAddress passes the client address to the endpoint, for
+	// endpoints that send it on with the PROXY protocol.
+	SendClientAddress bool
+
 	// Logger for logging.
 	Logger *slog.Logger
 }
@@ -51,6 +72,7 @@ func NewListener(cfg ListenerConfig, dialer ForwardDialer) *Listener {
 	if logger == nil {
 		logger = logging.NopLogger()
 	}
+	cfg.SNIMap = normalizeSNIMap(cfg.SNIMap)
 
 	return &Listener{
 		cfg:         cfg,
@@ -67,7 +89,7 @@ func (l *Listener) Start() error {
 		return fmt.Errorf("listener already running")
 	}
 
-	listener, err := net.Listen("tcp", l.cfg.Address)
+	listener, err := l.cfg.Family.Listen(l.cfg.Address)
 	if err != nil {
 		return fmt.Errorf("listen on %s: %w", l.cfg.Address, err)
 	}
@@ -196,6 +218,18 @@ func (l *Listener) handleConnection(conn net.Conn) {
 	// Create context for dialing (cancellable if we stop)
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
+	if l.cfg.SendClientAddress {
+		ctx = proxyproto.WithClientAddr(ctx, conn.RemoteAddr())
+	}
+
+	client, key, err := l.prepareConn(ctx, conn)
+	if err != nil {
+		l.logger.Debug("forward TLS handshake failed",
+			"key", l.cfg.Key,
+			"remote", remoteAddr,
+			logging.KeyError, err)
+		return
+	}
 
 	// Cancel dial if we're stopping
 	go func() {
@@ -207,10 +241,10 @@ func (l *Listener) handleConnection(conn net.Conn) {
 	}()
 
 	// Dial through the mesh to the port forward exit
-	target, err := l.dialer.DialForward(ctx, l.cfg.Key)
+	target, err := l.dialer.DialForward(ctx, key)
 	if err != nil {
 		l.logger.Debug("dial forward failed",
-			"key", l.cfg.Key,
+			"key", key,
 			"remote", remoteAddr,
 			logging.KeyError, err)
 		return
@@ -218,17 +252,48 @@ func (l *Listener) handleConnection(conn net.Conn) {
 	defer target.Close()
 
 	l.logger.Debug("forward connected",
-		"key", l.cfg.Key,
+		"key", key,
 		"remote", remoteAddr)
 
 	// Relay data bidirectionally
-	relay(conn, target)
+	relay(client, target)
 
 	l.logger.Debug("forward connection closed",
-		"key", l.cfg.Key,
+		"key", key,
 		"remote", remoteAddr)
 }
 
+// prepareConn terminates TLS or reads the TLS server name as configured and
+// returns the connection to relay and the routing key to dial.
+func (l *Listener) prepareConn(ctx context.Context, conn net.Conn) (net.Conn, string, error) {
+	if l.cfg.TLS == nil && len(l.cfg.SNIMap) == 0 {
+		return conn, l.cfg.Key, nil
+	}
+
+	key := l.cfg.Key
+	if l.cfg.TLS != nil {
+		hsCtx, cancel := context.WithTimeout(ctx, sniPeekTimeout)
+		defer cancel()
+		tlsConn := tls.Server(conn, l.cfg.TLS)
+		if err := tlsConn.HandshakeContext(hsCtx); err != nil {
+			return nil, "", err
+		}
+		if k, ok := matchSNI(l.cfg.SNIMap, tlsConn.ConnectionState().ServerName); ok {
+			key = k
+		}
+		return tlsConn, key, nil
+	}
+
+	// Non-TLS clients get the default key with their bytes replayed
+	conn.SetReadDeadline(time.Now().Add(sniPeekTimeout))
+	serverName, peeked, _ := peekServerName(conn)
+	conn.SetReadDeadline(time.Time{})
+	if k, ok := matchSNI(l.cfg.SNIMap, serverName); ok {
+		key = k
+	}
+	return newPrefixConn(conn, peeked), key, nil
+}
+
 // halfCloser is implemented by connections that support half-close.
 type halfCloser interface {
 	CloseWrite() error
@@ -0,0 +1,97 @@
+package forward
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// sniPeekTimeout bounds the wait for a TLS ClientHello (or handshake) on
+// listeners that route by server name or terminate TLS.
+const sniPeekTimeout = 10 * time.Second
+
+// errHelloRead stops the sniffing handshake once the ClientHello is parsed.
+var errHelloRead = errors.New("client hello read")
+
+// normalizeSNIMap lowercases server names so lookups are case-insensitive.
+func normalizeSNIMap(m map[string]string) map[string]string {
+	if len(m) == 0 {
+		return nil
+	}
+	out := make(map[string]string, len(m))
+	for name, key := range m {
+		out[strings.ToLower(strings.TrimSuffix(name, "."))] = key
+	}
+	return out
+}
+
+// matchSNI returns the routing key for a server name: an exact entry, or a
+// "*.parent" entry matching one label.
+func matchSNI(m map[string]string, serverName string) (string, bool) {
+	name := strings.ToLower(strings.TrimSuffix(serverName, "."))
+	if name == "" || len(m) == 0 {
+		return "", false
+	}
+	if key, ok := m[name]; ok {
+		return key, true
+	}
+	if i := strings.IndexByte(name, '.'); i > 0 {
+		if key, ok := m["*"+name[i:]]; ok {
+			return key, true
+		}
+	}
+	return "", false
+}
+
+// peekServerName reads the TLS ClientHello from conn without answering it
+// and returns the requested server name together with every byte read, so
+// the connection can be replayed unchanged. ok is false when the client
+// did not start a TLS handshake.
+func peekServerName(conn net.Conn) (serverName string, peeked []byte, ok bool) {
+	var buf bytes.Buffer
+	sniff := &sniffConn{Conn: conn, r: io.TeeReader(conn, &buf)}
+
+	err := tls.Server(sniff, &tls.Config{
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			serverName = hello.ServerName
+			return nil, errHelloRead
+		},
+	}).Handshake()
+
+	return serverName, buf.Bytes(), errors.Is(err, errHelloRead)
+}
+
+// sniffConn feeds a handshake from a recording reader and discards
+// everything the handshake writes.
+type sniffConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c *sniffConn) Read(b []byte) (int, error)  { return c.r.Read(b) }
+func (c *sniffConn) Write(b []byte) (int, error) { return len(b), nil }
+
+// prefixConn replays bytes read ahead of time before reading from the
+// underlying connection.
+type prefixConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func newPrefixConn(conn net.Conn, prefix []byte) *prefixConn {
+	return &prefixConn{Conn: conn, r: io.MultiReader(bytes.NewReader(prefix), conn)}
+}
+
+func (c *prefixConn) Read(b []byte) (int, error) { return c.r.Read(b) }
+
+// CloseWrite half-closes the underlying connection when it supports it.
+func (c *prefixConn) CloseWrite() error {
+	if hc, ok := c.Conn.(halfCloser); ok {
+		return hc.CloseWrite()
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+package forward
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/postalsys/muti-metroo/internal/certutil"
+)
+
+func TestMatchSNI(t *testing.T) {
+	m := normalizeSNIMap(map[string]string{
+		"git.example.com":    "git",
+		"*.Apps.example.com": "apps",
+	})
+
+	tests := []struct {
+		name    string
+		wantKey string
+		wantOK  bool
+	}{
+		{"git.example.com", "git", true},
+		{"GIT.example.com.", "git", true},
+		{"wiki.apps.example.com", "apps", true},
+		{"a.b.apps.example.com", "", false},
+		{"apps.example.com", "", false},
+		{"other.example.com", "", false},
+		{"", "", false},
+	}
+
+	for _, tt := range tests {
+		key, ok := matchSNI(m, tt.name)
+		if key != tt.wantKey || ok != tt.wantOK {
+			t.Errorf("matchSNI(%q) = %q, %v, want %q, %v", tt.name, key, ok, tt.wantKey, tt.wantOK)
+		}
+	}
+}
+
+// dialedConn is a mesh connection opened by a listener under test.
+type dialedConn struct {
+	key  string
+	conn net.Conn
+}
+
+// startSNIListener starts a listener whose dials are handed to the test.
+func startSNIListener(t *testing.T, cfg ListenerConfig) (*Listener, <-chan dialedConn) {
+	t.Helper()
+	dials := make(chan dialedConn, 1)
+	dialer := &mockDialer{
+		dialFunc: func(ctx context.Context, key string) (net.Conn, error) {
+			local, remote := net.Pipe()
+			dials <- dialedConn{key: key, conn: remote}
+			return local, nil
+		},
+	}
+
+	cfg.Address = "127.0.0.1:0"
+	l := NewListener(cfg, dialer)
+	if err := l.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	t.Cleanup(func() { l.Stop() })
+	return l, dials
+}
+
+func waitDial(t *testing.T, dials <-chan dialedConn) dialedConn {
+	t.Helper()
+	select {
+	case d := <-dials:
+		t.Cleanup(func() { d.conn.Close() })
+		return d
+	case <-time.After(5 * time.Second):
+		t.Fatal("listener did not dial the mesh")
+		return dialedConn{}
+	}
+}
+
+func TestListener_SNIPassthrough(t *testing.T) {
+	l, dials := startSNIListener(t, ListenerConfig{
+		Key:    "default",
+		SNIMap: map[string]string{"git.example.com": "git"},
+	})
+
+	conn, err := net.Dial("tcp", l.Address().String())
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer conn.Close()
+	go tls.Client(conn, &tls.Config{ServerName: "git.example.com", InsecureSkipVerify: true}).Handshake()
+
+	d := waitDial(t, dials)
+	if d.key != "git" {
+		t.Errorf("dialed key = %q, want %q", d.key, "git")
+	}
+
+	// The endpoint receives the untouched ClientHello
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(d.conn, header); err != nil {
+		t.Fatalf("read replayed hello: %v", err)
+	}
+	if header[0] != 0x16 {
+		t.Errorf("first record type = %#x, want handshake (0x16)", header[0])
+	}
+}
+
+func TestListener_SNIPlaintextFallback(t *testing.T) {
+	l, dials := startSNIListener(t, ListenerConfig{
+		Key:    "default",
+		SNIMap: map[string]string{"git.example.com": "git"},
+	})
+
+	conn, err := net.Dial("tcp", l.Address().String())
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("GET / HTTP/1.0\r\n\r\n"))
+
+	d := waitDial(t, dials)
+	if d.key != "default" {
+		t.Errorf("dialed key = %q, want %q", d.key, "default")
+	}
+	buf := make([]byte, 18)
+	if _, err := io.ReadFull(d.conn, buf); err != nil {
+		t.Fatalf("read replayed request: %v", err)
+	}
+	if string(buf) != "GET / HTTP/1.0\r\n\r\n" {
+		t.Errorf("replayed = %q", buf)
+	}
+}
+
+func TestListener_TLSTermination(t *testing.T) {
+	opts := certutil.DefaultServerOptions("tunnel.example.com")
+	opts.DNSNames = append(opts.DNSNames, "*.apps.example.com")
+	gen, err := certutil.GenerateCert(opts)
+	if err != nil {
+		t.Fatalf("GenerateCert() error = %v", err)
+	}
+	cert, err := gen.TLSCertificate()
+	if err != nil {
+		t.Fatalf("TLSCertificate() error = %v", err)
+	}
+
+	l, dials := startSNIListener(t, ListenerConfig{
+		Key:    "default",
+		TLS:    &tls.Config{Certificates: []tls.Certificate{cert}},
+		SNIMap: map[string]string{"*.apps.example.com": "apps"},
+	})
+
+	conn, err := tls.Dial("tcp", l.Address().String(), &tls.Config{
+		ServerName:         "wiki.apps.example.com",
+		InsecureSkipVerify: true,
+	})
+	if err != nil {
+		t.Fatalf("tls.Dial() error = %v", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("hello")); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+
+	d := waitDial(t, dials)
+	if d.key != "apps" {
+		t.Errorf("dialed key = %q, want %q", d.key, "apps")
+	}
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(d.conn, buf); err != nil {
+		t.Fatalf("read decrypted data: %v", err)
+	}
+	if string(buf) != "hello" {
+		t.Errorf("endpoint got %q, want %q", buf, "hello")
+	}
+}
@@ -0,0 +1,42 @@
+package health
+
+import (
+	"net/http"
+)
+
+// AcceptStatsResponse is the response for the /api/accept endpoint. Counters
+// are cumulative since the agent started.
+type AcceptStatsResponse struct {
+	LimitsEnabled  bool   `json:"limits_enabled"`
+	Pending        int    `json:"pending"`         // Accepted connections in the handshake now
+	Accepted       uint64 `json:"accepted"`        // Connections that completed the handshake
+	RateLimited    uint64 `json:"rate_limited"`    // Refused by the per-IP accept rate
+	PendingLimited uint64 `json:"pending_limited"` // Refused by the cap on pending handshakes
+	TimedOut       uint64 `json:"timed_out"`       // Closed at the handshake deadline
+	Failed         uint64 `json:"failed"`          // Handshake failed or was refused
+}
+
+// AcceptStatsProvider provides the counters of accepted peer connections.
+type AcceptStatsProvider interface {
+	// AcceptStats returns the listener accept counters.
+	AcceptStats() AcceptStatsResponse
+}
+
+// SetAcceptStatsProvider sets the accept counters provider.
+// This is called after the agent is initialized.
+func (s *Server) SetAcceptStatsProvider(provider AcceptStatsProvider) {
+	s.acceptStatsProvider = provider
+}
+
+// handleAcceptStats handles GET /api/accept for the listener accept counters.
+func (s *Server) handleAcceptStats(w http.ResponseWriter, r *http.Request) {
+	if !requireGET(w, r) {
+		return
+	}
+	if s.acceptStatsProvider == nil {
+		http.Error(w, "accept stats provider not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, s.acceptStatsProvider.AcceptStats())
+}
@@ -0,0 +1,133 @@
+package health
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/postalsys/muti-metroo/internal/protocol"
+	"github.com/postalsys/muti-metroo/internal/rbac"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// maxPeekBody bounds how much of a management request body is read to find
+// its action.
+const maxPeekBody = 1 << 20
+
+// APIToken is a bearer token that grants a role.
+type APIToken struct {
+	Name string
+	Hash string // bcrypt hash of the token
+	Role rbac.Role
+}
+
+// manageControlTypes maps management endpoints to the control type they
+// send. The same suffix serves the local agent ("/dns-cache/manage") and
+// remote agents ("/agents/{id}/dns-cache/manage").
+var manageControlTypes = map[string]uint8{
+	"routes/manage":            protocol.ControlTypeRouteManage,
+	"forward/manage":           protocol.ControlTypeForwardManage,
+	"forward/endpoint/manage":  protocol.ControlTypeForwardEndpointManage,
+	"display-name/manage":      protocol.ControlTypeDisplayNameManage,
+	"dns-cache/manage":         protocol.ControlTypeDNSCacheManage,
+	"exit-destinations/manage": protocol.ControlTypeExitDestManage,
+	"idle/manage":              protocol.ControlTypeIdleManage,
+	"scheduler/manage":         protocol.ControlTypeScheduleManage,
+	"update/manage":            protocol.ControlTypeUpdateManage,
+	"chaos/manage":             protocol.ControlTypeChaosManage,
+	"socks5-users/manage":      protocol.ControlTypeSOCKS5UsersManage,
+	"blocklist/manage":         protocol.ControlTypeBlocklistManage,
+	"crashes/manage":           protocol.ControlTypeCrashManage,
+	"debug-capture/manage":     protocol.ControlTypeDebugCapture,
+	"management-key/manage":    protocol.ControlTypeManagementKey,
+	"file/browse":              protocol.ControlTypeFileBrowse,
+}
+
+// requiredRole returns the role an HTTP request needs. Management endpoints
+// use the same rules as the control requests they send, so read-only
+// actions are open to viewers. Unknown endpoints require admin.
+func requiredRole(r *http.Request) rbac.Role {
+	path := strings.TrimPrefix(r.URL.Path, "/")
+
+	if rest, ok := strings.CutPrefix(path, "agents/"); ok {
+		_, sub, _ := strings.Cut(rest, "/")
+		switch {
+		case sub == "" || sub == "routes" || sub == "peers" || sub == "udp" || sub == "streams" || sub == "usage" || sub == "system" || sub == "routes/dampening" || sub == "routes/lookup":
+			return rbac.RoleViewer
+		case sub == "shell":
+			return rbac.RoleAdmin
+		case sub == "icmp" || strings.HasPrefix(sub, "file/upload") || strings.HasPrefix(sub, "file/download"):
+			return rbac.RoleOperator
+		}
+		path = sub
+	}
+
+	if controlType, ok := manageControlTypes[path]; ok {
+		return rbac.ControlRole(controlType, peekBody(r))
+	}
+
+	switch path {
+	case "agents", "events", "sleep/status", "api/topology", "api/topology/graph", "api/dashboard", "api/nodes", "api/routes", "api/routes/watch", "api/peers", "api/mesh-test", "api/streams", "api/udp", "api/icmp", "api/accept", "api/half-close", "api/exit-health", "api/port-limits", "api/routes/export", "usage", "system", "routes/dampening", "routes/lookup":
+		return rbac.RoleViewer
+	case "routes/advertise", "api/streams/kill":
+		return rbac.RoleOperator
+	default:
+		// sleep, wake, pprof
+		return rbac.RoleAdmin
+	}
+}
+
+// peekBody returns the start of the request body and leaves the body
+// readable for the handler.
+func peekBody(r *http.Request) []byte {
+	if r.Body == nil {
+		return nil
+	}
+	buf, _ := io.ReadAll(io.LimitReader(r.Body, maxPeekBody))
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(buf), r.Body), r.Body}
+	return buf
+}
+
+// validateRoleToken checks a token against the role tokens and returns the
+// role it grants. Validated tokens are cached by SHA-256 like the admin
+// token.
+func (s *Server) validateRoleToken(token string) (rbac.Role, bool) {
+	tokenSHA := sha256.Sum256([]byte(token))
+
+	s.tokenCacheMu.RLock()
+	role, ok := s.roleTokenCache[tokenSHA]
+	s.tokenCacheMu.RUnlock()
+	if ok {
+		return role, true
+	}
+
+	for _, t := range s.cfg.Tokens {
+		if bcrypt.CompareHashAndPassword([]byte(t.Hash), []byte(token)) == nil {
+			s.tokenCacheMu.Lock()
+			if s.roleTokenCache == nil {
+				s.roleTokenCache = make(map[[32]byte]rbac.Role)
+			}
+			s.roleTokenCache[tokenSHA] = t.Role
+			s.tokenCacheMu.Unlock()
+			return t.Role, true
+		}
+	}
+	return rbac.RoleNone, false
+}
+
+// authenticate returns the role granted by a bearer token. The token_hash
+// token grants admin.
+func (s *Server) authenticate(token string) (rbac.Role, bool) {
+	if token == "" {
+		return rbac.RoleNone, false
+	}
+	if s.cfg.TokenHash != "" && s.validateToken(token) {
+		return rbac.RoleAdmin, true
+	}
+	return s.validateRoleToken(token)
+}
@@ -0,0 +1,76 @@
+package health
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/postalsys/muti-metroo/internal/identity"
+	"github.com/postalsys/muti-metroo/internal/protocol"
+	"github.com/postalsys/muti-metroo/internal/socks5"
+)
+
+// BlocklistManageRequest is a SOCKS5 destination blocklist operation.
+type BlocklistManageRequest struct {
+	// Action is status, check or refresh.
+	Action string `json:"action"`
+
+	// Destination is the domain, IP address or host:port to check.
+	Destination string `json:"destination,omitempty"`
+}
+
+// BlocklistManageResult contains the response for a blocklist operation.
+type BlocklistManageResult struct {
+	Status    string                  `json:"status"`
+	Message   string                  `json:"message,omitempty"`
+	Blocklist *socks5.BlocklistStatus `json:"blocklist,omitempty"`
+
+	// Blocked and Match report the result of a check.
+	Blocked *bool              `json:"blocked,omitempty"`
+	Match   *socks5.BlockMatch `json:"match,omitempty"`
+}
+
+// BlocklistManageProvider provides SOCKS5 destination blocklist status,
+// checks and refreshes.
+type BlocklistManageProvider interface {
+	ManageBlocklist(req BlocklistManageRequest) (*BlocklistManageResult, error)
+}
+
+// SetBlocklistManageProvider sets the blocklist provider.
+func (s *Server) SetBlocklistManageProvider(provider BlocklistManageProvider) {
+	s.blocklistManageProvider = provider
+}
+
+// handleBlocklistManage handles POST /blocklist/manage for the SOCKS5
+// destination blocklist.
+func (s *Server) handleBlocklistManage(w http.ResponseWriter, r *http.Request) {
+	if !requirePOST(w, r) {
+		return
+	}
+	if s.blocklistManageProvider == nil {
+		http.Error(w, "blocklist management not configured", http.StatusServiceUnavailable)
+		return
+	}
+	if s.shouldRestrictTopology() {
+		http.Error(w, "blocklist management restricted: management key decryption unavailable", http.StatusForbidden)
+		return
+	}
+
+	var req BlocklistManageRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request: " + err.Error()})
+		return
+	}
+
+	result, err := s.blocklistManageProvider.ManageBlocklist(req)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+// handleRemoteBlocklistManage forwards blocklist requests to a remote agent.
+func (s *Server) handleRemoteBlocklistManage(w http.ResponseWriter, r *http.Request, targetID identity.AgentID) {
+	s.forwardRemoteControl(w, r, targetID, protocol.ControlTypeBlocklistManage, "blocklist management")
+}
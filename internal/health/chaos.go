@@ -0,0 +1,86 @@
+package health
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/postalsys/muti-metroo/internal/identity"
+	"github.com/postalsys/muti-metroo/internal/protocol"
+)
+
+// ChaosManageRequest is a peer link fault injection operation.
+type ChaosManageRequest struct {
+	// Action is status, set, partition or heal.
+	Action string `json:"action"`
+
+	// Latency, Jitter and Loss replace the faults applied to received
+	// frames (set only). Omitted fields are cleared.
+	Latency string  `json:"latency,omitempty"`
+	Jitter  string  `json:"jitter,omitempty"`
+	Loss    float64 `json:"loss,omitempty"`
+
+	// Peer is the agent ID, or a unique prefix of a connected peer's ID,
+	// to partition or heal. Heal without a peer heals all partitions.
+	Peer string `json:"peer,omitempty"`
+}
+
+// ChaosStats counts frames affected by fault injection.
+type ChaosStats struct {
+	Delayed     uint64 `json:"delayed"`
+	Dropped     uint64 `json:"dropped"`
+	Partitioned uint64 `json:"partitioned"`
+}
+
+// ChaosManageResult contains the response for a fault injection operation.
+// Every action returns the resulting state.
+type ChaosManageResult struct {
+	Status     string     `json:"status"`
+	Message    string     `json:"message,omitempty"`
+	Latency    string     `json:"latency"`
+	Jitter     string     `json:"jitter"`
+	Loss       float64    `json:"loss"`
+	Partitions []string   `json:"partitions"`
+	Stats      ChaosStats `json:"stats"`
+}
+
+// ChaosManageProvider injects faults into peer links.
+type ChaosManageProvider interface {
+	ManageChaos(req ChaosManageRequest) (*ChaosManageResult, error)
+}
+
+// SetChaosManageProvider sets the fault injection provider.
+func (s *Server) SetChaosManageProvider(provider ChaosManageProvider) {
+	s.chaosManageProvider = provider
+}
+
+// handleChaosManage handles POST /chaos/manage for peer link fault
+// injection.
+func (s *Server) handleChaosManage(w http.ResponseWriter, r *http.Request) {
+	if !requirePOST(w, r) {
+		return
+	}
+	if s.chaosManageProvider == nil {
+		http.Error(w, "chaos management not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	var req ChaosManageRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request: " + err.Error()})
+		return
+	}
+
+	result, err := s.chaosManageProvider.ManageChaos(req)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+// handleRemoteChaosManage forwards fault injection requests to a remote
+// agent.
+func (s *Server) handleRemoteChaosManage(w http.ResponseWriter, r *http.Request, targetID identity.AgentID) {
+	s.forwardRemoteControl(w, r, targetID, protocol.ControlTypeChaosManage, "chaos management")
+}
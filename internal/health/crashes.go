@@ -0,0 +1,89 @@
+package health
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/postalsys/muti-metroo/internal/identity"
+	"github.com/postalsys/muti-metroo/internal/protocol"
+	"github.com/postalsys/muti-metroo/internal/recovery"
+)
+
+// CrashManageRequest is a crash report operation.
+type CrashManageRequest struct {
+	// Action is list, get or clear.
+	Action string `json:"action"`
+
+	// ID selects the report to get, or the report to clear. Clear without
+	// an ID removes all reports.
+	ID string `json:"id,omitempty"`
+
+	// Offset is the position in the compressed report where a get chunk
+	// starts.
+	Offset int64 `json:"offset,omitempty"`
+}
+
+// CrashManageResult contains the response for a crash report operation.
+type CrashManageResult struct {
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+
+	// Reports is the list result, newest first. Truncated is set when
+	// older reports were left out to fit the response.
+	Reports   []recovery.Summary `json:"reports,omitempty"`
+	Truncated bool               `json:"truncated,omitempty"`
+
+	// A get returns the gzip-compressed report JSON in chunks: Data holds
+	// the bytes from Offset, Size is the compressed length of the report.
+	ID     string `json:"id,omitempty"`
+	Offset int64  `json:"offset,omitempty"`
+	Size   int64  `json:"size,omitempty"`
+	Data   []byte `json:"data,omitempty"`
+
+	// Deleted is the number of reports removed by clear.
+	Deleted int `json:"deleted,omitempty"`
+}
+
+// CrashManageProvider lists, returns and removes crash reports.
+type CrashManageProvider interface {
+	ManageCrashReports(req CrashManageRequest) (*CrashManageResult, error)
+}
+
+// SetCrashManageProvider sets the crash report provider.
+func (s *Server) SetCrashManageProvider(provider CrashManageProvider) {
+	s.crashManageProvider = provider
+}
+
+// handleCrashManage handles POST /crashes/manage for crash reports.
+func (s *Server) handleCrashManage(w http.ResponseWriter, r *http.Request) {
+	if !requirePOST(w, r) {
+		return
+	}
+	if s.crashManageProvider == nil {
+		http.Error(w, "crash report management not configured", http.StatusServiceUnavailable)
+		return
+	}
+	if s.shouldRestrictTopology() {
+		http.Error(w, "crash report management restricted: management key decryption unavailable", http.StatusForbidden)
+		return
+	}
+
+	var req CrashManageRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request: " + err.Error()})
+		return
+	}
+
+	result, err := s.crashManageProvider.ManageCrashReports(req)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+// handleRemoteCrashManage forwards crash report requests to a remote agent.
+func (s *Server) handleRemoteCrashManage(w http.ResponseWriter, r *http.Request, targetID identity.AgentID) {
+	s.forwardRemoteControl(w, r, targetID, protocol.ControlTypeCrashManage, "crash report management")
+}
@@ -0,0 +1,69 @@
+package health
+
+import (
+	"net/http"
+	"time"
+)
+
+// RouteDampeningResponse is the response for the /routes/dampening endpoint
+// and the /agents/{id}/routes/dampening remote query.
+type RouteDampeningResponse struct {
+	DampeningEnabled bool `json:"dampening_enabled"`
+	RateLimitEnabled bool `json:"rate_limit_enabled"`
+
+	// Origins lists origin agents with a flap penalty, suppressed origins
+	// first.
+	Origins []DampenedOriginInfo `json:"origins"`
+
+	// RateLimitedPeers lists peers whose route updates were dropped by the
+	// per-peer rate limit, most drops first.
+	RateLimitedPeers []RateLimitedPeerInfo `json:"rate_limited_peers"`
+}
+
+// DampenedOriginInfo is the dampening state of an origin agent.
+type DampenedOriginInfo struct {
+	OriginID        string     `json:"origin_id"`
+	OriginName      string     `json:"origin_name,omitempty"`
+	Penalty         float64    `json:"penalty"`
+	Flaps           int        `json:"flaps"`
+	Suppressed      bool       `json:"suppressed"`
+	SuppressedSince *time.Time `json:"suppressed_since,omitempty"`
+	ReuseAt         *time.Time `json:"reuse_at,omitempty"` // When the origin is released if it stops flapping
+	Routes          []string   `json:"routes,omitempty"`   // Routes of the newest advertisement
+}
+
+// RateLimitedPeerInfo is the rate limit state of a peer.
+type RateLimitedPeerInfo struct {
+	PeerID      string    `json:"peer_id"`
+	PeerName    string    `json:"peer_name,omitempty"`
+	Dropped     uint64    `json:"dropped"`
+	LastDropped time.Time `json:"last_dropped"`
+}
+
+// RouteDampeningProvider provides route flap dampening and rate limit state.
+type RouteDampeningProvider interface {
+	RouteDampening() RouteDampeningResponse
+}
+
+// SetRouteDampeningProvider sets the route dampening provider.
+func (s *Server) SetRouteDampeningProvider(provider RouteDampeningProvider) {
+	s.routeDampeningProvider = provider
+}
+
+// handleRouteDampening handles GET /routes/dampening for suppressed route
+// origins and rate limited peers.
+func (s *Server) handleRouteDampening(w http.ResponseWriter, r *http.Request) {
+	if !requireGET(w, r) {
+		return
+	}
+	if s.routeDampeningProvider == nil {
+		http.Error(w, "route dampening provider not configured", http.StatusServiceUnavailable)
+		return
+	}
+	if s.shouldRestrictTopology() {
+		http.Error(w, "route dampening restricted: management key decryption unavailable", http.StatusForbidden)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, s.routeDampeningProvider.RouteDampening())
+}
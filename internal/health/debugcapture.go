@@ -0,0 +1,80 @@
+package health
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/postalsys/muti-metroo/internal/identity"
+	"github.com/postalsys/muti-metroo/internal/protocol"
+)
+
+// DebugCaptureRequest is a transport debug capture operation.
+type DebugCaptureRequest struct {
+	// Action is status, start or stop.
+	Action string `json:"action"`
+
+	// Duration of the capture (start only), such as "10m".
+	Duration string `json:"duration,omitempty"`
+
+	// KeyLog and Qlog select the outputs (start only). When both are
+	// false, both are captured.
+	KeyLog bool `json:"key_log,omitempty"`
+	Qlog   bool `json:"qlog,omitempty"`
+}
+
+// DebugCaptureResult contains the response for a debug capture operation.
+// Every action returns the resulting state.
+type DebugCaptureResult struct {
+	Status     string `json:"status"`
+	Message    string `json:"message,omitempty"`
+	Active     bool   `json:"active"`
+	KeyLogFile string `json:"key_log_file,omitempty"`
+	QlogDir    string `json:"qlog_dir,omitempty"`
+	Started    string `json:"started,omitempty"` // RFC 3339
+	Until      string `json:"until,omitempty"`   // RFC 3339, empty when running until stopped
+	KeyLogs    uint64 `json:"key_log_lines"`
+	QlogFiles  uint64 `json:"qlog_files"`
+}
+
+// DebugCaptureProvider records TLS key logs and QUIC qlog traces of peer
+// connections.
+type DebugCaptureProvider interface {
+	ManageDebugCapture(req DebugCaptureRequest) (*DebugCaptureResult, error)
+}
+
+// SetDebugCaptureProvider sets the debug capture provider.
+func (s *Server) SetDebugCaptureProvider(provider DebugCaptureProvider) {
+	s.debugCaptureProvider = provider
+}
+
+// handleDebugCapture handles POST /debug-capture/manage for the transport
+// debug capture.
+func (s *Server) handleDebugCapture(w http.ResponseWriter, r *http.Request) {
+	if !requirePOST(w, r) {
+		return
+	}
+	if s.debugCaptureProvider == nil {
+		http.Error(w, "debug capture not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	var req DebugCaptureRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request: " + err.Error()})
+		return
+	}
+
+	result, err := s.debugCaptureProvider.ManageDebugCapture(req)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+// handleRemoteDebugCapture forwards debug capture requests to a remote
+// agent.
+func (s *Server) handleRemoteDebugCapture(w http.ResponseWriter, r *http.Request, targetID identity.AgentID) {
+	s.forwardRemoteControl(w, r, targetID, protocol.ControlTypeDebugCapture, "debug capture")
+}
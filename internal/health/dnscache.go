@@ -0,0 +1,77 @@
+package health
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/postalsys/muti-metroo/internal/identity"
+	"github.com/postalsys/muti-metroo/internal/protocol"
+)
+
+// DNSCacheStats describes the exit resolver cache.
+type DNSCacheStats struct {
+	Enabled         bool   `json:"enabled"`
+	Entries         int    `json:"entries"`
+	NegativeEntries int    `json:"negative_entries"`
+	Hits            uint64 `json:"hits"`
+	NegativeHits    uint64 `json:"negative_hits"`
+	Misses          uint64 `json:"misses"`
+	Evictions       uint64 `json:"evictions"`
+}
+
+// DNSCacheManageResult contains the response for a DNS cache operation.
+type DNSCacheManageResult struct {
+	Status  string         `json:"status"`
+	Message string         `json:"message,omitempty"`
+	Flushed int            `json:"flushed,omitempty"`
+	Stats   *DNSCacheStats `json:"stats,omitempty"`
+}
+
+// DNSCacheManageProvider provides exit DNS cache inspection and flushing.
+type DNSCacheManageProvider interface {
+	// ManageDNSCache handles stats/flush operations. For flush, an empty
+	// domain clears the whole cache.
+	ManageDNSCache(action, domain string) (*DNSCacheManageResult, error)
+}
+
+// SetDNSCacheManageProvider sets the DNS cache management provider.
+func (s *Server) SetDNSCacheManageProvider(provider DNSCacheManageProvider) {
+	s.dnsCacheManageProvider = provider
+}
+
+// handleDNSCacheManage handles POST /dns-cache/manage for cache stats and flushes.
+func (s *Server) handleDNSCacheManage(w http.ResponseWriter, r *http.Request) {
+	if !requirePOST(w, r) {
+		return
+	}
+	if s.dnsCacheManageProvider == nil {
+		http.Error(w, "DNS cache management not configured", http.StatusServiceUnavailable)
+		return
+	}
+	if s.shouldRestrictTopology() {
+		http.Error(w, "DNS cache management restricted: management key decryption unavailable", http.StatusForbidden)
+		return
+	}
+
+	var req struct {
+		Action string `json:"action"`
+		Domain string `json:"domain"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request: " + err.Error()})
+		return
+	}
+
+	result, err := s.dnsCacheManageProvider.ManageDNSCache(req.Action, req.Domain)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+// handleRemoteDNSCacheManage forwards DNS cache management requests to a remote agent.
+func (s *Server) handleRemoteDNSCacheManage(w http.ResponseWriter, r *http.Request, targetID identity.AgentID) {
+	s.forwardRemoteControl(w, r, targetID, protocol.ControlTypeDNSCacheManage, "DNS cache management")
+}
@@ -0,0 +1,321 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"nhooyr.io/websocket"
+)
+
+// Event types pushed on /events.
+const (
+	EventPeerUp        = "peer_up"
+	EventPeerDown      = "peer_down"
+	EventRouteAdd      = "route_add"
+	EventRouteWithdraw = "route_withdraw"
+	EventRouteDiff     = "route_diff"  // The route selected for a network changed
+	EventConvergence   = "convergence" // Route selection settled after peer events
+	EventStreamOpen    = "stream_open"
+	EventStreamClose   = "stream_close"
+	EventFileTransfer  = "file_transfer"
+	EventDropped       = "dropped" // Sent to a subscriber that fell behind
+)
+
+const (
+	// eventBufferSize is the per-subscriber queue. A subscriber that falls
+	// further behind loses events and is told how many with EventDropped.
+	eventBufferSize = 256
+
+	// eventPingInterval keeps idle event connections alive through proxies.
+	eventPingInterval = 30 * time.Second
+
+	// fileProgressInterval limits file_transfer progress events per transfer.
+	fileProgressInterval = 500 * time.Millisecond
+)
+
+// Event is one message on the /events WebSocket.
+type Event struct {
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+	Data any       `json:"data,omitempty"`
+}
+
+// PeerEvent is the data of peer_up and peer_down events.
+type PeerEvent struct {
+	ID          string `json:"id"`
+	ShortID     string `json:"short_id"`
+	DisplayName string `json:"display_name,omitempty"`
+	Transport   string `json:"transport,omitempty"`
+	IsDialer    bool   `json:"is_dialer"`
+	Error       string `json:"error,omitempty"` // Disconnect reason (peer_down)
+}
+
+// RouteEvent is the data of route_add and route_withdraw events.
+type RouteEvent struct {
+	Network  string `json:"network"`
+	Origin   string `json:"origin"`             // Short ID of the advertising agent
+	NextHop  string `json:"next_hop,omitempty"` // Short ID; empty for local routes and withdraws from the origin
+	Metric   uint16 `json:"metric,omitempty"`
+	HopCount int    `json:"hop_count,omitempty"`
+}
+
+// StreamEvent is the data of stream_open and stream_close events.
+type StreamEvent struct {
+	StreamInfo
+	Error string `json:"error,omitempty"` // Reset reason (stream_close)
+}
+
+// File transfer event states.
+const (
+	FileTransferStateProgress = "progress"
+	FileTransferStateDone     = "done"
+	FileTransferStateFailed   = "failed"
+)
+
+// FileTransferEvent is the data of file_transfer events.
+type FileTransferEvent struct {
+	ID        uint64 `json:"id"`        // Transfer ID, unique per agent run
+	Direction string `json:"direction"` // "upload" or "download"
+	Agent     string `json:"agent"`     // Short ID of the remote agent
+	Path      string `json:"path"`      // Path on the remote agent
+	Bytes     int64  `json:"bytes"`
+	Total     int64  `json:"total"` // -1 when unknown
+	State     string `json:"state"`
+	Error     string `json:"error,omitempty"`
+}
+
+// eventSubscriber is one /events connection.
+type eventSubscriber struct {
+	ch      chan Event
+	types   map[string]bool // nil means all types
+	dropped atomic.Uint64
+	done    chan struct{}
+}
+
+// eventHub fans events out to /events subscribers. The zero value is ready
+// to use.
+type eventHub struct {
+	mu     sync.RWMutex
+	subs   map[*eventSubscriber]struct{}
+	closed bool
+
+	transferSeq atomic.Uint64
+}
+
+// subscribe registers a subscriber for the given types (all when empty).
+// Returns nil after the hub has been closed.
+func (h *eventHub) subscribe(types []string) *eventSubscriber {
+	sub := &eventSubscriber{
+		ch:   make(chan Event, eventBufferSize),
+		done: make(chan struct{}),
+	}
+	if len(types) > 0 {
+		sub.types = make(map[string]bool, len(types))
+		for _, t := range types {
+			sub.types[t] = true
+		}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return nil
+	}
+	if h.subs == nil {
+		h.subs = make(map[*eventSubscriber]struct{})
+	}
+	h.subs[sub] = struct{}{}
+	return sub
+}
+
+// unsubscribe removes a subscriber.
+func (h *eventHub) unsubscribe(sub *eventSubscriber) {
+	h.mu.Lock()
+	delete(h.subs, sub)
+	h.mu.Unlock()
+}
+
+// active reports whether anyone is subscribed.
+func (h *eventHub) active() bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.subs) > 0
+}
+
+// publish queues an event for every interested subscriber without
+// blocking. Subscribers with a full queue count the event as dropped.
+func (h *eventHub) publish(ev Event) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for sub := range h.subs {
+		if sub.types != nil && !sub.types[ev.Type] {
+			continue
+		}
+		select {
+		case sub.ch <- ev:
+		default:
+			sub.dropped.Add(1)
+		}
+	}
+}
+
+// close ends all subscriptions. Hijacked WebSocket connections outlive
+// http.Server.Shutdown, so the handlers are told to return.
+func (h *eventHub) close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return
+	}
+	h.closed = true
+	for sub := range h.subs {
+		close(sub.done)
+		delete(h.subs, sub)
+	}
+}
+
+// HasEventSubscribers reports whether any /events client is connected.
+// Publishers use it to skip building events nobody receives.
+func (s *Server) HasEventSubscribers() bool {
+	return s.events.active()
+}
+
+// PublishEvent pushes an event to /events subscribers. It never blocks.
+func (s *Server) PublishEvent(eventType string, data any) {
+	s.events.publish(Event{Type: eventType, Time: time.Now(), Data: data})
+}
+
+// fileTransferEvents returns a progress callback that publishes throttled
+// file_transfer events for one transfer, and a function that publishes the
+// final event with the last reported progress.
+func (s *Server) fileTransferEvents(direction, agent, path string) (FileTransferProgress, func(err error)) {
+	ev := FileTransferEvent{
+		ID:        s.events.transferSeq.Add(1),
+		Direction: direction,
+		Agent:     agent,
+		Path:      path,
+		Total:     -1,
+	}
+
+	var mu sync.Mutex
+	var last time.Time
+	progress := func(bytes, total int64) {
+		mu.Lock()
+		defer mu.Unlock()
+		ev.Bytes, ev.Total = bytes, total
+		if time.Since(last) < fileProgressInterval || !s.events.active() {
+			return
+		}
+		last = time.Now()
+		e := ev
+		e.State = FileTransferStateProgress
+		s.PublishEvent(EventFileTransfer, e)
+	}
+	finish := func(err error) {
+		mu.Lock()
+		e := ev
+		mu.Unlock()
+		e.State = FileTransferStateDone
+		if err != nil {
+			e.State, e.Error = FileTransferStateFailed, err.Error()
+		}
+		s.PublishEvent(EventFileTransfer, e)
+	}
+	return progress, finish
+}
+
+// progressReader reports the bytes read through it.
+type progressReader struct {
+	io.Reader
+	read     int64
+	total    int64
+	progress FileTransferProgress
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.Reader.Read(b)
+	p.read += int64(n)
+	p.progress(p.read, p.total)
+	return n, err
+}
+
+// handleEvents handles GET /events, a WebSocket that pushes mesh events as
+// JSON text messages. The optional ?types= query parameter is a comma
+// separated list of event types to receive.
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	var types []string
+	if v := r.URL.Query().Get("types"); v != "" {
+		for _, t := range strings.Split(v, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				types = append(types, t)
+			}
+		}
+	}
+
+	// Disable write deadline for long-lived WebSocket connections
+	rc := http.NewResponseController(w)
+	_ = rc.SetWriteDeadline(time.Time{})
+
+	conn, err := websocket.Accept(w, r, &websocket.AcceptOptions{
+		Subprotocols: []string{"muti-events"},
+	})
+	if err != nil {
+		http.Error(w, "failed to accept websocket: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer conn.Close(websocket.StatusNormalClosure, "")
+
+	sub := s.events.subscribe(types)
+	if sub == nil {
+		conn.Close(websocket.StatusGoingAway, "server stopping")
+		return
+	}
+	defer s.events.unsubscribe(sub)
+
+	// Clients only send close frames; CloseRead handles them and cancels ctx
+	ctx := conn.CloseRead(r.Context())
+
+	ping := time.NewTicker(eventPingInterval)
+	defer ping.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-sub.done:
+			conn.Close(websocket.StatusGoingAway, "server stopping")
+			return
+		case <-ping.C:
+			pingCtx, cancel := context.WithTimeout(ctx, eventPingInterval)
+			err := conn.Ping(pingCtx)
+			cancel()
+			if err != nil {
+				return
+			}
+		case ev := <-sub.ch:
+			if n := sub.dropped.Swap(0); n > 0 {
+				if !writeEvent(ctx, conn, Event{Type: EventDropped, Time: time.Now(), Data: map[string]uint64{"count": n}}) {
+					return
+				}
+			}
+			if !writeEvent(ctx, conn, ev) {
+				return
+			}
+		}
+	}
+}
+
+// writeEvent sends one event as a JSON text message.
+func writeEvent(ctx context.Context, conn *websocket.Conn, ev Event) bool {
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return true
+	}
+	return conn.Write(ctx, websocket.MessageText, data) == nil
+}
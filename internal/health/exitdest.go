@@ -0,0 +1,85 @@
+package health
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/postalsys/muti-metroo/internal/identity"
+	"github.com/postalsys/muti-metroo/internal/protocol"
+)
+
+// ExitDestStat describes traffic to one exit destination within the
+// accounting window. bytes_out is sent to the destination, bytes_in is
+// received from it.
+type ExitDestStat struct {
+	Destination  string     `json:"destination"`
+	Connections  uint64     `json:"connections"`
+	BytesOut     uint64     `json:"bytes_out"`
+	BytesIn      uint64     `json:"bytes_in"`
+	Active       int        `json:"active"`
+	Blocked      bool       `json:"blocked"`
+	BlockedUntil *time.Time `json:"blocked_until,omitempty"`
+	LastSeen     time.Time  `json:"last_seen"`
+}
+
+// ExitDestManageResult contains the response for an exit destination operation.
+type ExitDestManageResult struct {
+	Status       string         `json:"status"`
+	Message      string         `json:"message,omitempty"`
+	Window       string         `json:"window,omitempty"`
+	Destinations []ExitDestStat `json:"destinations,omitempty"`
+}
+
+// ExitDestManageProvider provides exit per-destination statistics and unblocking.
+type ExitDestManageProvider interface {
+	// ManageExitDestinations handles top/unblock operations. For top, limit
+	// caps the number of destinations (0 = default) and sortBy is "bytes"
+	// or "connections".
+	ManageExitDestinations(action, sortBy, destination string, limit int) (*ExitDestManageResult, error)
+}
+
+// SetExitDestManageProvider sets the exit destination management provider.
+func (s *Server) SetExitDestManageProvider(provider ExitDestManageProvider) {
+	s.exitDestManageProvider = provider
+}
+
+// handleExitDestManage handles POST /exit-destinations/manage for
+// per-destination statistics and unblocking.
+func (s *Server) handleExitDestManage(w http.ResponseWriter, r *http.Request) {
+	if !requirePOST(w, r) {
+		return
+	}
+	if s.exitDestManageProvider == nil {
+		http.Error(w, "exit destination management not configured", http.StatusServiceUnavailable)
+		return
+	}
+	if s.shouldRestrictTopology() {
+		http.Error(w, "exit destination management restricted: management key decryption unavailable", http.StatusForbidden)
+		return
+	}
+
+	var req struct {
+		Action      string `json:"action"`
+		Sort        string `json:"sort"`
+		Destination string `json:"destination"`
+		Limit       int    `json:"limit"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request: " + err.Error()})
+		return
+	}
+
+	result, err := s.exitDestManageProvider.ManageExitDestinations(req.Action, req.Sort, req.Destination, req.Limit)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+// handleRemoteExitDestManage forwards exit destination requests to a remote agent.
+func (s *Server) handleRemoteExitDestManage(w http.ResponseWriter, r *http.Request, targetID identity.AgentID) {
+	s.forwardRemoteControl(w, r, targetID, protocol.ControlTypeExitDestManage, "exit destination management")
+}
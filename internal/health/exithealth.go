@@ -0,0 +1,64 @@
+package health
+
+import (
+	"net/http"
+)
+
+// ExitHealthResponse is the response for the /api/exit-health endpoint.
+// Counters are cumulative since the agent started.
+type ExitHealthResponse struct {
+	Enabled              bool                 `json:"enabled"`               // Health check running on this agent
+	Healthy              bool                 `json:"healthy"`               // Any target reachable within the thresholds
+	RoutesWithdrawn      bool                 `json:"routes_withdrawn"`      // Local CIDR and domain routes withdrawn
+	Since                string               `json:"since,omitempty"`       // Start of the current state (RFC 3339)
+	LastCheck            string               `json:"last_check,omitempty"`  // Time of the last check (RFC 3339)
+	ConsecutiveFailures  int                  `json:"consecutive_failures"`  // Failed checks in a row
+	ConsecutiveSuccesses int                  `json:"consecutive_successes"` // Passed checks in a row
+	Checks               uint64               `json:"checks"`                // Checks run
+	Failed               uint64               `json:"failed"`                // Checks where no target connected
+	Withdrawals          uint64               `json:"withdrawals"`           // Times the routes were withdrawn
+	Targets              []ExitHealthTarget   `json:"targets,omitempty"`     // Results of the last check
+	Thresholds           ExitHealthThresholds `json:"thresholds"`            // Configured thresholds
+}
+
+// ExitHealthTarget is the last probe result of one health check target.
+type ExitHealthTarget struct {
+	Target    string  `json:"target"`
+	OK        bool    `json:"ok"`
+	LatencyMs float64 `json:"latency_ms,omitempty"` // Connect time of a passed probe
+	Error     string  `json:"error,omitempty"`
+}
+
+// ExitHealthThresholds are the configured health check settings.
+type ExitHealthThresholds struct {
+	Interval         string `json:"interval"`
+	Timeout          string `json:"timeout"`
+	FailureThreshold int    `json:"failure_threshold"`
+	SuccessThreshold int    `json:"success_threshold"`
+}
+
+// ExitHealthProvider provides the state of the exit self-health check.
+type ExitHealthProvider interface {
+	// ExitHealth returns the state of the exit self-health check.
+	ExitHealth() ExitHealthResponse
+}
+
+// SetExitHealthProvider sets the exit health check provider.
+// This is called after the agent is initialized.
+func (s *Server) SetExitHealthProvider(provider ExitHealthProvider) {
+	s.exitHealthProvider = provider
+}
+
+// handleExitHealth handles GET /api/exit-health for the exit self-health
+// check.
+func (s *Server) handleExitHealth(w http.ResponseWriter, r *http.Request) {
+	if !requireGET(w, r) {
+		return
+	}
+	if s.exitHealthProvider == nil {
+		http.Error(w, "exit health provider not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, s.exitHealthProvider.ExitHealth())
+}
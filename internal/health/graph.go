@@ -0,0 +1,214 @@
+package health
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/postalsys/muti-metroo/internal/identity"
+)
+
+// GraphNode is an agent in the /api/topology/graph response.
+type GraphNode struct {
+	TopologyAgentInfo
+	PeerCount     int    `json:"peer_count"`       // Links reported for this agent
+	TxBytesPerSec uint64 `json:"tx_bytes_per_sec"` // Sum of the agent's outgoing link rates
+	RxBytesPerSec uint64 `json:"rx_bytes_per_sec"` // Sum of the agent's incoming link rates
+	RoutesURL     string `json:"routes_url"`       // Drill-down: route table of the agent
+	StreamsURL    string `json:"streams_url"`      // Drill-down: stream table of the agent
+}
+
+// GraphLink is a peer connection, merged from the peer lists of both ends.
+// Each link appears once; Source is the end with the lower agent ID.
+type GraphLink struct {
+	Source            string   `json:"source"` // Short ID
+	Target            string   `json:"target"` // Short ID
+	Transport         string   `json:"transport,omitempty"`
+	RTTMs             int64    `json:"rtt_ms"` // Highest RTT reported by either end
+	Unresponsive      bool     `json:"unresponsive,omitempty"`
+	SourceToTargetBps uint64   `json:"source_to_target_bytes_per_sec"`
+	TargetToSourceBps uint64   `json:"target_to_source_bytes_per_sec"`
+	ReportedBy        []string `json:"reported_by"` // Short IDs of the ends that listed the link
+}
+
+// GraphResponse is the response for the /api/topology/graph endpoint.
+type GraphResponse struct {
+	LocalAgent string      `json:"local_agent"` // Short ID
+	Nodes      []GraphNode `json:"nodes"`
+	Links      []GraphLink `json:"links"`
+}
+
+// graphLinkBuilder accumulates the reports of one link. Each direction's
+// rate is taken from the sender's measurement when it reported one, and from
+// the receiver's otherwise.
+type graphLinkBuilder struct {
+	link                   GraphLink
+	s2tSender, s2tReceiver *uint64
+	t2sSender, t2sReceiver *uint64
+}
+
+// peerReport is one entry of an agent's peer list.
+type peerReport struct {
+	from, to  identity.AgentID
+	transport string
+	rttMs     int64
+	tx, rx    uint64
+}
+
+// handleTopologyGraph handles GET /api/topology/graph for the live mesh
+// graph. Links are merged from the local peer list and the peer lists in
+// every agent's node info, with RTT and byte rates for overlays.
+func (s *Server) handleTopologyGraph(w http.ResponseWriter, r *http.Request) {
+	if !requireGET(w, r) {
+		return
+	}
+	if s.remoteProvider == nil {
+		http.Error(w, "provider not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, s.buildGraph())
+}
+
+// buildGraph merges the topology agents with link reports from all agents.
+func (s *Server) buildGraph() GraphResponse {
+	topology := s.buildTopology()
+	localID := s.remoteProvider.ID()
+
+	var reports []peerReport
+	if !s.shouldRestrictTopology() {
+		// The local peer list is live; node info is as of the last advertisement
+		for _, peer := range s.remoteProvider.GetPeerDetails() {
+			reports = append(reports, peerReport{
+				from:      localID,
+				to:        peer.ID,
+				transport: peer.Transport,
+				rttMs:     peer.RTT.Milliseconds(),
+				tx:        peer.TxBytesPerSec,
+				rx:        peer.RxBytesPerSec,
+			})
+		}
+		for agentID, info := range s.remoteProvider.GetAllNodeInfo() {
+			if agentID == localID || info == nil {
+				continue
+			}
+			for _, peer := range info.Peers {
+				reports = append(reports, peerReport{
+					from:      agentID,
+					to:        identity.AgentID(peer.PeerID),
+					transport: peer.Transport,
+					rttMs:     peer.RTTMs,
+					tx:        peer.TxBytesPerSec,
+					rx:        peer.RxBytesPerSec,
+				})
+			}
+		}
+	}
+
+	links := mergeLinkReports(reports)
+
+	nodes := make([]GraphNode, 0, len(topology.Agents))
+	index := make(map[string]int, len(topology.Agents))
+	for _, agent := range topology.Agents {
+		node := GraphNode{TopologyAgentInfo: agent}
+		if agent.IsLocal {
+			node.RoutesURL = "/api/dashboard"
+			node.StreamsURL = "/api/streams"
+		} else {
+			node.RoutesURL = "/agents/" + agent.ID + "/routes"
+			node.StreamsURL = "/agents/" + agent.ID + "/streams"
+		}
+		index[agent.ShortID] = len(nodes)
+		nodes = append(nodes, node)
+	}
+	for _, link := range links {
+		if i, ok := index[link.Source]; ok {
+			nodes[i].PeerCount++
+			nodes[i].TxBytesPerSec += link.SourceToTargetBps
+			nodes[i].RxBytesPerSec += link.TargetToSourceBps
+		}
+		if i, ok := index[link.Target]; ok {
+			nodes[i].PeerCount++
+			nodes[i].TxBytesPerSec += link.TargetToSourceBps
+			nodes[i].RxBytesPerSec += link.SourceToTargetBps
+		}
+	}
+
+	sort.Slice(nodes, func(i, j int) bool {
+		if nodes[i].IsLocal != nodes[j].IsLocal {
+			return nodes[i].IsLocal
+		}
+		return nodes[i].ShortID < nodes[j].ShortID
+	})
+
+	return GraphResponse{
+		LocalAgent: localID.ShortString(),
+		Nodes:      nodes,
+		Links:      links,
+	}
+}
+
+// mergeLinkReports combines peer list entries from both ends of each link
+// into one undirected link, sorted by source and target.
+func mergeLinkReports(reports []peerReport) []GraphLink {
+	builders := make(map[[2]identity.AgentID]*graphLinkBuilder)
+	for _, rep := range reports {
+		if rep.from == rep.to {
+			continue
+		}
+		source, target := rep.from, rep.to
+		if target.String() < source.String() {
+			source, target = target, source
+		}
+		key := [2]identity.AgentID{source, target}
+		b, ok := builders[key]
+		if !ok {
+			b = &graphLinkBuilder{link: GraphLink{
+				Source: source.ShortString(),
+				Target: target.ShortString(),
+			}}
+			builders[key] = b
+		}
+
+		if b.link.Transport == "" {
+			b.link.Transport = rep.transport
+		}
+		b.link.RTTMs = max(b.link.RTTMs, rep.rttMs)
+		b.link.ReportedBy = append(b.link.ReportedBy, rep.from.ShortString())
+
+		tx, rx := rep.tx, rep.rx
+		if rep.from == source {
+			b.s2tSender, b.t2sReceiver = &tx, &rx
+		} else {
+			b.t2sSender, b.s2tReceiver = &tx, &rx
+		}
+	}
+
+	links := make([]GraphLink, 0, len(builders))
+	for _, b := range builders {
+		link := b.link
+		link.Unresponsive = link.RTTMs > 60000
+		link.SourceToTargetBps = firstRate(b.s2tSender, b.s2tReceiver)
+		link.TargetToSourceBps = firstRate(b.t2sSender, b.t2sReceiver)
+		sort.Strings(link.ReportedBy)
+		links = append(links, link)
+	}
+	sort.Slice(links, func(i, j int) bool {
+		if links[i].Source != links[j].Source {
+			return links[i].Source < links[j].Source
+		}
+		return links[i].Target < links[j].Target
+	})
+	return links
+}
+
+// firstRate returns the sender's measurement when it is non-zero, else the
+// receiver's. Agents that predate traffic rates report zero.
+func firstRate(sender, receiver *uint64) uint64 {
+	if sender != nil && *sender > 0 {
+		return *sender
+	}
+	if receiver != nil {
+		return *receiver
+	}
+	return 0
+}
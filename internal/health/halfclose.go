@@ -0,0 +1,45 @@
+package health
+
+import (
+	"net/http"
+)
+
+// HalfCloseStatsResponse is the response for the /api/half-close endpoint.
+// Counters are cumulative since the exit handler started.
+type HalfCloseStatsResponse struct {
+	Enabled                  bool   `json:"enabled"`                    // Exit handler running on this agent
+	ClientLinger             string `json:"client_linger"`              // "0s" = until the idle timeout
+	DestinationLinger        string `json:"destination_linger"`         // "0s" = close at once
+	ClientHalfOpen           int    `json:"client_half_open"`           // Streams the client has finished, open now
+	DestinationHalfOpen      int    `json:"destination_half_open"`      // Streams the destination has finished, open now
+	ClientFinished           uint64 `json:"client_finished"`            // Client finished first
+	DestinationFinished      uint64 `json:"destination_finished"`       // Destination finished first
+	ClientLingerExpired      uint64 `json:"client_linger_expired"`      // Closed at client_linger
+	DestinationLingerExpired uint64 `json:"destination_linger_expired"` // Closed at destination_linger
+}
+
+// HalfCloseStatsProvider provides the counters of half-closed exit streams.
+type HalfCloseStatsProvider interface {
+	// HalfCloseStats returns the exit half-close settings and counters.
+	HalfCloseStats() HalfCloseStatsResponse
+}
+
+// SetHalfCloseStatsProvider sets the half-close counters provider.
+// This is called after the agent is initialized.
+func (s *Server) SetHalfCloseStatsProvider(provider HalfCloseStatsProvider) {
+	s.halfCloseStatsProvider = provider
+}
+
+// handleHalfCloseStats handles GET /api/half-close for the exit half-close
+// counters.
+func (s *Server) handleHalfCloseStats(w http.ResponseWriter, r *http.Request) {
+	if !requireGET(w, r) {
+		return
+	}
+	if s.halfCloseStatsProvider == nil {
+		http.Error(w, "half-close stats provider not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, s.halfCloseStatsProvider.HalfCloseStats())
+}
@@ -0,0 +1,43 @@
+package health
+
+import (
+	"net/http"
+)
+
+// ICMPStatsResponse is the response for the /api/icmp endpoint. Counters are
+// cumulative since the agent started.
+type ICMPStatsResponse struct {
+	Enabled           bool   `json:"enabled"`
+	ActiveSessions    int    `json:"active_sessions"`
+	SessionsOpened    uint64 `json:"sessions_opened"`
+	SessionsRejected  uint64 `json:"sessions_rejected"` // Refused by the total or per-peer session cap
+	EchoRequests      uint64 `json:"echo_requests"`
+	EchoReplies       uint64 `json:"echo_replies"`
+	DestRateLimited   uint64 `json:"dest_rate_limited"`   // Echoes dropped by the per-destination limit
+	GlobalRateLimited uint64 `json:"global_rate_limited"` // Echoes dropped by the global limit
+}
+
+// ICMPStatsProvider provides exit-side ICMP counters.
+type ICMPStatsProvider interface {
+	// ICMPStats returns the ICMP handler counters.
+	ICMPStats() ICMPStatsResponse
+}
+
+// SetICMPStatsProvider sets the ICMP counters provider.
+// This is called after the agent is initialized.
+func (s *Server) SetICMPStatsProvider(provider ICMPStatsProvider) {
+	s.icmpStatsProvider = provider
+}
+
+// handleICMPStats handles GET /api/icmp for the exit-side ICMP counters.
+func (s *Server) handleICMPStats(w http.ResponseWriter, r *http.Request) {
+	if !requireGET(w, r) {
+		return
+	}
+	if s.icmpStatsProvider == nil {
+		http.Error(w, "ICMP provider not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, s.icmpStatsProvider.ICMPStats())
+}
@@ -0,0 +1,85 @@
+package health
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/postalsys/muti-metroo/internal/identity"
+	"github.com/postalsys/muti-metroo/internal/protocol"
+)
+
+// Kinds of idle entries reported by the idle API.
+const (
+	IdleKindStream = "stream" // Outbound, exit or relay stream
+	IdleKindUDP    = "udp"    // UDP association terminated by this exit
+)
+
+// IdleEntry describes a stream or UDP association that has been idle for at
+// least the requested time.
+type IdleEntry struct {
+	Kind        string `json:"kind"`
+	ID          uint64 `json:"id"`
+	Direction   string `json:"direction,omitempty"` // Stream direction (streams only)
+	Peer        string `json:"peer,omitempty"`      // Short ID of the adjacent peer
+	Destination string `json:"destination,omitempty"`
+	BytesSent   uint64 `json:"bytes_sent"`
+	BytesRecv   uint64 `json:"bytes_recv"`
+	AgeMs       int64  `json:"age_ms"`
+	IdleMs      int64  `json:"idle_ms"`
+}
+
+// IdleManageResult contains the response for an idle list or close operation.
+// For close, Entries lists what was closed.
+type IdleManageResult struct {
+	Status  string      `json:"status"`
+	Message string      `json:"message,omitempty"`
+	MinIdle string      `json:"min_idle"`
+	Entries []IdleEntry `json:"entries"`
+}
+
+// IdleManageProvider lists and force-closes idle streams and UDP associations.
+type IdleManageProvider interface {
+	// ManageIdle handles list/close operations. minIdle is a duration string
+	// ("" = default); entries idle for at least that long are listed or
+	// closed.
+	ManageIdle(action, minIdle string) (*IdleManageResult, error)
+}
+
+// SetIdleManageProvider sets the idle stream management provider.
+func (s *Server) SetIdleManageProvider(provider IdleManageProvider) {
+	s.idleManageProvider = provider
+}
+
+// handleIdleManage handles POST /idle/manage for listing and force-closing
+// idle streams and UDP associations.
+func (s *Server) handleIdleManage(w http.ResponseWriter, r *http.Request) {
+	if !requirePOST(w, r) {
+		return
+	}
+	if s.idleManageProvider == nil {
+		http.Error(w, "idle management not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	var req struct {
+		Action  string `json:"action"`
+		MinIdle string `json:"min_idle"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request: " + err.Error()})
+		return
+	}
+
+	result, err := s.idleManageProvider.ManageIdle(req.Action, req.MinIdle)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+// handleRemoteIdleManage forwards idle management requests to a remote agent.
+func (s *Server) handleRemoteIdleManage(w http.ResponseWriter, r *http.Request, targetID identity.AgentID) {
+	s.forwardRemoteControl(w, r, targetID, protocol.ControlTypeIdleManage, "idle management")
+}
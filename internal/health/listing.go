@@ -0,0 +1,618 @@
+package health
+
+import (
+	"cmp"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/postalsys/muti-metroo/internal/identity"
+	"github.com/postalsys/muti-metroo/internal/protocol"
+)
+
+// ListPage describes which part of a filtered listing a response holds.
+// Total counts all entries matching the filters; Limit 0 means no limit.
+type ListPage struct {
+	Total  int `json:"total"`
+	Offset int `json:"offset"`
+	Limit  int `json:"limit"`
+}
+
+// RoutesResponse is the response for the /api/routes endpoint.
+type RoutesResponse struct {
+	ListPage
+	Routes []DashboardRouteInfo `json:"routes"`
+}
+
+// PeersResponse is the response for the /api/peers endpoint.
+type PeersResponse struct {
+	ListPage
+	Peers []DashboardPeerInfo `json:"peers"`
+}
+
+// Sort keys accepted by the listing endpoints. The first key of each list is
+// the default.
+var (
+	routeSortKeys = []string{"network", "origin", "hops", "next_hop"}
+	peerSortKeys  = []string{"name", "id", "state", "rtt", "bytes"}
+	nodeSortKeys  = []string{"name", "id", "hostname", "uptime"}
+)
+
+// listQuery holds the filter, sort and pagination parameters of a listing
+// request.
+type listQuery struct {
+	search    string       // q: agent ID prefix, display name substring or network text
+	searchNet netip.Prefix // q parsed as a CIDR or IP address, if it is one
+	origin    string       // origin: route origin ID prefix or display name
+	nextHop   string       // next_hop: ID prefix or display name of the first hop
+	prefix    netip.Prefix // prefix: networks overlapping this CIDR
+	routeType string       // type: "cidr" or "domain"
+	state     string       // state: peer connection state
+	tags      []string     // tag: agent tags that must all be present
+	sortKey   string       // sort: one of the endpoint's sort keys, "" for the default order
+	desc      bool         // order=desc
+	offset    int
+	limit     int
+}
+
+// parseListQuery parses the listing query parameters of r. sortKeys lists
+// the sort keys the endpoint accepts.
+func parseListQuery(r *http.Request, sortKeys []string) (listQuery, error) {
+	v := r.URL.Query()
+	q := listQuery{
+		search:    strings.TrimSpace(v.Get("q")),
+		origin:    strings.TrimSpace(v.Get("origin")),
+		nextHop:   strings.TrimSpace(v.Get("next_hop")),
+		routeType: v.Get("type"),
+		state:     v.Get("state"),
+		sortKey:   v.Get("sort"),
+		tags:      parseTags(v),
+	}
+
+	if q.search != "" {
+		q.searchNet, _ = parsePrefixOrAddr(q.search)
+	}
+	if s := v.Get("prefix"); s != "" {
+		p, err := parsePrefixOrAddr(s)
+		if err != nil {
+			return q, fmt.Errorf("invalid prefix %q: must be a CIDR or IP address", s)
+		}
+		q.prefix = p
+	}
+	if q.routeType != "" && q.routeType != "cidr" && q.routeType != "domain" {
+		return q, fmt.Errorf("invalid type %q: must be cidr or domain", q.routeType)
+	}
+	if q.sortKey != "" && !slices.Contains(sortKeys, q.sortKey) {
+		return q, fmt.Errorf("invalid sort %q: must be one of %s", q.sortKey, strings.Join(sortKeys, ", "))
+	}
+	switch order := v.Get("order"); order {
+	case "", "asc":
+	case "desc":
+		q.desc = true
+	default:
+		return q, fmt.Errorf("invalid order %q: must be asc or desc", order)
+	}
+
+	var err error
+	if q.offset, err = parseNonNegative(v.Get("offset")); err != nil {
+		return q, fmt.Errorf("invalid offset: %w", err)
+	}
+	if q.limit, err = parseNonNegative(v.Get("limit")); err != nil {
+		return q, fmt.Errorf("invalid limit: %w", err)
+	}
+	return q, nil
+}
+
+// parseTags returns the agent tags of the tag query parameters. The
+// parameter may repeat and each value may list several tags separated by
+// commas.
+func parseTags(v url.Values) []string {
+	var tags []string
+	for _, value := range v["tag"] {
+		for _, tag := range strings.Split(value, ",") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				tags = append(tags, tag)
+			}
+		}
+	}
+	return tags
+}
+
+// hasTags reports whether have contains every tag of want.
+func hasTags(have, want []string) bool {
+	for _, tag := range want {
+		if !slices.Contains(have, tag) {
+			return false
+		}
+	}
+	return true
+}
+
+// parseNonNegative parses an optional non-negative integer query parameter.
+func parseNonNegative(s string) (int, error) {
+	if s == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%q is not a non-negative integer", s)
+	}
+	return n, nil
+}
+
+// parsePrefixOrAddr parses a CIDR, or an IP address as a single-address
+// prefix.
+func parsePrefixOrAddr(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		return p.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// overlapsNetwork reports whether network, a CIDR or IP address, overlaps p.
+func overlapsNetwork(network string, p netip.Prefix) bool {
+	n, err := parsePrefixOrAddr(network)
+	return err == nil && n.Overlaps(p)
+}
+
+// matchAgent reports whether s names an agent: a prefix of its ID (short or
+// full form) or a case-insensitive substring of its display name.
+func matchAgent(s, shortID, displayName string) bool {
+	if s == "" {
+		return false
+	}
+	id := strings.ToLower(s)
+	if len(id) >= len(shortID) {
+		if strings.HasPrefix(id, shortID) {
+			return true
+		}
+	} else if strings.HasPrefix(shortID, id) {
+		return true
+	}
+	return strings.Contains(strings.ToLower(displayName), strings.ToLower(s))
+}
+
+// page returns the part of items selected by the offset and limit of q,
+// along with the page description.
+func page[T any](items []T, q listQuery) ([]T, ListPage) {
+	p := ListPage{Total: len(items), Offset: q.offset, Limit: q.limit}
+	start := min(q.offset, len(items))
+	end := len(items)
+	if q.limit > 0 {
+		end = min(start+q.limit, end)
+	}
+	return items[start:end], p
+}
+
+// sortList sorts items with compare, reversed when q asks for descending
+// order. Ties keep their existing order.
+func sortList[T any](items []T, q listQuery, compare func(a, b T) int) {
+	slices.SortStableFunc(items, func(a, b T) int {
+		if q.desc {
+			return compare(b, a)
+		}
+		return compare(a, b)
+	})
+}
+
+// compareNetworks orders CIDRs by address then prefix length, falling back
+// to text order for domain patterns.
+func compareNetworks(a, b string) int {
+	pa, errA := netip.ParsePrefix(a)
+	pb, errB := netip.ParsePrefix(b)
+	switch {
+	case errA == nil && errB == nil:
+		return cmp.Or(pa.Addr().Compare(pb.Addr()), cmp.Compare(pa.Bits(), pb.Bits()))
+	case errA == nil:
+		return -1
+	case errB == nil:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+// agentNamer resolves agent IDs to the display names and capabilities shown
+// by the dashboard endpoints.
+type agentNamer struct {
+	localID      identity.AgentID
+	localName    string
+	localTags    []string
+	displayNames map[identity.AgentID]string
+	nodeInfo     map[identity.AgentID]*protocol.NodeInfo
+}
+
+// newAgentNamer snapshots the display names and node info known to the
+// remote provider.
+func (s *Server) newAgentNamer() *agentNamer {
+	n := &agentNamer{
+		localID:      s.remoteProvider.ID(),
+		localName:    s.remoteProvider.DisplayName(),
+		displayNames: s.remoteProvider.GetAllDisplayNames(),
+		nodeInfo:     s.remoteProvider.GetAllNodeInfo(),
+	}
+	if info := s.remoteProvider.GetLocalNodeInfo(); info != nil {
+		n.localTags = info.AgentTags
+	}
+	return n
+}
+
+// name returns the display name of an agent, or its short ID if it has none.
+func (n *agentNamer) name(id identity.AgentID) string {
+	if id == n.localID {
+		return n.localName
+	}
+	if name, ok := n.displayNames[id]; ok && name != "" {
+		return name
+	}
+	return id.ShortString()
+}
+
+// udpEnabled reports whether an agent advertises UDP relay support.
+func (n *agentNamer) udpEnabled(id identity.AgentID) bool {
+	info, ok := n.nodeInfo[id]
+	return ok && info.UDPEnabled
+}
+
+// tags returns the exit tags an agent advertises.
+func (n *agentNamer) tags(id identity.AgentID) []string {
+	if info, ok := n.nodeInfo[id]; ok {
+		return info.Tags
+	}
+	return nil
+}
+
+// agentTags returns the agent tags of an agent, including the local agent.
+func (n *agentNamer) agentTags(id identity.AgentID) []string {
+	if id == n.localID {
+		return n.localTags
+	}
+	if info, ok := n.nodeInfo[id]; ok {
+		return info.AgentTags
+	}
+	return nil
+}
+
+// path returns the display names and short IDs of a route path, starting
+// with the local agent.
+func (n *agentNamer) path(path []identity.AgentID) (pathDisplay, pathIDs []string) {
+	pathDisplay = []string{n.localName}
+	pathIDs = []string{n.localID.ShortString()}
+	for _, agentID := range path {
+		pathDisplay = append(pathDisplay, n.name(agentID))
+		pathIDs = append(pathIDs, agentID.ShortString())
+	}
+	return pathDisplay, pathIDs
+}
+
+// dashboardPeers returns the connected peers as shown by the dashboard.
+func (s *Server) dashboardPeers(namer *agentNamer) []DashboardPeerInfo {
+	details := s.remoteProvider.GetPeerDetails()
+	peers := make([]DashboardPeerInfo, 0, len(details))
+	for _, peer := range details {
+		peers = append(peers, DashboardPeerInfo{
+			ID:           peer.ID.String(),
+			ShortID:      peer.ID.ShortString(),
+			DisplayName:  peer.DisplayName,
+			State:        peer.State,
+			RTTMs:        peer.RTT.Milliseconds(),
+			Unresponsive: peer.RTT.Seconds() > 60,
+			IsDialer:     peer.IsDialer,
+
+			ProtocolVersion: peer.ProtocolVersion,
+			Features:        peer.Features,
+			MaxPayload:      peer.MaxPayload,
+
+			BytesSent:     peer.BytesSent,
+			BytesRecv:     peer.BytesRecv,
+			TxBytesPerSec: peer.TxBytesPerSec,
+			RxBytesPerSec: peer.RxBytesPerSec,
+
+			AgentTags: namer.agentTags(peer.ID),
+		})
+	}
+	return peers
+}
+
+// dashboardRoutes returns the CIDR and domain routes as shown by the
+// dashboard: CIDR routes first, each type ordered by network, then origin.
+func (s *Server) dashboardRoutes(namer *agentNamer) []DashboardRouteInfo {
+	routeDetails := s.remoteProvider.GetRouteDetails()
+	domainRouteDetails := s.remoteProvider.GetDomainRouteDetails()
+	routes := make([]DashboardRouteInfo, 0, len(routeDetails)+len(domainRouteDetails))
+
+	for _, route := range routeDetails {
+		pathDisplay, pathIDs := namer.path(route.Path)
+		routes = append(routes, DashboardRouteInfo{
+			Network:     route.Network,
+			RouteType:   "cidr",
+			Origin:      namer.name(route.Origin),
+			OriginID:    route.Origin.ShortString(),
+			HopCount:    route.HopCount,
+			PathDisplay: pathDisplay,
+			PathIDs:     pathIDs,
+			TCP:         true,
+			UDP:         namer.udpEnabled(route.Origin),
+			Unreachable: route.Unreachable,
+			Alternates:  route.Alternates,
+			OriginTags:  namer.tags(route.Origin),
+
+			OriginAgentTags: namer.agentTags(route.Origin),
+		})
+	}
+
+	for _, route := range domainRouteDetails {
+		pathDisplay, pathIDs := namer.path(route.Path)
+		routes = append(routes, DashboardRouteInfo{
+			Network:     route.Pattern,
+			RouteType:   "domain",
+			Origin:      namer.name(route.Origin),
+			OriginID:    route.Origin.ShortString(),
+			HopCount:    route.HopCount,
+			PathDisplay: pathDisplay,
+			PathIDs:     pathIDs,
+			TCP:         true,
+			UDP:         namer.udpEnabled(route.Origin),
+
+			OriginAgentTags: namer.agentTags(route.Origin),
+		})
+	}
+
+	slices.SortFunc(routes, func(a, b DashboardRouteInfo) int {
+		if a.RouteType != b.RouteType {
+			if a.RouteType == "cidr" {
+				return -1
+			}
+			return 1
+		}
+		return cmp.Or(strings.Compare(a.Network, b.Network), strings.Compare(a.OriginID, b.OriginID))
+	})
+	return routes
+}
+
+// routeNextHop returns the short ID and display name of a route's first hop,
+// or empty strings for local routes.
+func routeNextHop(route DashboardRouteInfo) (shortID, name string) {
+	if len(route.PathIDs) < 2 {
+		return "", ""
+	}
+	return route.PathIDs[1], route.PathDisplay[1]
+}
+
+// matchRoute reports whether a route passes the filters of q.
+func matchRoute(route DashboardRouteInfo, q listQuery) bool {
+	if q.routeType != "" && route.RouteType != q.routeType {
+		return false
+	}
+	if !hasTags(route.OriginAgentTags, q.tags) {
+		return false
+	}
+	if q.origin != "" && !matchAgent(q.origin, route.OriginID, route.Origin) {
+		return false
+	}
+	hopID, hopName := routeNextHop(route)
+	if q.nextHop != "" && (hopID == "" || !matchAgent(q.nextHop, hopID, hopName)) {
+		return false
+	}
+	if q.prefix.IsValid() && (route.RouteType != "cidr" || !overlapsNetwork(route.Network, q.prefix)) {
+		return false
+	}
+	if q.search == "" {
+		return true
+	}
+	if q.searchNet.IsValid() {
+		return route.RouteType == "cidr" && overlapsNetwork(route.Network, q.searchNet)
+	}
+	return matchAgent(q.search, route.OriginID, route.Origin) ||
+		(hopID != "" && matchAgent(q.search, hopID, hopName)) ||
+		strings.Contains(strings.ToLower(route.Network), strings.ToLower(q.search))
+}
+
+// handleRoutes handles GET /api/routes, the CIDR and domain routes of the
+// dashboard with filtering, sorting and pagination.
+func (s *Server) handleRoutes(w http.ResponseWriter, r *http.Request) {
+	if !requireGET(w, r) {
+		return
+	}
+	if s.remoteProvider == nil {
+		http.Error(w, "provider not configured", http.StatusServiceUnavailable)
+		return
+	}
+	q, err := parseListQuery(r, routeSortKeys)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var routes []DashboardRouteInfo
+	if !s.shouldRestrictTopology() {
+		for _, route := range s.dashboardRoutes(s.newAgentNamer()) {
+			if matchRoute(route, q) {
+				routes = append(routes, route)
+			}
+		}
+	}
+
+	switch q.sortKey {
+	case "network":
+		sortList(routes, q, func(a, b DashboardRouteInfo) int {
+			return compareNetworks(a.Network, b.Network)
+		})
+	case "origin":
+		sortList(routes, q, func(a, b DashboardRouteInfo) int {
+			return cmp.Or(strings.Compare(strings.ToLower(a.Origin), strings.ToLower(b.Origin)), strings.Compare(a.OriginID, b.OriginID))
+		})
+	case "hops":
+		sortList(routes, q, func(a, b DashboardRouteInfo) int {
+			return cmp.Compare(a.HopCount, b.HopCount)
+		})
+	case "next_hop":
+		sortList(routes, q, func(a, b DashboardRouteInfo) int {
+			_, nameA := routeNextHop(a)
+			_, nameB := routeNextHop(b)
+			return strings.Compare(strings.ToLower(nameA), strings.ToLower(nameB))
+		})
+	default:
+		if q.desc {
+			slices.Reverse(routes)
+		}
+	}
+
+	routes, p := page(routes, q)
+	if routes == nil {
+		routes = []DashboardRouteInfo{}
+	}
+	writeJSON(w, http.StatusOK, RoutesResponse{ListPage: p, Routes: routes})
+}
+
+// matchPeer reports whether a peer passes the filters of q.
+func matchPeer(peer DashboardPeerInfo, q listQuery) bool {
+	if q.state != "" && !strings.EqualFold(peer.State, q.state) {
+		return false
+	}
+	if !hasTags(peer.AgentTags, q.tags) {
+		return false
+	}
+	return q.search == "" || matchAgent(q.search, peer.ShortID, peer.DisplayName)
+}
+
+// handlePeers handles GET /api/peers, the connected peers of the dashboard
+// with filtering, sorting and pagination.
+func (s *Server) handlePeers(w http.ResponseWriter, r *http.Request) {
+	if !requireGET(w, r) {
+		return
+	}
+	if s.remoteProvider == nil {
+		http.Error(w, "provider not configured", http.StatusServiceUnavailable)
+		return
+	}
+	q, err := parseListQuery(r, peerSortKeys)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var peers []DashboardPeerInfo
+	if !s.shouldRestrictTopology() {
+		for _, peer := range s.dashboardPeers(s.newAgentNamer()) {
+			if matchPeer(peer, q) {
+				peers = append(peers, peer)
+			}
+		}
+	}
+
+	byName := func(a, b DashboardPeerInfo) int {
+		return cmp.Or(strings.Compare(strings.ToLower(a.DisplayName), strings.ToLower(b.DisplayName)), strings.Compare(a.ID, b.ID))
+	}
+	switch q.sortKey {
+	case "id":
+		sortList(peers, q, func(a, b DashboardPeerInfo) int { return strings.Compare(a.ID, b.ID) })
+	case "state":
+		sortList(peers, q, func(a, b DashboardPeerInfo) int { return strings.Compare(a.State, b.State) })
+	case "rtt":
+		sortList(peers, q, func(a, b DashboardPeerInfo) int { return cmp.Compare(a.RTTMs, b.RTTMs) })
+	case "bytes":
+		sortList(peers, q, func(a, b DashboardPeerInfo) int {
+			return cmp.Compare(a.BytesSent+a.BytesRecv, b.BytesSent+b.BytesRecv)
+		})
+	default:
+		sortList(peers, q, byName)
+	}
+
+	peers, p := page(peers, q)
+	if peers == nil {
+		peers = []DashboardPeerInfo{}
+	}
+	writeJSON(w, http.StatusOK, PeersResponse{ListPage: p, Peers: peers})
+}
+
+// filterNodes returns the nodes passing the filters of q. A CIDR or IP
+// search or prefix filter matches nodes with an address in the network and
+// nodes originating a route that overlaps it.
+func (s *Server) filterNodes(nodes []TopologyAgentInfo, q listQuery) []TopologyAgentInfo {
+	if q.search == "" && !q.prefix.IsValid() && len(q.tags) == 0 {
+		return nodes
+	}
+
+	var routes []RouteDetails
+	if q.searchNet.IsValid() || q.prefix.IsValid() {
+		routes = s.remoteProvider.GetRouteDetails()
+	}
+	inNetwork := func(node TopologyAgentInfo, p netip.Prefix) bool {
+		for _, ip := range node.IPAddresses {
+			if overlapsNetwork(ip, p) {
+				return true
+			}
+		}
+		for _, route := range routes {
+			if route.Origin.String() == node.ID && overlapsNetwork(route.Network, p) {
+				return true
+			}
+		}
+		return false
+	}
+
+	var matched []TopologyAgentInfo
+	for _, node := range nodes {
+		if !hasTags(node.AgentTags, q.tags) {
+			continue
+		}
+		if q.prefix.IsValid() && !inNetwork(node, q.prefix) {
+			continue
+		}
+		if q.searchNet.IsValid() {
+			if !inNetwork(node, q.searchNet) {
+				continue
+			}
+		} else if q.search != "" && !matchAgent(q.search, node.ShortID, node.DisplayName) &&
+			!strings.Contains(strings.ToLower(node.Hostname), strings.ToLower(q.search)) {
+			continue
+		}
+		matched = append(matched, node)
+	}
+	return matched
+}
+
+// sortNodes orders nodes by the sort key of q. The default order lists the
+// local node first, then the rest by display name.
+func sortNodes(nodes []TopologyAgentInfo, q listQuery) {
+	byName := func(a, b TopologyAgentInfo) int {
+		return cmp.Or(strings.Compare(strings.ToLower(a.DisplayName), strings.ToLower(b.DisplayName)), strings.Compare(a.ID, b.ID))
+	}
+	switch q.sortKey {
+	case "name":
+		sortList(nodes, q, byName)
+	case "id":
+		sortList(nodes, q, func(a, b TopologyAgentInfo) int { return strings.Compare(a.ID, b.ID) })
+	case "hostname":
+		sortList(nodes, q, func(a, b TopologyAgentInfo) int {
+			return cmp.Or(strings.Compare(strings.ToLower(a.Hostname), strings.ToLower(b.Hostname)), byName(a, b))
+		})
+	case "uptime":
+		sortList(nodes, q, func(a, b TopologyAgentInfo) int { return cmp.Compare(a.UptimeHours, b.UptimeHours) })
+	default:
+		slices.SortStableFunc(nodes, func(a, b TopologyAgentInfo) int {
+			if a.IsLocal != b.IsLocal {
+				if a.IsLocal {
+					return -1
+				}
+				return 1
+			}
+			return byName(a, b)
+		})
+		if q.desc {
+			slices.Reverse(nodes)
+		}
+	}
+}
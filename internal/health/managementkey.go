@@ -0,0 +1,90 @@
+package health
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/postalsys/muti-metroo/internal/identity"
+	"github.com/postalsys/muti-metroo/internal/protocol"
+)
+
+// ManagementKeyRequest is a management key rotation operation.
+type ManagementKeyRequest struct {
+	// Action is status, stage, activate or abort.
+	Action string `json:"action"`
+
+	// PublicKey is the next management public key in hex (stage), or the
+	// staged key expected by activate (optional).
+	PublicKey string `json:"public_key,omitempty"`
+}
+
+// ManagementKeyAgent reports which management keys an agent's node info is
+// encrypted to, among the keys the reporting agent can decrypt.
+type ManagementKeyAgent struct {
+	ID          string   `json:"id"`
+	ShortID     string   `json:"short_id"`
+	DisplayName string   `json:"display_name,omitempty"`
+	SealedTo    []string `json:"sealed_to"`
+	Adopted     bool     `json:"adopted"` // Sealed to the target key
+}
+
+// ManagementKeyResult contains the response for a management key operation.
+// Every action returns the resulting state. The adoption report (Agents) is
+// only filled in on agents that hold a management private key.
+type ManagementKeyResult struct {
+	Status        string `json:"status"`
+	Message       string `json:"message,omitempty"`
+	PublicKey     string `json:"public_key"`
+	NextPublicKey string `json:"next_public_key,omitempty"`
+	CanDecrypt    bool   `json:"can_decrypt"`
+
+	// TargetKey is the key agents are moving to: the next key while one is
+	// staged, the public key otherwise.
+	TargetKey string               `json:"target_key,omitempty"`
+	Adopted   int                  `json:"adopted"`
+	Total     int                  `json:"total"`
+	Agents    []ManagementKeyAgent `json:"agents,omitempty"`
+}
+
+// ManagementKeyProvider rotates the management public key that node info is
+// encrypted to.
+type ManagementKeyProvider interface {
+	ManageManagementKey(req ManagementKeyRequest) (*ManagementKeyResult, error)
+}
+
+// SetManagementKeyProvider sets the management key provider.
+func (s *Server) SetManagementKeyProvider(provider ManagementKeyProvider) {
+	s.managementKeyProvider = provider
+}
+
+// handleManagementKey handles POST /management-key/manage for management key
+// rotation.
+func (s *Server) handleManagementKey(w http.ResponseWriter, r *http.Request) {
+	if !requirePOST(w, r) {
+		return
+	}
+	if s.managementKeyProvider == nil {
+		http.Error(w, "management key not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	var req ManagementKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request: " + err.Error()})
+		return
+	}
+
+	result, err := s.managementKeyProvider.ManageManagementKey(req)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+// handleRemoteManagementKey forwards management key requests to a remote
+// agent.
+func (s *Server) handleRemoteManagementKey(w http.ResponseWriter, r *http.Request, targetID identity.AgentID) {
+	s.forwardRemoteControl(w, r, targetID, protocol.ControlTypeManagementKey, "management key")
+}
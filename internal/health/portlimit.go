@@ -0,0 +1,48 @@
+package health
+
+import (
+	"net/http"
+)
+
+// PortLimitStatsResponse is the response for the /api/port-limits endpoint.
+// Counters are cumulative since the exit handler started.
+type PortLimitStatsResponse struct {
+	Active           bool            `json:"active"`             // Classes restrict connections now
+	DefaultRouteOnly bool            `json:"default_route_only"` // Classes apply only with a default route
+	Classes          []PortClassInfo `json:"classes"`
+}
+
+// PortClassInfo describes one exit port class.
+type PortClassInfo struct {
+	Name    string   `json:"name"`
+	Ports   []uint16 `json:"ports"`
+	Action  string   `json:"action"`  // "block" or "limit"
+	Active  int      `json:"active"`  // Connections open now
+	Refused uint64   `json:"refused"` // Connections refused
+}
+
+// PortLimitStatsProvider provides the exit port classes and their counters.
+type PortLimitStatsProvider interface {
+	// PortLimitStats returns the exit port classes and their counters.
+	PortLimitStats() PortLimitStatsResponse
+}
+
+// SetPortLimitStatsProvider sets the port class counters provider.
+// This is called after the agent is initialized.
+func (s *Server) SetPortLimitStatsProvider(provider PortLimitStatsProvider) {
+	s.portLimitStatsProvider = provider
+}
+
+// handlePortLimitStats handles GET /api/port-limits for the exit port
+// class counters.
+func (s *Server) handlePortLimitStats(w http.ResponseWriter, r *http.Request) {
+	if !requireGET(w, r) {
+		return
+	}
+	if s.portLimitStatsProvider == nil {
+		http.Error(w, "port limit stats provider not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, s.portLimitStatsProvider.PortLimitStats())
+}
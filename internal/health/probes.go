@@ -0,0 +1,88 @@
+package health
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ReadinessCheck is the result of one /readyz criterion.
+type ReadinessCheck struct {
+	Name   string // Short name, e.g. "peers" or "route:10.0.0.0/8"
+	OK     bool
+	Detail string // Why the check failed
+}
+
+// ReadinessProvider evaluates the configured readiness criteria.
+type ReadinessProvider interface {
+	// Readiness returns the result of every readiness criterion.
+	Readiness() []ReadinessCheck
+}
+
+// SetReadinessProvider sets the readiness criteria provider for /readyz.
+// Without one, /readyz only requires the agent to be running.
+func (s *Server) SetReadinessProvider(provider ReadinessProvider) {
+	s.readinessProvider = provider
+}
+
+// handleLivez handles the Kubernetes liveness probe. It answers as long as
+// the process serves HTTP, so a liveness failure means the agent is stuck,
+// never that it lost its peers.
+func (s *Server) handleLivez(w http.ResponseWriter, r *http.Request) {
+	if !requireGET(w, r) {
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	if r.URL.Query().Has("verbose") {
+		w.Write([]byte("[+]ping ok\nlivez check passed\n"))
+		return
+	}
+	w.Write([]byte("ok\n"))
+}
+
+// handleReadyz handles the Kubernetes readiness probe. The agent is ready
+// when it is running and every criterion of the readiness provider passes.
+// Like the Kubernetes API server, it answers "ok", lists the checks with
+// ?verbose, and always lists them on failure.
+func (s *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	if !requireGET(w, r) {
+		return
+	}
+
+	running := s.provider != nil && s.provider.IsRunning()
+	checks := []ReadinessCheck{{Name: "running", OK: running, Detail: "agent not running"}}
+	if running && s.readinessProvider != nil {
+		checks = append(checks, s.readinessProvider.Readiness()...)
+	}
+
+	ready := true
+	for _, c := range checks {
+		ready = ready && c.OK
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	if ready && !r.URL.Query().Has("verbose") {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("ok\n"))
+		return
+	}
+
+	var b strings.Builder
+	for _, c := range checks {
+		if c.OK {
+			fmt.Fprintf(&b, "[+]%s ok\n", c.Name)
+		} else {
+			fmt.Fprintf(&b, "[-]%s failed: %s\n", c.Name, c.Detail)
+		}
+	}
+	if ready {
+		b.WriteString("readyz check passed\n")
+		w.WriteHeader(http.StatusOK)
+	} else {
+		b.WriteString("readyz check failed\n")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	w.Write([]byte(b.String()))
+}
@@ -0,0 +1,245 @@
+package health
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/postalsys/muti-metroo/internal/identity"
+)
+
+// Route export stream message types.
+const (
+	RouteExportAdd       = "add"       // Route is new or changed; replaces any earlier entry with the same network and origin
+	RouteExportWithdraw  = "withdraw"  // Route is gone
+	RouteExportSync      = "sync"      // Initial table has been sent
+	RouteExportKeepalive = "keepalive" // Sent when the table is unchanged for a while
+)
+
+// RouteExportAgent identifies an agent in route export entries.
+type RouteExportAgent struct {
+	ID          string `json:"id"`
+	ShortID     string `json:"short_id"`
+	DisplayName string `json:"display_name,omitempty"`
+}
+
+// RouteExportEntry is one CIDR route of the export table.
+type RouteExportEntry struct {
+	Network     string            `json:"network"`
+	Family      string            `json:"family"` // "ipv4" or "ipv6"
+	Origin      RouteExportAgent  `json:"origin"`
+	NextHop     *RouteExportAgent `json:"next_hop,omitempty"`  // Nil for local routes
+	Transport   string            `json:"transport,omitempty"` // Transport of the link to the next hop
+	Metric      int               `json:"metric"`
+	HopCount    int               `json:"hop_count"`
+	Path        []string          `json:"path"`  // Agent IDs from the next hop to the origin
+	Local       bool              `json:"local"` // Advertised by this agent
+	Unreachable bool              `json:"unreachable,omitempty"`
+}
+
+// RouteExportResponse is the response for GET /api/routes/export.
+type RouteExportResponse struct {
+	Agent     RouteExportAgent   `json:"agent"`
+	Generated time.Time          `json:"generated"`
+	Routes    []RouteExportEntry `json:"routes"`
+}
+
+// RouteExportUpdate is one line of the /api/routes/export?stream=true
+// stream.
+type RouteExportUpdate struct {
+	Type  string            `json:"type"`
+	Time  time.Time         `json:"time"`
+	Route *RouteExportEntry `json:"route,omitempty"`
+}
+
+// handleRouteExport handles GET /api/routes/export, the CIDR routing table
+// with next hop metadata for feeding routing daemons. With ?stream=true the
+// response is newline delimited JSON: the table as add messages, a sync
+// message, then add and withdraw messages as the table changes.
+func (s *Server) handleRouteExport(w http.ResponseWriter, r *http.Request) {
+	if !requireGET(w, r) {
+		return
+	}
+	if s.remoteProvider == nil {
+		http.Error(w, "provider not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	if r.URL.Query().Get("stream") != "true" {
+		localID := s.remoteProvider.ID()
+		writeJSON(w, http.StatusOK, RouteExportResponse{
+			Agent: RouteExportAgent{
+				ID:          localID.String(),
+				ShortID:     localID.ShortString(),
+				DisplayName: s.remoteProvider.DisplayName(),
+			},
+			Generated: time.Now(),
+			Routes:    s.routeExportEntries(),
+		})
+		return
+	}
+
+	s.streamRouteExport(w, r)
+}
+
+// streamRouteExport writes the route export stream. Route events trigger a
+// comparison of the current table with what the client has been sent; the
+// same comparison runs every eventPingInterval, so changes that raise no
+// event and events dropped under load are still picked up.
+func (s *Server) streamRouteExport(w http.ResponseWriter, r *http.Request) {
+	sub := s.events.subscribe([]string{EventRouteAdd, EventRouteWithdraw})
+	if sub == nil {
+		http.Error(w, "server stopping", http.StatusServiceUnavailable)
+		return
+	}
+	defer s.events.unsubscribe(sub)
+
+	// Disable write deadline for the long-lived response
+	rc := http.NewResponseController(w)
+	_ = rc.SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+
+	enc := json.NewEncoder(w)
+	sent := make(map[string][]byte) // Route key -> JSON of the entry last sent
+	send := func(u RouteExportUpdate) bool {
+		u.Time = time.Now()
+		return enc.Encode(u) == nil
+	}
+
+	// sync sends the differences between the table and sent. Returns the
+	// number of messages written, or -1 on a write error.
+	sync := func() int {
+		current := make(map[string]bool)
+		n := 0
+		for _, e := range s.routeExportEntries() {
+			key := e.Network + " " + e.Origin.ID
+			current[key] = true
+			data, _ := json.Marshal(e)
+			if prev, ok := sent[key]; ok && bytes.Equal(prev, data) {
+				continue
+			}
+			if !send(RouteExportUpdate{Type: RouteExportAdd, Route: &e}) {
+				return -1
+			}
+			sent[key] = data
+			n++
+		}
+		for key, data := range sent {
+			if current[key] {
+				continue
+			}
+			var e RouteExportEntry
+			_ = json.Unmarshal(data, &e)
+			if !send(RouteExportUpdate{Type: RouteExportWithdraw, Route: &e}) {
+				return -1
+			}
+			delete(sent, key)
+			n++
+		}
+		return n
+	}
+
+	if sync() < 0 || !send(RouteExportUpdate{Type: RouteExportSync}) || rc.Flush() != nil {
+		return
+	}
+
+	ticker := time.NewTicker(eventPingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-sub.done:
+			return
+		case <-ticker.C:
+			n := sync()
+			if n < 0 || (n == 0 && !send(RouteExportUpdate{Type: RouteExportKeepalive})) {
+				return
+			}
+		case <-sub.ch:
+			// Coalesce a burst of events into one comparison
+			for drained := false; !drained; {
+				select {
+				case <-sub.ch:
+				default:
+					drained = true
+				}
+			}
+			sub.dropped.Store(0)
+			if sync() < 0 {
+				return
+			}
+		}
+		if rc.Flush() != nil {
+			return
+		}
+	}
+}
+
+// routeExportEntries returns the CIDR routes of the local table, sorted by
+// network and metric. When the topology is restricted by management key
+// encryption, no routes are returned.
+func (s *Server) routeExportEntries() []RouteExportEntry {
+	entries := []RouteExportEntry{}
+	if s.shouldRestrictTopology() {
+		return entries
+	}
+
+	localID := s.remoteProvider.ID()
+	localName := s.remoteProvider.DisplayName()
+	displayNames := s.remoteProvider.GetAllDisplayNames()
+	transports := make(map[identity.AgentID]string)
+	for _, p := range s.remoteProvider.GetPeerDetails() {
+		transports[p.ID] = p.Transport
+	}
+
+	exportAgent := func(id identity.AgentID) RouteExportAgent {
+		name := displayNames[id]
+		if id == localID {
+			name = localName
+		}
+		return RouteExportAgent{ID: id.String(), ShortID: id.ShortString(), DisplayName: name}
+	}
+
+	for _, route := range s.remoteProvider.GetRouteDetails() {
+		family := "ipv4"
+		if ip, _, err := net.ParseCIDR(route.Network); err == nil && ip.To4() == nil {
+			family = "ipv6"
+		}
+		path := make([]string, len(route.Path))
+		for i, id := range route.Path {
+			path[i] = id.String()
+		}
+		e := RouteExportEntry{
+			Network:     route.Network,
+			Family:      family,
+			Origin:      exportAgent(route.Origin),
+			Metric:      route.Metric,
+			HopCount:    route.HopCount,
+			Path:        path,
+			Local:       route.Origin == localID,
+			Unreachable: route.Unreachable,
+		}
+		if !e.Local && !route.NextHop.IsZero() && route.NextHop != localID {
+			nextHop := exportAgent(route.NextHop)
+			e.NextHop = &nextHop
+			e.Transport = transports[route.NextHop]
+		}
+		entries = append(entries, e)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Network != entries[j].Network {
+			return entries[i].Network < entries[j].Network
+		}
+		return entries[i].Metric < entries[j].Metric
+	})
+	return entries
+}
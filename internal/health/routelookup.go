@@ -0,0 +1,117 @@
+package health
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/postalsys/muti-metroo/internal/identity"
+	"github.com/postalsys/muti-metroo/internal/protocol"
+)
+
+// Route lookup matches.
+const (
+	RouteLookupMatchDomain  = "domain"  // A domain route matched the name
+	RouteLookupMatchCIDR    = "cidr"    // A CIDR route matched the address
+	RouteLookupMatchDefault = "default" // The default route exit resolves the name (remote_dns: always)
+	RouteLookupMatchNone    = "none"    // No route matched
+)
+
+// Route lookup actions: what a connection to the destination would do.
+const (
+	RouteLookupMesh      = "mesh"       // Stream opened through the mesh
+	RouteLookupLocalExit = "local_exit" // This agent is the exit
+	RouteLookupDirect    = "direct"     // Dialed directly without a route
+	RouteLookupReject    = "reject"     // Refused before any stream is opened
+)
+
+// RouteLookupRequest asks which route a destination would take.
+type RouteLookupRequest struct {
+	// Destination is the domain, IP address or host:port to look up.
+	Destination string `json:"destination"`
+
+	// User is the SOCKS5 user to look up for, which selects the namespace
+	// and the exit user policy. Empty for unauthenticated clients.
+	User string `json:"user,omitempty"`
+}
+
+// RouteLookupPath is a mesh path to the destination.
+type RouteLookupPath struct {
+	Exit        RouteExportAgent   `json:"exit"`
+	NextHop     RouteExportAgent   `json:"next_hop"`
+	Metric      int                `json:"metric"`
+	Path        []RouteExportAgent `json:"path,omitempty"` // Agents from the next hop to the exit; empty when encrypted
+	Connected   bool               `json:"connected"`      // Next hop is a connected peer
+	Unreachable bool               `json:"unreachable,omitempty"`
+}
+
+// RouteLookupResult describes what a connection to a destination would do
+// on an agent, without connecting.
+type RouteLookupResult struct {
+	Agent       RouteExportAgent `json:"agent"`
+	Destination string           `json:"destination"`
+	Namespace   string           `json:"namespace,omitempty"`
+	ResolvedIP  string           `json:"resolved_ip,omitempty"` // Set when this agent resolves the name
+
+	// Match is domain, cidr, default or none; Route is the matched domain
+	// pattern or network.
+	Match string `json:"match"`
+	Route string `json:"route,omitempty"`
+
+	// Selected is the path the stream would be opened on, set for mesh
+	// actions. Alternates are the other paths a failed open retries.
+	Selected   *RouteLookupPath  `json:"selected,omitempty"`
+	Alternates []RouteLookupPath `json:"alternates,omitempty"`
+
+	// Action is mesh, local_exit, direct or reject.
+	Action string `json:"action"`
+	Reason string `json:"reason,omitempty"` // Why the destination is rejected
+
+	// Decisions lists the policy decisions in the order they were made.
+	Decisions []string `json:"decisions"`
+}
+
+// RouteLookupProvider looks up the route a destination would take.
+type RouteLookupProvider interface {
+	LookupRoute(req RouteLookupRequest) (*RouteLookupResult, error)
+}
+
+// SetRouteLookupProvider sets the route lookup provider.
+func (s *Server) SetRouteLookupProvider(provider RouteLookupProvider) {
+	s.routeLookupProvider = provider
+}
+
+// handleRouteLookup handles POST /routes/lookup, which reports the route,
+// exit and policy decisions for a destination without opening a
+// connection.
+func (s *Server) handleRouteLookup(w http.ResponseWriter, r *http.Request) {
+	if !requirePOST(w, r) {
+		return
+	}
+	if s.routeLookupProvider == nil {
+		http.Error(w, "route lookup not configured", http.StatusServiceUnavailable)
+		return
+	}
+	if s.shouldRestrictTopology() {
+		http.Error(w, "route lookup restricted: management key decryption unavailable", http.StatusForbidden)
+		return
+	}
+
+	var req RouteLookupRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request: " + err.Error()})
+		return
+	}
+
+	result, err := s.routeLookupProvider.LookupRoute(req)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+// handleRemoteRouteLookup forwards route lookups to a remote agent.
+func (s *Server) handleRemoteRouteLookup(w http.ResponseWriter, r *http.Request, targetID identity.AgentID) {
+	s.forwardRemoteControl(w, r, targetID, protocol.ControlTypeRouteLookup, "route lookup")
+}
@@ -0,0 +1,178 @@
+package health
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// Route watch stream message types.
+const (
+	RouteWatchSnapshot    = "snapshot"    // Selected routes; replaces everything the client holds
+	RouteWatchDiff        = "diff"        // The route selected for a network changed
+	RouteWatchConvergence = "convergence" // Route selection settled after peer events
+	RouteWatchKeepalive   = "keepalive"   // Sent when nothing changed for a while
+)
+
+// Kinds of RouteDiffEvent.
+const (
+	RouteDiffAdded    = "added"
+	RouteDiffRemoved  = "removed"
+	RouteDiffReplaced = "replaced"
+)
+
+// SelectedRoute is the route traffic to a network currently takes.
+type SelectedRoute struct {
+	Network     string            `json:"network"`
+	Origin      RouteExportAgent  `json:"origin"`
+	NextHop     *RouteExportAgent `json:"next_hop,omitempty"` // Nil for local routes
+	Metric      int               `json:"metric"`
+	HopCount    int               `json:"hop_count"`
+	Local       bool              `json:"local"` // Advertised by this agent
+	Unreachable bool              `json:"unreachable,omitempty"`
+}
+
+// RouteDiffEvent is the data of route_diff events.
+type RouteDiffEvent struct {
+	Seq      uint64         `json:"seq"`  // Increases by one per diff
+	Kind     string         `json:"kind"` // "added", "removed" or "replaced"
+	Network  string         `json:"network"`
+	Route    *SelectedRoute `json:"route,omitempty"`    // Selected now; nil when removed
+	Previous *SelectedRoute `json:"previous,omitempty"` // Selected before; nil when added
+	Reason   string         `json:"reason"`             // advertise, withdraw, peer_down, failover, local, api, suspend, resume or refresh
+}
+
+// ConvergenceTrigger is a peer event that starts a convergence timer.
+type ConvergenceTrigger struct {
+	Event string           `json:"event"` // peer_up or peer_down
+	Peer  RouteExportAgent `json:"peer"`
+	Time  time.Time        `json:"time"`
+}
+
+// ConvergenceEvent is the data of convergence events. Peer events that
+// happen before the routes settle share one event.
+type ConvergenceEvent struct {
+	Triggers   []ConvergenceTrigger `json:"triggers"`
+	Changes    int                  `json:"changes"`     // Route diffs since the first trigger
+	DurationMs int64                `json:"duration_ms"` // From the first trigger to the last diff; 0 without diffs
+	Settled    bool                 `json:"settled"`     // False when routes kept changing until the timer gave up
+}
+
+// RouteWatchUpdate is one line of the /api/routes/watch stream.
+type RouteWatchUpdate struct {
+	Type        string            `json:"type"`
+	Time        time.Time         `json:"time"`
+	Routes      []SelectedRoute   `json:"routes,omitempty"` // snapshot
+	Diff        *RouteDiffEvent   `json:"diff,omitempty"`
+	Convergence *ConvergenceEvent `json:"convergence,omitempty"`
+}
+
+// RouteWatchProvider provides the selected routes for /api/routes/watch.
+type RouteWatchProvider interface {
+	// SelectedRoutes returns the selected route of every network, sorted
+	// by network, and the Seq of the last diff they include.
+	SelectedRoutes() ([]SelectedRoute, uint64)
+}
+
+// SetRouteWatchProvider sets the route watch provider.
+func (s *Server) SetRouteWatchProvider(provider RouteWatchProvider) {
+	s.routeWatchProvider = provider
+}
+
+// handleRouteWatch handles GET /api/routes/watch, a newline delimited JSON
+// stream of the route selection: a snapshot of the selected routes, then a
+// diff message whenever the route chosen for a network changes and a
+// convergence message once the selection settles after peers connect or
+// disconnect. A client that falls behind gets a fresh snapshot. When the
+// topology is restricted by management key encryption, the stream carries
+// only keepalives.
+func (s *Server) handleRouteWatch(w http.ResponseWriter, r *http.Request) {
+	if !requireGET(w, r) {
+		return
+	}
+	if s.routeWatchProvider == nil {
+		http.Error(w, "route watch provider not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	restricted := s.shouldRestrictTopology()
+	sub := s.events.subscribe([]string{EventRouteDiff, EventConvergence})
+	if sub == nil {
+		http.Error(w, "server stopping", http.StatusServiceUnavailable)
+		return
+	}
+	defer s.events.unsubscribe(sub)
+
+	// Disable write deadline for the long-lived response
+	rc := http.NewResponseController(w)
+	_ = rc.SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+
+	enc := json.NewEncoder(w)
+	send := func(u RouteWatchUpdate) bool {
+		u.Time = time.Now()
+		return enc.Encode(u) == nil
+	}
+
+	// Diffs up to seq are part of the last snapshot sent. The subscription
+	// is taken first, so diffs made while the snapshot was built are
+	// queued and skipped here.
+	var seq uint64
+	snapshot := func() bool {
+		routes := []SelectedRoute{}
+		if !restricted {
+			routes, seq = s.routeWatchProvider.SelectedRoutes()
+		}
+		return send(RouteWatchUpdate{Type: RouteWatchSnapshot, Routes: routes})
+	}
+
+	if !snapshot() || rc.Flush() != nil {
+		return
+	}
+
+	ticker := time.NewTicker(eventPingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-sub.done:
+			return
+		case <-ticker.C:
+			if !send(RouteWatchUpdate{Type: RouteWatchKeepalive}) {
+				return
+			}
+		case ev := <-sub.ch:
+			if restricted {
+				continue
+			}
+			if sub.dropped.Swap(0) > 0 {
+				// Missed diffs cannot be replayed; start over
+				if !snapshot() {
+					return
+				}
+				break
+			}
+			switch data := ev.Data.(type) {
+			case RouteDiffEvent:
+				if data.Seq <= seq {
+					continue
+				}
+				if !send(RouteWatchUpdate{Type: RouteWatchDiff, Diff: &data}) {
+					return
+				}
+			case ConvergenceEvent:
+				if !send(RouteWatchUpdate{Type: RouteWatchConvergence, Convergence: &data}) {
+					return
+				}
+			}
+		}
+		if rc.Flush() != nil {
+			return
+		}
+	}
+}
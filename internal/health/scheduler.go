@@ -0,0 +1,82 @@
+package health
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/postalsys/muti-metroo/internal/identity"
+	"github.com/postalsys/muti-metroo/internal/protocol"
+	"github.com/postalsys/muti-metroo/internal/scheduler"
+)
+
+// ScheduleManageRequest is a scheduled task operation.
+type ScheduleManageRequest struct {
+	// Action is add, remove, enable, disable, list, history or run.
+	Action string `json:"action"`
+
+	// ID selects the task for every action except add and list.
+	ID string `json:"id,omitempty"`
+
+	// Task is the task to install (add only). ID and CreatedAt are assigned
+	// by the agent.
+	Task *scheduler.Task `json:"task,omitempty"`
+
+	// Password is the shell password (shell tasks) or file transfer
+	// password (file_sync tasks) of the target agent. Checked on add and
+	// not stored.
+	Password string `json:"password,omitempty"`
+}
+
+// ScheduleManageResult contains the response for a scheduled task operation.
+type ScheduleManageResult struct {
+	Status  string                 `json:"status"`
+	Message string                 `json:"message,omitempty"`
+	Task    *scheduler.Task        `json:"task,omitempty"`
+	Tasks   []scheduler.TaskStatus `json:"tasks,omitempty"`
+	History []scheduler.Run        `json:"history,omitempty"`
+}
+
+// ScheduleManageProvider provides scheduled task management.
+type ScheduleManageProvider interface {
+	ManageSchedule(req ScheduleManageRequest) (*ScheduleManageResult, error)
+}
+
+// SetScheduleManageProvider sets the scheduled task management provider.
+func (s *Server) SetScheduleManageProvider(provider ScheduleManageProvider) {
+	s.scheduleManageProvider = provider
+}
+
+// handleScheduleManage handles POST /scheduler/manage for scheduled task
+// management.
+func (s *Server) handleScheduleManage(w http.ResponseWriter, r *http.Request) {
+	if !requirePOST(w, r) {
+		return
+	}
+	if s.scheduleManageProvider == nil {
+		http.Error(w, "scheduler management not configured", http.StatusServiceUnavailable)
+		return
+	}
+	if s.shouldRestrictTopology() {
+		http.Error(w, "scheduler management restricted: management key decryption unavailable", http.StatusForbidden)
+		return
+	}
+
+	var req ScheduleManageRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request: " + err.Error()})
+		return
+	}
+
+	result, err := s.scheduleManageProvider.ManageSchedule(req)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+// handleRemoteScheduleManage forwards scheduled task requests to a remote agent.
+func (s *Server) handleRemoteScheduleManage(w http.ResponseWriter, r *http.Request, targetID identity.AgentID) {
+	s.forwardRemoteControl(w, r, targetID, protocol.ControlTypeScheduleManage, "scheduler management")
+}
@@ -2,13 +2,13 @@
 package health
 
 import (
-	"archive/tar"
 	"bytes"
 	"compress/gzip"
 	"context"
 	"crypto/sha256"
 	"crypto/subtle"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net"
@@ -25,7 +25,9 @@ import (
 	"github.com/postalsys/muti-metroo/internal/crypto"
 	"github.com/postalsys/muti-metroo/internal/filetransfer"
 	"github.com/postalsys/muti-metroo/internal/identity"
+	"github.com/postalsys/muti-metroo/internal/ipfamily"
 	"github.com/postalsys/muti-metroo/internal/protocol"
+	"github.com/postalsys/muti-metroo/internal/rbac"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -139,7 +141,8 @@ type RemoteStatusProvider interface {
 
 	// UploadFile uploads a file or directory to a remote agent via stream-based transfer.
 	// localPath is the local file/directory path, remotePath is the destination on the remote agent.
-	UploadFile(ctx context.Context, targetID identity.AgentID, localPath, remotePath string, opts TransferOptions, progress FileTransferProgress) error
+	// Returns the SHA-256 (hex) of the content the remote agent verified.
+	UploadFile(ctx context.Context, targetID identity.AgentID, localPath, remotePath string, opts TransferOptions, progress FileTransferProgress) (string, error)
 
 	// DownloadFile downloads a file or directory from a remote agent via stream-based transfer.
 	// remotePath is the path on the remote agent, localPath is the local destination.
@@ -160,6 +163,10 @@ type DownloadStreamResult struct {
 	IsDirectory  bool   // True if downloading a directory (tar.gz)
 	Compressed   bool   // True if data is gzip compressed
 	Close        func() // Cleanup function to call when done
+
+	// Checksum returns the SHA-256 (hex) sent by the remote agent once
+	// Reader has returned io.EOF, or "" if none was sent.
+	Checksum func() string
 }
 
 // TransferOptions contains options for file upload/download operations.
@@ -168,6 +175,13 @@ type TransferOptions struct {
 	RateLimit    int64  // Max bytes per second (0 = unlimited)
 	Offset       int64  // Resume from this byte offset (for downloads)
 	OriginalSize int64  // Expected file size for resume validation
+	Length       int64  // Download only this many bytes from Offset (0 = to the end)
+	Route        int    // Index of the path to the target agent (0 = best; wraps around)
+
+	// CopyOptions selects how links and sparse files are handled. The side
+	// that archives a directory applies Symlinks and Hardlinks; the side that
+	// writes files applies Sparse and ExternalSymlinks.
+	CopyOptions filetransfer.CopyOptions
 }
 
 // PeerDetails contains detailed information about a connected peer.
@@ -177,7 +191,16 @@ type PeerDetails struct {
 	State       string
 	RTT         time.Duration
 	IsDialer    bool
-	Transport   string // Transport type: "quic", "h2", "ws"
+	Transport   string // Transport type: "quic", "h2", "ws", "wt", "tcp"
+
+	ProtocolVersion uint16   // Negotiated protocol version
+	Features        []string // Feature flags enabled on the link
+	MaxPayload      int      // Negotiated frame payload limit
+
+	BytesSent     uint64 // Frame bytes sent to the peer
+	BytesRecv     uint64 // Frame bytes received from the peer
+	TxBytesPerSec uint64 // Recent send rate
+	RxBytesPerSec uint64 // Recent receive rate
 }
 
 // RouteDetails contains detailed route information.
@@ -188,6 +211,13 @@ type RouteDetails struct {
 	Metric   int
 	HopCount int
 	Path     []identity.AgentID // Full path from local to origin
+
+	// Unreachable is set when path probes to Origin via NextHop fail
+	Unreachable bool
+
+	// Alternates is the number of loop-free alternate next hops kept for
+	// fast reroute
+	Alternates int
 }
 
 // DomainRouteDetails contains detailed domain route information for the dashboard.
@@ -282,6 +312,26 @@ type ForwardManageProvider interface {
 	ManageForwardListener(action, key, address string, maxConnections int) (*ForwardManageResult, error)
 }
 
+// ForwardEndpointManageResult contains the response for a forward endpoint management operation.
+type ForwardEndpointManageResult struct {
+	Status    string                             `json:"status"`
+	Message   string                             `json:"message,omitempty"`
+	Endpoints []ForwardEndpointManageResultEntry `json:"endpoints,omitempty"`
+}
+
+// ForwardEndpointManageResultEntry describes a single forward endpoint in list output.
+type ForwardEndpointManageResultEntry struct {
+	Key     string `json:"key"`
+	Target  string `json:"target"`
+	Dynamic bool   `json:"dynamic"`
+}
+
+// ForwardEndpointManageProvider provides dynamic forward endpoint management.
+type ForwardEndpointManageProvider interface {
+	// ManageForwardEndpoint handles add/remove/list operations on dynamic forward endpoints.
+	ManageForwardEndpoint(action, key, target string) (*ForwardEndpointManageResult, error)
+}
+
 // FileBrowseProvider provides file browsing (directory listing, stat, roots).
 type FileBrowseProvider interface {
 	BrowseFiles(req *filetransfer.BrowseRequest) *filetransfer.BrowseResponse
@@ -333,6 +383,8 @@ type TopologyAgentInfo struct {
 	ShellEnabled        bool     `json:"shell_enabled,omitempty"`         // Shell access enabled
 	FileTransferEnabled bool     `json:"file_transfer_enabled,omitempty"` // File transfer enabled
 	IcmpEnabled         bool     `json:"icmp_enabled,omitempty"`          // ICMP echo (ping) enabled
+	Tags                []string `json:"tags,omitempty"`                  // Exit tags (for exit)
+	AgentTags           []string `json:"agent_tags,omitempty"`            // Agent tags (agent.tags)
 }
 
 // TopologyConnection represents a connection between two agents.
@@ -342,7 +394,7 @@ type TopologyConnection struct {
 	IsDirect     bool   `json:"is_direct"`
 	RTTMs        int64  `json:"rtt_ms,omitempty"`
 	Unresponsive bool   `json:"unresponsive,omitempty"` // RTT > 60s indicates connection is stuck
-	Transport    string `json:"transport,omitempty"`    // Transport type for direct connections: "quic", "h2", "ws"
+	Transport    string `json:"transport,omitempty"`    // Transport type for direct connections: "quic", "h2", "ws", "wt", "tcp"
 }
 
 // TopologyResponse is the response for the /api/topology endpoint.
@@ -361,6 +413,17 @@ type DashboardPeerInfo struct {
 	RTTMs        int64  `json:"rtt_ms"`
 	Unresponsive bool   `json:"unresponsive,omitempty"` // RTT > 60s indicates connection is stuck
 	IsDialer     bool   `json:"is_dialer"`
+
+	ProtocolVersion uint16   `json:"protocol_version"`
+	Features        []string `json:"features"`
+	MaxPayload      int      `json:"max_payload"`
+
+	BytesSent     uint64 `json:"bytes_sent"`
+	BytesRecv     uint64 `json:"bytes_recv"`
+	TxBytesPerSec uint64 `json:"tx_bytes_per_sec"`
+	RxBytesPerSec uint64 `json:"rx_bytes_per_sec"`
+
+	AgentTags []string `json:"agent_tags,omitempty"` // Agent tags of the peer (agent.tags)
 }
 
 // DashboardRouteInfo contains information about a route.
@@ -374,6 +437,11 @@ type DashboardRouteInfo struct {
 	PathIDs     []string `json:"path_ids"`     // Short IDs for path highlighting
 	TCP         bool     `json:"tcp"`          // TCP support (always true)
 	UDP         bool     `json:"udp"`          // UDP support (exit has UDP enabled)
+	Unreachable bool     `json:"unreachable,omitempty"`
+	Alternates  int      `json:"alternates,omitempty"`  // Loop-free alternate next hops (fast reroute)
+	OriginTags  []string `json:"origin_tags,omitempty"` // Exit tags of the origin (see routing.prefer_tags)
+
+	OriginAgentTags []string `json:"origin_agent_tags,omitempty"` // Agent tags of the origin (agent.tags)
 }
 
 // DashboardDomainRouteInfo contains information about a domain route.
@@ -412,6 +480,8 @@ type DashboardResponse struct {
 	Routes        []DashboardRouteInfo            `json:"routes"`
 	DomainRoutes  []DashboardDomainRouteInfo      `json:"domain_routes,omitempty"`
 	ForwardRoutes []DashboardPortForwardRouteInfo `json:"forward_routes,omitempty"`
+	UDP           *UDPAssociationsResponse        `json:"udp,omitempty"`
+	ICMP          *ICMPStatsResponse              `json:"icmp,omitempty"`
 }
 
 // ServerConfig contains health server configuration.
@@ -419,6 +489,9 @@ type ServerConfig struct {
 	// Address to listen on (e.g., ":8080")
 	Address string
 
+	// Family selects the IP families of the listener (empty = dual-stack)
+	Family ipfamily.Family
+
 	// ReadTimeout for HTTP reads
 	ReadTimeout time.Duration
 
@@ -429,8 +502,12 @@ type ServerConfig struct {
 	// When non-empty, non-exempt endpoints require authentication.
 	TokenHash string
 
+	// Tokens are additional bearer tokens, each granting a role. Requests
+	// beyond a token's role get 403.
+	Tokens []APIToken
+
 	// Endpoint group toggles. Disabled endpoints return 404 with logging.
-	// /health, /healthz, /ready are always enabled.
+	// /health, /healthz, /ready, /livez and /readyz are always enabled.
 
 	// EnablePprof enables the /debug/pprof/* endpoints
 	EnablePprof bool
@@ -465,8 +542,34 @@ type Server struct {
 	sleepProvider         SleepProvider         // For sleep mode endpoints
 	routeManageProvider   RouteManageProvider   // For dynamic route management
 	forwardManageProvider ForwardManageProvider // For dynamic forward listener management
+	forwardEndpointManageProvider ForwardEndpointManageProvider // For dynamic forward endpoint management
+	dnsCacheManageProvider        DNSCacheManageProvider        // For exit DNS cache stats and flush
+	exitDestManageProvider        ExitDestManageProvider        // For exit per-destination stats and unblock
+	idleManageProvider            IdleManageProvider            // For idle stream list and forced close
+	scheduleManageProvider        ScheduleManageProvider        // For scheduled task management
+	updateManageProvider          UpdateManageProvider          // For agent binary self-update
+	chaosManageProvider           ChaosManageProvider           // For peer link fault injection
+	debugCaptureProvider          DebugCaptureProvider          // For TLS key log and QUIC qlog capture
+	managementKeyProvider         ManagementKeyProvider         // For management key rotation
+	socks5UsersManageProvider     SOCKS5UsersManageProvider     // For SOCKS5 user quota usage and reset
+	blocklistManageProvider       BlocklistManageProvider       // For SOCKS5 destination blocklist status and checks
+	usageProvider                 UsageProvider                 // For bandwidth usage accounting
+	crashManageProvider           CrashManageProvider           // For crash report listing and retrieval
+	routeDampeningProvider        RouteDampeningProvider        // For suppressed route origins and rate limited peers
+	routeLookupProvider           RouteLookupProvider           // For route lookups without connecting
+	routeWatchProvider            RouteWatchProvider            // For the live route selection diff stream
+	systemMetricsProvider         SystemMetricsProvider         // For live host resource metrics
 	fileBrowseProvider       FileBrowseProvider       // For file browsing (list, stat, roots)
 	displayNameManageProvider DisplayNameManageProvider // For dynamic display name management
+	streamProvider           StreamProvider           // For stream listing and kill
+	udpProvider              UDPProvider              // For UDP association statistics
+	icmpStatsProvider        ICMPStatsProvider        // For exit-side ICMP counters
+	acceptStatsProvider      AcceptStatsProvider      // For listener accept counters
+	halfCloseStatsProvider   HalfCloseStatsProvider   // For exit half-close counters
+	exitHealthProvider       ExitHealthProvider       // For the exit self-health check
+	portLimitStatsProvider   PortLimitStatsProvider   // For exit port class counters
+	readinessProvider        ReadinessProvider        // For the /readyz criteria
+	events                   eventHub                 // Subscribers of the /events stream
 	sealedBox                *crypto.SealedBox        // For checking decrypt capability
 	meshTestState         *MeshTestState        // For mesh test caching
 	server                *http.Server
@@ -477,6 +580,7 @@ type Server struct {
 	tokenCacheMu    sync.RWMutex
 	cachedTokenSHA  [32]byte // SHA-256 of last validated token
 	tokenCacheValid bool
+	roleTokenCache  map[[32]byte]rbac.Role // SHA-256 of validated role tokens
 }
 
 // disabledHandler returns a handler that returns 404 for disabled endpoints.
@@ -492,11 +596,15 @@ var authExemptPaths = map[string]bool{
 	"/health":  true,
 	"/healthz": true,
 	"/ready":   true,
+	"/livez":   true,
+	"/readyz":  true,
 	"/":        true,
 	"/logo.png": true,
 }
 
-// requireAuth returns middleware that enforces bearer token authentication.
+// requireAuth returns middleware that enforces bearer token authentication
+// and the role of the token. The role is attached to the request context so
+// control requests sent on its behalf carry it.
 // Exempt paths (health probes, splash) bypass authentication.
 func (s *Server) requireAuth(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -505,14 +613,19 @@ func (s *Server) requireAuth(next http.Handler) http.Handler {
 			return
 		}
 
-		token := extractBearerToken(r)
-		if token == "" || !s.validateToken(token) {
+		role, ok := s.authenticate(extractBearerToken(r))
+		if !ok {
 			w.Header().Set("WWW-Authenticate", `Bearer realm="muti-metroo"`)
 			http.Error(w, "unauthorized", http.StatusUnauthorized)
 			return
 		}
 
-		next.ServeHTTP(w, r)
+		if required := requiredRole(r); !role.Allows(required) {
+			http.Error(w, "forbidden: "+required.String()+" role required", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(rbac.WithRole(r.Context(), role)))
 	})
 }
 
@@ -613,6 +726,12 @@ func populateNodeInfo(agent *TopologyAgentInfo, nodeInfo *protocol.NodeInfo) {
 	if len(nodeInfo.Shells) > 0 {
 		agent.Shells = nodeInfo.Shells
 	}
+	if len(nodeInfo.Tags) > 0 {
+		agent.Tags = nodeInfo.Tags
+	}
+	if len(nodeInfo.AgentTags) > 0 {
+		agent.AgentTags = nodeInfo.AgentTags
+	}
 	if !agent.IsLocal {
 		if nodeInfo.ShellEnabled {
 			agent.ShellEnabled = true
@@ -670,6 +789,8 @@ func NewServer(cfg ServerConfig, provider StatsProvider) *Server {
 	mux.HandleFunc("/health", s.handleHealth)
 	mux.HandleFunc("/healthz", s.handleHealthz)
 	mux.HandleFunc("/ready", s.handleReady)
+	mux.HandleFunc("/livez", s.handleLivez)
+	mux.HandleFunc("/readyz", s.handleReadyz)
 
 	// Remote API endpoints: /agents, /agents/*, /routes/advertise, /sleep/*
 	if cfg.EnableRemoteAPI {
@@ -677,8 +798,24 @@ func NewServer(cfg ServerConfig, provider StatsProvider) *Server {
 		mux.HandleFunc("/agents/", s.handleAgentInfo)
 		mux.HandleFunc("/routes/advertise", s.handleTriggerAdvertise)
 		mux.HandleFunc("/routes/manage", s.handleRouteManage)
+		mux.HandleFunc("/routes/dampening", s.handleRouteDampening)
+		mux.HandleFunc("/routes/lookup", s.handleRouteLookup)
 		mux.HandleFunc("/forward/manage", s.handleForwardManage)
+		mux.HandleFunc("/forward/endpoint/manage", s.handleForwardEndpointManage)
 		mux.HandleFunc("/display-name/manage", s.handleDisplayNameManage)
+		mux.HandleFunc("/dns-cache/manage", s.handleDNSCacheManage)
+		mux.HandleFunc("/exit-destinations/manage", s.handleExitDestManage)
+		mux.HandleFunc("/idle/manage", s.handleIdleManage)
+		mux.HandleFunc("/scheduler/manage", s.handleScheduleManage)
+		mux.HandleFunc("/update/manage", s.handleUpdateManage)
+		mux.HandleFunc("/chaos/manage", s.handleChaosManage)
+		mux.HandleFunc("/debug-capture/manage", s.handleDebugCapture)
+		mux.HandleFunc("/management-key/manage", s.handleManagementKey)
+		mux.HandleFunc("/socks5-users/manage", s.handleSOCKS5UsersManage)
+		mux.HandleFunc("/blocklist/manage", s.handleBlocklistManage)
+		mux.HandleFunc("/crashes/manage", s.handleCrashManage)
+		mux.HandleFunc("/usage", s.handleUsage)
+		mux.HandleFunc("/system", s.handleSystemMetrics)
 		// Sleep mode endpoints
 		mux.HandleFunc("/sleep", s.handleSleep)
 		mux.HandleFunc("/sleep/status", s.handleSleepStatus)
@@ -688,8 +825,24 @@ func NewServer(cfg ServerConfig, provider StatsProvider) *Server {
 		mux.HandleFunc("/agents/", disabledHandler("agents"))
 		mux.HandleFunc("/routes/advertise", disabledHandler("routes_advertise"))
 		mux.HandleFunc("/routes/manage", disabledHandler("routes_manage"))
+		mux.HandleFunc("/routes/dampening", disabledHandler("routes_dampening"))
+		mux.HandleFunc("/routes/lookup", disabledHandler("routes_lookup"))
 		mux.HandleFunc("/forward/manage", disabledHandler("forward_manage"))
+		mux.HandleFunc("/forward/endpoint/manage", disabledHandler("forward_endpoint_manage"))
 		mux.HandleFunc("/display-name/manage", disabledHandler("display_name_manage"))
+		mux.HandleFunc("/dns-cache/manage", disabledHandler("dns_cache_manage"))
+		mux.HandleFunc("/exit-destinations/manage", disabledHandler("exit_destinations_manage"))
+		mux.HandleFunc("/idle/manage", disabledHandler("idle_manage"))
+		mux.HandleFunc("/scheduler/manage", disabledHandler("scheduler_manage"))
+		mux.HandleFunc("/update/manage", disabledHandler("update_manage"))
+		mux.HandleFunc("/chaos/manage", disabledHandler("chaos_manage"))
+		mux.HandleFunc("/debug-capture/manage", disabledHandler("debug_capture_manage"))
+		mux.HandleFunc("/management-key/manage", disabledHandler("management_key_manage"))
+		mux.HandleFunc("/socks5-users/manage", disabledHandler("socks5_users_manage"))
+		mux.HandleFunc("/blocklist/manage", disabledHandler("blocklist_manage"))
+		mux.HandleFunc("/crashes/manage", disabledHandler("crashes_manage"))
+		mux.HandleFunc("/usage", disabledHandler("usage"))
+		mux.HandleFunc("/system", disabledHandler("system"))
 		mux.HandleFunc("/sleep", disabledHandler("sleep"))
 		mux.HandleFunc("/sleep/status", disabledHandler("sleep_status"))
 		mux.HandleFunc("/wake", disabledHandler("wake"))
@@ -698,11 +851,26 @@ func NewServer(cfg ServerConfig, provider StatsProvider) *Server {
 	// Dashboard API endpoints
 	if cfg.EnableDashboard {
 		mux.HandleFunc("/api/topology", s.handleTopology)
+		mux.HandleFunc("/api/topology/graph", s.handleTopologyGraph)
 		mux.HandleFunc("/api/dashboard", s.handleDashboard)
 		mux.HandleFunc("/api/nodes", s.handleNodes)
+		mux.HandleFunc("/api/routes", s.handleRoutes)
+		mux.HandleFunc("/api/peers", s.handlePeers)
 		mux.HandleFunc("/api/mesh-test", s.handleMeshTest)
+		mux.HandleFunc("/api/streams", s.handleStreams)
+		mux.HandleFunc("/api/streams/kill", s.handleStreamKill)
+		mux.HandleFunc("/api/udp", s.handleUDPAssociations)
+		mux.HandleFunc("/api/icmp", s.handleICMPStats)
+		mux.HandleFunc("/api/accept", s.handleAcceptStats)
+		mux.HandleFunc("/api/half-close", s.handleHalfCloseStats)
+		mux.HandleFunc("/api/exit-health", s.handleExitHealth)
+		mux.HandleFunc("/api/port-limits", s.handlePortLimitStats)
+		mux.HandleFunc("/api/routes/export", s.handleRouteExport)
+		mux.HandleFunc("/api/routes/watch", s.handleRouteWatch)
+		mux.HandleFunc("/events", s.handleEvents)
 	} else {
 		mux.HandleFunc("/api/", disabledHandler("dashboard_api"))
+		mux.HandleFunc("/events", disabledHandler("events"))
 	}
 
 	// pprof debug endpoints
@@ -722,9 +890,9 @@ func NewServer(cfg ServerConfig, provider StatsProvider) *Server {
 	// Root splash page
 	mux.HandleFunc("/", s.handleSplash)
 
-	// Wrap with auth middleware if token_hash is configured
+	// Wrap with auth middleware if token_hash or role tokens are configured
 	var handler http.Handler = mux
-	if cfg.TokenHash != "" {
+	if cfg.TokenHash != "" || len(cfg.Tokens) > 0 {
 		handler = s.requireAuth(mux)
 	}
 
@@ -774,6 +942,12 @@ func (s *Server) SetForwardManageProvider(provider ForwardManageProvider) {
 	s.forwardManageProvider = provider
 }
 
+// SetForwardEndpointManageProvider sets the forward endpoint management provider.
+// This is called after the agent is initialized.
+func (s *Server) SetForwardEndpointManageProvider(provider ForwardEndpointManageProvider) {
+	s.forwardEndpointManageProvider = provider
+}
+
 // SetFileBrowseProvider sets the file browse provider.
 // This is called after the agent is initialized.
 func (s *Server) SetFileBrowseProvider(provider FileBrowseProvider) {
@@ -786,6 +960,18 @@ func (s *Server) SetDisplayNameManageProvider(provider DisplayNameManageProvider
 	s.displayNameManageProvider = provider
 }
 
+// SetStreamProvider sets the stream inspection provider.
+// This is called after the agent is initialized.
+func (s *Server) SetStreamProvider(provider StreamProvider) {
+	s.streamProvider = provider
+}
+
+// SetUDPProvider sets the UDP association statistics provider.
+// This is called after the agent is initialized.
+func (s *Server) SetUDPProvider(provider UDPProvider) {
+	s.udpProvider = provider
+}
+
 // CanDecryptManagement returns true if management key decryption is available.
 func (s *Server) CanDecryptManagement() bool {
 	return s.sealedBox != nil && s.sealedBox.CanDecrypt()
@@ -804,7 +990,7 @@ func (s *Server) shouldRestrictTopology() bool {
 
 // Start starts the health check server.
 func (s *Server) Start() error {
-	ln, err := net.Listen("tcp", s.cfg.Address)
+	ln, err := s.cfg.Family.Listen(s.cfg.Address)
 	if err != nil {
 		return err
 	}
@@ -822,6 +1008,8 @@ func (s *Server) Stop() error {
 		return nil
 	}
 
+	s.events.close()
+
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
@@ -980,7 +1168,7 @@ func (s *Server) handleAgentInfo(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Parse path: /agents/{agent-id}[/routes|/peers|/shell|/file/*]
+	// Parse path: /agents/{agent-id}[/routes|/peers|/streams|/usage|/system|/routes/dampening|/routes/lookup|/shell|/file/*]
 	path := strings.TrimPrefix(r.URL.Path, "/agents/")
 	parts := strings.SplitN(path, "/", 2)
 	if len(parts) == 0 || parts[0] == "" {
@@ -1012,12 +1200,51 @@ func (s *Server) handleAgentInfo(w http.ResponseWriter, r *http.Request) {
 		case parts[1] == "routes/manage":
 			s.handleRemoteRouteManage(w, r, targetID)
 			return
+		case parts[1] == "routes/lookup":
+			s.handleRemoteRouteLookup(w, r, targetID)
+			return
 		case parts[1] == "forward/manage":
 			s.handleRemoteForwardManage(w, r, targetID)
 			return
+		case parts[1] == "forward/endpoint/manage":
+			s.handleRemoteForwardEndpointManage(w, r, targetID)
+			return
 		case parts[1] == "display-name/manage":
 			s.handleRemoteDisplayNameManage(w, r, targetID)
 			return
+		case parts[1] == "dns-cache/manage":
+			s.handleRemoteDNSCacheManage(w, r, targetID)
+			return
+		case parts[1] == "exit-destinations/manage":
+			s.handleRemoteExitDestManage(w, r, targetID)
+			return
+		case parts[1] == "idle/manage":
+			s.handleRemoteIdleManage(w, r, targetID)
+			return
+		case parts[1] == "scheduler/manage":
+			s.handleRemoteScheduleManage(w, r, targetID)
+			return
+		case parts[1] == "update/manage":
+			s.handleRemoteUpdateManage(w, r, targetID)
+			return
+		case parts[1] == "chaos/manage":
+			s.handleRemoteChaosManage(w, r, targetID)
+			return
+		case parts[1] == "debug-capture/manage":
+			s.handleRemoteDebugCapture(w, r, targetID)
+			return
+		case parts[1] == "management-key/manage":
+			s.handleRemoteManagementKey(w, r, targetID)
+			return
+		case parts[1] == "socks5-users/manage":
+			s.handleRemoteSOCKS5UsersManage(w, r, targetID)
+			return
+		case parts[1] == "blocklist/manage":
+			s.handleRemoteBlocklistManage(w, r, targetID)
+			return
+		case parts[1] == "crashes/manage":
+			s.handleRemoteCrashManage(w, r, targetID)
+			return
 		case parts[1] == "file/browse":
 			s.handleFileBrowse(w, r, targetID)
 			return
@@ -1044,6 +1271,16 @@ func (s *Server) handleAgentInfo(w http.ResponseWriter, r *http.Request) {
 			controlType = protocol.ControlTypeRoutes
 		case "peers":
 			controlType = protocol.ControlTypePeers
+		case "udp":
+			controlType = protocol.ControlTypeUDPStats
+		case "streams":
+			controlType = protocol.ControlTypeStreams
+		case "usage":
+			controlType = protocol.ControlTypeUsage
+		case "routes/dampening":
+			controlType = protocol.ControlTypeRouteDampening
+		case "system":
+			controlType = protocol.ControlTypeSystemMetrics
 		}
 	}
 
@@ -1129,13 +1366,26 @@ func (s *Server) handleFileUpload(w http.ResponseWriter, r *http.Request, target
 	defer os.Remove(tmpPath) // Clean up temp file
 
 	// Copy uploaded file to temp
-	bytesReceived, err := io.Copy(tmpFile, file)
+	sum := filetransfer.NewChecksum()
+	bytesReceived, err := io.Copy(io.MultiWriter(tmpFile, sum), file)
 	tmpFile.Close()
 	if err != nil {
 		http.Error(w, "failed to save uploaded file: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	// Clients may send the SHA-256 of the file after the file part
+	if expected := r.FormValue("checksum"); expected != "" && !isDirectory {
+		if err := filetransfer.VerifyChecksum(expected, filetransfer.ChecksumHex(sum)); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]interface{}{
+				"success":    false,
+				"error":      err.Error(),
+				"error_code": protocol.ErrorCodeName(protocol.ErrChecksumMismatch),
+			})
+			return
+		}
+	}
+
 	// For directories, extract the tar first
 	localPath := tmpPath
 	if isDirectory {
@@ -1164,11 +1414,16 @@ func (s *Server) handleFileUpload(w http.ResponseWriter, r *http.Request, target
 		localPath = tmpDir
 	}
 
-	// Build transfer options
+	// Build transfer options. Clients apply the symlink and hardlink
+	// policies when they build the archive.
 	opts := TransferOptions{
 		Password:     password,
 		RateLimit:    rateLimit,
 		OriginalSize: originalSize,
+		CopyOptions: filetransfer.CopyOptions{
+			Sparse:           r.FormValue("sparse") == "true",
+			ExternalSymlinks: r.FormValue("external_symlinks") == "true",
+		},
 	}
 
 	// Perform stream-based upload
@@ -1179,12 +1434,18 @@ func (s *Server) handleFileUpload(w http.ResponseWriter, r *http.Request, target
 	rc := http.NewResponseController(w)
 	_ = rc.SetWriteDeadline(time.Now().Add(30 * time.Minute))
 
-	err = s.remoteProvider.UploadFile(ctx, targetID, localPath, remotePath, opts, nil)
+	progress, finish := s.fileTransferEvents("upload", targetID.ShortString(), remotePath)
+	checksum, err := s.remoteProvider.UploadFile(ctx, targetID, localPath, remotePath, opts, progress)
+	finish(err)
 	if err != nil {
-		writeJSON(w, http.StatusBadGateway, map[string]interface{}{
+		resp := map[string]interface{}{
 			"success": false,
 			"error":   err.Error(),
-		})
+		}
+		if errors.Is(err, filetransfer.ErrChecksumMismatch) {
+			resp["error_code"] = protocol.ErrorCodeName(protocol.ErrChecksumMismatch)
+		}
+		writeJSON(w, http.StatusBadGateway, resp)
 		return
 	}
 
@@ -1193,6 +1454,7 @@ func (s *Server) handleFileUpload(w http.ResponseWriter, r *http.Request, target
 		"bytes_written": bytesReceived,
 		"filename":      header.Filename,
 		"remote_path":   remotePath,
+		"checksum":      checksum,
 	})
 }
 
@@ -1219,6 +1481,10 @@ func (s *Server) handleFileDownload(w http.ResponseWriter, r *http.Request, targ
 		RateLimit    int64  `json:"rate_limit,omitempty"`
 		Offset       int64  `json:"offset,omitempty"`
 		OriginalSize int64  `json:"original_size,omitempty"`
+		Length       int64  `json:"length,omitempty"`
+		Route        int    `json:"route,omitempty"`
+		Symlinks     string `json:"symlinks,omitempty"`
+		Hardlinks    string `json:"hardlinks,omitempty"`
 	}
 	if err := json.Unmarshal(body, &req); err != nil {
 		http.Error(w, "invalid JSON: "+err.Error(), http.StatusBadRequest)
@@ -1229,6 +1495,10 @@ func (s *Server) handleFileDownload(w http.ResponseWriter, r *http.Request, targ
 		http.Error(w, "missing required field: path", http.StatusBadRequest)
 		return
 	}
+	if req.Offset < 0 || req.Length < 0 || req.Route < 0 {
+		http.Error(w, "offset, length and route must not be negative", http.StatusBadRequest)
+		return
+	}
 
 	// Use the basename of the remote path as local filename
 	localName := filepath.Base(req.Path)
@@ -1242,6 +1512,16 @@ func (s *Server) handleFileDownload(w http.ResponseWriter, r *http.Request, targ
 		RateLimit:    req.RateLimit,
 		Offset:       req.Offset,
 		OriginalSize: req.OriginalSize,
+		Length:       req.Length,
+		Route:        req.Route,
+		CopyOptions: filetransfer.CopyOptions{
+			Symlinks:  req.Symlinks,
+			Hardlinks: req.Hardlinks,
+		},
+	}
+	if err := opts.CopyOptions.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
 	// Perform streaming download directly (no temp file)
@@ -1252,8 +1532,10 @@ func (s *Server) handleFileDownload(w http.ResponseWriter, r *http.Request, targ
 	rc := http.NewResponseController(w)
 	_ = rc.SetWriteDeadline(time.Now().Add(30 * time.Minute))
 
+	progress, finish := s.fileTransferEvents("download", targetID.ShortString(), req.Path)
 	result, err := s.remoteProvider.DownloadFileStream(ctx, targetID, req.Path, opts)
 	if err != nil {
+		finish(err)
 		writeJSON(w, http.StatusBadGateway, map[string]interface{}{
 			"success": false,
 			"error":   err.Error(),
@@ -1279,14 +1561,20 @@ func (s *Server) handleFileDownload(w http.ResponseWriter, r *http.Request, targ
 		// Note: We don't set Content-Length because the stream is decompressed
 		// and we don't know the final size until we've read it all
 	}
+	// The checksum is only known at the end of the stream
+	w.Header().Set("Trailer", "X-Checksum-Sha256")
 
 	// Stream data directly to response
-	_, err = io.Copy(w, result.Reader)
+	_, err = io.Copy(w, &progressReader{Reader: result.Reader, total: result.Size, progress: progress})
+	finish(err)
 	if err != nil {
 		// Can't return error via HTTP at this point since we already started streaming
 		// The connection will just be broken
 		return
 	}
+	if result.Checksum != nil {
+		w.Header().Set("X-Checksum-Sha256", result.Checksum())
+	}
 }
 
 // extractTarWithFallback tries to extract a tar archive, handling both plain tar and gzip.
@@ -1316,55 +1604,12 @@ func extractTarWithFallback(r io.Reader, destDir string) error {
 		reader = gzr
 	}
 
-	// Create tar reader
-	tr := tar.NewReader(reader)
-
-	for {
-		header, err := tr.Next()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return fmt.Errorf("failed to read tar header: %w", err)
-		}
-
-		targetPath := filepath.Join(destDir, header.Name)
-
-		// Security check
-		if !strings.HasPrefix(filepath.Clean(targetPath), filepath.Clean(destDir)) {
-			return fmt.Errorf("tar entry attempts path traversal: %s", header.Name)
-		}
-
-		switch header.Typeflag {
-		case tar.TypeDir:
-			if err := os.MkdirAll(targetPath, os.FileMode(header.Mode)); err != nil {
-				return err
-			}
-		case tar.TypeReg:
-			if err := os.MkdirAll(filepath.Dir(targetPath), 0755); err != nil {
-				return err
-			}
-			f, err := os.OpenFile(targetPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(header.Mode))
-			if err != nil {
-				return err
-			}
-			if _, err := io.Copy(f, tr); err != nil {
-				f.Close()
-				return err
-			}
-			f.Close()
-		case tar.TypeSymlink:
-			if err := os.MkdirAll(filepath.Dir(targetPath), 0755); err != nil {
-				return err
-			}
-			os.Remove(targetPath)
-			if err := os.Symlink(header.Linkname, targetPath); err != nil {
-				return err
-			}
-		}
-	}
-
-	return nil
+	// The archive is sent on from the staging directory, so keep external
+	// symlinks for the receiving agent to judge and do not fill in holes
+	return filetransfer.ExtractTar(reader, destDir, filetransfer.CopyOptions{
+		Sparse:           true,
+		ExternalSymlinks: true,
+	})
 }
 
 // handleTriggerAdvertise handles POST /routes/advertise to trigger immediate route advertisement.
@@ -1502,6 +1747,44 @@ func (s *Server) handleRemoteForwardManage(w http.ResponseWriter, r *http.Reques
 	s.forwardRemoteControl(w, r, targetID, protocol.ControlTypeForwardManage, "forward management")
 }
 
+// handleForwardEndpointManage handles POST /forward/endpoint/manage to add/remove/list dynamic forward endpoints.
+func (s *Server) handleForwardEndpointManage(w http.ResponseWriter, r *http.Request) {
+	if !requirePOST(w, r) {
+		return
+	}
+	if s.forwardEndpointManageProvider == nil {
+		http.Error(w, "forward endpoint management not configured", http.StatusServiceUnavailable)
+		return
+	}
+	if s.shouldRestrictTopology() {
+		http.Error(w, "forward endpoint management restricted: management key decryption unavailable", http.StatusForbidden)
+		return
+	}
+
+	var req struct {
+		Action string `json:"action"`
+		Key    string `json:"key"`
+		Target string `json:"target"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request: " + err.Error()})
+		return
+	}
+
+	result, err := s.forwardEndpointManageProvider.ManageForwardEndpoint(req.Action, req.Key, req.Target)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+// handleRemoteForwardEndpointManage forwards forward endpoint management requests to a remote agent.
+func (s *Server) handleRemoteForwardEndpointManage(w http.ResponseWriter, r *http.Request, targetID identity.AgentID) {
+	s.forwardRemoteControl(w, r, targetID, protocol.ControlTypeForwardEndpointManage, "forward endpoint management")
+}
+
 // handleDisplayNameManage handles POST /display-name/manage to set/get the agent display name.
 func (s *Server) handleDisplayNameManage(w http.ResponseWriter, r *http.Request) {
 	if !requirePOST(w, r) {
@@ -1645,6 +1928,38 @@ func (s *Server) handleTopology(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	topology := s.buildTopology()
+	if tags := parseTags(r.URL.Query()); len(tags) > 0 {
+		topology = filterTopology(topology, tags)
+	}
+	writeJSON(w, http.StatusOK, topology)
+}
+
+// filterTopology keeps the agents that have all of tags and the connections
+// between them. The local agent is always kept as the anchor of the map.
+func filterTopology(topology TopologyResponse, tags []string) TopologyResponse {
+	kept := make(map[string]bool)
+	agents := make([]TopologyAgentInfo, 0, len(topology.Agents))
+	for _, agent := range topology.Agents {
+		if agent.IsLocal || hasTags(agent.AgentTags, tags) {
+			kept[agent.ShortID] = true
+			agents = append(agents, agent)
+		}
+	}
+	connections := make([]TopologyConnection, 0, len(topology.Connections))
+	for _, conn := range topology.Connections {
+		if kept[conn.FromAgent] && kept[conn.ToAgent] {
+			connections = append(connections, conn)
+		}
+	}
+	topology.Agents = agents
+	topology.Connections = connections
+	return topology
+}
+
+// buildTopology assembles all agents known to this agent, from peers, route
+// paths and node info, and the connections between them.
+func (s *Server) buildTopology() TopologyResponse {
 	localID := s.remoteProvider.ID()
 	localName := s.remoteProvider.DisplayName()
 
@@ -1664,12 +1979,11 @@ func (s *Server) handleTopology(w http.ResponseWriter, r *http.Request) {
 	// If management key encryption is enabled but we can't decrypt,
 	// only return local agent info (no peers, routes, or other agents)
 	if s.shouldRestrictTopology() {
-		writeJSON(w, http.StatusOK, TopologyResponse{
+		return TopologyResponse{
 			LocalAgent:  localAgent,
 			Agents:      []TopologyAgentInfo{localAgent},
 			Connections: []TopologyConnection{},
-		})
-		return
+		}
 	}
 
 	// Get all known display names from route advertisements
@@ -1907,11 +2221,11 @@ func (s *Server) handleTopology(w http.ResponseWriter, r *http.Request) {
 		connections = append(connections, conn)
 	}
 
-	writeJSON(w, http.StatusOK, TopologyResponse{
+	return TopologyResponse{
 		LocalAgent:  localAgent,
 		Agents:      agents,
 		Connections: connections,
-	})
+	}
 }
 
 // buildAgentRoles constructs the roles array based on agent capabilities.
@@ -1971,99 +2285,18 @@ func (s *Server) handleDashboard(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Build peer info
-	peers := make([]DashboardPeerInfo, 0, len(s.remoteProvider.GetPeerDetails()))
-	for _, peer := range s.remoteProvider.GetPeerDetails() {
-		peers = append(peers, DashboardPeerInfo{
-			ID:           peer.ID.String(),
-			ShortID:      peer.ID.ShortString(),
-			DisplayName:  peer.DisplayName,
-			State:        peer.State,
-			RTTMs:        peer.RTT.Milliseconds(),
-			Unresponsive: peer.RTT.Seconds() > 60,
-			IsDialer:     peer.IsDialer,
-		})
-	}
-
-	// Get display names for building path display
-	displayNames := s.remoteProvider.GetAllDisplayNames()
-	allNodeInfo := s.remoteProvider.GetAllNodeInfo()
-
-	// Helper to get display name or fall back to short ID
-	getDisplayName := func(id identity.AgentID) string {
-		if id == localID {
-			return localName
-		}
-		if name, ok := displayNames[id]; ok && name != "" {
-			return name
-		}
-		return id.ShortString()
-	}
-
-	// Helper to get UDP info for an agent
-	getUDPEnabled := func(id identity.AgentID) bool {
-		info, ok := allNodeInfo[id]
-		return ok && info.UDPEnabled
-	}
-
-	// Helper to build path display and IDs from agent path
-	buildPath := func(path []identity.AgentID) (pathDisplay, pathIDs []string) {
-		pathDisplay = []string{localName}
-		pathIDs = []string{localID.ShortString()}
-		for _, agentID := range path {
-			pathDisplay = append(pathDisplay, getDisplayName(agentID))
-			pathIDs = append(pathIDs, agentID.ShortString())
-		}
-		return pathDisplay, pathIDs
-	}
+	namer := s.newAgentNamer()
+	peers := s.dashboardPeers(namer)
+	getDisplayName := namer.name
+	getUDPEnabled := namer.udpEnabled
+	buildPath := namer.path
+	allNodeInfo := namer.nodeInfo
 
 	// Build route info (CIDR and domain routes)
-	routeDetails := s.remoteProvider.GetRouteDetails()
-	domainRouteDetails := s.remoteProvider.GetDomainRouteDetails()
-	routes := make([]DashboardRouteInfo, 0, len(routeDetails)+len(domainRouteDetails))
-
-	for _, route := range routeDetails {
-		pathDisplay, pathIDs := buildPath(route.Path)
-		routes = append(routes, DashboardRouteInfo{
-			Network:     route.Network,
-			RouteType:   "cidr",
-			Origin:      getDisplayName(route.Origin),
-			OriginID:    route.Origin.ShortString(),
-			HopCount:    route.HopCount,
-			PathDisplay: pathDisplay,
-			PathIDs:     pathIDs,
-			TCP:         true,
-			UDP:         getUDPEnabled(route.Origin),
-		})
-	}
-
-	for _, route := range domainRouteDetails {
-		pathDisplay, pathIDs := buildPath(route.Path)
-		routes = append(routes, DashboardRouteInfo{
-			Network:     route.Pattern,
-			RouteType:   "domain",
-			Origin:      getDisplayName(route.Origin),
-			OriginID:    route.Origin.ShortString(),
-			HopCount:    route.HopCount,
-			PathDisplay: pathDisplay,
-			PathIDs:     pathIDs,
-			TCP:         true,
-			UDP:         getUDPEnabled(route.Origin),
-		})
-	}
-
-	// Sort routes: CIDR first (by network), then domains (by pattern)
-	sort.Slice(routes, func(i, j int) bool {
-		if routes[i].RouteType != routes[j].RouteType {
-			return routes[i].RouteType == "cidr"
-		}
-		if routes[i].Network != routes[j].Network {
-			return routes[i].Network < routes[j].Network
-		}
-		return routes[i].OriginID < routes[j].OriginID
-	})
+	routes := s.dashboardRoutes(namer)
 
 	// Build legacy domain routes for backward compatibility
+	domainRouteDetails := s.remoteProvider.GetDomainRouteDetails()
 	domainRoutes := make([]DashboardDomainRouteInfo, 0, len(domainRouteDetails))
 	for _, route := range domainRouteDetails {
 		pathDisplay, pathIDs := buildPath(route.Path)
@@ -2218,6 +2451,22 @@ func (s *Server) handleDashboard(w http.ResponseWriter, r *http.Request) {
 		return forwardRoutes[i].ExitAgentID < forwardRoutes[j].ExitAgentID
 	})
 
+	// Include UDP association stats when this agent relays UDP
+	var udpStats *UDPAssociationsResponse
+	if s.udpProvider != nil {
+		if resp := s.udpProvider.UDPAssociations(); resp.Enabled {
+			udpStats = &resp
+		}
+	}
+
+	// Include ICMP counters when this agent answers pings
+	var icmpStats *ICMPStatsResponse
+	if s.icmpStatsProvider != nil {
+		if resp := s.icmpStatsProvider.ICMPStats(); resp.Enabled {
+			icmpStats = &resp
+		}
+	}
+
 	writeJSON(w, http.StatusOK, DashboardResponse{
 		Agent:         localAgentInfo,
 		Stats:         stats,
@@ -2225,15 +2474,19 @@ func (s *Server) handleDashboard(w http.ResponseWriter, r *http.Request) {
 		Routes:        routes,
 		DomainRoutes:  domainRoutes,
 		ForwardRoutes: forwardRoutes,
+		UDP:           udpStats,
+		ICMP:          icmpStats,
 	})
 }
 
 // NodesResponse is the response for the /api/nodes endpoint.
 type NodesResponse struct {
+	ListPage
 	Nodes []TopologyAgentInfo `json:"nodes"`
 }
 
-// handleNodes handles GET /api/nodes for detailed node info listing.
+// handleNodes handles GET /api/nodes for detailed node info listing, with
+// filtering, sorting and pagination.
 func (s *Server) handleNodes(w http.ResponseWriter, r *http.Request) {
 	if !requireGET(w, r) {
 		return
@@ -2242,6 +2495,11 @@ func (s *Server) handleNodes(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "provider not configured", http.StatusServiceUnavailable)
 		return
 	}
+	q, err := parseListQuery(r, nodeSortKeys)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	localID := s.remoteProvider.ID()
 	localName := s.remoteProvider.DisplayName()
@@ -2259,7 +2517,10 @@ func (s *Server) handleNodes(w http.ResponseWriter, r *http.Request) {
 	// If management key encryption is enabled but we can't decrypt,
 	// only return local node info
 	if s.shouldRestrictTopology() {
-		writeJSON(w, http.StatusOK, NodesResponse{Nodes: []TopologyAgentInfo{localNode}})
+		writeJSON(w, http.StatusOK, NodesResponse{
+			ListPage: ListPage{Total: 1},
+			Nodes:    []TopologyAgentInfo{localNode},
+		})
 		return
 	}
 
@@ -2298,5 +2559,11 @@ func (s *Server) handleNodes(w http.ResponseWriter, r *http.Request) {
 		nodes = append(nodes, node)
 	}
 
-	writeJSON(w, http.StatusOK, NodesResponse{Nodes: nodes})
+	nodes = s.filterNodes(nodes, q)
+	sortNodes(nodes, q)
+	nodes, p := page(nodes, q)
+	if nodes == nil {
+		nodes = []TopologyAgentInfo{}
+	}
+	writeJSON(w, http.StatusOK, NodesResponse{ListPage: p, Nodes: nodes})
 }
@@ -1,20 +1,26 @@
 package health
 
 import (
+	"bufio"
 	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"slices"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/postalsys/muti-metroo/internal/crypto"
 	"github.com/postalsys/muti-metroo/internal/identity"
 	"github.com/postalsys/muti-metroo/internal/protocol"
+	"github.com/postalsys/muti-metroo/internal/rbac"
+	"github.com/postalsys/muti-metroo/internal/sysinfo"
 	"golang.org/x/crypto/bcrypt"
+	"nhooyr.io/websocket"
 )
 
 // mockStatsProvider implements StatsProvider for testing.
@@ -182,6 +188,67 @@ func TestServer_handleReady_NotReady(t *testing.T) {
 	}
 }
 
+type mockReadinessProvider struct {
+	checks []ReadinessCheck
+}
+
+func (m *mockReadinessProvider) Readiness() []ReadinessCheck {
+	return m.checks
+}
+
+func TestServer_handleLivez(t *testing.T) {
+	// Liveness does not depend on the agent running
+	s := NewServer(DefaultServerConfig(), &mockStatsProvider{running: false})
+
+	for path, want := range map[string]string{
+		"/livez":         "ok\n",
+		"/livez?verbose": "[+]ping ok\nlivez check passed\n",
+	} {
+		rec := httptest.NewRecorder()
+		s.server.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusOK || rec.Body.String() != want {
+			t.Errorf("GET %s = %d %q, want 200 %q", path, rec.Code, rec.Body.String(), want)
+		}
+	}
+}
+
+func TestServer_handleReadyz(t *testing.T) {
+	provider := &mockStatsProvider{running: false}
+	s := NewServer(DefaultServerConfig(), provider)
+	readiness := &mockReadinessProvider{checks: []ReadinessCheck{
+		{Name: "peers", OK: false, Detail: "0 of 1 required peers connected"},
+		{Name: "route:10.0.0.0/8", OK: true},
+	}}
+	s.SetReadinessProvider(readiness)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		s.server.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+
+	// Criteria are only evaluated once the agent runs
+	rec := get("/readyz")
+	if rec.Code != http.StatusServiceUnavailable || rec.Body.String() != "[-]running failed: agent not running\nreadyz check failed\n" {
+		t.Errorf("not running: %d %q", rec.Code, rec.Body.String())
+	}
+
+	provider.running = true
+	rec = get("/readyz")
+	want := "[+]running ok\n[-]peers failed: 0 of 1 required peers connected\n[+]route:10.0.0.0/8 ok\nreadyz check failed\n"
+	if rec.Code != http.StatusServiceUnavailable || rec.Body.String() != want {
+		t.Errorf("missing peer: %d %q, want 503 %q", rec.Code, rec.Body.String(), want)
+	}
+
+	readiness.checks[0].OK = true
+	if rec = get("/readyz"); rec.Code != http.StatusOK || rec.Body.String() != "ok\n" {
+		t.Errorf("ready: %d %q, want 200 \"ok\\n\"", rec.Code, rec.Body.String())
+	}
+	if rec = get("/readyz?verbose"); rec.Code != http.StatusOK || !strings.HasSuffix(rec.Body.String(), "[+]peers ok\n[+]route:10.0.0.0/8 ok\nreadyz check passed\n") {
+		t.Errorf("ready verbose: %d %q", rec.Code, rec.Body.String())
+	}
+}
+
 func TestServer_StartStop(t *testing.T) {
 	cfg := ServerConfig{
 		Address:      "127.0.0.1:0", // Dynamic port
@@ -819,7 +886,7 @@ func TestServer_DisabledEndpoints(t *testing.T) {
 		cfg.EnableDashboard = false
 		s := NewServer(cfg, nil)
 
-		endpoints := []string{"/api/topology", "/api/dashboard"}
+		endpoints := []string{"/api/topology", "/api/topology/graph", "/api/dashboard"}
 		for _, endpoint := range endpoints {
 			req := httptest.NewRequest(http.MethodGet, endpoint, nil)
 			rec := httptest.NewRecorder()
@@ -1015,8 +1082,8 @@ func (m *mockRemoteStatusProvider) GetPortForwardRouteDetails() []PortForwardRou
 	return m.forwardRoutesList
 }
 
-func (m *mockRemoteStatusProvider) UploadFile(ctx context.Context, targetID identity.AgentID, localPath, remotePath string, opts TransferOptions, progress FileTransferProgress) error {
-	return nil
+func (m *mockRemoteStatusProvider) UploadFile(ctx context.Context, targetID identity.AgentID, localPath, remotePath string, opts TransferOptions, progress FileTransferProgress) (string, error) {
+	return "", nil
 }
 
 func (m *mockRemoteStatusProvider) DownloadFile(ctx context.Context, targetID identity.AgentID, remotePath, localPath string, opts TransferOptions, progress FileTransferProgress) error {
@@ -1159,6 +1226,47 @@ func TestServer_handleTopology(t *testing.T) {
 		}
 	})
 
+	t.Run("filtered by agent tag", func(t *testing.T) {
+		localID, _ := identity.NewAgentID()
+		webID, _ := identity.NewAgentID()
+		dbID, _ := identity.NewAgentID()
+
+		s := NewServer(DefaultServerConfig(), &mockStatsProvider{running: true})
+		s.SetRemoteProvider(&mockRemoteStatusProvider{
+			id:          localID,
+			displayName: "local-agent",
+			peerIDs:     []identity.AgentID{webID},
+			peerDetails: []PeerDetails{{ID: webID, DisplayName: "web", State: "connected"}},
+			routeDetails: []RouteDetails{
+				{Network: "10.0.0.0/8", Origin: dbID, Path: []identity.AgentID{webID, dbID}},
+			},
+			allNodeInfo: map[identity.AgentID]*protocol.NodeInfo{
+				webID: {DisplayName: "web", AgentTags: []string{"env:prod", "role:web"}},
+				dbID:  {DisplayName: "db", AgentTags: []string{"env:prod", "role:db"}},
+			},
+			localNodeInfo: &protocol.NodeInfo{},
+		})
+
+		rec := httptest.NewRecorder()
+		s.server.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/topology?tag=role:web", nil))
+		var response TopologyResponse
+		if err := json.NewDecoder(rec.Body).Decode(&response); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+
+		var names []string
+		for _, agent := range response.Agents {
+			names = append(names, agent.DisplayName)
+		}
+		slices.Sort(names)
+		if !slices.Equal(names, []string{"local-agent", "web"}) {
+			t.Errorf("agents = %v, want [local-agent web]", names)
+		}
+		if len(response.Connections) != 1 || response.Connections[0].ToAgent != webID.ShortString() {
+			t.Errorf("connections = %+v, want only local -> web", response.Connections)
+		}
+	})
+
 	t.Run("no remote provider", func(t *testing.T) {
 		cfg := DefaultServerConfig()
 		s := NewServer(cfg, nil)
@@ -1174,6 +1282,133 @@ func TestServer_handleTopology(t *testing.T) {
 	})
 }
 
+func TestServer_handleTopologyGraph(t *testing.T) {
+	t.Run("links merged from both ends", func(t *testing.T) {
+		cfg := DefaultServerConfig()
+		s := NewServer(cfg, &mockStatsProvider{running: true})
+
+		localID, _ := identity.NewAgentID()
+		peerID, _ := identity.NewAgentID()
+		remoteID, _ := identity.NewAgentID()
+
+		remoteProvider := &mockRemoteStatusProvider{
+			id:          localID,
+			displayName: "local-agent",
+			peerIDs:     []identity.AgentID{peerID},
+			peerDetails: []PeerDetails{
+				{
+					ID:            peerID,
+					DisplayName:   "peer-agent",
+					State:         "connected",
+					RTT:           40 * time.Millisecond,
+					Transport:     "quic",
+					TxBytesPerSec: 1000,
+					RxBytesPerSec: 2000,
+				},
+			},
+			routeDetails: []RouteDetails{
+				{
+					Network: "10.0.0.0/8",
+					NextHop: peerID,
+					Origin:  remoteID,
+					Metric:  2,
+					Path:    []identity.AgentID{peerID, remoteID},
+				},
+			},
+			allNodeInfo: map[identity.AgentID]*protocol.NodeInfo{
+				peerID: {
+					DisplayName: "peer-agent",
+					Peers: []protocol.PeerConnectionInfo{
+						{PeerID: localID, Transport: "quic", RTTMs: 45, TxBytesPerSec: 2100, RxBytesPerSec: 900},
+						{PeerID: remoteID, Transport: "h2", RTTMs: 10, TxBytesPerSec: 300, RxBytesPerSec: 0},
+					},
+				},
+				remoteID: {
+					DisplayName: "remote-agent",
+				},
+			},
+			localNodeInfo: &protocol.NodeInfo{},
+		}
+		s.SetRemoteProvider(remoteProvider)
+
+		req := httptest.NewRequest(http.MethodGet, "/api/topology/graph", nil)
+		rec := httptest.NewRecorder()
+
+		s.server.Handler.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+		}
+
+		var response GraphResponse
+		if err := json.NewDecoder(rec.Body).Decode(&response); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+
+		if len(response.Nodes) != 3 {
+			t.Fatalf("nodes = %d, want 3", len(response.Nodes))
+		}
+		if !response.Nodes[0].IsLocal || response.Nodes[0].StreamsURL != "/api/streams" {
+			t.Errorf("first node = %+v, want local agent with /api/streams", response.Nodes[0])
+		}
+
+		if len(response.Links) != 2 {
+			t.Fatalf("links = %d, want 2", len(response.Links))
+		}
+
+		var local *GraphLink
+		for i := range response.Links {
+			l := &response.Links[i]
+			if l.Source == localID.ShortString() || l.Target == localID.ShortString() {
+				local = l
+			}
+		}
+		if local == nil {
+			t.Fatal("local link missing")
+		}
+		if local.RTTMs != 45 {
+			t.Errorf("rtt_ms = %d, want 45", local.RTTMs)
+		}
+		if len(local.ReportedBy) != 2 {
+			t.Errorf("reported_by = %v, want both ends", local.ReportedBy)
+		}
+
+		// Each direction uses the sender's rate
+		localToPeer, peerToLocal := local.SourceToTargetBps, local.TargetToSourceBps
+		if local.Source != localID.ShortString() {
+			localToPeer, peerToLocal = peerToLocal, localToPeer
+		}
+		if localToPeer != 1000 || peerToLocal != 2100 {
+			t.Errorf("rates = %d/%d, want 1000/2100", localToPeer, peerToLocal)
+		}
+
+		for _, node := range response.Nodes {
+			if node.ShortID == remoteID.ShortString() {
+				if node.PeerCount != 1 || node.RxBytesPerSec != 300 {
+					t.Errorf("remote node = %+v, want 1 link receiving 300 B/s", node)
+				}
+				if node.RoutesURL != "/agents/"+remoteID.String()+"/routes" {
+					t.Errorf("routes_url = %q", node.RoutesURL)
+				}
+			}
+		}
+	})
+
+	t.Run("no remote provider", func(t *testing.T) {
+		cfg := DefaultServerConfig()
+		s := NewServer(cfg, nil)
+
+		req := httptest.NewRequest(http.MethodGet, "/api/topology/graph", nil)
+		rec := httptest.NewRecorder()
+
+		s.server.Handler.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusServiceUnavailable {
+			t.Errorf("status = %d, want %d", rec.Code, http.StatusServiceUnavailable)
+		}
+	})
+}
+
 func TestServer_handleDashboard(t *testing.T) {
 	t.Run("success", func(t *testing.T) {
 		cfg := DefaultServerConfig()
@@ -1325,6 +1560,236 @@ func TestServer_handleNodes(t *testing.T) {
 	})
 }
 
+func TestServer_handleNodes_Listing(t *testing.T) {
+	localID, _ := identity.NewAgentID()
+	peerA, _ := identity.NewAgentID()
+	peerB, _ := identity.NewAgentID()
+
+	s := NewServer(DefaultServerConfig(), &mockStatsProvider{running: true})
+	s.SetRemoteProvider(&mockRemoteStatusProvider{
+		id:          localID,
+		displayName: "local-agent",
+		allNodeInfo: map[identity.AgentID]*protocol.NodeInfo{
+			peerA: {DisplayName: "beta", IPAddresses: []string{"10.1.0.5"}, AgentTags: []string{"env:prod", "role:web"}},
+			peerB: {DisplayName: "alpha", IPAddresses: []string{"192.168.1.5"}, AgentTags: []string{"env:dr"}},
+		},
+		routeDetails: []RouteDetails{
+			{Network: "172.16.0.0/12", Origin: peerA, Path: []identity.AgentID{peerA}},
+		},
+	})
+
+	get := func(query string) NodesResponse {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		s.server.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/nodes"+query, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d, want %d", query, rec.Code, http.StatusOK)
+		}
+		var resp NodesResponse
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		return resp
+	}
+	names := func(resp NodesResponse) []string {
+		var out []string
+		for _, n := range resp.Nodes {
+			out = append(out, n.DisplayName)
+		}
+		return out
+	}
+
+	tests := []struct {
+		query string
+		want  []string
+		total int
+	}{
+		{"", []string{"local-agent", "alpha", "beta"}, 3},
+		{"?sort=name", []string{"alpha", "beta", "local-agent"}, 3},
+		{"?sort=name&order=desc", []string{"local-agent", "beta", "alpha"}, 3},
+		{"?offset=1&limit=1", []string{"alpha"}, 3},
+		{"?offset=5", nil, 3},
+		{"?q=ALP", []string{"alpha"}, 1},
+		{"?q=" + peerA.ShortString(), []string{"beta"}, 1},
+		{"?q=" + peerA.String(), []string{"beta"}, 1},
+		{"?q=192.168.0.0/16", []string{"alpha"}, 1},
+		{"?q=172.16.5.1", []string{"beta"}, 1},
+		{"?prefix=10.0.0.0/8", []string{"beta"}, 1},
+		{"?tag=env:prod", []string{"beta"}, 1},
+		{"?tag=env:prod,role:web", []string{"beta"}, 1},
+		{"?tag=env:prod&tag=env:dr", nil, 0},
+		{"?tag=env:dr&q=192.168.0.0/16", []string{"alpha"}, 1},
+	}
+	for _, tt := range tests {
+		resp := get(tt.query)
+		if got := names(resp); !slices.Equal(got, tt.want) {
+			t.Errorf("%q: nodes = %v, want %v", tt.query, got, tt.want)
+		}
+		if resp.Total != tt.total {
+			t.Errorf("%q: total = %d, want %d", tt.query, resp.Total, tt.total)
+		}
+	}
+
+	for _, query := range []string{"?sort=bogus", "?order=up", "?limit=-1", "?offset=x", "?prefix=10.0.0.0/99"} {
+		rec := httptest.NewRecorder()
+		s.server.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/nodes"+query, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%q: status = %d, want %d", query, rec.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestServer_handleRoutes(t *testing.T) {
+	localID, _ := identity.NewAgentID()
+	peerA, _ := identity.NewAgentID()
+	peerB, _ := identity.NewAgentID()
+	exitID, _ := identity.NewAgentID()
+
+	s := NewServer(DefaultServerConfig(), &mockStatsProvider{running: true})
+	s.SetRemoteProvider(&mockRemoteStatusProvider{
+		id:          localID,
+		displayName: "local-agent",
+		displayNames: map[identity.AgentID]string{
+			peerA:  "peer-a",
+			peerB:  "peer-b",
+			exitID: "exit-east",
+		},
+		routeDetails: []RouteDetails{
+			{Network: "10.0.0.0/8", Origin: exitID, HopCount: 2, Path: []identity.AgentID{peerA, exitID}},
+			{Network: "192.168.0.0/16", Origin: peerB, HopCount: 1, Path: []identity.AgentID{peerB}},
+			{Network: "10.20.0.0/16", Origin: peerB, HopCount: 1, Path: []identity.AgentID{peerB}},
+			{Network: "0.0.0.0/0", Origin: localID},
+		},
+		domainRoutesList: []DomainRouteDetails{
+			{Pattern: "*.internal.example", Origin: exitID, HopCount: 2, Path: []identity.AgentID{peerA, exitID}},
+		},
+		allNodeInfo: map[identity.AgentID]*protocol.NodeInfo{
+			exitID: {AgentTags: []string{"env:prod", "region:us-east"}},
+		},
+		localNodeInfo: &protocol.NodeInfo{AgentTags: []string{"env:prod"}},
+	})
+
+	get := func(query string) RoutesResponse {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		s.server.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/routes"+query, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d, want %d", query, rec.Code, http.StatusOK)
+		}
+		var resp RoutesResponse
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		return resp
+	}
+	networks := func(resp RoutesResponse) []string {
+		var out []string
+		for _, r := range resp.Routes {
+			out = append(out, r.Network)
+		}
+		return out
+	}
+
+	tests := []struct {
+		query string
+		want  []string
+		total int
+	}{
+		{"", []string{"0.0.0.0/0", "10.0.0.0/8", "10.20.0.0/16", "192.168.0.0/16", "*.internal.example"}, 5},
+		{"?limit=2", []string{"0.0.0.0/0", "10.0.0.0/8"}, 5},
+		{"?offset=4&limit=2", []string{"*.internal.example"}, 5},
+		{"?type=domain", []string{"*.internal.example"}, 1},
+		{"?origin=exit-east", []string{"10.0.0.0/8", "*.internal.example"}, 2},
+		{"?origin=" + peerB.ShortString(), []string{"10.20.0.0/16", "192.168.0.0/16"}, 2},
+		{"?next_hop=peer-a", []string{"10.0.0.0/8", "*.internal.example"}, 2},
+		{"?prefix=10.20.1.0/24", []string{"0.0.0.0/0", "10.0.0.0/8", "10.20.0.0/16"}, 3},
+		{"?q=10.20.0.0/16&origin=peer-b", []string{"10.20.0.0/16"}, 1},
+		{"?q=internal", []string{"*.internal.example"}, 1},
+		{"?tag=env:prod", []string{"0.0.0.0/0", "10.0.0.0/8", "*.internal.example"}, 3},
+		{"?tag=region:us-east&type=domain", []string{"*.internal.example"}, 1},
+		{"?type=cidr&sort=hops&order=desc", []string{"10.0.0.0/8", "10.20.0.0/16", "192.168.0.0/16", "0.0.0.0/0"}, 4},
+		{"?type=cidr&sort=network&order=desc", []string{"192.168.0.0/16", "10.20.0.0/16", "10.0.0.0/8", "0.0.0.0/0"}, 4},
+	}
+	for _, tt := range tests {
+		resp := get(tt.query)
+		if got := networks(resp); !slices.Equal(got, tt.want) {
+			t.Errorf("%q: routes = %v, want %v", tt.query, got, tt.want)
+		}
+		if resp.Total != tt.total {
+			t.Errorf("%q: total = %d, want %d", tt.query, resp.Total, tt.total)
+		}
+	}
+
+	if resp := get("?q=nomatch"); resp.Routes == nil || len(resp.Routes) != 0 {
+		t.Errorf("routes = %v, want empty list", resp.Routes)
+	}
+
+	rec := httptest.NewRecorder()
+	s.server.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/routes?type=ipv4", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
+
+func TestServer_handlePeers(t *testing.T) {
+	localID, _ := identity.NewAgentID()
+	peerA, _ := identity.NewAgentID()
+	peerB, _ := identity.NewAgentID()
+
+	s := NewServer(DefaultServerConfig(), &mockStatsProvider{running: true})
+	s.SetRemoteProvider(&mockRemoteStatusProvider{
+		id:          localID,
+		displayName: "local-agent",
+		peerDetails: []PeerDetails{
+			{ID: peerA, DisplayName: "zulu", State: "connected", RTT: 40 * time.Millisecond},
+			{ID: peerB, DisplayName: "alpha", State: "connected", RTT: 10 * time.Millisecond},
+		},
+		allNodeInfo: map[identity.AgentID]*protocol.NodeInfo{
+			peerA: {AgentTags: []string{"role:db"}},
+		},
+	})
+
+	get := func(query string) PeersResponse {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		s.server.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/peers"+query, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d, want %d", query, rec.Code, http.StatusOK)
+		}
+		var resp PeersResponse
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		return resp
+	}
+	names := func(resp PeersResponse) []string {
+		var out []string
+		for _, p := range resp.Peers {
+			out = append(out, p.DisplayName)
+		}
+		return out
+	}
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"", []string{"alpha", "zulu"}},
+		{"?sort=rtt&order=desc", []string{"zulu", "alpha"}},
+		{"?q=zu", []string{"zulu"}},
+		{"?q=" + peerB.ShortString(), []string{"alpha"}},
+		{"?limit=1", []string{"alpha"}},
+		{"?state=disconnected", nil},
+		{"?tag=role:db", []string{"zulu"}},
+		{"?tag=role:web", nil},
+	}
+	for _, tt := range tests {
+		if got := names(get(tt.query)); !slices.Equal(got, tt.want) {
+			t.Errorf("%q: peers = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+}
+
 // ============================================================================
 // Agent Info Handler Tests
 // ============================================================================
@@ -2465,7 +2930,7 @@ func TestAuth_QueryParamToken(t *testing.T) {
 func TestAuth_HealthEndpointsExempt(t *testing.T) {
 	s := newAuthServer(t, "test-secret-token")
 
-	exemptPaths := []string{"/health", "/healthz", "/ready", "/", "/logo.png"}
+	exemptPaths := []string{"/health", "/healthz", "/ready", "/livez", "/readyz", "/", "/logo.png"}
 	for _, path := range exemptPaths {
 		rec := httptest.NewRecorder()
 		req := httptest.NewRequest(http.MethodGet, path, nil)
@@ -2560,6 +3025,110 @@ func TestAuth_ProtectedEndpoints(t *testing.T) {
 	}
 }
 
+// newRoleTokenServer creates a test server with an admin token_hash and
+// viewer and operator role tokens.
+func newRoleTokenServer(t *testing.T) *Server {
+	t.Helper()
+	hashOf := func(token string) string {
+		hash, err := bcrypt.GenerateFromPassword([]byte(token), bcrypt.MinCost)
+		if err != nil {
+			t.Fatalf("failed to hash token: %v", err)
+		}
+		return string(hash)
+	}
+	cfg := DefaultServerConfig()
+	cfg.TokenHash = hashOf("admin-token")
+	cfg.Tokens = []APIToken{
+		{Name: "dashboards", Hash: hashOf("viewer-token"), Role: rbac.RoleViewer},
+		{Name: "oncall", Hash: hashOf("operator-token"), Role: rbac.RoleOperator},
+	}
+	provider := &mockStatsProvider{running: true}
+	return NewServer(cfg, provider)
+}
+
+// roleRecordingProvider records the role and body of forwarded control requests.
+type roleRecordingProvider struct {
+	mockRemoteStatusProvider
+	role rbac.Role
+	data []byte
+}
+
+func (m *roleRecordingProvider) SendControlRequestWithData(ctx context.Context, targetID identity.AgentID, controlType uint8, data []byte) (*protocol.ControlResponse, error) {
+	m.role, _ = rbac.FromContext(ctx)
+	m.data = data
+	return &protocol.ControlResponse{Success: true, Data: []byte("{}")}, nil
+}
+
+func TestAuth_RoleTokens(t *testing.T) {
+	s := newRoleTokenServer(t)
+
+	tests := []struct {
+		name   string
+		token  string
+		method string
+		path   string
+		body   string
+		want   int // 0 = any status other than 401 and 403
+	}{
+		{"viewer lists agents", "viewer-token", http.MethodGet, "/agents", "", 0},
+		{"viewer reads dns cache stats", "viewer-token", http.MethodPost, "/dns-cache/manage", `{"action":"stats"}`, 0},
+		{"viewer cannot flush dns cache", "viewer-token", http.MethodPost, "/dns-cache/manage", `{"action":"flush"}`, http.StatusForbidden},
+		{"viewer cannot sleep", "viewer-token", http.MethodPost, "/sleep", "", http.StatusForbidden},
+		{"viewer subscribes to events", "viewer-token", http.MethodGet, "/events", "", 0},
+		{"viewer reads remote system metrics", "viewer-token", http.MethodGet, "/agents/abc/system", "", 0},
+		{"viewer looks up a remote route", "viewer-token", http.MethodPost, "/agents/abc/routes/lookup", `{"destination":"10.1.2.3"}`, 0},
+		{"viewer watches routes", "viewer-token", http.MethodGet, "/api/routes/watch", "", 0},
+		{"operator flushes dns cache", "operator-token", http.MethodPost, "/dns-cache/manage", `{"action":"flush"}`, 0},
+		{"operator cannot apply update", "operator-token", http.MethodPost, "/update/manage", `{"action":"apply"}`, http.StatusForbidden},
+		{"operator cannot open shell", "operator-token", http.MethodGet, "/agents/abc/shell", "", http.StatusForbidden},
+		{"viewer reads debug capture status", "viewer-token", http.MethodPost, "/debug-capture/manage", `{"action":"status"}`, 0},
+		{"operator cannot start debug capture", "operator-token", http.MethodPost, "/debug-capture/manage", `{"action":"start","duration":"10m"}`, http.StatusForbidden},
+		{"admin sleeps", "admin-token", http.MethodPost, "/sleep", "", 0},
+		{"unknown token", "other-token", http.MethodGet, "/agents", "", http.StatusUnauthorized},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+			req.Header.Set("Authorization", "Bearer "+tc.token)
+			rec := httptest.NewRecorder()
+			s.server.Handler.ServeHTTP(rec, req)
+
+			if tc.want == 0 {
+				if rec.Code == http.StatusUnauthorized || rec.Code == http.StatusForbidden {
+					t.Errorf("status = %d, want request allowed", rec.Code)
+				}
+			} else if rec.Code != tc.want {
+				t.Errorf("status = %d, want %d", rec.Code, tc.want)
+			}
+		})
+	}
+}
+
+func TestAuth_RoleForwardedWithControlRequest(t *testing.T) {
+	s := newRoleTokenServer(t)
+	localID, _ := identity.NewAgentID()
+	targetID, _ := identity.NewAgentID()
+	remote := &roleRecordingProvider{mockRemoteStatusProvider: mockRemoteStatusProvider{id: localID}}
+	s.SetRemoteProvider(remote)
+
+	body := `{"action":"flush"}`
+	req := httptest.NewRequest(http.MethodPost, "/agents/"+targetID.String()+"/dns-cache/manage", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer operator-token")
+	rec := httptest.NewRecorder()
+	s.server.Handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+	if remote.role != rbac.RoleOperator {
+		t.Errorf("forwarded role = %v, want operator", remote.role)
+	}
+	if string(remote.data) != body {
+		t.Errorf("forwarded body = %q, want %q", remote.data, body)
+	}
+}
+
 func TestExtractBearerToken(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -2591,3 +3160,1155 @@ func TestExtractBearerToken(t *testing.T) {
 		})
 	}
 }
+
+// ============================================================================
+// Streams Tests
+// ============================================================================
+
+// mockStreamProvider implements StreamProvider for testing.
+type mockStreamProvider struct {
+	streams []StreamInfo
+	killed  []uint64
+}
+
+func (m *mockStreamProvider) ListStreams() []StreamInfo {
+	return m.streams
+}
+
+func (m *mockStreamProvider) KillStream(streamID uint64) error {
+	for _, st := range m.streams {
+		if st.ID == streamID {
+			m.killed = append(m.killed, streamID)
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %d", ErrStreamNotFound, streamID)
+}
+
+func TestHandleStreams_List(t *testing.T) {
+	cfg := DefaultServerConfig()
+	s := NewServer(cfg, &mockStatsProvider{running: true})
+	s.SetStreamProvider(&mockStreamProvider{
+		streams: []StreamInfo{
+			{ID: 3, Direction: StreamDirectionOutbound, DownstreamPeer: "abcd1234", Destination: "10.0.0.5:22", BytesSent: 100},
+			{ID: 8, Direction: StreamDirectionRelay, UpstreamPeer: "11112222", DownstreamPeer: "33334444", DownstreamID: 5},
+		},
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/api/streams", nil)
+	rec := httptest.NewRecorder()
+
+	s.server.Handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	var result StreamsResponse
+	if err := json.NewDecoder(rec.Body).Decode(&result); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(result.Streams) != 2 {
+		t.Fatalf("expected 2 streams, got %d", len(result.Streams))
+	}
+	if result.Streams[1].DownstreamID != 5 {
+		t.Errorf("expected downstream_id 5, got %d", result.Streams[1].DownstreamID)
+	}
+}
+
+func TestHandleStreams_EmptyList(t *testing.T) {
+	cfg := DefaultServerConfig()
+	s := NewServer(cfg, &mockStatsProvider{running: true})
+	s.SetStreamProvider(&mockStreamProvider{})
+
+	req := httptest.NewRequest(http.MethodGet, "/api/streams", nil)
+	rec := httptest.NewRecorder()
+
+	s.server.Handler.ServeHTTP(rec, req)
+
+	if !strings.Contains(rec.Body.String(), `"streams":[]`) {
+		t.Errorf("expected empty streams array, got %s", rec.Body.String())
+	}
+}
+
+func TestHandleStreamKill(t *testing.T) {
+	cfg := DefaultServerConfig()
+	s := NewServer(cfg, &mockStatsProvider{running: true})
+	provider := &mockStreamProvider{
+		streams: []StreamInfo{{ID: 7, Direction: StreamDirectionExit}},
+	}
+	s.SetStreamProvider(provider)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/streams/kill", strings.NewReader(`{"stream_id":7}`))
+	rec := httptest.NewRecorder()
+	s.server.Handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	if len(provider.killed) != 1 || provider.killed[0] != 7 {
+		t.Errorf("expected stream 7 killed, got %v", provider.killed)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/api/streams/kill", strings.NewReader(`{"stream_id":99}`))
+	rec = httptest.NewRecorder()
+	s.server.Handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected status %d for unknown stream, got %d", http.StatusNotFound, rec.Code)
+	}
+}
+
+func TestHandleStreams_DashboardDisabled(t *testing.T) {
+	cfg := DefaultServerConfig()
+	cfg.EnableDashboard = false
+	s := NewServer(cfg, &mockStatsProvider{running: true})
+	s.SetStreamProvider(&mockStreamProvider{})
+
+	req := httptest.NewRequest(http.MethodGet, "/api/streams", nil)
+	rec := httptest.NewRecorder()
+	s.server.Handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected status %d, got %d", http.StatusNotFound, rec.Code)
+	}
+}
+
+// mockUDPProvider implements UDPProvider for testing.
+type mockUDPProvider struct {
+	resp UDPAssociationsResponse
+}
+
+func (m *mockUDPProvider) UDPAssociations() UDPAssociationsResponse {
+	return m.resp
+}
+
+func TestHandleUDPAssociations(t *testing.T) {
+	cfg := DefaultServerConfig()
+	s := NewServer(cfg, &mockStatsProvider{running: true})
+	s.SetUDPProvider(&mockUDPProvider{resp: UDPAssociationsResponse{
+		Enabled: true,
+		Associations: []UDPAssociationInfo{
+			{StreamID: 4, Peer: "abcd1234", DatagramsOut: 3, BytesOut: 120, Endpoints: []string{"8.8.8.8:53"}},
+		},
+	}})
+
+	req := httptest.NewRequest(http.MethodGet, "/api/udp", nil)
+	rec := httptest.NewRecorder()
+
+	s.server.Handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	var result UDPAssociationsResponse
+	if err := json.NewDecoder(rec.Body).Decode(&result); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if !result.Enabled || len(result.Associations) != 1 {
+		t.Fatalf("unexpected response: %+v", result)
+	}
+	if result.Associations[0].Endpoints[0] != "8.8.8.8:53" {
+		t.Errorf("expected endpoint 8.8.8.8:53, got %v", result.Associations[0].Endpoints)
+	}
+}
+
+func TestHandleUDPAssociations_MethodNotAllowed(t *testing.T) {
+	cfg := DefaultServerConfig()
+	s := NewServer(cfg, &mockStatsProvider{running: true})
+	s.SetUDPProvider(&mockUDPProvider{})
+
+	req := httptest.NewRequest(http.MethodPost, "/api/udp", nil)
+	rec := httptest.NewRecorder()
+
+	s.server.Handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected status %d, got %d", http.StatusMethodNotAllowed, rec.Code)
+	}
+}
+
+// mockICMPStatsProvider implements ICMPStatsProvider for testing.
+type mockICMPStatsProvider struct {
+	resp ICMPStatsResponse
+}
+
+func (m *mockICMPStatsProvider) ICMPStats() ICMPStatsResponse {
+	return m.resp
+}
+
+func TestHandleICMPStats(t *testing.T) {
+	cfg := DefaultServerConfig()
+	s := NewServer(cfg, &mockStatsProvider{running: true})
+	s.SetICMPStatsProvider(&mockICMPStatsProvider{resp: ICMPStatsResponse{
+		Enabled:         true,
+		ActiveSessions:  2,
+		EchoRequests:    40,
+		DestRateLimited: 7,
+	}})
+
+	req := httptest.NewRequest(http.MethodGet, "/api/icmp", nil)
+	rec := httptest.NewRecorder()
+
+	s.server.Handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	var result ICMPStatsResponse
+	if err := json.NewDecoder(rec.Body).Decode(&result); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if !result.Enabled || result.ActiveSessions != 2 || result.EchoRequests != 40 || result.DestRateLimited != 7 {
+		t.Errorf("unexpected response: %+v", result)
+	}
+}
+
+type mockAcceptStatsProvider struct {
+	resp AcceptStatsResponse
+}
+
+func (m *mockAcceptStatsProvider) AcceptStats() AcceptStatsResponse {
+	return m.resp
+}
+
+func TestHandleAcceptStats(t *testing.T) {
+	s := NewServer(DefaultServerConfig(), &mockStatsProvider{running: true})
+
+	req := httptest.NewRequest(http.MethodGet, "/api/accept", nil)
+	rec := httptest.NewRecorder()
+	s.server.Handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("without provider: status %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+
+	s.SetAcceptStatsProvider(&mockAcceptStatsProvider{resp: AcceptStatsResponse{
+		LimitsEnabled: true,
+		Accepted:      12,
+		RateLimited:   30,
+		TimedOut:      2,
+	}})
+
+	rec = httptest.NewRecorder()
+	s.server.Handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	var result AcceptStatsResponse
+	if err := json.NewDecoder(rec.Body).Decode(&result); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if !result.LimitsEnabled || result.Accepted != 12 || result.RateLimited != 30 || result.TimedOut != 2 {
+		t.Errorf("unexpected response: %+v", result)
+	}
+}
+
+type mockHalfCloseStatsProvider struct {
+	resp HalfCloseStatsResponse
+}
+
+func (m *mockHalfCloseStatsProvider) HalfCloseStats() HalfCloseStatsResponse {
+	return m.resp
+}
+
+func TestHandleHalfCloseStats(t *testing.T) {
+	s := NewServer(DefaultServerConfig(), &mockStatsProvider{running: true})
+
+	req := httptest.NewRequest(http.MethodGet, "/api/half-close", nil)
+	rec := httptest.NewRecorder()
+	s.server.Handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("without provider: status %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+
+	s.SetHalfCloseStatsProvider(&mockHalfCloseStatsProvider{resp: HalfCloseStatsResponse{
+		Enabled:             true,
+		ClientLinger:        "1m0s",
+		ClientHalfOpen:      3,
+		ClientLingerExpired: 7,
+	}})
+
+	rec = httptest.NewRecorder()
+	s.server.Handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	var result HalfCloseStatsResponse
+	if err := json.NewDecoder(rec.Body).Decode(&result); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if !result.Enabled || result.ClientLinger != "1m0s" || result.ClientHalfOpen != 3 || result.ClientLingerExpired != 7 {
+		t.Errorf("unexpected response: %+v", result)
+	}
+}
+
+type mockPortLimitStatsProvider struct {
+	resp PortLimitStatsResponse
+}
+
+func (m *mockPortLimitStatsProvider) PortLimitStats() PortLimitStatsResponse {
+	return m.resp
+}
+
+func TestHandlePortLimitStats(t *testing.T) {
+	s := NewServer(DefaultServerConfig(), &mockStatsProvider{running: true})
+
+	req := httptest.NewRequest(http.MethodGet, "/api/port-limits", nil)
+	rec := httptest.NewRecorder()
+	s.server.Handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("without provider: status %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+
+	s.SetPortLimitStatsProvider(&mockPortLimitStatsProvider{resp: PortLimitStatsResponse{
+		Active:           true,
+		DefaultRouteOnly: true,
+		Classes: []PortClassInfo{
+			{Name: "smtp", Ports: []uint16{25, 465, 587}, Action: "block", Refused: 12},
+		},
+	}})
+
+	rec = httptest.NewRecorder()
+	s.server.Handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	var result PortLimitStatsResponse
+	if err := json.NewDecoder(rec.Body).Decode(&result); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if !result.Active || len(result.Classes) != 1 || result.Classes[0].Refused != 12 || len(result.Classes[0].Ports) != 3 {
+		t.Errorf("unexpected response: %+v", result)
+	}
+}
+
+type mockExitHealthProvider struct {
+	resp ExitHealthResponse
+}
+
+func (m *mockExitHealthProvider) ExitHealth() ExitHealthResponse {
+	return m.resp
+}
+
+func TestHandleExitHealth(t *testing.T) {
+	s := NewServer(DefaultServerConfig(), &mockStatsProvider{running: true})
+
+	req := httptest.NewRequest(http.MethodGet, "/api/exit-health", nil)
+	rec := httptest.NewRecorder()
+	s.server.Handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("without provider: status %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+
+	s.SetExitHealthProvider(&mockExitHealthProvider{resp: ExitHealthResponse{
+		Enabled:             true,
+		RoutesWithdrawn:     true,
+		ConsecutiveFailures: 4,
+		Withdrawals:         1,
+		Targets:             []ExitHealthTarget{{Target: "192.0.2.1:443", Error: "i/o timeout"}},
+	}})
+
+	rec = httptest.NewRecorder()
+	s.server.Handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	var result ExitHealthResponse
+	if err := json.NewDecoder(rec.Body).Decode(&result); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if !result.Enabled || result.Healthy || !result.RoutesWithdrawn || result.ConsecutiveFailures != 4 ||
+		len(result.Targets) != 1 || result.Targets[0].Error != "i/o timeout" {
+		t.Errorf("unexpected response: %+v", result)
+	}
+}
+
+type mockManagementKeyProvider struct {
+	got ManagementKeyRequest
+}
+
+func (m *mockManagementKeyProvider) ManageManagementKey(req ManagementKeyRequest) (*ManagementKeyResult, error) {
+	m.got = req
+	if req.Action != "stage" {
+		return nil, fmt.Errorf("unknown action %q", req.Action)
+	}
+	return &ManagementKeyResult{Status: "ok", PublicKey: "aa", NextPublicKey: req.PublicKey, TargetKey: req.PublicKey}, nil
+}
+
+func TestHandleManagementKey(t *testing.T) {
+	s := NewServer(DefaultServerConfig(), &mockStatsProvider{running: true})
+
+	post := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/management-key/manage", strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		s.server.Handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := post(`{"action":"status"}`); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("without provider: status %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+
+	provider := &mockManagementKeyProvider{}
+	s.SetManagementKeyProvider(provider)
+
+	rec := post(`{"action":"stage","public_key":"bb"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	var result ManagementKeyResult
+	if err := json.NewDecoder(rec.Body).Decode(&result); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if provider.got.PublicKey != "bb" || result.NextPublicKey != "bb" {
+		t.Errorf("unexpected request %+v or response %+v", provider.got, result)
+	}
+
+	if rec := post(`{"action":"bogus"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("unknown action: status %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
+
+type mockRouteLookupProvider struct {
+	got RouteLookupRequest
+}
+
+func (m *mockRouteLookupProvider) LookupRoute(req RouteLookupRequest) (*RouteLookupResult, error) {
+	m.got = req
+	if req.Destination == "" {
+		return nil, fmt.Errorf("destination is required")
+	}
+	return &RouteLookupResult{
+		Destination: req.Destination,
+		Match:       RouteLookupMatchCIDR,
+		Route:       "10.0.0.0/8",
+		Action:      RouteLookupMesh,
+		Decisions:   []string{"route 10.0.0.0/8 matched 10.1.2.3"},
+	}, nil
+}
+
+func TestHandleRouteLookup(t *testing.T) {
+	s := NewServer(DefaultServerConfig(), &mockStatsProvider{running: true})
+
+	post := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/routes/lookup", strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		s.server.Handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := post(`{"destination":"10.1.2.3"}`); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("without provider: status %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+
+	provider := &mockRouteLookupProvider{}
+	s.SetRouteLookupProvider(provider)
+
+	rec := post(`{"destination":"10.1.2.3","user":"alice"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	var result RouteLookupResult
+	if err := json.NewDecoder(rec.Body).Decode(&result); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if provider.got.User != "alice" || result.Route != "10.0.0.0/8" || result.Action != RouteLookupMesh {
+		t.Errorf("unexpected request %+v or response %+v", provider.got, result)
+	}
+
+	if rec := post(`{}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("missing destination: status %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/routes/lookup", nil)
+	rec = httptest.NewRecorder()
+	s.server.Handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET: status %d, want %d", rec.Code, http.StatusMethodNotAllowed)
+	}
+}
+
+type mockSystemMetricsProvider struct {
+	m sysinfo.Metrics
+}
+
+func (m *mockSystemMetricsProvider) SystemMetrics() *sysinfo.Metrics {
+	return &m.m
+}
+
+func TestHandleSystemMetrics(t *testing.T) {
+	s := NewServer(DefaultServerConfig(), &mockStatsProvider{running: true})
+
+	req := httptest.NewRequest(http.MethodGet, "/system", nil)
+	rec := httptest.NewRecorder()
+	s.server.Handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("without provider: status %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+
+	s.SetSystemMetricsProvider(&mockSystemMetricsProvider{m: sysinfo.Metrics{
+		CPU:    sysinfo.CPUMetrics{Cores: 4, Percent: 37.5},
+		Memory: sysinfo.MemoryMetrics{Total: 8 << 30, Used: 2 << 30},
+		Files:  sysinfo.FileMetrics{ProcessOpen: 21, ProcessLimit: 1024},
+	}})
+
+	rec = httptest.NewRecorder()
+	s.server.Handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	var result sysinfo.Metrics
+	if err := json.NewDecoder(rec.Body).Decode(&result); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if result.CPU.Percent != 37.5 || result.Memory.Used != 2<<30 || result.Files.ProcessOpen != 21 {
+		t.Errorf("unexpected response: %+v", result)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/system", nil)
+	rec = httptest.NewRecorder()
+	s.server.Handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST: status %d, want %d", rec.Code, http.StatusMethodNotAllowed)
+	}
+}
+
+// mockForwardEndpointManageProvider implements ForwardEndpointManageProvider for testing.
+type mockForwardEndpointManageProvider struct {
+	action, key, target string
+	err                 error
+}
+
+func (m *mockForwardEndpointManageProvider) ManageForwardEndpoint(action, key, target string) (*ForwardEndpointManageResult, error) {
+	m.action, m.key, m.target = action, key, target
+	if m.err != nil {
+		return nil, m.err
+	}
+	return &ForwardEndpointManageResult{Status: "ok", Message: "done"}, nil
+}
+
+func TestHandleForwardEndpointManage_Add(t *testing.T) {
+	cfg := DefaultServerConfig()
+	s := NewServer(cfg, &mockStatsProvider{running: true})
+	provider := &mockForwardEndpointManageProvider{}
+	s.SetForwardEndpointManageProvider(provider)
+
+	body := strings.NewReader(`{"action":"add","key":"adhoc-1","target":"localhost:80"}`)
+	req := httptest.NewRequest(http.MethodPost, "/forward/endpoint/manage", body)
+	rec := httptest.NewRecorder()
+
+	s.server.Handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	if provider.action != "add" || provider.key != "adhoc-1" || provider.target != "localhost:80" {
+		t.Errorf("provider got %q/%q/%q", provider.action, provider.key, provider.target)
+	}
+}
+
+func TestHandleForwardEndpointManage_Error(t *testing.T) {
+	cfg := DefaultServerConfig()
+	s := NewServer(cfg, &mockStatsProvider{running: true})
+	s.SetForwardEndpointManageProvider(&mockForwardEndpointManageProvider{err: fmt.Errorf("endpoint \"x\" not found")})
+
+	body := strings.NewReader(`{"action":"remove","key":"x"}`)
+	req := httptest.NewRequest(http.MethodPost, "/forward/endpoint/manage", body)
+	rec := httptest.NewRecorder()
+
+	s.server.Handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d, got %d", http.StatusBadRequest, rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), "not found") {
+		t.Errorf("expected error in body, got %s", rec.Body.String())
+	}
+}
+
+// mockDNSCacheManageProvider implements DNSCacheManageProvider for testing.
+type mockDNSCacheManageProvider struct {
+	action, domain string
+	err            error
+}
+
+func (m *mockDNSCacheManageProvider) ManageDNSCache(action, domain string) (*DNSCacheManageResult, error) {
+	m.action, m.domain = action, domain
+	if m.err != nil {
+		return nil, m.err
+	}
+	if action == "stats" {
+		return &DNSCacheManageResult{Status: "ok", Stats: &DNSCacheStats{Enabled: true, Entries: 3, Hits: 10}}, nil
+	}
+	return &DNSCacheManageResult{Status: "ok", Flushed: 1}, nil
+}
+
+func TestHandleDNSCacheManage_Stats(t *testing.T) {
+	cfg := DefaultServerConfig()
+	s := NewServer(cfg, &mockStatsProvider{running: true})
+	s.SetDNSCacheManageProvider(&mockDNSCacheManageProvider{})
+
+	body := strings.NewReader(`{"action":"stats"}`)
+	req := httptest.NewRequest(http.MethodPost, "/dns-cache/manage", body)
+	rec := httptest.NewRecorder()
+
+	s.server.Handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	var result DNSCacheManageResult
+	json.NewDecoder(rec.Body).Decode(&result)
+	if result.Stats == nil || result.Stats.Entries != 3 || result.Stats.Hits != 10 {
+		t.Errorf("unexpected stats: %+v", result.Stats)
+	}
+}
+
+func TestHandleDNSCacheManage_FlushDomain(t *testing.T) {
+	cfg := DefaultServerConfig()
+	s := NewServer(cfg, &mockStatsProvider{running: true})
+	provider := &mockDNSCacheManageProvider{}
+	s.SetDNSCacheManageProvider(provider)
+
+	body := strings.NewReader(`{"action":"flush","domain":"example.com"}`)
+	req := httptest.NewRequest(http.MethodPost, "/dns-cache/manage", body)
+	rec := httptest.NewRecorder()
+
+	s.server.Handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	if provider.action != "flush" || provider.domain != "example.com" {
+		t.Errorf("provider got %q/%q", provider.action, provider.domain)
+	}
+}
+
+func TestHandleDNSCacheManage_Errors(t *testing.T) {
+	cfg := DefaultServerConfig()
+	s := NewServer(cfg, &mockStatsProvider{running: true})
+
+	// No provider
+	req := httptest.NewRequest(http.MethodPost, "/dns-cache/manage", strings.NewReader(`{"action":"stats"}`))
+	rec := httptest.NewRecorder()
+	s.server.Handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected status %d, got %d", http.StatusServiceUnavailable, rec.Code)
+	}
+
+	s.SetDNSCacheManageProvider(&mockDNSCacheManageProvider{err: fmt.Errorf("DNS cache not available: exit is not enabled on this agent")})
+
+	req = httptest.NewRequest(http.MethodGet, "/dns-cache/manage", nil)
+	rec = httptest.NewRecorder()
+	s.server.Handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected status %d, got %d", http.StatusMethodNotAllowed, rec.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/dns-cache/manage", strings.NewReader(`{"action":"stats"}`))
+	rec = httptest.NewRecorder()
+	s.server.Handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d, got %d", http.StatusBadRequest, rec.Code)
+	}
+}
+
+// mockExitDestManageProvider implements ExitDestManageProvider for testing.
+type mockExitDestManageProvider struct {
+	action, sortBy, destination string
+	limit                       int
+	err                         error
+}
+
+func (m *mockExitDestManageProvider) ManageExitDestinations(action, sortBy, destination string, limit int) (*ExitDestManageResult, error) {
+	m.action, m.sortBy, m.destination, m.limit = action, sortBy, destination, limit
+	if m.err != nil {
+		return nil, m.err
+	}
+	if action == "top" {
+		return &ExitDestManageResult{
+			Status: "ok",
+			Window: "5m0s",
+			Destinations: []ExitDestStat{
+				{Destination: "example.com", Connections: 4, BytesOut: 100, BytesIn: 2000, Active: 1},
+			},
+		}, nil
+	}
+	return &ExitDestManageResult{Status: "ok", Message: "unblocked " + destination}, nil
+}
+
+func TestHandleExitDestManage_Top(t *testing.T) {
+	cfg := DefaultServerConfig()
+	s := NewServer(cfg, &mockStatsProvider{running: true})
+	provider := &mockExitDestManageProvider{}
+	s.SetExitDestManageProvider(provider)
+
+	body := strings.NewReader(`{"action":"top","sort":"connections","limit":5}`)
+	req := httptest.NewRequest(http.MethodPost, "/exit-destinations/manage", body)
+	rec := httptest.NewRecorder()
+
+	s.server.Handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	if provider.sortBy != "connections" || provider.limit != 5 {
+		t.Errorf("provider got sort=%q limit=%d", provider.sortBy, provider.limit)
+	}
+	var result ExitDestManageResult
+	json.NewDecoder(rec.Body).Decode(&result)
+	if len(result.Destinations) != 1 || result.Destinations[0].BytesIn != 2000 {
+		t.Errorf("unexpected destinations: %+v", result.Destinations)
+	}
+}
+
+func TestHandleExitDestManage_Errors(t *testing.T) {
+	cfg := DefaultServerConfig()
+	s := NewServer(cfg, &mockStatsProvider{running: true})
+
+	// No provider
+	req := httptest.NewRequest(http.MethodPost, "/exit-destinations/manage", strings.NewReader(`{"action":"top"}`))
+	rec := httptest.NewRecorder()
+	s.server.Handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected status %d, got %d", http.StatusServiceUnavailable, rec.Code)
+	}
+
+	s.SetExitDestManageProvider(&mockExitDestManageProvider{err: fmt.Errorf("destination stats not enabled")})
+
+	req = httptest.NewRequest(http.MethodGet, "/exit-destinations/manage", nil)
+	rec = httptest.NewRecorder()
+	s.server.Handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected status %d, got %d", http.StatusMethodNotAllowed, rec.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/exit-destinations/manage", strings.NewReader(`{"action":"top"}`))
+	rec = httptest.NewRecorder()
+	s.server.Handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d, got %d", http.StatusBadRequest, rec.Code)
+	}
+}
+
+// mockScheduleManageProvider implements ScheduleManageProvider for testing.
+type mockScheduleManageProvider struct {
+	req ScheduleManageRequest
+	err error
+}
+
+func (m *mockScheduleManageProvider) ManageSchedule(req ScheduleManageRequest) (*ScheduleManageResult, error) {
+	m.req = req
+	if m.err != nil {
+		return nil, m.err
+	}
+	task := *req.Task
+	task.ID = "3f2a9c1e5b7d8a60"
+	return &ScheduleManageResult{Status: "ok", Task: &task}, nil
+}
+
+func TestHandleScheduleManage_Add(t *testing.T) {
+	cfg := DefaultServerConfig()
+	s := NewServer(cfg, &mockStatsProvider{running: true})
+	provider := &mockScheduleManageProvider{}
+	s.SetScheduleManageProvider(provider)
+
+	body := strings.NewReader(`{"action":"add","password":"secret","task":{"schedule":"@every 15m","type":"shell","command":"df","args":["-h"]}}`)
+	req := httptest.NewRequest(http.MethodPost, "/scheduler/manage", body)
+	rec := httptest.NewRecorder()
+
+	s.server.Handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	if provider.req.Password != "secret" || provider.req.Task.Command != "df" || len(provider.req.Task.Args) != 1 {
+		t.Errorf("provider got %+v", provider.req)
+	}
+	var result ScheduleManageResult
+	json.NewDecoder(rec.Body).Decode(&result)
+	if result.Task == nil || result.Task.ID != "3f2a9c1e5b7d8a60" {
+		t.Errorf("unexpected task: %+v", result.Task)
+	}
+}
+
+func TestHandleScheduleManage_Errors(t *testing.T) {
+	cfg := DefaultServerConfig()
+	s := NewServer(cfg, &mockStatsProvider{running: true})
+
+	// No provider
+	req := httptest.NewRequest(http.MethodPost, "/scheduler/manage", strings.NewReader(`{"action":"list"}`))
+	rec := httptest.NewRecorder()
+	s.server.Handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected status %d, got %d", http.StatusServiceUnavailable, rec.Code)
+	}
+
+	s.SetScheduleManageProvider(&mockScheduleManageProvider{err: fmt.Errorf("scheduler not enabled")})
+
+	req = httptest.NewRequest(http.MethodPost, "/scheduler/manage", strings.NewReader(`{"action":`))
+	rec = httptest.NewRecorder()
+	s.server.Handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d for bad JSON, got %d", http.StatusBadRequest, rec.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/scheduler/manage", strings.NewReader(`{"action":"list"}`))
+	rec = httptest.NewRecorder()
+	s.server.Handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d, got %d", http.StatusBadRequest, rec.Code)
+	}
+}
+
+// mockUpdateManageProvider implements UpdateManageProvider for testing.
+type mockUpdateManageProvider struct {
+	req UpdateManageRequest
+	err error
+}
+
+func (m *mockUpdateManageProvider) ManageUpdate(req UpdateManageRequest) (*UpdateManageResult, error) {
+	m.req = req
+	if m.err != nil {
+		return nil, m.err
+	}
+	return &UpdateManageResult{Status: "ok", PreviousVersion: "1.0.0", NewVersion: "1.1.0", Method: "exec"}, nil
+}
+
+func TestHandleUpdateManage_Apply(t *testing.T) {
+	cfg := DefaultServerConfig()
+	s := NewServer(cfg, &mockStatsProvider{running: true})
+	provider := &mockUpdateManageProvider{}
+	s.SetUpdateManageProvider(provider)
+
+	body := strings.NewReader(`{"action":"apply","sha256":"abcd","signature":"ef01","password":"secret"}`)
+	req := httptest.NewRequest(http.MethodPost, "/update/manage", body)
+	rec := httptest.NewRecorder()
+
+	s.server.Handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	if provider.req.SHA256 != "abcd" || provider.req.Signature != "ef01" || provider.req.Password != "secret" {
+		t.Errorf("provider got %+v", provider.req)
+	}
+	var result UpdateManageResult
+	json.NewDecoder(rec.Body).Decode(&result)
+	if result.NewVersion != "1.1.0" || result.Method != "exec" {
+		t.Errorf("unexpected result: %+v", result)
+	}
+}
+
+func TestHandleUpdateManage_Errors(t *testing.T) {
+	cfg := DefaultServerConfig()
+	s := NewServer(cfg, &mockStatsProvider{running: true})
+
+	// No provider
+	req := httptest.NewRequest(http.MethodPost, "/update/manage", strings.NewReader(`{"action":"status"}`))
+	rec := httptest.NewRecorder()
+	s.server.Handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected status %d, got %d", http.StatusServiceUnavailable, rec.Code)
+	}
+
+	s.SetUpdateManageProvider(&mockUpdateManageProvider{err: fmt.Errorf("sha256 mismatch")})
+
+	req = httptest.NewRequest(http.MethodGet, "/update/manage", nil)
+	rec = httptest.NewRecorder()
+	s.server.Handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected status %d, got %d", http.StatusMethodNotAllowed, rec.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/update/manage", strings.NewReader(`{"action":"apply"}`))
+	rec = httptest.NewRecorder()
+	s.server.Handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d, got %d", http.StatusBadRequest, rec.Code)
+	}
+}
+
+func TestHandleEvents(t *testing.T) {
+	s := NewServer(DefaultServerConfig(), &mockStatsProvider{running: true})
+	ts := httptest.NewServer(s.server.Handler)
+	defer ts.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	wsURL := "ws" + strings.TrimPrefix(ts.URL, "http") + "/events?types=peer_up,file_transfer"
+	conn, _, err := websocket.Dial(ctx, wsURL, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.CloseNow()
+
+	for !s.HasEventSubscribers() {
+		if ctx.Err() != nil {
+			t.Fatal("subscriber not registered")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	readEvent := func() map[string]any {
+		t.Helper()
+		_, data, err := conn.Read(ctx)
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		var ev map[string]any
+		if err := json.Unmarshal(data, &ev); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		return ev
+	}
+
+	// Filtered out by ?types=
+	s.PublishEvent(EventPeerDown, PeerEvent{ShortID: "aaaa1111"})
+	s.PublishEvent(EventPeerUp, PeerEvent{ShortID: "bbbb2222"})
+
+	ev := readEvent()
+	if ev["type"] != EventPeerUp {
+		t.Fatalf("type = %v, want %s", ev["type"], EventPeerUp)
+	}
+	if data, _ := ev["data"].(map[string]any); data["short_id"] != "bbbb2222" {
+		t.Errorf("data = %v", ev["data"])
+	}
+
+	progress, finish := s.fileTransferEvents("upload", "cccc3333", "/tmp/file")
+	progress(512, 1024)
+	finish(fmt.Errorf("stream closed"))
+
+	// The first progress call publishes, the final event carries the last progress
+	if ev := readEvent(); ev["data"].(map[string]any)["state"] != FileTransferStateProgress {
+		t.Errorf("first transfer event = %v, want progress", ev["data"])
+	}
+	ev = readEvent()
+	data := ev["data"].(map[string]any)
+	if data["state"] != FileTransferStateFailed || data["bytes"] != float64(512) || data["error"] != "stream closed" {
+		t.Errorf("final transfer event = %v", data)
+	}
+
+	// Stopping the hub ends the stream
+	s.events.close()
+	if _, _, err := conn.Read(ctx); websocket.CloseStatus(err) != websocket.StatusGoingAway {
+		t.Errorf("close status = %v, want going away", websocket.CloseStatus(err))
+	}
+}
+
+// mockRouteExportProvider serves route details that tests can change while
+// a route export stream reads them.
+type mockRouteExportProvider struct {
+	mockRemoteStatusProvider
+	mu sync.Mutex
+}
+
+func (m *mockRouteExportProvider) GetRouteDetails() []RouteDetails {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.routeDetails
+}
+
+func (m *mockRouteExportProvider) setRoutes(routes []RouteDetails) {
+	m.mu.Lock()
+	m.routeDetails = routes
+	m.mu.Unlock()
+}
+
+func TestHandleRouteExport(t *testing.T) {
+	localID, _ := identity.NewAgentID()
+	peerID, _ := identity.NewAgentID()
+	exitID, _ := identity.NewAgentID()
+
+	s := NewServer(DefaultServerConfig(), &mockStatsProvider{running: true})
+	s.SetRemoteProvider(&mockRemoteStatusProvider{
+		id:           localID,
+		displayName:  "gateway",
+		peerDetails:  []PeerDetails{{ID: peerID, Transport: "quic"}},
+		displayNames: map[identity.AgentID]string{exitID: "exit-1"},
+		routeDetails: []RouteDetails{
+			{Network: "10.0.0.0/8", NextHop: peerID, Origin: exitID, Metric: 2, HopCount: 2, Path: []identity.AgentID{peerID, exitID}},
+			{Network: "fd00::/64", NextHop: peerID, Origin: exitID, Metric: 2, HopCount: 2, Path: []identity.AgentID{peerID, exitID}},
+			{Network: "192.168.0.0/16", Origin: localID},
+		},
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/api/routes/export", nil)
+	rec := httptest.NewRecorder()
+	s.server.Handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	var resp RouteExportResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if resp.Agent.ID != localID.String() || resp.Agent.DisplayName != "gateway" {
+		t.Errorf("agent = %+v", resp.Agent)
+	}
+	if len(resp.Routes) != 3 {
+		t.Fatalf("got %d routes, want 3", len(resp.Routes))
+	}
+
+	// Sorted by network
+	r := resp.Routes[0]
+	if r.Network != "10.0.0.0/8" || r.Family != "ipv4" || r.Local {
+		t.Errorf("routes[0] = %+v", r)
+	}
+	if r.NextHop == nil || r.NextHop.ID != peerID.String() || r.Transport != "quic" {
+		t.Errorf("routes[0] next hop = %+v, transport %q", r.NextHop, r.Transport)
+	}
+	if r.Origin.DisplayName != "exit-1" || len(r.Path) != 2 || r.Path[1] != exitID.String() {
+		t.Errorf("routes[0] origin = %+v, path = %v", r.Origin, r.Path)
+	}
+	if r := resp.Routes[1]; r.Network != "192.168.0.0/16" || !r.Local || r.NextHop != nil {
+		t.Errorf("routes[1] = %+v, want local route without next hop", r)
+	}
+	if r := resp.Routes[2]; r.Family != "ipv6" {
+		t.Errorf("routes[2] family = %q, want ipv6", r.Family)
+	}
+}
+
+func TestHandleRouteExport_Stream(t *testing.T) {
+	localID, _ := identity.NewAgentID()
+	peerID, _ := identity.NewAgentID()
+	exitID, _ := identity.NewAgentID()
+
+	route := func(network string) RouteDetails {
+		return RouteDetails{Network: network, NextHop: peerID, Origin: exitID, Metric: 1, HopCount: 1, Path: []identity.AgentID{exitID}}
+	}
+	provider := &mockRouteExportProvider{mockRemoteStatusProvider: mockRemoteStatusProvider{id: localID}}
+	provider.setRoutes([]RouteDetails{route("10.0.0.0/8")})
+
+	s := NewServer(DefaultServerConfig(), &mockStatsProvider{running: true})
+	s.SetRemoteProvider(provider)
+	ts := httptest.NewServer(s.server.Handler)
+	defer ts.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL+"/api/routes/export?stream=true", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+
+	lines := bufio.NewScanner(resp.Body)
+	next := func() RouteExportUpdate {
+		t.Helper()
+		if !lines.Scan() {
+			t.Fatalf("stream ended: %v", lines.Err())
+		}
+		var u RouteExportUpdate
+		if err := json.Unmarshal(lines.Bytes(), &u); err != nil {
+			t.Fatalf("decode %q: %v", lines.Text(), err)
+		}
+		return u
+	}
+
+	if u := next(); u.Type != RouteExportAdd || u.Route.Network != "10.0.0.0/8" {
+		t.Errorf("first update = %+v, want add 10.0.0.0/8", u)
+	}
+	if u := next(); u.Type != RouteExportSync {
+		t.Errorf("second update = %+v, want sync", u)
+	}
+
+	// A route event triggers a comparison with the table
+	provider.setRoutes([]RouteDetails{route("172.16.0.0/12")})
+	s.PublishEvent(EventRouteAdd, RouteEvent{Network: "172.16.0.0/12"})
+
+	got := map[string]string{}
+	for range 2 {
+		u := next()
+		got[u.Route.Network] = u.Type
+	}
+	if got["172.16.0.0/12"] != RouteExportAdd || got["10.0.0.0/8"] != RouteExportWithdraw {
+		t.Errorf("updates = %v, want add 172.16.0.0/12 and withdraw 10.0.0.0/8", got)
+	}
+
+	// Stopping the hub ends the stream
+	s.events.close()
+	if lines.Scan() {
+		t.Errorf("unexpected update after close: %s", lines.Text())
+	}
+}
+
+type mockRouteWatchProvider struct {
+	routes []SelectedRoute
+	seq    uint64
+}
+
+func (m *mockRouteWatchProvider) SelectedRoutes() ([]SelectedRoute, uint64) {
+	return m.routes, m.seq
+}
+
+func TestHandleRouteWatch(t *testing.T) {
+	provider := &mockRouteWatchProvider{
+		routes: []SelectedRoute{{Network: "10.0.0.0/8", Origin: RouteExportAgent{ShortID: "aaaa1111"}, Metric: 1}},
+		seq:    5,
+	}
+
+	s := NewServer(DefaultServerConfig(), &mockStatsProvider{running: true})
+	s.SetRouteWatchProvider(provider)
+	ts := httptest.NewServer(s.server.Handler)
+	defer ts.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL+"/api/routes/watch", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+
+	lines := bufio.NewScanner(resp.Body)
+	next := func() RouteWatchUpdate {
+		t.Helper()
+		if !lines.Scan() {
+			t.Fatalf("stream ended: %v", lines.Err())
+		}
+		var u RouteWatchUpdate
+		if err := json.Unmarshal(lines.Bytes(), &u); err != nil {
+			t.Fatalf("decode %q: %v", lines.Text(), err)
+		}
+		return u
+	}
+
+	if u := next(); u.Type != RouteWatchSnapshot || len(u.Routes) != 1 || u.Routes[0].Network != "10.0.0.0/8" {
+		t.Fatalf("first update = %+v, want snapshot with 10.0.0.0/8", u)
+	}
+
+	// Diffs already in the snapshot are skipped
+	s.PublishEvent(EventRouteDiff, RouteDiffEvent{Seq: 5, Kind: RouteDiffAdded, Network: "10.0.0.0/8"})
+	s.PublishEvent(EventRouteDiff, RouteDiffEvent{Seq: 6, Kind: RouteDiffRemoved, Network: "10.0.0.0/8", Reason: "withdraw"})
+	s.PublishEvent(EventConvergence, ConvergenceEvent{Changes: 1, DurationMs: 20, Settled: true})
+
+	if u := next(); u.Type != RouteWatchDiff || u.Diff.Seq != 6 || u.Diff.Kind != RouteDiffRemoved || u.Diff.Reason != "withdraw" {
+		t.Errorf("second update = %+v, want diff 6", u)
+	}
+	if u := next(); u.Type != RouteWatchConvergence || u.Convergence.Changes != 1 || !u.Convergence.Settled {
+		t.Errorf("third update = %+v, want convergence", u)
+	}
+
+	s.events.close()
+	if lines.Scan() {
+		t.Errorf("unexpected update after close: %s", lines.Text())
+	}
+}
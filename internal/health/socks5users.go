@@ -0,0 +1,75 @@
+package health
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/postalsys/muti-metroo/internal/identity"
+	"github.com/postalsys/muti-metroo/internal/protocol"
+	"github.com/postalsys/muti-metroo/internal/socks5"
+)
+
+// SOCKS5UsersManageRequest is a SOCKS5 user quota operation.
+type SOCKS5UsersManageRequest struct {
+	// Action is list or reset.
+	Action string `json:"action"`
+
+	// Username selects the user to reset. Reset without a username resets
+	// all users.
+	Username string `json:"username,omitempty"`
+}
+
+// SOCKS5UsersManageResult contains the response for a SOCKS5 user quota
+// operation.
+type SOCKS5UsersManageResult struct {
+	Status  string                   `json:"status"`
+	Message string                   `json:"message,omitempty"`
+	Users   []socks5.UserQuotaStatus `json:"users,omitempty"`
+	Reset   int                      `json:"reset,omitempty"`
+}
+
+// SOCKS5UsersManageProvider provides SOCKS5 user quota inspection and reset.
+type SOCKS5UsersManageProvider interface {
+	ManageSOCKS5Users(req SOCKS5UsersManageRequest) (*SOCKS5UsersManageResult, error)
+}
+
+// SetSOCKS5UsersManageProvider sets the SOCKS5 user quota provider.
+func (s *Server) SetSOCKS5UsersManageProvider(provider SOCKS5UsersManageProvider) {
+	s.socks5UsersManageProvider = provider
+}
+
+// handleSOCKS5UsersManage handles POST /socks5-users/manage for SOCKS5 user
+// expiry and quota usage.
+func (s *Server) handleSOCKS5UsersManage(w http.ResponseWriter, r *http.Request) {
+	if !requirePOST(w, r) {
+		return
+	}
+	if s.socks5UsersManageProvider == nil {
+		http.Error(w, "SOCKS5 user management not configured", http.StatusServiceUnavailable)
+		return
+	}
+	if s.shouldRestrictTopology() {
+		http.Error(w, "SOCKS5 user management restricted: management key decryption unavailable", http.StatusForbidden)
+		return
+	}
+
+	var req SOCKS5UsersManageRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request: " + err.Error()})
+		return
+	}
+
+	result, err := s.socks5UsersManageProvider.ManageSOCKS5Users(req)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+// handleRemoteSOCKS5UsersManage forwards SOCKS5 user quota requests to a
+// remote agent.
+func (s *Server) handleRemoteSOCKS5UsersManage(w http.ResponseWriter, r *http.Request, targetID identity.AgentID) {
+	s.forwardRemoteControl(w, r, targetID, protocol.ControlTypeSOCKS5UsersManage, "SOCKS5 user management")
+}
@@ -0,0 +1,116 @@
+package health
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// ErrStreamNotFound is returned by StreamProvider.KillStream for unknown stream IDs.
+var ErrStreamNotFound = errors.New("stream not found")
+
+// Stream directions reported by the streams API.
+const (
+	StreamDirectionOutbound = "outbound" // Opened by this agent (SOCKS5, forward, shell, file transfer)
+	StreamDirectionExit     = "exit"     // Terminated by this agent's exit handler
+	StreamDirectionRelay    = "relay"    // Forwarded through this agent (transit)
+)
+
+// StreamInfo describes an active stream for the /api/streams endpoint.
+// Byte counters are payload bytes as seen on the mesh side (after E2E encryption)
+// and frame counters count the STREAM_DATA frames carrying them.
+// For relay streams, bytes_sent is upstream -> downstream and bytes_recv is
+// downstream -> upstream. Latencies are measured from the open request and
+// are 0 until known.
+type StreamInfo struct {
+	ID             uint64  `json:"id"`
+	Direction      string  `json:"direction"`
+	UpstreamPeer   string  `json:"upstream_peer,omitempty"`   // Short ID of the hop toward the stream initiator
+	DownstreamPeer string  `json:"downstream_peer,omitempty"` // Short ID of the hop toward the exit
+	DownstreamID   uint64  `json:"downstream_id,omitempty"`   // Stream ID on the downstream connection (relay only)
+	Destination    string  `json:"destination,omitempty"`
+	AddressFamily  string  `json:"address_family,omitempty"` // "ipv4" or "ipv6" for exit streams
+	DialedAddr     string  `json:"dialed_addr,omitempty"`    // IP:port dialed by the exit handler
+	User           string  `json:"user,omitempty"`           // SOCKS5 account that opened the stream (outbound, or exit when shared)
+	Client         string  `json:"client,omitempty"`         // Client IP:port shared by the ingress (exit only)
+	State          string  `json:"state,omitempty"`
+	HalfClosed     string  `json:"half_closed,omitempty"` // "client" or "destination" when that side finished sending (exit only)
+	BytesSent      uint64  `json:"bytes_sent"`
+	BytesRecv      uint64  `json:"bytes_recv"`
+	FramesSent     uint64  `json:"frames_sent"`
+	FramesRecv     uint64  `json:"frames_recv"`
+	ConnectMs      float64 `json:"connect_ms,omitempty"`    // Until the stream opened (outbound) or the destination was dialed (exit)
+	FirstByteMs    float64 `json:"first_byte_ms,omitempty"` // Until the first byte arrived from the far end
+	AgeMs          int64   `json:"age_ms"`
+	IdleMs         int64   `json:"idle_ms"`
+}
+
+// DurationMs converts a duration to fractional milliseconds for the streams
+// API, rounded to microseconds.
+func DurationMs(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// StreamsResponse is the response for the /api/streams endpoint.
+type StreamsResponse struct {
+	Streams   []StreamInfo `json:"streams"`
+	Truncated bool         `json:"truncated,omitempty"` // Remote table cut to fit one control response
+}
+
+// StreamProvider provides stream inspection and termination.
+type StreamProvider interface {
+	// ListStreams returns all active local, exit, and relay streams.
+	ListStreams() []StreamInfo
+
+	// KillStream resets the stream with the given ID by sending STREAM_RESET
+	// to the adjacent peer(s). Returns ErrStreamNotFound for unknown IDs.
+	KillStream(streamID uint64) error
+}
+
+// handleStreams handles GET /api/streams for listing active streams.
+func (s *Server) handleStreams(w http.ResponseWriter, r *http.Request) {
+	if !requireGET(w, r) {
+		return
+	}
+	if s.streamProvider == nil {
+		http.Error(w, "stream provider not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	streams := s.streamProvider.ListStreams()
+	if streams == nil {
+		streams = []StreamInfo{}
+	}
+	writeJSON(w, http.StatusOK, StreamsResponse{Streams: streams})
+}
+
+// handleStreamKill handles POST /api/streams/kill to reset a stream.
+func (s *Server) handleStreamKill(w http.ResponseWriter, r *http.Request) {
+	if !requirePOST(w, r) {
+		return
+	}
+	if s.streamProvider == nil {
+		http.Error(w, "stream provider not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	var req struct {
+		StreamID uint64 `json:"stream_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request: " + err.Error()})
+		return
+	}
+
+	if err := s.streamProvider.KillStream(req.StreamID); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, ErrStreamNotFound) {
+			status = http.StatusNotFound
+		}
+		writeJSON(w, status, map[string]string{"error": err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
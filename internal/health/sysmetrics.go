@@ -0,0 +1,37 @@
+package health
+
+import (
+	"net/http"
+
+	"github.com/postalsys/muti-metroo/internal/sysinfo"
+)
+
+// SystemMetricsProvider provides live host resource metrics.
+type SystemMetricsProvider interface {
+	// SystemMetrics returns a reading of CPU, memory, disk, network
+	// interface and file descriptor usage.
+	SystemMetrics() *sysinfo.Metrics
+}
+
+// SetSystemMetricsProvider sets the host metrics provider.
+func (s *Server) SetSystemMetricsProvider(provider SystemMetricsProvider) {
+	s.systemMetricsProvider = provider
+}
+
+// handleSystemMetrics handles GET /system for the local host's resource
+// usage.
+func (s *Server) handleSystemMetrics(w http.ResponseWriter, r *http.Request) {
+	if !requireGET(w, r) {
+		return
+	}
+	if s.systemMetricsProvider == nil {
+		http.Error(w, "system metrics provider not configured", http.StatusServiceUnavailable)
+		return
+	}
+	if s.shouldRestrictTopology() {
+		http.Error(w, "system metrics restricted: management key decryption unavailable", http.StatusForbidden)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, s.systemMetricsProvider.SystemMetrics())
+}
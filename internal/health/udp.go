@@ -0,0 +1,51 @@
+package health
+
+import (
+	"net/http"
+)
+
+// UDPAssociationInfo describes an active UDP association on an exit node.
+// "Out" counters are datagrams relayed to destinations, "In" counters are
+// replies received from destinations. Byte counts are plaintext payload.
+type UDPAssociationInfo struct {
+	StreamID     uint64   `json:"stream_id"`
+	RequestID    uint64   `json:"request_id"`
+	Peer         string   `json:"peer"` // Short ID of the peer that opened the association
+	State        string   `json:"state"`
+	RelayAddr    string   `json:"relay_addr,omitempty"`
+	DatagramsOut uint64   `json:"datagrams_out"`
+	DatagramsIn  uint64   `json:"datagrams_in"`
+	BytesOut     uint64   `json:"bytes_out"`
+	BytesIn      uint64   `json:"bytes_in"`
+	Endpoints    []string `json:"endpoints"`
+	Filtered     uint64   `json:"datagrams_filtered"` // Replies dropped by restricted filtering
+	AgeMs        int64    `json:"age_ms"`
+	IdleMs       int64    `json:"idle_ms"`
+}
+
+// UDPAssociationsResponse is the response for the /api/udp endpoint and the
+// /agents/{id}/udp remote query.
+type UDPAssociationsResponse struct {
+	Enabled      bool                 `json:"enabled"`
+	Filtering    string               `json:"filtering,omitempty"` // full-cone, restricted or port-restricted
+	Associations []UDPAssociationInfo `json:"associations"`
+}
+
+// UDPProvider provides UDP association statistics.
+type UDPProvider interface {
+	// UDPAssociations returns statistics for all active UDP associations.
+	UDPAssociations() UDPAssociationsResponse
+}
+
+// handleUDPAssociations handles GET /api/udp for listing UDP associations.
+func (s *Server) handleUDPAssociations(w http.ResponseWriter, r *http.Request) {
+	if !requireGET(w, r) {
+		return
+	}
+	if s.udpProvider == nil {
+		http.Error(w, "UDP provider not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, s.udpProvider.UDPAssociations())
+}
@@ -0,0 +1,97 @@
+package health
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/postalsys/muti-metroo/internal/identity"
+	"github.com/postalsys/muti-metroo/internal/protocol"
+)
+
+// UpdateManageRequest is an agent binary update operation.
+type UpdateManageRequest struct {
+	// Action is status or apply.
+	Action string `json:"action"`
+
+	// SHA256 is the expected digest of the staged binary (apply only).
+	SHA256 string `json:"sha256,omitempty"`
+
+	// Version is the version the staged binary reports (apply only).
+	Version string `json:"version,omitempty"`
+
+	// Force allows installing a version older than the running one
+	// (apply only).
+	Force bool `json:"force,omitempty"`
+
+	// Signature is the hex Ed25519 signature of the update manifest
+	// (digest, version, target platform and force) made with the
+	// management signing key (apply only).
+	Signature string `json:"signature,omitempty"`
+
+	// Password is the file transfer password of the target agent (apply
+	// only).
+	Password string `json:"password,omitempty"`
+}
+
+// UpdateManageResult contains the response for an update operation.
+type UpdateManageResult struct {
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+
+	// Status fields
+	Version           string `json:"version,omitempty"`
+	OS                string `json:"os,omitempty"`
+	Arch              string `json:"arch,omitempty"`
+	Executable        string `json:"executable,omitempty"`
+	StagingPath       string `json:"staging_path,omitempty"`
+	SignatureRequired bool   `json:"signature_required,omitempty"`
+
+	// Apply fields
+	PreviousVersion string `json:"previous_version,omitempty"`
+	NewVersion      string `json:"new_version,omitempty"`
+	Method          string `json:"method,omitempty"`
+}
+
+// UpdateManageProvider provides agent binary updates.
+type UpdateManageProvider interface {
+	ManageUpdate(req UpdateManageRequest) (*UpdateManageResult, error)
+}
+
+// SetUpdateManageProvider sets the binary update provider.
+func (s *Server) SetUpdateManageProvider(provider UpdateManageProvider) {
+	s.updateManageProvider = provider
+}
+
+// handleUpdateManage handles POST /update/manage for agent binary updates.
+func (s *Server) handleUpdateManage(w http.ResponseWriter, r *http.Request) {
+	if !requirePOST(w, r) {
+		return
+	}
+	if s.updateManageProvider == nil {
+		http.Error(w, "update management not configured", http.StatusServiceUnavailable)
+		return
+	}
+	if s.shouldRestrictTopology() {
+		http.Error(w, "update management restricted: management key decryption unavailable", http.StatusForbidden)
+		return
+	}
+
+	var req UpdateManageRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request: " + err.Error()})
+		return
+	}
+
+	result, err := s.updateManageProvider.ManageUpdate(req)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+// handleRemoteUpdateManage forwards update requests to a remote agent.
+func (s *Server) handleRemoteUpdateManage(w http.ResponseWriter, r *http.Request, targetID identity.AgentID) {
+	s.forwardRemoteControl(w, r, targetID, protocol.ControlTypeUpdateManage, "update management")
+}
@@ -0,0 +1,44 @@
+package health
+
+import (
+	"net/http"
+
+	"github.com/postalsys/muti-metroo/internal/usage"
+)
+
+// UsageResponse is the response for the /usage endpoint and the
+// /agents/{id}/usage remote query. The report is omitted when usage
+// accounting is disabled.
+type UsageResponse struct {
+	Enabled bool `json:"enabled"`
+	*usage.Report
+}
+
+// UsageProvider provides bandwidth usage accounting.
+type UsageProvider interface {
+	// Usage returns the usage of the current and retained months.
+	Usage() UsageResponse
+}
+
+// SetUsageProvider sets the bandwidth usage provider.
+func (s *Server) SetUsageProvider(provider UsageProvider) {
+	s.usageProvider = provider
+}
+
+// handleUsage handles GET /usage for per-peer, per-user and per-destination
+// byte counters.
+func (s *Server) handleUsage(w http.ResponseWriter, r *http.Request) {
+	if !requireGET(w, r) {
+		return
+	}
+	if s.usageProvider == nil {
+		http.Error(w, "usage provider not configured", http.StatusServiceUnavailable)
+		return
+	}
+	if s.shouldRestrictTopology() {
+		http.Error(w, "usage restricted: management key decryption unavailable", http.StatusForbidden)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, s.usageProvider.Usage())
+}
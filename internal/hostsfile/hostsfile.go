@@ -0,0 +1,133 @@
+// Package hostsfile maintains the agent's own block of entries in a hosts
+// file such as /etc/hosts. Lines outside the block are never changed.
+package hostsfile
+
+import (
+	"bytes"
+	"fmt"
+	"maps"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+)
+
+const (
+	beginMarker = "# BEGIN muti-metroo"
+	endMarker   = "# END muti-metroo"
+)
+
+// File is a hosts file with an agent-managed block of entries.
+type File struct {
+	path string
+
+	mu      sync.Mutex
+	entries map[string]string // host name -> IP address
+}
+
+// New returns a File for the hosts file at path. The file is not touched
+// until the first entry is set.
+func New(path string) *File {
+	return &File{
+		path:    path,
+		entries: make(map[string]string),
+	}
+}
+
+// Path returns the hosts file path.
+func (f *File) Path() string {
+	return f.path
+}
+
+// Set maps name to ip, replacing any earlier address of name.
+func (f *File) Set(name string, ip net.IP) error {
+	if name == "" || strings.ContainsAny(name, " \t\r\n#") {
+		return fmt.Errorf("invalid host name %q", name)
+	}
+	if ip == nil {
+		return fmt.Errorf("no address for %s", name)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.entries[strings.ToLower(name)] = ip.String()
+	return f.write()
+}
+
+// Remove deletes the entry of name.
+func (f *File) Remove(name string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	name = strings.ToLower(name)
+	if _, ok := f.entries[name]; !ok {
+		return nil
+	}
+	delete(f.entries, name)
+	return f.write()
+}
+
+// Close removes the managed block from the hosts file.
+func (f *File) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	clear(f.entries)
+	return f.write()
+}
+
+// write replaces the managed block with the current entries. The file is
+// rewritten in place rather than renamed over, so hosts files that are bind
+// mounts (as in containers) keep working.
+func (f *File) write() error {
+	data, err := os.ReadFile(f.path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("read hosts file: %w", err)
+	}
+	hadBlock := bytes.Contains(data, []byte(beginMarker))
+	if !hadBlock && len(f.entries) == 0 {
+		return nil
+	}
+
+	out := stripBlock(data)
+	if len(f.entries) > 0 {
+		if len(out) > 0 && !bytes.HasSuffix(out, []byte("\n")) {
+			out = append(out, '\n')
+		}
+		out = append(out, beginMarker+"\n"...)
+		for _, name := range slices.Sorted(maps.Keys(f.entries)) {
+			out = append(out, f.entries[name]+"\t"+name+"\n"...)
+		}
+		out = append(out, endMarker+"\n"...)
+	}
+
+	perm := os.FileMode(0644)
+	if info, err := os.Stat(f.path); err == nil {
+		perm = info.Mode().Perm()
+	}
+	if err := os.WriteFile(f.path, out, perm); err != nil {
+		return fmt.Errorf("write hosts file: %w", err)
+	}
+	return nil
+}
+
+// stripBlock returns data without the managed block, including blocks left
+// behind by an agent that did not shut down cleanly.
+func stripBlock(data []byte) []byte {
+	var out []byte
+	inBlock := false
+	for _, line := range bytes.SplitAfter(data, []byte("\n")) {
+		switch string(bytes.TrimSpace(line)) {
+		case beginMarker:
+			inBlock = true
+		case endMarker:
+			inBlock = false
+		default:
+			if !inBlock {
+				out = append(out, line...)
+			}
+		}
+	}
+	return out
+}
@@ -0,0 +1,79 @@
+package hostsfile
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const original = "127.0.0.1\tlocalhost\n::1\tlocalhost\n"
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	return string(data)
+}
+
+func TestFile_SetRemoveClose(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hosts")
+	if err := os.WriteFile(path, []byte(original), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	f := New(path)
+	if err := f.Set("wiki.mesh.local", net.IPv4(127, 0, 0, 1)); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if err := f.Set("Git.Mesh.Local", net.ParseIP("10.0.0.5")); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	want := original + beginMarker + "\n10.0.0.5\tgit.mesh.local\n127.0.0.1\twiki.mesh.local\n" + endMarker + "\n"
+	if got := readFile(t, path); got != want {
+		t.Errorf("after Set:\n%s\nwant:\n%s", got, want)
+	}
+
+	if err := f.Remove("wiki.mesh.local"); err != nil {
+		t.Fatalf("Remove() error = %v", err)
+	}
+	want = original + beginMarker + "\n10.0.0.5\tgit.mesh.local\n" + endMarker + "\n"
+	if got := readFile(t, path); got != want {
+		t.Errorf("after Remove:\n%s\nwant:\n%s", got, want)
+	}
+
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if got := readFile(t, path); got != original {
+		t.Errorf("after Close:\n%s\nwant:\n%s", got, original)
+	}
+}
+
+func TestFile_ReplacesStaleBlock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hosts")
+	stale := "127.0.0.1\tlocalhost\n" + beginMarker + "\n10.9.9.9\told.mesh.local\n" + endMarker + "\n10.1.1.1\tother\n"
+	if err := os.WriteFile(path, []byte(stale), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	f := New(path)
+	if err := f.Set("new.mesh.local", net.IPv4(127, 0, 0, 1)); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	want := "127.0.0.1\tlocalhost\n10.1.1.1\tother\n" + beginMarker + "\n127.0.0.1\tnew.mesh.local\n" + endMarker + "\n"
+	if got := readFile(t, path); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestFile_InvalidName(t *testing.T) {
+	f := New(filepath.Join(t.TempDir(), "hosts"))
+	for _, name := range []string{"", "two names", "evil\n1.2.3.4 bank"} {
+		if err := f.Set(name, net.IPv4(127, 0, 0, 1)); err == nil {
+			t.Errorf("Set(%q) accepted an invalid name", name)
+		}
+	}
+}
@@ -2,6 +2,9 @@ package icmp
 
 import (
 	"time"
+
+	"github.com/postalsys/muti-metroo/internal/crypto"
+	"github.com/postalsys/muti-metroo/internal/exit"
 )
 
 // Config holds configuration for the ICMP echo handler.
@@ -25,6 +28,39 @@ type Config struct {
 	// MaxConcurrentReplies limits concurrent reply-waiting goroutines.
 	// 0 means unlimited (default).
 	MaxConcurrentReplies int
+
+	// MaxSessionsPerPeer limits concurrent sessions opened through one peer
+	// (the neighbor that delivered ICMP_OPEN). 0 means unlimited.
+	MaxSessionsPerPeer int
+
+	// DestRate limits echo requests per second to each destination IP.
+	// 0 means unlimited.
+	DestRate float64
+
+	// DestBurst is the per-destination token bucket size.
+	// 0 means one second worth of DestRate.
+	DestBurst int
+
+	// GlobalRate limits echo requests per second across all sessions.
+	// 0 means unlimited.
+	GlobalRate float64
+
+	// GlobalBurst is the global token bucket size.
+	// 0 means one second worth of GlobalRate.
+	GlobalBurst int
+
+	// Bind selects the source address of echo sockets per destination. ICMP
+	// sockets cannot be bound to a device, so a bind interface is applied
+	// through its address.
+	Bind exit.BindConfig
+
+	// Private refuses echo sessions to destinations in the exit's own
+	// network.
+	Private exit.PrivateFilter
+
+	// Rekey sets when sessions replace their E2E key, for ingresses that
+	// ask for it. Zero disables rekeying.
+	Rekey crypto.RekeyConfig
 }
 
 // DefaultConfig returns a Config with sensible defaults.
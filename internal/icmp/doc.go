@@ -22,7 +22,18 @@
 //
 //	sysctl -w net.ipv4.ping_group_range="0 65535"
 //
-// This allows non-root users to send ICMP echo requests.
+// This allows non-root users to send ICMP echo requests. The same sysctl
+// covers ICMPv6 sockets.
+//
+// # IPv6
+//
+// The exit node picks ICMPv4 or ICMPv6 from the length of the ICMP_OPEN
+// destination (4 or 16 bytes). Without unprivileged ICMPv6 sockets, IPv6
+// falls back to a raw socket when the agent has CAP_NET_RAW; raw sockets see
+// every ICMPv6 message on the host, so replies are matched by echo ID.
+// Neighbor discovery and other non-echo messages are skipped, and error
+// replies (unreachable, packet too big, time exceeded, parameter problem)
+// are returned as *ReplyError.
 //
 // # Configuration
 //
@@ -6,6 +6,7 @@ import (
 	"log/slog"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/postalsys/muti-metroo/internal/crypto"
@@ -46,6 +47,33 @@ type Handler struct {
 
 	// Semaphore for limiting concurrent reply goroutines
 	replySem chan struct{}
+
+	// Echo rate limits; nil when none are configured
+	limiter *echoLimiter
+
+	counters counters
+}
+
+// counters are the cumulative handler counters reported by Stats.
+type counters struct {
+	sessionsOpened    atomic.Uint64
+	sessionsRejected  atomic.Uint64
+	echoRequests      atomic.Uint64
+	echoReplies       atomic.Uint64
+	destRateLimited   atomic.Uint64
+	globalRateLimited atomic.Uint64
+}
+
+// Stats is a snapshot of the handler counters. Counters are cumulative since
+// the handler was created.
+type Stats struct {
+	ActiveSessions    int
+	SessionsOpened    uint64 // Sessions acknowledged with ICMP_OPEN_ACK
+	SessionsRejected  uint64 // ICMP_OPEN refused by the total or per-peer session cap
+	EchoRequests      uint64 // Echo requests sent to destinations
+	EchoReplies       uint64 // Echo replies relayed back through the mesh
+	DestRateLimited   uint64 // Echo requests dropped by the per-destination rate limit
+	GlobalRateLimited uint64 // Echo requests dropped by the global rate limit
 }
 
 // NewHandler creates a new ICMP handler.
@@ -60,6 +88,7 @@ func NewHandler(cfg Config, writer DataWriter, logger *slog.Logger) *Handler {
 		logger:      logger.With(slog.String("component", "icmp")),
 		ctx:         ctx,
 		cancel:      cancel,
+		limiter:     newEchoLimiter(cfg),
 	}
 
 	// Initialize reply semaphore if limit is configured
@@ -103,12 +132,40 @@ func (h *Handler) HandleICMPOpen(
 		return fmt.Errorf("ICMP echo is disabled")
 	}
 
-	// Check session limit
+	// The destination length selects the address family
+	if len(open.DestIP) != net.IPv4len && len(open.DestIP) != net.IPv6len {
+		h.writer.WriteICMPOpenErr(peerID, streamID, &protocol.ICMPOpenErr{
+			RequestID: open.RequestID,
+			ErrorCode: protocol.ErrGeneralFailure,
+			Message:   fmt.Sprintf("invalid destination address length %d", len(open.DestIP)),
+		})
+		return fmt.Errorf("invalid destination address length %d", len(open.DestIP))
+	}
+
+	if h.config.Private.Blocks(destIP) {
+		h.writer.WriteICMPOpenErr(peerID, streamID, &protocol.ICMPOpenErr{
+			RequestID: open.RequestID,
+			ErrorCode: protocol.ErrPrivateDestination,
+			Message:   "destination in private range not allowed",
+		})
+		return fmt.Errorf("destination %s in private range not allowed", destIP)
+	}
+
+	// Check session limits
 	h.mu.RLock()
 	count := len(h.sessions)
+	peerCount := 0
+	if h.config.MaxSessionsPerPeer > 0 {
+		for _, s := range h.sessions {
+			if s.PeerID == peerID {
+				peerCount++
+			}
+		}
+	}
 	h.mu.RUnlock()
 
 	if h.config.MaxSessions > 0 && count >= h.config.MaxSessions {
+		h.counters.sessionsRejected.Add(1)
 		h.writer.WriteICMPOpenErr(peerID, streamID, &protocol.ICMPOpenErr{
 			RequestID: open.RequestID,
 			ErrorCode: protocol.ErrICMPSessionLimit,
@@ -117,11 +174,25 @@ func (h *Handler) HandleICMPOpen(
 		return fmt.Errorf("session limit reached")
 	}
 
+	if h.config.MaxSessionsPerPeer > 0 && peerCount >= h.config.MaxSessionsPerPeer {
+		h.counters.sessionsRejected.Add(1)
+		h.writer.WriteICMPOpenErr(peerID, streamID, &protocol.ICMPOpenErr{
+			RequestID: open.RequestID,
+			ErrorCode: protocol.ErrICMPSessionLimit,
+			Message:   "ICMP session limit reached for peer",
+		})
+		return fmt.Errorf("per-peer session limit reached for %s", peerID.ShortString())
+	}
+
 	// Create session
 	session := NewSession(streamID, open.RequestID, peerID, destIP)
 
 	// Create ICMP socket for the appropriate IP version
-	sock, err := NewSocket(destIP)
+	srcIP, err := h.config.Bind.For(destIP).SourceIP(destIP)
+	var sock *Socket
+	if err == nil {
+		sock, err = NewSocketFrom(destIP, srcIP)
+	}
 	if err != nil {
 		h.writer.WriteICMPOpenErr(peerID, streamID, &protocol.ICMPOpenErr{
 			RequestID: open.RequestID,
@@ -161,6 +232,9 @@ func (h *Handler) HandleICMPOpen(
 	}
 	if hasEncryption {
 		ack.EphemeralPubKey = ephPub
+		if h.rekeys(open.E2EFlags) {
+			ack.E2EFlags = protocol.E2EFlagRekey
+		}
 	}
 
 	if err := h.writer.WriteICMPOpenAck(peerID, streamID, ack); err != nil {
@@ -169,6 +243,7 @@ func (h *Handler) HandleICMPOpen(
 	}
 
 	session.SetOpen()
+	h.counters.sessionsOpened.Add(1)
 
 	if hasEncryption {
 		h.logger.Info("ICMP session established",
@@ -180,6 +255,12 @@ func (h *Handler) HandleICMPOpen(
 	return nil
 }
 
+// rekeys reports whether a session opened with the E2E flags of the
+// ingress replaces its keys.
+func (h *Handler) rekeys(e2eFlags uint8) bool {
+	return e2eFlags&protocol.E2EFlagRekey != 0 && h.config.Rekey.Enabled()
+}
+
 // performKeyExchange generates an ephemeral keypair and derives the session key.
 // Returns the public key for inclusion in the ack, or an error.
 func (h *Handler) performKeyExchange(
@@ -213,11 +294,14 @@ func (h *Handler) performKeyExchange(
 		return zeroKey, fmt.Errorf("ECDH: %w", err)
 	}
 
-	crypto.ZeroKey(&ephPriv)
-
 	// Derive session key (we are responder)
 	sessionKey := crypto.DeriveSessionKey(sharedSecret, open.RequestID, remoteEphemeralPub, ephPub, false)
+	sessionKey.EnableReplayWindow() // Echoes may be lost or reordered
 	crypto.ZeroKey(&sharedSecret)
+	if h.rekeys(open.E2EFlags) {
+		sessionKey.EnableRekey(ephPriv, remoteEphemeralPub, h.config.Rekey)
+	}
+	crypto.ZeroKey(&ephPriv)
 
 	session.SetSessionKey(sessionKey)
 
@@ -246,9 +330,12 @@ func (h *Handler) HandleICMPEcho(peerID identity.AgentID, streamID uint64, echo
 		return nil
 	}
 
-	// Decrypt payload
+	// Decrypt payload. An echo that fails validation means someone on the
+	// path is tampering with or replaying the session, so end it.
 	plaintext, err := session.Decrypt(echo.Data)
 	if err != nil {
+		h.writer.WriteICMPClose(session.PeerID, streamID, protocol.ICMPCloseE2EValidation)
+		h.removeSession(streamID)
 		return fmt.Errorf("decrypt: %w", err)
 	}
 
@@ -257,10 +344,23 @@ func (h *Handler) HandleICMPEcho(peerID identity.AgentID, streamID uint64, echo
 		return fmt.Errorf("ICMP socket closed")
 	}
 
+	// Rate limited echoes are dropped; the client sees a lost reply
+	if h.limiter != nil {
+		if ok, destLimited := h.limiter.allow(session.DestIP.String(), time.Now()); !ok {
+			if destLimited {
+				h.counters.destRateLimited.Add(1)
+			} else {
+				h.counters.globalRateLimited.Add(1)
+			}
+			return nil
+		}
+	}
+
 	// Send echo request
 	if err := sock.SendEchoRequest(session.DestIP, echo.Identifier, echo.Sequence, plaintext); err != nil {
 		return fmt.Errorf("send echo: %w", err)
 	}
+	h.counters.echoRequests.Add(1)
 
 	// Wait for reply
 	timeout := h.config.EchoTimeout
@@ -294,7 +394,8 @@ func (h *Handler) waitForReply(session *Session, identifier, sequence uint16, ti
 
 	reply, err := sock.ReadEchoReplyFiltered(identifier, timeout)
 	if err != nil {
-		// Timeout or error, don't send anything
+		// Timeout, ICMP error reply (unreachable, time exceeded) or socket
+		// error; the ingress sees a lost reply
 		h.logger.Debug("ICMP reply timeout or error",
 			logging.KeyStreamID, session.StreamID,
 			"identifier", identifier,
@@ -329,7 +430,9 @@ func (h *Handler) waitForReply(session *Session, identifier, sequence uint16, ti
 		h.logger.Error("failed to send ICMP reply",
 			logging.KeyStreamID, session.StreamID,
 			"error", err)
+		return
 	}
+	h.counters.echoReplies.Add(1)
 }
 
 // HandleICMPClose processes an ICMP_CLOSE frame.
@@ -370,6 +473,19 @@ func (h *Handler) ActiveCount() int {
 	return len(h.sessions)
 }
 
+// Stats returns a snapshot of the handler counters.
+func (h *Handler) Stats() Stats {
+	return Stats{
+		ActiveSessions:    h.ActiveCount(),
+		SessionsOpened:    h.counters.sessionsOpened.Load(),
+		SessionsRejected:  h.counters.sessionsRejected.Load(),
+		EchoRequests:      h.counters.echoRequests.Load(),
+		EchoReplies:       h.counters.echoReplies.Load(),
+		DestRateLimited:   h.counters.destRateLimited.Load(),
+		GlobalRateLimited: h.counters.globalRateLimited.Load(),
+	}
+}
+
 // Close shuts down the handler and all sessions.
 func (h *Handler) Close() error {
 	h.cancel()
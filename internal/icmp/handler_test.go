@@ -8,6 +8,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/postalsys/muti-metroo/internal/exit"
 	"github.com/postalsys/muti-metroo/internal/identity"
 	"github.com/postalsys/muti-metroo/internal/protocol"
 )
@@ -172,6 +173,69 @@ func TestHandler_HandleICMPOpen_Disabled(t *testing.T) {
 	}
 }
 
+func TestHandler_HandleICMPOpen_InvalidDestLength(t *testing.T) {
+	writer := newMockDataWriter()
+	h := NewHandler(DefaultConfig(), writer, slog.Default())
+	defer h.Close()
+
+	peerID, _ := identity.NewAgentID()
+	open := &protocol.ICMPOpen{
+		RequestID: 777,
+		DestIP:    []byte{10, 0, 0},
+		TTL:       64,
+	}
+
+	var zeroKey [protocol.EphemeralKeySize]byte
+	if err := h.HandleICMPOpen(context.Background(), peerID, 1, open, zeroKey); err == nil {
+		t.Error("HandleICMPOpen() should reject a 3 byte destination")
+	}
+
+	errs := writer.getOpenErrs()
+	if len(errs) != 1 {
+		t.Fatalf("Expected 1 error, got %d", len(errs))
+	}
+	if errs[0].ErrorCode != protocol.ErrGeneralFailure {
+		t.Errorf("ErrorCode = %d, want %d", errs[0].ErrorCode, protocol.ErrGeneralFailure)
+	}
+	if h.ActiveCount() != 0 {
+		t.Errorf("ActiveCount() = %d, want 0", h.ActiveCount())
+	}
+}
+
+func TestHandler_HandleICMPOpen_PrivateDestination(t *testing.T) {
+	writer := newMockDataWriter()
+	cfg := DefaultConfig()
+	cfg.Private = exit.PrivateFilter{Block: true}
+	h := NewHandler(cfg, writer, slog.Default())
+	defer h.Close()
+
+	peerID, _ := identity.NewAgentID()
+	var zeroKey [protocol.EphemeralKeySize]byte
+	for i, dest := range []string{"192.168.1.1", "64:ff9b::a00:1", "ff02::1"} {
+		ip := net.ParseIP(dest)
+		if ip4 := ip.To4(); ip4 != nil {
+			ip = ip4
+		}
+		open := &protocol.ICMPOpen{RequestID: uint64(i + 1), DestIP: ip, TTL: 64}
+		if err := h.HandleICMPOpen(context.Background(), peerID, uint64(i+1), open, zeroKey); err == nil {
+			t.Errorf("HandleICMPOpen(%s) should reject a private destination", dest)
+		}
+	}
+
+	errs := writer.getOpenErrs()
+	if len(errs) != 3 {
+		t.Fatalf("Expected 3 errors, got %d", len(errs))
+	}
+	for _, e := range errs {
+		if e.ErrorCode != protocol.ErrPrivateDestination {
+			t.Errorf("ErrorCode = %d, want %d", e.ErrorCode, protocol.ErrPrivateDestination)
+		}
+	}
+	if h.ActiveCount() != 0 {
+		t.Errorf("ActiveCount() = %d, want 0", h.ActiveCount())
+	}
+}
+
 func TestHandler_HandleICMPOpen_SessionLimit(t *testing.T) {
 	writer := newMockDataWriter()
 	logger := slog.Default()
@@ -219,6 +283,37 @@ func TestHandler_HandleICMPOpen_SessionLimit(t *testing.T) {
 	}
 }
 
+func TestHandler_HandleICMPOpen_PerPeerLimit(t *testing.T) {
+	writer := newMockDataWriter()
+	cfg := DefaultConfig()
+	cfg.MaxSessionsPerPeer = 1
+
+	h := NewHandler(cfg, writer, slog.Default())
+	defer h.Close()
+
+	busyPeer, _ := identity.NewAgentID()
+	destIP := net.ParseIP("8.8.8.8")
+
+	// Fill the busy peer's allowance
+	h.mu.Lock()
+	h.sessions[999] = NewSession(999, 999, busyPeer, destIP)
+	h.mu.Unlock()
+
+	open := &protocol.ICMPOpen{RequestID: 12345, DestIP: destIP.To4(), TTL: 64}
+	var zeroKey [protocol.EphemeralKeySize]byte
+	if err := h.HandleICMPOpen(context.Background(), busyPeer, 1, open, zeroKey); err == nil {
+		t.Fatal("HandleICMPOpen() should refuse a second session from the same peer")
+	}
+
+	errs := writer.getOpenErrs()
+	if len(errs) != 1 || errs[0].ErrorCode != protocol.ErrICMPSessionLimit {
+		t.Fatalf("open errors = %+v, want one ErrICMPSessionLimit", errs)
+	}
+	if got := h.Stats().SessionsRejected; got != 1 {
+		t.Errorf("SessionsRejected = %d, want 1", got)
+	}
+}
+
 func TestHandler_HandleICMPClose(t *testing.T) {
 	writer := newMockDataWriter()
 	logger := slog.Default()
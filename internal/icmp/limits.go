@@ -0,0 +1,101 @@
+package icmp
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// destPruneInterval is how often idle per-destination buckets are dropped.
+const destPruneInterval = time.Minute
+
+// echoLimiter applies the per-destination and global echo rate limits with
+// token buckets. A nil limiter for either scope means unlimited.
+type echoLimiter struct {
+	global *rate.Limiter
+
+	destRate  rate.Limit
+	destBurst int
+
+	mu        sync.Mutex
+	dests     map[string]*destBucket
+	lastPrune time.Time
+}
+
+// destBucket is the token bucket of one destination IP.
+type destBucket struct {
+	limiter  *rate.Limiter
+	lastUsed time.Time
+}
+
+// newEchoLimiter creates the limiter for cfg. Returns nil when no rate limit
+// is configured.
+func newEchoLimiter(cfg Config) *echoLimiter {
+	if cfg.DestRate <= 0 && cfg.GlobalRate <= 0 {
+		return nil
+	}
+	l := &echoLimiter{}
+	if cfg.GlobalRate > 0 {
+		l.global = rate.NewLimiter(rate.Limit(cfg.GlobalRate), burstFor(cfg.GlobalRate, cfg.GlobalBurst))
+	}
+	if cfg.DestRate > 0 {
+		l.destRate = rate.Limit(cfg.DestRate)
+		l.destBurst = burstFor(cfg.DestRate, cfg.DestBurst)
+		l.dests = make(map[string]*destBucket)
+	}
+	return l
+}
+
+// burstFor returns the configured burst, or one second worth of tokens
+// (at least one) when it is not set.
+func burstFor(perSecond float64, burst int) int {
+	if burst > 0 {
+		return burst
+	}
+	return max(1, int(math.Ceil(perSecond)))
+}
+
+// allow reports whether an echo request to dest may be sent now. When it is
+// refused, destLimited tells whether the per-destination limit refused it
+// rather than the global one.
+func (l *echoLimiter) allow(dest string, now time.Time) (ok, destLimited bool) {
+	if l.dests != nil && !l.allowDest(dest, now) {
+		return false, true
+	}
+	if l.global != nil && !l.global.AllowN(now, 1) {
+		return false, false
+	}
+	return true, false
+}
+
+// allowDest takes a token from the bucket of dest.
+func (l *echoLimiter) allowDest(dest string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastPrune) >= destPruneInterval {
+		l.pruneLocked(now)
+	}
+
+	b, ok := l.dests[dest]
+	if !ok {
+		b = &destBucket{limiter: rate.NewLimiter(l.destRate, l.destBurst)}
+		l.dests[dest] = b
+	}
+	b.lastUsed = now
+	return b.limiter.AllowN(now, 1)
+}
+
+// pruneLocked drops buckets idle long enough to have refilled, since a new
+// bucket behaves the same. Caller must hold l.mu.
+func (l *echoLimiter) pruneLocked(now time.Time) {
+	l.lastPrune = now
+	refill := time.Duration(float64(l.destBurst) / float64(l.destRate) * float64(time.Second))
+	for dest, b := range l.dests {
+		if now.Sub(b.lastUsed) >= refill {
+			delete(l.dests, dest)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package icmp
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNewEchoLimiter_Unlimited(t *testing.T) {
+	if l := newEchoLimiter(DefaultConfig()); l != nil {
+		t.Errorf("newEchoLimiter() = %+v, want nil without rate limits", l)
+	}
+}
+
+func TestEchoLimiter_DestRate(t *testing.T) {
+	l := newEchoLimiter(Config{DestRate: 1, DestBurst: 2})
+	now := time.Now()
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.allow("10.0.0.1", now); !ok {
+			t.Fatalf("echo %d within burst refused", i)
+		}
+	}
+	ok, destLimited := l.allow("10.0.0.1", now)
+	if ok || !destLimited {
+		t.Errorf("allow() after burst = %v, %v, want false, true", ok, destLimited)
+	}
+
+	// Other destinations have their own bucket
+	if ok, _ := l.allow("10.0.0.2", now); !ok {
+		t.Error("echo to another destination refused")
+	}
+
+	// One token refills after a second
+	if ok, _ := l.allow("10.0.0.1", now.Add(time.Second)); !ok {
+		t.Error("echo after refill refused")
+	}
+}
+
+func TestEchoLimiter_GlobalRate(t *testing.T) {
+	l := newEchoLimiter(Config{GlobalRate: 2})
+	now := time.Now()
+
+	// Burst defaults to one second of the rate
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.allow("10.0.0.1", now); !ok {
+			t.Fatalf("echo %d within burst refused", i)
+		}
+	}
+	ok, destLimited := l.allow("10.0.0.2", now)
+	if ok || destLimited {
+		t.Errorf("allow() after burst = %v, %v, want false, false", ok, destLimited)
+	}
+}
+
+func TestEchoLimiter_PrunesIdleDestinations(t *testing.T) {
+	l := newEchoLimiter(Config{DestRate: 10})
+	now := time.Now()
+
+	l.allow("10.0.0.1", now)
+	l.allow("10.0.0.2", now.Add(destPruneInterval))
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.dests["10.0.0.1"]; ok {
+		t.Error("idle destination bucket not pruned")
+	}
+	if len(l.dests) != 1 {
+		t.Errorf("len(dests) = %d, want 1", len(l.dests))
+	}
+}
+
+func TestBurstFor(t *testing.T) {
+	tests := []struct {
+		rate  float64
+		burst int
+		want  int
+	}{
+		{10, 0, 10},
+		{0.5, 0, 1},
+		{2.5, 0, 3},
+		{10, 4, 4},
+	}
+	for _, tt := range tests {
+		if got := burstFor(tt.rate, tt.burst); got != tt.want {
+			t.Errorf("burstFor(%v, %d) = %d, want %d", tt.rate, tt.burst, got, tt.want)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package icmp
 
 import (
+	"encoding/binary"
 	"fmt"
 	"net"
 	"time"
@@ -16,43 +17,88 @@ const (
 	ICMPv6ProtocolNumber = 58
 )
 
+// ipv6HeaderLen is the fixed IPv6 header that precedes the invoking packet
+// quoted in ICMPv6 error messages.
+const ipv6HeaderLen = 40
+
 // Socket wraps an ICMP packet connection with IP version information.
 type Socket struct {
 	conn   *icmp.PacketConn
 	isIPv6 bool
+	raw    bool // Privileged raw socket: sees all ICMP traffic of the host
+}
+
+// ReplyError is an ICMP error message received in response to an echo
+// request, such as destination unreachable or time exceeded.
+type ReplyError struct {
+	Type  icmp.Type
+	Code  int
+	SrcIP net.IP // Router or host that sent the error
+}
+
+func (e *ReplyError) Error() string {
+	return fmt.Sprintf("%v (code %d) from %s", e.Type, e.Code, e.SrcIP)
 }
 
 // NewSocket creates an ICMP socket for the given IP address.
 // For IPv4 addresses, creates an IPv4 ICMP socket.
 // For IPv6 addresses, creates an IPv6 ICMP socket.
 func NewSocket(destIP net.IP) (*Socket, error) {
+	return NewSocketFrom(destIP, nil)
+}
+
+// NewSocketFrom creates an ICMP socket for destIP bound to the source address
+// srcIP. A nil srcIP leaves the source to the operating system.
+func NewSocketFrom(destIP, srcIP net.IP) (*Socket, error) {
 	isIPv6 := destIP.To4() == nil
 
 	if isIPv6 {
-		return NewSocketV6()
+		return newSocketV6(listenAddr(srcIP, "::"))
 	}
-	return NewSocketV4()
+	return newSocketV4(listenAddr(srcIP, "0.0.0.0"))
 }
 
 // NewSocketV4 creates a new unprivileged IPv4 ICMP socket.
 // Uses "udp4" network which allows unprivileged ICMP on Linux when
 // net.ipv4.ping_group_range sysctl is properly configured.
 func NewSocketV4() (*Socket, error) {
-	conn, err := icmp.ListenPacket("udp4", "0.0.0.0")
+	return newSocketV4("0.0.0.0")
+}
+
+func newSocketV4(addr string) (*Socket, error) {
+	conn, err := icmp.ListenPacket("udp4", addr)
 	if err != nil {
 		return nil, fmt.Errorf("create ICMP socket: %w", err)
 	}
 	return &Socket{conn: conn, isIPv6: false}, nil
 }
 
-// NewSocketV6 creates a new unprivileged IPv6 ICMP socket.
-// Uses "udp6" network which allows unprivileged ICMPv6 on Linux.
+// NewSocketV6 creates a new IPv6 ICMP socket.
+// Uses the unprivileged "udp6" network where available (governed by the same
+// net.ipv4.ping_group_range sysctl on Linux) and falls back to a raw ICMPv6
+// socket when the agent runs with the privileges for one.
 func NewSocketV6() (*Socket, error) {
-	conn, err := icmp.ListenPacket("udp6", "::")
-	if err != nil {
+	return newSocketV6("::")
+}
+
+func newSocketV6(addr string) (*Socket, error) {
+	conn, err := icmp.ListenPacket("udp6", addr)
+	if err == nil {
+		return &Socket{conn: conn, isIPv6: true}, nil
+	}
+	rawConn, rawErr := icmp.ListenPacket("ip6:ipv6-icmp", addr)
+	if rawErr != nil {
 		return nil, fmt.Errorf("create ICMPv6 socket: %w", err)
 	}
-	return &Socket{conn: conn, isIPv6: true}, nil
+	return &Socket{conn: rawConn, isIPv6: true, raw: true}, nil
+}
+
+// listenAddr returns srcIP as a listen address, or wildcard when it is nil.
+func listenAddr(srcIP net.IP, wildcard string) string {
+	if srcIP == nil {
+		return wildcard
+	}
+	return srcIP.String()
 }
 
 // Close closes the socket.
@@ -68,6 +114,9 @@ func (s *Socket) SendEchoRequest(destIP net.IP, id, seq uint16, payload []byte)
 	if s.isIPv6 {
 		msgType = ipv6.ICMPTypeEchoRequest
 		destAddr = &net.UDPAddr{IP: destIP.To16()}
+		if s.raw {
+			destAddr = &net.IPAddr{IP: destIP.To16()}
+		}
 	} else {
 		msgType = ipv4.ICMPTypeEcho
 		ip4 := destIP.To4()
@@ -111,67 +160,112 @@ type EchoReply struct {
 // ReadEchoReply reads an ICMP echo reply from the socket.
 // Returns the reply data or an error if timeout is reached.
 func (s *Socket) ReadEchoReply(timeout time.Duration) (*EchoReply, error) {
+	return s.readEchoReply(0, false, timeout)
+}
+
+// ReadEchoReplyFiltered reads an echo reply with timeout.
+// Note: For unprivileged ICMP sockets, we accept any reply since
+// each session has its own socket and the kernel may assign different IDs.
+// Raw sockets see the replies of every ping on the host, so there only
+// replies and errors for expectedID are accepted.
+func (s *Socket) ReadEchoReplyFiltered(expectedID uint16, timeout time.Duration) (*EchoReply, error) {
+	return s.readEchoReply(expectedID, s.raw, timeout)
+}
+
+// readEchoReply reads until an echo reply or an ICMP error for one of our
+// echo requests arrives, or the timeout expires. Other ICMP messages, such as
+// neighbor discovery and echo requests seen by raw sockets, are skipped.
+func (s *Socket) readEchoReply(expectedID uint16, filterID bool, timeout time.Duration) (*EchoReply, error) {
 	if err := s.conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
 		return nil, fmt.Errorf("set read deadline: %w", err)
 	}
 
-	buf := make([]byte, 1500)
-	n, peer, err := s.conn.ReadFrom(buf)
-	if err != nil {
-		return nil, err // Timeout or other error
-	}
-
-	// Parse ICMP message based on IP version
-	var protoNum int
-	var expectedType icmp.Type
-
+	protoNum := ICMPv4ProtocolNumber
 	if s.isIPv6 {
 		protoNum = ICMPv6ProtocolNumber
-		expectedType = ipv6.ICMPTypeEchoReply
-	} else {
-		protoNum = ICMPv4ProtocolNumber
-		expectedType = ipv4.ICMPTypeEchoReply
 	}
 
-	msg, err := icmp.ParseMessage(protoNum, buf[:n])
-	if err != nil {
-		return nil, fmt.Errorf("parse ICMP: %w", err)
+	buf := make([]byte, 1500)
+	for {
+		n, peer, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			return nil, err // Timeout or other error
+		}
+
+		msg, err := icmp.ParseMessage(protoNum, buf[:n])
+		if err != nil {
+			continue
+		}
+
+		srcIP := addrIP(peer)
+		switch body := msg.Body.(type) {
+		case *icmp.Echo:
+			if msg.Type != ipv4.ICMPTypeEchoReply && msg.Type != ipv6.ICMPTypeEchoReply {
+				continue
+			}
+			if filterID && uint16(body.ID) != expectedID {
+				continue
+			}
+			return &EchoReply{
+				ID:      uint16(body.ID),
+				Seq:     uint16(body.Seq),
+				Payload: append([]byte(nil), body.Data...),
+				SrcIP:   srcIP,
+			}, nil
+		case *icmp.DstUnreach, *icmp.TimeExceeded, *icmp.PacketTooBig, *icmp.ParamProb:
+			if filterID {
+				id, ok := s.invokingEchoID(errorData(body))
+				if !ok || id != expectedID {
+					continue
+				}
+			}
+			return nil, &ReplyError{Type: msg.Type, Code: msg.Code, SrcIP: srcIP}
+		}
 	}
+}
 
-	// Check for echo reply
-	if msg.Type != expectedType {
-		return nil, fmt.Errorf("unexpected ICMP type: %v (expected %v)", msg.Type, expectedType)
+// errorData returns the invoking packet quoted in an ICMP error body.
+func errorData(body icmp.MessageBody) []byte {
+	switch b := body.(type) {
+	case *icmp.DstUnreach:
+		return b.Data
+	case *icmp.TimeExceeded:
+		return b.Data
+	case *icmp.PacketTooBig:
+		return b.Data
+	case *icmp.ParamProb:
+		return b.Data
 	}
+	return nil
+}
 
-	echo, ok := msg.Body.(*icmp.Echo)
-	if !ok {
-		return nil, fmt.Errorf("invalid echo body")
+// invokingEchoID extracts the echo identifier from the packet quoted in an
+// ICMP error. The quoted packet starts with the IP header.
+func (s *Socket) invokingEchoID(data []byte) (uint16, bool) {
+	hdrLen := ipv6HeaderLen
+	echoType := byte(ipv6.ICMPTypeEchoRequest)
+	if !s.isIPv6 {
+		if len(data) < ipv4.HeaderLen {
+			return 0, false
+		}
+		hdrLen = int(data[0]&0x0f) * 4
+		echoType = byte(ipv4.ICMPTypeEcho)
+	}
+	if len(data) < hdrLen+8 || data[hdrLen] != echoType {
+		return 0, false
 	}
+	return binary.BigEndian.Uint16(data[hdrLen+4:]), true
+}
 
-	// Extract source IP
-	var srcIP net.IP
-	switch addr := peer.(type) {
+// addrIP returns the IP of a packet source address.
+func addrIP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
 	case *net.UDPAddr:
-		srcIP = addr.IP
+		return a.IP
 	case *net.IPAddr:
-		srcIP = addr.IP
-	default:
-		srcIP = nil
+		return a.IP
 	}
-
-	return &EchoReply{
-		ID:      uint16(echo.ID),
-		Seq:     uint16(echo.Seq),
-		Payload: echo.Data,
-		SrcIP:   srcIP,
-	}, nil
-}
-
-// ReadEchoReplyFiltered reads an echo reply with timeout.
-// Note: For unprivileged ICMP sockets, we accept any reply since
-// each session has its own socket and the kernel may assign different IDs.
-func (s *Socket) ReadEchoReplyFiltered(expectedID uint16, timeout time.Duration) (*EchoReply, error) {
-	return s.ReadEchoReply(timeout)
+	return nil
 }
 
 // IsIPv6 returns true if this socket is for IPv6.
@@ -8,6 +8,7 @@ import (
 
 	"golang.org/x/net/icmp"
 	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
 )
 
 // newICMPSocket creates a new unprivileged IPv4 ICMP socket for testing.
@@ -393,3 +394,54 @@ func TestICMPv6ProtocolNumber(t *testing.T) {
 		t.Errorf("ICMPv6ProtocolNumber = %d, want 58", ICMPv6ProtocolNumber)
 	}
 }
+
+func TestSocket_InvokingEchoID(t *testing.T) {
+	echo := func(typ icmp.Type, id int) []byte {
+		b, err := (&icmp.Message{Type: typ, Body: &icmp.Echo{ID: id, Seq: 1}}).Marshal(nil)
+		if err != nil {
+			t.Fatalf("Marshal() error = %v", err)
+		}
+		return b
+	}
+
+	v6Header := make([]byte, ipv6HeaderLen)
+	v6Header[0] = 0x60
+	v4Header := make([]byte, ipv4.HeaderLen)
+	v4Header[0] = 0x45
+
+	tests := []struct {
+		name   string
+		isIPv6 bool
+		data   []byte
+		wantID uint16
+		wantOK bool
+	}{
+		{"ipv6 echo request", true, append(v6Header, echo(ipv6.ICMPTypeEchoRequest, 4242)...), 4242, true},
+		{"ipv6 other type", true, append(v6Header, echo(ipv6.ICMPTypeEchoReply, 4242)...), 0, false},
+		{"ipv6 truncated", true, v6Header, 0, false},
+		{"ipv4 echo request", false, append(v4Header, echo(ipv4.ICMPTypeEcho, 99)...), 99, true},
+		{"ipv4 truncated", false, []byte{0x45}, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Socket{isIPv6: tt.isIPv6}
+			id, ok := s.invokingEchoID(tt.data)
+			if ok != tt.wantOK || id != tt.wantID {
+				t.Errorf("invokingEchoID() = %d, %v, want %d, %v", id, ok, tt.wantID, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestReplyError_Error(t *testing.T) {
+	err := &ReplyError{
+		Type:  ipv6.ICMPTypeDestinationUnreachable,
+		Code:  3,
+		SrcIP: net.ParseIP("2001:db8::1"),
+	}
+	want := "destination unreachable (code 3) from 2001:db8::1"
+	if err.Error() != want {
+		t.Errorf("Error() = %q, want %q", err.Error(), want)
+	}
+}
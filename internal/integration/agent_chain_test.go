@@ -9,6 +9,7 @@ import (
 	"io"
 	"net"
 	"os"
+	"path/filepath"
 	"sync"
 	"testing"
 	"time"
@@ -86,6 +87,24 @@ type AgentChain struct {
 	// it: the exit so it can send ICMP packets, and the ingress so its
 	// SOCKS5 server gets a non-nil icmpHandler for the CmdICMPEcho path.
 	ICMPConfigure func(*config.ICMPConfig)
+	// NATTraversal, when non-nil, sets cfg.Connections.NATTraversal on
+	// every agent.
+	NATTraversal *config.NATTraversalConfig
+	// Chaos, when non-nil, sets cfg.Chaos on every agent.
+	Chaos *config.ChaosConfig
+	// Reconnect, when non-nil, sets cfg.Connections.Reconnect on every
+	// agent.
+	Reconnect *config.ReconnectConfig
+	// AdvertiseInterval, when non-zero, sets cfg.Routing.AdvertiseInterval
+	// on every agent.
+	AdvertiseInterval time.Duration
+	// E2E, when non-nil, sets cfg.E2E on every agent.
+	E2E *config.E2EConfig
+	// RBAC, when non-nil, sets cfg.RBAC on the exit node (D).
+	RBAC *config.RBACConfig
+	// Transport is the peer transport between agents (default quic). With
+	// "mem" the agents are connected in memory, without sockets or TLS.
+	Transport string
 }
 
 // CertPair holds TLS certificate and key file paths.
@@ -188,6 +207,11 @@ func (c *AgentChain) buildConfig(i int) *config.Config {
 			},
 		},
 	}
+	if c.Transport == string(transport.TransportMemory) {
+		cfg.Listeners = []config.ListenerConfig{
+			{Transport: c.Transport, Address: c.memoryAddress(i)},
+		}
+	}
 
 	// Add peer connections based on topology A-B-C-D
 	// A (0) connects to B (1)
@@ -202,6 +226,10 @@ func (c *AgentChain) buildConfig(i int) *config.Config {
 				TLS:       config.TLSConfig{},
 			},
 		}
+		if c.Transport == string(transport.TransportMemory) {
+			cfg.Peers[0].Transport = c.Transport
+			cfg.Peers[0].Address = c.memoryAddress(i + 1)
+		}
 	}
 
 	// D is the exit node
@@ -237,6 +265,10 @@ func (c *AgentChain) buildConfig(i int) *config.Config {
 		if c.ICMPConfigure != nil {
 			c.ICMPConfigure(&cfg.ICMP)
 		}
+
+		if c.RBAC != nil {
+			cfg.RBAC = *c.RBAC
+		}
 	}
 
 	// A has SOCKS5 enabled
@@ -264,6 +296,22 @@ func (c *AgentChain) buildConfig(i int) *config.Config {
 		}
 	}
 
+	if c.NATTraversal != nil {
+		cfg.Connections.NATTraversal = *c.NATTraversal
+	}
+	if c.Chaos != nil {
+		cfg.Chaos = *c.Chaos
+	}
+	if c.Reconnect != nil {
+		cfg.Connections.Reconnect = *c.Reconnect
+	}
+	if c.AdvertiseInterval > 0 {
+		cfg.Routing.AdvertiseInterval = c.AdvertiseInterval
+	}
+	if c.E2E != nil {
+		cfg.E2E = *c.E2E
+	}
+
 	// Apply per-agent forward endpoints/listeners (opt-in via fixture fields)
 	if eps, ok := c.ForwardEndpoints[i]; ok {
 		cfg.Forward.Endpoints = eps
@@ -275,6 +323,12 @@ func (c *AgentChain) buildConfig(i int) *config.Config {
 	return cfg
 }
 
+// memoryAddress returns the memory listener address of agent i. The data
+// directory name keeps it unique among chains in the same process.
+func (c *AgentChain) memoryAddress(i int) string {
+	return filepath.Base(c.DataDirs[i])
+}
+
 // StartAgents starts all agents in order (D first, then C, B, A).
 func (c *AgentChain) StartAgents(t *testing.T) {
 	// Start in reverse order so listeners are ready for connections
@@ -717,6 +771,62 @@ func TestAgentChain_SOCKS5Connectivity(t *testing.T) {
 		stats.PeerCount, stats.RouteCount)
 }
 
+// TestAgentChain_MemoryTransport runs the 4-agent chain over the memory
+// transport and echoes data from A through B and C to an upstream behind D.
+func TestAgentChain_MemoryTransport(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	chain := NewAgentChain(t)
+	chain.Transport = string(transport.TransportMemory)
+	defer chain.Close()
+
+	chain.CreateAgents(t)
+	chain.StartAgents(t)
+	chain.VerifyConnectivity(t)
+	if !chain.WaitForRoutes(t) {
+		t.Fatal("Route propagation failed")
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to start echo server: %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(c net.Conn) {
+				defer c.Close()
+				io.Copy(c, c)
+			}(conn)
+		}
+	}()
+
+	conn, err := chain.Agents[0].Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial through mesh failed: %v", err)
+	}
+	defer conn.Close()
+
+	want := make([]byte, 256*1024)
+	rand.Read(want)
+	go conn.Write(want)
+
+	got := make([]byte, len(want))
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	if _, err := io.ReadFull(conn, got); err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if !bytes.Equal(want, got) {
+		t.Error("Echo mismatch")
+	}
+}
+
 // generateSelfSignedCert generates a self-signed TLS certificate.
 func generateSelfSignedCert() (tls.Certificate, error) {
 	certPEM, keyPEM, err := transport.GenerateSelfSignedCert("test", 24*time.Hour)
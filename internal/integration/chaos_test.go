@@ -0,0 +1,148 @@
+package integration
+
+import (
+	"testing"
+	"time"
+
+	"github.com/postalsys/muti-metroo/internal/agent"
+	"github.com/postalsys/muti-metroo/internal/config"
+	"github.com/postalsys/muti-metroo/internal/health"
+	"github.com/postalsys/muti-metroo/internal/transport"
+)
+
+// newChaosChain starts a memory transport chain with fault injection
+// enabled on every agent, quick reconnects and frequent advertisements.
+func newChaosChain(t *testing.T) *AgentChain {
+	t.Helper()
+
+	chain := NewAgentChain(t)
+	chain.Transport = string(transport.TransportMemory)
+	chain.Chaos = &config.ChaosConfig{Enabled: true, Seed: 1}
+	chain.Reconnect = &config.ReconnectConfig{
+		InitialDelay: 100 * time.Millisecond,
+		MaxDelay:     500 * time.Millisecond,
+		Multiplier:   2.0,
+	}
+	chain.AdvertiseInterval = time.Second
+	t.Cleanup(chain.Close)
+
+	chain.CreateAgents(t)
+	chain.StartAgents(t)
+	if !chain.WaitForRoutes(t) {
+		t.Fatal("Route propagation failed")
+	}
+	return chain
+}
+
+// hasRouteFrom reports whether a has a route originated by origin.
+func hasRouteFrom(a, origin *agent.Agent) bool {
+	for _, r := range a.GetRoutes() {
+		if r.OriginAgent == origin.ID() {
+			return true
+		}
+	}
+	return false
+}
+
+// waitFor polls cond until it holds or timeout passes.
+func waitFor(timeout time.Duration, cond func() bool) bool {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return true
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return cond()
+}
+
+func manageChaos(t *testing.T, a *agent.Agent, req health.ChaosManageRequest) *health.ChaosManageResult {
+	t.Helper()
+	res, err := a.ManageChaos(req)
+	if err != nil {
+		t.Fatalf("ManageChaos(%s) error = %v", req.Action, err)
+	}
+	return res
+}
+
+// TestChaos_PartitionAndHeal cuts the B-C link of the chain and checks that
+// B loses the exit route and traffic from A fails, then heals it and checks
+// that the link comes back and the next advertisement from D restores the
+// route and traffic.
+func TestChaos_PartitionAndHeal(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	chain := newChaosChain(t)
+	a, b, c, d := chain.Agents[0], chain.Agents[1], chain.Agents[2], chain.Agents[3]
+	echoAddr, stopEcho := startEchoServer(t)
+	defer stopEcho()
+
+	res := manageChaos(t, b, health.ChaosManageRequest{Action: "partition", Peer: c.ID().String()[:8]})
+	if len(res.Partitions) != 1 || res.Partitions[0] != c.ID().String() {
+		t.Fatalf("Partitions = %v, want [%s]", res.Partitions, c.ID())
+	}
+	if !waitFor(10*time.Second, func() bool { return !hasRouteFrom(b, d) }) {
+		t.Fatal("exit route still present on B after partition")
+	}
+	if _, err := a.Dial("tcp", echoAddr.String()); err == nil {
+		t.Error("Dial through a partitioned mesh succeeded")
+	}
+
+	// Reconnects keep failing their handshake while partitioned
+	time.Sleep(time.Second)
+	if hasRouteFrom(b, d) || b.Stats().PeerCount != 1 {
+		t.Fatal("B reconnected to C while partitioned")
+	}
+
+	res = manageChaos(t, b, health.ChaosManageRequest{Action: "heal"})
+	if len(res.Partitions) != 0 || res.Stats.Partitioned == 0 {
+		t.Fatalf("after heal: partitions %v, partitioned frames %d", res.Partitions, res.Stats.Partitioned)
+	}
+	if !waitFor(10*time.Second, func() bool { return b.Stats().PeerCount == 2 }) {
+		t.Fatal("B did not reconnect to C after heal")
+	}
+	if !waitFor(10*time.Second, func() bool { return hasRouteFrom(b, d) }) {
+		t.Fatal("exit route did not return to B after heal")
+	}
+
+	conn, err := a.Dial("tcp", echoAddr.String())
+	if err != nil {
+		t.Fatalf("Dial after heal failed: %v", err)
+	}
+	defer conn.Close()
+	echoRoundTrip(t, conn, []byte("healed"))
+}
+
+// TestChaos_Latency adds latency on the ingress agent and checks that it
+// shows in round trips through the mesh.
+func TestChaos_Latency(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	chain := newChaosChain(t)
+	a := chain.Agents[0]
+	echoAddr, stopEcho := startEchoServer(t)
+	defer stopEcho()
+
+	manageChaos(t, a, health.ChaosManageRequest{Action: "set", Latency: "100ms"})
+
+	conn, err := a.Dial("tcp", echoAddr.String())
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+
+	start := time.Now()
+	echoRoundTrip(t, conn, []byte("slow"))
+	if rtt := time.Since(start); rtt < 100*time.Millisecond {
+		t.Errorf("round trip took %v, want at least 100ms", rtt)
+	}
+
+	res := manageChaos(t, a, health.ChaosManageRequest{Action: "status"})
+	if res.Latency != "100ms" || res.Stats.Delayed == 0 {
+		t.Errorf("status = latency %s, %d delayed frames", res.Latency, res.Stats.Delayed)
+	}
+}
@@ -0,0 +1,75 @@
+package integration
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/postalsys/muti-metroo/internal/config"
+	"github.com/postalsys/muti-metroo/internal/socks5"
+)
+
+// TestE2E_RekeyLongStream sends several rekey periods of data through an
+// echo server, so both directions replace their keys mid-stream.
+func TestE2E_RekeyLongStream(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	echoAddr, echoCleanup := startEchoServer(t)
+	defer echoCleanup()
+
+	chain := NewAgentChain(t)
+	chain.E2E = &config.E2EConfig{Rekey: config.E2ERekeyConfig{
+		Enabled: true,
+		Bytes:   config.MinRekeyBytes,
+	}}
+	defer chain.Close()
+
+	chain.CreateAgents(t)
+	chain.StartAgents(t)
+	if !chain.WaitForRoutes(t) {
+		t.Fatal("Routes did not propagate")
+	}
+
+	conn := socks5Handshake(t, chain.Agents[0].SOCKS5Address().String())
+	defer conn.Close()
+
+	req := &bytes.Buffer{}
+	req.Write([]byte{socks5.SOCKS5Version, socks5.CmdConnect, 0x00, socks5.AddrTypeIPv4})
+	req.Write(echoAddr.IP.To4())
+	_ = binary.Write(req, binary.BigEndian, uint16(echoAddr.Port))
+	if _, err := conn.Write(req.Bytes()); err != nil {
+		t.Fatalf("Failed to write CONNECT: %v", err)
+	}
+	code, err := readSocks5Reply(conn, 10*time.Second)
+	if err != nil || code != socks5.ReplySucceeded {
+		t.Fatalf("CONNECT failed: code %d, error %v", code, err)
+	}
+
+	data := make([]byte, 4*config.MinRekeyBytes+12345)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+
+	writeErr := make(chan error, 1)
+	go func() {
+		_, err := conn.Write(data)
+		writeErr <- err
+	}()
+
+	conn.SetReadDeadline(time.Now().Add(60 * time.Second))
+	got := make([]byte, len(data))
+	if _, err := io.ReadFull(conn, got); err != nil {
+		t.Fatalf("Echo read failed: %v", err)
+	}
+	if err := <-writeErr; err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("Echoed data differs from sent data")
+	}
+}
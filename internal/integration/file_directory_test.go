@@ -26,8 +26,20 @@ import (
 func uploadDirectory(t *testing.T, agentAddr, targetID, localDir, remoteDir, password string) (*uploadResponse, error) {
 	t.Helper()
 
+	fields := map[string]string{}
+	if password != "" {
+		fields["password"] = password
+	}
+	return uploadDirectoryWith(t, agentAddr, targetID, localDir, remoteDir, filetransfer.CopyOptions{}, fields)
+}
+
+// uploadDirectoryWith is uploadDirectory with the archive built using opts
+// and extra form fields.
+func uploadDirectoryWith(t *testing.T, agentAddr, targetID, localDir, remoteDir string, opts filetransfer.CopyOptions, fields map[string]string) (*uploadResponse, error) {
+	t.Helper()
+
 	var tarBuf bytes.Buffer
-	if err := filetransfer.TarDirectory(localDir, &tarBuf); err != nil {
+	if err := filetransfer.TarDirectory(localDir, &tarBuf, opts); err != nil {
 		return nil, fmt.Errorf("tar %s: %w", localDir, err)
 	}
 
@@ -47,8 +59,8 @@ func uploadDirectory(t *testing.T, agentAddr, targetID, localDir, remoteDir, pas
 	if err := writer.WriteField("directory", "true"); err != nil {
 		return nil, err
 	}
-	if password != "" {
-		if err := writer.WriteField("password", password); err != nil {
+	for name, value := range fields {
+		if err := writer.WriteField(name, value); err != nil {
 			return nil, err
 		}
 	}
@@ -84,7 +96,15 @@ func uploadDirectory(t *testing.T, agentAddr, targetID, localDir, remoteDir, pas
 func downloadDirectory(t *testing.T, agentAddr, targetID, remoteDir, localDir, password string) error {
 	t.Helper()
 
-	reqBody, err := json.Marshal(downloadRequest{Path: remoteDir, Password: password})
+	return downloadDirectoryWith(t, agentAddr, targetID, downloadRequest{Path: remoteDir, Password: password}, localDir, filetransfer.CopyOptions{})
+}
+
+// downloadDirectoryWith is downloadDirectory for a full request, extracting
+// with opts.
+func downloadDirectoryWith(t *testing.T, agentAddr, targetID string, request downloadRequest, localDir string, opts filetransfer.CopyOptions) error {
+	t.Helper()
+
+	reqBody, err := json.Marshal(request)
 	if err != nil {
 		return err
 	}
@@ -111,7 +131,7 @@ func downloadDirectory(t *testing.T, agentAddr, targetID, remoteDir, localDir, p
 	if err := os.MkdirAll(localDir, 0o755); err != nil {
 		return err
 	}
-	return extractTarStream(resp.Body, localDir)
+	return extractTarStream(resp.Body, localDir, opts)
 }
 
 // extractTarStream is a thin wrapper around filetransfer.UntarDirectory used
@@ -119,8 +139,8 @@ func downloadDirectory(t *testing.T, agentAddr, targetID, remoteDir, localDir, p
 // application/gzip and (since the streamReader directory fix) the body
 // actually contains gzip-wrapped tar, which is exactly what UntarDirectory
 // expects.
-func extractTarStream(r io.Reader, destDir string) error {
-	return filetransfer.UntarDirectory(r, destDir)
+func extractTarStream(r io.Reader, destDir string, opts filetransfer.CopyOptions) error {
+	return filetransfer.UntarDirectory(r, destDir, opts)
 }
 
 // dirFile describes an expected file in a directory tree: its relative
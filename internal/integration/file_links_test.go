@@ -0,0 +1,118 @@
+//go:build !windows
+
+// Package integration provides integration tests for Muti Metroo.
+package integration
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/postalsys/muti-metroo/internal/config"
+	"github.com/postalsys/muti-metroo/internal/filetransfer"
+)
+
+// TestFileTransfer_DirectoryLinks uploads a directory with a sparse file, a
+// hardlink and symlinks through the mesh and downloads it again with
+// symlinks followed.
+func TestFileTransfer_DirectoryLinks(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	tmpDir := t.TempDir()
+
+	ftCfg := &config.FileTransferConfig{
+		Enabled:      true,
+		AllowedPaths: []string{tmpDir},
+	}
+
+	chain := newFileTransferTestChain(t, ftCfg)
+	defer chain.Close()
+
+	chain.CreateAgents(t)
+	chain.StartAgents(t)
+
+	time.Sleep(3 * time.Second)
+
+	targetID := chain.Agents[3].ID().String()
+	addr := chain.HTTPAddrs[0]
+
+	// 4 MB file with data only in its first and last block
+	localDir := t.TempDir()
+	image := make([]byte, 4*1024*1024)
+	copy(image, "header")
+	copy(image[len(image)-6:], "footer")
+	os.WriteFile(filepath.Join(localDir, "data.img"), image, 0644)
+	os.WriteFile(filepath.Join(localDir, "a.txt"), []byte("shared"), 0644)
+	if err := os.Link(filepath.Join(localDir, "a.txt"), filepath.Join(localDir, "b.txt")); err != nil {
+		t.Fatalf("Failed to create hardlink: %v", err)
+	}
+	os.Symlink("a.txt", filepath.Join(localDir, "cfg"))
+	os.Symlink("/etc/hostname", filepath.Join(localDir, "hostname"))
+
+	remoteDir := filepath.Join(tmpDir, "tree")
+
+	// A symlink pointing outside the tree is refused unless allowed
+	result, err := uploadDirectoryWith(t, addr, targetID, localDir, filepath.Join(tmpDir, "refused"), filetransfer.CopyOptions{}, nil)
+	if err != nil {
+		t.Fatalf("Upload request failed: %v", err)
+	}
+	if result.Success {
+		t.Error("Upload with an external symlink should fail without external_symlinks")
+	}
+
+	result, err = uploadDirectoryWith(t, addr, targetID, localDir, remoteDir, filetransfer.CopyOptions{}, map[string]string{
+		"sparse":            "true",
+		"external_symlinks": "true",
+	})
+	if err != nil || !result.Success {
+		t.Fatalf("Upload failed: %v %+v", err, result)
+	}
+
+	data, err := os.ReadFile(filepath.Join(remoteDir, "data.img"))
+	if err != nil || !bytes.Equal(data, image) {
+		t.Fatalf("Remote data.img differs from the original (err %v)", err)
+	}
+	var st syscall.Stat_t
+	if err := syscall.Stat(filepath.Join(remoteDir, "data.img"), &st); err == nil && st.Blocks*512 >= int64(len(image)) {
+		t.Logf("data.img allocates %d bytes; file system may not support holes", st.Blocks*512)
+	}
+
+	a, errA := os.Stat(filepath.Join(remoteDir, "a.txt"))
+	b, errB := os.Stat(filepath.Join(remoteDir, "b.txt"))
+	if errA != nil || errB != nil || !os.SameFile(a, b) {
+		t.Errorf("Remote a.txt and b.txt are not the same file (%v, %v)", errA, errB)
+	}
+	for name, want := range map[string]string{"cfg": "a.txt", "hostname": "/etc/hostname"} {
+		if target, err := os.Readlink(filepath.Join(remoteDir, name)); err != nil || target != want {
+			t.Errorf("Remote %s -> %q (%v), want %q", name, target, err, want)
+		}
+	}
+
+	// Download with symlinks followed: cfg becomes a copy of a.txt, while
+	// hostname points outside the allowed paths and stays a link
+	downloadDir := filepath.Join(t.TempDir(), "download")
+	err = downloadDirectoryWith(t, addr, targetID, downloadRequest{Path: remoteDir, Symlinks: filetransfer.SymlinkFollow}, downloadDir,
+		filetransfer.CopyOptions{ExternalSymlinks: true})
+	if err != nil {
+		t.Fatalf("Download failed: %v", err)
+	}
+
+	info, err := os.Lstat(filepath.Join(downloadDir, "cfg"))
+	if err != nil || !info.Mode().IsRegular() {
+		t.Errorf("Downloaded cfg should be a regular file (%v)", err)
+	} else if data, _ := os.ReadFile(filepath.Join(downloadDir, "cfg")); string(data) != "shared" {
+		t.Errorf("Downloaded cfg = %q, want %q", data, "shared")
+	}
+	if target, err := os.Readlink(filepath.Join(downloadDir, "hostname")); err != nil || target != "/etc/hostname" {
+		t.Errorf("Downloaded hostname -> %q (%v), want /etc/hostname", target, err)
+	}
+	data, err = os.ReadFile(filepath.Join(downloadDir, "data.img"))
+	if err != nil || !bytes.Equal(data, image) {
+		t.Errorf("Downloaded data.img differs from the original (err %v)", err)
+	}
+}
@@ -0,0 +1,135 @@
+// Package integration provides integration tests for Muti Metroo.
+package integration
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/postalsys/muti-metroo/internal/config"
+	"github.com/postalsys/muti-metroo/internal/filetransfer"
+)
+
+// downloadRange POSTs a ranged download request and returns the body and
+// the checksum trailer.
+func downloadRange(agentAddr, targetID string, req downloadRequest) ([]byte, string, error) {
+	body, _ := json.Marshal(req)
+	url := fmt.Sprintf("http://%s/agents/%s/file/download", agentAddr, targetID)
+	client := &http.Client{Timeout: 60 * time.Second}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("status %d: %s", resp.StatusCode, data)
+	}
+	return data, resp.Trailer.Get("X-Checksum-Sha256"), nil
+}
+
+// TestFileTransfer_ParallelRanges downloads a file as parallel ranges the
+// way `muti-metroo download --parallel` does and reassembles it.
+func TestFileTransfer_ParallelRanges(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	tmpDir := t.TempDir()
+
+	ftCfg := &config.FileTransferConfig{
+		Enabled:      true,
+		AllowedPaths: []string{tmpDir},
+	}
+
+	chain := newFileTransferTestChain(t, ftCfg)
+	defer chain.Close()
+
+	chain.CreateAgents(t)
+	chain.StartAgents(t)
+
+	time.Sleep(3 * time.Second)
+
+	targetID := chain.Agents[3].ID().String()
+	addr := chain.HTTPAddrs[0]
+
+	content := make([]byte, 3*filetransfer.MinParallelChunk+12345)
+	rand.Read(content)
+	remotePath := filepath.Join(tmpDir, "large.bin")
+	if err := os.WriteFile(remotePath, content, 0644); err != nil {
+		t.Fatalf("Failed to create remote file: %v", err)
+	}
+
+	ranges := filetransfer.SplitRanges(int64(len(content)), 8)
+	if len(ranges) != 3 {
+		t.Fatalf("SplitRanges() = %d ranges, want 3", len(ranges))
+	}
+
+	result := make([]byte, len(content))
+	var wg sync.WaitGroup
+	errs := make([]error, len(ranges))
+	for i, r := range ranges {
+		wg.Add(1)
+		go func(i int, r filetransfer.ByteRange) {
+			defer wg.Done()
+			// Route indexes wrap around, so any index is valid on a chain
+			data, checksum, err := downloadRange(addr, targetID, downloadRequest{
+				Path:         remotePath,
+				Offset:       r.Offset,
+				Length:       r.Length,
+				OriginalSize: int64(len(content)),
+				Route:        i,
+			})
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			if int64(len(data)) != r.Length {
+				errs[i] = fmt.Errorf("received %d bytes, want %d", len(data), r.Length)
+				return
+			}
+
+			// Each range carries the checksum of just its own bytes
+			sum := sha256.Sum256(content[r.Offset : r.Offset+r.Length])
+			if checksum != hex.EncodeToString(sum[:]) {
+				errs[i] = fmt.Errorf("checksum = %q, want checksum of the range", checksum)
+				return
+			}
+			copy(result[r.Offset:], data)
+		}(i, r)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("Range %d failed: %v", i, err)
+		}
+	}
+	if !bytes.Equal(result, content) {
+		t.Error("Reassembled file differs from the original")
+	}
+
+	// A range past the end of the file is rejected
+	_, _, err := downloadRange(addr, targetID, downloadRequest{
+		Path:   remotePath,
+		Offset: int64(len(content)) - 10,
+		Length: 20,
+	})
+	if err == nil {
+		t.Error("Range past the end of the file should fail")
+	}
+}
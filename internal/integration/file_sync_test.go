@@ -0,0 +1,153 @@
+// Package integration provides integration tests for Muti Metroo.
+package integration
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/postalsys/muti-metroo/internal/config"
+	"github.com/postalsys/muti-metroo/internal/filetransfer"
+)
+
+// browseRemote POSTs a file browse request to the target agent.
+func browseRemote(t *testing.T, agentAddr, targetID string, req *filetransfer.BrowseRequest) *filetransfer.BrowseResponse {
+	t.Helper()
+
+	body, _ := json.Marshal(req)
+	url := fmt.Sprintf("http://%s/agents/%s/file/browse", agentAddr, targetID)
+	client := &http.Client{Timeout: 60 * time.Second}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("browse %s request failed: %v", req.Action, err)
+	}
+	defer resp.Body.Close()
+
+	var result filetransfer.BrowseResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatalf("browse %s: decode response (status %d): %v", req.Action, resp.StatusCode, err)
+	}
+	if result.Error != "" {
+		t.Fatalf("browse %s error: %s", req.Action, result.Error)
+	}
+	return &result
+}
+
+// remoteManifest reads the full manifest of a remote directory page by page.
+func remoteManifest(t *testing.T, agentAddr, targetID, dir string) []filetransfer.ManifestEntry {
+	t.Helper()
+
+	var entries []filetransfer.ManifestEntry
+	for {
+		page := browseRemote(t, agentAddr, targetID, &filetransfer.BrowseRequest{
+			Action: "manifest",
+			Path:   dir,
+			Offset: len(entries),
+			Limit:  100,
+		})
+		entries = append(entries, page.Manifest...)
+		if !page.Truncated || len(page.Manifest) == 0 {
+			return entries
+		}
+	}
+}
+
+// TestFileTransfer_SyncManifest syncs a directory through the mesh the way
+// `muti-metroo sync` does: compare manifests, delete, upload only the
+// changed files, and check that a second pass finds nothing to do.
+func TestFileTransfer_SyncManifest(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	tmpDir := t.TempDir()
+
+	ftCfg := &config.FileTransferConfig{
+		Enabled:      true,
+		AllowedPaths: []string{tmpDir},
+	}
+
+	chain := newFileTransferTestChain(t, ftCfg)
+	defer chain.Close()
+
+	chain.CreateAgents(t)
+	chain.StartAgents(t)
+
+	time.Sleep(3 * time.Second)
+
+	targetID := chain.Agents[3].ID().String()
+	addr := chain.HTTPAddrs[0]
+
+	// Enough files with long names to need several manifest pages
+	localDir := t.TempDir()
+	for i := 0; i < 120; i++ {
+		name := fmt.Sprintf("conf.d/%03d-%s.yaml", i, strings.Repeat("x", 100))
+		path := filepath.Join(localDir, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := os.WriteFile(path, []byte(fmt.Sprintf("value: %d\n", i)), 0644); err != nil {
+			t.Fatalf("Failed to create local file: %v", err)
+		}
+	}
+
+	remoteDir := filepath.Join(tmpDir, "app")
+	sync := func() *filetransfer.SyncPlan {
+		local, err := filetransfer.BuildManifest(localDir)
+		if err != nil {
+			t.Fatalf("BuildManifest() error = %v", err)
+		}
+		plan := filetransfer.PlanSync(local, remoteManifest(t, addr, targetID, remoteDir), true)
+		for _, e := range plan.Delete {
+			browseRemote(t, addr, targetID, &filetransfer.BrowseRequest{
+				Action:    "delete",
+				Path:      remoteDir + "/" + e.Path,
+				Recursive: e.IsDir,
+			})
+		}
+		for _, e := range plan.Upload {
+			result, err := uploadFile(t, addr, targetID, filepath.Join(localDir, filepath.FromSlash(e.Path)), remoteDir+"/"+e.Path, "")
+			if err != nil || !result.Success {
+				t.Fatalf("Upload of %s failed: %v %+v", e.Path, err, result)
+			}
+		}
+		return plan
+	}
+
+	// First pass: the remote directory does not exist yet
+	if plan := sync(); len(plan.Upload) != 120 || plan.Unchanged != 0 {
+		t.Fatalf("First sync uploaded %d, unchanged %d, want 120 and 0", len(plan.Upload), plan.Unchanged)
+	}
+
+	// Change one file, remove one, and leave an extraneous file remotely
+	changed := fmt.Sprintf("conf.d/%03d-%s.yaml", 7, strings.Repeat("x", 100))
+	os.WriteFile(filepath.Join(localDir, filepath.FromSlash(changed)), []byte("value: changed\n"), 0644)
+	os.Remove(filepath.Join(localDir, "conf.d", fmt.Sprintf("%03d-%s.yaml", 8, strings.Repeat("x", 100))))
+	os.WriteFile(filepath.Join(remoteDir, "stale.txt"), []byte("stale"), 0644)
+
+	plan := sync()
+	if len(plan.Upload) != 1 || plan.Upload[0].Path != changed {
+		t.Errorf("Second sync uploads = %+v, want only %s", plan.Upload, changed)
+	}
+	if len(plan.Delete) != 2 || plan.Unchanged != 118 {
+		t.Errorf("Second sync deleted %d, unchanged %d, want 2 and 118", len(plan.Delete), plan.Unchanged)
+	}
+
+	data, err := os.ReadFile(filepath.Join(remoteDir, filepath.FromSlash(changed)))
+	if err != nil || string(data) != "value: changed\n" {
+		t.Errorf("Changed file on remote = %q, %v", data, err)
+	}
+	if _, err := os.Stat(filepath.Join(remoteDir, "stale.txt")); !os.IsNotExist(err) {
+		t.Error("Extraneous remote file was not deleted")
+	}
+
+	// Third pass: nothing left to do
+	if plan := sync(); len(plan.Upload) != 0 || len(plan.Delete) != 0 || plan.Unchanged != 119 {
+		t.Errorf("Third sync = %d uploads, %d deletes, %d unchanged, want 0, 0, 119",
+			len(plan.Upload), len(plan.Delete), plan.Unchanged)
+	}
+}
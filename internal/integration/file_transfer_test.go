@@ -38,6 +38,8 @@ type uploadResponse struct {
 	Error        string `json:"error,omitempty"`
 	BytesWritten int64  `json:"bytes_written"`
 	RemotePath   string `json:"remote_path,omitempty"`
+	Checksum     string `json:"checksum,omitempty"`
+	ErrorCode    string `json:"error_code,omitempty"`
 }
 
 // downloadRequest is the JSON request for file download.
@@ -47,6 +49,10 @@ type downloadRequest struct {
 	RateLimit    int64  `json:"rate_limit,omitempty"`
 	Offset       int64  `json:"offset,omitempty"`
 	OriginalSize int64  `json:"original_size,omitempty"`
+	Length       int64  `json:"length,omitempty"`
+	Route        int    `json:"route,omitempty"`
+	Symlinks     string `json:"symlinks,omitempty"`
+	Hardlinks    string `json:"hardlinks,omitempty"`
 }
 
 // uploadFile uploads a file via the HTTP API.
@@ -616,6 +622,100 @@ func TestFileTransfer_ResumeDownload(t *testing.T) {
 	}
 }
 
+// TestFileTransfer_Checksum tests end-to-end SHA-256 verification of uploads
+// and downloads.
+func TestFileTransfer_Checksum(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	tmpDir := t.TempDir()
+
+	ftCfg := &config.FileTransferConfig{
+		Enabled:      true,
+		AllowedPaths: []string{tmpDir},
+	}
+
+	chain := newFileTransferTestChain(t, ftCfg)
+	defer chain.Close()
+
+	chain.CreateAgents(t)
+	chain.StartAgents(t)
+
+	time.Sleep(3 * time.Second)
+
+	targetID := chain.Agents[3].ID().String()
+
+	testContent := make([]byte, 100*1024)
+	rand.Read(testContent)
+	sum := sha256.Sum256(testContent)
+	wantChecksum := hex.EncodeToString(sum[:])
+
+	localPath := filepath.Join(t.TempDir(), "checksum.bin")
+	if err := os.WriteFile(localPath, testContent, 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	// Upload reports the checksum verified by the remote agent
+	remotePath := filepath.Join(tmpDir, "checksum.bin")
+	result, err := uploadFile(t, chain.HTTPAddrs[0], targetID, localPath, remotePath, "")
+	if err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+	if !result.Success {
+		t.Fatalf("Upload not successful: %s", result.Error)
+	}
+	if result.Checksum != wantChecksum {
+		t.Errorf("Upload checksum = %s, want %s", result.Checksum, wantChecksum)
+	}
+
+	// A client checksum that does not match the file is rejected
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+	writer.WriteField("path", filepath.Join(tmpDir, "corrupt.bin"))
+	part, _ := writer.CreateFormFile("file", "corrupt.bin")
+	part.Write(testContent)
+	writer.WriteField("checksum", strings.Repeat("0", 64))
+	writer.Close()
+
+	url := fmt.Sprintf("http://%s/agents/%s/file/upload", chain.HTTPAddrs[0], targetID)
+	resp, err := http.Post(url, writer.FormDataContentType(), &buf)
+	if err != nil {
+		t.Fatalf("Upload request failed: %v", err)
+	}
+	var rejected uploadResponse
+	json.NewDecoder(resp.Body).Decode(&rejected)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest || rejected.ErrorCode != "CHECKSUM_MISMATCH" {
+		t.Errorf("Corrupt upload = %d %+v, want 400 with CHECKSUM_MISMATCH", resp.StatusCode, rejected)
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "corrupt.bin")); !os.IsNotExist(err) {
+		t.Error("Corrupt upload was written on the remote agent")
+	}
+
+	// Downloads carry the checksum of the whole file in an HTTP trailer,
+	// also when resuming from an offset
+	for _, offset := range []int64{0, 5000} {
+		reqData, _ := json.Marshal(downloadRequest{Path: remotePath, Offset: offset, OriginalSize: int64(len(testContent))})
+		url := fmt.Sprintf("http://%s/agents/%s/file/download", chain.HTTPAddrs[0], targetID)
+		resp, err := http.Post(url, "application/json", bytes.NewReader(reqData))
+		if err != nil {
+			t.Fatalf("Download request failed: %v", err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("Download read failed: %v", err)
+		}
+		if !bytes.Equal(body, testContent[offset:]) {
+			t.Errorf("Download from %d: got %d bytes, want %d", offset, len(body), len(testContent)-int(offset))
+		}
+		if got := resp.Trailer.Get("X-Checksum-Sha256"); got != wantChecksum {
+			t.Errorf("Download from %d: checksum trailer = %q, want %s", offset, got, wantChecksum)
+		}
+	}
+}
+
 // TestFileTransfer_RateLimit tests rate-limited transfers.
 func TestFileTransfer_RateLimit(t *testing.T) {
 	if testing.Short() {
@@ -876,3 +976,59 @@ func TestFileTransfer_Disabled(t *testing.T) {
 		}
 	}
 }
+
+// TestFileTransfer_ViewerPeerDenied tests that the exit refuses upload and
+// download streams arriving from a peer whose role is below operator.
+func TestFileTransfer_ViewerPeerDenied(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	tmpDir := t.TempDir()
+
+	ftCfg := &config.FileTransferConfig{
+		Enabled:      true,
+		AllowedPaths: []string{tmpDir},
+	}
+
+	chain := newFileTransferTestChain(t, ftCfg)
+	chain.RBAC = &config.RBACConfig{DefaultPeerRole: "viewer"}
+	defer chain.Close()
+
+	chain.CreateAgents(t)
+	chain.StartAgents(t)
+
+	time.Sleep(3 * time.Second)
+
+	targetID := chain.Agents[3].ID().String()
+
+	localPath := filepath.Join(t.TempDir(), "denied.txt")
+	if err := os.WriteFile(localPath, []byte("denied"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	remotePath := filepath.Join(tmpDir, "denied.txt")
+
+	result, err := uploadFile(t, chain.HTTPAddrs[0], targetID, localPath, remotePath, "")
+	if err == nil && result.Success {
+		t.Fatal("Expected upload from viewer peer to be denied")
+	}
+	if err == nil && !strings.Contains(result.Error, "operator role required") {
+		t.Errorf("Expected role error, got: %s", result.Error)
+	}
+	if _, statErr := os.Stat(remotePath); statErr == nil {
+		t.Error("Remote file was written despite denial")
+	}
+
+	// Seed a file so the download fails on role, not on a missing path
+	if err := os.WriteFile(remotePath, []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	downloadPath := filepath.Join(t.TempDir(), "denied-download.txt")
+	err = downloadFile(t, chain.HTTPAddrs[0], targetID, remotePath, downloadPath, "", 0, 0, 0)
+	if err == nil {
+		t.Fatal("Expected download from viewer peer to be denied")
+	}
+	if !strings.Contains(err.Error(), "operator role required") {
+		t.Errorf("Expected role error, got: %v", err)
+	}
+}
@@ -0,0 +1,59 @@
+package integration
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/postalsys/muti-metroo/internal/config"
+)
+
+// TestNATTraversal_UpgradesRelayedPath verifies that agents reached through
+// a single transit peer exchange endpoints over the mesh and open a direct
+// QUIC link to each other. Loopback has no NAT, so this covers the
+// rendezvous exchange and the dial from the listener socket.
+func TestNATTraversal_UpgradesRelayedPath(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	chain := NewAgentChain(t)
+	defer chain.Close()
+
+	chain.NATTraversal = &config.NATTraversalConfig{
+		Enabled:       true,
+		Interval:      500 * time.Millisecond,
+		PunchTimeout:  2 * time.Second,
+		RetryInterval: time.Minute,
+		MaxDirect:     8,
+	}
+	chain.CreateAgents(t)
+	chain.StartAgents(t)
+	chain.VerifyConnectivity(t)
+
+	// A and C only share B, B and D only share C
+	pairs := [][2]int{{0, 2}, {1, 3}}
+	deadline := time.Now().Add(20 * time.Second)
+	for time.Now().Before(deadline) {
+		linked := 0
+		for _, p := range pairs {
+			if slices.Contains(chain.Agents[p[0]].GetPeerIDs(), chain.Agents[p[1]].ID()) {
+				linked++
+			}
+		}
+		if linked == len(pairs) {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	for _, p := range pairs {
+		from, to := chain.Agents[p[0]], chain.Agents[p[1]]
+		if !slices.Contains(from.GetPeerIDs(), to.ID()) {
+			t.Errorf("agent %d has no direct link to agent %d", p[0], p[1])
+		}
+		if !slices.Contains(to.GetPeerIDs(), from.ID()) {
+			t.Errorf("agent %d has no direct link to agent %d", p[1], p[0])
+		}
+	}
+}
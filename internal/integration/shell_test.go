@@ -7,6 +7,7 @@ import (
 	"io"
 	"strings"
 	"sync"
+	"syscall"
 	"testing"
 	"time"
 
@@ -550,3 +551,123 @@ func TestShell_TTYSessionOpen(t *testing.T) {
 		}
 	}
 }
+
+// readyWriter collects output and closes ready on the first write.
+type readyWriter struct {
+	mu    sync.Mutex
+	buf   bytes.Buffer
+	ready chan struct{}
+}
+
+func (w *readyWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.buf.Len() == 0 {
+		close(w.ready)
+	}
+	return w.buf.Write(p)
+}
+
+// TestShell_SignalForwarding tests that a SIGINT sent by the client through
+// the mesh stops the remote command.
+func TestShell_SignalForwarding(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	shellCfg := &config.ShellConfig{
+		Enabled:     true,
+		Whitelist:   []string{"*"},
+		MaxSessions: 10,
+	}
+
+	chain := newShellTestChain(t, shellCfg)
+	defer chain.Close()
+
+	chain.CreateAgents(t)
+	chain.StartAgents(t)
+
+	time.Sleep(3 * time.Second)
+
+	targetID := chain.Agents[3].ID().String()
+
+	stdout := &readyWriter{ready: make(chan struct{})}
+	client := shell.NewClient(shell.ClientConfig{
+		AgentAddr: chain.HTTPAddrs[0],
+		TargetID:  targetID,
+		Command:   "sh",
+		Args:      []string{"-c", "echo ready; exec sleep 30"},
+		Stdout:    stdout,
+		Stderr:    io.Discard,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	type result struct {
+		exitCode int
+		err      error
+	}
+	done := make(chan result, 1)
+	go func() {
+		exitCode, err := client.Run(ctx)
+		done <- result{exitCode, err}
+	}()
+
+	select {
+	case <-stdout.ready:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Command did not start")
+	}
+
+	start := time.Now()
+	if err := client.SendSignal(ctx, syscall.SIGINT); err != nil {
+		t.Fatalf("SendSignal failed: %v", err)
+	}
+
+	select {
+	case r := <-done:
+		if r.err != nil {
+			t.Fatalf("Session failed: %v", r.err)
+		}
+		if r.exitCode == 0 {
+			t.Error("Expected non-zero exit code after SIGINT")
+		}
+		t.Logf("Command stopped %v after SIGINT with exit code %d", time.Since(start), r.exitCode)
+	case <-time.After(10 * time.Second):
+		t.Fatal("SIGINT did not stop the remote command")
+	}
+}
+
+// TestShell_ViewerPeerDenied tests that the exit refuses a shell stream
+// arriving from a peer whose role is below admin.
+func TestShell_ViewerPeerDenied(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	shellCfg := &config.ShellConfig{
+		Enabled:     true,
+		Whitelist:   []string{"*"},
+		MaxSessions: 10,
+	}
+
+	chain := newShellTestChain(t, shellCfg)
+	chain.RBAC = &config.RBACConfig{DefaultPeerRole: "viewer"}
+	defer chain.Close()
+
+	chain.CreateAgents(t)
+	chain.StartAgents(t)
+
+	time.Sleep(3 * time.Second)
+
+	targetID := chain.Agents[3].ID().String()
+
+	_, _, _, err := executeCommand(t, chain, targetID, "whoami", nil, "")
+	if err == nil {
+		t.Fatal("Expected shell from viewer peer to be denied, got nil")
+	}
+	if !strings.Contains(err.Error(), "admin role required") {
+		t.Errorf("Expected role error, got: %v", err)
+	}
+}
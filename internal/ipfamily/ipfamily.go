@@ -0,0 +1,89 @@
+// Package ipfamily selects the IP families a listening socket accepts:
+// IPv4 and IPv6 on one dual-stack socket, or only one of them.
+package ipfamily
+
+import (
+	"fmt"
+	"net"
+)
+
+// Family is the address_family setting of a listener.
+type Family string
+
+const (
+	// Dual accepts IPv4 and IPv6. A wildcard address (":port", "0.0.0.0"
+	// or "[::]") opens one dual-stack socket on which IPv4 clients appear
+	// as IPv4-mapped IPv6 addresses; Go reports them as plain IPv4.
+	Dual Family = "dual"
+
+	// IPv4 accepts IPv4 only, even on a wildcard address.
+	IPv4 Family = "ipv4"
+
+	// IPv6 accepts IPv6 only. The socket is opened with IPV6_V6ONLY, so an
+	// IPv4 listener can share the port.
+	IPv6 Family = "ipv6"
+)
+
+// Parse parses an address_family setting. An empty string is Dual.
+func Parse(s string) (Family, error) {
+	switch f := Family(s); f {
+	case "":
+		return Dual, nil
+	case Dual, IPv4, IPv6:
+		return f, nil
+	}
+	return "", fmt.Errorf("must be dual, ipv4 or ipv6, got %q", s)
+}
+
+// Network returns the Go network name for a listener of base, "tcp" or
+// "udp": base itself for Dual (and the empty Family), with a "4" or "6"
+// suffix otherwise.
+func (f Family) Network(base string) string {
+	switch f {
+	case IPv4:
+		return base + "4"
+	case IPv6:
+		return base + "6"
+	}
+	return base
+}
+
+// CheckAddress returns an error if the host of a host:port listen address
+// is an IP literal of the other family. Hostnames are accepted, and so is
+// 0.0.0.0 for IPv6, which binds "[::]". IPv4-mapped IPv6 literals count as
+// IPv4.
+func (f Family) CheckAddress(addr string) error {
+	if f != IPv4 && f != IPv6 {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil
+	}
+	switch is4 := ip.To4() != nil; {
+	case f == IPv4 && !is4:
+		return fmt.Errorf("%s is not an IPv4 address", host)
+	case f == IPv6 && is4 && !ip.IsUnspecified():
+		return fmt.Errorf("%s is not an IPv6 address", host)
+	}
+	return nil
+}
+
+// Listen opens a TCP listener on address for the family.
+func (f Family) Listen(address string) (net.Listener, error) {
+	return net.Listen(f.Network("tcp"), address)
+}
+
+// ListenUDP opens a UDP socket on address for the family.
+func (f Family) ListenUDP(address string) (*net.UDPConn, error) {
+	network := f.Network("udp")
+	laddr, err := net.ResolveUDPAddr(network, address)
+	if err != nil {
+		return nil, err
+	}
+	return net.ListenUDP(network, laddr)
+}
@@ -0,0 +1,185 @@
+package ipfamily
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Family
+		wantErr bool
+	}{
+		{"", Dual, false},
+		{"dual", Dual, false},
+		{"ipv4", IPv4, false},
+		{"ipv6", IPv6, false},
+		{"IPv4", "", true},
+		{"v6", "", true},
+	}
+	for _, tt := range tests {
+		got, err := Parse(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Parse(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Parse(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestNetwork(t *testing.T) {
+	tests := []struct {
+		f    Family
+		base string
+		want string
+	}{
+		{"", "tcp", "tcp"},
+		{Dual, "udp", "udp"},
+		{IPv4, "tcp", "tcp4"},
+		{IPv6, "udp", "udp6"},
+	}
+	for _, tt := range tests {
+		if got := tt.f.Network(tt.base); got != tt.want {
+			t.Errorf("%q.Network(%q) = %q, want %q", tt.f, tt.base, got, tt.want)
+		}
+	}
+}
+
+func TestCheckAddress(t *testing.T) {
+	tests := []struct {
+		f       Family
+		addr    string
+		wantErr bool
+	}{
+		{Dual, "[::1]:80", false},
+		{Dual, "127.0.0.1:80", false},
+		{IPv4, ":80", false},
+		{IPv4, "0.0.0.0:80", false},
+		{IPv4, "10.0.0.1:80", false},
+		{IPv4, "[::ffff:10.0.0.1]:80", false},
+		{IPv4, "localhost:80", false},
+		{IPv4, "[::]:80", true},
+		{IPv4, "[::1]:80", true},
+		{IPv6, ":80", false},
+		{IPv6, "[::]:80", false},
+		{IPv6, "0.0.0.0:80", false},
+		{IPv6, "[2001:db8::1]:80", false},
+		{IPv6, "127.0.0.1:80", true},
+		{IPv6, "[::ffff:127.0.0.1]:80", true},
+		{IPv6, "no-port", true},
+	}
+	for _, tt := range tests {
+		err := tt.f.CheckAddress(tt.addr)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%q.CheckAddress(%q) error = %v, wantErr %v", tt.f, tt.addr, err, tt.wantErr)
+		}
+	}
+}
+
+// requireIPv6 skips the test when the host has no IPv6 loopback.
+func requireIPv6(t *testing.T) {
+	t.Helper()
+	ln, err := net.Listen("tcp6", "[::1]:0")
+	if err != nil {
+		t.Skipf("IPv6 loopback not available: %v", err)
+	}
+	ln.Close()
+}
+
+// dial connects to host on the port of ln and returns the remote address
+// seen by ln, or an error if the connection was refused.
+func dial(t *testing.T, ln net.Listener, host string) (net.Addr, error) {
+	t.Helper()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	c, err := net.DialTimeout("tcp", net.JoinHostPort(host, port), time.Second)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	sc, err := ln.Accept()
+	if err != nil {
+		t.Fatalf("Accept() error = %v", err)
+	}
+	defer sc.Close()
+	return sc.RemoteAddr(), nil
+}
+
+func TestListen_Dual(t *testing.T) {
+	requireIPv6(t)
+
+	ln, err := Dual.Listen(":0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	defer ln.Close()
+
+	// IPv4 clients arrive as IPv4-mapped addresses on the dual-stack socket
+	// and must be reported as plain IPv4
+	addr, err := dial(t, ln, "127.0.0.1")
+	if err != nil {
+		t.Fatalf("IPv4 dial error = %v", err)
+	}
+	ip := addr.(*net.TCPAddr).IP
+	if ip.To4() == nil || ip.String() != "127.0.0.1" {
+		t.Errorf("IPv4 client address = %s, want 127.0.0.1", ip)
+	}
+
+	addr, err = dial(t, ln, "::1")
+	if err != nil {
+		t.Fatalf("IPv6 dial error = %v", err)
+	}
+	if ip := addr.(*net.TCPAddr).IP; !ip.Equal(net.IPv6loopback) {
+		t.Errorf("IPv6 client address = %s, want ::1", ip)
+	}
+}
+
+func TestListen_SingleFamily(t *testing.T) {
+	requireIPv6(t)
+
+	tests := []struct {
+		f       Family
+		accept  string
+		refused string
+	}{
+		{IPv4, "127.0.0.1", "::1"},
+		{IPv6, "::1", "127.0.0.1"},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.f), func(t *testing.T) {
+			ln, err := tt.f.Listen(":0")
+			if err != nil {
+				t.Fatalf("Listen() error = %v", err)
+			}
+			defer ln.Close()
+
+			if _, err := dial(t, ln, tt.accept); err != nil {
+				t.Errorf("dial %s error = %v", tt.accept, err)
+			}
+			if _, err := dial(t, ln, tt.refused); err == nil {
+				t.Errorf("dial %s succeeded, want refused", tt.refused)
+			}
+		})
+	}
+}
+
+func TestListenUDP(t *testing.T) {
+	requireIPv6(t)
+
+	conn, err := IPv6.ListenUDP("[::1]:0")
+	if err != nil {
+		t.Fatalf("ListenUDP() error = %v", err)
+	}
+	defer conn.Close()
+	if ip := conn.LocalAddr().(*net.UDPAddr).IP; ip.To4() != nil {
+		t.Errorf("local address = %s, want IPv6", ip)
+	}
+
+	if _, err := IPv4.ListenUDP("[::1]:0"); err == nil {
+		t.Error("IPv4.ListenUDP([::1]:0) succeeded, want error")
+	}
+}
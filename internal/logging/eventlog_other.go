@@ -0,0 +1,21 @@
+//go:build !windows
+
+package logging
+
+import (
+	"errors"
+	"log/slog"
+)
+
+// errEventLogUnsupported is returned for event log output off Windows.
+var errEventLogUnsupported = errors.New("event log output is only supported on Windows")
+
+type eventLogWriter struct{}
+
+func openEventLog(EventLogOutput) (*eventLogWriter, error) {
+	return nil, errEventLogUnsupported
+}
+
+func (*eventLogWriter) writeRecord(slog.Level, []byte) error { return errEventLogUnsupported }
+
+func (*eventLogWriter) Close() error { return nil }
@@ -0,0 +1,80 @@
+//go:build windows
+
+package logging
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+
+	"golang.org/x/sys/windows/svc/eventlog"
+)
+
+// eventID is the event ID of all records written to the Windows Event Log.
+const eventID = 1
+
+// eventLogWriter writes records to the Windows Event Log, mapping debug and
+// info to Information, warn to Warning and error to Error events.
+type eventLogWriter struct {
+	mu  sync.Mutex
+	log *eventlog.Log
+}
+
+func openEventLog(cfg EventLogOutput) (*eventLogWriter, error) {
+	source := cfg.Source
+	if source == "" {
+		source = DefaultSource
+	}
+	l, err := eventlog.Open(source)
+	if err != nil {
+		return nil, fmt.Errorf("open event log source %q: %w", source, err)
+	}
+	return &eventLogWriter{log: l}, nil
+}
+
+func (w *eventLogWriter) writeRecord(level slog.Level, msg []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.log == nil {
+		return nil
+	}
+	switch {
+	case level >= slog.LevelError:
+		return w.log.Error(eventID, string(msg))
+	case level >= slog.LevelWarn:
+		return w.log.Warning(eventID, string(msg))
+	default:
+		return w.log.Info(eventID, string(msg))
+	}
+}
+
+func (w *eventLogWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.log == nil {
+		return nil
+	}
+	err := w.log.Close()
+	w.log = nil
+	return err
+}
+
+// RegisterEventSource registers source with the Application event log so
+// its messages display without a message file warning. Registering an
+// existing source is not an error. Requires administrator rights.
+func RegisterEventSource(source string) error {
+	err := eventlog.InstallAsEventCreate(source, eventlog.Error|eventlog.Warning|eventlog.Info)
+	if err != nil && !strings.Contains(err.Error(), "registry key already exists") {
+		return err
+	}
+	return nil
+}
+
+// UnregisterEventSource removes an event source registered with
+// RegisterEventSource.
+func UnregisterEventSource(source string) error {
+	return eventlog.Remove(source)
+}
@@ -17,21 +17,22 @@ func NewLogger(level, format string) *slog.Logger {
 
 // NewLoggerWithWriter creates a new structured logger with a custom writer.
 func NewLoggerWithWriter(level, format string, w io.Writer) *slog.Logger {
-	lvl := parseLevel(level)
+	return slog.New(newHandler(level, format, w, nil))
+}
 
+// newHandler creates a text or JSON handler writing to w.
+func newHandler(level, format string, w io.Writer, replace func([]string, slog.Attr) slog.Attr) slog.Handler {
 	opts := &slog.HandlerOptions{
-		Level: lvl,
+		Level:       parseLevel(level),
+		ReplaceAttr: replace,
 	}
 
-	var handler slog.Handler
 	switch strings.ToLower(format) {
 	case "json":
-		handler = slog.NewJSONHandler(w, opts)
+		return slog.NewJSONHandler(w, opts)
 	default:
-		handler = slog.NewTextHandler(w, opts)
+		return slog.NewTextHandler(w, opts)
 	}
-
-	return slog.New(handler)
 }
 
 // parseLevel converts a string log level to slog.Level.
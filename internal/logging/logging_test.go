@@ -3,8 +3,12 @@ package logging
 import (
 	"bytes"
 	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestNewLogger_TextFormat(t *testing.T) {
@@ -140,3 +144,103 @@ func TestLoggerWithAttributes(t *testing.T) {
 		t.Errorf("expected transport attribute, got: %s", output)
 	}
 }
+
+func TestRotatingFile_RotatesBySize(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "agent.log")
+
+	f, err := OpenRotatingFile(FileOutput{Path: path, MaxSize: 100, MaxBackups: 2})
+	if err != nil {
+		t.Fatalf("OpenRotatingFile: %v", err)
+	}
+	defer f.Close()
+
+	line := []byte(strings.Repeat("x", 59) + "\n")
+	for i := 0; i < 5; i++ {
+		if _, err := f.Write(line); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+		// Distinct backup timestamps
+		time.Sleep(2 * time.Millisecond)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if len(data) != len(line) {
+		t.Errorf("current file size = %d, want %d", len(data), len(line))
+	}
+	if backups := f.backups(); len(backups) != 2 {
+		t.Errorf("backups = %v, want 2 files", backups)
+	}
+}
+
+func TestRotatingFile_RotatesByAge(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "agent.log")
+
+	f, err := OpenRotatingFile(FileOutput{Path: path, MaxAge: time.Millisecond})
+	if err != nil {
+		t.Fatalf("OpenRotatingFile: %v", err)
+	}
+	defer f.Close()
+
+	f.Write([]byte("first\n"))
+	time.Sleep(5 * time.Millisecond)
+	f.Write([]byte("second\n"))
+
+	data, _ := os.ReadFile(path)
+	if string(data) != "second\n" {
+		t.Errorf("current file = %q, want %q", data, "second\n")
+	}
+	if backups := f.backups(); len(backups) != 1 {
+		t.Errorf("backups = %v, want 1 file", backups)
+	}
+}
+
+func TestNewLoggerWithOutput_Syslog(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("ListenPacket: %v", err)
+	}
+	defer pc.Close()
+
+	logger, closer, err := NewLoggerWithOutput("info", "text", Output{
+		Type:   OutputSyslog,
+		Syslog: SyslogOutput{Address: "udp://" + pc.LocalAddr().String(), Facility: "local3", Tag: "mm-test"},
+	})
+	if err != nil {
+		t.Fatalf("NewLoggerWithOutput: %v", err)
+	}
+	defer closer.Close()
+
+	logger.With(KeyPeerID, "abc123").Warn("peer lost")
+
+	buf := make([]byte, 2048)
+	pc.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("ReadFrom: %v", err)
+	}
+	msg := string(buf[:n])
+
+	// local3 (19) * 8 + warning (4)
+	if !strings.HasPrefix(msg, "<156>1 ") {
+		t.Errorf("message = %q, want <156>1 prefix", msg)
+	}
+	if !strings.Contains(msg, " mm-test ") {
+		t.Errorf("message = %q, want mm-test app name", msg)
+	}
+	if !strings.HasSuffix(msg, `level=WARN msg="peer lost" peer_id=abc123`) {
+		t.Errorf("message = %q, want text record without time", msg)
+	}
+}
+
+func TestNewLoggerWithOutput_Invalid(t *testing.T) {
+	if _, _, err := NewLoggerWithOutput("info", "text", Output{Type: "journald"}); err == nil {
+		t.Error("expected error for unknown output")
+	}
+	if _, _, err := NewLoggerWithOutput("info", "text", Output{Type: OutputFile}); err == nil {
+		t.Error("expected error for file output without path")
+	}
+}
@@ -0,0 +1,147 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Log output types.
+const (
+	OutputStderr   = "stderr"
+	OutputStdout   = "stdout"
+	OutputFile     = "file"
+	OutputSyslog   = "syslog"
+	OutputEventLog = "eventlog"
+)
+
+// DefaultSource is the syslog tag and Windows Event Log source used when none
+// is configured. It matches the default service name.
+const DefaultSource = "muti-metroo"
+
+// Output selects where log records are written.
+type Output struct {
+	Type     string // stderr (default), stdout, file, syslog, eventlog
+	File     FileOutput
+	Syslog   SyslogOutput
+	EventLog EventLogOutput
+}
+
+// FileOutput configures a rotating log file.
+type FileOutput struct {
+	Path       string
+	MaxSize    int64         // Rotate before the file grows past this many bytes (0 = DefaultMaxSize)
+	MaxAge     time.Duration // Rotate when the file is older than this (0 = never)
+	MaxBackups int           // Rotated files to keep (0 = DefaultMaxBackups)
+}
+
+// SyslogOutput configures RFC 5424 syslog output.
+type SyslogOutput struct {
+	Address  string // "" for the local syslog socket, or udp://, tcp:// or unix:// address
+	Facility string // Facility name (default daemon)
+	Tag      string // APP-NAME field (default DefaultSource)
+}
+
+// EventLogOutput configures Windows Event Log output.
+type EventLogOutput struct {
+	Source string // Event source name (default DefaultSource)
+}
+
+// NewLoggerWithOutput creates a structured logger writing to out. The
+// returned closer releases the output once the logger is no longer used.
+func NewLoggerWithOutput(level, format string, out Output) (*slog.Logger, io.Closer, error) {
+	switch strings.ToLower(out.Type) {
+	case "", OutputStderr:
+		return NewLoggerWithWriter(level, format, os.Stderr), nopCloser{}, nil
+	case OutputStdout:
+		return NewLoggerWithWriter(level, format, os.Stdout), nopCloser{}, nil
+	case OutputFile:
+		f, err := OpenRotatingFile(out.File)
+		if err != nil {
+			return nil, nil, err
+		}
+		return NewLoggerWithWriter(level, format, f), f, nil
+	case OutputSyslog:
+		w, err := dialSyslog(out.Syslog)
+		if err != nil {
+			return nil, nil, err
+		}
+		return slog.New(newSinkHandler(level, format, w)), w, nil
+	case OutputEventLog:
+		w, err := openEventLog(out.EventLog)
+		if err != nil {
+			return nil, nil, err
+		}
+		return slog.New(newSinkHandler(level, format, w)), w, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown log output %q", out.Type)
+	}
+}
+
+type nopCloser struct{}
+
+func (nopCloser) Close() error { return nil }
+
+// recordSink receives formatted log records along with their level, for
+// outputs that map levels to their own severities.
+type recordSink interface {
+	writeRecord(level slog.Level, msg []byte) error
+	io.Closer
+}
+
+// sinkHandler formats records with a text or JSON handler and passes each to
+// a recordSink. The timestamp is left out as sinks add their own.
+type sinkHandler struct {
+	inner slog.Handler
+	state *sinkState
+}
+
+// sinkState is shared by a sinkHandler and the handlers derived from it.
+type sinkState struct {
+	mu   sync.Mutex
+	buf  bytes.Buffer
+	sink recordSink
+}
+
+func newSinkHandler(level, format string, sink recordSink) *sinkHandler {
+	state := &sinkState{sink: sink}
+	dropTime := func(groups []string, a slog.Attr) slog.Attr {
+		if len(groups) == 0 && a.Key == slog.TimeKey {
+			return slog.Attr{}
+		}
+		return a
+	}
+	return &sinkHandler{
+		inner: newHandler(level, format, &state.buf, dropTime),
+		state: state,
+	}
+}
+
+func (h *sinkHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.inner.Enabled(ctx, level)
+}
+
+func (h *sinkHandler) Handle(ctx context.Context, r slog.Record) error {
+	h.state.mu.Lock()
+	defer h.state.mu.Unlock()
+
+	h.state.buf.Reset()
+	if err := h.inner.Handle(ctx, r); err != nil {
+		return err
+	}
+	return h.state.sink.writeRecord(r.Level, bytes.TrimRight(h.state.buf.Bytes(), "\n"))
+}
+
+func (h *sinkHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &sinkHandler{inner: h.inner.WithAttrs(attrs), state: h.state}
+}
+
+func (h *sinkHandler) WithGroup(name string) slog.Handler {
+	return &sinkHandler{inner: h.inner.WithGroup(name), state: h.state}
+}
@@ -0,0 +1,207 @@
+package logging
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// syslogFacilities maps facility names to their RFC 5424 codes.
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5,
+	"lpr": 6, "news": 7, "uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// localSyslogSockets are tried in order when no syslog address is set.
+var localSyslogSockets = []string{"/dev/log", "/var/run/syslog", "/var/run/log"}
+
+// ParseSyslogFacility returns the code of a syslog facility name. An empty
+// name is the daemon facility.
+func ParseSyslogFacility(name string) (int, error) {
+	if name == "" {
+		return syslogFacilities["daemon"], nil
+	}
+	code, ok := syslogFacilities[strings.ToLower(name)]
+	if !ok {
+		return 0, fmt.Errorf("unknown syslog facility %q", name)
+	}
+	return code, nil
+}
+
+// ParseSyslogAddress splits a syslog address into network and address.
+// Accepted forms are udp://host:port, tcp://host:port, unix:///path and a
+// bare host:port, which is UDP. An empty address is the local syslog socket
+// and returns an empty network.
+func ParseSyslogAddress(address string) (network, addr string, err error) {
+	if address == "" {
+		return "", "", nil
+	}
+	network, addr, ok := strings.Cut(address, "://")
+	if !ok {
+		network, addr = "udp", address
+	}
+	switch network {
+	case "udp", "tcp":
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			return "", "", fmt.Errorf("invalid syslog address %q: must be host:port", address)
+		}
+	case "unix":
+		if addr == "" {
+			return "", "", fmt.Errorf("invalid syslog address %q: socket path is required", address)
+		}
+	default:
+		return "", "", fmt.Errorf("invalid syslog address %q: scheme must be udp, tcp or unix", address)
+	}
+	return network, addr, nil
+}
+
+// syslogWriter sends records as RFC 5424 messages. Stream connections use
+// octet-counting framing (RFC 6587). A failed write reconnects and retries
+// once.
+type syslogWriter struct {
+	network  string
+	addr     string
+	facility int
+	hostname string
+	tag      string
+	pid      string
+
+	mu     sync.Mutex
+	conn   net.Conn
+	stream bool // conn is a stream socket and needs framing
+	closed bool
+}
+
+func dialSyslog(cfg SyslogOutput) (*syslogWriter, error) {
+	facility, err := ParseSyslogFacility(cfg.Facility)
+	if err != nil {
+		return nil, err
+	}
+	network, addr, err := ParseSyslogAddress(cfg.Address)
+	if err != nil {
+		return nil, err
+	}
+	hostname, _ := os.Hostname()
+	if hostname == "" {
+		hostname = "-"
+	}
+	tag := cfg.Tag
+	if tag == "" {
+		tag = DefaultSource
+	}
+
+	w := &syslogWriter{
+		network:  network,
+		addr:     addr,
+		facility: facility,
+		hostname: hostname,
+		tag:      tag,
+		pid:      strconv.Itoa(os.Getpid()),
+	}
+	if err := w.connect(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// connect dials the syslog server. Unix sockets are tried as datagram
+// sockets first, then as stream sockets.
+func (w *syslogWriter) connect() error {
+	if w.conn != nil {
+		w.conn.Close()
+		w.conn = nil
+	}
+
+	var candidates [][2]string
+	switch w.network {
+	case "":
+		for _, path := range localSyslogSockets {
+			candidates = append(candidates, [2]string{"unixgram", path}, [2]string{"unix", path})
+		}
+	case "unix":
+		candidates = [][2]string{{"unixgram", w.addr}, {"unix", w.addr}}
+	default:
+		candidates = [][2]string{{w.network, w.addr}}
+	}
+
+	var lastErr error
+	for _, c := range candidates {
+		conn, err := net.DialTimeout(c[0], c[1], 5*time.Second)
+		if err == nil {
+			w.conn = conn
+			w.stream = c[0] == "tcp" || c[0] == "unix"
+			return nil
+		}
+		lastErr = err
+	}
+	if w.network == "" {
+		return errors.New("no local syslog socket found, set a syslog address")
+	}
+	return fmt.Errorf("connect to syslog: %w", lastErr)
+}
+
+// severity maps a log level to an RFC 5424 severity.
+func severity(level slog.Level) int {
+	switch {
+	case level >= slog.LevelError:
+		return 3
+	case level >= slog.LevelWarn:
+		return 4
+	case level >= slog.LevelInfo:
+		return 6
+	default:
+		return 7
+	}
+}
+
+// format returns the RFC 5424 message for a record, framed for the
+// connection type.
+func (w *syslogWriter) format(level slog.Level, msg []byte, now time.Time) []byte {
+	line := fmt.Sprintf("<%d>1 %s %s %s %s - - %s",
+		w.facility*8+severity(level),
+		now.Format("2006-01-02T15:04:05.000000Z07:00"),
+		w.hostname, w.tag, w.pid, msg)
+	if w.stream {
+		return []byte(strconv.Itoa(len(line)) + " " + line)
+	}
+	return []byte(line)
+}
+
+func (w *syslogWriter) writeRecord(level slog.Level, msg []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return net.ErrClosed
+	}
+	now := time.Now()
+	if w.conn != nil {
+		if _, err := w.conn.Write(w.format(level, msg, now)); err == nil {
+			return nil
+		}
+	}
+	if err := w.connect(); err != nil {
+		return err
+	}
+	_, err := w.conn.Write(w.format(level, msg, now))
+	return err
+}
+
+func (w *syslogWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.closed = true
+	if w.conn == nil {
+		return nil
+	}
+	return w.conn.Close()
+}
@@ -0,0 +1,159 @@
+// Package middleware defines hooks that programs embedding an agent can
+// insert into stream handling, at the ingress and at the exit, for custom
+// access checks, traffic inspection or metrics.
+package middleware
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/postalsys/muti-metroo/internal/identity"
+)
+
+// Side tells where in the mesh a stream is handled.
+type Side uint8
+
+const (
+	// Ingress streams are dialed for local clients: SOCKS5, port forward
+	// listeners and tunnels.
+	Ingress Side = iota + 1
+
+	// Exit streams are opened by a remote ingress and connected to the
+	// destination by this agent.
+	Exit
+)
+
+// String returns the side name.
+func (s Side) String() string {
+	switch s {
+	case Ingress:
+		return "ingress"
+	case Exit:
+		return "exit"
+	default:
+		return "unknown"
+	}
+}
+
+// Direction tells which way stream data flows.
+type Direction uint8
+
+const (
+	// ToDestination is data from the client toward the destination.
+	ToDestination Direction = iota + 1
+
+	// FromDestination is data from the destination toward the client.
+	FromDestination
+)
+
+// String returns the direction name.
+func (d Direction) String() string {
+	switch d {
+	case ToDestination:
+		return "to_destination"
+	case FromDestination:
+		return "from_destination"
+	default:
+		return "unknown"
+	}
+}
+
+// Stream describes a stream passed to middleware. The same value is passed
+// to every hook of one stream, so middleware may key per-stream state on it.
+type Stream struct {
+	Side        Side
+	ID          uint64           // Stream ID on the link to the ingress (exit only)
+	Peer        identity.AgentID // Peer the stream arrived from (exit only)
+	Network     string           // "tcp"
+	Destination string           // Requested host:port, or "forward:<key>" for port forwards
+	User        string           // SOCKS5 account, when known (see socks5.send_username at the exit)
+	Client      net.Addr         // Client address, when shared (see send_client_address)
+	StartedAt   time.Time
+}
+
+// StreamMiddleware is called on stream open, data and close.
+//
+// OpenStream runs before the stream is opened toward the destination;
+// returning an error refuses it. StreamData runs for every chunk of
+// plaintext and returns the data to pass on, which may be data itself, a
+// replacement, or empty to drop it. data is only valid during the call.
+// Returning an error ends the stream. CloseStream runs once for every
+// stream OpenStream accepted. Hooks for different streams run
+// concurrently, and the two directions of one stream may too.
+type StreamMiddleware interface {
+	OpenStream(ctx context.Context, s *Stream) error
+	StreamData(s *Stream, dir Direction, data []byte) ([]byte, error)
+	CloseStream(s *Stream)
+}
+
+// Funcs implements StreamMiddleware from optional functions. Missing
+// functions accept the stream and pass data unchanged.
+type Funcs struct {
+	Open  func(ctx context.Context, s *Stream) error
+	Data  func(s *Stream, dir Direction, data []byte) ([]byte, error)
+	Close func(s *Stream)
+}
+
+// OpenStream calls f.Open.
+func (f Funcs) OpenStream(ctx context.Context, s *Stream) error {
+	if f.Open == nil {
+		return nil
+	}
+	return f.Open(ctx, s)
+}
+
+// StreamData calls f.Data.
+func (f Funcs) StreamData(s *Stream, dir Direction, data []byte) ([]byte, error) {
+	if f.Data == nil {
+		return data, nil
+	}
+	return f.Data(s, dir, data)
+}
+
+// CloseStream calls f.Close.
+func (f Funcs) CloseStream(s *Stream) {
+	if f.Close != nil {
+		f.Close(s)
+	}
+}
+
+// Chain runs middleware in order. Data flows through every middleware in
+// order in both directions, and CloseStream runs in reverse order. The
+// zero value does nothing.
+type Chain []StreamMiddleware
+
+// OpenStream opens s with every middleware, stopping at the first error.
+// Middleware that already accepted s is closed again.
+func (c Chain) OpenStream(ctx context.Context, s *Stream) error {
+	for i, m := range c {
+		if err := m.OpenStream(ctx, s); err != nil {
+			for j := i - 1; j >= 0; j-- {
+				c[j].CloseStream(s)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// StreamData passes data through every middleware.
+func (c Chain) StreamData(s *Stream, dir Direction, data []byte) ([]byte, error) {
+	for _, m := range c {
+		if len(data) == 0 {
+			break
+		}
+		var err error
+		if data, err = m.StreamData(s, dir, data); err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+// CloseStream closes s with every middleware.
+func (c Chain) CloseStream(s *Stream) {
+	for i := len(c) - 1; i >= 0; i-- {
+		c[i].CloseStream(s)
+	}
+}
@@ -0,0 +1,79 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestChain(t *testing.T) {
+	var calls []string
+	record := func(name string, openErr error) StreamMiddleware {
+		return Funcs{
+			Open: func(ctx context.Context, s *Stream) error {
+				calls = append(calls, "open "+name)
+				return openErr
+			},
+			Data: func(s *Stream, dir Direction, data []byte) ([]byte, error) {
+				return append(data, name...), nil
+			},
+			Close: func(s *Stream) {
+				calls = append(calls, "close "+name)
+			},
+		}
+	}
+
+	s := &Stream{Side: Ingress, Destination: "example.com:443"}
+	chain := Chain{record("a", nil), record("b", nil)}
+	if err := chain.OpenStream(context.Background(), s); err != nil {
+		t.Fatalf("OpenStream() error = %v", err)
+	}
+	data, err := chain.StreamData(s, ToDestination, []byte("x"))
+	if err != nil || string(data) != "xab" {
+		t.Errorf("StreamData() = %q, %v, want %q", data, err, "xab")
+	}
+	chain.CloseStream(s)
+	if want := []string{"open a", "open b", "close b", "close a"}; !slices.Equal(calls, want) {
+		t.Errorf("calls = %v, want %v", calls, want)
+	}
+
+	// A refusal closes the middleware that already accepted the stream
+	calls = nil
+	refused := errors.New("refused")
+	chain = Chain{record("a", nil), record("b", refused), record("c", nil)}
+	if err := chain.OpenStream(context.Background(), s); !errors.Is(err, refused) {
+		t.Fatalf("OpenStream() error = %v, want %v", err, refused)
+	}
+	if want := []string{"open a", "open b", "close a"}; !slices.Equal(calls, want) {
+		t.Errorf("calls = %v, want %v", calls, want)
+	}
+}
+
+func TestChain_DropData(t *testing.T) {
+	called := false
+	chain := Chain{
+		Funcs{Data: func(s *Stream, dir Direction, data []byte) ([]byte, error) { return nil, nil }},
+		Funcs{Data: func(s *Stream, dir Direction, data []byte) ([]byte, error) {
+			called = true
+			return data, nil
+		}},
+	}
+	data, err := chain.StreamData(&Stream{}, FromDestination, []byte("x"))
+	if err != nil || len(data) != 0 || called {
+		t.Errorf("StreamData() = %q, %v (later middleware called: %v), want dropped", data, err, called)
+	}
+}
+
+func TestFuncs_Defaults(t *testing.T) {
+	var f Funcs
+	if err := f.OpenStream(context.Background(), &Stream{}); err != nil {
+		t.Errorf("OpenStream() error = %v", err)
+	}
+	data, err := f.StreamData(&Stream{}, ToDestination, []byte("x"))
+	if err != nil || !bytes.Equal(data, []byte("x")) {
+		t.Errorf("StreamData() = %q, %v, want unchanged", data, err)
+	}
+	f.CloseStream(&Stream{})
+}
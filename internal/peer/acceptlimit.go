@@ -0,0 +1,199 @@
+package peer
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Errors returned when an incoming connection is refused before its handshake.
+var (
+	ErrAcceptRateLimit = errors.New("accept rate exceeded for source address")
+	ErrTooManyPending  = errors.New("too many connections waiting for handshake")
+)
+
+// acceptLimitPruneInterval is how often idle source addresses are removed.
+const acceptLimitPruneInterval = time.Minute
+
+// AcceptLimitConfig limits incoming peer connections before they complete
+// the handshake. Zero values mean no limit.
+type AcceptLimitConfig struct {
+	// PerIPRate is the number of new connections per second accepted from
+	// one source IP, with bursts of PerIPBurst (at least 1).
+	PerIPRate  float64
+	PerIPBurst int
+
+	// MaxPending is the number of accepted connections that may be in the
+	// handshake at the same time.
+	MaxPending int
+
+	// RetryThreshold makes QUIC listeners validate source addresses with a
+	// Retry packet while this many connections are in the handshake.
+	RetryThreshold int
+}
+
+// AcceptStats are the counters of an AcceptLimiter, cumulative since it
+// was created.
+type AcceptStats struct {
+	Pending        int    // Connections in the handshake now
+	Accepted       uint64 // Connections that completed the handshake
+	RateLimited    uint64 // Refused by the per-IP rate
+	PendingLimited uint64 // Refused by MaxPending
+	TimedOut       uint64 // Closed when the handshake deadline passed
+	Failed         uint64 // Handshake failed for another reason
+}
+
+// AcceptLimiter enforces AcceptLimitConfig on a listener's accepted
+// connections and counts the outcome of their handshakes. It is safe for
+// concurrent use.
+type AcceptLimiter struct {
+	cfg AcceptLimitConfig
+	now func() time.Time
+
+	mu        sync.Mutex
+	sources   map[string]*acceptSource
+	lastPrune time.Time
+
+	pending        atomic.Int64
+	accepted       atomic.Uint64
+	rateLimited    atomic.Uint64
+	pendingLimited atomic.Uint64
+	timedOut       atomic.Uint64
+	failed         atomic.Uint64
+}
+
+// acceptSource is the accept rate state of one source IP.
+type acceptSource struct {
+	limiter  *rate.Limiter
+	lastUsed time.Time
+}
+
+// NewAcceptLimiter creates an accept limiter.
+func NewAcceptLimiter(cfg AcceptLimitConfig) *AcceptLimiter {
+	return &AcceptLimiter{
+		cfg:     cfg,
+		now:     time.Now,
+		sources: make(map[string]*acceptSource),
+	}
+}
+
+// Admit admits a connection accepted from addr into the handshake. On
+// success the returned function must be called with the handshake result
+// once it is done.
+func (l *AcceptLimiter) Admit(addr net.Addr) (done func(err error), err error) {
+	if !l.allowSource(addr) {
+		l.rateLimited.Add(1)
+		return nil, ErrAcceptRateLimit
+	}
+	if n := l.pending.Add(1); l.cfg.MaxPending > 0 && n > int64(l.cfg.MaxPending) {
+		l.pending.Add(-1)
+		l.pendingLimited.Add(1)
+		return nil, ErrTooManyPending
+	}
+
+	var once sync.Once
+	return func(err error) {
+		once.Do(func() {
+			l.pending.Add(-1)
+			switch {
+			case err == nil:
+				l.accepted.Add(1)
+			case errors.Is(err, context.DeadlineExceeded):
+				l.timedOut.Add(1)
+			default:
+				l.failed.Add(1)
+			}
+		})
+	}, nil
+}
+
+// VerifySourceAddress reports whether a new QUIC connection from addr must
+// first prove it can receive packets at that address (a Retry round trip).
+// It is true while RetryThreshold or more connections are in the handshake.
+func (l *AcceptLimiter) VerifySourceAddress(addr net.Addr) bool {
+	return l.cfg.RetryThreshold > 0 && l.pending.Load() >= int64(l.cfg.RetryThreshold)
+}
+
+// Stats returns the counters.
+func (l *AcceptLimiter) Stats() AcceptStats {
+	return AcceptStats{
+		Pending:        int(l.pending.Load()),
+		Accepted:       l.accepted.Load(),
+		RateLimited:    l.rateLimited.Load(),
+		PendingLimited: l.pendingLimited.Load(),
+		TimedOut:       l.timedOut.Load(),
+		Failed:         l.failed.Load(),
+	}
+}
+
+// allowSource applies the per-IP rate. Addresses without an IP, such as
+// in-memory connections, are always allowed.
+func (l *AcceptLimiter) allowSource(addr net.Addr) bool {
+	if l.cfg.PerIPRate <= 0 {
+		return true
+	}
+	ip := sourceIP(addr)
+	if ip == "" {
+		return true
+	}
+	now := l.now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.pruneLocked(now)
+
+	s := l.sources[ip]
+	if s == nil {
+		s = &acceptSource{limiter: rate.NewLimiter(rate.Limit(l.cfg.PerIPRate), max(l.cfg.PerIPBurst, 1))}
+		l.sources[ip] = s
+	}
+	s.lastUsed = now
+	return s.limiter.AllowN(now, 1)
+}
+
+// pruneLocked forgets sources idle for long enough to have refilled their
+// token bucket. Must be called with l.mu held.
+func (l *AcceptLimiter) pruneLocked(now time.Time) {
+	if now.Sub(l.lastPrune) < acceptLimitPruneInterval {
+		return
+	}
+	l.lastPrune = now
+
+	idle := max(acceptLimitPruneInterval,
+		time.Duration(float64(max(l.cfg.PerIPBurst, 1))/l.cfg.PerIPRate*float64(time.Second)))
+	for ip, s := range l.sources {
+		if now.Sub(s.lastUsed) > idle {
+			delete(l.sources, ip)
+		}
+	}
+}
+
+// sourceIP returns the IP address of a remote address, or "" when it has
+// none.
+func sourceIP(addr net.Addr) string {
+	switch a := addr.(type) {
+	case nil:
+		return ""
+	case *net.UDPAddr:
+		if a == nil || a.IP == nil {
+			return ""
+		}
+		return a.IP.String()
+	case *net.TCPAddr:
+		if a == nil || a.IP == nil {
+			return ""
+		}
+		return a.IP.String()
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil || net.ParseIP(host) == nil {
+		return ""
+	}
+	return host
+}
@@ -0,0 +1,72 @@
+package peer
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestAcceptLimiter_Limits(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	l := NewAcceptLimiter(AcceptLimitConfig{PerIPRate: 1, PerIPBurst: 2, MaxPending: 3, RetryThreshold: 2})
+	l.now = func() time.Time { return now }
+
+	addr := &net.UDPAddr{IP: net.ParseIP("192.0.2.10"), Port: 40000}
+	done1, err := l.Admit(addr)
+	if err != nil {
+		t.Fatalf("Admit() #1 error = %v", err)
+	}
+	if l.VerifySourceAddress(addr) {
+		t.Error("VerifySourceAddress() = true below the retry threshold")
+	}
+	done2, err := l.Admit(addr)
+	if err != nil {
+		t.Fatalf("Admit() #2 error = %v", err)
+	}
+	if !l.VerifySourceAddress(addr) {
+		t.Error("VerifySourceAddress() = false at the retry threshold")
+	}
+	if _, err := l.Admit(addr); !errors.Is(err, ErrAcceptRateLimit) {
+		t.Errorf("Admit() over rate error = %v, want %v", err, ErrAcceptRateLimit)
+	}
+
+	done3, err := l.Admit(&net.TCPAddr{IP: net.ParseIP("192.0.2.11")})
+	if err != nil {
+		t.Fatalf("Admit() from another IP error = %v", err)
+	}
+	if _, err := l.Admit(&net.TCPAddr{IP: net.ParseIP("192.0.2.12")}); !errors.Is(err, ErrTooManyPending) {
+		t.Errorf("Admit() over max pending error = %v, want %v", err, ErrTooManyPending)
+	}
+
+	done1(nil)
+	done1(nil) // Counted once
+	done2(context.DeadlineExceeded)
+	done3(errors.New("bad hello"))
+
+	now = now.Add(time.Second)
+	if _, err := l.Admit(addr); err != nil {
+		t.Errorf("Admit() after refill error = %v", err)
+	}
+
+	want := AcceptStats{Pending: 1, Accepted: 1, RateLimited: 1, PendingLimited: 1, TimedOut: 1, Failed: 1}
+	if got := l.Stats(); got != want {
+		t.Errorf("Stats() = %+v, want %+v", got, want)
+	}
+}
+
+func TestAcceptLimiter_NoLimits(t *testing.T) {
+	l := NewAcceptLimiter(AcceptLimitConfig{})
+	for i := 0; i < 100; i++ {
+		if _, err := l.Admit(&net.UDPAddr{IP: net.ParseIP("192.0.2.10")}); err != nil {
+			t.Fatalf("Admit() #%d error = %v", i+1, err)
+		}
+	}
+	if l.VerifySourceAddress(nil) {
+		t.Error("VerifySourceAddress() = true without a retry threshold")
+	}
+	if got := l.Stats().Pending; got != 100 {
+		t.Errorf("Stats().Pending = %d, want 100", got)
+	}
+}
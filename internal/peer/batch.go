@@ -0,0 +1,147 @@
+package peer
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// batchCloseTimeout bounds the final flush when a batching connection closes.
+const batchCloseTimeout = time.Second
+
+// BatchConfig configures outbound frame coalescing on a connection.
+//
+// With batching enabled, frames written to the connection are appended to a
+// buffer that is written to the transport in one call once FlushDelay has
+// passed since the first buffered frame or MaxBytes have accumulated.
+type BatchConfig struct {
+	// Enabled turns on coalescing
+	Enabled bool
+
+	// FlushDelay is the longest a frame waits in the buffer
+	FlushDelay time.Duration
+
+	// MaxBytes triggers an immediate write once this many bytes are buffered
+	MaxBytes int
+}
+
+// DefaultBatchConfig returns sensible defaults with batching disabled.
+func DefaultBatchConfig() BatchConfig {
+	return BatchConfig{
+		Enabled:    false,
+		FlushDelay: time.Millisecond,
+		MaxBytes:   64 * 1024,
+	}
+}
+
+// batchWriter coalesces small writes into larger ones. A write error is
+// sticky and returned by every later Write, matching an unbuffered stream
+// that has failed.
+type batchWriter struct {
+	w        io.Writer
+	delay    time.Duration
+	maxBytes int
+
+	mu    sync.Mutex
+	buf   []byte
+	timer *time.Timer
+	armed bool
+	err   error
+}
+
+// newBatchWriter wraps w, filling unset values from DefaultBatchConfig.
+func newBatchWriter(w io.Writer, cfg BatchConfig) *batchWriter {
+	defaults := DefaultBatchConfig()
+	if cfg.FlushDelay <= 0 {
+		cfg.FlushDelay = defaults.FlushDelay
+	}
+	if cfg.MaxBytes <= 0 {
+		cfg.MaxBytes = defaults.MaxBytes
+	}
+	return &batchWriter{
+		w:        w,
+		delay:    cfg.FlushDelay,
+		maxBytes: cfg.MaxBytes,
+	}
+}
+
+// Write buffers p. Once MaxBytes are buffered the buffer is written before
+// returning, so a slow transport still pushes back on writers.
+func (b *batchWriter) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.err != nil {
+		return 0, b.err
+	}
+
+	// Large writes with nothing buffered gain nothing from a copy
+	if len(b.buf) == 0 && len(p) >= b.maxBytes {
+		if _, err := b.w.Write(p); err != nil {
+			b.err = err
+			return 0, err
+		}
+		return len(p), nil
+	}
+
+	b.buf = append(b.buf, p...)
+	if len(b.buf) >= b.maxBytes {
+		if err := b.flushLocked(); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+
+	if !b.armed {
+		b.armed = true
+		if b.timer == nil {
+			b.timer = time.AfterFunc(b.delay, b.flushTimer)
+		} else {
+			b.timer.Reset(b.delay)
+		}
+	}
+	return len(p), nil
+}
+
+// Flush writes any buffered data.
+func (b *batchWriter) Flush() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.err != nil {
+		return b.err
+	}
+	return b.flushLocked()
+}
+
+// flushTimer runs when FlushDelay expires.
+func (b *batchWriter) flushTimer() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.armed && b.err == nil {
+		b.flushLocked()
+	}
+}
+
+// flushLocked writes the buffer. Caller holds b.mu.
+func (b *batchWriter) flushLocked() error {
+	if b.armed {
+		b.armed = false
+		b.timer.Stop()
+	}
+	if len(b.buf) == 0 {
+		return nil
+	}
+	_, err := b.w.Write(b.buf)
+	b.buf = b.buf[:0]
+	if err != nil {
+		b.err = err
+	}
+	return err
+}
+
+// Buffered returns the number of bytes waiting to be written.
+func (b *batchWriter) Buffered() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.buf)
+}
@@ -3,8 +3,11 @@ package peer
 
 import (
 	"context"
+	"crypto/x509"
+	"encoding/binary"
 	"fmt"
 	"net"
+	"slices"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -59,19 +62,51 @@ type Connection struct {
 	state        atomic.Int32
 	capabilities []string
 
+	// Negotiated during the handshake (see protocol.NegotiateFeatures)
+	version         uint16
+	features        []string
+	missingFeatures []string
+
+	// Certificate pinning (verified during handshake)
+	certPins                map[identity.AgentID]string // Mesh-wide AgentID -> fingerprint table
+	expectedCertFingerprint string                      // Per-peer pinned fingerprint (dialer only)
+	allowedAgents           []identity.AgentID          // Agents the listener accepts (listener only)
+
 	// Frame I/O
 	reader        *protocol.FrameReader
 	writer        *protocol.FrameWriter
 	controlStream transport.Stream
 	writeMu       sync.Mutex
+	writeBatching map[transport.TransportType]BatchConfig
+	batch         *batchWriter    // Non-nil when outbound frames are coalesced
+	sched         *writeScheduler // Non-nil when outbound frames are scheduled by class
+	writeStarted  atomic.Int64    // Start of the write in progress (UnixNano), 0 when idle
+	writeWaiting  atomic.Int32    // Writers waiting for their turn (see WriteStall)
+
+	// Frame payload limit (see MaxPayload)
+	maxPayloadByTransport map[transport.TransportType]int
+	peerMaxPayload        int  // Per-peer limit, overrides maxPayloadByTransport
+	proxied               bool // Dialed through an upstream proxy
+	maxPayload            int  // Negotiated during the handshake
+
+	// Traffic classes of non-interactive streams (see streamFrameClass)
+	classMu       sync.Mutex
+	streamClasses map[uint64]*streamClass
 
 	// Streams
 	streamAlloc  *transport.StreamIDAllocator
 	nextStreamID atomic.Uint64
 
 	// Activity tracking
-	lastActivity atomic.Int64
-	rtt          atomic.Int64 // Round-trip time in nanoseconds
+	lastActivity     atomic.Int64
+	lastUserActivity atomic.Int64 // Last stream, UDP or ICMP frame (quiet mode)
+	rtt              atomic.Int64 // Round-trip time in nanoseconds
+	quiet            bool         // Dialed as a quiet peer (see IsQuiet)
+
+	// Traffic accounting (see ByteRates)
+	bytesSent atomic.Uint64
+	bytesRecv atomic.Uint64
+	rates     trafficRates
 
 	// Lifecycle
 	ctx       context.Context
@@ -83,6 +118,7 @@ type Connection struct {
 	// Frame processing
 	frameCh    chan *protocol.Frame // Sequential frame dispatch channel (stream-ordered frames)
 	fastLaneCh chan *protocol.Frame // Parallel dispatch for unordered frames (UDP_DATAGRAM, ICMP_ECHO)
+	pending    atomic.Int64         // Frames queued on frameCh and not yet fully processed
 
 	// Callbacks
 	onFrame      func(*Connection, *protocol.Frame)
@@ -97,6 +133,40 @@ type ConnectionConfig struct {
 	HandshakeTimeout time.Duration
 	OnFrame          func(*Connection, *protocol.Frame)
 	OnDisconnect     func(*Connection, error)
+
+	// CertPins maps agent IDs to pinned certificate fingerprints
+	// ("sha256:<hex>"). When the remote agent's claimed ID is in the table,
+	// the certificate presented on the transport must match.
+	CertPins map[identity.AgentID]string
+
+	// ExpectedCertFingerprint pins the certificate of this specific peer
+	// ("sha256:<hex>"). Takes precedence over CertPins.
+	ExpectedCertFingerprint string
+
+	// AllowedAgents restricts which agents may complete the handshake on an
+	// accepted connection (listener only). Others get PEER_HELLO_REJECT.
+	// Empty allows any agent.
+	AllowedAgents []identity.AgentID
+
+	// Quiet requests quiet mode for this link (dialer only). Announced to
+	// the remote side with CapabilityQuiet during the handshake.
+	Quiet bool
+
+	// WriteBatching enables outbound frame coalescing per transport type.
+	// Applied once the handshake completes.
+	WriteBatching map[transport.TransportType]BatchConfig
+
+	// QoS schedules outbound frames by traffic class.
+	QoS QoSConfig
+
+	// MaxPayload limits the frame payloads wanted per transport type;
+	// PeerMaxPayload overrides it for this peer. The limit is announced
+	// with CapabilityMaxPayload during the handshake.
+	MaxPayload     map[transport.TransportType]int
+	PeerMaxPayload int
+
+	// Proxied is set when the peer is dialed through an upstream proxy.
+	Proxied bool
 }
 
 // DefaultConnectionConfig returns a config with defaults.
@@ -124,6 +194,8 @@ func NewConnection(conn transport.PeerConn, cfg ConnectionConfig) *Connection {
 		conn:         conn,
 		isDialer:     conn.IsDialer(),
 		capabilities: cfg.Capabilities,
+		certPins:     cfg.CertPins,
+		quiet:        cfg.Quiet,
 		streamAlloc:  transport.NewStreamIDAllocator(conn.IsDialer()),
 		ctx:          ctx,
 		cancel:       cancel,
@@ -135,16 +207,25 @@ func NewConnection(conn transport.PeerConn, cfg ConnectionConfig) *Connection {
 		onDisconnect: cfg.OnDisconnect,
 	}
 
+	c.expectedCertFingerprint = cfg.ExpectedCertFingerprint
+	c.allowedAgents = cfg.AllowedAgents
+	c.writeBatching = cfg.WriteBatching
+	c.maxPayloadByTransport = cfg.MaxPayload
+	c.peerMaxPayload = cfg.PeerMaxPayload
+	c.proxied = cfg.Proxied
+	c.sched = newWriteScheduler(cfg.QoS)
 	c.state.Store(int32(StateHandshaking))
+	c.rates.at = time.Now()
 	c.updateActivity()
+	c.markUserActivity()
 
 	// frameCh drains sequentially to preserve per-stream ordering
 	// (STREAM_CLOSE must not pass STREAM_DATA on the same stream).
 	// fastLaneCh drains in parallel because its frame types
 	// (UDP_DATAGRAM, ICMP_ECHO) are explicitly unordered.
-	go c.drainFrames(c.frameCh)
+	go c.drainFrames(c.frameCh, &c.pending)
 	for i := 0; i < fastLaneWorkerCount; i++ {
-		go c.drainFrames(c.fastLaneCh)
+		go c.drainFrames(c.fastLaneCh, nil)
 	}
 
 	return c
@@ -152,20 +233,23 @@ func NewConnection(conn transport.PeerConn, cfg ConnectionConfig) *Connection {
 
 // drainFrames pulls frames from ch and dispatches each to onFrame, returning
 // when the connection is closed. A panic in any single handler is recovered
-// so it does not kill the goroutine.
-func (c *Connection) drainFrames(ch <-chan *protocol.Frame) {
+// so it does not kill the goroutine. If pending is non-nil it is decremented
+// once a frame has been fully processed.
+func (c *Connection) drainFrames(ch <-chan *protocol.Frame, pending *atomic.Int64) {
 	for {
 		select {
 		case frame := <-ch:
-			if c.onFrame == nil {
-				continue
-			}
-			func() {
-				defer func() {
-					_ = recover()
+			if c.onFrame != nil {
+				func() {
+					defer func() {
+						_ = recover()
+					}()
+					c.onFrame(c, frame)
 				}()
-				c.onFrame(c, frame)
-			}()
+			}
+			if pending != nil {
+				pending.Add(-1)
+			}
 		case <-c.closed:
 			return
 		}
@@ -210,6 +294,27 @@ func (c *Connection) HasCapability(cap string) bool {
 	return false
 }
 
+// ProtocolVersion returns the protocol version negotiated with the peer.
+func (c *Connection) ProtocolVersion() uint16 {
+	return c.version
+}
+
+// Features returns the feature flags enabled on this link.
+func (c *Connection) Features() []string {
+	return c.features
+}
+
+// MissingFeatures returns the supported feature flags the peer lacks. The
+// link uses the fallback behavior of each.
+func (c *Connection) MissingFeatures() []string {
+	return c.missingFeatures
+}
+
+// SupportsFeature checks if a feature flag is enabled on this link.
+func (c *Connection) SupportsFeature(name string) bool {
+	return slices.Contains(c.features, name)
+}
+
 // NextStreamID returns the next available stream ID.
 func (c *Connection) NextStreamID() uint64 {
 	return c.streamAlloc.Next()
@@ -230,17 +335,98 @@ func (c *Connection) AcceptStream(ctx context.Context) (transport.Stream, error)
 
 // WriteFrame writes a frame to the connection.
 func (c *Connection) WriteFrame(f *protocol.Frame) error {
+	class := c.frameClass(f.Type, f.Flags, f.StreamID)
+	if protocol.IsStreamFrame(f.Type) {
+		f.Flags = protocol.WithClass(f.Flags, class)
+	}
+	c.writeWaiting.Add(1)
+	if err := c.sched.acquire(class, c.closed); err != nil {
+		c.writeWaiting.Add(-1)
+		return err
+	}
+	defer c.sched.release()
+
 	c.writeMu.Lock()
 	defer c.writeMu.Unlock()
+	c.writeWaiting.Add(-1)
 
 	if c.writer == nil {
 		return fmt.Errorf("connection not initialized")
 	}
 
-	c.updateActivity()
+	c.beginWrite()
+	defer c.writeStarted.Store(0)
+	if isUserFrame(f.Type) {
+		c.markUserActivity()
+	}
+	c.countSent(len(f.Payload))
 	return c.writer.Write(f)
 }
 
+// WriteRawFrame writes an already encoded frame (see protocol.Frame.Raw).
+func (c *Connection) WriteRawFrame(raw []byte) error {
+	c.writeWaiting.Add(1)
+	if len(raw) >= protocol.HeaderSize {
+		class := c.frameClass(raw[0], raw[1], binary.BigEndian.Uint64(raw[6:14]))
+		if err := c.sched.acquire(class, c.closed); err != nil {
+			c.writeWaiting.Add(-1)
+			return err
+		}
+		defer c.sched.release()
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.writeWaiting.Add(-1)
+
+	if c.writer == nil {
+		return fmt.Errorf("connection not initialized")
+	}
+
+	c.beginWrite()
+	defer c.writeStarted.Store(0)
+	if len(raw) > 0 && isUserFrame(raw[0]) {
+		c.markUserActivity()
+	}
+	c.bytesSent.Add(uint64(len(raw)))
+	return c.writer.WriteRaw(raw)
+}
+
+// beginWrite records the start of a write, which is also activity. The
+// caller clears writeStarted when the write returns. Called with writeMu
+// held.
+func (c *Connection) beginWrite() {
+	now := time.Now().UnixNano()
+	c.lastActivity.Store(now)
+	c.writeStarted.Store(now)
+}
+
+// WriteStall returns how long the write in progress has been blocked (0
+// when no write is in progress) and the number of writers waiting behind
+// it. A write blocked for long means the peer or the path to it stopped
+// reading.
+func (c *Connection) WriteStall() (time.Duration, int) {
+	var stalled time.Duration
+	if started := c.writeStarted.Load(); started != 0 {
+		stalled = time.Since(time.Unix(0, started))
+	}
+	return stalled, int(c.writeWaiting.Load())
+}
+
+// enableBatching switches the connection to coalesced writes if configured
+// for its transport. Called once after the handshake.
+func (c *Connection) enableBatching() {
+	cfg, ok := c.writeBatching[c.TransportType()]
+	if !ok || !cfg.Enabled || c.controlStream == nil {
+		return
+	}
+
+	c.writeMu.Lock()
+	c.batch = newBatchWriter(c.controlStream, cfg)
+	c.writer = protocol.NewFrameWriter(c.batch)
+	c.writeMu.Unlock()
+}
+
 // SendData sends a STREAM_DATA frame.
 func (c *Connection) SendData(streamID uint64, data []byte) error {
 	return c.WriteFrame(&protocol.Frame{
@@ -304,6 +490,12 @@ func (c *Connection) Close() error {
 	c.closeOnce.Do(func() {
 		c.cancel()
 		c.SetState(StateDisconnected)
+		// Push out coalesced frames, such as a command sent right before
+		// disconnecting, without waiting long on a dead peer
+		if c.batch != nil && c.controlStream != nil {
+			c.controlStream.SetWriteDeadline(time.Now().Add(batchCloseTimeout))
+			c.batch.Flush()
+		}
 		// Close control stream if set
 		if c.controlStream != nil {
 			c.controlStream.Close()
@@ -360,6 +552,15 @@ func (c *Connection) RemoteAddr() string {
 	return addrToString(c.conn.RemoteAddr())
 }
 
+// PeerCertificate returns the certificate the peer presented, or nil when
+// it presented none.
+func (c *Connection) PeerCertificate() *x509.Certificate {
+	if c.conn == nil {
+		return nil
+	}
+	return transport.PeerCertificate(c.conn)
+}
+
 // addrToString converts a net.Addr to string, returning empty string if nil.
 func addrToString(addr net.Addr) string {
 	if addr == nil {
@@ -0,0 +1,90 @@
+package peer
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/postalsys/muti-metroo/internal/identity"
+	"github.com/postalsys/muti-metroo/internal/protocol"
+	"github.com/postalsys/muti-metroo/internal/transport"
+)
+
+// CapabilityMaxPayload prefixes the capability announcing the largest frame
+// payload an agent wants to receive on a link, e.g. "max-payload=4096". It
+// is only announced when below protocol.MaxPayloadSize. Both sides send
+// frames no larger than the lower of the two announced limits.
+const CapabilityMaxPayload = "max-payload="
+
+// DefaultProxiedMaxPayload is the payload limit of WebSocket links dialed
+// through an upstream proxy when no limit is configured. Proxies buffering
+// WebSocket messages are known to reset connections on large frames.
+const DefaultProxiedMaxPayload = 4096
+
+// MaxPayload returns the largest frame payload to send on this link, as
+// negotiated during the handshake.
+func (c *Connection) MaxPayload() int {
+	if c.maxPayload == 0 {
+		return protocol.MaxPayloadSize
+	}
+	return c.maxPayload
+}
+
+// localMaxPayload returns the payload limit this side wants on the link: the
+// per-peer limit, the limit of the transport type, the proxied WebSocket
+// default or protocol.MaxPayloadSize, in that order.
+func (c *Connection) localMaxPayload() int {
+	if c.peerMaxPayload > 0 {
+		return clampPayload(c.peerMaxPayload)
+	}
+	if limit := c.maxPayloadByTransport[c.TransportType()]; limit > 0 {
+		return clampPayload(limit)
+	}
+	if c.proxied && c.TransportType() == transport.TransportWebSocket {
+		return DefaultProxiedMaxPayload
+	}
+	return protocol.MaxPayloadSize
+}
+
+// maxPayloadCapability returns the capability announcing the local payload
+// limit, or "" when the link takes full-size frames.
+func (c *Connection) maxPayloadCapability() string {
+	limit := c.localMaxPayload()
+	if limit >= protocol.MaxPayloadSize {
+		return ""
+	}
+	return CapabilityMaxPayload + strconv.Itoa(limit)
+}
+
+// negotiateMaxPayload returns the payload limit of a link: the lower of the
+// local limit and the limit the peer announced in remote.
+func negotiateMaxPayload(local int, remote []string) int {
+	limit := clampPayload(local)
+	for _, c := range remote {
+		v, ok := strings.CutPrefix(c, CapabilityMaxPayload)
+		if !ok {
+			continue
+		}
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			limit = min(limit, clampPayload(n))
+		}
+	}
+	return limit
+}
+
+// clampPayload bounds a payload limit to the range a link can negotiate.
+func clampPayload(n int) int {
+	return max(protocol.MinPayloadSize, min(n, protocol.MaxPayloadSize))
+}
+
+// MaxPayload returns the largest frame payload to send to a peer, or
+// protocol.MaxPayloadSize when the peer is not connected.
+func (m *Manager) MaxPayload(peerID identity.AgentID) int {
+	m.mu.RLock()
+	conn := m.peers[peerID]
+	m.mu.RUnlock()
+
+	if conn == nil {
+		return protocol.MaxPayloadSize
+	}
+	return conn.MaxPayload()
+}
@@ -4,8 +4,10 @@ package peer
 import (
 	"context"
 	"fmt"
+	"slices"
 	"time"
 
+	"github.com/postalsys/muti-metroo/internal/certutil"
 	"github.com/postalsys/muti-metroo/internal/identity"
 	"github.com/postalsys/muti-metroo/internal/protocol"
 	"github.com/postalsys/muti-metroo/internal/transport"
@@ -17,6 +19,25 @@ type HandshakeResult struct {
 	RemoteDisplayName string
 	Capabilities      []string
 	RTT               time.Duration
+
+	// Version is the negotiated protocol version, the lower of both sides.
+	Version uint16
+
+	// Features are the feature flags enabled on the link; MissingFeatures
+	// are the locally supported ones the peer lacks (see protocol.Features).
+	Features        []string
+	MissingFeatures []string
+}
+
+// HandshakeRejectedError is returned to the dialer when the listener
+// refused the handshake with PEER_HELLO_REJECT.
+type HandshakeRejectedError struct {
+	Code    uint16 // protocol error code, e.g. protocol.ErrAgentNotAllowed
+	Message string
+}
+
+func (e *HandshakeRejectedError) Error() string {
+	return fmt.Sprintf("handshake rejected by peer: %s (%s)", e.Message, protocol.ErrorCodeName(e.Code))
 }
 
 // Handshaker handles the handshake protocol between peers.
@@ -89,6 +110,14 @@ func (h *Handshaker) PerformHandshake(ctx context.Context, conn *Connection, exp
 	conn.RemoteID = result.RemoteID
 	conn.RemoteDisplayName = result.RemoteDisplayName
 	conn.capabilities = result.Capabilities
+	conn.version = result.Version
+	conn.features = result.Features
+	conn.missingFeatures = result.MissingFeatures
+	conn.maxPayload = negotiateMaxPayload(conn.localMaxPayload(), result.Capabilities)
+	if conn.quiet && !conn.SupportsFeature(protocol.FeatureQuietMode) {
+		conn.quiet = false
+	}
+	conn.enableBatching()
 	conn.SetState(StateConnected)
 
 	// Signal that reader/writer are ready for use
@@ -106,7 +135,7 @@ func (h *Handshaker) dialerHandshake(ctx context.Context, conn *Connection, read
 		Version:      protocol.ProtocolVersion,
 		AgentID:      h.localID,
 		Timestamp:    uint64(time.Now().UnixNano()),
-		Capabilities: h.capabilities,
+		Capabilities: slices.Concat(h.helloCapabilities(conn), protocol.FeatureNames()),
 		DisplayName:  h.displayName,
 	}
 
@@ -124,6 +153,14 @@ func (h *Handshaker) dialerHandshake(ctx context.Context, conn *Connection, read
 		return nil, fmt.Errorf("failed to read PEER_HELLO_ACK: %w", err)
 	}
 
+	if frame.Type == protocol.FramePeerHelloReject {
+		reject, err := protocol.DecodePeerHelloReject(frame.Payload)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode PEER_HELLO_REJECT: %w", err)
+		}
+		return nil, &HandshakeRejectedError{Code: reject.ErrorCode, Message: reject.Message}
+	}
+
 	if frame.Type != protocol.FramePeerHelloAck {
 		return nil, fmt.Errorf("expected PEER_HELLO_ACK, got frame type 0x%02x", frame.Type)
 	}
@@ -134,6 +171,11 @@ func (h *Handshaker) dialerHandshake(ctx context.Context, conn *Connection, read
 		return nil, fmt.Errorf("failed to decode PEER_HELLO_ACK: %w", err)
 	}
 
+	version, err := protocol.NegotiateVersion(ack.Version)
+	if err != nil {
+		return nil, err
+	}
+
 	// Verify peer ID if expected
 	remoteID := ack.AgentID
 
@@ -142,15 +184,23 @@ func (h *Handshaker) dialerHandshake(ctx context.Context, conn *Connection, read
 			expectedPeerID.String(), remoteID.String())
 	}
 
+	if err := verifyCertificatePin(conn, remoteID); err != nil {
+		return nil, err
+	}
+
 	// Calculate RTT
 	rtt := time.Since(startTime)
 	conn.UpdateRTT(uint64(startTime.UnixNano()))
 
+	features, missing := protocol.NegotiateFeatures(version, ack.Capabilities)
 	return &HandshakeResult{
 		RemoteID:          remoteID,
 		RemoteDisplayName: ack.DisplayName,
 		Capabilities:      ack.Capabilities,
 		RTT:               rtt,
+		Version:           version,
+		Features:          features,
+		MissingFeatures:   missing,
 	}, nil
 }
 
@@ -172,10 +222,10 @@ func (h *Handshaker) listenerHandshake(ctx context.Context, conn *Connection, re
 		return nil, fmt.Errorf("failed to decode PEER_HELLO: %w", err)
 	}
 
-	// Verify protocol version
-	if hello.Version != protocol.ProtocolVersion {
-		return nil, fmt.Errorf("protocol version mismatch: expected %d, got %d",
-			protocol.ProtocolVersion, hello.Version)
+	// Verify protocol version. Newer peers fall back to our version.
+	version, err := protocol.NegotiateVersion(hello.Version)
+	if err != nil {
+		return nil, err
 	}
 
 	// Verify peer ID if expected
@@ -186,12 +236,30 @@ func (h *Handshaker) listenerHandshake(ctx context.Context, conn *Connection, re
 			expectedPeerID.String(), remoteID.String())
 	}
 
+	// Reject before acknowledging so a spoofing peer learns nothing about us
+	if err := verifyCertificatePin(conn, remoteID); err != nil {
+		return nil, err
+	}
+
+	if len(conn.allowedAgents) > 0 && !slices.Contains(conn.allowedAgents, remoteID) {
+		reject := &protocol.PeerHelloReject{
+			ErrorCode: protocol.ErrAgentNotAllowed,
+			Message:   "agent not allowed on this listener",
+		}
+		writer.Write(&protocol.Frame{
+			Type:     protocol.FramePeerHelloReject,
+			StreamID: protocol.ControlStreamID,
+			Payload:  reject.Encode(),
+		})
+		return nil, fmt.Errorf("agent %s not in allowed_agents", remoteID.ShortString())
+	}
+
 	// Send PEER_HELLO_ACK (uses same format as PeerHello)
 	ack := &protocol.PeerHello{
 		Version:      protocol.ProtocolVersion,
 		AgentID:      h.localID,
 		Timestamp:    hello.Timestamp, // Echo back for RTT calculation
-		Capabilities: h.capabilities,
+		Capabilities: slices.Concat(h.helloCapabilities(conn), protocol.FeatureNames()),
 		DisplayName:  h.displayName,
 	}
 
@@ -203,14 +271,58 @@ func (h *Handshaker) listenerHandshake(ctx context.Context, conn *Connection, re
 		return nil, fmt.Errorf("failed to send PEER_HELLO_ACK: %w", err)
 	}
 
+	features, missing := protocol.NegotiateFeatures(version, hello.Capabilities)
 	return &HandshakeResult{
 		RemoteID:          remoteID,
 		RemoteDisplayName: hello.DisplayName,
 		Capabilities:      hello.Capabilities,
 		RTT:               0, // Listener doesn't measure RTT during handshake
+		Version:           version,
+		Features:          features,
+		MissingFeatures:   missing,
 	}, nil
 }
 
+// verifyCertificatePin checks the certificate presented on the transport
+// against the fingerprint pinned for the claimed agent ID. A per-peer pin
+// takes precedence over the mesh-wide table. A certificate pinned to a
+// different agent is rejected as well, so one pinned certificate cannot be
+// used to impersonate another agent.
+func verifyCertificatePin(conn *Connection, remoteID identity.AgentID) error {
+	pinned := conn.expectedCertFingerprint
+	if pinned == "" {
+		pinned = conn.certPins[remoteID]
+	}
+	if pinned == "" && len(conn.certPins) == 0 {
+		return nil
+	}
+
+	cert := transport.PeerCertificate(conn.conn)
+	if cert == nil {
+		if pinned == "" {
+			return nil
+		}
+		return fmt.Errorf("agent %s has a pinned certificate but presented none", remoteID.ShortString())
+	}
+	actual := certutil.Fingerprint(cert)
+
+	if pinned != "" {
+		if actual != pinned {
+			return fmt.Errorf("certificate fingerprint mismatch for agent %s: expected %s, got %s",
+				remoteID.ShortString(), pinned, actual)
+		}
+		return nil
+	}
+
+	for id, fp := range conn.certPins {
+		if fp == actual && id != remoteID {
+			return fmt.Errorf("certificate pinned to agent %s presented by agent %s",
+				id.ShortString(), remoteID.ShortString())
+		}
+	}
+	return nil
+}
+
 // AcceptHandshake accepts an incoming connection and performs handshake.
 func (h *Handshaker) AcceptHandshake(ctx context.Context, peerConn transport.PeerConn, cfg ConnectionConfig) (*Connection, error) {
 	return h.handshakeConnection(ctx, NewConnection(peerConn, cfg), cfg.ExpectedPeerID)
@@ -3,13 +3,20 @@ package peer
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"io"
+	"slices"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/postalsys/muti-metroo/internal/certutil"
 	"github.com/postalsys/muti-metroo/internal/identity"
 	"github.com/postalsys/muti-metroo/internal/protocol"
+	"github.com/postalsys/muti-metroo/internal/transport"
 )
 
 // ============================================================================
@@ -186,7 +193,7 @@ func TestListenerHandshake_VersionMismatch(t *testing.T) {
 	clientFrameWriter := protocol.NewFrameWriter(clientWriter)
 
 	hello := &protocol.PeerHello{
-		Version:      0xFF, // Wrong version
+		Version:      protocol.MinProtocolVersion - 1, // Too old
 		AgentID:      remoteID,
 		Timestamp:    uint64(time.Now().UnixNano()),
 		Capabilities: []string{},
@@ -207,9 +214,9 @@ func TestListenerHandshake_VersionMismatch(t *testing.T) {
 		if err == nil {
 			t.Error("Expected error for version mismatch, got nil")
 		}
-		// Error should mention version mismatch
-		if err != nil && !bytes.Contains([]byte(err.Error()), []byte("protocol version mismatch")) {
-			t.Errorf("Expected 'protocol version mismatch' error, got: %v", err)
+		// Error should mention the unsupported version
+		if err != nil && !bytes.Contains([]byte(err.Error()), []byte("protocol version 0 not supported")) {
+			t.Errorf("Expected 'protocol version 0 not supported' error, got: %v", err)
 		}
 	case <-time.After(2 * time.Second):
 		t.Fatal("Handshake did not complete in time")
@@ -220,6 +227,78 @@ func TestListenerHandshake_VersionMismatch(t *testing.T) {
 	clientReader.Close()
 }
 
+func TestListenerHandshake_NewerPeerFallsBack(t *testing.T) {
+	localID, _ := identity.NewAgentID()
+	remoteID, _ := identity.NewAgentID()
+
+	h := NewHandshaker(localID, "", nil, 1*time.Second)
+
+	clientReader, serverWriter := io.Pipe()
+	serverReader, clientWriter := io.Pipe()
+	defer serverWriter.Close()
+	defer clientWriter.Close()
+
+	conn := NewConnection(&mockPeerConn{isDialer: false}, DefaultConnectionConfig(localID))
+	defer conn.Close()
+	mockCtrlStream := &pipedMockStream{reader: serverReader, writer: serverWriter}
+
+	resultCh := make(chan *HandshakeResult, 1)
+	errCh := make(chan error, 1)
+	go func() {
+		reader := protocol.NewFrameReader(mockCtrlStream)
+		writer := protocol.NewFrameWriter(mockCtrlStream)
+		result, err := h.listenerHandshake(context.Background(), conn, reader, writer, identity.AgentID{})
+		resultCh <- result
+		errCh <- err
+	}()
+
+	// A newer peer announcing only a feature this version does not know
+	hello := &protocol.PeerHello{
+		Version:      protocol.ProtocolVersion + 1,
+		AgentID:      remoteID,
+		Timestamp:    uint64(time.Now().UnixNano()),
+		Capabilities: []string{"feature:from-the-future"},
+	}
+	if err := protocol.NewFrameWriter(clientWriter).Write(&protocol.Frame{
+		Type:     protocol.FramePeerHello,
+		StreamID: protocol.ControlStreamID,
+		Payload:  hello.Encode(),
+	}); err != nil {
+		t.Fatalf("Failed to write frame: %v", err)
+	}
+
+	frame, err := protocol.NewFrameReader(clientReader).Read()
+	if err != nil {
+		t.Fatalf("Failed to read ack: %v", err)
+	}
+	ack, err := protocol.DecodePeerHello(frame.Payload)
+	if err != nil {
+		t.Fatalf("Failed to decode ack: %v", err)
+	}
+	if ack.Version != protocol.ProtocolVersion {
+		t.Errorf("ack version = %d, want %d", ack.Version, protocol.ProtocolVersion)
+	}
+	for _, name := range protocol.FeatureNames() {
+		if !slices.Contains(ack.Capabilities, name) {
+			t.Errorf("ack does not announce feature %s", name)
+		}
+	}
+
+	result := <-resultCh
+	if err := <-errCh; err != nil {
+		t.Fatalf("listenerHandshake error = %v", err)
+	}
+	if result.Version != protocol.ProtocolVersion {
+		t.Errorf("Version = %d, want %d", result.Version, protocol.ProtocolVersion)
+	}
+	if len(result.Features) != 0 {
+		t.Errorf("Features = %v, want none", result.Features)
+	}
+	if !slices.Equal(result.MissingFeatures, protocol.FeatureNames()) {
+		t.Errorf("MissingFeatures = %v, want %v", result.MissingFeatures, protocol.FeatureNames())
+	}
+}
+
 func TestListenerHandshake_PeerIDMismatch(t *testing.T) {
 	localID, _ := identity.NewAgentID()
 	remoteID, _ := identity.NewAgentID()
@@ -583,9 +662,15 @@ func TestHandshake_Success(t *testing.T) {
 			t.Errorf("Dialer got wrong remote ID: expected %s, got %s",
 				remoteID.String(), dialerResult.RemoteID.String())
 		}
-		if len(dialerResult.Capabilities) != 1 || dialerResult.Capabilities[0] != "cap3" {
+		if len(dialerResult.Capabilities) != 1+len(protocol.Features) || dialerResult.Capabilities[0] != "cap3" {
 			t.Errorf("Dialer got wrong capabilities: %v", dialerResult.Capabilities)
 		}
+		if !slices.Equal(dialerResult.Features, protocol.FeatureNames()) || len(dialerResult.MissingFeatures) != 0 {
+			t.Errorf("Dialer features = %v, missing %v", dialerResult.Features, dialerResult.MissingFeatures)
+		}
+		if dialerResult.Version != protocol.ProtocolVersion {
+			t.Errorf("Dialer version = %d, want %d", dialerResult.Version, protocol.ProtocolVersion)
+		}
 	}
 
 	if listenerResult != nil {
@@ -593,7 +678,7 @@ func TestHandshake_Success(t *testing.T) {
 			t.Errorf("Listener got wrong remote ID: expected %s, got %s",
 				localID.String(), listenerResult.RemoteID.String())
 		}
-		if len(listenerResult.Capabilities) != 2 {
+		if len(listenerResult.Capabilities) != 2+len(protocol.Features) {
 			t.Errorf("Listener got wrong capabilities count: %d", len(listenerResult.Capabilities))
 		}
 	}
@@ -602,6 +687,59 @@ func TestHandshake_Success(t *testing.T) {
 	dialerWriter.Close()
 }
 
+func TestHandshake_AgentNotAllowed(t *testing.T) {
+	localID, _ := identity.NewAgentID()
+	remoteID, _ := identity.NewAgentID()
+	otherID, _ := identity.NewAgentID()
+
+	dialerHandshaker := NewHandshaker(localID, "", nil, 5*time.Second)
+	listenerHandshaker := NewHandshaker(remoteID, "", nil, 5*time.Second)
+
+	dialerReader, listenerWriter := io.Pipe()
+	listenerReader, dialerWriter := io.Pipe()
+	defer listenerWriter.Close()
+	defer dialerWriter.Close()
+
+	dialerConn := NewConnection(&mockPeerConn{isDialer: true}, DefaultConnectionConfig(localID))
+	defer dialerConn.Close()
+	dialerStream := &pipedMockStream{reader: dialerReader, writer: dialerWriter}
+
+	listenerCfg := DefaultConnectionConfig(remoteID)
+	listenerCfg.AllowedAgents = []identity.AgentID{otherID}
+	listenerConn := NewConnection(&mockPeerConn{isDialer: false}, listenerCfg)
+	defer listenerConn.Close()
+	listenerStream := &pipedMockStream{reader: listenerReader, writer: listenerWriter}
+
+	dialerErrCh := make(chan error, 1)
+	listenerErrCh := make(chan error, 1)
+	go func() {
+		_, err := dialerHandshaker.dialerHandshake(context.Background(), dialerConn,
+			protocol.NewFrameReader(dialerStream), protocol.NewFrameWriter(dialerStream), identity.AgentID{})
+		dialerErrCh <- err
+	}()
+	go func() {
+		_, err := listenerHandshaker.listenerHandshake(context.Background(), listenerConn,
+			protocol.NewFrameReader(listenerStream), protocol.NewFrameWriter(listenerStream), identity.AgentID{})
+		listenerErrCh <- err
+	}()
+
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-dialerErrCh:
+			var rejected *HandshakeRejectedError
+			if !errors.As(err, &rejected) || rejected.Code != protocol.ErrAgentNotAllowed {
+				t.Errorf("dialer error = %v, want HandshakeRejectedError with AGENT_NOT_ALLOWED", err)
+			}
+		case err := <-listenerErrCh:
+			if err == nil || !strings.Contains(err.Error(), "not in allowed_agents") {
+				t.Errorf("listener error = %v, want not in allowed_agents", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Handshake timed out")
+		}
+	}
+}
+
 func TestHandshakeResult_Fields(t *testing.T) {
 	remoteID, _ := identity.NewAgentID()
 
@@ -680,3 +818,74 @@ func (s *pipedMockStream) SetReadDeadline(t time.Time) error {
 func (s *pipedMockStream) SetWriteDeadline(t time.Time) error {
 	return nil
 }
+
+// ============================================================================
+// Certificate Pinning Tests
+// ============================================================================
+
+// tlsMockPeerConn is a mockPeerConn that exposes a TLS connection state.
+type tlsMockPeerConn struct {
+	mockPeerConn
+	cert *x509.Certificate
+}
+
+func (m *tlsMockPeerConn) TLSConnectionState() (tls.ConnectionState, bool) {
+	if m.cert == nil {
+		return tls.ConnectionState{}, true
+	}
+	return tls.ConnectionState{PeerCertificates: []*x509.Certificate{m.cert}}, true
+}
+
+func TestVerifyCertificatePin(t *testing.T) {
+	localID, _ := identity.NewAgentID()
+	agentA, _ := identity.NewAgentID()
+	agentB, _ := identity.NewAgentID()
+
+	certA, err := certutil.GenerateCA("agent-a", time.Hour)
+	if err != nil {
+		t.Fatalf("GenerateCA failed: %v", err)
+	}
+	certB, err := certutil.GenerateCA("agent-b", time.Hour)
+	if err != nil {
+		t.Fatalf("GenerateCA failed: %v", err)
+	}
+	pins := map[identity.AgentID]string{
+		agentA: certA.Fingerprint(),
+		agentB: certB.Fingerprint(),
+	}
+
+	tests := []struct {
+		name     string
+		peerConn transport.PeerConn
+		pins     map[identity.AgentID]string
+		expected string
+		remoteID identity.AgentID
+		wantErr  bool
+	}{
+		{"no pins", &tlsMockPeerConn{cert: certA.Certificate}, nil, "", agentA, false},
+		{"table match", &tlsMockPeerConn{cert: certA.Certificate}, pins, "", agentA, false},
+		{"table mismatch", &tlsMockPeerConn{cert: certB.Certificate}, pins, "", agentA, true},
+		{"unpinned agent with foreign pinned cert", &tlsMockPeerConn{cert: certA.Certificate}, pins, "", localID, true},
+		{"unpinned agent with own cert", &tlsMockPeerConn{cert: certA.Certificate}, map[identity.AgentID]string{agentB: certB.Fingerprint()}, "", agentA, false},
+		{"per-peer match", &tlsMockPeerConn{cert: certB.Certificate}, nil, certB.Fingerprint(), agentB, false},
+		{"per-peer overrides table", &tlsMockPeerConn{cert: certB.Certificate}, pins, certB.Fingerprint(), agentA, false},
+		{"per-peer mismatch", &tlsMockPeerConn{cert: certA.Certificate}, nil, certB.Fingerprint(), agentB, true},
+		{"pinned but no certificate", &tlsMockPeerConn{}, pins, "", agentA, true},
+		{"pinned but no TLS", &mockPeerConn{}, pins, "", agentA, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConnectionConfig(localID)
+			cfg.CertPins = tt.pins
+			cfg.ExpectedCertFingerprint = tt.expected
+			conn := NewConnection(tt.peerConn, cfg)
+			defer conn.Close()
+
+			err := verifyCertificatePin(conn, tt.remoteID)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("verifyCertificatePin() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
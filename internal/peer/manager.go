@@ -24,6 +24,19 @@ type PeerInfo struct {
 	Persistent   bool // If true, auto-reconnect on disconnect
 	DialOptions  *transport.DialOptions
 	Transport    transport.Transport // Transport to use for this peer (nil = use manager default)
+
+	// CertFingerprint pins the peer's TLS certificate ("sha256:<hex>").
+	// Empty means no per-peer pin (the mesh-wide CertPins still apply).
+	CertFingerprint string
+
+	// Quiet suppresses keepalives and advertisements while the link is
+	// idle. A quiet peer is not reconnected automatically; see
+	// ConnectQuietPeers.
+	Quiet bool
+
+	// MaxPayload limits the frame payloads wanted on the link (0 = the
+	// limit of the transport type). See CapabilityMaxPayload.
+	MaxPayload int
 }
 
 // ManagerConfig contains configuration for the peer manager.
@@ -36,12 +49,23 @@ type ManagerConfig struct {
 	HandshakeTimeout  time.Duration
 	KeepaliveInterval time.Duration
 	KeepaliveTimeout  time.Duration
-	KeepaliveJitter   float64 // Jitter fraction (0.0-1.0) to randomize keepalive timing
+	KeepaliveJitter   float64                                 // Jitter fraction (0.0-1.0) to randomize keepalive timing
+	CertPins          map[identity.AgentID]string             // Mesh-wide certificate pinning table (AgentID -> "sha256:<hex>")
+	WriteBatching     map[transport.TransportType]BatchConfig // Outbound frame coalescing per transport
+	QoS               QoSConfig                               // Outbound frame scheduling by traffic class
+	MaxPayload        map[transport.TransportType]int         // Frame payload limit per transport
 	ReconnectConfig   ReconnectConfig
 	Logger            *slog.Logger
 	OnPeerConnected   func(*Connection)
 	OnPeerDisconnect  func(*Connection, error)
 	OnFrame           func(*Connection, *protocol.Frame)
+	OnQuietResume     func(*Connection) // User traffic resumed on an idle quiet link
+
+	// FastPath, if set, is offered STREAM_DATA frames directly from the
+	// read loop when no earlier frame from the connection is still queued,
+	// so handling them inline cannot reorder frames. Returns true if the
+	// frame was handled; otherwise it is dispatched to OnFrame as usual.
+	FastPath func(*Connection, *protocol.Frame) bool
 }
 
 // DefaultManagerConfig returns a config with sensible defaults.
@@ -69,6 +93,9 @@ type Manager struct {
 	peerInfos   map[string]*PeerInfo // Address -> PeerInfo
 	reconnector *Reconnector
 
+	quietMu     sync.Mutex           // Serializes ConnectQuietPeers
+	quietFailed map[string]time.Time // Quiet peer address -> last failed dial
+
 	ctx    context.Context
 	cancel context.CancelFunc
 	wg     sync.WaitGroup
@@ -84,13 +111,14 @@ func NewManager(cfg ManagerConfig) *Manager {
 	}
 
 	m := &Manager{
-		cfg:        cfg,
-		handshaker: NewHandshaker(cfg.LocalID, cfg.DisplayName, cfg.Capabilities, cfg.HandshakeTimeout),
-		logger:     logger,
-		peers:      make(map[identity.AgentID]*Connection),
-		peerInfos:  make(map[string]*PeerInfo),
-		ctx:        ctx,
-		cancel:     cancel,
+		cfg:         cfg,
+		handshaker:  NewHandshaker(cfg.LocalID, cfg.DisplayName, cfg.Capabilities, cfg.HandshakeTimeout),
+		logger:      logger,
+		peers:       make(map[identity.AgentID]*Connection),
+		peerInfos:   make(map[string]*PeerInfo),
+		quietFailed: make(map[string]time.Time),
+		ctx:         ctx,
+		cancel:      cancel,
 	}
 
 	// Create reconnector with callback to this manager
@@ -134,7 +162,7 @@ func (m *Manager) connectWithTransport(ctx context.Context, tr transport.Transpo
 
 	conn, err := m.handshaker.DialAndHandshake(ctx, tr, addr, connCfg, dialOpts)
 	if err != nil {
-		if info != nil && info.Persistent {
+		if info != nil && info.Persistent && !info.Quiet {
 			m.reconnector.Schedule(addr)
 		}
 		return nil, err
@@ -148,30 +176,51 @@ func (m *Manager) connectWithTransport(ctx context.Context, tr transport.Transpo
 // buildConnectionConfig creates a ConnectionConfig and DialOptions from peer info.
 func (m *Manager) buildConnectionConfig(info *PeerInfo) (ConnectionConfig, transport.DialOptions) {
 	var expectedID identity.AgentID
+	var expectedFingerprint string
+	var quiet bool
+	var maxPayload int
 	if info != nil {
 		expectedID = info.ExpectedID
+		expectedFingerprint = info.CertFingerprint
+		quiet = info.Quiet
+		maxPayload = info.MaxPayload
 	}
 
 	connCfg := ConnectionConfig{
-		LocalID:          m.cfg.LocalID,
-		ExpectedPeerID:   expectedID,
-		Capabilities:     m.cfg.Capabilities,
-		HandshakeTimeout: m.cfg.HandshakeTimeout,
-		OnFrame:          m.cfg.OnFrame,
-		OnDisconnect:     m.handleDisconnect,
+		LocalID:                 m.cfg.LocalID,
+		ExpectedPeerID:          expectedID,
+		CertPins:                m.cfg.CertPins,
+		ExpectedCertFingerprint: expectedFingerprint,
+		Quiet:                   quiet,
+		WriteBatching:           m.cfg.WriteBatching,
+		QoS:                     m.cfg.QoS,
+		MaxPayload:              m.cfg.MaxPayload,
+		PeerMaxPayload:          maxPayload,
+		Capabilities:            m.cfg.Capabilities,
+		HandshakeTimeout:        m.cfg.HandshakeTimeout,
+		OnFrame:                 m.cfg.OnFrame,
+		OnDisconnect:            m.handleDisconnect,
 	}
 
 	dialOpts := m.cfg.DialOptions
 	if info != nil && info.DialOptions != nil {
 		dialOpts = *info.DialOptions
 	}
+	connCfg.Proxied = dialOpts.ProxyURL != ""
 
 	return connCfg, dialOpts
 }
 
 // Accept accepts an incoming connection and performs handshake.
 func (m *Manager) Accept(ctx context.Context, peerConn transport.PeerConn) (*Connection, error) {
+	return m.AcceptFrom(ctx, peerConn, nil)
+}
+
+// AcceptFrom accepts an incoming connection and performs handshake,
+// refusing agents not in allowed. An empty list allows any agent.
+func (m *Manager) AcceptFrom(ctx context.Context, peerConn transport.PeerConn, allowed []identity.AgentID) (*Connection, error) {
 	connCfg, _ := m.buildConnectionConfig(nil)
+	connCfg.AllowedAgents = allowed
 
 	conn, err := m.handshaker.AcceptHandshake(ctx, peerConn, connCfg)
 	if err != nil {
@@ -214,12 +263,32 @@ func (m *Manager) registerConnection(conn *Connection) {
 	go m.readLoop(conn)
 	go m.keepaliveLoop(conn)
 
+	m.logDowngrades(conn)
+
 	// Notify callback
 	if m.cfg.OnPeerConnected != nil {
 		m.cfg.OnPeerConnected(conn)
 	}
 }
 
+// logDowngrades logs the protocol version and each feature a peer falls
+// back on, so mixed-version links are visible during a rollout.
+func (m *Manager) logDowngrades(conn *Connection) {
+	if v := conn.ProtocolVersion(); v < protocol.ProtocolVersion {
+		m.logger.Info("peer uses older protocol version",
+			logging.KeyPeerID, conn.RemoteID.ShortString(),
+			"version", v,
+			"local_version", protocol.ProtocolVersion)
+	}
+	for _, name := range conn.MissingFeatures() {
+		f, _ := protocol.LookupFeature(name)
+		m.logger.Info("peer lacks feature, using fallback",
+			logging.KeyPeerID, conn.RemoteID.ShortString(),
+			"feature", name,
+			"fallback", f.Fallback)
+	}
+}
+
 // handleDisconnect is called when a connection is closed.
 func (m *Manager) handleDisconnect(conn *Connection, err error) {
 	m.mu.Lock()
@@ -243,8 +312,9 @@ func (m *Manager) handleDisconnect(conn *Connection, err error) {
 		m.cfg.OnPeerDisconnect(conn, err)
 	}
 
-	// Schedule reconnect if persistent, using the config address
-	if peerInfo != nil && peerInfo.Persistent && configAddr != "" {
+	// Schedule reconnect if persistent, using the config address. Quiet
+	// peers are reconnected on demand instead.
+	if peerInfo != nil && peerInfo.Persistent && !peerInfo.Quiet && configAddr != "" {
 		m.reconnector.Schedule(configAddr)
 	}
 }
@@ -301,6 +371,13 @@ func (m *Manager) readLoop(conn *Connection) {
 		}
 
 		conn.updateActivity()
+		conn.countReceived(len(frame.Payload))
+		if isUserFrame(frame.Type) {
+			conn.markUserActivity()
+		}
+		if protocol.IsStreamFrame(frame.Type) {
+			conn.streamFrameClass(frame.Type, frame.Flags, frame.StreamID, false)
+		}
 
 		// Handle control frames internally
 		switch frame.Type {
@@ -326,6 +403,13 @@ func (m *Manager) readLoop(conn *Connection) {
 			switch frame.Type {
 			case protocol.FrameUDPDatagram, protocol.FrameICMPEcho:
 				ch = conn.fastLaneCh
+			case protocol.FrameStreamData:
+				if m.cfg.FastPath != nil && conn.pending.Load() == 0 && m.cfg.FastPath(conn, frame) {
+					continue
+				}
+			}
+			if ch == conn.frameCh {
+				conn.pending.Add(1)
 			}
 			select {
 			case ch <- frame:
@@ -367,6 +451,7 @@ func (m *Manager) keepaliveLoop(conn *Connection) {
 	timer := time.NewTimer(m.jitteredKeepaliveInterval())
 	defer timer.Stop()
 
+	idle := false
 	for {
 		select {
 		case <-conn.Done():
@@ -379,6 +464,18 @@ func (m *Manager) keepaliveLoop(conn *Connection) {
 				return
 			}
 
+			// Quiet links send nothing while idle and rely on the
+			// transport to detect a dead connection
+			if m.quietIdle(conn) {
+				if !idle {
+					idle = true
+					m.logger.Debug("quiet peer idle, pausing keepalives",
+						logging.KeyPeerID, conn.RemoteID.ShortString())
+				}
+				timer.Reset(m.jitteredKeepaliveInterval())
+				continue
+			}
+
 			// Check for timeout
 			if time.Since(conn.LastActivity()) > m.cfg.KeepaliveInterval+m.cfg.KeepaliveTimeout {
 				conn.Close()
@@ -393,6 +490,15 @@ func (m *Manager) keepaliveLoop(conn *Connection) {
 				return
 			}
 
+			if idle {
+				idle = false
+				m.logger.Debug("quiet peer active, resuming keepalives",
+					logging.KeyPeerID, conn.RemoteID.ShortString())
+				if m.cfg.OnQuietResume != nil {
+					m.cfg.OnQuietResume(conn)
+				}
+			}
+
 			// Reset timer with new jittered interval
 			timer.Reset(m.jitteredKeepaliveInterval())
 		}
@@ -497,6 +603,19 @@ func (m *Manager) SendToPeer(peerID identity.AgentID, frame *protocol.Frame) err
 	return conn.WriteFrame(frame)
 }
 
+// SendRawToPeer sends an already encoded frame to a specific peer.
+func (m *Manager) SendRawToPeer(peerID identity.AgentID, raw []byte) error {
+	m.mu.RLock()
+	conn := m.peers[peerID]
+	m.mu.RUnlock()
+
+	if conn == nil {
+		return fmt.Errorf("peer not found: %s", peerID.ShortString())
+	}
+
+	return conn.WriteRawFrame(raw)
+}
+
 // GetPeerIDs returns all connected peer IDs.
 // Implements flood.PeerSender interface.
 func (m *Manager) GetPeerIDs() []identity.AgentID {
@@ -520,6 +639,18 @@ func (m *Manager) SetFrameCallback(callback func(identity.AgentID, *protocol.Fra
 	}
 }
 
+// SetFastPath sets the inline STREAM_DATA handler (see ManagerConfig.FastPath).
+// Must be called before connections are established.
+func (m *Manager) SetFastPath(handler func(identity.AgentID, *protocol.Frame) bool) {
+	if handler == nil {
+		m.cfg.FastPath = nil
+		return
+	}
+	m.cfg.FastPath = func(conn *Connection, frame *protocol.Frame) bool {
+		return handler(conn.RemoteID, frame)
+	}
+}
+
 // DisconnectAll closes all peer connections without removing peer configurations.
 // This is used for sleep mode - connections can be re-established later.
 // Unlike Close(), this does not stop the manager or reconnector.
@@ -598,8 +729,10 @@ func (m *Manager) ReconnectAll(ctx context.Context) error {
 				"addr", addr,
 				logging.KeyError, err)
 			lastErr = err
-			// Schedule for reconnection
-			m.reconnector.Schedule(addr)
+			// Schedule for reconnection (quiet peers reconnect on demand)
+			if !info.Quiet {
+				m.reconnector.Schedule(addr)
+			}
 		}
 	}
 
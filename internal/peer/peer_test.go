@@ -1,7 +1,9 @@
 package peer
 
 import (
+	"bytes"
 	"context"
+	"io"
 	"net"
 	"sync"
 	"sync/atomic"
@@ -107,6 +109,45 @@ func TestConnection_Activity(t *testing.T) {
 	}
 }
 
+func TestConnection_WriteStall(t *testing.T) {
+	localID, _ := identity.NewAgentID()
+	conn := NewConnection(&mockPeerConn{}, DefaultConnectionConfig(localID))
+	defer conn.Close()
+
+	// A pipe nobody reads blocks the write like a peer that stopped reading
+	pr, pw := io.Pipe()
+	defer pr.Close()
+	conn.writer = protocol.NewFrameWriter(pw)
+
+	if stalled, waiting := conn.WriteStall(); stalled != 0 || waiting != 0 {
+		t.Fatalf("idle WriteStall = %v, %d, want 0, 0", stalled, waiting)
+	}
+
+	for range 2 {
+		go conn.WriteFrame(&protocol.Frame{Type: protocol.FrameKeepalive, Payload: make([]byte, 8)})
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	stalled, waiting := conn.WriteStall()
+	if stalled < 40*time.Millisecond {
+		t.Errorf("stalled = %v, want at least 40ms", stalled)
+	}
+	if waiting != 1 {
+		t.Errorf("waiting = %d, want 1", waiting)
+	}
+
+	// Draining the pipe completes both writes
+	go io.Copy(io.Discard, pr)
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if stalled, waiting = conn.WriteStall(); stalled == 0 && waiting == 0 {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Errorf("WriteStall after drain = %v, %d, want 0, 0", stalled, waiting)
+}
+
 func TestConnection_RTT(t *testing.T) {
 	localID, _ := identity.NewAgentID()
 	cfg := DefaultConnectionConfig(localID)
@@ -1223,6 +1264,44 @@ func TestConnection_WriteFrame_Success(t *testing.T) {
 	}
 }
 
+func TestConnection_ByteCounters(t *testing.T) {
+	localID, _ := identity.NewAgentID()
+	cfg := DefaultConnectionConfig(localID)
+	mockConn := &mockPeerConn{}
+	conn := NewConnection(mockConn, cfg)
+	defer conn.Close()
+
+	conn.writer = protocol.NewFrameWriter(&mockStream{})
+
+	if err := conn.SendData(1, make([]byte, 100)); err != nil {
+		t.Fatalf("SendData() error = %v", err)
+	}
+	if err := conn.WriteRawFrame(make([]byte, protocol.HeaderSize+50)); err != nil {
+		t.Fatalf("WriteRawFrame() error = %v", err)
+	}
+	conn.countReceived(200)
+
+	wantSent := uint64(protocol.HeaderSize+100) + uint64(protocol.HeaderSize+50)
+	if got := conn.BytesSent(); got != wantSent {
+		t.Errorf("BytesSent() = %d, want %d", got, wantSent)
+	}
+	if got := conn.BytesReceived(); got != protocol.HeaderSize+200 {
+		t.Errorf("BytesReceived() = %d, want %d", got, protocol.HeaderSize+200)
+	}
+
+	// The first sample averages since creation, so both rates are non-zero
+	tx, rx := conn.ByteRates()
+	if tx == 0 || rx == 0 {
+		t.Errorf("ByteRates() = %d, %d, want non-zero", tx, rx)
+	}
+
+	// Within the window the previous sample is returned unchanged
+	conn.countReceived(1 << 20)
+	if tx2, rx2 := conn.ByteRates(); tx2 != tx || rx2 != rx {
+		t.Errorf("ByteRates() within window = %d, %d, want %d, %d", tx2, rx2, tx, rx)
+	}
+}
+
 func TestConnection_SendData(t *testing.T) {
 	localID, _ := identity.NewAgentID()
 	cfg := DefaultConnectionConfig(localID)
@@ -1871,3 +1950,593 @@ func TestConnection_ProcessFramesExitsOnClose(t *testing.T) {
 		t.Errorf("frames processed after close: before=%d, after=%d", beforeClose, afterClose)
 	}
 }
+
+// ============================================================================
+// Quiet Mode Tests
+// ============================================================================
+
+func TestConnection_QuietUserActivity(t *testing.T) {
+	localID, _ := identity.NewAgentID()
+	cfg := DefaultConnectionConfig(localID)
+	cfg.Quiet = true
+	conn := NewConnection(&mockPeerConn{}, cfg)
+	defer conn.Close()
+	conn.writer = protocol.NewFrameWriter(&mockStream{})
+
+	if !conn.IsQuiet() {
+		t.Error("IsQuiet() = false for quiet connection")
+	}
+
+	old := time.Now().Add(-time.Hour)
+	conn.lastUserActivity.Store(old.UnixNano())
+
+	if err := conn.SendKeepalive(); err != nil {
+		t.Fatalf("SendKeepalive() error = %v", err)
+	}
+	if !conn.LastUserActivity().Equal(old) {
+		t.Error("keepalive should not count as user activity")
+	}
+
+	if err := conn.SendData(1, []byte("x")); err != nil {
+		t.Fatalf("SendData() error = %v", err)
+	}
+	if time.Since(conn.LastUserActivity()) > time.Second {
+		t.Error("stream data should count as user activity")
+	}
+}
+
+func TestConnection_IsQuiet_RemoteCapability(t *testing.T) {
+	localID, _ := identity.NewAgentID()
+	conn := NewConnection(&mockPeerConn{}, DefaultConnectionConfig(localID))
+	defer conn.Close()
+
+	if conn.IsQuiet() {
+		t.Error("IsQuiet() = true without quiet config or capability")
+	}
+	conn.capabilities = []string{CapabilityQuiet}
+	if !conn.IsQuiet() {
+		t.Error("IsQuiet() = false with remote quiet capability")
+	}
+}
+
+func TestHandshaker_helloCapabilities(t *testing.T) {
+	localID, _ := identity.NewAgentID()
+	h := NewHandshaker(localID, "", []string{"exit"}, time.Second)
+
+	conn := &Connection{}
+	if got := h.helloCapabilities(conn); len(got) != 1 {
+		t.Errorf("helloCapabilities = %v, want [exit]", got)
+	}
+
+	conn.quiet = true
+	got := h.helloCapabilities(conn)
+	if len(got) != 2 || got[1] != CapabilityQuiet {
+		t.Errorf("helloCapabilities = %v, want [exit quiet]", got)
+	}
+	if len(h.capabilities) != 1 {
+		t.Error("helloCapabilities should not modify handshaker capabilities")
+	}
+}
+
+func TestManager_SuppressAdvertise(t *testing.T) {
+	localID, _ := identity.NewAgentID()
+	tr := transport.NewQUICTransport()
+	defer tr.Close()
+
+	cfg := DefaultManagerConfig(localID, tr)
+	cfg.KeepaliveInterval = time.Minute
+	m := NewManager(cfg)
+	defer m.Close()
+
+	quietCfg := DefaultConnectionConfig(localID)
+	quietCfg.Quiet = true
+	quiet := NewConnection(&mockPeerConn{}, quietCfg)
+	defer quiet.Close()
+	quiet.RemoteID, _ = identity.NewAgentID()
+
+	normal := NewConnection(&mockPeerConn{}, DefaultConnectionConfig(localID))
+	defer normal.Close()
+	normal.RemoteID, _ = identity.NewAgentID()
+
+	m.mu.Lock()
+	m.peers[quiet.RemoteID] = quiet
+	m.peers[normal.RemoteID] = normal
+	m.mu.Unlock()
+
+	if m.SuppressAdvertise(quiet.RemoteID) {
+		t.Error("recently active quiet peer should not be suppressed")
+	}
+
+	idle := time.Now().Add(-2 * time.Minute).UnixNano()
+	quiet.lastUserActivity.Store(idle)
+	normal.lastUserActivity.Store(idle)
+
+	if !m.SuppressAdvertise(quiet.RemoteID) {
+		t.Error("idle quiet peer should be suppressed")
+	}
+	if m.SuppressAdvertise(normal.RemoteID) {
+		t.Error("idle normal peer should not be suppressed")
+	}
+
+	ids := m.QuietPeerIDs()
+	if len(ids) != 1 || ids[0] != quiet.RemoteID {
+		t.Errorf("QuietPeerIDs = %v, want [%s]", ids, quiet.RemoteID.ShortString())
+	}
+}
+
+func TestManager_ConnectQuietPeers_RetryInterval(t *testing.T) {
+	localID, _ := identity.NewAgentID()
+	tr := transport.NewQUICTransport()
+	defer tr.Close()
+
+	cfg := DefaultManagerConfig(localID, tr)
+	cfg.HandshakeTimeout = 200 * time.Millisecond
+	m := NewManager(cfg)
+	defer m.Close()
+
+	m.AddPeer(PeerInfo{Address: "127.0.0.1:1", Persistent: true, Quiet: true})
+	m.AddPeer(PeerInfo{Address: "127.0.0.1:2", Persistent: true})
+
+	if conns := m.ConnectQuietPeers(context.Background()); len(conns) != 0 {
+		t.Fatalf("ConnectQuietPeers connected %d peers, want 0", len(conns))
+	}
+
+	m.quietMu.Lock()
+	_, quietFailed := m.quietFailed["127.0.0.1:1"]
+	_, normalFailed := m.quietFailed["127.0.0.1:2"]
+	m.quietMu.Unlock()
+	if !quietFailed {
+		t.Error("failed quiet peer should be recorded")
+	}
+	if normalFailed {
+		t.Error("non-quiet peer should not be dialed")
+	}
+	if m.reconnector.GetAttempts("127.0.0.1:1") != 0 {
+		t.Error("failed quiet peer should not be scheduled for reconnect")
+	}
+
+	// Within the retry interval the peer is skipped without dialing
+	start := time.Now()
+	m.ConnectQuietPeers(context.Background())
+	if time.Since(start) > 100*time.Millisecond {
+		t.Error("quiet peer should not be redialed within the retry interval")
+	}
+}
+
+// ============================================================================
+// Relay Fast Path Tests
+// ============================================================================
+
+func TestConnection_WriteRawFrame(t *testing.T) {
+	localID, _ := identity.NewAgentID()
+	conn := NewConnection(&mockPeerConn{}, DefaultConnectionConfig(localID))
+	defer conn.Close()
+
+	f := &protocol.Frame{Type: protocol.FrameStreamData, StreamID: 9, Payload: []byte("abc")}
+	raw, _ := f.Encode()
+
+	if err := conn.WriteRawFrame(raw); err == nil {
+		t.Error("WriteRawFrame() before handshake should fail")
+	}
+
+	stream := &mockStream{}
+	conn.writer = protocol.NewFrameWriter(stream)
+	conn.lastUserActivity.Store(time.Now().Add(-time.Hour).UnixNano())
+
+	if err := conn.WriteRawFrame(raw); err != nil {
+		t.Fatalf("WriteRawFrame() error = %v", err)
+	}
+	if !bytes.Equal(stream.data, raw) {
+		t.Error("WriteRawFrame() should write the buffer unchanged")
+	}
+	if time.Since(conn.LastUserActivity()) > time.Second {
+		t.Error("raw STREAM_DATA should count as user activity")
+	}
+}
+
+func TestConnection_PendingFrames(t *testing.T) {
+	localID, _ := identity.NewAgentID()
+	release := make(chan struct{})
+	cfg := DefaultConnectionConfig(localID)
+	cfg.OnFrame = func(*Connection, *protocol.Frame) {
+		<-release
+	}
+	conn := NewConnection(&mockPeerConn{}, cfg)
+	defer conn.Close()
+
+	conn.pending.Add(1)
+	conn.frameCh <- &protocol.Frame{Type: protocol.FrameStreamData}
+
+	time.Sleep(20 * time.Millisecond)
+	if conn.pending.Load() != 1 {
+		t.Error("frame being processed should still count as pending")
+	}
+
+	close(release)
+	deadline := time.Now().Add(time.Second)
+	for conn.pending.Load() != 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if conn.pending.Load() != 0 {
+		t.Error("pending should drop to 0 once the frame is processed")
+	}
+}
+
+// ============================================================================
+// Write Batching Tests
+// ============================================================================
+
+// countingWriter records each Write call separately.
+type countingWriter struct {
+	mu     sync.Mutex
+	writes [][]byte
+	err    error
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil {
+		return 0, w.err
+	}
+	w.writes = append(w.writes, append([]byte(nil), p...))
+	return len(p), nil
+}
+
+func (w *countingWriter) count() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return len(w.writes)
+}
+
+func TestBatchWriter_CoalescesWrites(t *testing.T) {
+	w := &countingWriter{}
+	b := newBatchWriter(w, BatchConfig{Enabled: true, FlushDelay: 20 * time.Millisecond, MaxBytes: 1024})
+
+	for i := 0; i < 5; i++ {
+		if _, err := b.Write([]byte("frame")); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+	}
+	if w.count() != 0 {
+		t.Fatalf("writes before flush delay = %d, want 0", w.count())
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for w.count() == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if w.count() != 1 {
+		t.Fatalf("writes after flush delay = %d, want 1", w.count())
+	}
+	if got := string(w.writes[0]); got != "frameframeframeframeframe" {
+		t.Errorf("coalesced write = %q", got)
+	}
+	if b.Buffered() != 0 {
+		t.Errorf("Buffered() = %d after flush, want 0", b.Buffered())
+	}
+}
+
+func TestBatchWriter_FlushesAtMaxBytes(t *testing.T) {
+	w := &countingWriter{}
+	b := newBatchWriter(w, BatchConfig{Enabled: true, FlushDelay: time.Hour, MaxBytes: 8})
+
+	b.Write([]byte("abcd"))
+	if w.count() != 0 {
+		t.Fatal("write below MaxBytes should be buffered")
+	}
+	b.Write([]byte("efgh"))
+	if w.count() != 1 {
+		t.Fatalf("writes at MaxBytes = %d, want 1", w.count())
+	}
+
+	// Large write with an empty buffer goes straight through
+	b.Write(bytes.Repeat([]byte("x"), 16))
+	if w.count() != 2 || len(w.writes[1]) != 16 {
+		t.Errorf("large write not passed through directly")
+	}
+}
+
+func TestBatchWriter_StickyError(t *testing.T) {
+	w := &countingWriter{err: net.ErrClosed}
+	b := newBatchWriter(w, BatchConfig{Enabled: true, FlushDelay: time.Hour, MaxBytes: 1024})
+
+	b.Write([]byte("frame"))
+	if err := b.Flush(); err != net.ErrClosed {
+		t.Fatalf("Flush() error = %v, want %v", err, net.ErrClosed)
+	}
+
+	w.mu.Lock()
+	w.err = nil
+	w.mu.Unlock()
+	if _, err := b.Write([]byte("frame")); err != net.ErrClosed {
+		t.Errorf("Write() after failure error = %v, want sticky %v", err, net.ErrClosed)
+	}
+}
+
+func TestConnection_EnableBatching(t *testing.T) {
+	localID, _ := identity.NewAgentID()
+
+	cfg := DefaultConnectionConfig(localID)
+	cfg.WriteBatching = map[transport.TransportType]BatchConfig{
+		transport.TransportWebSocket: {Enabled: true},
+	}
+	conn := NewConnection(&mockPeerConn{}, cfg)
+	defer conn.Close()
+	conn.controlStream = &mockStream{}
+	conn.enableBatching()
+	if conn.batch != nil {
+		t.Error("batching enabled on a transport that is not configured")
+	}
+
+	cfg.WriteBatching[transport.TransportQUIC] = BatchConfig{Enabled: true, FlushDelay: time.Hour}
+	conn2 := NewConnection(&mockPeerConn{}, cfg)
+	stream := &mockStream{}
+	conn2.controlStream = stream
+	conn2.enableBatching()
+	if conn2.batch == nil {
+		t.Fatal("batching not enabled for configured transport")
+	}
+
+	if err := conn2.SendKeepalive(); err != nil {
+		t.Fatalf("SendKeepalive() error = %v", err)
+	}
+	if conn2.batch.Buffered() == 0 {
+		t.Error("frame should be buffered")
+	}
+
+	// Close pushes out buffered frames
+	conn2.Close()
+	stream.mu.Lock()
+	n := len(stream.data)
+	stream.mu.Unlock()
+	if n == 0 {
+		t.Error("buffered frame not flushed on close")
+	}
+}
+
+// ============================================================================
+// QoS Tests
+// ============================================================================
+
+// waiting returns the number of writers queued on the scheduler.
+func (s *writeScheduler) waiting() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for _, q := range s.waiters {
+		n += len(q)
+	}
+	return n
+}
+
+func TestWriteScheduler_Disabled(t *testing.T) {
+	if s := newWriteScheduler(DefaultQoSConfig()); s != nil {
+		t.Fatal("scheduler created with QoS disabled")
+	}
+	var s *writeScheduler
+	if err := s.acquire(protocol.ClassBulk, nil); err != nil {
+		t.Errorf("nil scheduler acquire() error = %v", err)
+	}
+	s.release()
+}
+
+func TestWriteScheduler_WeightedOrder(t *testing.T) {
+	s := newWriteScheduler(QoSConfig{Enabled: true, RPCWeight: 2, InteractiveWeight: 1, BulkWeight: 1})
+	if err := s.acquire(protocol.ClassBulk, nil); err != nil {
+		t.Fatalf("acquire() error = %v", err)
+	}
+
+	var (
+		mu    sync.Mutex
+		order []string
+		wg    sync.WaitGroup
+	)
+	queue := func(class protocol.TrafficClass) {
+		want := s.waiting() + 1
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := s.acquire(class, nil); err != nil {
+				t.Errorf("acquire(%s) error = %v", class, err)
+				return
+			}
+			mu.Lock()
+			order = append(order, class.String())
+			mu.Unlock()
+			s.release()
+		}()
+		for s.waiting() < want {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		queue(protocol.ClassBulk)
+	}
+	for i := 0; i < 3; i++ {
+		queue(protocol.ClassInteractive)
+	}
+	for i := 0; i < 3; i++ {
+		queue(protocol.ClassRPC)
+	}
+	queue(protocol.ClassControl)
+
+	s.release()
+	wg.Wait()
+
+	want := []string{
+		"control",
+		"rpc", "rpc", "interactive", "bulk",
+		"rpc", "interactive", "bulk",
+		"interactive", "bulk",
+	}
+	if len(order) != len(want) {
+		t.Fatalf("order = %v, want %v", order, want)
+	}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("order = %v, want %v", order, want)
+		}
+	}
+}
+
+func TestWriteScheduler_AbandonOnClose(t *testing.T) {
+	s := newWriteScheduler(QoSConfig{Enabled: true})
+	if err := s.acquire(protocol.ClassControl, nil); err != nil {
+		t.Fatalf("acquire() error = %v", err)
+	}
+
+	done := make(chan struct{})
+	errCh := make(chan error, 1)
+	go func() { errCh <- s.acquire(protocol.ClassBulk, done) }()
+	for s.waiting() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	close(done)
+	if err := <-errCh; err != errConnectionClosed {
+		t.Errorf("acquire() error = %v, want %v", err, errConnectionClosed)
+	}
+
+	s.release()
+	if s.busy || s.waiting() != 0 {
+		t.Error("scheduler should be idle after the abandoned writer")
+	}
+}
+
+func TestConnection_StreamClassFlags(t *testing.T) {
+	localID, _ := identity.NewAgentID()
+	cfg := DefaultConnectionConfig(localID)
+	cfg.QoS = QoSConfig{Enabled: true}
+	conn := NewConnection(&mockPeerConn{}, cfg)
+	defer conn.Close()
+	stream := &mockStream{}
+	conn.writer = protocol.NewFrameWriter(stream)
+
+	lastFlags := func() uint8 {
+		stream.mu.Lock()
+		defer stream.mu.Unlock()
+		f, err := protocol.NewFrameReader(bytes.NewReader(stream.data)).Read()
+		if err != nil {
+			t.Fatalf("Read() error = %v", err)
+		}
+		stream.data = nil
+		return f.Flags
+	}
+
+	if err := conn.WriteFrame(&protocol.Frame{Type: protocol.FrameStreamOpen, Flags: protocol.ClassBulk.Flags(), StreamID: 7}); err != nil {
+		t.Fatalf("WriteFrame() error = %v", err)
+	}
+	lastFlags()
+
+	if err := conn.SendData(7, []byte("x")); err != nil {
+		t.Fatalf("SendData() error = %v", err)
+	}
+	if got := protocol.FlagsClass(lastFlags()); got != protocol.ClassBulk {
+		t.Errorf("STREAM_DATA class = %s, want bulk", got)
+	}
+
+	// FIN bits are kept alongside the class
+	if err := conn.WriteFrame(&protocol.Frame{Type: protocol.FrameStreamClose, Flags: protocol.FlagFinWrite, StreamID: 7}); err != nil {
+		t.Fatalf("WriteFrame() error = %v", err)
+	}
+	if flags := lastFlags(); flags != protocol.FlagFinWrite|protocol.ClassBulk.Flags() {
+		t.Errorf("STREAM_CLOSE flags = 0x%02x", flags)
+	}
+	if len(conn.streamClasses) != 1 {
+		t.Fatal("stream forgotten after a one-sided close")
+	}
+
+	// The remote close ends the stream
+	conn.streamFrameClass(protocol.FrameStreamClose, protocol.FlagFinWrite, 7, false)
+	if len(conn.streamClasses) != 0 {
+		t.Error("stream class kept after both sides closed")
+	}
+
+	// Unknown streams are interactive
+	if err := conn.SendData(9, []byte("x")); err != nil {
+		t.Fatalf("SendData() error = %v", err)
+	}
+	if got := protocol.FlagsClass(lastFlags()); got != protocol.ClassInteractive {
+		t.Errorf("untracked stream class = %s, want interactive", got)
+	}
+}
+
+// ============================================================================
+// Frame Size Tests
+// ============================================================================
+
+func TestNegotiateMaxPayload(t *testing.T) {
+	tests := []struct {
+		name   string
+		local  int
+		remote []string
+		want   int
+	}{
+		{"no limits", protocol.MaxPayloadSize, nil, protocol.MaxPayloadSize},
+		{"local limit", 4096, nil, 4096},
+		{"remote limit", protocol.MaxPayloadSize, []string{"exit", CapabilityMaxPayload + "8192"}, 8192},
+		{"lower of both", 4096, []string{CapabilityMaxPayload + "8192"}, 4096},
+		{"remote below minimum", protocol.MaxPayloadSize, []string{CapabilityMaxPayload + "100"}, protocol.MinPayloadSize},
+		{"remote above maximum", 4096, []string{CapabilityMaxPayload + "65536"}, 4096},
+		{"malformed remote", 4096, []string{CapabilityMaxPayload + "big"}, 4096},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := negotiateMaxPayload(tt.local, tt.remote); got != tt.want {
+				t.Errorf("negotiateMaxPayload() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConnection_localMaxPayload(t *testing.T) {
+	localID, _ := identity.NewAgentID()
+	cfg := DefaultConnectionConfig(localID)
+	conn := NewConnection(&mockPeerConn{}, cfg)
+	defer conn.Close()
+
+	if got := conn.localMaxPayload(); got != protocol.MaxPayloadSize {
+		t.Errorf("default localMaxPayload() = %d, want %d", got, protocol.MaxPayloadSize)
+	}
+	if got := conn.maxPayloadCapability(); got != "" {
+		t.Errorf("maxPayloadCapability() = %q for full-size frames, want empty", got)
+	}
+
+	// Proxied QUIC keeps full-size frames; the default only applies to WebSocket
+	conn.proxied = true
+	if got := conn.localMaxPayload(); got != protocol.MaxPayloadSize {
+		t.Errorf("proxied QUIC localMaxPayload() = %d, want %d", got, protocol.MaxPayloadSize)
+	}
+
+	conn.maxPayloadByTransport = map[transport.TransportType]int{transport.TransportQUIC: 8192}
+	if got := conn.localMaxPayload(); got != 8192 {
+		t.Errorf("transport localMaxPayload() = %d, want 8192", got)
+	}
+
+	conn.peerMaxPayload = 2048
+	if got := conn.localMaxPayload(); got != 2048 {
+		t.Errorf("per-peer localMaxPayload() = %d, want 2048", got)
+	}
+	if got := conn.maxPayloadCapability(); got != CapabilityMaxPayload+"2048" {
+		t.Errorf("maxPayloadCapability() = %q, want %s2048", got, CapabilityMaxPayload)
+	}
+
+	h := NewHandshaker(localID, "", []string{"exit"}, time.Second)
+	if got := h.helloCapabilities(conn); len(got) != 2 || got[1] != CapabilityMaxPayload+"2048" {
+		t.Errorf("helloCapabilities = %v, want [exit %s2048]", got, CapabilityMaxPayload)
+	}
+}
+
+func TestManager_MaxPayload_NotConnected(t *testing.T) {
+	localID, _ := identity.NewAgentID()
+	tr := transport.NewQUICTransport()
+	defer tr.Close()
+
+	m := NewManager(DefaultManagerConfig(localID, tr))
+	defer m.Close()
+
+	peerID, _ := identity.NewAgentID()
+	if got := m.MaxPayload(peerID); got != protocol.MaxPayloadSize {
+		t.Errorf("MaxPayload() = %d for unknown peer, want %d", got, protocol.MaxPayloadSize)
+	}
+}
@@ -0,0 +1,231 @@
+package peer
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/postalsys/muti-metroo/internal/protocol"
+)
+
+// errConnectionClosed is returned by writes that were waiting for their turn
+// when the connection closed.
+var errConnectionClosed = errors.New("connection closed")
+
+// QoSConfig configures weighted scheduling of outbound frames.
+//
+// Writers contending for a connection are served by traffic class (see
+// protocol.TrafficClass). Control frames always go first. The remaining
+// classes share the link in proportion to their weights, counted in frames,
+// so a bulk transfer keeps a share of the link without starving shells or
+// interactive streams.
+type QoSConfig struct {
+	// Enabled turns on scheduling. Class flags are set on stream frames
+	// either way.
+	Enabled bool
+
+	// Weights of the RPC, interactive and bulk classes
+	RPCWeight         int
+	InteractiveWeight int
+	BulkWeight        int
+}
+
+// DefaultQoSConfig returns sensible defaults with scheduling disabled.
+func DefaultQoSConfig() QoSConfig {
+	return QoSConfig{
+		Enabled:           false,
+		RPCWeight:         4,
+		InteractiveWeight: 2,
+		BulkWeight:        1,
+	}
+}
+
+// weightedClasses lists the classes served by weight, highest priority first.
+var weightedClasses = [...]protocol.TrafficClass{
+	protocol.ClassRPC,
+	protocol.ClassInteractive,
+	protocol.ClassBulk,
+}
+
+// writeScheduler hands the connection's write turn to waiting writers by
+// traffic class. A nil scheduler lets writers through in lock order.
+type writeScheduler struct {
+	mu      sync.Mutex
+	busy    bool
+	waiters [protocol.NumTrafficClasses][]chan struct{}
+	weights [protocol.NumTrafficClasses]int
+	credits [protocol.NumTrafficClasses]int
+}
+
+// newWriteScheduler returns a scheduler for cfg, or nil when scheduling is
+// disabled. Unset weights are filled from DefaultQoSConfig.
+func newWriteScheduler(cfg QoSConfig) *writeScheduler {
+	if !cfg.Enabled {
+		return nil
+	}
+	defaults := DefaultQoSConfig()
+	s := &writeScheduler{}
+	s.weights[protocol.ClassRPC] = positiveOr(cfg.RPCWeight, defaults.RPCWeight)
+	s.weights[protocol.ClassInteractive] = positiveOr(cfg.InteractiveWeight, defaults.InteractiveWeight)
+	s.weights[protocol.ClassBulk] = positiveOr(cfg.BulkWeight, defaults.BulkWeight)
+	s.credits = s.weights
+	return s
+}
+
+// positiveOr returns v, or def when v is not positive.
+func positiveOr(v, def int) int {
+	if v <= 0 {
+		return def
+	}
+	return v
+}
+
+// acquire waits for the write turn. Returns errConnectionClosed if done is
+// closed first.
+func (s *writeScheduler) acquire(class protocol.TrafficClass, done <-chan struct{}) error {
+	if s == nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	if !s.busy {
+		s.busy = true
+		s.mu.Unlock()
+		return nil
+	}
+	turn := make(chan struct{})
+	s.waiters[class] = append(s.waiters[class], turn)
+	s.mu.Unlock()
+
+	select {
+	case <-turn:
+		return nil
+	case <-done:
+	}
+
+	s.mu.Lock()
+	queue := s.waiters[class]
+	for i, ch := range queue {
+		if ch == turn {
+			s.waiters[class] = append(queue[:i], queue[i+1:]...)
+			s.mu.Unlock()
+			return errConnectionClosed
+		}
+	}
+	s.mu.Unlock()
+
+	// The turn was handed over while giving up; pass it on
+	s.release()
+	return errConnectionClosed
+}
+
+// release ends the current write turn and hands it to the next waiter.
+func (s *writeScheduler) release() {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if next := s.next(); next != nil {
+		close(next)
+		return
+	}
+	s.busy = false
+}
+
+// next dequeues the waiter to serve next: control first, then the weighted
+// classes while they have credits left in the current round. Starts a new
+// round once every waiting class has spent its credits. Called with mu held.
+func (s *writeScheduler) next() chan struct{} {
+	if turn := s.pop(protocol.ClassControl); turn != nil {
+		return turn
+	}
+	for round := 0; round < 2; round++ {
+		for _, class := range weightedClasses {
+			if s.credits[class] > 0 && len(s.waiters[class]) > 0 {
+				s.credits[class]--
+				return s.pop(class)
+			}
+		}
+		s.credits = s.weights
+	}
+	return nil
+}
+
+// pop dequeues the oldest waiter of a class. Called with mu held.
+func (s *writeScheduler) pop(class protocol.TrafficClass) chan struct{} {
+	queue := s.waiters[class]
+	if len(queue) == 0 {
+		return nil
+	}
+	turn := queue[0]
+	queue[0] = nil
+	s.waiters[class] = queue[1:]
+	return turn
+}
+
+// Stream close directions recorded in streamClass.closed.
+const (
+	closedLocal uint8 = 1 << iota
+	closedRemote
+)
+
+// streamClass is the traffic class of a stream open on a connection.
+type streamClass struct {
+	class  protocol.TrafficClass
+	closed uint8 // closedLocal and closedRemote bits
+}
+
+// streamFrameClass returns the class of a stream frame sent (local) or
+// received on the connection and tracks stream classes so that frames
+// without class bits, such as STREAM_DATA written by the stream handlers,
+// inherit the class their STREAM_OPEN carried. Only non-interactive streams
+// are tracked; they are forgotten once reset, refused or closed in both
+// directions.
+func (c *Connection) streamFrameClass(frameType, flags uint8, streamID uint64, local bool) protocol.TrafficClass {
+	class := protocol.FlagsClass(flags)
+
+	c.classMu.Lock()
+	defer c.classMu.Unlock()
+
+	if frameType == protocol.FrameStreamOpen {
+		if class != protocol.ClassInteractive {
+			if c.streamClasses == nil {
+				c.streamClasses = make(map[uint64]*streamClass)
+			}
+			c.streamClasses[streamID] = &streamClass{class: class}
+		}
+		return class
+	}
+
+	sc := c.streamClasses[streamID]
+	if sc == nil {
+		return class
+	}
+	switch frameType {
+	case protocol.FrameStreamOpenErr, protocol.FrameStreamReset:
+		delete(c.streamClasses, streamID)
+	case protocol.FrameStreamClose:
+		if local {
+			sc.closed |= closedLocal
+		} else {
+			sc.closed |= closedRemote
+		}
+		if sc.closed == closedLocal|closedRemote {
+			delete(c.streamClasses, streamID)
+		}
+	}
+	if class == protocol.ClassInteractive {
+		class = sc.class
+	}
+	return class
+}
+
+// frameClass returns the class of an outbound frame.
+func (c *Connection) frameClass(frameType, flags uint8, streamID uint64) protocol.TrafficClass {
+	if protocol.IsStreamFrame(frameType) {
+		return c.streamFrameClass(frameType, flags, streamID, true)
+	}
+	return protocol.ClassOf(frameType, flags)
+}
@@ -0,0 +1,157 @@
+package peer
+
+import (
+	"context"
+	"time"
+
+	"github.com/postalsys/muti-metroo/internal/identity"
+	"github.com/postalsys/muti-metroo/internal/logging"
+	"github.com/postalsys/muti-metroo/internal/protocol"
+)
+
+// CapabilityQuiet is announced in PEER_HELLO by a dialer that wants the link
+// to stay silent while idle. Both sides then suppress keepalives and periodic
+// route/node-info advertisements once no user traffic has flowed for a
+// keepalive interval.
+const CapabilityQuiet = "quiet"
+
+// quietRetryInterval is the minimum time between on-demand dial attempts to
+// the same unreachable quiet peer.
+const quietRetryInterval = 10 * time.Second
+
+// IsQuiet returns true if this link runs in quiet mode, either because it was
+// dialed as a quiet peer or because the remote dialer requested it.
+func (c *Connection) IsQuiet() bool {
+	return c.quiet || c.HasCapability(CapabilityQuiet)
+}
+
+// LastUserActivity returns the time the last stream, UDP or ICMP frame was
+// sent or received.
+func (c *Connection) LastUserActivity() time.Time {
+	return time.Unix(0, c.lastUserActivity.Load())
+}
+
+// markUserActivity updates the last user activity timestamp.
+func (c *Connection) markUserActivity() {
+	c.lastUserActivity.Store(time.Now().UnixNano())
+}
+
+// isUserFrame reports whether a frame carries user traffic, as opposed to
+// keepalives, advertisements and control messages.
+func isUserFrame(frameType uint8) bool {
+	switch frameType {
+	case protocol.FrameStreamOpen, protocol.FrameStreamOpenAck, protocol.FrameStreamOpenErr,
+		protocol.FrameStreamData, protocol.FrameStreamClose, protocol.FrameStreamReset,
+		protocol.FrameUDPOpen, protocol.FrameUDPOpenAck, protocol.FrameUDPOpenErr,
+		protocol.FrameUDPDatagram, protocol.FrameUDPClose,
+		protocol.FrameICMPOpen, protocol.FrameICMPOpenAck, protocol.FrameICMPOpenErr,
+		protocol.FrameICMPEcho, protocol.FrameICMPClose:
+		return true
+	}
+	return false
+}
+
+// helloCapabilities returns the capabilities to announce on a connection.
+func (h *Handshaker) helloCapabilities(conn *Connection) []string {
+	maxPayload := conn.maxPayloadCapability()
+	if !conn.quiet && maxPayload == "" {
+		return h.capabilities
+	}
+	caps := make([]string, 0, len(h.capabilities)+2)
+	caps = append(caps, h.capabilities...)
+	if conn.quiet {
+		caps = append(caps, CapabilityQuiet)
+	}
+	if maxPayload != "" {
+		caps = append(caps, maxPayload)
+	}
+	return caps
+}
+
+// quietIdle reports whether a quiet link has carried no user traffic for a
+// keepalive interval.
+func (m *Manager) quietIdle(conn *Connection) bool {
+	return conn.IsQuiet() && time.Since(conn.LastUserActivity()) > m.cfg.KeepaliveInterval
+}
+
+// SuppressAdvertise returns true if periodic route and node info
+// advertisements to a peer should be held back because it is an idle quiet
+// link.
+func (m *Manager) SuppressAdvertise(peerID identity.AgentID) bool {
+	m.mu.RLock()
+	conn := m.peers[peerID]
+	m.mu.RUnlock()
+	return conn != nil && m.quietIdle(conn)
+}
+
+// QuietPeerIDs returns the IDs of all connected quiet peers.
+func (m *Manager) QuietPeerIDs() []identity.AgentID {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var ids []identity.AgentID
+	for id, conn := range m.peers {
+		if conn.IsQuiet() {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// ConnectQuietPeers dials configured quiet peers that are not connected.
+// Quiet peers are not reconnected in the background, so this is called on
+// demand before outbound traffic is routed. Peers that failed to connect
+// within quietRetryInterval are skipped. Returns the connections established.
+func (m *Manager) ConnectQuietPeers(ctx context.Context) []*Connection {
+	if m.reconnector.IsPaused() {
+		return nil
+	}
+
+	m.quietMu.Lock()
+	defer m.quietMu.Unlock()
+
+	m.mu.RLock()
+	connected := make(map[string]bool, len(m.peers))
+	for id, conn := range m.peers {
+		connected[id.String()] = true
+		if addr := conn.ConfigAddr(); addr != "" {
+			connected[addr] = true
+		}
+	}
+	var infos []*PeerInfo
+	for addr, info := range m.peerInfos {
+		if !info.Quiet || connected[addr] {
+			continue
+		}
+		if info.ExpectedID != (identity.AgentID{}) && connected[info.ExpectedID.String()] {
+			continue
+		}
+		if time.Since(m.quietFailed[addr]) < quietRetryInterval {
+			continue
+		}
+		infos = append(infos, info)
+	}
+	m.mu.RUnlock()
+
+	var conns []*Connection
+	for _, info := range infos {
+		tr := m.cfg.Transport
+		if info.Transport != nil {
+			tr = info.Transport
+		}
+
+		connCtx, cancel := context.WithTimeout(ctx, m.cfg.HandshakeTimeout+m.cfg.DialOptions.Timeout)
+		conn, err := m.connectWithTransport(connCtx, tr, info.Address)
+		cancel()
+		if err != nil {
+			m.quietFailed[info.Address] = time.Now()
+			m.logger.Debug("failed to connect quiet peer",
+				logging.KeyAddress, info.Address,
+				logging.KeyError, err)
+			continue
+		}
+		delete(m.quietFailed, info.Address)
+		conns = append(conns, conn)
+	}
+	return conns
+}
@@ -0,0 +1,66 @@
+package peer
+
+import (
+	"sync"
+	"time"
+
+	"github.com/postalsys/muti-metroo/internal/protocol"
+)
+
+// rateWindow is the minimum interval over which ByteRates averages. Calls
+// within the window return the previous result.
+const rateWindow = 5 * time.Second
+
+// trafficRates holds the last byte rate sample of a connection.
+type trafficRates struct {
+	mu       sync.Mutex
+	at       time.Time // Time of the last sample (connection creation before the first)
+	sampled  bool
+	sent     uint64 // BytesSent at the last sample
+	recv     uint64 // BytesReceived at the last sample
+	txPerSec uint64
+	rxPerSec uint64
+}
+
+// BytesSent returns the number of frame bytes written to the peer.
+func (c *Connection) BytesSent() uint64 {
+	return c.bytesSent.Load()
+}
+
+// BytesReceived returns the number of frame bytes read from the peer.
+func (c *Connection) BytesReceived() uint64 {
+	return c.bytesRecv.Load()
+}
+
+// ByteRates returns the average send and receive rates in bytes per second
+// since the previous sample. A new sample is taken at most once per
+// rateWindow; the first call averages since the connection was created.
+func (c *Connection) ByteRates() (txPerSec, rxPerSec uint64) {
+	c.rates.mu.Lock()
+	defer c.rates.mu.Unlock()
+
+	now := time.Now()
+	elapsed := now.Sub(c.rates.at)
+	if c.rates.sampled && elapsed < rateWindow {
+		return c.rates.txPerSec, c.rates.rxPerSec
+	}
+
+	sent, recv := c.BytesSent(), c.BytesReceived()
+	if secs := elapsed.Seconds(); secs > 0 {
+		c.rates.txPerSec = uint64(float64(sent-c.rates.sent) / secs)
+		c.rates.rxPerSec = uint64(float64(recv-c.rates.recv) / secs)
+	}
+	c.rates.at, c.rates.sent, c.rates.recv = now, sent, recv
+	c.rates.sampled = true
+	return c.rates.txPerSec, c.rates.rxPerSec
+}
+
+// countSent adds a written frame to the sent byte counter.
+func (c *Connection) countSent(payloadLen int) {
+	c.bytesSent.Add(uint64(protocol.HeaderSize + payloadLen))
+}
+
+// countReceived adds a read frame to the received byte counter.
+func (c *Connection) countReceived(payloadLen int) {
+	c.bytesRecv.Add(uint64(protocol.HeaderSize + payloadLen))
+}
@@ -0,0 +1,164 @@
+// Package privdrop switches a process started as root to an unprivileged
+// user once it has bound its sockets. On Linux selected capabilities can be
+// kept across the switch, so raw ICMP sockets or later low-port binds keep
+// working without the rest of root's privileges.
+package privdrop
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// ErrUnsupported is returned by Drop on platforms without user switching.
+var ErrUnsupported = errors.New("dropping privileges is not supported on this platform")
+
+// capabilities maps the supported capability names to their Linux numbers.
+var capabilities = map[string]uint{
+	"net_bind_service": 10,
+	"net_admin":        12,
+	"net_raw":          13,
+}
+
+// ParseCapability returns the Linux number of a capability name such as
+// "net_raw" or "CAP_NET_RAW".
+func ParseCapability(name string) (uint, error) {
+	key := strings.TrimPrefix(strings.ToLower(strings.TrimSpace(name)), "cap_")
+	c, ok := capabilities[key]
+	if !ok {
+		return 0, fmt.Errorf("unsupported capability %q (supported: net_bind_service, net_admin, net_raw)", name)
+	}
+	return c, nil
+}
+
+// Config selects the user to switch to.
+type Config struct {
+	User         string   // User name or numeric ID
+	Group        string   // Group name or numeric ID; empty for the user's primary group
+	Capabilities []string // Capabilities to keep (Linux only)
+}
+
+// credentials are the resolved IDs of a Config.
+type credentials struct {
+	uid    int
+	gid    int
+	groups []int
+}
+
+// Drop switches the process to cfg.User. The process must run as root,
+// unless it already runs as that user, in which case Drop does nothing.
+func Drop(cfg Config) error {
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		return ErrUnsupported
+	}
+
+	creds, err := resolve(cfg)
+	if err != nil {
+		return err
+	}
+
+	caps := make([]uint, 0, len(cfg.Capabilities))
+	for _, name := range cfg.Capabilities {
+		c, err := ParseCapability(name)
+		if err != nil {
+			return err
+		}
+		caps = append(caps, c)
+	}
+
+	if uid := os.Geteuid(); uid != 0 {
+		if uid == creds.uid {
+			return nil
+		}
+		return fmt.Errorf("switching to user %s requires root", cfg.User)
+	}
+	return drop(creds, caps)
+}
+
+// Account is a resolved user account.
+type Account struct {
+	Username string
+	HomeDir  string
+	UID      int
+	GID      int   // cfg.Group, or the user's primary group
+	Groups   []int // GID first, then the user's supplementary groups
+}
+
+// LookupAccount resolves the user and group of cfg without switching to
+// them, e.g. to start commands as that user.
+func LookupAccount(cfg Config) (Account, error) {
+	u, err := lookupUser(cfg.User)
+	if err != nil {
+		return Account{}, err
+	}
+	uid, err := strconv.Atoi(u.Uid)
+	if err != nil {
+		return Account{}, fmt.Errorf("user %s: invalid uid %q", cfg.User, u.Uid)
+	}
+
+	gidStr := u.Gid
+	if cfg.Group != "" {
+		g, err := lookupGroup(cfg.Group)
+		if err != nil {
+			return Account{}, err
+		}
+		gidStr = g.Gid
+	}
+	gid, err := strconv.Atoi(gidStr)
+	if err != nil {
+		return Account{}, fmt.Errorf("group %s: invalid gid %q", cfg.Group, gidStr)
+	}
+
+	// Keep the user's supplementary groups; without them only the primary group
+	groups := []int{gid}
+	if ids, err := u.GroupIds(); err == nil {
+		for _, id := range ids {
+			if n, err := strconv.Atoi(id); err == nil && n != gid {
+				groups = append(groups, n)
+			}
+		}
+	}
+
+	return Account{Username: u.Username, HomeDir: u.HomeDir, UID: uid, GID: gid, Groups: groups}, nil
+}
+
+// resolve looks up the user, group and supplementary groups of cfg.
+func resolve(cfg Config) (credentials, error) {
+	acct, err := LookupAccount(cfg)
+	if err != nil {
+		return credentials{}, err
+	}
+	return credentials{uid: acct.UID, gid: acct.GID, groups: acct.Groups}, nil
+}
+
+// lookupUser finds a user by name or numeric ID.
+func lookupUser(name string) (*user.User, error) {
+	if _, err := strconv.Atoi(name); err == nil {
+		if u, err := user.LookupId(name); err == nil {
+			return u, nil
+		}
+	}
+	u, err := user.Lookup(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown user %s: %w", name, err)
+	}
+	return u, nil
+}
+
+// lookupGroup finds a group by name or numeric ID.
+func lookupGroup(name string) (*user.Group, error) {
+	if _, err := strconv.Atoi(name); err == nil {
+		if g, err := user.LookupGroupId(name); err == nil {
+			return g, nil
+		}
+	}
+	g, err := user.LookupGroup(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown group %s: %w", name, err)
+	}
+	return g, nil
+}
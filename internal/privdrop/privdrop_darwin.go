@@ -0,0 +1,23 @@
+//go:build darwin
+
+package privdrop
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// drop switches the process to creds. Capabilities do not exist on macOS
+// and are ignored.
+func drop(creds credentials, _ []uint) error {
+	if err := syscall.Setgroups(creds.groups); err != nil {
+		return fmt.Errorf("setgroups: %w", err)
+	}
+	if err := syscall.Setgid(creds.gid); err != nil {
+		return fmt.Errorf("setgid %d: %w", creds.gid, err)
+	}
+	if err := syscall.Setuid(creds.uid); err != nil {
+		return fmt.Errorf("setuid %d: %w", creds.uid, err)
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+//go:build linux
+
+package privdrop
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// drop switches all threads to creds. Capabilities are per thread on Linux,
+// so keeping them uses AllThreadsSyscall, which is unavailable in cgo builds.
+func drop(creds credentials, caps []uint) error {
+	if len(caps) > 0 {
+		// Keep the permitted capabilities across setuid
+		if _, _, errno := syscall.AllThreadsSyscall(syscall.SYS_PRCTL, unix.PR_SET_KEEPCAPS, 1, 0); errno != 0 {
+			if errors.Is(errno, syscall.ENOTSUP) {
+				return errors.New("keeping capabilities requires a build without cgo")
+			}
+			return fmt.Errorf("keep capabilities: %w", errno)
+		}
+	}
+
+	if err := syscall.Setgroups(creds.groups); err != nil {
+		return fmt.Errorf("setgroups: %w", err)
+	}
+	if err := syscall.Setgid(creds.gid); err != nil {
+		return fmt.Errorf("setgid %d: %w", creds.gid, err)
+	}
+	if err := syscall.Setuid(creds.uid); err != nil {
+		return fmt.Errorf("setuid %d: %w", creds.uid, err)
+	}
+	if len(caps) == 0 {
+		return nil
+	}
+
+	// setuid cleared the effective set; restore only the kept capabilities
+	// and drop the rest from the permitted set
+	var mask uint64
+	for _, c := range caps {
+		mask |= 1 << c
+	}
+	hdr := unix.CapUserHeader{Version: unix.LINUX_CAPABILITY_VERSION_3}
+	data := [2]unix.CapUserData{
+		{Effective: uint32(mask), Permitted: uint32(mask)},
+		{Effective: uint32(mask >> 32), Permitted: uint32(mask >> 32)},
+	}
+	_, _, errno := syscall.AllThreadsSyscall(unix.SYS_CAPSET,
+		uintptr(unsafe.Pointer(&hdr)), uintptr(unsafe.Pointer(&data[0])), 0)
+	runtime.KeepAlive(&hdr)
+	runtime.KeepAlive(&data)
+	if errno != 0 {
+		return fmt.Errorf("capset: %w", errno)
+	}
+	return nil
+}
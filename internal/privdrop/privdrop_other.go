@@ -0,0 +1,8 @@
+//go:build !linux && !darwin
+
+package privdrop
+
+// drop is not supported on this platform.
+func drop(creds credentials, caps []uint) error {
+	return ErrUnsupported
+}
@@ -0,0 +1,71 @@
+package privdrop
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestParseCapability(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    uint
+		wantErr bool
+	}{
+		{"net_raw", 13, false},
+		{"CAP_NET_BIND_SERVICE", 10, false},
+		{" Net_Admin ", 12, false},
+		{"sys_admin", 0, true},
+		{"", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseCapability(tt.name)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseCapability(%q) error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseCapability(%q) = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestResolve(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no numeric user IDs on Windows")
+	}
+
+	creds, err := resolve(Config{User: "0"})
+	if err != nil {
+		t.Fatalf("resolve() error = %v", err)
+	}
+	if creds.uid != 0 || creds.gid != 0 {
+		t.Errorf("resolve() = uid %d gid %d, want 0 0", creds.uid, creds.gid)
+	}
+	if len(creds.groups) == 0 || creds.groups[0] != 0 {
+		t.Errorf("groups = %v, want the primary group first", creds.groups)
+	}
+
+	if _, err := resolve(Config{User: "no-such-user-for-privdrop"}); err == nil {
+		t.Error("resolve() should fail for an unknown user")
+	}
+	if _, err := resolve(Config{User: "0", Group: "no-such-group-for-privdrop"}); err == nil {
+		t.Error("resolve() should fail for an unknown group")
+	}
+}
+
+func TestLookupAccount(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no numeric user IDs on Windows")
+	}
+
+	acct, err := LookupAccount(Config{User: "0"})
+	if err != nil {
+		t.Fatalf("LookupAccount() error = %v", err)
+	}
+	if acct.Username == "" || acct.HomeDir == "" {
+		t.Errorf("LookupAccount() = %+v, want the user name and home directory", acct)
+	}
+	if acct.UID != 0 || acct.GID != 0 {
+		t.Errorf("LookupAccount() = uid %d gid %d, want 0 0", acct.UID, acct.GID)
+	}
+}
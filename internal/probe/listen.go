@@ -16,13 +16,13 @@ import (
 
 // ListenOptions contains configuration for a probe listener.
 type ListenOptions struct {
-	// Transport type: "quic", "h2", "ws"
+	// Transport type: "quic", "h2", "ws", "wt", "tcp"
 	Transport string
 
 	// Address is the listen address (e.g., "0.0.0.0:4433")
 	Address string
 
-	// Path is the HTTP path for h2/ws transports (default: "/mesh")
+	// Path is the HTTP path for h2/ws/wt transports (default: "/mesh")
 	Path string
 
 	// TLSCert is the path to the TLS certificate file
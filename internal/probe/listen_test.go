@@ -213,6 +213,16 @@ func TestListenIntegration(t *testing.T) {
 	t.Run("WS listener and probe", func(t *testing.T) {
 		testListenerAndProbe(t, "ws", certFile, keyFile)
 	})
+
+	// Test with WebTransport transport
+	t.Run("WT listener and probe", func(t *testing.T) {
+		testListenerAndProbe(t, "wt", certFile, keyFile)
+	})
+
+	// Test with TLS/TCP transport
+	t.Run("TCP listener and probe", func(t *testing.T) {
+		testListenerAndProbe(t, "tcp", certFile, keyFile)
+	})
 }
 
 func testListenerAndProbe(t *testing.T, transportType, certFile, keyFile string) {
@@ -237,6 +247,10 @@ func testListenerAndProbeWithTransport(t *testing.T, transportType, certFile, ke
 		tr = transport.NewH2Transport()
 	case "ws":
 		tr = transport.NewWebSocketTransport()
+	case "wt":
+		tr = transport.NewWebTransportTransport()
+	case "tcp":
+		tr = transport.NewTCPTransport()
 	default:
 		t.Fatalf("Unknown transport type: %s", transportType)
 	}
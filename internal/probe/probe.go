@@ -19,13 +19,13 @@ import (
 
 // Options contains configuration for a connectivity probe.
 type Options struct {
-	// Transport type: "quic", "h2", "ws"
+	// Transport type: "quic", "h2", "ws", "wt", "tcp"
 	Transport string
 
 	// Address is the host:port to probe
 	Address string
 
-	// Path is the HTTP path for h2/ws transports (default: "/mesh")
+	// Path is the HTTP path for h2/ws/wt transports (default: "/mesh")
 	Path string
 
 	// Timeout for the entire probe operation
@@ -223,6 +223,14 @@ func performProbeHandshake(ctx context.Context, conn transport.PeerConn) (*probe
 		return nil, fmt.Errorf("failed to read PEER_HELLO_ACK: %w", err)
 	}
 
+	if frame.Type == protocol.FramePeerHelloReject {
+		reject, err := protocol.DecodePeerHelloReject(frame.Payload)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode PEER_HELLO_REJECT: %w", err)
+		}
+		return nil, &rejectedError{code: reject.ErrorCode, message: reject.Message}
+	}
+
 	if frame.Type != protocol.FramePeerHelloAck {
 		return nil, fmt.Errorf("expected PEER_HELLO_ACK, got frame type 0x%02x", frame.Type)
 	}
@@ -239,6 +247,17 @@ func performProbeHandshake(ctx context.Context, conn transport.PeerConn) (*probe
 	}, nil
 }
 
+// rejectedError is returned when the listener answers PEER_HELLO with
+// PEER_HELLO_REJECT.
+type rejectedError struct {
+	code    uint16
+	message string
+}
+
+func (e *rejectedError) Error() string {
+	return fmt.Sprintf("handshake rejected: %s (%s)", e.message, protocol.ErrorCodeName(e.code))
+}
+
 // createTransport creates a transport instance for the given type.
 func createTransport(transportType string) (transport.Transport, error) {
 	switch transportType {
@@ -248,6 +267,10 @@ func createTransport(transportType string) (transport.Transport, error) {
 		return transport.NewH2Transport(), nil
 	case "ws":
 		return transport.NewWebSocketTransport(), nil
+	case "wt":
+		return transport.NewWebTransportTransport(), nil
+	case "tcp":
+		return transport.NewTCPTransport(), nil
 	default:
 		return nil, fmt.Errorf("unknown transport type: %s", transportType)
 	}
@@ -291,7 +314,7 @@ func buildTLSConfig(opts Options) (*tls.Config, error) {
 // the appropriate scheme and path if not already present.
 func formatTransportAddress(transportType, address, path string, plaintext bool) string {
 	switch transportType {
-	case "h2":
+	case "h2", "wt":
 		return formatURLWithScheme(address, path, "https://", "http://")
 	case "ws":
 		if plaintext {
@@ -375,6 +398,12 @@ func classifyError(err error) string {
 		return "TLS handshake failed - " + err.Error()
 	}
 
+	// Refused by a listener with allowed_agents
+	var rejected *rejectedError
+	if errors.As(err, &rejected) {
+		return "Muti Metroo listener refused the probe - " + rejected.message + " (listener restricts agent IDs)"
+	}
+
 	// Protocol errors
 	if strings.Contains(errStr, "PEER_HELLO") || strings.Contains(errStr, "frame type") {
 		return "Connected but received invalid response - not a Muti Metroo listener?"
@@ -0,0 +1,69 @@
+package protocol
+
+// TrafficClass is the scheduling priority of a frame on a peer connection.
+//
+// Stream frames carry the class of their stream in the FlagClassMask bits of
+// the frame header. The class is chosen by the agent that opens the stream and
+// copied by relays, so every hop schedules the stream the same way. Frames
+// without class bits, including those from older agents, are interactive.
+// Other frame types are classified by ClassOf.
+type TrafficClass uint8
+
+const (
+	ClassInteractive TrafficClass = 0 // Proxied TCP streams and ICMP echo
+	ClassBulk        TrafficClass = 1 // File transfers and UDP datagrams
+	ClassRPC         TrafficClass = 2 // Shell sessions and control requests
+	ClassControl     TrafficClass = 3 // Keepalives, handshakes and route updates
+
+	// NumTrafficClasses is the number of traffic classes.
+	NumTrafficClasses = 4
+)
+
+// String returns the class name used in configuration and logs.
+func (c TrafficClass) String() string {
+	switch c {
+	case ClassInteractive:
+		return "interactive"
+	case ClassBulk:
+		return "bulk"
+	case ClassRPC:
+		return "rpc"
+	case ClassControl:
+		return "control"
+	default:
+		return "unknown"
+	}
+}
+
+// Flags returns the frame flag bits that carry the class.
+func (c TrafficClass) Flags() uint8 {
+	return uint8(c) << flagClassShift & FlagClassMask
+}
+
+// FlagsClass returns the class carried in frame flags.
+func FlagsClass(flags uint8) TrafficClass {
+	return TrafficClass((flags & FlagClassMask) >> flagClassShift)
+}
+
+// WithClass returns flags with the class bits replaced by c.
+func WithClass(flags uint8, c TrafficClass) uint8 {
+	return flags&^FlagClassMask | c.Flags()
+}
+
+// ClassOf returns the class of a frame. Stream frames use the class carried
+// in their flags; other frame types have a fixed class.
+func ClassOf(frameType, flags uint8) TrafficClass {
+	switch {
+	case IsStreamFrame(frameType):
+		return FlagsClass(flags)
+	case IsUDPFrame(frameType):
+		return ClassBulk
+	case IsICMPFrame(frameType):
+		return ClassInteractive
+	case frameType == FrameControlRequest || frameType == FrameControlResponse:
+		return ClassRPC
+	default:
+		// Keepalives, handshakes, routing, node info and sleep/wake
+		return ClassControl
+	}
+}
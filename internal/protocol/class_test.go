@@ -0,0 +1,47 @@
+package protocol
+
+import "testing"
+
+func TestTrafficClassFlags(t *testing.T) {
+	for c := TrafficClass(0); c < NumTrafficClasses; c++ {
+		flags := WithClass(FlagFinWrite|FlagFinRead, c)
+		if got := FlagsClass(flags); got != c {
+			t.Errorf("FlagsClass(WithClass(%s)) = %s", c, got)
+		}
+		if flags&(FlagFinWrite|FlagFinRead) != FlagFinWrite|FlagFinRead {
+			t.Errorf("WithClass(%s) cleared the FIN flags: 0x%02x", c, flags)
+		}
+		if c.String() == "unknown" {
+			t.Errorf("class %d has no name", c)
+		}
+	}
+	if FlagsClass(FlagFinWrite) != ClassInteractive {
+		t.Error("frames without class bits should be interactive")
+	}
+}
+
+func TestClassOf(t *testing.T) {
+	tests := []struct {
+		frameType uint8
+		flags     uint8
+		want      TrafficClass
+	}{
+		{FrameKeepalive, 0, ClassControl},
+		{FrameKeepaliveAck, 0, ClassControl},
+		{FrameRouteAdvertise, 0, ClassControl},
+		{FramePeerHello, 0, ClassControl},
+		{FrameSleepCommand, 0, ClassControl},
+		{FrameControlRequest, 0, ClassRPC},
+		{FrameControlResponse, 0, ClassRPC},
+		{FrameUDPDatagram, 0, ClassBulk},
+		{FrameICMPEcho, 0, ClassInteractive},
+		{FrameStreamData, 0, ClassInteractive},
+		{FrameStreamData, ClassBulk.Flags(), ClassBulk},
+		{FrameStreamOpen, ClassRPC.Flags() | FlagFinWrite, ClassRPC},
+	}
+	for _, tt := range tests {
+		if got := ClassOf(tt.frameType, tt.flags); got != tt.want {
+			t.Errorf("ClassOf(%s, 0x%02x) = %s, want %s", FrameTypeName(tt.frameType), tt.flags, got, tt.want)
+		}
+	}
+}
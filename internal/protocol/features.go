@@ -0,0 +1,78 @@
+package protocol
+
+import (
+	"fmt"
+	"slices"
+)
+
+// MinProtocolVersion is the oldest protocol version an agent still speaks.
+// Peers announcing a version below it are rejected during the handshake.
+const MinProtocolVersion uint16 = 1
+
+// Feature flags are announced in the Capabilities list of PEER_HELLO and
+// PEER_HELLO_ACK. A feature is used on a link only when both ends announce it
+// and the negotiated protocol version is at least its MinVersion, so new
+// behavior can be rolled out across a mesh running mixed versions: upgraded
+// agents use it with each other and fall back with older peers.
+const (
+	// FeatureQuietMode is announced by agents that honor quiet link
+	// requests. A dialer only runs a link in quiet mode when the listener
+	// announces it; otherwise the link keeps regular keepalives.
+	FeatureQuietMode = "feature:quiet-mode"
+)
+
+// Feature describes a negotiable feature flag.
+type Feature struct {
+	Name       string
+	MinVersion uint16 // Lowest negotiated protocol version the feature works with
+	Fallback   string // Behavior with peers that lack the feature, for logs
+}
+
+// Features lists the feature flags this agent supports, in announcement order.
+var Features = []Feature{
+	{Name: FeatureQuietMode, MinVersion: 1, Fallback: "quiet link requests are ignored, keepalives stay on"},
+}
+
+// FeatureNames returns the names of all supported features.
+func FeatureNames() []string {
+	names := make([]string, len(Features))
+	for i, f := range Features {
+		names[i] = f.Name
+	}
+	return names
+}
+
+// LookupFeature returns the feature with the given name.
+func LookupFeature(name string) (Feature, bool) {
+	for _, f := range Features {
+		if f.Name == name {
+			return f, true
+		}
+	}
+	return Feature{}, false
+}
+
+// NegotiateVersion returns the protocol version used with a peer announcing
+// remote: the lower of the two versions. Returns an error when the peer is
+// older than MinProtocolVersion.
+func NegotiateVersion(remote uint16) (uint16, error) {
+	if remote < MinProtocolVersion {
+		return 0, fmt.Errorf("protocol version %d not supported (minimum %d)", remote, MinProtocolVersion)
+	}
+	return min(remote, ProtocolVersion), nil
+}
+
+// NegotiateFeatures returns the supported features enabled with a peer that
+// announced the capabilities remote at the negotiated version, and the
+// supported features that are not. Unknown names from newer peers are
+// ignored.
+func NegotiateFeatures(version uint16, remote []string) (enabled, missing []string) {
+	for _, f := range Features {
+		if version >= f.MinVersion && slices.Contains(remote, f.Name) {
+			enabled = append(enabled, f.Name)
+		} else {
+			missing = append(missing, f.Name)
+		}
+	}
+	return enabled, missing
+}
@@ -0,0 +1,101 @@
+package protocol
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestNegotiateVersion(t *testing.T) {
+	tests := []struct {
+		remote  uint16
+		want    uint16
+		wantErr bool
+	}{
+		{remote: ProtocolVersion, want: ProtocolVersion},
+		{remote: ProtocolVersion + 1, want: ProtocolVersion},
+		{remote: MinProtocolVersion, want: MinProtocolVersion},
+		{remote: MinProtocolVersion - 1, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := NegotiateVersion(tt.remote)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("NegotiateVersion(%d) error = %v, wantErr %v", tt.remote, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("NegotiateVersion(%d) = %d, want %d", tt.remote, got, tt.want)
+		}
+	}
+}
+
+func TestNegotiateFeatures(t *testing.T) {
+	saved := Features
+	defer func() { Features = saved }()
+	Features = []Feature{
+		{Name: "feature:a", MinVersion: 1},
+		{Name: "feature:b", MinVersion: 2},
+		{Name: "feature:c", MinVersion: 1},
+	}
+
+	tests := []struct {
+		name        string
+		version     uint16
+		remote      []string
+		wantEnabled []string
+		wantMissing []string
+	}{
+		{
+			name:        "all announced",
+			version:     2,
+			remote:      []string{"feature:a", "feature:b", "feature:c"},
+			wantEnabled: []string{"feature:a", "feature:b", "feature:c"},
+		},
+		{
+			name:        "older peer announces nothing",
+			version:     1,
+			remote:      nil,
+			wantMissing: []string{"feature:a", "feature:b", "feature:c"},
+		},
+		{
+			name:        "version below feature minimum",
+			version:     1,
+			remote:      []string{"feature:a", "feature:b"},
+			wantEnabled: []string{"feature:a"},
+			wantMissing: []string{"feature:b", "feature:c"},
+		},
+		{
+			name:        "unknown names and plain capabilities ignored",
+			version:     2,
+			remote:      []string{"quiet", "feature:z", "feature:c"},
+			wantEnabled: []string{"feature:c"},
+			wantMissing: []string{"feature:a", "feature:b"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			enabled, missing := NegotiateFeatures(tt.version, tt.remote)
+			if !slices.Equal(enabled, tt.wantEnabled) {
+				t.Errorf("enabled = %v, want %v", enabled, tt.wantEnabled)
+			}
+			if !slices.Equal(missing, tt.wantMissing) {
+				t.Errorf("missing = %v, want %v", missing, tt.wantMissing)
+			}
+		})
+	}
+}
+
+func TestFeatureNames(t *testing.T) {
+	names := FeatureNames()
+	if len(names) != len(Features) {
+		t.Fatalf("FeatureNames() = %v", names)
+	}
+	for _, name := range names {
+		f, ok := LookupFeature(name)
+		if !ok || f.Name != name || f.Fallback == "" {
+			t.Errorf("LookupFeature(%q) = %+v, %v", name, f, ok)
+		}
+	}
+	if _, ok := LookupFeature("feature:unknown"); ok {
+		t.Error("LookupFeature found an unknown feature")
+	}
+}
@@ -1,11 +1,14 @@
 package protocol
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
 	"net"
+	"sync/atomic"
 
 	"github.com/postalsys/muti-metroo/internal/identity"
 )
@@ -87,6 +90,13 @@ func (w *bufferWriter) writeAgentIDs(ids []identity.AgentID) {
 	}
 }
 
+// writeE2EFlags writes the optional E2E flags byte, omitted when zero.
+func (w *bufferWriter) writeE2EFlags(flags uint8) {
+	if flags != 0 {
+		w.writeUint8(flags)
+	}
+}
+
 func (w *bufferWriter) bytes() []byte {
 	return w.buf[:w.offset]
 }
@@ -154,7 +164,7 @@ func (r *bufferReader) readUint64() uint64 {
 }
 
 func (r *bufferReader) readBytes(n int) []byte {
-	if r.err != nil || r.offset+n > len(r.buf) {
+	if r.err != nil || n < 0 || n > r.remaining() {
 		r.setError("truncated")
 		return nil
 	}
@@ -164,6 +174,13 @@ func (r *bufferReader) readBytes(n int) []byte {
 	return data
 }
 
+// boundedCount caps a decoded element count at the number of minSize-byte
+// elements left in the buffer, so a forged count cannot force a large
+// allocation before the data runs out.
+func (r *bufferReader) boundedCount(count, minSize int) int {
+	return min(count, r.remaining()/minSize)
+}
+
 func (r *bufferReader) readBool() bool {
 	return r.readUint8() != 0
 }
@@ -201,12 +218,13 @@ func (r *bufferReader) readAgentIDs() []identity.AgentID {
 	if r.err != nil {
 		return nil
 	}
+	if count*identity.IDSize > r.remaining() {
+		r.setError("agentIDs truncated")
+		return nil
+	}
 	ids := make([]identity.AgentID, count)
 	for i := 0; i < count; i++ {
 		ids[i] = r.readAgentID()
-		if r.err != nil {
-			return nil
-		}
 	}
 	return ids
 }
@@ -223,6 +241,15 @@ func (r *bufferReader) readEphemeralKey() [EphemeralKeySize]byte {
 	return key
 }
 
+// readE2EFlags reads the optional E2E flags byte that ends a frame, or
+// returns 0 if the sender did not include it.
+func (r *bufferReader) readE2EFlags() uint8 {
+	if r.err != nil || r.remaining() == 0 {
+		return 0
+	}
+	return r.readUint8()
+}
+
 // addressLength returns the byte length for a given address type.
 // For domain addresses, domainLenByte should be the first byte of the address data.
 func addressLength(addrType uint8, domainLenByte byte) (int, error) {
@@ -267,6 +294,23 @@ type Frame struct {
 	Flags    uint8
 	StreamID uint64
 	Payload  []byte
+
+	// raw is the encoded frame as read by FrameReader. Payload aliases its
+	// tail. Nil for frames built locally or by Decode.
+	raw []byte
+}
+
+// Raw returns the encoded frame as read from the wire, or nil if the frame
+// was not produced by FrameReader. Payload aliases the tail of the returned
+// buffer, so the header can be rewritten with SetRawStreamID and the buffer
+// forwarded with FrameWriter.WriteRaw without re-encoding.
+func (f *Frame) Raw() []byte {
+	return f.raw
+}
+
+// SetRawStreamID rewrites the stream ID of an encoded frame in place.
+func SetRawStreamID(raw []byte, streamID uint64) {
+	binary.BigEndian.PutUint64(raw[6:14], streamID)
 }
 
 // Encode serializes the frame to bytes.
@@ -384,7 +428,7 @@ func DecodePeerHello(buf []byte) (*PeerHello, error) {
 	}
 
 	capLen := int(r.readUint8())
-	p.Capabilities = make([]string, 0, capLen)
+	p.Capabilities = make([]string, 0, r.boundedCount(capLen, 1))
 	for i := 0; i < capLen && r.err == nil; i++ {
 		p.Capabilities = append(p.Capabilities, r.readString())
 	}
@@ -395,23 +439,211 @@ func DecodePeerHello(buf []byte) (*PeerHello, error) {
 	return p, nil
 }
 
+// PeerHelloReject is the payload for PEER_HELLO_REJECT frames, which a
+// listener sends in place of PEER_HELLO_ACK to tell the dialer why the
+// handshake was refused.
+type PeerHelloReject struct {
+	ErrorCode uint16
+	Message   string
+}
+
+// Encode serializes PeerHelloReject to bytes.
+func (p *PeerHelloReject) Encode() []byte {
+	msg := p.Message
+	if len(msg) > 255 {
+		msg = msg[:255]
+	}
+
+	w := newBufferWriter(2 + 1 + len(msg))
+	w.writeUint16(p.ErrorCode)
+	w.writeString(msg)
+
+	return w.bytes()
+}
+
+// DecodePeerHelloReject deserializes PeerHelloReject from bytes.
+func DecodePeerHelloReject(buf []byte) (*PeerHelloReject, error) {
+	if len(buf) < 3 { // 2 + 1
+		return nil, fmt.Errorf("%w: PeerHelloReject too short", ErrInvalidFrame)
+	}
+
+	r := newBufferReader(buf, "PeerHelloReject")
+	p := &PeerHelloReject{
+		ErrorCode: r.readUint16(),
+		Message:   r.readString(),
+	}
+
+	if r.err != nil {
+		return nil, r.err
+	}
+	return p, nil
+}
+
 // EphemeralKeySize is the size of X25519 ephemeral public keys.
 const EphemeralKeySize = 32
 
+// E2E flags of the open requests and acknowledgments of streams, UDP
+// associations and ICMP sessions. They follow the other fields and are
+// omitted when zero, so agents that do not know them ignore them.
+const (
+	// E2EFlagRekey: the sender supports replacing the session keys
+	// in-band. A session is rekeyed when both the open request and its
+	// acknowledgment set it.
+	E2EFlagRekey uint8 = 0x01
+)
+
 // StreamOpen is the payload for STREAM_OPEN frames.
 type StreamOpen struct {
 	RequestID       uint64
 	AddressType     uint8
 	Address         []byte // IPv4 (4), IPv6 (16), or domain (1+N)
 	Port            uint16
-	TTL             uint8
+	TTL             uint8 // Hop limit, decremented by each relay (0 = not enforced)
 	RemainingPath   []identity.AgentID
 	EphemeralPubKey [EphemeralKeySize]byte // Initiator's ephemeral public key for E2E encryption
+
+	// MaxPayload is the smallest frame payload limit of the links the open
+	// crossed so far. The ingress sets the limit of its first hop and each
+	// relay lowers it to the limit of its next hop, so the exit learns the
+	// limit of the whole path. Zero when unknown.
+	MaxPayload uint16
+
+	// ClientAddr and ClientPort identify the client connected to the
+	// ingress (IPv4 4 bytes or IPv6 16 bytes). ClientUser is the SOCKS5
+	// account it authenticated as. Empty unless the ingress shares them,
+	// for exits that apply user policy or pass the address on with the
+	// PROXY protocol.
+	ClientAddr []byte
+	ClientPort uint16
+	ClientUser string
+
+	E2EFlags uint8 // E2EFlag* bits
+
+	// Namespace is the tenant namespace the stream belongs to. Exits
+	// refuse streams from other namespaces. Empty is the default namespace.
+	Namespace string
+
+	// OpenToken identifies one connection attempt of the client across
+	// retries over other paths, so relays and exits can tell a retried
+	// STREAM_OPEN from a new stream. OpenProof shows which attempt this
+	// is. Zero from agents that do not set them.
+	OpenToken OpenToken
+	OpenProof OpenProof
+}
+
+// OpenTokenSize is the size of stream open tokens.
+const OpenTokenSize = 16
+
+// MaxOpenAttempts is the number of attempts an open chain has proofs for.
+const MaxOpenAttempts = 8
+
+// OpenToken is a client-unique token carried by STREAM_OPEN. Every attempt
+// of one dial carries the same token.
+type OpenToken [OpenTokenSize]byte
+
+// OpenProof proves that a STREAM_OPEN comes from the agent that created its
+// open token, and which attempt of the dial it is. Attempt k carries the
+// chain element that hashes to the token in k steps.
+type OpenProof struct {
+	Attempt  uint8
+	Preimage [OpenTokenSize]byte
+}
+
+// OpenChain creates the open token of one dial and the proofs of its
+// attempts. The token is the end of a hash chain over a random seed; each
+// attempt reveals the element one step further back. The token and proof
+// travel in the clear, but a transit agent that copies them from an open
+// it relays cannot make the proof of a later attempt, so it cannot take
+// the token over from the stream that holds it.
+type OpenChain struct {
+	seed     [OpenTokenSize]byte
+	attempts atomic.Uint32
+}
+
+// NewOpenChain returns an open chain with a random seed.
+func NewOpenChain() *OpenChain {
+	c := &OpenChain{}
+	rand.Read(c.seed[:])
+	return c
+}
+
+// Token returns the open token of the chain.
+func (c *OpenChain) Token() OpenToken {
+	return OpenToken(openHash(c.seed, MaxOpenAttempts))
+}
+
+// Next returns the token and the proof of the next attempt. Attempts past
+// MaxOpenAttempts repeat the proof of the last one.
+func (c *OpenChain) Next() (OpenToken, OpenProof) {
+	n := min(c.attempts.Add(1), MaxOpenAttempts)
+	return c.Token(), OpenProof{
+		Attempt:  uint8(n),
+		Preimage: openHash(c.seed, MaxOpenAttempts-int(n)),
+	}
+}
+
+// openHash applies the chain step, SHA-256 truncated to OpenTokenSize, n
+// times.
+func openHash(b [OpenTokenSize]byte, n int) [OpenTokenSize]byte {
+	for range n {
+		sum := sha256.Sum256(b[:])
+		copy(b[:], sum[:])
+	}
+	return b
+}
+
+// Verify reports whether p is a proof of an attempt of t.
+func (t OpenToken) Verify(p OpenProof) bool {
+	if t.IsZero() || p.Attempt == 0 || p.Attempt > MaxOpenAttempts {
+		return false
+	}
+	return openHash(p.Preimage, int(p.Attempt)) == t
+}
+
+// IsZero reports whether the token is unset.
+func (t OpenToken) IsZero() bool {
+	return t == OpenToken{}
+}
+
+// String returns the token in hex, for logs.
+func (t OpenToken) String() string {
+	return fmt.Sprintf("%x", t[:])
 }
 
 // Encode serializes StreamOpen to bytes.
 func (s *StreamOpen) Encode() []byte {
 	size := 8 + 1 + len(s.Address) + 2 + 1 + 1 + len(s.RemainingPath)*16 + EphemeralKeySize
+	hasAddr := len(s.ClientAddr) == 4 || len(s.ClientAddr) == 16
+	hasUser := s.ClientUser != "" && len(s.ClientUser) <= 255
+	hasNamespace := s.Namespace != "" && len(s.Namespace) <= 255
+	hasToken := !s.OpenToken.IsZero()
+	hasFlags := s.E2EFlags != 0 || hasNamespace || hasToken
+	hasLimit := s.MaxPayload != 0 || hasAddr || hasUser || hasFlags
+	if hasLimit {
+		size += 2
+	}
+	var user string
+	if hasUser {
+		user = s.ClientUser
+	}
+	if hasAddr || hasUser || hasFlags {
+		size++
+	}
+	if hasAddr {
+		size += len(s.ClientAddr) + 2
+	}
+	if hasUser || hasFlags {
+		size += 1 + len(user)
+	}
+	if hasFlags {
+		size++
+	}
+	if hasNamespace || hasToken {
+		size += 1 + len(s.Namespace)
+	}
+	if hasToken {
+		size += OpenTokenSize + 1 + OpenTokenSize
+	}
 
 	w := newBufferWriter(size)
 	w.writeUint64(s.RequestID)
@@ -421,6 +653,31 @@ func (s *StreamOpen) Encode() []byte {
 	w.writeUint8(s.TTL)
 	w.writeAgentIDs(s.RemainingPath)
 	w.writeBytes(s.EphemeralPubKey[:])
+	if hasLimit {
+		w.writeUint16(s.MaxPayload) // Zero when only later fields follow
+	}
+	switch {
+	case hasAddr:
+		w.writeUint8(uint8(len(s.ClientAddr)))
+		w.writeBytes(s.ClientAddr)
+		w.writeUint16(s.ClientPort)
+	case hasUser || hasFlags:
+		w.writeUint8(0) // No client address
+	}
+	if hasUser || hasFlags {
+		w.writeString(user) // Empty when only the flags follow
+	}
+	if hasFlags {
+		w.writeUint8(s.E2EFlags) // Zero when only the namespace follows
+	}
+	if hasNamespace || hasToken {
+		w.writeString(s.Namespace) // Empty when only the token follows
+	}
+	if hasToken {
+		w.writeBytes(s.OpenToken[:])
+		w.writeUint8(s.OpenProof.Attempt)
+		w.writeBytes(s.OpenProof.Preimage[:])
+	}
 
 	return w.bytes()
 }
@@ -457,10 +714,48 @@ func DecodeStreamOpen(buf []byte) (*StreamOpen, error) {
 	s.TTL = r.readUint8()
 	s.RemainingPath = r.readAgentIDs()
 	s.EphemeralPubKey = r.readEphemeralKey()
-
 	if r.err != nil {
 		return nil, r.err
 	}
+
+	// Path payload limit (optional - for backward compatibility with older agents)
+	if r.remaining() > 0 {
+		s.MaxPayload = r.readUint16()
+		if r.err != nil {
+			return nil, r.err
+		}
+	}
+
+	// Client address and user (optional - for backward compatibility with older agents)
+	if r.remaining() > 0 {
+		switch n := int(r.readUint8()); n {
+		case 0:
+		case 4, 16:
+			s.ClientAddr = r.readBytes(n)
+			s.ClientPort = r.readUint16()
+		default:
+			return nil, fmt.Errorf("%w: StreamOpen client address length %d", ErrInvalidFrame, n)
+		}
+		if r.remaining() > 0 {
+			s.ClientUser = r.readString()
+		}
+		if r.remaining() > 0 {
+			s.E2EFlags = r.readUint8()
+		}
+		if r.remaining() > 0 {
+			s.Namespace = r.readString()
+		}
+		if r.remaining() > 0 {
+			copy(s.OpenToken[:], r.readBytes(OpenTokenSize))
+		}
+		if r.remaining() > 0 {
+			s.OpenProof.Attempt = r.readUint8()
+			copy(s.OpenProof.Preimage[:], r.readBytes(OpenTokenSize))
+		}
+		if r.err != nil {
+			return nil, r.err
+		}
+	}
 	return s, nil
 }
 
@@ -491,16 +786,26 @@ type StreamOpenAck struct {
 	BoundAddr       []byte
 	BoundPort       uint16
 	EphemeralPubKey [EphemeralKeySize]byte // Responder's ephemeral public key for E2E encryption
+	E2EFlags        uint8                  // E2EFlag* bits
+
+	// MaxPayload is the smallest frame payload limit of the links the ACK
+	// crossed, lowered by each relay like StreamOpen.MaxPayload. Zero when
+	// unknown.
+	MaxPayload uint16
 }
 
 // Encode serializes StreamOpenAck to bytes.
 func (s *StreamOpenAck) Encode() []byte {
-	w := newBufferWriter(8 + 1 + len(s.BoundAddr) + 2 + EphemeralKeySize)
+	w := newBufferWriter(8 + 1 + len(s.BoundAddr) + 2 + EphemeralKeySize + 2 + 1)
 	w.writeUint64(s.RequestID)
 	w.writeUint8(s.BoundAddrType)
 	w.writeBytes(s.BoundAddr)
 	w.writeUint16(s.BoundPort)
 	w.writeBytes(s.EphemeralPubKey[:])
+	if s.MaxPayload != 0 || s.E2EFlags != 0 {
+		w.writeUint16(s.MaxPayload) // Zero when only the flags follow
+	}
+	w.writeE2EFlags(s.E2EFlags)
 
 	return w.bytes()
 }
@@ -531,6 +836,11 @@ func DecodeStreamOpenAck(buf []byte) (*StreamOpenAck, error) {
 	s.BoundAddr = r.readBytes(addrLen)
 	s.BoundPort = r.readUint16()
 	s.EphemeralPubKey = r.readEphemeralKey()
+	// Optional trailing path payload limit and flags (absent from older agents)
+	if r.err == nil && r.remaining() > 0 {
+		s.MaxPayload = r.readUint16()
+	}
+	s.E2EFlags = r.readE2EFlags()
 
 	if r.err != nil {
 		return nil, r.err
@@ -621,6 +931,10 @@ func DecodeKeepalive(buf []byte) (*Keepalive, error) {
 	return &Keepalive{Timestamp: r.readUint64()}, nil
 }
 
+// minRouteSize is the smallest encoded Route: family, prefix length and
+// metric with an empty prefix.
+const minRouteSize = 4
+
 // Route represents a single route in ROUTE_ADVERTISE/WITHDRAW.
 type Route struct {
 	AddressFamily uint8
@@ -748,13 +1062,16 @@ type RouteAdvertise struct {
 	Path              []identity.AgentID // Route path (may be decrypted from EncPath)
 	EncPath           *EncryptedData     // Encrypted path data (nil if not using encryption)
 	SeenBy            []identity.AgentID
+	Signature         *RouteSignature // Origin signature over Routes (nil if unsigned)
+	Namespace         string          // Tenant namespace of the origin's routes (empty = default)
 }
 
 // Encode serializes RouteAdvertise to bytes.
 // Format with encryption support:
 //
 //	origin(16) + displayNameLen(1) + displayName + seq(8) + routeCount(1) + routes +
-//	EncryptedData(flag+len+path) + seenByLen(1) + seenBy
+//	EncryptedData(flag+len+path) + seenByLen(1) + seenBy [+ RouteSignature]
+//	[+ namespace marker(1) + namespaceLen(1) + namespace]
 func (r *RouteAdvertise) Encode() []byte {
 	// Prepare path data (encrypted or plaintext)
 	encPath := r.EncPath
@@ -774,6 +1091,12 @@ func (r *RouteAdvertise) Encode() []byte {
 	}
 	size += len(encPathBytes)
 	size += 1 + len(r.SeenBy)*16
+	if r.Signature != nil {
+		size += routeSignatureSize
+	}
+	if r.Namespace != "" {
+		size += 2 + len(r.Namespace)
+	}
 
 	w := newBufferWriter(size)
 	w.writeBytes(r.OriginAgent[:])
@@ -790,6 +1113,11 @@ func (r *RouteAdvertise) Encode() []byte {
 
 	w.writeBytes(encPathBytes)
 	w.writeAgentIDs(r.SeenBy)
+	r.Signature.write(w)
+	if r.Namespace != "" {
+		w.writeUint8(routeNamespaceMarker)
+		w.writeString(r.Namespace)
+	}
 
 	return w.bytes()
 }
@@ -798,7 +1126,8 @@ func (r *RouteAdvertise) Encode() []byte {
 // Supports new format with encrypted path:
 //
 //	origin(16) + displayNameLen(1) + displayName + seq(8) + routeCount(1) + routes +
-//	EncryptedData(flag+len+path) + seenByLen(1) + seenBy
+//	EncryptedData(flag+len+path) + seenByLen(1) + seenBy [+ RouteSignature]
+//	[+ namespace marker(1) + namespaceLen(1) + namespace]
 func DecodeRouteAdvertise(buf []byte) (*RouteAdvertise, error) {
 	if len(buf) < 28 { // Minimum size
 		return nil, fmt.Errorf("%w: RouteAdvertise too short", ErrInvalidFrame)
@@ -815,6 +1144,9 @@ func DecodeRouteAdvertise(buf []byte) (*RouteAdvertise, error) {
 	}
 
 	routeCount := int(rd.readUint8())
+	if routeCount*minRouteSize > rd.remaining() {
+		return nil, fmt.Errorf("%w: RouteAdvertise routes truncated", ErrInvalidFrame)
+	}
 	ra.Routes = make([]Route, routeCount)
 	for i := 0; i < routeCount && rd.err == nil; i++ {
 		route := &ra.Routes[i]
@@ -882,6 +1214,15 @@ func DecodeRouteAdvertise(buf []byte) (*RouteAdvertise, error) {
 	if rd.err != nil {
 		return nil, rd.err
 	}
+	ra.Signature = readRouteSignature(rd)
+
+	if rd.remaining() > 1 && rd.buf[rd.offset] == routeNamespaceMarker {
+		rd.offset++
+		ra.Namespace = rd.readString()
+		if rd.err != nil {
+			return nil, rd.err
+		}
+	}
 
 	return ra, nil
 }
@@ -892,6 +1233,7 @@ type RouteWithdraw struct {
 	Sequence    uint64
 	Routes      []Route
 	SeenBy      []identity.AgentID
+	Signature   *RouteSignature // Origin signature over Routes (nil if unsigned)
 }
 
 // Encode serializes RouteWithdraw to bytes.
@@ -903,6 +1245,9 @@ func (r *RouteWithdraw) Encode() []byte {
 		size += 2 + pLen + 2 // family + prefixLen + prefix + metric
 	}
 	size += 1 + len(r.SeenBy)*16
+	if r.Signature != nil {
+		size += routeSignatureSize
+	}
 
 	w := newBufferWriter(size)
 	w.writeBytes(r.OriginAgent[:])
@@ -918,6 +1263,7 @@ func (r *RouteWithdraw) Encode() []byte {
 	}
 
 	w.writeAgentIDs(r.SeenBy)
+	r.Signature.write(w)
 	return w.bytes()
 }
 
@@ -934,6 +1280,9 @@ func DecodeRouteWithdraw(buf []byte) (*RouteWithdraw, error) {
 	}
 
 	routeCount := int(rd.readUint8())
+	if routeCount*minRouteSize > rd.remaining() {
+		return nil, fmt.Errorf("%w: RouteWithdraw routes truncated", ErrInvalidFrame)
+	}
 	rw.Routes = make([]Route, routeCount)
 	for i := 0; i < routeCount && rd.err == nil; i++ {
 		route := &rw.Routes[i]
@@ -951,10 +1300,85 @@ func DecodeRouteWithdraw(buf []byte) (*RouteWithdraw, error) {
 	if rd.err != nil {
 		return nil, rd.err
 	}
+	rw.Signature = readRouteSignature(rd)
 
 	return rw, nil
 }
 
+// routeSignatureMarker introduces a RouteSignature trailer. Agents that
+// predate route signing stop decoding after SeenBy and ignore the trailer.
+const routeSignatureMarker uint8 = 0x01
+
+// routeNamespaceMarker introduces the namespace trailer of a
+// ROUTE_ADVERTISE, after the signature if there is one. The namespace is not
+// signed: exits enforce it on every stream they accept.
+const routeNamespaceMarker uint8 = 0x02
+
+// routeSignatureSize is the encoded trailer size: marker(1) + timestamp(8) +
+// public key(32) + signature(64).
+const routeSignatureSize = 1 + 8 + 32 + SignatureSize
+
+// RouteSignature is the origin agent's Ed25519 signature over the routes of
+// a ROUTE_ADVERTISE or ROUTE_WITHDRAW. It is appended after SeenBy and
+// relayed unchanged, so receivers can verify that transit agents did not
+// forge or modify the routes.
+//
+// Signed data (RouteSignableBytes): frame type, origin agent, timestamp and
+// routes. Sequence, path and SeenBy change in transit and are not signed.
+type RouteSignature struct {
+	Timestamp uint64              // Unix nanoseconds at signing; orders route sets from one origin
+	PublicKey [32]byte            // Origin's route signing key
+	Signature [SignatureSize]byte // Ed25519 signature
+}
+
+// write appends the signature trailer. A nil signature writes nothing.
+func (s *RouteSignature) write(w *bufferWriter) {
+	if s == nil {
+		return
+	}
+	w.writeUint8(routeSignatureMarker)
+	w.writeUint64(s.Timestamp)
+	w.writeBytes(s.PublicKey[:])
+	w.writeBytes(s.Signature[:])
+}
+
+// readRouteSignature reads an optional signature trailer. Returns nil when
+// none is present or the trailer is not recognized.
+func readRouteSignature(rd *bufferReader) *RouteSignature {
+	if rd.remaining() < routeSignatureSize || rd.buf[rd.offset] != routeSignatureMarker {
+		return nil
+	}
+	rd.offset++
+	s := &RouteSignature{Timestamp: rd.readUint64()}
+	copy(s.PublicKey[:], rd.readBytes(32))
+	copy(s.Signature[:], rd.readBytes(SignatureSize))
+	return s
+}
+
+// RouteSignableBytes returns the bytes an origin signs for a route set.
+// frameType is FrameRouteAdvertise or FrameRouteWithdraw, so a signed
+// advertisement cannot be replayed as a withdrawal.
+func RouteSignableBytes(frameType uint8, origin identity.AgentID, timestamp uint64, routes []Route) []byte {
+	size := 1 + 16 + 8 + 1
+	for _, route := range routes {
+		size += 1 + 1 + 2 + len(route.Prefix) + 2
+	}
+
+	w := newBufferWriter(size)
+	w.writeUint8(frameType)
+	w.writeBytes(origin[:])
+	w.writeUint64(timestamp)
+	w.writeUint8(uint8(len(routes)))
+	for _, route := range routes {
+		w.writeUint8(route.AddressFamily)
+		w.writeUint8(route.PrefixLength)
+		w.writeUint16(uint16(len(route.Prefix)))
+		w.writeBytes(route.Prefix)
+		w.writeUint16(route.Metric)
+	}
+	return w.bytes()
+}
+
 // ============================================================================
 // Encrypted data wrappers for management key encryption
 // ============================================================================
@@ -1004,9 +1428,12 @@ func DecodeEncryptedData(buf []byte) (*EncryptedData, int, error) {
 // Used in NodeInfo to advertise connected peers to the mesh.
 type PeerConnectionInfo struct {
 	PeerID    [16]byte // Remote peer AgentID
-	Transport string   // Transport type: "quic", "h2", "ws"
+	Transport string   // Transport type: "quic", "h2", "ws", "wt", "tcp"
 	RTTMs     int64    // Round-trip time in milliseconds (0 if unknown)
 	IsDialer  bool     // True if this agent initiated the connection
+
+	TxBytesPerSec uint64 // Recent send rate to the peer (0 if unknown)
+	RxBytesPerSec uint64 // Recent receive rate from the peer (0 if unknown)
 }
 
 // MaxPeersInNodeInfo is the maximum number of peers to include in NodeInfo.
@@ -1018,6 +1445,10 @@ const MaxForwardListenersInNodeInfo = 20
 // MaxShellsInNodeInfo is the maximum number of shells to include in NodeInfo.
 const MaxShellsInNodeInfo = 10
 
+// MaxTagsInNodeInfo is the maximum number of exit tags, and of agent tags, to
+// include in NodeInfo.
+const MaxTagsInNodeInfo = 16
+
 // ForwardListenerInfo contains port forward listener information for NodeInfo.
 // Used to advertise which agents have listeners for specific forward routing keys.
 type ForwardListenerInfo struct {
@@ -1042,6 +1473,8 @@ type NodeInfo struct {
 	FileTransferEnabled bool                   // File transfer enabled (for exit agents)
 	ShellEnabled        bool                   // Shell access enabled (for exit agents)
 	IcmpEnabled         bool                   // ICMP echo (ping) handler is running
+	Tags                []string               // Exit tags (e.g., ["exit:residential"]) matched by routing.prefer_tags
+	AgentTags           []string               // Agent tags (e.g., ["env:prod", "region:eu"]) for grouping and filtering
 }
 
 // EncodeNodeInfo encodes just the NodeInfo portion to bytes.
@@ -1065,6 +1498,16 @@ func EncodeNodeInfo(info *NodeInfo) []byte {
 		shells = shells[:MaxShellsInNodeInfo]
 	}
 
+	// Limit tags to max
+	tags := info.Tags
+	if len(tags) > MaxTagsInNodeInfo {
+		tags = tags[:MaxTagsInNodeInfo]
+	}
+	agentTags := info.AgentTags
+	if len(agentTags) > MaxTagsInNodeInfo {
+		agentTags = agentTags[:MaxTagsInNodeInfo]
+	}
+
 	// Calculate size
 	size := 1 + len(info.DisplayName)
 	size += 1 + len(info.Hostname)
@@ -1092,6 +1535,16 @@ func EncodeNodeInfo(info *NodeInfo) []byte {
 	size += 1 // FileTransferEnabled
 	size += 1 // ShellEnabled
 	size += 1 // IcmpEnabled
+	size += 1 // PeerTrafficCount
+	size += len(peers) * 16
+	size += 1 // TagCount
+	for _, tag := range tags {
+		size += 1 + len(tag)
+	}
+	size += 1 // AgentTagCount
+	for _, tag := range agentTags {
+		size += 1 + len(tag)
+	}
 
 	w := newBufferWriter(size)
 	w.writeString(info.DisplayName)
@@ -1141,6 +1594,25 @@ func EncodeNodeInfo(info *NodeInfo) []byte {
 	// IcmpEnabled
 	w.writeBool(info.IcmpEnabled)
 
+	// Peer traffic rates, in the same order as Peers
+	w.writeUint8(uint8(len(peers)))
+	for _, peer := range peers {
+		w.writeUint64(peer.TxBytesPerSec)
+		w.writeUint64(peer.RxBytesPerSec)
+	}
+
+	// Tags
+	w.writeUint8(uint8(len(tags)))
+	for _, tag := range tags {
+		w.writeString(tag)
+	}
+
+	// AgentTags
+	w.writeUint8(uint8(len(agentTags)))
+	for _, tag := range agentTags {
+		w.writeString(tag)
+	}
+
 	return w.bytes()
 }
 
@@ -1166,6 +1638,9 @@ func DecodeNodeInfo(buf []byte) (*NodeInfo, error) {
 
 	// IPAddresses
 	ipCount := int(r.readUint8())
+	if ipCount > r.remaining() {
+		return nil, fmt.Errorf("%w: NodeInfo IP addresses truncated", ErrInvalidFrame)
+	}
 	info.IPAddresses = make([]string, ipCount)
 	for i := 0; i < ipCount && r.err == nil; i++ {
 		info.IPAddresses[i] = r.readString()
@@ -1260,6 +1735,45 @@ func DecodeNodeInfo(buf []byte) (*NodeInfo, error) {
 		info.IcmpEnabled = r.readBool()
 	}
 
+	// Peer traffic rates (optional - for backward compatibility with older agents)
+	if r.remaining() > 0 {
+		rateCount := int(r.readUint8())
+		for i := 0; i < rateCount && i < len(info.Peers) && r.remaining() >= 16; i++ {
+			info.Peers[i].TxBytesPerSec = r.readUint64()
+			info.Peers[i].RxBytesPerSec = r.readUint64()
+		}
+	}
+
+	// Tags (optional - for backward compatibility with older agents)
+	if r.remaining() > 0 {
+		tagCount := int(r.readUint8())
+		if tagCount > MaxTagsInNodeInfo {
+			tagCount = MaxTagsInNodeInfo
+		}
+		for i := 0; i < tagCount && r.remaining() > 0; i++ {
+			tag := r.readString()
+			if r.err != nil {
+				break
+			}
+			info.Tags = append(info.Tags, tag)
+		}
+	}
+
+	// AgentTags (optional - for backward compatibility with older agents)
+	if r.remaining() > 0 {
+		tagCount := int(r.readUint8())
+		if tagCount > MaxTagsInNodeInfo {
+			tagCount = MaxTagsInNodeInfo
+		}
+		for i := 0; i < tagCount && r.remaining() > 0; i++ {
+			tag := r.readString()
+			if r.err != nil {
+				break
+			}
+			info.AgentTags = append(info.AgentTags, tag)
+		}
+	}
+
 	return info, nil
 }
 
@@ -1370,18 +1884,20 @@ type ControlRequest struct {
 	TargetAgent identity.AgentID   // Target agent to forward request to (zero = this agent)
 	Path        []identity.AgentID // Remaining path to target
 	Data        []byte             // Optional request data (e.g., RPC request payload)
+	Role        uint8              // Role of the requester (0 = not stated, from older agents)
 }
 
 // Encode serializes ControlRequest to bytes.
 func (c *ControlRequest) Encode() []byte {
-	// Format: RequestID(8) + ControlType(1) + TargetAgent(16) + PathLen(1) + Path(N*16) + DataLen(4) + Data
-	w := newBufferWriter(8 + 1 + 16 + 1 + len(c.Path)*16 + 4 + len(c.Data))
+	// Format: RequestID(8) + ControlType(1) + TargetAgent(16) + PathLen(1) + Path(N*16) + DataLen(4) + Data + Role(1)
+	w := newBufferWriter(8 + 1 + 16 + 1 + len(c.Path)*16 + 4 + len(c.Data) + 1)
 	w.writeUint64(c.RequestID)
 	w.writeUint8(c.ControlType)
 	w.writeBytes(c.TargetAgent[:])
 	w.writeAgentIDs(c.Path)
 	w.writeUint32(uint32(len(c.Data)))
 	w.writeBytes(c.Data)
+	w.writeUint8(c.Role)
 
 	return w.bytes()
 }
@@ -1405,6 +1921,11 @@ func DecodeControlRequest(buf []byte) (*ControlRequest, error) {
 		c.Data = r.readBytes(dataLen)
 	}
 
+	// Role (optional - for backward compatibility with older agents)
+	if r.err == nil && r.remaining() > 0 {
+		c.Role = r.readUint8()
+	}
+
 	if r.err != nil {
 		return nil, r.err
 	}
@@ -1420,12 +1941,16 @@ type ControlResponse struct {
 	Data        []byte // Response data (Prometheus text, JSON status, etc.)
 }
 
+// MaxControlResponseData is the largest Data a ControlResponse carries in
+// one frame. Longer data is cut off by Encode.
+const MaxControlResponseData = MaxPayloadSize - 12
+
 // Encode serializes ControlResponse to bytes.
 func (c *ControlResponse) Encode() []byte {
 	// Limit data size to fit in payload
 	data := c.Data
-	if len(data) > MaxPayloadSize-12 {
-		data = data[:MaxPayloadSize-12]
+	if len(data) > MaxControlResponseData {
+		data = data[:MaxControlResponseData]
 	}
 
 	w := newBufferWriter(8 + 1 + 1 + 2 + len(data))
@@ -1474,11 +1999,12 @@ type UDPOpen struct {
 	TTL             uint8                  // Hop limit
 	RemainingPath   []identity.AgentID     // Route to exit agent
 	EphemeralPubKey [EphemeralKeySize]byte // Initiator's ephemeral public key for E2E encryption
+	E2EFlags        uint8                  // E2EFlag* bits
 }
 
 // Encode serializes UDPOpen to bytes.
 func (u *UDPOpen) Encode() []byte {
-	w := newBufferWriter(8 + 1 + len(u.Address) + 2 + 1 + 1 + len(u.RemainingPath)*16 + EphemeralKeySize)
+	w := newBufferWriter(8 + 1 + len(u.Address) + 2 + 1 + 1 + len(u.RemainingPath)*16 + EphemeralKeySize + 1)
 	w.writeUint64(u.RequestID)
 	w.writeUint8(u.AddressType)
 	w.writeBytes(u.Address)
@@ -1486,6 +2012,7 @@ func (u *UDPOpen) Encode() []byte {
 	w.writeUint8(u.TTL)
 	w.writeAgentIDs(u.RemainingPath)
 	w.writeBytes(u.EphemeralPubKey[:])
+	w.writeE2EFlags(u.E2EFlags)
 
 	return w.bytes()
 }
@@ -1522,6 +2049,7 @@ func DecodeUDPOpen(buf []byte) (*UDPOpen, error) {
 	u.TTL = r.readUint8()
 	u.RemainingPath = r.readAgentIDs()
 	u.EphemeralPubKey = r.readEphemeralKey()
+	u.E2EFlags = r.readE2EFlags()
 
 	if r.err != nil {
 		return nil, r.err
@@ -1537,16 +2065,18 @@ type UDPOpenAck struct {
 	BoundAddr       []byte                 // Relay bind address
 	BoundPort       uint16                 // Relay bind port
 	EphemeralPubKey [EphemeralKeySize]byte // Responder's ephemeral public key for E2E encryption
+	E2EFlags        uint8                  // E2EFlag* bits
 }
 
 // Encode serializes UDPOpenAck to bytes.
 func (u *UDPOpenAck) Encode() []byte {
-	w := newBufferWriter(8 + 1 + len(u.BoundAddr) + 2 + EphemeralKeySize)
+	w := newBufferWriter(8 + 1 + len(u.BoundAddr) + 2 + EphemeralKeySize + 1)
 	w.writeUint64(u.RequestID)
 	w.writeUint8(u.BoundAddrType)
 	w.writeBytes(u.BoundAddr)
 	w.writeUint16(u.BoundPort)
 	w.writeBytes(u.EphemeralPubKey[:])
+	w.writeE2EFlags(u.E2EFlags)
 
 	return w.bytes()
 }
@@ -1577,6 +2107,7 @@ func DecodeUDPOpenAck(buf []byte) (*UDPOpenAck, error) {
 	u.BoundAddr = r.readBytes(addrLen)
 	u.BoundPort = r.readUint16()
 	u.EphemeralPubKey = r.readEphemeralKey()
+	u.E2EFlags = r.readE2EFlags()
 
 	if r.err != nil {
 		return nil, r.err
@@ -1696,11 +2227,12 @@ type UDPClose struct {
 
 // UDP close reason codes
 const (
-	UDPCloseNormal     uint8 = 0 // Normal termination
-	UDPCloseTimeout    uint8 = 1 // Idle timeout
-	UDPCloseError      uint8 = 2 // Error occurred
-	UDPCloseTCPClosed  uint8 = 3 // TCP control connection closed
-	UDPCloseAdminClose uint8 = 4 // Administrative close
+	UDPCloseNormal        uint8 = 0 // Normal termination
+	UDPCloseTimeout       uint8 = 1 // Idle timeout
+	UDPCloseError         uint8 = 2 // Error occurred
+	UDPCloseTCPClosed     uint8 = 3 // TCP control connection closed
+	UDPCloseAdminClose    uint8 = 4 // Administrative close
+	UDPCloseE2EValidation uint8 = 5 // Datagram replayed, outside the replay window or failed authentication
 )
 
 // Encode serializes UDPClose to bytes.
@@ -1730,18 +2262,20 @@ type ICMPOpen struct {
 	TTL             uint8                  // Hop limit
 	RemainingPath   []identity.AgentID     // Route to exit agent
 	EphemeralPubKey [EphemeralKeySize]byte // Initiator's ephemeral public key for E2E encryption
+	E2EFlags        uint8                  // E2EFlag* bits
 }
 
 // Encode serializes ICMPOpen to bytes.
 func (i *ICMPOpen) Encode() []byte {
-	// Format: RequestID(8) + DestIPLen(1) + DestIP + TTL(1) + PathLen(1) + Path + EphemeralPubKey(32)
-	w := newBufferWriter(8 + 1 + len(i.DestIP) + 1 + 1 + len(i.RemainingPath)*16 + EphemeralKeySize)
+	// Format: RequestID(8) + DestIPLen(1) + DestIP + TTL(1) + PathLen(1) + Path + EphemeralPubKey(32) [+ E2EFlags(1)]
+	w := newBufferWriter(8 + 1 + len(i.DestIP) + 1 + 1 + len(i.RemainingPath)*16 + EphemeralKeySize + 1)
 	w.writeUint64(i.RequestID)
 	w.writeUint8(uint8(len(i.DestIP)))
 	w.writeBytes(i.DestIP)
 	w.writeUint8(i.TTL)
 	w.writeAgentIDs(i.RemainingPath)
 	w.writeBytes(i.EphemeralPubKey[:])
+	w.writeE2EFlags(i.E2EFlags)
 
 	return w.bytes()
 }
@@ -1762,6 +2296,7 @@ func DecodeICMPOpen(buf []byte) (*ICMPOpen, error) {
 	i.TTL = r.readUint8()
 	i.RemainingPath = r.readAgentIDs()
 	i.EphemeralPubKey = r.readEphemeralKey()
+	i.E2EFlags = r.readE2EFlags()
 
 	if r.err != nil {
 		return nil, r.err
@@ -1779,13 +2314,15 @@ func (i *ICMPOpen) GetDestinationIP() net.IP {
 type ICMPOpenAck struct {
 	RequestID       uint64                 // Correlation ID
 	EphemeralPubKey [EphemeralKeySize]byte // Responder's ephemeral public key for E2E encryption
+	E2EFlags        uint8                  // E2EFlag* bits
 }
 
 // Encode serializes ICMPOpenAck to bytes.
 func (i *ICMPOpenAck) Encode() []byte {
-	w := newBufferWriter(8 + EphemeralKeySize)
+	w := newBufferWriter(8 + EphemeralKeySize + 1)
 	w.writeUint64(i.RequestID)
 	w.writeBytes(i.EphemeralPubKey[:])
+	w.writeE2EFlags(i.E2EFlags)
 
 	return w.bytes()
 }
@@ -1801,6 +2338,7 @@ func DecodeICMPOpenAck(buf []byte) (*ICMPOpenAck, error) {
 		RequestID:       r.readUint64(),
 		EphemeralPubKey: r.readEphemeralKey(),
 	}
+	i.E2EFlags = r.readE2EFlags()
 
 	if r.err != nil {
 		return nil, r.err
@@ -2206,7 +2744,7 @@ func DecodeQueuedState(buf []byte) (*QueuedState, error) {
 
 	// Routes
 	routeCount := int(r.readUint16())
-	q.Routes = make([]RouteAdvertise, 0, routeCount)
+	q.Routes = make([]RouteAdvertise, 0, r.boundedCount(routeCount, 2))
 	for i := 0; i < routeCount && r.err == nil; i++ {
 		length := int(r.readUint16())
 		data := r.readBytes(length)
@@ -2222,7 +2760,7 @@ func DecodeQueuedState(buf []byte) (*QueuedState, error) {
 
 	// Withdraws
 	withdrawCount := int(r.readUint16())
-	q.Withdraws = make([]RouteWithdraw, 0, withdrawCount)
+	q.Withdraws = make([]RouteWithdraw, 0, r.boundedCount(withdrawCount, 2))
 	for i := 0; i < withdrawCount && r.err == nil; i++ {
 		length := int(r.readUint16())
 		data := r.readBytes(length)
@@ -2238,7 +2776,7 @@ func DecodeQueuedState(buf []byte) (*QueuedState, error) {
 
 	// NodeInfos
 	nodeInfoCount := int(r.readUint16())
-	q.NodeInfos = make([]NodeInfoAdvertise, 0, nodeInfoCount)
+	q.NodeInfos = make([]NodeInfoAdvertise, 0, r.boundedCount(nodeInfoCount, 2))
 	for i := 0; i < nodeInfoCount && r.err == nil; i++ {
 		length := int(r.readUint16())
 		data := r.readBytes(length)
@@ -2305,10 +2843,12 @@ func (fr *FrameReader) Read() (*Frame, error) {
 		return nil, err
 	}
 
-	// Read payload
-	payload := make([]byte, length)
+	// Read payload into the same buffer as the header so the frame can be
+	// forwarded as-is (see Frame.Raw)
+	raw := make([]byte, HeaderSize+int(length))
+	copy(raw, fr.header[:])
 	if length > 0 {
-		if _, err := io.ReadFull(fr.r, payload); err != nil {
+		if _, err := io.ReadFull(fr.r, raw[HeaderSize:]); err != nil {
 			return nil, err
 		}
 	}
@@ -2317,7 +2857,8 @@ func (fr *FrameReader) Read() (*Frame, error) {
 		Type:     frameType,
 		Flags:    flags,
 		StreamID: streamID,
-		Payload:  payload,
+		Payload:  raw[HeaderSize:],
+		raw:      raw,
 	}, nil
 }
 
@@ -2341,6 +2882,16 @@ func (fw *FrameWriter) Write(f *Frame) error {
 	return err
 }
 
+// WriteRaw writes an already encoded frame, such as one returned by
+// Frame.Raw.
+func (fw *FrameWriter) WriteRaw(raw []byte) error {
+	if len(raw) < HeaderSize {
+		return fmt.Errorf("%w: header too short", ErrInvalidFrame)
+	}
+	_, err := fw.w.Write(raw)
+	return err
+}
+
 // WriteFrame is a convenience method to write a frame with the given parameters.
 func (fw *FrameWriter) WriteFrame(frameType uint8, flags uint8, streamID uint64, payload []byte) error {
 	return fw.Write(&Frame{
@@ -0,0 +1,31 @@
+package protocol
+
+import "context"
+
+type pathMaxPayloadKey struct{}
+
+// WithPathMaxPayload returns ctx carrying the payload limit of the path a
+// stream was opened over (StreamOpen.MaxPayload), for handlers that size
+// the frames they send back. Zero leaves ctx unchanged.
+func WithPathMaxPayload(ctx context.Context, limit int) context.Context {
+	if limit <= 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, pathMaxPayloadKey{}, limit)
+}
+
+// PathMaxPayloadFromContext returns the limit stored by WithPathMaxPayload,
+// or 0 when the path limit is unknown.
+func PathMaxPayloadFromContext(ctx context.Context) int {
+	limit, _ := ctx.Value(pathMaxPayloadKey{}).(int)
+	return limit
+}
+
+// PathMaxPayload lowers a path payload limit to the limit of one more link.
+// A zero path limit is unknown and takes the link's limit.
+func PathMaxPayload(path, link int) int {
+	if path <= 0 || link < path {
+		return link
+	}
+	return path
+}
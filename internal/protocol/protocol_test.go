@@ -3,6 +3,7 @@ package protocol
 import (
 	"bytes"
 	"io"
+	"reflect"
 	"testing"
 
 	"github.com/postalsys/muti-metroo/internal/identity"
@@ -23,6 +24,7 @@ func TestFrameTypeName(t *testing.T) {
 		{FrameRouteWithdraw, "ROUTE_WITHDRAW"},
 		{FramePeerHello, "PEER_HELLO"},
 		{FramePeerHelloAck, "PEER_HELLO_ACK"},
+		{FramePeerHelloReject, "PEER_HELLO_REJECT"},
 		{FrameKeepalive, "KEEPALIVE"},
 		{FrameKeepaliveAck, "KEEPALIVE_ACK"},
 		{0xFF, "UNKNOWN"},
@@ -49,6 +51,7 @@ func TestErrorCodeName(t *testing.T) {
 		{ErrDNSError, "DNS_ERROR"},
 		{ErrExitDisabled, "EXIT_DISABLED"},
 		{ErrResourceLimit, "RESOURCE_LIMIT"},
+		{ErrE2EValidation, "E2E_VALIDATION_FAILED"},
 		{999, "UNKNOWN"},
 	}
 
@@ -88,7 +91,7 @@ func TestIsRoutingFrame(t *testing.T) {
 }
 
 func TestIsControlFrame(t *testing.T) {
-	controlFrames := []uint8{FramePeerHello, FramePeerHelloAck, FrameKeepalive, FrameKeepaliveAck}
+	controlFrames := []uint8{FramePeerHello, FramePeerHelloAck, FrameKeepalive, FrameKeepaliveAck, FramePeerHelloReject}
 	for _, ft := range controlFrames {
 		if !IsControlFrame(ft) {
 			t.Errorf("IsControlFrame(%s) = false, want true", FrameTypeName(ft))
@@ -268,6 +271,25 @@ func TestPeerHello_EncodeDecode(t *testing.T) {
 	}
 }
 
+func TestPeerHelloReject_EncodeDecode(t *testing.T) {
+	original := &PeerHelloReject{
+		ErrorCode: ErrAgentNotAllowed,
+		Message:   "agent not allowed on this listener",
+	}
+
+	decoded, err := DecodePeerHelloReject(original.Encode())
+	if err != nil {
+		t.Fatalf("DecodePeerHelloReject() error = %v", err)
+	}
+	if *decoded != *original {
+		t.Errorf("decoded = %+v, want %+v", decoded, original)
+	}
+
+	if _, err := DecodePeerHelloReject([]byte{0}); err == nil {
+		t.Error("DecodePeerHelloReject() accepted a truncated payload")
+	}
+}
+
 func TestPeerHello_EmptyCapabilities(t *testing.T) {
 	agentID, _ := identity.NewAgentID()
 
@@ -534,6 +556,103 @@ func TestRouteWithdraw_EncodeDecode(t *testing.T) {
 	}
 }
 
+func TestRouteSignature_EncodeDecode(t *testing.T) {
+	origin, _ := identity.NewAgentID()
+	routes := []Route{{AddressFamily: AddrFamilyIPv4, PrefixLength: 8, Prefix: []byte{10, 0, 0, 0}, Metric: 1}}
+	sig := &RouteSignature{Timestamp: 1234}
+	sig.PublicKey[0] = 0xAA
+	sig.Signature[63] = 0xBB
+
+	adv := &RouteAdvertise{OriginAgent: origin, Sequence: 1, Routes: routes, SeenBy: []identity.AgentID{origin}, Signature: sig}
+	decodedAdv, err := DecodeRouteAdvertise(adv.Encode())
+	if err != nil {
+		t.Fatalf("DecodeRouteAdvertise() error = %v", err)
+	}
+	if decodedAdv.Signature == nil || *decodedAdv.Signature != *sig {
+		t.Errorf("RouteAdvertise Signature = %+v, want %+v", decodedAdv.Signature, sig)
+	}
+
+	wd := &RouteWithdraw{OriginAgent: origin, Sequence: 2, Routes: routes, Signature: sig}
+	decodedWd, err := DecodeRouteWithdraw(wd.Encode())
+	if err != nil {
+		t.Fatalf("DecodeRouteWithdraw() error = %v", err)
+	}
+	if decodedWd.Signature == nil || *decodedWd.Signature != *sig {
+		t.Errorf("RouteWithdraw Signature = %+v, want %+v", decodedWd.Signature, sig)
+	}
+
+	// Unsigned payloads decode without a signature, and the signed payload
+	// only differs by the trailer, so older decoders ignore it
+	adv.Signature = nil
+	unsigned := adv.Encode()
+	decodedAdv, err = DecodeRouteAdvertise(unsigned)
+	if err != nil {
+		t.Fatalf("DecodeRouteAdvertise() error = %v", err)
+	}
+	if decodedAdv.Signature != nil {
+		t.Error("unsigned RouteAdvertise decoded with a signature")
+	}
+	adv.Signature = sig
+	if signed := adv.Encode(); !bytes.Equal(signed[:len(unsigned)], unsigned) {
+		t.Error("signature must be a trailer after SeenBy")
+	}
+}
+
+func TestRouteAdvertise_Namespace(t *testing.T) {
+	origin, _ := identity.NewAgentID()
+	routes := []Route{{AddressFamily: AddrFamilyIPv4, PrefixLength: 8, Prefix: []byte{10, 0, 0, 0}, Metric: 1}}
+	sig := &RouteSignature{Timestamp: 1234}
+
+	for _, signature := range []*RouteSignature{nil, sig} {
+		adv := &RouteAdvertise{OriginAgent: origin, Sequence: 1, Routes: routes, Signature: signature}
+		plain := adv.Encode()
+		adv.Namespace = "acme"
+		encoded := adv.Encode()
+		if !bytes.Equal(encoded[:len(plain)], plain) {
+			t.Fatal("namespace must be a trailer after the signature")
+		}
+
+		decoded, err := DecodeRouteAdvertise(encoded)
+		if err != nil {
+			t.Fatalf("DecodeRouteAdvertise() error = %v", err)
+		}
+		if decoded.Namespace != "acme" {
+			t.Errorf("Namespace = %q, want %q", decoded.Namespace, "acme")
+		}
+		if (decoded.Signature == nil) != (signature == nil) {
+			t.Errorf("Signature = %+v, want %+v", decoded.Signature, signature)
+		}
+
+		decoded, err = DecodeRouteAdvertise(plain)
+		if err != nil {
+			t.Fatalf("DecodeRouteAdvertise() error = %v", err)
+		}
+		if decoded.Namespace != "" {
+			t.Errorf("Namespace without trailer = %q, want empty", decoded.Namespace)
+		}
+	}
+}
+
+func TestRouteSignableBytes(t *testing.T) {
+	origin, _ := identity.NewAgentID()
+	routes := []Route{{AddressFamily: AddrFamilyIPv4, PrefixLength: 8, Prefix: []byte{10, 0, 0, 0}, Metric: 1}}
+	base := RouteSignableBytes(FrameRouteAdvertise, origin, 1, routes)
+
+	other, _ := identity.NewAgentID()
+	modified := []Route{{AddressFamily: AddrFamilyIPv4, PrefixLength: 16, Prefix: []byte{10, 0, 0, 0}, Metric: 1}}
+	variants := map[string][]byte{
+		"frame type": RouteSignableBytes(FrameRouteWithdraw, origin, 1, routes),
+		"origin":     RouteSignableBytes(FrameRouteAdvertise, other, 1, routes),
+		"timestamp":  RouteSignableBytes(FrameRouteAdvertise, origin, 2, routes),
+		"routes":     RouteSignableBytes(FrameRouteAdvertise, origin, 1, modified),
+	}
+	for name, b := range variants {
+		if bytes.Equal(base, b) {
+			t.Errorf("changing the %s does not change the signed bytes", name)
+		}
+	}
+}
+
 func TestFrameReaderWriter(t *testing.T) {
 	// Create a buffer to simulate network connection
 	buf := new(bytes.Buffer)
@@ -586,6 +705,43 @@ func TestFrameReader_EOF(t *testing.T) {
 	}
 }
 
+func TestFrameReader_RawForward(t *testing.T) {
+	in := &Frame{Type: FrameStreamData, Flags: FlagFinWrite, StreamID: 7, Payload: []byte("relayed")}
+	encoded, _ := in.Encode()
+
+	f, err := NewFrameReader(bytes.NewReader(encoded)).Read()
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if !bytes.Equal(f.Raw(), encoded) {
+		t.Fatal("Raw() should return the frame as read")
+	}
+
+	SetRawStreamID(f.Raw(), 42)
+
+	out := new(bytes.Buffer)
+	if err := NewFrameWriter(out).WriteRaw(f.Raw()); err != nil {
+		t.Fatalf("WriteRaw() error = %v", err)
+	}
+	got, err := Decode(out.Bytes())
+	if err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	if got.StreamID != 42 || got.Type != in.Type || got.Flags != in.Flags {
+		t.Errorf("forwarded frame = %v, want StreamID 42 with original type and flags", got)
+	}
+	if !bytes.Equal(got.Payload, in.Payload) {
+		t.Error("forwarded payload mismatch")
+	}
+
+	if (&Frame{Type: FrameStreamData}).Raw() != nil {
+		t.Error("Raw() should be nil for locally built frames")
+	}
+	if err := NewFrameWriter(out).WriteRaw([]byte{1, 2}); err == nil {
+		t.Error("WriteRaw() should reject a truncated frame")
+	}
+}
+
 func TestFrameWriter_WriteFrame(t *testing.T) {
 	buf := new(bytes.Buffer)
 	writer := NewFrameWriter(buf)
@@ -687,6 +843,59 @@ func BenchmarkFrame_Decode(b *testing.B) {
 	}
 }
 
+// loopReader returns the same encoded frame forever.
+type loopReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *loopReader) Read(p []byte) (int, error) {
+	n := copy(p, r.data[r.pos:])
+	r.pos = (r.pos + n) % len(r.data)
+	return n, nil
+}
+
+// benchmarkRelayForward measures a transit hop: read STREAM_DATA from one
+// connection and write it to the next under a different stream ID.
+func benchmarkRelayForward(b *testing.B, raw bool) {
+	f := &Frame{Type: FrameStreamData, StreamID: 12345, Payload: make([]byte, 16*1024)}
+	data, _ := f.Encode()
+	reader := NewFrameReader(&loopReader{data: data})
+	writer := NewFrameWriter(io.Discard)
+
+	b.SetBytes(int64(len(f.Payload)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		in, err := reader.Read()
+		if err != nil {
+			b.Fatal(err)
+		}
+		if raw {
+			SetRawStreamID(in.Raw(), 67890)
+			err = writer.WriteRaw(in.Raw())
+		} else {
+			err = writer.Write(&Frame{
+				Type:     FrameStreamData,
+				StreamID: 67890,
+				Flags:    in.Flags,
+				Payload:  in.Payload,
+			})
+		}
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkRelayForward_Reencode(b *testing.B) {
+	benchmarkRelayForward(b, false)
+}
+
+func BenchmarkRelayForward_Raw(b *testing.B) {
+	benchmarkRelayForward(b, true)
+}
+
 func TestNodeInfoAdvertise_EncodeDecode(t *testing.T) {
 	origin, _ := identity.NewAgentID()
 	seen1, _ := identity.NewAgentID()
@@ -835,6 +1044,100 @@ func TestNodeInfoAdvertise_WithPeers(t *testing.T) {
 	}
 }
 
+func TestNodeInfo_PeerTrafficRates(t *testing.T) {
+	peer1, _ := identity.NewAgentID()
+	peer2, _ := identity.NewAgentID()
+
+	info := &NodeInfo{
+		DisplayName: "agent",
+		Peers: []PeerConnectionInfo{
+			{PeerID: peer1, Transport: "quic", TxBytesPerSec: 1500, RxBytesPerSec: 250000},
+			{PeerID: peer2, Transport: "h2"},
+		},
+	}
+
+	decoded, err := DecodeNodeInfo(EncodeNodeInfo(info))
+	if err != nil {
+		t.Fatalf("DecodeNodeInfo() error = %v", err)
+	}
+	if len(decoded.Peers) != 2 {
+		t.Fatalf("Peers length = %d, want 2", len(decoded.Peers))
+	}
+	if decoded.Peers[0].TxBytesPerSec != 1500 || decoded.Peers[0].RxBytesPerSec != 250000 {
+		t.Errorf("Peer[0] rates = %d/%d, want 1500/250000", decoded.Peers[0].TxBytesPerSec, decoded.Peers[0].RxBytesPerSec)
+	}
+	if decoded.Peers[1].TxBytesPerSec != 0 || decoded.Peers[1].RxBytesPerSec != 0 {
+		t.Errorf("Peer[1] rates = %d/%d, want 0/0", decoded.Peers[1].TxBytesPerSec, decoded.Peers[1].RxBytesPerSec)
+	}
+
+	// Older agents end the encoding after IcmpEnabled (rates and tag count)
+	data := EncodeNodeInfo(info)
+	old := data[:len(data)-1-2*16-1]
+	decoded, err = DecodeNodeInfo(old)
+	if err != nil {
+		t.Fatalf("DecodeNodeInfo(old format) error = %v", err)
+	}
+	if len(decoded.Peers) != 2 || decoded.Peers[0].TxBytesPerSec != 0 {
+		t.Errorf("old format peers = %+v, want 2 peers without rates", decoded.Peers)
+	}
+}
+
+func TestNodeInfo_Tags(t *testing.T) {
+	info := &NodeInfo{
+		DisplayName: "exit",
+		Tags:        []string{"exit:residential", "exit:dc-eu"},
+	}
+
+	data := EncodeNodeInfo(info)
+	decoded, err := DecodeNodeInfo(data)
+	if err != nil {
+		t.Fatalf("DecodeNodeInfo() error = %v", err)
+	}
+	if len(decoded.Tags) != 2 || decoded.Tags[0] != "exit:residential" || decoded.Tags[1] != "exit:dc-eu" {
+		t.Errorf("Tags = %v, want [exit:residential exit:dc-eu]", decoded.Tags)
+	}
+
+	// Older agents end the encoding before the tags
+	old := data[:len(data)-1-1-len("exit:residential")-1-len("exit:dc-eu")-1]
+	decoded, err = DecodeNodeInfo(old)
+	if err != nil {
+		t.Fatalf("DecodeNodeInfo(old format) error = %v", err)
+	}
+	if decoded.Tags != nil {
+		t.Errorf("old format Tags = %v, want none", decoded.Tags)
+	}
+}
+
+func TestNodeInfo_AgentTags(t *testing.T) {
+	info := &NodeInfo{
+		DisplayName: "web-1",
+		Tags:        []string{"exit:dc-eu"},
+		AgentTags:   []string{"env:prod", "role:web"},
+	}
+
+	data := EncodeNodeInfo(info)
+	decoded, err := DecodeNodeInfo(data)
+	if err != nil {
+		t.Fatalf("DecodeNodeInfo() error = %v", err)
+	}
+	if !reflect.DeepEqual(decoded.AgentTags, info.AgentTags) {
+		t.Errorf("AgentTags = %v, want %v", decoded.AgentTags, info.AgentTags)
+	}
+	if !reflect.DeepEqual(decoded.Tags, info.Tags) {
+		t.Errorf("Tags = %v, want %v", decoded.Tags, info.Tags)
+	}
+
+	// Older agents end the encoding after the exit tags
+	old := data[:len(data)-1-len("env:prod")-1-len("role:web")-1]
+	decoded, err = DecodeNodeInfo(old)
+	if err != nil {
+		t.Fatalf("DecodeNodeInfo(old format) error = %v", err)
+	}
+	if decoded.AgentTags != nil || len(decoded.Tags) != 1 {
+		t.Errorf("old format AgentTags = %v, Tags = %v, want none and [exit:dc-eu]", decoded.AgentTags, decoded.Tags)
+	}
+}
+
 func TestNodeInfoAdvertise_BackwardCompatibility(t *testing.T) {
 	// Simulate old-format NodeInfo (without peers) by encoding without peers
 	// then decoding - should work and have empty peers slice
@@ -959,6 +1262,281 @@ func TestStreamOpen_WithEphemeralKey(t *testing.T) {
 	}
 }
 
+func TestStreamOpen_ClientAddr(t *testing.T) {
+	original := &StreamOpen{
+		RequestID:   1,
+		AddressType: AddrTypeIPv4,
+		Address:     []byte{10, 0, 0, 1},
+		Port:        5432,
+		ClientAddr:  []byte{203, 0, 113, 7},
+		ClientPort:  51000,
+	}
+
+	decoded, err := DecodeStreamOpen(original.Encode())
+	if err != nil {
+		t.Fatalf("DecodeStreamOpen() error = %v", err)
+	}
+	if !bytes.Equal(decoded.ClientAddr, original.ClientAddr) || decoded.ClientPort != original.ClientPort {
+		t.Errorf("client = %v:%d, want %v:%d", decoded.ClientAddr, decoded.ClientPort, original.ClientAddr, original.ClientPort)
+	}
+
+	// Without a client address the encoding is unchanged, so older agents
+	// decode it
+	original.ClientAddr = nil
+	data := original.Encode()
+	decoded, err = DecodeStreamOpen(data)
+	if err != nil {
+		t.Fatalf("DecodeStreamOpen() error = %v", err)
+	}
+	if decoded.ClientAddr != nil {
+		t.Errorf("ClientAddr = %v, want nil", decoded.ClientAddr)
+	}
+
+	if _, err := DecodeStreamOpen(append(data, 0, 0, 5, 1, 2, 3, 4, 5, 0, 80)); err == nil {
+		t.Error("DecodeStreamOpen() accepted a 5-byte client address")
+	}
+
+	// A user without an address, and both together
+	original.ClientUser = "alice"
+	decoded, err = DecodeStreamOpen(original.Encode())
+	if err != nil {
+		t.Fatalf("DecodeStreamOpen() error = %v", err)
+	}
+	if decoded.ClientAddr != nil || decoded.ClientUser != "alice" {
+		t.Errorf("client = %v %q, want no address and alice", decoded.ClientAddr, decoded.ClientUser)
+	}
+	original.ClientAddr = []byte{0x20, 0x01, 0x0d, 0xb8, 15: 7}
+	decoded, err = DecodeStreamOpen(original.Encode())
+	if err != nil {
+		t.Fatalf("DecodeStreamOpen() error = %v", err)
+	}
+	if !bytes.Equal(decoded.ClientAddr, original.ClientAddr) || decoded.ClientPort != 51000 || decoded.ClientUser != "alice" {
+		t.Errorf("client = %v:%d %q, want %v:51000 alice", decoded.ClientAddr, decoded.ClientPort, decoded.ClientUser, original.ClientAddr)
+	}
+}
+
+func TestStreamOpen_E2EFlags(t *testing.T) {
+	base := StreamOpen{
+		RequestID:   1,
+		AddressType: AddrTypeIPv4,
+		Address:     []byte{10, 0, 0, 1},
+		Port:        443,
+	}
+	plain := base.Encode()
+
+	tests := []struct {
+		name string
+		addr []byte
+		user string
+	}{
+		{"flags only", nil, ""},
+		{"with address", []byte{203, 0, 113, 7}, ""},
+		{"with user", nil, "alice"},
+		{"with both", []byte{203, 0, 113, 7}, "alice"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			open := base
+			open.ClientAddr, open.ClientPort, open.ClientUser = tt.addr, 51000, tt.user
+			open.E2EFlags = E2EFlagRekey
+			decoded, err := DecodeStreamOpen(open.Encode())
+			if err != nil {
+				t.Fatalf("DecodeStreamOpen() error = %v", err)
+			}
+			if decoded.E2EFlags != E2EFlagRekey {
+				t.Errorf("E2EFlags = %#x, want %#x", decoded.E2EFlags, E2EFlagRekey)
+			}
+			if !bytes.Equal(decoded.ClientAddr, tt.addr) || decoded.ClientUser != tt.user {
+				t.Errorf("client = %v %q, want %v %q", decoded.ClientAddr, decoded.ClientUser, tt.addr, tt.user)
+			}
+		})
+	}
+
+	// Without flags the encoding is unchanged
+	decoded, err := DecodeStreamOpen(plain)
+	if err != nil {
+		t.Fatalf("DecodeStreamOpen() error = %v", err)
+	}
+	if decoded.E2EFlags != 0 {
+		t.Errorf("E2EFlags = %#x, want 0", decoded.E2EFlags)
+	}
+}
+
+func TestStreamOpen_Namespace(t *testing.T) {
+	tests := []struct {
+		name  string
+		flags uint8
+		user  string
+	}{
+		{"namespace only", 0, ""},
+		{"with flags", E2EFlagRekey, ""},
+		{"with user", 0, "alice"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			open := StreamOpen{
+				RequestID:   1,
+				AddressType: AddrTypeIPv4,
+				Address:     []byte{10, 0, 0, 1},
+				Port:        443,
+				ClientUser:  tt.user,
+				E2EFlags:    tt.flags,
+				Namespace:   "acme",
+			}
+			decoded, err := DecodeStreamOpen(open.Encode())
+			if err != nil {
+				t.Fatalf("DecodeStreamOpen() error = %v", err)
+			}
+			if decoded.Namespace != "acme" {
+				t.Errorf("Namespace = %q, want %q", decoded.Namespace, "acme")
+			}
+			if decoded.E2EFlags != tt.flags || decoded.ClientUser != tt.user {
+				t.Errorf("E2EFlags, ClientUser = %#x, %q, want %#x, %q", decoded.E2EFlags, decoded.ClientUser, tt.flags, tt.user)
+			}
+		})
+	}
+}
+
+func TestOpenChain(t *testing.T) {
+	chain := NewOpenChain()
+	token := chain.Token()
+	if token.IsZero() || token == NewOpenChain().Token() {
+		t.Fatal("NewOpenChain() should return distinct non-zero tokens")
+	}
+
+	var proofs []OpenProof
+	for i := 1; i <= MaxOpenAttempts+1; i++ {
+		got, proof := chain.Next()
+		if got != token {
+			t.Fatalf("attempt %d token = %s, want %s", i, got, token)
+		}
+		if want := min(i, MaxOpenAttempts); int(proof.Attempt) != want {
+			t.Errorf("attempt %d proof.Attempt = %d, want %d", i, proof.Attempt, want)
+		}
+		if !token.Verify(proof) {
+			t.Errorf("attempt %d proof does not verify", i)
+		}
+		proofs = append(proofs, proof)
+	}
+
+	// A copied proof only verifies for the attempt it was made for
+	relabelled := proofs[0]
+	relabelled.Attempt = 2
+	if token.Verify(relabelled) {
+		t.Error("proof of attempt 1 should not verify as attempt 2")
+	}
+	if NewOpenChain().Token().Verify(proofs[0]) {
+		t.Error("proof should not verify for another token")
+	}
+	if token.Verify(OpenProof{}) || (OpenToken{}).Verify(proofs[0]) {
+		t.Error("zero proof or token should not verify")
+	}
+	over := proofs[0]
+	over.Attempt = MaxOpenAttempts + 1
+	if token.Verify(over) {
+		t.Error("attempt past MaxOpenAttempts should not verify")
+	}
+}
+
+func TestStreamOpen_OpenToken(t *testing.T) {
+	token, proof := NewOpenChain().Next()
+
+	tests := []struct {
+		name      string
+		namespace string
+		user      string
+	}{
+		{"token only", "", ""},
+		{"with namespace", "acme", ""},
+		{"with user", "", "alice"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			open := StreamOpen{
+				RequestID:   1,
+				AddressType: AddrTypeIPv4,
+				Address:     []byte{10, 0, 0, 1},
+				Port:        443,
+				ClientUser:  tt.user,
+				Namespace:   tt.namespace,
+				OpenToken:   token,
+				OpenProof:   proof,
+			}
+			decoded, err := DecodeStreamOpen(open.Encode())
+			if err != nil {
+				t.Fatalf("DecodeStreamOpen() error = %v", err)
+			}
+			if decoded.OpenToken != token || decoded.OpenProof != proof {
+				t.Errorf("OpenToken, OpenProof = %s, %+v, want %s, %+v", decoded.OpenToken, decoded.OpenProof, token, proof)
+			}
+			if decoded.Namespace != tt.namespace || decoded.ClientUser != tt.user || decoded.E2EFlags != 0 {
+				t.Errorf("Namespace, ClientUser, E2EFlags = %q, %q, %#x, want %q, %q, 0", decoded.Namespace, decoded.ClientUser, decoded.E2EFlags, tt.namespace, tt.user)
+			}
+		})
+	}
+
+	// Without a token the encoding is unchanged, and a truncated token is
+	// rejected
+	plain := StreamOpen{RequestID: 1, AddressType: AddrTypeIPv4, Address: []byte{10, 0, 0, 1}, Port: 443}
+	if n := len(plain.Encode()); n != 8+1+4+2+1+1+EphemeralKeySize {
+		t.Errorf("StreamOpen without options is %d bytes", n)
+	}
+	if decoded, err := DecodeStreamOpen(plain.Encode()); err != nil || !decoded.OpenToken.IsZero() {
+		t.Errorf("DecodeStreamOpen() = %v, %v, want no token", decoded, err)
+	}
+	withToken := StreamOpen{RequestID: 1, AddressType: AddrTypeIPv4, Address: []byte{10, 0, 0, 1}, Port: 443, OpenToken: token, OpenProof: proof}
+	buf := withToken.Encode()
+	if _, err := DecodeStreamOpen(buf[:len(buf)-1]); err == nil {
+		t.Error("DecodeStreamOpen() should reject a truncated proof")
+	}
+	if _, err := DecodeStreamOpen(buf[:len(buf)-1-OpenTokenSize-1]); err == nil {
+		t.Error("DecodeStreamOpen() should reject a truncated token")
+	}
+}
+
+func TestOpenAck_E2EFlags(t *testing.T) {
+	streamAck := &StreamOpenAck{RequestID: 1, BoundAddrType: AddrTypeIPv4, BoundAddr: []byte{10, 0, 0, 1}}
+	udpOpen := &UDPOpen{RequestID: 2, AddressType: AddrTypeIPv4, Address: []byte{0, 0, 0, 0}}
+	udpAck := &UDPOpenAck{RequestID: 3, BoundAddrType: AddrTypeIPv4, BoundAddr: []byte{10, 0, 0, 1}}
+	icmpOpen := &ICMPOpen{RequestID: 4, DestIP: []byte{8, 8, 8, 8}}
+	icmpAck := &ICMPOpenAck{RequestID: 5}
+
+	decode := func(t *testing.T) []uint8 {
+		t.Helper()
+		sa, err1 := DecodeStreamOpenAck(streamAck.Encode())
+		uo, err2 := DecodeUDPOpen(udpOpen.Encode())
+		ua, err3 := DecodeUDPOpenAck(udpAck.Encode())
+		io, err4 := DecodeICMPOpen(icmpOpen.Encode())
+		ia, err5 := DecodeICMPOpenAck(icmpAck.Encode())
+		for _, err := range []error{err1, err2, err3, err4, err5} {
+			if err != nil {
+				t.Fatalf("decode error = %v", err)
+			}
+		}
+		return []uint8{sa.E2EFlags, uo.E2EFlags, ua.E2EFlags, io.E2EFlags, ia.E2EFlags}
+	}
+
+	for i, f := range decode(t) {
+		if f != 0 {
+			t.Errorf("frame %d E2EFlags = %#x, want 0", i, f)
+		}
+	}
+	if n := len(icmpAck.Encode()); n != 8+EphemeralKeySize {
+		t.Errorf("ICMPOpenAck without flags is %d bytes, want %d", n, 8+EphemeralKeySize)
+	}
+
+	streamAck.E2EFlags = E2EFlagRekey
+	udpOpen.E2EFlags = E2EFlagRekey
+	udpAck.E2EFlags = E2EFlagRekey
+	icmpOpen.E2EFlags = E2EFlagRekey
+	icmpAck.E2EFlags = E2EFlagRekey
+	for i, f := range decode(t) {
+		if f != E2EFlagRekey {
+			t.Errorf("frame %d E2EFlags = %#x, want %#x", i, f, E2EFlagRekey)
+		}
+	}
+}
+
 func TestStreamOpen_IPv6WithEphemeralKey(t *testing.T) {
 	// IPv6 address: ::1
 	ipv6Addr := []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1}
@@ -1057,6 +1635,42 @@ func TestStreamOpenAck_IPv6WithEphemeralKey(t *testing.T) {
 	}
 }
 
+func TestStreamOpen_MaxPayload(t *testing.T) {
+	open := StreamOpen{
+		RequestID:   1,
+		AddressType: AddrTypeIPv4,
+		Address:     []byte{10, 0, 0, 1},
+		Port:        443,
+		MaxPayload:  4096,
+	}
+	decoded, err := DecodeStreamOpen(open.Encode())
+	if err != nil {
+		t.Fatalf("DecodeStreamOpen() error = %v", err)
+	}
+	if decoded.MaxPayload != 4096 {
+		t.Errorf("MaxPayload = %d, want 4096", decoded.MaxPayload)
+	}
+
+	ack := StreamOpenAck{RequestID: 1, BoundAddrType: AddrTypeIPv4, BoundAddr: []byte{10, 0, 0, 1}, MaxPayload: 2048}
+	decodedAck, err := DecodeStreamOpenAck(ack.Encode())
+	if err != nil {
+		t.Fatalf("DecodeStreamOpenAck() error = %v", err)
+	}
+	if decodedAck.MaxPayload != 2048 {
+		t.Errorf("ACK MaxPayload = %d, want 2048", decodedAck.MaxPayload)
+	}
+
+	// Opens and ACKs from agents without the field decode with no limit
+	plain := StreamOpen{RequestID: 1, AddressType: AddrTypeIPv4, Address: []byte{10, 0, 0, 1}, Port: 443}
+	if decoded, err := DecodeStreamOpen(plain.Encode()); err != nil || decoded.MaxPayload != 0 {
+		t.Errorf("DecodeStreamOpen() = %v, %v, want no limit", decoded, err)
+	}
+	plainAck := StreamOpenAck{RequestID: 1, BoundAddrType: AddrTypeIPv4, BoundAddr: []byte{10, 0, 0, 1}}
+	if decoded, err := DecodeStreamOpenAck(plainAck.Encode()); err != nil || decoded.MaxPayload != 0 {
+		t.Errorf("DecodeStreamOpenAck() = %v, %v, want no limit", decoded, err)
+	}
+}
+
 func TestDecodeStreamOpen_MissingEphemeralKey(t *testing.T) {
 	// Create a truncated StreamOpen without ephemeral key
 	// This simulates a malformed frame
@@ -1144,6 +1758,39 @@ func TestControlRequest_EmptyPathAndData(t *testing.T) {
 	}
 }
 
+func TestControlRequest_Role(t *testing.T) {
+	target, _ := identity.NewAgentID()
+
+	original := &ControlRequest{
+		RequestID:   7,
+		ControlType: ControlTypeRouteManage,
+		TargetAgent: target,
+		Data:        []byte(`{"action":"list"}`),
+		Role:        2,
+	}
+
+	data := original.Encode()
+	decoded, err := DecodeControlRequest(data)
+	if err != nil {
+		t.Fatalf("DecodeControlRequest() error = %v", err)
+	}
+	if decoded.Role != original.Role {
+		t.Errorf("Role = %d, want %d", decoded.Role, original.Role)
+	}
+
+	// Requests from older agents end after the data and carry no role
+	legacy, err := DecodeControlRequest(data[:len(data)-1])
+	if err != nil {
+		t.Fatalf("DecodeControlRequest() legacy error = %v", err)
+	}
+	if legacy.Role != 0 {
+		t.Errorf("legacy Role = %d, want 0", legacy.Role)
+	}
+	if !bytes.Equal(legacy.Data, original.Data) {
+		t.Errorf("legacy Data mismatch")
+	}
+}
+
 func TestControlRequest_LargeData(t *testing.T) {
 	target, _ := identity.NewAgentID()
 
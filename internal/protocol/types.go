@@ -22,6 +22,9 @@ const (
 	FrameKeepalive    uint8 = 0x22 // Liveness probe
 	FrameKeepaliveAck uint8 = 0x23 // Liveness response
 
+	// Handshake refusal, sent by the listener instead of PEER_HELLO_ACK
+	FramePeerHelloReject uint8 = 0x26
+
 	// Mesh control frames (for remote status queries)
 	FrameControlRequest  uint8 = 0x24 // Request status from remote agent
 	FrameControlResponse uint8 = 0x25 // Response with status data
@@ -58,12 +61,36 @@ const (
 	ControlTypeForwardManage uint8 = 0x09 // Dynamic forward listener management (add/remove/list)
 	ControlTypeFileBrowse          uint8 = 0x0A // File browsing (directory listing, stat, roots)
 	ControlTypeDisplayNameManage   uint8 = 0x0B // Dynamic display name management
+	ControlTypeUDPStats            uint8 = 0x0C // UDP association statistics
+	ControlTypeForwardEndpointManage uint8 = 0x0D // Dynamic forward endpoint management (add/remove/list)
+	ControlTypeDNSCacheManage        uint8 = 0x0E // Exit DNS cache statistics and flush
+	ControlTypeExitDestManage        uint8 = 0x0F // Exit per-destination statistics and unblock
+	ControlTypePathProbe             uint8 = 0x10 // End-to-end path liveness probe (empty reply)
+	ControlTypeScheduleManage        uint8 = 0x11 // Scheduled task management (add/remove/list/history/run)
+	ControlTypeUpdateManage          uint8 = 0x12 // Agent binary update (status/apply)
+	ControlTypeStreams               uint8 = 0x13 // Active stream table (read-only)
+	ControlTypeIdleManage            uint8 = 0x14 // Idle stream and UDP association list and forced close
+	ControlTypeRendezvous            uint8 = 0x15 // NAT traversal: observed endpoint and hole punch exchange
+	ControlTypeChaosManage           uint8 = 0x16 // Peer link fault injection (status/set/partition/heal)
+	ControlTypeSOCKS5UsersManage     uint8 = 0x17 // SOCKS5 user expiry and quota usage (list/reset)
+	ControlTypeBlocklistManage       uint8 = 0x18 // SOCKS5 destination blocklist (status/check/refresh)
+	ControlTypeUsage                 uint8 = 0x19 // Bandwidth usage per peer, user and destination (read-only)
+	ControlTypeCrashManage           uint8 = 0x1A // Crash reports (list/get/clear)
+	ControlTypeRouteDampening        uint8 = 0x1B // Suppressed route origins and rate limited peers (read-only)
+	ControlTypeSystemMetrics         uint8 = 0x1C // Live CPU, memory, disk, interface and file descriptor usage (read-only)
+	ControlTypeDebugCapture          uint8 = 0x1D // TLS key log and QUIC qlog capture of peer connections (status/start/stop)
+	ControlTypeManagementKey         uint8 = 0x1E // Management key rotation (status/stage/activate/abort)
+	ControlTypeRouteLookup           uint8 = 0x1F // Route a destination would take, without connecting (read-only)
 )
 
 // Frame flags
 const (
 	FlagFinWrite uint8 = 0x01 // Sender done writing
 	FlagFinRead  uint8 = 0x02 // Sender done reading
+
+	// Bits 2-3 carry the traffic class of stream frames (see TrafficClass)
+	FlagClassMask  uint8 = 0x0C
+	flagClassShift       = 2
 )
 
 // Address type constants
@@ -107,12 +134,17 @@ const (
 	ErrShellAuthFailed    uint16 = 21 // Shell authentication failed
 	ErrPTYFailed          uint16 = 22 // PTY allocation failed
 	ErrCommandNotAllowed  uint16 = 23 // Command not in whitelist
+	ErrChecksumMismatch   uint16 = 24 // Transferred file did not match its SHA-256
+	ErrPrivateDestination uint16 = 25 // Destination in a private range refused by exit.block_private
+	ErrStreamSuperseded   uint16 = 26 // Stream open replaced by a retry carrying the same open token, or refused for a token held by a later attempt
 	ErrUDPDisabled        uint16 = 30 // UDP relay is disabled
 	ErrUDPPortNotAllowed  uint16 = 31 // UDP port not in whitelist
 	ErrForwardNotFound    uint16 = 40 // Port forward routing key not configured
 	ErrICMPDisabled       uint16 = 50 // ICMP echo is disabled
 	ErrICMPDestNotAllowed uint16 = 51 // ICMP destination not in allowed CIDRs
 	ErrICMPSessionLimit   uint16 = 52 // Maximum ICMP sessions reached
+	ErrE2EValidation      uint16 = 60 // E2E frame replayed, out of sequence or failed authentication
+	ErrAgentNotAllowed    uint16 = 70 // Agent not in the listener's allowed_agents (PEER_HELLO_REJECT)
 )
 
 // Protocol constants
@@ -126,6 +158,9 @@ const (
 	// MaxPayloadSize is the maximum frame payload size (16 KB)
 	MaxPayloadSize = 16384
 
+	// MinPayloadSize is the smallest payload limit a link can negotiate
+	MinPayloadSize = 1024
+
 	// MaxFrameSize is the maximum total frame size
 	MaxFrameSize = HeaderSize + MaxPayloadSize
 
@@ -172,9 +207,10 @@ const (
 
 // ICMP close reasons
 const (
-	ICMPCloseNormal  uint8 = 0 // Normal close
-	ICMPCloseTimeout uint8 = 1 // Idle timeout
-	ICMPCloseError   uint8 = 2 // Error occurred
+	ICMPCloseNormal        uint8 = 0 // Normal close
+	ICMPCloseTimeout       uint8 = 1 // Idle timeout
+	ICMPCloseError         uint8 = 2 // Error occurred
+	ICMPCloseE2EValidation uint8 = 3 // Echo replayed, outside the replay window or failed authentication
 )
 
 // FrameTypeName returns a human-readable name for a frame type.
@@ -202,6 +238,8 @@ func FrameTypeName(t uint8) string {
 		return "PEER_HELLO"
 	case FramePeerHelloAck:
 		return "PEER_HELLO_ACK"
+	case FramePeerHelloReject:
+		return "PEER_HELLO_REJECT"
 	case FrameKeepalive:
 		return "KEEPALIVE"
 	case FrameKeepaliveAck:
@@ -290,6 +328,12 @@ func ErrorCodeName(code uint16) string {
 		return "PTY_FAILED"
 	case ErrCommandNotAllowed:
 		return "COMMAND_NOT_ALLOWED"
+	case ErrChecksumMismatch:
+		return "CHECKSUM_MISMATCH"
+	case ErrPrivateDestination:
+		return "PRIVATE_DESTINATION"
+	case ErrStreamSuperseded:
+		return "STREAM_SUPERSEDED"
 	case ErrUDPDisabled:
 		return "UDP_DISABLED"
 	case ErrUDPPortNotAllowed:
@@ -302,6 +346,10 @@ func ErrorCodeName(code uint16) string {
 		return "ICMP_DEST_NOT_ALLOWED"
 	case ErrICMPSessionLimit:
 		return "ICMP_SESSION_LIMIT"
+	case ErrE2EValidation:
+		return "E2E_VALIDATION_FAILED"
+	case ErrAgentNotAllowed:
+		return "AGENT_NOT_ALLOWED"
 	default:
 		return "UNKNOWN"
 	}
@@ -319,7 +367,7 @@ func IsRoutingFrame(t uint8) bool {
 
 // IsControlFrame returns true if the frame type is a control frame.
 func IsControlFrame(t uint8) bool {
-	return t >= FramePeerHello && t <= FrameControlResponse
+	return t >= FramePeerHello && t <= FramePeerHelloReject
 }
 
 // IsUDPFrame returns true if the frame type is a UDP-related frame.
@@ -0,0 +1,69 @@
+// Package proxyproto writes PROXY protocol version 2 headers, which tell a
+// server behind the mesh the address of the client that connected at the
+// ingress, and carries that address through dial contexts.
+package proxyproto
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+)
+
+// signature starts every version 2 header.
+var signature = []byte{0x0D, 0x0A, 0x0D, 0x0A, 0x00, 0x0D, 0x0A, 0x51, 0x55, 0x49, 0x54, 0x0A}
+
+const (
+	cmdLocal = 0x20 // Version 2, LOCAL: no address information
+	cmdProxy = 0x21 // Version 2, PROXY: relayed connection
+
+	famUnspec  = 0x00
+	famTCPIPv4 = 0x11
+	famTCPIPv6 = 0x21
+)
+
+// Header returns a version 2 header for a TCP connection from src to dst.
+// Without a client address (src nil) a LOCAL header is returned, which
+// servers take as a connection without a known client. An IPv4 and an
+// IPv6 address are both sent as IPv6.
+func Header(src, dst *net.TCPAddr) []byte {
+	if src == nil || dst == nil || src.IP == nil || dst.IP == nil {
+		return append(append([]byte{}, signature...), cmdLocal, famUnspec, 0, 0)
+	}
+
+	srcIP, dstIP := src.IP.To4(), dst.IP.To4()
+	fam := byte(famTCPIPv4)
+	if srcIP == nil || dstIP == nil {
+		srcIP, dstIP = src.IP.To16(), dst.IP.To16()
+		fam = famTCPIPv6
+	}
+
+	buf := make([]byte, 0, len(signature)+4+2*len(srcIP)+4)
+	buf = append(buf, signature...)
+	buf = append(buf, cmdProxy, fam)
+	buf = binary.BigEndian.AppendUint16(buf, uint16(2*len(srcIP)+4))
+	buf = append(buf, srcIP...)
+	buf = append(buf, dstIP...)
+	buf = binary.BigEndian.AppendUint16(buf, uint16(src.Port))
+	buf = binary.BigEndian.AppendUint16(buf, uint16(dst.Port))
+	return buf
+}
+
+type clientAddrKey struct{}
+
+// WithClientAddr returns a context carrying the address of the client
+// whose connection is being dialed through the mesh. Addresses other than
+// TCP addresses (e.g. UNIX sockets) are ignored.
+func WithClientAddr(ctx context.Context, addr net.Addr) context.Context {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok || tcpAddr.IP == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, clientAddrKey{}, tcpAddr)
+}
+
+// ClientAddrFromContext returns the client address stored with
+// WithClientAddr, or nil.
+func ClientAddrFromContext(ctx context.Context) *net.TCPAddr {
+	addr, _ := ctx.Value(clientAddrKey{}).(*net.TCPAddr)
+	return addr
+}
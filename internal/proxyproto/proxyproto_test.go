@@ -0,0 +1,72 @@
+package proxyproto
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+)
+
+func TestHeader(t *testing.T) {
+	tests := []struct {
+		name string
+		src  *net.TCPAddr
+		dst  *net.TCPAddr
+		want []byte
+	}{
+		{
+			name: "ipv4",
+			src:  &net.TCPAddr{IP: net.IPv4(203, 0, 113, 7), Port: 51000},
+			dst:  &net.TCPAddr{IP: net.IPv4(10, 0, 0, 5), Port: 5432},
+			want: []byte{0x21, 0x11, 0, 12, 203, 0, 113, 7, 10, 0, 0, 5, 0xC7, 0x38, 0x15, 0x38},
+		},
+		{
+			name: "local without client",
+			dst:  &net.TCPAddr{IP: net.IPv4(10, 0, 0, 5), Port: 5432},
+			want: []byte{0x20, 0x00, 0, 0},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Header(tt.src, tt.dst)
+			if !bytes.HasPrefix(got, signature) {
+				t.Fatalf("header %x lacks the v2 signature", got)
+			}
+			if !bytes.Equal(got[len(signature):], tt.want) {
+				t.Errorf("Header() = %x, want %x", got[len(signature):], tt.want)
+			}
+		})
+	}
+}
+
+func TestHeader_MixedFamilies(t *testing.T) {
+	got := Header(
+		&net.TCPAddr{IP: net.ParseIP("2001:db8::7"), Port: 443},
+		&net.TCPAddr{IP: net.IPv4(10, 0, 0, 5), Port: 8443},
+	)
+	body := got[len(signature):]
+	if body[1] != famTCPIPv6 || len(body) != 4+36 {
+		t.Fatalf("family = %#x, length = %d; want IPv6 with 36 address bytes", body[1], len(body)-4)
+	}
+	if dst := net.IP(body[20:36]); !dst.Equal(net.IPv4(10, 0, 0, 5)) {
+		t.Errorf("destination = %v, want IPv4-mapped 10.0.0.5", dst)
+	}
+}
+
+func TestClientAddrContext(t *testing.T) {
+	ctx := context.Background()
+	if ClientAddrFromContext(ctx) != nil {
+		t.Error("empty context has a client address")
+	}
+
+	unix := WithClientAddr(ctx, &net.UnixAddr{Name: "/run/socks.sock", Net: "unix"})
+	if ClientAddrFromContext(unix) != nil {
+		t.Error("UNIX socket client stored as client address")
+	}
+
+	addr := &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 40000}
+	if got := ClientAddrFromContext(WithClientAddr(ctx, addr)); got != addr {
+		t.Errorf("ClientAddrFromContext() = %v, want %v", got, addr)
+	}
+}
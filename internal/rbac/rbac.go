@@ -0,0 +1,157 @@
+// Package rbac defines the roles that gate the HTTP management API and
+// control requests relayed over the mesh.
+//
+// Roles are ordered: a role may do everything the roles below it may do.
+//
+//	viewer    status, peers, routes and read-only management actions
+//	operator  file transfer, port forwards, routes and exit maintenance
+//	admin     shell, scheduled tasks, updates and agent configuration
+package rbac
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/postalsys/muti-metroo/internal/protocol"
+)
+
+// Role is an access level. The zero value RoleNone means no role was
+// stated; how it is treated is up to the caller.
+type Role uint8
+
+const (
+	RoleNone     Role = 0
+	RoleViewer   Role = 1
+	RoleOperator Role = 2
+	RoleAdmin    Role = 3
+)
+
+// String returns the configuration name of the role.
+func (r Role) String() string {
+	switch r {
+	case RoleViewer:
+		return "viewer"
+	case RoleOperator:
+		return "operator"
+	case RoleAdmin:
+		return "admin"
+	default:
+		return "none"
+	}
+}
+
+// Allows reports whether r may perform an action that requires role
+// required.
+func (r Role) Allows(required Role) bool {
+	return r >= required
+}
+
+// ParseRole parses a role name (viewer, operator or admin).
+func ParseRole(s string) (Role, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "viewer":
+		return RoleViewer, nil
+	case "operator":
+		return RoleOperator, nil
+	case "admin":
+		return RoleAdmin, nil
+	default:
+		return RoleNone, fmt.Errorf("unknown role %q (expected viewer, operator or admin)", s)
+	}
+}
+
+type contextKey struct{}
+
+// WithRole returns a context carrying the role of the caller.
+func WithRole(ctx context.Context, role Role) context.Context {
+	return context.WithValue(ctx, contextKey{}, role)
+}
+
+// FromContext returns the role carried by ctx, if any.
+func FromContext(ctx context.Context) (Role, bool) {
+	role, ok := ctx.Value(contextKey{}).(Role)
+	return role, ok
+}
+
+// controlRoles is the role required to change state through each control
+// type. Control types not listed require RoleAdmin.
+var controlRoles = map[uint8]Role{
+	protocol.ControlTypeStatus:                RoleViewer,
+	protocol.ControlTypePeers:                 RoleViewer,
+	protocol.ControlTypeRoutes:                RoleViewer,
+	protocol.ControlTypeUDPStats:              RoleViewer,
+	protocol.ControlTypePathProbe:             RoleViewer,
+	protocol.ControlTypeStreams:               RoleViewer,
+	protocol.ControlTypeRendezvous:            RoleViewer,
+	protocol.ControlTypeUsage:                 RoleViewer,
+	protocol.ControlTypeRouteDampening:        RoleViewer,
+	protocol.ControlTypeSystemMetrics:         RoleViewer,
+	protocol.ControlTypeRouteLookup:           RoleViewer,
+	protocol.ControlTypeFileBrowse:            RoleOperator,
+	protocol.ControlTypeRouteManage:           RoleOperator,
+	protocol.ControlTypeForwardManage:         RoleOperator,
+	protocol.ControlTypeForwardEndpointManage: RoleOperator,
+	protocol.ControlTypeDNSCacheManage:        RoleOperator,
+	protocol.ControlTypeExitDestManage:        RoleOperator,
+	protocol.ControlTypeIdleManage:            RoleOperator,
+	protocol.ControlTypeSOCKS5UsersManage:     RoleOperator,
+	protocol.ControlTypeBlocklistManage:       RoleOperator,
+	protocol.ControlTypeCrashManage:           RoleOperator,
+	protocol.ControlTypeRPC:                   RoleAdmin,
+	protocol.ControlTypeDisplayNameManage:     RoleAdmin,
+	protocol.ControlTypeScheduleManage:        RoleAdmin,
+	protocol.ControlTypeUpdateManage:          RoleAdmin,
+	protocol.ControlTypeChaosManage:           RoleAdmin,
+	protocol.ControlTypeDebugCapture:          RoleAdmin,
+	protocol.ControlTypeManagementKey:         RoleAdmin,
+}
+
+// manageTypes are the JSON management control types. Their read-only
+// actions are open to viewers.
+var manageTypes = map[uint8]bool{
+	protocol.ControlTypeRouteManage:           true,
+	protocol.ControlTypeForwardManage:         true,
+	protocol.ControlTypeForwardEndpointManage: true,
+	protocol.ControlTypeDisplayNameManage:     true,
+	protocol.ControlTypeDNSCacheManage:        true,
+	protocol.ControlTypeExitDestManage:        true,
+	protocol.ControlTypeIdleManage:            true,
+	protocol.ControlTypeScheduleManage:        true,
+	protocol.ControlTypeUpdateManage:          true,
+	protocol.ControlTypeChaosManage:           true,
+	protocol.ControlTypeSOCKS5UsersManage:     true,
+	protocol.ControlTypeBlocklistManage:       true,
+	protocol.ControlTypeCrashManage:           true,
+	protocol.ControlTypeDebugCapture:          true,
+	protocol.ControlTypeManagementKey:         true,
+}
+
+// readOnlyActions are management actions that do not change state.
+var readOnlyActions = map[string]bool{
+	"list":    true,
+	"get":     true,
+	"stats":   true,
+	"top":     true,
+	"history": true,
+	"status":  true,
+	"check":   true,
+}
+
+// ControlRole returns the role required for a control request of the given
+// type with the given JSON payload.
+func ControlRole(controlType uint8, data []byte) Role {
+	if manageTypes[controlType] {
+		var req struct {
+			Action string `json:"action"`
+		}
+		if json.Unmarshal(data, &req) == nil && readOnlyActions[req.Action] {
+			return RoleViewer
+		}
+	}
+	if role, ok := controlRoles[controlType]; ok {
+		return role
+	}
+	return RoleAdmin
+}
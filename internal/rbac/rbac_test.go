@@ -0,0 +1,84 @@
+package rbac
+
+import (
+	"context"
+	"testing"
+
+	"github.com/postalsys/muti-metroo/internal/protocol"
+)
+
+func TestParseRole(t *testing.T) {
+	for _, name := range []string{"viewer", "operator", "admin"} {
+		role, err := ParseRole(name)
+		if err != nil {
+			t.Fatalf("ParseRole(%q) error: %v", name, err)
+		}
+		if role.String() != name {
+			t.Errorf("ParseRole(%q).String() = %q", name, role.String())
+		}
+	}
+	if _, err := ParseRole("root"); err == nil {
+		t.Error("expected error for unknown role")
+	}
+}
+
+func TestRole_Allows(t *testing.T) {
+	if !RoleAdmin.Allows(RoleOperator) || !RoleOperator.Allows(RoleOperator) {
+		t.Error("higher or equal role should be allowed")
+	}
+	if RoleViewer.Allows(RoleOperator) || RoleNone.Allows(RoleViewer) {
+		t.Error("lower role should not be allowed")
+	}
+}
+
+func TestContext(t *testing.T) {
+	if _, ok := FromContext(context.Background()); ok {
+		t.Error("empty context should carry no role")
+	}
+	role, ok := FromContext(WithRole(context.Background(), RoleOperator))
+	if !ok || role != RoleOperator {
+		t.Errorf("FromContext = %v, %v", role, ok)
+	}
+}
+
+func TestControlRole(t *testing.T) {
+	tests := []struct {
+		name        string
+		controlType uint8
+		data        string
+		want        Role
+	}{
+		{"status", protocol.ControlTypeStatus, "", RoleViewer},
+		{"route list", protocol.ControlTypeRouteManage, `{"action":"list"}`, RoleViewer},
+		{"route add", protocol.ControlTypeRouteManage, `{"action":"add","network":"10.0.0.0/8"}`, RoleOperator},
+		{"file browse list", protocol.ControlTypeFileBrowse, `{"action":"list"}`, RoleOperator},
+		{"rpc", protocol.ControlTypeRPC, `{"command":"id"}`, RoleAdmin},
+		{"schedule history", protocol.ControlTypeScheduleManage, `{"action":"history","id":"x"}`, RoleViewer},
+		{"schedule add", protocol.ControlTypeScheduleManage, `{"action":"add"}`, RoleAdmin},
+		{"update apply", protocol.ControlTypeUpdateManage, `{"action":"apply"}`, RoleAdmin},
+		{"idle list", protocol.ControlTypeIdleManage, `{"action":"list"}`, RoleViewer},
+		{"idle close", protocol.ControlTypeIdleManage, `{"action":"close","min_idle":"1h"}`, RoleOperator},
+		{"blocklist check", protocol.ControlTypeBlocklistManage, `{"action":"check","destination":"example.com"}`, RoleViewer},
+		{"blocklist refresh", protocol.ControlTypeBlocklistManage, `{"action":"refresh"}`, RoleOperator},
+		{"usage", protocol.ControlTypeUsage, "", RoleViewer},
+		{"crash list", protocol.ControlTypeCrashManage, `{"action":"list"}`, RoleViewer},
+		{"crash get", protocol.ControlTypeCrashManage, `{"action":"get","id":"20260102T030405Z-a1b2c3"}`, RoleViewer},
+		{"crash clear", protocol.ControlTypeCrashManage, `{"action":"clear"}`, RoleOperator},
+		{"route dampening", protocol.ControlTypeRouteDampening, "", RoleViewer},
+		{"route lookup", protocol.ControlTypeRouteLookup, `{"destination":"10.1.2.3"}`, RoleViewer},
+		{"system metrics", protocol.ControlTypeSystemMetrics, "", RoleViewer},
+		{"debug capture status", protocol.ControlTypeDebugCapture, `{"action":"status"}`, RoleViewer},
+		{"debug capture start", protocol.ControlTypeDebugCapture, `{"action":"start","duration":"10m"}`, RoleAdmin},
+		{"management key status", protocol.ControlTypeManagementKey, `{"action":"status"}`, RoleViewer},
+		{"management key stage", protocol.ControlTypeManagementKey, `{"action":"stage","public_key":"ab"}`, RoleAdmin},
+		{"bad json", protocol.ControlTypeDNSCacheManage, `{`, RoleOperator},
+		{"unknown type", 0x7F, "", RoleAdmin},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ControlRole(tt.controlType, []byte(tt.data)); got != tt.want {
+				t.Errorf("ControlRole = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -9,6 +9,7 @@ import (
 
 // RecoverWithLog recovers from panics and logs them with the provided logger.
 // Use this with defer at the start of goroutines to prevent crashes and log diagnostics.
+// The panic is also saved as a crash report by every started Store.
 //
 // Example:
 //
@@ -18,11 +19,12 @@ import (
 //	}()
 func RecoverWithLog(logger *slog.Logger, name string) {
 	if r := recover(); r != nil {
-		stack := string(debug.Stack())
+		stack := debug.Stack()
 		logger.Error("panic recovered",
 			"goroutine", name,
 			"panic", fmt.Sprintf("%v", r),
-			"stack", stack)
+			"stack", string(stack))
+		report(name, r, stack)
 	}
 }
 
@@ -30,11 +32,12 @@ func RecoverWithLog(logger *slog.Logger, name string) {
 // The callback can be used for cleanup or metrics reporting.
 func RecoverWithCallback(logger *slog.Logger, name string, callback func(recovered interface{})) {
 	if r := recover(); r != nil {
-		stack := string(debug.Stack())
+		stack := debug.Stack()
 		logger.Error("panic recovered",
 			"goroutine", name,
 			"panic", fmt.Sprintf("%v", r),
-			"stack", stack)
+			"stack", string(stack))
+		report(name, r, stack)
 		if callback != nil {
 			callback(r)
 		}
@@ -0,0 +1,399 @@
+package recovery
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Crash report defaults and limits.
+const (
+	// DirName is the crash report directory in the data directory.
+	DirName = "crashes"
+
+	// DefaultMaxReports is the number of reports kept when no limit is set.
+	DefaultMaxReports = 20
+
+	// fatalFile receives the runtime's output when the process dies.
+	fatalFile = "fatal.out"
+
+	// fatalHeader starts fatalFile and carries the metadata of the process
+	// that wrote it.
+	fatalHeader = "muti-metroo-crash-output "
+
+	// maxGoroutineDump bounds the all-goroutine dump of a report.
+	maxGoroutineDump = 1 << 20
+
+	// summaryPanicLen bounds the panic value shown in report summaries.
+	summaryPanicLen = 200
+)
+
+// ErrReportNotFound is returned for unknown crash report IDs.
+var ErrReportNotFound = errors.New("crash report not found")
+
+// Metadata identifies the build and configuration of the agent that crashed.
+type Metadata struct {
+	AgentID    string `json:"agent_id,omitempty"`
+	Version    string `json:"version"`
+	ConfigHash string `json:"config_hash,omitempty"`
+	GoVersion  string `json:"go_version"`
+	OS         string `json:"os"`
+	Arch       string `json:"arch"`
+}
+
+// Report is a crash report: a panic recovered in a goroutine, or a fatal
+// crash of the process collected on the next start.
+type Report struct {
+	ID        string    `json:"id"`
+	Time      time.Time `json:"time"`
+	Goroutine string    `json:"goroutine,omitempty"` // Name passed to RecoverWithLog
+	Panic     string    `json:"panic"`
+	Fatal     bool      `json:"fatal,omitempty"` // The process died
+	Stack     string    `json:"stack"`           // Panicking goroutine, or the full runtime output of a fatal crash
+	Dump      string    `json:"goroutines,omitempty"`
+	Metadata
+}
+
+// Summary is the list view of a report.
+type Summary struct {
+	ID        string    `json:"id"`
+	Time      time.Time `json:"time"`
+	Goroutine string    `json:"goroutine,omitempty"`
+	Panic     string    `json:"panic"`
+	Fatal     bool      `json:"fatal,omitempty"`
+	Version   string    `json:"version"`
+	Size      int64     `json:"size"`
+}
+
+// Store persists crash reports as JSON files in a directory, keeping the
+// newest MaxReports.
+type Store struct {
+	dir  string
+	max  int
+	meta Metadata
+
+	mu sync.Mutex
+}
+
+var (
+	reportersMu sync.Mutex
+	reporters   = map[*Store]bool{}
+
+	fatalMu    sync.Mutex
+	fatalOwner *Store
+)
+
+// NewStore creates a crash report store in dir. maxReports <= 0 uses
+// DefaultMaxReports.
+func NewStore(dir string, maxReports int, meta Metadata) (*Store, error) {
+	if maxReports <= 0 {
+		maxReports = DefaultMaxReports
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("create crash report directory: %w", err)
+	}
+	meta.GoVersion = runtime.Version()
+	meta.OS = runtime.GOOS
+	meta.Arch = runtime.GOARCH
+	return &Store{dir: dir, max: maxReports, meta: meta}, nil
+}
+
+// Start makes the store record panics recovered by RecoverWithLog and
+// RecoverWithCallback, collects the output of a previous fatal crash and
+// directs the runtime's output for the next one to the store. Returns the
+// report collected from a previous crash, if any.
+func (s *Store) Start() (*Report, error) {
+	reportersMu.Lock()
+	reporters[s] = true
+	reportersMu.Unlock()
+
+	collected, err := s.collectFatal()
+	if err != nil {
+		return nil, err
+	}
+	return collected, s.captureFatal()
+}
+
+// Close stops recording panics and releases the fatal crash output.
+func (s *Store) Close() {
+	reportersMu.Lock()
+	delete(reporters, s)
+	reportersMu.Unlock()
+
+	fatalMu.Lock()
+	defer fatalMu.Unlock()
+	if fatalOwner == s {
+		debug.SetCrashOutput(nil, debug.CrashOptions{})
+		fatalOwner = nil
+	}
+}
+
+// report records a recovered panic with all registered stores.
+func report(name string, recovered any, stack []byte) {
+	reportersMu.Lock()
+	stores := make([]*Store, 0, len(reporters))
+	for s := range reporters {
+		stores = append(stores, s)
+	}
+	reportersMu.Unlock()
+	if len(stores) == 0 {
+		return
+	}
+
+	dump := goroutineDump()
+	for _, s := range stores {
+		s.Save(&Report{
+			Time:      time.Now(),
+			Goroutine: name,
+			Panic:     fmt.Sprintf("%v", recovered),
+			Stack:     string(stack),
+			Dump:      dump,
+		})
+	}
+}
+
+// goroutineDump returns the stacks of all goroutines, cut at
+// maxGoroutineDump bytes.
+func goroutineDump() string {
+	buf := make([]byte, 64*1024)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) || len(buf) >= maxGoroutineDump {
+			return string(buf[:n])
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+}
+
+// newReportID returns a report ID that sorts in time order.
+func newReportID(t time.Time) string {
+	var b [3]byte
+	rand.Read(b[:])
+	return t.UTC().Format("20060102T150405Z") + "-" + hex.EncodeToString(b[:])
+}
+
+// Save writes a report, filling in its ID and the store metadata, and
+// removes the oldest reports beyond the limit.
+func (s *Store) Save(r *Report) error {
+	if r.ID == "" {
+		r.ID = newReportID(r.Time)
+	}
+	if r.Version == "" {
+		r.Metadata = s.meta
+	}
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	path := filepath.Join(s.dir, r.ID+".json")
+	tmp := path + ".tmp"
+	err = os.WriteFile(tmp, data, 0600)
+	if err == nil {
+		if err = os.Rename(tmp, path); err != nil {
+			os.Remove(tmp)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("write crash report: %w", err)
+	}
+
+	ids := s.ids()
+	for len(ids) > s.max {
+		os.Remove(filepath.Join(s.dir, ids[0]+".json"))
+		ids = ids[1:]
+	}
+	return nil
+}
+
+// ids returns the IDs of the stored reports, oldest first.
+func (s *Store) ids() []string {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil
+	}
+	var ids []string
+	for _, e := range entries {
+		if id, ok := strings.CutSuffix(e.Name(), ".json"); ok && !e.IsDir() {
+			ids = append(ids, id)
+		}
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+// List returns summaries of the stored reports, newest first.
+func (s *Store) List() []Summary {
+	s.mu.Lock()
+	ids := s.ids()
+	s.mu.Unlock()
+
+	summaries := make([]Summary, 0, len(ids))
+	for _, id := range slices.Backward(ids) {
+		r, size, err := s.read(id)
+		if err != nil {
+			continue
+		}
+		panicText := r.Panic
+		if len(panicText) > summaryPanicLen {
+			panicText = panicText[:summaryPanicLen] + "..."
+		}
+		summaries = append(summaries, Summary{
+			ID:        r.ID,
+			Time:      r.Time,
+			Goroutine: r.Goroutine,
+			Panic:     panicText,
+			Fatal:     r.Fatal,
+			Version:   r.Version,
+			Size:      size,
+		})
+	}
+	return summaries
+}
+
+// Get returns the report with the given ID.
+func (s *Store) Get(id string) (*Report, error) {
+	r, _, err := s.read(id)
+	return r, err
+}
+
+// read loads a report and returns it with its file size.
+func (s *Store) read(id string) (*Report, int64, error) {
+	if id == "" || strings.ContainsAny(id, `/\.`) {
+		return nil, 0, ErrReportNotFound
+	}
+	data, err := os.ReadFile(filepath.Join(s.dir, id+".json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, 0, ErrReportNotFound
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	var r Report
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, 0, fmt.Errorf("decode crash report %s: %w", id, err)
+	}
+	return &r, int64(len(data)), nil
+}
+
+// Delete removes the report with the given ID, or all reports when id is
+// empty. Returns the number of reports removed.
+func (s *Store) Delete(id string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if id != "" {
+		if _, _, err := s.read(id); err != nil {
+			return 0, err
+		}
+		if err := os.Remove(filepath.Join(s.dir, id+".json")); err != nil {
+			return 0, err
+		}
+		return 1, nil
+	}
+
+	n := 0
+	for _, id := range s.ids() {
+		if os.Remove(filepath.Join(s.dir, id+".json")) == nil {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// captureFatal directs the runtime's fatal crash output to fatalFile,
+// starting it with a header carrying the store metadata.
+func (s *Store) captureFatal() error {
+	header, err := json.Marshal(s.meta)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Join(s.dir, fatalFile), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("open crash output: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := fmt.Fprintf(f, "%s%s\n", fatalHeader, header); err != nil {
+		return fmt.Errorf("write crash output: %w", err)
+	}
+
+	fatalMu.Lock()
+	defer fatalMu.Unlock()
+	if err := debug.SetCrashOutput(f, debug.CrashOptions{}); err != nil {
+		return fmt.Errorf("set crash output: %w", err)
+	}
+	fatalOwner = s
+	return nil
+}
+
+// collectFatal turns the output of a previous fatal crash into a report.
+// The metadata comes from the header written by the crashed process.
+func (s *Store) collectFatal() (*Report, error) {
+	path := filepath.Join(s.dir, fatalFile)
+	info, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read crash output: %w", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read crash output: %w", err)
+	}
+
+	r, ok := parseFatal(data)
+	if !ok {
+		return nil, nil
+	}
+	// The runtime's last write to the file was the crash
+	r.Time = info.ModTime()
+	if err := s.Save(r); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// parseFatal parses fatalFile contents. Returns false when the file holds
+// only the header, i.e. the process did not crash.
+func parseFatal(data []byte) (*Report, bool) {
+	r := &Report{Fatal: true}
+
+	header, output, _ := bytes.Cut(data, []byte("\n"))
+	if meta, ok := bytes.CutPrefix(header, []byte(fatalHeader)); ok {
+		json.Unmarshal(meta, &r.Metadata)
+	} else {
+		output = data
+	}
+	output = bytes.TrimSpace(output)
+	if len(output) == 0 {
+		return nil, false
+	}
+
+	// The first line is the panic or fatal error message
+	r.Stack = string(output)
+	sc := bufio.NewScanner(bytes.NewReader(output))
+	for sc.Scan() {
+		if line := strings.TrimSpace(sc.Text()); line != "" {
+			r.Panic = line
+			break
+		}
+	}
+	return r, true
+}
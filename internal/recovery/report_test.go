@@ -0,0 +1,175 @@
+package recovery
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestStore(t *testing.T, max int) *Store {
+	t.Helper()
+	s, err := NewStore(t.TempDir(), max, Metadata{AgentID: "abc123", Version: "1.2.3", ConfigHash: "feedface"})
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+	return s
+}
+
+func TestStore_SaveListGet(t *testing.T) {
+	s := newTestStore(t, 0)
+
+	base := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	for i := range 3 {
+		if err := s.Save(&Report{Time: base.Add(time.Duration(i) * time.Minute), Panic: "boom", Stack: "stack"}); err != nil {
+			t.Fatalf("Save: %v", err)
+		}
+	}
+
+	list := s.List()
+	if len(list) != 3 {
+		t.Fatalf("List returned %d reports, want 3", len(list))
+	}
+	if !list[0].Time.After(list[2].Time) {
+		t.Errorf("List not newest first: %v, %v", list[0].Time, list[2].Time)
+	}
+	if list[0].Version != "1.2.3" || list[0].Size == 0 {
+		t.Errorf("summary = %+v, want version and size", list[0])
+	}
+
+	r, err := s.Get(list[0].ID)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if r.AgentID != "abc123" || r.ConfigHash != "feedface" || r.GoVersion == "" {
+		t.Errorf("report metadata = %+v", r.Metadata)
+	}
+
+	if _, err := s.Get("../etc/passwd"); !errors.Is(err, ErrReportNotFound) {
+		t.Errorf("Get with path = %v, want ErrReportNotFound", err)
+	}
+}
+
+func TestStore_Prune(t *testing.T) {
+	s := newTestStore(t, 2)
+
+	base := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	var ids []string
+	for i := range 4 {
+		r := &Report{Time: base.Add(time.Duration(i) * time.Hour), Panic: "boom"}
+		if err := s.Save(r); err != nil {
+			t.Fatalf("Save: %v", err)
+		}
+		ids = append(ids, r.ID)
+	}
+
+	list := s.List()
+	if len(list) != 2 {
+		t.Fatalf("List returned %d reports, want 2", len(list))
+	}
+	if list[0].ID != ids[3] || list[1].ID != ids[2] {
+		t.Errorf("kept %s, %s, want the newest two", list[0].ID, list[1].ID)
+	}
+}
+
+func TestStore_Delete(t *testing.T) {
+	s := newTestStore(t, 0)
+	for range 3 {
+		s.Save(&Report{Time: time.Now(), Panic: "boom"})
+	}
+
+	id := s.List()[0].ID
+	if n, err := s.Delete(id); err != nil || n != 1 {
+		t.Fatalf("Delete(%s) = %d, %v", id, n, err)
+	}
+	if _, err := s.Delete(id); !errors.Is(err, ErrReportNotFound) {
+		t.Errorf("second Delete = %v, want ErrReportNotFound", err)
+	}
+	if n, err := s.Delete(""); err != nil || n != 2 {
+		t.Errorf("Delete all = %d, %v, want 2", n, err)
+	}
+	if len(s.List()) != 0 {
+		t.Error("reports left after Delete all")
+	}
+}
+
+func TestRecoverWithLog_SavesReport(t *testing.T) {
+	s := newTestStore(t, 0)
+	if _, err := s.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	defer s.Close()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer RecoverWithLog(logger, "reportGoroutine")
+		panic("reported panic")
+	}()
+	wg.Wait()
+
+	list := s.List()
+	if len(list) != 1 {
+		t.Fatalf("List returned %d reports, want 1", len(list))
+	}
+	r, err := s.Get(list[0].ID)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if r.Goroutine != "reportGoroutine" || r.Panic != "reported panic" || r.Fatal {
+		t.Errorf("report = %+v", r)
+	}
+	if !strings.Contains(r.Stack, "TestRecoverWithLog_SavesReport") {
+		t.Errorf("stack does not include the panicking function: %s", r.Stack)
+	}
+	if r.Dump == "" {
+		t.Error("goroutine dump is empty")
+	}
+}
+
+func TestStore_CollectFatal(t *testing.T) {
+	s := newTestStore(t, 0)
+
+	// Output left by a crashed process
+	output := fatalHeader + `{"agent_id":"old","version":"1.0.0","go_version":"go1.24","os":"linux","arch":"amd64"}` + "\n" +
+		"fatal error: concurrent map writes\n\ngoroutine 7 [running]:\nmain.main()\n"
+	if err := os.WriteFile(filepath.Join(s.dir, fatalFile), []byte(output), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	collected, err := s.Start()
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	s.Close()
+
+	if collected == nil {
+		t.Fatal("Start did not collect the fatal crash")
+	}
+	if !collected.Fatal || collected.Panic != "fatal error: concurrent map writes" {
+		t.Errorf("collected = %+v", collected)
+	}
+	if collected.Version != "1.0.0" || collected.AgentID != "old" {
+		t.Errorf("metadata = %+v, want the crashed process metadata", collected.Metadata)
+	}
+	if len(s.List()) != 1 {
+		t.Error("collected report was not saved")
+	}
+
+	// A clean start leaves only the header
+	collected, err = s.Start()
+	if err != nil {
+		t.Fatalf("second Start: %v", err)
+	}
+	s.Close()
+	if collected != nil {
+		t.Errorf("second Start collected %+v, want nothing", collected)
+	}
+}
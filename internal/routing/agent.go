@@ -180,6 +180,26 @@ func (t *AgentTable) RemoveRoutesFromPeer(peerID identity.AgentID) int {
 	return count
 }
 
+// TouchRoutesFromPeer marks all agent routes learned from a specific peer as
+// freshly updated, so CleanupStaleRoutes keeps them while the peer is
+// connected but not re-advertising. Returns the number of routes touched.
+func (t *AgentTable) TouchRoutesFromPeer(peerID identity.AgentID) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	count := 0
+	for _, routes := range t.routes {
+		for _, r := range routes {
+			if r.NextHop == peerID {
+				r.LastUpdate = now
+				count++
+			}
+		}
+	}
+	return count
+}
+
 // Lookup finds the best agent presence route for a target agent.
 func (t *AgentTable) Lookup(agentID identity.AgentID) *AgentRoute {
 	t.mu.RLock()
@@ -631,3 +631,54 @@ func TestManager_CleanupStaleAgentRoutes(t *testing.T) {
 		t.Errorf("CleanupStaleAgentRoutes = %d, want 1", removed)
 	}
 }
+
+func TestManager_RefreshRoutesFromPeer(t *testing.T) {
+	localID, _ := identity.NewAgentID()
+	mgr := NewManager(localID)
+
+	agentA, _ := identity.NewAgentID()
+	agentC, _ := identity.NewAgentID()
+	peerB, _ := identity.NewAgentID()
+	peerD, _ := identity.NewAgentID()
+
+	mgr.ProcessAgentRouteAdvertise(peerB, agentA, 1, agentA, []identity.AgentID{peerB, agentA}, nil, 1)
+	mgr.ProcessAgentRouteAdvertise(peerD, agentC, 1, agentC, []identity.AgentID{peerD, agentC}, nil, 1)
+	mgr.SetNodeInfo(agentA, &protocol.NodeInfo{DisplayName: "a"}, 1)
+	mgr.SetNodeInfo(agentC, &protocol.NodeInfo{DisplayName: "c"}, 1)
+
+	// Backdate routes and node info
+	agentTable := mgr.AgentTable()
+	agentTable.mu.Lock()
+	for _, routes := range agentTable.routes {
+		for _, r := range routes {
+			r.LastUpdate = r.LastUpdate.Add(-10 * time.Minute)
+		}
+	}
+	agentTable.mu.Unlock()
+	mgr.mu.Lock()
+	for _, entry := range mgr.nodeInfos {
+		entry.LastUpdate = entry.LastUpdate.Add(-10 * time.Minute)
+	}
+	mgr.mu.Unlock()
+
+	if refreshed := mgr.RefreshRoutesFromPeer(peerB); refreshed != 1 {
+		t.Errorf("RefreshRoutesFromPeer = %d, want 1", refreshed)
+	}
+
+	if removed := mgr.CleanupStaleAgentRoutes(5 * time.Minute); removed != 1 {
+		t.Errorf("CleanupStaleAgentRoutes = %d, want 1", removed)
+	}
+	if mgr.LookupAgent(agentA) == nil {
+		t.Error("route via refreshed peer should be kept")
+	}
+	if mgr.LookupAgent(agentC) != nil {
+		t.Error("route via other peer should expire")
+	}
+
+	if removed := mgr.CleanupStaleNodeInfo(5 * time.Minute); removed != 1 {
+		t.Errorf("CleanupStaleNodeInfo = %d, want 1", removed)
+	}
+	if mgr.GetNodeInfo(agentA) == nil {
+		t.Error("node info of agent behind refreshed peer should be kept")
+	}
+}
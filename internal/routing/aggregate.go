@@ -0,0 +1,165 @@
+package routing
+
+import (
+	"bytes"
+	"net"
+	"sort"
+)
+
+// aggPrefix is a masked prefix used as a map key during aggregation.
+type aggPrefix struct {
+	addr [net.IPv6len]byte // Address bytes, IPv4 uses the first 4
+	size int               // Address length in bytes (4 or 16)
+	bits int
+}
+
+// newAggPrefix converts a network to an aggPrefix. ok is false for
+// non-canonical masks.
+func newAggPrefix(network *net.IPNet) (aggPrefix, bool) {
+	addr, ones, ok := trieKey(network)
+	if !ok {
+		return aggPrefix{}, false
+	}
+	p := aggPrefix{size: len(addr), bits: ones}
+	copy(p.addr[:], addr)
+	return p, true
+}
+
+// parent returns the prefix one bit shorter that covers p.
+func (p aggPrefix) parent() aggPrefix {
+	p.bits--
+	p.addr[p.bits/8] &^= 0x80 >> uint(p.bits%8)
+	return p
+}
+
+// sibling returns the other half of p's parent.
+func (p aggPrefix) sibling() aggPrefix {
+	i := p.bits - 1
+	p.addr[i/8] ^= 0x80 >> uint(i%8)
+	return p
+}
+
+// network converts p back to a net.IPNet.
+func (p aggPrefix) network() *net.IPNet {
+	ip := make(net.IP, p.size)
+	copy(ip, p.addr[:p.size])
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(p.bits, p.size*8)}
+}
+
+// AggregateRoutes summarizes routes into fewer covering prefixes.
+//
+// Two routes are merged when they are the two halves of a shorter prefix
+// and have the same metric, repeatedly, so 10.1.0.0/24 through
+// 10.1.255.0/24 become 10.1.0.0/16. A route is dropped when another route
+// covers it with an equal or lower metric. The advertised address space is
+// unchanged.
+//
+// With groups set, only routes inside a group are aggregated and summaries
+// never grow beyond their group's prefix. Routes outside every group pass
+// through unchanged. With no groups, all routes are aggregated.
+func AggregateRoutes(routes []*LocalRoute, groups []*net.IPNet) []*LocalRoute {
+	type bucket struct {
+		floor  int
+		routes map[aggPrefix]uint16
+	}
+	buckets := make(map[string]*bucket)
+	var result []*LocalRoute
+
+	for _, r := range routes {
+		if r == nil || r.Network == nil {
+			continue
+		}
+		p, ok := newAggPrefix(r.Network)
+		if !ok {
+			result = append(result, r)
+			continue
+		}
+
+		// Find the most specific group containing this route
+		var group *net.IPNet
+		groupBits := -1
+		for _, g := range groups {
+			gOnes, gBits := g.Mask.Size()
+			if gBits != p.size*8 || gOnes > p.bits || gOnes <= groupBits || !g.Contains(p.network().IP) {
+				continue
+			}
+			group, groupBits = g, gOnes
+		}
+		if len(groups) > 0 && group == nil {
+			result = append(result, r)
+			continue
+		}
+
+		bucketKey := "all"
+		floor := 0
+		if group != nil {
+			bucketKey = group.String()
+			floor = groupBits
+		}
+		if p.size == net.IPv4len {
+			bucketKey += "/v4"
+		}
+		b := buckets[bucketKey]
+		if b == nil {
+			b = &bucket{floor: floor, routes: make(map[aggPrefix]uint16)}
+			buckets[bucketKey] = b
+		}
+		if metric, ok := b.routes[p]; !ok || r.Metric < metric {
+			b.routes[p] = r.Metric
+		}
+	}
+
+	for _, b := range buckets {
+		for p, metric := range aggregatePrefixes(b.routes, b.floor) {
+			result = append(result, &LocalRoute{Network: p.network(), Metric: metric})
+		}
+	}
+
+	// Stable order keeps advertisements identical across runs
+	sort.Slice(result, func(i, j int) bool {
+		a, b := result[i].Network, result[j].Network
+		if c := bytes.Compare(a.IP, b.IP); c != 0 {
+			return c < 0
+		}
+		onesA, _ := a.Mask.Size()
+		onesB, _ := b.Mask.Size()
+		return onesA < onesB
+	})
+	return result
+}
+
+// aggregatePrefixes removes covered prefixes and merges equal-metric
+// siblings, never producing a prefix shorter than floor bits.
+func aggregatePrefixes(routes map[aggPrefix]uint16, floor int) map[aggPrefix]uint16 {
+	// Drop routes covered by a shorter route that is at least as good
+	for p, metric := range routes {
+		for q := p; q.bits > floor; {
+			q = q.parent()
+			if m, ok := routes[q]; ok && m <= metric {
+				delete(routes, p)
+				break
+			}
+		}
+	}
+
+	// Merge siblings from the longest prefixes up
+	maxBits := 0
+	for p := range routes {
+		maxBits = max(maxBits, p.bits)
+	}
+	for bits := maxBits; bits > floor; bits-- {
+		for p, metric := range routes {
+			if p.bits != bits {
+				continue
+			}
+			sib := p.sibling()
+			if m, ok := routes[sib]; !ok || m != metric {
+				continue
+			}
+			delete(routes, p)
+			delete(routes, sib)
+			routes[p.parent()] = metric
+		}
+	}
+	return routes
+}
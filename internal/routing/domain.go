@@ -248,22 +248,54 @@ func (t *DomainTable) RemoveRoutesFromPeer(peerID identity.AgentID) int {
 	return count
 }
 
+// TouchRoutesFromPeer marks all domain routes learned from a specific peer
+// as freshly updated, so CleanupStaleRoutes keeps them while the peer is
+// connected but not re-advertising. Returns the number of routes touched.
+func (t *DomainTable) TouchRoutesFromPeer(peerID identity.AgentID) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	count := 0
+	for _, routeMap := range t.allRouteMaps() {
+		for _, routes := range routeMap {
+			for _, r := range routes {
+				if r.NextHop == peerID {
+					r.LastUpdate = now
+					count++
+				}
+			}
+		}
+	}
+	return count
+}
+
 // Lookup finds the best domain route for a domain name.
 // First checks exact matches, then single-level wildcards.
 func (t *DomainTable) Lookup(domain string) *DomainRoute {
 	t.mu.RLock()
 	defer t.mu.RUnlock()
 
-	return t.lookupUnlocked(domain)
+	return t.lookupUnlocked(domain, nil)
+}
+
+// LookupFunc finds the best domain route for a domain name among the routes
+// for which match returns true.
+func (t *DomainTable) LookupFunc(domain string, match func(*DomainRoute) bool) *DomainRoute {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	return t.lookupUnlocked(domain, match)
 }
 
 // lookupUnlocked performs lookup without locking (caller must hold lock).
-func (t *DomainTable) lookupUnlocked(domain string) *DomainRoute {
+// A nil match accepts every route.
+func (t *DomainTable) lookupUnlocked(domain string, match func(*DomainRoute) bool) *DomainRoute {
 	domain = strings.ToLower(domain)
 
 	// 1. Check exact match first
-	if routes, ok := t.exactRoutes[domain]; ok && len(routes) > 0 {
-		return routes[0].Clone() // First is best due to sorting by metric
+	if route := firstMatch(t.exactRoutes[domain], match); route != nil {
+		return route.Clone() // First is best due to sorting by metric
 	}
 
 	// 2. Check single-level wildcard
@@ -271,14 +303,24 @@ func (t *DomainTable) lookupUnlocked(domain string) *DomainRoute {
 	idx := strings.Index(domain, ".")
 	if idx > 0 && idx < len(domain)-1 {
 		baseDomain := domain[idx+1:]
-		if routes, ok := t.wildcardBase[baseDomain]; ok && len(routes) > 0 {
-			return routes[0].Clone()
+		if route := firstMatch(t.wildcardBase[baseDomain], match); route != nil {
+			return route.Clone()
 		}
 	}
 
 	return nil
 }
 
+// firstMatch returns the first route for which match returns true.
+func firstMatch(routes []*DomainRoute, match func(*DomainRoute) bool) *DomainRoute {
+	for _, r := range routes {
+		if match == nil || match(r) {
+			return r
+		}
+	}
+	return nil
+}
+
 // GetAllRoutes returns all domain routes in the table.
 func (t *DomainTable) GetAllRoutes() []*DomainRoute {
 	t.mu.RLock()
@@ -190,6 +190,26 @@ func (t *ForwardTable) RemoveRoutesFromPeer(peerID identity.AgentID) int {
 	return count
 }
 
+// TouchRoutesFromPeer marks all port forward routes learned from a specific peer as
+// freshly updated, so CleanupStaleRoutes keeps them while the peer is
+// connected but not re-advertising. Returns the number of routes touched.
+func (t *ForwardTable) TouchRoutesFromPeer(peerID identity.AgentID) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	count := 0
+	for _, routes := range t.routes {
+		for _, r := range routes {
+			if r.NextHop == peerID {
+				r.LastUpdate = now
+				count++
+			}
+		}
+	}
+	return count
+}
+
 // Lookup finds the best port forward route for a routing key.
 func (t *ForwardTable) Lookup(key string) *ForwardRoute {
 	t.mu.RLock()
@@ -201,6 +221,20 @@ func (t *ForwardTable) Lookup(key string) *ForwardRoute {
 	return nil
 }
 
+// LookupFunc finds the best port forward route for a routing key among the
+// routes for which match returns true.
+func (t *ForwardTable) LookupFunc(key string, match func(*ForwardRoute) bool) *ForwardRoute {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	for _, r := range t.routes[key] {
+		if match(r) {
+			return r.Clone()
+		}
+	}
+	return nil
+}
+
 // GetAllRoutes returns all port forward routes in the table.
 func (t *ForwardTable) GetAllRoutes() []*ForwardRoute {
 	t.mu.RLock()
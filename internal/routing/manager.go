@@ -17,6 +17,7 @@ type RouteChange struct {
 	Type    RouteChangeType
 	Route   *Route
 	OldPath []identity.AgentID // For updates
+	Reason  string             // What caused the change (Reason* constants)
 }
 
 // RouteChangeType indicates the type of route change.
@@ -28,6 +29,18 @@ const (
 	RouteUpdated
 )
 
+// Reasons carried in RouteChange.Reason.
+const (
+	ReasonLocal     = "local"     // Local route added or removed
+	ReasonAPI       = "api"       // Dynamic route added or removed via the API
+	ReasonAdvertise = "advertise" // ROUTE_ADVERTISE received
+	ReasonWithdraw  = "withdraw"  // ROUTE_WITHDRAW received
+	ReasonPeerDown  = "peer_down" // Next hop disconnected
+	ReasonFailover  = "failover"  // Switched to an alternate after the next hop disconnected
+	ReasonSuspend   = "suspend"   // Local routes suspended
+	ReasonResume    = "resume"    // Local routes resumed
+)
+
 // LocalRoute represents a locally-announced route.
 type LocalRoute struct {
 	Network *net.IPNet
@@ -61,6 +74,23 @@ type Manager struct {
 	sequence      uint64
 	sealedBox     *crypto.SealedBox // For decrypting NodeInfo (nil if not configured)
 
+	// Route aggregation for advertised local routes
+	aggregate       bool
+	aggregateGroups []*net.IPNet
+
+	// Exit tags preferred over metric when choosing CIDR routes (see SetPreferTags)
+	preferTags []string
+
+	// Local CIDR and domain routes held out of the tables and
+	// advertisements (see SuspendLocalRoutes)
+	suspended bool
+
+	// Tenant namespaces of route origins (see namespace.go). Guarded by
+	// nsMu, which is never held while taking a table lock.
+	nsMu           sync.RWMutex
+	localNamespace string
+	namespaces     map[identity.AgentID]string
+
 	// Subscribers for route changes
 	subscribers []chan<- RouteChange
 	subMu       sync.RWMutex
@@ -80,6 +110,7 @@ func NewManager(localID identity.AgentID) *Manager {
 		localForwards: make(map[string]*LocalForwardRoute),
 		displayNames:  make(map[identity.AgentID]string),
 		nodeInfos:     make(map[identity.AgentID]*NodeInfoEntry),
+		namespaces:    make(map[identity.AgentID]string),
 	}
 }
 
@@ -296,8 +327,14 @@ func (m *Manager) AddLocalRoute(network *net.IPNet, metric uint16) bool {
 		Network: network,
 		Metric:  metric,
 	}
+	suspended := m.suspended
 	m.mu.Unlock()
 
+	// Added to the table when the local routes are resumed
+	if suspended {
+		return true
+	}
+
 	// Add to table with ourselves as origin
 	// Note: Path is empty for local routes to avoid loop detection on our own ID
 	route := &Route{
@@ -312,8 +349,9 @@ func (m *Manager) AddLocalRoute(network *net.IPNet, metric uint16) bool {
 	added := m.table.AddRoute(route)
 	if added {
 		m.notifyChange(RouteChange{
-			Type:  RouteAdded,
-			Route: route.Clone(),
+			Type:   RouteAdded,
+			Reason: ReasonLocal,
+			Route:  route.Clone(),
 		})
 	}
 
@@ -340,7 +378,8 @@ func (m *Manager) RemoveLocalRoute(network *net.IPNet) bool {
 	removed := m.table.RemoveRoute(network, m.localID)
 	if removed {
 		m.notifyChange(RouteChange{
-			Type: RouteRemoved,
+			Type:   RouteRemoved,
+			Reason: ReasonLocal,
 			Route: &Route{
 				Network:     network,
 				OriginAgent: m.localID,
@@ -366,6 +405,34 @@ func (m *Manager) GetLocalRoutes() []*LocalRoute {
 	return routes
 }
 
+// SetAggregation enables summarizing local CIDR routes before they are
+// advertised. With groups set, only routes inside a group are aggregated.
+// See AggregateRoutes.
+func (m *Manager) SetAggregation(groups []*net.IPNet) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.aggregate = true
+	m.aggregateGroups = groups
+}
+
+// GetAdvertisedLocalRoutes returns the local CIDR routes as advertised to
+// peers, aggregated if enabled.
+func (m *Manager) GetAdvertisedLocalRoutes() []*LocalRoute {
+	if m.LocalRoutesSuspended() {
+		return nil
+	}
+	routes := m.GetLocalRoutes()
+
+	m.mu.RLock()
+	aggregate, groups := m.aggregate, m.aggregateGroups
+	m.mu.RUnlock()
+
+	if !aggregate {
+		return routes
+	}
+	return AggregateRoutes(routes, groups)
+}
+
 // AddDynamicRoute adds a route via the API. Returns an error if the route
 // already exists as a config route. If the route already exists as a dynamic
 // route, it is updated with the new metric.
@@ -390,8 +457,13 @@ func (m *Manager) AddDynamicRoute(network *net.IPNet, metric uint16) error {
 	lr := &LocalRoute{Network: network, Metric: metric}
 	m.localRoutes[key] = lr
 	m.dynamicRoutes[key] = lr
+	suspended := m.suspended
 	m.mu.Unlock()
 
+	if suspended {
+		return nil
+	}
+
 	route := &Route{
 		Network:     network,
 		NextHop:     m.localID,
@@ -404,8 +476,9 @@ func (m *Manager) AddDynamicRoute(network *net.IPNet, metric uint16) error {
 	added := m.table.AddRoute(route)
 	if added {
 		m.notifyChange(RouteChange{
-			Type:  RouteAdded,
-			Route: route.Clone(),
+			Type:   RouteAdded,
+			Reason: ReasonAPI,
+			Route:  route.Clone(),
 		})
 	}
 
@@ -437,7 +510,8 @@ func (m *Manager) RemoveDynamicRoute(network *net.IPNet) error {
 	removed := m.table.RemoveRoute(network, m.localID)
 	if removed {
 		m.notifyChange(RouteChange{
-			Type: RouteRemoved,
+			Type:   RouteRemoved,
+			Reason: ReasonAPI,
 			Route: &Route{
 				Network:     network,
 				OriginAgent: m.localID,
@@ -509,8 +583,9 @@ func (m *Manager) ProcessRouteAdvertise(
 		if m.table.AddRoute(route) {
 			accepted = append(accepted, route.Clone())
 			m.notifyChange(RouteChange{
-				Type:  RouteAdded,
-				Route: route.Clone(),
+				Type:   RouteAdded,
+				Reason: ReasonAdvertise,
+				Route:  route.Clone(),
 			})
 		}
 	}
@@ -518,6 +593,41 @@ func (m *Manager) ProcessRouteAdvertise(
 	return accepted
 }
 
+// SetFastReroute enables precomputing alternate next hops for CIDR routes.
+// See Table.AddAlternate and Table.FailoverFromPeer.
+func (m *Manager) SetFastReroute(enabled bool) {
+	m.table.SetFastReroute(enabled)
+}
+
+// ProcessAlternateAdvertise records the routes of a duplicate ROUTE_ADVERTISE
+// (one already applied through another peer) as alternate next hops.
+// Returns the number of alternates stored.
+func (m *Manager) ProcessAlternateAdvertise(
+	fromPeer identity.AgentID,
+	originAgent identity.AgentID,
+	sequence uint64,
+	routes []RouteEntry,
+	path []identity.AgentID,
+	encPath *protocol.EncryptedData,
+) int {
+	stored := 0
+	for _, entry := range routes {
+		alt := &Route{
+			Network:     entry.Network,
+			NextHop:     fromPeer,
+			OriginAgent: originAgent,
+			Metric:      entry.Metric + 1,
+			Path:        path,
+			EncPath:     encPath,
+			Sequence:    sequence,
+		}
+		if m.table.AddAlternate(alt) {
+			stored++
+		}
+	}
+	return stored
+}
+
 // ProcessRouteWithdraw processes an incoming ROUTE_WITHDRAW.
 // Returns true if any routes were removed.
 func (m *Manager) ProcessRouteWithdraw(
@@ -530,7 +640,8 @@ func (m *Manager) ProcessRouteWithdraw(
 		if m.table.RemoveRoute(entry.Network, originAgent) {
 			removed = true
 			m.notifyChange(RouteChange{
-				Type: RouteRemoved,
+				Type:   RouteRemoved,
+				Reason: ReasonWithdraw,
 				Route: &Route{
 					Network:     entry.Network,
 					OriginAgent: originAgent,
@@ -543,18 +654,55 @@ func (m *Manager) ProcessRouteWithdraw(
 }
 
 // HandlePeerDisconnect removes all routes learned from a disconnected peer.
+// Routes with a loop-free alternate switch to it (see Table.FailoverFromPeer).
+// Subscribers are notified of each removed or rerouted route.
 func (m *Manager) HandlePeerDisconnect(peerID identity.AgentID) int {
-	return m.table.RemoveRoutesFromPeer(peerID)
+	removed, rerouted := m.table.FailoverFromPeer(peerID)
+	for _, r := range removed {
+		m.notifyChange(RouteChange{Type: RouteRemoved, Route: r, Reason: ReasonPeerDown})
+	}
+	for _, r := range rerouted {
+		m.notifyChange(RouteChange{Type: RouteUpdated, Route: r, Reason: ReasonFailover})
+	}
+	return len(removed) + len(rerouted)
 }
 
-// Lookup finds the best route for an IP address.
+// Lookup finds the best route for an IP address. With preferred tags set,
+// the route is chosen as described in SetPreferTags.
 func (m *Manager) Lookup(ip net.IP) *Route {
-	return m.table.Lookup(ip)
+	if !m.hasPreferTags() {
+		return m.table.Lookup(ip)
+	}
+	if paths := m.LookupPaths(ip); len(paths) > 0 {
+		return paths[0]
+	}
+	return nil
+}
+
+// LookupPaths returns all known paths for an IP address, best first.
+func (m *Manager) LookupPaths(ip net.IP) []*Route {
+	paths := m.table.LookupPaths(ip)
+	m.sortByPreferTags(paths)
+	return paths
+}
+
+// GetRoute returns the best route for a specific network, honoring the
+// preferred tags.
+func (m *Manager) GetRoute(network *net.IPNet) *Route {
+	if !m.hasPreferTags() {
+		return m.table.GetRoute(network)
+	}
+	routes := m.table.GetAllRoutesForNetwork(network)
+	if len(routes) == 0 {
+		return nil
+	}
+	m.sortByPreferTags(routes)
+	return routes[0]
 }
 
 // LookupNextHop returns just the next-hop peer ID for an IP.
 func (m *Manager) LookupNextHop(ip net.IP) (identity.AgentID, bool) {
-	route := m.table.Lookup(ip)
+	route := m.Lookup(ip)
 	if route == nil {
 		return identity.AgentID{}, false
 	}
@@ -740,8 +888,13 @@ func (m *Manager) AddLocalDomainRoute(pattern string, metric uint16) bool {
 		BaseDomain: baseDomain,
 		Metric:     metric,
 	}
+	suspended := m.suspended
 	m.mu.Unlock()
 
+	if suspended {
+		return true
+	}
+
 	// Add to domain table with ourselves as origin
 	route := &DomainRoute{
 		Pattern:     pattern,
@@ -1006,6 +1159,12 @@ func (m *Manager) LookupAgent(agentID identity.AgentID) *AgentRoute {
 	return m.agentTable.Lookup(agentID)
 }
 
+// LookupAgentRoutes returns all agent presence routes for a target agent,
+// best first.
+func (m *Manager) LookupAgentRoutes(agentID identity.AgentID) []*AgentRoute {
+	return m.agentTable.GetRoutesForAgent(agentID)
+}
+
 // ProcessAgentRouteAdvertise processes an incoming agent presence route advertisement.
 // Returns true if the route was added/updated.
 func (m *Manager) ProcessAgentRouteAdvertise(
@@ -1034,6 +1193,33 @@ func (m *Manager) HandlePeerDisconnectAgent(peerID identity.AgentID) int {
 	return m.agentTable.RemoveRoutesFromPeer(peerID)
 }
 
+// RefreshRoutesFromPeer keeps everything learned through a connected peer
+// from expiring: CIDR, domain, forward and agent routes with the peer as next
+// hop, and node info of the agents reached through it. Used for quiet peers
+// that hold back periodic advertisements while idle. Returns the number of
+// routes refreshed.
+func (m *Manager) RefreshRoutesFromPeer(peerID identity.AgentID) int {
+	count := m.table.TouchRoutesFromPeer(peerID)
+	count += m.domainTable.TouchRoutesFromPeer(peerID)
+	count += m.forwardTable.TouchRoutesFromPeer(peerID)
+	count += m.agentTable.TouchRoutesFromPeer(peerID)
+
+	agentRoutes := m.agentTable.GetAllRoutes()
+	now := time.Now()
+	m.mu.Lock()
+	for _, r := range agentRoutes {
+		if r.NextHop != peerID {
+			continue
+		}
+		if entry, ok := m.nodeInfos[r.AgentID]; ok {
+			entry.LastUpdate = now
+		}
+	}
+	m.mu.Unlock()
+
+	return count
+}
+
 // CleanupStaleAgentRoutes removes agent routes that haven't been updated within maxAge.
 // Local routes are never removed. Returns the number of routes removed.
 func (m *Manager) CleanupStaleAgentRoutes(maxAge time.Duration) int {
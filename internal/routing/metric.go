@@ -0,0 +1,241 @@
+package routing
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/postalsys/muti-metroo/internal/identity"
+)
+
+// MetricMode selects how routes to the same prefix are compared.
+type MetricMode uint8
+
+const (
+	// MetricHops compares the advertised hop count only.
+	MetricHops MetricMode = iota
+
+	// MetricLatency compares the measured RTT to the next hop plus an
+	// estimate for each further hop.
+	MetricLatency
+
+	// MetricHybrid is MetricLatency with a fixed penalty per hop, so an
+	// extra hop must save a noticeable amount of latency to be chosen.
+	MetricHybrid
+)
+
+// Effective cost parameters for MetricLatency and MetricHybrid.
+const (
+	// hopLatencyEstimate is assumed for every hop beyond the next hop, whose
+	// RTT is not known here, and for next hops without an RTT sample yet.
+	hopLatencyEstimate = 10 * time.Millisecond
+
+	// hybridHopPenalty is added per hop in MetricHybrid.
+	hybridHopPenalty = 50 * time.Millisecond
+
+	// A new RTT sample replaces the one used for routing only when it
+	// differs by at least rttChangeRatio and rttChangeMin, so jitter does
+	// not reorder routes.
+	rttChangeRatio = 0.25
+	rttChangeMin   = 5 * time.Millisecond
+
+	// A route replaces the current best one only when its cost is lower by
+	// at least switchRatio of the current cost and switchMin.
+	switchRatio = 0.2
+	switchMin   = 10 * time.Millisecond
+)
+
+// ParseMetricMode parses a routing.metric value. An empty string is
+// MetricHops.
+func ParseMetricMode(s string) (MetricMode, error) {
+	switch s {
+	case "", "hops":
+		return MetricHops, nil
+	case "latency":
+		return MetricLatency, nil
+	case "hybrid":
+		return MetricHybrid, nil
+	}
+	return MetricHops, fmt.Errorf("unknown metric mode %q (want hops, latency or hybrid)", s)
+}
+
+// String returns the configuration name of the mode.
+func (m MetricMode) String() string {
+	switch m {
+	case MetricLatency:
+		return "latency"
+	case MetricHybrid:
+		return "hybrid"
+	default:
+		return "hops"
+	}
+}
+
+// SetMetricMode sets how routes are compared and reorders the table.
+func (t *Table) SetMetricMode(mode MetricMode) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.metricMode = mode
+	for key := range t.routes {
+		t.sortRoutes(key)
+	}
+}
+
+// MetricMode returns how routes are compared.
+func (t *Table) MetricMode() MetricMode {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.metricMode
+}
+
+// UpdatePeerRTTs sets the measured RTT of the connected peers, used as the
+// cost of the first hop with MetricLatency and MetricHybrid. Peers missing
+// from rtts are forgotten. Samples close to the previous one are ignored,
+// and routes are only reordered, or switched to a fast reroute alternate
+// to the same origin, when a significant change was seen. Returns the
+// number of prefixes whose best route changed.
+func (t *Table) UpdatePeerRTTs(rtts map[identity.AgentID]time.Duration) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.metricMode == MetricHops {
+		return 0
+	}
+
+	changed := false
+	for id := range t.rtt {
+		if _, ok := rtts[id]; !ok {
+			delete(t.rtt, id)
+			changed = true
+		}
+	}
+	for id, rtt := range rtts {
+		old, ok := t.rtt[id]
+		if ok {
+			diff := rtt - old
+			if diff < 0 {
+				diff = -diff
+			}
+			if diff < rttChangeMin || float64(diff) < rttChangeRatio*float64(old) {
+				continue
+			}
+		}
+		t.rtt[id] = rtt
+		changed = true
+	}
+	if !changed {
+		return 0
+	}
+
+	switched := 0
+	for key, routes := range t.routes {
+		best := routes[0].OriginAgent
+		bestHop := routes[0].NextHop
+		for _, r := range routes {
+			t.promoteAlternate(key, r.OriginAgent)
+		}
+		t.sortRoutes(key)
+		if routes := t.routes[key]; routes[0].OriginAgent != best || routes[0].NextHop != bestHop {
+			switched++
+		}
+	}
+	return switched
+}
+
+// PeerRTT returns the RTT of a peer used for routing, if known.
+func (t *Table) PeerRTT(peerID identity.AgentID) (time.Duration, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	rtt, ok := t.rtt[peerID]
+	return rtt, ok
+}
+
+// cost returns the effective cost of r with MetricLatency or MetricHybrid.
+// Caller must hold the lock.
+func (t *Table) cost(r *Route) time.Duration {
+	hops := time.Duration(max(r.Metric, 1))
+	first, ok := t.rtt[r.NextHop]
+	if !ok {
+		first = hopLatencyEstimate
+	}
+	c := first + (hops-1)*hopLatencyEstimate
+	if t.metricMode == MetricHybrid {
+		c += hops * hybridHopPenalty
+	}
+	return c
+}
+
+// better reports whether a is preferred over b: reachable routes first,
+// then lower metric or effective cost. Caller must hold the lock.
+func (t *Table) better(a, b *Route) bool {
+	if a.Unreachable != b.Unreachable {
+		return !a.Unreachable
+	}
+	if t.metricMode == MetricHops {
+		return a.Metric < b.Metric
+	}
+	return t.cost(a) < t.cost(b)
+}
+
+// clearlyBetter reports whether a should replace the current choice b,
+// applying the switch margin to effective costs. Caller must hold the lock.
+func (t *Table) clearlyBetter(a, b *Route) bool {
+	if a.Unreachable != b.Unreachable || t.metricMode == MetricHops {
+		return t.better(a, b)
+	}
+	cb := t.cost(b)
+	margin := max(switchMin, time.Duration(switchRatio*float64(cb)))
+	return t.cost(a)+margin <= cb
+}
+
+// promoteAlternate replaces the route of origin under key with a fast
+// reroute alternate of the same sequence that is clearly better, keeping
+// the replaced route as an alternate. Only used with MetricLatency and
+// MetricHybrid, since with MetricHops the first advertisement to arrive
+// is never beaten by a duplicate. Caller must hold the write lock.
+func (t *Table) promoteAlternate(key string, origin identity.AgentID) bool {
+	if t.metricMode == MetricHops || !t.fastReroute {
+		return false
+	}
+	routes := t.routes[key]
+	i := -1
+	for j, r := range routes {
+		if r.OriginAgent == origin {
+			i = j
+			break
+		}
+	}
+	if i < 0 {
+		return false
+	}
+	cur := routes[i]
+
+	var best *Route
+	for _, alt := range t.alternates[key] {
+		if alt.OriginAgent != origin || alt.Sequence < cur.Sequence {
+			continue
+		}
+		if best == nil || t.better(alt, best) {
+			best = alt
+		}
+	}
+	if best == nil || !t.clearlyBetter(best, cur) {
+		return false
+	}
+
+	t.removeAlternates(key, func(alt *Route) bool { return alt == best })
+	routes[i] = best
+	t.storeAlternate(key, cur)
+	return true
+}
+
+// SetMetricMode sets how CIDR routes to the same prefix are compared. See
+// Table.SetMetricMode.
+func (m *Manager) SetMetricMode(mode MetricMode) {
+	m.table.SetMetricMode(mode)
+}
+
+// UpdatePeerRTTs passes the measured RTT of the connected peers to the
+// routing table. See Table.UpdatePeerRTTs.
+func (m *Manager) UpdatePeerRTTs(rtts map[identity.AgentID]time.Duration) int {
+	return m.table.UpdatePeerRTTs(rtts)
+}
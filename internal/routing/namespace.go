@@ -0,0 +1,127 @@
+package routing
+
+import (
+	"net"
+
+	"github.com/postalsys/muti-metroo/internal/identity"
+)
+
+// Namespaces split one mesh into isolated tenant networks. Every agent's
+// exit, domain and forward routes belong to the namespace it advertises
+// them in; the empty string is the default namespace. The Lookup*In methods
+// only return routes whose origin is in the given namespace, so an ingress
+// never selects another tenant's exit. Exits enforce the namespace of each
+// stream they accept as well.
+
+// SetLocalNamespace sets the namespace of the local agent's routes.
+func (m *Manager) SetLocalNamespace(ns string) {
+	m.nsMu.Lock()
+	defer m.nsMu.Unlock()
+	m.localNamespace = ns
+}
+
+// LocalNamespace returns the namespace of the local agent's routes.
+func (m *Manager) LocalNamespace() string {
+	m.nsMu.RLock()
+	defer m.nsMu.RUnlock()
+	return m.localNamespace
+}
+
+// SetOriginNamespace records the namespace an origin advertised its routes
+// in. Each advertisement carries it, so it follows the origin's latest one.
+func (m *Manager) SetOriginNamespace(origin identity.AgentID, ns string) {
+	if origin == m.localID {
+		return
+	}
+	m.nsMu.Lock()
+	defer m.nsMu.Unlock()
+	if ns == "" {
+		delete(m.namespaces, origin)
+	} else {
+		m.namespaces[origin] = ns
+	}
+}
+
+// OriginNamespace returns the namespace of an origin's routes.
+func (m *Manager) OriginNamespace(origin identity.AgentID) string {
+	m.nsMu.RLock()
+	defer m.nsMu.RUnlock()
+	if origin == m.localID {
+		return m.localNamespace
+	}
+	return m.namespaces[origin]
+}
+
+// hasNamespaces reports whether any known origin, including the local
+// agent, is outside the default namespace.
+func (m *Manager) hasNamespaces() bool {
+	m.nsMu.RLock()
+	defer m.nsMu.RUnlock()
+	return m.localNamespace != "" || len(m.namespaces) > 0
+}
+
+// LookupIn finds the best route for an IP address whose origin is in
+// namespace ns.
+func (m *Manager) LookupIn(ip net.IP, ns string) *Route {
+	if ns == "" && !m.hasNamespaces() {
+		return m.Lookup(ip)
+	}
+	if paths := m.LookupPathsIn(ip, ns); len(paths) > 0 {
+		return paths[0]
+	}
+	return nil
+}
+
+// LookupPathsIn returns all known paths for an IP address whose origin is in
+// namespace ns, best first.
+func (m *Manager) LookupPathsIn(ip net.IP, ns string) []*Route {
+	paths := m.LookupPaths(ip)
+	if ns == "" && !m.hasNamespaces() {
+		return paths
+	}
+	kept := paths[:0]
+	for _, r := range paths {
+		if m.OriginNamespace(r.OriginAgent) == ns {
+			kept = append(kept, r)
+		}
+	}
+	return kept
+}
+
+// LookupDomainIn finds the best domain route for a domain name whose origin
+// is in namespace ns.
+func (m *Manager) LookupDomainIn(domain, ns string) *DomainRoute {
+	if ns == "" && !m.hasNamespaces() {
+		return m.LookupDomain(domain)
+	}
+	return m.domainTable.LookupFunc(domain, func(r *DomainRoute) bool {
+		return m.OriginNamespace(r.OriginAgent) == ns
+	})
+}
+
+// LookupForwardIn finds the best port forward route for a routing key whose
+// origin is in namespace ns.
+func (m *Manager) LookupForwardIn(key, ns string) *ForwardRoute {
+	if ns == "" && !m.hasNamespaces() {
+		return m.LookupForward(key)
+	}
+	return m.forwardTable.LookupFunc(key, func(r *ForwardRoute) bool {
+		return m.OriginNamespace(r.OriginAgent) == ns
+	})
+}
+
+// GetRouteIn returns the best route for a specific network whose origin is
+// in namespace ns.
+func (m *Manager) GetRouteIn(network *net.IPNet, ns string) *Route {
+	if ns == "" && !m.hasNamespaces() {
+		return m.GetRoute(network)
+	}
+	routes := m.table.GetAllRoutesForNetwork(network)
+	m.sortByPreferTags(routes)
+	for _, r := range routes {
+		if m.OriginNamespace(r.OriginAgent) == ns {
+			return r
+		}
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package routing
+
+import (
+	"slices"
+
+	"github.com/postalsys/muti-metroo/internal/identity"
+)
+
+// SetPreferTags sets the exit tags preferred when choosing between CIDR
+// routes to the same prefix, most preferred first. Exits advertise their
+// tags in node info. Among reachable routes of the longest matching prefix,
+// a route whose origin has an earlier tag in the list wins over one with a
+// later tag or none, regardless of metric; routes with equal preference are
+// ordered by metric as usual. Unreachable routes stay behind reachable ones,
+// and a longer prefix still wins over a preferred shorter one.
+//
+// An origin's tags are only known when its node info can be read, so with
+// management key encryption the preference only applies on agents holding
+// the management private key.
+func (m *Manager) SetPreferTags(tags []string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.preferTags = slices.Clone(tags)
+}
+
+// PreferTags returns the preferred exit tags.
+func (m *Manager) PreferTags() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return slices.Clone(m.preferTags)
+}
+
+// hasPreferTags reports whether any exit tags are preferred.
+func (m *Manager) hasPreferTags() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.preferTags) > 0
+}
+
+// GetAgentTags returns the exit tags an agent advertises in its node info.
+func (m *Manager) GetAgentTags(agentID identity.AgentID) []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if entry := m.nodeInfos[agentID]; entry != nil && entry.Info != nil {
+		return entry.Info.Tags
+	}
+	return nil
+}
+
+// tagRank returns the position in the preferred tags of the first tag an
+// origin advertises, or len(m.preferTags) when it has none of them. Must be
+// called with m.mu held.
+func (m *Manager) tagRank(origin identity.AgentID) int {
+	rank := len(m.preferTags)
+	entry := m.nodeInfos[origin]
+	if entry == nil || entry.Info == nil {
+		return rank
+	}
+	for _, tag := range entry.Info.Tags {
+		if i := slices.Index(m.preferTags, tag); i >= 0 && i < rank {
+			rank = i
+		}
+	}
+	return rank
+}
+
+// sortByPreferTags reorders routes, sorted longest prefix first, so that
+// within each prefix length reachable routes come first, then routes from
+// origins with preferred tags. The existing order is kept otherwise.
+func (m *Manager) sortByPreferTags(routes []*Route) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if len(m.preferTags) == 0 || len(routes) < 2 {
+		return
+	}
+
+	ranks := make(map[identity.AgentID]int)
+	for _, r := range routes {
+		if _, ok := ranks[r.OriginAgent]; !ok {
+			ranks[r.OriginAgent] = m.tagRank(r.OriginAgent)
+		}
+	}
+	slices.SortStableFunc(routes, func(a, b *Route) int {
+		onesA, _ := a.Network.Mask.Size()
+		onesB, _ := b.Network.Mask.Size()
+		if onesA != onesB {
+			return onesB - onesA
+		}
+		if a.Unreachable != b.Unreachable {
+			if a.Unreachable {
+				return 1
+			}
+			return -1
+		}
+		return ranks[a.OriginAgent] - ranks[b.OriginAgent]
+	})
+}
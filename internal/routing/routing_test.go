@@ -1,10 +1,16 @@
 package routing
 
 import (
+	"fmt"
+	"math/rand"
 	"net"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/postalsys/muti-metroo/internal/identity"
+	"github.com/postalsys/muti-metroo/internal/protocol"
 )
 
 // ============================================================================
@@ -435,6 +441,50 @@ func TestTable_LookupAll(t *testing.T) {
 	}
 }
 
+func TestTable_LookupPaths(t *testing.T) {
+	localID, _ := identity.NewAgentID()
+	peerA, _ := identity.NewAgentID()
+	peerB, _ := identity.NewAgentID()
+	exit1, _ := identity.NewAgentID()
+	exit2, _ := identity.NewAgentID()
+	table := NewTable(localID)
+	table.SetFastReroute(true)
+
+	wide := MustParseCIDR("10.0.0.0/8")
+	narrow := MustParseCIDR("10.1.0.0/16")
+	table.AddRoute(&Route{Network: wide, NextHop: peerB, OriginAgent: exit2, Metric: 1, Sequence: 1, Path: []identity.AgentID{peerB, exit2}})
+	table.AddRoute(&Route{Network: narrow, NextHop: peerA, OriginAgent: exit1, Metric: 4, Sequence: 1, Path: []identity.AgentID{peerA, exit1}})
+	table.AddRoute(&Route{Network: narrow, NextHop: peerB, OriginAgent: exit2, Metric: 5, Sequence: 1, Path: []identity.AgentID{peerB, exit2}})
+	table.AddAlternate(&Route{Network: narrow, NextHop: peerB, OriginAgent: exit1, Metric: 3, Sequence: 1, Path: []identity.AgentID{peerB, exit1}})
+
+	paths := table.LookupPaths(net.ParseIP("10.1.2.3"))
+	want := []struct {
+		network string
+		origin  identity.AgentID
+		nextHop identity.AgentID
+	}{
+		{"10.1.0.0/16", exit1, peerA},
+		{"10.1.0.0/16", exit2, peerB},
+		{"10.1.0.0/16", exit1, peerB}, // alternate after the routes
+		{"10.0.0.0/8", exit2, peerB},
+	}
+	if len(paths) != len(want) {
+		t.Fatalf("LookupPaths() = %v, want %d paths", paths, len(want))
+	}
+	for i, w := range want {
+		if paths[i].Network.String() != w.network || paths[i].OriginAgent != w.origin || paths[i].NextHop != w.nextHop {
+			t.Errorf("LookupPaths()[%d] = %v, want %s from %s via %s", i, paths[i], w.network, w.origin.ShortString(), w.nextHop.ShortString())
+		}
+	}
+	if best := table.Lookup(net.ParseIP("10.1.2.3")); best.OriginAgent != paths[0].OriginAgent || best.NextHop != paths[0].NextHop {
+		t.Errorf("LookupPaths()[0] = %v, want Lookup() result %v", paths[0], best)
+	}
+
+	if paths := table.LookupPaths(net.ParseIP("192.168.1.1")); len(paths) != 0 {
+		t.Errorf("LookupPaths() without a match = %v, want none", paths)
+	}
+}
+
 // ============================================================================
 // Manager Tests
 // ============================================================================
@@ -600,6 +650,121 @@ func TestManager_Subscribe(t *testing.T) {
 	}
 }
 
+func TestManager_PeerDisconnectNotifies(t *testing.T) {
+	localID, _ := identity.NewAgentID()
+	peer1, _ := identity.NewAgentID()
+	mgr := NewManager(localID)
+
+	mgr.ProcessRouteAdvertise(peer1, peer1, 1, []RouteEntry{
+		{Network: MustParseCIDR("172.16.0.0/12"), Metric: 1},
+	}, nil, nil)
+
+	ch := make(chan RouteChange, 10)
+	mgr.Subscribe(ch)
+
+	if n := mgr.HandlePeerDisconnect(peer1); n != 1 {
+		t.Fatalf("HandlePeerDisconnect removed %d routes, want 1", n)
+	}
+
+	select {
+	case change := <-ch:
+		if change.Type != RouteRemoved {
+			t.Errorf("Change type = %v, want RouteRemoved", change.Type)
+		}
+		if change.Route.Network.String() != "172.16.0.0/12" || change.Route.NextHop != peer1 {
+			t.Errorf("Change route = %s via %s", change.Route.Network, change.Route.NextHop.ShortString())
+		}
+		if change.Reason != ReasonPeerDown {
+			t.Errorf("Change reason = %q, want %q", change.Reason, ReasonPeerDown)
+		}
+	default:
+		t.Error("Should receive route removal notification")
+	}
+}
+
+func TestSelection_Update(t *testing.T) {
+	localID, _ := identity.NewAgentID()
+	peer1, _ := identity.NewAgentID()
+	peer2, _ := identity.NewAgentID()
+	mgr := NewManager(localID)
+	network := MustParseCIDR("10.0.0.0/8")
+
+	mgr.ProcessRouteAdvertise(peer1, peer1, 1, []RouteEntry{{Network: network, Metric: 3}}, nil, nil)
+	sel := NewSelection(mgr)
+	if routes := sel.Routes(); len(routes) != 1 || routes[0].OriginAgent != peer1 {
+		t.Fatalf("Routes() = %v, want the route from peer1", routes)
+	}
+
+	// A re-advertisement with a new sequence leaves the selection as it was
+	mgr.ProcessRouteAdvertise(peer1, peer1, 2, []RouteEntry{{Network: network, Metric: 3}}, nil, nil)
+	if d := sel.Update(network, ReasonAdvertise); d != nil {
+		t.Errorf("Update after re-advertisement = %+v, want nil", d)
+	}
+
+	// A worse route from another origin is not selected
+	mgr.ProcessRouteAdvertise(peer2, peer2, 1, []RouteEntry{{Network: network, Metric: 5}}, nil, nil)
+	if d := sel.Update(network, ReasonAdvertise); d != nil {
+		t.Errorf("Update after worse route = %+v, want nil", d)
+	}
+
+	mgr.ProcessRouteWithdraw(peer1, []RouteEntry{{Network: network}})
+	d := sel.Update(network, ReasonWithdraw)
+	if d == nil || d.Kind != DiffReplaced || d.Reason != ReasonWithdraw {
+		t.Fatalf("Update after withdraw = %+v, want replaced by withdraw", d)
+	}
+	if d.Previous.OriginAgent != peer1 || d.Route.OriginAgent != peer2 {
+		t.Errorf("Replaced %s with %s, want peer1 with peer2", d.Previous, d.Route)
+	}
+
+	mgr.HandlePeerDisconnect(peer2)
+	d = sel.Update(network, ReasonPeerDown)
+	if d == nil || d.Kind != DiffRemoved || d.Route != nil || d.Previous.OriginAgent != peer2 {
+		t.Fatalf("Update after peer down = %+v, want removal of the peer2 route", d)
+	}
+
+	mgr.AddLocalRoute(network, 0)
+	d = sel.Update(network, ReasonLocal)
+	if d == nil || d.Kind != DiffAdded || d.Previous != nil || d.Route.OriginAgent != localID {
+		t.Fatalf("Update after local route = %+v, want local route added", d)
+	}
+}
+
+func TestSelection_Resync(t *testing.T) {
+	localID, _ := identity.NewAgentID()
+	peerID, _ := identity.NewAgentID()
+	mgr := NewManager(localID)
+
+	mgr.ProcessRouteAdvertise(peerID, peerID, 1, []RouteEntry{
+		{Network: MustParseCIDR("10.0.0.0/8"), Metric: 1},
+		{Network: MustParseCIDR("172.16.0.0/12"), Metric: 1},
+	}, nil, nil)
+	sel := NewSelection(mgr)
+
+	if diffs := sel.Resync(); len(diffs) != 0 {
+		t.Fatalf("Resync of an unchanged table = %+v, want none", diffs)
+	}
+
+	// Expiry raises no route change; Resync finds it
+	mgr.table.RemoveRoutesFromPeer(peerID)
+	mgr.ProcessRouteAdvertise(peerID, peerID, 2, []RouteEntry{
+		{Network: MustParseCIDR("192.168.0.0/16"), Metric: 1},
+	}, nil, nil)
+
+	diffs := sel.Resync()
+	want := []string{"10.0.0.0/8 removed", "172.16.0.0/12 removed", "192.168.0.0/16 added"}
+	if len(diffs) != len(want) {
+		t.Fatalf("Resync returned %d diffs, want %d", len(diffs), len(want))
+	}
+	for i, d := range diffs {
+		if got := d.Network.String() + " " + d.Kind; got != want[i] {
+			t.Errorf("diff %d = %q, want %q", i, got, want[i])
+		}
+		if d.Reason != ReasonRefresh {
+			t.Errorf("diff %d reason = %q, want %q", i, d.Reason, ReasonRefresh)
+		}
+	}
+}
+
 func TestManager_GetRoutesToAdvertise(t *testing.T) {
 	localID, _ := identity.NewAgentID()
 	peer1, _ := identity.NewAgentID()
@@ -966,3 +1131,786 @@ func TestManager_IsDynamicRoute(t *testing.T) {
 		t.Error("IsDynamicRoute(nil) should be false")
 	}
 }
+
+// ============================================================================
+// Prefix Trie Tests
+// ============================================================================
+
+// linearLookup is the reference longest-prefix match over all routes.
+func linearLookup(routes []*Route, ip net.IP) *Route {
+	var best *Route
+	bestLen := -1
+	for _, r := range routes {
+		if !r.Network.Contains(ip) {
+			continue
+		}
+		ones, _ := r.Network.Mask.Size()
+		if ones > bestLen || (ones == bestLen && r.Metric < best.Metric) {
+			best, bestLen = r, ones
+		}
+	}
+	return best
+}
+
+func TestTable_Lookup_MatchesLinearScan(t *testing.T) {
+	localID, _ := identity.NewAgentID()
+	origin, _ := identity.NewAgentID()
+	table := NewTable(localID)
+	rng := rand.New(rand.NewSource(1))
+
+	added := make(map[string]*net.IPNet)
+	for i := 0; i < 2000; i++ {
+		ones := rng.Intn(33)
+		ip := net.IPv4(byte(rng.Intn(4)), byte(rng.Intn(256)), byte(rng.Intn(256)), byte(rng.Intn(256))).To4()
+		network := &net.IPNet{IP: ip.Mask(net.CIDRMask(ones, 32)), Mask: net.CIDRMask(ones, 32)}
+		table.AddRoute(&Route{Network: network, NextHop: origin, OriginAgent: origin, Metric: uint16(rng.Intn(10))})
+		added[network.String()] = network
+	}
+
+	// Remove a third of the prefixes to exercise trie pruning
+	i := 0
+	for key, network := range added {
+		if i%3 == 0 {
+			table.RemoveRoute(network, origin)
+			delete(added, key)
+		}
+		i++
+	}
+
+	all := table.GetAllRoutes()
+	for i := 0; i < 5000; i++ {
+		ip := net.IPv4(byte(rng.Intn(4)), byte(rng.Intn(256)), byte(rng.Intn(256)), byte(rng.Intn(256)))
+		want := linearLookup(all, ip)
+		got := table.Lookup(ip)
+		if (want == nil) != (got == nil) {
+			t.Fatalf("Lookup(%s) = %v, want %v", ip, got, want)
+		}
+		if want != nil && got.Network.String() != want.Network.String() {
+			t.Fatalf("Lookup(%s) = %s, want %s", ip, got.Network, want.Network)
+		}
+	}
+	if table.Size() != len(added) {
+		t.Errorf("Size() = %d, want %d", table.Size(), len(added))
+	}
+}
+
+func TestTable_Lookup_FamiliesSeparate(t *testing.T) {
+	localID, _ := identity.NewAgentID()
+	origin, _ := identity.NewAgentID()
+	table := NewTable(localID)
+
+	table.AddRoute(&Route{Network: MustParseCIDR("::/0"), NextHop: origin, OriginAgent: origin})
+
+	if r := table.Lookup(net.ParseIP("10.1.2.3")); r != nil {
+		t.Errorf("IPv6 default route matched IPv4 address: %v", r)
+	}
+	if r := table.Lookup(net.ParseIP("2001:db8::1")); r == nil {
+		t.Error("IPv6 default route did not match IPv6 address")
+	}
+
+	table.AddRoute(&Route{Network: MustParseCIDR("0.0.0.0/0"), NextHop: origin, OriginAgent: origin})
+	if r := table.Lookup(net.ParseIP("10.1.2.3")); r == nil || r.Network.String() != "0.0.0.0/0" {
+		t.Errorf("Lookup(10.1.2.3) = %v, want 0.0.0.0/0", r)
+	}
+}
+
+func TestTable_Lookup_AfterCleanupAndPeerRemoval(t *testing.T) {
+	localID, _ := identity.NewAgentID()
+	peer1, _ := identity.NewAgentID()
+	peer2, _ := identity.NewAgentID()
+	table := NewTable(localID)
+
+	table.AddRoute(&Route{Network: MustParseCIDR("10.0.0.0/8"), NextHop: peer1, OriginAgent: peer1, Metric: 1})
+	table.AddRoute(&Route{Network: MustParseCIDR("10.1.0.0/16"), NextHop: peer2, OriginAgent: peer2, Metric: 1})
+	table.AddRoute(&Route{Network: MustParseCIDR("10.1.0.0/16"), NextHop: peer1, OriginAgent: peer1, Metric: 2})
+
+	table.RemoveRoutesFromPeer(peer2)
+	r := table.Lookup(net.ParseIP("10.1.2.3"))
+	if r == nil || r.NextHop != peer1 || r.Network.String() != "10.1.0.0/16" {
+		t.Fatalf("Lookup after peer removal = %v, want 10.1.0.0/16 via peer1", r)
+	}
+
+	table.RemoveRoutesFromPeer(peer1)
+	if r := table.Lookup(net.ParseIP("10.1.2.3")); r != nil {
+		t.Errorf("Lookup after removing all routes = %v, want nil", r)
+	}
+	if table.index.v4 != nil {
+		t.Error("trie not pruned after removing all routes")
+	}
+}
+
+// buildTable fills a table with n IPv4 routes of mixed prefix lengths.
+func buildTable(n int) *Table {
+	localID, _ := identity.NewAgentID()
+	origin, _ := identity.NewAgentID()
+	table := NewTable(localID)
+	rng := rand.New(rand.NewSource(1))
+	for table.Size() < n {
+		ones := 8 + rng.Intn(25)
+		ip := net.IPv4(byte(rng.Intn(224)), byte(rng.Intn(256)), byte(rng.Intn(256)), byte(rng.Intn(256))).To4()
+		table.AddRoute(&Route{
+			Network:     &net.IPNet{IP: ip.Mask(net.CIDRMask(ones, 32)), Mask: net.CIDRMask(ones, 32)},
+			NextHop:     origin,
+			OriginAgent: origin,
+		})
+	}
+	return table
+}
+
+func BenchmarkTable_Lookup(b *testing.B) {
+	for _, n := range []int{100, 10000, 100000} {
+		b.Run(fmt.Sprintf("routes=%d", n), func(b *testing.B) {
+			table := buildTable(n)
+			rng := rand.New(rand.NewSource(2))
+			ips := make([]net.IP, 1024)
+			for i := range ips {
+				ips[i] = net.IPv4(byte(rng.Intn(224)), byte(rng.Intn(256)), byte(rng.Intn(256)), byte(rng.Intn(256)))
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				table.Lookup(ips[i%len(ips)])
+			}
+		})
+	}
+}
+
+func BenchmarkTable_AddRoute(b *testing.B) {
+	localID, _ := identity.NewAgentID()
+	origin, _ := identity.NewAgentID()
+	rng := rand.New(rand.NewSource(3))
+	networks := make([]*net.IPNet, 100000)
+	for i := range networks {
+		ones := 8 + rng.Intn(25)
+		ip := net.IPv4(byte(rng.Intn(224)), byte(rng.Intn(256)), byte(rng.Intn(256)), byte(rng.Intn(256))).To4()
+		networks[i] = &net.IPNet{IP: ip.Mask(net.CIDRMask(ones, 32)), Mask: net.CIDRMask(ones, 32)}
+	}
+	table := NewTable(localID)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		table.AddRoute(&Route{Network: networks[i%len(networks)], NextHop: origin, OriginAgent: origin, Sequence: uint64(i)})
+	}
+}
+
+// ============================================================================
+// Route Aggregation Tests
+// ============================================================================
+
+func aggregatedStrings(routes []*LocalRoute) []string {
+	out := make([]string, len(routes))
+	for i, r := range routes {
+		out[i] = fmt.Sprintf("%s:%d", r.Network, r.Metric)
+	}
+	return out
+}
+
+func TestAggregateRoutes(t *testing.T) {
+	tests := []struct {
+		name   string
+		routes []string // "cidr:metric"
+		groups []string
+		want   []string
+	}{
+		{
+			name: "full /16 of /24s",
+			routes: func() []string {
+				var r []string
+				for i := 0; i < 256; i++ {
+					r = append(r, fmt.Sprintf("10.1.%d.0/24:0", i))
+				}
+				return r
+			}(),
+			want: []string{"10.1.0.0/16:0"},
+		},
+		{
+			name:   "siblings with different metrics stay apart",
+			routes: []string{"10.1.0.0/24:0", "10.1.1.0/24:1"},
+			want:   []string{"10.1.0.0/24:0", "10.1.1.0/24:1"},
+		},
+		{
+			name:   "non-adjacent prefixes stay apart",
+			routes: []string{"10.1.1.0/24:0", "10.1.2.0/24:0"},
+			want:   []string{"10.1.1.0/24:0", "10.1.2.0/24:0"},
+		},
+		{
+			name:   "covered prefix dropped",
+			routes: []string{"10.0.0.0/8:0", "10.1.0.0/16:0", "10.2.0.0/16:1"},
+			want:   []string{"10.0.0.0/8:0"},
+		},
+		{
+			name:   "covered prefix with better metric kept",
+			routes: []string{"10.0.0.0/8:2", "10.1.0.0/16:1"},
+			want:   []string{"10.0.0.0/8:2", "10.1.0.0/16:1"},
+		},
+		{
+			name:   "summary limited to group",
+			routes: []string{"10.1.0.0/24:0", "10.1.1.0/24:0", "10.1.2.0/24:0", "10.1.3.0/24:0"},
+			groups: []string{"10.1.0.0/23"},
+			want:   []string{"10.1.0.0/23:0", "10.1.2.0/24:0", "10.1.3.0/24:0"},
+		},
+		{
+			name:   "IPv6 siblings",
+			routes: []string{"2001:db8::/33:0", "2001:db8:8000::/33:0"},
+			want:   []string{"2001:db8::/32:0"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var routes []*LocalRoute
+			for _, r := range tt.routes {
+				i := strings.LastIndex(r, ":")
+				metric, _ := strconv.Atoi(r[i+1:])
+				routes = append(routes, &LocalRoute{Network: MustParseCIDR(r[:i]), Metric: uint16(metric)})
+			}
+			var groups []*net.IPNet
+			for _, g := range tt.groups {
+				groups = append(groups, MustParseCIDR(g))
+			}
+
+			got := aggregatedStrings(AggregateRoutes(routes, groups))
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("AggregateRoutes() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestManager_GetAdvertisedLocalRoutes(t *testing.T) {
+	localID, _ := identity.NewAgentID()
+	m := NewManager(localID)
+	m.AddLocalRoute(MustParseCIDR("10.1.0.0/24"), 0)
+	m.AddLocalRoute(MustParseCIDR("10.1.1.0/24"), 0)
+
+	if n := len(m.GetAdvertisedLocalRoutes()); n != 2 {
+		t.Errorf("advertised %d routes without aggregation, want 2", n)
+	}
+
+	m.SetAggregation(nil)
+	routes := m.GetAdvertisedLocalRoutes()
+	if len(routes) != 1 || routes[0].Network.String() != "10.1.0.0/23" {
+		t.Errorf("advertised %v with aggregation, want [10.1.0.0/23]", aggregatedStrings(routes))
+	}
+
+	// The routing table keeps the original prefixes
+	if m.Size() != 2 {
+		t.Errorf("table size = %d, want 2", m.Size())
+	}
+}
+
+func TestTable_SetPathDown_FailsOver(t *testing.T) {
+	localID, _ := identity.NewAgentID()
+	peer1, _ := identity.NewAgentID()
+	peer2, _ := identity.NewAgentID()
+	exit1, _ := identity.NewAgentID()
+	exit2, _ := identity.NewAgentID()
+	table := NewTable(localID)
+
+	table.AddRoute(&Route{
+		Network:     MustParseCIDR("10.0.0.0/8"),
+		NextHop:     peer1,
+		OriginAgent: exit1,
+		Metric:      2,
+	})
+	table.AddRoute(&Route{
+		Network:     MustParseCIDR("10.0.0.0/8"),
+		NextHop:     peer2,
+		OriginAgent: exit2,
+		Metric:      5,
+	})
+
+	ip := net.ParseIP("10.1.2.3")
+	if r := table.Lookup(ip); r == nil || r.OriginAgent != exit1 {
+		t.Fatalf("Lookup() = %v, want route via exit1", r)
+	}
+
+	if n := table.SetPathDown(exit1, peer1, true); n != 1 {
+		t.Errorf("SetPathDown(down) changed %d routes, want 1", n)
+	}
+	if !table.IsPathDown(exit1, peer1) {
+		t.Error("IsPathDown() = false after marking path down")
+	}
+	r := table.Lookup(ip)
+	if r == nil || r.OriginAgent != exit2 {
+		t.Fatalf("Lookup() = %v, want failover to exit2", r)
+	}
+	if all := table.LookupAll(ip); len(all) != 1 || all[0].OriginAgent != exit2 {
+		t.Errorf("LookupAll() best = %v, want exit2", all)
+	}
+
+	// Unreachable routes are still used when nothing else matches
+	table.SetPathDown(exit2, peer2, true)
+	if r := table.Lookup(ip); r == nil || r.OriginAgent != exit1 || !r.Unreachable {
+		t.Errorf("Lookup() = %v, want unreachable route via exit1", r)
+	}
+
+	// Recovery restores the lower-metric path
+	table.SetPathDown(exit1, peer1, false)
+	if r := table.Lookup(ip); r == nil || r.OriginAgent != exit1 || r.Unreachable {
+		t.Errorf("Lookup() = %v, want reachable route via exit1", r)
+	}
+}
+
+func TestTable_SetPathDown_AppliesToNewRoutes(t *testing.T) {
+	localID, _ := identity.NewAgentID()
+	peer1, _ := identity.NewAgentID()
+	peer2, _ := identity.NewAgentID()
+	exit, _ := identity.NewAgentID()
+	table := NewTable(localID)
+
+	table.SetPathDown(exit, peer1, true)
+	table.AddRoute(&Route{
+		Network:     MustParseCIDR("192.168.0.0/16"),
+		NextHop:     peer1,
+		OriginAgent: exit,
+		Metric:      1,
+		Sequence:    1,
+	})
+	if r := table.GetRoute(MustParseCIDR("192.168.0.0/16")); r == nil || !r.Unreachable {
+		t.Errorf("route added on a down path should be unreachable, got %v", r)
+	}
+
+	// Re-learned through another next hop
+	table.AddRoute(&Route{
+		Network:     MustParseCIDR("192.168.0.0/16"),
+		NextHop:     peer2,
+		OriginAgent: exit,
+		Metric:      2,
+		Sequence:    2,
+	})
+	if r := table.GetRoute(MustParseCIDR("192.168.0.0/16")); r == nil || r.Unreachable {
+		t.Errorf("route via a new next hop should be reachable, got %v", r)
+	}
+
+	// Disconnecting the peer forgets its down paths
+	table.RemoveRoutesFromPeer(peer1)
+	if table.IsPathDown(exit, peer1) {
+		t.Error("IsPathDown() = true after peer routes were removed")
+	}
+}
+
+// ============================================================================
+// Fast Reroute Tests
+// ============================================================================
+
+func TestTable_FastReroute_FailoverToLoopFreeAlternate(t *testing.T) {
+	localID, _ := identity.NewAgentID()
+	peerA, _ := identity.NewAgentID()
+	peerB, _ := identity.NewAgentID()
+	peerC, _ := identity.NewAgentID()
+	exit, _ := identity.NewAgentID()
+	table := NewTable(localID)
+	table.SetFastReroute(true)
+
+	network := MustParseCIDR("10.0.0.0/8")
+	table.AddRoute(&Route{Network: network, NextHop: peerA, OriginAgent: exit, Metric: 2, Sequence: 1, Path: []identity.AgentID{peerA, exit}})
+
+	// Through B the path avoids A; through C it passes through A
+	if !table.AddAlternate(&Route{Network: network, NextHop: peerB, OriginAgent: exit, Metric: 3, Sequence: 1, Path: []identity.AgentID{peerB, exit}}) {
+		t.Fatal("AddAlternate via B should be stored")
+	}
+	if !table.AddAlternate(&Route{Network: network, NextHop: peerC, OriginAgent: exit, Metric: 3, Sequence: 1, Path: []identity.AgentID{peerC, peerA, exit}}) {
+		t.Fatal("AddAlternate via C should be stored")
+	}
+	if alts := table.GetAlternates(network); len(alts) != 2 {
+		t.Fatalf("GetAlternates() = %d entries, want 2", len(alts))
+	}
+
+	removed, rerouted := table.FailoverFromPeer(peerA)
+	if len(removed) != 0 || len(rerouted) != 1 {
+		t.Fatalf("FailoverFromPeer() removed %d, rerouted %d; want 0, 1", len(removed), len(rerouted))
+	}
+	r := table.Lookup(net.ParseIP("10.1.2.3"))
+	if r == nil || r.NextHop != peerB {
+		t.Fatalf("Lookup() after failover = %v, want next hop B", r)
+	}
+	if alts := table.GetAlternates(network); len(alts) != 1 || alts[0].NextHop != peerC {
+		t.Errorf("remaining alternates = %v, want only C", alts)
+	}
+
+	// With no alternate left that avoids B, the route is removed
+	removed, rerouted = table.FailoverFromPeer(peerB)
+	if len(removed) != 0 || len(rerouted) != 1 || rerouted[0].NextHop != peerC {
+		t.Fatalf("FailoverFromPeer(B) removed %d, rerouted %v; want C to take over", len(removed), rerouted)
+	}
+	removed, rerouted = table.FailoverFromPeer(peerC)
+	if len(removed) != 1 || len(rerouted) != 0 {
+		t.Errorf("FailoverFromPeer(C) removed %d, rerouted %d; want 1, 0", len(removed), len(rerouted))
+	}
+	if table.Size() != 0 {
+		t.Errorf("Size = %d, want 0", table.Size())
+	}
+}
+
+func TestTable_FastReroute_Alternates(t *testing.T) {
+	localID, _ := identity.NewAgentID()
+	peerA, _ := identity.NewAgentID()
+	peerB, _ := identity.NewAgentID()
+	exit, _ := identity.NewAgentID()
+	network := MustParseCIDR("10.0.0.0/8")
+	primary := &Route{Network: network, NextHop: peerA, OriginAgent: exit, Metric: 2, Sequence: 1, Path: []identity.AgentID{peerA, exit}}
+	alt := &Route{Network: network, NextHop: peerB, OriginAgent: exit, Metric: 3, Sequence: 1, Path: []identity.AgentID{peerB, exit}}
+
+	t.Run("disabled", func(t *testing.T) {
+		table := NewTable(localID)
+		table.AddRoute(primary)
+		if table.AddAlternate(alt) {
+			t.Error("AddAlternate should not store alternates when fast reroute is disabled")
+		}
+	})
+
+	t.Run("rejected", func(t *testing.T) {
+		table := NewTable(localID)
+		table.SetFastReroute(true)
+		if table.AddAlternate(alt) {
+			t.Error("alternate without a primary route should not be stored")
+		}
+		table.AddRoute(primary)
+		if table.AddAlternate(&Route{Network: network, NextHop: peerA, OriginAgent: exit, Metric: 2, Sequence: 1, Path: []identity.AgentID{peerA, exit}}) {
+			t.Error("alternate through the primary next hop should not be stored")
+		}
+		if table.AddAlternate(&Route{Network: network, NextHop: peerB, OriginAgent: exit, Metric: 3, Sequence: 1}) {
+			t.Error("alternate without a path should not be stored")
+		}
+		if table.AddAlternate(&Route{Network: network, NextHop: peerB, OriginAgent: exit, Metric: 3, Sequence: 1, Path: []identity.AgentID{peerB, localID, exit}}) {
+			t.Error("alternate looping through the local agent should not be stored")
+		}
+	})
+
+	t.Run("replaced primary becomes alternate", func(t *testing.T) {
+		table := NewTable(localID)
+		table.SetFastReroute(true)
+		table.AddRoute(primary)
+		table.AddRoute(&Route{Network: network, NextHop: peerB, OriginAgent: exit, Metric: 3, Sequence: 2, Path: []identity.AgentID{peerB, exit}})
+		alts := table.GetAlternates(network)
+		if len(alts) != 1 || alts[0].NextHop != peerA {
+			t.Fatalf("alternates = %v, want the previous route via A", alts)
+		}
+	})
+
+	t.Run("withdrawal clears alternates", func(t *testing.T) {
+		table := NewTable(localID)
+		table.SetFastReroute(true)
+		table.AddRoute(primary)
+		table.AddAlternate(alt)
+		table.RemoveRoute(network, exit)
+		if alts := table.GetAlternates(network); len(alts) != 0 {
+			t.Errorf("alternates after withdrawal = %v, want none", alts)
+		}
+	})
+
+	t.Run("stale alternates expire", func(t *testing.T) {
+		table := NewTable(localID)
+		table.SetFastReroute(true)
+		table.AddRoute(primary)
+		table.AddAlternate(alt)
+		table.CleanupStaleRoutes(-time.Second)
+		if alts := table.GetAlternates(network); len(alts) != 0 {
+			t.Errorf("alternates after cleanup = %v, want none", alts)
+		}
+	})
+
+	t.Run("unreachable alternate not used", func(t *testing.T) {
+		table := NewTable(localID)
+		table.SetFastReroute(true)
+		table.AddRoute(primary)
+		table.AddAlternate(alt)
+		table.SetPathDown(exit, peerB, true)
+		if removed, rerouted := table.FailoverFromPeer(peerA); len(removed) != 1 || len(rerouted) != 0 {
+			t.Errorf("FailoverFromPeer() removed %d, rerouted %d; want 1, 0", len(removed), len(rerouted))
+		}
+	})
+}
+
+func TestManager_PeerDisconnectReroutes(t *testing.T) {
+	localID, _ := identity.NewAgentID()
+	peerA, _ := identity.NewAgentID()
+	peerB, _ := identity.NewAgentID()
+	exit, _ := identity.NewAgentID()
+	mgr := NewManager(localID)
+	mgr.SetFastReroute(true)
+
+	entries := []RouteEntry{{Network: MustParseCIDR("10.0.0.0/8"), Metric: 1}}
+	mgr.ProcessRouteAdvertise(peerA, exit, 1, entries, []identity.AgentID{peerA, exit}, nil)
+	if n := mgr.ProcessAlternateAdvertise(peerB, exit, 1, entries, []identity.AgentID{peerB, exit}, nil); n != 1 {
+		t.Fatalf("ProcessAlternateAdvertise() = %d, want 1", n)
+	}
+
+	ch := make(chan RouteChange, 10)
+	mgr.Subscribe(ch)
+
+	if n := mgr.HandlePeerDisconnect(peerA); n != 1 {
+		t.Fatalf("HandlePeerDisconnect() = %d, want 1", n)
+	}
+	if nextHop, ok := mgr.LookupNextHop(net.ParseIP("10.1.2.3")); !ok || nextHop != peerB {
+		t.Errorf("LookupNextHop() = %s, %v; want B", nextHop.ShortString(), ok)
+	}
+
+	select {
+	case change := <-ch:
+		if change.Type != RouteUpdated || change.Route.NextHop != peerB {
+			t.Errorf("change = %v via %s, want RouteUpdated via B", change.Type, change.Route.NextHop.ShortString())
+		}
+	default:
+		t.Error("Should receive route update notification")
+	}
+}
+
+func TestManager_PreferTags(t *testing.T) {
+	localID, _ := identity.NewAgentID()
+	peerA, _ := identity.NewAgentID()
+	peerB, _ := identity.NewAgentID()
+	exitA, _ := identity.NewAgentID()
+	exitB, _ := identity.NewAgentID()
+	mgr := NewManager(localID)
+
+	defaultRoute := []RouteEntry{{Network: MustParseCIDR("0.0.0.0/0"), Metric: 1}}
+	mgr.ProcessRouteAdvertise(peerA, exitA, 1, defaultRoute, []identity.AgentID{peerA, exitA}, nil)
+	mgr.ProcessRouteAdvertise(peerB, exitB, 1, []RouteEntry{{Network: MustParseCIDR("0.0.0.0/0"), Metric: 5}}, []identity.AgentID{peerB, exitB}, nil)
+	mgr.SetNodeInfo(exitA, &protocol.NodeInfo{Tags: []string{"exit:dc-eu"}}, 1)
+	mgr.SetNodeInfo(exitB, &protocol.NodeInfo{Tags: []string{"exit:residential"}}, 1)
+
+	ip := net.ParseIP("198.51.100.7")
+	if r := mgr.Lookup(ip); r == nil || r.OriginAgent != exitA {
+		t.Fatalf("without preference Lookup() = %v, want the lower metric via A", r)
+	}
+
+	mgr.SetPreferTags([]string{"exit:residential", "exit:dc-eu"})
+	if r := mgr.Lookup(ip); r == nil || r.OriginAgent != exitB {
+		t.Errorf("Lookup() = %v, want the residential exit", r)
+	}
+	if r := mgr.GetRoute(MustParseCIDR("0.0.0.0/0")); r == nil || r.OriginAgent != exitB {
+		t.Errorf("GetRoute() = %v, want the residential exit", r)
+	}
+	if paths := mgr.LookupPaths(ip); len(paths) != 2 || paths[0].OriginAgent != exitB || paths[1].OriginAgent != exitA {
+		t.Errorf("LookupPaths() = %v, want residential then dc-eu", paths)
+	}
+
+	// A longer prefix still wins over a preferred exit
+	mgr.ProcessRouteAdvertise(peerA, exitA, 2, []RouteEntry{{Network: MustParseCIDR("198.51.100.0/24"), Metric: 1}}, []identity.AgentID{peerA, exitA}, nil)
+	if r := mgr.Lookup(ip); r == nil || r.OriginAgent != exitA {
+		t.Errorf("Lookup() = %v, want the /24 via A", r)
+	}
+
+	// Reachable routes win over a preferred exit behind a failed path
+	mgr.Table().SetPathDown(exitB, peerB, true)
+	if r := mgr.GetRoute(MustParseCIDR("0.0.0.0/0")); r == nil || r.OriginAgent != exitA {
+		t.Errorf("GetRoute() = %v, want the reachable dc-eu exit", r)
+	}
+}
+
+func TestManager_Namespaces(t *testing.T) {
+	localID, _ := identity.NewAgentID()
+	peer, _ := identity.NewAgentID()
+	exitA, _ := identity.NewAgentID()
+	exitB, _ := identity.NewAgentID()
+	mgr := NewManager(localID)
+
+	path := func(exit identity.AgentID) []identity.AgentID { return []identity.AgentID{peer, exit} }
+	mgr.ProcessRouteAdvertise(peer, exitA, 1, []RouteEntry{{Network: MustParseCIDR("0.0.0.0/0"), Metric: 1}}, path(exitA), nil)
+	mgr.ProcessRouteAdvertise(peer, exitB, 1, []RouteEntry{{Network: MustParseCIDR("0.0.0.0/0"), Metric: 5}}, path(exitB), nil)
+	mgr.ProcessDomainRouteAdvertise(peer, exitA, 1, []DomainRouteEntry{{Pattern: "db.internal", Metric: 1}}, path(exitA), nil)
+	mgr.ProcessDomainRouteAdvertise(peer, exitB, 1, []DomainRouteEntry{{Pattern: "*.internal", IsWildcard: true, Metric: 1}}, path(exitB), nil)
+	mgr.ProcessForwardRouteAdvertise(peer, exitA, 1, []ForwardRouteEntry{{Key: "web", Target: "10.0.0.1:80", Metric: 1}}, path(exitA), nil)
+	mgr.ProcessForwardRouteAdvertise(peer, exitB, 1, []ForwardRouteEntry{{Key: "web", Target: "10.9.0.1:80", Metric: 5}}, path(exitB), nil)
+
+	ip := net.ParseIP("198.51.100.7")
+	if r := mgr.LookupIn(ip, ""); r == nil || r.OriginAgent != exitA {
+		t.Fatalf("LookupIn(default) without namespaces = %v, want A", r)
+	}
+	if r := mgr.LookupIn(ip, "acme"); r != nil {
+		t.Errorf("LookupIn(acme) without namespaces = %v, want nil", r)
+	}
+
+	mgr.SetOriginNamespace(exitB, "acme")
+	if got := mgr.OriginNamespace(exitB); got != "acme" {
+		t.Errorf("OriginNamespace(B) = %q, want acme", got)
+	}
+	if r := mgr.LookupIn(ip, "acme"); r == nil || r.OriginAgent != exitB {
+		t.Errorf("LookupIn(acme) = %v, want B", r)
+	}
+	if paths := mgr.LookupPathsIn(ip, ""); len(paths) != 1 || paths[0].OriginAgent != exitA {
+		t.Errorf("LookupPathsIn(default) = %v, want only A", paths)
+	}
+
+	// The exact match of another namespace does not hide the wildcard
+	if r := mgr.LookupDomainIn("db.internal", "acme"); r == nil || r.OriginAgent != exitB {
+		t.Errorf("LookupDomainIn(acme) = %v, want the wildcard of B", r)
+	}
+	if r := mgr.LookupDomainIn("db.internal", ""); r == nil || r.OriginAgent != exitA {
+		t.Errorf("LookupDomainIn(default) = %v, want the exact route of A", r)
+	}
+	if r := mgr.LookupForwardIn("web", "acme"); r == nil || r.Target != "10.9.0.1:80" {
+		t.Errorf("LookupForwardIn(acme) = %v, want the endpoint of B", r)
+	}
+	if r := mgr.LookupForwardIn("web", "other"); r != nil {
+		t.Errorf("LookupForwardIn(other) = %v, want nil", r)
+	}
+
+	// A later advertisement in the default namespace moves the origin back
+	mgr.SetOriginNamespace(exitB, "")
+	if r := mgr.LookupIn(ip, "acme"); r != nil {
+		t.Errorf("LookupIn(acme) after move = %v, want nil", r)
+	}
+
+	mgr.SetLocalNamespace("acme")
+	if got := mgr.OriginNamespace(localID); got != "acme" {
+		t.Errorf("OriginNamespace(local) = %q, want acme", got)
+	}
+}
+
+// ============================================================================
+// Metric Mode Tests
+// ============================================================================
+
+func TestParseMetricMode(t *testing.T) {
+	for _, tt := range []struct {
+		in   string
+		want MetricMode
+	}{{"", MetricHops}, {"hops", MetricHops}, {"latency", MetricLatency}, {"hybrid", MetricHybrid}} {
+		got, err := ParseMetricMode(tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("ParseMetricMode(%q) = %v, %v; want %v", tt.in, got, err, tt.want)
+		}
+	}
+	if _, err := ParseMetricMode("rtt"); err == nil {
+		t.Error("ParseMetricMode(\"rtt\") should fail")
+	}
+}
+
+func TestTable_MetricLatency_PrefersFastLinks(t *testing.T) {
+	localID, _ := identity.NewAgentID()
+	slowPeer, _ := identity.NewAgentID()
+	fastPeer, _ := identity.NewAgentID()
+	exitA, _ := identity.NewAgentID()
+	exitB, _ := identity.NewAgentID()
+	table := NewTable(localID)
+
+	network := MustParseCIDR("0.0.0.0/0")
+	ip := net.ParseIP("203.0.113.1")
+	table.AddRoute(&Route{Network: network, NextHop: slowPeer, OriginAgent: exitA, Metric: 1, Sequence: 1})
+	table.AddRoute(&Route{Network: network, NextHop: fastPeer, OriginAgent: exitB, Metric: 2, Sequence: 1})
+
+	rtts := map[identity.AgentID]time.Duration{slowPeer: 400 * time.Millisecond, fastPeer: 5 * time.Millisecond}
+	if n := table.UpdatePeerRTTs(rtts); n != 0 {
+		t.Errorf("UpdatePeerRTTs() with hop metric = %d, want 0", n)
+	}
+	if r := table.Lookup(ip); r == nil || r.OriginAgent != exitA {
+		t.Fatalf("hops Lookup() = %v, want the 1-hop exit", r)
+	}
+
+	table.SetMetricMode(MetricHybrid)
+	if n := table.UpdatePeerRTTs(rtts); n != 1 {
+		t.Errorf("UpdatePeerRTTs() = %d, want 1", n)
+	}
+	if r := table.Lookup(ip); r == nil || r.OriginAgent != exitB {
+		t.Errorf("hybrid Lookup() = %v, want the 2-hop exit over fast links", r)
+	}
+	table.SetMetricMode(MetricLatency)
+	if r := table.Lookup(ip); r == nil || r.OriginAgent != exitB {
+		t.Errorf("latency Lookup() = %v, want the 2-hop exit over fast links", r)
+	}
+
+	// Jitter is ignored, and a small advantage does not switch routes back
+	table.UpdatePeerRTTs(map[identity.AgentID]time.Duration{slowPeer: 30 * time.Millisecond, fastPeer: 5 * time.Millisecond})
+	if rtt, _ := table.PeerRTT(slowPeer); rtt != 30*time.Millisecond {
+		t.Fatalf("PeerRTT(slow) = %v, want 30ms", rtt)
+	}
+	if n := table.UpdatePeerRTTs(map[identity.AgentID]time.Duration{slowPeer: 12 * time.Millisecond, fastPeer: 7 * time.Millisecond}); n != 0 {
+		t.Errorf("UpdatePeerRTTs() within the switch margin = %d, want 0", n)
+	}
+	if rtt, _ := table.PeerRTT(fastPeer); rtt != 5*time.Millisecond {
+		t.Errorf("PeerRTT(fast) = %v, want the 5ms sample kept", rtt)
+	}
+	if r := table.Lookup(ip); r == nil || r.OriginAgent != exitB {
+		t.Errorf("Lookup() = %v, want the current route kept", r)
+	}
+	if n := table.UpdatePeerRTTs(map[identity.AgentID]time.Duration{slowPeer: 2 * time.Millisecond, fastPeer: 40 * time.Millisecond}); n != 1 {
+		t.Errorf("UpdatePeerRTTs() = %d, want 1", n)
+	}
+	if r := table.Lookup(ip); r == nil || r.OriginAgent != exitA {
+		t.Errorf("Lookup() = %v, want the 1-hop exit once clearly faster", r)
+	}
+}
+
+func TestTable_MetricLatency_PromotesAlternate(t *testing.T) {
+	localID, _ := identity.NewAgentID()
+	slowPeer, _ := identity.NewAgentID()
+	fastPeer, _ := identity.NewAgentID()
+	exit, _ := identity.NewAgentID()
+	table := NewTable(localID)
+	table.SetFastReroute(true)
+	table.SetMetricMode(MetricLatency)
+	table.UpdatePeerRTTs(map[identity.AgentID]time.Duration{slowPeer: 400 * time.Millisecond, fastPeer: 5 * time.Millisecond})
+
+	network := MustParseCIDR("10.0.0.0/8")
+	table.AddRoute(&Route{Network: network, NextHop: slowPeer, OriginAgent: exit, Metric: 1, Sequence: 1, Path: []identity.AgentID{exit}})
+	if !table.AddAlternate(&Route{Network: network, NextHop: fastPeer, OriginAgent: exit, Metric: 2, Sequence: 1, Path: []identity.AgentID{fastPeer, exit}}) {
+		t.Fatal("AddAlternate() via the fast peer should be stored")
+	}
+	if r := table.Lookup(net.ParseIP("10.1.2.3")); r == nil || r.NextHop != fastPeer {
+		t.Fatalf("Lookup() = %v, want next hop via the fast peer", r)
+	}
+	if alts := table.GetAlternates(network); len(alts) != 1 || alts[0].NextHop != slowPeer {
+		t.Errorf("GetAlternates() = %v, want the slow path kept as alternate", alts)
+	}
+}
+
+func TestManager_SuspendLocalRoutes(t *testing.T) {
+	localID, _ := identity.NewAgentID()
+	m := NewManager(localID)
+	m.AddLocalRoute(MustParseCIDR("10.1.0.0/24"), 0)
+	m.AddLocalDomainRoute("*.example.com", 0)
+
+	withdrawn, ok := m.SuspendLocalRoutes()
+	if !ok || len(withdrawn) != 1 || withdrawn[0].Network.String() != "10.1.0.0/24" {
+		t.Fatalf("SuspendLocalRoutes() = %v, %v, want [10.1.0.0/24], true", aggregatedStrings(withdrawn), ok)
+	}
+	if _, ok := m.SuspendLocalRoutes(); ok {
+		t.Error("second SuspendLocalRoutes() = true, want false")
+	}
+	if !m.LocalRoutesSuspended() {
+		t.Error("LocalRoutesSuspended() = false, want true")
+	}
+
+	// Routes are out of the tables and advertisements but stay configured
+	if r := m.Lookup(net.ParseIP("10.1.0.5")); r != nil {
+		t.Errorf("Lookup while suspended = %v, want nil", r)
+	}
+	if r := m.LookupDomain("www.example.com"); r != nil {
+		t.Errorf("LookupDomain while suspended = %v, want nil", r)
+	}
+	if n := len(m.GetAdvertisedLocalRoutes()); n != 0 {
+		t.Errorf("advertised %d CIDR routes while suspended, want 0", n)
+	}
+	if n := len(m.GetAdvertisedLocalDomainRoutes()); n != 0 {
+		t.Errorf("advertised %d domain routes while suspended, want 0", n)
+	}
+	if n := len(m.GetLocalRoutes()); n != 1 {
+		t.Errorf("GetLocalRoutes while suspended = %d routes, want 1", n)
+	}
+
+	// Routes added while suspended wait for the resume
+	m.AddLocalRoute(MustParseCIDR("10.2.0.0/24"), 0)
+	if m.Size() != 0 {
+		t.Errorf("table size while suspended = %d, want 0", m.Size())
+	}
+
+	if !m.ResumeLocalRoutes() {
+		t.Fatal("ResumeLocalRoutes() = false, want true")
+	}
+	if m.ResumeLocalRoutes() {
+		t.Error("second ResumeLocalRoutes() = true, want false")
+	}
+	if m.Size() != 2 {
+		t.Errorf("table size after resume = %d, want 2", m.Size())
+	}
+	if r := m.Lookup(net.ParseIP("10.2.0.5")); r == nil || r.OriginAgent != localID {
+		t.Errorf("Lookup after resume = %v, want local route", r)
+	}
+	if r := m.LookupDomain("www.example.com"); r == nil {
+		t.Error("LookupDomain after resume = nil, want local route")
+	}
+	if n := len(m.GetAdvertisedLocalRoutes()); n != 2 {
+		t.Errorf("advertised %d CIDR routes after resume, want 2", n)
+	}
+}
@@ -0,0 +1,143 @@
+package routing
+
+import (
+	"net"
+	"sort"
+)
+
+// Kinds of RouteDiff.
+const (
+	DiffAdded    = "added"    // A network became reachable
+	DiffRemoved  = "removed"  // A network is no longer reachable
+	DiffReplaced = "replaced" // Traffic to a network takes another route
+)
+
+// ReasonRefresh marks diffs found by Selection.Resync rather than caused by
+// a RouteChange: route expiry, path probes and RTT metric updates.
+const ReasonRefresh = "refresh"
+
+// RouteDiff is a change of the route selected for a network.
+type RouteDiff struct {
+	Kind     string
+	Network  *net.IPNet
+	Route    *Route // Selected route, nil when removed
+	Previous *Route // Previously selected route, nil when added
+	Reason   string
+}
+
+// Selection tracks the route the manager selects for each network, so that
+// changes of the best route can be reported as diffs. Changes to routes
+// that are not selected, and re-advertisements that leave the selected
+// route as it was, produce no diff. It is not safe for concurrent use.
+type Selection struct {
+	m        *Manager
+	selected map[string]*Route
+}
+
+// NewSelection returns a Selection holding the current best routes of m.
+func NewSelection(m *Manager) *Selection {
+	s := &Selection{m: m, selected: make(map[string]*Route)}
+	for _, network := range s.networks() {
+		if r := m.GetRoute(network); r != nil {
+			s.selected[network.String()] = r
+		}
+	}
+	return s
+}
+
+// Update re-evaluates the selection for network after a change. Returns
+// nil when the selected route did not change.
+func (s *Selection) Update(network *net.IPNet, reason string) *RouteDiff {
+	if network == nil {
+		return nil
+	}
+	key := network.String()
+	prev := s.selected[key]
+	cur := s.m.GetRoute(network)
+
+	diff := &RouteDiff{Network: network, Route: cur, Previous: prev, Reason: reason}
+	switch {
+	case prev == nil && cur == nil:
+		return nil
+	case prev == nil:
+		diff.Kind = DiffAdded
+	case cur == nil:
+		diff.Kind = DiffRemoved
+	case sameSelection(prev, cur):
+		s.selected[key] = cur
+		return nil
+	default:
+		diff.Kind = DiffReplaced
+	}
+
+	if cur == nil {
+		delete(s.selected, key)
+	} else {
+		s.selected[key] = cur
+	}
+	return diff
+}
+
+// Resync re-evaluates every network, catching selection changes that raise
+// no RouteChange. Diffs are sorted by network and carry ReasonRefresh.
+func (s *Selection) Resync() []RouteDiff {
+	networks := s.networks()
+	seen := make(map[string]bool, len(networks))
+	for _, network := range networks {
+		seen[network.String()] = true
+	}
+	for _, r := range s.selected {
+		if !seen[r.Network.String()] {
+			networks = append(networks, r.Network)
+		}
+	}
+	sortNetworks(networks)
+
+	var diffs []RouteDiff
+	for _, network := range networks {
+		if d := s.Update(network, ReasonRefresh); d != nil {
+			diffs = append(diffs, *d)
+		}
+	}
+	return diffs
+}
+
+// Routes returns the selected routes sorted by network.
+func (s *Selection) Routes() []*Route {
+	routes := make([]*Route, 0, len(s.selected))
+	for _, r := range s.selected {
+		routes = append(routes, r)
+	}
+	sort.Slice(routes, func(i, j int) bool {
+		return routes[i].Network.String() < routes[j].Network.String()
+	})
+	return routes
+}
+
+// networks returns the distinct networks of the routing table.
+func (s *Selection) networks() []*net.IPNet {
+	seen := make(map[string]bool)
+	var networks []*net.IPNet
+	for _, r := range s.m.table.GetAllRoutes() {
+		key := r.Network.String()
+		if !seen[key] {
+			seen[key] = true
+			networks = append(networks, r.Network)
+		}
+	}
+	return networks
+}
+
+// sameSelection reports whether two selected routes send traffic the same
+// way. Sequence and refresh time changes are ignored.
+func sameSelection(a, b *Route) bool {
+	return a.OriginAgent == b.OriginAgent && a.NextHop == b.NextHop &&
+		a.Metric == b.Metric && a.Unreachable == b.Unreachable
+}
+
+// sortNetworks sorts networks by their string form.
+func sortNetworks(networks []*net.IPNet) {
+	sort.Slice(networks, func(i, j int) bool {
+		return networks[i].String() < networks[j].String()
+	})
+}
@@ -0,0 +1,119 @@
+package routing
+
+// SuspendLocalRoutes takes the local CIDR and domain routes out of the
+// routing tables and advertisements, for an exit that cannot currently reach
+// its destinations. The routes stay configured: routes added while suspended
+// are recorded, and ResumeLocalRoutes puts them all back. Forward and agent
+// presence routes are not affected.
+//
+// Returns the CIDR routes as they were advertised, to be withdrawn from
+// peers, and false if the routes were already suspended.
+func (m *Manager) SuspendLocalRoutes() ([]*LocalRoute, bool) {
+	if m.LocalRoutesSuspended() {
+		return nil, false
+	}
+	advertised := m.GetAdvertisedLocalRoutes()
+
+	m.mu.Lock()
+	if m.suspended {
+		m.mu.Unlock()
+		return nil, false
+	}
+	m.suspended = true
+	routes := make([]*LocalRoute, 0, len(m.localRoutes))
+	for _, lr := range m.localRoutes {
+		routes = append(routes, lr)
+	}
+	patterns := make([]string, 0, len(m.localDomains))
+	for pattern := range m.localDomains {
+		patterns = append(patterns, pattern)
+	}
+	m.mu.Unlock()
+
+	for _, lr := range routes {
+		if m.table.RemoveRoute(lr.Network, m.localID) {
+			m.notifyChange(RouteChange{
+				Type:   RouteRemoved,
+				Reason: ReasonSuspend,
+				Route: &Route{
+					Network:     lr.Network,
+					OriginAgent: m.localID,
+				},
+			})
+		}
+	}
+	for _, pattern := range patterns {
+		m.domainTable.RemoveRoute(pattern, m.localID)
+	}
+
+	return advertised, true
+}
+
+// ResumeLocalRoutes returns the local CIDR and domain routes taken out by
+// SuspendLocalRoutes to the routing tables and advertisements. Returns false
+// if the routes were not suspended.
+func (m *Manager) ResumeLocalRoutes() bool {
+	m.mu.Lock()
+	if !m.suspended {
+		m.mu.Unlock()
+		return false
+	}
+	m.suspended = false
+	m.sequence++
+	seq := m.sequence
+
+	routes := make([]*Route, 0, len(m.localRoutes))
+	for _, lr := range m.localRoutes {
+		routes = append(routes, &Route{
+			Network:     lr.Network,
+			NextHop:     m.localID,
+			OriginAgent: m.localID,
+			Metric:      lr.Metric,
+			Sequence:    seq,
+		})
+	}
+	domains := make([]*DomainRoute, 0, len(m.localDomains))
+	for _, dr := range m.localDomains {
+		domains = append(domains, &DomainRoute{
+			Pattern:     dr.Pattern,
+			IsWildcard:  dr.IsWildcard,
+			BaseDomain:  dr.BaseDomain,
+			NextHop:     m.localID,
+			OriginAgent: m.localID,
+			Metric:      dr.Metric,
+			Sequence:    seq,
+		})
+	}
+	m.mu.Unlock()
+
+	for _, route := range routes {
+		if m.table.AddRoute(route) {
+			m.notifyChange(RouteChange{
+				Type:   RouteAdded,
+				Route:  route.Clone(),
+				Reason: ReasonResume,
+			})
+		}
+	}
+	for _, route := range domains {
+		m.domainTable.AddRoute(route)
+	}
+	return true
+}
+
+// LocalRoutesSuspended reports whether the local CIDR and domain routes are
+// suspended.
+func (m *Manager) LocalRoutesSuspended() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.suspended
+}
+
+// GetAdvertisedLocalDomainRoutes returns the local domain routes to
+// advertise: none while the local routes are suspended.
+func (m *Manager) GetAdvertisedLocalDomainRoutes() []*LocalDomainRoute {
+	if m.LocalRoutesSuspended() {
+		return nil
+	}
+	return m.GetLocalDomainRoutes()
+}
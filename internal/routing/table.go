@@ -4,6 +4,7 @@ package routing
 import (
 	"fmt"
 	"net"
+	"slices"
 	"sort"
 	"sync"
 	"time"
@@ -37,6 +38,16 @@ type Route struct {
 
 	// LastUpdate is when this route was last added or refreshed
 	LastUpdate time.Time
+
+	// Unreachable is set when path probes to OriginAgent via NextHop fail.
+	// Unreachable routes are only used when no reachable alternative exists.
+	Unreachable bool
+}
+
+// PathKey identifies the path to an origin agent through a next hop.
+type PathKey struct {
+	Origin  identity.AgentID
+	NextHop identity.AgentID
 }
 
 // String returns a human-readable representation of the route.
@@ -54,6 +65,7 @@ func (r *Route) Clone() *Route {
 		Metric:      r.Metric,
 		Sequence:    r.Sequence,
 		LastUpdate:  r.LastUpdate,
+		Unreachable: r.Unreachable,
 	}
 	copy(clone.Network.IP, r.Network.IP)
 	copy(clone.Network.Mask, r.Network.Mask)
@@ -72,12 +84,33 @@ func (r *Route) Clone() *Route {
 }
 
 // Table is a thread-safe routing table with longest-prefix match support.
+// Route entries are stored per prefix, with one entry per origin agent, and
+// indexed by a prefix trie so lookups do not scan the whole table.
 type Table struct {
 	mu sync.RWMutex
 
 	// routes maps CIDR string to route entries (may have multiple routes per prefix)
 	routes map[string][]*Route
 
+	// index finds the keys of routes matching an address by longest-prefix match
+	index prefixTrie
+
+	// down holds paths that failed liveness probes
+	down map[PathKey]struct{}
+
+	// alternates maps CIDR string to routes to the same origins through
+	// other next hops, kept for fast reroute (see AddAlternate)
+	alternates map[string][]*Route
+
+	// fastReroute keeps replaced routes as alternates
+	fastReroute bool
+
+	// metricMode selects how routes are compared (see SetMetricMode)
+	metricMode MetricMode
+
+	// rtt holds the peer RTTs used for effective costs (see UpdatePeerRTTs)
+	rtt map[identity.AgentID]time.Duration
+
 	// localID is this agent's ID (for loop detection)
 	localID identity.AgentID
 }
@@ -85,8 +118,22 @@ type Table struct {
 // NewTable creates a new routing table.
 func NewTable(localID identity.AgentID) *Table {
 	return &Table{
-		routes:  make(map[string][]*Route),
-		localID: localID,
+		routes:     make(map[string][]*Route),
+		down:       make(map[PathKey]struct{}),
+		alternates: make(map[string][]*Route),
+		rtt:        make(map[identity.AgentID]time.Duration),
+		localID:    localID,
+	}
+}
+
+// SetFastReroute enables keeping alternate next hops for learned routes.
+// Disabling it drops all stored alternates.
+func (t *Table) SetFastReroute(enabled bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.fastReroute = enabled
+	if !enabled {
+		t.alternates = make(map[string][]*Route)
 	}
 }
 
@@ -117,10 +164,15 @@ func (t *Table) AddRoute(route *Route) bool {
 			// Update if newer sequence or better metric
 			if route.Sequence > r.Sequence ||
 				(route.Sequence == r.Sequence && route.Metric < r.Metric) {
-				cloned := route.Clone()
-				cloned.LastUpdate = now
+				cloned := t.cloneForInsert(route, now)
 				t.routes[key][i] = cloned
 				t.sortRoutes(key)
+				if t.fastReroute {
+					t.removeAlternate(key, route.OriginAgent, route.NextHop)
+					if r.NextHop != route.NextHop {
+						t.storeAlternate(key, r)
+					}
+				}
 				return true
 			}
 			return false // Older/worse route
@@ -128,19 +180,95 @@ func (t *Table) AddRoute(route *Route) bool {
 	}
 
 	// New route from this origin
-	cloned := route.Clone()
-	cloned.LastUpdate = now
+	cloned := t.cloneForInsert(route, now)
+	if len(existing) == 0 {
+		t.index.insert(cloned.Network, key)
+	}
 	t.routes[key] = append(t.routes[key], cloned)
 	t.sortRoutes(key)
 	return true
 }
 
-// sortRoutes sorts routes for a key by metric (lowest first).
+// cloneForInsert copies route for storage, stamping it with now and the
+// current liveness of its path.
+func (t *Table) cloneForInsert(route *Route, now time.Time) *Route {
+	cloned := route.Clone()
+	cloned.LastUpdate = now
+	_, cloned.Unreachable = t.down[PathKey{Origin: route.OriginAgent, NextHop: route.NextHop}]
+	return cloned
+}
+
+// sortRoutes sorts routes for a key by reachability, then by metric (lowest
+// first). With MetricLatency and MetricHybrid the current best route stays
+// first unless another one is clearly better, to avoid oscillation.
 func (t *Table) sortRoutes(key string) {
 	routes := t.routes[key]
-	sort.Slice(routes, func(i, j int) bool {
-		return routes[i].Metric < routes[j].Metric
+	if len(routes) < 2 {
+		return
+	}
+	current := routes[0].OriginAgent
+	sort.SliceStable(routes, func(i, j int) bool {
+		return t.better(routes[i], routes[j])
 	})
+	if t.metricMode == MetricHops || routes[0].OriginAgent == current {
+		return
+	}
+	for i, r := range routes {
+		if r.OriginAgent == current {
+			if !t.clearlyBetter(routes[0], r) {
+				copy(routes[1:i+1], routes[:i])
+				routes[0] = r
+			}
+			break
+		}
+	}
+}
+
+// SetPathDown marks the path to origin via nextHop as unreachable (down) or
+// reachable again. Routes on an unreachable path sort behind reachable
+// alternatives, so lookups fail over to other next hops. Returns the number
+// of routes whose state changed.
+func (t *Table) SetPathDown(origin, nextHop identity.AgentID, down bool) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	pk := PathKey{Origin: origin, NextHop: nextHop}
+	if down {
+		t.down[pk] = struct{}{}
+	} else {
+		delete(t.down, pk)
+	}
+
+	changed := 0
+	for key, routes := range t.routes {
+		resort := false
+		for _, r := range routes {
+			if r.OriginAgent == origin && r.NextHop == nextHop && r.Unreachable != down {
+				r.Unreachable = down
+				resort = true
+				changed++
+			}
+		}
+		if resort {
+			t.sortRoutes(key)
+		}
+	}
+	for _, alts := range t.alternates {
+		for _, alt := range alts {
+			if alt.OriginAgent == origin && alt.NextHop == nextHop {
+				alt.Unreachable = down
+			}
+		}
+	}
+	return changed
+}
+
+// IsPathDown reports whether the path to origin via nextHop is marked down.
+func (t *Table) IsPathDown(origin, nextHop identity.AgentID) bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	_, ok := t.down[PathKey{Origin: origin, NextHop: nextHop}]
+	return ok
 }
 
 // RemoveRoute removes a route from a specific origin.
@@ -157,10 +285,12 @@ func (t *Table) RemoveRoute(network *net.IPNet, originAgent identity.AgentID) bo
 	routes := t.routes[key]
 	for i, r := range routes {
 		if r.OriginAgent == originAgent {
-			// Remove this route
+			// Remove this route and its alternates
+			t.removeAlternates(key, func(alt *Route) bool { return alt.OriginAgent == originAgent })
 			t.routes[key] = append(routes[:i], routes[i+1:]...)
 			if len(t.routes[key]) == 0 {
 				delete(t.routes, key)
+				t.index.remove(network, key)
 			}
 			return true
 		}
@@ -169,24 +299,208 @@ func (t *Table) RemoveRoute(network *net.IPNet, originAgent identity.AgentID) bo
 }
 
 // RemoveRoutesFromPeer removes all routes learned from a specific peer.
+// Routes that fail over to an alternate are counted as removed.
 func (t *Table) RemoveRoutesFromPeer(peerID identity.AgentID) int {
+	removed, rerouted := t.FailoverFromPeer(peerID)
+	return len(removed) + len(rerouted)
+}
+
+// FailoverFromPeer removes all routes learned from a disconnected peer. With
+// fast reroute, a route whose origin is also reachable through a loop-free
+// alternate (one whose path does not pass through peerID) is switched to the
+// best such alternate instead. Returns the routes removed without a
+// replacement and the alternates that took over.
+func (t *Table) FailoverFromPeer(peerID identity.AgentID) (removed, rerouted []*Route) {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 
-	count := 0
+	for pk := range t.down {
+		if pk.NextHop == peerID {
+			delete(t.down, pk)
+		}
+	}
+
+	for key := range t.alternates {
+		t.removeAlternates(key, func(alt *Route) bool { return alt.NextHop == peerID })
+	}
+
 	for key, routes := range t.routes {
+		network := routes[0].Network
 		filtered := routes[:0]
 		for _, r := range routes {
 			if r.NextHop != peerID {
 				filtered = append(filtered, r)
+			} else if alt := t.takeAlternate(key, r.OriginAgent, peerID); alt != nil {
+				filtered = append(filtered, alt)
+				rerouted = append(rerouted, alt.Clone())
 			} else {
-				count++
+				removed = append(removed, r.Clone())
 			}
 		}
 		if len(filtered) == 0 {
 			delete(t.routes, key)
+			t.index.remove(network, key)
 		} else {
 			t.routes[key] = filtered
+			t.sortRoutes(key)
+		}
+	}
+	return removed, rerouted
+}
+
+// AddAlternate records route as an alternate next hop for a route already in
+// the table from the same origin. Alternates are learned from duplicate
+// advertisements arriving through other peers and are only kept with fast
+// reroute enabled. Routes without a known path are not kept, since streams
+// cannot be source-routed along them. Returns true if the alternate was
+// stored.
+func (t *Table) AddAlternate(route *Route) bool {
+	if route == nil || route.Network == nil || len(route.Path) == 0 {
+		return false
+	}
+	for _, id := range route.Path {
+		if id == t.localID {
+			return false // Loop detected
+		}
+	}
+
+	key := route.Network.String()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !t.fastReroute {
+		return false
+	}
+	for _, r := range t.routes[key] {
+		if r.OriginAgent == route.OriginAgent {
+			if r.NextHop == route.NextHop {
+				return false
+			}
+			if !t.storeAlternate(key, t.cloneForInsert(route, time.Now())) {
+				return false
+			}
+			if t.promoteAlternate(key, route.OriginAgent) {
+				t.sortRoutes(key)
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// GetAlternates returns the alternates stored for a network, best first.
+func (t *Table) GetAlternates(network *net.IPNet) []*Route {
+	if network == nil {
+		return nil
+	}
+
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	alts := t.alternates[network.String()]
+	result := make([]*Route, len(alts))
+	for i, r := range alts {
+		result[i] = r.Clone()
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return t.better(result[i], result[j])
+	})
+	return result
+}
+
+// storeAlternate adds alt to the alternates of key, replacing an older entry
+// for the same origin and next hop. Caller must hold the write lock.
+func (t *Table) storeAlternate(key string, alt *Route) bool {
+	if len(alt.Path) == 0 {
+		return false
+	}
+	alts := t.alternates[key]
+	for i, r := range alts {
+		if r.OriginAgent == alt.OriginAgent && r.NextHop == alt.NextHop {
+			if alt.Sequence < r.Sequence {
+				return false
+			}
+			alts[i] = alt
+			return true
+		}
+	}
+	t.alternates[key] = append(alts, alt)
+	return true
+}
+
+// takeAlternate removes and returns the best reachable alternate for origin
+// whose path avoids failed, or nil if there is none. Caller must hold the
+// write lock.
+func (t *Table) takeAlternate(key string, origin, failed identity.AgentID) *Route {
+	var best *Route
+	for _, alt := range t.alternates[key] {
+		if alt.OriginAgent != origin || alt.NextHop == failed || alt.Unreachable {
+			continue
+		}
+		if slices.Contains(alt.Path, failed) {
+			continue
+		}
+		if best == nil || t.better(alt, best) {
+			best = alt
+		}
+	}
+	if best != nil {
+		t.removeAlternates(key, func(alt *Route) bool { return alt == best })
+	}
+	return best
+}
+
+// removeAlternate removes the alternate for origin through nextHop. Caller
+// must hold the write lock.
+func (t *Table) removeAlternate(key string, origin, nextHop identity.AgentID) {
+	t.removeAlternates(key, func(alt *Route) bool {
+		return alt.OriginAgent == origin && alt.NextHop == nextHop
+	})
+}
+
+// removeAlternates removes the alternates of key matching drop. Caller must
+// hold the write lock.
+func (t *Table) removeAlternates(key string, drop func(*Route) bool) {
+	alts := t.alternates[key]
+	if len(alts) == 0 {
+		return
+	}
+	kept := alts[:0]
+	for _, alt := range alts {
+		if !drop(alt) {
+			kept = append(kept, alt)
+		}
+	}
+	if len(kept) == 0 {
+		delete(t.alternates, key)
+	} else {
+		t.alternates[key] = kept
+	}
+}
+
+// TouchRoutesFromPeer marks all routes learned from a specific peer as
+// freshly updated, so CleanupStaleRoutes keeps them while the peer is
+// connected but not re-advertising. Returns the number of routes touched.
+func (t *Table) TouchRoutesFromPeer(peerID identity.AgentID) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	count := 0
+	for _, routes := range t.routes {
+		for _, r := range routes {
+			if r.NextHop == peerID {
+				r.LastUpdate = now
+				count++
+			}
+		}
+	}
+	for _, alts := range t.alternates {
+		for _, alt := range alts {
+			if alt.NextHop == peerID {
+				alt.LastUpdate = now
+			}
 		}
 	}
 	return count
@@ -203,29 +517,24 @@ func (t *Table) Lookup(ip net.IP) *Route {
 // lookupUnlocked performs lookup without locking (caller must hold lock).
 func (t *Table) lookupUnlocked(ip net.IP) *Route {
 	var bestRoute *Route
-	var bestPrefixLen int = -1
-
-	// Normalize IP to 16-byte form
-	ip = ip.To16()
-
-	for _, routes := range t.routes {
-		if len(routes) == 0 {
-			continue
-		}
 
-		// Check if IP is in this network
-		first := routes[0]
-		if !first.Network.Contains(ip) {
-			continue
+	// Prefixes are visited shortest first, so the last match wins
+	t.index.match(ip, func(keys []string) {
+		var levelBest *Route
+		for _, key := range keys {
+			routes := t.routes[key]
+			if len(routes) == 0 {
+				continue
+			}
+			// First is best due to sorting by reachability and metric
+			if levelBest == nil || t.better(routes[0], levelBest) {
+				levelBest = routes[0]
+			}
 		}
-
-		// Calculate prefix length
-		ones, _ := first.Network.Mask.Size()
-		if ones > bestPrefixLen {
-			bestPrefixLen = ones
-			bestRoute = first // First is best due to sorting by metric
+		if levelBest != nil {
+			bestRoute = levelBest
 		}
-	}
+	})
 
 	if bestRoute != nil {
 		return bestRoute.Clone()
@@ -238,36 +547,65 @@ func (t *Table) LookupAll(ip net.IP) []*Route {
 	t.mu.RLock()
 	defer t.mu.RUnlock()
 
-	ip = ip.To16()
 	var matches []*Route
 
-	for _, routes := range t.routes {
-		if len(routes) == 0 {
-			continue
-		}
-
-		first := routes[0]
-		if !first.Network.Contains(ip) {
-			continue
+	t.index.match(ip, func(keys []string) {
+		for _, key := range keys {
+			// Add best route from each matching prefix
+			if routes := t.routes[key]; len(routes) > 0 {
+				matches = append(matches, routes[0].Clone())
+			}
 		}
+	})
 
-		// Add best route from each matching prefix
-		matches = append(matches, first.Clone())
-	}
-
-	// Sort by prefix length (longest first), then by metric
+	// Sort by prefix length (longest first), then by reachability and metric
 	sort.Slice(matches, func(i, j int) bool {
 		onesI, _ := matches[i].Network.Mask.Size()
 		onesJ, _ := matches[j].Network.Mask.Size()
 		if onesI != onesJ {
 			return onesI > onesJ
 		}
-		return matches[i].Metric < matches[j].Metric
+		return t.better(matches[i], matches[j])
 	})
 
 	return matches
 }
 
+// LookupPaths returns every known path for an IP address: the routes of all
+// matching prefixes, longest prefix first and best first within a prefix,
+// each prefix followed by its fast reroute alternates. The first entry is the
+// route Lookup returns.
+func (t *Table) LookupPaths(ip net.IP) []*Route {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	var levels [][]*Route
+	t.index.match(ip, func(keys []string) {
+		var routes, alts []*Route
+		for _, key := range keys {
+			for _, r := range t.routes[key] {
+				routes = append(routes, r.Clone())
+			}
+			for _, alt := range t.alternates[key] {
+				alts = append(alts, alt.Clone())
+			}
+		}
+		for _, level := range [][]*Route{routes, alts} {
+			sort.SliceStable(level, func(i, j int) bool {
+				return t.better(level[i], level[j])
+			})
+		}
+		levels = append(levels, append(routes, alts...))
+	})
+
+	// Prefixes are visited shortest first
+	var paths []*Route
+	for i := len(levels) - 1; i >= 0; i-- {
+		paths = append(paths, levels[i]...)
+	}
+	return paths
+}
+
 // GetRoute returns the best route for a specific network.
 func (t *Table) GetRoute(network *net.IPNet) *Route {
 	if network == nil {
@@ -359,6 +697,9 @@ func (t *Table) Clear() {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 	t.routes = make(map[string][]*Route)
+	t.down = make(map[PathKey]struct{})
+	t.alternates = make(map[string][]*Route)
+	t.index.clear()
 }
 
 // HasRoute checks if a route exists for the given network and origin.
@@ -429,8 +770,14 @@ func (t *Table) CleanupStaleRoutes(maxAge time.Duration) int {
 			t.routes[key] = kept
 		} else {
 			delete(t.routes, key)
+			t.index.remove(routes[0].Network, key)
 		}
 	}
 
+	// Alternates expire like routes but are not counted
+	for key := range t.alternates {
+		t.removeAlternates(key, func(alt *Route) bool { return now.Sub(alt.LastUpdate) > maxAge })
+	}
+
 	return removed
 }
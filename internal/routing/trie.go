@@ -0,0 +1,213 @@
+package routing
+
+import (
+	"net"
+)
+
+// prefixTrie indexes CIDR prefixes for longest-prefix match. It is a
+// path-compressed binary trie: chains of single-child nodes are collapsed,
+// so it holds at most two nodes per prefix and a lookup visits at most one
+// node per bit of the address.
+//
+// Each node stores the Table map keys for its prefix. A prefix normally has
+// exactly one key, but networks advertised with host bits set (such as
+// 10.0.0.1/8) produce a distinct key for the same masked prefix.
+type prefixTrie struct {
+	v4 *trieNode
+	v6 *trieNode
+}
+
+// trieNode is a prefix in the trie. Nodes without keys only join subtrees.
+type trieNode struct {
+	prefix []byte // Network address, masked to bits
+	bits   int
+	keys   []string
+	child  [2]*trieNode
+}
+
+// trieKey returns the masked address and prefix length used to index a
+// network. ok is false for non-canonical masks.
+func trieKey(network *net.IPNet) (addr []byte, ones int, ok bool) {
+	ones, bits := network.Mask.Size()
+	switch bits {
+	case 32:
+		addr = network.IP.To4()
+	case 128:
+		addr = network.IP.To16()
+	}
+	if addr == nil {
+		return nil, 0, false
+	}
+	return []byte(net.IP(addr).Mask(network.Mask)), ones, true
+}
+
+// root returns the subtree for an address of the given byte length.
+func (p *prefixTrie) root(addrLen int) **trieNode {
+	if addrLen == net.IPv4len {
+		return &p.v4
+	}
+	return &p.v6
+}
+
+// insert adds key under network.
+func (p *prefixTrie) insert(network *net.IPNet, key string) {
+	addr, ones, ok := trieKey(network)
+	if !ok {
+		return
+	}
+
+	link := p.root(len(addr))
+	for {
+		n := *link
+		if n == nil {
+			*link = &trieNode{prefix: addr, bits: ones, keys: []string{key}}
+			return
+		}
+
+		common := commonBits(n.prefix, addr, min(n.bits, ones))
+		switch {
+		case common == n.bits && common == ones:
+			for _, k := range n.keys {
+				if k == key {
+					return
+				}
+			}
+			n.keys = append(n.keys, key)
+			return
+		case common == n.bits:
+			// n covers the new prefix, descend
+			link = &n.child[bitAt(addr, n.bits)]
+		case common == ones:
+			// New prefix covers n
+			leaf := &trieNode{prefix: addr, bits: ones, keys: []string{key}}
+			leaf.child[bitAt(n.prefix, ones)] = n
+			*link = leaf
+			return
+		default:
+			// Prefixes diverge at bit common, join them under a new node
+			join := &trieNode{
+				prefix: []byte(net.IP(addr).Mask(net.CIDRMask(common, len(addr)*8))),
+				bits:   common,
+			}
+			join.child[bitAt(addr, common)] = &trieNode{prefix: addr, bits: ones, keys: []string{key}}
+			join.child[bitAt(n.prefix, common)] = n
+			*link = join
+			return
+		}
+	}
+}
+
+// remove deletes key from network, pruning nodes that are no longer needed.
+func (p *prefixTrie) remove(network *net.IPNet, key string) {
+	addr, ones, ok := trieKey(network)
+	if !ok {
+		return
+	}
+	link := p.root(len(addr))
+	*link = (*link).remove(addr, ones, key)
+}
+
+// remove deletes key from the subtree and returns its new root.
+func (n *trieNode) remove(addr []byte, ones int, key string) *trieNode {
+	if n == nil || n.bits > ones || commonBits(n.prefix, addr, n.bits) < n.bits {
+		return n
+	}
+
+	if n.bits == ones {
+		for i, k := range n.keys {
+			if k == key {
+				n.keys = append(n.keys[:i], n.keys[i+1:]...)
+				break
+			}
+		}
+	} else {
+		b := bitAt(addr, n.bits)
+		n.child[b] = n.child[b].remove(addr, ones, key)
+	}
+
+	if len(n.keys) > 0 {
+		return n
+	}
+	switch {
+	case n.child[0] == nil:
+		return n.child[1]
+	case n.child[1] == nil:
+		return n.child[0]
+	}
+	return n
+}
+
+// match calls fn with the keys of every prefix containing ip, from the
+// shortest prefix to the longest.
+func (p *prefixTrie) match(ip net.IP, fn func(keys []string)) {
+	addr := ip.To4()
+	if addr == nil {
+		addr = ip.To16()
+	}
+	if addr == nil {
+		return
+	}
+
+	n := *p.root(len(addr))
+	checked := 0
+	for n != nil {
+		if !bitsEqual(n.prefix, addr, checked, n.bits) {
+			return
+		}
+		if len(n.keys) > 0 {
+			fn(n.keys)
+		}
+		if n.bits == len(addr)*8 {
+			return
+		}
+		checked = n.bits
+		n = n.child[bitAt(addr, n.bits)]
+	}
+}
+
+// clear removes all prefixes.
+func (p *prefixTrie) clear() {
+	p.v4 = nil
+	p.v6 = nil
+}
+
+// bitAt returns bit i of b, counting from the most significant bit.
+func bitAt(b []byte, i int) int {
+	return int(b[i/8]>>(7-uint(i%8))) & 1
+}
+
+// commonBits returns how many leading bits a and b share, up to limit.
+func commonBits(a, b []byte, limit int) int {
+	n := 0
+	for i := 0; n < limit; i++ {
+		x := a[i] ^ b[i]
+		if x == 0 {
+			n += 8
+			continue
+		}
+		for x&0x80 == 0 {
+			x <<= 1
+			n++
+		}
+		break
+	}
+	return min(n, limit)
+}
+
+// bitsEqual reports whether a and b agree on bits [from, to).
+func bitsEqual(a, b []byte, from, to int) bool {
+	for i := from; i < to; {
+		if i%8 == 0 && to-i >= 8 {
+			if a[i/8] != b[i/8] {
+				return false
+			}
+			i += 8
+			continue
+		}
+		if bitAt(a, i) != bitAt(b, i) {
+			return false
+		}
+		i++
+	}
+	return true
+}
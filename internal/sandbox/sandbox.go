@@ -0,0 +1,137 @@
+// Package sandbox confines commands started by the shell on Linux. A
+// sandboxed command is started through this binary, which applies resource
+// limits, no_new_privs, a landlock filesystem allow-list and a seccomp
+// filter to itself and then executes the command, so the restrictions
+// cover the command and everything it starts.
+package sandbox
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// ErrUnsupported is returned by Wrap on platforms without sandbox support.
+var ErrUnsupported = errors.New("command sandbox is only supported on Linux")
+
+const (
+	// helperArg is the first argument of a re-executed binary that
+	// applies a profile and executes the command.
+	helperArg = "__sandbox-exec"
+
+	// profileEnv carries the JSON profile to the helper.
+	profileEnv = "MUTI_METROO_SANDBOX_PROFILE"
+
+	// exitCodeFailed is returned when the sandbox cannot be applied, like
+	// a shell returns 126 for a command it cannot execute.
+	exitCodeFailed = 126
+)
+
+// Profile describes the restrictions of a sandboxed command.
+type Profile struct {
+	// ReadOnly paths may be read and executed, ReadWrite paths also
+	// modified. Everything else is inaccessible. Paths that do not exist
+	// are skipped.
+	ReadOnly  []string `json:"read_only,omitempty"`
+	ReadWrite []string `json:"read_write,omitempty"`
+
+	// Seccomp blocks system calls commands have no business making:
+	// ptrace, mounts, module loading, kexec, bpf, namespaces, keyrings.
+	Seccomp bool `json:"seccomp,omitempty"`
+
+	// Limits are applied as rlimits (0 = unlimited).
+	Limits Limits `json:"limits"`
+}
+
+// Limits are per-process resource limits.
+type Limits struct {
+	MaxMemory    uint64        `json:"max_memory,omitempty"`     // Address space in bytes (RLIMIT_AS)
+	MaxProcesses uint64        `json:"max_processes,omitempty"`  // Processes of the user (RLIMIT_NPROC)
+	MaxOpenFiles uint64        `json:"max_open_files,omitempty"` // Open file descriptors (RLIMIT_NOFILE)
+	MaxFileSize  uint64        `json:"max_file_size,omitempty"`  // Size of written files in bytes (RLIMIT_FSIZE)
+	CPUTime      time.Duration `json:"cpu_time,omitempty"`       // CPU time (RLIMIT_CPU, whole seconds)
+}
+
+// Supported reports whether commands can be sandboxed on this platform.
+func Supported() bool {
+	return runtime.GOOS == "linux"
+}
+
+// Wrap makes cmd start through the sandbox helper with profile p. It must
+// be called before cmd is started. cmd.Path, its arguments and environment
+// are rewritten; the process ID is still the command's after the helper
+// executes it.
+func Wrap(cmd *exec.Cmd, p Profile) error {
+	if !Supported() {
+		return ErrUnsupported
+	}
+	if cmd.Err != nil {
+		// Let Start report the lookup error
+		return nil
+	}
+
+	self, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("locate sandbox helper: %w", err)
+	}
+	data, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+
+	env := cmd.Env
+	if env == nil {
+		env = os.Environ()
+	}
+	cmd.Env = append(withoutProfile(env), profileEnv+"="+string(data))
+	cmd.Args = append([]string{self, helperArg, cmd.Path}, cmd.Args...)
+	cmd.Path = self
+	return nil
+}
+
+// MaybeExec runs the sandbox helper when this process was started by Wrap:
+// it applies the profile and replaces itself with the command. It returns
+// only when the process is not a helper, so call it first thing in main.
+func MaybeExec() {
+	if len(os.Args) < 4 || os.Args[1] != helperArg {
+		return
+	}
+
+	// Restrictions apply to the calling thread, which must also execute
+	// the command
+	runtime.LockOSThread()
+
+	err := execHelper(os.Args[2], os.Args[3:])
+	fmt.Fprintf(os.Stderr, "sandbox: %v\n", err)
+	os.Exit(exitCodeFailed)
+}
+
+// execHelper applies the profile from the environment and executes path.
+// It only returns on failure.
+func execHelper(path string, argv []string) error {
+	var p Profile
+	if err := json.Unmarshal([]byte(os.Getenv(profileEnv)), &p); err != nil {
+		return fmt.Errorf("invalid profile: %w", err)
+	}
+	if err := apply(p); err != nil {
+		return err
+	}
+	return execve(path, argv, withoutProfile(os.Environ()))
+}
+
+// withoutProfile returns env without the profile variable, so commands
+// started inside the sandbox do not see it.
+func withoutProfile(env []string) []string {
+	out := make([]string, 0, len(env))
+	for _, kv := range env {
+		if !strings.HasPrefix(kv, profileEnv+"=") {
+			out = append(out, kv)
+		}
+	}
+	return out
+}
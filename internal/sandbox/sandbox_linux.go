@@ -0,0 +1,158 @@
+//go:build linux
+
+package sandbox
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Landlock filesystem rights. Rights of later ABI versions are added when
+// the kernel supports them; IOCTL_DEV is left out so terminals keep working.
+const (
+	accessFSv1 = unix.LANDLOCK_ACCESS_FS_EXECUTE |
+		unix.LANDLOCK_ACCESS_FS_WRITE_FILE |
+		unix.LANDLOCK_ACCESS_FS_READ_FILE |
+		unix.LANDLOCK_ACCESS_FS_READ_DIR |
+		unix.LANDLOCK_ACCESS_FS_REMOVE_DIR |
+		unix.LANDLOCK_ACCESS_FS_REMOVE_FILE |
+		unix.LANDLOCK_ACCESS_FS_MAKE_CHAR |
+		unix.LANDLOCK_ACCESS_FS_MAKE_DIR |
+		unix.LANDLOCK_ACCESS_FS_MAKE_REG |
+		unix.LANDLOCK_ACCESS_FS_MAKE_SOCK |
+		unix.LANDLOCK_ACCESS_FS_MAKE_FIFO |
+		unix.LANDLOCK_ACCESS_FS_MAKE_BLOCK |
+		unix.LANDLOCK_ACCESS_FS_MAKE_SYM
+
+	accessReadOnly = unix.LANDLOCK_ACCESS_FS_EXECUTE |
+		unix.LANDLOCK_ACCESS_FS_READ_FILE |
+		unix.LANDLOCK_ACCESS_FS_READ_DIR
+
+	// accessFile are the rights that apply to a file rather than a directory
+	accessFile = unix.LANDLOCK_ACCESS_FS_EXECUTE |
+		unix.LANDLOCK_ACCESS_FS_WRITE_FILE |
+		unix.LANDLOCK_ACCESS_FS_READ_FILE |
+		unix.LANDLOCK_ACCESS_FS_TRUNCATE
+)
+
+// apply restricts the calling thread, and so the command it executes.
+func apply(p Profile) error {
+	if err := setLimits(p.Limits); err != nil {
+		return err
+	}
+	if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+		return fmt.Errorf("no_new_privs: %w", err)
+	}
+	if err := restrictPaths(p.ReadOnly, p.ReadWrite); err != nil {
+		return err
+	}
+	if p.Seccomp {
+		if err := loadSeccomp(); err != nil {
+			return fmt.Errorf("seccomp: %w", err)
+		}
+	}
+	return nil
+}
+
+// setLimits applies the non-zero limits as rlimits.
+func setLimits(l Limits) error {
+	cpu := uint64((l.CPUTime + time.Second - 1) / time.Second)
+	limits := []struct {
+		name     string
+		resource int
+		value    uint64
+	}{
+		{"max_memory", unix.RLIMIT_AS, l.MaxMemory},
+		{"max_processes", unix.RLIMIT_NPROC, l.MaxProcesses},
+		{"max_open_files", unix.RLIMIT_NOFILE, l.MaxOpenFiles},
+		{"max_file_size", unix.RLIMIT_FSIZE, l.MaxFileSize},
+		{"cpu_time", unix.RLIMIT_CPU, cpu},
+	}
+	for _, lim := range limits {
+		if lim.value == 0 {
+			continue
+		}
+		rl := unix.Rlimit{Cur: lim.value, Max: lim.value}
+		if err := unix.Setrlimit(lim.resource, &rl); err != nil {
+			return fmt.Errorf("set %s: %w", lim.name, err)
+		}
+	}
+	return nil
+}
+
+// restrictPaths allows filesystem access only beneath the given paths.
+func restrictPaths(readOnly, readWrite []string) error {
+	abi, _, errno := unix.Syscall(unix.SYS_LANDLOCK_CREATE_RULESET, 0, 0, unix.LANDLOCK_CREATE_RULESET_VERSION)
+	if errno != 0 {
+		return fmt.Errorf("landlock is not available: %w", errno)
+	}
+	handled := uint64(accessFSv1)
+	if abi >= 2 {
+		handled |= unix.LANDLOCK_ACCESS_FS_REFER
+	}
+	if abi >= 3 {
+		handled |= unix.LANDLOCK_ACCESS_FS_TRUNCATE
+	}
+
+	attr := unix.LandlockRulesetAttr{Access_fs: handled}
+	fd, _, errno := unix.Syscall(unix.SYS_LANDLOCK_CREATE_RULESET,
+		uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr), 0)
+	if errno != 0 {
+		return fmt.Errorf("create landlock ruleset: %w", errno)
+	}
+	ruleset := int(fd)
+	defer unix.Close(ruleset)
+
+	for _, path := range readOnly {
+		if err := addPathRule(ruleset, path, accessReadOnly&handled); err != nil {
+			return err
+		}
+	}
+	for _, path := range readWrite {
+		if err := addPathRule(ruleset, path, handled); err != nil {
+			return err
+		}
+	}
+
+	if _, _, errno := unix.Syscall(unix.SYS_LANDLOCK_RESTRICT_SELF, uintptr(ruleset), 0, 0); errno != 0 {
+		return fmt.Errorf("enforce landlock ruleset: %w", errno)
+	}
+	return nil
+}
+
+// addPathRule grants access beneath path. Missing paths are skipped.
+func addPathRule(ruleset int, path string, access uint64) error {
+	fd, err := unix.Open(path, unix.O_PATH|unix.O_CLOEXEC, 0)
+	if err != nil {
+		if errors.Is(err, unix.ENOENT) {
+			return nil
+		}
+		return fmt.Errorf("open %s: %w", path, err)
+	}
+	defer unix.Close(fd)
+
+	var st unix.Stat_t
+	if err := unix.Fstat(fd, &st); err != nil {
+		return fmt.Errorf("stat %s: %w", path, err)
+	}
+	if st.Mode&unix.S_IFMT != unix.S_IFDIR {
+		access &= accessFile
+	}
+
+	rule := unix.LandlockPathBeneathAttr{Allowed_access: access, Parent_fd: int32(fd)}
+	if _, _, errno := unix.Syscall6(unix.SYS_LANDLOCK_ADD_RULE, uintptr(ruleset),
+		unix.LANDLOCK_RULE_PATH_BENEATH, uintptr(unsafe.Pointer(&rule)), 0, 0, 0); errno != 0 {
+		return fmt.Errorf("allow %s: %w", path, errno)
+	}
+	return nil
+}
+
+// execve replaces the process with path.
+func execve(path string, argv, env []string) error {
+	return syscall.Exec(path, argv, env)
+}
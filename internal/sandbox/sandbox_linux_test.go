@@ -0,0 +1,107 @@
+//go:build linux
+
+package sandbox
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestMain lets the test binary act as the sandbox helper.
+func TestMain(m *testing.M) {
+	MaybeExec()
+	os.Exit(m.Run())
+}
+
+// runSandboxed runs a command under p and returns its combined output.
+func runSandboxed(t *testing.T, p Profile, name string, args ...string) (string, error) {
+	t.Helper()
+	cmd := exec.Command(name, args...)
+	if err := Wrap(cmd, p); err != nil {
+		t.Fatalf("Wrap() error = %v", err)
+	}
+	out, err := cmd.CombinedOutput()
+	if strings.Contains(string(out), "landlock is not available") {
+		t.Skip("landlock is not available on this kernel")
+	}
+	return string(out), err
+}
+
+func TestSandbox_Paths(t *testing.T) {
+	if _, err := exec.LookPath("cat"); err != nil {
+		t.Skip("cat not found")
+	}
+	dir := t.TempDir()
+	secret := filepath.Join(dir, "secret")
+	if err := os.WriteFile(secret, []byte("hidden"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	system := []string{"/usr", "/bin", "/lib", "/lib64", "/etc"}
+
+	// Outside the allow-list
+	if out, err := runSandboxed(t, Profile{ReadOnly: system}, "cat", secret); err == nil {
+		t.Errorf("reading outside the allow-list succeeded: %q", out)
+	}
+
+	// Read-only path
+	ro := append(system, dir)
+	out, err := runSandboxed(t, Profile{ReadOnly: ro}, "cat", secret)
+	if err != nil || out != "hidden" {
+		t.Errorf("cat = %q, %v; want the file contents", out, err)
+	}
+	if _, err := runSandboxed(t, Profile{ReadOnly: ro}, "cp", secret, filepath.Join(dir, "copy")); err == nil {
+		t.Error("writing to a read-only path succeeded")
+	}
+
+	// Read-write path
+	if out, err := runSandboxed(t, Profile{ReadOnly: system, ReadWrite: []string{dir}}, "cp", secret, filepath.Join(dir, "copy")); err != nil {
+		t.Errorf("writing to a read-write path failed: %v: %s", err, out)
+	}
+}
+
+func TestSandbox_Seccomp(t *testing.T) {
+	if _, err := exec.LookPath("unshare"); err != nil {
+		t.Skip("unshare not found")
+	}
+	p := Profile{ReadOnly: []string{"/"}, Seccomp: true}
+	if out, err := runSandboxed(t, p, "unshare", "--user", "true"); err == nil {
+		t.Errorf("unshare succeeded under seccomp: %q", out)
+	}
+}
+
+func TestSandbox_Limits(t *testing.T) {
+	p := Profile{ReadOnly: []string{"/"}, Limits: Limits{MaxOpenFiles: 64}}
+	out, err := runSandboxed(t, p, "sh", "-c", "ulimit -n")
+	if err != nil {
+		t.Fatalf("sh error = %v: %s", err, out)
+	}
+	if strings.TrimSpace(out) != "64" {
+		t.Errorf("ulimit -n = %q, want 64", out)
+	}
+}
+
+func TestSandbox_ProfileHidden(t *testing.T) {
+	p := Profile{ReadOnly: []string{"/"}}
+	out, err := runSandboxed(t, p, "sh", "-c", "env")
+	if err != nil {
+		t.Fatalf("sh error = %v: %s", err, out)
+	}
+	if strings.Contains(out, profileEnv) {
+		t.Errorf("command sees %s", profileEnv)
+	}
+}
+
+func TestSandbox_HelperFailure(t *testing.T) {
+	p := Profile{ReadOnly: []string{"/"}, Limits: Limits{MaxOpenFiles: 1 << 62}}
+	out, err := runSandboxed(t, p, "true")
+	exitErr, ok := err.(*exec.ExitError)
+	if !ok || exitErr.ExitCode() != exitCodeFailed {
+		t.Fatalf("error = %v, want exit code %d", err, exitCodeFailed)
+	}
+	if !strings.Contains(out, "sandbox: set max_open_files") {
+		t.Errorf("output = %q, want the failure reason", out)
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package sandbox
+
+// apply is not supported on this platform.
+func apply(p Profile) error {
+	return ErrUnsupported
+}
+
+// execve is not supported on this platform.
+func execve(path string, argv, env []string) error {
+	return ErrUnsupported
+}
@@ -0,0 +1,10 @@
+//go:build linux && !amd64 && !arm64
+
+package sandbox
+
+import "errors"
+
+// loadSeccomp is not supported on this architecture.
+func loadSeccomp() error {
+	return errors.New("not supported on this architecture")
+}
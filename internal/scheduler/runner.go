@@ -0,0 +1,194 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/postalsys/muti-metroo/internal/sandbox"
+)
+
+// limitedBuffer keeps the first max bytes written to it.
+type limitedBuffer struct {
+	mu        sync.Mutex
+	buf       []byte
+	max       int
+	truncated bool
+}
+
+// Write implements io.Writer. It never fails so the command is not
+// interrupted by a full buffer.
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if room := b.max - len(b.buf); room < len(p) {
+		b.buf = append(b.buf, p[:max(room, 0)]...)
+		b.truncated = true
+	} else {
+		b.buf = append(b.buf, p...)
+	}
+	return len(p), nil
+}
+
+// runCommand runs a shell task, capturing combined output. A non-nil
+// prepare sets the account the command runs as; a non-nil sb confines it.
+func runCommand(ctx context.Context, task *Task, run *Run, maxOutput int, prepare PrepareFunc, sb *sandbox.Profile) {
+	out := &limitedBuffer{max: maxOutput}
+	cmd := exec.CommandContext(ctx, task.Command, task.Args...)
+	cmd.Stdout = out
+	cmd.Stderr = out
+	if prepare != nil {
+		release, err := prepare(cmd)
+		if err != nil {
+			run.Error = err.Error()
+			return
+		}
+		defer release()
+	}
+	if sb != nil {
+		if err := sandbox.Wrap(cmd, *sb); err != nil {
+			run.Error = "sandbox: " + err.Error()
+			return
+		}
+	}
+
+	err := cmd.Run()
+	run.Output = string(out.buf)
+	run.Truncated = out.truncated
+
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		run.Success = true
+		run.ExitCode = 0
+	case ctx.Err() == context.DeadlineExceeded:
+		run.Error = "timed out"
+	case errors.As(err, &exitErr):
+		run.ExitCode = exitErr.ExitCode()
+		run.Error = fmt.Sprintf("exit status %d", run.ExitCode)
+	default:
+		run.Error = err.Error()
+	}
+}
+
+// syncFiles copies Source to Dest. Regular files are copied when their size
+// or modification time differs from the destination; directories are
+// walked recursively. Files removed from Source are not deleted from Dest.
+func syncFiles(ctx context.Context, task *Task, run *Run) {
+	copied, err := syncTree(ctx, task.Source, task.Dest)
+	run.Output = fmt.Sprintf("%d file(s) copied", copied)
+	if err != nil {
+		run.Error = err.Error()
+		return
+	}
+	run.Success = true
+	run.ExitCode = 0
+}
+
+// syncTree copies changed files from src to dst and returns how many were
+// copied.
+func syncTree(ctx context.Context, src, dst string) (int, error) {
+	info, err := os.Stat(src)
+	if err != nil {
+		return 0, err
+	}
+	if !info.IsDir() {
+		return syncFile(src, dst, info)
+	}
+
+	copied := 0
+	err = filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		if d.IsDir() {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			return os.MkdirAll(target, info.Mode().Perm()|0700)
+		}
+		if !d.Type().IsRegular() {
+			return nil // Skip symlinks, devices and sockets
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		n, err := syncFile(path, target, info)
+		copied += n
+		return err
+	})
+	return copied, err
+}
+
+// syncFile copies one regular file unless dst already has the same size and
+// modification time. Returns 1 if the file was copied.
+func syncFile(src, dst string, info fs.FileInfo) (int, error) {
+	if existing, err := os.Stat(dst); err == nil {
+		if existing.IsDir() {
+			return 0, fmt.Errorf("%s is a directory", dst)
+		}
+		if existing.Size() == info.Size() && existing.ModTime().Equal(info.ModTime()) {
+			return 0, nil
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return 0, err
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+
+	// Write to a temporary file so readers never see a partial copy.
+	tmp := dst + ".mmsync"
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return 0, err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return 0, err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmp)
+		return 0, err
+	}
+	if err := os.Chtimes(tmp, info.ModTime(), info.ModTime()); err != nil {
+		os.Remove(tmp)
+		return 0, err
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		os.Remove(tmp)
+		return 0, err
+	}
+	return 1, nil
+}
+
+// containsPath reports whether path is base or inside it.
+func containsPath(base, path string) bool {
+	rel, err := filepath.Rel(base, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
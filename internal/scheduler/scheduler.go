@@ -0,0 +1,571 @@
+// Package scheduler runs recurring tasks (shell commands and file syncs) on
+// an agent. Tasks are installed remotely, persisted in the data directory,
+// and keep a bounded execution history.
+package scheduler
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/postalsys/muti-metroo/internal/logging"
+	"github.com/postalsys/muti-metroo/internal/recovery"
+	"github.com/postalsys/muti-metroo/internal/sandbox"
+)
+
+// Task types.
+const (
+	TypeShell    = "shell"     // Run a command
+	TypeFileSync = "file_sync" // Copy changed files from Source to Dest
+)
+
+// Run triggers.
+const (
+	TriggerSchedule = "schedule"
+	TriggerManual   = "manual"
+)
+
+// StateFile is the file in the data directory holding tasks and history.
+const StateFile = "scheduler.json"
+
+// ErrTaskNotFound is returned for an unknown task ID.
+var ErrTaskNotFound = errors.New("task not found")
+
+// Task is a recurring job.
+type Task struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name,omitempty"`
+	Schedule  string    `json:"schedule"`
+	Type      string    `json:"type"`
+	Command   string    `json:"command,omitempty"`  // shell: base command name
+	Args      []string  `json:"args,omitempty"`     // shell: arguments
+	Source    string    `json:"source,omitempty"`   // file_sync: file or directory to copy
+	Dest      string    `json:"dest,omitempty"`     // file_sync: destination path
+	Timeout   int       `json:"timeout,omitempty"`  // Seconds (0 = Config.DefaultTimeout)
+	Disabled  bool      `json:"disabled,omitempty"` // Paused tasks keep their history
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Run is one execution of a task.
+type Run struct {
+	StartedAt  time.Time `json:"started_at"`
+	DurationMs int64     `json:"duration_ms"`
+	Trigger    string    `json:"trigger"`
+	Success    bool      `json:"success"`
+	ExitCode   int       `json:"exit_code"`
+	Output     string    `json:"output,omitempty"`
+	Truncated  bool      `json:"truncated,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// TaskStatus is a task with its scheduling state.
+type TaskStatus struct {
+	Task
+	NextRun *time.Time `json:"next_run,omitempty"`
+	LastRun *Run       `json:"last_run,omitempty"`
+	Running bool       `json:"running"`
+}
+
+// PrepareFunc sets up a shell task's command before it starts. release is
+// called once the command has finished.
+type PrepareFunc func(cmd *exec.Cmd) (release func(), err error)
+
+// Config configures a Scheduler.
+type Config struct {
+	// DataDir holds StateFile.
+	DataDir string
+
+	// MaxTasks limits installed tasks (0 = unlimited).
+	MaxTasks int
+
+	// HistorySize is the number of runs kept per task.
+	HistorySize int
+
+	// MaxOutput caps the captured output of a run in bytes.
+	MaxOutput int
+
+	// DefaultTimeout applies to tasks without their own timeout.
+	DefaultTimeout time.Duration
+
+	// Authorize checks a task against the agent's policy. It is called when
+	// a task is added and before every run, so policy changes apply to
+	// installed tasks.
+	Authorize func(task *Task) error
+
+	// Prepare sets the account shell tasks run as (nil = the agent's).
+	Prepare PrepareFunc
+
+	// Sandbox confines shell tasks (nil = no sandbox, Linux only).
+	Sandbox *sandbox.Profile
+
+	Logger *slog.Logger
+}
+
+// entry is an installed task with runtime state.
+type entry struct {
+	task     Task
+	schedule Schedule
+	next     time.Time
+	history  []Run // Oldest first
+	running  bool
+}
+
+// state is the persisted form of the scheduler.
+type state struct {
+	Tasks   []Task           `json:"tasks"`
+	History map[string][]Run `json:"history,omitempty"`
+}
+
+// Scheduler runs tasks on their schedules.
+type Scheduler struct {
+	cfg    Config
+	logger *slog.Logger
+
+	mu      sync.Mutex
+	entries map[string]*entry
+
+	saveMu sync.Mutex // Serializes state file writes
+
+	wake   chan struct{}
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	now func() time.Time // For tests
+}
+
+// New creates a scheduler and loads tasks saved in cfg.DataDir.
+func New(cfg Config) (*Scheduler, error) {
+	if cfg.HistorySize <= 0 {
+		cfg.HistorySize = 20
+	}
+	if cfg.MaxOutput <= 0 {
+		cfg.MaxOutput = 64 * 1024
+	}
+	if cfg.DefaultTimeout <= 0 {
+		cfg.DefaultTimeout = 5 * time.Minute
+	}
+	logger := cfg.Logger
+	if logger == nil {
+		logger = logging.NopLogger()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Scheduler{
+		cfg:     cfg,
+		logger:  logger,
+		entries: make(map[string]*entry),
+		wake:    make(chan struct{}, 1),
+		ctx:     ctx,
+		cancel:  cancel,
+		now:     time.Now,
+	}
+	if err := s.load(); err != nil {
+		cancel()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Start begins running tasks.
+func (s *Scheduler) Start() {
+	s.wg.Add(1)
+	go s.loop()
+}
+
+// Stop cancels running tasks and waits for them to finish.
+func (s *Scheduler) Stop() {
+	s.cancel()
+	s.wg.Wait()
+}
+
+// Add validates and installs a task, returning it with its assigned ID.
+func (s *Scheduler) Add(task Task) (*Task, error) {
+	sched, err := s.validate(&task)
+	if err != nil {
+		return nil, err
+	}
+	if s.cfg.Authorize != nil {
+		if err := s.cfg.Authorize(&task); err != nil {
+			return nil, err
+		}
+	}
+
+	task.ID = newTaskID()
+	task.CreatedAt = s.now().UTC()
+
+	s.mu.Lock()
+	if s.cfg.MaxTasks > 0 && len(s.entries) >= s.cfg.MaxTasks {
+		s.mu.Unlock()
+		return nil, fmt.Errorf("task limit reached (%d)", s.cfg.MaxTasks)
+	}
+	e := &entry{task: task, schedule: sched}
+	if !task.Disabled {
+		e.next = sched.Next(s.now())
+	}
+	s.entries[task.ID] = e
+	s.mu.Unlock()
+
+	s.save()
+	s.signal()
+	return &task, nil
+}
+
+// Remove deletes a task and its history. A run in progress is not stopped.
+func (s *Scheduler) Remove(id string) error {
+	s.mu.Lock()
+	if _, ok := s.entries[id]; !ok {
+		s.mu.Unlock()
+		return ErrTaskNotFound
+	}
+	delete(s.entries, id)
+	s.mu.Unlock()
+
+	s.save()
+	s.signal()
+	return nil
+}
+
+// SetDisabled pauses or resumes a task.
+func (s *Scheduler) SetDisabled(id string, disabled bool) error {
+	s.mu.Lock()
+	e, ok := s.entries[id]
+	if !ok {
+		s.mu.Unlock()
+		return ErrTaskNotFound
+	}
+	e.task.Disabled = disabled
+	e.next = time.Time{}
+	if !disabled {
+		e.next = e.schedule.Next(s.now())
+	}
+	s.mu.Unlock()
+
+	s.save()
+	s.signal()
+	return nil
+}
+
+// List returns all tasks sorted by creation time.
+func (s *Scheduler) List() []TaskStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := make([]TaskStatus, 0, len(s.entries))
+	for _, e := range s.entries {
+		result = append(result, e.status())
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if !result[i].CreatedAt.Equal(result[j].CreatedAt) {
+			return result[i].CreatedAt.Before(result[j].CreatedAt)
+		}
+		return result[i].ID < result[j].ID
+	})
+	return result
+}
+
+// History returns the runs of a task, newest first.
+func (s *Scheduler) History(id string) ([]Run, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[id]
+	if !ok {
+		return nil, ErrTaskNotFound
+	}
+	runs := make([]Run, len(e.history))
+	for i, r := range e.history {
+		runs[len(runs)-1-i] = r
+	}
+	return runs, nil
+}
+
+// RunNow starts a task immediately, outside its schedule.
+func (s *Scheduler) RunNow(id string) error {
+	s.mu.Lock()
+	e, ok := s.entries[id]
+	if !ok {
+		s.mu.Unlock()
+		return ErrTaskNotFound
+	}
+	if e.running {
+		s.mu.Unlock()
+		return fmt.Errorf("task %s is already running", id)
+	}
+	e.running = true
+	task := e.task
+	s.mu.Unlock()
+
+	s.wg.Add(1)
+	go s.execute(task, TriggerManual)
+	return nil
+}
+
+// status returns the public view of an entry. Caller must hold s.mu.
+func (e *entry) status() TaskStatus {
+	st := TaskStatus{Task: e.task, Running: e.running}
+	if !e.next.IsZero() {
+		next := e.next
+		st.NextRun = &next
+	}
+	if n := len(e.history); n > 0 {
+		last := e.history[n-1]
+		st.LastRun = &last
+	}
+	return st
+}
+
+// validate checks a task's fields and parses its schedule.
+func (s *Scheduler) validate(task *Task) (Schedule, error) {
+	sched, err := ParseSchedule(task.Schedule)
+	if err != nil {
+		return nil, fmt.Errorf("invalid schedule: %w", err)
+	}
+	switch task.Type {
+	case TypeShell:
+		if task.Command == "" {
+			return nil, fmt.Errorf("command is required for shell tasks")
+		}
+	case TypeFileSync:
+		if task.Source == "" || task.Dest == "" {
+			return nil, fmt.Errorf("source and dest are required for file_sync tasks")
+		}
+		if !filepath.IsAbs(task.Source) || !filepath.IsAbs(task.Dest) {
+			return nil, fmt.Errorf("source and dest must be absolute paths")
+		}
+		if containsPath(filepath.Clean(task.Source), filepath.Clean(task.Dest)) {
+			return nil, fmt.Errorf("dest must not be inside source")
+		}
+	default:
+		return nil, fmt.Errorf("unknown task type %q (expected %s or %s)", task.Type, TypeShell, TypeFileSync)
+	}
+	if task.Timeout < 0 {
+		return nil, fmt.Errorf("timeout must not be negative")
+	}
+	return sched, nil
+}
+
+// signal wakes the loop to recompute the next due time.
+func (s *Scheduler) signal() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// loop sleeps until the next task is due and starts due tasks.
+func (s *Scheduler) loop() {
+	defer s.wg.Done()
+	defer recovery.RecoverWithLog(s.logger, "scheduler.loop")
+
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+
+	for {
+		wait := s.startDue()
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(wait)
+
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-s.wake:
+		case <-timer.C:
+		}
+	}
+}
+
+// startDue starts every due task that is not already running and returns
+// how long to wait until the next one.
+func (s *Scheduler) startDue() time.Duration {
+	now := s.now()
+	wait := time.Hour
+
+	s.mu.Lock()
+	var due []Task
+	for _, e := range s.entries {
+		if e.next.IsZero() {
+			continue
+		}
+		if !e.next.After(now) {
+			if !e.running {
+				e.running = true
+				due = append(due, e.task)
+			} else {
+				s.logger.Warn("scheduled task still running, skipping run",
+					"task", e.task.ID)
+			}
+			e.next = e.schedule.Next(now)
+			if e.next.IsZero() {
+				continue
+			}
+		}
+		wait = min(wait, e.next.Sub(now))
+	}
+	s.mu.Unlock()
+
+	for _, task := range due {
+		s.wg.Add(1)
+		go s.execute(task, TriggerSchedule)
+	}
+	return max(wait, 0)
+}
+
+// execute runs a task and records the result.
+func (s *Scheduler) execute(task Task, trigger string) {
+	defer s.wg.Done()
+	defer recovery.RecoverWithLog(s.logger, "scheduler.execute")
+
+	run := Run{StartedAt: s.now().UTC(), Trigger: trigger, ExitCode: -1}
+	start := time.Now()
+
+	if s.cfg.Authorize != nil {
+		if err := s.cfg.Authorize(&task); err != nil {
+			run.Error = "not authorized: " + err.Error()
+		}
+	}
+	if run.Error == "" {
+		timeout := s.cfg.DefaultTimeout
+		if task.Timeout > 0 {
+			timeout = time.Duration(task.Timeout) * time.Second
+		}
+		ctx, cancel := context.WithTimeout(s.ctx, timeout)
+		switch task.Type {
+		case TypeShell:
+			runCommand(ctx, &task, &run, s.cfg.MaxOutput, s.cfg.Prepare, s.cfg.Sandbox)
+		case TypeFileSync:
+			syncFiles(ctx, &task, &run)
+		}
+		cancel()
+	}
+	run.DurationMs = time.Since(start).Milliseconds()
+
+	if run.Success {
+		s.logger.Debug("scheduled task finished",
+			"task", task.ID,
+			"trigger", trigger,
+			"duration_ms", run.DurationMs)
+	} else {
+		s.logger.Warn("scheduled task failed",
+			"task", task.ID,
+			"trigger", trigger,
+			"exit_code", run.ExitCode,
+			logging.KeyError, run.Error)
+	}
+
+	s.mu.Lock()
+	e, ok := s.entries[task.ID]
+	if ok {
+		e.running = false
+		e.history = append(e.history, run)
+		if over := len(e.history) - s.cfg.HistorySize; over > 0 {
+			e.history = append([]Run(nil), e.history[over:]...)
+		}
+	}
+	s.mu.Unlock()
+
+	if ok {
+		s.save()
+	}
+}
+
+// load reads the state file, skipping tasks that no longer parse.
+func (s *Scheduler) load() error {
+	if s.cfg.DataDir == "" {
+		return nil
+	}
+	data, err := os.ReadFile(filepath.Join(s.cfg.DataDir, StateFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read scheduler state: %w", err)
+	}
+
+	var st state
+	if err := json.Unmarshal(data, &st); err != nil {
+		return fmt.Errorf("parse scheduler state: %w", err)
+	}
+
+	now := s.now()
+	for _, task := range st.Tasks {
+		sched, err := s.validate(&task)
+		if err != nil {
+			s.logger.Warn("skipping invalid scheduled task",
+				"task", task.ID,
+				logging.KeyError, err)
+			continue
+		}
+		e := &entry{task: task, schedule: sched, history: st.History[task.ID]}
+		if over := len(e.history) - s.cfg.HistorySize; over > 0 {
+			e.history = e.history[over:]
+		}
+		if !task.Disabled {
+			e.next = sched.Next(now)
+		}
+		s.entries[task.ID] = e
+	}
+	return nil
+}
+
+// save writes the state file atomically. Errors are logged.
+func (s *Scheduler) save() {
+	if s.cfg.DataDir == "" {
+		return
+	}
+
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+
+	s.mu.Lock()
+	st := state{History: make(map[string][]Run)}
+	for id, e := range s.entries {
+		st.Tasks = append(st.Tasks, e.task)
+		if len(e.history) > 0 {
+			st.History[id] = append([]Run(nil), e.history...)
+		}
+	}
+	s.mu.Unlock()
+	sort.Slice(st.Tasks, func(i, j int) bool {
+		return st.Tasks[i].CreatedAt.Before(st.Tasks[j].CreatedAt)
+	})
+
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		s.logger.Error("failed to encode scheduler state", logging.KeyError, err)
+		return
+	}
+
+	path := filepath.Join(s.cfg.DataDir, StateFile)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		s.logger.Error("failed to write scheduler state", logging.KeyError, err)
+		return
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		s.logger.Error("failed to write scheduler state", logging.KeyError, err)
+	}
+}
+
+// newTaskID returns a random 8-byte hex ID.
+func newTaskID() string {
+	var b [8]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
@@ -0,0 +1,360 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseSchedule(t *testing.T) {
+	base := time.Date(2026, 3, 14, 10, 7, 30, 0, time.Local) // Saturday
+
+	tests := []struct {
+		spec string
+		want time.Time
+	}{
+		{"@every 15m", base.Add(15 * time.Minute)},
+		{"@hourly", time.Date(2026, 3, 14, 11, 0, 0, 0, time.Local)},
+		{"@daily", time.Date(2026, 3, 15, 0, 0, 0, 0, time.Local)},
+		{"@weekly", time.Date(2026, 3, 15, 0, 0, 0, 0, time.Local)},
+		{"@monthly", time.Date(2026, 4, 1, 0, 0, 0, 0, time.Local)},
+		{"*/5 * * * *", time.Date(2026, 3, 14, 10, 10, 0, 0, time.Local)},
+		{"30 2 * * *", time.Date(2026, 3, 15, 2, 30, 0, 0, time.Local)},
+		{"0 9 * * 1-5", time.Date(2026, 3, 16, 9, 0, 0, 0, time.Local)},
+		{"0 0 * * 7", time.Date(2026, 3, 15, 0, 0, 0, 0, time.Local)},
+		{"0,30 10 * * *", time.Date(2026, 3, 14, 10, 30, 0, 0, time.Local)},
+		{"0 0 1,15 * 1", time.Date(2026, 3, 15, 0, 0, 0, 0, time.Local)}, // dom or dow
+		{"0 0 31 2 *", time.Time{}},                                      // Never matches
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			s, err := ParseSchedule(tt.spec)
+			if err != nil {
+				t.Fatalf("ParseSchedule(%q) error = %v", tt.spec, err)
+			}
+			if got := s.Next(base); !got.Equal(tt.want) {
+				t.Errorf("Next() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSchedule_Invalid(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"@every 10s",
+		"@every soon",
+		"@yearly",
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"a * * * *",
+	} {
+		if _, err := ParseSchedule(spec); err == nil {
+			t.Errorf("ParseSchedule(%q) succeeded, want error", spec)
+		}
+	}
+}
+
+func newTestScheduler(t *testing.T, dir string, authorize func(*Task) error) *Scheduler {
+	t.Helper()
+	s, err := New(Config{
+		DataDir:     dir,
+		MaxTasks:    2,
+		HistorySize: 3,
+		MaxOutput:   16,
+		Authorize:   authorize,
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	t.Cleanup(s.Stop)
+	return s
+}
+
+func TestScheduler_AddPersistsTasks(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestScheduler(t, dir, nil)
+
+	task, err := s.Add(Task{Name: "disk", Schedule: "@hourly", Type: TypeShell, Command: "df", Args: []string{"-h"}})
+	if err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	if task.ID == "" || task.CreatedAt.IsZero() {
+		t.Errorf("Add() did not assign ID and CreatedAt: %+v", task)
+	}
+	if _, err := s.Add(Task{Schedule: "@daily", Type: TypeFileSync, Source: "/srv/a", Dest: "/srv/b"}); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	if _, err := s.Add(Task{Schedule: "@daily", Type: TypeShell, Command: "uptime"}); err == nil {
+		t.Error("Add() over MaxTasks succeeded, want error")
+	}
+
+	reloaded := newTestScheduler(t, dir, nil)
+	tasks := reloaded.List()
+	if len(tasks) != 2 {
+		t.Fatalf("List() after reload = %d tasks, want 2", len(tasks))
+	}
+	if tasks[0].ID != task.ID || tasks[0].Command != "df" || tasks[0].NextRun == nil {
+		t.Errorf("reloaded task = %+v", tasks[0])
+	}
+
+	if err := reloaded.Remove(task.ID); err != nil {
+		t.Fatalf("Remove() error = %v", err)
+	}
+	if err := reloaded.Remove(task.ID); !errors.Is(err, ErrTaskNotFound) {
+		t.Errorf("Remove() again error = %v, want ErrTaskNotFound", err)
+	}
+}
+
+func TestScheduler_AddValidates(t *testing.T) {
+	s := newTestScheduler(t, t.TempDir(), nil)
+
+	for _, task := range []Task{
+		{Schedule: "bad", Type: TypeShell, Command: "ls"},
+		{Schedule: "@hourly", Type: "script", Command: "ls"},
+		{Schedule: "@hourly", Type: TypeShell},
+		{Schedule: "@hourly", Type: TypeFileSync, Source: "/a"},
+		{Schedule: "@hourly", Type: TypeFileSync, Source: "a", Dest: "/b"},
+		{Schedule: "@hourly", Type: TypeFileSync, Source: "/a", Dest: "/a/b"},
+		{Schedule: "@hourly", Type: TypeShell, Command: "ls", Timeout: -1},
+	} {
+		if _, err := s.Add(task); err == nil {
+			t.Errorf("Add(%+v) succeeded, want error", task)
+		}
+	}
+}
+
+func TestScheduler_AuthorizeOnAddAndRun(t *testing.T) {
+	allowed := true
+	authorize := func(task *Task) error {
+		if !allowed || task.Command == "rm" {
+			return errors.New("denied")
+		}
+		return nil
+	}
+	s := newTestScheduler(t, t.TempDir(), authorize)
+
+	if _, err := s.Add(Task{Schedule: "@hourly", Type: TypeShell, Command: "rm"}); err == nil {
+		t.Fatal("Add() of denied task succeeded")
+	}
+
+	task, err := s.Add(Task{Schedule: "@hourly", Type: TypeShell, Command: "echo"})
+	if err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+
+	allowed = false
+	if err := s.RunNow(task.ID); err != nil {
+		t.Fatalf("RunNow() error = %v", err)
+	}
+	runs := waitForRuns(t, s, task.ID, 1)
+	if runs[0].Success || !strings.Contains(runs[0].Error, "not authorized") {
+		t.Errorf("run = %+v, want not authorized", runs[0])
+	}
+}
+
+func TestScheduler_RunNowRecordsHistory(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestScheduler(t, dir, nil)
+
+	task, err := s.Add(Task{Schedule: "@daily", Type: TypeShell, Command: "echo", Args: []string{"hello from the scheduler"}})
+	if err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+
+	for i := 1; i <= 4; i++ {
+		if err := s.RunNow(task.ID); err != nil {
+			t.Fatalf("RunNow() error = %v", err)
+		}
+		waitForRuns(t, s, task.ID, min(i, 3))
+		waitIdle(t, s, task.ID)
+	}
+
+	runs, _ := s.History(task.ID)
+	if len(runs) != 3 {
+		t.Fatalf("History() = %d runs, want HistorySize 3", len(runs))
+	}
+	r := runs[0]
+	if !r.Success || r.ExitCode != 0 || r.Trigger != TriggerManual {
+		t.Errorf("run = %+v, want manual success", r)
+	}
+	if r.Output != "hello from the s" || !r.Truncated {
+		t.Errorf("output = %q truncated = %v, want first 16 bytes truncated", r.Output, r.Truncated)
+	}
+
+	reloaded := newTestScheduler(t, dir, nil)
+	if runs, _ := reloaded.History(task.ID); len(runs) != 3 {
+		t.Errorf("History() after reload = %d runs, want 3", len(runs))
+	}
+}
+
+func TestScheduler_Prepare(t *testing.T) {
+	s := newTestScheduler(t, t.TempDir(), nil)
+	released := make(chan struct{}, 1)
+	fail := false
+	s.cfg.Prepare = func(cmd *exec.Cmd) (func(), error) {
+		if fail {
+			return nil, errors.New("run as deploy: unknown user")
+		}
+		cmd.Env = []string{"TASK_ACCOUNT=deploy"}
+		return func() { released <- struct{}{} }, nil
+	}
+
+	task, err := s.Add(Task{Schedule: "@daily", Type: TypeShell, Command: "printenv", Args: []string{"TASK_ACCOUNT"}})
+	if err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	if err := s.RunNow(task.ID); err != nil {
+		t.Fatalf("RunNow() error = %v", err)
+	}
+	runs := waitForRuns(t, s, task.ID, 1)
+	if !runs[0].Success || runs[0].Output != "deploy\n" {
+		t.Errorf("run = %+v, want the prepared environment", runs[0])
+	}
+	select {
+	case <-released:
+	default:
+		t.Error("release was not called")
+	}
+
+	waitIdle(t, s, task.ID)
+	fail = true
+	if err := s.RunNow(task.ID); err != nil {
+		t.Fatalf("RunNow() error = %v", err)
+	}
+	runs = waitForRuns(t, s, task.ID, 2)
+	if runs[0].Success || !strings.Contains(runs[0].Error, "unknown user") {
+		t.Errorf("run = %+v, want the prepare error", runs[0])
+	}
+}
+
+func TestScheduler_RunsDueTasks(t *testing.T) {
+	s := newTestScheduler(t, t.TempDir(), nil)
+
+	now := time.Now()
+	var offset atomic.Int64
+	s.now = func() time.Time { return now.Add(time.Duration(offset.Load())) }
+	task, err := s.Add(Task{Schedule: "@every 1m", Type: TypeShell, Command: "true"})
+	if err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+
+	s.Start()
+	offset.Store(int64(2 * time.Minute))
+	s.signal()
+
+	runs := waitForRuns(t, s, task.ID, 1)
+	if runs[0].Trigger != TriggerSchedule || !runs[0].Success {
+		t.Errorf("run = %+v, want scheduled success", runs[0])
+	}
+}
+
+func TestScheduler_SetDisabled(t *testing.T) {
+	s := newTestScheduler(t, t.TempDir(), nil)
+
+	task, err := s.Add(Task{Schedule: "@hourly", Type: TypeShell, Command: "true"})
+	if err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	if err := s.SetDisabled(task.ID, true); err != nil {
+		t.Fatalf("SetDisabled() error = %v", err)
+	}
+	if st := s.List()[0]; !st.Disabled || st.NextRun != nil {
+		t.Errorf("disabled task = %+v, want no next run", st)
+	}
+	if err := s.SetDisabled(task.ID, false); err != nil {
+		t.Fatalf("SetDisabled() error = %v", err)
+	}
+	if st := s.List()[0]; st.Disabled || st.NextRun == nil {
+		t.Errorf("enabled task = %+v, want next run", st)
+	}
+}
+
+func TestSyncTree(t *testing.T) {
+	src := t.TempDir()
+	dst := filepath.Join(t.TempDir(), "copy")
+
+	if err := os.MkdirAll(filepath.Join(src, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "a.txt"), []byte("alpha"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "sub", "b.txt"), []byte("bravo"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := syncTree(context.Background(), src, dst)
+	if err != nil || n != 2 {
+		t.Fatalf("syncTree() = %d, %v, want 2 files", n, err)
+	}
+	data, err := os.ReadFile(filepath.Join(dst, "sub", "b.txt"))
+	if err != nil || string(data) != "bravo" {
+		t.Errorf("copied file = %q, %v", data, err)
+	}
+
+	n, err = syncTree(context.Background(), src, dst)
+	if err != nil || n != 0 {
+		t.Errorf("second syncTree() = %d, %v, want nothing copied", n, err)
+	}
+
+	later := time.Now().Add(time.Hour)
+	if err := os.WriteFile(filepath.Join(src, "a.txt"), []byte("ALPHA"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(filepath.Join(src, "a.txt"), later, later)
+	n, err = syncTree(context.Background(), src, dst)
+	if err != nil || n != 1 {
+		t.Errorf("syncTree() after change = %d, %v, want 1 file", n, err)
+	}
+}
+
+// waitForRuns waits until a task has at least n runs and returns them.
+func waitForRuns(t *testing.T, s *Scheduler, id string, n int) []Run {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		runs, err := s.History(id)
+		if err != nil {
+			t.Fatalf("History() error = %v", err)
+		}
+		if len(runs) >= n {
+			return runs
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %d runs of %s", n, id)
+	return nil
+}
+
+// waitIdle waits until a task is no longer running.
+func waitIdle(t *testing.T, s *Scheduler, id string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		running := false
+		for _, st := range s.List() {
+			if st.ID == id {
+				running = st.Running
+			}
+		}
+		if !running {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %s to finish", id)
+}
@@ -0,0 +1,79 @@
+//go:build !windows
+
+package selfupdate
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// systemdInstallScript installs the staged binary and restarts the unit.
+// It runs in a transient unit, outside the agent unit's sandbox
+// (ProtectSystem=strict makes the install directory read-only to the agent).
+const systemdInstallScript = `install -m 0755 "$1" "$2.new" && mv -f "$2.new" "$2" && systemctl restart "$3"`
+
+// replaceFile renames src over dst. The rename is atomic on Unix, even while
+// dst is being executed.
+func replaceFile(src, dst string) error {
+	return os.Rename(src, dst)
+}
+
+// underSystemd reports whether the process was started by systemd.
+func underSystemd() bool {
+	return os.Getenv("INVOCATION_ID") != ""
+}
+
+// Prepare installs the staged binary over the target and returns the
+// restart method to pass to Restart. Under a hardened systemd unit, where
+// the agent cannot write its own executable, installation is deferred to a
+// transient unit.
+func Prepare(opts RestartOptions) (string, error) {
+	err := Install(opts.Staged, opts.Target)
+	if err == nil {
+		return MethodExec, nil
+	}
+	if underSystemd() && opts.ServiceName != "" {
+		if _, lookErr := exec.LookPath("systemd-run"); lookErr == nil {
+			return MethodSystemd, nil
+		}
+	}
+	return "", fmt.Errorf("install %s: %w", opts.Target, err)
+}
+
+// RestartMethod returns the method for restarting the running binary
+// without installing an update. The process image is replaced in place, so
+// this also works under a hardened systemd unit.
+func RestartMethod() string {
+	return MethodExec
+}
+
+// Restart restarts the agent into the new binary using a method returned by
+// Prepare. With MethodExec it does not return on success: the agent is
+// stopped and the process image replaced, keeping the PID, so service
+// managers and PID files keep tracking it.
+func Restart(method string, opts RestartOptions) error {
+	switch method {
+	case MethodExec:
+		if opts.Stop != nil {
+			opts.Stop()
+		}
+		return syscall.Exec(opts.Target, os.Args, os.Environ())
+
+	case MethodSystemd:
+		unit := fmt.Sprintf("%s-update-%d", opts.ServiceName, time.Now().Unix())
+		out, err := exec.Command("systemd-run", "--collect", "--quiet", "--unit", unit,
+			"/bin/sh", "-c", systemdInstallScript, "sh",
+			opts.Staged, opts.Target, opts.ServiceName+".service").CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("systemd-run: %s: %w", strings.TrimSpace(string(out)), err)
+		}
+		return nil
+
+	default:
+		return fmt.Errorf("unsupported restart method %q", method)
+	}
+}
@@ -0,0 +1,85 @@
+//go:build windows
+
+package selfupdate
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+
+	"golang.org/x/sys/windows"
+
+	"github.com/postalsys/muti-metroo/internal/service"
+)
+
+// replaceFile moves src over dst. A running executable cannot be
+// overwritten on Windows but can be renamed, so dst is first moved aside to
+// dst.old (removed on the next update).
+func replaceFile(src, dst string) error {
+	old := dst + ".old"
+	os.Remove(old)
+	if err := os.Rename(dst, old); err != nil {
+		return err
+	}
+	if err := os.Rename(src, dst); err != nil {
+		os.Rename(old, dst)
+		return err
+	}
+	return nil
+}
+
+// Prepare installs the staged binary over the target and returns the
+// restart method to pass to Restart.
+func Prepare(opts RestartOptions) (string, error) {
+	if err := Install(opts.Staged, opts.Target); err != nil {
+		return "", fmt.Errorf("install %s: %w", opts.Target, err)
+	}
+	if !service.IsInteractive() {
+		return MethodWindowsService, nil
+	}
+	return MethodSpawn, nil
+}
+
+// RestartMethod returns the method for restarting the running binary
+// without installing an update.
+func RestartMethod() string {
+	if !service.IsInteractive() {
+		return MethodWindowsService
+	}
+	return MethodSpawn
+}
+
+// Restart restarts the agent into the new binary using a method returned by
+// Prepare. A service is restarted by a detached "net stop / net start" so
+// the Service Control Manager starts the new executable. A console agent
+// starts the new binary with the same arguments and exits.
+func Restart(method string, opts RestartOptions) error {
+	switch method {
+	case MethodWindowsService:
+		if opts.ServiceName == "" {
+			return fmt.Errorf("service name is required")
+		}
+		cmd := exec.Command("cmd.exe", "/C",
+			fmt.Sprintf("net stop %q & net start %q", opts.ServiceName, opts.ServiceName))
+		cmd.SysProcAttr = &syscall.SysProcAttr{
+			CreationFlags: windows.DETACHED_PROCESS | windows.CREATE_NEW_PROCESS_GROUP,
+		}
+		return cmd.Start()
+
+	case MethodSpawn:
+		if opts.Stop != nil {
+			opts.Stop()
+		}
+		cmd := exec.Command(opts.Target, os.Args[1:]...)
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+		if err := cmd.Start(); err != nil {
+			return err
+		}
+		os.Exit(0)
+		return nil
+
+	default:
+		return fmt.Errorf("unsupported restart method %q", method)
+	}
+}
@@ -0,0 +1,281 @@
+// Package selfupdate verifies a staged agent binary, installs it over the
+// running executable and restarts the agent into it.
+package selfupdate
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/postalsys/muti-metroo/internal/crypto"
+)
+
+// signPrefix separates update signatures from other signed messages
+// (sleep/wake commands) made with the same key.
+const signPrefix = "muti-metroo-update:"
+
+// probeTimeout bounds the "--version" run of a staged binary.
+const probeTimeout = 10 * time.Second
+
+// Restart methods reported by Restart.
+const (
+	MethodExec           = "exec"            // Replace the process image in place
+	MethodSystemd        = "systemd"         // Install and restart via a transient systemd unit
+	MethodWindowsService = "windows_service" // Restart through the Service Control Manager
+	MethodSpawn          = "spawn"           // Start the new binary and exit (Windows console)
+)
+
+// Manifest describes the binary an update installs. The signature covers
+// all of it, so a signature made for one release cannot be replayed to
+// install another, or the same binary on a different platform.
+type Manifest struct {
+	SHA256  string // Digest of the binary (hex)
+	Version string // Version the binary reports with --version
+	OS      string // Target GOOS
+	Arch    string // Target GOARCH
+
+	// Force allows installing a version older than the running one.
+	Force bool
+}
+
+// SignableBytes returns the message signed for the update.
+func (m Manifest) SignableBytes() []byte {
+	msg := signPrefix + m.Version + "\n" + m.OS + "/" + m.Arch + "\n" + strings.ToLower(m.SHA256)
+	if m.Force {
+		msg += "\nforce"
+	}
+	return []byte(msg)
+}
+
+// FileSHA256 returns the SHA-256 digest of a file as lowercase hex.
+func FileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Verify checks that the file at path has the digest in m and, when
+// publicKey is non-nil, that signatureHex is a valid Ed25519 signature of
+// m.SignableBytes().
+func Verify(path string, m Manifest, signatureHex string, publicKey *[crypto.Ed25519PublicKeySize]byte) error {
+	if m.SHA256 == "" {
+		return fmt.Errorf("sha256 is required")
+	}
+	actual, err := FileSHA256(path)
+	if err != nil {
+		return fmt.Errorf("read staged binary: %w", err)
+	}
+	if !strings.EqualFold(actual, m.SHA256) {
+		return fmt.Errorf("sha256 mismatch: staged binary is %s", actual)
+	}
+
+	if publicKey == nil {
+		return nil
+	}
+	if signatureHex == "" {
+		return fmt.Errorf("signature is required")
+	}
+	sigBytes, err := hex.DecodeString(signatureHex)
+	if err != nil || len(sigBytes) != crypto.Ed25519SignatureSize {
+		return fmt.Errorf("invalid signature encoding")
+	}
+	var sig [crypto.Ed25519SignatureSize]byte
+	copy(sig[:], sigBytes)
+	if !crypto.Verify(*publicKey, m.SignableBytes(), sig) {
+		return fmt.Errorf("signature verification failed")
+	}
+	return nil
+}
+
+// Check verifies the staged binary against m, runs it to confirm it
+// reports m.Version, and refuses a version older than running unless
+// m.Force is set. It returns the staged binary's version.
+func Check(ctx context.Context, path string, m Manifest, signatureHex string, publicKey *[crypto.Ed25519PublicKeySize]byte, running string) (string, error) {
+	if err := Verify(path, m, signatureHex, publicKey); err != nil {
+		return "", err
+	}
+	if m.Version == "" {
+		return "", fmt.Errorf("version is required")
+	}
+	version, err := Probe(ctx, path)
+	if err != nil {
+		return "", err
+	}
+	if version != m.Version {
+		return "", fmt.Errorf("staged binary reports version %s, not %s", version, m.Version)
+	}
+	if !m.Force && IsOlder(version, running) {
+		return "", fmt.Errorf("refusing to downgrade from %s to %s without force", running, version)
+	}
+	return version, nil
+}
+
+// IsOlder reports whether version a should be treated as older than b.
+// Release versions ("1.4.0", "v1.4.0-rc1") are compared numerically, a
+// pre-release sorting before its release. Other versions, such as dev
+// builds, cannot be ordered; they count as older unless equal to b.
+func IsOlder(a, b string) bool {
+	if a == b {
+		return false
+	}
+	va, okA := parseVersion(a)
+	vb, okB := parseVersion(b)
+	if !okA || !okB {
+		return true
+	}
+	for i := range va.core {
+		if va.core[i] != vb.core[i] {
+			return va.core[i] < vb.core[i]
+		}
+	}
+	switch {
+	case va.pre == vb.pre:
+		return false
+	case va.pre == "":
+		return false
+	case vb.pre == "":
+		return true
+	}
+	return va.pre < vb.pre
+}
+
+// releaseVersion is a parsed "major.minor.patch[-pre]" version.
+type releaseVersion struct {
+	core [3]int
+	pre  string
+}
+
+// parseVersion parses a release version. Build metadata after "+" is
+// ignored.
+func parseVersion(s string) (releaseVersion, bool) {
+	var v releaseVersion
+	s = strings.TrimPrefix(s, "v")
+	s, _, _ = strings.Cut(s, "+")
+	s, v.pre, _ = strings.Cut(s, "-")
+	parts := strings.Split(s, ".")
+	if len(parts) != 3 {
+		return v, false
+	}
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return v, false
+		}
+		v.core[i] = n
+	}
+	return v, true
+}
+
+// Probe runs the binary with "--version" and returns the reported version.
+// This also proves the binary runs on this platform before it is installed.
+func Probe(ctx context.Context, path string) (string, error) {
+	if err := os.Chmod(path, 0755); err != nil {
+		return "", err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+
+	var out bytes.Buffer
+	cmd := exec.CommandContext(ctx, path, "--version")
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("staged binary does not run: %w", err)
+	}
+
+	// Output is "<name> version <version>"
+	_, version, ok := strings.Cut(strings.TrimSpace(out.String()), "version ")
+	if !ok || version == "" {
+		return "", fmt.Errorf("staged binary did not report a version")
+	}
+	return strings.TrimSpace(version), nil
+}
+
+// Executable returns the resolved path of the running executable.
+func Executable() (string, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	return filepath.EvalSymlinks(exe)
+}
+
+// Install copies staged over target. The copy is written next to target
+// and renamed into place, so target is never partially written.
+func Install(staged, target string) error {
+	info, err := os.Stat(target)
+	if err != nil {
+		return err
+	}
+
+	tmp := target + ".new"
+	if err := copyFile(staged, tmp, info.Mode().Perm()|0700); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := replaceFile(tmp, target); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// copyFile copies src to dst with the given permissions.
+func copyFile(src, dst string, perm os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Chmod(dst, perm)
+}
+
+// RestartOptions configures Restart.
+type RestartOptions struct {
+	// Staged is the verified new binary.
+	Staged string
+
+	// Target is the executable to replace.
+	Target string
+
+	// ServiceName is the systemd unit (without ".service") or Windows
+	// service name to restart.
+	ServiceName string
+
+	// Stop shuts the agent down before the process is replaced.
+	Stop func()
+}
@@ -0,0 +1,261 @@
+package selfupdate
+
+import (
+	"context"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/postalsys/muti-metroo/internal/crypto"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFileSHA256(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bin")
+	writeFile(t, path, "hello")
+
+	got, err := FileSHA256(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+	if got != want {
+		t.Errorf("FileSHA256 = %s, want %s", got, want)
+	}
+}
+
+func TestManifest_SignableBytes(t *testing.T) {
+	base := Manifest{SHA256: "abcd", Version: "1.4.0", OS: "linux", Arch: "amd64"}
+	if !strings.HasPrefix(string(base.SignableBytes()), signPrefix) {
+		t.Error("signable bytes should carry the update prefix")
+	}
+	upper := base
+	upper.SHA256 = "ABCD"
+	if string(upper.SignableBytes()) != string(base.SignableBytes()) {
+		t.Error("signable bytes should not depend on digest case")
+	}
+
+	changed := map[string]Manifest{
+		"sha256":  {SHA256: "abce", Version: "1.4.0", OS: "linux", Arch: "amd64"},
+		"version": {SHA256: "abcd", Version: "1.3.0", OS: "linux", Arch: "amd64"},
+		"os":      {SHA256: "abcd", Version: "1.4.0", OS: "windows", Arch: "amd64"},
+		"arch":    {SHA256: "abcd", Version: "1.4.0", OS: "linux", Arch: "arm64"},
+		"force":   {SHA256: "abcd", Version: "1.4.0", OS: "linux", Arch: "amd64", Force: true},
+	}
+	for name, m := range changed {
+		if string(m.SignableBytes()) == string(base.SignableBytes()) {
+			t.Errorf("signable bytes should cover %s", name)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bin")
+	writeFile(t, path, "new binary")
+	digest, err := FileSHA256(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := Manifest{SHA256: digest, Version: "1.4.0", OS: "linux", Arch: "amd64"}
+
+	kp, err := crypto.GenerateSigningKeypair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig := crypto.Sign(kp.PrivateKey, m.SignableBytes())
+	sigHex := hex.EncodeToString(sig[:])
+
+	other, err := crypto.GenerateSigningKeypair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherSig := crypto.Sign(other.PrivateKey, m.SignableBytes())
+
+	with := func(f func(*Manifest)) Manifest {
+		c := m
+		f(&c)
+		return c
+	}
+
+	tests := []struct {
+		name    string
+		m       Manifest
+		sig     string
+		key     *[crypto.Ed25519PublicKeySize]byte
+		wantErr string
+	}{
+		{name: "digest only", m: m},
+		{name: "digest uppercase", m: with(func(c *Manifest) { c.SHA256 = strings.ToUpper(digest) })},
+		{name: "signed", m: m, sig: sigHex, key: &kp.PublicKey},
+		{name: "missing digest", m: with(func(c *Manifest) { c.SHA256 = "" }), wantErr: "sha256 is required"},
+		{name: "wrong digest", m: with(func(c *Manifest) { c.SHA256 = strings.Repeat("0", 64) }), wantErr: "sha256 mismatch"},
+		{name: "missing signature", m: m, key: &kp.PublicKey, wantErr: "signature is required"},
+		{name: "bad encoding", m: m, sig: "zz", key: &kp.PublicKey, wantErr: "invalid signature encoding"},
+		{name: "wrong key", m: m, sig: hex.EncodeToString(otherSig[:]), key: &kp.PublicKey, wantErr: "verification failed"},
+		{name: "other version", m: with(func(c *Manifest) { c.Version = "1.5.0" }), sig: sigHex, key: &kp.PublicKey, wantErr: "verification failed"},
+		{name: "other platform", m: with(func(c *Manifest) { c.Arch = "arm64" }), sig: sigHex, key: &kp.PublicKey, wantErr: "verification failed"},
+		{name: "force added", m: with(func(c *Manifest) { c.Force = true }), sig: sigHex, key: &kp.PublicKey, wantErr: "verification failed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Verify(path, tt.m, tt.sig, tt.key)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestIsOlder(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"1.4.0", "1.4.0", false},
+		{"1.3.9", "1.4.0", true},
+		{"1.10.0", "1.9.0", false},
+		{"v1.4.0", "1.4.1", true},
+		{"1.4.0-rc1", "1.4.0", true},
+		{"1.4.0", "1.4.0-rc1", false},
+		{"1.4.0-rc1", "1.4.0-rc2", true},
+		{"1.4.0+build5", "1.4.0", false},
+		{"dev-a1b2c3d", "dev-a1b2c3d", false},
+		{"dev-a1b2c3d", "dev-e4f5a6b", true},
+		{"1.5.0", "dev-a1b2c3d", true},
+	}
+	for _, tt := range tests {
+		if got := IsOlder(tt.a, tt.b); got != tt.want {
+			t.Errorf("IsOlder(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+// writeVersionScript writes a staged "binary" that reports version.
+func writeVersionScript(t *testing.T, version string) string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("shell script binaries need a Unix shell")
+	}
+	path := filepath.Join(t.TempDir(), "muti-metroo")
+	writeFile(t, path, "#!/bin/sh\necho \"muti-metroo version "+version+"\"\n")
+	return path
+}
+
+func TestCheck_ReplayedOlderSignatureRejected(t *testing.T) {
+	kp, err := crypto.GenerateSigningKeypair()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A signed 1.3.0 update captured earlier and replayed against an agent
+	// that already runs 1.4.0.
+	path := writeVersionScript(t, "1.3.0")
+	digest, err := FileSHA256(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := Manifest{SHA256: digest, Version: "1.3.0", OS: runtime.GOOS, Arch: runtime.GOARCH}
+	sig := crypto.Sign(kp.PrivateKey, old.SignableBytes())
+	sigHex := hex.EncodeToString(sig[:])
+
+	_, err = Check(context.Background(), path, old, sigHex, &kp.PublicKey, "1.4.0")
+	if err == nil || !strings.Contains(err.Error(), "refusing to downgrade") {
+		t.Errorf("replayed signature: error = %v, want downgrade refusal", err)
+	}
+
+	// Claiming a newer version, or force, breaks the signature.
+	newer := old
+	newer.Version = "1.5.0"
+	if _, err := Check(context.Background(), path, newer, sigHex, &kp.PublicKey, "1.4.0"); err == nil || !strings.Contains(err.Error(), "verification failed") {
+		t.Errorf("relabelled version: error = %v, want verification failure", err)
+	}
+	forced := old
+	forced.Force = true
+	if _, err := Check(context.Background(), path, forced, sigHex, &kp.PublicKey, "1.4.0"); err == nil || !strings.Contains(err.Error(), "verification failed") {
+		t.Errorf("force without signature: error = %v, want verification failure", err)
+	}
+
+	// A downgrade signed with force is installed.
+	forcedSig := crypto.Sign(kp.PrivateKey, forced.SignableBytes())
+	version, err := Check(context.Background(), path, forced, hex.EncodeToString(forcedSig[:]), &kp.PublicKey, "1.4.0")
+	if err != nil {
+		t.Fatalf("signed forced downgrade: %v", err)
+	}
+	if version != "1.3.0" {
+		t.Errorf("version = %s, want 1.3.0", version)
+	}
+}
+
+func TestCheck_VersionMismatch(t *testing.T) {
+	path := writeVersionScript(t, "1.5.0")
+	digest, err := FileSHA256(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := Manifest{SHA256: digest, Version: "1.6.0", OS: runtime.GOOS, Arch: runtime.GOARCH}
+	if _, err := Check(context.Background(), path, m, "", nil, "1.4.0"); err == nil || !strings.Contains(err.Error(), "reports version 1.5.0") {
+		t.Errorf("error = %v, want version mismatch", err)
+	}
+
+	m.Version = ""
+	if _, err := Check(context.Background(), path, m, "", nil, "1.4.0"); err == nil || !strings.Contains(err.Error(), "version is required") {
+		t.Errorf("error = %v, want version required", err)
+	}
+
+	m.Version = "1.5.0"
+	if _, err := Check(context.Background(), path, m, "", nil, "1.4.0"); err != nil {
+		t.Errorf("upgrade: %v", err)
+	}
+}
+
+func TestInstall(t *testing.T) {
+	dir := t.TempDir()
+	staged := filepath.Join(dir, "staged")
+	target := filepath.Join(dir, "muti-metroo")
+	writeFile(t, staged, "new")
+	writeFile(t, target, "old")
+
+	if err := Install(staged, target); err != nil {
+		t.Fatalf("Install: %v", err)
+	}
+
+	got, err := os.ReadFile(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "new" {
+		t.Errorf("target = %q, want %q", got, "new")
+	}
+	if _, err := os.Stat(target + ".new"); !os.IsNotExist(err) {
+		t.Error("temporary file left behind")
+	}
+	if _, err := os.Stat(staged); err != nil {
+		t.Error("staged binary should be kept")
+	}
+}
+
+func TestInstall_MissingTarget(t *testing.T) {
+	dir := t.TempDir()
+	staged := filepath.Join(dir, "staged")
+	writeFile(t, staged, "new")
+
+	if err := Install(staged, filepath.Join(dir, "missing")); err == nil {
+		t.Error("expected error for missing target")
+	}
+}
@@ -46,6 +46,10 @@ type ServiceConfig struct {
 
 	// Group is the group to run the service as (Linux only, empty for root)
 	Group string
+
+	// Dependencies are services that must be running before this one starts,
+	// in addition to the TCP/IP stack (Windows only)
+	Dependencies []string
 }
 
 // DefaultConfig returns a default service configuration.
@@ -399,6 +403,25 @@ func IsInteractive() bool {
 	return isInteractiveImpl()
 }
 
+// serviceDirEnv is set in a Windows service's environment to the working
+// directory chosen at install time.
+const serviceDirEnv = "MUTI_METROO_SERVICE_DIR"
+
+// EnterWorkingDir changes to the service working directory recorded at
+// install time. Windows starts services in System32, so relative paths in the
+// config would otherwise resolve there. Call it before loading the config;
+// it does nothing when running interactively.
+func EnterWorkingDir() error {
+	dir := os.Getenv(serviceDirEnv)
+	if dir == "" || IsInteractive() {
+		return nil
+	}
+	if err := os.Chdir(dir); err != nil {
+		return fmt.Errorf("failed to enter service working directory: %w", err)
+	}
+	return nil
+}
+
 // RunAsService runs the given ServiceRunner as a Windows service.
 // This should only be called when IsInteractive() returns false.
 // On non-Windows platforms, this is a no-op that returns nil.
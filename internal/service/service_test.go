@@ -1,6 +1,7 @@
 package service
 
 import (
+	"os"
 	"path/filepath"
 	"runtime"
 	"testing"
@@ -212,6 +213,23 @@ func TestIsRoot(t *testing.T) {
 	_ = isRoot
 }
 
+func TestEnterWorkingDirInteractive(t *testing.T) {
+	// Tests run interactively, so the recorded directory must be ignored
+	t.Setenv(serviceDirEnv, t.TempDir())
+
+	before, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := EnterWorkingDir(); err != nil {
+		t.Fatalf("EnterWorkingDir() error = %v", err)
+	}
+	after, _ := os.Getwd()
+	if after != before {
+		t.Errorf("working directory changed to %s", after)
+	}
+}
+
 func TestIsInstalled(t *testing.T) {
 	// Test with a service name that definitely doesn't exist
 	installed := IsInstalled("definitely-not-installed-service-12345")
@@ -12,8 +12,10 @@ import (
 	"strings"
 	"syscall"
 	"time"
+	"unicode/utf16"
 	"unsafe"
 
+	"github.com/postalsys/muti-metroo/internal/logging"
 	"golang.org/x/sys/windows"
 	"golang.org/x/sys/windows/registry"
 	"golang.org/x/sys/windows/svc"
@@ -45,6 +47,29 @@ const (
 	SERVICE_RUNNING           = 0x4
 )
 
+// serviceStopTimeout bounds a graceful stop, like TimeoutStopSec in the
+// systemd unit. The SCM is told how long to wait before each progress update.
+const (
+	serviceStopTimeout   = 30 * time.Second
+	serviceStopGrace     = 5 * time.Second
+	serviceStopProgress  = 2 * time.Second
+	serviceStopWaitHint  = 5 * time.Second
+	serviceRecoveryReset = 24 * time.Hour
+)
+
+// serviceRecoveryActions restart the service after a failure with backoff,
+// like Restart=on-failure in the systemd unit. The SCM repeats the last
+// action for further failures and resets the count after serviceRecoveryReset.
+var serviceRecoveryActions = []windows.SC_ACTION{
+	{Type: windows.SC_ACTION_RESTART, Delay: 5000},
+	{Type: windows.SC_ACTION_RESTART, Delay: 30000},
+	{Type: windows.SC_ACTION_RESTART, Delay: 60000},
+}
+
+// defaultServiceDependencies are always declared, so the agent starts after
+// the TCP/IP stack, like After=network-online.target on Linux.
+var defaultServiceDependencies = []string{"Tcpip"}
+
 type serviceStatus struct {
 	serviceType             uint32
 	currentState            uint32
@@ -126,6 +151,7 @@ func installWindowsService(cfg ServiceConfig, execPath string, embedded bool) er
 	namePtr, _ := syscall.UTF16PtrFromString(cfg.Name)
 	displayNamePtr, _ := syscall.UTF16PtrFromString(cfg.DisplayName)
 	cmdLinePtr, _ := syscall.UTF16PtrFromString(cmdLine)
+	deps := multiSZ(serviceDependencies(cfg))
 
 	r1, _, err := procCreateService.Call(
 		scManager,
@@ -138,7 +164,7 @@ func installWindowsService(cfg ServiceConfig, execPath string, embedded bool) er
 		uintptr(unsafe.Pointer(cmdLinePtr)),
 		0, // No load order group
 		0, // No tag
-		0, // No dependencies
+		uintptr(unsafe.Pointer(&deps[0])),
 		0, // LocalSystem account
 		0, // No password
 	)
@@ -154,6 +180,26 @@ func installWindowsService(cfg ServiceConfig, execPath string, embedded bool) er
 		setServiceDescription(serviceHandle, cfg.Description)
 	}
 
+	if err := setServiceDelayedStart(serviceHandle); err != nil {
+		fmt.Printf("Note: failed to enable delayed start: %v\n", err)
+	}
+	if err := setServiceRecovery(serviceHandle); err != nil {
+		fmt.Printf("Note: failed to configure recovery actions: %v\n", err)
+	}
+
+	// Services start in System32; record the working directory so relative
+	// paths in the config resolve as they do under systemd
+	if cfg.WorkingDir != "" {
+		if err := setServiceWorkingDir(cfg.Name, cfg.WorkingDir); err != nil {
+			fmt.Printf("Note: failed to set working directory: %v\n", err)
+		}
+	}
+
+	// Register the service name as an event source for agent.log_output: eventlog
+	if err := logging.RegisterEventSource(cfg.Name); err != nil {
+		fmt.Printf("Note: failed to register event log source: %v\n", err)
+	}
+
 	r1, _, err = procStartService.Call(serviceHandle, 0, 0)
 	if r1 == 0 {
 		fmt.Printf("Note: service created but failed to start: %v\n", err)
@@ -225,6 +271,8 @@ func uninstallImpl(serviceName string) error {
 		return fmt.Errorf("failed to delete service: %w", err)
 	}
 
+	_ = logging.UnregisterEventSource(serviceName)
+
 	fmt.Printf("Removed Windows service: %s\n", serviceName)
 	return nil
 }
@@ -315,6 +363,79 @@ func setServiceDescription(serviceHandle uintptr, description string) {
 	procChangeServiceConfig2.Call(serviceHandle, 1, uintptr(unsafe.Pointer(&sd)))
 }
 
+// serviceDependencies returns the services that must run before this one:
+// the defaults plus cfg.Dependencies, without duplicates.
+func serviceDependencies(cfg ServiceConfig) []string {
+	deps := append([]string(nil), defaultServiceDependencies...)
+	for _, dep := range cfg.Dependencies {
+		dep = strings.TrimSpace(dep)
+		if dep == "" {
+			continue
+		}
+		duplicate := false
+		for _, existing := range deps {
+			if strings.EqualFold(existing, dep) {
+				duplicate = true
+				break
+			}
+		}
+		if !duplicate {
+			deps = append(deps, dep)
+		}
+	}
+	return deps
+}
+
+// multiSZ encodes strings as a UTF-16 list terminated by an empty string,
+// the format CreateService expects for dependencies.
+func multiSZ(items []string) []uint16 {
+	var buf []uint16
+	for _, item := range items {
+		buf = append(buf, utf16.Encode([]rune(item))...)
+		buf = append(buf, 0)
+	}
+	return append(buf, 0)
+}
+
+// setServiceDelayedStart makes the automatic start wait until the other
+// auto-start services are up, so the network is usually ready.
+func setServiceDelayedStart(serviceHandle uintptr) error {
+	info := windows.SERVICE_DELAYED_AUTO_START_INFO{IsDelayedAutoStartUp: 1}
+	return windows.ChangeServiceConfig2(windows.Handle(serviceHandle),
+		windows.SERVICE_CONFIG_DELAYED_AUTO_START_INFO, (*byte)(unsafe.Pointer(&info)))
+}
+
+// setServiceRecovery configures serviceRecoveryActions. They also apply when
+// the agent stops itself with an error, not only when the process crashes.
+func setServiceRecovery(serviceHandle uintptr) error {
+	handle := windows.Handle(serviceHandle)
+	actions := windows.SERVICE_FAILURE_ACTIONS{
+		ResetPeriod:  uint32(serviceRecoveryReset / time.Second),
+		ActionsCount: uint32(len(serviceRecoveryActions)),
+		Actions:      &serviceRecoveryActions[0],
+	}
+	if err := windows.ChangeServiceConfig2(handle,
+		windows.SERVICE_CONFIG_FAILURE_ACTIONS, (*byte)(unsafe.Pointer(&actions))); err != nil {
+		return err
+	}
+
+	flag := windows.SERVICE_FAILURE_ACTIONS_FLAG{FailureActionsOnNonCrashFailures: 1}
+	return windows.ChangeServiceConfig2(handle,
+		windows.SERVICE_CONFIG_FAILURE_ACTIONS_FLAG, (*byte)(unsafe.Pointer(&flag)))
+}
+
+// setServiceWorkingDir stores dir in the service's environment, where
+// EnterWorkingDir picks it up when the service starts.
+func setServiceWorkingDir(serviceName, dir string) error {
+	key, err := registry.OpenKey(registry.LOCAL_MACHINE,
+		`SYSTEM\CurrentControlSet\Services\`+serviceName, registry.SET_VALUE)
+	if err != nil {
+		return err
+	}
+	defer key.Close()
+	return key.SetStringsValue("Environment", []string{serviceDirEnv + "=" + dir})
+}
+
 // isInteractiveImpl returns true if the process is running interactively (not as a Windows service).
 func isInteractiveImpl() bool {
 	isService, err := svc.IsWindowsService()
@@ -336,8 +457,12 @@ type windowsServiceHandler struct {
 }
 
 // Execute implements svc.Handler.Execute.
+//
+// Requested stops always exit with code 0: a non-zero code would trigger the
+// recovery actions and restart the service that was just stopped.
 func (h *windowsServiceHandler) Execute(args []string, r <-chan svc.ChangeRequest, changes chan<- svc.Status) (ssec bool, errno uint32) {
-	const cmdsAccepted = svc.AcceptStop | svc.AcceptShutdown
+	// Pre-shutdown gives a longer window than shutdown to close streams
+	const cmdsAccepted = svc.AcceptStop | svc.AcceptShutdown | svc.AcceptPreShutdown
 
 	// Report Running immediately so the SCM does not time out while the
 	// agent performs its startup delay.
@@ -363,12 +488,12 @@ func (h *windowsServiceHandler) Execute(args []string, r <-chan svc.ChangeReques
 			switch c.Cmd {
 			case svc.Interrogate:
 				changes <- c.CurrentStatus
-			case svc.Stop, svc.Shutdown:
-				changes <- svc.Status{State: svc.StopPending}
-				ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-				h.runner.StopWithContext(ctx)
-				cancel()
-				<-startErrCh
+			case svc.Stop, svc.Shutdown, svc.PreShutdown:
+				h.stop(changes)
+				select {
+				case <-startErrCh:
+				case <-time.After(serviceStopGrace):
+				}
 				return false, 0
 			}
 		}
@@ -382,20 +507,48 @@ running:
 			switch c.Cmd {
 			case svc.Interrogate:
 				changes <- c.CurrentStatus
-			case svc.Stop, svc.Shutdown:
-				changes <- svc.Status{State: svc.StopPending}
-				ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-				err := h.runner.StopWithContext(ctx)
-				cancel()
-				if err != nil {
-					return false, 2
-				}
+			case svc.Stop, svc.Shutdown, svc.PreShutdown:
+				h.stop(changes)
 				return false, 0
 			}
 		}
 	}
 }
 
+// stop stops the runner within serviceStopTimeout. It reports StopPending
+// progress meanwhile so the SCM does not treat the stop as hung, and gives up
+// serviceStopGrace after the deadline if the runner ignores it.
+func (h *windowsServiceHandler) stop(changes chan<- svc.Status) {
+	waitHint := uint32(serviceStopWaitHint / time.Millisecond)
+	changes <- svc.Status{State: svc.StopPending, WaitHint: waitHint}
+
+	ctx, cancel := context.WithTimeout(context.Background(), serviceStopTimeout)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- h.runner.StopWithContext(ctx)
+	}()
+
+	deadline := time.NewTimer(serviceStopTimeout + serviceStopGrace)
+	defer deadline.Stop()
+	progress := time.NewTicker(serviceStopProgress)
+	defer progress.Stop()
+
+	var checkPoint uint32
+	for {
+		select {
+		case <-done:
+			return
+		case <-deadline.C:
+			return
+		case <-progress.C:
+			checkPoint++
+			changes <- svc.Status{State: svc.StopPending, CheckPoint: checkPoint, WaitHint: waitHint}
+		}
+	}
+}
+
 // =============================================================================
 // Windows User Service (Registry Run key + rundll32)
 // =============================================================================
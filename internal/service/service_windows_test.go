@@ -283,3 +283,28 @@ config=C:\path\to\config.yaml
 		}
 	})
 }
+
+func TestServiceDependencies(t *testing.T) {
+	deps := serviceDependencies(ServiceConfig{Dependencies: []string{"Dnscache", "tcpip", " ", "Dnscache"}})
+	want := []string{"Tcpip", "Dnscache"}
+	if strings.Join(deps, ",") != strings.Join(want, ",") {
+		t.Errorf("serviceDependencies() = %v, want %v", deps, want)
+	}
+}
+
+func TestMultiSZ(t *testing.T) {
+	got := multiSZ([]string{"Tcpip", "Nsi"})
+	want := []uint16{'T', 'c', 'p', 'i', 'p', 0, 'N', 's', 'i', 0, 0}
+	if len(got) != len(want) {
+		t.Fatalf("multiSZ() = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("multiSZ() = %v, want %v", got, want)
+		}
+	}
+
+	if empty := multiSZ(nil); len(empty) != 1 || empty[0] != 0 {
+		t.Errorf("multiSZ(nil) = %v, want [0]", empty)
+	}
+}
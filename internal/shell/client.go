@@ -7,7 +7,9 @@ import (
 	"io"
 	"net/http"
 	"os"
+	"os/signal"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -28,8 +30,12 @@ type Client struct {
 	env         map[string]string
 	workDir     string
 	timeout     int
+	runAs       string
 
-	// Output writers (defaults to os.Stdout/os.Stderr)
+	forwardSignals bool
+
+	// Input reader and output writers (defaults to os.Stdin/os.Stdout/os.Stderr)
+	stdin  io.Reader
 	stdout io.Writer
 	stderr io.Writer
 
@@ -65,6 +71,16 @@ type ClientConfig struct {
 	WorkDir string
 	// Timeout is the session timeout in seconds (0 = no timeout)
 	Timeout int
+	// RunAs is the account to run as on the target agent, which must allow
+	// it in shell.run_as.allowed_users (empty = the agent's default)
+	RunAs string
+	// ForwardSignals catches local interrupt and termination signals while
+	// the session runs and sends them to the remote process. A signal that
+	// arrives before the session starts, or a second Ctrl-C within
+	// signalExitWindow, ends the session instead.
+	ForwardSignals bool
+	// Stdin is the reader for stdin in streaming mode (defaults to os.Stdin)
+	Stdin io.Reader
 	// Stdout is the writer for stdout (defaults to os.Stdout)
 	Stdout io.Writer
 	// Stderr is the writer for stderr (defaults to os.Stderr)
@@ -80,7 +96,11 @@ func NewClient(cfg ClientConfig) *Client {
 
 	url := fmt.Sprintf("ws://%s/agents/%s/shell?mode=%s", cfg.AgentAddr, cfg.TargetID, mode)
 
-	// Set default output writers
+	// Set default input and output
+	stdin := cfg.Stdin
+	if stdin == nil {
+		stdin = os.Stdin
+	}
 	stdout := cfg.Stdout
 	if stdout == nil {
 		stdout = os.Stdout
@@ -102,9 +122,13 @@ func NewClient(cfg ClientConfig) *Client {
 		env:         cfg.Env,
 		workDir:     cfg.WorkDir,
 		timeout:     cfg.Timeout,
+		runAs:       cfg.RunAs,
+		stdin:       stdin,
 		stdout:      stdout,
 		stderr:      stderr,
 		done:        make(chan struct{}),
+
+		forwardSignals: cfg.ForwardSignals,
 	}
 }
 
@@ -115,6 +139,21 @@ func (c *Client) Run(ctx context.Context) (int, error) {
 		c.fetchAgentInfo()
 	}
 
+	// Catch signals for the whole run: until the session starts they
+	// cancel it, afterwards they are forwarded
+	var sigCh chan os.Signal
+	var started atomic.Bool
+	if c.forwardSignals {
+		var cancelRun context.CancelFunc
+		ctx, cancelRun = context.WithCancel(ctx)
+		defer cancelRun()
+
+		sigCh = make(chan os.Signal, 1)
+		signal.Notify(sigCh, forwardedSignals...)
+		defer signal.Stop(sigCh)
+		go c.handleSignals(ctx, cancelRun, sigCh, &started)
+	}
+
 	// Connect to WebSocket
 	dialOpts := &websocket.DialOptions{
 		Subprotocols: []string{"muti-shell"},
@@ -139,6 +178,7 @@ func (c *Client) Run(ctx context.Context) (int, error) {
 		WorkDir:  c.workDir,
 		Password: c.password,
 		Timeout:  c.timeout,
+		RunAs:    c.runAs,
 	}
 
 	// Get terminal size if interactive
@@ -193,7 +233,7 @@ func (c *Client) Run(ctx context.Context) (int, error) {
 		if err := json.Unmarshal(payload, &shellErr); err != nil {
 			return 1, fmt.Errorf("remote error: %s", string(payload))
 		}
-		return 1, fmt.Errorf("remote error: %s", shellErr.Message)
+		return 1, shellErr.remoteError()
 	}
 
 	if msgType != MsgAck {
@@ -233,17 +273,23 @@ func (c *Client) Run(ctx context.Context) (int, error) {
 
 	var wg sync.WaitGroup
 
-	// Handle window resize (SIGWINCH) in interactive mode
+	// Handle window resize in interactive mode
 	if c.interactive {
-		sigCh := make(chan os.Signal, 1)
-		setupResizeSignal(sigCh)
-		wg.Add(1)
+		resizeCh := make(chan struct{}, 1)
+		wg.Add(2)
 		go func() {
 			defer wg.Done()
-			c.handleResize(sessionCtx, sigCh)
+			watchResize(sessionCtx, resizeCh)
+		}()
+		go func() {
+			defer wg.Done()
+			c.handleResize(sessionCtx, resizeCh)
 		}()
 	}
 
+	// From here on signals go to the remote process
+	started.Store(true)
+
 	// Read from stdin and send to WebSocket
 	// Note: pumpStdin is NOT added to wg because os.Stdin.Read() is a blocking
 	// syscall that doesn't respect context cancellation. The goroutine will exit
@@ -276,7 +322,7 @@ func (c *Client) Run(ctx context.Context) (int, error) {
 	return int(c.exitCode), c.exitError
 }
 
-// pumpStdin reads from stdin and sends to WebSocket.
+// pumpStdin reads from the stdin reader and sends to WebSocket.
 func (c *Client) pumpStdin(ctx context.Context) {
 	buf := make([]byte, 4096)
 	for {
@@ -286,7 +332,7 @@ func (c *Client) pumpStdin(ctx context.Context) {
 		default:
 		}
 
-		n, err := os.Stdin.Read(buf)
+		n, err := c.stdin.Read(buf)
 		if n > 0 {
 			msg := EncodeStdin(buf[:n])
 			if err := c.conn.Write(ctx, websocket.MessageBinary, msg); err != nil {
@@ -319,7 +365,7 @@ func (c *Client) pumpOutput(ctx context.Context) {
 			case <-c.done:
 				// MsgExit already received
 			default:
-				if websocket.CloseStatus(err) != websocket.StatusNormalClosure {
+				if ctx.Err() == nil && websocket.CloseStatus(err) != websocket.StatusNormalClosure {
 					c.setError(err)
 				}
 				close(c.done)
@@ -354,7 +400,7 @@ func (c *Client) pumpOutput(ctx context.Context) {
 			if err := json.Unmarshal(payload, &shellErr); err != nil {
 				c.setError(fmt.Errorf("remote error: %s", string(payload)))
 			} else {
-				c.setError(fmt.Errorf("remote error: %s", shellErr.Message))
+				c.setError(shellErr.remoteError())
 			}
 			close(c.done)
 			return
@@ -362,13 +408,13 @@ func (c *Client) pumpOutput(ctx context.Context) {
 	}
 }
 
-// handleResize handles SIGWINCH signals and sends resize messages.
-func (c *Client) handleResize(ctx context.Context, sigCh <-chan os.Signal) {
+// handleResize sends the terminal size whenever it changes.
+func (c *Client) handleResize(ctx context.Context, resizeCh <-chan struct{}) {
 	for {
 		select {
 		case <-ctx.Done():
 			return
-		case <-sigCh:
+		case <-resizeCh:
 			if !term.IsTerminal(int(os.Stdin.Fd())) {
 				continue
 			}
@@ -393,9 +439,50 @@ func (c *Client) setError(err error) {
 	c.mu.Unlock()
 }
 
+// signalExitWindow is how soon a second Ctrl-C must follow the first to end
+// the session locally instead of being forwarded.
+const signalExitWindow = time.Second
+
+// handleSignals forwards caught signals to the remote process once the
+// session has started. Before that, or on a repeated Ctrl-C, it calls cancel.
+func (c *Client) handleSignals(ctx context.Context, cancel context.CancelFunc, sigCh <-chan os.Signal, started *atomic.Bool) {
+	var lastInt time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case s := <-sigCh:
+			sig, ok := s.(syscall.Signal)
+			if !ok || !started.Load() {
+				cancel()
+				return
+			}
+			if sig == syscall.SIGINT {
+				if time.Since(lastInt) < signalExitWindow {
+					// Exit like a shell killed by SIGINT
+					c.mu.Lock()
+					c.exitCode = 130
+					c.mu.Unlock()
+					cancel()
+					return
+				}
+				lastInt = time.Now()
+			}
+			if err := c.SendSignal(ctx, sig); err != nil {
+				cancel()
+				return
+			}
+		}
+	}
+}
+
 // SendSignal sends a signal to the remote process.
 func (c *Client) SendSignal(ctx context.Context, sig syscall.Signal) error {
-	msg := EncodeSignal(uint8(sig))
+	signum, ok := WireSignal(sig)
+	if !ok {
+		return fmt.Errorf("signal %d has no wire number", sig)
+	}
+	msg := EncodeSignal(signum)
 	return c.conn.Write(ctx, websocket.MessageBinary, msg)
 }
 
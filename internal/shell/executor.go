@@ -13,6 +13,7 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/postalsys/muti-metroo/internal/sandbox"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -26,6 +27,10 @@ type Config struct {
 	// Commands should be base names only (e.g., "whoami", "ls", "bash").
 	Whitelist []string `yaml:"whitelist"`
 
+	// Rules restrict the arguments and environment of specific commands.
+	// A command with rules is allowed even if it is not in Whitelist.
+	Rules []Rule `yaml:"rules"`
+
 	// PasswordHash is the bcrypt hash of the shell password.
 	// If set, all shell requests must include the correct password.
 	PasswordHash string `yaml:"password_hash"`
@@ -35,6 +40,12 @@ type Config struct {
 
 	// MaxSessions limits concurrent shell sessions (0 = unlimited)
 	MaxSessions int `yaml:"max_sessions"`
+
+	// Sandbox confines every command (nil = no sandbox, Linux only)
+	Sandbox *sandbox.Profile `yaml:"-"`
+
+	// RunAs selects the account commands execute as
+	RunAs RunAs `yaml:"run_as"`
 }
 
 // DefaultConfig returns default shell configuration (disabled).
@@ -49,6 +60,7 @@ func DefaultConfig() Config {
 // Executor handles shell command execution with security checks.
 type Executor struct {
 	config   Config
+	rules    map[string][]compiledRule // Command -> rules
 	mu       sync.Mutex
 	sessions int // Active session count
 }
@@ -57,6 +69,7 @@ type Executor struct {
 func NewExecutor(cfg Config) *Executor {
 	return &Executor{
 		config: cfg,
+		rules:  compileRules(cfg.Rules),
 	}
 }
 
@@ -189,11 +202,12 @@ type Session struct {
 	mu          sync.Mutex
 	started     bool
 	startTime   time.Time
+	release     func() // Frees what was acquired to start as another account
 }
 
 // validateAndAcquire performs common validation for all session types:
-// checks if shell is enabled, validates authentication, command whitelist,
-// arguments, and acquires a session slot.
+// checks if shell is enabled, validates authentication, the command policy
+// (whitelist, argument and environment rules), and acquires a session slot.
 func (e *Executor) validateAndAcquire(meta *ShellMeta) error {
 	if !e.config.Enabled {
 		return fmt.Errorf("shell is disabled")
@@ -203,14 +217,19 @@ func (e *Executor) validateAndAcquire(meta *ShellMeta) error {
 		return err
 	}
 
-	if !e.IsCommandAllowed(meta.Command) {
-		return fmt.Errorf("command '%s' is not allowed", meta.Command)
+	if err := e.CheckPolicy(meta); err != nil {
+		return err
 	}
 
-	if err := e.ValidateArgs(meta.Args); err != nil {
+	if err := e.checkRunAs(meta); err != nil {
 		return err
 	}
 
+	// Never run commands unconfined when a sandbox is configured
+	if e.config.Sandbox != nil && !sandbox.Supported() {
+		return fmt.Errorf("shell sandbox: %w", sandbox.ErrUnsupported)
+	}
+
 	return e.AcquireSession()
 }
 
@@ -240,9 +259,18 @@ func (e *Executor) NewSession(ctx context.Context, meta *ShellMeta) (*Session, e
 	// Create command
 	cmd := exec.CommandContext(sessionCtx, meta.Command, meta.Args...)
 
+	release, err := e.runAs(cmd, meta)
+	if err != nil {
+		cancel()
+		e.ReleaseSession()
+		return nil, err
+	}
+
 	// Set up environment
 	if len(meta.Env) > 0 {
-		cmd.Env = os.Environ()
+		if cmd.Env == nil {
+			cmd.Env = os.Environ()
+		}
 		for k, v := range meta.Env {
 			cmd.Env = append(cmd.Env, k+"="+v)
 		}
@@ -253,9 +281,17 @@ func (e *Executor) NewSession(ctx context.Context, meta *ShellMeta) (*Session, e
 		cmd.Dir = meta.WorkDir
 	}
 
+	if err := e.confine(cmd); err != nil {
+		release()
+		cancel()
+		e.ReleaseSession()
+		return nil, err
+	}
+
 	// Set up pipes
 	stdin, err := cmd.StdinPipe()
 	if err != nil {
+		release()
 		cancel()
 		e.ReleaseSession()
 		return nil, fmt.Errorf("failed to create stdin pipe: %w", err)
@@ -264,6 +300,7 @@ func (e *Executor) NewSession(ctx context.Context, meta *ShellMeta) (*Session, e
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
 		stdin.Close()
+		release()
 		cancel()
 		e.ReleaseSession()
 		return nil, fmt.Errorf("failed to create stdout pipe: %w", err)
@@ -273,6 +310,7 @@ func (e *Executor) NewSession(ctx context.Context, meta *ShellMeta) (*Session, e
 	if err != nil {
 		stdin.Close()
 		stdout.Close()
+		release()
 		cancel()
 		e.ReleaseSession()
 		return nil, fmt.Errorf("failed to create stderr pipe: %w", err)
@@ -288,6 +326,7 @@ func (e *Executor) NewSession(ctx context.Context, meta *ShellMeta) (*Session, e
 		done:      make(chan struct{}),
 		exitCode:  -1,
 		startTime: time.Now(),
+		release:   release,
 	}
 
 	return session, nil
@@ -347,7 +386,9 @@ func (s *Session) Start() error {
 		return fmt.Errorf("session already started")
 	}
 
-	if err := s.cmd.Start(); err != nil {
+	err := s.cmd.Start()
+	s.releaseAccount()
+	if err != nil {
 		s.mu.Unlock()
 		return fmt.Errorf("failed to start command: %w", err)
 	}
@@ -449,6 +490,7 @@ func (s *Session) Close() {
 	if s.started && s.cmd.Process != nil {
 		s.cmd.Process.Kill()
 	}
+	s.releaseAccount()
 	s.mu.Unlock()
 
 	// Wait for done
@@ -459,6 +501,15 @@ func (s *Session) Close() {
 	}
 }
 
+// releaseAccount frees what was acquired to start as another account, once.
+// Must be called with s.mu held.
+func (s *Session) releaseAccount() {
+	if s.release != nil {
+		s.release()
+		s.release = nil
+	}
+}
+
 // Duration returns how long the session has been running.
 func (s *Session) Duration() time.Duration {
 	return time.Since(s.startTime)
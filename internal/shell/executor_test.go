@@ -2,11 +2,13 @@ package shell
 
 import (
 	"context"
+	"os"
 	"runtime"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/postalsys/muti-metroo/internal/sandbox"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -334,6 +336,50 @@ func TestExecutor_NewSession(t *testing.T) {
 	}
 }
 
+// TestMain lets the test binary act as the sandbox helper.
+func TestMain(m *testing.M) {
+	sandbox.MaybeExec()
+	os.Exit(m.Run())
+}
+
+func TestExecutor_NewSession_Sandbox(t *testing.T) {
+	exec := NewExecutor(Config{
+		Enabled:     true,
+		MaxSessions: 10,
+		Whitelist:   []string{"*"},
+		Sandbox:     &sandbox.Profile{ReadOnly: []string{"/"}},
+	})
+
+	meta := &ShellMeta{Command: "echo", Args: []string{"hello"}}
+	session, err := exec.NewSession(context.Background(), meta)
+	if !sandbox.Supported() {
+		if err == nil {
+			session.Close()
+			t.Fatal("NewSession() should refuse to run unconfined")
+		}
+		if exec.ActiveSessions() != 0 {
+			t.Errorf("ActiveSessions() = %d, want 0 after refusal", exec.ActiveSessions())
+		}
+		return
+	}
+	if err != nil {
+		t.Fatalf("NewSession() error = %v", err)
+	}
+	defer func() {
+		session.Close()
+		exec.ReleaseSession()
+	}()
+
+	// The command starts through the sandbox helper (this binary)
+	self, _ := os.Executable()
+	if session.cmd.Path != self {
+		t.Errorf("cmd.Path = %q, want the sandbox helper %q", session.cmd.Path, self)
+	}
+	if n := len(session.cmd.Args); n < 2 || session.cmd.Args[n-1] != "hello" {
+		t.Errorf("cmd.Args = %v, want the original arguments last", session.cmd.Args)
+	}
+}
+
 func TestExecutor_NewSession_NotWhitelisted(t *testing.T) {
 	exec := NewExecutor(Config{
 		Enabled:     true,
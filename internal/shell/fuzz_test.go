@@ -0,0 +1,30 @@
+package shell
+
+import "testing"
+
+func FuzzDecodeMeta(f *testing.F) {
+	f.Add([]byte(`{"command":"whoami"}`))
+	f.Add([]byte(`{"command":"bash","args":["-l"],"env":{"TERM":"xterm"},"tty":{"rows":24,"cols":80},"timeout":60}`))
+
+	f.Fuzz(func(t *testing.T, payload []byte) {
+		meta, err := DecodeMeta(payload)
+		if err != nil {
+			return
+		}
+		encoded, err := EncodeMeta(meta)
+		if err != nil {
+			t.Fatalf("EncodeMeta() error = %v", err)
+		}
+		msgType, again, err := DecodeMessage(encoded)
+		if err != nil || msgType != MsgMeta {
+			t.Fatalf("DecodeMessage() = %d, %v", msgType, err)
+		}
+		// Escaping can grow the JSON past the cap, which is fine to reject.
+		if len(again) > MaxMetaSize {
+			return
+		}
+		if _, err := DecodeMeta(again); err != nil {
+			t.Fatalf("re-decode error = %v", err)
+		}
+	})
+}
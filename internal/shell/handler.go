@@ -2,10 +2,10 @@ package shell
 
 import (
 	"context"
+	"errors"
 	"io"
 	"log/slog"
 	"sync"
-	"syscall"
 	"time"
 
 	"github.com/postalsys/muti-metroo/internal/crypto"
@@ -18,6 +18,7 @@ import (
 type DataWriter interface {
 	WriteStreamData(peerID identity.AgentID, streamID uint64, data []byte, flags uint8) error
 	WriteStreamClose(peerID identity.AgentID, streamID uint64) error
+	WriteStreamReset(peerID identity.AgentID, streamID uint64, errorCode uint16) error
 }
 
 // ShellStream tracks an active shell stream.
@@ -167,9 +168,10 @@ func (h *Handler) HandleStreamData(peerID identity.AgentID, streamID uint64, dat
 
 	plaintext, err := sessionKey.Decrypt(data)
 	if err != nil {
-		h.logger.Error("failed to decrypt shell data",
+		h.logger.Warn("shell data failed E2E validation, stream reset",
 			logging.KeyStreamID, streamID,
 			logging.KeyError, err)
+		h.resetStream(ss, protocol.ErrE2EValidation)
 		return
 	}
 
@@ -213,8 +215,8 @@ func (h *Handler) handleMetadata(ss *ShellStream, data []byte) {
 	// Does not call releaseSession because no session is active at these
 	// error points (or release was already done manually), and ss.mu is
 	// already held by this function.
-	fail := func(msg string) {
-		h.sendError(ss, msg)
+	failWithReason := func(msg string, reason string) {
+		h.sendError(ss, msg, reason)
 
 		h.mu.Lock()
 		if !ss.Closed {
@@ -225,6 +227,9 @@ func (h *Handler) handleMetadata(ss *ShellStream, data []byte) {
 
 		h.writer.WriteStreamClose(ss.PeerID, ss.StreamID)
 	}
+	fail := func(msg string) {
+		failWithReason(msg, "")
+	}
 
 	msgType, payload, err := DecodeMessage(data)
 	if err != nil {
@@ -251,7 +256,7 @@ func (h *Handler) handleMetadata(ss *ShellStream, data []byte) {
 	if ss.IsInteractive && meta.TTY != nil {
 		ptySession, err := h.executor.NewPTYSession(ctx, meta)
 		if err != nil {
-			fail("failed to start PTY session: " + err.Error())
+			failWithReason("failed to start PTY session: "+err.Error(), denyReason(err))
 			return
 		}
 
@@ -264,7 +269,7 @@ func (h *Handler) handleMetadata(ss *ShellStream, data []byte) {
 	// Streaming session
 	session, err := h.executor.NewSession(ctx, meta)
 	if err != nil {
-		fail("failed to start session: " + err.Error())
+		failWithReason("failed to start session: "+err.Error(), denyReason(err))
 		return
 	}
 
@@ -349,13 +354,21 @@ func (h *Handler) handleSignal(ss *ShellStream, data []byte) {
 		return
 	}
 
+	sig, ok := LocalSignal(signum)
+	if !ok {
+		h.logger.Debug("unsupported signal",
+			logging.KeyStreamID, ss.StreamID,
+			"signal", SignalName(signum))
+		return
+	}
+
 	ss.mu.Lock()
 	defer ss.mu.Unlock()
 
 	if ss.PTYSession != nil {
-		ss.PTYSession.Signal(syscall.Signal(signum))
+		ss.PTYSession.Signal(sig)
 	} else if ss.Session != nil {
-		ss.Session.Signal(syscall.Signal(signum))
+		ss.Session.Signal(sig)
 	}
 }
 
@@ -541,10 +554,21 @@ func (h *Handler) sendAck(ss *ShellStream, success bool, errMsg string) {
 	h.writeEncrypted(ss, data, 0)
 }
 
-// sendError sends an error message.
-func (h *Handler) sendError(ss *ShellStream, errMsg string) {
+// denyReason returns the policy deny reason carried by err, if any.
+func denyReason(err error) string {
+	var deny *DenyError
+	if errors.As(err, &deny) {
+		return deny.Reason
+	}
+	return ""
+}
+
+// sendError sends an error message. reason is set when the command policy
+// rejected the request.
+func (h *Handler) sendError(ss *ShellStream, errMsg string, reason string) {
 	shellErr := &ShellError{
 		Message: errMsg,
+		Reason:  reason,
 	}
 	data, err := EncodeError(shellErr)
 	if err != nil {
@@ -580,6 +604,18 @@ func (h *Handler) releaseSession(ss *ShellStream) {
 
 // closeStream closes the stream and releases the session slot.
 func (h *Handler) closeStream(ss *ShellStream) {
+	h.endStream(ss)
+	h.writer.WriteStreamClose(ss.PeerID, ss.StreamID)
+}
+
+// resetStream aborts the stream with errorCode and releases the session slot.
+func (h *Handler) resetStream(ss *ShellStream, errorCode uint16) {
+	h.endStream(ss)
+	h.writer.WriteStreamReset(ss.PeerID, ss.StreamID, errorCode)
+}
+
+// endStream stops tracking the stream and releases the session slot.
+func (h *Handler) endStream(ss *ShellStream) {
 	h.mu.Lock()
 	if !ss.Closed {
 		ss.Closed = true
@@ -588,7 +624,6 @@ func (h *Handler) closeStream(ss *ShellStream) {
 	h.mu.Unlock()
 
 	h.releaseSession(ss)
-	h.writer.WriteStreamClose(ss.PeerID, ss.StreamID)
 }
 
 // ActiveStreams returns the number of active shell streams.
@@ -10,6 +10,7 @@ import (
 
 	"github.com/postalsys/muti-metroo/internal/crypto"
 	"github.com/postalsys/muti-metroo/internal/identity"
+	"github.com/postalsys/muti-metroo/internal/protocol"
 )
 
 // testEphemeralKey generates a test ephemeral key pair for testing.
@@ -55,6 +56,7 @@ type mockDataWriter struct {
 	mu       sync.Mutex
 	messages []mockMessage
 	closed   map[uint64]bool
+	resets   map[uint64]uint16
 }
 
 type mockMessage struct {
@@ -68,6 +70,7 @@ func newMockDataWriter() *mockDataWriter {
 	return &mockDataWriter{
 		messages: make([]mockMessage, 0),
 		closed:   make(map[uint64]bool),
+		resets:   make(map[uint64]uint16),
 	}
 }
 
@@ -87,6 +90,13 @@ func (m *mockDataWriter) WriteStreamClose(peerID identity.AgentID, streamID uint
 	return nil
 }
 
+func (m *mockDataWriter) WriteStreamReset(peerID identity.AgentID, streamID uint64, errorCode uint16) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.resets[streamID] = errorCode
+	return nil
+}
+
 func (m *mockDataWriter) getMessages() []mockMessage {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -101,6 +111,13 @@ func (m *mockDataWriter) isClosed(streamID uint64) bool {
 	return m.closed[streamID]
 }
 
+func (m *mockDataWriter) resetCode(streamID uint64) (uint16, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	code, ok := m.resets[streamID]
+	return code, ok
+}
+
 func mustNewAgentID(t *testing.T) identity.AgentID {
 	t.Helper()
 	id, err := identity.NewAgentID()
@@ -596,6 +613,47 @@ func TestHandler_HandleStreamClose_Twice(t *testing.T) {
 	}
 }
 
+func TestHandler_HandleStreamData_ReplayResetsStream(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Skipping streaming session test on Windows")
+	}
+
+	writer := newMockDataWriter()
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError}))
+
+	exec := NewExecutor(Config{
+		Enabled:     true,
+		MaxSessions: 10,
+		Whitelist:   []string{"*"},
+	})
+
+	handler := NewHandler(exec, writer, logger)
+
+	peerID := mustNewAgentID(t)
+	streamID := uint64(1)
+	requestID := uint64(1)
+
+	sessionKey := openStreamWithSessionKey(t, handler, peerID, streamID, requestID, false)
+
+	metaMsg, _ := EncodeMeta(&ShellMeta{Command: "sleep", Args: []string{"1"}})
+	encryptedMeta, err := sessionKey.Encrypt(metaMsg)
+	if err != nil {
+		t.Fatalf("failed to encrypt metadata: %v", err)
+	}
+	handler.HandleStreamData(peerID, streamID, encryptedMeta, 0)
+
+	// A transit node replaying the frame ends the session
+	handler.HandleStreamData(peerID, streamID, encryptedMeta, 0)
+
+	code, ok := writer.resetCode(streamID)
+	if !ok || code != protocol.ErrE2EValidation {
+		t.Errorf("reset code = %d (sent %v), want %d", code, ok, protocol.ErrE2EValidation)
+	}
+	if handler.ActiveStreams() != 0 {
+		t.Errorf("ActiveStreams() = %d, want 0", handler.ActiveStreams())
+	}
+}
+
 func TestHandler_HandleStreamData_InvalidMessage(t *testing.T) {
 	if runtime.GOOS == "windows" {
 		t.Skip("Skipping streaming session test on Windows")
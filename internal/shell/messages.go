@@ -16,11 +16,51 @@ const (
 	MsgStdout uint8 = 0x04 // Raw stdout bytes
 	MsgStderr uint8 = 0x05 // Raw stderr bytes
 	MsgResize uint8 = 0x06 // 4 bytes: rows (uint16 BE), cols (uint16 BE)
-	MsgSignal uint8 = 0x07 // 1 byte: signal number
+	MsgSignal uint8 = 0x07 // 1 byte: signal number (Sig* constants)
 	MsgExit   uint8 = 0x08 // 4 bytes: exit code (int32 BE)
 	MsgError  uint8 = 0x09 // JSON error message
 )
 
+// Signal numbers carried by SIGNAL messages. They follow Linux numbering on
+// every platform; each agent maps them to its own signals, so a client on
+// macOS can stop a process on Linux.
+const (
+	SigHUP   uint8 = 1
+	SigINT   uint8 = 2
+	SigQUIT  uint8 = 3
+	SigKILL  uint8 = 9
+	SigUSR1  uint8 = 10
+	SigUSR2  uint8 = 12
+	SigTERM  uint8 = 15
+	SigCONT  uint8 = 18
+	SigSTOP  uint8 = 19
+	SigTSTP  uint8 = 20
+	SigWINCH uint8 = 28
+)
+
+// signalNames maps wire signal numbers to their names without "SIG".
+var signalNames = map[uint8]string{
+	SigHUP:   "HUP",
+	SigINT:   "INT",
+	SigQUIT:  "QUIT",
+	SigKILL:  "KILL",
+	SigUSR1:  "USR1",
+	SigUSR2:  "USR2",
+	SigTERM:  "TERM",
+	SigCONT:  "CONT",
+	SigSTOP:  "STOP",
+	SigTSTP:  "TSTP",
+	SigWINCH: "WINCH",
+}
+
+// SignalName returns the name of a wire signal number, e.g. "SIGINT".
+func SignalName(signum uint8) string {
+	if name, ok := signalNames[signum]; ok {
+		return "SIG" + name
+	}
+	return fmt.Sprintf("signal %d", signum)
+}
+
 // MsgTypeName returns a human-readable name for a message type.
 func MsgTypeName(t uint8) string {
 	switch t {
@@ -56,6 +96,7 @@ type ShellMeta struct {
 	Password string            `json:"password,omitempty"` // Authentication password
 	TTY      *TTYSettings      `json:"tty,omitempty"`      // Non-nil = allocate PTY
 	Timeout  int               `json:"timeout,omitempty"`  // Session timeout in seconds (0 = no timeout)
+	RunAs    string            `json:"run_as,omitempty"`   // Account to run as instead of shell.run_as.user
 }
 
 // TTYSettings contains terminal configuration for interactive sessions.
@@ -74,7 +115,17 @@ type ShellAck struct {
 // ShellError is sent when an error occurs during the session.
 type ShellError struct {
 	Message string `json:"message"`
-	Code    int    `json:"code,omitempty"` // Optional error code
+	Code    int    `json:"code,omitempty"`   // Optional error code
+	Reason  string `json:"reason,omitempty"` // Deny reason when rejected by the command policy
+}
+
+// remoteError converts a received ShellError to an error. Policy denials
+// wrap a *DenyError so callers can inspect the reason.
+func (e *ShellError) remoteError() error {
+	if e.Reason != "" {
+		return fmt.Errorf("remote error: %w (%s)", &DenyError{Reason: e.Reason, Message: e.Message}, e.Reason)
+	}
+	return fmt.Errorf("remote error: %s", e.Message)
 }
 
 // EncodeMessage encodes a message with its type prefix.
@@ -102,8 +153,15 @@ func EncodeMeta(meta *ShellMeta) ([]byte, error) {
 	return EncodeMessage(MsgMeta, payload), nil
 }
 
+// MaxMetaSize is the largest ShellMeta payload accepted. META is sent in a
+// single frame, so anything larger did not come from an agent.
+const MaxMetaSize = 16 * 1024
+
 // DecodeMeta decodes a ShellMeta message from payload (without type prefix).
 func DecodeMeta(payload []byte) (*ShellMeta, error) {
+	if len(payload) > MaxMetaSize {
+		return nil, fmt.Errorf("meta too large: %d bytes exceeds %d", len(payload), MaxMetaSize)
+	}
 	var meta ShellMeta
 	if err := json.Unmarshal(payload, &meta); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal meta: %w", err)
@@ -2,6 +2,7 @@ package shell
 
 import (
 	"encoding/json"
+	"strings"
 	"testing"
 )
 
@@ -589,6 +590,11 @@ func TestDecodeMeta_Errors(t *testing.T) {
 			payload: []byte(`null`),
 			wantErr: false, // JSON null decodes to zero value
 		},
+		{
+			name:    "oversized payload",
+			payload: []byte(`{"command":"` + strings.Repeat("a", MaxMetaSize) + `"}`),
+			wantErr: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -808,3 +814,30 @@ func TestEncodeDecodeError_RoundTrip(t *testing.T) {
 		t.Errorf("Code = %d, want %d", decoded.Code, original.Code)
 	}
 }
+
+func TestSignalName(t *testing.T) {
+	if got := SignalName(SigINT); got != "SIGINT" {
+		t.Errorf("SignalName(SigINT) = %q, want %q", got, "SIGINT")
+	}
+	if got := SignalName(99); got != "signal 99" {
+		t.Errorf("SignalName(99) = %q, want %q", got, "signal 99")
+	}
+}
+
+func TestLocalWireSignal_RoundTrip(t *testing.T) {
+	for _, signum := range []uint8{SigHUP, SigINT, SigQUIT, SigKILL, SigTERM} {
+		sig, ok := LocalSignal(signum)
+		if !ok {
+			t.Errorf("LocalSignal(%s) not supported", SignalName(signum))
+			continue
+		}
+		back, ok := WireSignal(sig)
+		if !ok || back != signum {
+			t.Errorf("WireSignal(LocalSignal(%s)) = %d, %v", SignalName(signum), back, ok)
+		}
+	}
+
+	if _, ok := LocalSignal(99); ok {
+		t.Error("LocalSignal(99) should not be supported")
+	}
+}
@@ -0,0 +1,166 @@
+package shell
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Deny reasons reported in ShellError.Reason when a request is rejected by
+// the command policy.
+const (
+	DenyCommandNotAllowed = "command_not_allowed"
+	DenyArgsNotAllowed    = "args_not_allowed"
+	DenyEnvNotAllowed     = "env_not_allowed"
+	DenyDangerousArgs     = "dangerous_args"
+	DenyRunAsNotAllowed   = "run_as_not_allowed"
+)
+
+// DenyError is returned when the command policy rejects a request.
+type DenyError struct {
+	Reason  string // One of the Deny* constants
+	Message string
+}
+
+// Error implements the error interface.
+func (e *DenyError) Error() string {
+	return e.Message
+}
+
+// Rule restricts how a command may be invoked. A command with rules is
+// allowed when any of its rules matches; the whitelist alone no longer
+// applies to it.
+type Rule struct {
+	// Command is the base command name (e.g., "systemctl").
+	Command string `yaml:"command"`
+
+	// Args are glob patterns matched against the arguments joined by single
+	// spaces. "*" matches any text and "?" matches one character, so
+	// "status *" allows "status nginx" but not "stop nginx".
+	Args []string `yaml:"args"`
+
+	// ArgsRegex are regular expressions matched against the joined
+	// arguments. They are anchored at both ends.
+	ArgsRegex []string `yaml:"args_regex"`
+
+	// Env lists environment variables the caller may set. Empty = none,
+	// ["*"] = any.
+	Env []string `yaml:"env"`
+}
+
+// compiledRule is a Rule with its argument patterns compiled.
+type compiledRule struct {
+	patterns []*regexp.Regexp // nil = any arguments
+	env      map[string]bool
+	anyEnv   bool
+}
+
+// compileRules groups rules by command. An invalid pattern is dropped
+// rather than widening the rule, so a rule whose patterns all fail to
+// compile matches nothing.
+func compileRules(rules []Rule) map[string][]compiledRule {
+	compiled := make(map[string][]compiledRule)
+	for _, r := range rules {
+		cr := compiledRule{env: make(map[string]bool)}
+		restricted := len(r.Args) > 0 || len(r.ArgsRegex) > 0
+		for _, g := range r.Args {
+			cr.patterns = append(cr.patterns, globToRegexp(g))
+		}
+		for _, expr := range r.ArgsRegex {
+			if re, err := regexp.Compile("^(?:" + expr + ")$"); err == nil {
+				cr.patterns = append(cr.patterns, re)
+			}
+		}
+		if restricted && cr.patterns == nil {
+			cr.patterns = []*regexp.Regexp{}
+		}
+		for _, name := range r.Env {
+			if name == "*" {
+				cr.anyEnv = true
+			}
+			cr.env[name] = true
+		}
+		compiled[r.Command] = append(compiled[r.Command], cr)
+	}
+	return compiled
+}
+
+// globToRegexp converts an argument glob to an anchored regular expression.
+func globToRegexp(glob string) *regexp.Regexp {
+	var b strings.Builder
+	b.WriteString("^")
+	for _, c := range glob {
+		switch c {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.MustCompile(b.String())
+}
+
+// matchesArgs reports whether the joined arguments satisfy the rule.
+func (r compiledRule) matchesArgs(joined string) bool {
+	if r.patterns == nil {
+		return true
+	}
+	for _, re := range r.patterns {
+		if re.MatchString(joined) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsEnv reports whether every variable in env may be set.
+func (r compiledRule) allowsEnv(env map[string]string) bool {
+	if r.anyEnv {
+		return true
+	}
+	for name := range env {
+		if !r.env[name] {
+			return false
+		}
+	}
+	return true
+}
+
+// CheckPolicy checks a request against the whitelist and command rules.
+// Rejections are returned as *DenyError with a structured reason.
+func (e *Executor) CheckPolicy(meta *ShellMeta) error {
+	if e.hasWildcard() {
+		return nil
+	}
+
+	rules := e.rules[meta.Command]
+	if len(rules) == 0 && !e.IsCommandAllowed(meta.Command) {
+		return &DenyError{Reason: DenyCommandNotAllowed, Message: "command '" + meta.Command + "' is not allowed"}
+	}
+
+	if err := e.ValidateArgs(meta.Args); err != nil {
+		return &DenyError{Reason: DenyDangerousArgs, Message: err.Error()}
+	}
+
+	if len(rules) == 0 {
+		return nil
+	}
+
+	joined := strings.Join(meta.Args, " ")
+	argsMatched := false
+	for _, r := range rules {
+		if !r.matchesArgs(joined) {
+			continue
+		}
+		argsMatched = true
+		if r.allowsEnv(meta.Env) {
+			return nil
+		}
+	}
+	if argsMatched {
+		return &DenyError{Reason: DenyEnvNotAllowed, Message: "environment variables not allowed for command '" + meta.Command + "'"}
+	}
+	return &DenyError{Reason: DenyArgsNotAllowed, Message: "arguments not allowed for command '" + meta.Command + "'"}
+}
@@ -0,0 +1,168 @@
+package shell
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestExecutor_CheckPolicy(t *testing.T) {
+	rules := []Rule{
+		{Command: "systemctl", Args: []string{"status *", "is-active ?*"}},
+		{Command: "journalctl", ArgsRegex: []string{`-u [a-z-]+ -n [0-9]{1,3}`}, Env: []string{"SYSTEMD_PAGER"}},
+		{Command: "uptime"},
+		{Command: "env", Env: []string{"*"}},
+	}
+
+	tests := []struct {
+		name       string
+		whitelist  []string
+		command    string
+		args       []string
+		env        map[string]string
+		wantReason string // Empty = allowed
+	}{
+		{
+			name:    "glob allows status",
+			command: "systemctl",
+			args:    []string{"status", "nginx"},
+		},
+		{
+			name:    "second glob",
+			command: "systemctl",
+			args:    []string{"is-active", "sshd"},
+		},
+		{
+			name:       "glob rejects stop",
+			command:    "systemctl",
+			args:       []string{"stop", "nginx"},
+			wantReason: DenyArgsNotAllowed,
+		},
+		{
+			name:       "glob requires arguments",
+			command:    "systemctl",
+			wantReason: DenyArgsNotAllowed,
+		},
+		{
+			name:    "regex allows",
+			command: "journalctl",
+			args:    []string{"-u", "nginx", "-n", "50"},
+			env:     map[string]string{"SYSTEMD_PAGER": "cat"},
+		},
+		{
+			name:       "regex is anchored",
+			command:    "journalctl",
+			args:       []string{"-u", "nginx", "-n", "50", "-f"},
+			wantReason: DenyArgsNotAllowed,
+		},
+		{
+			name:       "env not in rule",
+			command:    "journalctl",
+			args:       []string{"-u", "nginx", "-n", "50"},
+			env:        map[string]string{"LD_PRELOAD": "evil.so"},
+			wantReason: DenyEnvNotAllowed,
+		},
+		{
+			name:       "rule without env allows none",
+			command:    "uptime",
+			env:        map[string]string{"LANG": "C"},
+			wantReason: DenyEnvNotAllowed,
+		},
+		{
+			name:    "rule without args allows any",
+			command: "uptime",
+			args:    []string{"-p"},
+		},
+		{
+			name:    "env wildcard",
+			command: "env",
+			env:     map[string]string{"ANY": "value"},
+		},
+		{
+			name:       "dangerous args still rejected",
+			command:    "systemctl",
+			args:       []string{"status", "$(reboot)"},
+			wantReason: DenyDangerousArgs,
+		},
+		{
+			name:       "rules override whitelist",
+			whitelist:  []string{"systemctl"},
+			command:    "systemctl",
+			args:       []string{"stop", "nginx"},
+			wantReason: DenyArgsNotAllowed,
+		},
+		{
+			name:      "whitelist without rules",
+			whitelist: []string{"whoami"},
+			command:   "whoami",
+			env:       map[string]string{"LANG": "C"},
+		},
+		{
+			name:       "not whitelisted and no rules",
+			command:    "rm",
+			args:       []string{"-rf", "x"},
+			wantReason: DenyCommandNotAllowed,
+		},
+		{
+			name:      "wildcard whitelist bypasses rules",
+			whitelist: []string{"*"},
+			command:   "systemctl",
+			args:      []string{"stop", "nginx"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exec := NewExecutor(Config{
+				Enabled:   true,
+				Whitelist: tt.whitelist,
+				Rules:     rules,
+			})
+
+			err := exec.CheckPolicy(&ShellMeta{Command: tt.command, Args: tt.args, Env: tt.env})
+			if tt.wantReason == "" {
+				if err != nil {
+					t.Errorf("CheckPolicy() error = %v, want allowed", err)
+				}
+				return
+			}
+			var deny *DenyError
+			if !errors.As(err, &deny) {
+				t.Fatalf("CheckPolicy() error = %v, want *DenyError", err)
+			}
+			if deny.Reason != tt.wantReason {
+				t.Errorf("CheckPolicy() reason = %q, want %q", deny.Reason, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestCompileRules_InvalidRegexMatchesNothing(t *testing.T) {
+	exec := NewExecutor(Config{
+		Enabled: true,
+		Rules:   []Rule{{Command: "ls", ArgsRegex: []string{"("}}},
+	})
+
+	for _, args := range [][]string{nil, {"-l"}} {
+		err := exec.CheckPolicy(&ShellMeta{Command: "ls", Args: args})
+		var deny *DenyError
+		if !errors.As(err, &deny) || deny.Reason != DenyArgsNotAllowed {
+			t.Errorf("CheckPolicy(%v) error = %v, want args_not_allowed", args, err)
+		}
+	}
+}
+
+func TestShellError_RemoteError(t *testing.T) {
+	err := (&ShellError{Message: "arguments not allowed", Reason: DenyArgsNotAllowed}).remoteError()
+	var deny *DenyError
+	if !errors.As(err, &deny) || deny.Reason != DenyArgsNotAllowed {
+		t.Errorf("remoteError() = %v, want wrapped DenyError", err)
+	}
+
+	err = (&ShellError{Message: "boom"}).remoteError()
+	if errors.As(err, &deny) {
+		t.Errorf("remoteError() = %v, want plain error without reason", err)
+	}
+	if err.Error() != "remote error: boom" {
+		t.Errorf("remoteError() = %q", err.Error())
+	}
+}
@@ -0,0 +1,75 @@
+package shell
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// maxPrefixLine is the longest partial line a PrefixWriter holds before
+// writing it out without a newline.
+const maxPrefixLine = 64 * 1024
+
+// PrefixWriter writes output line by line with a prefix, so the output of
+// several sessions can share one terminal. Writers of the same destination
+// share mu, which keeps their lines from interleaving. A partial line is held
+// until its newline arrives or Flush is called.
+type PrefixWriter struct {
+	w      io.Writer
+	prefix []byte
+	mu     *sync.Mutex
+
+	buf []byte
+}
+
+// NewPrefixWriter creates a writer that prefixes each line written to w.
+func NewPrefixWriter(w io.Writer, prefix string, mu *sync.Mutex) *PrefixWriter {
+	return &PrefixWriter{w: w, prefix: []byte(prefix), mu: mu}
+}
+
+// Write writes the complete lines of b and holds the rest.
+func (p *PrefixWriter) Write(b []byte) (int, error) {
+	p.buf = append(p.buf, b...)
+
+	end := bytes.LastIndexByte(p.buf, '\n') + 1
+	if end == 0 && len(p.buf) >= maxPrefixLine {
+		p.buf = append(p.buf, '\n')
+		end = len(p.buf)
+	}
+	if end == 0 {
+		return len(b), nil
+	}
+
+	err := p.writeLines(p.buf[:end])
+	p.buf = append(p.buf[:0], p.buf[end:]...)
+	if err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Flush writes a held partial line, ending it with a newline.
+func (p *PrefixWriter) Flush() error {
+	if len(p.buf) == 0 {
+		return nil
+	}
+	line := append(p.buf, '\n')
+	p.buf = p.buf[:0]
+	return p.writeLines(line)
+}
+
+// writeLines writes newline-terminated lines, each with the prefix.
+func (p *PrefixWriter) writeLines(lines []byte) error {
+	out := make([]byte, 0, len(lines)+len(p.prefix)*(bytes.Count(lines, []byte{'\n'})))
+	for len(lines) > 0 {
+		i := bytes.IndexByte(lines, '\n') + 1
+		out = append(out, p.prefix...)
+		out = append(out, lines[:i]...)
+		lines = lines[i:]
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, err := p.w.Write(out)
+	return err
+}
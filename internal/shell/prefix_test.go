@@ -0,0 +1,78 @@
+package shell
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestPrefixWriter(t *testing.T) {
+	var out bytes.Buffer
+	var mu sync.Mutex
+	w := NewPrefixWriter(&out, "[web] ", &mu)
+
+	for _, s := range []string{"Linux web ", "5.15\nfirst", " line\nsecond\n", "partial"} {
+		if n, err := w.Write([]byte(s)); err != nil || n != len(s) {
+			t.Fatalf("Write(%q) = %d, %v", s, n, err)
+		}
+	}
+	want := "[web] Linux web 5.15\n[web] first line\n[web] second\n"
+	if out.String() != want {
+		t.Errorf("output = %q, want %q", out.String(), want)
+	}
+
+	if err := w.Flush(); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+	want += "[web] partial\n"
+	if out.String() != want {
+		t.Errorf("output after Flush = %q, want %q", out.String(), want)
+	}
+
+	// Nothing held, nothing written
+	w.Flush()
+	if out.String() != want {
+		t.Errorf("second Flush wrote %q", strings.TrimPrefix(out.String(), want))
+	}
+}
+
+func TestPrefixWriter_LongLine(t *testing.T) {
+	var out bytes.Buffer
+	var mu sync.Mutex
+	w := NewPrefixWriter(&out, "> ", &mu)
+
+	w.Write(bytes.Repeat([]byte("x"), maxPrefixLine))
+	if got := out.Len(); got != maxPrefixLine+3 {
+		t.Errorf("output length = %d, want %d", got, maxPrefixLine+3)
+	}
+}
+
+func TestPrefixWriter_Shared(t *testing.T) {
+	var out bytes.Buffer
+	var mu sync.Mutex
+
+	var wg sync.WaitGroup
+	for _, name := range []string{"a", "b", "c"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := NewPrefixWriter(&out, name+": ", &mu)
+			for i := 0; i < 100; i++ {
+				w.Write([]byte("0123456789"))
+				w.Write([]byte("\n"))
+			}
+		}()
+	}
+	wg.Wait()
+
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != 300 {
+		t.Fatalf("got %d lines, want 300", len(lines))
+	}
+	for _, line := range lines {
+		if len(line) != len("a: 0123456789") || line[1:] != ": 0123456789" {
+			t.Fatalf("interleaved line %q", line)
+		}
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"syscall"
 
 	"github.com/creack/pty"
+	"golang.org/x/sys/unix"
 )
 
 // PTYSessionInterface defines the interface for PTY sessions.
@@ -53,8 +54,18 @@ func (e *Executor) NewPTYSession(ctx context.Context, meta *ShellMeta) (PTYSessi
 	// Create command
 	cmd := exec.CommandContext(sessionCtx, meta.Command, meta.Args...)
 
+	release, err := e.runAs(cmd, meta)
+	if err != nil {
+		cancel()
+		e.ReleaseSession()
+		return nil, err
+	}
+	defer release()
+
 	// Set up environment
-	cmd.Env = os.Environ()
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
 	if meta.TTY != nil && meta.TTY.Term != "" {
 		cmd.Env = append(cmd.Env, "TERM="+meta.TTY.Term)
 	} else {
@@ -69,6 +80,12 @@ func (e *Executor) NewPTYSession(ctx context.Context, meta *ShellMeta) (PTYSessi
 		cmd.Dir = meta.WorkDir
 	}
 
+	if err := e.confine(cmd); err != nil {
+		cancel()
+		e.ReleaseSession()
+		return nil, err
+	}
+
 	// Set up initial window size
 	winsize := &pty.Winsize{
 		Rows: 24,
@@ -137,7 +154,10 @@ func (s *PTYSession) Resize(rows, cols uint16) error {
 	})
 }
 
-// Signal sends a signal to the process.
+// Signal sends a signal to the foreground process group of the terminal,
+// as the terminal driver does for Ctrl-C, so a command run from the shell
+// receives it rather than the shell itself. It falls back to the shell
+// process when the foreground group is unknown.
 func (s *PTYSession) Signal(sig syscall.Signal) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -146,9 +166,33 @@ func (s *PTYSession) Signal(sig syscall.Signal) error {
 		return fmt.Errorf("no process")
 	}
 
+	if pgrp, err := foregroundGroup(s.ptmx); err == nil && pgrp > 0 {
+		if err := syscall.Kill(-pgrp, sig); err == nil {
+			return nil
+		}
+	}
+
 	return s.cmd.Process.Signal(sig)
 }
 
+// foregroundGroup returns the foreground process group of the terminal.
+func foregroundGroup(ptmx *os.File) (int, error) {
+	conn, err := ptmx.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+
+	var pgrp int
+	var ioctlErr error
+	err = conn.Control(func(fd uintptr) {
+		pgrp, ioctlErr = unix.IoctlGetInt(int(fd), unix.TIOCGPGRP)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return pgrp, ioctlErr
+}
+
 // Wait waits for the process to exit and returns the exit code.
 func (s *PTYSession) Wait() int32 {
 	<-s.done
@@ -0,0 +1,69 @@
+//go:build !windows
+
+package shell
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestPTYSession_SignalForegroundGroup(t *testing.T) {
+	exec := NewExecutor(Config{
+		Enabled:     true,
+		MaxSessions: 10,
+		Whitelist:   []string{"*"},
+	})
+
+	// An interactive shell runs each command in its own foreground process
+	// group and ignores SIGINT itself
+	session, err := exec.NewPTYSession(context.Background(), &ShellMeta{
+		Command: "sh",
+		Args:    []string{"-i"},
+	})
+	if err != nil {
+		t.Fatalf("NewPTYSession() error = %v", err)
+	}
+	defer session.Close()
+
+	var mu sync.Mutex
+	var output bytes.Buffer
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, err := session.Read(buf)
+			mu.Lock()
+			output.Write(buf[:n])
+			mu.Unlock()
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	session.Write([]byte("sleep 30\n"))
+	time.Sleep(500 * time.Millisecond)
+
+	if err := session.Signal(syscall.SIGINT); err != nil {
+		t.Fatalf("Signal() error = %v", err)
+	}
+
+	// The shell survives and runs the next command once sleep is interrupted
+	session.Write([]byte("echo survived-$((1+1))\n"))
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		mu.Lock()
+		done := strings.Contains(output.String(), "survived-2")
+		mu.Unlock()
+		if done {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Error("SIGINT did not reach the foreground command within 5s")
+}
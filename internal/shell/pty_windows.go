@@ -52,6 +52,12 @@ func (e *Executor) NewPTYSession(ctx context.Context, meta *ShellMeta) (PTYSessi
 		return nil, err
 	}
 
+	// ConPTY starts processes with CreateProcess, without a user token
+	if user, _, _ := e.account(meta); user != "" {
+		e.ReleaseSession()
+		return nil, fmt.Errorf("running interactive sessions as %s is not supported on Windows", user)
+	}
+
 	sessionCtx, cancel := context.WithCancel(ctx)
 
 	// Set up initial window size
@@ -0,0 +1,104 @@
+package shell
+
+import (
+	"os/exec"
+	"strings"
+)
+
+// RunAs selects the account commands execute as instead of the agent's.
+type RunAs struct {
+	// User is the account commands run as (empty = the agent's account).
+	User string `yaml:"user"`
+
+	// Group overrides the primary group of User (Unix only).
+	Group string `yaml:"group"`
+
+	// Password of User, needed to log it on (Windows only).
+	Password string `yaml:"password"`
+
+	// AllowedUsers are the accounts a request may pick instead of User.
+	// Empty = requests cannot choose the account.
+	AllowedUsers []string `yaml:"allowed_users"`
+}
+
+// defaultPath is PATH for commands run as another account; the agent's own
+// PATH may point into its service account's directories.
+const defaultPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+// passthroughEnv are the variables of the agent's environment kept for
+// commands run as another account. Everything else, including secrets the
+// agent was started with, is dropped.
+var passthroughEnv = []string{"LANG", "LANGUAGE", "TZ", "TERM"}
+
+// checkRunAs rejects requests for an account that is neither the
+// configured one nor in AllowedUsers.
+func (e *Executor) checkRunAs(meta *ShellMeta) error {
+	if meta.RunAs == "" || meta.RunAs == e.config.RunAs.User {
+		return nil
+	}
+	for _, u := range e.config.RunAs.AllowedUsers {
+		if u == meta.RunAs {
+			return nil
+		}
+	}
+	return &DenyError{Reason: DenyRunAsNotAllowed, Message: "running as '" + meta.RunAs + "' is not allowed"}
+}
+
+// account returns the user, group and password a request runs as. An empty
+// user means the agent's own account.
+func (e *Executor) account(meta *ShellMeta) (user, group, password string) {
+	if meta.RunAs != "" && meta.RunAs != e.config.RunAs.User {
+		return meta.RunAs, "", ""
+	}
+	return e.config.RunAs.User, e.config.RunAs.Group, e.config.RunAs.Password
+}
+
+// runAs makes cmd execute as the account selected for meta, with an
+// environment of its own. It must be called before the caller adds the
+// request's environment. release frees what was acquired to start the
+// command; call it once the command has started or will not be started.
+func (e *Executor) runAs(cmd *exec.Cmd, meta *ShellMeta) (release func(), err error) {
+	user, group, password := e.account(meta)
+	if user == "" {
+		return func() {}, nil
+	}
+	return startAs(cmd, user, group, password)
+}
+
+// PrepareCommand makes cmd execute as shell.run_as.user, like a request
+// that does not pick an account. The scheduler uses it for shell tasks.
+func (e *Executor) PrepareCommand(cmd *exec.Cmd) (release func(), err error) {
+	return e.runAs(cmd, &ShellMeta{})
+}
+
+// accountEnv builds the environment of a command run as another account:
+// the passthrough variables of base plus the account's PATH, HOME, USER
+// and LOGNAME.
+func accountEnv(base []string, username, home string) []string {
+	env := make([]string, 0, len(passthroughEnv)+4)
+	for _, kv := range base {
+		name, _, _ := strings.Cut(kv, "=")
+		if keepEnv(name) {
+			env = append(env, kv)
+		}
+	}
+	return append(env,
+		"PATH="+defaultPath,
+		"HOME="+home,
+		"USER="+username,
+		"LOGNAME="+username,
+	)
+}
+
+// keepEnv reports whether an agent environment variable is passed through.
+func keepEnv(name string) bool {
+	if strings.HasPrefix(name, "LC_") {
+		return true
+	}
+	for _, n := range passthroughEnv {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,185 @@
+package shell
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"os/user"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestExecutor_checkRunAs(t *testing.T) {
+	exec := NewExecutor(Config{
+		Enabled:   true,
+		Whitelist: []string{"*"},
+		RunAs:     RunAs{User: "svc", AllowedUsers: []string{"deploy"}},
+	})
+
+	tests := []struct {
+		runAs   string
+		allowed bool
+	}{
+		{"", true},
+		{"svc", true},
+		{"deploy", true},
+		{"root", false},
+		{"Deploy", false},
+	}
+	for _, tt := range tests {
+		err := exec.checkRunAs(&ShellMeta{Command: "id", RunAs: tt.runAs})
+		if tt.allowed {
+			if err != nil {
+				t.Errorf("checkRunAs(%q) error = %v, want allowed", tt.runAs, err)
+			}
+			continue
+		}
+		var deny *DenyError
+		if !errors.As(err, &deny) || deny.Reason != DenyRunAsNotAllowed {
+			t.Errorf("checkRunAs(%q) error = %v, want %s", tt.runAs, err, DenyRunAsNotAllowed)
+		}
+	}
+}
+
+func TestExecutor_checkRunAs_NoOverrides(t *testing.T) {
+	// Without allowed_users a request cannot pick an account, even with a
+	// wildcard whitelist
+	exec := NewExecutor(Config{Enabled: true, Whitelist: []string{"*"}})
+
+	_, err := exec.NewSession(context.Background(), &ShellMeta{Command: "id", RunAs: "root"})
+	var deny *DenyError
+	if !errors.As(err, &deny) || deny.Reason != DenyRunAsNotAllowed {
+		t.Fatalf("NewSession() error = %v, want %s", err, DenyRunAsNotAllowed)
+	}
+	if exec.ActiveSessions() != 0 {
+		t.Errorf("ActiveSessions() = %d, want 0 after refusal", exec.ActiveSessions())
+	}
+}
+
+func TestExecutor_account(t *testing.T) {
+	exec := NewExecutor(Config{
+		RunAs: RunAs{User: "svc", Group: "staff", Password: "secret", AllowedUsers: []string{"deploy"}},
+	})
+
+	user, group, password := exec.account(&ShellMeta{})
+	if user != "svc" || group != "staff" || password != "secret" {
+		t.Errorf("account() = %q, %q, %q; want the configured account", user, group, password)
+	}
+
+	// An override does not inherit the configured group or password
+	user, group, password = exec.account(&ShellMeta{RunAs: "deploy"})
+	if user != "deploy" || group != "" || password != "" {
+		t.Errorf("account(deploy) = %q, %q, %q; want deploy alone", user, group, password)
+	}
+}
+
+func TestAccountEnv(t *testing.T) {
+	base := []string{
+		"PATH=/root/bin:/usr/bin",
+		"HOME=/root",
+		"USER=root",
+		"LANG=en_US.UTF-8",
+		"LC_ALL=C",
+		"TZ=UTC",
+		"AWS_SECRET_ACCESS_KEY=hunter2",
+		"MUTI_METROO_SHELL_PASSWORD=secret",
+		"SSH_AUTH_SOCK=/tmp/agent.sock",
+	}
+	env := accountEnv(base, "deploy", "/home/deploy")
+
+	for _, want := range []string{
+		"PATH=" + defaultPath,
+		"HOME=/home/deploy",
+		"USER=deploy",
+		"LOGNAME=deploy",
+		"LANG=en_US.UTF-8",
+		"LC_ALL=C",
+		"TZ=UTC",
+	} {
+		if !slices.Contains(env, want) {
+			t.Errorf("env missing %s: %v", want, env)
+		}
+	}
+	for _, kv := range env {
+		for _, dropped := range []string{"AWS_SECRET_ACCESS_KEY=", "MUTI_METROO_", "SSH_AUTH_SOCK=", "HOME=/root", "USER=root", "PATH=/root"} {
+			if strings.HasPrefix(kv, dropped) {
+				t.Errorf("env keeps %s", kv)
+			}
+		}
+	}
+}
+
+// runSession runs meta to completion and returns its stdout.
+func runSession(t *testing.T, e *Executor, meta *ShellMeta) string {
+	t.Helper()
+	session, err := e.NewSession(context.Background(), meta)
+	if err != nil {
+		t.Fatalf("NewSession() error = %v", err)
+	}
+	defer e.ReleaseSession()
+	session.RequireDrain()
+	if err := session.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	out, _ := io.ReadAll(session.Stdout())
+	session.SignalDrainDone()
+	<-session.Done()
+	if code := session.ExitCode(); code != 0 {
+		t.Fatalf("exit code = %d, output %q", code, out)
+	}
+	return string(out)
+}
+
+func TestExecutor_NewSession_RunAsSanitizesEnv(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Windows uses the account's own environment block")
+	}
+	me, err := user.Current()
+	if err != nil {
+		t.Skip("current user unknown")
+	}
+	t.Setenv("MUTI_METROO_TEST_SECRET", "hunter2")
+
+	// Running as the agent's own user needs no privileges but still gets
+	// the account's environment
+	exec := NewExecutor(Config{
+		Enabled:   true,
+		Whitelist: []string{"*"},
+		RunAs:     RunAs{User: me.Username},
+	})
+	out := runSession(t, exec, &ShellMeta{Command: "env", Env: map[string]string{"DEPLOY_ENV": "prod"}})
+
+	if strings.Contains(out, "MUTI_METROO_TEST_SECRET") {
+		t.Errorf("command sees the agent's environment:\n%s", out)
+	}
+	for _, want := range []string{"HOME=" + me.HomeDir, "USER=" + me.Username, "DEPLOY_ENV=prod"} {
+		if !strings.Contains(out, want+"\n") {
+			t.Errorf("env output missing %s:\n%s", want, out)
+		}
+	}
+}
+
+func TestExecutor_NewSession_RunAsOtherUser(t *testing.T) {
+	if runtime.GOOS == "windows" || os.Geteuid() != 0 {
+		t.Skip("switching users requires root on Unix")
+	}
+	if _, err := user.Lookup("nobody"); err != nil {
+		t.Skip("user nobody not found")
+	}
+
+	exec := NewExecutor(Config{
+		Enabled:   true,
+		Whitelist: []string{"*"},
+		RunAs:     RunAs{AllowedUsers: []string{"nobody"}},
+	})
+
+	if out := runSession(t, exec, &ShellMeta{Command: "id", Args: []string{"-un"}}); strings.TrimSpace(out) != "root" {
+		t.Errorf("id -un = %q, want root without run_as", out)
+	}
+	if out := runSession(t, exec, &ShellMeta{Command: "id", Args: []string{"-un"}, RunAs: "nobody"}); strings.TrimSpace(out) != "nobody" {
+		t.Errorf("id -un = %q, want nobody", out)
+	}
+}
@@ -0,0 +1,46 @@
+//go:build !windows
+
+package shell
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+
+	"github.com/postalsys/muti-metroo/internal/privdrop"
+)
+
+// startAs sets the credentials, environment and working directory of cmd
+// for user. Switching to another user requires root; running as the
+// agent's own user only replaces the environment.
+func startAs(cmd *exec.Cmd, user, group, _ string) (func(), error) {
+	acct, err := privdrop.LookupAccount(privdrop.Config{User: user, Group: group})
+	if err != nil {
+		return nil, fmt.Errorf("run as: %w", err)
+	}
+
+	if euid := os.Geteuid(); acct.UID != euid || (group != "" && acct.GID != os.Getegid()) {
+		if euid != 0 {
+			return nil, fmt.Errorf("running commands as %s requires the agent to run as root", user)
+		}
+		groups := make([]uint32, len(acct.Groups))
+		for i, g := range acct.Groups {
+			groups[i] = uint32(g)
+		}
+		if cmd.SysProcAttr == nil {
+			cmd.SysProcAttr = &syscall.SysProcAttr{}
+		}
+		cmd.SysProcAttr.Credential = &syscall.Credential{
+			Uid:    uint32(acct.UID),
+			Gid:    uint32(acct.GID),
+			Groups: groups,
+		}
+	}
+
+	cmd.Env = accountEnv(os.Environ(), acct.Username, acct.HomeDir)
+	if fi, err := os.Stat(acct.HomeDir); err == nil && fi.IsDir() {
+		cmd.Dir = acct.HomeDir
+	}
+	return func() {}, nil
+}
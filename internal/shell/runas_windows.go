@@ -0,0 +1,91 @@
+//go:build windows
+
+package shell
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	modAdvapi32    = windows.NewLazySystemDLL("advapi32.dll")
+	procLogonUserW = modAdvapi32.NewProc("LogonUserW")
+)
+
+const (
+	logon32LogonBatch      = 4
+	logon32ProviderDefault = 0
+)
+
+// startAs logs user on with its password and makes cmd start with the
+// resulting token through CreateProcessAsUser. The agent needs the
+// "Replace a process level token" right, which LocalSystem has. The
+// command gets the account's own environment and profile directory.
+func startAs(cmd *exec.Cmd, user, _, password string) (func(), error) {
+	if password == "" {
+		return nil, fmt.Errorf("running commands as %s requires its password on Windows", user)
+	}
+	token, err := logonUser(user, password)
+	if err != nil {
+		return nil, fmt.Errorf("run as %s: %w", user, err)
+	}
+
+	env, err := token.Environ(false)
+	if err != nil {
+		token.Close()
+		return nil, fmt.Errorf("run as %s: environment: %w", user, err)
+	}
+	cmd.Env = env
+	if dir, err := token.GetUserProfileDirectory(); err == nil {
+		cmd.Dir = dir
+	}
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Token = syscall.Token(token)
+	return func() { token.Close() }, nil
+}
+
+// logonUser logs on "user", "DOMAIN\user" or "user@domain" as a batch job.
+func logonUser(user, password string) (windows.Token, error) {
+	domain := "."
+	if d, name, ok := strings.Cut(user, `\`); ok {
+		domain, user = d, name
+	} else if strings.Contains(user, "@") {
+		domain = ""
+	}
+
+	userPtr, err := windows.UTF16PtrFromString(user)
+	if err != nil {
+		return 0, err
+	}
+	passPtr, err := windows.UTF16PtrFromString(password)
+	if err != nil {
+		return 0, err
+	}
+	var domainPtr *uint16
+	if domain != "" {
+		if domainPtr, err = windows.UTF16PtrFromString(domain); err != nil {
+			return 0, err
+		}
+	}
+
+	var token windows.Token
+	r, _, err := procLogonUserW.Call(
+		uintptr(unsafe.Pointer(userPtr)),
+		uintptr(unsafe.Pointer(domainPtr)),
+		uintptr(unsafe.Pointer(passPtr)),
+		logon32LogonBatch,
+		logon32ProviderDefault,
+		uintptr(unsafe.Pointer(&token)),
+	)
+	if r == 0 {
+		return 0, fmt.Errorf("logon: %w", err)
+	}
+	return token, nil
+}
@@ -0,0 +1,19 @@
+package shell
+
+import (
+	"fmt"
+	"os/exec"
+
+	"github.com/postalsys/muti-metroo/internal/sandbox"
+)
+
+// confine makes cmd start in the configured sandbox, if any.
+func (e *Executor) confine(cmd *exec.Cmd) error {
+	if e.config.Sandbox == nil {
+		return nil
+	}
+	if err := sandbox.Wrap(cmd, *e.config.Sandbox); err != nil {
+		return fmt.Errorf("shell sandbox: %w", err)
+	}
+	return nil
+}
@@ -3,12 +3,47 @@
 package shell
 
 import (
+	"context"
 	"os"
 	"os/signal"
 	"syscall"
 )
 
-// setupResizeSignal sets up SIGWINCH handling for terminal resizing on Unix systems.
-func setupResizeSignal(sigCh chan os.Signal) {
+// localSignals maps wire signal numbers to the signals of this system.
+var localSignals = map[uint8]syscall.Signal{
+	SigHUP:   syscall.SIGHUP,
+	SigINT:   syscall.SIGINT,
+	SigQUIT:  syscall.SIGQUIT,
+	SigKILL:  syscall.SIGKILL,
+	SigUSR1:  syscall.SIGUSR1,
+	SigUSR2:  syscall.SIGUSR2,
+	SigTERM:  syscall.SIGTERM,
+	SigCONT:  syscall.SIGCONT,
+	SigSTOP:  syscall.SIGSTOP,
+	SigTSTP:  syscall.SIGTSTP,
+	SigWINCH: syscall.SIGWINCH,
+}
+
+// forwardedSignals are the signals the client passes on to the remote
+// process in streaming mode.
+var forwardedSignals = []os.Signal{syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGQUIT}
+
+// watchResize notifies ch when the terminal is resized (SIGWINCH) until ctx
+// is done.
+func watchResize(ctx context.Context, ch chan<- struct{}) {
+	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGWINCH)
+	defer signal.Stop(sigCh)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-sigCh:
+			select {
+			case ch <- struct{}{}:
+			default:
+			}
+		}
+	}
 }
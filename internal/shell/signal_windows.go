@@ -3,12 +3,54 @@
 package shell
 
 import (
+	"context"
 	"os"
+	"syscall"
+	"time"
+
+	"golang.org/x/term"
 )
 
-// setupResizeSignal is a no-op on Windows as SIGWINCH is not supported.
-// Windows terminal resize is handled differently (via console API).
-func setupResizeSignal(sigCh chan os.Signal) {
-	// SIGWINCH doesn't exist on Windows
-	// Terminal resize on Windows would need to use Windows Console API
+// localSignals maps wire signal numbers to the signals of this system.
+// Windows has no job control or user signals.
+var localSignals = map[uint8]syscall.Signal{
+	SigHUP:  syscall.SIGHUP,
+	SigINT:  syscall.SIGINT,
+	SigQUIT: syscall.SIGQUIT,
+	SigKILL: syscall.SIGKILL,
+	SigTERM: syscall.SIGTERM,
+}
+
+// forwardedSignals are the signals the client passes on to the remote
+// process in streaming mode.
+var forwardedSignals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+
+// resizePollInterval is how often the console size is checked. Windows has
+// no SIGWINCH, so resizes are detected by polling.
+const resizePollInterval = 250 * time.Millisecond
+
+// watchResize notifies ch when the console size changes until ctx is done.
+func watchResize(ctx context.Context, ch chan<- struct{}) {
+	fd := int(os.Stdin.Fd())
+	width, height, _ := term.GetSize(fd)
+
+	ticker := time.NewTicker(resizePollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			w, h, err := term.GetSize(fd)
+			if err != nil || (w == width && h == height) {
+				continue
+			}
+			width, height = w, h
+			select {
+			case ch <- struct{}{}:
+			default:
+			}
+		}
+	}
 }
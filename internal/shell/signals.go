@@ -0,0 +1,20 @@
+package shell
+
+import "syscall"
+
+// LocalSignal returns the signal of this system for a wire signal number.
+// It reports false for signals the system does not have.
+func LocalSignal(signum uint8) (syscall.Signal, bool) {
+	sig, ok := localSignals[signum]
+	return sig, ok
+}
+
+// WireSignal returns the wire signal number for a signal of this system.
+func WireSignal(sig syscall.Signal) (uint8, bool) {
+	for num, local := range localSignals {
+		if local == sig {
+			return num, true
+		}
+	}
+	return 0, false
+}
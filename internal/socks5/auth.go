@@ -2,8 +2,10 @@
 package socks5
 
 import (
+	"context"
 	"crypto/subtle"
 	"errors"
+	"fmt"
 	"io"
 
 	"golang.org/x/crypto/bcrypt"
@@ -110,6 +112,20 @@ func MustHashPassword(password string) string {
 // UserPassAuthenticator handles username/password authentication (RFC 1929).
 type UserPassAuthenticator struct {
 	Credentials CredentialStore
+
+	// ExitSelection lists the accounts allowed to pick an exit by appending
+	// ExitHintSeparator and the exit to their username. For these accounts,
+	// credentials are checked against the name without the hint.
+	ExitSelection map[string]bool
+
+	// ExitCredentials, when set, lets every account it accepts select an
+	// exit. Names with an exit hint that are not in ExitSelection are
+	// checked against it, without the hint, instead of Credentials.
+	ExitCredentials CredentialStore
+
+	// Limiter, when set, rejects accounts that are expired or over quota
+	// after their credentials were accepted.
+	Limiter UserLimiter
 }
 
 // NewUserPassAuthenticator creates a new username/password authenticator.
@@ -122,7 +138,8 @@ func (a *UserPassAuthenticator) GetMethod() byte {
 	return AuthMethodUserPass
 }
 
-// Authenticate performs username/password authentication.
+// Authenticate performs username/password authentication and returns the
+// username as sent by the client, including any accepted exit hint.
 // Protocol (RFC 1929):
 //
 //	+----+------+----------+------+----------+
@@ -175,13 +192,33 @@ func (a *UserPassAuthenticator) Authenticate(reader io.Reader, writer io.Writer)
 		}
 	}
 
+	// Strip the exit hint for accounts allowed to select exits
+	account := string(username)
+	creds := a.Credentials
+	if user, exit := SplitExitHint(account); exit != "" {
+		switch {
+		case a.ExitSelection[user]:
+			account = user
+		case a.ExitCredentials != nil:
+			account, creds = user, a.ExitCredentials
+		}
+	}
+
 	// Validate credentials
-	if !a.Credentials.Valid(string(username), string(password)) {
+	if !creds.Valid(account, string(password)) {
 		// Send failure response
 		writer.Write([]byte{0x01, AuthStatusFailure})
 		return "", errors.New("authentication failed")
 	}
 
+	// Check expiry and quotas of the account
+	if a.Limiter != nil {
+		if err := a.Limiter.Admit(account); err != nil {
+			writer.Write([]byte{0x01, AuthStatusFailure})
+			return "", fmt.Errorf("authentication failed for %s: %w", account, err)
+		}
+	}
+
 	// Send success response
 	_, err := writer.Write([]byte{0x01, AuthStatusSuccess})
 	if err != nil {
@@ -199,22 +236,46 @@ type AuthConfig struct {
 	Users map[string]string
 	// HashedUsers maps username to bcrypt password hash (recommended).
 	HashedUsers map[string]string
+	// ExitSelection lists usernames allowed to select an exit per connection.
+	ExitSelection map[string]bool
+	// External checks credentials of users not accepted by the configured
+	// users (optional).
+	External CredentialStore
+	// ExternalExitSelection lets all users accepted by External select an
+	// exit per connection.
+	ExternalExitSelection bool
+	// Limiter rejects expired or over-quota accounts (optional).
+	Limiter UserLimiter
 }
 
 // CreateAuthenticators creates authenticators based on config.
-// If HashedUsers is provided, it takes precedence over Users.
+// If HashedUsers is provided, it takes precedence over Users. External
+// credentials are checked after the configured users.
 func CreateAuthenticators(cfg AuthConfig) []Authenticator {
 	var auths []Authenticator
 
 	if cfg.Enabled {
+		var stores CredentialChain
 		// Prefer hashed credentials if available
 		if len(cfg.HashedUsers) > 0 {
-			creds := HashedCredentials(cfg.HashedUsers)
-			auths = append(auths, NewUserPassAuthenticator(creds))
+			stores = append(stores, HashedCredentials(cfg.HashedUsers))
 		} else if len(cfg.Users) > 0 {
 			// Fall back to plaintext credentials (deprecated)
-			creds := StaticCredentials(cfg.Users)
-			auths = append(auths, NewUserPassAuthenticator(creds))
+			stores = append(stores, StaticCredentials(cfg.Users))
+		}
+		if cfg.External != nil {
+			stores = append(stores, cfg.External)
+		}
+
+		if len(stores) > 0 {
+			auth := &UserPassAuthenticator{Credentials: stores, ExitSelection: cfg.ExitSelection, Limiter: cfg.Limiter}
+			if len(stores) == 1 {
+				auth.Credentials = stores[0]
+			}
+			if cfg.External != nil && cfg.ExternalExitSelection {
+				auth.ExitCredentials = cfg.External
+			}
+			auths = append(auths, auth)
 		}
 	}
 
@@ -224,3 +285,18 @@ func CreateAuthenticators(cfg AuthConfig) []Authenticator {
 
 	return auths
 }
+
+type userKey struct{}
+
+// WithUser returns a context carrying the authenticated account name of the
+// client, without any exit hint.
+func WithUser(ctx context.Context, user string) context.Context {
+	return context.WithValue(ctx, userKey{}, user)
+}
+
+// UserFromContext returns the authenticated account name of the client, or
+// "" if the connection did not authenticate with a username.
+func UserFromContext(ctx context.Context) string {
+	user, _ := ctx.Value(userKey{}).(string)
+	return user
+}
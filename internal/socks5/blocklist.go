@@ -0,0 +1,510 @@
+package socks5
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/postalsys/muti-metroo/internal/logging"
+)
+
+// Defaults for destination blocklists.
+const (
+	DefaultBlocklistRefreshInterval = time.Hour
+	DefaultBlocklistFeedTimeout     = 30 * time.Second
+
+	// maxBlocklistFeedSize bounds the body of a blocklist feed.
+	maxBlocklistFeedSize = 32 * 1024 * 1024
+)
+
+// Blocklist source types.
+const (
+	BlocklistSourceInline = "inline"
+	BlocklistSourceFile   = "file"
+	BlocklistSourceFeed   = "feed"
+)
+
+// DestinationFilter rejects CONNECT requests before any dial is made.
+// Implementations must be safe for concurrent use.
+type DestinationFilter interface {
+	// Check reports whether connections to host (a domain name or an IP
+	// address) are blocked, and by which entry.
+	Check(host string) (BlockMatch, bool)
+}
+
+// BlockMatch is the blocklist entry that matched a destination.
+type BlockMatch struct {
+	// Source is "inline", a file path or a feed URL.
+	Source string `json:"source"`
+	// Entry is the domain or CIDR that matched.
+	Entry string `json:"entry"`
+}
+
+// BlocklistConfig configures destination blocklists.
+type BlocklistConfig struct {
+	// Domains block these domains and all their subdomains.
+	Domains []string
+
+	// CIDRs block destination IP addresses in these ranges. A plain IP
+	// address blocks only itself.
+	CIDRs []string
+
+	// Files are local list files, reloaded on every refresh.
+	Files []string
+
+	// Feeds are HTTPS URLs of list files, fetched on every refresh.
+	Feeds []string
+
+	// FeedTimeout limits each feed request (0 = DefaultBlocklistFeedTimeout).
+	FeedTimeout time.Duration
+
+	// HTTPClient fetches feeds (nil = a client without proxy settings from
+	// the environment).
+	HTTPClient *http.Client
+
+	// Logger reports blocked destinations and failed refreshes.
+	Logger *slog.Logger
+}
+
+// BlocklistSourceStatus reports one list source.
+type BlocklistSourceStatus struct {
+	Source string `json:"source"`
+	// Type is "inline", "file" or "feed".
+	Type    string    `json:"type"`
+	Entries int       `json:"entries"`
+	Invalid int       `json:"invalid,omitempty"`
+	Blocked uint64    `json:"blocked"`
+	Loaded  time.Time `json:"loaded,omitempty"`
+	// Error is the last refresh error. The entries of the last successful
+	// load stay in use.
+	Error string `json:"error,omitempty"`
+}
+
+// BlocklistStatus reports the blocklist and its counters.
+type BlocklistStatus struct {
+	Entries     int                     `json:"entries"`
+	Checked     uint64                  `json:"checked"`
+	Blocked     uint64                  `json:"blocked"`
+	LastRefresh time.Time               `json:"last_refresh,omitempty"`
+	Sources     []BlocklistSourceStatus `json:"sources"`
+}
+
+// Blocklist is a DestinationFilter built from inline entries, list files
+// and HTTPS feeds. Refresh reloads files and feeds and swaps the compiled
+// set atomically, so checks never wait for a refresh. A source that fails
+// to refresh keeps its previous entries.
+//
+// List files have one entry per line: a domain, an IP address or a CIDR.
+// Text after '#' is a comment. Hosts file lines ("0.0.0.0 ads.example.com")
+// block the names after the address.
+type Blocklist struct {
+	cfg    BlocklistConfig
+	logger *slog.Logger
+
+	set     atomic.Pointer[blockSet]
+	checked atomic.Uint64
+	blocked atomic.Uint64
+
+	refreshMu   sync.Mutex // Serializes refreshes
+	mu          sync.Mutex // Guards the fields below
+	sources     []*blockSource
+	lastRefresh time.Time
+}
+
+// blockSource is one list source with the entries of its last good load.
+type blockSource struct {
+	name    string
+	kind    string
+	entries blockEntries
+	blocked atomic.Uint64
+
+	loaded       time.Time
+	err          error
+	etag         string
+	lastModified string
+}
+
+// blockEntries are the parsed entries of one source.
+type blockEntries struct {
+	domains []string
+	nets    []*net.IPNet
+	invalid int
+}
+
+// blockSet is the compiled union of all sources. Single addresses are kept
+// in a map so long lists of IPs do not slow down every check.
+type blockSet struct {
+	domains map[string]*blockSource
+	ips     map[string]*blockSource
+	nets    []blockNet
+	size    int
+}
+
+// blockNet is a blocked range and the source that listed it.
+type blockNet struct {
+	ipnet  *net.IPNet
+	source *blockSource
+}
+
+// NewBlocklist creates a blocklist with its inline entries and files.
+// Feeds are fetched by the first Refresh, so an unreachable feed does not
+// delay startup.
+func NewBlocklist(cfg BlocklistConfig) (*Blocklist, error) {
+	if cfg.FeedTimeout <= 0 {
+		cfg.FeedTimeout = DefaultBlocklistFeedTimeout
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = logging.NopLogger()
+	}
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = &http.Client{Transport: &http.Transport{}}
+	}
+
+	b := &Blocklist{cfg: cfg, logger: cfg.Logger}
+
+	inline := &blockSource{name: BlocklistSourceInline, kind: BlocklistSourceInline}
+	for _, d := range cfg.Domains {
+		if net.ParseIP(d) != nil || strings.Contains(d, "/") || !inline.entries.add(d) {
+			return nil, fmt.Errorf("blocklist: invalid domain %q", d)
+		}
+	}
+	for _, c := range cfg.CIDRs {
+		if (net.ParseIP(c) == nil && !strings.Contains(c, "/")) || !inline.entries.add(c) {
+			return nil, fmt.Errorf("blocklist: invalid CIDR %q", c)
+		}
+	}
+	inline.loaded = time.Now()
+	b.sources = append(b.sources, inline)
+
+	for _, path := range cfg.Files {
+		b.sources = append(b.sources, &blockSource{name: path, kind: BlocklistSourceFile})
+	}
+	for _, url := range cfg.Feeds {
+		b.sources = append(b.sources, &blockSource{name: url, kind: BlocklistSourceFeed})
+	}
+
+	for _, src := range b.sources {
+		if src.kind != BlocklistSourceFile {
+			continue
+		}
+		if err := b.loadFile(src); err != nil {
+			return nil, fmt.Errorf("blocklist: %w", err)
+		}
+	}
+	b.mu.Lock()
+	b.set.Store(b.compileLocked())
+	b.mu.Unlock()
+	return b, nil
+}
+
+// Check reports whether host is blocked and counts the attempt.
+func (b *Blocklist) Check(host string) (BlockMatch, bool) {
+	b.checked.Add(1)
+	m, src := b.lookup(host)
+	if src == nil {
+		return BlockMatch{}, false
+	}
+	b.blocked.Add(1)
+	src.blocked.Add(1)
+	b.logger.Debug("destination blocked", "host", host, "source", m.Source, "entry", m.Entry)
+	return m, true
+}
+
+// Test reports whether host would be blocked, without counting it.
+func (b *Blocklist) Test(host string) (BlockMatch, bool) {
+	m, src := b.lookup(host)
+	return m, src != nil
+}
+
+// lookup finds the entry blocking host.
+func (b *Blocklist) lookup(host string) (BlockMatch, *blockSource) {
+	set := b.set.Load()
+	if set == nil {
+		return BlockMatch{}, nil
+	}
+
+	host = strings.TrimSuffix(strings.ToLower(strings.Trim(host, "[]")), ".")
+	if ip := net.ParseIP(host); ip != nil {
+		if src, ok := set.ips[ip.String()]; ok {
+			return BlockMatch{Source: src.name, Entry: ip.String()}, src
+		}
+		for _, n := range set.nets {
+			if n.ipnet.Contains(ip) {
+				return BlockMatch{Source: n.source.name, Entry: n.ipnet.String()}, n.source
+			}
+		}
+		return BlockMatch{}, nil
+	}
+
+	// Walk up the labels so an entry blocks its subdomains too
+	for name := host; name != ""; {
+		if src, ok := set.domains[name]; ok {
+			return BlockMatch{Source: src.name, Entry: name}, src
+		}
+		i := strings.IndexByte(name, '.')
+		if i < 0 {
+			break
+		}
+		name = name[i+1:]
+	}
+	return BlockMatch{}, nil
+}
+
+// Refresh reloads files and feeds and swaps in the new set. Sources that
+// fail keep their previous entries; their errors are joined.
+func (b *Blocklist) Refresh(ctx context.Context) error {
+	b.refreshMu.Lock()
+	defer b.refreshMu.Unlock()
+
+	var errs []error
+	for _, src := range b.sources {
+		var err error
+		switch src.kind {
+		case BlocklistSourceFile:
+			err = b.loadFile(src)
+		case BlocklistSourceFeed:
+			err = b.loadFeed(ctx, src)
+		default:
+			continue
+		}
+
+		b.mu.Lock()
+		src.err = err
+		b.mu.Unlock()
+		if err != nil {
+			b.logger.Warn("blocklist refresh failed", "source", src.name, logging.KeyError, err)
+			errs = append(errs, fmt.Errorf("%s: %w", src.name, err))
+		}
+	}
+
+	b.mu.Lock()
+	b.set.Store(b.compileLocked())
+	b.lastRefresh = time.Now()
+	b.mu.Unlock()
+
+	return errors.Join(errs...)
+}
+
+// loadFile reads a list file into src.
+func (b *Blocklist) loadFile(src *blockSource) error {
+	f, err := os.Open(src.name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	entries, err := parseBlocklist(f)
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	src.entries = entries
+	src.loaded = time.Now()
+	b.mu.Unlock()
+	return nil
+}
+
+// loadFeed fetches a list feed into src. Unchanged feeds answer conditional
+// requests with 304 and keep their entries.
+func (b *Blocklist) loadFeed(ctx context.Context, src *blockSource) error {
+	ctx, cancel := context.WithTimeout(ctx, b.cfg.FeedTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, src.name, nil)
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	if src.etag != "" {
+		req.Header.Set("If-None-Match", src.etag)
+	}
+	if src.lastModified != "" {
+		req.Header.Set("If-Modified-Since", src.lastModified)
+	}
+	b.mu.Unlock()
+
+	resp, err := b.cfg.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified:
+		b.mu.Lock()
+		src.loaded = time.Now()
+		b.mu.Unlock()
+		return nil
+	case resp.StatusCode < 200 || resp.StatusCode >= 300:
+		return fmt.Errorf("feed returned %s", resp.Status)
+	}
+
+	body := &io.LimitedReader{R: resp.Body, N: maxBlocklistFeedSize + 1}
+	entries, err := parseBlocklist(body)
+	if err != nil {
+		return err
+	}
+	if body.N == 0 {
+		return fmt.Errorf("feed exceeds %d bytes", maxBlocklistFeedSize)
+	}
+
+	b.mu.Lock()
+	src.entries = entries
+	src.loaded = time.Now()
+	src.etag = resp.Header.Get("ETag")
+	src.lastModified = resp.Header.Get("Last-Modified")
+	b.mu.Unlock()
+	return nil
+}
+
+// compileLocked builds the union of all source entries. The first source
+// listing an entry gets the blocks. b.mu must be held.
+func (b *Blocklist) compileLocked() *blockSet {
+	set := &blockSet{
+		domains: make(map[string]*blockSource),
+		ips:     make(map[string]*blockSource),
+	}
+	for _, src := range b.sources {
+		for _, d := range src.entries.domains {
+			if _, ok := set.domains[d]; !ok {
+				set.domains[d] = src
+			}
+		}
+		for _, n := range src.entries.nets {
+			if ones, bits := n.Mask.Size(); ones == bits {
+				if _, ok := set.ips[n.IP.String()]; !ok {
+					set.ips[n.IP.String()] = src
+				}
+				continue
+			}
+			set.nets = append(set.nets, blockNet{ipnet: n, source: src})
+		}
+	}
+	set.size = len(set.domains) + len(set.ips) + len(set.nets)
+	return set
+}
+
+// Status returns the counters and the state of every source.
+func (b *Blocklist) Status() BlocklistStatus {
+	st := BlocklistStatus{
+		Checked: b.checked.Load(),
+		Blocked: b.blocked.Load(),
+	}
+	if set := b.set.Load(); set != nil {
+		st.Entries = set.size
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	st.LastRefresh = b.lastRefresh
+	for _, src := range b.sources {
+		ss := BlocklistSourceStatus{
+			Source:  src.name,
+			Type:    src.kind,
+			Entries: len(src.entries.domains) + len(src.entries.nets),
+			Invalid: src.entries.invalid,
+			Blocked: src.blocked.Load(),
+			Loaded:  src.loaded,
+		}
+		if src.err != nil {
+			ss.Error = src.err.Error()
+		}
+		st.Sources = append(st.Sources, ss)
+	}
+	return st
+}
+
+// parseBlocklist reads list entries from r, one per line.
+func parseBlocklist(r io.Reader) (blockEntries, error) {
+	var entries blockEntries
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		switch {
+		case len(fields) == 0:
+		case len(fields) == 1:
+			entries.add(fields[0])
+		case net.ParseIP(fields[0]) != nil:
+			// Hosts file line: the names after the address are blocked.
+			// Single-label names such as localhost are skipped.
+			for _, name := range fields[1:] {
+				if strings.Contains(name, ".") {
+					entries.add(name)
+				}
+			}
+		default:
+			entries.invalid++
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return blockEntries{}, err
+	}
+	sort.Strings(entries.domains)
+	return entries, nil
+}
+
+// add parses one entry and reports whether it was valid.
+func (e *blockEntries) add(entry string) bool {
+	entry = strings.TrimSpace(entry)
+	if strings.Contains(entry, "/") {
+		_, ipnet, err := net.ParseCIDR(entry)
+		if err != nil {
+			e.invalid++
+			return false
+		}
+		e.nets = append(e.nets, ipnet)
+		return true
+	}
+	if ip := net.ParseIP(entry); ip != nil {
+		bits := 8 * net.IPv6len
+		if ip4 := ip.To4(); ip4 != nil {
+			ip, bits = ip4, 8*net.IPv4len
+		}
+		e.nets = append(e.nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+		return true
+	}
+
+	// "*.example.com" and ".example.com" mean the same as "example.com"
+	name := strings.TrimSuffix(strings.ToLower(entry), ".")
+	name = strings.TrimPrefix(strings.TrimPrefix(name, "*"), ".")
+	if !validBlockDomain(name) {
+		e.invalid++
+		return false
+	}
+	e.domains = append(e.domains, name)
+	return true
+}
+
+// validBlockDomain reports whether name looks like a domain name.
+func validBlockDomain(name string) bool {
+	if name == "" || len(name) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(name, ".") {
+		if label == "" || len(label) > 63 {
+			return false
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+				return false
+			}
+		}
+	}
+	return true
+}
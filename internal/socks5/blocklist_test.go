@@ -0,0 +1,266 @@
+package socks5
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseBlocklist(t *testing.T) {
+	list := `# comment
+ads.example.com
+*.tracker.test   # trailing comment
+.cdn.test.
+10.0.0.0/8
+192.0.2.7
+2001:db8::/32
+0.0.0.0 malware.test localhost
+not a valid line
+bad_cidr/99
+`
+	entries, err := parseBlocklist(strings.NewReader(list))
+	if err != nil {
+		t.Fatalf("parseBlocklist() error = %v", err)
+	}
+
+	wantDomains := []string{"ads.example.com", "cdn.test", "malware.test", "tracker.test"}
+	if strings.Join(entries.domains, ",") != strings.Join(wantDomains, ",") {
+		t.Errorf("domains = %v, want %v", entries.domains, wantDomains)
+	}
+	if len(entries.nets) != 3 {
+		t.Errorf("nets = %v, want 3 entries", entries.nets)
+	}
+	if entries.invalid != 2 {
+		t.Errorf("invalid = %d, want 2", entries.invalid)
+	}
+}
+
+func TestBlocklist_Check(t *testing.T) {
+	b, err := NewBlocklist(BlocklistConfig{
+		Domains: []string{"example.com"},
+		CIDRs:   []string{"10.0.0.0/8", "192.0.2.7", "2001:db8::/32"},
+	})
+	if err != nil {
+		t.Fatalf("NewBlocklist() error = %v", err)
+	}
+
+	tests := []struct {
+		host    string
+		blocked bool
+		entry   string
+	}{
+		{"example.com", true, "example.com"},
+		{"WWW.Example.COM.", true, "example.com"},
+		{"notexample.com", false, ""},
+		{"example.org", false, ""},
+		{"10.1.2.3", true, "10.0.0.0/8"},
+		{"192.0.2.7", true, "192.0.2.7"},
+		{"192.0.2.8", false, ""},
+		{"[2001:db8::1]", true, "2001:db8::/32"},
+		{"2001:db9::1", false, ""},
+	}
+	for _, tt := range tests {
+		m, blocked := b.Check(tt.host)
+		if blocked != tt.blocked || m.Entry != tt.entry {
+			t.Errorf("Check(%q) = %+v, %v, want entry %q, %v", tt.host, m, blocked, tt.entry, tt.blocked)
+		}
+		if blocked && m.Source != BlocklistSourceInline {
+			t.Errorf("Check(%q) source = %q, want %q", tt.host, m.Source, BlocklistSourceInline)
+		}
+	}
+
+	// Test does not count
+	if _, blocked := b.Test("example.com"); !blocked {
+		t.Error("Test(example.com) = false, want true")
+	}
+
+	st := b.Status()
+	if st.Checked != uint64(len(tests)) || st.Blocked != 5 {
+		t.Errorf("Status() checked, blocked = %d, %d, want %d, 5", st.Checked, st.Blocked, len(tests))
+	}
+	if st.Entries != 4 || len(st.Sources) != 1 || st.Sources[0].Blocked != 5 {
+		t.Errorf("Status() = %+v", st)
+	}
+
+	for _, cfg := range []BlocklistConfig{
+		{Domains: []string{"bad domain"}},
+		{Domains: []string{"10.0.0.0/8"}},
+		{CIDRs: []string{"example.com"}},
+		{CIDRs: []string{"10.0.0.0/33"}},
+		{Files: []string{filepath.Join(t.TempDir(), "missing.txt")}},
+	} {
+		if _, err := NewBlocklist(cfg); err == nil {
+			t.Errorf("NewBlocklist(%+v) error = nil, want error", cfg)
+		}
+	}
+}
+
+func TestBlocklist_Refresh(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blocked.txt")
+	os.WriteFile(path, []byte("file.test\n"), 0644)
+
+	var (
+		mu       sync.Mutex
+		feed     = "feed.test\n"
+		fail     bool
+		requests int
+	)
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests++
+		if fail {
+			http.Error(w, "down", http.StatusBadGateway)
+			return
+		}
+		etag := `"` + feed + `"`
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		io.WriteString(w, feed)
+	}))
+	defer srv.Close()
+
+	b, err := NewBlocklist(BlocklistConfig{
+		Files:      []string{path},
+		Feeds:      []string{srv.URL},
+		HTTPClient: srv.Client(),
+	})
+	if err != nil {
+		t.Fatalf("NewBlocklist() error = %v", err)
+	}
+	if _, blocked := b.Test("feed.test"); blocked {
+		t.Error("feed entries loaded before the first refresh")
+	}
+	if err := b.Refresh(context.Background()); err != nil {
+		t.Fatalf("Refresh() error = %v", err)
+	}
+
+	for _, host := range []string{"file.test", "feed.test"} {
+		if _, blocked := b.Test(host); !blocked {
+			t.Errorf("Test(%q) = false, want true", host)
+		}
+	}
+
+	// Unchanged feed answers 304 and keeps its entries
+	if err := b.Refresh(context.Background()); err != nil {
+		t.Fatalf("Refresh() error = %v", err)
+	}
+	if m, blocked := b.Test("www.feed.test"); !blocked || m.Source != srv.URL {
+		t.Errorf("Test(www.feed.test) = %+v, %v after 304", m, blocked)
+	}
+
+	// Updated sources replace their entries
+	os.WriteFile(path, []byte("file2.test\n"), 0644)
+	mu.Lock()
+	feed = "feed2.test\n"
+	mu.Unlock()
+	if err := b.Refresh(context.Background()); err != nil {
+		t.Fatalf("Refresh() error = %v", err)
+	}
+	for host, want := range map[string]bool{"file.test": false, "file2.test": true, "feed.test": false, "feed2.test": true} {
+		if _, blocked := b.Test(host); blocked != want {
+			t.Errorf("Test(%q) = %v, want %v", host, blocked, want)
+		}
+	}
+
+	// Failing sources keep the entries of their last good load
+	os.Remove(path)
+	mu.Lock()
+	fail = true
+	mu.Unlock()
+	if err := b.Refresh(context.Background()); err == nil {
+		t.Error("Refresh() with failing sources error = nil, want error")
+	}
+	for _, host := range []string{"file2.test", "feed2.test"} {
+		if _, blocked := b.Test(host); !blocked {
+			t.Errorf("Test(%q) = false after failed refresh, want true", host)
+		}
+	}
+	for _, src := range b.Status().Sources {
+		if src.Type != BlocklistSourceInline && (src.Error == "" || src.Entries != 1) {
+			t.Errorf("source %+v: want an error and 1 entry", src)
+		}
+	}
+
+	mu.Lock()
+	if requests != 4 {
+		t.Errorf("feed requests = %d, want 4", requests)
+	}
+	mu.Unlock()
+}
+
+func TestServer_ConnectBlocked(t *testing.T) {
+	b, err := NewBlocklist(BlocklistConfig{CIDRs: []string{"127.0.0.0/8"}})
+	if err != nil {
+		t.Fatalf("NewBlocklist() error = %v", err)
+	}
+
+	dialed := make(chan struct{}, 1)
+	cfg := DefaultServerConfig()
+	cfg.Address = "127.0.0.1:0"
+	cfg.Dialer = dialerFunc(func(ctx context.Context, network, address string) (net.Conn, error) {
+		dialed <- struct{}{}
+		return nil, io.EOF
+	})
+	s := NewServer(cfg)
+	s.SetDestinationFilter(b)
+	if err := s.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer s.Stop()
+
+	conn, err := net.Dial("tcp", s.Address().String())
+	if err != nil {
+		t.Fatalf("Dial SOCKS5 error: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	conn.Write([]byte{SOCKS5Version, 1, AuthMethodNoAuth})
+	io.ReadFull(conn, make([]byte, 2))
+
+	req := &bytes.Buffer{}
+	req.Write([]byte{SOCKS5Version, CmdConnect, 0x00, AddrTypeIPv4, 127, 0, 0, 1})
+	binary.Write(req, binary.BigEndian, uint16(80))
+	conn.Write(req.Bytes())
+
+	reply := make([]byte, 10)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		t.Fatalf("Read reply error: %v", err)
+	}
+	if reply[1] != ReplyNotAllowed {
+		t.Errorf("Reply = %d, want %d", reply[1], ReplyNotAllowed)
+	}
+	select {
+	case <-dialed:
+		t.Error("blocked destination was dialed")
+	default:
+	}
+	if st := b.Status(); st.Blocked != 1 {
+		t.Errorf("Status().Blocked = %d, want 1", st.Blocked)
+	}
+}
+
+// dialerFunc adapts a function to the Dialer interface.
+type dialerFunc func(ctx context.Context, network, address string) (net.Conn, error)
+
+func (f dialerFunc) Dial(network, address string) (net.Conn, error) {
+	return f(context.Background(), network, address)
+}
+
+func (f dialerFunc) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	return f(ctx, network, address)
+}
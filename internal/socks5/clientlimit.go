@@ -0,0 +1,232 @@
+package socks5
+
+import (
+	"errors"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"github.com/postalsys/muti-metroo/internal/logging"
+)
+
+// Errors returned when a client may not open another connection.
+var (
+	ErrClientBanned          = errors.New("client temporarily banned")
+	ErrClientConnectionLimit = errors.New("too many concurrent connections")
+	ErrClientRateLimit       = errors.New("connection rate exceeded")
+)
+
+// clientLimitPruneInterval is how often idle client entries are removed.
+const clientLimitPruneInterval = time.Minute
+
+// ClientLimit caps the connections of one client. Zero values mean no limit.
+type ClientLimit struct {
+	// MaxConnections is the number of concurrent connections.
+	MaxConnections int
+
+	// Rate is the number of new connections per second, with bursts of
+	// Burst (at least 1).
+	Rate  float64
+	Burst int
+}
+
+// enabled reports whether any limit is set.
+func (l ClientLimit) enabled() bool {
+	return l.MaxConnections > 0 || l.Rate > 0
+}
+
+// ClientLimitConfig configures per source IP and per user connection limits.
+type ClientLimitConfig struct {
+	PerIP   ClientLimit
+	PerUser ClientLimit
+
+	// BanThreshold rejections within BanWindow ban the source IP or user
+	// for BanDuration (0 = no bans).
+	BanThreshold int
+	BanWindow    time.Duration
+	BanDuration  time.Duration
+
+	// Logger reports bans.
+	Logger *slog.Logger
+}
+
+// clientState is the connection state of one source IP or user.
+type clientState struct {
+	active   int
+	limiter  *rate.Limiter
+	lastUsed time.Time
+
+	rejections  int
+	windowStart time.Time
+	bannedUntil time.Time
+}
+
+// clientTable applies one ClientLimit per key.
+type clientTable struct {
+	kind    string // "ip" or "user", for logging
+	limit   ClientLimit
+	clients map[string]*clientState
+}
+
+// ClientLimiter enforces ClientLimitConfig. It is safe for concurrent use.
+type ClientLimiter struct {
+	cfg    ClientLimitConfig
+	logger *slog.Logger
+	now    func() time.Time
+
+	mu        sync.Mutex
+	ips       clientTable
+	users     clientTable
+	lastPrune time.Time
+}
+
+// NewClientLimiter creates a client limiter.
+func NewClientLimiter(cfg ClientLimitConfig) *ClientLimiter {
+	if cfg.Logger == nil {
+		cfg.Logger = logging.NopLogger()
+	}
+	return &ClientLimiter{
+		cfg:    cfg,
+		logger: cfg.Logger,
+		now:    time.Now,
+		ips:    clientTable{kind: "ip", limit: cfg.PerIP, clients: make(map[string]*clientState)},
+		users:  clientTable{kind: "user", limit: cfg.PerUser, clients: make(map[string]*clientState)},
+	}
+}
+
+// AdmitIP admits a new connection from a source address. On success the
+// returned function must be called when the connection closes. Addresses
+// without an IP, such as UNIX socket peers, are always admitted.
+func (l *ClientLimiter) AdmitIP(addr net.Addr) (release func(), err error) {
+	ip := clientIP(addr)
+	if ip == "" || !l.cfg.PerIP.enabled() {
+		return func() {}, nil
+	}
+	return l.admit(&l.ips, ip)
+}
+
+// AdmitUser admits a new connection from an authenticated user. On success
+// the returned function must be called when the connection closes.
+func (l *ClientLimiter) AdmitUser(user string) (release func(), err error) {
+	if user == "" || !l.cfg.PerUser.enabled() {
+		return func() {}, nil
+	}
+	return l.admit(&l.users, user)
+}
+
+// admit checks a key against its table's limit and counts the connection.
+func (l *ClientLimiter) admit(t *clientTable, key string) (func(), error) {
+	now := l.now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.pruneLocked(now)
+
+	c := t.clients[key]
+	if c == nil {
+		c = &clientState{}
+		if t.limit.Rate > 0 {
+			c.limiter = rate.NewLimiter(rate.Limit(t.limit.Rate), max(t.limit.Burst, 1))
+		}
+		t.clients[key] = c
+	}
+	c.lastUsed = now
+
+	if now.Before(c.bannedUntil) {
+		return nil, ErrClientBanned
+	}
+	var err error
+	switch {
+	case t.limit.MaxConnections > 0 && c.active >= t.limit.MaxConnections:
+		err = ErrClientConnectionLimit
+	case c.limiter != nil && !c.limiter.AllowN(now, 1):
+		err = ErrClientRateLimit
+	}
+	if err != nil {
+		l.rejectLocked(t, key, c, now, err)
+		return nil, err
+	}
+
+	c.active++
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mu.Lock()
+			c.active--
+			c.lastUsed = l.now()
+			l.mu.Unlock()
+		})
+	}, nil
+}
+
+// rejectLocked counts a rejection and bans the key once BanThreshold
+// rejections fall within BanWindow. Must be called with l.mu held.
+func (l *ClientLimiter) rejectLocked(t *clientTable, key string, c *clientState, now time.Time, reason error) {
+	if l.cfg.BanThreshold <= 0 {
+		return
+	}
+	if now.Sub(c.windowStart) > l.cfg.BanWindow {
+		c.windowStart = now
+		c.rejections = 0
+	}
+	c.rejections++
+	if c.rejections < l.cfg.BanThreshold {
+		return
+	}
+
+	c.bannedUntil = now.Add(l.cfg.BanDuration)
+	c.rejections = 0
+	l.logger.Warn("SOCKS5 client temporarily banned",
+		"kind", t.kind,
+		"client", key,
+		"reason", reason.Error(),
+		"rejections", l.cfg.BanThreshold,
+		"window", l.cfg.BanWindow,
+		"duration", l.cfg.BanDuration)
+}
+
+// pruneLocked forgets idle clients without connections or an active ban.
+// Must be called with l.mu held.
+func (l *ClientLimiter) pruneLocked(now time.Time) {
+	if now.Sub(l.lastPrune) < clientLimitPruneInterval {
+		return
+	}
+	l.lastPrune = now
+
+	for _, t := range []*clientTable{&l.ips, &l.users} {
+		// Forgetting a client must neither end its ban window early nor
+		// hand it a full token bucket before it could have refilled
+		idle := max(l.cfg.BanWindow, clientLimitPruneInterval)
+		if t.limit.Rate > 0 {
+			idle = max(idle, time.Duration(float64(max(t.limit.Burst, 1))/t.limit.Rate*float64(time.Second)))
+		}
+		for key, c := range t.clients {
+			if c.active == 0 && now.After(c.bannedUntil) && now.Sub(c.lastUsed) > idle {
+				delete(t.clients, key)
+			}
+		}
+	}
+}
+
+// clientIP returns the IP address of a connection's remote address, or ""
+// when it has none.
+func clientIP(addr net.Addr) string {
+	switch a := addr.(type) {
+	case nil:
+		return ""
+	case *net.TCPAddr:
+		if a == nil || a.IP == nil {
+			return ""
+		}
+		return a.IP.String()
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil || net.ParseIP(host) == nil {
+		return ""
+	}
+	return host
+}
@@ -0,0 +1,129 @@
+package socks5
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestClientLimiter_Limits(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	l := NewClientLimiter(ClientLimitConfig{
+		PerIP:   ClientLimit{MaxConnections: 2},
+		PerUser: ClientLimit{Rate: 1, Burst: 2},
+	})
+	l.now = func() time.Time { return now }
+
+	addr := &net.TCPAddr{IP: net.ParseIP("192.0.2.10"), Port: 40000}
+	r1, err := l.AdmitIP(addr)
+	if err != nil {
+		t.Fatalf("AdmitIP() #1 error = %v", err)
+	}
+	if _, err := l.AdmitIP(&net.TCPAddr{IP: addr.IP, Port: 40001}); err != nil {
+		t.Fatalf("AdmitIP() #2 error = %v", err)
+	}
+	if _, err := l.AdmitIP(addr); !errors.Is(err, ErrClientConnectionLimit) {
+		t.Errorf("AdmitIP() #3 error = %v, want %v", err, ErrClientConnectionLimit)
+	}
+	if _, err := l.AdmitIP(&net.TCPAddr{IP: net.ParseIP("192.0.2.11")}); err != nil {
+		t.Errorf("AdmitIP() from another IP error = %v", err)
+	}
+	r1()
+	r1() // Releasing twice frees one slot only
+	if _, err := l.AdmitIP(addr); err != nil {
+		t.Errorf("AdmitIP() after release error = %v", err)
+	}
+	if _, err := l.AdmitIP(addr); !errors.Is(err, ErrClientConnectionLimit) {
+		t.Errorf("AdmitIP() after double release error = %v, want %v", err, ErrClientConnectionLimit)
+	}
+
+	// UNIX socket peers have no IP
+	if _, err := l.AdmitIP(&net.UnixAddr{Name: "@", Net: "unix"}); err != nil {
+		t.Errorf("AdmitIP(unix) error = %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := l.AdmitUser("alice"); err != nil {
+			t.Fatalf("AdmitUser() #%d error = %v", i+1, err)
+		}
+	}
+	if _, err := l.AdmitUser("alice"); !errors.Is(err, ErrClientRateLimit) {
+		t.Errorf("AdmitUser() over rate error = %v, want %v", err, ErrClientRateLimit)
+	}
+	now = now.Add(time.Second)
+	if _, err := l.AdmitUser("alice"); err != nil {
+		t.Errorf("AdmitUser() after refill error = %v", err)
+	}
+}
+
+func TestClientLimiter_Ban(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	l := NewClientLimiter(ClientLimitConfig{
+		PerUser:      ClientLimit{MaxConnections: 1},
+		BanThreshold: 3,
+		BanWindow:    time.Minute,
+		BanDuration:  10 * time.Minute,
+	})
+	l.now = func() time.Time { return now }
+
+	release, err := l.AdmitUser("bob")
+	if err != nil {
+		t.Fatalf("AdmitUser() error = %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := l.AdmitUser("bob"); !errors.Is(err, ErrClientConnectionLimit) {
+			t.Fatalf("AdmitUser() rejection #%d error = %v", i+1, err)
+		}
+	}
+
+	// Banned even though a slot is free
+	release()
+	if _, err := l.AdmitUser("bob"); !errors.Is(err, ErrClientBanned) {
+		t.Errorf("AdmitUser() while banned error = %v, want %v", err, ErrClientBanned)
+	}
+	now = now.Add(10 * time.Minute)
+	if _, err := l.AdmitUser("bob"); err != nil {
+		t.Errorf("AdmitUser() after ban error = %v", err)
+	}
+}
+
+func TestHandler_ClientLimits(t *testing.T) {
+	h := NewHandler(nil, &DirectDialer{})
+	h.SetClientLimiter(NewClientLimiter(ClientLimitConfig{PerIP: ClientLimit{MaxConnections: 1}}))
+
+	client, server := net.Pipe()
+	defer client.Close()
+	addr := &net.TCPAddr{IP: net.ParseIP("192.0.2.20"), Port: 1}
+	first := &addrConn{Conn: server, remote: addr}
+	done := make(chan struct{})
+	go func() {
+		h.Handle(first)
+		close(done)
+	}()
+
+	// Wait until the first connection holds the slot: the handler is
+	// blocked reading the greeting
+	client.Write([]byte{SOCKS5Version, 1, AuthMethodNoAuth})
+	reply := make([]byte, 2)
+	if _, err := client.Read(reply); err != nil {
+		t.Fatalf("read method selection: %v", err)
+	}
+
+	second, secondPeer := net.Pipe()
+	defer secondPeer.Close()
+	if err := h.Handle(&addrConn{Conn: second, remote: addr}); !errors.Is(err, ErrClientConnectionLimit) {
+		t.Errorf("Handle() second connection error = %v, want %v", err, ErrClientConnectionLimit)
+	}
+
+	client.Close()
+	<-done
+}
+
+// addrConn overrides the remote address of a connection.
+type addrConn struct {
+	net.Conn
+	remote net.Addr
+}
+
+func (c *addrConn) RemoteAddr() net.Addr { return c.remote }
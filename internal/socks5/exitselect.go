@@ -0,0 +1,40 @@
+package socks5
+
+import (
+	"context"
+	"errors"
+	"strings"
+)
+
+// ExitHintSeparator separates the account name from the requested exit in a
+// SOCKS5 username, e.g. "alice@agent:abc123" or "alice@agent:exit-eu-west".
+const ExitHintSeparator = "@agent:"
+
+// ErrExitUnavailable is returned by dialers when the exit selected through the
+// username is unknown or unreachable. It is reported as "network unreachable".
+var ErrExitUnavailable = errors.New("selected exit unavailable")
+
+// SplitExitHint splits a SOCKS5 username into the account name and the
+// requested exit (agent ID prefix or display name). exit is empty if the
+// username carries no exit hint.
+func SplitExitHint(username string) (user, exit string) {
+	i := strings.LastIndex(username, ExitHintSeparator)
+	if i <= 0 || i+len(ExitHintSeparator) == len(username) {
+		return username, ""
+	}
+	return username[:i], username[i+len(ExitHintSeparator):]
+}
+
+type exitHintKey struct{}
+
+// WithExitHint returns a context carrying the exit requested by the client.
+func WithExitHint(ctx context.Context, exit string) context.Context {
+	return context.WithValue(ctx, exitHintKey{}, exit)
+}
+
+// ExitHintFromContext returns the exit requested by the client, or "" if the
+// connection uses normal routing.
+func ExitHintFromContext(ctx context.Context) string {
+	exit, _ := ctx.Value(exitHintKey{}).(string)
+	return exit
+}
@@ -0,0 +1,269 @@
+package socks5
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/postalsys/muti-metroo/internal/logging"
+)
+
+// External authenticator types.
+const (
+	ExternalAuthHTTP    = "http"
+	ExternalAuthCommand = "command"
+)
+
+// Defaults for external authentication.
+const (
+	DefaultExternalAuthTimeout = 5 * time.Second
+
+	// maxExternalAuthCacheEntries bounds the verdict cache. When it is full
+	// and nothing has expired, the cache starts over.
+	maxExternalAuthCacheEntries = 10000
+)
+
+// ExternalAuthConfig configures credential checks against an HTTP webhook
+// or an OS command.
+type ExternalAuthConfig struct {
+	// Type is ExternalAuthHTTP or ExternalAuthCommand.
+	Type string
+
+	// URL receives a POST with a JSON body {"username": ..., "password": ...}.
+	// A 2xx status accepts the credentials, 401 or 403 rejects them, and
+	// anything else is an error (http only).
+	URL string
+
+	// Headers are added to every webhook request (http only).
+	Headers map[string]string
+
+	// Command is the program and its arguments (command only). It reads the
+	// username and the password from stdin, one per line. Exit status 0
+	// accepts the credentials, any other status rejects them.
+	Command []string
+
+	// Timeout limits each check (0 = DefaultExternalAuthTimeout).
+	Timeout time.Duration
+
+	// CacheTTL is how long accepted credentials are remembered (0 = not
+	// cached).
+	CacheTTL time.Duration
+
+	// NegativeCacheTTL is how long rejected credentials are remembered
+	// (0 = not cached). Errors and timeouts are never cached.
+	NegativeCacheTTL time.Duration
+
+	// HTTPClient sends webhook requests (nil = a client without proxy
+	// settings from the environment).
+	HTTPClient *http.Client
+
+	// Logger reports failed checks.
+	Logger *slog.Logger
+}
+
+// ExternalCredentials is a CredentialStore that asks an HTTP webhook or an
+// OS command whether credentials are valid, caching the verdicts. Cache
+// keys are hashes, so passwords are not kept in memory.
+type ExternalCredentials struct {
+	cfg    ExternalAuthConfig
+	check  func(ctx context.Context, username, password string) (bool, error)
+	logger *slog.Logger
+
+	mu    sync.Mutex
+	cache map[[sha256.Size]byte]externalVerdict
+}
+
+type externalVerdict struct {
+	ok      bool
+	expires time.Time
+}
+
+// NewExternalCredentials creates an external credential store.
+func NewExternalCredentials(cfg ExternalAuthConfig) (*ExternalCredentials, error) {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = DefaultExternalAuthTimeout
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = logging.NopLogger()
+	}
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = &http.Client{Transport: &http.Transport{}}
+	}
+
+	e := &ExternalCredentials{
+		cfg:    cfg,
+		logger: cfg.Logger,
+		cache:  make(map[[sha256.Size]byte]externalVerdict),
+	}
+
+	switch cfg.Type {
+	case ExternalAuthHTTP:
+		if cfg.URL == "" {
+			return nil, errors.New("external auth: url is required for http")
+		}
+		e.check = e.checkHTTP
+	case ExternalAuthCommand:
+		if len(cfg.Command) == 0 || cfg.Command[0] == "" {
+			return nil, errors.New("external auth: command is required")
+		}
+		e.check = e.checkCommand
+	default:
+		return nil, fmt.Errorf("external auth: unknown type %q (expected %q or %q)", cfg.Type, ExternalAuthHTTP, ExternalAuthCommand)
+	}
+	return e, nil
+}
+
+// Valid checks the credentials against the external authenticator, or
+// returns the cached verdict. Errors count as a rejection.
+func (e *ExternalCredentials) Valid(username, password string) bool {
+	// Exit hints are stripped by the authenticator for accounts allowed to
+	// use them; any hint left here is not for the external system to judge
+	if username == "" || strings.Contains(username, ExitHintSeparator) {
+		return false
+	}
+
+	key := externalCacheKey(username, password)
+	now := time.Now()
+
+	e.mu.Lock()
+	v, ok := e.cache[key]
+	e.mu.Unlock()
+	if ok && now.Before(v.expires) {
+		return v.ok
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), e.cfg.Timeout)
+	defer cancel()
+
+	valid, err := e.check(ctx, username, password)
+	if err != nil {
+		e.logger.Warn("SOCKS5 external authentication failed",
+			"type", e.cfg.Type,
+			"username", username,
+			logging.KeyError, err)
+		return false
+	}
+
+	ttl := e.cfg.NegativeCacheTTL
+	if valid {
+		ttl = e.cfg.CacheTTL
+	}
+	if ttl > 0 {
+		e.remember(key, externalVerdict{ok: valid, expires: now.Add(ttl)})
+	}
+	return valid
+}
+
+// remember stores a verdict, making room when the cache is full.
+func (e *ExternalCredentials) remember(key [sha256.Size]byte, v externalVerdict) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if len(e.cache) >= maxExternalAuthCacheEntries {
+		now := time.Now()
+		for k, old := range e.cache {
+			if !now.Before(old.expires) {
+				delete(e.cache, k)
+			}
+		}
+		if len(e.cache) >= maxExternalAuthCacheEntries {
+			clear(e.cache)
+		}
+	}
+	e.cache[key] = v
+}
+
+func externalCacheKey(username, password string) [sha256.Size]byte {
+	h := sha256.New()
+	h.Write([]byte(username))
+	h.Write([]byte{0})
+	h.Write([]byte(password))
+	var key [sha256.Size]byte
+	h.Sum(key[:0])
+	return key
+}
+
+// checkHTTP posts the credentials to the webhook.
+func (e *ExternalCredentials) checkHTTP(ctx context.Context, username, password string) (bool, error) {
+	body, err := json.Marshal(map[string]string{
+		"username": username,
+		"password": password,
+	})
+	if err != nil {
+		return false, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.cfg.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := e.cfg.HTTPClient.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return true, nil
+	case resp.StatusCode == http.StatusUnauthorized, resp.StatusCode == http.StatusForbidden:
+		return false, nil
+	default:
+		return false, fmt.Errorf("webhook returned %s", resp.Status)
+	}
+}
+
+// checkCommand runs the command with the credentials on stdin.
+func (e *ExternalCredentials) checkCommand(ctx context.Context, username, password string) (bool, error) {
+	// One value per line; a newline inside either would shift the fields
+	if strings.ContainsAny(username, "\r\n") || strings.ContainsAny(password, "\r\n") {
+		return false, nil
+	}
+
+	cmd := exec.CommandContext(ctx, e.cfg.Command[0], e.cfg.Command[1:]...)
+	cmd.Stdin = strings.NewReader(username + "\n" + password + "\n")
+	cmd.WaitDelay = time.Second
+
+	err := cmd.Run()
+	if err == nil {
+		return true, nil
+	}
+	if ctx.Err() != nil {
+		return false, fmt.Errorf("command timed out after %v", e.cfg.Timeout)
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return false, nil
+	}
+	return false, err
+}
+
+// CredentialChain accepts credentials that any of its stores accepts,
+// trying them in order.
+type CredentialChain []CredentialStore
+
+// Valid checks the credentials against each store in turn.
+func (c CredentialChain) Valid(username, password string) bool {
+	for _, store := range c {
+		if store.Valid(username, password) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,176 @@
+package socks5
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newWebhook starts a webhook accepting alice/secret and counting calls.
+func newWebhook(t *testing.T) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if r.Header.Get("X-Api-Key") != "k1" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		var body struct{ Username, Password string }
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if body.Username == "alice" && body.Password == "secret" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &calls
+}
+
+func TestExternalCredentials_HTTP(t *testing.T) {
+	srv, calls := newWebhook(t)
+	creds, err := NewExternalCredentials(ExternalAuthConfig{
+		Type:             ExternalAuthHTTP,
+		URL:              srv.URL,
+		Headers:          map[string]string{"X-Api-Key": "k1"},
+		CacheTTL:         time.Minute,
+		NegativeCacheTTL: time.Minute,
+	})
+	if err != nil {
+		t.Fatalf("NewExternalCredentials() error = %v", err)
+	}
+
+	if !creds.Valid("alice", "secret") {
+		t.Error("Valid(alice, secret) = false, want true")
+	}
+	if creds.Valid("alice", "wrong") {
+		t.Error("Valid(alice, wrong) = true, want false")
+	}
+	if creds.Valid("alice@agent:abc", "secret") {
+		t.Error("Valid() with exit hint = true, want false")
+	}
+
+	// Both verdicts are cached
+	creds.Valid("alice", "secret")
+	creds.Valid("alice", "wrong")
+	if got := calls.Load(); got != 2 {
+		t.Errorf("webhook calls = %d, want 2", got)
+	}
+}
+
+func TestExternalCredentials_HTTPErrorsNotCached(t *testing.T) {
+	srv, calls := newWebhook(t)
+	creds, err := NewExternalCredentials(ExternalAuthConfig{
+		Type:             ExternalAuthHTTP,
+		URL:              srv.URL, // no API key: the webhook fails with 500
+		CacheTTL:         time.Minute,
+		NegativeCacheTTL: time.Minute,
+	})
+	if err != nil {
+		t.Fatalf("NewExternalCredentials() error = %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if creds.Valid("alice", "secret") {
+			t.Fatal("Valid() = true on webhook error, want false")
+		}
+	}
+	if got := calls.Load(); got != 2 {
+		t.Errorf("webhook calls = %d, want 2 (errors must not be cached)", got)
+	}
+}
+
+func TestExternalCredentials_Command(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("test script needs a POSIX shell")
+	}
+	script := filepath.Join(t.TempDir(), "check.sh")
+	content := "#!/bin/sh\nread user\nread pass\n[ \"$user\" = alice ] && [ \"$pass\" = secret ]\n"
+	if err := os.WriteFile(script, []byte(content), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	creds, err := NewExternalCredentials(ExternalAuthConfig{
+		Type:    ExternalAuthCommand,
+		Command: []string{script},
+	})
+	if err != nil {
+		t.Fatalf("NewExternalCredentials() error = %v", err)
+	}
+	if !creds.Valid("alice", "secret") {
+		t.Error("Valid(alice, secret) = false, want true")
+	}
+	if creds.Valid("alice", "wrong") {
+		t.Error("Valid(alice, wrong) = true, want false")
+	}
+	if creds.Valid("alice", "secret\nalice") {
+		t.Error("Valid() with newline in password = true, want false")
+	}
+
+	slow, _ := NewExternalCredentials(ExternalAuthConfig{
+		Type:    ExternalAuthCommand,
+		Command: []string{"sleep", "5"},
+		Timeout: 50 * time.Millisecond,
+	})
+	start := time.Now()
+	if slow.Valid("alice", "secret") {
+		t.Error("Valid() = true after timeout, want false")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Valid() took %v, want the timeout to stop the command", elapsed)
+	}
+}
+
+func TestCreateAuthenticators_External(t *testing.T) {
+	srv, _ := newWebhook(t)
+	ext, err := NewExternalCredentials(ExternalAuthConfig{
+		Type:    ExternalAuthHTTP,
+		URL:     srv.URL,
+		Headers: map[string]string{"X-Api-Key": "k1"},
+	})
+	if err != nil {
+		t.Fatalf("NewExternalCredentials() error = %v", err)
+	}
+
+	auths := CreateAuthenticators(AuthConfig{
+		Enabled:               true,
+		Required:              true,
+		Users:                 map[string]string{"bob": "pw"},
+		External:              ext,
+		ExternalExitSelection: true,
+	})
+	if len(auths) != 1 {
+		t.Fatalf("CreateAuthenticators() len = %d, want 1", len(auths))
+	}
+	auth := auths[0]
+
+	tests := []struct {
+		username, password string
+		wantOK             bool
+	}{
+		{"bob", "pw", true},                     // configured user
+		{"alice", "secret", true},               // external user
+		{"alice@agent:abc", "secret", true},     // external users may select exits
+		{"bob@agent:abc", "pw", false},          // bob is not known to the webhook
+		{"carol", "secret", false},              // unknown to both
+		{"alice", "pw", false},                  // wrong password
+		{"alice@agent:abc@agent:x", "x", false}, // hint left after stripping
+	}
+	for _, tt := range tests {
+		_, err := auth.Authenticate(bytes.NewReader(userPassRequest(tt.username, tt.password)), &bytes.Buffer{})
+		if (err == nil) != tt.wantOK {
+			t.Errorf("Authenticate(%q, %q) error = %v, want ok = %v", tt.username, tt.password, err, tt.wantOK)
+		}
+	}
+}
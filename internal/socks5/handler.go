@@ -9,7 +9,10 @@ import (
 	"net"
 	"strconv"
 	"sync"
+	"syscall"
 	"time"
+
+	"github.com/postalsys/muti-metroo/internal/proxyproto"
 )
 
 // SOCKS5 protocol constants per RFC 1928.
@@ -77,6 +80,27 @@ type Handler struct {
 	icmpHandler      ICMPHandler
 	icmpAssocMu      sync.Mutex
 	icmpAssociations map[uint64]*ICMPAssociation
+
+	// limiter accounts relayed bytes of authenticated users (optional)
+	limiter UserLimiter
+
+	// destFilter rejects blocked CONNECT destinations (optional)
+	destFilter DestinationFilter
+
+	// usage records relayed bytes of authenticated users (optional)
+	usage UsageRecorder
+
+	// clients limits connections per source IP and user (optional)
+	clients *ClientLimiter
+
+	// sendClientAddr passes the client address to the dialer
+	sendClientAddr bool
+}
+
+// UsageRecorder records the CONNECT traffic of authenticated users. in is
+// received from the client, out is sent to it.
+type UsageRecorder interface {
+	AddUser(user string, in, out uint64)
 }
 
 // Dialer interface for making outbound connections.
@@ -135,24 +159,86 @@ func (h *Handler) SetICMPHandler(handler ICMPHandler) {
 	h.icmpHandler = handler
 }
 
+// SetUserLimiter sets the limiter that accounts CONNECT traffic of
+// authenticated users. Connections are closed once the user's byte quota
+// is exceeded.
+func (h *Handler) SetUserLimiter(limiter UserLimiter) {
+	h.limiter = limiter
+}
+
+// SetDestinationFilter sets the filter that rejects CONNECT requests to
+// blocked destinations before they are dialed.
+func (h *Handler) SetDestinationFilter(filter DestinationFilter) {
+	h.destFilter = filter
+}
+
+// SetUsageRecorder sets the recorder that accounts CONNECT traffic of
+// authenticated users.
+func (h *Handler) SetUsageRecorder(recorder UsageRecorder) {
+	h.usage = recorder
+}
+
+// SetClientLimiter sets the limiter that caps connections per source IP
+// and per authenticated user.
+func (h *Handler) SetClientLimiter(limiter *ClientLimiter) {
+	h.clients = limiter
+}
+
+// SetSendClientAddress sets whether CONNECT dials carry the client address
+// in their context (see proxyproto.WithClientAddr).
+func (h *Handler) SetSendClientAddress(send bool) {
+	h.sendClientAddr = send
+}
+
 // Handle processes a SOCKS5 connection.
 func (h *Handler) Handle(conn net.Conn) error {
+	// Connections over a source IP's limits are closed before the handshake
+	if h.clients != nil {
+		release, err := h.clients.AdmitIP(conn.RemoteAddr())
+		if err != nil {
+			return fmt.Errorf("client %s: %w", conn.RemoteAddr(), err)
+		}
+		defer release()
+	}
+
 	// Perform authentication
-	_, err := h.authenticate(conn)
+	username, err := h.authenticate(conn)
 	if err != nil {
 		return fmt.Errorf("authentication: %w", err)
 	}
 
+	// Authenticators only accept an exit hint from accounts allowed to use it
+	ctx := context.Background()
+	user, exit := SplitExitHint(username)
+	if user != "" {
+		ctx = WithUser(ctx, user)
+	}
+	if exit != "" {
+		ctx = WithExitHint(ctx, exit)
+	}
+	if h.sendClientAddr {
+		ctx = proxyproto.WithClientAddr(ctx, conn.RemoteAddr())
+	}
+
 	// Read the request
 	req, err := h.readRequest(conn)
 	if err != nil {
 		return fmt.Errorf("read request: %w", err)
 	}
 
+	if h.clients != nil && user != "" {
+		release, err := h.clients.AdmitUser(user)
+		if err != nil {
+			h.sendReply(conn, ReplyNotAllowed, nil, 0)
+			return fmt.Errorf("user %s: %w", user, err)
+		}
+		defer release()
+	}
+
 	// Dispatch based on command
 	switch req.Command {
 	case CmdConnect:
-		return h.handleConnect(conn, req)
+		return h.handleConnect(ctx, conn, req)
 	case CmdUDPAssociate:
 		return h.handleUDPAssociate(conn, req)
 	case CmdICMPEcho:
@@ -171,13 +257,23 @@ type noDeadlineMonitor interface {
 	NoDeadlineMonitor() bool
 }
 
-// handleConnect handles CONNECT commands.
-func (h *Handler) handleConnect(conn net.Conn, req *Request) error {
+// handleConnect handles CONNECT commands. ctx carries per-connection dial
+// hints such as the exit selected through the username.
+func (h *Handler) handleConnect(parent context.Context, conn net.Conn, req *Request) error {
 	targetAddr := net.JoinHostPort(req.DestAddr, strconv.Itoa(int(req.DestPort)))
 
+	// Blocked destinations never reach the dialer, so no mesh traffic is
+	// generated for them
+	if h.destFilter != nil {
+		if m, blocked := h.destFilter.Check(req.DestAddr); blocked {
+			h.sendReply(conn, ReplyNotAllowed, nil, 0)
+			return fmt.Errorf("destination %s blocked by %s (%s)", targetAddr, m.Entry, m.Source)
+		}
+	}
+
 	// Create context that cancels when client disconnects during dial.
 	// This prevents orphan streams when clients (like nmap) timeout early.
-	ctx, cancel := context.WithCancel(context.Background())
+	ctx, cancel := context.WithCancel(parent)
 	defer cancel()
 
 	// Check if connection supports deadline-based monitoring.
@@ -267,7 +363,11 @@ func (h *Handler) handleConnect(conn net.Conn, req *Request) error {
 	conn.SetDeadline(time.Time{})
 	target.SetDeadline(time.Time{})
 
-	// Bidirectional relay
+	// Bidirectional relay, accounted to the user when limits or usage
+	// recording apply
+	if user := UserFromContext(parent); user != "" && (h.limiter != nil || h.usage != nil) {
+		return relayAccounted(conn, target, user, h.limiter, h.usage)
+	}
 	return relay(conn, target)
 }
 
@@ -281,9 +381,9 @@ func (h *Handler) handleUDPAssociate(conn net.Conn, req *Request) error {
 	}
 
 	// Parse expected client address from request
-	// The client MAY specify the address/port it will use, or 0.0.0.0:0
+	// The client MAY specify the address and/or port it will use, or 0.0.0.0:0
 	var expectedClient *net.UDPAddr
-	if req.DestIP != nil && !req.DestIP.IsUnspecified() {
+	if req.DestPort != 0 || (req.DestIP != nil && !req.DestIP.IsUnspecified()) {
 		expectedClient = &net.UDPAddr{
 			IP:   req.DestIP,
 			Port: int(req.DestPort),
@@ -330,8 +430,11 @@ func (h *Handler) handleUDPAssociate(conn net.Conn, req *Request) error {
 	var replyIP net.IP
 	if tcpLocal, ok := conn.LocalAddr().(*net.TCPAddr); ok && !tcpLocal.IP.IsUnspecified() {
 		replyIP = tcpLocal.IP
+	} else if relayAddr.IP.To4() == nil {
+		// Fallback to loopback of the relay socket's family if we can't
+		// determine the IP
+		replyIP = net.IPv6loopback
 	} else {
-		// Fallback to 127.0.0.1 if we can't determine the IP
 		replyIP = net.IPv4(127, 0, 0, 1)
 	}
 	h.sendReply(conn, ReplySucceeded, replyIP, uint16(relayAddr.Port))
@@ -612,8 +715,24 @@ func (h *Handler) sendReplyForError(conn net.Conn, err error) {
 	h.sendReply(conn, reply, nil, 0)
 }
 
+// ReplyError is implemented by dial errors that know which SOCKS5 reply
+// code describes them, such as failures reported by a remote exit.
+type ReplyError interface {
+	error
+	Reply() byte
+}
+
 // mapErrorToReply converts a network error to the appropriate SOCKS5 reply code.
 func mapErrorToReply(err error) byte {
+	if errors.Is(err, ErrExitUnavailable) {
+		return ReplyNetworkUnreachable
+	}
+
+	var replyErr ReplyError
+	if errors.As(err, &replyErr) {
+		return replyErr.Reply()
+	}
+
 	// Check for DNS errors first (more specific)
 	var dnsErr *net.DNSError
 	if errors.As(err, &dnsErr) {
@@ -621,11 +740,16 @@ func mapErrorToReply(err error) byte {
 	}
 
 	// Check for operation errors
-	if netErr, ok := err.(*net.OpError); ok {
-		if netErr.Timeout() {
+	var netErr *net.OpError
+	if errors.As(err, &netErr) {
+		switch {
+		case netErr.Timeout():
 			return ReplyTTLExpired
-		}
-		if netErr.Op == "dial" {
+		case errors.Is(err, syscall.ECONNREFUSED):
+			return ReplyConnectionRefused
+		case errors.Is(err, syscall.ENETUNREACH):
+			return ReplyNetworkUnreachable
+		case netErr.Op == "dial":
 			return ReplyHostUnreachable
 		}
 	}
@@ -665,3 +789,57 @@ func relay(client, target net.Conn) error {
 	}
 	return err2
 }
+
+// relayAccounted relays like relay, reporting the bytes of each direction
+// to the recorder and counting them against the user's quota. Either may be
+// nil. Both connections are closed once the quota is exceeded.
+func relayAccounted(client, target net.Conn, user string, limiter UserLimiter, recorder UsageRecorder) error {
+	var once sync.Once
+	count := func(n int, fromClient bool) error {
+		if n <= 0 {
+			return nil
+		}
+		if recorder != nil {
+			if fromClient {
+				recorder.AddUser(user, uint64(n), 0)
+			} else {
+				recorder.AddUser(user, 0, uint64(n))
+			}
+		}
+		if limiter != nil && !limiter.AddBytes(user, uint64(n)) {
+			once.Do(func() {
+				client.Close()
+				target.Close()
+			})
+			return ErrByteQuotaExceeded
+		}
+		return nil
+	}
+	return relay(
+		&countingConn{Conn: client, count: func(n int) error { return count(n, false) }},
+		&countingConn{Conn: target, count: func(n int) error { return count(n, true) }},
+	)
+}
+
+// countingConn reports bytes written to it. The count callback may fail
+// the write after the data was sent.
+type countingConn struct {
+	net.Conn
+	count func(n int) error
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	if cerr := c.count(n); err == nil {
+		err = cerr
+	}
+	return n, err
+}
+
+// CloseWrite forwards half-close to the wrapped connection.
+func (c *countingConn) CloseWrite() error {
+	if hc, ok := c.Conn.(halfCloser); ok {
+		return hc.CloseWrite()
+	}
+	return nil
+}
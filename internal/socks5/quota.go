@@ -0,0 +1,320 @@
+package socks5
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/postalsys/muti-metroo/internal/logging"
+)
+
+// QuotaStateFile is the name of the usage file in the agent data directory.
+const QuotaStateFile = "socks5_usage.json"
+
+// Errors returned when a user may not open more connections.
+var (
+	ErrCredentialsExpired      = errors.New("credentials expired")
+	ErrByteQuotaExceeded       = errors.New("byte quota exceeded")
+	ErrConnectionQuotaExceeded = errors.New("connection quota exceeded")
+)
+
+// UserLimiter admits authenticated users and accounts their traffic.
+// Implementations must be safe for concurrent use.
+type UserLimiter interface {
+	// Admit is called after a user's credentials were accepted. It returns
+	// an error when the user may not connect, and otherwise counts the
+	// connection.
+	Admit(user string) error
+
+	// AddBytes records n relayed bytes and reports whether the user is
+	// still within quota.
+	AddBytes(user string, n uint64) bool
+}
+
+// UserLimits are the limits of one user. Zero values mean no limit.
+type UserLimits struct {
+	ExpiresAt        time.Time
+	QuotaBytes       uint64
+	QuotaConnections uint64
+}
+
+// UserUsage is the traffic of one user since the last reset.
+type UserUsage struct {
+	Bytes       uint64    `json:"bytes"`
+	Connections uint64    `json:"connections"`
+	LastSeen    time.Time `json:"last_seen,omitempty"`
+	ResetAt     time.Time `json:"reset_at,omitempty"`
+}
+
+// UserQuotaStatus reports the limits and usage of one user.
+type UserQuotaStatus struct {
+	Username         string     `json:"username"`
+	ExpiresAt        *time.Time `json:"expires_at,omitempty"`
+	QuotaBytes       uint64     `json:"quota_bytes,omitempty"`
+	QuotaConnections uint64     `json:"quota_connections,omitempty"`
+	UserUsage
+	// State is "ok", "expired", "bytes_exceeded" or "connections_exceeded".
+	State string `json:"state"`
+}
+
+// QuotaConfig configures user quota tracking.
+type QuotaConfig struct {
+	// Limits maps usernames to their limits. Users without an entry are
+	// tracked but not limited.
+	Limits map[string]UserLimits
+
+	// DataDir holds QuotaStateFile. Empty keeps usage in memory only.
+	DataDir string
+
+	// Logger reports rejected logins and persistence errors.
+	Logger *slog.Logger
+}
+
+// UserQuotas tracks per-user usage against expiry and quota limits and
+// persists it to the data directory. It implements UserLimiter.
+type UserQuotas struct {
+	limits  map[string]UserLimits
+	dataDir string
+	logger  *slog.Logger
+	now     func() time.Time
+
+	mu    sync.Mutex
+	usage map[string]*UserUsage
+	dirty bool
+
+	saveMu sync.Mutex // Serializes writes of the state file
+}
+
+// NewUserQuotas creates a quota tracker and loads saved usage.
+func NewUserQuotas(cfg QuotaConfig) (*UserQuotas, error) {
+	if cfg.Logger == nil {
+		cfg.Logger = logging.NopLogger()
+	}
+	q := &UserQuotas{
+		limits:  cfg.Limits,
+		dataDir: cfg.DataDir,
+		logger:  cfg.Logger,
+		now:     time.Now,
+		usage:   make(map[string]*UserUsage),
+	}
+	if err := q.load(); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// Admit checks the user's expiry and quotas and counts a new connection.
+func (q *UserQuotas) Admit(user string) error {
+	now := q.now()
+	limits := q.limits[user]
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	u := q.usageLocked(user)
+	if err := limits.check(u, now); err != nil {
+		q.logger.Info("SOCKS5 login rejected", "user", user, "reason", err.Error())
+		return err
+	}
+	u.Connections++
+	u.LastSeen = now
+	q.dirty = true
+	return nil
+}
+
+// AddBytes records relayed bytes for the user.
+func (q *UserQuotas) AddBytes(user string, n uint64) bool {
+	limits := q.limits[user]
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	u := q.usageLocked(user)
+	u.Bytes += n
+	q.dirty = true
+	return limits.QuotaBytes == 0 || u.Bytes <= limits.QuotaBytes
+}
+
+// usageLocked returns the usage entry of user, creating it. q.mu must be
+// held.
+func (q *UserQuotas) usageLocked(user string) *UserUsage {
+	u, ok := q.usage[user]
+	if !ok {
+		u = &UserUsage{}
+		q.usage[user] = u
+	}
+	return u
+}
+
+// check returns why a user with usage u may not connect at now, or nil.
+func (l UserLimits) check(u *UserUsage, now time.Time) error {
+	switch {
+	case !l.ExpiresAt.IsZero() && !now.Before(l.ExpiresAt):
+		return ErrCredentialsExpired
+	case l.QuotaBytes > 0 && u.Bytes >= l.QuotaBytes:
+		return ErrByteQuotaExceeded
+	case l.QuotaConnections > 0 && u.Connections >= l.QuotaConnections:
+		return ErrConnectionQuotaExceeded
+	}
+	return nil
+}
+
+// Status returns the limits and usage of every limited or seen user,
+// sorted by username.
+func (q *UserQuotas) Status() []UserQuotaStatus {
+	now := q.now()
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	users := make(map[string]bool, len(q.limits)+len(q.usage))
+	for user := range q.limits {
+		users[user] = true
+	}
+	for user := range q.usage {
+		users[user] = true
+	}
+
+	result := make([]UserQuotaStatus, 0, len(users))
+	for user := range users {
+		limits := q.limits[user]
+		var usage UserUsage
+		if u, ok := q.usage[user]; ok {
+			usage = *u
+		}
+		st := UserQuotaStatus{
+			Username:         user,
+			QuotaBytes:       limits.QuotaBytes,
+			QuotaConnections: limits.QuotaConnections,
+			UserUsage:        usage,
+			State:            "ok",
+		}
+		if !limits.ExpiresAt.IsZero() {
+			expires := limits.ExpiresAt
+			st.ExpiresAt = &expires
+		}
+		switch limits.check(&usage, now) {
+		case ErrCredentialsExpired:
+			st.State = "expired"
+		case ErrByteQuotaExceeded:
+			st.State = "bytes_exceeded"
+		case ErrConnectionQuotaExceeded:
+			st.State = "connections_exceeded"
+		}
+		result = append(result, st)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Username < result[j].Username
+	})
+	return result
+}
+
+// Reset clears the usage of user, or of all users when user is empty, and
+// returns the number of users reset. Expiry is not affected.
+func (q *UserQuotas) Reset(user string) (int, error) {
+	now := q.now()
+
+	q.mu.Lock()
+	reset := 0
+	for name, u := range q.usage {
+		if user != "" && name != user {
+			continue
+		}
+		*u = UserUsage{LastSeen: u.LastSeen, ResetAt: now}
+		reset++
+	}
+	if reset > 0 {
+		q.dirty = true
+	}
+	q.mu.Unlock()
+
+	if user != "" && reset == 0 {
+		if _, ok := q.limits[user]; !ok {
+			return 0, fmt.Errorf("unknown user %q", user)
+		}
+	}
+	q.Save()
+	return reset, nil
+}
+
+// quotaState is the content of QuotaStateFile.
+type quotaState struct {
+	Users map[string]*UserUsage `json:"users"`
+}
+
+// load reads saved usage from the data directory.
+func (q *UserQuotas) load() error {
+	if q.dataDir == "" {
+		return nil
+	}
+	data, err := os.ReadFile(filepath.Join(q.dataDir, QuotaStateFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read SOCKS5 usage: %w", err)
+	}
+
+	var st quotaState
+	if err := json.Unmarshal(data, &st); err != nil {
+		return fmt.Errorf("parse SOCKS5 usage: %w", err)
+	}
+	for user, u := range st.Users {
+		if u != nil {
+			q.usage[user] = u
+		}
+	}
+	return nil
+}
+
+// Save writes usage to the data directory atomically if it changed since
+// the last save. Errors are logged.
+func (q *UserQuotas) Save() {
+	if q.dataDir == "" {
+		return
+	}
+
+	q.saveMu.Lock()
+	defer q.saveMu.Unlock()
+
+	q.mu.Lock()
+	if !q.dirty {
+		q.mu.Unlock()
+		return
+	}
+	st := quotaState{Users: make(map[string]*UserUsage, len(q.usage))}
+	for user, u := range q.usage {
+		copied := *u
+		st.Users[user] = &copied
+	}
+	q.dirty = false
+	q.mu.Unlock()
+
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		q.logger.Error("failed to encode SOCKS5 usage", logging.KeyError, err)
+		return
+	}
+
+	path := filepath.Join(q.dataDir, QuotaStateFile)
+	tmp := path + ".tmp"
+	err = os.WriteFile(tmp, data, 0600)
+	if err == nil {
+		if err = os.Rename(tmp, path); err != nil {
+			os.Remove(tmp)
+		}
+	}
+	if err != nil {
+		q.logger.Error("failed to write SOCKS5 usage", logging.KeyError, err)
+		// Try again on the next save
+		q.mu.Lock()
+		q.dirty = true
+		q.mu.Unlock()
+	}
+}
@@ -0,0 +1,242 @@
+package socks5
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestUserQuotas_Limits(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	q, err := NewUserQuotas(QuotaConfig{Limits: map[string]UserLimits{
+		"temp":  {ExpiresAt: now.Add(time.Hour)},
+		"bytes": {QuotaBytes: 100},
+		"conns": {QuotaConnections: 2},
+	}})
+	if err != nil {
+		t.Fatalf("NewUserQuotas() error = %v", err)
+	}
+	q.now = func() time.Time { return now }
+
+	if err := q.Admit("temp"); err != nil {
+		t.Errorf("Admit(temp) before expiry error = %v", err)
+	}
+	now = now.Add(time.Hour)
+	if err := q.Admit("temp"); !errors.Is(err, ErrCredentialsExpired) {
+		t.Errorf("Admit(temp) at expiry error = %v, want %v", err, ErrCredentialsExpired)
+	}
+
+	if !q.AddBytes("bytes", 100) {
+		t.Error("AddBytes() at quota = false, want true")
+	}
+	if q.AddBytes("bytes", 1) {
+		t.Error("AddBytes() over quota = true, want false")
+	}
+	if err := q.Admit("bytes"); !errors.Is(err, ErrByteQuotaExceeded) {
+		t.Errorf("Admit(bytes) error = %v, want %v", err, ErrByteQuotaExceeded)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := q.Admit("conns"); err != nil {
+			t.Fatalf("Admit(conns) #%d error = %v", i+1, err)
+		}
+	}
+	if err := q.Admit("conns"); !errors.Is(err, ErrConnectionQuotaExceeded) {
+		t.Errorf("Admit(conns) #3 error = %v, want %v", err, ErrConnectionQuotaExceeded)
+	}
+
+	// Users without limits are tracked but never rejected
+	if err := q.Admit("free"); err != nil {
+		t.Errorf("Admit(free) error = %v", err)
+	}
+
+	states := make(map[string]string)
+	for _, st := range q.Status() {
+		states[st.Username] = st.State
+	}
+	want := map[string]string{
+		"temp":  "expired",
+		"bytes": "bytes_exceeded",
+		"conns": "connections_exceeded",
+		"free":  "ok",
+	}
+	for user, state := range want {
+		if states[user] != state {
+			t.Errorf("Status() %s state = %q, want %q", user, states[user], state)
+		}
+	}
+
+	// Reset restores quotas but not expired credentials
+	if n, err := q.Reset(""); err != nil || n != 4 {
+		t.Errorf("Reset(\"\") = %d, %v, want 4, nil", n, err)
+	}
+	if err := q.Admit("bytes"); err != nil {
+		t.Errorf("Admit(bytes) after reset error = %v", err)
+	}
+	if err := q.Admit("conns"); err != nil {
+		t.Errorf("Admit(conns) after reset error = %v", err)
+	}
+	if err := q.Admit("temp"); !errors.Is(err, ErrCredentialsExpired) {
+		t.Errorf("Admit(temp) after reset error = %v, want %v", err, ErrCredentialsExpired)
+	}
+	if _, err := q.Reset("nobody"); err == nil {
+		t.Error("Reset(nobody) should fail for an unknown user")
+	}
+}
+
+func TestUserQuotas_Persistence(t *testing.T) {
+	dir := t.TempDir()
+	limits := map[string]UserLimits{"alice": {QuotaBytes: 1000}}
+
+	q, err := NewUserQuotas(QuotaConfig{Limits: limits, DataDir: dir})
+	if err != nil {
+		t.Fatalf("NewUserQuotas() error = %v", err)
+	}
+	q.Admit("alice")
+	q.AddBytes("alice", 600)
+	q.Save()
+
+	q2, err := NewUserQuotas(QuotaConfig{Limits: limits, DataDir: dir})
+	if err != nil {
+		t.Fatalf("NewUserQuotas() reload error = %v", err)
+	}
+	st := q2.Status()
+	if len(st) != 1 || st[0].Bytes != 600 || st[0].Connections != 1 {
+		t.Fatalf("Status() after reload = %+v, want 600 bytes and 1 connection", st)
+	}
+	if q2.AddBytes("alice", 600) {
+		t.Error("AddBytes() = true, want quota exceeded across restarts")
+	}
+}
+
+func TestUserPassAuthenticator_Limiter(t *testing.T) {
+	q, _ := NewUserQuotas(QuotaConfig{Limits: map[string]UserLimits{
+		"alice": {QuotaConnections: 1},
+	}})
+	auth := &UserPassAuthenticator{
+		Credentials:   StaticCredentials{"alice": "pw"},
+		ExitSelection: map[string]bool{"alice": true},
+		Limiter:       q,
+	}
+
+	if _, err := auth.Authenticate(bytes.NewReader(userPassRequest("alice@agent:abc", "pw")), &bytes.Buffer{}); err != nil {
+		t.Fatalf("Authenticate() error = %v", err)
+	}
+
+	// The exit hint does not create a separate quota
+	var resp bytes.Buffer
+	_, err := auth.Authenticate(bytes.NewReader(userPassRequest("alice", "pw")), &resp)
+	if !errors.Is(err, ErrConnectionQuotaExceeded) {
+		t.Errorf("Authenticate() error = %v, want %v", err, ErrConnectionQuotaExceeded)
+	}
+	if !bytes.Equal(resp.Bytes(), []byte{0x01, AuthStatusFailure}) {
+		t.Errorf("Authenticate() response = %v, want auth failure", resp.Bytes())
+	}
+}
+
+func TestServer_ByteQuotaClosesConnection(t *testing.T) {
+	echo, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Echo server listen error: %v", err)
+	}
+	defer echo.Close()
+	go func() {
+		for {
+			c, err := echo.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				io.Copy(c, c)
+			}()
+		}
+	}()
+
+	q, _ := NewUserQuotas(QuotaConfig{Limits: map[string]UserLimits{
+		"alice": {QuotaBytes: 1000},
+	}})
+	cfg := DefaultServerConfig()
+	cfg.Address = "127.0.0.1:0"
+	cfg.Authenticators = []Authenticator{&UserPassAuthenticator{
+		Credentials: StaticCredentials{"alice": "pw"},
+		Limiter:     q,
+	}}
+	s := NewServer(cfg)
+	s.SetUserLimiter(q)
+	rec := &usageRecorder{}
+	s.SetUsageRecorder(rec)
+	if err := s.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer s.Stop()
+
+	conn, err := net.Dial("tcp", s.Address().String())
+	if err != nil {
+		t.Fatalf("Dial SOCKS5 error: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	conn.Write([]byte{SOCKS5Version, 1, AuthMethodUserPass})
+	conn.Write(userPassRequest("alice", "pw"))
+	resp := make([]byte, 4)
+	if _, err := io.ReadFull(conn, resp); err != nil || resp[3] != AuthStatusSuccess {
+		t.Fatalf("auth response = %v, %v", resp, err)
+	}
+
+	addr := echo.Addr().(*net.TCPAddr)
+	req := []byte{SOCKS5Version, CmdConnect, 0x00, AddrTypeIPv4}
+	req = append(req, addr.IP.To4()...)
+	req = binary.BigEndian.AppendUint16(req, uint16(addr.Port))
+	conn.Write(req)
+	reply := make([]byte, 10)
+	if _, err := io.ReadFull(conn, reply); err != nil || reply[1] != ReplySucceeded {
+		t.Fatalf("CONNECT reply = %v, %v", reply, err)
+	}
+
+	// 400 bytes each way stay within the quota
+	chunk := make([]byte, 400)
+	conn.Write(chunk)
+	if _, err := io.ReadFull(conn, chunk); err != nil {
+		t.Fatalf("echo within quota error = %v", err)
+	}
+
+	// The next round trip crosses it and the connection is closed
+	conn.Write(chunk)
+	if _, err := io.Copy(io.Discard, conn); err != nil {
+		t.Fatalf("read after quota error = %v, want EOF", err)
+	}
+	if err := q.Admit("alice"); !errors.Is(err, ErrByteQuotaExceeded) {
+		t.Errorf("Admit() after relay error = %v, want %v", err, ErrByteQuotaExceeded)
+	}
+
+	// Usage is recorded per direction, including the write that crossed
+	// the quota
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if rec.in["alice"] != 800 || rec.out["alice"] < 400 {
+		t.Errorf("recorded usage in, out = %d, %d, want 800, >= 400", rec.in["alice"], rec.out["alice"])
+	}
+}
+
+// usageRecorder collects recorded user traffic.
+type usageRecorder struct {
+	mu      sync.Mutex
+	in, out map[string]uint64
+}
+
+func (r *usageRecorder) AddUser(user string, in, out uint64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.in == nil {
+		r.in, r.out = make(map[string]uint64), make(map[string]uint64)
+	}
+	r.in[user] += in
+	r.out[user] += out
+}
@@ -2,18 +2,38 @@ package socks5
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
+	"os"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
+
+	"github.com/postalsys/muti-metroo/internal/ipfamily"
 )
 
+// UnixSocketPrefix marks a listen address as a UNIX domain socket path
+// (e.g., "unix:///run/muti/socks.sock").
+const UnixSocketPrefix = "unix://"
+
+// DefaultSocketMode is the permission mode applied to UNIX socket listeners
+// when none is configured.
+const DefaultSocketMode os.FileMode = 0600
+
 // ServerConfig holds server configuration.
 type ServerConfig struct {
-	// Address to listen on (e.g., "127.0.0.1:1080")
+	// Address to listen on (e.g., "127.0.0.1:1080" or "unix:///run/muti/socks.sock")
 	Address string
 
+	// SocketMode sets the file permissions of a UNIX socket listener
+	// (0 = DefaultSocketMode). Ignored for TCP addresses.
+	SocketMode os.FileMode
+
+	// Family selects the IP families of a TCP listener (empty = dual-stack)
+	Family ipfamily.Family
+
 	// MaxConnections limits concurrent connections (0 = unlimited)
 	MaxConnections int
 
@@ -28,6 +48,10 @@ type ServerConfig struct {
 
 	// Dialer for making outbound connections
 	Dialer Dialer
+
+	// SendClientAddress passes the client address to the exit, for exits
+	// that send it on with the PROXY protocol
+	SendClientAddress bool
 }
 
 // DefaultServerConfig returns sensible defaults.
@@ -68,9 +92,12 @@ func NewServer(cfg ServerConfig) *Server {
 		cfg.Authenticators = []Authenticator{&NoAuthAuthenticator{}}
 	}
 
+	handler := NewHandler(cfg.Authenticators, cfg.Dialer)
+	handler.SetSendClientAddress(cfg.SendClientAddress)
+
 	return &Server{
 		cfg:     cfg,
-		handler: NewHandler(cfg.Authenticators, cfg.Dialer),
+		handler: handler,
 		tracker: newConnTracker[net.Conn](),
 		stopCh:  make(chan struct{}),
 	}
@@ -82,9 +109,9 @@ func (s *Server) Start() error {
 		return fmt.Errorf("server already running")
 	}
 
-	listener, err := net.Listen("tcp", s.cfg.Address)
+	listener, err := s.listen()
 	if err != nil {
-		return fmt.Errorf("listen: %w", err)
+		return err
 	}
 
 	// Create a new stopCh for this run (supports restart after Stop)
@@ -100,6 +127,75 @@ func (s *Server) Start() error {
 	return nil
 }
 
+// ParseListenAddress splits a listen address into its network and address.
+// Addresses with the "unix://" prefix map to a UNIX socket path, anything else
+// is treated as a TCP host:port.
+func ParseListenAddress(addr string) (network, address string) {
+	if path, ok := strings.CutPrefix(addr, UnixSocketPrefix); ok {
+		return "unix", path
+	}
+	return "tcp", addr
+}
+
+// listen opens the configured TCP or UNIX socket listener.
+func (s *Server) listen() (net.Listener, error) {
+	network, address := ParseListenAddress(s.cfg.Address)
+	if network != "unix" {
+		listener, err := s.cfg.Family.Listen(address)
+		if err != nil {
+			return nil, fmt.Errorf("listen: %w", err)
+		}
+		return listener, nil
+	}
+
+	if address == "" {
+		return nil, fmt.Errorf("listen: empty unix socket path")
+	}
+	if err := removeStaleSocket(address); err != nil {
+		return nil, err
+	}
+
+	listener, err := net.Listen(network, address)
+	if err != nil {
+		return nil, fmt.Errorf("listen: %w", err)
+	}
+
+	mode := s.cfg.SocketMode
+	if mode == 0 {
+		mode = DefaultSocketMode
+	}
+	if err := os.Chmod(address, mode); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("chmod unix socket: %w", err)
+	}
+	return listener, nil
+}
+
+// removeStaleSocket removes a socket file left behind by a previous run.
+// Regular files are never removed, and neither is a socket that still
+// accepts connections.
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("stat unix socket: %w", err)
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("listen: %s exists and is not a socket", path)
+	}
+
+	if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+		conn.Close()
+		return fmt.Errorf("listen: unix socket %s is in use", path)
+	}
+	if err := os.Remove(path); err != nil {
+		return fmt.Errorf("remove stale unix socket: %w", err)
+	}
+	return nil
+}
+
 // Stop gracefully stops the server.
 func (s *Server) Stop() error {
 	if !s.running.Load() {
@@ -179,8 +275,11 @@ func (s *Server) SetUDPHandler(handler UDPAssociationHandler) {
 	s.handler.SetUDPHandler(handler)
 
 	// Set the UDP bind IP from the server's configured address
-	// This ensures UDP relay sockets bind to the same interface as the TCP listener
-	if host, _, err := net.SplitHostPort(s.cfg.Address); err == nil {
+	// This ensures UDP relay sockets bind to the same interface as the TCP listener.
+	// UNIX socket clients are local, so their relay sockets stay on loopback.
+	if network, _ := ParseListenAddress(s.cfg.Address); network == "unix" {
+		s.handler.SetUDPBindIP(net.IPv4(127, 0, 0, 1))
+	} else if host, _, err := net.SplitHostPort(s.cfg.Address); err == nil {
 		if ip := net.ParseIP(host); ip != nil {
 			s.handler.SetUDPBindIP(ip)
 		}
@@ -193,6 +292,30 @@ func (s *Server) SetICMPHandler(handler ICMPHandler) {
 	s.handler.SetICMPHandler(handler)
 }
 
+// SetUserLimiter sets the limiter that accounts CONNECT traffic of
+// authenticated users.
+func (s *Server) SetUserLimiter(limiter UserLimiter) {
+	s.handler.SetUserLimiter(limiter)
+}
+
+// SetDestinationFilter sets the filter that rejects CONNECT requests to
+// blocked destinations.
+func (s *Server) SetDestinationFilter(filter DestinationFilter) {
+	s.handler.SetDestinationFilter(filter)
+}
+
+// SetClientLimiter sets the limiter that caps connections per source IP
+// and per authenticated user.
+func (s *Server) SetClientLimiter(limiter *ClientLimiter) {
+	s.handler.SetClientLimiter(limiter)
+}
+
+// SetUsageRecorder sets the recorder that accounts CONNECT traffic of
+// authenticated users.
+func (s *Server) SetUsageRecorder(recorder UsageRecorder) {
+	s.handler.SetUsageRecorder(recorder)
+}
+
 // StartWebSocket starts a WebSocket listener for SOCKS5 connections.
 // This allows SOCKS5 protocol to be tunneled over WebSocket transport.
 func (s *Server) StartWebSocket(cfg WebSocketConfig) error {
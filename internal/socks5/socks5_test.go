@@ -2,10 +2,16 @@ package socks5
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
+	"errors"
+	"fmt"
 	"io"
 	"net"
+	"os"
+	"path/filepath"
 	"sync"
+	"syscall"
 	"testing"
 	"time"
 )
@@ -560,6 +566,280 @@ func TestServer_BasicConnect(t *testing.T) {
 	}
 }
 
+func TestSplitExitHint(t *testing.T) {
+	tests := []struct {
+		username string
+		wantUser string
+		wantExit string
+	}{
+		{"alice", "alice", ""},
+		{"alice@agent:abc123", "alice", "abc123"},
+		{"alice@agent:exit-eu-west", "alice", "exit-eu-west"},
+		{"alice@example.com@agent:abc", "alice@example.com", "abc"},
+		{"alice@agent:", "alice@agent:", ""},
+		{"@agent:abc", "@agent:abc", ""},
+	}
+	for _, tt := range tests {
+		user, exit := SplitExitHint(tt.username)
+		if user != tt.wantUser || exit != tt.wantExit {
+			t.Errorf("SplitExitHint(%q) = %q, %q, want %q, %q", tt.username, user, exit, tt.wantUser, tt.wantExit)
+		}
+	}
+}
+
+// testReplyError is a dial error carrying its own SOCKS5 reply.
+type testReplyError byte
+
+func (e testReplyError) Error() string { return "remote failure" }
+
+func (e testReplyError) Reply() byte { return byte(e) }
+
+func TestMapErrorToReply(t *testing.T) {
+	dialErr := func(errno syscall.Errno) error {
+		return &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", errno)}
+	}
+	tests := []struct {
+		name string
+		err  error
+		want byte
+	}{
+		{"exit unavailable", fmt.Errorf("%w: no exit", ErrExitUnavailable), ReplyNetworkUnreachable},
+		{"reply error", fmt.Errorf("dial: %w", testReplyError(ReplyNotAllowed)), ReplyNotAllowed},
+		{"refused", dialErr(syscall.ECONNREFUSED), ReplyConnectionRefused},
+		{"network unreachable", dialErr(syscall.ENETUNREACH), ReplyNetworkUnreachable},
+		{"host unreachable", dialErr(syscall.EHOSTUNREACH), ReplyHostUnreachable},
+		{"timeout", &net.OpError{Op: "dial", Err: context.DeadlineExceeded}, ReplyTTLExpired},
+		{"dns", &net.DNSError{Err: "no such host", Name: "nx.example"}, ReplyHostUnreachable},
+		{"other", errors.New("boom"), ReplyServerFailure},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mapErrorToReply(tt.err); got != tt.want {
+				t.Errorf("mapErrorToReply(%v) = %#x, want %#x", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+// userPassRequest builds an RFC 1929 username/password request.
+func userPassRequest(username, password string) []byte {
+	req := []byte{0x01, byte(len(username))}
+	req = append(req, username...)
+	req = append(req, byte(len(password)))
+	return append(req, password...)
+}
+
+func TestUserPassAuthenticator_ExitSelection(t *testing.T) {
+	auth := &UserPassAuthenticator{
+		Credentials:   StaticCredentials{"alice": "pw", "bob": "pw"},
+		ExitSelection: map[string]bool{"alice": true},
+	}
+
+	user, err := auth.Authenticate(bytes.NewReader(userPassRequest("alice@agent:abc123", "pw")), &bytes.Buffer{})
+	if err != nil {
+		t.Fatalf("Authenticate() error = %v", err)
+	}
+	if user != "alice@agent:abc123" {
+		t.Errorf("Authenticate() user = %q, want full username with hint", user)
+	}
+
+	// bob is not allowed to select exits, so the hint is part of the username
+	if _, err := auth.Authenticate(bytes.NewReader(userPassRequest("bob@agent:abc123", "pw")), &bytes.Buffer{}); err == nil {
+		t.Error("Authenticate() should fail for a user not allowed to select exits")
+	}
+}
+
+// hintDialer records the exit hint and user passed through the dial context.
+type hintDialer struct {
+	exit chan string
+	user chan string
+}
+
+func (d *hintDialer) Dial(network, address string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, address)
+}
+
+func (d *hintDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	d.exit <- ExitHintFromContext(ctx)
+	d.user <- UserFromContext(ctx)
+	return nil, ErrExitUnavailable
+}
+
+func TestServer_ExitHintReachesDialer(t *testing.T) {
+	dialer := &hintDialer{exit: make(chan string, 1), user: make(chan string, 1)}
+	cfg := DefaultServerConfig()
+	cfg.Address = "127.0.0.1:0"
+	cfg.Dialer = dialer
+	cfg.Authenticators = CreateAuthenticators(AuthConfig{
+		Enabled:       true,
+		Required:      true,
+		Users:         map[string]string{"alice": "pw"},
+		ExitSelection: map[string]bool{"alice": true},
+	})
+	s := NewServer(cfg)
+	if err := s.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer s.Stop()
+
+	conn, err := net.Dial("tcp", s.Address().String())
+	if err != nil {
+		t.Fatalf("Dial SOCKS5 error: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	conn.Write([]byte{SOCKS5Version, 1, AuthMethodUserPass})
+	methodResp := make([]byte, 2)
+	io.ReadFull(conn, methodResp)
+	conn.Write(userPassRequest("alice@agent:exit-eu", "pw"))
+	authResp := make([]byte, 2)
+	io.ReadFull(conn, authResp)
+	if authResp[1] != AuthStatusSuccess {
+		t.Fatalf("auth status = %d, want success", authResp[1])
+	}
+
+	conn.Write([]byte{SOCKS5Version, CmdConnect, 0x00, AddrTypeIPv4, 10, 0, 0, 1, 0, 80})
+	reply := make([]byte, 10)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		t.Fatalf("Read reply error: %v", err)
+	}
+	if reply[1] != ReplyNetworkUnreachable {
+		t.Errorf("Reply = %d, want %d", reply[1], ReplyNetworkUnreachable)
+	}
+
+	if got := <-dialer.exit; got != "exit-eu" {
+		t.Errorf("exit hint = %q, want %q", got, "exit-eu")
+	}
+	if got := <-dialer.user; got != "alice" {
+		t.Errorf("user = %q, want %q", got, "alice")
+	}
+}
+
+func TestParseListenAddress(t *testing.T) {
+	tests := []struct {
+		addr        string
+		wantNetwork string
+		wantAddress string
+	}{
+		{"127.0.0.1:1080", "tcp", "127.0.0.1:1080"},
+		{"[::1]:1080", "tcp", "[::1]:1080"},
+		{"unix:///run/muti/socks.sock", "unix", "/run/muti/socks.sock"},
+		{"unix://socks.sock", "unix", "socks.sock"},
+	}
+
+	for _, tt := range tests {
+		network, address := ParseListenAddress(tt.addr)
+		if network != tt.wantNetwork || address != tt.wantAddress {
+			t.Errorf("ParseListenAddress(%q) = (%q, %q), want (%q, %q)",
+				tt.addr, network, address, tt.wantNetwork, tt.wantAddress)
+		}
+	}
+}
+
+// shortTempDir returns a temp directory with a path short enough for a
+// UNIX socket (sun_path is limited to about 104 bytes).
+func shortTempDir(t *testing.T) string {
+	t.Helper()
+	dir, err := os.MkdirTemp("", "socks")
+	if err != nil {
+		t.Fatalf("MkdirTemp error: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+func TestServer_UnixSocket(t *testing.T) {
+	path := filepath.Join(shortTempDir(t), "socks.sock")
+
+	cfg := DefaultServerConfig()
+	cfg.Address = UnixSocketPrefix + path
+	cfg.SocketMode = 0660
+	s := NewServer(cfg)
+	if err := s.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Stat socket error: %v", err)
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		t.Errorf("%s is not a socket", path)
+	}
+	if perm := info.Mode().Perm(); perm != 0660 {
+		t.Errorf("socket mode = %o, want 660", perm)
+	}
+
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatalf("Dial unix socket error: %v", err)
+	}
+	conn.Write([]byte{SOCKS5Version, 1, AuthMethodNoAuth})
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	methodResp := make([]byte, 2)
+	if _, err := io.ReadFull(conn, methodResp); err != nil {
+		t.Fatalf("Read method selection error: %v", err)
+	}
+	if methodResp[1] != AuthMethodNoAuth {
+		t.Errorf("Method = %d, want %d", methodResp[1], AuthMethodNoAuth)
+	}
+	conn.Close()
+
+	// A second server must not take over a socket that is in use
+	if err := NewServer(cfg).Start(); err == nil {
+		t.Error("Start() on an in-use socket should fail")
+	}
+
+	if err := s.Stop(); err != nil {
+		t.Errorf("Stop() error = %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("socket file should be removed on Stop(), stat err = %v", err)
+	}
+}
+
+func TestServer_UnixSocketStale(t *testing.T) {
+	dir := shortTempDir(t)
+
+	// Leave a stale socket file behind, as after a crash
+	stalePath := filepath.Join(dir, "stale.sock")
+	l, err := net.Listen("unix", stalePath)
+	if err != nil {
+		t.Fatalf("Listen error: %v", err)
+	}
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	l.Close()
+
+	cfg := DefaultServerConfig()
+	cfg.Address = UnixSocketPrefix + stalePath
+	s := NewServer(cfg)
+	if err := s.Start(); err != nil {
+		t.Fatalf("Start() over stale socket error = %v", err)
+	}
+	info, err := os.Stat(stalePath)
+	if err != nil {
+		t.Fatalf("Stat socket error: %v", err)
+	}
+	if perm := info.Mode().Perm(); perm != DefaultSocketMode {
+		t.Errorf("socket mode = %o, want %o", perm, DefaultSocketMode)
+	}
+	s.Stop()
+
+	// Regular files are never removed
+	filePath := filepath.Join(dir, "file.sock")
+	if err := os.WriteFile(filePath, []byte("data"), 0600); err != nil {
+		t.Fatalf("WriteFile error: %v", err)
+	}
+	cfg.Address = UnixSocketPrefix + filePath
+	if err := NewServer(cfg).Start(); err == nil {
+		t.Error("Start() over a regular file should fail")
+	}
+	if _, err := os.Stat(filePath); err != nil {
+		t.Errorf("regular file should be left in place: %v", err)
+	}
+}
+
 func TestServer_MaxConnections(t *testing.T) {
 	cfg := DefaultServerConfig()
 	cfg.Address = "127.0.0.1:0"
@@ -75,19 +75,18 @@ type UDPAssociation struct {
 }
 
 // NewUDPAssociation creates a new UDP association.
-// bindIP specifies the IP to bind the UDP relay socket to (nil defaults to 0.0.0.0).
+// bindIP specifies the IP to bind the UDP relay socket to (nil defaults to
+// the wildcard address of the client's family).
 func NewUDPAssociation(tcpConn net.Conn, handler UDPAssociationHandler, bindIP net.IP) (*UDPAssociation, error) {
 	ctx, cancel := context.WithCancel(context.Background())
 
 	// Create UDP relay socket
-	// Use "udp4" to force IPv4 - on macOS "udp" creates a dual-stack IPv6 socket
-	// which reports [::] as the local address and causes issues with SOCKS5 clients
+	// Use "udp4" or "udp6" rather than "udp" - on macOS "udp" creates a
+	// dual-stack IPv6 socket which reports [::] as the local address and
+	// causes issues with SOCKS5 clients
 	// Bind to the same IP as the SOCKS5 TCP listener for security
-	udpBindIP := net.IPv4zero
-	if bindIP != nil && !bindIP.IsUnspecified() {
-		udpBindIP = bindIP
-	}
-	udpConn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: udpBindIP, Port: 0})
+	network, udpBindIP := relaySocketAddr(tcpConn, bindIP)
+	udpConn, err := net.ListenUDP(network, &net.UDPAddr{IP: udpBindIP, Port: 0})
 	if err != nil {
 		cancel()
 		return nil, fmt.Errorf("create UDP socket: %w", err)
@@ -102,6 +101,24 @@ func NewUDPAssociation(tcpConn net.Conn, handler UDPAssociationHandler, bindIP n
 	}, nil
 }
 
+// relaySocketAddr returns the network and IP of a UDP relay socket. The
+// reply to UDP ASSOCIATE names the address the client connected to, so the
+// socket takes the family of the TCP connection's local address: IPv6 for
+// clients that connected over IPv6, IPv4 otherwise (including IPv4 clients
+// of a dual-stack listener, which arrive as IPv4-mapped addresses).
+func relaySocketAddr(tcpConn net.Conn, bindIP net.IP) (string, net.IP) {
+	if bindIP != nil && !bindIP.IsUnspecified() {
+		if bindIP.To4() == nil {
+			return "udp6", bindIP
+		}
+		return "udp4", bindIP
+	}
+	if local, ok := tcpConn.LocalAddr().(*net.TCPAddr); ok && local.IP != nil && local.IP.To4() == nil {
+		return "udp6", net.IPv6unspecified
+	}
+	return "udp4", net.IPv4zero
+}
+
 // LocalAddr returns the local address of the UDP relay socket.
 func (a *UDPAssociation) LocalAddr() *net.UDPAddr {
 	return a.UDPConn.LocalAddr().(*net.UDPAddr)
@@ -183,25 +200,21 @@ func (a *UDPAssociation) ReadLoop() {
 			continue
 		}
 
-		// Update actual client address on first datagram
+		// Ignore datagrams from unexpected addresses, then latch the
+		// client address on the first accepted datagram. Replies always go
+		// to that address, so the client port stays fixed for the
+		// association's lifetime.
 		a.mu.Lock()
+		if !clientMatches(a.ExpectedClientAddr, clientAddr) ||
+			(a.ActualClientAddr != nil && !clientMatches(a.ActualClientAddr, clientAddr)) {
+			a.mu.Unlock()
+			continue
+		}
 		if a.ActualClientAddr == nil {
 			a.ActualClientAddr = clientAddr
 		}
 		a.mu.Unlock()
 
-		// Verify client address if expected address was specified
-		a.mu.RLock()
-		expected := a.ExpectedClientAddr
-		a.mu.RUnlock()
-
-		if expected != nil && expected.IP != nil && !expected.IP.IsUnspecified() {
-			if !clientAddr.IP.Equal(expected.IP) {
-				// Ignore datagrams from unexpected addresses
-				continue
-			}
-		}
-
 		// Parse SOCKS5 UDP header
 		header, payload, err := ParseUDPHeader(buf[:n])
 		if err != nil {
@@ -223,6 +236,18 @@ func (a *UDPAssociation) ReadLoop() {
 	}
 }
 
+// clientMatches reports whether a datagram from addr matches the expected
+// client address. An unspecified IP or a zero port matches any value.
+func clientMatches(expected, addr *net.UDPAddr) bool {
+	if expected == nil {
+		return true
+	}
+	if expected.IP != nil && !expected.IP.IsUnspecified() && !expected.IP.Equal(addr.IP) {
+		return false
+	}
+	return expected.Port == 0 || expected.Port == addr.Port
+}
+
 // WriteToClient sends a datagram back to the SOCKS5 client.
 // The data should be the raw UDP payload (will be wrapped with SOCKS5 header).
 func (a *UDPAssociation) WriteToClient(addrType byte, addr []byte, port uint16, data []byte) error {
@@ -303,6 +303,70 @@ func TestUDPAssociation_Context(t *testing.T) {
 	}
 }
 
+func TestClientMatches(t *testing.T) {
+	addr := &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 5000}
+	tests := []struct {
+		name     string
+		expected *net.UDPAddr
+		want     bool
+	}{
+		{"none", nil, true},
+		{"any", &net.UDPAddr{IP: net.IPv4zero}, true},
+		{"same ip any port", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")}, true},
+		{"same ip and port", &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 5000}, true},
+		{"other port", &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 5001}, false},
+		{"port only", &net.UDPAddr{IP: net.IPv4zero, Port: 5000}, true},
+		{"other port only", &net.UDPAddr{IP: net.IPv4zero, Port: 5001}, false},
+		{"other ip", &net.UDPAddr{IP: net.ParseIP("127.0.0.2")}, false},
+		{"v4-mapped ip", &net.UDPAddr{IP: net.ParseIP("::ffff:127.0.0.1"), Port: 5000}, true},
+		{"ipv6 ip", &net.UDPAddr{IP: net.ParseIP("::1")}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := clientMatches(tt.expected, addr); got != tt.want {
+				t.Errorf("clientMatches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// localAddrConn is a net.Conn that reports a fixed local address.
+type localAddrConn struct {
+	net.Conn
+	local net.Addr
+}
+
+func (c localAddrConn) LocalAddr() net.Addr { return c.local }
+
+func TestRelaySocketAddr(t *testing.T) {
+	tcp := func(ip string) net.Conn {
+		return localAddrConn{local: &net.TCPAddr{IP: net.ParseIP(ip), Port: 1080}}
+	}
+	tests := []struct {
+		name        string
+		conn        net.Conn
+		bindIP      net.IP
+		wantNetwork string
+		wantIP      string
+	}{
+		{"ipv4 client", tcp("127.0.0.1"), nil, "udp4", "0.0.0.0"},
+		{"v4-mapped client", tcp("::ffff:192.0.2.1"), nil, "udp4", "0.0.0.0"},
+		{"ipv6 client", tcp("2001:db8::1"), nil, "udp6", "::"},
+		{"ipv6 client on wildcard", tcp("2001:db8::1"), net.IPv6unspecified, "udp6", "::"},
+		{"ipv4 bind", tcp("192.0.2.1"), net.ParseIP("192.0.2.1"), "udp4", "192.0.2.1"},
+		{"ipv6 bind", tcp("::1"), net.IPv6loopback, "udp6", "::1"},
+		{"unix socket", localAddrConn{local: &net.UnixAddr{Name: "/tmp/s", Net: "unix"}}, net.IPv4(127, 0, 0, 1), "udp4", "127.0.0.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			network, ip := relaySocketAddr(tt.conn, tt.bindIP)
+			if network != tt.wantNetwork || ip.String() != tt.wantIP {
+				t.Errorf("relaySocketAddr() = %s %s, want %s %s", network, ip, tt.wantNetwork, tt.wantIP)
+			}
+		})
+	}
+}
+
 func TestHandler_UDPAssociate_Disabled(t *testing.T) {
 	h := NewHandler(nil, nil)
 	// Don't set UDP handler - should reject UDP ASSOCIATE
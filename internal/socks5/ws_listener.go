@@ -12,6 +12,7 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/postalsys/muti-metroo/internal/ipfamily"
 	"nhooyr.io/websocket"
 )
 
@@ -20,6 +21,9 @@ type WebSocketConfig struct {
 	// Address to listen on (e.g., "0.0.0.0:8443" or "127.0.0.1:8081")
 	Address string
 
+	// Family selects the IP families of the listener (empty = dual-stack)
+	Family ipfamily.Family
+
 	// Path for WebSocket upgrade (default: "/socks5")
 	Path string
 
@@ -153,7 +157,7 @@ func (l *WebSocketListener) Start() error {
 	}
 
 	// Start server
-	ln, err := net.Listen("tcp", l.cfg.Address)
+	ln, err := l.cfg.Family.Listen(l.cfg.Address)
 	if err != nil {
 		return fmt.Errorf("listen: %w", err)
 	}
@@ -253,6 +257,9 @@ func (l *WebSocketListener) handleWebSocket(w http.ResponseWriter, r *http.Reque
 
 	// Wrap as net.Conn
 	wc := newWsConn(conn)
+	if addr, err := net.ResolveTCPAddr("tcp", r.RemoteAddr); err == nil {
+		wc.remoteAddr = addr
+	}
 
 	l.tracker.add(wc)
 	l.wg.Add(1)
@@ -274,6 +281,7 @@ type wsConn struct {
 	conn       *websocket.Conn
 	baseCtx    context.Context
 	baseCancel context.CancelFunc
+	remoteAddr net.Addr // HTTP client address (nil if unknown)
 
 	mu             sync.RWMutex
 	deadline       time.Time
@@ -386,11 +394,11 @@ func (c *wsConn) LocalAddr() net.Addr {
 	return nil
 }
 
-// RemoteAddr returns nil as the underlying WebSocket library does not expose
-// the remote TCP address. Code that uses RemoteAddr() should handle nil gracefully.
-// For logging purposes, the HTTP request's RemoteAddr can be used at accept time.
+// RemoteAddr returns the address of the HTTP client that opened the
+// WebSocket, taken from the upgrade request, or nil if it is unknown. Behind
+// a reverse proxy this is the proxy's address.
 func (c *wsConn) RemoteAddr() net.Addr {
-	return nil
+	return c.remoteAddr
 }
 
 // SetDeadline sets both read and write deadlines.
@@ -64,11 +64,18 @@ type Stream struct {
 	remoteFinCh    chan struct{} // Signals remote half-close to readers
 
 	// For request/response tracking
-	DestAddr  string
-	DestPort  uint16
-	CreatedAt time.Time
-	BytesSent atomic.Uint64
-	BytesRecv atomic.Uint64
+	DestAddr   string
+	DestPort   uint16
+	User       string // Authenticated SOCKS5 user that opened the stream, if any
+	CreatedAt  time.Time
+	BytesSent  atomic.Uint64
+	BytesRecv  atomic.Uint64
+	FramesSent atomic.Uint64
+	FramesRecv atomic.Uint64
+
+	lastActivity atomic.Int64 // UnixNano of last data sent or received
+	openedAt     atomic.Int64 // UnixNano when the stream opened
+	firstByteAt  atomic.Int64 // UnixNano of the first data received
 
 	// Callbacks
 	onData  func(*Stream, []byte)
@@ -77,6 +84,8 @@ type Stream struct {
 
 	// End-to-end encryption session key
 	sessionKey *crypto.SessionKey
+
+	pathMaxPayload atomic.Int32 // Payload limit of the path, from STREAM_OPEN_ACK (0 if unknown)
 }
 
 // NewStream creates a new stream.
@@ -93,6 +102,7 @@ func NewStream(id uint64, localID, remoteID identity.AgentID, requestID uint64)
 		CreatedAt:   time.Now(),
 	}
 	s.state.Store(int32(StateOpening))
+	s.lastActivity.Store(s.CreatedAt.UnixNano())
 	return s
 }
 
@@ -108,9 +118,31 @@ func (s *Stream) SetState(state StreamState) {
 
 // Open marks the stream as open.
 func (s *Stream) Open() {
+	s.openedAt.CompareAndSwap(0, time.Now().UnixNano())
 	s.SetState(StateOpen)
 }
 
+// ConnectLatency returns the time from the open request until the stream
+// opened, or 0 while it is still opening.
+func (s *Stream) ConnectLatency() time.Duration {
+	return sinceCreated(s, s.openedAt.Load())
+}
+
+// FirstByteLatency returns the time from the open request until the first
+// data arrived, or 0 if none has.
+func (s *Stream) FirstByteLatency() time.Duration {
+	return sinceCreated(s, s.firstByteAt.Load())
+}
+
+// sinceCreated returns the time from CreatedAt to the UnixNano timestamp at,
+// or 0 if at is unset.
+func sinceCreated(s *Stream, at int64) time.Duration {
+	if at == 0 {
+		return 0
+	}
+	return time.Unix(0, at).Sub(s.CreatedAt)
+}
+
 // IsOpen returns true if the stream is open for reading and writing.
 func (s *Stream) IsOpen() bool {
 	state := s.State()
@@ -184,13 +216,30 @@ func (s *Stream) PushData(data []byte) error {
 
 	select {
 	case s.readBuffer <- data:
+		now := time.Now().UnixNano()
 		s.BytesRecv.Add(uint64(len(data)))
+		s.FramesRecv.Add(1)
+		s.firstByteAt.CompareAndSwap(0, now)
+		s.lastActivity.Store(now)
 		return nil
 	case <-s.closed:
 		return io.EOF
 	}
 }
 
+// RecordSent accounts one frame of n bytes written to the stream by the
+// owner.
+func (s *Stream) RecordSent(n int) {
+	s.BytesSent.Add(uint64(n))
+	s.FramesSent.Add(1)
+	s.lastActivity.Store(time.Now().UnixNano())
+}
+
+// LastActivity returns the time data was last sent or received on the stream.
+func (s *Stream) LastActivity() time.Time {
+	return time.Unix(0, s.lastActivity.Load())
+}
+
 // Read reads data from the stream.
 // Prioritizes reading buffered data before returning EOF on close or remote half-close.
 func (s *Stream) Read(ctx context.Context) ([]byte, error) {
@@ -282,6 +331,12 @@ func (s *Stream) SetSessionKey(key *crypto.SessionKey) {
 	s.sessionKey = key
 }
 
+// PathMaxPayload returns the smallest frame payload limit of the links to
+// the far end of the stream, or 0 when the far end did not report it.
+func (s *Stream) PathMaxPayload() int {
+	return int(s.pathMaxPayload.Load())
+}
+
 // GetSessionKey returns the encryption session key for this stream.
 func (s *Stream) GetSessionKey() *crypto.SessionKey {
 	s.mu.Lock()
@@ -339,6 +394,9 @@ type StreamOpenResult struct {
 
 	// RemoteEphemeral is the exit node's ephemeral public key for E2E encryption
 	RemoteEphemeral [crypto.KeySize]byte
+
+	// E2EFlags are the protocol.E2EFlag* bits of the acknowledgment
+	E2EFlags uint8
 }
 
 // Manager manages streams for a peer connection.
@@ -378,6 +436,7 @@ func (m *Manager) SetCallbacks(onOpen func(*Stream), onClose func(*Stream, error
 type OpenStreamPending struct {
 	ResultCh  <-chan *StreamOpenResult
 	RequestID uint64
+	Stream    *Stream // Not listed by GetAllStreams until the open succeeds
 }
 
 // OpenStream initiates opening a stream and returns the pending info.
@@ -394,7 +453,7 @@ func (m *Manager) OpenStream(streamID uint64, remoteID identity.AgentID, destAdd
 	if len(m.streams) >= m.cfg.MaxStreamsTotal {
 		m.mu.Unlock()
 		resultCh <- &StreamOpenResult{Error: fmt.Errorf("max streams limit reached")}
-		return &OpenStreamPending{ResultCh: resultCh, RequestID: requestID}
+		return &OpenStreamPending{ResultCh: resultCh, RequestID: requestID, Stream: stream}
 	}
 
 	timer := time.AfterFunc(timeout, func() {
@@ -409,7 +468,7 @@ func (m *Manager) OpenStream(streamID uint64, remoteID identity.AgentID, destAdd
 	}
 	m.mu.Unlock()
 
-	return &OpenStreamPending{ResultCh: resultCh, RequestID: requestID}
+	return &OpenStreamPending{ResultCh: resultCh, RequestID: requestID, Stream: stream}
 }
 
 // handleRequestTimeout handles a timed-out stream open request.
@@ -433,7 +492,7 @@ func (m *Manager) handleRequestTimeout(requestID uint64) {
 }
 
 // HandleStreamOpenAck processes a STREAM_OPEN_ACK frame.
-func (m *Manager) HandleStreamOpenAck(requestID uint64, boundAddr net.IP, boundPort uint16, remoteEphemeral [crypto.KeySize]byte) (*Stream, error) {
+func (m *Manager) HandleStreamOpenAck(requestID uint64, boundAddr net.IP, boundPort uint16, remoteEphemeral [crypto.KeySize]byte, e2eFlags uint8, pathMaxPayload int) (*Stream, error) {
 	m.mu.Lock()
 	pending, ok := m.pendingRequests[requestID]
 	if !ok {
@@ -445,6 +504,7 @@ func (m *Manager) HandleStreamOpenAck(requestID uint64, boundAddr net.IP, boundP
 	crypto.ZeroKey(&pending.EphemeralPrivate)
 
 	stream := pending.Stream
+	stream.pathMaxPayload.Store(int32(pathMaxPayload))
 	stream.Open()
 	m.streams[stream.ID] = stream
 	m.mu.Unlock()
@@ -455,6 +515,7 @@ func (m *Manager) HandleStreamOpenAck(requestID uint64, boundAddr net.IP, boundP
 		BoundIP:         boundAddr,
 		BoundPort:       boundPort,
 		RemoteEphemeral: remoteEphemeral,
+		E2EFlags:        e2eFlags,
 	}
 
 	// Notify callback
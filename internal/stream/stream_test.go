@@ -169,6 +169,64 @@ func TestStream_PushAndRead(t *testing.T) {
 	}
 }
 
+func TestStream_RecordSentAndActivity(t *testing.T) {
+	localID, _ := identity.NewAgentID()
+	remoteID, _ := identity.NewAgentID()
+
+	s := NewStream(1, localID, remoteID, 100)
+	s.Open()
+
+	created := s.LastActivity()
+	if !created.Equal(s.CreatedAt) {
+		t.Errorf("LastActivity() = %v, want CreatedAt %v", created, s.CreatedAt)
+	}
+
+	time.Sleep(5 * time.Millisecond)
+	s.RecordSent(42)
+
+	if s.BytesSent.Load() != 42 {
+		t.Errorf("BytesSent = %d, want 42", s.BytesSent.Load())
+	}
+	if !s.LastActivity().After(created) {
+		t.Error("LastActivity() should advance after RecordSent")
+	}
+}
+
+func TestStream_Statistics(t *testing.T) {
+	localID, _ := identity.NewAgentID()
+	remoteID, _ := identity.NewAgentID()
+	m := NewManager(DefaultManagerConfig(), localID)
+
+	pending := m.OpenStream(1, remoteID, "10.0.0.1", 80, time.Second)
+	s := pending.Stream
+	if s.ConnectLatency() != 0 || s.FirstByteLatency() != 0 {
+		t.Error("latencies should be 0 before the stream opens")
+	}
+
+	time.Sleep(5 * time.Millisecond)
+	var remoteEphemeral [crypto.KeySize]byte
+	if _, err := m.HandleStreamOpenAck(pending.RequestID, nil, 0, remoteEphemeral, 0, 0); err != nil {
+		t.Fatalf("HandleStreamOpenAck() error = %v", err)
+	}
+	connect := s.ConnectLatency()
+	if connect < 5*time.Millisecond {
+		t.Errorf("ConnectLatency() = %v, want at least 5ms", connect)
+	}
+
+	s.RecordSent(10)
+	s.RecordSent(20)
+	time.Sleep(5 * time.Millisecond)
+	m.HandleStreamData(1, 0, []byte("first"))
+	m.HandleStreamData(1, 0, []byte("second"))
+
+	if s.FramesSent.Load() != 2 || s.FramesRecv.Load() != 2 {
+		t.Errorf("frames sent/recv = %d/%d, want 2/2", s.FramesSent.Load(), s.FramesRecv.Load())
+	}
+	if first := s.FirstByteLatency(); first < connect+5*time.Millisecond {
+		t.Errorf("FirstByteLatency() = %v, want at least %v", first, connect+5*time.Millisecond)
+	}
+}
+
 func TestStream_ReadTimeout(t *testing.T) {
 	localID, _ := identity.NewAgentID()
 	remoteID, _ := identity.NewAgentID()
@@ -501,7 +559,7 @@ func TestManager_OpenStream_Success(t *testing.T) {
 	go func() {
 		time.Sleep(10 * time.Millisecond)
 		var remoteEphemeral [crypto.KeySize]byte
-		m.HandleStreamOpenAck(pending.RequestID, nil, 0, remoteEphemeral)
+		m.HandleStreamOpenAck(pending.RequestID, nil, 0, remoteEphemeral, 0, 0)
 	}()
 
 	result := <-pending.ResultCh
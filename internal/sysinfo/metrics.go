@@ -0,0 +1,214 @@
+package sysinfo
+
+import (
+	"os"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Sections of Metrics that a platform may not support, listed in
+// Metrics.Unsupported.
+const (
+	SectionCPU        = "cpu"
+	SectionMemory     = "memory"
+	SectionDisks      = "disks"
+	SectionInterfaces = "interfaces"
+	SectionFiles      = "files"
+)
+
+const (
+	// maxSampleAge is how old the previous sample may be for CPU usage and
+	// interface rates to be computed against it. Older samples are
+	// replaced by a short fresh measurement.
+	maxSampleAge = time.Minute
+
+	// freshSampleInterval is the measurement window used when there is no
+	// recent previous sample.
+	freshSampleInterval = 250 * time.Millisecond
+)
+
+// Metrics is a reading of the host's resource usage. CPU usage and
+// interface rates cover the time since the previous reading.
+type Metrics struct {
+	Time     time.Time `json:"time"`
+	Interval float64   `json:"interval_seconds"` // Window of CPU usage and rates
+
+	CPU        CPUMetrics         `json:"cpu"`
+	Memory     MemoryMetrics      `json:"memory"`
+	Disks      []DiskMetrics      `json:"disks,omitempty"`
+	Interfaces []InterfaceMetrics `json:"interfaces,omitempty"`
+	Files      FileMetrics        `json:"files"`
+	Process    ProcessMetrics     `json:"process"`
+
+	// Unsupported lists the sections not available on this platform.
+	Unsupported []string `json:"unsupported,omitempty"`
+}
+
+// CPUMetrics is the CPU usage of the host.
+type CPUMetrics struct {
+	Cores   int       `json:"cores"`
+	Percent float64   `json:"percent"`            // Busy share of all cores, 0-100
+	Load    []float64 `json:"load_avg,omitempty"` // 1, 5 and 15 minute load average (Unix)
+}
+
+// MemoryMetrics is the memory usage of the host in bytes.
+type MemoryMetrics struct {
+	Total     uint64 `json:"total"`
+	Available uint64 `json:"available"`
+	Used      uint64 `json:"used"`
+	SwapTotal uint64 `json:"swap_total,omitempty"`
+	SwapUsed  uint64 `json:"swap_used,omitempty"`
+}
+
+// DiskMetrics is the usage of one mounted filesystem in bytes.
+type DiskMetrics struct {
+	Path  string `json:"path"`
+	Total uint64 `json:"total"`
+	Free  uint64 `json:"free"` // Available to unprivileged users
+	Used  uint64 `json:"used"`
+}
+
+// InterfaceMetrics holds the counters of a network interface since boot,
+// and its rates over the sample interval.
+type InterfaceMetrics struct {
+	Name      string  `json:"name"`
+	RxBytes   uint64  `json:"rx_bytes"`
+	TxBytes   uint64  `json:"tx_bytes"`
+	RxPackets uint64  `json:"rx_packets"`
+	TxPackets uint64  `json:"tx_packets"`
+	RxErrors  uint64  `json:"rx_errors"`
+	TxErrors  uint64  `json:"tx_errors"`
+	RxRate    float64 `json:"rx_bytes_per_sec"`
+	TxRate    float64 `json:"tx_bytes_per_sec"`
+}
+
+// FileMetrics counts open file descriptors (handles on Windows).
+type FileMetrics struct {
+	ProcessOpen  int    `json:"process_open"`
+	ProcessLimit uint64 `json:"process_limit,omitempty"`
+	SystemOpen   uint64 `json:"system_open,omitempty"`
+	SystemLimit  uint64 `json:"system_limit,omitempty"`
+}
+
+// ProcessMetrics describes the agent process.
+type ProcessMetrics struct {
+	PID        int    `json:"pid"`
+	Goroutines int    `json:"goroutines"`
+	HeapBytes  uint64 `json:"heap_bytes"`
+	SysBytes   uint64 `json:"sys_bytes"` // Memory obtained from the OS by the Go runtime
+}
+
+// cpuTimes are cumulative CPU times in platform units.
+type cpuTimes struct {
+	busy, total uint64
+}
+
+// ifCounters are the cumulative counters of one interface.
+type ifCounters struct {
+	rxBytes, txBytes, rxPackets, txPackets, rxErrors, txErrors uint64
+}
+
+// rawSample holds the cumulative counters that rates are computed from.
+type rawSample struct {
+	at  time.Time
+	cpu cpuTimes
+	ifs map[string]ifCounters
+}
+
+// Sampler takes Metrics readings, computing CPU usage and interface rates
+// against the previous reading. It is safe for concurrent use.
+type Sampler struct {
+	mu   sync.Mutex
+	prev *rawSample
+}
+
+// NewSampler creates a metrics sampler.
+func NewSampler() *Sampler {
+	return &Sampler{}
+}
+
+// Sample reads the current metrics. Without a reading in the last minute it
+// measures for a quarter second first.
+func (s *Sampler) Sample() *Metrics {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.prev == nil || time.Since(s.prev.at) > maxSampleAge {
+		s.prev = takeRawSample()
+		time.Sleep(freshSampleInterval)
+	}
+	cur := takeRawSample()
+	prev := s.prev
+	s.prev = cur
+
+	m := &Metrics{
+		Time:     cur.at.UTC(),
+		Interval: cur.at.Sub(prev.at).Seconds(),
+	}
+	m.CPU.Cores = runtime.NumCPU()
+	if cur.cpu.total > prev.cpu.total && cur.cpu.busy >= prev.cpu.busy {
+		m.CPU.Percent = 100 * float64(cur.cpu.busy-prev.cpu.busy) / float64(cur.cpu.total-prev.cpu.total)
+	}
+
+	m.CPU.Load = readLoadAvg()
+
+	var ok bool
+	if cur.cpu.total == 0 {
+		m.Unsupported = append(m.Unsupported, SectionCPU)
+	}
+	if m.Memory, ok = readMemory(); !ok {
+		m.Unsupported = append(m.Unsupported, SectionMemory)
+	}
+	if m.Disks, ok = readDisks(); !ok {
+		m.Unsupported = append(m.Unsupported, SectionDisks)
+	}
+	if cur.ifs == nil {
+		m.Unsupported = append(m.Unsupported, SectionInterfaces)
+	}
+	if m.Files, ok = readFiles(); !ok {
+		m.Unsupported = append(m.Unsupported, SectionFiles)
+	}
+
+	for name, c := range cur.ifs {
+		im := InterfaceMetrics{
+			Name:      name,
+			RxBytes:   c.rxBytes,
+			TxBytes:   c.txBytes,
+			RxPackets: c.rxPackets,
+			TxPackets: c.txPackets,
+			RxErrors:  c.rxErrors,
+			TxErrors:  c.txErrors,
+		}
+		if p, ok := prev.ifs[name]; ok && m.Interval > 0 {
+			if c.rxBytes >= p.rxBytes {
+				im.RxRate = float64(c.rxBytes-p.rxBytes) / m.Interval
+			}
+			if c.txBytes >= p.txBytes {
+				im.TxRate = float64(c.txBytes-p.txBytes) / m.Interval
+			}
+		}
+		m.Interfaces = append(m.Interfaces, im)
+	}
+	sort.Slice(m.Interfaces, func(i, j int) bool { return m.Interfaces[i].Name < m.Interfaces[j].Name })
+
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	m.Process = ProcessMetrics{
+		PID:        os.Getpid(),
+		Goroutines: runtime.NumGoroutine(),
+		HeapBytes:  ms.HeapAlloc,
+		SysBytes:   ms.Sys,
+	}
+	return m
+}
+
+// takeRawSample reads the cumulative counters. CPU times are zero and ifs
+// is nil where the platform does not support them.
+func takeRawSample() *rawSample {
+	s := &rawSample{at: time.Now()}
+	s.cpu, _ = readCPUTimes()
+	s.ifs, _ = readInterfaces()
+	return s
+}
@@ -0,0 +1,91 @@
+package sysinfo
+
+import (
+	"encoding/binary"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// readCPUTimes is not supported on macOS.
+func readCPUTimes() (cpuTimes, bool) {
+	return cpuTimes{}, false
+}
+
+// readLoadAvg reads the vm.loadavg sysctl, a struct loadavg of three
+// fixed-point values and their scale.
+func readLoadAvg() []float64 {
+	raw, err := unix.SysctlRaw("vm.loadavg")
+	if err != nil || len(raw) < 24 {
+		return nil
+	}
+	scale := float64(binary.LittleEndian.Uint64(raw[16:24]))
+	if scale == 0 {
+		return nil
+	}
+	load := make([]float64, 3)
+	for i := range load {
+		load[i] = float64(binary.LittleEndian.Uint32(raw[i*4:])) / scale
+	}
+	return load
+}
+
+// readMemory reads the physical memory size and the free page count.
+// Inactive pages the system could reclaim are not counted as available.
+func readMemory() (MemoryMetrics, bool) {
+	total, err := unix.SysctlUint64("hw.memsize")
+	if err != nil {
+		return MemoryMetrics{}, false
+	}
+	m := MemoryMetrics{Total: total}
+	free, err1 := unix.SysctlUint32("vm.page_free_count")
+	pageSize, err2 := unix.SysctlUint32("hw.pagesize")
+	if err1 == nil && err2 == nil {
+		m.Available = min(uint64(free)*uint64(pageSize), total)
+	}
+	m.Used = m.Total - m.Available
+	return m, true
+}
+
+// readDisks returns the usage of the root filesystem.
+func readDisks() ([]DiskMetrics, bool) {
+	var st unix.Statfs_t
+	if err := unix.Statfs("/", &st); err != nil {
+		return nil, false
+	}
+	bsize := uint64(st.Bsize)
+	d := DiskMetrics{
+		Path:  "/",
+		Total: st.Blocks * bsize,
+		Free:  st.Bavail * bsize,
+	}
+	d.Used = d.Total - min(st.Bfree*bsize, d.Total)
+	return []DiskMetrics{d}, true
+}
+
+// readInterfaces is not supported on macOS.
+func readInterfaces() (map[string]ifCounters, bool) {
+	return nil, false
+}
+
+// readFiles counts the entries of /dev/fd and reads the system-wide
+// counts from the kern.num_files and kern.maxfiles sysctls.
+func readFiles() (FileMetrics, bool) {
+	entries, err := os.ReadDir("/dev/fd")
+	if err != nil {
+		return FileMetrics{}, false
+	}
+	fm := FileMetrics{ProcessOpen: len(entries) - 1} // Without the one reading the directory
+
+	var rl unix.Rlimit
+	if unix.Getrlimit(unix.RLIMIT_NOFILE, &rl) == nil && rl.Cur != unix.RLIM_INFINITY {
+		fm.ProcessLimit = rl.Cur
+	}
+	if n, err := unix.SysctlUint32("kern.num_files"); err == nil {
+		fm.SystemOpen = uint64(n)
+	}
+	if n, err := unix.SysctlUint32("kern.maxfiles"); err == nil {
+		fm.SystemLimit = uint64(n)
+	}
+	return fm, true
+}
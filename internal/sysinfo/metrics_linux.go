@@ -0,0 +1,238 @@
+package sysinfo
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// readCPUTimes reads the aggregate CPU line of /proc/stat.
+func readCPUTimes() (cpuTimes, bool) {
+	f, err := os.Open("/proc/stat")
+	if err != nil {
+		return cpuTimes{}, false
+	}
+	defer f.Close()
+	return parseProcStat(f)
+}
+
+// parseProcStat parses the "cpu" line of /proc/stat. Idle and iowait
+// count as idle time; guest time is already included in user time.
+func parseProcStat(r io.Reader) (cpuTimes, bool) {
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 5 || fields[0] != "cpu" {
+			continue
+		}
+		var t cpuTimes
+		for i, f := range fields[1:] {
+			if i >= 8 { // guest and guest_nice
+				break
+			}
+			v, err := strconv.ParseUint(f, 10, 64)
+			if err != nil {
+				return cpuTimes{}, false
+			}
+			t.total += v
+			if i != 3 && i != 4 { // idle, iowait
+				t.busy += v
+			}
+		}
+		return t, true
+	}
+	return cpuTimes{}, false
+}
+
+// readLoadAvg reads /proc/loadavg.
+func readLoadAvg() []float64 {
+	data, err := os.ReadFile("/proc/loadavg")
+	if err != nil {
+		return nil
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) < 3 {
+		return nil
+	}
+	load := make([]float64, 3)
+	for i := range load {
+		if load[i], err = strconv.ParseFloat(fields[i], 64); err != nil {
+			return nil
+		}
+	}
+	return load
+}
+
+// readMemory reads /proc/meminfo.
+func readMemory() (MemoryMetrics, bool) {
+	f, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return MemoryMetrics{}, false
+	}
+	defer f.Close()
+	return parseMeminfo(f)
+}
+
+// parseMeminfo parses /proc/meminfo, whose values are in KiB.
+func parseMeminfo(r io.Reader) (MemoryMetrics, bool) {
+	values := make(map[string]uint64)
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		key, rest, ok := strings.Cut(sc.Text(), ":")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(rest)
+		if len(fields) == 0 {
+			continue
+		}
+		if v, err := strconv.ParseUint(fields[0], 10, 64); err == nil {
+			values[key] = v * 1024
+		}
+	}
+	total, ok := values["MemTotal"]
+	if !ok {
+		return MemoryMetrics{}, false
+	}
+	avail, ok := values["MemAvailable"]
+	if !ok {
+		avail = values["MemFree"] + values["Buffers"] + values["Cached"]
+	}
+	m := MemoryMetrics{
+		Total:     total,
+		Available: min(avail, total),
+		SwapTotal: values["SwapTotal"],
+	}
+	m.Used = m.Total - m.Available
+	if free := values["SwapFree"]; free <= m.SwapTotal {
+		m.SwapUsed = m.SwapTotal - free
+	}
+	return m, true
+}
+
+// readDisks returns the usage of the mounted block device filesystems, one
+// entry per device, and always of the root filesystem.
+func readDisks() ([]DiskMetrics, bool) {
+	data, _ := os.ReadFile("/proc/self/mounts")
+	paths := []string{"/"}
+	seen := map[string]bool{}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || !strings.HasPrefix(fields[0], "/dev/") || seen[fields[0]] {
+			continue
+		}
+		seen[fields[0]] = true
+		if mp := unescapeMountPath(fields[1]); mp != "/" {
+			paths = append(paths, mp)
+		}
+	}
+
+	var disks []DiskMetrics
+	for _, p := range paths {
+		var st unix.Statfs_t
+		if err := unix.Statfs(p, &st); err != nil || st.Blocks == 0 {
+			continue
+		}
+		bsize := uint64(st.Bsize)
+		d := DiskMetrics{
+			Path:  p,
+			Total: st.Blocks * bsize,
+			Free:  st.Bavail * bsize,
+		}
+		d.Used = d.Total - min(st.Bfree*bsize, d.Total)
+		disks = append(disks, d)
+	}
+	return disks, len(disks) > 0
+}
+
+// unescapeMountPath decodes the octal escapes (such as \040 for a space)
+// of a path in /proc/self/mounts.
+func unescapeMountPath(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			if v, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(v))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// readInterfaces reads the interface counters of /proc/net/dev.
+func readInterfaces() (map[string]ifCounters, bool) {
+	data, err := os.ReadFile("/proc/net/dev")
+	if err != nil {
+		return nil, false
+	}
+	return parseNetDev(bytes.NewReader(data))
+}
+
+// parseNetDev parses /proc/net/dev: after two header lines, each line is
+// "name: rx_bytes rx_packets rx_errs ... (8 fields) tx_bytes tx_packets
+// tx_errs ...".
+func parseNetDev(r io.Reader) (map[string]ifCounters, bool) {
+	ifs := make(map[string]ifCounters)
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		name, rest, ok := strings.Cut(sc.Text(), ":")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(rest)
+		if len(fields) < 11 {
+			continue
+		}
+		var v [11]uint64
+		for i := range v {
+			v[i], _ = strconv.ParseUint(fields[i], 10, 64)
+		}
+		ifs[strings.TrimSpace(name)] = ifCounters{
+			rxBytes:   v[0],
+			rxPackets: v[1],
+			rxErrors:  v[2],
+			txBytes:   v[8],
+			txPackets: v[9],
+			txErrors:  v[10],
+		}
+	}
+	return ifs, true
+}
+
+// readFiles counts the entries of /proc/self/fd and reads the system-wide
+// counts from /proc/sys/fs/file-nr.
+func readFiles() (FileMetrics, bool) {
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		return FileMetrics{}, false
+	}
+	fm := FileMetrics{ProcessOpen: len(entries) - 1} // Without the one reading the directory
+
+	var rl unix.Rlimit
+	if unix.Getrlimit(unix.RLIMIT_NOFILE, &rl) == nil && rl.Cur != unix.RLIM_INFINITY {
+		fm.ProcessLimit = rl.Cur
+	}
+	if data, err := os.ReadFile("/proc/sys/fs/file-nr"); err == nil {
+		// allocated, unused (always 0 since Linux 2.6), maximum
+		if fields := strings.Fields(string(data)); len(fields) == 3 {
+			allocated, _ := strconv.ParseUint(fields[0], 10, 64)
+			unused, _ := strconv.ParseUint(fields[1], 10, 64)
+			if unused <= allocated {
+				fm.SystemOpen = allocated - unused
+			}
+			fm.SystemLimit, _ = strconv.ParseUint(fields[2], 10, 64)
+		}
+	}
+	return fm, true
+}
@@ -0,0 +1,105 @@
+package sysinfo
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseProcStat(t *testing.T) {
+	input := "cpu  100 5 50 800 20 3 2 1 7 0\ncpu0 50 2 25 400 10 1 1 0 3 0\nintr 12345\n"
+	got, ok := parseProcStat(strings.NewReader(input))
+	if !ok {
+		t.Fatal("parseProcStat() ok = false")
+	}
+	// Guest fields excluded; idle (800) and iowait (20) are not busy
+	want := cpuTimes{busy: 100 + 5 + 50 + 3 + 2 + 1, total: 100 + 5 + 50 + 800 + 20 + 3 + 2 + 1}
+	if got != want {
+		t.Errorf("parseProcStat() = %+v, want %+v", got, want)
+	}
+
+	if _, ok := parseProcStat(strings.NewReader("intr 1\n")); ok {
+		t.Error("parseProcStat() without a cpu line ok = true")
+	}
+}
+
+func TestParseMeminfo(t *testing.T) {
+	input := `MemTotal:        8000 kB
+MemFree:         1000 kB
+MemAvailable:    3000 kB
+Buffers:          500 kB
+Cached:          1500 kB
+SwapTotal:       2000 kB
+SwapFree:        1500 kB
+`
+	got, ok := parseMeminfo(strings.NewReader(input))
+	if !ok {
+		t.Fatal("parseMeminfo() ok = false")
+	}
+	want := MemoryMetrics{
+		Total:     8000 * 1024,
+		Available: 3000 * 1024,
+		Used:      5000 * 1024,
+		SwapTotal: 2000 * 1024,
+		SwapUsed:  500 * 1024,
+	}
+	if got != want {
+		t.Errorf("parseMeminfo() = %+v, want %+v", got, want)
+	}
+
+	// Kernels before 3.14 have no MemAvailable
+	got, _ = parseMeminfo(strings.NewReader("MemTotal: 8000 kB\nMemFree: 1000 kB\nBuffers: 500 kB\nCached: 1500 kB\n"))
+	if got.Available != 3000*1024 {
+		t.Errorf("parseMeminfo() without MemAvailable Available = %d, want %d", got.Available, 3000*1024)
+	}
+}
+
+func TestParseNetDev(t *testing.T) {
+	input := `Inter-|   Receive                                                |  Transmit
+ face |bytes    packets errs drop fifo frame compressed multicast|bytes    packets errs drop fifo colls carrier compressed
+    lo:    1000      10    0    0    0     0          0         0     1000      10    0    0    0     0       0          0
+  eth0: 5000000    4000    2    0    0     0          0         0   300000    2500    1    0    0     0       0          0
+`
+	got, ok := parseNetDev(strings.NewReader(input))
+	if !ok {
+		t.Fatal("parseNetDev() ok = false")
+	}
+	if len(got) != 2 {
+		t.Fatalf("parseNetDev() returned %d interfaces, want 2", len(got))
+	}
+	want := ifCounters{rxBytes: 5000000, rxPackets: 4000, rxErrors: 2, txBytes: 300000, txPackets: 2500, txErrors: 1}
+	if got["eth0"] != want {
+		t.Errorf("parseNetDev()[eth0] = %+v, want %+v", got["eth0"], want)
+	}
+}
+
+func TestUnescapeMountPath(t *testing.T) {
+	if got := unescapeMountPath(`/mnt/my\040disk`); got != "/mnt/my disk" {
+		t.Errorf("unescapeMountPath() = %q, want %q", got, "/mnt/my disk")
+	}
+	if got := unescapeMountPath(`/mnt/a\0`); got != `/mnt/a\0` {
+		t.Errorf("unescapeMountPath() truncated escape = %q", got)
+	}
+}
+
+func TestSampler_Sample(t *testing.T) {
+	s := NewSampler()
+	m := s.Sample()
+	if len(m.Unsupported) != 0 {
+		t.Errorf("Sample() Unsupported = %v, want none on Linux", m.Unsupported)
+	}
+	if m.Interval <= 0 {
+		t.Errorf("Sample() Interval = %v, want > 0", m.Interval)
+	}
+	if m.CPU.Percent < 0 || m.CPU.Percent > 100 {
+		t.Errorf("Sample() CPU.Percent = %v, want 0-100", m.CPU.Percent)
+	}
+	if m.Memory.Total == 0 || m.Memory.Used > m.Memory.Total {
+		t.Errorf("Sample() Memory = %+v", m.Memory)
+	}
+	if m.Files.ProcessOpen <= 0 {
+		t.Errorf("Sample() Files.ProcessOpen = %d, want > 0", m.Files.ProcessOpen)
+	}
+	if m.Process.Goroutines == 0 {
+		t.Error("Sample() Process.Goroutines = 0")
+	}
+}
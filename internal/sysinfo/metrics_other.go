@@ -0,0 +1,12 @@
+//go:build !linux && !darwin && !windows
+
+package sysinfo
+
+// Host metrics are not supported on this platform.
+
+func readCPUTimes() (cpuTimes, bool)                { return cpuTimes{}, false }
+func readLoadAvg() []float64                        { return nil }
+func readMemory() (MemoryMetrics, bool)             { return MemoryMetrics{}, false }
+func readDisks() ([]DiskMetrics, bool)              { return nil, false }
+func readInterfaces() (map[string]ifCounters, bool) { return nil, false }
+func readFiles() (FileMetrics, bool)                { return FileMetrics{}, false }
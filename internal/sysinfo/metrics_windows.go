@@ -0,0 +1,138 @@
+package sysinfo
+
+import (
+	"net"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	modKernel32               = windows.NewLazySystemDLL("kernel32.dll")
+	procGetSystemTimes        = modKernel32.NewProc("GetSystemTimes")
+	procGlobalMemoryStatusEx  = modKernel32.NewProc("GlobalMemoryStatusEx")
+	procGetProcessHandleCount = modKernel32.NewProc("GetProcessHandleCount")
+)
+
+// memoryStatusEx is the MEMORYSTATUSEX structure.
+type memoryStatusEx struct {
+	Length               uint32
+	MemoryLoad           uint32
+	TotalPhys            uint64
+	AvailPhys            uint64
+	TotalPageFile        uint64
+	AvailPageFile        uint64
+	TotalVirtual         uint64
+	AvailVirtual         uint64
+	AvailExtendedVirtual uint64
+}
+
+// filetimeTicks returns a FILETIME as a count of 100ns ticks.
+func filetimeTicks(ft windows.Filetime) uint64 {
+	return uint64(ft.HighDateTime)<<32 | uint64(ft.LowDateTime)
+}
+
+// readCPUTimes reads the system times. Kernel time includes idle time.
+func readCPUTimes() (cpuTimes, bool) {
+	var idle, kernel, user windows.Filetime
+	r, _, _ := procGetSystemTimes.Call(
+		uintptr(unsafe.Pointer(&idle)),
+		uintptr(unsafe.Pointer(&kernel)),
+		uintptr(unsafe.Pointer(&user)),
+	)
+	if r == 0 {
+		return cpuTimes{}, false
+	}
+	total := filetimeTicks(kernel) + filetimeTicks(user)
+	return cpuTimes{busy: total - min(filetimeTicks(idle), total), total: total}, true
+}
+
+// readLoadAvg returns nil: Windows has no load average.
+func readLoadAvg() []float64 {
+	return nil
+}
+
+// readMemory reads the physical memory and page file usage. The page file
+// is reported as swap.
+func readMemory() (MemoryMetrics, bool) {
+	ms := memoryStatusEx{Length: uint32(unsafe.Sizeof(memoryStatusEx{}))}
+	if r, _, _ := procGlobalMemoryStatusEx.Call(uintptr(unsafe.Pointer(&ms))); r == 0 {
+		return MemoryMetrics{}, false
+	}
+	m := MemoryMetrics{
+		Total:     ms.TotalPhys,
+		Available: min(ms.AvailPhys, ms.TotalPhys),
+		SwapTotal: ms.TotalPageFile,
+		SwapUsed:  ms.TotalPageFile - min(ms.AvailPageFile, ms.TotalPageFile),
+	}
+	m.Used = m.Total - m.Available
+	return m, true
+}
+
+// readDisks returns the usage of the fixed drives.
+func readDisks() ([]DiskMetrics, bool) {
+	mask, err := windows.GetLogicalDrives()
+	if err != nil {
+		return nil, false
+	}
+	var disks []DiskMetrics
+	for i := 0; i < 26; i++ {
+		if mask&(1<<i) == 0 {
+			continue
+		}
+		root := string(rune('A'+i)) + `:\`
+		p, err := windows.UTF16PtrFromString(root)
+		if err != nil || windows.GetDriveType(p) != windows.DRIVE_FIXED {
+			continue
+		}
+		var avail, total, free uint64
+		if windows.GetDiskFreeSpaceEx(p, &avail, &total, &free) != nil || total == 0 {
+			continue
+		}
+		disks = append(disks, DiskMetrics{
+			Path:  root,
+			Total: total,
+			Free:  avail,
+			Used:  total - min(free, total),
+		})
+	}
+	return disks, len(disks) > 0
+}
+
+// readInterfaces reads the counters of the interfaces that are up.
+func readInterfaces() (map[string]ifCounters, bool) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, false
+	}
+	ifs := make(map[string]ifCounters)
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 {
+			continue
+		}
+		row := windows.MibIfRow2{InterfaceIndex: uint32(iface.Index)}
+		if windows.GetIfEntry2Ex(windows.MibIfEntryNormal, &row) != nil {
+			continue
+		}
+		ifs[iface.Name] = ifCounters{
+			rxBytes:   row.InOctets,
+			txBytes:   row.OutOctets,
+			rxPackets: row.InUcastPkts + row.InNUcastPkts,
+			txPackets: row.OutUcastPkts + row.OutNUcastPkts,
+			rxErrors:  row.InErrors,
+			txErrors:  row.OutErrors,
+		}
+	}
+	return ifs, true
+}
+
+// readFiles reads the handle count of the process. Windows has no
+// per-process or system-wide handle limit to report.
+func readFiles() (FileMetrics, bool) {
+	var count uint32
+	r, _, _ := procGetProcessHandleCount.Call(uintptr(windows.CurrentProcess()), uintptr(unsafe.Pointer(&count)))
+	if r == 0 {
+		return FileMetrics{}, false
+	}
+	return FileMetrics{ProcessOpen: int(count)}, true
+}
@@ -0,0 +1,45 @@
+// Package sysroute installs routes into the host routing table. It uses
+// netlink on Linux, the routing socket on macOS and the IP Helper API on
+// Windows. All routes point at a single network interface without a
+// gateway, which is how a TUN device is reached.
+package sysroute
+
+import (
+	"errors"
+	"net/netip"
+)
+
+// ErrUnsupported is returned by Open on platforms without route support.
+var ErrUnsupported = errors.New("kernel routes are not supported on this platform")
+
+// Config selects where routes are installed.
+type Config struct {
+	Interface string // Interface the routes point at
+	Metric    int    // Route metric, 0 for the system default (ignored on macOS)
+	Table     int    // Routing table, 0 for the main table (Linux only)
+}
+
+// Table adds and removes routes through one interface. Implementations are
+// safe for concurrent use.
+type Table interface {
+	// Add installs a route to prefix. Adding an installed route is not an
+	// error.
+	Add(prefix netip.Prefix) error
+
+	// Delete removes the route to prefix. Deleting a missing route is not
+	// an error.
+	Delete(prefix netip.Prefix) error
+
+	// Flush removes routes on the interface that an earlier run left
+	// behind, for example after a crash. It is a no-op where routes cannot
+	// be told apart from ones added by other software.
+	Flush() error
+
+	// Close releases the table. Installed routes are left in place.
+	Close() error
+}
+
+// Open returns the routing table for cfg. The interface must exist.
+func Open(cfg Config) (Table, error) {
+	return openTable(cfg)
+}
@@ -0,0 +1,163 @@
+package sysroute
+
+import (
+	"errors"
+	"fmt"
+	"math/bits"
+	"net"
+	"net/netip"
+	"os"
+	"sync"
+
+	"golang.org/x/net/route"
+	"golang.org/x/sys/unix"
+)
+
+// rtfMutiMetroo tags routes installed by the agent, shown as flag "1" by
+// netstat -rn. Flush removes routes with this flag on the interface.
+const rtfMutiMetroo = unix.RTF_PROTO1
+
+// routeSocketTable manages routes over a PF_ROUTE socket.
+type routeSocketTable struct {
+	mu      sync.Mutex
+	fd      int
+	seq     int
+	ifindex int
+}
+
+func openTable(cfg Config) (Table, error) {
+	iface, err := net.InterfaceByName(cfg.Interface)
+	if err != nil {
+		return nil, err
+	}
+	fd, err := unix.Socket(unix.AF_ROUTE, unix.SOCK_RAW, unix.AF_UNSPEC)
+	if err != nil {
+		return nil, fmt.Errorf("routing socket: %w", err)
+	}
+	// Replies are not read; errors come back from write
+	_ = unix.Shutdown(fd, unix.SHUT_RD)
+	return &routeSocketTable{fd: fd, ifindex: iface.Index}, nil
+}
+
+func (t *routeSocketTable) Add(prefix netip.Prefix) error {
+	err := t.write(unix.RTM_ADD, prefix)
+	if err != nil && !errors.Is(err, unix.EEXIST) {
+		return fmt.Errorf("add route %s: %w", prefix, err)
+	}
+	return nil
+}
+
+func (t *routeSocketTable) Delete(prefix netip.Prefix) error {
+	err := t.write(unix.RTM_DELETE, prefix)
+	if err != nil && !errors.Is(err, unix.ESRCH) {
+		return fmt.Errorf("delete route %s: %w", prefix, err)
+	}
+	return nil
+}
+
+func (t *routeSocketTable) Flush() error {
+	rib, err := route.FetchRIB(unix.AF_UNSPEC, route.RIBTypeRoute, 0)
+	if err != nil {
+		return fmt.Errorf("list routes: %w", err)
+	}
+	msgs, err := route.ParseRIB(route.RIBTypeRoute, rib)
+	if err != nil {
+		return fmt.Errorf("list routes: %w", err)
+	}
+	var errs []error
+	for _, msg := range msgs {
+		rm, ok := msg.(*route.RouteMessage)
+		if !ok || rm.Flags&rtfMutiMetroo == 0 || rm.Index != t.ifindex {
+			continue
+		}
+		if prefix, ok := messagePrefix(rm); ok {
+			if err := t.Delete(prefix); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (t *routeSocketTable) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.fd < 0 {
+		return nil
+	}
+	err := unix.Close(t.fd)
+	t.fd = -1
+	return err
+}
+
+// write sends one route message for an interface route to prefix.
+func (t *routeSocketTable) write(typ int, prefix netip.Prefix) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.fd < 0 {
+		return net.ErrClosed
+	}
+
+	prefix = prefix.Masked()
+	var dst, mask route.Addr
+	if prefix.Addr().Is4() {
+		m := net.CIDRMask(prefix.Bits(), 32)
+		dst = &route.Inet4Addr{IP: prefix.Addr().As4()}
+		mask = &route.Inet4Addr{IP: [4]byte(m)}
+	} else {
+		m := net.CIDRMask(prefix.Bits(), 128)
+		dst = &route.Inet6Addr{IP: prefix.Addr().As16()}
+		mask = &route.Inet6Addr{IP: [16]byte(m)}
+	}
+
+	t.seq++
+	msg := &route.RouteMessage{
+		Version: unix.RTM_VERSION,
+		Type:    typ,
+		Flags:   unix.RTF_UP | unix.RTF_STATIC | rtfMutiMetroo,
+		Index:   t.ifindex,
+		ID:      uintptr(os.Getpid()),
+		Seq:     t.seq,
+		Addrs: []route.Addr{
+			unix.RTAX_DST:     dst,
+			unix.RTAX_GATEWAY: &route.LinkAddr{Index: t.ifindex},
+			unix.RTAX_NETMASK: mask,
+		},
+	}
+	b, err := msg.Marshal()
+	if err != nil {
+		return err
+	}
+	_, err = unix.Write(t.fd, b)
+	return err
+}
+
+// messagePrefix returns the destination prefix of a dumped route.
+func messagePrefix(rm *route.RouteMessage) (netip.Prefix, bool) {
+	if len(rm.Addrs) <= unix.RTAX_NETMASK {
+		return netip.Prefix{}, false
+	}
+	switch dst := rm.Addrs[unix.RTAX_DST].(type) {
+	case *route.Inet4Addr:
+		ones := 32
+		if m, ok := rm.Addrs[unix.RTAX_NETMASK].(*route.Inet4Addr); ok {
+			ones = maskBits(m.IP[:])
+		}
+		return netip.PrefixFrom(netip.AddrFrom4(dst.IP), ones), true
+	case *route.Inet6Addr:
+		ones := 128
+		if m, ok := rm.Addrs[unix.RTAX_NETMASK].(*route.Inet6Addr); ok {
+			ones = maskBits(m.IP[:])
+		}
+		return netip.PrefixFrom(netip.AddrFrom16(dst.IP), ones), true
+	}
+	return netip.Prefix{}, false
+}
+
+func maskBits(mask []byte) int {
+	n := 0
+	for _, b := range mask {
+		n += bits.OnesCount8(b)
+	}
+	return n
+}
@@ -0,0 +1,277 @@
+package sysroute
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"sync"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// rtprotMutiMetroo is the route protocol (rtm_protocol) that tags routes
+// installed by the agent, shown as "proto 77" by ip route. Flush removes
+// routes with this tag on the interface.
+const rtprotMutiMetroo = 77
+
+// netlinkTable manages routes over an rtnetlink socket.
+type netlinkTable struct {
+	mu      sync.Mutex
+	fd      int
+	seq     uint32
+	ifindex int
+	metric  uint32
+	table   uint32
+}
+
+func openTable(cfg Config) (Table, error) {
+	iface, err := net.InterfaceByName(cfg.Interface)
+	if err != nil {
+		return nil, err
+	}
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.NETLINK_ROUTE)
+	if err != nil {
+		return nil, fmt.Errorf("netlink socket: %w", err)
+	}
+	if err := unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("netlink bind: %w", err)
+	}
+	table := uint32(cfg.Table)
+	if table == 0 {
+		table = unix.RT_TABLE_MAIN
+	}
+	return &netlinkTable{
+		fd:      fd,
+		ifindex: iface.Index,
+		metric:  uint32(cfg.Metric),
+		table:   table,
+	}, nil
+}
+
+func (t *netlinkTable) Add(prefix netip.Prefix) error {
+	err := t.request(unix.RTM_NEWROUTE, unix.NLM_F_CREATE|unix.NLM_F_REPLACE, t.routeMessage(prefix))
+	if err != nil {
+		return fmt.Errorf("add route %s: %w", prefix, err)
+	}
+	return nil
+}
+
+func (t *netlinkTable) Delete(prefix netip.Prefix) error {
+	err := t.request(unix.RTM_DELROUTE, 0, t.routeMessage(prefix))
+	if err != nil && !errors.Is(err, unix.ESRCH) {
+		return fmt.Errorf("delete route %s: %w", prefix, err)
+	}
+	return nil
+}
+
+func (t *netlinkTable) Flush() error {
+	stale, err := t.dump()
+	if err != nil {
+		return fmt.Errorf("list routes: %w", err)
+	}
+	var errs []error
+	for _, prefix := range stale {
+		if err := t.Delete(prefix); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (t *netlinkTable) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.fd < 0 {
+		return nil
+	}
+	err := unix.Close(t.fd)
+	t.fd = -1
+	return err
+}
+
+// routeMessage builds an rtmsg with attributes for a route to prefix
+// through the interface.
+func (t *netlinkTable) routeMessage(prefix netip.Prefix) []byte {
+	prefix = prefix.Masked()
+	family := byte(unix.AF_INET)
+	if prefix.Addr().Is6() {
+		family = unix.AF_INET6
+	}
+	rtmTable := byte(unix.RT_TABLE_UNSPEC)
+	if t.table < 256 {
+		rtmTable = byte(t.table)
+	}
+
+	b := make([]byte, unix.SizeofRtMsg)
+	b[0] = family
+	b[1] = byte(prefix.Bits())
+	b[4] = rtmTable
+	b[5] = rtprotMutiMetroo
+	b[6] = unix.RT_SCOPE_LINK
+	b[7] = unix.RTN_UNICAST
+
+	b = appendAttr(b, unix.RTA_DST, prefix.Addr().AsSlice())
+	b = appendAttr(b, unix.RTA_OIF, u32(uint32(t.ifindex)))
+	b = appendAttr(b, unix.RTA_TABLE, u32(t.table))
+	if t.metric > 0 {
+		b = appendAttr(b, unix.RTA_PRIORITY, u32(t.metric))
+	}
+	return b
+}
+
+// request sends one netlink request and waits for its acknowledgement.
+func (t *netlinkTable) request(typ uint16, flags uint16, payload []byte) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.fd < 0 {
+		return net.ErrClosed
+	}
+
+	t.seq++
+	seq := t.seq
+	if err := t.send(typ, flags|unix.NLM_F_REQUEST|unix.NLM_F_ACK, seq, payload); err != nil {
+		return err
+	}
+	for {
+		msgs, err := t.receive()
+		if err != nil {
+			return err
+		}
+		for _, m := range msgs {
+			if m.Header.Seq != seq || m.Header.Type != unix.NLMSG_ERROR {
+				continue
+			}
+			if len(m.Data) < 4 {
+				return errors.New("short netlink ack")
+			}
+			if errno := -int32(binary.NativeEndian.Uint32(m.Data[:4])); errno != 0 {
+				return unix.Errno(errno)
+			}
+			return nil
+		}
+	}
+}
+
+// dump returns the prefixes of routes tagged rtprotMutiMetroo on the
+// interface in the configured table.
+func (t *netlinkTable) dump() ([]netip.Prefix, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.fd < 0 {
+		return nil, net.ErrClosed
+	}
+
+	t.seq++
+	seq := t.seq
+	req := make([]byte, unix.SizeofRtMsg) // AF_UNSPEC: both families
+	if err := t.send(unix.RTM_GETROUTE, unix.NLM_F_REQUEST|unix.NLM_F_DUMP, seq, req); err != nil {
+		return nil, err
+	}
+
+	var prefixes []netip.Prefix
+	for {
+		msgs, err := t.receive()
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range msgs {
+			if m.Header.Seq != seq {
+				continue
+			}
+			switch m.Header.Type {
+			case unix.NLMSG_DONE:
+				return prefixes, nil
+			case unix.NLMSG_ERROR:
+				if len(m.Data) >= 4 {
+					if errno := -int32(binary.NativeEndian.Uint32(m.Data[:4])); errno != 0 {
+						return nil, unix.Errno(errno)
+					}
+				}
+				return prefixes, nil
+			case unix.RTM_NEWROUTE:
+				if prefix, ok := t.ownRoute(m); ok {
+					prefixes = append(prefixes, prefix)
+				}
+			}
+		}
+	}
+}
+
+// ownRoute reports whether a dumped route was installed through this table
+// and returns its prefix.
+func (t *netlinkTable) ownRoute(m syscall.NetlinkMessage) (netip.Prefix, bool) {
+	if len(m.Data) < unix.SizeofRtMsg {
+		return netip.Prefix{}, false
+	}
+	bits, table, proto := int(m.Data[1]), uint32(m.Data[4]), m.Data[5]
+	if proto != rtprotMutiMetroo {
+		return netip.Prefix{}, false
+	}
+	attrs, err := syscall.ParseNetlinkRouteAttr(&m)
+	if err != nil {
+		return netip.Prefix{}, false
+	}
+	var dst netip.Addr
+	oif := -1
+	for _, attr := range attrs {
+		switch attr.Attr.Type {
+		case unix.RTA_DST:
+			dst, _ = netip.AddrFromSlice(attr.Value)
+		case unix.RTA_OIF:
+			if len(attr.Value) >= 4 {
+				oif = int(binary.NativeEndian.Uint32(attr.Value))
+			}
+		case unix.RTA_TABLE:
+			if len(attr.Value) >= 4 {
+				table = binary.NativeEndian.Uint32(attr.Value)
+			}
+		}
+	}
+	if !dst.IsValid() || oif != t.ifindex || table != t.table {
+		return netip.Prefix{}, false
+	}
+	return netip.PrefixFrom(dst, bits), true
+}
+
+func (t *netlinkTable) send(typ, flags uint16, seq uint32, payload []byte) error {
+	b := make([]byte, unix.NLMSG_HDRLEN, unix.NLMSG_HDRLEN+len(payload))
+	binary.NativeEndian.PutUint32(b[0:4], uint32(unix.NLMSG_HDRLEN+len(payload)))
+	binary.NativeEndian.PutUint16(b[4:6], typ)
+	binary.NativeEndian.PutUint16(b[6:8], flags)
+	binary.NativeEndian.PutUint32(b[8:12], seq)
+	b = append(b, payload...)
+	return unix.Sendto(t.fd, b, 0, &unix.SockaddrNetlink{Family: unix.AF_NETLINK})
+}
+
+func (t *netlinkTable) receive() ([]syscall.NetlinkMessage, error) {
+	buf := make([]byte, 1<<16)
+	n, _, err := unix.Recvfrom(t.fd, buf, 0)
+	if err != nil {
+		return nil, err
+	}
+	return syscall.ParseNetlinkMessage(buf[:n])
+}
+
+// appendAttr appends a route attribute, padded to the netlink alignment.
+func appendAttr(b []byte, typ uint16, value []byte) []byte {
+	l := unix.SizeofRtAttr + len(value)
+	attr := make([]byte, rtaAlign(l))
+	binary.NativeEndian.PutUint16(attr[0:2], uint16(l))
+	binary.NativeEndian.PutUint16(attr[2:4], typ)
+	copy(attr[unix.SizeofRtAttr:], value)
+	return append(b, attr...)
+}
+
+func rtaAlign(l int) int {
+	return (l + unix.RTA_ALIGNTO - 1) &^ (unix.RTA_ALIGNTO - 1)
+}
+
+func u32(v uint32) []byte {
+	b := make([]byte, 4)
+	binary.NativeEndian.PutUint32(b, v)
+	return b
+}
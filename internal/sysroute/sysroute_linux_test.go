@@ -0,0 +1,73 @@
+package sysroute
+
+import (
+	"errors"
+	"net/netip"
+	"slices"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// testTable is a routing table unlikely to be used on the test host.
+const testTable = 4242
+
+func openTestTable(t *testing.T) *netlinkTable {
+	t.Helper()
+	table, err := Open(Config{Interface: "lo", Metric: 50, Table: testTable})
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	t.Cleanup(func() { table.Close() })
+	return table.(*netlinkTable)
+}
+
+func TestNetlinkTable(t *testing.T) {
+	table := openTestTable(t)
+
+	v4 := netip.MustParsePrefix("198.51.100.0/24")
+	v6 := netip.MustParsePrefix("2001:db8:4242::/48")
+	if err := table.Add(v4); err != nil {
+		if errors.Is(err, unix.EPERM) || errors.Is(err, unix.EACCES) {
+			t.Skip("needs CAP_NET_ADMIN")
+		}
+		t.Fatalf("Add(%s) error = %v", v4, err)
+	}
+	t.Cleanup(func() { table.Delete(v4) })
+	if err := table.Add(v4); err != nil {
+		t.Errorf("second Add(%s) error = %v", v4, err)
+	}
+	if err := table.Add(v6); err != nil {
+		t.Logf("Add(%s) error = %v (IPv6 unavailable?)", v6, err)
+		v6 = netip.Prefix{}
+	} else {
+		t.Cleanup(func() { table.Delete(v6) })
+	}
+
+	got, err := table.dump()
+	if err != nil {
+		t.Fatalf("dump() error = %v", err)
+	}
+	if !slices.Contains(got, v4) || (v6.IsValid() && !slices.Contains(got, v6)) {
+		t.Errorf("dump() = %v, want %s and %s", got, v4, v6)
+	}
+
+	// Flush removes everything a previous table left behind
+	other := openTestTable(t)
+	if err := other.Flush(); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+	if got, _ := table.dump(); len(got) != 0 {
+		t.Errorf("dump() after Flush() = %v, want none", got)
+	}
+
+	if err := table.Delete(v4); err != nil {
+		t.Errorf("Delete() of a missing route error = %v", err)
+	}
+}
+
+func TestOpen_UnknownInterface(t *testing.T) {
+	if _, err := Open(Config{Interface: "mm-no-such-if0"}); err == nil {
+		t.Error("Open() with an unknown interface succeeded")
+	}
+}
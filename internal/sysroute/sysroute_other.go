@@ -0,0 +1,8 @@
+//go:build !linux && !darwin && !windows
+
+package sysroute
+
+// openTable is not supported on this platform.
+func openTable(cfg Config) (Table, error) {
+	return nil, ErrUnsupported
+}
@@ -0,0 +1,87 @@
+package sysroute
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	modiphlpapi                  = windows.NewLazySystemDLL("iphlpapi.dll")
+	procInitializeIpForwardEntry = modiphlpapi.NewProc("InitializeIpForwardEntry")
+	procCreateIpForwardEntry2    = modiphlpapi.NewProc("CreateIpForwardEntry2")
+	procDeleteIpForwardEntry2    = modiphlpapi.NewProc("DeleteIpForwardEntry2")
+)
+
+// ipHelperTable manages routes through the IP Helper API.
+type ipHelperTable struct {
+	ifindex uint32
+	metric  uint32
+}
+
+func openTable(cfg Config) (Table, error) {
+	iface, err := net.InterfaceByName(cfg.Interface)
+	if err != nil {
+		return nil, err
+	}
+	if err := modiphlpapi.Load(); err != nil {
+		return nil, err
+	}
+	return &ipHelperTable{ifindex: uint32(iface.Index), metric: uint32(cfg.Metric)}, nil
+}
+
+func (t *ipHelperTable) Add(prefix netip.Prefix) error {
+	row := t.row(prefix)
+	r, _, _ := procCreateIpForwardEntry2.Call(uintptr(unsafe.Pointer(row)))
+	if err := windows.Errno(r); r != 0 && !errors.Is(err, windows.ERROR_OBJECT_ALREADY_EXISTS) {
+		return fmt.Errorf("add route %s: %w", prefix, err)
+	}
+	return nil
+}
+
+func (t *ipHelperTable) Delete(prefix netip.Prefix) error {
+	row := t.row(prefix)
+	r, _, _ := procDeleteIpForwardEntry2.Call(uintptr(unsafe.Pointer(row)))
+	if err := windows.Errno(r); r != 0 && !errors.Is(err, windows.ERROR_NOT_FOUND) {
+		return fmt.Errorf("delete route %s: %w", prefix, err)
+	}
+	return nil
+}
+
+// Flush is a no-op: routes added through the IP Helper API cannot be told
+// apart from ones added with "route add".
+func (t *ipHelperTable) Flush() error {
+	return nil
+}
+
+func (t *ipHelperTable) Close() error {
+	return nil
+}
+
+// row builds an on-link route to prefix through the interface.
+func (t *ipHelperTable) row(prefix netip.Prefix) *windows.MibIpForwardRow2 {
+	prefix = prefix.Masked()
+	row := &windows.MibIpForwardRow2{}
+	procInitializeIpForwardEntry.Call(uintptr(unsafe.Pointer(row)))
+
+	row.InterfaceIndex = t.ifindex
+	row.DestinationPrefix.PrefixLength = uint8(prefix.Bits())
+	if prefix.Addr().Is4() {
+		dst := (*windows.RawSockaddrInet4)(unsafe.Pointer(&row.DestinationPrefix.Prefix))
+		dst.Family = windows.AF_INET
+		dst.Addr = prefix.Addr().As4()
+		row.NextHop.Family = windows.AF_INET // Unspecified next hop: on-link
+	} else {
+		dst := (*windows.RawSockaddrInet6)(unsafe.Pointer(&row.DestinationPrefix.Prefix))
+		dst.Family = windows.AF_INET6
+		dst.Addr = prefix.Addr().As16()
+		row.NextHop.Family = windows.AF_INET6
+	}
+	row.Metric = t.metric
+	row.Protocol = windows.MIB_IPPROTO_NETMGMT
+	return row
+}
@@ -0,0 +1,399 @@
+package testmesh
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"runtime"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/postalsys/muti-metroo/internal/socks5"
+)
+
+// Load protocols.
+const (
+	ProtocolTCP = "tcp"
+	ProtocolUDP = "udp"
+)
+
+// udpReplyTimeout is how long a UDP worker waits for an echoed datagram
+// before counting it as lost.
+const udpReplyTimeout = 2 * time.Second
+
+// dialRetryDelay is how long a TCP worker waits after a failed dial.
+const dialRetryDelay = 100 * time.Millisecond
+
+// LoadOptions configures a load run.
+type LoadOptions struct {
+	// Protocol is tcp or udp (default tcp).
+	Protocol string
+
+	// Concurrency is the number of parallel workers (default 1). Each
+	// worker holds one TCP connection or one UDP association.
+	Concurrency int
+
+	// Duration is how long load is generated (default 10s).
+	Duration time.Duration
+
+	// PayloadSize is the bytes each operation sends and receives back
+	// (default 1024). UDP payloads are limited by the maximum datagram size.
+	PayloadSize int
+}
+
+// Result is the outcome of a load run. One operation is one payload sent
+// through the mesh to an echo server and received back.
+type Result struct {
+	Nodes       int           `json:"nodes"`
+	Protocol    string        `json:"protocol"`
+	Concurrency int           `json:"concurrency"`
+	PayloadSize int           `json:"payload_size"`
+	Duration    time.Duration `json:"duration_ns"`
+
+	Operations int64 `json:"operations"`
+	Errors     int64 `json:"errors"`
+	Bytes      int64 `json:"bytes"`
+
+	OpsPerSecond   float64 `json:"ops_per_second"`
+	BytesPerSecond float64 `json:"bytes_per_second"`
+
+	Latency LatencyStats `json:"latency"`
+	Allocs  AllocStats   `json:"allocs"`
+}
+
+// LatencyStats are round-trip latency percentiles of successful operations.
+type LatencyStats struct {
+	Min time.Duration `json:"min_ns"`
+	P50 time.Duration `json:"p50_ns"`
+	P90 time.Duration `json:"p90_ns"`
+	P99 time.Duration `json:"p99_ns"`
+	Max time.Duration `json:"max_ns"`
+}
+
+// AllocStats are Go heap statistics for the whole process (all nodes and
+// the load generators) during the run.
+type AllocStats struct {
+	TotalBytes  uint64  `json:"total_bytes"`
+	Mallocs     uint64  `json:"mallocs"`
+	BytesPerOp  float64 `json:"bytes_per_op"`
+	AllocsPerOp float64 `json:"allocs_per_op"`
+	NumGC       uint32  `json:"num_gc"`
+	HeapInUse   uint64  `json:"heap_in_use"`
+}
+
+// workerResult is what one load worker measured.
+type workerResult struct {
+	ops       int64
+	errs      int64
+	bytes     int64
+	latencies []time.Duration
+}
+
+// RunLoad starts an echo server next to the exit node and generates load to
+// it from the ingress node until opts.Duration has passed or ctx is done.
+func (m *Mesh) RunLoad(ctx context.Context, opts LoadOptions) (*Result, error) {
+	if opts.Protocol == "" {
+		opts.Protocol = ProtocolTCP
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = 1
+	}
+	if opts.Duration <= 0 {
+		opts.Duration = 10 * time.Second
+	}
+	if opts.PayloadSize <= 0 {
+		opts.PayloadSize = 1024
+	}
+
+	var worker func(ctx context.Context, target string, payloadSize int) workerResult
+	var target string
+	switch opts.Protocol {
+	case ProtocolTCP:
+		ln, err := startTCPEcho()
+		if err != nil {
+			return nil, err
+		}
+		defer ln.Close()
+		target = ln.Addr().String()
+		worker = m.tcpWorker
+	case ProtocolUDP:
+		pc, err := startUDPEcho()
+		if err != nil {
+			return nil, err
+		}
+		defer pc.Close()
+		target = pc.LocalAddr().String()
+		worker = m.udpWorker
+	default:
+		return nil, fmt.Errorf("unknown protocol %q (must be tcp or udp)", opts.Protocol)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, opts.Duration)
+	defer cancel()
+
+	runtime.GC()
+	var before runtime.MemStats
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+
+	results := make([]workerResult, opts.Concurrency)
+	var wg sync.WaitGroup
+	for i := range results {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = worker(ctx, target, opts.PayloadSize)
+		}()
+	}
+	wg.Wait()
+
+	elapsed := time.Since(start)
+	var after runtime.MemStats
+	runtime.ReadMemStats(&after)
+
+	res := &Result{
+		Nodes:       len(m.nodes),
+		Protocol:    opts.Protocol,
+		Concurrency: opts.Concurrency,
+		PayloadSize: opts.PayloadSize,
+		Duration:    elapsed,
+	}
+	var latencies []time.Duration
+	for _, r := range results {
+		res.Operations += r.ops
+		res.Errors += r.errs
+		res.Bytes += r.bytes
+		latencies = append(latencies, r.latencies...)
+	}
+	if secs := elapsed.Seconds(); secs > 0 {
+		res.OpsPerSecond = float64(res.Operations) / secs
+		res.BytesPerSecond = float64(res.Bytes) / secs
+	}
+	res.Latency = latencyStats(latencies)
+	res.Allocs = AllocStats{
+		TotalBytes: after.TotalAlloc - before.TotalAlloc,
+		Mallocs:    after.Mallocs - before.Mallocs,
+		NumGC:      after.NumGC - before.NumGC,
+		HeapInUse:  after.HeapInuse,
+	}
+	if res.Operations > 0 {
+		res.Allocs.BytesPerOp = float64(res.Allocs.TotalBytes) / float64(res.Operations)
+		res.Allocs.AllocsPerOp = float64(res.Allocs.Mallocs) / float64(res.Operations)
+	}
+	return res, nil
+}
+
+// latencyStats returns the percentiles of latencies, which it sorts.
+func latencyStats(latencies []time.Duration) LatencyStats {
+	if len(latencies) == 0 {
+		return LatencyStats{}
+	}
+	slices.Sort(latencies)
+	pct := func(p float64) time.Duration {
+		return latencies[int(p*float64(len(latencies)-1))]
+	}
+	return LatencyStats{
+		Min: latencies[0],
+		P50: pct(0.50),
+		P90: pct(0.90),
+		P99: pct(0.99),
+		Max: latencies[len(latencies)-1],
+	}
+}
+
+// tcpWorker opens a mesh connection to the echo server and echoes payloads
+// over it until ctx is done. A failed connection is counted as an error and
+// replaced.
+func (m *Mesh) tcpWorker(ctx context.Context, target string, payloadSize int) workerResult {
+	var res workerResult
+	payload := bytes.Repeat([]byte{'x'}, payloadSize)
+	buf := make([]byte, payloadSize)
+
+	for ctx.Err() == nil {
+		conn, err := m.Ingress().DialContext(ctx, "tcp", target)
+		if err != nil {
+			if ctx.Err() == nil {
+				res.errs++
+				sleepCtx(ctx, dialRetryDelay)
+			}
+			continue
+		}
+		stop := context.AfterFunc(ctx, func() { conn.Close() })
+
+		for ctx.Err() == nil {
+			start := time.Now()
+			if _, err = conn.Write(payload); err == nil {
+				_, err = io.ReadFull(conn, buf)
+			}
+			if err != nil {
+				if ctx.Err() == nil {
+					res.errs++
+				}
+				break
+			}
+			res.latencies = append(res.latencies, time.Since(start))
+			res.ops++
+			res.bytes += int64(2 * payloadSize)
+		}
+		stop()
+		conn.Close()
+	}
+	return res
+}
+
+// sleepCtx waits for d or until ctx is done.
+func sleepCtx(ctx context.Context, d time.Duration) {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+	case <-t.C:
+	}
+}
+
+// udpWorker opens a SOCKS5 UDP association on the ingress node and echoes
+// datagrams through it until ctx is done. A datagram without a reply within
+// udpReplyTimeout is counted as an error.
+func (m *Mesh) udpWorker(ctx context.Context, target string, payloadSize int) workerResult {
+	var res workerResult
+
+	targetAddr, err := net.ResolveUDPAddr("udp", target)
+	if err != nil {
+		res.errs++
+		return res
+	}
+	ctrl, relay, err := udpAssociate(ctx, m.Ingress().SOCKS5Address().String())
+	if err != nil {
+		res.errs++
+		return res
+	}
+	defer ctrl.Close()
+
+	conn, err := net.DialUDP("udp", nil, relay)
+	if err != nil {
+		res.errs++
+		return res
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	header := socks5.BuildUDPHeader(socks5.AddrTypeIPv4, targetAddr.IP.To4(), uint16(targetAddr.Port))
+	datagram := append(header, bytes.Repeat([]byte{'x'}, payloadSize)...)
+	buf := make([]byte, 65535)
+
+	for ctx.Err() == nil {
+		start := time.Now()
+		if _, err := conn.Write(datagram); err != nil {
+			if ctx.Err() == nil {
+				res.errs++
+			}
+			continue
+		}
+		conn.SetReadDeadline(start.Add(udpReplyTimeout))
+		n, err := conn.Read(buf)
+		if err != nil {
+			if ctx.Err() == nil {
+				res.errs++
+			}
+			continue
+		}
+		_, reply, err := socks5.ParseUDPHeader(buf[:n])
+		if err != nil || len(reply) != payloadSize {
+			res.errs++
+			continue
+		}
+		res.latencies = append(res.latencies, time.Since(start))
+		res.ops++
+		res.bytes += int64(2 * payloadSize)
+	}
+	return res
+}
+
+// udpAssociate performs a no-auth SOCKS5 UDP ASSOCIATE and returns the
+// control connection, which must stay open for the association to live,
+// and the relay address.
+func udpAssociate(ctx context.Context, socksAddr string) (net.Conn, *net.UDPAddr, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", socksAddr)
+	if err != nil {
+		return nil, nil, err
+	}
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	req := []byte{
+		socks5.SOCKS5Version, 1, socks5.AuthMethodNoAuth,
+		socks5.SOCKS5Version, socks5.CmdUDPAssociate, 0x00, socks5.AddrTypeIPv4, 0, 0, 0, 0, 0, 0,
+	}
+	if _, err := conn.Write(req); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	// Method selection (2 bytes) and IPv4 reply (10 bytes)
+	reply := make([]byte, 12)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	if reply[1] != socks5.AuthMethodNoAuth {
+		conn.Close()
+		return nil, nil, errors.New("SOCKS5 server requires authentication")
+	}
+	if reply[3] != socks5.ReplySucceeded {
+		conn.Close()
+		return nil, nil, fmt.Errorf("UDP ASSOCIATE rejected: reply code %d", reply[3])
+	}
+	conn.SetDeadline(time.Time{})
+
+	relay := &net.UDPAddr{
+		IP:   net.IPv4(reply[6], reply[7], reply[8], reply[9]),
+		Port: int(binary.BigEndian.Uint16(reply[10:12])),
+	}
+	return conn, relay, nil
+}
+
+// startTCPEcho starts a TCP echo server on loopback.
+func startTCPEcho() (net.Listener, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return ln, nil
+}
+
+// startUDPEcho starts a UDP echo server on loopback.
+func startUDPEcho() (net.PacketConn, error) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		buf := make([]byte, 65535)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			pc.WriteTo(buf[:n], addr)
+		}
+	}()
+	return pc, nil
+}
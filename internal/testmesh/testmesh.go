@@ -0,0 +1,193 @@
+// Package testmesh runs a mesh of agents inside one process, connected by
+// the in-memory transport, and generates TCP and UDP load across it. It
+// backs the bench command and stress tests.
+package testmesh
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/postalsys/muti-metroo/internal/agent"
+	"github.com/postalsys/muti-metroo/internal/config"
+	"github.com/postalsys/muti-metroo/internal/transport"
+)
+
+// Topology is how the nodes of a mesh are peered.
+type Topology string
+
+const (
+	// TopologyChain peers each node with the next one, so traffic from the
+	// first node crosses every node to reach the exit.
+	TopologyChain Topology = "chain"
+
+	// TopologyFull peers every node with every other node.
+	TopologyFull Topology = "full"
+)
+
+// readyTimeout is how long New waits for the exit route to reach the
+// ingress node.
+const readyTimeout = 30 * time.Second
+
+// Options configures a mesh.
+type Options struct {
+	// Nodes is the number of agents, at least 2. Node 0 is the ingress
+	// with a SOCKS5 server and the last node is the exit.
+	Nodes int
+
+	// Topology is how the nodes are peered (default chain).
+	Topology Topology
+
+	// LogLevel is the agents' log level (default error).
+	LogLevel string
+}
+
+// Mesh is a running in-process mesh.
+type Mesh struct {
+	nodes    []*agent.Agent
+	dataDirs []string
+}
+
+// New creates and starts a mesh, and returns once the ingress node has a
+// route to the exit.
+func New(ctx context.Context, opts Options) (*Mesh, error) {
+	if opts.Nodes < 2 {
+		return nil, fmt.Errorf("mesh needs at least 2 nodes, got %d", opts.Nodes)
+	}
+	if opts.Topology == "" {
+		opts.Topology = TopologyChain
+	}
+	if opts.Topology != TopologyChain && opts.Topology != TopologyFull {
+		return nil, fmt.Errorf("unknown topology %q (must be chain or full)", opts.Topology)
+	}
+	if opts.LogLevel == "" {
+		opts.LogLevel = "error"
+	}
+
+	m := &Mesh{}
+	network := transport.NewMemoryNetwork()
+	for i := 0; i < opts.Nodes; i++ {
+		dir, err := os.MkdirTemp("", "testmesh-")
+		if err != nil {
+			m.Close()
+			return nil, err
+		}
+		m.dataDirs = append(m.dataDirs, dir)
+
+		a, err := agent.New(nodeConfig(i, dir, opts))
+		if err != nil {
+			m.Close()
+			return nil, fmt.Errorf("create node %d: %w", i, err)
+		}
+		a.RegisterTransport(transport.NewMemoryTransport(network))
+		m.nodes = append(m.nodes, a)
+	}
+
+	// Start from the exit so listeners exist before peers dial them
+	for i := len(m.nodes) - 1; i >= 0; i-- {
+		if err := m.nodes[i].Start(); err != nil {
+			m.Close()
+			return nil, fmt.Errorf("start node %d: %w", i, err)
+		}
+	}
+
+	if err := m.waitReady(ctx); err != nil {
+		m.Close()
+		return nil, err
+	}
+	return m, nil
+}
+
+// nodeConfig builds the configuration of node i.
+func nodeConfig(i int, dataDir string, opts Options) *config.Config {
+	cfg := config.Default()
+	cfg.Agent.DataDir = dataDir
+	cfg.Agent.DisplayName = nodeAddress(i)
+	cfg.Agent.LogLevel = opts.LogLevel
+
+	cfg.Listeners = []config.ListenerConfig{
+		{Transport: string(transport.TransportMemory), Address: nodeAddress(i)},
+	}
+
+	var peers []int
+	switch opts.Topology {
+	case TopologyChain:
+		if i+1 < opts.Nodes {
+			peers = append(peers, i+1)
+		}
+	case TopologyFull:
+		for j := i + 1; j < opts.Nodes; j++ {
+			peers = append(peers, j)
+		}
+	}
+	for _, j := range peers {
+		cfg.Peers = append(cfg.Peers, config.PeerConfig{
+			ID:        "auto",
+			Transport: string(transport.TransportMemory),
+			Address:   nodeAddress(j),
+		})
+	}
+
+	if i == 0 {
+		cfg.SOCKS5.Enabled = true
+		cfg.SOCKS5.Address = "127.0.0.1:0"
+	}
+	if i == opts.Nodes-1 {
+		cfg.Exit.Enabled = true
+		cfg.Exit.Routes = []string{"0.0.0.0/0"}
+		cfg.UDP.Enabled = true
+	}
+	return cfg
+}
+
+func nodeAddress(i int) string {
+	return fmt.Sprintf("node-%d", i)
+}
+
+// waitReady waits until the ingress node has learned the exit route.
+func (m *Mesh) waitReady(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, readyTimeout)
+	defer cancel()
+
+	exitID := m.Exit().ID()
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		for _, r := range m.Ingress().GetRoutes() {
+			if r.OriginAgent == exitID {
+				return nil
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("exit route did not reach the ingress node: %w", ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// Nodes returns the agents of the mesh, ingress first and exit last.
+func (m *Mesh) Nodes() []*agent.Agent {
+	return m.nodes
+}
+
+// Ingress returns the node where load enters the mesh.
+func (m *Mesh) Ingress() *agent.Agent {
+	return m.nodes[0]
+}
+
+// Exit returns the node where load leaves the mesh.
+func (m *Mesh) Exit() *agent.Agent {
+	return m.nodes[len(m.nodes)-1]
+}
+
+// Close stops all nodes and removes their data directories.
+func (m *Mesh) Close() {
+	for _, a := range m.nodes {
+		a.Stop()
+	}
+	for _, dir := range m.dataDirs {
+		os.RemoveAll(dir)
+	}
+}
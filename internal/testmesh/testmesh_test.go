@@ -0,0 +1,74 @@
+package testmesh
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMesh_Load(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping mesh load test in short mode")
+	}
+
+	ctx := context.Background()
+	m, err := New(ctx, Options{Nodes: 3})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer m.Close()
+
+	for _, proto := range []string{ProtocolTCP, ProtocolUDP} {
+		t.Run(proto, func(t *testing.T) {
+			res, err := m.RunLoad(ctx, LoadOptions{
+				Protocol:    proto,
+				Concurrency: 2,
+				Duration:    500 * time.Millisecond,
+				PayloadSize: 512,
+			})
+			if err != nil {
+				t.Fatalf("RunLoad() error = %v", err)
+			}
+			if res.Operations == 0 {
+				t.Fatalf("RunLoad() completed no operations (%d errors)", res.Errors)
+			}
+			if res.Bytes != res.Operations*2*512 {
+				t.Errorf("Bytes = %d, want %d", res.Bytes, res.Operations*2*512)
+			}
+			if res.Latency.P50 <= 0 || res.Latency.P50 > res.Latency.Max {
+				t.Errorf("Latency = %+v, want 0 < p50 <= max", res.Latency)
+			}
+		})
+	}
+}
+
+func TestNew_Options(t *testing.T) {
+	ctx := context.Background()
+	if _, err := New(ctx, Options{Nodes: 1}); err == nil {
+		t.Error("New() with one node succeeded")
+	}
+	if _, err := New(ctx, Options{Nodes: 2, Topology: "ring"}); err == nil {
+		t.Error("New() with an unknown topology succeeded")
+	}
+}
+
+func TestLatencyStats(t *testing.T) {
+	var latencies []time.Duration
+	for i := 100; i >= 1; i-- {
+		latencies = append(latencies, time.Duration(i)*time.Millisecond)
+	}
+	got := latencyStats(latencies)
+	want := LatencyStats{
+		Min: time.Millisecond,
+		P50: 50 * time.Millisecond,
+		P90: 90 * time.Millisecond,
+		P99: 99 * time.Millisecond,
+		Max: 100 * time.Millisecond,
+	}
+	if got != want {
+		t.Errorf("latencyStats() = %+v, want %+v", got, want)
+	}
+	if (latencyStats(nil) != LatencyStats{}) {
+		t.Error("latencyStats(nil) is not zero")
+	}
+}
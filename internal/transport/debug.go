@@ -0,0 +1,236 @@
+package transport
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/qlog"
+	"github.com/quic-go/quic-go/qlogwriter"
+)
+
+// DebugCaptureOptions selects what a debug capture records.
+type DebugCaptureOptions struct {
+	// KeyLogFile is appended with the TLS session secrets of peer
+	// connections in NSS key log format (SSLKEYLOGFILE). Empty disables
+	// key logging.
+	KeyLogFile string
+
+	// QlogDir receives one qlog trace (.sqlog) per QUIC and WebTransport
+	// peer connection. Empty disables qlog.
+	QlogDir string
+
+	// Duration ends the capture after this long. Zero runs until Stop.
+	Duration time.Duration
+}
+
+// DebugCaptureStatus describes the running capture.
+type DebugCaptureStatus struct {
+	Active     bool
+	KeyLogFile string
+	QlogDir    string
+	Started    time.Time
+	Until      time.Time // Zero when the capture runs until stopped
+	KeyLogs    uint64    // Key log lines written
+	QlogFiles  uint64    // qlog traces started
+}
+
+// DebugCapture records the TLS session secrets and QUIC qlog traces of
+// peer connections while a capture runs, so transport problems can be
+// inspected with Wireshark and qvis. Use it as the KeyLogWriter of peer
+// TLS configs and as the QUICTracer of dial and listen options; both do
+// nothing while no capture runs. It is safe for concurrent use.
+type DebugCapture struct {
+	mu  sync.Mutex
+	cur *captureWindow // nil when no capture runs
+}
+
+// captureWindow is one running capture.
+type captureWindow struct {
+	opts      DebugCaptureOptions
+	started   time.Time
+	until     time.Time
+	keyLog    *os.File
+	keyLogs   uint64
+	qlogFiles uint64
+	timer     *time.Timer
+	done      chan struct{} // Closed when the capture ends
+}
+
+// NewDebugCapture creates a debug capture that is not running.
+func NewDebugCapture() *DebugCapture {
+	return &DebugCapture{}
+}
+
+// Start begins a capture, replacing the running one.
+func (d *DebugCapture) Start(opts DebugCaptureOptions) error {
+	if opts.KeyLogFile == "" && opts.QlogDir == "" {
+		return fmt.Errorf("nothing to capture: no key log file or qlog directory")
+	}
+
+	w := &captureWindow{
+		opts:    opts,
+		started: time.Now(),
+		done:    make(chan struct{}),
+	}
+	if opts.KeyLogFile != "" {
+		if err := os.MkdirAll(filepath.Dir(opts.KeyLogFile), 0700); err != nil {
+			return fmt.Errorf("create key log directory: %w", err)
+		}
+		f, err := os.OpenFile(opts.KeyLogFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			return fmt.Errorf("open key log file: %w", err)
+		}
+		w.keyLog = f
+	}
+	if opts.QlogDir != "" {
+		if err := os.MkdirAll(opts.QlogDir, 0700); err != nil {
+			if w.keyLog != nil {
+				w.keyLog.Close()
+			}
+			return fmt.Errorf("create qlog directory: %w", err)
+		}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.stopLocked()
+	if opts.Duration > 0 {
+		w.until = w.started.Add(opts.Duration)
+		w.timer = time.AfterFunc(opts.Duration, func() {
+			d.mu.Lock()
+			defer d.mu.Unlock()
+			if d.cur == w {
+				d.stopLocked()
+			}
+		})
+	}
+	d.cur = w
+	return nil
+}
+
+// Stop ends the running capture. qlog traces of connections that are
+// still open stop recording. Reports whether a capture was running.
+func (d *DebugCapture) Stop() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.stopLocked()
+}
+
+// stopLocked ends the running capture. Caller must hold the lock.
+func (d *DebugCapture) stopLocked() bool {
+	w := d.cur
+	if w == nil {
+		return false
+	}
+	d.cur = nil
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+	if w.keyLog != nil {
+		w.keyLog.Close()
+	}
+	close(w.done)
+	return true
+}
+
+// Status returns the state of the running capture.
+func (d *DebugCapture) Status() DebugCaptureStatus {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	w := d.cur
+	if w == nil {
+		return DebugCaptureStatus{}
+	}
+	return DebugCaptureStatus{
+		Active:     true,
+		KeyLogFile: w.opts.KeyLogFile,
+		QlogDir:    w.opts.QlogDir,
+		Started:    w.started,
+		Until:      w.until,
+		KeyLogs:    w.keyLogs,
+		QlogFiles:  w.qlogFiles,
+	}
+}
+
+// Write appends key log lines to the key log file while a capture runs and
+// discards them otherwise. It never fails, since crypto/tls aborts the
+// handshake when writing the key log fails.
+func (d *DebugCapture) Write(p []byte) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if w := d.cur; w != nil && w.keyLog != nil {
+		if _, err := w.keyLog.Write(p); err == nil {
+			w.keyLogs += uint64(bytes.Count(p, []byte{'\n'}))
+		}
+	}
+	return len(p), nil
+}
+
+// QUICTracer starts a qlog trace for a new QUIC connection while a capture
+// with a qlog directory runs, and returns nil otherwise. It has the
+// signature of quic.Config.Tracer.
+func (d *DebugCapture) QUICTracer(_ context.Context, isClient bool, connID quic.ConnectionID) qlogwriter.Trace {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	w := d.cur
+	if w == nil || w.opts.QlogDir == "" {
+		return nil
+	}
+	role := "server"
+	if isClient {
+		role = "client"
+	}
+	name := fmt.Sprintf("%s_%s_%s.sqlog", time.Now().UTC().Format("20060102T150405"), connID, role)
+	f, err := os.OpenFile(filepath.Join(w.opts.QlogDir, name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil
+	}
+	w.qlogFiles++
+
+	seq := qlogwriter.NewConnectionFileSeq(&qlogFile{f: f, buf: bufio.NewWriter(f), done: w.done},
+		isClient, connID, []string{qlog.EventSchema})
+	go seq.Run()
+	return seq
+}
+
+// qlogFile is the output of one qlog trace. Once the capture ends, it is
+// closed and further events are discarded, so traces of long-lived
+// connections stop growing. Only used from the trace's writer goroutine.
+type qlogFile struct {
+	f      *os.File
+	buf    *bufio.Writer
+	done   <-chan struct{}
+	closed bool
+}
+
+func (q *qlogFile) Write(p []byte) (int, error) {
+	select {
+	case <-q.done:
+		q.Close()
+	default:
+	}
+	if q.closed {
+		return len(p), nil
+	}
+	return q.buf.Write(p)
+}
+
+func (q *qlogFile) Close() error {
+	if q.closed {
+		return nil
+	}
+	q.closed = true
+	q.buf.Flush()
+	return q.f.Close()
+}
@@ -0,0 +1,129 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+func TestDebugCapture_QUIC(t *testing.T) {
+	dir := t.TempDir()
+	keyLogFile := filepath.Join(dir, "keys", "keylog.txt")
+	qlogDir := filepath.Join(dir, "qlog")
+
+	capture := NewDebugCapture()
+	if err := capture.Start(DebugCaptureOptions{KeyLogFile: keyLogFile, QlogDir: qlogDir}); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	certPEM, keyPEM, err := GenerateSelfSignedCert("localhost", 24*time.Hour)
+	if err != nil {
+		t.Fatalf("GenerateSelfSignedCert() error = %v", err)
+	}
+	serverTLS, err := TLSConfigFromBytes(certPEM, keyPEM)
+	if err != nil {
+		t.Fatalf("TLSConfigFromBytes() error = %v", err)
+	}
+	serverTLS.KeyLogWriter = capture
+	clientTLS := &tls.Config{
+		InsecureSkipVerify: true,
+		NextProtos:         []string{ALPNProtocol},
+		KeyLogWriter:       capture,
+	}
+
+	tr := NewQUICTransport()
+	defer tr.Close()
+	listener, err := tr.Listen("127.0.0.1:0", ListenOptions{TLSConfig: serverTLS, QUICTracer: capture.QUICTracer})
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	defer listener.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	accepted := make(chan PeerConn, 1)
+	go func() {
+		conn, err := listener.Accept(ctx)
+		if err == nil {
+			accepted <- conn
+		}
+	}()
+	conn, err := tr.Dial(ctx, listener.Addr().String(), DialOptions{TLSConfig: clientTLS, QUICTracer: capture.QUICTracer})
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	select {
+	case server := <-accepted:
+		server.Close()
+	case <-ctx.Done():
+		t.Fatal("Accept() timed out")
+	}
+	conn.Close()
+
+	st := capture.Status()
+	if !st.Active || st.KeyLogs == 0 || st.QlogFiles != 2 {
+		t.Errorf("Status() = %+v, want active with key logs and 2 qlog files", st)
+	}
+	if !capture.Stop() {
+		t.Error("Stop() = false, want true")
+	}
+	if capture.Stop() {
+		t.Error("second Stop() = true, want false")
+	}
+	if capture.Status().Active {
+		t.Error("Status().Active = true after Stop()")
+	}
+
+	data, err := os.ReadFile(keyLogFile)
+	if err != nil {
+		t.Fatalf("read key log: %v", err)
+	}
+	if !bytes.Contains(data, []byte("CLIENT_TRAFFIC_SECRET_0 ")) {
+		t.Errorf("key log lacks traffic secrets:\n%s", data)
+	}
+	traces, _ := filepath.Glob(filepath.Join(qlogDir, "*.sqlog"))
+	if len(traces) != 2 {
+		t.Errorf("qlog traces = %v, want client and server", traces)
+	}
+
+	// Nothing is written without a running capture
+	if n, err := capture.Write([]byte("CLIENT_RANDOM x y\n")); n != 18 || err != nil {
+		t.Errorf("Write() = %d, %v; want 18, nil", n, err)
+	}
+	if capture.QUICTracer(ctx, true, quic.ConnectionID{}) != nil {
+		t.Error("QUICTracer() returned a trace without a running capture")
+	}
+	if after, _ := os.ReadFile(keyLogFile); !bytes.Equal(after, data) {
+		t.Error("key log changed after Stop()")
+	}
+}
+
+func TestDebugCapture_Duration(t *testing.T) {
+	capture := NewDebugCapture()
+	if err := capture.Start(DebugCaptureOptions{}); err == nil {
+		t.Error("Start() without outputs succeeded")
+	}
+	if err := capture.Start(DebugCaptureOptions{
+		QlogDir:  t.TempDir(),
+		Duration: 50 * time.Millisecond,
+	}); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	if st := capture.Status(); !st.Active || st.Until.IsZero() {
+		t.Errorf("Status() = %+v, want active with an end time", st)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for capture.Status().Active {
+		if time.Now().After(deadline) {
+			t.Fatal("capture still active after its duration")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -116,6 +116,12 @@ func DialUTLSWithALPN(ctx context.Context, network, addr string, tlsConfig *tls.
 		return nil, fmt.Errorf("failed to dial: %w", err)
 	}
 
+	return handshakeUTLS(rawConn, tlsConfig, preset, alpn)
+}
+
+// handshakeUTLS runs a uTLS client handshake with the given fingerprint
+// preset on an established connection. rawConn is closed on failure.
+func handshakeUTLS(rawConn net.Conn, tlsConfig *tls.Config, preset string, alpn []string) (net.Conn, error) {
 	// Create uTLS config
 	utlsConfig := &utls.Config{
 		ServerName:         tlsConfig.ServerName,
@@ -123,6 +129,7 @@ func DialUTLSWithALPN(ctx context.Context, network, addr string, tlsConfig *tls.
 		RootCAs:            tlsConfig.RootCAs,
 		MinVersion:         tlsConfig.MinVersion,
 		MaxVersion:         tlsConfig.MaxVersion,
+		KeyLogWriter:       tlsConfig.KeyLogWriter,
 	}
 
 	// Handle client certificates for mTLS
@@ -12,6 +12,7 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/postalsys/muti-metroo/internal/ipfamily"
 	"golang.org/x/net/http2"
 )
 
@@ -79,21 +80,12 @@ func (t *H2Transport) Dial(ctx context.Context, addr string, opts DialOptions) (
 
 	var h2Transport *http2.Transport
 
-	// Use uTLS for fingerprinting if enabled
-	if IsFingerprintEnabled(opts.FingerprintPreset) {
+	// Use our own dialer for uTLS fingerprinting and upstream proxies
+	if IsFingerprintEnabled(opts.FingerprintPreset) || opts.ProxyURL != "" {
 		h2Transport = &http2.Transport{
 			AllowHTTP: false,
 			DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
-				// Use uTLS with browser fingerprint
-				conn, err := DialUTLSWithALPN(ctx, network, addr, tlsConfig, opts.FingerprintPreset, []string{"h2"})
-				if err != nil {
-					return nil, err
-				}
-				if conn == nil {
-					// Fallback to standard TLS (shouldn't happen if IsFingerprintEnabled returned true)
-					return tls.Dial(network, addr, tlsConfig)
-				}
-				return conn, nil
+				return dialTLS(ctx, addr, tlsConfig, opts)
 			},
 		}
 	} else {
@@ -177,6 +169,7 @@ func (t *H2Transport) Dial(ctx context.Context, addr string, opts DialOptions) (
 		reader:       resp.Body,
 		writer:       pipeWriter,
 		isDialer:     true,
+		tlsState:     resp.TLS,
 		cancelDialFn: connCancel, // Cancel connection context on Close()
 	}, nil
 }
@@ -215,6 +208,7 @@ func (t *H2Transport) Listen(addr string, opts ListenOptions) (Listener, error)
 
 	listener := &H2Listener{
 		addr:         addr,
+		family:       opts.Family,
 		path:         path,
 		tlsConfig:    tlsConfig,
 		httpHeader:   httpHeader,
@@ -256,6 +250,7 @@ func (t *H2Transport) Close() error {
 // H2Listener implements Listener for HTTP/2.
 type H2Listener struct {
 	addr         string
+	family       ipfamily.Family
 	path         string
 	tlsConfig    *tls.Config
 	httpHeader   string // Custom protocol header name (empty to disable)
@@ -283,7 +278,7 @@ func (l *H2Listener) start() error {
 	http2.ConfigureServer(l.server, &http2.Server{})
 
 	// Create TCP listener
-	ln, err := net.Listen("tcp", l.addr)
+	ln, err := l.family.Listen(l.addr)
 	if err != nil {
 		return fmt.Errorf("listen failed: %w", err)
 	}
@@ -352,6 +347,7 @@ func (l *H2Listener) handleH2Stream(w http.ResponseWriter, r *http.Request) {
 		isDialer:   false,
 		flusher:    flusher,
 		respWriter: w,
+		tlsState:   r.TLS,
 		doneCh:     make(chan struct{}),
 	}
 
@@ -461,6 +457,7 @@ type H2PeerConn struct {
 	isDialer     bool
 	flusher      http.Flusher
 	respWriter   http.ResponseWriter
+	tlsState     *tls.ConnectionState // TLS state of the underlying HTTP/2 connection
 	streamOnce   sync.Once
 	stream       *H2Stream
 	closed       atomic.Bool
@@ -541,6 +538,14 @@ func (c *H2PeerConn) TransportType() TransportType {
 	return TransportHTTP2
 }
 
+// TLSConnectionState returns the TLS state of the underlying HTTP/2 connection.
+func (c *H2PeerConn) TLSConnectionState() (tls.ConnectionState, bool) {
+	if c.tlsState == nil {
+		return tls.ConnectionState{}, false
+	}
+	return *c.tlsState, true
+}
+
 // H2Stream implements Stream for HTTP/2.
 type H2Stream struct {
 	reader  io.ReadCloser
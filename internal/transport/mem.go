@@ -0,0 +1,450 @@
+package transport
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// TransportMemory connects agents in the same process without sockets or
+// TLS. It is used by tests and in-process meshes and is not accepted in
+// configuration files.
+const TransportMemory TransportType = "mem"
+
+// memPipeSize is how many bytes one direction of a memory connection
+// buffers before writers block, like a socket send buffer.
+const memPipeSize = 1 << 20
+
+// MemoryNetwork is the address space shared by memory transports. Agents
+// whose transports use the same network can dial each other's listeners.
+type MemoryNetwork struct {
+	mu        sync.Mutex
+	listeners map[string]*MemoryListener
+	nextPort  atomic.Uint64
+}
+
+// NewMemoryNetwork creates an empty memory network.
+func NewMemoryNetwork() *MemoryNetwork {
+	return &MemoryNetwork{listeners: make(map[string]*MemoryListener)}
+}
+
+var defaultMemoryNetwork = NewMemoryNetwork()
+
+// DefaultMemoryNetwork returns the process-wide memory network that agents
+// register their memory transport on.
+func DefaultMemoryNetwork() *MemoryNetwork {
+	return defaultMemoryNetwork
+}
+
+// MemoryTransport implements Transport over in-memory pipes. Like the TCP
+// transport, each connection carries a single byte stream and virtual
+// streams are multiplexed over it with the frame protocol. TLS options are
+// ignored.
+type MemoryTransport struct {
+	network   *MemoryNetwork
+	mu        sync.Mutex
+	listeners []*MemoryListener
+	closed    bool
+}
+
+// NewMemoryTransport creates a memory transport on network.
+func NewMemoryTransport(network *MemoryNetwork) *MemoryTransport {
+	return &MemoryTransport{network: network}
+}
+
+// Type returns the transport type.
+func (t *MemoryTransport) Type() TransportType {
+	return TransportMemory
+}
+
+// Dial connects to a memory listener on the same network.
+func (t *MemoryTransport) Dial(ctx context.Context, addr string, opts DialOptions) (PeerConn, error) {
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		return nil, fmt.Errorf("transport closed")
+	}
+	t.mu.Unlock()
+
+	addr = strings.TrimPrefix(addr, "mem://")
+	t.network.mu.Lock()
+	l := t.network.listeners[addr]
+	t.network.mu.Unlock()
+	if l == nil {
+		return nil, fmt.Errorf("memory dial %s: connection refused", addr)
+	}
+
+	local := memAddr(fmt.Sprintf("client-%d", t.network.nextPort.Add(1)))
+	a2b, b2a := newMemPipe(), newMemPipe()
+	dialer := &MemoryPeerConn{conn: newMemConn(b2a, a2b, local, l.addr), isDialer: true}
+	acceptor := &MemoryPeerConn{conn: newMemConn(a2b, b2a, l.addr, local)}
+
+	select {
+	case l.connCh <- acceptor:
+		return dialer, nil
+	case <-l.closeCh:
+		return nil, fmt.Errorf("memory dial %s: connection refused", addr)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Listen registers a listener under addr on the memory network.
+func (t *MemoryTransport) Listen(addr string, opts ListenOptions) (Listener, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.closed {
+		return nil, fmt.Errorf("transport closed")
+	}
+
+	addr = strings.TrimPrefix(addr, "mem://")
+	l := &MemoryListener{
+		network: t.network,
+		addr:    memAddr(addr),
+		connCh:  make(chan *MemoryPeerConn, 16),
+		closeCh: make(chan struct{}),
+	}
+
+	t.network.mu.Lock()
+	if _, ok := t.network.listeners[addr]; ok {
+		t.network.mu.Unlock()
+		return nil, fmt.Errorf("memory listen %s: address already in use", addr)
+	}
+	t.network.listeners[addr] = l
+	t.network.mu.Unlock()
+
+	t.listeners = append(t.listeners, l)
+	return l, nil
+}
+
+// Close shuts down the transport and all listeners.
+func (t *MemoryTransport) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.closed {
+		return nil
+	}
+	t.closed = true
+	for _, l := range t.listeners {
+		l.Close()
+	}
+	t.listeners = nil
+	return nil
+}
+
+// MemoryListener implements Listener for the memory transport.
+type MemoryListener struct {
+	network *MemoryNetwork
+	addr    memAddr
+	connCh  chan *MemoryPeerConn
+	closeCh chan struct{}
+	closed  atomic.Bool
+}
+
+// Accept waits for and returns the next memory connection.
+func (l *MemoryListener) Accept(ctx context.Context) (PeerConn, error) {
+	select {
+	case conn := <-l.connCh:
+		return conn, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-l.closeCh:
+		return nil, fmt.Errorf("listener closed")
+	}
+}
+
+// Addr returns the listener's address.
+func (l *MemoryListener) Addr() net.Addr {
+	return l.addr
+}
+
+// Close stops the listener and frees its address.
+func (l *MemoryListener) Close() error {
+	if l.closed.Swap(true) {
+		return nil
+	}
+	close(l.closeCh)
+	l.network.mu.Lock()
+	if l.network.listeners[string(l.addr)] == l {
+		delete(l.network.listeners, string(l.addr))
+	}
+	l.network.mu.Unlock()
+	return nil
+}
+
+// MemoryPeerConn implements PeerConn for the memory transport.
+type MemoryPeerConn struct {
+	conn     *memConn
+	isDialer bool
+}
+
+// OpenStream returns the single stream of the connection.
+func (c *MemoryPeerConn) OpenStream(ctx context.Context) (Stream, error) {
+	return c.stream()
+}
+
+// AcceptStream returns the single stream of the connection.
+func (c *MemoryPeerConn) AcceptStream(ctx context.Context) (Stream, error) {
+	return c.stream()
+}
+
+func (c *MemoryPeerConn) stream() (Stream, error) {
+	if c.conn.closed.Load() {
+		return nil, fmt.Errorf("connection closed")
+	}
+	return c.conn, nil
+}
+
+// Close terminates the connection.
+func (c *MemoryPeerConn) Close() error {
+	return c.conn.Close()
+}
+
+// LocalAddr returns the local address.
+func (c *MemoryPeerConn) LocalAddr() net.Addr {
+	return c.conn.local
+}
+
+// RemoteAddr returns the remote address.
+func (c *MemoryPeerConn) RemoteAddr() net.Addr {
+	return c.conn.remote
+}
+
+// IsDialer returns true if this side initiated the connection.
+func (c *MemoryPeerConn) IsDialer() bool {
+	return c.isDialer
+}
+
+// TransportType returns the transport protocol type.
+func (c *MemoryPeerConn) TransportType() TransportType {
+	return TransportMemory
+}
+
+// memAddr is the address of a memory listener or dialer.
+type memAddr string
+
+func (a memAddr) Network() string { return string(TransportMemory) }
+func (a memAddr) String() string  { return string(a) }
+
+// memPipe is one direction of a memory connection: a bounded byte buffer
+// with a writer that can close it (EOF once drained) and a reader that can
+// break it (later writes fail).
+type memPipe struct {
+	mu     sync.Mutex
+	buf    []byte
+	eof    bool
+	broken bool
+
+	// readable and writable are signaled when data or space may be
+	// available, or the pipe was closed
+	readable chan struct{}
+	writable chan struct{}
+}
+
+func newMemPipe() *memPipe {
+	return &memPipe{
+		readable: make(chan struct{}, 1),
+		writable: make(chan struct{}, 1),
+	}
+}
+
+func signal(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// closeWrite marks the end of data from the writer.
+func (p *memPipe) closeWrite() {
+	p.mu.Lock()
+	p.eof = true
+	p.mu.Unlock()
+	signal(p.readable)
+}
+
+// closeRead discards buffered data and fails later writes.
+func (p *memPipe) closeRead() {
+	p.mu.Lock()
+	p.broken = true
+	p.buf = nil
+	p.mu.Unlock()
+	signal(p.writable)
+	signal(p.readable)
+}
+
+// memDeadline is a read or write deadline whose changes wake blocked calls.
+type memDeadline struct {
+	mu      sync.Mutex
+	t       time.Time
+	changed chan struct{}
+}
+
+func newMemDeadline() *memDeadline {
+	return &memDeadline{changed: make(chan struct{})}
+}
+
+func (d *memDeadline) set(t time.Time) {
+	d.mu.Lock()
+	d.t = t
+	close(d.changed)
+	d.changed = make(chan struct{})
+	d.mu.Unlock()
+}
+
+// wait blocks until ready is signaled, the deadline passes, the deadline
+// changes or done is closed. It returns os.ErrDeadlineExceeded on timeout.
+func (d *memDeadline) wait(ready, done <-chan struct{}) error {
+	d.mu.Lock()
+	t, changed := d.t, d.changed
+	d.mu.Unlock()
+
+	var timeout <-chan time.Time
+	if !t.IsZero() {
+		wait := time.Until(t)
+		if wait <= 0 {
+			return os.ErrDeadlineExceeded
+		}
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case <-ready:
+	case <-changed:
+	case <-done:
+	case <-timeout:
+		return os.ErrDeadlineExceeded
+	}
+	return nil
+}
+
+// memConn is one end of a memory connection and its single stream.
+type memConn struct {
+	r, w          *memPipe
+	local, remote memAddr
+	rd, wd        *memDeadline
+	done          chan struct{}
+	closed        atomic.Bool
+	closeOnce     sync.Once
+}
+
+func newMemConn(r, w *memPipe, local, remote memAddr) *memConn {
+	return &memConn{
+		r:      r,
+		w:      w,
+		local:  local,
+		remote: remote,
+		rd:     newMemDeadline(),
+		wd:     newMemDeadline(),
+		done:   make(chan struct{}),
+	}
+}
+
+// StreamID returns the stream ID.
+func (c *memConn) StreamID() uint64 {
+	return 1
+}
+
+// Read reads buffered data, blocking until some is available.
+func (c *memConn) Read(b []byte) (int, error) {
+	for {
+		if c.closed.Load() {
+			return 0, net.ErrClosed
+		}
+		p := c.r
+		p.mu.Lock()
+		if len(p.buf) > 0 {
+			n := copy(b, p.buf)
+			p.buf = p.buf[n:]
+			p.mu.Unlock()
+			signal(p.writable)
+			return n, nil
+		}
+		eof := p.eof
+		p.mu.Unlock()
+		if eof {
+			return 0, io.EOF
+		}
+		if err := c.rd.wait(p.readable, c.done); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// Write buffers b for the other end, blocking while the buffer is full.
+func (c *memConn) Write(b []byte) (int, error) {
+	written := 0
+	for written < len(b) {
+		if c.closed.Load() {
+			return written, net.ErrClosed
+		}
+		p := c.w
+		p.mu.Lock()
+		if p.broken {
+			p.mu.Unlock()
+			return written, io.ErrClosedPipe
+		}
+		if p.eof {
+			p.mu.Unlock()
+			return written, fmt.Errorf("write after close")
+		}
+		if space := memPipeSize - len(p.buf); space > 0 {
+			n := min(space, len(b)-written)
+			p.buf = append(p.buf, b[written:written+n]...)
+			written += n
+			p.mu.Unlock()
+			signal(p.readable)
+			continue
+		}
+		p.mu.Unlock()
+		if err := c.wd.wait(p.writable, c.done); err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// CloseWrite signals the other end that no more data follows.
+func (c *memConn) CloseWrite() error {
+	c.w.closeWrite()
+	return nil
+}
+
+// Close closes both directions.
+func (c *memConn) Close() error {
+	c.closeOnce.Do(func() {
+		c.closed.Store(true)
+		close(c.done)
+		c.w.closeWrite()
+		c.r.closeRead()
+	})
+	return nil
+}
+
+// SetDeadline sets read and write deadlines.
+func (c *memConn) SetDeadline(t time.Time) error {
+	c.rd.set(t)
+	c.wd.set(t)
+	return nil
+}
+
+// SetReadDeadline sets the read deadline.
+func (c *memConn) SetReadDeadline(t time.Time) error {
+	c.rd.set(t)
+	return nil
+}
+
+// SetWriteDeadline sets the write deadline.
+func (c *memConn) SetWriteDeadline(t time.Time) error {
+	c.wd.set(t)
+	return nil
+}
@@ -0,0 +1,166 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+// newMemPair starts a memory listener and dials it.
+func newMemPair(t *testing.T) (client, server PeerConn) {
+	t.Helper()
+
+	network := NewMemoryNetwork()
+	transport := NewMemoryTransport(network)
+	t.Cleanup(func() { transport.Close() })
+
+	listener, err := transport.Listen("node-a", ListenOptions{})
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	client, err = NewMemoryTransport(network).Dial(ctx, "mem://node-a", DialOptions{})
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	server, err = listener.Accept(ctx)
+	if err != nil {
+		t.Fatalf("Accept() error = %v", err)
+	}
+	t.Cleanup(func() { server.Close() })
+	return client, server
+}
+
+func TestMemoryTransport_Stream(t *testing.T) {
+	client, server := newMemPair(t)
+
+	if !client.IsDialer() || server.IsDialer() {
+		t.Errorf("IsDialer() = %v/%v, want true/false", client.IsDialer(), server.IsDialer())
+	}
+	if client.TransportType() != TransportMemory {
+		t.Errorf("TransportType() = %s, want %s", client.TransportType(), TransportMemory)
+	}
+	if client.RemoteAddr().String() != "node-a" {
+		t.Errorf("RemoteAddr() = %s, want node-a", client.RemoteAddr())
+	}
+
+	ctx := context.Background()
+	cs, err := client.OpenStream(ctx)
+	if err != nil {
+		t.Fatalf("OpenStream() error = %v", err)
+	}
+	ss, err := server.AcceptStream(ctx)
+	if err != nil {
+		t.Fatalf("AcceptStream() error = %v", err)
+	}
+
+	// Larger than the pipe buffer, so the writer has to wait for the reader
+	want := bytes.Repeat([]byte("0123456789"), memPipeSize/4)
+	go func() {
+		cs.Write(want)
+		cs.CloseWrite()
+	}()
+
+	got, err := io.ReadAll(ss)
+	if err != nil {
+		t.Fatalf("ReadAll() error = %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("read %d bytes, want %d", len(got), len(want))
+	}
+
+	// The other direction is still open after CloseWrite
+	if _, err := ss.Write([]byte("reply")); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(cs, buf); err != nil || string(buf) != "reply" {
+		t.Errorf("ReadFull() = %q, %v, want reply", buf, err)
+	}
+}
+
+func TestMemoryTransport_Deadline(t *testing.T) {
+	client, _ := newMemPair(t)
+	s, _ := client.OpenStream(context.Background())
+
+	s.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+	if _, err := s.Read(make([]byte, 1)); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("Read() error = %v, want deadline exceeded", err)
+	}
+
+	// Clearing the deadline wakes a blocked read, which keeps waiting
+	s.SetReadDeadline(time.Now().Add(time.Hour))
+	done := make(chan error, 1)
+	go func() {
+		_, err := s.Read(make([]byte, 1))
+		done <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	s.SetReadDeadline(time.Now())
+	select {
+	case err := <-done:
+		if !errors.Is(err, os.ErrDeadlineExceeded) {
+			t.Errorf("Read() error = %v, want deadline exceeded", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("Read() did not return after the deadline moved")
+	}
+}
+
+func TestMemoryTransport_Close(t *testing.T) {
+	client, server := newMemPair(t)
+	cs, _ := client.OpenStream(context.Background())
+	ss, _ := server.AcceptStream(context.Background())
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := ss.Read(make([]byte, 1))
+		done <- err
+	}()
+
+	client.Close()
+	select {
+	case err := <-done:
+		if err != io.EOF {
+			t.Errorf("peer Read() error = %v, want EOF", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("peer Read() did not return after Close")
+	}
+	if _, err := ss.Write([]byte("x")); err == nil {
+		t.Error("Write() to a closed connection succeeded")
+	}
+	if _, err := cs.Read(make([]byte, 1)); err == nil {
+		t.Error("Read() after Close succeeded")
+	}
+}
+
+func TestMemoryTransport_Listen(t *testing.T) {
+	network := NewMemoryNetwork()
+	tr := NewMemoryTransport(network)
+
+	if _, err := tr.Listen("node-a", ListenOptions{}); err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	if _, err := NewMemoryTransport(network).Listen("node-a", ListenOptions{}); err == nil {
+		t.Error("Listen() on a used address succeeded")
+	}
+	if _, err := tr.Dial(context.Background(), "node-b", DialOptions{}); err == nil {
+		t.Error("Dial() to a missing listener succeeded")
+	}
+
+	// Closing the transport frees its addresses
+	tr.Close()
+	if _, err := NewMemoryTransport(network).Listen("node-a", ListenOptions{}); err != nil {
+		t.Errorf("Listen() after Close error = %v", err)
+	}
+}
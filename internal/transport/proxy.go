@@ -0,0 +1,614 @@
+package transport
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+// Proxy URL schemes supported for outbound peer connections.
+// "socks5" resolves peer hostnames locally, "socks5h" lets the proxy resolve them.
+const (
+	proxySchemeHTTP    = "http"
+	proxySchemeHTTPS   = "https"
+	proxySchemeSOCKS5  = "socks5"
+	proxySchemeSOCKS5H = "socks5h"
+)
+
+// SOCKS5 protocol constants (RFC 1928, RFC 1929)
+const (
+	socksVersion          = 0x05
+	socksAuthVersion      = 0x01
+	socksAuthNone         = 0x00
+	socksAuthPassword     = 0x02
+	socksAuthNoAcceptable = 0xFF
+	socksCmdConnect       = 0x01
+	socksCmdUDPAssociate  = 0x03
+	socksAtypIPv4         = 0x01
+	socksAtypDomain       = 0x03
+	socksAtypIPv6         = 0x04
+)
+
+// socksReplyErrors describes SOCKS5 reply codes (RFC 1928 Section 6).
+var socksReplyErrors = map[byte]string{
+	0x01: "general SOCKS server failure",
+	0x02: "connection not allowed by ruleset",
+	0x03: "network unreachable",
+	0x04: "host unreachable",
+	0x05: "connection refused",
+	0x06: "TTL expired",
+	0x07: "command not supported",
+	0x08: "address type not supported",
+}
+
+// parseProxyURL parses opts.ProxyURL. ProxyUsername and ProxyPassword, when
+// set, take precedence over credentials embedded in the URL.
+func parseProxyURL(opts DialOptions) (*url.URL, error) {
+	u, err := url.Parse(opts.ProxyURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy URL: %w", err)
+	}
+	switch u.Scheme {
+	case proxySchemeHTTP, proxySchemeHTTPS, proxySchemeSOCKS5, proxySchemeSOCKS5H:
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q (must be http, https, socks5, or socks5h)", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("invalid proxy URL: missing host")
+	}
+	if opts.ProxyUsername != "" {
+		u.User = url.UserPassword(opts.ProxyUsername, opts.ProxyPassword)
+	}
+	return u, nil
+}
+
+// proxyAddress returns the host:port of the proxy, using the scheme's
+// default port when the URL has none.
+func proxyAddress(u *url.URL) string {
+	port := u.Port()
+	if port == "" {
+		switch u.Scheme {
+		case proxySchemeHTTP:
+			port = "80"
+		case proxySchemeHTTPS:
+			port = "443"
+		default:
+			port = "1080"
+		}
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+// isSOCKSProxy reports whether the proxy URL uses a SOCKS5 scheme.
+func isSOCKSProxy(u *url.URL) bool {
+	return u.Scheme == proxySchemeSOCKS5 || u.Scheme == proxySchemeSOCKS5H
+}
+
+// dialContext opens a TCP connection to addr, through the configured proxy
+// if there is one. With ProxyFallback set, a proxy that cannot be reached or
+// refuses the connection is bypassed and addr is dialed directly.
+func dialContext(ctx context.Context, addr string, opts DialOptions) (net.Conn, error) {
+	var dialer net.Dialer
+	if opts.ProxyURL == "" {
+		return dialer.DialContext(ctx, "tcp", addr)
+	}
+
+	conn, err := dialProxy(ctx, addr, opts)
+	if err != nil && opts.ProxyFallback && ctx.Err() == nil {
+		slog.Warn("proxy connection failed; dialing peer directly",
+			slog.String("addr", addr),
+			slog.String("error", err.Error()))
+		return dialer.DialContext(ctx, "tcp", addr)
+	}
+	return conn, err
+}
+
+// dialTLS opens a TLS connection to addr through dialContext. It uses uTLS
+// when a fingerprint preset is configured. The handshake offers
+// tlsConfig.NextProtos.
+func dialTLS(ctx context.Context, addr string, tlsConfig *tls.Config, opts DialOptions) (tlsConn, error) {
+	if tlsConfig.ServerName == "" {
+		if host, _, err := net.SplitHostPort(addr); err == nil {
+			tlsConfig = tlsConfig.Clone()
+			tlsConfig.ServerName = host
+		}
+	}
+
+	rawConn, err := dialContext(ctx, addr, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if IsFingerprintEnabled(opts.FingerprintPreset) {
+		if deadline, ok := ctx.Deadline(); ok {
+			rawConn.SetDeadline(deadline)
+		}
+		conn, err := handshakeUTLS(rawConn, tlsConfig, opts.FingerprintPreset, tlsConfig.NextProtos)
+		if err != nil {
+			return nil, err
+		}
+		rawConn.SetDeadline(time.Time{})
+		return conn.(*utlsConn), nil
+	}
+
+	conn := tls.Client(rawConn, tlsConfig)
+	if err := conn.HandshakeContext(ctx); err != nil {
+		rawConn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// dialProxy opens a tunnel to addr through the configured HTTP CONNECT or
+// SOCKS5 proxy.
+func dialProxy(ctx context.Context, addr string, opts DialOptions) (net.Conn, error) {
+	u, err := parseProxyURL(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := connectProxy(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+
+	tunnel := conn
+	if isSOCKSProxy(u) {
+		err = socksConnect(ctx, conn, u, addr)
+	} else {
+		tunnel, err = httpConnect(conn, u, addr)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("proxy %s: %w", proxyAddress(u), err)
+	}
+
+	tunnel.SetDeadline(time.Time{})
+	return tunnel, nil
+}
+
+// connectProxy opens the connection to the proxy itself, wrapped in TLS for
+// https proxies. The connection deadline follows ctx until the tunnel is up.
+func connectProxy(ctx context.Context, u *url.URL) (net.Conn, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", proxyAddress(u))
+	if err != nil {
+		return nil, fmt.Errorf("proxy dial failed: %w", err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if u.Scheme == proxySchemeHTTPS {
+		tlsConn := tls.Client(conn, &tls.Config{
+			ServerName: u.Hostname(),
+			MinVersion: tls.VersionTLS12,
+		})
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("proxy TLS handshake failed: %w", err)
+		}
+		conn = tlsConn
+	}
+	return conn, nil
+}
+
+// httpConnect opens a tunnel with an HTTP CONNECT request, using Basic
+// authentication when the proxy URL has credentials.
+func httpConnect(conn net.Conn, u *url.URL, addr string) (net.Conn, error) {
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if u.User != nil {
+		password, _ := u.User.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(u.User.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+	if err := req.Write(conn); err != nil {
+		return nil, fmt.Errorf("CONNECT request failed: %w", err)
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		return nil, fmt.Errorf("CONNECT response failed: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("CONNECT refused: %s", resp.Status)
+	}
+
+	if br.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, r: br}, nil
+	}
+	return conn, nil
+}
+
+// bufferedConn is a net.Conn whose reads drain a bufio.Reader first.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+// Read reads from the buffer, then from the connection.
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+// socksConnect opens a tunnel with a SOCKS5 CONNECT request.
+func socksConnect(ctx context.Context, conn net.Conn, u *url.URL, addr string) error {
+	target, err := socksTargetAddr(ctx, addr, u.Scheme == proxySchemeSOCKS5H)
+	if err != nil {
+		return err
+	}
+	if err := socksAuthenticate(conn, u); err != nil {
+		return err
+	}
+	_, err = socksRequest(conn, socksCmdConnect, target)
+	return err
+}
+
+// socksAuthenticate negotiates the SOCKS5 authentication method, offering
+// username/password authentication when the proxy URL has credentials.
+func socksAuthenticate(conn net.Conn, u *url.URL) error {
+	methods := []byte{socksAuthNone}
+	if u.User != nil {
+		methods = append(methods, socksAuthPassword)
+	}
+	greeting := append([]byte{socksVersion, byte(len(methods))}, methods...)
+	if _, err := conn.Write(greeting); err != nil {
+		return fmt.Errorf("SOCKS greeting failed: %w", err)
+	}
+
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return fmt.Errorf("SOCKS greeting failed: %w", err)
+	}
+	if reply[0] != socksVersion {
+		return fmt.Errorf("unexpected SOCKS version %d", reply[0])
+	}
+
+	switch reply[1] {
+	case socksAuthNone:
+		return nil
+	case socksAuthPassword:
+		if u.User == nil {
+			return fmt.Errorf("SOCKS proxy requires authentication")
+		}
+		username := u.User.Username()
+		password, _ := u.User.Password()
+		if len(username) > 255 || len(password) > 255 {
+			return fmt.Errorf("SOCKS credentials too long")
+		}
+		req := []byte{socksAuthVersion, byte(len(username))}
+		req = append(req, username...)
+		req = append(req, byte(len(password)))
+		req = append(req, password...)
+		if _, err := conn.Write(req); err != nil {
+			return fmt.Errorf("SOCKS authentication failed: %w", err)
+		}
+		if _, err := io.ReadFull(conn, reply); err != nil {
+			return fmt.Errorf("SOCKS authentication failed: %w", err)
+		}
+		if reply[1] != 0x00 {
+			return fmt.Errorf("SOCKS authentication rejected")
+		}
+		return nil
+	case socksAuthNoAcceptable:
+		return fmt.Errorf("SOCKS proxy accepts none of the offered authentication methods")
+	default:
+		return fmt.Errorf("unsupported SOCKS authentication method %d", reply[1])
+	}
+}
+
+// socksRequest sends a SOCKS5 command and returns the bound address from the
+// reply.
+func socksRequest(conn net.Conn, cmd byte, target []byte) (*net.UDPAddr, error) {
+	req := append([]byte{socksVersion, cmd, 0x00}, target...)
+	if _, err := conn.Write(req); err != nil {
+		return nil, fmt.Errorf("SOCKS request failed: %w", err)
+	}
+
+	header := make([]byte, 3)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return nil, fmt.Errorf("SOCKS reply failed: %w", err)
+	}
+	if header[0] != socksVersion {
+		return nil, fmt.Errorf("unexpected SOCKS version %d", header[0])
+	}
+	if header[1] != 0x00 {
+		if msg, ok := socksReplyErrors[header[1]]; ok {
+			return nil, fmt.Errorf("SOCKS request refused: %s", msg)
+		}
+		return nil, fmt.Errorf("SOCKS request refused: reply code %d", header[1])
+	}
+
+	host, port, err := readSocksAddr(conn)
+	if err != nil {
+		return nil, fmt.Errorf("SOCKS reply failed: %w", err)
+	}
+	bound := &net.UDPAddr{IP: net.ParseIP(host), Port: port}
+	if bound.IP == nil {
+		// Domain name in the reply; resolve it for the UDP relay
+		ip, err := net.ResolveIPAddr("ip", host)
+		if err != nil {
+			return nil, fmt.Errorf("SOCKS reply failed: %w", err)
+		}
+		bound.IP = ip.IP
+	}
+	return bound, nil
+}
+
+// socksTargetAddr encodes addr as a SOCKS5 address. Hostnames are passed to
+// the proxy when remoteDNS is set and resolved locally otherwise.
+func socksTargetAddr(ctx context.Context, addr string, remoteDNS bool) ([]byte, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid address %q: %w", addr, err)
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port in %q", addr)
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil && !remoteDNS {
+		ips, err := net.DefaultResolver.LookupIP(ctx, "ip", host)
+		if err != nil {
+			return nil, err
+		}
+		ip = ips[0]
+	}
+
+	var b []byte
+	switch {
+	case ip == nil:
+		if len(host) > 255 {
+			return nil, fmt.Errorf("hostname too long: %s", host)
+		}
+		b = append([]byte{socksAtypDomain, byte(len(host))}, host...)
+	case ip.To4() != nil:
+		b = append([]byte{socksAtypIPv4}, ip.To4()...)
+	default:
+		b = append([]byte{socksAtypIPv6}, ip.To16()...)
+	}
+	return binary.BigEndian.AppendUint16(b, uint16(port)), nil
+}
+
+// readSocksAddr reads a SOCKS5 address (ATYP, address, port).
+func readSocksAddr(r io.Reader) (string, int, error) {
+	atyp := make([]byte, 1)
+	if _, err := io.ReadFull(r, atyp); err != nil {
+		return "", 0, err
+	}
+
+	var host string
+	switch atyp[0] {
+	case socksAtypIPv4, socksAtypIPv6:
+		ip := make(net.IP, net.IPv4len)
+		if atyp[0] == socksAtypIPv6 {
+			ip = make(net.IP, net.IPv6len)
+		}
+		if _, err := io.ReadFull(r, ip); err != nil {
+			return "", 0, err
+		}
+		host = ip.String()
+	case socksAtypDomain:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(r, length); err != nil {
+			return "", 0, err
+		}
+		name := make([]byte, length[0])
+		if _, err := io.ReadFull(r, name); err != nil {
+			return "", 0, err
+		}
+		host = string(name)
+	default:
+		return "", 0, fmt.Errorf("unknown SOCKS address type %d", atyp[0])
+	}
+
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(r, port); err != nil {
+		return "", 0, err
+	}
+	return host, int(binary.BigEndian.Uint16(port)), nil
+}
+
+// dialQUIC opens a QUIC connection to addr. With a SOCKS5 proxy configured,
+// packets travel through the proxy's UDP relay (UDP ASSOCIATE); HTTP proxies
+// cannot carry UDP. ProxyFallback applies as for dialContext.
+func dialQUIC(ctx context.Context, addr string, tlsConfig *tls.Config, quicConfig *quic.Config, opts DialOptions) (*quic.Conn, error) {
+	if opts.ProxyURL == "" {
+		return quic.DialAddr(ctx, addr, tlsConfig, quicConfig)
+	}
+
+	pc, target, err := listenSOCKSPacket(ctx, addr, opts)
+	if err != nil {
+		if opts.ProxyFallback && ctx.Err() == nil {
+			slog.Warn("proxy UDP relay failed; dialing peer directly",
+				slog.String("addr", addr),
+				slog.String("error", err.Error()))
+			return quic.DialAddr(ctx, addr, tlsConfig, quicConfig)
+		}
+		return nil, err
+	}
+
+	// quic.Dial takes the server name from the address, which is now an IP
+	if tlsConfig.ServerName == "" {
+		if host, _, err := net.SplitHostPort(addr); err == nil {
+			tlsConfig = tlsConfig.Clone()
+			tlsConfig.ServerName = host
+		}
+	}
+
+	conn, err := quic.Dial(ctx, pc, target, tlsConfig, quicConfig)
+	if err != nil {
+		pc.Close()
+		return nil, err
+	}
+
+	// quic.Dial does not close a packet conn it did not create
+	go func() {
+		<-conn.Context().Done()
+		pc.Close()
+	}()
+	return conn, nil
+}
+
+// listenSOCKSPacket sets up a SOCKS5 UDP association for sending datagrams
+// to addr and returns a packet conn that tunnels them through the relay,
+// together with the resolved target address.
+func listenSOCKSPacket(ctx context.Context, addr string, opts DialOptions) (net.PacketConn, *net.UDPAddr, error) {
+	u, err := parseProxyURL(opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !isSOCKSProxy(u) {
+		return nil, nil, fmt.Errorf("%s proxies cannot carry UDP; use a socks5 proxy", u.Scheme)
+	}
+
+	// QUIC needs a concrete peer address, so the target is always resolved locally
+	target, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, nil, err
+	}
+	header, err := socksTargetAddr(ctx, target.String(), false)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ctrl, err := connectProxy(ctx, u)
+	if err != nil {
+		return nil, nil, err
+	}
+	fail := func(err error) (net.PacketConn, *net.UDPAddr, error) {
+		ctrl.Close()
+		return nil, nil, fmt.Errorf("proxy %s: %w", proxyAddress(u), err)
+	}
+
+	if err := socksAuthenticate(ctrl, u); err != nil {
+		return fail(err)
+	}
+	relay, err := socksRequest(ctrl, socksCmdUDPAssociate, []byte{socksAtypIPv4, 0, 0, 0, 0, 0, 0})
+	if err != nil {
+		return fail(err)
+	}
+	if relay.IP.IsUnspecified() {
+		relay.IP = ctrl.RemoteAddr().(*net.TCPAddr).IP
+	}
+	ctrl.SetDeadline(time.Time{})
+
+	udpConn, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		return fail(err)
+	}
+
+	pc := &socksPacketConn{
+		conn:   udpConn,
+		ctrl:   ctrl,
+		relay:  relay,
+		target: target,
+		header: append([]byte{0, 0, 0}, header...),
+		buf:    make([]byte, 64*1024),
+	}
+
+	// The association lasts as long as the control connection
+	go func() {
+		io.Copy(io.Discard, ctrl)
+		pc.Close()
+	}()
+	return pc, target, nil
+}
+
+// socksPacketConn is a net.PacketConn that sends every datagram to one target
+// through a SOCKS5 UDP relay. It deliberately does not expose the underlying
+// *net.UDPConn, so quic-go cannot bypass the encapsulation.
+type socksPacketConn struct {
+	conn      *net.UDPConn
+	ctrl      net.Conn
+	relay     *net.UDPAddr
+	target    *net.UDPAddr
+	header    []byte // SOCKS5 UDP request header for target
+	buf       []byte // Read buffer (ReadFrom is not called concurrently)
+	closeOnce sync.Once
+}
+
+// ReadFrom reads the next datagram from the relay and strips its header.
+// Datagrams from other senders and fragments are dropped.
+func (c *socksPacketConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	for {
+		n, from, err := c.conn.ReadFromUDP(c.buf)
+		if err != nil {
+			return 0, nil, err
+		}
+		if !from.IP.Equal(c.relay.IP) || from.Port != c.relay.Port {
+			continue
+		}
+		if n < 4 || c.buf[2] != 0 {
+			continue
+		}
+		r := bytes.NewReader(c.buf[3:n])
+		if _, _, err := readSocksAddr(r); err != nil {
+			continue
+		}
+		return copy(p, c.buf[n-r.Len():n]), c.target, nil
+	}
+}
+
+// WriteTo sends a datagram to the target through the relay. addr is ignored.
+func (c *socksPacketConn) WriteTo(p []byte, _ net.Addr) (int, error) {
+	packet := make([]byte, 0, len(c.header)+len(p))
+	packet = append(packet, c.header...)
+	packet = append(packet, p...)
+	if _, err := c.conn.WriteToUDP(packet, c.relay); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close ends the UDP association.
+func (c *socksPacketConn) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		c.ctrl.Close()
+		err = c.conn.Close()
+	})
+	return err
+}
+
+// LocalAddr returns the local UDP address.
+func (c *socksPacketConn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+// SetDeadline sets read and write deadlines.
+func (c *socksPacketConn) SetDeadline(t time.Time) error {
+	return c.conn.SetDeadline(t)
+}
+
+// SetReadDeadline sets the read deadline.
+func (c *socksPacketConn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+// SetWriteDeadline sets the write deadline.
+func (c *socksPacketConn) SetWriteDeadline(t time.Time) error {
+	return c.conn.SetWriteDeadline(t)
+}
@@ -0,0 +1,383 @@
+package transport
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testProxy is a minimal HTTP CONNECT or SOCKS5 proxy (CONNECT and
+// UDP ASSOCIATE) that counts the tunnels it opens.
+type testProxy struct {
+	ln       net.Listener
+	username string
+	password string
+	tunnels  atomic.Int32
+}
+
+func startTestProxy(t *testing.T, socks bool, username, password string) *testProxy {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	p := &testProxy{ln: ln, username: username, password: password}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			if socks {
+				go p.serveSOCKS(conn)
+			} else {
+				go p.serveHTTP(conn)
+			}
+		}
+	}()
+	return p
+}
+
+func (p *testProxy) url(scheme string) string {
+	return scheme + "://" + p.ln.Addr().String()
+}
+
+func (p *testProxy) serveHTTP(conn net.Conn) {
+	defer conn.Close()
+	req, err := http.ReadRequest(bufio.NewReader(conn))
+	if err != nil || req.Method != http.MethodConnect {
+		return
+	}
+	if p.username != "" {
+		want := "Basic " + base64.StdEncoding.EncodeToString([]byte(p.username+":"+p.password))
+		if req.Header.Get("Proxy-Authorization") != want {
+			io.WriteString(conn, "HTTP/1.1 407 Proxy Authentication Required\r\n\r\n")
+			return
+		}
+	}
+	target, err := net.Dial("tcp", req.Host)
+	if err != nil {
+		io.WriteString(conn, "HTTP/1.1 502 Bad Gateway\r\n\r\n")
+		return
+	}
+	p.tunnels.Add(1)
+	io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+	relay(conn, target)
+}
+
+func (p *testProxy) serveSOCKS(conn net.Conn) {
+	defer conn.Close()
+
+	buf := make([]byte, 512)
+	if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+		return
+	}
+	methods := make([]byte, buf[1])
+	io.ReadFull(conn, methods)
+	if p.username == "" {
+		conn.Write([]byte{socksVersion, socksAuthNone})
+	} else {
+		if !bytes.Contains(methods, []byte{socksAuthPassword}) {
+			conn.Write([]byte{socksVersion, socksAuthNoAcceptable})
+			return
+		}
+		conn.Write([]byte{socksVersion, socksAuthPassword})
+		io.ReadFull(conn, buf[:2])
+		user := make([]byte, buf[1])
+		io.ReadFull(conn, user)
+		io.ReadFull(conn, buf[:1])
+		pass := make([]byte, buf[0])
+		io.ReadFull(conn, pass)
+		if string(user) != p.username || string(pass) != p.password {
+			conn.Write([]byte{socksAuthVersion, 0x01})
+			return
+		}
+		conn.Write([]byte{socksAuthVersion, 0x00})
+	}
+
+	if _, err := io.ReadFull(conn, buf[:3]); err != nil {
+		return
+	}
+	cmd := buf[1]
+	host, port, err := readSocksAddr(conn)
+	if err != nil {
+		return
+	}
+
+	switch cmd {
+	case socksCmdConnect:
+		target, err := net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+		if err != nil {
+			conn.Write([]byte{socksVersion, 0x05, 0, socksAtypIPv4, 0, 0, 0, 0, 0, 0})
+			return
+		}
+		p.tunnels.Add(1)
+		conn.Write([]byte{socksVersion, 0x00, 0, socksAtypIPv4, 0, 0, 0, 0, 0, 0})
+		relay(conn, target)
+	case socksCmdUDPAssociate:
+		udp, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if err != nil {
+			return
+		}
+		defer udp.Close()
+		p.tunnels.Add(1)
+		bound := udp.LocalAddr().(*net.UDPAddr)
+		reply := []byte{socksVersion, 0x00, 0, socksAtypIPv4, 127, 0, 0, 1}
+		conn.Write(binary.BigEndian.AppendUint16(reply, uint16(bound.Port)))
+		go relayUDP(udp)
+		io.Copy(io.Discard, conn)
+	default:
+		conn.Write([]byte{socksVersion, 0x07, 0, socksAtypIPv4, 0, 0, 0, 0, 0, 0})
+	}
+}
+
+// relayUDP forwards datagrams between the single associated client and its
+// targets, adding and stripping SOCKS5 UDP headers.
+func relayUDP(udp *net.UDPConn) {
+	var client *net.UDPAddr
+	buf := make([]byte, 64*1024)
+	for {
+		n, from, err := udp.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		if client == nil || from.String() == client.String() {
+			client = from
+			r := bytes.NewReader(buf[3:n])
+			host, port, err := readSocksAddr(r)
+			if err != nil {
+				continue
+			}
+			target := &net.UDPAddr{IP: net.ParseIP(host), Port: port}
+			udp.WriteToUDP(buf[n-r.Len():n], target)
+			continue
+		}
+		packet := []byte{0, 0, 0, socksAtypIPv4}
+		packet = append(packet, from.IP.To4()...)
+		packet = binary.BigEndian.AppendUint16(packet, uint16(from.Port))
+		udp.WriteToUDP(append(packet, buf[:n]...), client)
+	}
+}
+
+func relay(a, b net.Conn) {
+	defer b.Close()
+	go io.Copy(b, a)
+	io.Copy(a, b)
+}
+
+// dialPair starts a listener on tr and dials it through opts, then checks
+// that a stream works end to end. dialAddr maps the listen address to the
+// dial address; nil dials it as is.
+func dialPair(t *testing.T, tr Transport, path string, dialAddr func(addr string) string, opts DialOptions) error {
+	t.Helper()
+
+	certPEM, keyPEM, err := GenerateSelfSignedCert("localhost", 24*time.Hour)
+	if err != nil {
+		t.Fatalf("GenerateSelfSignedCert() error = %v", err)
+	}
+	serverTLS, err := TLSConfigFromBytes(certPEM, keyPEM)
+	if err != nil {
+		t.Fatalf("TLSConfigFromBytes() error = %v", err)
+	}
+	t.Cleanup(func() { tr.Close() })
+
+	listener, err := tr.Listen("127.0.0.1:0", ListenOptions{TLSConfig: serverTLS, Path: path})
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	acceptCh := make(chan PeerConn, 1)
+	go func() {
+		conn, _ := listener.Accept(ctx)
+		acceptCh <- conn
+	}()
+
+	opts.Timeout = 5 * time.Second
+	addr := listener.Addr().String()
+	if dialAddr != nil {
+		addr = dialAddr(addr)
+	}
+	client, err := tr.Dial(ctx, addr, opts)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	out, err := client.OpenStream(ctx)
+	if err != nil {
+		t.Fatalf("OpenStream() error = %v", err)
+	}
+	if _, err := out.Write([]byte("ping")); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+
+	server := <-acceptCh
+	if server == nil {
+		t.Fatal("Accept() failed")
+	}
+	defer server.Close()
+	in, err := server.AcceptStream(ctx)
+	if err != nil {
+		t.Fatalf("AcceptStream() error = %v", err)
+	}
+	in.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(in, buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("ReadFull() = %q, %v; want %q", buf, err, "ping")
+	}
+	return nil
+}
+
+func TestProxy_TCPThroughHTTPConnect(t *testing.T) {
+	proxy := startTestProxy(t, false, "user", "secret")
+
+	err := dialPair(t, NewTCPTransport(), "", nil, DialOptions{
+		ProxyURL:      proxy.url("http"),
+		ProxyUsername: "user",
+		ProxyPassword: "secret",
+	})
+	if err != nil {
+		t.Fatalf("Dial() through HTTP proxy error = %v", err)
+	}
+	if proxy.tunnels.Load() != 1 {
+		t.Errorf("proxy opened %d tunnels, want 1", proxy.tunnels.Load())
+	}
+
+	// Wrong credentials are refused
+	err = dialPair(t, NewTCPTransport(), "", nil, DialOptions{
+		ProxyURL:      proxy.url("http"),
+		ProxyUsername: "user",
+		ProxyPassword: "wrong",
+	})
+	if err == nil || !strings.Contains(err.Error(), "407") {
+		t.Errorf("Dial() with wrong proxy password error = %v, want 407", err)
+	}
+}
+
+func TestProxy_TCPThroughSOCKS5(t *testing.T) {
+	proxy := startTestProxy(t, true, "user", "secret")
+
+	// Credentials embedded in the URL
+	err := dialPair(t, NewTCPTransport(), "", nil, DialOptions{
+		ProxyURL: "socks5h://user:secret@" + proxy.ln.Addr().String(),
+	})
+	if err != nil {
+		t.Fatalf("Dial() through SOCKS5 proxy error = %v", err)
+	}
+	if proxy.tunnels.Load() != 1 {
+		t.Errorf("proxy opened %d tunnels, want 1", proxy.tunnels.Load())
+	}
+
+	err = dialPair(t, NewTCPTransport(), "", nil, DialOptions{ProxyURL: proxy.url("socks5")})
+	if err == nil {
+		t.Error("Dial() without SOCKS5 credentials succeeded")
+	}
+}
+
+func TestProxy_H2ThroughSOCKS5(t *testing.T) {
+	proxy := startTestProxy(t, true, "", "")
+
+	err := dialPair(t, NewH2Transport(), "/mesh", func(addr string) string {
+		return "https://" + addr + "/mesh"
+	}, DialOptions{ProxyURL: proxy.url("socks5")})
+	if err != nil {
+		t.Fatalf("Dial() through SOCKS5 proxy error = %v", err)
+	}
+	if proxy.tunnels.Load() != 1 {
+		t.Errorf("proxy opened %d tunnels, want 1", proxy.tunnels.Load())
+	}
+}
+
+func TestProxy_QUICThroughSOCKS5(t *testing.T) {
+	proxy := startTestProxy(t, true, "user", "secret")
+
+	err := dialPair(t, NewQUICTransport(), "", nil, DialOptions{
+		ProxyURL:      proxy.url("socks5"),
+		ProxyUsername: "user",
+		ProxyPassword: "secret",
+	})
+	if err != nil {
+		t.Fatalf("Dial() through SOCKS5 UDP relay error = %v", err)
+	}
+	if proxy.tunnels.Load() != 1 {
+		t.Errorf("proxy opened %d associations, want 1", proxy.tunnels.Load())
+	}
+
+	// HTTP proxies cannot carry QUIC
+	httpProxy := startTestProxy(t, false, "", "")
+	err = dialPair(t, NewQUICTransport(), "", nil, DialOptions{ProxyURL: httpProxy.url("http")})
+	if err == nil || !strings.Contains(err.Error(), "cannot carry UDP") {
+		t.Errorf("QUIC Dial() through HTTP proxy error = %v, want UDP error", err)
+	}
+}
+
+func TestProxy_Fallback(t *testing.T) {
+	// A proxy address nothing listens on
+	ln, _ := net.Listen("tcp", "127.0.0.1:0")
+	deadProxy := "socks5://" + ln.Addr().String()
+	ln.Close()
+
+	for _, tc := range []struct {
+		name string
+		tr   func() Transport
+	}{
+		{"tcp", func() Transport { return NewTCPTransport() }},
+		{"quic", func() Transport { return NewQUICTransport() }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := dialPair(t, tc.tr(), "", nil, DialOptions{ProxyURL: deadProxy}); err == nil {
+				t.Error("Dial() through an unreachable proxy succeeded")
+			}
+			err := dialPair(t, tc.tr(), "", nil, DialOptions{ProxyURL: deadProxy, ProxyFallback: true})
+			if err != nil {
+				t.Errorf("Dial() with proxy fallback error = %v", err)
+			}
+		})
+	}
+}
+
+func TestParseProxyURL(t *testing.T) {
+	tests := []struct {
+		url      string
+		wantAddr string
+		wantErr  bool
+	}{
+		{"http://proxy.example.com", "proxy.example.com:80", false},
+		{"https://proxy.example.com", "proxy.example.com:443", false},
+		{"socks5://proxy.example.com", "proxy.example.com:1080", false},
+		{"socks5h://proxy.example.com:9050", "proxy.example.com:9050", false},
+		{"ftp://proxy.example.com", "", true},
+		{"socks5://", "", true},
+	}
+	for _, tt := range tests {
+		u, err := parseProxyURL(DialOptions{ProxyURL: tt.url})
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseProxyURL(%q) error = %v, wantErr %v", tt.url, err, tt.wantErr)
+			continue
+		}
+		if err == nil && proxyAddress(u) != tt.wantAddr {
+			t.Errorf("proxyAddress(%q) = %q, want %q", tt.url, proxyAddress(u), tt.wantAddr)
+		}
+	}
+
+	// Explicit credentials override the URL's
+	u, _ := parseProxyURL(DialOptions{ProxyURL: "socks5://a:b@proxy:1080", ProxyUsername: "c", ProxyPassword: "d"})
+	if pass, _ := u.User.Password(); u.User.Username() != "c" || pass != "d" {
+		t.Errorf("credentials = %s, want c:d", u.User)
+	}
+}
@@ -2,6 +2,7 @@ package transport
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"log/slog"
 	"net"
@@ -71,6 +72,7 @@ func (t *QUICTransport) Dial(ctx context.Context, addr string, opts DialOptions)
 		KeepAlivePeriod:       DefaultKeepAlivePeriod,
 		MaxIncomingStreams:    DefaultMaxIncomingStreams,
 		MaxIncomingUniStreams: 0, // We don't use uni streams
+		Tracer:                opts.QUICTracer,
 	}
 
 	// Apply timeout
@@ -80,7 +82,12 @@ func (t *QUICTransport) Dial(ctx context.Context, addr string, opts DialOptions)
 		defer cancel()
 	}
 
-	conn, err := quic.DialAddr(ctx, addr, tlsConfig, quicConfig)
+	var conn *quic.Conn
+	if l := t.openListener(); l != nil && opts.FromListener && opts.ProxyURL == "" {
+		conn, err = dialFromListener(ctx, l, addr, tlsConfig, quicConfig)
+	} else {
+		conn, err = dialQUIC(ctx, addr, tlsConfig, quicConfig, opts)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("QUIC dial failed: %w", err)
 	}
@@ -125,21 +132,103 @@ func (t *QUICTransport) Listen(addr string, opts ListenOptions) (Listener, error
 		KeepAlivePeriod:       DefaultKeepAlivePeriod,
 		MaxIncomingStreams:    int64(maxStreams),
 		MaxIncomingUniStreams: 0,
+		Tracer:                opts.QUICTracer,
 	}
 
-	listener, err := quic.ListenAddr(addr, tlsConfig, quicConfig)
+	// The listener runs on its own quic.Transport so dials and punch
+	// packets can share its UDP socket
+	udpConn, err := opts.Family.ListenUDP(addr)
 	if err != nil {
 		return nil, fmt.Errorf("QUIC listen failed: %w", err)
 	}
+	tr := &quic.Transport{Conn: udpConn, VerifySourceAddress: opts.VerifySourceAddress}
+	listener, err := tr.Listen(tlsConfig, quicConfig)
+	if err != nil {
+		tr.Close()
+		udpConn.Close()
+		return nil, fmt.Errorf("QUIC listen failed: %w", err)
+	}
 
 	ql := &QUICListener{
 		listener: listener,
+		tr:       tr,
+		udpConn:  udpConn,
+		network:  opts.Family.Network("udp"),
 	}
 	t.listeners = append(t.listeners, ql)
 
 	return ql, nil
 }
 
+// openListener returns the first open listener, or nil if there is none.
+func (t *QUICTransport) openListener() *QUICListener {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, l := range t.listeners {
+		l.mu.Lock()
+		closed := l.closed
+		l.mu.Unlock()
+		if !closed {
+			return l
+		}
+	}
+	return nil
+}
+
+// dialFromListener dials addr from the listener's UDP socket. Hostnames
+// resolve to the socket's family.
+func dialFromListener(ctx context.Context, l *QUICListener, addr string, tlsConfig *tls.Config, quicConfig *quic.Config) (*quic.Conn, error) {
+	udpAddr, err := net.ResolveUDPAddr(l.network, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	// Transport.Dial takes the server name from the config only
+	if tlsConfig.ServerName == "" {
+		if host, _, err := net.SplitHostPort(addr); err == nil {
+			tlsConfig = tlsConfig.Clone()
+			tlsConfig.ServerName = host
+		}
+	}
+
+	return l.tr.Dial(ctx, udpAddr, tlsConfig, quicConfig)
+}
+
+// Punch sends a small non-QUIC datagram to addr from the listener's UDP
+// socket. It opens a mapping in the local NAT so that a peer dialing from
+// addr can reach the listener. The receiver drops the datagram.
+func (t *QUICTransport) Punch(addr string) error {
+	l := t.openListener()
+	if l == nil {
+		return fmt.Errorf("no QUIC listener")
+	}
+	udpAddr, err := net.ResolveUDPAddr(l.network, addr)
+	if err != nil {
+		return err
+	}
+	// quic-go treats a datagram with the two high bits clear as non-QUIC
+	_, err = l.tr.WriteTo([]byte{0}, udpAddr)
+	return err
+}
+
+// ListenAddr returns the local address of the first open listener, or nil
+// if there is none.
+func (t *QUICTransport) ListenAddr() net.Addr {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, l := range t.listeners {
+		l.mu.Lock()
+		closed := l.closed
+		l.mu.Unlock()
+		if !closed {
+			return l.Addr()
+		}
+	}
+	return nil
+}
+
 // Close shuts down the transport and all listeners.
 func (t *QUICTransport) Close() error {
 	t.mu.Lock()
@@ -164,6 +253,9 @@ func (t *QUICTransport) Close() error {
 // QUICListener implements Listener for QUIC.
 type QUICListener struct {
 	listener *quic.Listener
+	tr       *quic.Transport
+	udpConn  *net.UDPConn
+	network  string // "udp", "udp4" or "udp6", for dials from the socket
 	closed   bool
 	mu       sync.Mutex
 }
@@ -196,7 +288,10 @@ func (l *QUICListener) Close() error {
 	}
 	l.closed = true
 
-	return l.listener.Close()
+	err := l.listener.Close()
+	l.tr.Close()
+	l.udpConn.Close()
+	return err
 }
 
 // QUICPeerConn implements PeerConn for QUIC.
@@ -250,6 +345,11 @@ func (c *QUICPeerConn) TransportType() TransportType {
 	return TransportQUIC
 }
 
+// TLSConnectionState returns the TLS state of the QUIC handshake.
+func (c *QUICPeerConn) TLSConnectionState() (tls.ConnectionState, bool) {
+	return c.conn.ConnectionState().TLS, true
+}
+
 // QUICStream implements Stream for QUIC.
 type QUICStream struct {
 	stream *quic.Stream
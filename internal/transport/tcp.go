@@ -0,0 +1,354 @@
+package transport
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// TCP transport constants
+const (
+	tcpHandshakeTimeout = 10 * time.Second
+	tcpKeepAlivePeriod  = 30 * time.Second
+)
+
+// tlsConn is a TLS connection from crypto/tls or uTLS.
+type tlsConn interface {
+	net.Conn
+	ConnectionState() tls.ConnectionState
+}
+
+// TCPTransport implements Transport using plain TLS over TCP.
+// It is the lowest-common-denominator fallback for networks that block UDP
+// and interfere with HTTP upgrades. Like WebSocket, each connection carries
+// a single byte stream; virtual streams are multiplexed over it using our
+// frame protocol (StreamID in frames identifies the stream).
+type TCPTransport struct {
+	mu        sync.Mutex
+	listeners []*TCPListener
+	closed    bool
+}
+
+// NewTCPTransport creates a new TLS/TCP transport.
+func NewTCPTransport() *TCPTransport {
+	return &TCPTransport{}
+}
+
+// Type returns the transport type.
+func (t *TCPTransport) Type() TransportType {
+	return TransportTCP
+}
+
+// Dial connects to a remote peer using TLS over TCP.
+func (t *TCPTransport) Dial(ctx context.Context, addr string, opts DialOptions) (PeerConn, error) {
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		return nil, fmt.Errorf("transport closed")
+	}
+	t.mu.Unlock()
+
+	addr = strings.TrimPrefix(addr, "tcp://")
+
+	// Determine ALPN protocol to use
+	alpn := opts.ALPNProtocol
+	if alpn == "" {
+		alpn = DefaultALPNProtocol
+	}
+
+	tlsConfig, err := prepareTLSConfigForDial(opts.TLSConfig, opts.StrictVerify, []string{alpn})
+	if err != nil {
+		return nil, err
+	}
+
+	// Apply timeout
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+
+	// Dial through the proxy if configured, with uTLS if fingerprinting is enabled
+	conn, err := dialTLS(ctx, addr, tlsConfig, opts)
+	if err != nil {
+		return nil, fmt.Errorf("TCP dial failed: %w", err)
+	}
+
+	return &TCPPeerConn{
+		conn:     conn,
+		isDialer: true,
+	}, nil
+}
+
+// Listen creates a TLS/TCP listener.
+func (t *TCPTransport) Listen(addr string, opts ListenOptions) (Listener, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.closed {
+		return nil, fmt.Errorf("transport closed")
+	}
+
+	tlsConfig := opts.TLSConfig
+	if tlsConfig == nil {
+		return nil, fmt.Errorf("TLS config required for TCP listener")
+	}
+
+	// Determine ALPN protocol to use
+	alpn := opts.ALPNProtocol
+	if alpn == "" {
+		alpn = DefaultALPNProtocol
+	}
+
+	// Clone and set ALPN
+	tlsConfig = tlsConfig.Clone()
+	tlsConfig.NextProtos = []string{alpn}
+
+	lc := net.ListenConfig{KeepAlive: tcpKeepAlivePeriod}
+	netLn, err := lc.Listen(context.Background(), opts.Family.Network("tcp"), addr)
+	if err != nil {
+		return nil, fmt.Errorf("TCP listen failed: %w", err)
+	}
+
+	listener := &TCPListener{
+		netLn:     netLn,
+		tlsConfig: tlsConfig,
+		connCh:    make(chan *TCPPeerConn, 16),
+		closeCh:   make(chan struct{}),
+	}
+	go listener.acceptLoop()
+
+	t.listeners = append(t.listeners, listener)
+	return listener, nil
+}
+
+// Close shuts down the transport and all listeners.
+func (t *TCPTransport) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.closed {
+		return nil
+	}
+	t.closed = true
+
+	var lastErr error
+	for _, l := range t.listeners {
+		if err := l.Close(); err != nil {
+			lastErr = err
+		}
+	}
+	t.listeners = nil
+
+	return lastErr
+}
+
+// TCPListener implements Listener for TLS over TCP.
+type TCPListener struct {
+	netLn     net.Listener
+	tlsConfig *tls.Config
+	connCh    chan *TCPPeerConn
+	closeCh   chan struct{}
+	closed    atomic.Bool
+}
+
+// acceptLoop accepts TCP connections and hands each to a handshake goroutine,
+// so a slow client cannot hold up the others.
+func (l *TCPListener) acceptLoop() {
+	for {
+		conn, err := l.netLn.Accept()
+		if err != nil {
+			if l.closed.Load() || errors.Is(err, net.ErrClosed) {
+				return
+			}
+			// Transient error (e.g. too many open files); back off briefly
+			select {
+			case <-time.After(100 * time.Millisecond):
+				continue
+			case <-l.closeCh:
+				return
+			}
+		}
+		go l.handshake(conn)
+	}
+}
+
+// handshake completes the TLS handshake and queues the connection for Accept.
+func (l *TCPListener) handshake(raw net.Conn) {
+	conn := tls.Server(raw, l.tlsConfig)
+
+	ctx, cancel := context.WithTimeout(context.Background(), tcpHandshakeTimeout)
+	defer cancel()
+	if err := conn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return
+	}
+
+	peerConn := &TCPPeerConn{
+		conn:     conn,
+		isDialer: false,
+	}
+
+	select {
+	case l.connCh <- peerConn:
+	case <-l.closeCh:
+		conn.Close()
+	}
+}
+
+// Accept waits for and returns the next TLS/TCP connection.
+func (l *TCPListener) Accept(ctx context.Context) (PeerConn, error) {
+	select {
+	case conn := <-l.connCh:
+		return conn, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-l.closeCh:
+		return nil, fmt.Errorf("listener closed")
+	}
+}
+
+// Addr returns the listener's address.
+func (l *TCPListener) Addr() net.Addr {
+	return l.netLn.Addr()
+}
+
+// Close stops the listener.
+func (l *TCPListener) Close() error {
+	if l.closed.Swap(true) {
+		return nil
+	}
+
+	close(l.closeCh)
+	return l.netLn.Close()
+}
+
+// TCPPeerConn implements PeerConn for TLS over TCP.
+// The TLS connection is a single bidirectional stream that the peer manager
+// uses as its control stream. All virtual streams are multiplexed over it
+// using our frame protocol.
+type TCPPeerConn struct {
+	conn       tlsConn
+	isDialer   bool
+	streamOnce sync.Once
+	stream     *TCPStream
+	closed     atomic.Bool
+}
+
+// OpenStream returns the single TLS stream.
+func (c *TCPPeerConn) OpenStream(ctx context.Context) (Stream, error) {
+	return c.getStream()
+}
+
+// AcceptStream returns the single TLS stream.
+func (c *TCPPeerConn) AcceptStream(ctx context.Context) (Stream, error) {
+	return c.getStream()
+}
+
+// getStream returns the single stream, creating it on first use.
+func (c *TCPPeerConn) getStream() (Stream, error) {
+	if c.closed.Load() {
+		return nil, fmt.Errorf("connection closed")
+	}
+	c.streamOnce.Do(func() {
+		c.stream = &TCPStream{conn: c.conn, id: 1}
+	})
+	return c.stream, nil
+}
+
+// Close terminates the TCP connection.
+func (c *TCPPeerConn) Close() error {
+	if c.closed.Swap(true) {
+		return nil
+	}
+	return c.conn.Close()
+}
+
+// LocalAddr returns the local address.
+func (c *TCPPeerConn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+// RemoteAddr returns the remote address.
+func (c *TCPPeerConn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// IsDialer returns true if this side initiated the connection.
+func (c *TCPPeerConn) IsDialer() bool {
+	return c.isDialer
+}
+
+// TransportType returns the transport protocol type.
+func (c *TCPPeerConn) TransportType() TransportType {
+	return TransportTCP
+}
+
+// TLSConnectionState returns the TLS state of the connection.
+func (c *TCPPeerConn) TLSConnectionState() (tls.ConnectionState, bool) {
+	return c.conn.ConnectionState(), true
+}
+
+// TCPStream implements Stream for TLS over TCP.
+// It is a thin wrapper around the TLS connection, so deadlines and
+// half-close map directly onto the underlying socket.
+type TCPStream struct {
+	conn   tlsConn
+	id     uint64
+	closed atomic.Bool
+}
+
+// StreamID returns the stream ID.
+func (s *TCPStream) StreamID() uint64 {
+	return s.id
+}
+
+// Read reads data from the stream.
+func (s *TCPStream) Read(p []byte) (int, error) {
+	return s.conn.Read(p)
+}
+
+// Write writes data to the stream.
+func (s *TCPStream) Write(p []byte) (int, error) {
+	if s.closed.Load() {
+		return 0, fmt.Errorf("stream closed")
+	}
+	return s.conn.Write(p)
+}
+
+// CloseWrite sends a TLS close_notify and half-closes the TCP connection.
+func (s *TCPStream) CloseWrite() error {
+	if cw, ok := s.conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return nil
+}
+
+// Close fully closes the stream and the connection.
+func (s *TCPStream) Close() error {
+	if s.closed.Swap(true) {
+		return nil
+	}
+	return s.conn.Close()
+}
+
+// SetDeadline sets read and write deadlines.
+func (s *TCPStream) SetDeadline(t time.Time) error {
+	return s.conn.SetDeadline(t)
+}
+
+// SetReadDeadline sets the read deadline.
+func (s *TCPStream) SetReadDeadline(t time.Time) error {
+	return s.conn.SetReadDeadline(t)
+}
+
+// SetWriteDeadline sets the write deadline.
+func (s *TCPStream) SetWriteDeadline(t time.Time) error {
+	return s.conn.SetWriteDeadline(t)
+}
@@ -0,0 +1,197 @@
+package transport
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+)
+
+// newTCPPair starts a TLS/TCP listener and dials it.
+func newTCPPair(t *testing.T, opts DialOptions) (client, server PeerConn) {
+	t.Helper()
+
+	certPEM, keyPEM, err := GenerateSelfSignedCert("localhost", 24*time.Hour)
+	if err != nil {
+		t.Fatalf("GenerateSelfSignedCert() error = %v", err)
+	}
+	serverTLS, err := TLSConfigFromBytes(certPEM, keyPEM)
+	if err != nil {
+		t.Fatalf("TLSConfigFromBytes() error = %v", err)
+	}
+
+	transport := NewTCPTransport()
+	t.Cleanup(func() { transport.Close() })
+
+	listener, err := transport.Listen("127.0.0.1:0", ListenOptions{TLSConfig: serverTLS})
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	acceptCh := make(chan PeerConn, 1)
+	go func() {
+		conn, err := listener.Accept(ctx)
+		if err != nil {
+			t.Errorf("Accept() error = %v", err)
+		}
+		acceptCh <- conn
+	}()
+
+	opts.Timeout = 5 * time.Second
+	client, err = transport.Dial(ctx, listener.Addr().String(), opts)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	server = <-acceptCh
+	if server == nil {
+		t.FailNow()
+	}
+	t.Cleanup(func() { server.Close() })
+	return client, server
+}
+
+func TestTCPTransport_Type(t *testing.T) {
+	transport := NewTCPTransport()
+	defer transport.Close()
+
+	if transport.Type() != TransportTCP {
+		t.Errorf("Type() = %s, want %s", transport.Type(), TransportTCP)
+	}
+}
+
+func TestTCPTransport_Stream(t *testing.T) {
+	for _, preset := range []string{"", "chrome"} {
+		t.Run("fingerprint="+preset, func(t *testing.T) {
+			client, server := newTCPPair(t, DialOptions{FingerprintPreset: preset})
+
+			if !client.IsDialer() || server.IsDialer() {
+				t.Errorf("IsDialer() = %v/%v, want true/false", client.IsDialer(), server.IsDialer())
+			}
+			if client.TransportType() != TransportTCP {
+				t.Errorf("TransportType() = %s, want %s", client.TransportType(), TransportTCP)
+			}
+			if client.RemoteAddr() == nil || server.RemoteAddr() == nil {
+				t.Error("RemoteAddr() = nil")
+			}
+			if PeerCertificate(client) == nil {
+				t.Error("PeerCertificate() = nil on the dialer")
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			out, err := client.OpenStream(ctx)
+			if err != nil {
+				t.Fatalf("OpenStream() error = %v", err)
+			}
+			in, err := server.AcceptStream(ctx)
+			if err != nil {
+				t.Fatalf("AcceptStream() error = %v", err)
+			}
+
+			// The connection carries a single stream in both directions
+			if again, _ := client.OpenStream(ctx); again != out {
+				t.Error("OpenStream() returned a second stream")
+			}
+
+			msg := "hello over tls"
+			if _, err := out.Write([]byte(msg)); err != nil {
+				t.Fatalf("Write() error = %v", err)
+			}
+			if err := out.CloseWrite(); err != nil {
+				t.Fatalf("CloseWrite() error = %v", err)
+			}
+
+			in.SetReadDeadline(time.Now().Add(5 * time.Second))
+			got, err := io.ReadAll(in)
+			if err != nil {
+				t.Fatalf("ReadAll() error = %v", err)
+			}
+			if string(got) != msg {
+				t.Errorf("received %q, want %q", got, msg)
+			}
+
+			// The other direction still works after the half-close
+			if _, err := in.Write([]byte("reply")); err != nil {
+				t.Fatalf("Write() after peer CloseWrite error = %v", err)
+			}
+			buf := make([]byte, 5)
+			out.SetReadDeadline(time.Now().Add(5 * time.Second))
+			if _, err := io.ReadFull(out, buf); err != nil || string(buf) != "reply" {
+				t.Errorf("ReadFull() = %q, %v; want %q", buf, err, "reply")
+			}
+		})
+	}
+}
+
+func TestTCPTransport_ReadDeadline(t *testing.T) {
+	client, _ := newTCPPair(t, DialOptions{})
+
+	stream, err := client.OpenStream(context.Background())
+	if err != nil {
+		t.Fatalf("OpenStream() error = %v", err)
+	}
+	stream.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if _, err := stream.Read(make([]byte, 1)); err == nil {
+		t.Error("Read() past the deadline succeeded")
+	}
+}
+
+func TestTCPTransport_CloseEndsConnection(t *testing.T) {
+	client, server := newTCPPair(t, DialOptions{})
+
+	ctx := context.Background()
+	in, _ := server.AcceptStream(ctx)
+
+	client.Close()
+
+	in.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := in.Read(make([]byte, 1)); err == nil {
+		t.Error("Read() on a closed connection succeeded")
+	}
+	if _, err := client.OpenStream(ctx); err == nil {
+		t.Error("OpenStream() after Close() succeeded")
+	}
+}
+
+func TestTCPTransport_ALPNMismatch(t *testing.T) {
+	certPEM, keyPEM, _ := GenerateSelfSignedCert("localhost", 24*time.Hour)
+	serverTLS, _ := TLSConfigFromBytes(certPEM, keyPEM)
+
+	transport := NewTCPTransport()
+	defer transport.Close()
+
+	listener, err := transport.Listen("127.0.0.1:0", ListenOptions{TLSConfig: serverTLS})
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := transport.Dial(ctx, listener.Addr().String(), DialOptions{ALPNProtocol: "other"}); err == nil {
+		t.Error("Dial() with a mismatched ALPN protocol succeeded")
+	}
+}
+
+func TestTCPTransport_ListenRequiresTLS(t *testing.T) {
+	transport := NewTCPTransport()
+	defer transport.Close()
+
+	if _, err := transport.Listen("127.0.0.1:0", ListenOptions{}); err == nil {
+		t.Error("Listen() without TLS config succeeded")
+	}
+}
+
+func TestTCPTransport_DialClosed(t *testing.T) {
+	transport := NewTCPTransport()
+	transport.Close()
+
+	if _, err := transport.Dial(context.Background(), "127.0.0.1:4433", DialOptions{}); err == nil {
+		t.Error("Dial() on closed transport succeeded")
+	}
+}
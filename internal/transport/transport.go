@@ -4,10 +4,15 @@ package transport
 import (
 	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"io"
 	"net"
 	"sync/atomic"
 	"time"
+
+	"github.com/postalsys/muti-metroo/internal/ipfamily"
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/qlogwriter"
 )
 
 // TransportType identifies the transport protocol.
@@ -17,6 +22,12 @@ const (
 	TransportQUIC      TransportType = "quic"
 	TransportHTTP2     TransportType = "h2"
 	TransportWebSocket TransportType = "ws"
+
+	// TransportWebTransport is WebTransport over HTTP/3.
+	TransportWebTransport TransportType = "wt"
+
+	// TransportTCP is plain TLS over TCP.
+	TransportTCP TransportType = "tcp"
 )
 
 // Transport creates and accepts peer connections.
@@ -70,6 +81,28 @@ type PeerConn interface {
 	TransportType() TransportType
 }
 
+// TLSStateProvider is implemented by peer connections that can expose the
+// TLS connection state negotiated with the remote side. Plaintext connections
+// (e.g. WebSocket behind a reverse proxy) report ok=false.
+type TLSStateProvider interface {
+	TLSConnectionState() (state tls.ConnectionState, ok bool)
+}
+
+// PeerCertificate returns the leaf certificate presented by the remote side of
+// the connection, or nil if the transport does not expose TLS state or the
+// remote side did not present a certificate.
+func PeerCertificate(conn PeerConn) *x509.Certificate {
+	provider, ok := conn.(TLSStateProvider)
+	if !ok {
+		return nil
+	}
+	state, ok := provider.TLSConnectionState()
+	if !ok || len(state.PeerCertificates) == 0 {
+		return nil
+	}
+	return state.PeerCertificates[0]
+}
+
 // Stream is a bidirectional byte stream with half-close support.
 type Stream interface {
 	io.Reader
@@ -105,7 +138,9 @@ type DialOptions struct {
 	// Timeout is the connection timeout.
 	Timeout time.Duration
 
-	// ProxyURL is the HTTP proxy URL (for WebSocket transport).
+	// ProxyURL is the upstream proxy for the dial: http://, https://,
+	// socks5:// (local DNS) or socks5h:// (proxy DNS). QUIC and WebTransport
+	// need a SOCKS5 proxy, which relays their packets with UDP ASSOCIATE.
 	ProxyURL string
 
 	// ProxyUsername is the proxy authentication username.
@@ -114,6 +149,20 @@ type DialOptions struct {
 	// ProxyPassword is the proxy authentication password.
 	ProxyPassword string
 
+	// ProxyFallback dials the peer directly when the proxy cannot be reached
+	// or refuses the connection.
+	ProxyFallback bool
+
+	// FromListener dials QUIC from the UDP socket of the transport's QUIC
+	// listener instead of a fresh socket, so the peer observes the same
+	// public endpoint that incoming connections use (NAT hole punching).
+	// Ignored when no QUIC listener is running or a proxy is configured.
+	FromListener bool
+
+	// QUICTracer, when set, may return a qlog trace for the connection
+	// (QUIC and WebTransport only).
+	QUICTracer QUICTracer
+
 	// Protocol identifiers for OPSEC customization.
 	// Empty string disables the identifier.
 
@@ -141,7 +190,7 @@ type ListenOptions struct {
 	// TLSConfig is the TLS configuration for the listener.
 	TLSConfig *tls.Config
 
-	// Path is the HTTP path (for HTTP/2 and WebSocket transports).
+	// Path is the HTTP path (for HTTP/2, WebSocket and WebTransport transports).
 	Path string
 
 	// MaxStreams is the maximum number of concurrent streams per connection.
@@ -166,8 +215,25 @@ type ListenOptions struct {
 	// WSSubprotocol is the WebSocket subprotocol identifier.
 	// Default: "muti-metroo/1". Empty string disables subprotocol.
 	WSSubprotocol string
+
+	// VerifySourceAddress, when set, is asked for every new QUIC connection
+	// whether the client must first validate its address with a Retry
+	// packet (QUIC listeners only).
+	VerifySourceAddress func(net.Addr) bool
+
+	// QUICTracer, when set, may return a qlog trace for every accepted
+	// connection (QUIC and WebTransport only).
+	QUICTracer QUICTracer
+
+	// Family selects the IP families the listening socket accepts. Empty
+	// is ipfamily.Dual.
+	Family ipfamily.Family
 }
 
+// QUICTracer returns a qlog trace for a new QUIC connection, or nil to not
+// trace it. See quic.Config.Tracer.
+type QUICTracer func(ctx context.Context, isClient bool, connID quic.ConnectionID) qlogwriter.Trace
+
 // DefaultDialOptions returns DialOptions with sensible defaults.
 func DefaultDialOptions() DialOptions {
 	return DialOptions{
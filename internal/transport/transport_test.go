@@ -647,3 +647,65 @@ func TestQUICListener_Address(t *testing.T) {
 		t.Errorf("Addr() type = %T, want *net.UDPAddr", addr)
 	}
 }
+
+func TestQUICTransport_DialFromListener(t *testing.T) {
+	certPEM, keyPEM, _ := GenerateSelfSignedCert("localhost", 24*time.Hour)
+	serverTLS, _ := TLSConfigFromBytes(certPEM, keyPEM)
+
+	server := NewQUICTransport()
+	defer server.Close()
+	serverLn, err := server.Listen("127.0.0.1:0", ListenOptions{TLSConfig: serverTLS})
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+
+	client := NewQUICTransport()
+	defer client.Close()
+	if err := client.Punch(serverLn.Addr().String()); err == nil {
+		t.Error("Punch() without a listener succeeded")
+	}
+	if client.ListenAddr() != nil {
+		t.Errorf("ListenAddr() = %v without a listener, want nil", client.ListenAddr())
+	}
+
+	clientLn, err := client.Listen("127.0.0.1:0", ListenOptions{TLSConfig: serverTLS})
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	if client.ListenAddr().String() != clientLn.Addr().String() {
+		t.Errorf("ListenAddr() = %v, want %v", client.ListenAddr(), clientLn.Addr())
+	}
+	if err := client.Punch(serverLn.Addr().String()); err != nil {
+		t.Errorf("Punch() error = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for _, fromListener := range []bool{false, true} {
+		acceptCh := make(chan PeerConn, 1)
+		go func() {
+			conn, _ := serverLn.Accept(ctx)
+			acceptCh <- conn
+		}()
+
+		conn, err := client.Dial(ctx, serverLn.Addr().String(), DialOptions{FromListener: fromListener})
+		if err != nil {
+			t.Fatalf("Dial(FromListener=%v) error = %v", fromListener, err)
+		}
+		defer conn.Close()
+
+		accepted := <-acceptCh
+		if accepted == nil {
+			t.Fatal("Accept() failed")
+		}
+		defer accepted.Close()
+
+		// Only a dial from the listener socket shows the listener's address
+		sameSocket := accepted.RemoteAddr().String() == clientLn.Addr().String()
+		if sameSocket != fromListener {
+			t.Errorf("Dial(FromListener=%v) remote address = %v, listener = %v",
+				fromListener, accepted.RemoteAddr(), clientLn.Addr())
+		}
+	}
+}
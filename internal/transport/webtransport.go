@@ -0,0 +1,767 @@
+package transport
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+	"github.com/quic-go/quic-go/quicvarint"
+)
+
+// WebTransport constants
+const (
+	wtDefaultPath = "/mesh"
+
+	// wtProtocol is the :protocol pseudo-header of the Extended CONNECT
+	// request that opens a WebTransport session.
+	wtProtocol = "webtransport"
+
+	// wtDraftHeader announces the WebTransport draft spoken by both sides.
+	// Browsers and most HTTP/3 front ends expect it on the CONNECT response.
+	wtDraftHeader = "Sec-Webtransport-Http3-Draft"
+	wtDraftValue  = "draft02"
+
+	// settingEnableWebTransport is the SETTINGS_ENABLE_WEBTRANSPORT HTTP/3
+	// setting both endpoints must announce.
+	settingEnableWebTransport uint64 = 0x2b603742
+
+	// wtStreamSignal prefixes bidirectional WebTransport streams, followed
+	// by the session ID (the stream ID of the CONNECT request).
+	wtStreamSignal uint64 = 0x41
+
+	// wtSessionWait bounds how long an incoming stream waits for its session
+	// to be registered. The client opens streams as soon as it sees the
+	// CONNECT response, which can race the server-side registration.
+	wtSessionWait = 5 * time.Second
+)
+
+// WebTransportTransport implements Transport using WebTransport over HTTP/3.
+// Each peer connection is a WebTransport session opened with an Extended
+// CONNECT request, and peer streams map to WebTransport bidirectional
+// streams. Unlike the QUIC transport, the connection negotiates the standard
+// "h3" ALPN, so it passes through CDNs and HTTP/3-terminating proxies that
+// reject raw QUIC with a custom ALPN.
+type WebTransportTransport struct {
+	mu        sync.Mutex
+	listeners []*WebTransportListener
+	closed    bool
+}
+
+// NewWebTransportTransport creates a new WebTransport transport.
+func NewWebTransportTransport() *WebTransportTransport {
+	return &WebTransportTransport{}
+}
+
+// Type returns the transport type.
+func (t *WebTransportTransport) Type() TransportType {
+	return TransportWebTransport
+}
+
+// Dial opens a WebTransport session to a remote peer. The address is either
+// a URL (https://host:port/path) or a bare host:port using the default path.
+// Note: like the QUIC transport, TLS fingerprint customization and HTTP
+// proxies are not supported.
+func (t *WebTransportTransport) Dial(ctx context.Context, addr string, opts DialOptions) (PeerConn, error) {
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		return nil, fmt.Errorf("transport closed")
+	}
+	t.mu.Unlock()
+
+	if IsFingerprintEnabled(opts.FingerprintPreset) {
+		slog.Warn("TLS fingerprinting is not supported for WebTransport transport; using standard TLS",
+			slog.String("preset", opts.FingerprintPreset),
+			slog.String("addr", addr))
+	}
+
+	baseURL, path := parseWebTransportAddress(addr)
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid WebTransport address: %w", err)
+	}
+	if u.Scheme != "https" {
+		return nil, fmt.Errorf("WebTransport requires https, got %s", u.Scheme)
+	}
+
+	// HTTP/3 always negotiates "h3"; the custom ALPN travels in the header
+	tlsConfig, err := prepareTLSConfigForDial(opts.TLSConfig, opts.StrictVerify, []string{http3.NextProtoH3})
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig.ServerName == "" {
+		tlsConfig.ServerName = u.Hostname()
+	}
+
+	// Apply timeout
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+
+	conn, err := dialQUIC(ctx, u.Host, tlsConfig, newWebTransportQUICConfig(DefaultMaxIncomingStreams, opts.QUICTracer), opts)
+	if err != nil {
+		return nil, fmt.Errorf("WebTransport dial failed: %w", err)
+	}
+
+	pc, err := openWebTransportSession(ctx, conn, u.Host, path, opts)
+	if err != nil {
+		conn.CloseWithError(0, "")
+		return nil, fmt.Errorf("WebTransport dial failed: %w", err)
+	}
+	return pc, nil
+}
+
+// openWebTransportSession runs the HTTP/3 handshake on a dialed connection
+// and sends the Extended CONNECT request that opens the session.
+func openWebTransportSession(ctx context.Context, conn *quic.Conn, host, path string, opts DialOptions) (*WebTransportPeerConn, error) {
+	h3 := &http3.Transport{
+		EnableDatagrams:    true,
+		AdditionalSettings: map[uint64]uint64{settingEnableWebTransport: 1},
+		DisableCompression: true,
+	}
+	client := h3.NewRawClientConn(conn)
+	go func() {
+		for {
+			str, err := conn.AcceptUniStream(context.Background())
+			if err != nil {
+				return
+			}
+			go client.HandleUnidirectionalStream(str)
+		}
+	}()
+
+	// The server must announce Extended CONNECT and WebTransport support
+	select {
+	case <-client.ReceivedSettings():
+	case <-ctx.Done():
+		return nil, fmt.Errorf("waiting for HTTP/3 settings: %w", ctx.Err())
+	case <-conn.Context().Done():
+		return nil, fmt.Errorf("connection closed during HTTP/3 handshake")
+	}
+	settings := client.Settings()
+	if !settings.EnableExtendedConnect || settings.Other[settingEnableWebTransport] != 1 {
+		return nil, fmt.Errorf("server does not support WebTransport")
+	}
+
+	reqStr, err := client.OpenRequestStream(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("open request stream: %w", err)
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		Proto:  wtProtocol,
+		Host:   host,
+		URL:    &url.URL{Scheme: "https", Host: host, Path: path},
+		Header: http.Header{},
+	}
+	req.Header.Set(wtDraftHeader, wtDraftValue)
+
+	// Set custom protocol header if configured (empty string disables)
+	httpHeader := opts.HTTPHeader
+	if httpHeader == "" {
+		httpHeader = DefaultHTTPHeader
+	}
+	alpnValue := opts.ALPNProtocol
+	if alpnValue == "" {
+		alpnValue = DefaultALPNProtocol
+	}
+	if httpHeader != "" {
+		req.Header.Set(httpHeader, alpnValue)
+	}
+
+	if err := reqStr.SendRequestHeader(req); err != nil {
+		return nil, fmt.Errorf("send CONNECT request: %w", err)
+	}
+
+	// ReadResponse does not take a context; abort the stream on timeout
+	stop := context.AfterFunc(ctx, func() {
+		reqStr.CancelRead(quic.StreamErrorCode(http3.ErrCodeRequestCanceled))
+	})
+	resp, err := reqStr.ReadResponse()
+	stop()
+	if err != nil {
+		return nil, fmt.Errorf("read CONNECT response: %w", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("status %d", resp.StatusCode)
+	}
+
+	pc := newWebTransportPeerConn(conn, reqStr, true)
+	go pc.acceptLoop()
+	return pc, nil
+}
+
+// Listen creates a WebTransport listener.
+func (t *WebTransportTransport) Listen(addr string, opts ListenOptions) (Listener, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.closed {
+		return nil, fmt.Errorf("transport closed")
+	}
+
+	tlsConfig := opts.TLSConfig
+	if tlsConfig == nil {
+		return nil, fmt.Errorf("TLS config required for WebTransport listener")
+	}
+	tlsConfig = tlsConfig.Clone()
+	tlsConfig.NextProtos = []string{http3.NextProtoH3}
+
+	path := opts.Path
+	if path == "" {
+		path = wtDefaultPath
+	}
+
+	// Determine protocol identifiers
+	httpHeader := opts.HTTPHeader
+	if httpHeader == "" {
+		httpHeader = DefaultHTTPHeader
+	}
+	alpnProtocol := opts.ALPNProtocol
+	if alpnProtocol == "" {
+		alpnProtocol = DefaultALPNProtocol
+	}
+
+	maxStreams := opts.MaxStreams
+	if maxStreams <= 0 {
+		maxStreams = DefaultMaxIncomingStreams
+	}
+
+	udpConn, err := opts.Family.ListenUDP(addr)
+	if err != nil {
+		return nil, fmt.Errorf("WebTransport listen failed: %w", err)
+	}
+	tr := &quic.Transport{Conn: udpConn}
+	ql, err := tr.Listen(tlsConfig, newWebTransportQUICConfig(maxStreams, opts.QUICTracer))
+	if err != nil {
+		tr.Close()
+		udpConn.Close()
+		return nil, fmt.Errorf("WebTransport listen failed: %w", err)
+	}
+
+	listener := &WebTransportListener{
+		listener:     ql,
+		tr:           tr,
+		udpConn:      udpConn,
+		path:         path,
+		httpHeader:   httpHeader,
+		alpnProtocol: alpnProtocol,
+		connCh:       make(chan *WebTransportPeerConn, 16),
+		closeCh:      make(chan struct{}),
+	}
+	go listener.acceptLoop()
+
+	t.listeners = append(t.listeners, listener)
+	return listener, nil
+}
+
+// Close shuts down the transport and all listeners.
+func (t *WebTransportTransport) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.closed {
+		return nil
+	}
+	t.closed = true
+
+	var lastErr error
+	for _, l := range t.listeners {
+		if err := l.Close(); err != nil {
+			lastErr = err
+		}
+	}
+	t.listeners = nil
+
+	return lastErr
+}
+
+// newWebTransportQUICConfig returns the QUIC configuration for WebTransport
+// connections. HTTP/3 needs unidirectional streams for its control and QPACK
+// streams and WebTransport requires datagram support to be negotiated.
+func newWebTransportQUICConfig(maxStreams int, tracer QUICTracer) *quic.Config {
+	return &quic.Config{
+		MaxIdleTimeout:     DefaultMaxIdleTimeout,
+		KeepAlivePeriod:    DefaultKeepAlivePeriod,
+		MaxIncomingStreams: int64(maxStreams),
+		EnableDatagrams:    true,
+		Tracer:             tracer,
+	}
+}
+
+// WebTransportListener implements Listener for WebTransport.
+type WebTransportListener struct {
+	listener     *quic.Listener
+	tr           *quic.Transport
+	udpConn      *net.UDPConn
+	path         string
+	httpHeader   string // Custom protocol header name (empty to disable)
+	alpnProtocol string // Protocol identifier value
+	connCh       chan *WebTransportPeerConn
+	closeCh      chan struct{}
+	closed       atomic.Bool
+}
+
+// acceptLoop accepts QUIC connections until the listener is closed.
+func (l *WebTransportListener) acceptLoop() {
+	for {
+		conn, err := l.listener.Accept(context.Background())
+		if err != nil {
+			return
+		}
+		go l.serveConn(conn)
+	}
+}
+
+// serveConn runs HTTP/3 on an accepted connection. A connection can carry
+// several sessions, e.g. when a proxy pools them onto one upstream
+// connection.
+func (l *WebTransportListener) serveConn(conn *quic.Conn) {
+	sc := &wtServerConn{
+		listener:   l,
+		conn:       conn,
+		sessions:   make(map[quic.StreamID]*WebTransportPeerConn),
+		registered: make(chan struct{}),
+	}
+	server := &http3.Server{
+		Handler:            http.HandlerFunc(sc.handleConnect),
+		EnableDatagrams:    true,
+		AdditionalSettings: map[uint64]uint64{settingEnableWebTransport: 1},
+	}
+	raw, err := server.NewRawServerConn(conn)
+	if err != nil {
+		conn.CloseWithError(0, "")
+		return
+	}
+	sc.raw = raw
+
+	go func() {
+		for {
+			str, err := conn.AcceptUniStream(context.Background())
+			if err != nil {
+				return
+			}
+			go raw.HandleUnidirectionalStream(str)
+		}
+	}()
+
+	for {
+		str, err := conn.AcceptStream(context.Background())
+		if err != nil {
+			return
+		}
+		go sc.handleStream(str)
+	}
+}
+
+// wtServerConn is the server side of an HTTP/3 connection carrying
+// WebTransport sessions.
+type wtServerConn struct {
+	listener *WebTransportListener
+	conn     *quic.Conn
+	raw      *http3.RawServerConn
+
+	mu         sync.Mutex
+	sessions   map[quic.StreamID]*WebTransportPeerConn
+	registered chan struct{} // Closed and replaced when a session is added
+}
+
+// handleStream dispatches an incoming bidirectional stream: WebTransport
+// streams go to their session, anything else is an HTTP/3 request.
+func (c *wtServerConn) handleStream(str *quic.Stream) {
+	typ, err := quicvarint.Peek(str)
+	if err != nil {
+		str.CancelRead(quic.StreamErrorCode(http3.ErrCodeRequestIncomplete))
+		str.CancelWrite(quic.StreamErrorCode(http3.ErrCodeRequestIncomplete))
+		return
+	}
+	if typ != wtStreamSignal {
+		c.raw.HandleRequestStream(str)
+		return
+	}
+
+	sessionID, err := readWebTransportHeader(str)
+	if err != nil {
+		str.CancelRead(0)
+		str.CancelWrite(0)
+		return
+	}
+	if sess := c.waitSession(sessionID); sess != nil {
+		sess.deliver(str)
+		return
+	}
+	str.CancelRead(0)
+	str.CancelWrite(0)
+}
+
+// waitSession returns the session with the given ID, waiting up to
+// wtSessionWait for it to be registered. Returns nil if it never is.
+func (c *wtServerConn) waitSession(id quic.StreamID) *WebTransportPeerConn {
+	timer := time.NewTimer(wtSessionWait)
+	defer timer.Stop()
+
+	for {
+		c.mu.Lock()
+		sess, registered := c.sessions[id], c.registered
+		c.mu.Unlock()
+		if sess != nil {
+			return sess
+		}
+
+		select {
+		case <-registered:
+		case <-timer.C:
+			return nil
+		case <-c.conn.Context().Done():
+			return nil
+		}
+	}
+}
+
+// handleConnect accepts Extended CONNECT requests that open a session.
+func (c *wtServerConn) handleConnect(w http.ResponseWriter, r *http.Request) {
+	l := c.listener
+
+	// Check if we're closed
+	if l.closed.Load() {
+		http.Error(w, "server closed", http.StatusServiceUnavailable)
+		return
+	}
+
+	if r.URL.Path != l.path {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodConnect || r.Proto != wtProtocol {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Check protocol header (only if configured)
+	if l.httpHeader != "" {
+		proto := r.Header.Get(l.httpHeader)
+		if proto != "" && proto != l.alpnProtocol {
+			http.Error(w, "unsupported protocol", http.StatusBadRequest)
+			return
+		}
+	}
+
+	streamer, ok := w.(http3.HTTPStreamer)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set(wtDraftHeader, wtDraftValue)
+	// Set protocol header in response (only if configured)
+	if l.httpHeader != "" {
+		w.Header().Set(l.httpHeader, l.alpnProtocol)
+	}
+	w.WriteHeader(http.StatusOK)
+	str := streamer.HTTPStream()
+
+	sess := newWebTransportPeerConn(c.conn, str, false)
+	sess.onClose = func() { c.removeSession(sess.sessionID) }
+	c.addSession(sess)
+
+	// The session ends when the client closes the CONNECT stream
+	go func() {
+		io.Copy(io.Discard, str)
+		sess.Close()
+	}()
+
+	// Send to Accept channel
+	select {
+	case l.connCh <- sess:
+	case <-l.closeCh:
+		sess.Close()
+	}
+}
+
+// addSession registers a session and wakes streams waiting for it.
+func (c *wtServerConn) addSession(sess *WebTransportPeerConn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.sessions[sess.sessionID] = sess
+	close(c.registered)
+	c.registered = make(chan struct{})
+}
+
+// removeSession unregisters a closed session.
+func (c *wtServerConn) removeSession(id quic.StreamID) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.sessions, id)
+}
+
+// Accept waits for and returns the next WebTransport session.
+func (l *WebTransportListener) Accept(ctx context.Context) (PeerConn, error) {
+	select {
+	case conn := <-l.connCh:
+		return conn, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-l.closeCh:
+		return nil, fmt.Errorf("listener closed")
+	}
+}
+
+// Addr returns the listener's address.
+func (l *WebTransportListener) Addr() net.Addr {
+	return l.listener.Addr()
+}
+
+// Close stops the listener.
+func (l *WebTransportListener) Close() error {
+	if l.closed.Swap(true) {
+		return nil
+	}
+
+	close(l.closeCh)
+	err := l.listener.Close()
+	l.tr.Close()
+	l.udpConn.Close()
+	return err
+}
+
+// wtSessionStream is the CONNECT request stream of a session, an
+// *http3.Stream on the server and an *http3.RequestStream on the client.
+type wtSessionStream interface {
+	StreamID() quic.StreamID
+	CancelRead(quic.StreamErrorCode)
+	Close() error
+}
+
+// WebTransportPeerConn implements PeerConn for a WebTransport session.
+type WebTransportPeerConn struct {
+	conn      *quic.Conn
+	session   wtSessionStream
+	sessionID quic.StreamID
+	isDialer  bool
+	onClose   func() // Unregisters the session on the server side
+
+	streamCh  chan *quic.Stream
+	closeCh   chan struct{}
+	closeOnce sync.Once
+
+	mu      sync.Mutex
+	streams map[*quic.Stream]struct{} // Open streams, reset when the session closes
+}
+
+// newWebTransportPeerConn creates a session on an established CONNECT stream.
+func newWebTransportPeerConn(conn *quic.Conn, session wtSessionStream, isDialer bool) *WebTransportPeerConn {
+	return &WebTransportPeerConn{
+		conn:      conn,
+		session:   session,
+		sessionID: session.StreamID(),
+		isDialer:  isDialer,
+		streamCh:  make(chan *quic.Stream, 16),
+		closeCh:   make(chan struct{}),
+		streams:   make(map[*quic.Stream]struct{}),
+	}
+}
+
+// acceptLoop hands streams opened by the server to the client session. The
+// dialer owns the QUIC connection, so every stream belongs to its session.
+func (c *WebTransportPeerConn) acceptLoop() {
+	for {
+		str, err := c.conn.AcceptStream(context.Background())
+		if err != nil {
+			c.Close()
+			return
+		}
+		go func() {
+			id, err := readWebTransportHeader(str)
+			if err != nil || id != c.sessionID {
+				str.CancelRead(0)
+				str.CancelWrite(0)
+				return
+			}
+			c.deliver(str)
+		}()
+	}
+}
+
+// deliver queues an incoming stream for AcceptStream.
+func (c *WebTransportPeerConn) deliver(str *quic.Stream) {
+	if !c.track(str) {
+		return
+	}
+	select {
+	case c.streamCh <- str:
+	case <-c.closeCh:
+	}
+}
+
+// track records an open stream. Returns false and resets the stream if the
+// session is already closed.
+func (c *WebTransportPeerConn) track(str *quic.Stream) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.streams == nil {
+		str.CancelRead(0)
+		str.CancelWrite(0)
+		return false
+	}
+	c.streams[str] = struct{}{}
+	return true
+}
+
+// untrack forgets a stream closed by its owner.
+func (c *WebTransportPeerConn) untrack(str *quic.Stream) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.streams, str)
+}
+
+// OpenStream creates a new outgoing WebTransport stream.
+func (c *WebTransportPeerConn) OpenStream(ctx context.Context) (Stream, error) {
+	select {
+	case <-c.closeCh:
+		return nil, fmt.Errorf("session closed")
+	default:
+	}
+
+	str, err := c.conn.OpenStreamSync(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open WebTransport stream: %w", err)
+	}
+	header := quicvarint.Append(nil, wtStreamSignal)
+	header = quicvarint.Append(header, uint64(c.sessionID))
+	if _, err := str.Write(header); err != nil {
+		str.CancelRead(0)
+		str.CancelWrite(0)
+		return nil, fmt.Errorf("failed to open WebTransport stream: %w", err)
+	}
+	if !c.track(str) {
+		return nil, fmt.Errorf("session closed")
+	}
+
+	return &WebTransportStream{QUICStream: QUICStream{stream: str}, session: c}, nil
+}
+
+// AcceptStream waits for an incoming WebTransport stream.
+func (c *WebTransportPeerConn) AcceptStream(ctx context.Context) (Stream, error) {
+	select {
+	case str := <-c.streamCh:
+		return &WebTransportStream{QUICStream: QUICStream{stream: str}, session: c}, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-c.closeCh:
+		return nil, fmt.Errorf("session closed")
+	}
+}
+
+// Close ends the session and resets its streams. The dialer also closes
+// the QUIC connection; the listener leaves it to other sessions.
+func (c *WebTransportPeerConn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closeCh)
+
+		c.mu.Lock()
+		streams := c.streams
+		c.streams = nil
+		c.mu.Unlock()
+		for str := range streams {
+			str.CancelRead(0)
+			str.CancelWrite(0)
+		}
+
+		c.session.CancelRead(0)
+		c.session.Close()
+		if c.onClose != nil {
+			c.onClose()
+		}
+		if c.isDialer {
+			c.conn.CloseWithError(0, "connection closed")
+		}
+	})
+	return nil
+}
+
+// LocalAddr returns the local address.
+func (c *WebTransportPeerConn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+// RemoteAddr returns the remote address.
+func (c *WebTransportPeerConn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// IsDialer returns true if this side initiated the connection.
+func (c *WebTransportPeerConn) IsDialer() bool {
+	return c.isDialer
+}
+
+// TransportType returns the transport protocol type.
+func (c *WebTransportPeerConn) TransportType() TransportType {
+	return TransportWebTransport
+}
+
+// TLSConnectionState returns the TLS state of the HTTP/3 connection. Behind
+// an HTTP/3-terminating proxy this is the proxy's TLS session.
+func (c *WebTransportPeerConn) TLSConnectionState() (tls.ConnectionState, bool) {
+	return c.conn.ConnectionState().TLS, true
+}
+
+// WebTransportStream implements Stream for a WebTransport stream.
+type WebTransportStream struct {
+	QUICStream
+	session *WebTransportPeerConn
+}
+
+// Close fully closes the stream.
+func (s *WebTransportStream) Close() error {
+	s.session.untrack(s.stream)
+	return s.QUICStream.Close()
+}
+
+// readWebTransportHeader consumes the stream signal and session ID that
+// start a bidirectional WebTransport stream.
+func readWebTransportHeader(str *quic.Stream) (quic.StreamID, error) {
+	r := quicvarint.NewReader(str)
+	typ, err := quicvarint.Read(r)
+	if err != nil {
+		return 0, err
+	}
+	if typ != wtStreamSignal {
+		return 0, fmt.Errorf("unexpected stream type 0x%x", typ)
+	}
+	id, err := quicvarint.Read(r)
+	if err != nil {
+		return 0, err
+	}
+	return quic.StreamID(id), nil
+}
+
+// parseWebTransportAddress parses the address into the base URL and path.
+// Bare addresses (host:port or host:port/path) use https.
+func parseWebTransportAddress(addr string) (baseURL, path string) {
+	scheme, rest := "https://", addr
+	if i := strings.Index(addr, "://"); i >= 0 {
+		scheme, rest = addr[:i+3], addr[i+3:]
+	}
+	if i := strings.Index(rest, "/"); i >= 0 {
+		return scheme + rest[:i], rest[i:]
+	}
+	return scheme + rest, wtDefaultPath
+}
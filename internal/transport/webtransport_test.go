@@ -0,0 +1,229 @@
+package transport
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go/http3"
+)
+
+// newWebTransportPair starts a WebTransport listener and dials it.
+func newWebTransportPair(t *testing.T) (client, server PeerConn) {
+	t.Helper()
+
+	certPEM, keyPEM, err := GenerateSelfSignedCert("localhost", 24*time.Hour)
+	if err != nil {
+		t.Fatalf("GenerateSelfSignedCert() error = %v", err)
+	}
+	serverTLS, err := TLSConfigFromBytes(certPEM, keyPEM)
+	if err != nil {
+		t.Fatalf("TLSConfigFromBytes() error = %v", err)
+	}
+
+	transport := NewWebTransportTransport()
+	t.Cleanup(func() { transport.Close() })
+
+	listener, err := transport.Listen("127.0.0.1:0", ListenOptions{
+		TLSConfig: serverTLS,
+		Path:      "/mesh",
+	})
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	acceptCh := make(chan PeerConn, 1)
+	go func() {
+		conn, err := listener.Accept(ctx)
+		if err != nil {
+			t.Errorf("Accept() error = %v", err)
+		}
+		acceptCh <- conn
+	}()
+
+	client, err = transport.Dial(ctx, "https://"+listener.Addr().String()+"/mesh", DialOptions{
+		Timeout: 5 * time.Second,
+	})
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	server = <-acceptCh
+	if server == nil {
+		t.FailNow()
+	}
+	t.Cleanup(func() { server.Close() })
+	return client, server
+}
+
+// echoStream writes msg on a stream and checks the peer sees it.
+func echoStream(t *testing.T, from, to PeerConn, msg string) {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	out, err := from.OpenStream(ctx)
+	if err != nil {
+		t.Fatalf("OpenStream() error = %v", err)
+	}
+	defer out.Close()
+	if _, err := out.Write([]byte(msg)); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	out.CloseWrite()
+
+	in, err := to.AcceptStream(ctx)
+	if err != nil {
+		t.Fatalf("AcceptStream() error = %v", err)
+	}
+	defer in.Close()
+	in.SetReadDeadline(time.Now().Add(5 * time.Second))
+	got, err := io.ReadAll(in)
+	if err != nil {
+		t.Fatalf("ReadAll() error = %v", err)
+	}
+	if string(got) != msg {
+		t.Errorf("received %q, want %q", got, msg)
+	}
+}
+
+func TestWebTransportTransport_Type(t *testing.T) {
+	transport := NewWebTransportTransport()
+	defer transport.Close()
+
+	if transport.Type() != TransportWebTransport {
+		t.Errorf("Type() = %s, want %s", transport.Type(), TransportWebTransport)
+	}
+}
+
+func TestWebTransportTransport_Streams(t *testing.T) {
+	client, server := newWebTransportPair(t)
+
+	if !client.IsDialer() || server.IsDialer() {
+		t.Errorf("IsDialer() = %v/%v, want true/false", client.IsDialer(), server.IsDialer())
+	}
+	if client.TransportType() != TransportWebTransport {
+		t.Errorf("TransportType() = %s, want %s", client.TransportType(), TransportWebTransport)
+	}
+	if PeerCertificate(client) == nil {
+		t.Error("PeerCertificate() = nil on the dialer")
+	}
+
+	echoStream(t, client, server, "hello from client")
+	echoStream(t, server, client, "hello from server")
+	echoStream(t, client, server, "second stream")
+}
+
+func TestWebTransportTransport_CloseEndsSession(t *testing.T) {
+	client, server := newWebTransportPair(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	stream, err := server.OpenStream(ctx)
+	if err != nil {
+		t.Fatalf("OpenStream() error = %v", err)
+	}
+	stream.Write([]byte("x"))
+	if _, err := client.AcceptStream(ctx); err != nil {
+		t.Fatalf("AcceptStream() error = %v", err)
+	}
+
+	client.Close()
+
+	// The server session ends once the client is gone
+	stream.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := stream.Read(make([]byte, 1)); err == nil {
+		t.Error("Read() on a closed session succeeded")
+	}
+	if _, err := server.AcceptStream(ctx); err == nil {
+		t.Error("AcceptStream() on a closed session succeeded")
+	}
+	if _, err := client.OpenStream(ctx); err == nil {
+		t.Error("OpenStream() after Close() succeeded")
+	}
+}
+
+func TestWebTransportTransport_PlainHTTP3(t *testing.T) {
+	certPEM, keyPEM, _ := GenerateSelfSignedCert("localhost", 24*time.Hour)
+	serverTLS, _ := TLSConfigFromBytes(certPEM, keyPEM)
+
+	transport := NewWebTransportTransport()
+	defer transport.Close()
+
+	listener, err := transport.Listen("127.0.0.1:0", ListenOptions{TLSConfig: serverTLS})
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+
+	// Ordinary HTTP/3 requests get a regular response
+	client := &http.Client{
+		Transport: &http3.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
+		Timeout:   5 * time.Second,
+	}
+	defer client.Transport.(*http3.Transport).Close()
+
+	for _, path := range []string{"/", "/mesh"} {
+		resp, err := client.Get("https://" + listener.Addr().String() + path)
+		if err != nil {
+			t.Fatalf("GET %s error = %v", path, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusOK {
+			t.Errorf("GET %s status = %d, want an error status", path, resp.StatusCode)
+		}
+	}
+
+	// A session on another path is refused
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := transport.Dial(ctx, listener.Addr().String()+"/other", DialOptions{}); err == nil {
+		t.Error("Dial() to an unknown path succeeded")
+	}
+}
+
+func TestWebTransportTransport_ListenRequiresTLS(t *testing.T) {
+	transport := NewWebTransportTransport()
+	defer transport.Close()
+
+	if _, err := transport.Listen("127.0.0.1:0", ListenOptions{}); err == nil {
+		t.Error("Listen() without TLS config succeeded")
+	}
+}
+
+func TestWebTransportTransport_DialClosed(t *testing.T) {
+	transport := NewWebTransportTransport()
+	transport.Close()
+
+	if _, err := transport.Dial(context.Background(), "127.0.0.1:4433", DialOptions{}); err == nil {
+		t.Error("Dial() on closed transport succeeded")
+	}
+}
+
+func TestParseWebTransportAddress(t *testing.T) {
+	tests := []struct {
+		addr     string
+		wantBase string
+		wantPath string
+	}{
+		{"relay.example.com:443", "https://relay.example.com:443", "/mesh"},
+		{"relay.example.com:443/tunnel", "https://relay.example.com:443", "/tunnel"},
+		{"https://relay.example.com/mesh/v1", "https://relay.example.com", "/mesh/v1"},
+		{"https://relay.example.com:8443", "https://relay.example.com:8443", "/mesh"},
+	}
+	for _, tt := range tests {
+		base, path := parseWebTransportAddress(tt.addr)
+		if base != tt.wantBase || path != tt.wantPath {
+			t.Errorf("parseWebTransportAddress(%q) = %q, %q; want %q, %q",
+				tt.addr, base, path, tt.wantBase, tt.wantPath)
+		}
+	}
+}
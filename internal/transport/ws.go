@@ -7,12 +7,12 @@ import (
 	"io"
 	"net"
 	"net/http"
-	"net/url"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/postalsys/muti-metroo/internal/ipfamily"
 	"nhooyr.io/websocket"
 )
 
@@ -80,7 +80,7 @@ func (t *WebSocketTransport) Dial(ctx context.Context, addr string, opts DialOpt
 	dialOpts.HTTPClient = httpClient
 
 	// Dial WebSocket
-	conn, _, err := websocket.Dial(ctx, wsURL, dialOpts)
+	conn, resp, err := websocket.Dial(ctx, wsURL, dialOpts)
 	if err != nil {
 		return nil, fmt.Errorf("WebSocket dial failed: %w", err)
 	}
@@ -88,10 +88,14 @@ func (t *WebSocketTransport) Dial(ctx context.Context, addr string, opts DialOpt
 	// Configure connection
 	conn.SetReadLimit(wsDefaultReadLimit)
 
-	return &WebSocketPeerConn{
+	peerConn := &WebSocketPeerConn{
 		conn:     conn,
 		isDialer: true,
-	}, nil
+	}
+	if resp != nil {
+		peerConn.tlsState = resp.TLS
+	}
+	return peerConn, nil
 }
 
 // Listen creates a WebSocket listener.
@@ -121,6 +125,7 @@ func (t *WebSocketTransport) Listen(addr string, opts ListenOptions) (Listener,
 
 	listener := &WebSocketListener{
 		addr:          addr,
+		family:        opts.Family,
 		path:          path,
 		tlsConfig:     tlsConfig,
 		wsSubprotocol: wsSubprotocol,
@@ -161,6 +166,7 @@ func (t *WebSocketTransport) Close() error {
 // WebSocketListener implements Listener for WebSocket.
 type WebSocketListener struct {
 	addr          string
+	family        ipfamily.Family
 	path          string
 	tlsConfig     *tls.Config
 	wsSubprotocol string // WebSocket subprotocol (empty to disable)
@@ -184,7 +190,7 @@ func (l *WebSocketListener) start() error {
 	}
 
 	// Create TCP listener
-	ln, err := net.Listen("tcp", l.addr)
+	ln, err := l.family.Listen(l.addr)
 	if err != nil {
 		return fmt.Errorf("listen failed: %w", err)
 	}
@@ -226,6 +232,7 @@ func (l *WebSocketListener) handleWebSocket(w http.ResponseWriter, r *http.Reque
 	peerConn := &WebSocketPeerConn{
 		conn:     conn,
 		isDialer: false,
+		tlsState: r.TLS,
 	}
 
 	// Send to Accept channel
@@ -281,6 +288,7 @@ func (l *WebSocketListener) Close() error {
 type WebSocketPeerConn struct {
 	conn       *websocket.Conn
 	isDialer   bool
+	tlsState   *tls.ConnectionState // nil for plaintext connections
 	streamOnce sync.Once
 	stream     *WebSocketStream
 	closed     atomic.Bool
@@ -340,6 +348,15 @@ func (c *WebSocketPeerConn) TransportType() TransportType {
 	return TransportWebSocket
 }
 
+// TLSConnectionState returns the TLS state of the WebSocket connection.
+// Plaintext connections (reverse proxy mode) report ok=false.
+func (c *WebSocketPeerConn) TLSConnectionState() (tls.ConnectionState, bool) {
+	if c.tlsState == nil {
+		return tls.ConnectionState{}, false
+	}
+	return *c.tlsState, true
+}
+
 // WebSocketStream implements Stream for WebSocket.
 // It wraps the WebSocket connection as a stream using binary messages.
 type WebSocketStream struct {
@@ -470,35 +487,24 @@ func buildHTTPClient(opts DialOptions) (*http.Client, error) {
 
 	transport := &http.Transport{}
 
-	// Use uTLS for fingerprinting if enabled
-	if IsFingerprintEnabled(opts.FingerprintPreset) {
+	// Use our own dialer for uTLS fingerprinting and upstream proxies. The
+	// proxy tunnel is opened with CONNECT (or SOCKS5) for wss:// and ws://.
+	if opts.ProxyURL != "" {
+		if _, err := parseProxyURL(opts); err != nil {
+			return nil, err
+		}
+	}
+	if IsFingerprintEnabled(opts.FingerprintPreset) || opts.ProxyURL != "" {
+		transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return dialContext(ctx, addr, opts)
+		}
 		transport.DialTLSContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
-			conn, err := DialUTLS(ctx, network, addr, tlsConfig, opts.FingerprintPreset)
-			if err != nil {
-				return nil, err
-			}
-			if conn == nil {
-				// Fallback to standard TLS (shouldn't happen if IsFingerprintEnabled returned true)
-				return tls.Dial(network, addr, tlsConfig)
-			}
-			return conn, nil
+			return dialTLS(ctx, addr, tlsConfig, opts)
 		}
 	} else {
 		transport.TLSClientConfig = tlsConfig
 	}
 
-	// Configure proxy if specified
-	if opts.ProxyURL != "" {
-		proxyURL, err := url.Parse(opts.ProxyURL)
-		if err == nil {
-			// Add proxy authentication if provided
-			if opts.ProxyUsername != "" {
-				proxyURL.User = url.UserPassword(opts.ProxyUsername, opts.ProxyPassword)
-			}
-			transport.Proxy = http.ProxyURL(proxyURL)
-		}
-	}
-
 	return &http.Client{
 		Transport: transport,
 		Timeout:   opts.Timeout,
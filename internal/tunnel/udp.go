@@ -0,0 +1,416 @@
+// Package tunnel implements plain port tunnels: local listeners whose
+// traffic is carried through the mesh to one fixed destination, for
+// applications that cannot use the SOCKS5 proxy.
+package tunnel
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/postalsys/muti-metroo/internal/ipfamily"
+	"github.com/postalsys/muti-metroo/internal/logging"
+	"github.com/postalsys/muti-metroo/internal/protocol"
+	"github.com/postalsys/muti-metroo/internal/recovery"
+)
+
+const (
+	// DefaultUDPIdleTimeout is how long a client session lasts without
+	// datagrams in either direction.
+	DefaultUDPIdleTimeout = 60 * time.Second
+
+	// udpQueueSize is the number of datagrams buffered per session while
+	// its mesh path opens. Datagrams beyond it are dropped, as UDP would.
+	udpQueueSize = 64
+)
+
+// ReplyWriter receives the datagrams the destination sends back.
+type ReplyWriter interface {
+	// WriteToClient delivers a reply from addr:port to the client.
+	WriteToClient(addrType byte, addr []byte, port uint16, data []byte) error
+}
+
+// Relay carries the datagrams of UDP tunnel sessions through the mesh.
+// Implemented by the agent.
+type Relay interface {
+	// OpenUDPSession creates a mesh UDP association whose replies are
+	// written to client. Mesh paths are opened by the first datagram.
+	OpenUDPSession(client ReplyWriter) (id uint64, err error)
+
+	// RelayUDPDatagram sends a datagram of a session to its destination.
+	RelayUDPDatagram(id uint64, destAddr net.Addr, destPort uint16, addrType byte, rawAddr []byte, data []byte) error
+
+	// CloseUDPAssociation closes a session's mesh association.
+	CloseUDPAssociation(id uint64)
+}
+
+// UDPListenerConfig holds UDP tunnel listener configuration.
+type UDPListenerConfig struct {
+	// Address is the local UDP address to listen on.
+	Address string
+
+	// Family selects the IP families of the socket (empty = dual-stack).
+	Family ipfamily.Family
+
+	// Target is the destination host:port all datagrams are sent to.
+	Target string
+
+	// IdleTimeout ends client sessions without traffic
+	// (0 = DefaultUDPIdleTimeout).
+	IdleTimeout time.Duration
+
+	// MaxSessions limits concurrent client sessions (0 = unlimited).
+	MaxSessions int
+
+	// Logger for logging.
+	Logger *slog.Logger
+}
+
+// UDPListener forwards the datagrams it receives to a fixed target through
+// the mesh. Each client source address gets its own session, so replies
+// reach the client that caused them.
+type UDPListener struct {
+	cfg    UDPListenerConfig
+	relay  Relay
+	logger *slog.Logger
+
+	conn     *net.UDPConn
+	addrType byte
+	rawAddr  []byte
+	port     uint16
+	destAddr *net.UDPAddr
+
+	mu       sync.Mutex
+	sessions map[string]*udpSession
+
+	dropped  atomic.Uint64
+	running  atomic.Bool
+	stopOnce sync.Once
+	stopCh   chan struct{}
+	wg       sync.WaitGroup
+}
+
+// udpSession is the traffic of one client source address.
+type udpSession struct {
+	l          *UDPListener
+	key        string
+	client     *net.UDPAddr
+	id         uint64
+	queue      chan []byte
+	done       chan struct{}
+	closeOnce  sync.Once
+	lastActive atomic.Int64 // Unix nanoseconds
+}
+
+// NewUDPListener creates a new UDP tunnel listener.
+func NewUDPListener(cfg UDPListenerConfig, relay Relay) *UDPListener {
+	if cfg.Logger == nil {
+		cfg.Logger = logging.NopLogger()
+	}
+	if cfg.IdleTimeout <= 0 {
+		cfg.IdleTimeout = DefaultUDPIdleTimeout
+	}
+	return &UDPListener{
+		cfg:      cfg,
+		relay:    relay,
+		logger:   cfg.Logger,
+		sessions: make(map[string]*udpSession),
+		stopCh:   make(chan struct{}),
+	}
+}
+
+// Start binds the listener and starts forwarding.
+func (l *UDPListener) Start() error {
+	if l.running.Load() {
+		return fmt.Errorf("listener already running")
+	}
+
+	addrType, rawAddr, port, err := parseTarget(l.cfg.Target)
+	if err != nil {
+		return err
+	}
+	l.addrType, l.rawAddr, l.port = addrType, rawAddr, port
+	l.destAddr = &net.UDPAddr{Port: int(port)}
+	if addrType != protocol.AddrTypeDomain {
+		l.destAddr.IP = net.IP(rawAddr)
+	}
+
+	conn, err := l.cfg.Family.ListenUDP(l.cfg.Address)
+	if err != nil {
+		return fmt.Errorf("listen on %s: %w", l.cfg.Address, err)
+	}
+	l.conn = conn
+	l.running.Store(true)
+
+	l.wg.Add(2)
+	go l.readLoop()
+	go l.idleLoop()
+
+	l.logger.Info("UDP tunnel listener started",
+		"address", conn.LocalAddr().String(),
+		"target", l.cfg.Target)
+
+	return nil
+}
+
+// Stop closes the listener and all client sessions.
+func (l *UDPListener) Stop() error {
+	var err error
+	l.stopOnce.Do(func() {
+		l.running.Store(false)
+		close(l.stopCh)
+
+		if l.conn != nil {
+			err = l.conn.Close()
+		}
+
+		l.mu.Lock()
+		sessions := make([]*udpSession, 0, len(l.sessions))
+		for _, s := range l.sessions {
+			sessions = append(sessions, s)
+		}
+		l.mu.Unlock()
+		for _, s := range sessions {
+			s.close()
+		}
+
+		l.logger.Info("UDP tunnel listener stopped",
+			"target", l.cfg.Target)
+	})
+
+	l.wg.Wait()
+	return err
+}
+
+// Address returns the listening address.
+func (l *UDPListener) Address() net.Addr {
+	if l.conn == nil {
+		return nil
+	}
+	return l.conn.LocalAddr()
+}
+
+// Target returns the destination host:port.
+func (l *UDPListener) Target() string {
+	return l.cfg.Target
+}
+
+// SessionCount returns the number of active client sessions.
+func (l *UDPListener) SessionCount() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.sessions)
+}
+
+// Dropped returns the number of datagrams dropped because a session queue
+// was full or no session could be created.
+func (l *UDPListener) Dropped() uint64 {
+	return l.dropped.Load()
+}
+
+// readLoop reads client datagrams and queues them on their session.
+func (l *UDPListener) readLoop() {
+	defer l.wg.Done()
+	defer recovery.RecoverWithLog(l.logger, "tunnel.UDPListener.readLoop")
+
+	buf := make([]byte, 65535) // Max UDP datagram size
+	for {
+		n, client, err := l.conn.ReadFromUDP(buf)
+		if err != nil {
+			if !l.running.Load() {
+				return
+			}
+			continue
+		}
+
+		s := l.session(client)
+		if s == nil {
+			l.dropped.Add(1)
+			continue
+		}
+		s.touch()
+
+		data := make([]byte, n)
+		copy(data, buf[:n])
+		select {
+		case s.queue <- data:
+		default:
+			l.dropped.Add(1)
+		}
+	}
+}
+
+// session returns the session of a client, creating it on its first
+// datagram. Returns nil when the session limit is reached or the mesh
+// association cannot be created.
+func (l *UDPListener) session(client *net.UDPAddr) *udpSession {
+	key := client.String()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if s := l.sessions[key]; s != nil {
+		return s
+	}
+	if !l.running.Load() {
+		return nil
+	}
+	if l.cfg.MaxSessions > 0 && len(l.sessions) >= l.cfg.MaxSessions {
+		l.logger.Debug("UDP tunnel session limit reached",
+			"client", key,
+			"max_sessions", l.cfg.MaxSessions)
+		return nil
+	}
+
+	s := &udpSession{
+		l:      l,
+		key:    key,
+		client: client,
+		queue:  make(chan []byte, udpQueueSize),
+		done:   make(chan struct{}),
+	}
+	id, err := l.relay.OpenUDPSession(s)
+	if err != nil {
+		l.logger.Debug("UDP tunnel session failed",
+			"client", key,
+			logging.KeyError, err)
+		return nil
+	}
+	s.id = id
+	s.touch()
+	l.sessions[key] = s
+
+	l.wg.Add(1)
+	go s.sendLoop()
+
+	l.logger.Debug("UDP tunnel session opened",
+		"client", key,
+		"target", l.cfg.Target,
+		logging.KeyStreamID, id)
+	return s
+}
+
+// idleLoop closes sessions without traffic for IdleTimeout.
+func (l *UDPListener) idleLoop() {
+	defer l.wg.Done()
+	defer recovery.RecoverWithLog(l.logger, "tunnel.UDPListener.idleLoop")
+
+	ticker := time.NewTicker(max(l.cfg.IdleTimeout/4, time.Second))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.stopCh:
+			return
+		case now := <-ticker.C:
+			l.closeIdle(now)
+		}
+	}
+}
+
+// closeIdle closes the sessions idle since before now - IdleTimeout.
+func (l *UDPListener) closeIdle(now time.Time) {
+	cutoff := now.Add(-l.cfg.IdleTimeout).UnixNano()
+
+	l.mu.Lock()
+	var idle []*udpSession
+	for _, s := range l.sessions {
+		if s.lastActive.Load() < cutoff {
+			idle = append(idle, s)
+		}
+	}
+	l.mu.Unlock()
+
+	for _, s := range idle {
+		s.close()
+	}
+}
+
+// sendLoop relays the queued datagrams of a session. Each session has its
+// own loop, so a slow mesh path open only delays its own client.
+func (s *udpSession) sendLoop() {
+	l := s.l
+	defer l.wg.Done()
+	defer recovery.RecoverWithLog(l.logger, "tunnel.udpSession.sendLoop")
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case data := <-s.queue:
+			if err := l.relay.RelayUDPDatagram(s.id, l.destAddr, l.port, l.addrType, l.rawAddr, data); err != nil {
+				l.logger.Debug("UDP tunnel datagram not relayed",
+					"client", s.key,
+					"target", l.cfg.Target,
+					logging.KeyError, err)
+			}
+		}
+	}
+}
+
+// WriteToClient implements ReplyWriter. The reply is sent to the client
+// from the listener socket, so the client sees it coming from the address
+// it sent to.
+func (s *udpSession) WriteToClient(_ byte, _ []byte, _ uint16, data []byte) error {
+	select {
+	case <-s.done:
+		return errors.New("session closed")
+	default:
+	}
+	s.touch()
+	_, err := s.l.conn.WriteToUDP(data, s.client)
+	return err
+}
+
+// touch records session activity.
+func (s *udpSession) touch() {
+	s.lastActive.Store(time.Now().UnixNano())
+}
+
+// close ends the session and its mesh association.
+func (s *udpSession) close() {
+	s.closeOnce.Do(func() {
+		close(s.done)
+
+		l := s.l
+		l.mu.Lock()
+		if l.sessions[s.key] == s {
+			delete(l.sessions, s.key)
+		}
+		l.mu.Unlock()
+
+		l.relay.CloseUDPAssociation(s.id)
+		l.logger.Debug("UDP tunnel session closed",
+			"client", s.key,
+			logging.KeyStreamID, s.id)
+	})
+}
+
+// parseTarget converts host:port to its mesh address encoding.
+func parseTarget(target string) (addrType byte, rawAddr []byte, port uint16, err error) {
+	host, portStr, err := net.SplitHostPort(target)
+	if err != nil {
+		return 0, nil, 0, fmt.Errorf("invalid target %q: %w", target, err)
+	}
+	p, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil || p == 0 {
+		return 0, nil, 0, fmt.Errorf("invalid target port %q", portStr)
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			return protocol.AddrTypeIPv4, ip4, uint16(p), nil
+		}
+		return protocol.AddrTypeIPv6, ip.To16(), uint16(p), nil
+	}
+	if host == "" || len(host) > 255 {
+		return 0, nil, 0, fmt.Errorf("invalid target host %q", host)
+	}
+	rawAddr = append([]byte{byte(len(host))}, host...)
+	return protocol.AddrTypeDomain, rawAddr, uint16(p), nil
+}
@@ -0,0 +1,185 @@
+package tunnel
+
+import (
+	"bytes"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/postalsys/muti-metroo/internal/protocol"
+)
+
+// echoRelay answers every datagram with the same payload.
+type echoRelay struct {
+	mu      sync.Mutex
+	nextID  uint64
+	clients map[uint64]ReplyWriter
+	closed  []uint64
+	targets []string
+}
+
+func newEchoRelay() *echoRelay {
+	return &echoRelay{clients: make(map[uint64]ReplyWriter)}
+}
+
+func (r *echoRelay) OpenUDPSession(client ReplyWriter) (uint64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.nextID++
+	r.clients[r.nextID] = client
+	return r.nextID, nil
+}
+
+func (r *echoRelay) RelayUDPDatagram(id uint64, destAddr net.Addr, destPort uint16, addrType byte, rawAddr []byte, data []byte) error {
+	r.mu.Lock()
+	client := r.clients[id]
+	r.targets = append(r.targets, destAddr.String())
+	r.mu.Unlock()
+	return client.WriteToClient(addrType, rawAddr, destPort, data)
+}
+
+func (r *echoRelay) CloseUDPAssociation(id uint64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.clients, id)
+	r.closed = append(r.closed, id)
+}
+
+func (r *echoRelay) closedCount() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.closed)
+}
+
+func startListener(t *testing.T, cfg UDPListenerConfig, relay Relay) *UDPListener {
+	t.Helper()
+	cfg.Address = "127.0.0.1:0"
+	l := NewUDPListener(cfg, relay)
+	if err := l.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	t.Cleanup(func() { l.Stop() })
+	return l
+}
+
+func dialListener(t *testing.T, l *UDPListener) *net.UDPConn {
+	t.Helper()
+	conn, err := net.DialUDP("udp", nil, l.Address().(*net.UDPAddr))
+	if err != nil {
+		t.Fatalf("DialUDP() error = %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func roundTrip(t *testing.T, conn *net.UDPConn, msg []byte) {
+	t.Helper()
+	if _, err := conn.Write(msg); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, 1500)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if !bytes.Equal(buf[:n], msg) {
+		t.Errorf("reply = %q, want %q", buf[:n], msg)
+	}
+}
+
+func TestUDPListener_Sessions(t *testing.T) {
+	relay := newEchoRelay()
+	l := startListener(t, UDPListenerConfig{Target: "10.0.0.53:53"}, relay)
+
+	a := dialListener(t, l)
+	b := dialListener(t, l)
+	roundTrip(t, a, []byte("from a"))
+	roundTrip(t, b, []byte("from b"))
+	roundTrip(t, a, []byte("again from a"))
+
+	if got := l.SessionCount(); got != 2 {
+		t.Errorf("SessionCount() = %d, want 2", got)
+	}
+	relay.mu.Lock()
+	for _, target := range relay.targets {
+		if target != "10.0.0.53:53" {
+			t.Errorf("datagram sent to %s, want 10.0.0.53:53", target)
+		}
+	}
+	relay.mu.Unlock()
+
+	l.Stop()
+	if got := relay.closedCount(); got != 2 {
+		t.Errorf("closed associations = %d, want 2", got)
+	}
+}
+
+func TestUDPListener_MaxSessions(t *testing.T) {
+	relay := newEchoRelay()
+	l := startListener(t, UDPListenerConfig{Target: "10.0.0.53:53", MaxSessions: 1}, relay)
+
+	roundTrip(t, dialListener(t, l), []byte("first"))
+
+	second := dialListener(t, l)
+	second.Write([]byte("second"))
+	second.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	if _, err := second.Read(make([]byte, 16)); err == nil {
+		t.Error("second client got a reply over the session limit")
+	}
+	if got := l.Dropped(); got != 1 {
+		t.Errorf("Dropped() = %d, want 1", got)
+	}
+}
+
+func TestUDPListener_IdleTimeout(t *testing.T) {
+	relay := newEchoRelay()
+	l := startListener(t, UDPListenerConfig{Target: "10.0.0.53:53", IdleTimeout: time.Minute}, relay)
+
+	roundTrip(t, dialListener(t, l), []byte("ping"))
+
+	l.closeIdle(time.Now())
+	if got := l.SessionCount(); got != 1 {
+		t.Fatalf("SessionCount() before timeout = %d, want 1", got)
+	}
+	l.closeIdle(time.Now().Add(2 * time.Minute))
+	if got := l.SessionCount(); got != 0 {
+		t.Errorf("SessionCount() after timeout = %d, want 0", got)
+	}
+	if got := relay.closedCount(); got != 1 {
+		t.Errorf("closed associations = %d, want 1", got)
+	}
+}
+
+func TestParseTarget(t *testing.T) {
+	tests := []struct {
+		target   string
+		addrType byte
+		rawAddr  []byte
+		port     uint16
+		wantErr  bool
+	}{
+		{"10.0.0.1:53", protocol.AddrTypeIPv4, []byte{10, 0, 0, 1}, 53, false},
+		{"[2001:db8::1]:5060", protocol.AddrTypeIPv6, net.ParseIP("2001:db8::1"), 5060, false},
+		{"ntp.example.com:123", protocol.AddrTypeDomain, append([]byte{15}, "ntp.example.com"...), 123, false},
+		{"10.0.0.1", 0, nil, 0, true},
+		{"10.0.0.1:0", 0, nil, 0, true},
+		{":53", 0, nil, 0, true},
+	}
+
+	for _, tt := range tests {
+		addrType, rawAddr, port, err := parseTarget(tt.target)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseTarget(%q) error = %v, wantErr %v", tt.target, err, tt.wantErr)
+			continue
+		}
+		if tt.wantErr {
+			continue
+		}
+		if addrType != tt.addrType || !bytes.Equal(rawAddr, tt.rawAddr) || port != tt.port {
+			t.Errorf("parseTarget(%q) = %d %v %d, want %d %v %d",
+				tt.target, addrType, rawAddr, port, tt.addrType, tt.rawAddr, tt.port)
+		}
+	}
+}
@@ -3,7 +3,10 @@ package udp
 import (
 	"context"
 	"net"
+	"net/netip"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/postalsys/muti-metroo/internal/crypto"
@@ -36,6 +39,27 @@ func (s AssociationState) String() string {
 	}
 }
 
+// maxTrackedEndpoints bounds the per-association endpoint set so a client
+// spraying datagrams at many destinations cannot grow it without limit.
+const maxTrackedEndpoints = 256
+
+// AssociationStats is a snapshot of an association's traffic counters.
+type AssociationStats struct {
+	StreamID     uint64
+	RequestID    uint64
+	PeerID       identity.AgentID
+	State        AssociationState
+	CreatedAt    time.Time
+	LastActivity time.Time
+	RelayAddr    string
+	DatagramsOut uint64
+	DatagramsIn  uint64
+	BytesOut     uint64
+	BytesIn      uint64
+	Filtered     uint64
+	Endpoints    []string
+}
+
 // Association represents an active UDP tunnel through the mesh.
 type Association struct {
 	mu sync.RWMutex
@@ -60,6 +84,26 @@ type Association struct {
 	// Client tracking (for return path)
 	ClientAddr *net.UDPAddr // SOCKS5 client's address (for ingress)
 
+	// Traffic counters (plaintext payload bytes). "Out" is toward the
+	// destination, "In" is replies received from destinations.
+	DatagramsOut atomic.Uint64
+	DatagramsIn  atomic.Uint64
+	BytesOut     atomic.Uint64
+	BytesIn      atomic.Uint64
+
+	// Datagrams from remote endpoints dropped by restricted filtering
+	Filtered atomic.Uint64
+
+	// Distinct remote endpoints contacted, capped at maxTrackedEndpoints
+	endpoints map[string]struct{}
+
+	// Endpoints replies are accepted from with restricted filtering, with
+	// the time the client last sent to them
+	permitted map[netip.AddrPort]time.Time
+
+	// Set once the association has been logged for exceeding a threshold
+	thresholdLogged atomic.Bool
+
 	// Cleanup
 	ctx    context.Context
 	cancel context.CancelFunc
@@ -78,6 +122,7 @@ func NewAssociation(streamID, requestID uint64, peerID identity.AgentID) *Associ
 		State:        StateOpening,
 		CreatedAt:    now,
 		LastActivity: now,
+		endpoints:    make(map[string]struct{}),
 		ctx:          ctx,
 		cancel:       cancel,
 	}
@@ -102,6 +147,73 @@ func (a *Association) UpdateActivity() {
 	a.LastActivity = time.Now()
 }
 
+// RecordOutbound records a datagram sent to a remote endpoint.
+func (a *Association) RecordOutbound(dest string, n int) {
+	a.DatagramsOut.Add(1)
+	a.BytesOut.Add(uint64(n))
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if _, ok := a.endpoints[dest]; !ok && len(a.endpoints) < maxTrackedEndpoints {
+		a.endpoints[dest] = struct{}{}
+	}
+}
+
+// RecordInbound records a datagram received from a remote endpoint.
+func (a *Association) RecordInbound(n int) {
+	a.DatagramsIn.Add(1)
+	a.BytesIn.Add(uint64(n))
+}
+
+// Endpoints returns the sorted list of remote endpoints contacted.
+func (a *Association) Endpoints() []string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	endpoints := make([]string, 0, len(a.endpoints))
+	for ep := range a.endpoints {
+		endpoints = append(endpoints, ep)
+	}
+	sort.Strings(endpoints)
+	return endpoints
+}
+
+// EndpointCount returns the number of distinct remote endpoints contacted.
+func (a *Association) EndpointCount() int {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	return len(a.endpoints)
+}
+
+// Stats returns a point-in-time snapshot of the association.
+func (a *Association) Stats() AssociationStats {
+	endpoints := a.Endpoints()
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	stats := AssociationStats{
+		StreamID:     a.StreamID,
+		RequestID:    a.RequestID,
+		PeerID:       a.PeerID,
+		State:        a.State,
+		CreatedAt:    a.CreatedAt,
+		LastActivity: a.LastActivity,
+		DatagramsOut: a.DatagramsOut.Load(),
+		DatagramsIn:  a.DatagramsIn.Load(),
+		BytesOut:     a.BytesOut.Load(),
+		BytesIn:      a.BytesIn.Load(),
+		Filtered:     a.Filtered.Load(),
+		Endpoints:    endpoints,
+	}
+	if a.RelayAddr != nil {
+		stats.RelayAddr = a.RelayAddr.String()
+	}
+	return stats
+}
+
 // IsExpired checks if the association has been idle longer than the timeout.
 func (a *Association) IsExpired(timeout time.Duration) bool {
 	if timeout == 0 {
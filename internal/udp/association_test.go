@@ -1,6 +1,7 @@
 package udp
 
 import (
+	"fmt"
 	"testing"
 	"time"
 
@@ -166,3 +167,40 @@ func TestAssociation_Context(t *testing.T) {
 		t.Error("Context should be done after Close")
 	}
 }
+
+func TestAssociation_RecordTraffic(t *testing.T) {
+	peerID, _ := identity.NewAgentID()
+	assoc := NewAssociation(1, 2, peerID)
+
+	assoc.RecordOutbound("10.0.0.1:53", 40)
+	assoc.RecordOutbound("10.0.0.1:53", 60)
+	assoc.RecordOutbound("10.0.0.2:53", 10)
+	assoc.RecordInbound(200)
+
+	stats := assoc.Stats()
+	if stats.DatagramsOut != 3 || stats.BytesOut != 110 {
+		t.Errorf("out = %d datagrams / %d bytes, want 3 / 110", stats.DatagramsOut, stats.BytesOut)
+	}
+	if stats.DatagramsIn != 1 || stats.BytesIn != 200 {
+		t.Errorf("in = %d datagrams / %d bytes, want 1 / 200", stats.DatagramsIn, stats.BytesIn)
+	}
+	if len(stats.Endpoints) != 2 || stats.Endpoints[0] != "10.0.0.1:53" || stats.Endpoints[1] != "10.0.0.2:53" {
+		t.Errorf("Endpoints = %v, want [10.0.0.1:53 10.0.0.2:53]", stats.Endpoints)
+	}
+}
+
+func TestAssociation_EndpointCap(t *testing.T) {
+	peerID, _ := identity.NewAgentID()
+	assoc := NewAssociation(1, 2, peerID)
+
+	for i := 0; i < maxTrackedEndpoints+10; i++ {
+		assoc.RecordOutbound(fmt.Sprintf("10.0.%d.%d:53", i/256, i%256), 1)
+	}
+
+	if got := assoc.EndpointCount(); got != maxTrackedEndpoints {
+		t.Errorf("EndpointCount = %d, want %d", got, maxTrackedEndpoints)
+	}
+	if got := assoc.DatagramsOut.Load(); got != uint64(maxTrackedEndpoints+10) {
+		t.Errorf("DatagramsOut = %d, want %d", got, maxTrackedEndpoints+10)
+	}
+}
@@ -2,6 +2,9 @@ package udp
 
 import (
 	"time"
+
+	"github.com/postalsys/muti-metroo/internal/crypto"
+	"github.com/postalsys/muti-metroo/internal/exit"
 )
 
 // Config holds configuration for the UDP relay handler.
@@ -21,6 +24,55 @@ type Config struct {
 	// MaxDatagramSize is the maximum UDP payload size.
 	// Default is 1472 (typical MTU - IP/UDP headers).
 	MaxDatagramSize int
+
+	// LogThresholds controls logging of associations with unusually high
+	// traffic. Each association is logged at most once.
+	LogThresholds LogThresholds
+
+	// Bind selects the source address or interface of association sockets.
+	// An association serves every destination from one socket, so per-route
+	// overrides do not apply.
+	Bind exit.Bind
+
+	// Private drops datagrams to destinations in the exit's own network.
+	Private exit.PrivateFilter
+
+	// Filtering selects which remote endpoints may send datagrams back to
+	// the client. The zero value relays from any endpoint (full cone).
+	Filtering Filtering
+
+	// Rekey sets when associations replace their E2E key, for ingresses that
+	// ask for it. Zero disables rekeying.
+	Rekey crypto.RekeyConfig
+}
+
+// LogThresholds defines per-association limits that trigger a warning log.
+// A zero value disables the corresponding check.
+type LogThresholds struct {
+	// Bytes is the total payload bytes (both directions).
+	Bytes uint64
+
+	// Datagrams is the total datagram count (both directions).
+	Datagrams uint64
+
+	// Endpoints is the number of distinct remote endpoints contacted.
+	Endpoints int
+}
+
+// Enabled reports whether any threshold is set.
+func (t LogThresholds) Enabled() bool {
+	return t.Bytes > 0 || t.Datagrams > 0 || t.Endpoints > 0
+}
+
+// Exceeded reports whether the association has crossed any threshold.
+func (t LogThresholds) Exceeded(a *Association) bool {
+	if t.Bytes > 0 && a.BytesOut.Load()+a.BytesIn.Load() > t.Bytes {
+		return true
+	}
+	if t.Datagrams > 0 && a.DatagramsOut.Load()+a.DatagramsIn.Load() > t.Datagrams {
+		return true
+	}
+	return t.Endpoints > 0 && a.EndpointCount() > t.Endpoints
 }
 
 // DefaultConfig returns a Config with sensible defaults.
@@ -3,6 +3,8 @@ package udp
 import (
 	"testing"
 	"time"
+
+	"github.com/postalsys/muti-metroo/internal/identity"
 )
 
 func TestDefaultConfig(t *testing.T) {
@@ -21,3 +23,32 @@ func TestDefaultConfig(t *testing.T) {
 		t.Errorf("MaxDatagramSize = %d, want 1472", cfg.MaxDatagramSize)
 	}
 }
+
+func TestLogThresholds_Exceeded(t *testing.T) {
+	peerID, _ := identity.NewAgentID()
+	assoc := NewAssociation(1, 2, peerID)
+	assoc.RecordOutbound("10.0.0.1:53", 100)
+	assoc.RecordInbound(100)
+
+	tests := []struct {
+		name       string
+		thresholds LogThresholds
+		want       bool
+	}{
+		{"disabled", LogThresholds{}, false},
+		{"bytes under", LogThresholds{Bytes: 200}, false},
+		{"bytes over", LogThresholds{Bytes: 199}, true},
+		{"datagrams over", LogThresholds{Datagrams: 1}, true},
+		{"endpoints under", LogThresholds{Endpoints: 1}, false},
+		{"any exceeded", LogThresholds{Bytes: 1000, Datagrams: 1}, true},
+	}
+
+	for _, tt := range tests {
+		if got := tt.thresholds.Exceeded(assoc); got != tt.want {
+			t.Errorf("%s: Exceeded = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+	if (LogThresholds{}).Enabled() {
+		t.Error("zero LogThresholds should not be enabled")
+	}
+}
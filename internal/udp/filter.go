@@ -0,0 +1,112 @@
+package udp
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"time"
+)
+
+// Filtering selects which remote endpoints may send datagrams back to the
+// client through an association's exit socket. Each association keeps one
+// socket, and so one public port, for its lifetime whatever the filtering.
+type Filtering uint8
+
+const (
+	// FilterFullCone relays datagrams from any remote endpoint (endpoint
+	// independent filtering, RFC 4787). Needed by STUN, WebRTC and other
+	// peer-to-peer protocols where the remote side learns the exit port
+	// from a third party.
+	FilterFullCone Filtering = iota
+
+	// FilterRestricted relays datagrams only from IP addresses the client
+	// has sent to (address dependent filtering).
+	FilterRestricted
+
+	// FilterPortRestricted relays datagrams only from IP address and port
+	// pairs the client has sent to (address and port dependent filtering).
+	FilterPortRestricted
+)
+
+// maxPermittedEndpoints bounds the endpoints a restricted association
+// remembers. The entry used least recently is dropped to make room.
+const maxPermittedEndpoints = 4096
+
+// ParseFiltering parses a udp.filtering value. An empty string is
+// FilterFullCone.
+func ParseFiltering(s string) (Filtering, error) {
+	switch s {
+	case "", "full-cone":
+		return FilterFullCone, nil
+	case "restricted":
+		return FilterRestricted, nil
+	case "port-restricted":
+		return FilterPortRestricted, nil
+	}
+	return FilterFullCone, fmt.Errorf("unknown filtering %q (want full-cone, restricted or port-restricted)", s)
+}
+
+// String returns the configuration name of the filtering.
+func (f Filtering) String() string {
+	switch f {
+	case FilterRestricted:
+		return "restricted"
+	case FilterPortRestricted:
+		return "port-restricted"
+	default:
+		return "full-cone"
+	}
+}
+
+// key returns the permit key of a remote endpoint: the address alone for
+// FilterRestricted, address and port for FilterPortRestricted.
+func (f Filtering) key(addr *net.UDPAddr) netip.AddrPort {
+	ap := addr.AddrPort()
+	ip := ap.Addr().Unmap()
+	if f == FilterRestricted {
+		return netip.AddrPortFrom(ip, 0)
+	}
+	return netip.AddrPortFrom(ip, ap.Port())
+}
+
+// Permit records that the client sent a datagram to addr, so replies from
+// it pass the filter.
+func (a *Association) Permit(f Filtering, addr *net.UDPAddr) {
+	if f == FilterFullCone {
+		return
+	}
+	key := f.key(addr)
+	now := time.Now()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.permitted == nil {
+		a.permitted = make(map[netip.AddrPort]time.Time)
+	}
+	if _, ok := a.permitted[key]; !ok && len(a.permitted) >= maxPermittedEndpoints {
+		var oldest netip.AddrPort
+		var oldestAt time.Time
+		for k, at := range a.permitted {
+			if oldestAt.IsZero() || at.Before(oldestAt) {
+				oldest, oldestAt = k, at
+			}
+		}
+		delete(a.permitted, oldest)
+	}
+	a.permitted[key] = now
+}
+
+// Permitted reports whether a datagram from addr passes the filter.
+func (a *Association) Permitted(f Filtering, addr *net.UDPAddr) bool {
+	if f == FilterFullCone {
+		return true
+	}
+	key := f.key(addr)
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	_, ok := a.permitted[key]
+	return ok
+}
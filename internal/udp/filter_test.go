@@ -0,0 +1,124 @@
+package udp
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/postalsys/muti-metroo/internal/identity"
+	"github.com/postalsys/muti-metroo/internal/protocol"
+)
+
+func TestParseFiltering(t *testing.T) {
+	for _, tt := range []struct {
+		in   string
+		want Filtering
+	}{
+		{"", FilterFullCone},
+		{"full-cone", FilterFullCone},
+		{"restricted", FilterRestricted},
+		{"port-restricted", FilterPortRestricted},
+	} {
+		got, err := ParseFiltering(tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("ParseFiltering(%q) = %v, %v, want %v", tt.in, got, err, tt.want)
+		}
+	}
+	if _, err := ParseFiltering("symmetric"); err == nil {
+		t.Error("ParseFiltering(symmetric) should fail")
+	}
+}
+
+func TestAssociation_PermitLimit(t *testing.T) {
+	a := NewAssociation(1, 1, identity.AgentID{})
+	first := &net.UDPAddr{IP: net.IPv4(198, 51, 100, 1), Port: 1}
+	a.Permit(FilterPortRestricted, first)
+	time.Sleep(time.Millisecond)
+	for i := 0; i < maxPermittedEndpoints; i++ {
+		a.Permit(FilterPortRestricted, &net.UDPAddr{IP: net.IPv4(203, 0, 113, 1), Port: 1000 + i})
+	}
+	if a.Permitted(FilterPortRestricted, first) {
+		t.Error("oldest endpoint should be dropped when the limit is reached")
+	}
+	if !a.Permitted(FilterPortRestricted, &net.UDPAddr{IP: net.IPv4(203, 0, 113, 1), Port: 1000}) {
+		t.Error("newer endpoint should still be permitted")
+	}
+}
+
+func TestHandler_Filtering(t *testing.T) {
+	tests := []struct {
+		filtering    Filtering
+		wantIn       int
+		wantFiltered uint64
+	}{
+		{FilterFullCone, 3, 0},
+		{FilterRestricted, 2, 1},
+		{FilterPortRestricted, 1, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.filtering.String(), func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.Enabled = true
+			cfg.IdleTimeout = 0
+			cfg.Filtering = tt.filtering
+
+			writer := newMockDataWriter()
+			h := NewHandler(cfg, writer, testLogger())
+			defer h.Close()
+
+			// The client sends to a; b shares a's address, c does not
+			listen := func(ip net.IP) *net.UDPConn {
+				c, err := net.ListenUDP("udp4", &net.UDPAddr{IP: ip})
+				if err != nil {
+					t.Skipf("ListenUDP %s: %v", ip, err)
+				}
+				t.Cleanup(func() { c.Close() })
+				return c
+			}
+			a := listen(net.IPv4(127, 0, 0, 1))
+			b := listen(net.IPv4(127, 0, 0, 1))
+			c := listen(net.IPv4(127, 0, 0, 2))
+
+			peerID, _ := identity.NewAgentID()
+			open := &protocol.UDPOpen{RequestID: 1, AddressType: protocol.AddrTypeIPv4, Address: []byte{0, 0, 0, 0}}
+			var ephKey [protocol.EphemeralKeySize]byte
+			if err := h.HandleUDPOpen(context.Background(), peerID, 1, open, ephKey); err != nil {
+				t.Fatalf("HandleUDPOpen: %v", err)
+			}
+			aAddr := a.LocalAddr().(*net.UDPAddr)
+			err := h.HandleUDPDatagram(peerID, 1, &protocol.UDPDatagram{
+				AddressType: protocol.AddrTypeIPv4,
+				Address:     aAddr.IP.To4(),
+				Port:        uint16(aAddr.Port),
+				Data:        []byte("hello"),
+			})
+			if err != nil {
+				t.Fatalf("HandleUDPDatagram: %v", err)
+			}
+
+			assoc := h.GetAssociation(1)
+			relay := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: assoc.RelayAddr.Port}
+			for _, conn := range []*net.UDPConn{a, b, c} {
+				if _, err := conn.WriteToUDP([]byte("reply"), relay); err != nil {
+					t.Fatalf("WriteToUDP: %v", err)
+				}
+			}
+
+			deadline := time.Now().Add(3 * time.Second)
+			for time.Now().Before(deadline) {
+				if len(writer.getDatagrams()) == tt.wantIn && assoc.Filtered.Load() == tt.wantFiltered {
+					break
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			if got := len(writer.getDatagrams()); got != tt.wantIn {
+				t.Errorf("relayed %d datagrams, want %d", got, tt.wantIn)
+			}
+			if got := assoc.Filtered.Load(); got != tt.wantFiltered {
+				t.Errorf("Filtered = %d, want %d", got, tt.wantFiltered)
+			}
+		})
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log/slog"
 	"net"
+	"sort"
 	"sync"
 	"time"
 
@@ -145,7 +146,7 @@ func (h *Handler) HandleUDPOpen(
 	assoc := NewAssociation(streamID, open.RequestID, peerID)
 
 	// Create UDP socket
-	udpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4zero, Port: 0})
+	udpConn, err := h.listenUDP()
 	if err != nil {
 		h.writer.WriteUDPOpenErr(peerID, streamID, &protocol.UDPOpenErr{
 			RequestID: open.RequestID,
@@ -190,6 +191,9 @@ func (h *Handler) HandleUDPOpen(
 	}
 	if hasEncryption {
 		ack.EphemeralPubKey = ephPub
+		if h.rekeys(open.E2EFlags) {
+			ack.E2EFlags = protocol.E2EFlagRekey
+		}
 	}
 
 	if err := h.writer.WriteUDPOpenAck(peerID, streamID, ack); err != nil {
@@ -213,6 +217,28 @@ func (h *Handler) HandleUDPOpen(
 	return nil
 }
 
+// listenUDP creates the socket of a new association, bound to the configured
+// source address and interface. Without a source address the socket is
+// dual-stack.
+func (h *Handler) listenUDP() (*net.UDPConn, error) {
+	host := net.IPv4zero.String()
+	if ip := h.config.Bind.Address; ip != nil {
+		host = ip.String()
+	}
+	lc := net.ListenConfig{Control: h.config.Bind.Control()}
+	pc, err := lc.ListenPacket(context.Background(), "udp", net.JoinHostPort(host, "0"))
+	if err != nil {
+		return nil, err
+	}
+	return pc.(*net.UDPConn), nil
+}
+
+// rekeys reports whether a association opened with the E2E flags of the
+// ingress replaces its keys.
+func (h *Handler) rekeys(e2eFlags uint8) bool {
+	return e2eFlags&protocol.E2EFlagRekey != 0 && h.config.Rekey.Enabled()
+}
+
 // performKeyExchange generates an ephemeral keypair and derives the session key.
 // Returns the public key for inclusion in the ack, or an error.
 func (h *Handler) performKeyExchange(
@@ -246,11 +272,14 @@ func (h *Handler) performKeyExchange(
 		return zeroKey, fmt.Errorf("ECDH: %w", err)
 	}
 
-	crypto.ZeroKey(&ephPriv)
-
 	// Derive session key (we are responder)
 	sessionKey := crypto.DeriveSessionKey(sharedSecret, open.RequestID, remoteEphemeralPub, ephPub, false)
+	sessionKey.EnableReplayWindow() // Datagrams may be lost or reordered
 	crypto.ZeroKey(&sharedSecret)
+	if h.rekeys(open.E2EFlags) {
+		sessionKey.EnableRekey(ephPriv, remoteEphemeralPub, h.config.Rekey)
+	}
+	crypto.ZeroKey(&ephPriv)
 
 	assoc.SetSessionKey(sessionKey)
 
@@ -274,9 +303,12 @@ func (h *Handler) HandleUDPDatagram(peerID identity.AgentID, streamID uint64, da
 
 	assoc.UpdateActivity()
 
-	// Decrypt payload
+	// Decrypt payload. A datagram that fails validation means someone on
+	// the path is tampering with or replaying the session, so end it.
 	plaintext, err := assoc.Decrypt(datagram.Data)
 	if err != nil {
+		h.writer.WriteUDPClose(assoc.PeerID, streamID, protocol.UDPCloseE2EValidation)
+		h.removeAssociation(streamID)
 		return fmt.Errorf("decrypt: %w", err)
 	}
 
@@ -290,6 +322,9 @@ func (h *Handler) HandleUDPDatagram(peerID identity.AgentID, streamID uint64, da
 	if err != nil {
 		return err
 	}
+	if h.config.Private.Blocks(destAddr.IP) {
+		return fmt.Errorf("destination %s in private range not allowed", destAddr.IP)
+	}
 
 	// Send to destination
 	assoc.mu.RLock()
@@ -300,11 +335,17 @@ func (h *Handler) HandleUDPDatagram(peerID identity.AgentID, streamID uint64, da
 		return fmt.Errorf("UDP connection closed")
 	}
 
+	// Permit replies before sending, so a fast reply is not filtered
+	assoc.Permit(h.config.Filtering, destAddr)
+
 	_, err = conn.WriteToUDP(plaintext, destAddr)
 	if err != nil {
 		return fmt.Errorf("send: %w", err)
 	}
 
+	assoc.RecordOutbound(destAddr.String(), len(plaintext))
+	h.checkThresholds(assoc)
+
 	return nil
 }
 
@@ -322,6 +363,19 @@ func (h *Handler) HandleUDPClose(peerID identity.AgentID, streamID uint64) error
 	return nil
 }
 
+// CloseAssociation closes an association on request, sending UDP_CLOSE to
+// the peer that opened it. Returns false if there is no such association.
+func (h *Handler) CloseAssociation(streamID uint64) bool {
+	assoc := h.GetAssociation(streamID)
+	if assoc == nil {
+		return false
+	}
+
+	h.writer.WriteUDPClose(assoc.PeerID, streamID, protocol.UDPCloseNormal)
+	h.removeAssociation(streamID)
+	return true
+}
+
 // GetAssociation returns an association by stream ID.
 func (h *Handler) GetAssociation(streamID uint64) *Association {
 	h.mu.RLock()
@@ -338,6 +392,47 @@ func (h *Handler) GetAssociationByRequestID(requestID uint64) *Association {
 	return h.byRequestID[requestID]
 }
 
+// Stats returns a snapshot of all active associations, ordered by stream ID.
+func (h *Handler) Stats() []AssociationStats {
+	h.mu.RLock()
+	assocs := make([]*Association, 0, len(h.associations))
+	for _, assoc := range h.associations {
+		assocs = append(assocs, assoc)
+	}
+	h.mu.RUnlock()
+
+	stats := make([]AssociationStats, len(assocs))
+	for i, assoc := range assocs {
+		stats[i] = assoc.Stats()
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].StreamID < stats[j].StreamID
+	})
+	return stats
+}
+
+// checkThresholds logs the association once if it has crossed any of the
+// configured log thresholds.
+func (h *Handler) checkThresholds(assoc *Association) {
+	t := h.config.LogThresholds
+	if !t.Enabled() || assoc.thresholdLogged.Load() || !t.Exceeded(assoc) {
+		return
+	}
+	if !assoc.thresholdLogged.CompareAndSwap(false, true) {
+		return
+	}
+
+	h.logger.Warn("UDP association exceeded log threshold",
+		logging.KeyStreamID, assoc.StreamID,
+		logging.KeyRequestID, assoc.RequestID,
+		logging.KeyPeerID, assoc.PeerID.ShortString(),
+		"datagrams_out", assoc.DatagramsOut.Load(),
+		"datagrams_in", assoc.DatagramsIn.Load(),
+		"bytes_out", assoc.BytesOut.Load(),
+		"bytes_in", assoc.BytesIn.Load(),
+		"endpoints", assoc.EndpointCount())
+}
+
 // ActiveCount returns the number of active associations.
 func (h *Handler) ActiveCount() int {
 	h.mu.RLock()
@@ -346,6 +441,11 @@ func (h *Handler) ActiveCount() int {
 	return len(h.associations)
 }
 
+// Filtering returns the configured filtering of remote endpoints.
+func (h *Handler) Filtering() Filtering {
+	return h.config.Filtering
+}
+
 // Close shuts down the handler and all associations.
 func (h *Handler) Close() error {
 	h.cancel()
@@ -417,7 +517,14 @@ func (h *Handler) readLoop(assoc *Association) {
 			continue
 		}
 
+		if !assoc.Permitted(h.config.Filtering, remoteAddr) {
+			assoc.Filtered.Add(1)
+			continue
+		}
+
 		assoc.UpdateActivity()
+		assoc.RecordInbound(n)
+		h.checkThresholds(assoc)
 
 		// Encrypt payload
 		plaintext := buf[:n]
@@ -3,11 +3,13 @@ package udp
 import (
 	"context"
 	"log/slog"
+	"net"
 	"os"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/postalsys/muti-metroo/internal/crypto"
 	"github.com/postalsys/muti-metroo/internal/identity"
 	"github.com/postalsys/muti-metroo/internal/protocol"
 )
@@ -282,6 +284,82 @@ func TestHandler_HandleUDPClose(t *testing.T) {
 	}
 }
 
+func TestHandler_CloseAssociation(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Enabled = true
+	cfg.IdleTimeout = 0
+
+	writer := newMockDataWriter()
+	h := NewHandler(cfg, writer, testLogger())
+	defer h.Close()
+
+	peerID, _ := identity.NewAgentID()
+	var ephKey [protocol.EphemeralKeySize]byte
+
+	open := &protocol.UDPOpen{RequestID: 1, AddressType: protocol.AddrTypeIPv4, Address: []byte{0, 0, 0, 0}}
+	if err := h.HandleUDPOpen(context.Background(), peerID, 1, open, ephKey); err != nil {
+		t.Fatalf("HandleUDPOpen error = %v", err)
+	}
+
+	if !h.CloseAssociation(1) {
+		t.Fatal("CloseAssociation(1) = false, want true")
+	}
+	if h.ActiveCount() != 0 {
+		t.Errorf("ActiveCount after close = %d, want 0", h.ActiveCount())
+	}
+
+	writer.mu.Lock()
+	if len(writer.closes) != 1 || writer.closes[0] != protocol.UDPCloseNormal {
+		t.Errorf("closes = %v, want one UDPCloseNormal", writer.closes)
+	}
+	writer.mu.Unlock()
+
+	if h.CloseAssociation(1) {
+		t.Error("CloseAssociation() of a closed association should return false")
+	}
+}
+
+func TestHandler_HandleUDPDatagram_E2EValidationFailure(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Enabled = true
+	cfg.IdleTimeout = 0
+
+	writer := newMockDataWriter()
+	h := NewHandler(cfg, writer, testLogger())
+	defer h.Close()
+
+	peerID, _ := identity.NewAgentID()
+	_, ephPub, err := crypto.GenerateEphemeralKeypair()
+	if err != nil {
+		t.Fatalf("GenerateEphemeralKeypair() error = %v", err)
+	}
+
+	open := &protocol.UDPOpen{RequestID: 1, AddressType: protocol.AddrTypeIPv4, Address: []byte{0, 0, 0, 0}}
+	if err := h.HandleUDPOpen(context.Background(), peerID, 1, open, ephPub); err != nil {
+		t.Fatalf("HandleUDPOpen error = %v", err)
+	}
+
+	// A datagram that does not authenticate under the session key
+	datagram := &protocol.UDPDatagram{
+		AddressType: protocol.AddrTypeIPv4,
+		Address:     []byte{127, 0, 0, 1},
+		Port:        9,
+		Data:        make([]byte, crypto.EncryptionOverhead+4),
+	}
+	if err := h.HandleUDPDatagram(peerID, 1, datagram); err == nil {
+		t.Fatal("HandleUDPDatagram() accepted a forged datagram")
+	}
+
+	if h.ActiveCount() != 0 {
+		t.Errorf("ActiveCount = %d, want 0", h.ActiveCount())
+	}
+	writer.mu.Lock()
+	defer writer.mu.Unlock()
+	if len(writer.closes) != 1 || writer.closes[0] != protocol.UDPCloseE2EValidation {
+		t.Errorf("closes = %v, want one UDPCloseE2EValidation", writer.closes)
+	}
+}
+
 func TestHandler_Close(t *testing.T) {
 	cfg := DefaultConfig()
 	cfg.Enabled = true
@@ -344,3 +422,69 @@ func TestHandler_CleanupExpired(t *testing.T) {
 		t.Errorf("ActiveCount after cleanup = %d, want 0", h.ActiveCount())
 	}
 }
+
+func TestHandler_Stats(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Enabled = true
+	cfg.IdleTimeout = 0
+
+	writer := newMockDataWriter()
+	h := NewHandler(cfg, writer, testLogger())
+	defer h.Close()
+
+	// Echo server acting as the remote endpoint
+	echo, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("ListenUDP: %v", err)
+	}
+	defer echo.Close()
+	go func() {
+		buf := make([]byte, 1500)
+		n, addr, err := echo.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		echo.WriteToUDP(append(buf[:n], buf[:n]...), addr)
+	}()
+
+	peerID, _ := identity.NewAgentID()
+	open := &protocol.UDPOpen{RequestID: 7, AddressType: protocol.AddrTypeIPv4, Address: []byte{0, 0, 0, 0}}
+	var ephKey [protocol.EphemeralKeySize]byte
+	if err := h.HandleUDPOpen(context.Background(), peerID, 1, open, ephKey); err != nil {
+		t.Fatalf("HandleUDPOpen: %v", err)
+	}
+
+	echoAddr := echo.LocalAddr().(*net.UDPAddr)
+	err = h.HandleUDPDatagram(peerID, 1, &protocol.UDPDatagram{
+		AddressType: protocol.AddrTypeIPv4,
+		Address:     echoAddr.IP.To4(),
+		Port:        uint16(echoAddr.Port),
+		Data:        []byte("ping"),
+	})
+	if err != nil {
+		t.Fatalf("HandleUDPDatagram: %v", err)
+	}
+
+	deadline := time.Now().Add(3 * time.Second)
+	for len(writer.getDatagrams()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	stats := h.Stats()
+	if len(stats) != 1 {
+		t.Fatalf("Stats returned %d associations, want 1", len(stats))
+	}
+	st := stats[0]
+	if st.StreamID != 1 || st.RequestID != 7 || st.PeerID != peerID {
+		t.Errorf("identifiers = %d/%d/%s, want 1/7/%s", st.StreamID, st.RequestID, st.PeerID.ShortString(), peerID.ShortString())
+	}
+	if st.DatagramsOut != 1 || st.BytesOut != 4 {
+		t.Errorf("out = %d/%d, want 1/4", st.DatagramsOut, st.BytesOut)
+	}
+	if st.DatagramsIn != 1 || st.BytesIn != 8 {
+		t.Errorf("in = %d/%d, want 1/8", st.DatagramsIn, st.BytesIn)
+	}
+	if len(st.Endpoints) != 1 || st.Endpoints[0] != echoAddr.String() {
+		t.Errorf("Endpoints = %v, want [%s]", st.Endpoints, echoAddr)
+	}
+}
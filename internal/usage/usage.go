@@ -0,0 +1,340 @@
+// Package usage keeps persistent byte counters per peer link, per SOCKS5
+// user and per exit destination bucket, rolled over every calendar month.
+package usage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/postalsys/muti-metroo/internal/logging"
+	"golang.org/x/net/publicsuffix"
+)
+
+// StateFile is the name of the usage file in the data directory.
+const StateFile = "usage.json"
+
+// Defaults for usage accounting.
+const (
+	DefaultRetainMonths    = 12
+	DefaultMaxDestinations = 1000
+
+	// OtherBucket collects destinations once MaxDestinations buckets exist.
+	OtherBucket = "other"
+
+	// monthFormat names a period by its UTC month.
+	monthFormat = "2006-01"
+)
+
+// Counter is the traffic of one peer, user or destination bucket, seen from
+// this agent: In is received from it, Out is sent to it.
+type Counter struct {
+	In  uint64 `json:"bytes_in"`
+	Out uint64 `json:"bytes_out"`
+}
+
+// Period is the usage of one calendar month (UTC).
+type Period struct {
+	// Month is the period in YYYY-MM form.
+	Month string `json:"month"`
+
+	Peers        map[string]*Counter `json:"peers"`
+	Users        map[string]*Counter `json:"users"`
+	Destinations map[string]*Counter `json:"destinations"`
+}
+
+// Report is the usage of the current month and of retained past months,
+// newest first.
+type Report struct {
+	Current   Period    `json:"current"`
+	History   []Period  `json:"history,omitempty"`
+	UpdatedAt time.Time `json:"updated_at,omitempty"`
+}
+
+// Config configures usage accounting.
+type Config struct {
+	// DataDir holds StateFile. Empty keeps usage in memory only.
+	DataDir string
+
+	// RetainMonths is how many past months are kept after a rollover
+	// (0 = DefaultRetainMonths).
+	RetainMonths int
+
+	// MaxDestinations bounds the destination buckets of a month. Traffic to
+	// further buckets is counted under OtherBucket (0 =
+	// DefaultMaxDestinations).
+	MaxDestinations int
+
+	// Logger reports persistence errors.
+	Logger *slog.Logger
+}
+
+// Ledger accumulates usage and writes it to the data directory. It is safe
+// for concurrent use.
+type Ledger struct {
+	cfg    Config
+	logger *slog.Logger
+	now    func() time.Time
+
+	mu      sync.Mutex
+	current *Period
+	history []Period
+	updated time.Time
+	dirty   bool
+
+	saveMu sync.Mutex // Serializes writes of the state file
+}
+
+// New creates a ledger and loads saved usage. Saved usage of a past month
+// is rolled over right away.
+func New(cfg Config) (*Ledger, error) {
+	if cfg.RetainMonths <= 0 {
+		cfg.RetainMonths = DefaultRetainMonths
+	}
+	if cfg.MaxDestinations <= 0 {
+		cfg.MaxDestinations = DefaultMaxDestinations
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = logging.NopLogger()
+	}
+
+	l := &Ledger{cfg: cfg, logger: cfg.Logger, now: time.Now}
+	if err := l.load(); err != nil {
+		return nil, err
+	}
+	if l.current == nil {
+		l.current = newPeriod(l.now())
+	}
+	return l, nil
+}
+
+// newPeriod returns an empty period for the month of t.
+func newPeriod(t time.Time) *Period {
+	return &Period{
+		Month:        t.UTC().Format(monthFormat),
+		Peers:        make(map[string]*Counter),
+		Users:        make(map[string]*Counter),
+		Destinations: make(map[string]*Counter),
+	}
+}
+
+// AddPeer records traffic over the link to a peer.
+func (l *Ledger) AddPeer(peer string, in, out uint64) {
+	l.add(tablePeers, peer, in, out)
+}
+
+// AddUser records CONNECT traffic of a SOCKS5 user.
+func (l *Ledger) AddUser(user string, in, out uint64) {
+	l.add(tableUsers, user, in, out)
+}
+
+// AddDestination records exit traffic to a destination host, counted under
+// its Bucket.
+func (l *Ledger) AddDestination(host string, in, out uint64) {
+	l.add(tableDestinations, Bucket(host), in, out)
+}
+
+// table selects one of the counter tables of a period.
+type table int
+
+const (
+	tablePeers table = iota
+	tableUsers
+	tableDestinations
+)
+
+// add adds traffic to a key of the current period, rolling over first when
+// the month changed.
+func (l *Ledger) add(t table, key string, in, out uint64) {
+	if in == 0 && out == 0 {
+		return
+	}
+	now := l.now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.rolloverLocked(now)
+	var m map[string]*Counter
+	switch t {
+	case tablePeers:
+		m = l.current.Peers
+	case tableUsers:
+		m = l.current.Users
+	default:
+		m = l.current.Destinations
+		if _, ok := m[key]; !ok && len(m) >= l.cfg.MaxDestinations {
+			key = OtherBucket
+		}
+	}
+	c, ok := m[key]
+	if !ok {
+		c = &Counter{}
+		m[key] = c
+	}
+	c.In += in
+	c.Out += out
+	l.updated = now
+	l.dirty = true
+}
+
+// rolloverLocked starts a new period when now is past the current month
+// and trims the history. l.mu must be held.
+func (l *Ledger) rolloverLocked(now time.Time) {
+	month := now.UTC().Format(monthFormat)
+	if l.current.Month == month {
+		return
+	}
+	l.history = append([]Period{*l.current}, l.history...)
+	if len(l.history) > l.cfg.RetainMonths {
+		l.history = l.history[:l.cfg.RetainMonths]
+	}
+	l.current = newPeriod(now)
+	l.dirty = true
+}
+
+// Report returns a copy of the current and retained usage.
+func (l *Ledger) Report() Report {
+	now := l.now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.rolloverLocked(now)
+	r := Report{Current: l.current.clone(), UpdatedAt: l.updated}
+	for _, p := range l.history {
+		r.History = append(r.History, p.clone())
+	}
+	return r
+}
+
+// clone returns a deep copy of p.
+func (p Period) clone() Period {
+	copyTable := func(m map[string]*Counter) map[string]*Counter {
+		out := make(map[string]*Counter, len(m))
+		for k, c := range m {
+			copied := *c
+			out[k] = &copied
+		}
+		return out
+	}
+	return Period{
+		Month:        p.Month,
+		Peers:        copyTable(p.Peers),
+		Users:        copyTable(p.Users),
+		Destinations: copyTable(p.Destinations),
+	}
+}
+
+// Bucket returns the destination bucket of a host: the registrable domain
+// of a domain name (www.example.co.uk -> example.co.uk), the /24 of an IPv4
+// address and the /48 of an IPv6 address.
+func Bucket(host string) string {
+	host = strings.TrimSuffix(strings.ToLower(strings.Trim(host, "[]")), ".")
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			return (&net.IPNet{IP: ip4.Mask(net.CIDRMask(24, 32)), Mask: net.CIDRMask(24, 32)}).String()
+		}
+		return (&net.IPNet{IP: ip.Mask(net.CIDRMask(48, 128)), Mask: net.CIDRMask(48, 128)}).String()
+	}
+	if domain, err := publicsuffix.EffectiveTLDPlusOne(host); err == nil {
+		return domain
+	}
+	return host
+}
+
+// state is the content of StateFile.
+type state struct {
+	Current   *Period   `json:"current"`
+	History   []Period  `json:"history,omitempty"`
+	UpdatedAt time.Time `json:"updated_at,omitempty"`
+}
+
+// load reads saved usage from the data directory.
+func (l *Ledger) load() error {
+	if l.cfg.DataDir == "" {
+		return nil
+	}
+	data, err := os.ReadFile(filepath.Join(l.cfg.DataDir, StateFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read usage: %w", err)
+	}
+
+	var st state
+	if err := json.Unmarshal(data, &st); err != nil {
+		return fmt.Errorf("parse usage: %w", err)
+	}
+	if st.Current == nil || st.Current.Month == "" {
+		return nil
+	}
+	l.current = st.Current.normalized()
+	for _, p := range st.History {
+		l.history = append(l.history, *p.normalized())
+	}
+	l.updated = st.UpdatedAt
+	l.rolloverLocked(l.now())
+	return nil
+}
+
+// normalized returns p with nil tables replaced by empty ones.
+func (p Period) normalized() *Period {
+	for _, m := range []*map[string]*Counter{&p.Peers, &p.Users, &p.Destinations} {
+		if *m == nil {
+			*m = make(map[string]*Counter)
+		}
+	}
+	return &p
+}
+
+// Save writes usage to the data directory atomically if it changed since
+// the last save. Errors are logged.
+func (l *Ledger) Save() {
+	if l.cfg.DataDir == "" {
+		return
+	}
+
+	l.saveMu.Lock()
+	defer l.saveMu.Unlock()
+
+	l.mu.Lock()
+	if !l.dirty {
+		l.mu.Unlock()
+		return
+	}
+	current := l.current.clone()
+	st := state{Current: &current, History: l.history, UpdatedAt: l.updated}
+	data, err := json.MarshalIndent(st, "", "  ")
+	l.dirty = false
+	l.mu.Unlock()
+
+	if err != nil {
+		l.logger.Error("failed to encode usage", logging.KeyError, err)
+		return
+	}
+
+	path := filepath.Join(l.cfg.DataDir, StateFile)
+	tmp := path + ".tmp"
+	err = os.WriteFile(tmp, data, 0600)
+	if err == nil {
+		if err = os.Rename(tmp, path); err != nil {
+			os.Remove(tmp)
+		}
+	}
+	if err != nil {
+		l.logger.Error("failed to write usage", logging.KeyError, err)
+		// Try again on the next save
+		l.mu.Lock()
+		l.dirty = true
+		l.mu.Unlock()
+	}
+}
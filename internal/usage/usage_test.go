@@ -0,0 +1,129 @@
+package usage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBucket(t *testing.T) {
+	tests := []struct {
+		host string
+		want string
+	}{
+		{"www.example.com", "example.com"},
+		{"API.Example.co.uk.", "example.co.uk"},
+		{"example.com", "example.com"},
+		{"localhost", "localhost"},
+		{"192.0.2.77", "192.0.2.0/24"},
+		{"[2001:db8:1:2::5]", "2001:db8:1::/48"},
+		{"::ffff:10.1.2.3", "10.1.2.0/24"},
+	}
+	for _, tt := range tests {
+		if got := Bucket(tt.host); got != tt.want {
+			t.Errorf("Bucket(%q) = %q, want %q", tt.host, got, tt.want)
+		}
+	}
+}
+
+func TestLedger_Add(t *testing.T) {
+	l, err := New(Config{MaxDestinations: 2})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	l.AddPeer("abc123", 100, 200)
+	l.AddPeer("abc123", 1, 2)
+	l.AddUser("alice", 10, 20)
+	l.AddUser("bob", 0, 0)
+	l.AddDestination("a.example.com", 5, 6)
+	l.AddDestination("b.example.com", 5, 6)
+	l.AddDestination("192.0.2.1", 1, 1)
+	l.AddDestination("198.51.100.1", 2, 2)
+	l.AddDestination("203.0.113.1", 3, 3)
+
+	cur := l.Report().Current
+	if c := cur.Peers["abc123"]; c == nil || c.In != 101 || c.Out != 202 {
+		t.Errorf("peer counter = %+v, want 101/202", c)
+	}
+	if c := cur.Users["alice"]; c == nil || c.In != 10 || c.Out != 20 {
+		t.Errorf("user counter = %+v, want 10/20", c)
+	}
+	if _, ok := cur.Users["bob"]; ok {
+		t.Error("zero traffic created a user entry")
+	}
+	if c := cur.Destinations["example.com"]; c == nil || c.In != 10 || c.Out != 12 {
+		t.Errorf("destination counter = %+v, want 10/12", c)
+	}
+	if c := cur.Destinations[OtherBucket]; c == nil || c.In != 5 || c.Out != 5 {
+		t.Errorf("other bucket = %+v, want 5/5", c)
+	}
+	if len(cur.Destinations) != 3 {
+		t.Errorf("destinations = %v, want 3 buckets", cur.Destinations)
+	}
+}
+
+func TestLedger_Rollover(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2026, 1, 31, 23, 0, 0, 0, time.UTC)
+
+	l, err := New(Config{DataDir: dir, RetainMonths: 2})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	l.now = func() time.Time { return now }
+	l.current = newPeriod(now)
+
+	for i := 0; i < 4; i++ {
+		l.AddPeer("p", 1, 1)
+		now = now.AddDate(0, 0, 28)
+	}
+
+	r := l.Report()
+	if r.Current.Month != "2026-05" || len(r.Current.Peers) != 0 {
+		t.Errorf("current = %+v, want empty 2026-05", r.Current)
+	}
+	var months []string
+	for _, p := range r.History {
+		months = append(months, p.Month)
+	}
+	if len(months) != 2 || months[0] != "2026-04" || months[1] != "2026-03" {
+		t.Errorf("history months = %v, want [2026-04 2026-03]", months)
+	}
+
+	// Saved usage of a past month is loaded into the history
+	l.AddUser("alice", 7, 8)
+	l.Save()
+	if _, err := os.Stat(filepath.Join(dir, StateFile)); err != nil {
+		t.Fatalf("state file not written: %v", err)
+	}
+
+	loaded, err := New(Config{DataDir: dir, RetainMonths: 2})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	r = loaded.Report()
+	if len(r.History) != 2 || r.History[0].Month != "2026-05" || r.History[0].Users["alice"].Out != 8 {
+		t.Errorf("loaded history = %+v, want 2026-05 first", r.History)
+	}
+}
+
+func TestLedger_LoadErrors(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, StateFile), []byte("{not json"), 0600)
+	if _, err := New(Config{DataDir: dir}); err == nil {
+		t.Error("New() with corrupt state error = nil, want error")
+	}
+
+	// Saving with no changes writes nothing
+	empty := t.TempDir()
+	l, err := New(Config{DataDir: empty})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	l.Save()
+	if _, err := os.Stat(filepath.Join(empty, StateFile)); !os.IsNotExist(err) {
+		t.Errorf("state file written without changes: %v", err)
+	}
+}
@@ -0,0 +1,324 @@
+// Package watchdog checks the goroutine count, heap size and peer write
+// stalls of the agent against thresholds, saves profiles when they are
+// crossed and tells the caller which recovery actions are due.
+package watchdog
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/postalsys/muti-metroo/internal/logging"
+)
+
+// Watchdog defaults.
+const (
+	// DirName is the snapshot directory in the data directory.
+	DirName = "watchdog"
+
+	DefaultInterval          = 30 * time.Second
+	DefaultStuckWriteTimeout = time.Minute
+	DefaultSnapshotCooldown  = 15 * time.Minute
+	DefaultMaxSnapshots      = 10
+	DefaultRestartAfter      = 3
+	DefaultRestartCooldown   = time.Hour
+
+	// restartFile in the snapshot directory holds the time of the last
+	// restart, so the cooldown holds across restarts.
+	restartFile = "last_restart"
+
+	// snapshotTimeFormat names snapshot directories. It sorts lexically in
+	// time order.
+	snapshotTimeFormat = "20060102T150405Z"
+)
+
+// Limits reported in Result.Breaches.
+const (
+	BreachGoroutines = "goroutines"
+	BreachHeap       = "heap"
+	BreachStuckPeer  = "stuck_peer"
+)
+
+// Config configures a Watchdog.
+type Config struct {
+	// MaxGoroutines is the goroutine count above which the agent is
+	// unhealthy (0 = not checked).
+	MaxGoroutines int
+
+	// MaxHeap is the allocated heap size in bytes above which the agent is
+	// unhealthy (0 = not checked).
+	MaxHeap uint64
+
+	// StuckWriteTimeout is how long a peer write may block before the peer
+	// counts as stuck (0 = DefaultStuckWriteTimeout).
+	StuckWriteTimeout time.Duration
+
+	// SnapshotDir receives profiles when a limit is crossed. Empty disables
+	// snapshots.
+	SnapshotDir string
+
+	// SnapshotCooldown is the minimum time between snapshots
+	// (0 = DefaultSnapshotCooldown).
+	SnapshotCooldown time.Duration
+
+	// MaxSnapshots is the number of snapshots kept (0 = DefaultMaxSnapshots).
+	MaxSnapshots int
+
+	// Restart enables restarts: Result.Restart is set once the goroutine
+	// or heap limit has been crossed on RestartAfter consecutive checks
+	// (0 = DefaultRestartAfter), at most once per RestartCooldown
+	// (0 = DefaultRestartCooldown).
+	Restart         bool
+	RestartAfter    int
+	RestartCooldown time.Duration
+
+	// Logger reports crossed limits and snapshot errors.
+	Logger *slog.Logger
+}
+
+// PeerWrite is the write state of a peer connection.
+type PeerWrite struct {
+	ID      string
+	Stalled time.Duration // How long the current write has been blocked
+	Waiting int           // Writers queued behind it
+}
+
+// Sample is the state the watchdog checks.
+type Sample struct {
+	Goroutines int
+	HeapAlloc  uint64
+	Peers      []PeerWrite
+}
+
+// Result is the outcome of a check.
+type Result struct {
+	Sample
+
+	// Breaches lists the limits crossed (BreachGoroutines, BreachHeap,
+	// BreachStuckPeer).
+	Breaches []string
+
+	// StuckPeers are the peers whose write has been blocked for longer
+	// than StuckWriteTimeout.
+	StuckPeers []PeerWrite
+
+	// Snapshot is the directory of the snapshot saved by this check.
+	Snapshot string
+
+	// Restart is set when the agent should be restarted.
+	Restart bool
+}
+
+// Watchdog evaluates samples against the configured limits. It is safe for
+// concurrent use.
+type Watchdog struct {
+	cfg    Config
+	logger *slog.Logger
+	now    func() time.Time
+
+	mu           sync.Mutex
+	over         int // Consecutive checks over the goroutine or heap limit
+	lastSnapshot time.Time
+	lastRestart  time.Time
+}
+
+// New creates a watchdog.
+func New(cfg Config) *Watchdog {
+	if cfg.StuckWriteTimeout <= 0 {
+		cfg.StuckWriteTimeout = DefaultStuckWriteTimeout
+	}
+	if cfg.SnapshotCooldown <= 0 {
+		cfg.SnapshotCooldown = DefaultSnapshotCooldown
+	}
+	if cfg.MaxSnapshots <= 0 {
+		cfg.MaxSnapshots = DefaultMaxSnapshots
+	}
+	if cfg.RestartAfter <= 0 {
+		cfg.RestartAfter = DefaultRestartAfter
+	}
+	if cfg.RestartCooldown <= 0 {
+		cfg.RestartCooldown = DefaultRestartCooldown
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = logging.NopLogger()
+	}
+
+	w := &Watchdog{cfg: cfg, logger: cfg.Logger, now: time.Now}
+	if cfg.SnapshotDir != "" {
+		if data, err := os.ReadFile(filepath.Join(cfg.SnapshotDir, restartFile)); err == nil {
+			w.lastRestart, _ = time.Parse(time.RFC3339, strings.TrimSpace(string(data)))
+		}
+	}
+	return w
+}
+
+// Check samples the runtime and evaluates it together with the write state
+// of the peers.
+func (w *Watchdog) Check(peers []PeerWrite) Result {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	return w.Evaluate(Sample{
+		Goroutines: runtime.NumGoroutine(),
+		HeapAlloc:  ms.HeapAlloc,
+		Peers:      peers,
+	})
+}
+
+// Evaluate checks a sample against the limits, logs what was crossed and
+// saves a snapshot unless one was saved within SnapshotCooldown.
+func (w *Watchdog) Evaluate(s Sample) Result {
+	r := Result{Sample: s}
+
+	if w.cfg.MaxGoroutines > 0 && s.Goroutines > w.cfg.MaxGoroutines {
+		r.Breaches = append(r.Breaches, BreachGoroutines)
+		w.logger.Warn("goroutine limit exceeded",
+			"goroutines", s.Goroutines,
+			"limit", w.cfg.MaxGoroutines)
+	}
+	if w.cfg.MaxHeap > 0 && s.HeapAlloc > w.cfg.MaxHeap {
+		r.Breaches = append(r.Breaches, BreachHeap)
+		w.logger.Warn("heap limit exceeded",
+			"heap_bytes", s.HeapAlloc,
+			"limit", w.cfg.MaxHeap)
+	}
+	for _, p := range s.Peers {
+		if p.Stalled > w.cfg.StuckWriteTimeout {
+			r.StuckPeers = append(r.StuckPeers, p)
+			w.logger.Warn("peer write stuck",
+				logging.KeyPeerID, p.ID,
+				"stalled", p.Stalled.Round(time.Second),
+				"waiting_writers", p.Waiting)
+		}
+	}
+	if len(r.StuckPeers) > 0 {
+		r.Breaches = append(r.Breaches, BreachStuckPeer)
+	}
+
+	w.mu.Lock()
+	if slices.Contains(r.Breaches, BreachGoroutines) || slices.Contains(r.Breaches, BreachHeap) {
+		w.over++
+	} else {
+		w.over = 0
+	}
+	if w.cfg.Restart && w.over >= w.cfg.RestartAfter {
+		now := w.now()
+		if w.lastRestart.IsZero() || now.Sub(w.lastRestart) >= w.cfg.RestartCooldown {
+			r.Restart = true
+			w.lastRestart = now
+			w.over = 0
+			w.saveRestart(now)
+		} else if w.over == w.cfg.RestartAfter {
+			w.logger.Warn("restart skipped, last restart too recent",
+				"last_restart", w.lastRestart,
+				"cooldown", w.cfg.RestartCooldown)
+		}
+	}
+	takeSnapshot := len(r.Breaches) > 0 && w.cfg.SnapshotDir != "" &&
+		(w.lastSnapshot.IsZero() || w.now().Sub(w.lastSnapshot) >= w.cfg.SnapshotCooldown)
+	if takeSnapshot {
+		w.lastSnapshot = w.now()
+	}
+	w.mu.Unlock()
+
+	if takeSnapshot {
+		dir, err := w.Snapshot(strings.Join(r.Breaches, "-"))
+		if err != nil {
+			w.logger.Error("snapshot failed", logging.KeyError, err)
+		} else {
+			r.Snapshot = dir
+			w.logger.Warn("snapshot saved", "path", dir)
+		}
+	}
+	return r
+}
+
+// saveRestart records the time of a restart in the snapshot directory.
+func (w *Watchdog) saveRestart(t time.Time) {
+	if w.cfg.SnapshotDir == "" {
+		return
+	}
+	err := os.MkdirAll(w.cfg.SnapshotDir, 0700)
+	if err == nil {
+		err = os.WriteFile(filepath.Join(w.cfg.SnapshotDir, restartFile), []byte(t.UTC().Format(time.RFC3339)+"\n"), 0600)
+	}
+	if err != nil {
+		w.logger.Error("save restart time failed", logging.KeyError, err)
+	}
+}
+
+// Snapshot saves a goroutine dump and a heap profile to a new directory
+// under SnapshotDir and removes the oldest snapshots beyond MaxSnapshots.
+// Returns the directory.
+func (w *Watchdog) Snapshot(reason string) (string, error) {
+	if w.cfg.SnapshotDir == "" {
+		return "", fmt.Errorf("no snapshot directory")
+	}
+	name := w.now().UTC().Format(snapshotTimeFormat)
+	if reason != "" {
+		name += "-" + reason
+	}
+	dir := filepath.Join(w.cfg.SnapshotDir, name)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("create snapshot directory: %w", err)
+	}
+
+	// Goroutine stacks as text, heap as a pprof profile
+	if err := writeProfile(filepath.Join(dir, "goroutines.txt"), "goroutine", 2); err != nil {
+		return "", err
+	}
+	if err := writeProfile(filepath.Join(dir, "heap.pprof"), "heap", 0); err != nil {
+		return "", err
+	}
+
+	w.prune()
+	return dir, nil
+}
+
+// writeProfile writes a runtime profile to path.
+func writeProfile(path, profile string, debug int) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("write %s profile: %w", profile, err)
+	}
+	if err := pprof.Lookup(profile).WriteTo(f, debug); err != nil {
+		f.Close()
+		return fmt.Errorf("write %s profile: %w", profile, err)
+	}
+	return f.Close()
+}
+
+// Snapshots returns the snapshot directories, oldest first.
+func (w *Watchdog) Snapshots() []string {
+	entries, err := os.ReadDir(w.cfg.SnapshotDir)
+	if err != nil {
+		return nil
+	}
+	var dirs []string
+	for _, e := range entries {
+		if !e.IsDir() || len(e.Name()) < len(snapshotTimeFormat) {
+			continue
+		}
+		if _, err := time.Parse(snapshotTimeFormat, e.Name()[:len(snapshotTimeFormat)]); err != nil {
+			continue
+		}
+		dirs = append(dirs, filepath.Join(w.cfg.SnapshotDir, e.Name()))
+	}
+	slices.Sort(dirs)
+	return dirs
+}
+
+// prune removes the oldest snapshots beyond MaxSnapshots.
+func (w *Watchdog) prune() {
+	dirs := w.Snapshots()
+	for len(dirs) > w.cfg.MaxSnapshots {
+		os.RemoveAll(dirs[0])
+		dirs = dirs[1:]
+	}
+}
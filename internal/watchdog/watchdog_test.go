@@ -0,0 +1,135 @@
+package watchdog
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestEvaluate_Limits(t *testing.T) {
+	w := New(Config{MaxGoroutines: 100, MaxHeap: 1 << 20, StuckWriteTimeout: time.Minute})
+
+	r := w.Evaluate(Sample{Goroutines: 50, HeapAlloc: 1 << 10})
+	if len(r.Breaches) != 0 || r.Restart {
+		t.Fatalf("healthy sample: breaches %v, restart %v", r.Breaches, r.Restart)
+	}
+
+	r = w.Evaluate(Sample{
+		Goroutines: 150,
+		HeapAlloc:  2 << 20,
+		Peers: []PeerWrite{
+			{ID: "slow", Stalled: 10 * time.Second},
+			{ID: "stuck", Stalled: 2 * time.Minute, Waiting: 4},
+		},
+	})
+	want := []string{BreachGoroutines, BreachHeap, BreachStuckPeer}
+	if !slices.Equal(r.Breaches, want) {
+		t.Errorf("breaches = %v, want %v", r.Breaches, want)
+	}
+	if len(r.StuckPeers) != 1 || r.StuckPeers[0].ID != "stuck" {
+		t.Errorf("stuck peers = %+v, want only stuck", r.StuckPeers)
+	}
+	if r.Snapshot != "" {
+		t.Errorf("snapshot %q saved without a snapshot directory", r.Snapshot)
+	}
+}
+
+func TestEvaluate_ZeroLimitsNotChecked(t *testing.T) {
+	w := New(Config{})
+	r := w.Evaluate(Sample{Goroutines: 1 << 20, HeapAlloc: 1 << 40})
+	if len(r.Breaches) != 0 {
+		t.Errorf("breaches = %v, want none", r.Breaches)
+	}
+}
+
+func TestEvaluate_RestartAfterConsecutiveChecks(t *testing.T) {
+	w := New(Config{MaxGoroutines: 10, Restart: true, RestartAfter: 3})
+	over := Sample{Goroutines: 20}
+
+	if w.Evaluate(over).Restart || w.Evaluate(over).Restart {
+		t.Fatal("restart before three consecutive checks")
+	}
+	// A healthy check resets the count
+	w.Evaluate(Sample{Goroutines: 5})
+	if w.Evaluate(over).Restart || w.Evaluate(over).Restart {
+		t.Fatal("restart after the count was reset")
+	}
+	if !w.Evaluate(over).Restart {
+		t.Error("no restart after three consecutive checks")
+	}
+
+	// Stuck peers alone never restart the agent
+	w = New(Config{Restart: true, RestartAfter: 1, StuckWriteTimeout: time.Second})
+	if w.Evaluate(Sample{Peers: []PeerWrite{{ID: "p", Stalled: time.Hour}}}).Restart {
+		t.Error("restart for a stuck peer")
+	}
+}
+
+func TestEvaluate_SnapshotCooldownAndPrune(t *testing.T) {
+	dir := t.TempDir()
+	w := New(Config{MaxGoroutines: 1, SnapshotDir: dir, SnapshotCooldown: time.Hour, MaxSnapshots: 2})
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	w.now = func() time.Time { return now }
+
+	r := w.Evaluate(Sample{Goroutines: 2})
+	if r.Snapshot == "" {
+		t.Fatal("no snapshot on the first breach")
+	}
+	if filepath.Base(r.Snapshot) != "20260102T030405Z-goroutines" {
+		t.Errorf("snapshot name = %s", filepath.Base(r.Snapshot))
+	}
+	for _, name := range []string{"goroutines.txt", "heap.pprof"} {
+		info, err := os.Stat(filepath.Join(r.Snapshot, name))
+		if err != nil || info.Size() == 0 {
+			t.Errorf("%s missing or empty: %v", name, err)
+		}
+	}
+
+	now = now.Add(time.Minute)
+	if r := w.Evaluate(Sample{Goroutines: 2}); r.Snapshot != "" {
+		t.Error("snapshot saved within the cooldown")
+	}
+
+	for range 3 {
+		now = now.Add(time.Hour)
+		if r := w.Evaluate(Sample{Goroutines: 2}); r.Snapshot == "" {
+			t.Fatal("no snapshot after the cooldown")
+		}
+	}
+	if got := len(w.Snapshots()); got != 2 {
+		t.Errorf("%d snapshots kept, want 2", got)
+	}
+}
+
+func TestEvaluate_RestartCooldown(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	cfg := Config{MaxGoroutines: 10, SnapshotDir: dir, Restart: true, RestartAfter: 1, RestartCooldown: time.Hour}
+	over := Sample{Goroutines: 20}
+
+	w := New(cfg)
+	w.now = func() time.Time { return now }
+	if !w.Evaluate(over).Restart {
+		t.Fatal("no restart on the first breach")
+	}
+
+	// A new process after the restart sees the saved restart time
+	w = New(cfg)
+	now = now.Add(10 * time.Minute)
+	w.now = func() time.Time { return now }
+	if w.Evaluate(over).Restart {
+		t.Error("restart within the cooldown")
+	}
+	now = now.Add(time.Hour)
+	if !w.Evaluate(over).Restart {
+		t.Error("no restart after the cooldown")
+	}
+
+	// Without Restart the watchdog never asks for one
+	w = New(Config{MaxGoroutines: 10, RestartAfter: 1})
+	if w.Evaluate(over).Restart {
+		t.Error("restart with restarts disabled")
+	}
+}
@@ -493,7 +493,7 @@ func (w *Wizard) askNetworkConfig() (transport, listenAddr, path string, plainTe
 	}
 
 	// Ask for path and reverse proxy if using HTTP-based transport
-	if transport == "h2" || transport == "ws" {
+	if transport == "h2" || transport == "ws" || transport == "wt" {
 		path, err = prompt.ReadLineValidated("HTTP Path", path, func(s string) error {
 			if s == "" || !strings.HasPrefix(s, "/") {
 				return fmt.Errorf("path must start with /")
@@ -789,7 +789,7 @@ func (w *Wizard) testPeerConnectivity(peer config.PeerConfig) error {
 	}
 
 	// Set default path if not specified
-	if opts.Path == "" && (peer.Transport == "h2" || peer.Transport == "ws") {
+	if opts.Path == "" && (peer.Transport == "h2" || peer.Transport == "ws" || peer.Transport == "wt") {
 		opts.Path = "/mesh"
 	}
 
@@ -882,7 +882,7 @@ func (w *Wizard) askSinglePeer(listenerTransport string, peerNum int) (config.Pe
 	}
 
 	// Ask for path if HTTP transport
-	if peer.Transport == "h2" || peer.Transport == "ws" {
+	if peer.Transport == "h2" || peer.Transport == "ws" || peer.Transport == "wt" {
 		peerPath, err := prompt.ReadLine("HTTP Path", "/mesh")
 		if err != nil {
 			return peer, err
@@ -1556,7 +1556,7 @@ func (w *Wizard) buildConfig(
 		Transport: transport,
 		Address:   listenAddr,
 	}
-	if transport == "h2" || transport == "ws" {
+	if transport == "h2" || transport == "ws" || transport == "wt" {
 		listener.Path = listenPath
 	}
 	if plainText {
@@ -2461,8 +2461,8 @@ func (w *Wizard) privilegeLevel() string {
 
 // Transport options and their display labels for selection prompts.
 var (
-	transportLabels = []string{"QUIC", "HTTP/2", "WebSocket"}
-	transportValues = []string{"quic", "h2", "ws"}
+	transportLabels = []string{"QUIC", "HTTP/2", "WebSocket", "WebTransport", "TLS/TCP"}
+	transportValues = []string{"quic", "h2", "ws", "wt", "tcp"}
 )
 
 // transportIndex returns the index of the given transport in transportValues.
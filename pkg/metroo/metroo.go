@@ -0,0 +1,225 @@
+// Package metroo runs a Muti Metroo agent inside a Go program, so the
+// program can reach the mesh without the CLI.
+//
+// An agent is built from the same configuration the CLI reads, started,
+// and then used as a dialer:
+//
+//	cfg, err := metroo.LoadConfig("config.yaml")
+//	if err != nil {
+//		return err
+//	}
+//	agent, err := metroo.New(cfg)
+//	if err != nil {
+//		return err
+//	}
+//	if err := agent.Start(); err != nil {
+//		return err
+//	}
+//	defer agent.Stop()
+//
+//	client := &http.Client{Transport: agent.HTTPTransport()}
+//	resp, err := client.Get("http://10.20.0.5:8080/")
+//
+// Destinations are routed as for SOCKS5 clients: through the exit that
+// advertises the best route, or dialed directly without one (see MeshOnly).
+// Listen serves a port forward key from the program itself.
+package metroo
+
+import (
+	"context"
+	"net"
+	"net/http"
+
+	"github.com/postalsys/muti-metroo/internal/agent"
+	"github.com/postalsys/muti-metroo/internal/config"
+	"github.com/postalsys/muti-metroo/internal/middleware"
+)
+
+// Config is the agent configuration, with the fields and YAML keys
+// documented in the configuration reference.
+type Config = config.Config
+
+// DefaultConfig returns a configuration with all defaults: no listeners,
+// peers, SOCKS5 server, exit or HTTP API. Data is kept in ./data.
+func DefaultConfig() *Config {
+	return config.Default()
+}
+
+// LoadConfig reads and validates a YAML configuration file.
+func LoadConfig(path string) (*Config, error) {
+	return config.Load(path)
+}
+
+// ParseConfig parses and validates YAML configuration.
+func ParseConfig(data []byte) (*Config, error) {
+	return config.Parse(data)
+}
+
+// Stream middleware types, see Agent.UseStreamMiddleware.
+type (
+	StreamMiddleware = middleware.StreamMiddleware
+	Stream           = middleware.Stream
+	MiddlewareFuncs  = middleware.Funcs
+	Side             = middleware.Side
+	Direction        = middleware.Direction
+)
+
+// Sides and directions passed to stream middleware.
+const (
+	Ingress         = middleware.Ingress
+	Exit            = middleware.Exit
+	ToDestination   = middleware.ToDestination
+	FromDestination = middleware.FromDestination
+)
+
+// Dialer opens connections through the mesh. *Agent implements it.
+type Dialer interface {
+	DialContext(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// Agent is an agent running in this process.
+type Agent struct {
+	a *agent.Agent
+}
+
+// New validates cfg and creates an agent. It loads or creates the agent
+// identity in cfg.Agent.DataDir unless the configuration carries one.
+func New(cfg *Config) (*Agent, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	a, err := agent.New(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Agent{a: a}, nil
+}
+
+// Start starts the configured listeners, peer connections and services.
+func (a *Agent) Start() error {
+	return a.a.Start()
+}
+
+// Stop stops the agent and closes its connections.
+func (a *Agent) Stop() error {
+	return a.a.Stop()
+}
+
+// Shutdown stops the agent, giving up when ctx is done.
+func (a *Agent) Shutdown(ctx context.Context) error {
+	return a.a.StopWithContext(ctx)
+}
+
+// IsRunning reports whether the agent is running.
+func (a *Agent) IsRunning() bool {
+	return a.a.IsRunning()
+}
+
+// ID returns the agent ID in hex.
+func (a *Agent) ID() string {
+	return a.a.ID().String()
+}
+
+// DisplayName returns the agent's display name, or its short ID.
+func (a *Agent) DisplayName() string {
+	return a.a.DisplayName()
+}
+
+// Dial connects to address through the mesh. Only TCP is supported.
+func (a *Agent) Dial(network, address string) (net.Conn, error) {
+	return a.a.Dial(network, address)
+}
+
+// DialContext connects to address through the mesh, like a SOCKS5
+// CONNECT on this agent. Only TCP is supported.
+func (a *Agent) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	return a.a.DialContext(ctx, network, address)
+}
+
+// DialForward connects to the port forward endpoint advertised for key.
+func (a *Agent) DialForward(ctx context.Context, key string) (net.Conn, error) {
+	return a.a.DialForward(ctx, key)
+}
+
+// DialOption changes how DialFunc, RoundTripper and HTTPTransport dial.
+type DialOption func(*dialOptions)
+
+type dialOptions struct {
+	meshOnly bool
+}
+
+// MeshOnly fails dials to destinations without a mesh route, instead of
+// connecting to them directly from this host.
+func MeshOnly() DialOption {
+	return func(o *dialOptions) { o.meshOnly = true }
+}
+
+// DialFunc returns a dial function with the signature of
+// net.Dialer.DialContext, for clients that accept a custom dialer (HTTP,
+// gRPC, database drivers). Destinations follow the mesh CIDR and domain
+// routes; domains with a domain route are resolved by the exit.
+func (a *Agent) DialFunc(opts ...DialOption) func(ctx context.Context, network, address string) (net.Conn, error) {
+	var o dialOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if !o.meshOnly {
+		return a.a.DialContext
+	}
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		return a.a.DialContext(agent.WithMeshOnly(ctx), network, address)
+	}
+}
+
+// HTTPTransport returns an http.Transport that dials through the mesh.
+// Proxy environment variables are ignored.
+func (a *Agent) HTTPTransport(opts ...DialOption) *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.Proxy = nil
+	t.DialContext = a.DialFunc(opts...)
+	return t
+}
+
+// RoundTripper returns an http.RoundTripper that sends requests through
+// the mesh, for http.Client.Transport or httputil.ReverseProxy.Transport.
+func (a *Agent) RoundTripper(opts ...DialOption) http.RoundTripper {
+	return a.HTTPTransport(opts...)
+}
+
+// Listen advertises key as a port forward endpoint served by the program:
+// connections other agents open to key, with DialForward or a port forward
+// listener, are returned by Accept. The endpoint is withdrawn when the
+// listener is closed. The agent must be running.
+//
+// Connections arrive over a loopback TCP socket, so other local processes
+// can connect to the listener's address too.
+func (a *Agent) Listen(key string) (net.Listener, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := a.a.ManageForwardEndpoint("add", key, ln.Addr().String()); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return &endpointListener{Listener: ln, agent: a.a, key: key}, nil
+}
+
+// UseStreamMiddleware adds m to the middleware called on TCP streams the
+// agent dials as ingress and connects as exit. It must be called before
+// Start.
+func (a *Agent) UseStreamMiddleware(m StreamMiddleware) {
+	a.a.UseStreamMiddleware(m)
+}
+
+// endpointListener withdraws its forward endpoint when closed.
+type endpointListener struct {
+	net.Listener
+	agent *agent.Agent
+	key   string
+}
+
+func (l *endpointListener) Close() error {
+	l.agent.ManageForwardEndpoint("remove", l.key, "")
+	return l.Listener.Close()
+}
@@ -0,0 +1,82 @@
+package metroo
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAgent(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Agent.DataDir = t.TempDir()
+
+	a, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if err := a.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer a.Stop()
+	if !a.IsRunning() || len(a.ID()) != 32 {
+		t.Fatalf("IsRunning() = %v, ID() = %q", a.IsRunning(), a.ID())
+	}
+
+	// Destinations without a route are dialed directly
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "hello")
+	}))
+	defer srv.Close()
+	client := &http.Client{Transport: a.HTTPTransport()}
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "hello" {
+		t.Errorf("body = %q, want %q", body, "hello")
+	}
+
+	// Mesh-only dials refuse destinations without a route
+	if _, err := a.DialFunc(MeshOnly())(context.Background(), "tcp", srv.Listener.Addr().String()); err == nil {
+		t.Error("mesh-only dial without a route succeeded")
+	}
+	req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+	if _, err := a.RoundTripper(MeshOnly()).RoundTrip(req); err == nil || !strings.Contains(err.Error(), "no mesh route") {
+		t.Errorf("mesh-only RoundTrip() error = %v, want no mesh route", err)
+	}
+
+	// Listen advertises a forward endpoint until closed
+	ln, err := a.Listen("embedded")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	if r := a.a.LookupForwardRoute("embedded"); r == nil || r.Target != ln.Addr().String() {
+		t.Errorf("LookupForwardRoute() = %+v, want target %s", r, ln.Addr())
+	}
+	ln.Close()
+	if r := a.a.LookupForwardRoute("embedded"); r != nil {
+		t.Errorf("LookupForwardRoute() after Close = %+v, want nil", r)
+	}
+
+	if err := a.Shutdown(context.Background()); err != nil {
+		t.Errorf("Shutdown() error = %v", err)
+	}
+}
+
+func TestNew_InvalidConfig(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Agent.DataDir = t.TempDir()
+	cfg.Exit.Routes = []string{"not-a-cidr"}
+	if _, err := New(cfg); err == nil {
+		t.Error("New() with an invalid config succeeded")
+	}
+}
+
+var _ Dialer = (*Agent)(nil)
+var _ net.Listener = (*endpointListener)(nil)